@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"os"
+	"text/tabwriter"
+)
+
+func cmdAST(args []string) {
+	fs := flag.NewFlagSet("ast", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the whole syntax tree as JSON")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "Usage: gmx ast [-json] <input.gmx>\n\n"+
+			"Prints the declarations of a .gmx file and their line. With -json, prints\n"+
+			"the parsed file (models, services, functions, template and style positions)\n"+
+			"for documentation generators, diagram tools and custom linters.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	inputFile := fs.Arg(0)
+	// Flags may follow the input file: gmx ast app.gmx --json
+	_ = fs.Parse(fs.Args()[1:])
+
+	// The lines of a directory build would point into several files
+	if isDirBuild(inputFile) {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: gmx ast takes a single .gmx file\n")
+		os.Exit(1)
+	}
+	_, file, err := load(inputFile)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *asJSON {
+		data, err := json.MarshalIndent(ast.Export(file), "", "  ")
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "KIND\tNAME\tLINE\n")
+	for _, m := range file.Models {
+		_, _ = fmt.Fprintf(w, "model\t%s\t%d\n", m.Name, m.Line)
+	}
+	for _, s := range file.Services {
+		_, _ = fmt.Fprintf(w, "service\t%s\t%d\n", s.Name, s.Line)
+	}
+	for _, v := range file.Vars {
+		_, _ = fmt.Fprintf(w, "var\t%s\t%d\n", v.Name, v.Line)
+	}
+	for _, s := range file.Settings {
+		_, _ = fmt.Fprintf(w, "setting\t%s\t%d\n", s.Name, s.Line)
+	}
+	for _, wiz := range file.Wizards {
+		_, _ = fmt.Fprintf(w, "wizard\t%s\t%d\n", wiz.Name, wiz.Line)
+	}
+	if file.Script != nil {
+		for _, fn := range file.Script.Funcs {
+			_, _ = fmt.Fprintf(w, "func\t%s\t%d\n", fn.Name, fn.Line)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
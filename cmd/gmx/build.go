@@ -1,8 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/generator"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -12,8 +15,20 @@ import (
 func cmdBuild(args []string) {
 	fs := flag.NewFlagSet("build", flag.ExitOnError)
 	outputBinary := fs.String("o", "", "output binary path (default: input filename without extension)")
+	dev := fs.Bool("dev", false, "development build: catch outgoing mail at /__gmx/mail instead of sending it")
+	minify := fs.Bool("minify", false, "strip insignificant whitespace and comments from the embedded template and styles")
+	criticalCSS := fs.Bool("critical-css", false, "inline the component styles used by the initial render and lazy-load the rest")
+	strict := fs.Bool("strict", false, "reject implicit behaviors: unused declarations, unreferenced routes, fallback SQLite, unvalidated saves")
+	update := fs.Bool("update", false, "accept imported files and modules that changed since gmx.lock was written")
+	module := fs.String("module", defaultModule, "Go module path of the build (not with -emit)")
+	pkg := fs.String("package", "main", "generated package name (requires -emit unless main)")
+	emitDir := fs.String("emit", "", "write the generated Go sources to this directory instead of building a binary")
+	benchmarks := fs.Bool("with-benchmarks", false, "also write Go benchmarks of the page and GET handlers (requires -emit)")
+	tests := fs.Bool("with-tests", false, "also write Go tests of the page and handlers (requires -emit)")
 	fs.Usage = func() {
-		_, _ = fmt.Fprintf(os.Stderr, "Usage: gmx build [-o binary] <input.gmx>\n\nFlags:\n")
+		_, _ = fmt.Fprintf(os.Stderr, "Usage: gmx build [-o binary] [-dev] [-strict] [-update] [-module path | -emit dir [-package name] [-with-benchmarks] [-with-tests]] <input.gmx | dir>\n\n"+
+			"A directory is built as one server: each .gmx page is served at the route\n"+
+			"derived from its path (tasks/index.gmx → /tasks).\n\nFlags:\n")
 		fs.PrintDefaults()
 	}
 	_ = fs.Parse(args)
@@ -24,13 +39,43 @@ func cmdBuild(args []string) {
 	}
 
 	inputFile := fs.Arg(0)
+	opts := generator.Options{Dev: *dev, Package: *pkg, CriticalCSS: *criticalCSS, Minify: *minify, Strict: *strict}
+
+	if *emitDir != "" {
+		// Emitted sources join the module of the directory they are written to
+		moduleSet := false
+		fs.Visit(func(f *flag.Flag) { moduleSet = moduleSet || f.Name == "module" })
+		if moduleSet {
+			_, _ = fmt.Fprintf(os.Stderr, "Error: -module applies to built binaries; with -emit, the sources belong to the module of %s\n", *emitDir)
+			os.Exit(1)
+		}
+		goFile, err := emitSources(inputFile, *emitDir, opts, *update, *benchmarks, *tests)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Generated %s successfully\n", goFile)
+		return
+	}
+	if *pkg != "main" {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: -package %s requires -emit: binaries are built from package main\n", *pkg)
+		os.Exit(1)
+	}
+	if *benchmarks {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: -with-benchmarks requires -emit: benchmarks run with go test in the emitted sources\n")
+		os.Exit(1)
+	}
+	if *tests {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: -with-tests requires -emit; gmx test runs the tests of a .gmx file without it\n")
+		os.Exit(1)
+	}
+
 	binary := *outputBinary
 	if binary == "" {
-		base := filepath.Base(inputFile)
-		binary = strings.TrimSuffix(base, filepath.Ext(base))
+		binary = appName(inputFile)
 	}
 
-	if err := buildBinary(inputFile, binary); err != nil {
+	if err := buildBinary(inputFile, binary, opts, *module, lockModeFor(*update)); err != nil {
 		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
@@ -38,9 +83,61 @@ func cmdBuild(args []string) {
 	fmt.Printf("Built %s successfully\n", binary)
 }
 
-// buildBinary compiles a .gmx file into a Go binary.
-func buildBinary(inputFile, outputBinary string) error {
-	code, err := compile(inputFile)
+// defaultModule is the Go module path of builds without -module.
+const defaultModule = "gmx-app"
+
+// emitSources writes the generated Go source of a .gmx file into dir, for
+// inclusion in an existing module, and returns the written file path; with
+// benchmarks, a _bench_test.go file benchmarking the handlers is written too,
+// and with tests, a _test.go file testing them.
+func emitSources(inputFile, dir string, opts generator.Options, update, benchmarks, tests bool) (string, error) {
+	c, err := compile(inputFile, opts, lockModeFor(update))
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("creating output directory: %w", err)
+	}
+
+	name := "main.go"
+	if opts.Package != "" && opts.Package != "main" {
+		name = opts.Package + ".go"
+	}
+	goFile := filepath.Join(dir, name)
+	if err := os.WriteFile(goFile, []byte(c.code), 0644); err != nil {
+		return "", fmt.Errorf("writing generated code: %w", err)
+	}
+	if benchmarks {
+		code, err := generator.NewWithOptions(opts).GenerateBenchmarks(c.resolved)
+		if err != nil {
+			return "", fmt.Errorf("generating benchmarks: %w", err)
+		}
+		benchFile := strings.TrimSuffix(goFile, ".go") + "_bench_test.go"
+		if err := os.WriteFile(benchFile, []byte(code), 0644); err != nil {
+			return "", fmt.Errorf("writing benchmarks: %w", err)
+		}
+	}
+	if tests {
+		code, err := generator.NewWithOptions(opts).GenerateTests(c.resolved)
+		if err != nil {
+			return "", fmt.Errorf("generating tests: %w", err)
+		}
+		testFile := strings.TrimSuffix(goFile, ".go") + "_test.go"
+		if err := os.WriteFile(testFile, []byte(code), 0644); err != nil {
+			return "", fmt.Errorf("writing tests: %w", err)
+		}
+	}
+	if err := c.lock.write(); err != nil {
+		return "", err
+	}
+	return goFile, nil
+}
+
+// buildBinary compiles a .gmx file into a Go binary, inside a temporary
+// module with the given path; the lock mode decides how imports that changed
+// since gmx.lock was written are handled.
+func buildBinary(inputFile, outputBinary string, opts generator.Options, module string, mode lockMode) error {
+	c, err := compile(inputFile, opts, mode)
 	if err != nil {
 		return err
 	}
@@ -54,48 +151,114 @@ func buildBinary(inputFile, outputBinary string) error {
 		_ = os.RemoveAll(path)
 	}(tmpDir)
 
+	if err := writeModule(c, inputFile, tmpDir, module); err != nil {
+		return err
+	}
+
+	// Build the binary
+	absBinary, err := filepath.Abs(outputBinary)
+	if err != nil {
+		return fmt.Errorf("resolving output path: %w", err)
+	}
+
+	// Create an output directory if needed
+	if dir := filepath.Dir(absBinary); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("creating output directory: %w", err)
+		}
+	}
+
+	// Compiler errors in script functions point to their .gmx line, not the temporary main.go
+	var stderr bytes.Buffer
+	goBuild := exec.Command("go", "build", "-o", absBinary, ".")
+	goBuild.Dir = tmpDir
+	goBuild.Stdout = os.Stdout
+	goBuild.Stderr = &stderr
+	err = goBuild.Run()
+	_, _ = fmt.Fprint(os.Stderr, mapGoErrors(stderr.String(), c.code, inputFile, scriptFuncs(inputFile, c.file)))
+	if err != nil {
+		return fmt.Errorf("go build: %w", err)
+	}
+
+	return c.lock.write()
+}
+
+// writeModule writes the generated Go source of a compilation into dir, with
+// the hand-written Go files of the app, as a module with the given path whose
+// dependencies are resolved and recorded in gmx.lock.
+func writeModule(c *compilation, inputFile, dir, module string) error {
 	// Write generated Go source
-	goFile := filepath.Join(tmpDir, "main.go")
-	if err := os.WriteFile(goFile, []byte(code), 0644); err != nil {
+	goFile := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(goFile, []byte(c.code), 0644); err != nil {
 		return fmt.Errorf("writing generated code: %w", err)
 	}
 
+	// Custom services and repositories are implemented by hand-written Go files next to the .gmx file
+	if hasHandWrittenGo(c.file) {
+		if err := copyGoSources(inputDir(inputFile), dir); err != nil {
+			return err
+		}
+	}
+
 	// Initialize go.mod in the temp directory
-	modInit := exec.Command("go", "mod", "init", "gmx-app")
-	modInit.Dir = tmpDir
+	modInit := exec.Command("go", "mod", "init", module)
+	modInit.Dir = dir
 	modInit.Stderr = os.Stderr
 	if err := modInit.Run(); err != nil {
 		return fmt.Errorf("go mod init: %w", err)
 	}
 
+	// Locked module versions are required before resolving the others
+	if err := c.lock.pinModules(dir); err != nil {
+		return err
+	}
+
 	// Run go mod tidy to resolve dependencies
 	modTidy := exec.Command("go", "mod", "tidy")
-	modTidy.Dir = tmpDir
+	modTidy.Dir = dir
 	modTidy.Stderr = os.Stderr
 	if err := modTidy.Run(); err != nil {
 		return fmt.Errorf("go mod tidy: %w", err)
 	}
+	return c.lock.recordModules(dir, c.resolved)
+}
 
-	// Build the binary
-	absBinary, err := filepath.Abs(outputBinary)
-	if err != nil {
-		return fmt.Errorf("resolving output path: %w", err)
+// hasHandWrittenGo reports whether a file declares services with the "custom"
+// provider or models whose @repository is a type of package main.
+func hasHandWrittenGo(file *ast.GMXFile) bool {
+	for _, svc := range file.Services {
+		if svc.Provider == "custom" {
+			return true
+		}
 	}
-
-	// Create an output directory if needed
-	if dir := filepath.Dir(absBinary); dir != "." {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return fmt.Errorf("creating output directory: %w", err)
+	for _, model := range file.Models {
+		if ann := model.FindAnnotation("repository"); ann != nil && !strings.Contains(ann.SimpleArg(), ".") {
+			return true
 		}
 	}
+	return false
+}
 
-	goBuild := exec.Command("go", "build", "-o", absBinary, ".")
-	goBuild.Dir = tmpDir
-	goBuild.Stdout = os.Stdout
-	goBuild.Stderr = os.Stderr
-	if err := goBuild.Run(); err != nil {
-		return fmt.Errorf("go build: %w", err)
+// copyGoSources copies the non-test Go files of srcDir into the build directory.
+func copyGoSources(srcDir, dstDir string) error {
+	matches, err := filepath.Glob(filepath.Join(srcDir, "*.go"))
+	if err != nil {
+		return fmt.Errorf("listing Go sources: %w", err)
+	}
+	for _, src := range matches {
+		if strings.HasSuffix(src, "_test.go") {
+			continue
+		}
+		if filepath.Base(src) == "main.go" {
+			return fmt.Errorf("%s conflicts with the generated main.go, rename it", src)
+		}
+		data, err := os.ReadFile(src)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", src, err)
+		}
+		if err := os.WriteFile(filepath.Join(dstDir, filepath.Base(src)), data, 0644); err != nil {
+			return fmt.Errorf("copying %s: %w", src, err)
+		}
 	}
-
 	return nil
 }
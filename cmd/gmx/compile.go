@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/ast"
 	"github.com/btouchard/gmx/internal/compiler/generator"
 	"github.com/btouchard/gmx/internal/compiler/lexer"
 	"github.com/btouchard/gmx/internal/compiler/parser"
@@ -11,11 +12,55 @@ import (
 	"strings"
 )
 
-// compile reads a .gmx file and returns the generated Go source code.
-func compile(inputFile string) (string, error) {
+// compilation is the output of compile
+type compilation struct {
+	code     string                 // generated Go source
+	file     *ast.GMXFile           // parsed input file
+	resolved *resolver.ResolvedFile // input file with its imports merged
+	lock     *buildLock             // gmx.lock state, written once the build succeeds
+}
+
+// compile reads a .gmx file and returns the generated Go source code, after
+// checking its imports against gmx.lock according to the lock mode.
+func compile(inputFile string, opts generator.Options, mode lockMode) (*compilation, error) {
+	resolved, file, err := load(inputFile)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := checkImportLock(inputFile, resolved, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	// Apps with a migrations directory apply its SQL instead of AutoMigrate
+	if opts.Migrations, err = loadMigrations(inputFile, generator.New().DatabaseProvider(resolved)); err != nil {
+		return nil, err
+	}
+
+	// 3. Generation
+	opts.Source = inputFile
+	gen := generator.NewWithOptions(opts)
+	code, err := gen.GenerateResolved(resolved)
+	if err != nil {
+		return nil, fmt.Errorf("generation: %w", err)
+	}
+	for _, warning := range gen.Warnings(resolved.Main) {
+		_, _ = fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+	return &compilation{code: code, file: file, resolved: resolved, lock: lock}, nil
+}
+
+// load parses a .gmx file and resolves its imports and fragment references;
+// it returns the resolved file along with the parsed one. A directory is
+// loaded as a directory build, whose parsed file is the merged one.
+func load(inputFile string) (*resolver.ResolvedFile, *ast.GMXFile, error) {
+	if isDirBuild(inputFile) {
+		return loadDir(inputFile)
+	}
+
 	data, err := os.ReadFile(inputFile)
 	if err != nil {
-		return "", fmt.Errorf("reading file: %w", err)
+		return nil, nil, fmt.Errorf("reading file: %w", err)
 	}
 
 	// 1. Lexing
@@ -31,41 +76,73 @@ func compile(inputFile string) (string, error) {
 		for _, e := range p.Errors() {
 			b.WriteString("  " + e + "\n")
 		}
-		return "", fmt.Errorf("%s", b.String())
+		return nil, nil, fmt.Errorf("%s", b.String())
 	}
 
-	// 3. Import Resolution & Generation
-	gen := generator.New()
+	// Single-file compilation: nothing to resolve
+	if len(file.Imports) == 0 && !resolver.HasFragmentRefs(file) {
+		return &resolver.ResolvedFile{Main: file}, file, nil
+	}
 
-	if len(file.Imports) > 0 {
-		basePath := filepath.Dir(inputFile)
-		absInputFile, err := filepath.Abs(inputFile)
-		if err != nil {
-			return "", fmt.Errorf("resolving input file path: %w", err)
-		}
+	// 3. Import/Fragment Resolution
+	basePath := filepath.Dir(inputFile)
+	absInputFile, err := filepath.Abs(inputFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("resolving input file path: %w", err)
+	}
 
-		res := resolver.New(basePath)
-		resolved, resolveErrors := res.Resolve(file, absInputFile)
+	res := resolver.New(basePath)
+	resolved, resolveErrors := res.Resolve(file, absInputFile)
 
-		if len(resolveErrors) > 0 {
-			var b strings.Builder
-			b.WriteString("import resolution errors:\n")
-			for _, e := range resolveErrors {
-				b.WriteString("  " + e + "\n")
-			}
-			return "", fmt.Errorf("%s", b.String())
+	if len(resolveErrors) > 0 {
+		var b strings.Builder
+		b.WriteString("import resolution errors:\n")
+		for _, e := range resolveErrors {
+			b.WriteString("  " + e + "\n")
 		}
+		return nil, nil, fmt.Errorf("%s", b.String())
+	}
+	return resolved, file, nil
+}
 
-		code, err := gen.GenerateResolved(resolved)
-		if err != nil {
-			return "", fmt.Errorf("generation: %w", err)
+// loadDir resolves a directory build: every .gmx page of dir, served at the
+// route derived from its path
+func loadDir(dir string) (*resolver.ResolvedFile, *ast.GMXFile, error) {
+	resolved, resolveErrors := resolver.New(dir).ResolveDir(dir)
+	if len(resolveErrors) > 0 {
+		var b strings.Builder
+		b.WriteString("page resolution errors:\n")
+		for _, e := range resolveErrors {
+			b.WriteString("  " + e + "\n")
 		}
-		return code, nil
+		return nil, nil, fmt.Errorf("%s", b.String())
 	}
+	return resolved, resolved.Main, nil
+}
 
-	code, err := gen.Generate(file)
-	if err != nil {
-		return "", fmt.Errorf("generation: %w", err)
+// isDirBuild reports whether the input is a directory of pages
+func isDirBuild(input string) bool {
+	info, err := os.Stat(input)
+	return err == nil && info.IsDir()
+}
+
+// inputDir returns the directory holding gmx.lock and the Go files of a
+// build: the input directory itself, or the directory of the input file
+func inputDir(input string) string {
+	if isDirBuild(input) {
+		return input
+	}
+	return filepath.Dir(input)
+}
+
+// appName returns the name of the app built from an input: the input file
+// without its extension, or the name of the input directory
+func appName(input string) string {
+	if isDirBuild(input) {
+		if abs, err := filepath.Abs(input); err == nil {
+			return filepath.Base(abs)
+		}
 	}
-	return code, nil
+	base := filepath.Base(input)
+	return strings.TrimSuffix(base, filepath.Ext(base))
 }
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/generator"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// deployTarget holds the settings shared by the generated deployment files
+type deployTarget struct {
+	app    string // binary and service name
+	domain string
+	tls    bool
+	user   string
+	bin    string // installed binary path
+	info   generator.DeployInfo
+}
+
+func cmdDeployConfig(args []string) {
+	fs := flag.NewFlagSet("deploy-config", flag.ExitOnError)
+	domain := fs.String("domain", "example.com", "public domain name served by the reverse proxy")
+	proxy := fs.String("proxy", "caddy", "reverse proxy configuration to generate: caddy or nginx")
+	tls := fs.Bool("tls", true, "terminate HTTPS at the reverse proxy (Let's Encrypt certificates)")
+	user := fs.String("user", "", "system user running the service (default: the app name)")
+	bin := fs.String("bin", "", "installed binary path (default: /usr/local/bin/<app>)")
+	outDir := fs.String("o", "", "write the files to this directory instead of stdout")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "Usage: gmx deploy-config [-domain name] [-proxy caddy|nginx] [-tls=false] [-o dir] <input.gmx>\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *proxy != "caddy" && *proxy != "nginx" {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: unsupported proxy %q (expected caddy or nginx)\n", *proxy)
+		os.Exit(1)
+	}
+
+	inputFile := fs.Arg(0)
+	resolved, _, err := load(inputFile)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	t := deployTarget{
+		app:    appName(inputFile),
+		domain: *domain,
+		tls:    *tls,
+		user:   *user,
+		bin:    *bin,
+		info:   generator.New().DeployInfo(resolved),
+	}
+	if t.user == "" {
+		t.user = t.app
+	}
+	if t.bin == "" {
+		t.bin = "/usr/local/bin/" + t.app
+	}
+
+	files := []struct{ name, content string }{
+		{t.app + ".service", t.systemdUnit()},
+		{t.app + ".env", t.envFile()},
+	}
+	if *proxy == "caddy" {
+		files = append(files, struct{ name, content string }{"Caddyfile", t.caddyfile()})
+	} else {
+		files = append(files, struct{ name, content string }{t.app + ".conf", t.nginxConf()})
+	}
+
+	if *outDir == "" {
+		for i, f := range files {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("# ===== %s =====\n%s", f.name, f.content)
+		}
+		return
+	}
+
+	if err := os.MkdirAll(*outDir, 0o755); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: creating output directory: %v\n", err)
+		os.Exit(1)
+	}
+	for _, f := range files {
+		path := filepath.Join(*outDir, f.name)
+		if err := os.WriteFile(path, []byte(f.content), 0o644); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Error: writing %s: %v\n", path, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Generated %s\n", path)
+	}
+}
+
+// systemdUnit renders the service unit; the working directory is the state
+// directory so that the fallback SQLite database survives restarts
+func (t deployTarget) systemdUnit() string {
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	b.WriteString(fmt.Sprintf("Description=%s (GMX app)\n", t.app))
+	b.WriteString("After=network-online.target\n")
+	b.WriteString("Wants=network-online.target\n\n")
+
+	b.WriteString("[Service]\n")
+	b.WriteString("Type=simple\n")
+	b.WriteString(fmt.Sprintf("User=%s\n", t.user))
+	b.WriteString(fmt.Sprintf("Group=%s\n", t.user))
+	b.WriteString(fmt.Sprintf("ExecStart=%s\n", t.bin))
+	b.WriteString(fmt.Sprintf("StateDirectory=%s\n", t.app))
+	b.WriteString(fmt.Sprintf("WorkingDirectory=/var/lib/%s\n", t.app))
+	if t.info.SQLitePath != "" {
+		b.WriteString(fmt.Sprintf("# The SQLite database is /var/lib/%s/%s\n", t.app, t.info.SQLitePath))
+	}
+	if len(t.info.EnvVars) > 0 {
+		b.WriteString(fmt.Sprintf("EnvironmentFile=/etc/%s/%s.env\n", t.app, t.app))
+	}
+	b.WriteString("Restart=on-failure\n")
+	b.WriteString("RestartSec=2\n")
+	b.WriteString("NoNewPrivileges=true\n")
+	b.WriteString("PrivateTmp=true\n")
+	b.WriteString("ProtectSystem=strict\n")
+	b.WriteString("ProtectHome=true\n\n")
+
+	b.WriteString("[Install]\n")
+	b.WriteString("WantedBy=multi-user.target\n")
+	return b.String()
+}
+
+// envFile renders the EnvironmentFile template: required variables are
+// left empty, optional ones are commented out with their default
+func (t deployTarget) envFile() string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("# Environment of %s, installed as /etc/%s/%s.env (mode 0600)\n", t.app, t.app, t.app))
+	if len(t.info.EnvVars) == 0 {
+		b.WriteString("# The app reads no environment variables\n")
+	}
+	for _, v := range t.info.EnvVars {
+		owner := "built-in"
+		if v.Service != "" {
+			owner = "service " + v.Service
+		}
+		if v.Required {
+			b.WriteString(fmt.Sprintf("\n# %s, required\n%s=\n", owner, v.Name))
+		} else {
+			b.WriteString(fmt.Sprintf("\n# %s, optional\n#%s=%s\n", owner, v.Name, v.Default))
+		}
+	}
+	return b.String()
+}
+
+// caddyfile renders the Caddy site; Caddy obtains certificates on its own
+func (t deployTarget) caddyfile() string {
+	site := t.domain
+	if !t.tls {
+		site = "http://" + t.domain
+	}
+
+	var b strings.Builder
+	b.WriteString(site + " {\n")
+	b.WriteString("\tencode zstd gzip\n")
+	b.WriteString(fmt.Sprintf("\treverse_proxy 127.0.0.1:%d\n", t.info.Port))
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// nginxConf renders the nginx server blocks; with TLS, HTTP redirects to
+// HTTPS and certificates are expected at the certbot paths
+func (t deployTarget) nginxConf() string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("upstream %s {\n", t.app))
+	b.WriteString(fmt.Sprintf("    server 127.0.0.1:%d;\n", t.info.Port))
+	b.WriteString("}\n\n")
+
+	if t.tls {
+		b.WriteString("server {\n")
+		b.WriteString("    listen 80;\n")
+		b.WriteString("    listen [::]:80;\n")
+		b.WriteString(fmt.Sprintf("    server_name %s;\n", t.domain))
+		b.WriteString("    return 301 https://$host$request_uri;\n")
+		b.WriteString("}\n\n")
+	}
+
+	b.WriteString("server {\n")
+	if t.tls {
+		b.WriteString("    listen 443 ssl;\n")
+		b.WriteString("    listen [::]:443 ssl;\n")
+		b.WriteString("    http2 on;\n")
+		b.WriteString(fmt.Sprintf("    server_name %s;\n\n", t.domain))
+		b.WriteString(fmt.Sprintf("    ssl_certificate /etc/letsencrypt/live/%s/fullchain.pem;\n", t.domain))
+		b.WriteString(fmt.Sprintf("    ssl_certificate_key /etc/letsencrypt/live/%s/privkey.pem;\n\n", t.domain))
+	} else {
+		b.WriteString("    listen 80;\n")
+		b.WriteString("    listen [::]:80;\n")
+		b.WriteString(fmt.Sprintf("    server_name %s;\n\n", t.domain))
+	}
+	b.WriteString("    gzip on;\n")
+	b.WriteString("    gzip_types text/css application/javascript application/json;\n\n")
+
+	// The binary sets long-lived cache headers on its assets: keep them out of the access log
+	for _, path := range t.info.AssetPaths {
+		b.WriteString(fmt.Sprintf("    location = %s {\n", path))
+		b.WriteString(fmt.Sprintf("        proxy_pass http://%s;\n", t.app))
+		b.WriteString("        access_log off;\n")
+		b.WriteString("    }\n\n")
+	}
+
+	b.WriteString("    location / {\n")
+	b.WriteString(fmt.Sprintf("        proxy_pass http://%s;\n", t.app))
+	b.WriteString("        proxy_http_version 1.1;\n")
+	b.WriteString("        proxy_set_header Host $host;\n")
+	b.WriteString("        proxy_set_header X-Real-IP $remote_addr;\n")
+	b.WriteString("        proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;\n")
+	b.WriteString("        proxy_set_header X-Forwarded-Proto $scheme;\n")
+	b.WriteString("    }\n")
+	b.WriteString("}\n")
+	return b.String()
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/generator"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// devStopTimeout is how long a replaced server may take to exit before it is killed
+const devStopTimeout = 5 * time.Second
+
+func cmdDev(args []string) {
+	fs := flag.NewFlagSet("dev", flag.ExitOnError)
+	strict := fs.Bool("strict", false, "reject implicit behaviors: unused declarations, unreferenced routes, fallback SQLite, unvalidated saves")
+	interval := fs.Duration("interval", 500*time.Millisecond, "how often the watched files are checked for changes")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "Usage: gmx dev [-strict] [-interval 500ms] <input.gmx | dir> [-- args...]\n\n"+
+			"Builds a development server (see build -dev), then rebuilds and restarts it\n"+
+			"whenever the input file, its imported .gmx files or its Go files change.\n"+
+			"Edited .gmx files are accepted without rewriting gmx.lock; modules stay pinned.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	inputFile := fs.Arg(0)
+
+	// Collect arguments after "--" to pass to the binary
+	var extraArgs []string
+	allArgs := fs.Args()
+	for i, a := range allArgs[1:] {
+		if a == "--" {
+			extraArgs = allArgs[i+2:]
+			break
+		}
+	}
+
+	tmpDir, err := os.MkdirTemp("", "gmx-dev-*")
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	d := &devServer{
+		input:  inputFile,
+		opts:   generator.Options{Dev: true, Strict: *strict},
+		lock:   lockWatch,
+		args:   extraArgs,
+		dir:    tmpDir,
+		exited: make(chan error, 1),
+	}
+	code := d.run(*interval)
+	if err := os.RemoveAll(tmpDir); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+	os.Exit(code)
+}
+
+// devServer rebuilds a .gmx app and restarts its server when its sources change
+type devServer struct {
+	input string
+	opts  generator.Options
+	lock  lockMode
+	args  []string // passed to the server binary
+	dir   string   // holds the successive binaries
+
+	builds  int
+	cmd     *exec.Cmd  // running server, nil when stopped
+	binary  string     // binary of the running server
+	exited  chan error // receives the exit of the running server
+	watched map[string]time.Time
+}
+
+// run watches the sources until SIGINT or SIGTERM and returns the exit code
+func (d *devServer) run(interval time.Duration) int {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	d.rebuild()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if changed := d.changes(); len(changed) > 0 {
+				fmt.Printf("gmx dev: %s changed, rebuilding\n", strings.Join(changed, ", "))
+				d.rebuild()
+			}
+		case err := <-d.exited:
+			d.cmd = nil
+			if err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "gmx dev: server exited: %v (waiting for changes)\n", err)
+			} else {
+				fmt.Println("gmx dev: server exited (waiting for changes)")
+			}
+		case <-sigCh:
+			if err := d.stop(); err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return 1
+			}
+			return 0
+		}
+	}
+}
+
+// rebuild compiles the app and swaps the running server for the new binary;
+// on a build error the previous server keeps running
+func (d *devServer) rebuild() {
+	// Files are listed before the build so that a failing edit is watched too
+	d.watch()
+
+	d.builds++
+	binary := filepath.Join(d.dir, fmt.Sprintf("app-%d", d.builds))
+	start := time.Now()
+	if err := buildBinary(d.input, binary, d.opts, defaultModule, d.lock); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "gmx dev: build failed: %v\n", err)
+		return
+	}
+	fmt.Printf("gmx dev: built in %s\n", time.Since(start).Round(time.Millisecond))
+
+	if err := d.stop(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "gmx dev: %v\n", err)
+	}
+	if err := d.start(binary); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "gmx dev: %v\n", err)
+	}
+}
+
+// start runs a server binary, forwarding stdin/stdout/stderr
+func (d *devServer) start(binary string) error {
+	cmd := exec.Command(binary, d.args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting server: %w", err)
+	}
+	d.cmd, d.binary = cmd, binary
+	go func() { d.exited <- cmd.Wait() }()
+	return nil
+}
+
+// stop interrupts the running server, kills it if it does not exit in
+// time, and removes its binary
+func (d *devServer) stop() error {
+	if d.cmd == nil {
+		return nil
+	}
+	cmd := d.cmd
+	d.cmd = nil
+
+	if err := cmd.Process.Signal(os.Interrupt); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return fmt.Errorf("stopping server: %w", err)
+	}
+	select {
+	case <-d.exited:
+	case <-time.After(devStopTimeout):
+		if err := cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			return fmt.Errorf("killing server: %w", err)
+		}
+		<-d.exited
+	}
+	if err := os.Remove(d.binary); err != nil {
+		return fmt.Errorf("removing old binary: %w", err)
+	}
+	return nil
+}
+
+// watch records the modification times of the input file, the imported .gmx
+// files resolved from it and the Go files next to it
+func (d *devServer) watch() {
+	files := []string{d.input}
+	if resolved, _, err := load(d.input); err == nil {
+		for path := range resolved.Sources {
+			files = append(files, path)
+		}
+		for _, page := range resolved.Main.Pages {
+			files = append(files, page.Path)
+		}
+	} else {
+		// Keep the imports of the last readable version of the input
+		for path := range d.watched {
+			files = append(files, path)
+		}
+	}
+	if goFiles, err := filepath.Glob(filepath.Join(inputDir(d.input), "*.go")); err == nil {
+		files = append(files, goFiles...)
+	}
+
+	d.watched = make(map[string]time.Time, len(files))
+	for _, path := range files {
+		d.watched[path] = modTime(path)
+	}
+}
+
+// changes returns the watched files modified, created or removed since the last build
+func (d *devServer) changes() []string {
+	var changed []string
+	for path, mtime := range d.watched {
+		if !modTime(path).Equal(mtime) {
+			changed = append(changed, filepath.Base(path))
+		}
+	}
+	// New Go files join the build as soon as they appear
+	if goFiles, err := filepath.Glob(filepath.Join(inputDir(d.input), "*.go")); err == nil {
+		for _, path := range goFiles {
+			if _, ok := d.watched[path]; !ok {
+				changed = append(changed, filepath.Base(path))
+			}
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// modTime returns the modification time of a file, zero when it cannot be read
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/generator"
+	"os"
+)
+
+func cmdDiff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	checkCompat := fs.Bool("check-compat", false, "fail when a change breaks the clients of the API: removed routes, renamed JSON fields, narrowed validation")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "Usage: gmx diff [-check-compat] <old.gmx> <new.gmx>\n\n"+
+			"Compares two versions of an app declaration by declaration: the models,\n"+
+			"fields and indexes, the functions and their annotations, the routes and\n"+
+			"the services added (+), removed (-) or changed (~), then the schema\n"+
+			"changes gmx migrate would write for them.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	// Flags may follow the input files: gmx diff old.gmx app.gmx --check-compat
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	previousFile := fs.Arg(0)
+	_ = fs.Parse(fs.Args()[1:])
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	currentFile := fs.Arg(0)
+	_ = fs.Parse(fs.Args()[1:])
+	if fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	previous, _, err := load(previousFile)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %s: %v\n", previousFile, err)
+		os.Exit(1)
+	}
+	current, _, err := load(currentFile)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %s: %v\n", currentFile, err)
+		os.Exit(1)
+	}
+
+	diffs, err := generator.New().Diff(previous, current)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if len(diffs) == 0 {
+		fmt.Println("No semantic changes")
+		return
+	}
+	breaking := 0
+	for _, d := range diffs {
+		if *checkCompat && d.Breaking != "" {
+			breaking++
+			fmt.Printf("%s  [breaking: %s]\n", d, d.Breaking)
+			continue
+		}
+		fmt.Println(d)
+	}
+	if breaking > 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "\n%d breaking change(s) for API clients\n", breaking)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/generator"
+	"github.com/btouchard/gmx/internal/compiler/resolver"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func cmdDocs(args []string) {
+	fs := flag.NewFlagSet("docs", flag.ExitOnError)
+	outDir := fs.String("o", "gmx-docs", "directory the reference is written to, as index.html")
+	serve := fs.String("serve", "", "serve the reference on this address instead, such as :6060; each request renders the current sources")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "Usage: gmx docs [-o dir] [-serve addr] <input.gmx | dir>\n\n"+
+			"Renders an HTML reference of a project: its models with their fields and\n"+
+			"annotations, its routes with their parameters, its services with their\n"+
+			"environment variables.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	inputFile := fs.Arg(0)
+	// Flags may follow the input file: gmx docs app.gmx -serve :6060
+	_ = fs.Parse(fs.Args()[1:])
+
+	if *serve != "" {
+		http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			page, err := renderDocs(inputFile)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write(page)
+		})
+		fmt.Printf("Serving the reference of %s on %s\n", inputFile, *serve)
+		if err := http.ListenAndServe(*serve, nil); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	page, err := renderDocs(inputFile)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	path := filepath.Join(*outDir, "index.html")
+	if err := os.WriteFile(path, page, 0644); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote %s\n", path)
+}
+
+// docsPage is the data of the reference page
+type docsPage struct {
+	Project  string
+	Models   []docsModel
+	Routes   []docsRoute
+	Services []docsService
+	EnvVars  []generator.EnvVar
+}
+
+type docsModel struct {
+	Name        string
+	Annotations string
+	Line        int
+	Fields      []docsField
+	Indexes     []string
+}
+
+type docsField struct {
+	Name        string
+	Type        string
+	Annotations string
+}
+
+type docsRoute struct {
+	Method      string
+	Path        string
+	Source      string
+	Line        int
+	Params      string
+	Annotations string
+}
+
+type docsService struct {
+	Name     string
+	Provider string
+	Env      []string
+	Methods  []string
+}
+
+// renderDocs loads a project and renders its reference page
+func renderDocs(inputFile string) ([]byte, error) {
+	resolved, _, err := load(inputFile)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if err := docsTemplate.Execute(&b, docsData(inputFile, resolved)); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// docsData gathers the declarations documented by the reference page
+func docsData(inputFile string, resolved *resolver.ResolvedFile) docsPage {
+	file := resolved.Main
+	gen := generator.New()
+	page := docsPage{Project: filepath.Base(inputFile), EnvVars: gen.DeployInfo(resolved).EnvVars}
+
+	for _, model := range file.Models {
+		m := docsModel{Name: model.Name, Annotations: annotationList(model.Annotations), Line: model.Line}
+		for _, field := range model.Fields {
+			m.Fields = append(m.Fields, docsField{Name: field.Name, Type: field.TypeString(), Annotations: annotationList(field.Annotations)})
+		}
+		for _, index := range model.Indexes {
+			desc := fmt.Sprintf("%s([%s])", index.TokenLiteral(), strings.Join(index.Fields, ", "))
+			if index.Name != "" {
+				desc = fmt.Sprintf("%s([%s], name: %q)", index.TokenLiteral(), strings.Join(index.Fields, ", "), index.Name)
+			}
+			m.Indexes = append(m.Indexes, desc)
+		}
+		page.Models = append(page.Models, m)
+	}
+
+	funcs := make(map[string]*ast.FuncDecl)
+	if file.Script != nil {
+		for _, fn := range file.Script.Funcs {
+			funcs["func "+fn.Name] = fn
+		}
+	}
+	for _, route := range gen.Routes(resolved) {
+		r := docsRoute{Method: route.Method, Path: route.Path, Source: route.Source, Line: route.Line}
+		if fn, ok := funcs[route.Source]; ok {
+			params := make([]string, len(fn.Params))
+			for i, p := range fn.Params {
+				params[i] = p.Name + ": " + p.Type
+			}
+			r.Params = strings.Join(params, ", ")
+			r.Annotations = annotationList(fn.Annotations)
+		}
+		page.Routes = append(page.Routes, r)
+	}
+
+	for _, svc := range file.Services {
+		s := docsService{Name: svc.Name, Provider: svc.Provider}
+		for _, field := range svc.Fields {
+			if field.EnvVar != "" {
+				s.Env = append(s.Env, field.EnvVar)
+			}
+		}
+		for _, method := range svc.Methods {
+			params := make([]string, len(method.Params))
+			for i, p := range method.Params {
+				params[i] = p.Name + ": " + p.Type
+			}
+			s.Methods = append(s.Methods, strings.TrimSpace(fmt.Sprintf("%s(%s) %s", method.Name, strings.Join(params, ", "), method.ReturnType)))
+		}
+		page.Services = append(page.Services, s)
+	}
+	return page
+}
+
+var docsTemplate = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Project}} reference</title>
+<style>
+  body { font-family: system-ui, sans-serif; margin: 0; color: #1f2937; }
+  nav { position: fixed; top: 0; bottom: 0; width: 14rem; overflow-y: auto; padding: 1.5rem; background: #f3f4f6; box-sizing: border-box; }
+  nav a { display: block; color: #374151; text-decoration: none; padding: .15rem 0; }
+  nav h3 { margin: 1rem 0 .25rem; font-size: .75rem; text-transform: uppercase; color: #6b7280; }
+  main { margin-left: 14rem; padding: 1.5rem 2.5rem; max-width: 60rem; }
+  h2 { border-bottom: 1px solid #e5e7eb; padding-bottom: .25rem; margin-top: 2.5rem; }
+  table { border-collapse: collapse; width: 100%; margin: .5rem 0 1.5rem; }
+  th, td { text-align: left; padding: .35rem .6rem; border-bottom: 1px solid #e5e7eb; vertical-align: top; }
+  th { font-size: .8rem; color: #6b7280; }
+  code { font-family: ui-monospace, monospace; font-size: .9em; }
+  .method { font-weight: 600; font-family: ui-monospace, monospace; }
+  .muted { color: #6b7280; }
+</style>
+</head>
+<body>
+<nav>
+  <strong>{{.Project}}</strong>
+  {{- if .Models}}
+  <h3>Models</h3>
+  {{- range .Models}}
+  <a href="#model-{{.Name}}">{{.Name}}</a>
+  {{- end}}
+  {{- end}}
+  <h3>Reference</h3>
+  <a href="#routes">Routes</a>
+  {{- if .Services}}
+  <a href="#services">Services</a>
+  {{- end}}
+  {{- if .EnvVars}}
+  <a href="#env">Environment</a>
+  {{- end}}
+</nav>
+<main>
+<h1>{{.Project}}</h1>
+
+{{- if .Models}}
+<h2>Models</h2>
+{{- range .Models}}
+<h3 id="model-{{.Name}}">{{.Name}} {{if .Annotations}}<code class="muted">{{.Annotations}}</code>{{end}}</h3>
+<table>
+  <tr><th>Field</th><th>Type</th><th>Annotations</th></tr>
+  {{- range .Fields}}
+  <tr><td><code>{{.Name}}</code></td><td><code>{{.Type}}</code></td><td><code>{{.Annotations}}</code></td></tr>
+  {{- end}}
+</table>
+{{- if .Indexes}}
+<p>Indexes: {{range $i, $index := .Indexes}}{{if $i}}, {{end}}<code>{{$index}}</code>{{end}}</p>
+{{- end}}
+{{- end}}
+{{- end}}
+
+<h2 id="routes">Routes</h2>
+<table>
+  <tr><th>Method</th><th>Path</th><th>Parameters</th><th>Source</th></tr>
+  {{- range .Routes}}
+  <tr>
+    <td class="method">{{.Method}}</td>
+    <td><code>{{.Path}}</code></td>
+    <td><code>{{.Params}}</code>{{if .Annotations}} <code class="muted">{{.Annotations}}</code>{{end}}</td>
+    <td>{{.Source}}{{if .Line}} <span class="muted">(line {{.Line}})</span>{{end}}</td>
+  </tr>
+  {{- end}}
+</table>
+
+{{- if .Services}}
+<h2 id="services">Services</h2>
+<table>
+  <tr><th>Service</th><th>Provider</th><th>Environment</th><th>Methods</th></tr>
+  {{- range .Services}}
+  <tr>
+    <td><code>{{.Name}}</code></td>
+    <td>{{.Provider}}</td>
+    <td>{{range .Env}}<code>{{.}}</code><br>{{end}}</td>
+    <td>{{range .Methods}}<code>{{.}}</code><br>{{end}}</td>
+  </tr>
+  {{- end}}
+</table>
+{{- end}}
+
+{{- if .EnvVars}}
+<h2 id="env">Environment</h2>
+<table>
+  <tr><th>Variable</th><th>Used by</th><th>Required</th><th>Default</th></tr>
+  {{- range .EnvVars}}
+  <tr>
+    <td><code>{{.Name}}</code></td>
+    <td>{{if .Service}}{{.Service}}{{else}}<span class="muted">built-in</span>{{end}}</td>
+    <td>{{if .Required}}yes{{else}}no{{end}}</td>
+    <td><code>{{.Default}}</code></td>
+  </tr>
+  {{- end}}
+</table>
+{{- end}}
+</main>
+</body>
+</html>
+`))
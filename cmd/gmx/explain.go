@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/generator"
+	"github.com/btouchard/gmx/internal/compiler/script"
+	"go/format"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// gmxLineComment matches the source-map comments of the transpiler: // gmx:12
+var gmxLineComment = regexp.MustCompile(`(?m)^(\s*)// gmx:(\d+)$`)
+
+func cmdExplain(args []string) {
+	fs := flag.NewFlagSet("explain", flag.ExitOnError)
+	funcName := fs.String("func", "", "script function to explain")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "Usage: gmx explain <input.gmx> -func name\n\nPrints the Go code generated for a script function, each source-map line\nannotated with the .gmx line it comes from.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	inputFile := fs.Arg(0)
+	// Flags may follow the input file: gmx explain app.gmx --func toggleTask
+	_ = fs.Parse(fs.Args()[1:])
+	if *funcName == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	resolved, file, err := load(inputFile)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var fn *ast.FuncDecl
+	var names []string
+	if file.Script != nil {
+		for _, f := range file.Script.Funcs {
+			names = append(names, f.Name)
+			if f.Name == *funcName {
+				fn = f
+			}
+		}
+	}
+	if fn == nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: no script function %q in %s (functions: %s)\n", *funcName, inputFile, strings.Join(names, ", "))
+		os.Exit(1)
+	}
+
+	modelNames := make([]string, len(file.Models))
+	for i, model := range file.Models {
+		modelNames[i] = model.Name
+	}
+	result := script.TranspileFunction(fn, modelNames)
+	if len(result.Errors) > 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: transpile errors: %v\n", result.Errors)
+		os.Exit(1)
+	}
+	code, err := format.Source([]byte(strings.TrimSpace(result.GoCode) + "\n"))
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: formatting generated code: %v\n", err)
+		os.Exit(1)
+	}
+
+	source, err := os.ReadFile(inputFile)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: reading %s: %v\n", inputFile, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("// %s:%d\n", inputFile, fn.Line)
+	for _, route := range generator.New().Routes(resolved) {
+		if route.Source == "func "+fn.Name {
+			fmt.Printf("// %s %s → %s\n", route.Method, route.Path, route.Handler)
+		}
+	}
+	fmt.Print(annotateSourceLines(string(code), strings.Split(string(source), "\n")))
+}
+
+// annotateSourceLines appends the .gmx source line to each // gmx:N comment
+func annotateSourceLines(code string, lines []string) string {
+	return gmxLineComment.ReplaceAllStringFunc(code, func(match string) string {
+		m := gmxLineComment.FindStringSubmatch(match)
+		n, err := strconv.Atoi(m[2])
+		if err != nil || n < 1 || n > len(lines) {
+			return match
+		}
+		return fmt.Sprintf("%s// gmx:%d  %s", m[1], n, strings.TrimSpace(lines[n-1]))
+	})
+}
@@ -12,6 +12,7 @@ import (
 var (
 	openTagRe  = regexp.MustCompile(`^<(script|template|style)(\s+scoped)?>$`)
 	closeTagRe = regexp.MustCompile(`^</(script|template|style)>$`)
+	pragmaRe   = regexp.MustCompile(`^gmx\s+(\S+)$`)
 )
 
 func cmdFmt(args []string) {
@@ -46,17 +47,24 @@ type section struct {
 	content string
 }
 
-// parseSections extracts top-level sections from a .gmx file.
+// parseSections extracts top-level sections from a .gmx file, along with
+// the `gmx 1.0` version pragma preceding them, if any.
 // Only tags at column 0 (start of line) are considered section boundaries.
-func parseSections(input string) []section {
+func parseSections(input string) (string, []section) {
 	lines := strings.Split(input, "\n")
 	var sections []section
 	var current *section
 	var contentLines []string
+	pragma := ""
 
 	for _, line := range lines {
 		trimmed := strings.TrimRight(line, " \t\r")
 
+		if m := pragmaRe.FindStringSubmatch(strings.TrimSpace(trimmed)); m != nil && current == nil && len(sections) == 0 {
+			pragma = "gmx " + m[1]
+			continue
+		}
+
 		if m := openTagRe.FindStringSubmatch(trimmed); m != nil && current == nil {
 			current = &section{tag: m[1], attr: m[2]}
 			contentLines = nil
@@ -76,7 +84,7 @@ func parseSections(input string) []section {
 		}
 	}
 
-	return sections
+	return pragma, sections
 }
 
 func fmtFile(path string, showDiff bool) error {
@@ -86,7 +94,7 @@ func fmtFile(path string, showDiff bool) error {
 	}
 
 	original := string(data)
-	sections := parseSections(original)
+	pragma, sections := parseSections(original)
 
 	if len(sections) == 0 {
 		return fmt.Errorf("no sections found")
@@ -105,6 +113,9 @@ func fmtFile(path string, showDiff bool) error {
 
 	// Rebuild file
 	var b strings.Builder
+	if pragma != "" {
+		b.WriteString(pragma + "\n\n")
+	}
 	for i, s := range ordered {
 		if i > 0 {
 			b.WriteString("\n")
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/generator"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func cmdIndexes(args []string) {
+	fs := flag.NewFlagSet("indexes", flag.ExitOnError)
+	apply := fs.Bool("apply", false, "declare the suggested indexes in the models, and write their migration if the app has migrations")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "Usage: gmx indexes [-apply] <input.gmx | dir>\n\n"+
+			"Inspects the where(), order() and @scoped filters of the model queries and\n"+
+			"suggests the indexes they would use that the models do not declare.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	inputFile := fs.Arg(0)
+	// Flags may follow the input file: gmx indexes app.gmx -apply
+	_ = fs.Parse(fs.Args()[1:])
+
+	resolved, _, err := load(inputFile)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	suggestions := generator.New().SuggestIndexes(resolved)
+	if len(suggestions) == 0 {
+		fmt.Println("No missing indexes")
+		return
+	}
+	for _, s := range suggestions {
+		position := fmt.Sprintf("line %d", s.Line)
+		// Queries of a directory build come from several files
+		if !isDirBuild(inputFile) {
+			position = fmt.Sprintf("%s:%d", inputFile, s.Line)
+		}
+		fmt.Printf("%s: %s; add %s to model %s\n", position, indexReason(s), s.Declaration(), s.Model)
+	}
+	if !*apply {
+		return
+	}
+
+	if isDirBuild(inputFile) {
+		_, _ = fmt.Fprintln(os.Stderr, "Error: -apply edits single-file apps; declare the indexes in the models of the directory")
+		os.Exit(1)
+	}
+	if err := applyIndexes(inputFile, resolved.Main.Models, suggestions); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Declared %d index(es) in %s\n", len(suggestions), inputFile)
+
+	// Apps without migrations create the indexes with AutoMigrate on startup
+	if _, err := os.Stat(filepath.Join(inputDir(inputFile), migrationsDir)); err != nil {
+		return
+	}
+	written, err := writeMigration(inputFile, "", false)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	for _, path := range written {
+		fmt.Printf("Wrote %s\n", path)
+	}
+}
+
+// indexReason describes the queries an index would serve:
+// 3 queries of Task filter by tenantId, status and sort by createdAt
+func indexReason(s generator.IndexSuggestion) string {
+	var b strings.Builder
+	if s.Queries == 1 {
+		fmt.Fprintf(&b, "a query of %s", s.Model)
+	} else {
+		fmt.Fprintf(&b, "%d queries of %s", s.Queries, s.Model)
+	}
+	if len(s.Filter) > 0 {
+		b.WriteString(" filter by " + strings.Join(s.Filter, ", "))
+		if s.Order != "" {
+			b.WriteString(" and")
+		}
+	}
+	if s.Order != "" {
+		b.WriteString(" sort by " + s.Order)
+	}
+	return b.String()
+}
+
+// applyIndexes declares the suggested indexes at the end of the body of
+// their models, indented as the fields
+func applyIndexes(inputFile string, models []*ast.ModelDecl, suggestions []generator.IndexSuggestion) error {
+	data, err := os.ReadFile(inputFile)
+	if err != nil {
+		return fmt.Errorf("reading file: %w", err)
+	}
+	lines := strings.Split(string(data), "\n")
+
+	// Insert from the last model, keeping the lines of the others
+	inserts := make(map[int][]string)
+	for _, s := range suggestions {
+		var model *ast.ModelDecl
+		for _, m := range models {
+			if m.Name == s.Model {
+				model = m
+			}
+		}
+		if model == nil {
+			return fmt.Errorf("model %s not found in %s", s.Model, inputFile)
+		}
+		end, indent, err := modelBodyEnd(lines, model)
+		if err != nil {
+			return err
+		}
+		inserts[end] = append(inserts[end], indent+s.Declaration())
+	}
+	var out []string
+	for i, line := range lines {
+		out = append(out, inserts[i]...)
+		out = append(out, line)
+	}
+	if err := os.WriteFile(inputFile, []byte(strings.Join(out, "\n")), 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", inputFile, err)
+	}
+	return nil
+}
+
+// modelBodyEnd returns the index of the line closing the body of a model,
+// and the indentation of its first field; braces in strings and comments
+// are skipped
+func modelBodyEnd(lines []string, model *ast.ModelDecl) (int, string, error) {
+	depth, indent := 0, ""
+	for i := model.Line - 1; i >= 0 && i < len(lines); i++ {
+		line := lines[i]
+		if depth > 0 && indent == "" && strings.TrimSpace(line) != "" {
+			indent = line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		}
+		inString := false
+		for j := 0; j < len(line); j++ {
+			switch c := line[j]; {
+			case c == '"':
+				inString = !inString
+			case inString && c == '\\':
+				j++
+			case inString:
+			case c == '/' && j+1 < len(line) && line[j+1] == '/':
+				j = len(line)
+			case c == '{':
+				depth++
+			case c == '}':
+				depth--
+				if depth == 0 {
+					if strings.TrimSpace(line[:j]) != "" {
+						return 0, "", fmt.Errorf("line %d: model %s closes on the line of a declaration; move its } to a line of its own", i+1, model.Name)
+					}
+					return i, indent, nil
+				}
+			}
+		}
+	}
+	return 0, "", fmt.Errorf("line %d: end of model %s not found", model.Line, model.Name)
+}
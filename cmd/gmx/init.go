@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Module versions required by the generated code of the starter app, matching
+// the versions GMX itself is tested against (see go.mod)
+const (
+	initGoVersion     = "1.24"
+	initGormVersion   = "v1.31.1"
+	initSQLiteVersion = "v1.6.0"
+)
+
+func cmdInit(args []string) {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	module := fs.String("module", "", "Go module path of the project (default: the project name)")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "Usage: gmx init [-module path] <name>\n\n"+
+			"Creates the directory <name> with a starter app: <name>.gmx, a go.mod\n"+
+			"requiring the modules of the generated code, a Makefile and a .gitignore.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	dir := fs.Arg(0)
+	app := filepath.Base(filepath.Clean(dir))
+	if app == "." || app == string(filepath.Separator) || strings.ContainsAny(app, " \t") {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: invalid project name %q\n", dir)
+		os.Exit(1)
+	}
+	if *module == "" {
+		*module = app
+	}
+
+	files := []struct{ name, content string }{
+		{app + ".gmx", strings.ReplaceAll(starterApp, "APP_NAME", app)},
+		{"go.mod", starterGoMod(*module)},
+		{"Makefile", starterMakefile(app)},
+		{".gitignore", starterGitignore(app)},
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: creating project directory: %v\n", err)
+		os.Exit(1)
+	}
+	// Existing files are never overwritten, so init can't clobber a project
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+		if _, err := os.Stat(path); err == nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Error: %s already exists\n", path)
+			os.Exit(1)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+		if err := os.WriteFile(path, []byte(f.content), 0o644); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Error: writing %s: %v\n", path, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Generated %s\n", path)
+	}
+
+	fmt.Printf("\nNext steps:\n  cd %s\n  make dev    # serves http://localhost:8080, rebuilt on every change\n", dir)
+}
+
+// starterApp is the example .gmx file: a task list with a model, script
+// functions and an HTMX template; APP_NAME is replaced by the project name
+const starterApp = `<script>
+service Database {
+  provider: "sqlite"
+  url:      string @env("DATABASE_URL") @default("APP_NAME.db")
+}
+
+model Task {
+  id:        uuid     @pk @default(uuid_v4)
+  title:     string   @min(1) @max(255)
+  done:      bool     @default(false)
+  createdAt: datetime
+}
+
+func listTasks() error {
+  let tasks = try Task.all()
+  return render(tasks)
+}
+
+func createTask(title: string) error {
+  const task = Task{title: title}
+  try task.save()
+  return render(task)
+}
+
+func toggleTask(id: uuid) error {
+  let task = try Task.find(id)
+  task.done = !task.done
+  try task.save()
+  return render(task)
+}
+</script>
+
+<template>
+<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="UTF-8">
+  <title>APP_NAME</title>
+  <script src="https://unpkg.com/htmx.org@2.0.4"></script>
+</head>
+<body>
+  <h1>APP_NAME</h1>
+
+  <form hx-post="{{route "createTask"}}" hx-target="#tasks" hx-swap="beforeend">
+    <input type="text" name="title" placeholder="What needs to be done?" required />
+    <button type="submit">Add</button>
+  </form>
+
+  <ul id="tasks">
+    {{range .Tasks}}
+    <li id="task-{{.ID}}">
+      <input type="checkbox" {{if .Done}}checked{{end}}
+             hx-patch="{{route "toggleTask"}}?id={{.ID}}" hx-target="#task-{{.ID}}" hx-swap="outerHTML" />
+      {{.Title}}
+    </li>
+    {{end}}
+  </ul>
+</body>
+</html>
+</template>
+`
+
+// starterGoMod renders the go.mod of the project, used when the generated
+// sources are emitted into it and built with the go tool
+func starterGoMod(module string) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("module %s\n\n", module))
+	b.WriteString(fmt.Sprintf("go %s\n\n", initGoVersion))
+	b.WriteString("require (\n")
+	b.WriteString(fmt.Sprintf("\tgorm.io/driver/sqlite %s\n", initSQLiteVersion))
+	b.WriteString(fmt.Sprintf("\tgorm.io/gorm %s\n", initGormVersion))
+	b.WriteString(")\n")
+	return b.String()
+}
+
+// starterMakefile renders the Makefile driving the gmx commands; the emit
+// target builds the generated sources with the project's go.mod
+func starterMakefile(app string) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("APP := %s\n\n", app))
+	b.WriteString(".PHONY: dev run build emit clean\n\n")
+	b.WriteString("# Development server, rebuilt and restarted on every change\n")
+	b.WriteString("dev:\n\tgmx dev $(APP).gmx\n\n")
+	b.WriteString("run:\n\tgmx run $(APP).gmx\n\n")
+	b.WriteString("# Single binary, ready to deploy\n")
+	b.WriteString("build:\n\tgmx build $(APP).gmx\n\n")
+	b.WriteString("# Generated Go sources in server/, built with go.mod\n")
+	b.WriteString("emit:\n\tgmx build -emit server $(APP).gmx\n\tgo mod tidy\n\tgo build -o $(APP) ./server\n\n")
+	b.WriteString("clean:\n\trm -rf $(APP) server\n")
+	return b.String()
+}
+
+// starterGitignore renders the .gitignore of the project; gmx.lock is kept
+// under version control, the reference written by gmx docs is not
+func starterGitignore(app string) string {
+	return fmt.Sprintf("/%s\n/server/\n/gmx-docs/\n*.db\n", app)
+}
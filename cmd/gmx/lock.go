@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/resolver"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// lockMode is how a build treats entries that changed since gmx.lock was written
+type lockMode int
+
+const (
+	lockVerify lockMode = iota // fail on changed entries
+	lockUpdate                 // accept changed entries and rewrite the lock (--update)
+	lockWatch                  // accept edited .gmx files, keep modules pinned, never write the lock (gmx dev)
+)
+
+// buildLock tracks gmx.lock across a build: the pinned entries read from
+// disk and the entries observed by this build
+type buildLock struct {
+	path    string
+	locked  *resolver.Lock // nil without a lock file
+	current *resolver.Lock
+	update  bool // accept changed entries instead of failing
+	watch   bool // accept edited .gmx files, leaving the lock file untouched
+}
+
+// lockModeFor returns the lock mode of a build run with or without --update
+func lockModeFor(update bool) lockMode {
+	if update {
+		return lockUpdate
+	}
+	return lockVerify
+}
+
+// checkImportLock hashes the imported .gmx files and compares them with the
+// gmx.lock next to the input file, or in the input directory
+func checkImportLock(inputFile string, resolved *resolver.ResolvedFile, mode lockMode) (*buildLock, error) {
+	dir := inputDir(inputFile)
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("resolving input directory: %w", err)
+	}
+
+	bl := &buildLock{path: filepath.Join(dir, resolver.LockFile), current: resolver.NewLock(), update: mode == lockUpdate, watch: mode == lockWatch}
+	if bl.locked, err = resolver.ReadLock(bl.path); err != nil {
+		return nil, err
+	}
+	if err := bl.current.AddSources(absDir, resolved.Sources); err != nil {
+		return nil, err
+	}
+	// Files being edited keep their locked hashes until the next regular build
+	if bl.watch && bl.locked != nil {
+		bl.current.Files = bl.locked.Files
+	}
+	// Entries of a lock written by a full build that this step does not observe are kept
+	if bl.locked != nil {
+		for path, m := range bl.locked.Modules {
+			bl.current.Modules[path] = m
+		}
+	}
+	return bl, bl.verify()
+}
+
+// verify fails when a locked entry changed, unless the build accepts updates
+func (bl *buildLock) verify() error {
+	if bl.locked == nil || bl.update {
+		return nil
+	}
+	if diffs := bl.locked.Mismatches(bl.current); len(diffs) > 0 {
+		return fmt.Errorf("%s does not match the sources:\n  %s\nrebuild with --update to accept the changes", bl.path, strings.Join(diffs, "\n  "))
+	}
+	return nil
+}
+
+// pinModules writes the locked module versions into the build module
+// before its dependencies are resolved
+func (bl *buildLock) pinModules(buildDir string) error {
+	if bl.locked == nil || bl.update || len(bl.locked.Modules) == 0 {
+		return nil
+	}
+
+	paths := make([]string, 0, len(bl.locked.Modules))
+	for path := range bl.locked.Modules {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var require strings.Builder
+	require.WriteString("\nrequire (\n")
+	for _, path := range paths {
+		require.WriteString(fmt.Sprintf("\t%s %s\n", path, bl.locked.Modules[path].Version))
+	}
+	require.WriteString(")\n")
+
+	goMod := filepath.Join(buildDir, "go.mod")
+	f, err := os.OpenFile(goMod, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("pinning locked modules: %w", err)
+	}
+	if _, err := f.WriteString(require.String()); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("pinning locked modules: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("pinning locked modules: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(buildDir, "go.sum"), []byte(bl.locked.GoSumLines()), 0644); err != nil {
+		return fmt.Errorf("pinning locked modules: %w", err)
+	}
+	return nil
+}
+
+// recordModules replaces the module entries with the versions the build
+// module resolved for the native imports, then verifies them
+func (bl *buildLock) recordModules(buildDir string, resolved *resolver.ResolvedFile) error {
+	var imports []string
+	for _, imp := range resolved.Main.Imports {
+		if imp.IsNative {
+			imports = append(imports, imp.Path)
+		}
+	}
+
+	goSum, err := os.ReadFile(filepath.Join(buildDir, "go.sum"))
+	if os.IsNotExist(err) {
+		goSum = nil // no third-party dependency
+	} else if err != nil {
+		return fmt.Errorf("reading go.sum: %w", err)
+	}
+	bl.current.Modules = make(map[string]resolver.Module)
+	bl.current.AddModules(goSum, imports)
+	return bl.verify()
+}
+
+// write saves the observed entries when they differ from the lock file;
+// apps without imports and watch builds do not write it
+func (bl *buildLock) write() error {
+	if bl.watch || (bl.locked == nil && bl.current.IsEmpty()) {
+		return nil
+	}
+	content := bl.current.Format()
+	if bl.locked != nil && bl.locked.Format() == content {
+		return nil
+	}
+	if err := os.WriteFile(bl.path, []byte(content), 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", bl.path, err)
+	}
+	return nil
+}
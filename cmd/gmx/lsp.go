@@ -0,0 +1,21 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/btouchard/gmx/internal/lsp"
+	"os"
+)
+
+func cmdLSP(args []string) {
+	fs := flag.NewFlagSet("lsp", flag.ExitOnError)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "Usage: gmx lsp\n\nServes .gmx files to an editor over the Language Server Protocol on stdin\nand stdout: parse errors as diagnostics, completion of annotations and model\nfields in script blocks, and go-to-definition across imported files.\n")
+	}
+	_ = fs.Parse(args)
+
+	if err := lsp.NewServer(os.Stdin, os.Stdout).Run(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -17,12 +17,40 @@ func main() {
 	args := os.Args[2:]
 
 	switch cmd {
+	case "init":
+		cmdInit(args)
 	case "build":
 		cmdBuild(args)
 	case "run":
 		cmdRun(args)
+	case "test":
+		cmdTest(args)
+	case "dev":
+		cmdDev(args)
 	case "fmt":
 		cmdFmt(args)
+	case "deploy-config":
+		cmdDeployConfig(args)
+	case "report":
+		cmdReport(args)
+	case "routes":
+		cmdRoutes(args)
+	case "explain":
+		cmdExplain(args)
+	case "migrate":
+		cmdMigrate(args)
+	case "indexes":
+		cmdIndexes(args)
+	case "diff":
+		cmdDiff(args)
+	case "replay":
+		cmdReplay(args)
+	case "ast":
+		cmdAST(args)
+	case "docs":
+		cmdDocs(args)
+	case "lsp":
+		cmdLSP(args)
 	default:
 		// Fallback: if an argument looks like a .gmx file, treat as "build"
 		if strings.HasSuffix(cmd, ".gmx") {
@@ -40,9 +68,23 @@ func printUsage() {
 	_, _ = fmt.Fprintf(os.Stderr, `Usage: %s <command> [arguments]
 
 Commands:
-  build   Compile a .gmx file into a Go binary
-  run     Build and run a .gmx file immediately
-  fmt     Format .gmx files
+  init           Create a starter project: a .gmx app, go.mod, Makefile and .gitignore
+  build          Compile a .gmx file, or a directory of .gmx pages, into a Go binary
+  run            Build and run a .gmx file immediately
+  test           Run the generated handler tests of a .gmx file
+  dev            Run a .gmx file, rebuilding and restarting it when its sources change
+  fmt            Format .gmx files
+  deploy-config  Generate a systemd unit and a Caddy or nginx site for a .gmx app
+  report         Summarize the models, routes, services and functions of a .gmx app
+  routes         List the routes registered by a .gmx app
+  explain        Show the Go code generated for a script function
+  migrate        Write the SQL migration of the model changes since the last migration
+  indexes        Suggest the indexes missing for the model queries of a .gmx app
+  docs           Render an HTML reference of the models, routes and services of a .gmx app
+  diff           Compare two versions of a .gmx app: models, fields, routes, services and schema
+  replay         Send the requests recorded by a dev build run with --record again
+  ast            Print the declarations of a .gmx file, or its syntax tree as JSON
+  lsp            Run a language server for editors over stdin and stdout
 
 Run '%s <command> -h' for command-specific help.
 
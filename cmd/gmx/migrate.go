@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/generator"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// migrationsDir is the directory of the migrations, next to the input file
+const migrationsDir = "migrations"
+
+// schemaFile is the snapshot of the schema the migrations lead to
+const schemaFile = "schema.json"
+
+// migrationFile matches the up files of migrations: 0002_add_posts_photo.up.sql
+var migrationFile = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.up\.sql$`)
+
+// migrationName matches the names given with -name
+var migrationName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+func cmdMigrate(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	name := fs.String("name", "", "name of the migration (default: derived from the changes)")
+	allowDestructive := fs.Bool("allow-destructive", false, "accept changes that lose data: dropped tables and columns, column type changes")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "Usage: gmx migrate [-name name] [-allow-destructive] <input.gmx | dir>\n\n"+
+			"Compares the models with migrations/schema.json and writes the SQL migration\n"+
+			"of the changes for each provider (migrations/sqlite, postgres, mysql).\n"+
+			"Builds embed the migrations of their provider and apply them on startup.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	inputFile := fs.Arg(0)
+	// Flags may follow the input file: gmx migrate app.gmx -name add_tags
+	_ = fs.Parse(fs.Args()[1:])
+
+	written, err := writeMigration(inputFile, *name, *allowDestructive)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if len(written) == 0 {
+		fmt.Println("No schema changes")
+		return
+	}
+	for _, path := range written {
+		fmt.Printf("Wrote %s\n", path)
+	}
+}
+
+// writeMigration writes the migration of the changes between the schema
+// snapshot and the models, and returns the written files
+func writeMigration(inputFile, name string, allowDestructive bool) ([]string, error) {
+	c, err := compile(inputFile, generator.Options{}, lockVerify)
+	if err != nil {
+		return nil, err
+	}
+	current, err := generator.New().Schema(c.resolved)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(inputDir(inputFile), migrationsDir)
+	previous, err := readSchema(filepath.Join(dir, schemaFile))
+	if err != nil {
+		return nil, err
+	}
+	changes, err := generator.DiffSchema(previous, current)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	var destructive []string
+	for _, change := range changes {
+		if change.Destructive() {
+			destructive = append(destructive, change.String())
+		}
+	}
+	if len(destructive) > 0 && !allowDestructive {
+		return nil, fmt.Errorf("these changes lose data:\n  %s\nrun with -allow-destructive to write the migration anyway", strings.Join(destructive, "\n  "))
+	}
+
+	current.Version = previous.Version + 1
+	if name == "" {
+		name = generator.MigrationName(current.Version, changes)
+	}
+	if !migrationName.MatchString(name) {
+		return nil, fmt.Errorf("invalid migration name %q: use lowercase letters, digits and underscores", name)
+	}
+
+	var upSummary, downSummary strings.Builder
+	for _, change := range changes {
+		upSummary.WriteString("-- " + change.String() + "\n")
+		downSummary.WriteString("-- revert " + change.String() + "\n")
+	}
+	var written []string
+	for _, provider := range generator.MigrationProviders {
+		up, down := generator.MigrationSQL(changes, provider)
+		m := generator.Migration{Version: current.Version, Name: name}
+		providerDir := filepath.Join(dir, provider)
+		if err := os.MkdirAll(providerDir, 0755); err != nil {
+			return nil, fmt.Errorf("creating migrations directory: %w", err)
+		}
+		for suffix, sql := range map[string]string{".up.sql": upSummary.String() + "\n" + up, ".down.sql": downSummary.String() + "\n" + down} {
+			path := filepath.Join(providerDir, m.FileName()+suffix)
+			if err := os.WriteFile(path, []byte(sql), 0644); err != nil {
+				return nil, fmt.Errorf("writing migration: %w", err)
+			}
+		}
+		written = append(written, filepath.Join(providerDir, m.FileName()+".{up,down}.sql"))
+	}
+
+	data, err := json.MarshalIndent(current, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, schemaFile)
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return nil, fmt.Errorf("writing %s: %w", path, err)
+	}
+	return append(written, path), nil
+}
+
+// readSchema reads the schema snapshot, empty before the first migration
+func readSchema(path string) (*generator.Schema, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &generator.Schema{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	var schema generator.Schema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return &schema, nil
+}
+
+// loadMigrations reads the migrations of a provider next to the input file;
+// builds without a migrations directory use AutoMigrate
+func loadMigrations(inputFile, provider string) ([]generator.Migration, error) {
+	dir := filepath.Join(inputDir(inputFile), migrationsDir, provider)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading migrations: %w", err)
+	}
+
+	var migrations []generator.Migration
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(match[1])
+		up, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("reading migration: %w", err)
+		}
+		down, err := os.ReadFile(filepath.Join(dir, strings.TrimSuffix(entry.Name(), ".up.sql")+".down.sql"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("reading migration: %w", err)
+		}
+		migrations = append(migrations, generator.Migration{Version: version, Name: match[2], Up: string(up), Down: string(down)})
+	}
+	generator.SortMigrations(migrations)
+	return migrations, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// recordedExchange is a request recorded by a dev build run with --record
+type recordedExchange struct {
+	Method    string      `json:"method"`
+	URL       string      `json:"url"`
+	Header    http.Header `json:"header"`
+	Body      string      `json:"body"`
+	Truncated bool        `json:"truncated"`
+	Status    int         `json:"status"`
+}
+
+// replayHopHeaders are recorded headers the replayed request does not carry
+var replayHopHeaders = map[string]bool{"Content-Length": true, "Connection": true, "Accept-Encoding": true}
+
+func cmdReplay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	target := fs.String("target", "http://localhost:8080", "base URL of the server receiving the requests")
+	delay := fs.Duration("delay", 0, "pause between requests")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "Usage: gmx replay [-target url] [-delay 100ms] <recordings dir | recording.json...>\n\n"+
+			"Sends the requests recorded by a dev build run with --record again, in\n"+
+			"their recorded order, and compares the response statuses.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	// Flags may follow the recordings: gmx replay recordings -target ...
+	var paths []string
+	for fs.NArg() > 0 {
+		paths = append(paths, fs.Arg(0))
+		_ = fs.Parse(fs.Args()[1:])
+	}
+
+	if len(paths) == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	exchanges, err := loadRecordings(paths)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	base, err := url.Parse(strings.TrimSuffix(*target, "/"))
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: invalid -target %q, such as http://localhost:8080\n", *target)
+		os.Exit(1)
+	}
+
+	differ, err := replay(base, exchanges, *delay)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("\n%d request(s) replayed, %d with a different status\n", len(exchanges), differ)
+	if differ > 0 {
+		os.Exit(1)
+	}
+}
+
+// loadRecordings reads the recordings of files in the given order, and
+// those of directories by file name, which is their recording order
+func loadRecordings(paths []string) ([]recordedExchange, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(path, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no recordings in %s; run a dev build with --record %s", strings.Join(paths, ", "), paths[0])
+	}
+
+	exchanges := make([]recordedExchange, 0, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var exchange recordedExchange
+		if err := json.Unmarshal(data, &exchange); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if exchange.Method == "" || !strings.HasPrefix(exchange.URL, "/") {
+			return nil, fmt.Errorf("%s: not a gmx recording", file)
+		}
+		exchanges = append(exchanges, exchange)
+	}
+	return exchanges, nil
+}
+
+// replay sends the recorded requests to base, printing each response status
+// next to the recorded one, and returns the number of differing statuses.
+// The client keeps the cookies of the session, and answers the CSRF check
+// with the token of the target, recordings carrying none.
+func replay(base *url.URL, exchanges []recordedExchange, delay time.Duration) (int, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return 0, err
+	}
+	client := &http.Client{
+		Jar:     jar,
+		Timeout: 30 * time.Second,
+		// Redirects are compared as recorded, not followed
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	// The first page sets the CSRF cookie
+	resp, err := client.Get(base.String() + "/")
+	if err != nil {
+		return 0, fmt.Errorf("reaching %s: %w", base, err)
+	}
+	_ = resp.Body.Close()
+
+	differ := 0
+	for i, exchange := range exchanges {
+		if i > 0 && delay > 0 {
+			time.Sleep(delay)
+		}
+		line := fmt.Sprintf("%s %s", exchange.Method, exchange.URL)
+		if exchange.Truncated {
+			fmt.Printf("  skip  %s: body not recorded\n", line)
+			continue
+		}
+
+		req, err := http.NewRequest(exchange.Method, base.String()+exchange.URL, strings.NewReader(exchange.Body))
+		if err != nil {
+			return differ, fmt.Errorf("%s: %w", line, err)
+		}
+		for key, values := range exchange.Header {
+			if !replayHopHeaders[key] {
+				req.Header[key] = values
+			}
+		}
+		for _, cookie := range jar.Cookies(base) {
+			if cookie.Name == "_csrf" {
+				req.Header.Set("X-CSRF-Token", cookie.Value)
+			}
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return differ, fmt.Errorf("%s: %w", line, err)
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		_ = resp.Body.Close()
+
+		if resp.StatusCode == exchange.Status {
+			fmt.Printf("  %d   %s\n", resp.StatusCode, line)
+			continue
+		}
+		differ++
+		fmt.Printf("✗ %d   %s (recorded %d)\n", resp.StatusCode, line, exchange.Status)
+		if first, _, _ := strings.Cut(strings.TrimSpace(string(body)), "\n"); first != "" {
+			fmt.Printf("        %s\n", first)
+		}
+	}
+	return differ, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/generator"
+	"github.com/btouchard/gmx/internal/compiler/resolver"
+	"github.com/btouchard/gmx/internal/compiler/script"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+func cmdReport(args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "Usage: gmx report <input.gmx>\n\nPrints the models, routes, services and script functions of a project.\n")
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	inputFile := fs.Arg(0)
+	resolved, _, err := load(inputFile)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := writeReport(os.Stdout, inputFile, resolved); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// writeReport prints the project summary, one aligned table per section
+func writeReport(out io.Writer, inputFile string, resolved *resolver.ResolvedFile) error {
+	file := resolved.Main
+	gen := generator.New()
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	_, _ = fmt.Fprintf(w, "Project %s\n", inputFile)
+	if len(resolved.Components) > 0 {
+		names := make([]string, 0, len(resolved.Components))
+		for name := range resolved.Components {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		_, _ = fmt.Fprintf(w, "Components: %s\n", strings.Join(names, ", "))
+	}
+
+	_, _ = fmt.Fprintf(w, "\nModels (%d)\n", len(file.Models))
+	for _, model := range file.Models {
+		_, _ = fmt.Fprintf(w, "  %s\t%d fields\n", model.Name, len(model.Fields))
+		for _, field := range model.Fields {
+			_, _ = fmt.Fprintf(w, "    %s\t%s\n", field.Name, strings.TrimSpace(field.TypeString()+" "+annotationList(field.Annotations)))
+		}
+	}
+
+	routes := gen.Routes(resolved)
+	_, _ = fmt.Fprintf(w, "\nRoutes (%d)\n", len(routes))
+	for _, route := range routes {
+		source := route.Source
+		if route.Line > 0 {
+			source = fmt.Sprintf("%s (line %d)", source, route.Line)
+		}
+		_, _ = fmt.Fprintf(w, "  %s\t%s\t%s\n", route.Method, route.Path, source)
+	}
+
+	_, _ = fmt.Fprintf(w, "\nServices (%d)\n", len(file.Services))
+	for _, svc := range file.Services {
+		var env []string
+		for _, field := range svc.Fields {
+			if field.EnvVar != "" {
+				env = append(env, field.EnvVar)
+			}
+		}
+		_, _ = fmt.Fprintf(w, "  %s\t%s\t%s\n", svc.Name, svc.Provider, strings.Join(env, " "))
+	}
+	var required []string
+	for _, v := range gen.DeployInfo(resolved).EnvVars {
+		if v.Required {
+			required = append(required, v.Name)
+		}
+	}
+	if len(required) > 0 {
+		_, _ = fmt.Fprintf(w, "  Required env vars: %s\n", strings.Join(required, ", "))
+	}
+
+	var funcs []*ast.FuncDecl
+	if file.Script != nil {
+		funcs = file.Script.Funcs
+	}
+	_, _ = fmt.Fprintf(w, "\nFunctions (%d)\n", len(funcs))
+	if len(funcs) > 0 {
+		_, _ = fmt.Fprintf(w, "  NAME\tLINE\tSTATEMENTS\tCOMPLEXITY\n")
+	}
+	for _, fn := range funcs {
+		_, _ = fmt.Fprintf(w, "  %s\t%d\t%d\t%d\n", fn.Name, fn.Line, statementCount(fn), script.Complexity(fn))
+	}
+
+	return w.Flush()
+}
+
+// annotationList renders annotations as written: @pk @min(3) @index(name: idx_owner)
+func annotationList(annotations []*ast.Annotation) string {
+	parts := make([]string, 0, len(annotations))
+	for _, ann := range annotations {
+		parts = append(parts, ann.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+// statementCount counts the statements of a function body, nested blocks included
+func statementCount(fn *ast.FuncDecl) int {
+	count := 0
+	ast.Inspect(fn, func(n ast.Node) bool {
+		switch n.(type) {
+		case *ast.LetStmt, *ast.AssignStmt, *ast.ReturnStmt, *ast.IfStmt, *ast.ForStmt, *ast.EnqueueStmt, *ast.ExprStmt:
+			count++
+		}
+		return true
+	})
+	return count
+}
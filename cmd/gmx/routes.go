@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/generator"
+	"os"
+	"text/tabwriter"
+)
+
+func cmdRoutes(args []string) {
+	fs := flag.NewFlagSet("routes", flag.ExitOnError)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "Usage: gmx routes <input.gmx | dir>\n\nPrints the routes the generated server registers.\n")
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	inputFile := fs.Arg(0)
+	resolved, _, err := load(inputFile)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "METHOD\tPATH\tHANDLER\tSOURCE\n")
+	for _, route := range generator.New().Routes(resolved) {
+		source := route.Source
+		// Functions of a directory build come from several files
+		if route.Line > 0 && !isDirBuild(inputFile) {
+			// file:line, clickable in editors and terminals
+			source = fmt.Sprintf("%s:%d (%s)", inputFile, route.Line, route.Source)
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", route.Method, route.Path, route.Handler, source)
+	}
+	if err := w.Flush(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
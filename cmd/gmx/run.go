@@ -3,18 +3,24 @@ package main
 import (
 	"flag"
 	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/generator"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
-	"strings"
 	"syscall"
 )
 
 func cmdRun(args []string) {
 	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	dev := fs.Bool("dev", false, "development build: catch outgoing mail at /__gmx/mail instead of sending it")
+	minify := fs.Bool("minify", false, "strip insignificant whitespace and comments from the embedded template and styles")
+	criticalCSS := fs.Bool("critical-css", false, "inline the component styles used by the initial render and lazy-load the rest")
+	strict := fs.Bool("strict", false, "reject implicit behaviors: unused declarations, unreferenced routes, fallback SQLite, unvalidated saves")
+	update := fs.Bool("update", false, "accept imported files and modules that changed since gmx.lock was written")
 	fs.Usage = func() {
-		_, _ = fmt.Fprintf(os.Stderr, "Usage: gmx run <input.gmx> [-- args...]\n")
+		_, _ = fmt.Fprintf(os.Stderr, "Usage: gmx run [-dev] [-strict] [-update] <input.gmx | dir> [-- args...]\n\nFlags:\n")
+		fs.PrintDefaults()
 	}
 	_ = fs.Parse(args)
 
@@ -42,9 +48,7 @@ func cmdRun(args []string) {
 		os.Exit(1)
 	}
 
-	base := filepath.Base(inputFile)
-	binaryName := strings.TrimSuffix(base, filepath.Ext(base))
-	binaryPath := filepath.Join(tmpDir, binaryName)
+	binaryPath := filepath.Join(tmpDir, appName(inputFile))
 
 	// Cleanup on exit
 	cleanup := func() {
@@ -52,7 +56,7 @@ func cmdRun(args []string) {
 	}
 	defer cleanup()
 
-	if err := buildBinary(inputFile, binaryPath); err != nil {
+	if err := buildBinary(inputFile, binaryPath, generator.Options{Dev: *dev, CriticalCSS: *criticalCSS, Minify: *minify, Strict: *strict}, defaultModule, lockModeFor(*update)); err != nil {
 		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
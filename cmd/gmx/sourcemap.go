@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// goErrorPosition matches the position of a Go compiler error in the
+// generated source: ./main.go:42:9: undefined: x
+var goErrorPosition = regexp.MustCompile(`^(?:\./)?main\.go:(\d+)(?::\d+)?: (.*)$`)
+
+// goFuncDecl matches the declaration line of a top-level function
+var goFuncDecl = regexp.MustCompile(`^func (\w+)\(`)
+
+// scriptFuncs returns the script functions declared in the input file itself,
+// whose // gmx:N comments are lines of that file; directory builds merge
+// the functions of several pages and return none
+func scriptFuncs(inputFile string, file *ast.GMXFile) map[string]bool {
+	funcs := make(map[string]bool)
+	if isDirBuild(inputFile) || file.Script == nil {
+		return funcs
+	}
+	for _, fn := range file.Script.Funcs {
+		funcs[fn.Name] = true
+	}
+	return funcs
+}
+
+// sourceLines maps each line of the generated code, numbered from 1, to the
+// .gmx line of the nearest // gmx:N comment above it in the same function;
+// lines outside the given script functions map to 0
+func sourceLines(code string, funcs map[string]bool) []int {
+	lines := strings.Split(code, "\n")
+	mapped := make([]int, len(lines)+1)
+	current, inFunc := 0, false
+	for i, line := range lines {
+		if m := gmxLineComment.FindStringSubmatch(line); m != nil {
+			current, _ = strconv.Atoi(m[2])
+		} else if m := goFuncDecl.FindStringSubmatch(line); m != nil {
+			inFunc = funcs[m[1]]
+		}
+		if inFunc {
+			mapped[i+1] = current
+		}
+		if line == "}" {
+			current, inFunc = 0, false
+		}
+	}
+	return mapped
+}
+
+// mapGoErrors rewrites the Go compiler errors reported in transpiled script
+// functions to the .gmx line they come from, keeping the generated position
+// in parentheses; other output is left as is
+func mapGoErrors(output, code, inputFile string, funcs map[string]bool) string {
+	if output == "" {
+		return ""
+	}
+	mapped := sourceLines(code, funcs)
+	lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+	for i, line := range lines {
+		m := goErrorPosition.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		goLine, err := strconv.Atoi(m[1])
+		if err != nil || goLine >= len(mapped) || mapped[goLine] == 0 {
+			continue
+		}
+		lines[i] = fmt.Sprintf("%s:%d: %s (main.go:%d)", inputFile, mapped[goLine], m[2], goLine)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
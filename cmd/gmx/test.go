@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/generator"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+func cmdTest(args []string) {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	dev := fs.Bool("dev", false, "development build: catch outgoing mail at /__gmx/mail instead of sending it")
+	strict := fs.Bool("strict", false, "reject implicit behaviors: unused declarations, unreferenced routes, fallback SQLite, unvalidated saves")
+	update := fs.Bool("update", false, "accept imported files and modules that changed since gmx.lock was written")
+	run := fs.String("run", "", "run only the tests matching this regular expression, as go test -run")
+	verbose := fs.Bool("v", false, "print the name and status of each test")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "Usage: gmx test [-dev] [-strict] [-update] [-run regexp] [-v] <input.gmx | dir>\n\n"+
+			"Tests every handler of the app against an in-memory SQLite database: method\n"+
+			"guard, CSRF rejection, missing parameters, access control and a happy path.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	inputFile := fs.Arg(0)
+	opts := generator.Options{Dev: *dev, Strict: *strict}
+	goTest := []string{"test", "-count=1"}
+	if *run != "" {
+		goTest = append(goTest, "-run", *run)
+	}
+	if *verbose {
+		goTest = append(goTest, "-v")
+	}
+
+	err := testApp(inputFile, opts, lockModeFor(*update), goTest)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.ExitCode())
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// testApp runs go test with the given arguments on the generated tests of a
+// .gmx file, inside a temporary module; a failing run returns the
+// *exec.ExitError of go test, whose output was already printed.
+func testApp(inputFile string, opts generator.Options, mode lockMode, goTest []string) error {
+	c, err := compile(inputFile, opts, mode)
+	if err != nil {
+		return err
+	}
+	tests, err := generator.NewWithOptions(opts).GenerateTests(c.resolved)
+	if err != nil {
+		return fmt.Errorf("generating tests: %w", err)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "gmx-test-*")
+	if err != nil {
+		return fmt.Errorf("creating temp dir: %w", err)
+	}
+	defer func(path string) {
+		_ = os.RemoveAll(path)
+	}(tmpDir)
+
+	// The tests are written first for go mod tidy to resolve their imports
+	if err := os.WriteFile(filepath.Join(tmpDir, "main_test.go"), []byte(tests), 0644); err != nil {
+		return fmt.Errorf("writing tests: %w", err)
+	}
+	if err := writeModule(c, inputFile, tmpDir, defaultModule); err != nil {
+		return err
+	}
+
+	// Compiler errors in script functions point to their .gmx line, not the temporary main.go
+	var stderr bytes.Buffer
+	cmd := exec.Command("go", append(goTest, ".")...)
+	cmd.Dir = tmpDir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	_, _ = fmt.Fprint(os.Stderr, mapGoErrors(stderr.String(), c.code, inputFile, scriptFuncs(inputFile, c.file)))
+	if err != nil {
+		return err
+	}
+	return c.lock.write()
+}
@@ -1,5 +1,12 @@
 package ast
 
+import (
+	"sort"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/lang"
+)
+
 // Node is the base interface for all AST nodes
 type Node interface {
 	TokenLiteral() string
@@ -7,36 +14,149 @@ type Node interface {
 
 // GMXFile is the root AST node representing a complete .gmx file
 type GMXFile struct {
-	Imports  []*ImportDecl
-	Models   []*ModelDecl
-	Services []*ServiceDecl
-	Vars     []*VarDecl
-	Script   *ScriptBlock
-	Template *TemplateBlock
-	Style    *StyleBlock
+	Version       lang.Version // Language version from the `gmx 1.0` pragma, lang.Default without one
+	Imports       []*ImportDecl
+	Models        []*ModelDecl
+	Services      []*ServiceDecl
+	Vars          []*VarDecl
+	Settings      []*SettingDecl
+	Wizards       []*WizardDecl
+	Server        *ServerDecl        // HTTP server options, nil to listen on :8080 without timeouts
+	Observability *ObservabilityDecl // Monitoring options, nil without metrics
+	Script        *ScriptBlock
+	Template      *TemplateBlock
+	Style         *StyleBlock
+	Pages         []*Page // Pages of a directory build; Template then joins their templates
 }
 
 func (f *GMXFile) TokenLiteral() string { return "gmx" }
 
+// Page is a .gmx file of a directory build, served at the route derived
+// from its path: tasks/index.gmx → /tasks, about.gmx → /about
+type Page struct {
+	Route    string
+	Path     string // absolute path of the page file
+	Template *TemplateBlock
+	Style    *StyleBlock
+}
+
 // ============ MODEL SECTION ============
 
 // ModelDecl represents a model definition: model Task { ... }
 type ModelDecl struct {
-	Name   string
-	Fields []*FieldDecl
+	Name        string
+	Fields      []*FieldDecl
+	Annotations []*Annotation // Annotations preceding the model keyword: @repository("TaskRepo") model Task { ... }
+	Indexes     []*IndexDecl  // Composite indexes declared in the model body: @@index([ownerId, status])
+	Policy      *PolicyDecl   // Authorization rules of the model, nil without a policy block
+	Line        int           // Source line of the declaration
 }
 
 func (m *ModelDecl) TokenLiteral() string { return "model" }
 
+// FindField returns the field with the given name, or nil
+func (m *ModelDecl) FindField(name string) *FieldDecl {
+	for _, field := range m.Fields {
+		if field.Name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+// FindAnnotation returns the model annotation with the given name, or nil
+func (m *ModelDecl) FindAnnotation(name string) *Annotation {
+	for _, ann := range m.Annotations {
+		if ann.Name == name {
+			return ann
+		}
+	}
+	return nil
+}
+
+// Preloads returns the relation fields the model loads with its records:
+// @preload(user) or @preload([user, tags])
+func (m *ModelDecl) Preloads() []string {
+	ann := m.FindAnnotation("preload")
+	if ann == nil || ann.SimpleArg() == "" {
+		return nil
+	}
+	return strings.Split(ann.SimpleArg(), ", ")
+}
+
 // FieldDecl represents a field: title: string @min(3) @max(255)
 type FieldDecl struct {
 	Name        string
-	Type        string // "uuid", "string", "bool", "int", "float", "datetime", "User", "Post[]"
+	Type        string   // "uuid", "string", "bool", "int", "float", "datetime", "enum", "User", "Post[]"
+	Variants    []string // values of an enum field: enum(pending, active, done)
 	Annotations []*Annotation
+	Line        int // Source line of the declaration
 }
 
 func (f *FieldDecl) TokenLiteral() string { return f.Name }
 
+// TypeString renders the type as declared, with the variants of an enum:
+// enum(pending, active, done)
+func (f *FieldDecl) TypeString() string {
+	if f.Type == "enum" {
+		return "enum(" + strings.Join(f.Variants, ", ") + ")"
+	}
+	return f.Type
+}
+
+// FindAnnotation returns the field annotation with the given name, or nil
+func (f *FieldDecl) FindAnnotation(name string) *Annotation {
+	for _, ann := range f.Annotations {
+		if ann.Name == name {
+			return ann
+		}
+	}
+	return nil
+}
+
+// IndexDecl represents an index over several fields of a model:
+// @@index([ownerId, status]), @@unique([ownerId, slug], name: "idx_owner_slug")
+type IndexDecl struct {
+	Name   string   // "" for the default idx_<table>_<columns>
+	Fields []string // indexed fields, in index order
+	Unique bool     // declared with @@unique
+	Line   int      // Source line of the declaration
+}
+
+func (i *IndexDecl) TokenLiteral() string {
+	if i.Unique {
+		return "@@unique"
+	}
+	return "@@index"
+}
+
+// PolicyDecl represents the authorization rules of a model:
+// policy Task { read: ctx.user != "" delete: role(admin) }
+type PolicyDecl struct {
+	Model string
+	Rules []*PolicyRule
+	Line  int // Source line of the declaration
+}
+
+func (p *PolicyDecl) TokenLiteral() string { return "policy" }
+
+// FindRule returns the rule of the given action, or nil when the action is unrestricted
+func (p *PolicyDecl) FindRule(action string) *PolicyRule {
+	for _, rule := range p.Rules {
+		if rule.Action == action {
+			return rule
+		}
+	}
+	return nil
+}
+
+// PolicyRule is the condition allowing one action: update: task.userId == ctx.user
+type PolicyRule struct {
+	Action string     // "read", "create", "update" or "delete"
+	Cond   Expression // evaluated with the record bound to the lowercased model name
+	Line   int
+}
+
 // ============ SERVICE SECTION ============
 
 // ServiceDecl represents a service declaration
@@ -45,6 +165,7 @@ type ServiceDecl struct {
 	Provider string
 	Fields   []*ServiceField
 	Methods  []*ServiceMethod
+	Line     int // Source line of the declaration
 }
 
 func (s *ServiceDecl) TokenLiteral() string { return "service" }
@@ -59,11 +180,12 @@ type ServiceField struct {
 
 func (s *ServiceField) TokenLiteral() string { return s.Name }
 
-// ServiceMethod is a method signature declared on a service
+// ServiceMethod is a method declared on a service: a signature, optionally with a GMX Script body
 type ServiceMethod struct {
 	Name       string
 	Params     []*Param
 	ReturnType string
+	Body       []Statement // nil for signature-only methods
 }
 
 func (s *ServiceMethod) TokenLiteral() string { return s.Name }
@@ -78,6 +200,29 @@ type Annotation struct {
 
 func (a *Annotation) TokenLiteral() string { return "@" + a.Name }
 
+// String renders the annotation as written, named arguments sorted:
+// @min(3), @index(name: idx_owner)
+func (a *Annotation) String() string {
+	var args []string
+	if arg := a.SimpleArg(); arg != "" {
+		args = append(args, arg)
+	}
+	keys := make([]string, 0, len(a.Args))
+	for key := range a.Args {
+		if key != "_" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, key+": "+a.Args[key])
+	}
+	if len(args) == 0 {
+		return "@" + a.Name
+	}
+	return "@" + a.Name + "(" + strings.Join(args, ", ") + ")"
+}
+
 // SimpleArg returns the unnamed argument value (for @default(x), @min(3), etc.)
 func (a *Annotation) SimpleArg() string {
 	if v, ok := a.Args["_"]; ok {
@@ -86,6 +231,111 @@ func (a *Annotation) SimpleArg() string {
 	return ""
 }
 
+// SettingDecl represents a runtime-tunable value stored in the database:
+// setting supportEmail: string @default("help@example.com")
+type SettingDecl struct {
+	Name        string
+	Type        string // "string", "int", "float", "bool"
+	Annotations []*Annotation
+	Line        int // Source line of the declaration
+}
+
+func (s *SettingDecl) TokenLiteral() string { return "setting" }
+
+// Default returns the @default value of the setting, or "" without one
+func (s *SettingDecl) Default() string {
+	for _, ann := range s.Annotations {
+		if ann.Name == "default" {
+			return ann.SimpleArg()
+		}
+	}
+	return ""
+}
+
+// ServerDecl configures the HTTP server of the application:
+// server { port: @env("PORT") @default(8080), readTimeout: 10s, tls: { cert: @env("TLS_CERT"), key: @env("TLS_KEY") } }
+type ServerDecl struct {
+	Options []*ServerOption
+	TLS     []*ServerOption // cert and key of the tls block, nil without one
+	Line    int             // Source line of the declaration
+}
+
+func (s *ServerDecl) TokenLiteral() string { return "server" }
+
+// FindOption returns the option with the given name, or nil
+func (s *ServerDecl) FindOption(name string) *ServerOption {
+	return findServerOption(s.Options, name)
+}
+
+// FindTLSOption returns the option of the tls block with the given name, or nil
+func (s *ServerDecl) FindTLSOption(name string) *ServerOption {
+	return findServerOption(s.TLS, name)
+}
+
+// ObservabilityDecl configures the monitoring of the application:
+// observability { metrics: true }
+type ObservabilityDecl struct {
+	Options []*ServerOption
+	Line    int // Source line of the declaration
+}
+
+func (o *ObservabilityDecl) TokenLiteral() string { return "observability" }
+
+// FindOption returns the option with the given name, or nil
+func (o *ObservabilityDecl) FindOption(name string) *ServerOption {
+	return findServerOption(o.Options, name)
+}
+
+func findServerOption(options []*ServerOption, name string) *ServerOption {
+	for _, opt := range options {
+		if opt.Name == name {
+			return opt
+		}
+	}
+	return nil
+}
+
+// ServerOption is a server setting, given literally (readTimeout: 10s) or
+// read from an environment variable (port: @env("PORT") @default(8080))
+type ServerOption struct {
+	Name     string
+	Value    string // literal value or default of the variable, strings unquoted
+	EnvVar   string // "" for literal values
+	Required bool   // read from a variable without @default
+	Line     int
+}
+
+// WizardDecl represents a multi-step form whose values are kept on the
+// server until its last step calls the finish function:
+// wizard Onboarding { step profile { name: string } finish completeOnboarding }
+type WizardDecl struct {
+	Name   string
+	Steps  []*WizardStep
+	Finish string // script function called with the values of every step
+	Line   int    // Source line of the declaration
+}
+
+func (w *WizardDecl) TokenLiteral() string { return "wizard" }
+
+// FindField returns the field of any step with the given name, or nil
+func (w *WizardDecl) FindField(name string) *FieldDecl {
+	for _, step := range w.Steps {
+		for _, field := range step.Fields {
+			if field.Name == name {
+				return field
+			}
+		}
+	}
+	return nil
+}
+
+// WizardStep is one form of a wizard, validated before the next one
+type WizardStep struct {
+	Name   string
+	Fields []*FieldDecl
+	Line   int // Source line of the declaration
+}
+
 // ============ SCRIPT SECTION ============
 
 // ImportDecl represents an import declaration with three syntaxes:
@@ -93,11 +343,12 @@ func (a *Annotation) SimpleArg() string {
 // 2. Destructured import: import { sendEmail, MailerConfig } from './services/mailer.gmx'
 // 3. Native Go import: import "github.com/stripe/stripe-go" as Stripe
 type ImportDecl struct {
-	Default  string   // "TaskItem" (import X from '...')
-	Members  []string // ["sendEmail", "MailerConfig"] (import { x, y } from '...')
-	Path     string   // "./components/TaskItem.gmx" or "github.com/stripe/stripe-go"
-	Alias    string   // "Stripe" (import "pkg" as X)
-	IsNative bool     // true for Go package imports (no 'from', has 'as')
+	Default  string            // "TaskItem" (import X from '...')
+	Members  []string          // ["sendEmail", "MailerConfig"] (import { x, y } from '...')
+	Aliases  map[string]string // member → local name (import { Task as TodoTask } from '...')
+	Path     string            // "./components/TaskItem.gmx" or "github.com/stripe/stripe-go"
+	Alias    string            // "Stripe" (import "pkg" as X)
+	IsNative bool              // true for Go package imports (no 'from', has 'as')
 }
 
 func (i *ImportDecl) TokenLiteral() string { return "import" }
@@ -108,6 +359,8 @@ type VarDecl struct {
 	Type    string     // optional, empty = inferred
 	Value   Expression // initial value (required)
 	IsConst bool       // true for const, false for let
+	Cond    Expression // compile-time condition of the enclosing #if block, nil outside
+	Line    int        // Source line of the declaration
 }
 
 func (v *VarDecl) TokenLiteral() string {
@@ -132,19 +385,35 @@ func (s *ScriptBlock) TokenLiteral() string { return "script" }
 
 // FuncDecl represents a function declaration
 type FuncDecl struct {
-	Name       string
-	Params     []*Param
-	ReturnType string // "error", "string", "bool", etc. Empty if void
-	Body       []Statement
-	Line       int // Source line for source maps
+	Name        string
+	Params      []*Param
+	ReturnType  string // "error", "string", "bool", etc. Empty if void
+	Body        []Statement
+	Before      []Statement   // Statements of the before hooks, run ahead of the body: before createTask { ... }
+	After       []Statement   // Statements of the after hooks, run once the body succeeded: after createTask { ... }
+	Annotations []*Annotation // Annotations preceding the func keyword: @captcha(turnstile) func signup(...)
+	RoutePrefix string        // Path prefix of the enclosing route group: "/admin", empty outside groups
+	Cond        Expression    // Compile-time condition of the enclosing #if block, nil outside
+	Line        int           // Source line for source maps
 }
 
 func (f *FuncDecl) TokenLiteral() string { return "func" }
 
+// FindAnnotation returns the function annotation with the given name, or nil
+func (f *FuncDecl) FindAnnotation(name string) *Annotation {
+	for _, ann := range f.Annotations {
+		if ann.Name == name {
+			return ann
+		}
+	}
+	return nil
+}
+
 // Param represents a function parameter
 type Param struct {
 	Name string
 	Type string
+	Line int // Source line of the declaration
 }
 
 // Statement is the interface for all statements
@@ -174,7 +443,7 @@ func (l *LetStmt) statementNode()       {}
 
 // AssignStmt: x = expr, x.field = expr
 type AssignStmt struct {
-	Target Expression // Could be Ident or MemberExpr
+	Target Expression // Could be Ident, MemberExpr or IndexExpr
 	Value  Expression
 	Line   int
 }
@@ -202,6 +471,45 @@ type IfStmt struct {
 func (i *IfStmt) TokenLiteral() string { return "if" }
 func (i *IfStmt) statementNode()       {}
 
+// ForStmt: for item in collection { ... } or for i, item in collection { ... }
+type ForStmt struct {
+	Index    string // index variable, "" without one
+	Value    string // element variable
+	Iterable Expression
+	Body     []Statement
+	Line     int
+}
+
+func (f *ForStmt) TokenLiteral() string { return "for" }
+func (f *ForStmt) statementNode()       {}
+
+// SagaStmt: saga { step { ... } compensate { ... } ... }
+type SagaStmt struct {
+	Steps []*SagaStep
+	Line  int
+}
+
+func (s *SagaStmt) TokenLiteral() string { return "saga" }
+func (s *SagaStmt) statementNode()       {}
+
+// SagaStep is one step of a saga, with the statements undoing it once it
+// succeeded and a later step failed
+type SagaStep struct {
+	Body       []Statement
+	Compensate []Statement // nil when the step has nothing to undo
+	Line       int
+}
+
+// EnqueueStmt: enqueue sendWelcomeEmail(user.id), queuing a run of a @job
+// function with the values of its arguments
+type EnqueueStmt struct {
+	Call *CallExpr
+	Line int
+}
+
+func (e *EnqueueStmt) TokenLiteral() string { return "enqueue" }
+func (e *EnqueueStmt) statementNode()       {}
+
 // ExprStmt: expression used as statement (e.g. function calls)
 type ExprStmt struct {
 	Expr Expression
@@ -240,6 +548,15 @@ type FloatLit struct {
 func (f *FloatLit) TokenLiteral() string { return f.Value }
 func (f *FloatLit) expressionNode()      {}
 
+// DurationLit: 24h, 30m, 500ms
+type DurationLit struct {
+	Value string
+	Line  int
+}
+
+func (d *DurationLit) TokenLiteral() string { return d.Value }
+func (d *DurationLit) expressionNode()      {}
+
 // StringLit: "hello" (including interpolation segments)
 type StringLit struct {
 	Value string       // Raw string value
@@ -302,6 +619,16 @@ type CallExpr struct {
 func (c *CallExpr) TokenLiteral() string { return "call" }
 func (c *CallExpr) expressionNode()      {}
 
+// NamedArg: name: value — a named call argument, as in Task.where(done: false)
+type NamedArg struct {
+	Name  string
+	Value Expression
+	Line  int
+}
+
+func (n *NamedArg) TokenLiteral() string { return n.Name }
+func (n *NamedArg) expressionNode()      {}
+
 // MemberExpr: obj.field (property access)
 type MemberExpr struct {
 	Object   Expression
@@ -312,6 +639,16 @@ type MemberExpr struct {
 func (m *MemberExpr) TokenLiteral() string { return "." }
 func (m *MemberExpr) expressionNode()      {}
 
+// IndexExpr: obj[key] (json field key access)
+type IndexExpr struct {
+	Object Expression
+	Index  Expression
+	Line   int
+}
+
+func (i *IndexExpr) TokenLiteral() string { return "[" }
+func (i *IndexExpr) expressionNode()      {}
+
 // TryExpr: try expr (unwrap or return error)
 type TryExpr struct {
 	Expr Expression
@@ -321,6 +658,19 @@ type TryExpr struct {
 func (t *TryExpr) TokenLiteral() string { return "try" }
 func (t *TryExpr) expressionNode()      {}
 
+// RememberExpr: Cache.remember("tasks", 5m) { ... }, the records a block
+// returns, cached by a redis service under a key for a time to live
+type RememberExpr struct {
+	Cache string // name of the cache service
+	Key   Expression
+	TTL   Expression
+	Body  []Statement
+	Line  int
+}
+
+func (r *RememberExpr) TokenLiteral() string { return "remember" }
+func (r *RememberExpr) expressionNode()      {}
+
 // RenderExpr: render(model) or render(model1, model2)
 type RenderExpr struct {
 	Args []Expression
@@ -362,7 +712,9 @@ func (s *StructLit) expressionNode()      {}
 
 // TemplateBlock contains the raw HTML/template content
 type TemplateBlock struct {
-	Source string // Raw HTML with Go template syntax
+	Source      string // Raw HTML with Go template syntax
+	StartLine   int    // Line offset in the .gmx file: line 1 of Source is StartLine+1
+	StartColumn int    // Column offset of the first line of Source in the .gmx file
 }
 
 func (t *TemplateBlock) TokenLiteral() string { return "template" }
@@ -371,8 +723,9 @@ func (t *TemplateBlock) TokenLiteral() string { return "template" }
 
 // StyleBlock contains the raw CSS
 type StyleBlock struct {
-	Source string // Raw CSS content
-	Scoped bool   // Whether <style scoped> was used
+	Source    string // Raw CSS content
+	Scoped    bool   // Whether <style scoped> was used
+	StartLine int    // Line offset in the .gmx file: line 1 of Source is StartLine+1
 }
 
 func (s *StyleBlock) TokenLiteral() string { return "style" }
@@ -1,6 +1,9 @@
 package ast
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestTokenLiterals(t *testing.T) {
 	tests := []struct {
@@ -10,6 +13,8 @@ func TestTokenLiterals(t *testing.T) {
 	}{
 		{"GMXFile", &GMXFile{}, "gmx"},
 		{"ModelDecl", &ModelDecl{Name: "Task"}, "model"},
+		{"PolicyDecl", &PolicyDecl{Model: "Task"}, "policy"},
+		{"SettingDecl", &SettingDecl{Name: "supportEmail"}, "setting"},
 		{"FieldDecl", &FieldDecl{Name: "title"}, "title"},
 		{"ServiceDecl", &ServiceDecl{Name: "Database"}, "service"},
 		{"ServiceField", &ServiceField{Name: "url"}, "url"},
@@ -22,18 +27,22 @@ func TestTokenLiterals(t *testing.T) {
 		{"AssignStmt", &AssignStmt{}, "="},
 		{"ReturnStmt", &ReturnStmt{}, "return"},
 		{"IfStmt", &IfStmt{}, "if"},
+		{"ForStmt", &ForStmt{}, "for"},
 		{"ExprStmt", &ExprStmt{Expr: &Ident{Name: "x"}}, "x"},
 		{"Ident", &Ident{Name: "task"}, "task"},
 		{"IntLit", &IntLit{Value: "42"}, "42"},
 		{"FloatLit", &FloatLit{Value: "3.14"}, "3.14"},
+		{"DurationLit", &DurationLit{Value: "24h"}, "24h"},
 		{"StringLit", &StringLit{Value: "hello"}, "hello"},
 		{"BoolLit true", &BoolLit{Value: true}, "true"},
 		{"BoolLit false", &BoolLit{Value: false}, "false"},
 		{"UnaryExpr", &UnaryExpr{Op: "!"}, "!"},
 		{"BinaryExpr", &BinaryExpr{Op: "+"}, "+"},
 		{"CallExpr", &CallExpr{}, "call"},
+		{"NamedArg", &NamedArg{Name: "done"}, "done"},
 		{"MemberExpr", &MemberExpr{Property: "name"}, "."},
 		{"TryExpr", &TryExpr{}, "try"},
+		{"RememberExpr", &RememberExpr{Cache: "Cache"}, "remember"},
 		{"RenderExpr", &RenderExpr{}, "render"},
 		{"ErrorExpr", &ErrorExpr{}, "error"},
 		{"CtxExpr", &CtxExpr{Field: "tenant"}, "ctx"},
@@ -90,12 +99,51 @@ func TestAnnotationSimpleArg(t *testing.T) {
 	}
 }
 
+func TestAnnotationString(t *testing.T) {
+	tests := []struct {
+		ann      *Annotation
+		expected string
+	}{
+		{&Annotation{Name: "pk"}, "@pk"},
+		{&Annotation{Name: "min", Args: map[string]string{"_": "3"}}, "@min(3)"},
+		{&Annotation{Name: "relation", Args: map[string]string{"references": "id", "onDelete": "cascade"}}, "@relation(onDelete: cascade, references: id)"},
+		{&Annotation{Name: "timeout", Args: map[string]string{"_": "5s", "message": "slow"}}, "@timeout(5s, message: slow)"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.ann.String(); got != tt.expected {
+			t.Errorf("String() = %q, want %q", got, tt.expected)
+		}
+	}
+}
+
+func TestModelPreloads(t *testing.T) {
+	tests := []struct {
+		name     string
+		model    *ModelDecl
+		expected []string
+	}{
+		{"none", &ModelDecl{Name: "Task"}, nil},
+		{"one", &ModelDecl{Annotations: []*Annotation{{Name: "preload", Args: map[string]string{"_": "user"}}}}, []string{"user"}},
+		{"list", &ModelDecl{Annotations: []*Annotation{{Name: "preload", Args: map[string]string{"_": "user, tags"}}}}, []string{"user", "tags"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.model.Preloads(); strings.Join(got, "|") != strings.Join(tt.expected, "|") || len(got) != len(tt.expected) {
+				t.Errorf("Preloads() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
 func TestStatementNodes(t *testing.T) {
 	// Just verify that statement interface is implemented
 	var _ Statement = (*LetStmt)(nil)
 	var _ Statement = (*AssignStmt)(nil)
 	var _ Statement = (*ReturnStmt)(nil)
 	var _ Statement = (*IfStmt)(nil)
+	var _ Statement = (*ForStmt)(nil)
 	var _ Statement = (*ExprStmt)(nil)
 }
 
@@ -104,15 +152,64 @@ func TestExpressionNodes(t *testing.T) {
 	var _ Expression = (*Ident)(nil)
 	var _ Expression = (*IntLit)(nil)
 	var _ Expression = (*FloatLit)(nil)
+	var _ Expression = (*DurationLit)(nil)
 	var _ Expression = (*StringLit)(nil)
 	var _ Expression = (*BoolLit)(nil)
 	var _ Expression = (*UnaryExpr)(nil)
 	var _ Expression = (*BinaryExpr)(nil)
 	var _ Expression = (*CallExpr)(nil)
+	var _ Expression = (*NamedArg)(nil)
 	var _ Expression = (*MemberExpr)(nil)
 	var _ Expression = (*TryExpr)(nil)
+	var _ Expression = (*RememberExpr)(nil)
 	var _ Expression = (*RenderExpr)(nil)
 	var _ Expression = (*ErrorExpr)(nil)
 	var _ Expression = (*CtxExpr)(nil)
 	var _ Expression = (*StructLit)(nil)
 }
+
+func TestInspect(t *testing.T) {
+	fn := &FuncDecl{
+		Name: "toggle",
+		Body: []Statement{
+			&LetStmt{Name: "task", Value: &TryExpr{Expr: &CallExpr{
+				Function: &MemberExpr{Object: &Ident{Name: "Task"}, Property: "find"},
+				Args:     []Expression{&Ident{Name: "id"}},
+			}}},
+			&IfStmt{
+				Condition:   &BinaryExpr{Left: &Ident{Name: "a"}, Op: "&&", Right: &Ident{Name: "b"}},
+				Consequence: []Statement{(*LetStmt)(nil)},
+				Alternative: []Statement{&ReturnStmt{Value: &ErrorExpr{Message: &StringLit{Value: "x"}}}},
+			},
+			&ReturnStmt{},
+		},
+	}
+
+	var idents []string
+	count := 0
+	Inspect(fn, func(n Node) bool {
+		count++
+		if id, ok := n.(*Ident); ok {
+			idents = append(idents, id.Name)
+		}
+		return true
+	})
+
+	if got := strings.Join(idents, ","); got != "Task,id,a,b" {
+		t.Errorf("visited idents %q, want Task,id,a,b", got)
+	}
+	if count != 15 {
+		t.Errorf("visited %d nodes, want 15", count)
+	}
+
+	// Returning false skips the children
+	visited := 0
+	Inspect(fn, func(n Node) bool {
+		visited++
+		_, isFunc := n.(*FuncDecl)
+		return isFunc
+	})
+	if visited != 4 {
+		t.Errorf("visited %d nodes without descending, want 4", visited)
+	}
+}
@@ -0,0 +1,121 @@
+package ast
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// Export returns a parsed file as plain maps and slices, ready to encode as
+// JSON for external tools. Declarations and script nodes are objects whose
+// "node" key names their type (ModelDecl, CallExpr...), with their fields in
+// lowerCamelCase and empty fields left out; "line" is the line of the .gmx
+// file. The script, template and style sections are described by their
+// position rather than their source.
+func Export(file *GMXFile) map[string]any {
+	funcs := []*FuncDecl{}
+	if file.Script != nil {
+		funcs = file.Script.Funcs
+	}
+	out := map[string]any{
+		"version":  file.Version.String(),
+		"imports":  exportList(file.Imports),
+		"models":   exportList(file.Models),
+		"services": exportList(file.Services),
+		"vars":     exportList(file.Vars),
+		"settings": exportList(file.Settings),
+		"wizards":  exportList(file.Wizards),
+		"funcs":    exportList(funcs),
+	}
+	if file.Server != nil {
+		out["server"] = exportValue(reflect.ValueOf(file.Server))
+	}
+	if file.Observability != nil {
+		out["observability"] = exportValue(reflect.ValueOf(file.Observability))
+	}
+	if file.Script != nil {
+		out["script"] = exportSection(file.Script.StartLine, 0, file.Script.Source)
+	}
+	if file.Template != nil {
+		out["template"] = exportSection(file.Template.StartLine, file.Template.StartColumn, file.Template.Source)
+	}
+	if file.Style != nil {
+		style := exportSection(file.Style.StartLine, 0, file.Style.Source)
+		style["scoped"] = file.Style.Scoped
+		out["style"] = style
+	}
+	return out
+}
+
+// exportSection describes a section by the lines and column its content
+// spans in the .gmx file
+func exportSection(startLine, startColumn int, source string) map[string]any {
+	section := map[string]any{
+		"startLine": startLine + 1,
+		"endLine":   startLine + strings.Count(source, "\n") + 1,
+	}
+	if startColumn > 0 {
+		section["startColumn"] = startColumn + 1
+	}
+	return section
+}
+
+// exportList exports the nodes of a slice, empty rather than nil
+func exportList[T any](nodes []T) []any {
+	list := make([]any, 0, len(nodes))
+	for _, node := range nodes {
+		list = append(list, exportValue(reflect.ValueOf(node)))
+	}
+	return list
+}
+
+// exportValue exports a node, or a value of one of its fields
+func exportValue(v reflect.Value) any {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		// Statements that failed to parse are kept as typed nil pointers
+		if v.IsNil() {
+			return nil
+		}
+		return exportValue(v.Elem())
+	case reflect.Struct:
+		// Values of other packages, such as language versions, print themselves
+		if s, ok := v.Interface().(fmt.Stringer); ok && v.Type().PkgPath() != reflect.TypeOf(GMXFile{}).PkgPath() {
+			return s.String()
+		}
+		obj := map[string]any{"node": v.Type().Name()}
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() || v.Field(i).IsZero() {
+				continue
+			}
+			obj[exportKey(field.Name)] = exportValue(v.Field(i))
+		}
+		return obj
+	case reflect.Slice:
+		list := make([]any, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			list = append(list, exportValue(v.Index(i)))
+		}
+		return list
+	case reflect.Map:
+		obj := make(map[string]any, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			obj[fmt.Sprint(iter.Key().Interface())] = exportValue(iter.Value())
+		}
+		return obj
+	default:
+		return v.Interface()
+	}
+}
+
+// exportKey returns the lowerCamelCase key of a field: Line → line,
+// ReturnType → returnType, TLS → tls
+func exportKey(name string) string {
+	if strings.IndexFunc(name, unicode.IsLower) == -1 {
+		return strings.ToLower(name)
+	}
+	return strings.ToLower(name[:1]) + name[1:]
+}
@@ -0,0 +1,97 @@
+package ast
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/lang"
+)
+
+func TestExport(t *testing.T) {
+	file := &GMXFile{
+		Version: lang.Version{Major: 1, Minor: 1},
+		Models: []*ModelDecl{{
+			Name:   "Task",
+			Fields: []*FieldDecl{{Name: "title", Type: "string", Line: 4}},
+			Line:   3,
+		}},
+		Services:      []*ServiceDecl{{Name: "Mailer", Provider: "smtp", Line: 6}},
+		Server:        &ServerDecl{Options: []*ServerOption{{Name: "port", Value: "8080", Line: 10}}, Line: 9},
+		Observability: &ObservabilityDecl{Options: []*ServerOption{{Name: "metrics", Value: "true", Line: 16}}, Line: 15},
+		Script: &ScriptBlock{
+			Source: "model Task {\n  title: string\n}",
+			Funcs: []*FuncDecl{{
+				Name:       "toggle",
+				ReturnType: "error",
+				Body: []Statement{
+					&ReturnStmt{Value: &Ident{Name: "nil", Line: 13}, Line: 13},
+					(*IfStmt)(nil),
+				},
+				Line: 12,
+			}},
+			StartLine: 2,
+		},
+		Template: &TemplateBlock{Source: "<p>\n</p>", StartLine: 20, StartColumn: 10},
+		Style:    &StyleBlock{Source: "p {}", Scoped: true, StartLine: 24},
+	}
+
+	data, err := json.Marshal(Export(file))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		key  string
+		want string
+	}{
+		{"version", "version", `"1.1"`},
+		{"models", "models", `[{"fields":[{"line":4,"name":"title","node":"FieldDecl","type":"string"}],"line":3,"name":"Task","node":"ModelDecl"}]`},
+		{"services", "services", `[{"line":6,"name":"Mailer","node":"ServiceDecl","provider":"smtp"}]`},
+		{"server", "server", `{"line":9,"node":"ServerDecl","options":[{"line":10,"name":"port","node":"ServerOption","value":"8080"}]}`},
+		{"observability", "observability", `{"line":15,"node":"ObservabilityDecl","options":[{"line":16,"name":"metrics","node":"ServerOption","value":"true"}]}`},
+		// Statements that failed to parse are null
+		{"funcs", "funcs", `[{"body":[{"line":13,"node":"ReturnStmt","value":{"line":13,"name":"nil","node":"Ident"}},null],"line":12,"name":"toggle","node":"FuncDecl","returnType":"error"}]`},
+		{"empty lists", "settings", `[]`},
+		{"script", "script", `{"endLine":5,"startLine":3}`},
+		{"template", "template", `{"endLine":22,"startColumn":11,"startLine":21}`},
+		{"style", "style", `{"endLine":25,"scoped":true,"startLine":25}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var want any
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatalf("bad expectation: %v", err)
+			}
+			if !reflect.DeepEqual(got[tt.key], want) {
+				gotJSON, _ := json.Marshal(got[tt.key])
+				t.Errorf("%s = %s, want %s", tt.key, gotJSON, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportKey(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Line", "line"},
+		{"ReturnType", "returnType"},
+		{"TLS", "tls"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exportKey(tt.name); got != tt.want {
+				t.Errorf("exportKey(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
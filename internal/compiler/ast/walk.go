@@ -0,0 +1,111 @@
+package ast
+
+import (
+	"reflect"
+	"sort"
+)
+
+// Inspect traverses the statements and expressions of a function body in
+// depth-first order: it calls f(node) and, if f returns true, visits the
+// children of node. Nil nodes, including statements the parser failed to
+// build, are skipped.
+func Inspect(node Node, f func(Node) bool) {
+	if isNil(node) || !f(node) {
+		return
+	}
+
+	switch n := node.(type) {
+	case *FuncDecl:
+		inspectList(n.Before, f)
+		inspectList(n.Body, f)
+		inspectList(n.After, f)
+	case *PolicyDecl:
+		for _, rule := range n.Rules {
+			Inspect(rule.Cond, f)
+		}
+	case *VarDecl:
+		Inspect(n.Value, f)
+	case *LetStmt:
+		Inspect(n.Value, f)
+	case *AssignStmt:
+		Inspect(n.Target, f)
+		Inspect(n.Value, f)
+	case *ReturnStmt:
+		Inspect(n.Value, f)
+	case *IfStmt:
+		Inspect(n.Condition, f)
+		inspectList(n.Consequence, f)
+		inspectList(n.Alternative, f)
+	case *ForStmt:
+		Inspect(n.Iterable, f)
+		inspectList(n.Body, f)
+	case *SagaStmt:
+		for _, step := range n.Steps {
+			inspectList(step.Body, f)
+			inspectList(step.Compensate, f)
+		}
+	case *EnqueueStmt:
+		Inspect(n.Call, f)
+	case *ExprStmt:
+		Inspect(n.Expr, f)
+	case *StringLit:
+		for _, part := range n.Parts {
+			if part.IsExpr {
+				Inspect(part.Expr, f)
+			}
+		}
+	case *UnaryExpr:
+		Inspect(n.Operand, f)
+	case *BinaryExpr:
+		Inspect(n.Left, f)
+		Inspect(n.Right, f)
+	case *CallExpr:
+		Inspect(n.Function, f)
+		for _, arg := range n.Args {
+			Inspect(arg, f)
+		}
+	case *NamedArg:
+		Inspect(n.Value, f)
+	case *MemberExpr:
+		Inspect(n.Object, f)
+	case *IndexExpr:
+		Inspect(n.Object, f)
+		Inspect(n.Index, f)
+	case *TryExpr:
+		Inspect(n.Expr, f)
+	case *RememberExpr:
+		Inspect(n.Key, f)
+		Inspect(n.TTL, f)
+		inspectList(n.Body, f)
+	case *RenderExpr:
+		for _, arg := range n.Args {
+			Inspect(arg, f)
+		}
+	case *ErrorExpr:
+		Inspect(n.Message, f)
+	case *StructLit:
+		names := make([]string, 0, len(n.Fields))
+		for name := range n.Fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			Inspect(n.Fields[name], f)
+		}
+	}
+}
+
+func inspectList(stmts []Statement, f func(Node) bool) {
+	for _, stmt := range stmts {
+		Inspect(stmt, f)
+	}
+}
+
+// isNil reports whether a node is nil or wraps a nil pointer
+func isNil(node Node) bool {
+	if node == nil {
+		return true
+	}
+	v := reflect.ValueOf(node)
+	return v.Kind() == reflect.Pointer && v.IsNil()
+}
@@ -1,6 +1,9 @@
 package errors
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // Position represents a location in source code
 type Position struct {
@@ -9,7 +12,14 @@ type Position struct {
 	Column int
 }
 
+// String renders file:line:column; the column is omitted when unknown (0)
 func (p Position) String() string {
+	if p.Column == 0 {
+		if p.File != "" {
+			return fmt.Sprintf("%s:%d", p.File, p.Line)
+		}
+		return fmt.Sprintf("%d", p.Line)
+	}
 	if p.File != "" {
 		return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
 	}
@@ -44,6 +54,11 @@ func (el *ErrorList) HasErrors() bool {
 	return len(el.Errors) > 0
 }
 
+// Error makes a non-empty list usable as an error, one diagnostic per line
+func (el *ErrorList) Error() string {
+	return strings.TrimSuffix(el.String(), "\n")
+}
+
 func (el *ErrorList) String() string {
 	s := ""
 	for _, e := range el.Errors {
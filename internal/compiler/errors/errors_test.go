@@ -26,6 +26,11 @@ func TestPositionString(t *testing.T) {
 			Position{Line: 1, Column: 1},
 			"1:1",
 		},
+		{
+			"unknown column",
+			Position{File: "test.gmx", Line: 10},
+			"test.gmx:10",
+		},
 	}
 
 	for _, tt := range tests {
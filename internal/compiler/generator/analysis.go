@@ -4,7 +4,7 @@ import (
 	"github.com/btouchard/gmx/internal/compiler/ast"
 )
 
-// hasAnnotationMatch scans all fields of all models and returns true
+// hasAnnotationMatch scans all fields of all models and wizard steps and returns true
 // if at least one annotation satisfies the predicate.
 func (g *Generator) hasAnnotationMatch(file *ast.GMXFile, predicate func(*ast.Annotation) bool) bool {
 	for _, model := range file.Models {
@@ -16,6 +16,17 @@ func (g *Generator) hasAnnotationMatch(file *ast.GMXFile, predicate func(*ast.An
 			}
 		}
 	}
+	for _, wizard := range file.Wizards {
+		for _, step := range wizard.Steps {
+			for _, field := range step.Fields {
+				for _, ann := range field.Annotations {
+					if predicate(ann) {
+						return true
+					}
+				}
+			}
+		}
+	}
 	return false
 }
 
@@ -36,7 +47,7 @@ func (g *Generator) hasFieldMatch(file *ast.GMXFile, predicate func(*ast.FieldDe
 func (g *Generator) hasValidation(model *ast.ModelDecl) bool {
 	for _, field := range model.Fields {
 		for _, ann := range field.Annotations {
-			if ann.Name == "min" || ann.Name == "max" || ann.Name == "email" {
+			if ann.Name == "min" || ann.Name == "max" || ann.Name == "email" || ann.Name == "maxSize" {
 				return true
 			}
 		}
@@ -44,8 +55,14 @@ func (g *Generator) hasValidation(model *ast.ModelDecl) bool {
 	return false
 }
 
-// needsStrconv checks if script functions have int or bool parameters
+// needsStrconv checks if script functions have int or bool parameters, or
+// if honeypot timestamps, backup retention, load-shedding limits, signed
+// link expiries or settings must be formatted and parsed
 func (g *Generator) needsStrconv(file *ast.GMXFile) bool {
+	if g.hasFuncAnnotation(file, "honeypot") || g.findBackupService(file.Services) != nil || g.findLoadShedService(file.Services) != nil ||
+		g.hasFuncAnnotation(file, "signed") || len(file.Settings) > 0 || g.hasActivityFeed(file) || g.hasWizards(file) {
+		return true
+	}
 	if file.Script == nil || file.Script.Funcs == nil {
 		return false
 	}
@@ -103,27 +120,6 @@ func (g *Generator) scriptFuncNames(file *ast.GMXFile) map[string]bool {
 	return names
 }
 
-// extractModelNames extracts model names from the model list
-func (g *Generator) extractModelNames(models []*ast.ModelDecl) []string {
-	names := make([]string, len(models))
-	for i, model := range models {
-		names[i] = model.Name
-	}
-	return names
-}
-
-// hasServicesWithEnv checks if any service has fields with @env annotations
-func (g *Generator) hasServicesWithEnv(file *ast.GMXFile) bool {
-	for _, svc := range file.Services {
-		for _, field := range svc.Fields {
-			if field.EnvVar != "" {
-				return true
-			}
-		}
-	}
-	return false
-}
-
 // findDatabaseService returns the Database service declaration if one exists
 func (g *Generator) findDatabaseService(services []*ast.ServiceDecl) *ast.ServiceDecl {
 	for _, svc := range services {
@@ -144,3 +140,29 @@ func (g *Generator) hasServiceWithProvider(file *ast.GMXFile, provider string) b
 	}
 	return false
 }
+
+// hasPasswordField checks if any model declares a password field
+func (g *Generator) hasPasswordField(file *ast.GMXFile) bool {
+	return g.hasFieldMatch(file, func(field *ast.FieldDecl) bool {
+		return field.Type == "password"
+	})
+}
+
+// funcsWithAnnotation returns the script functions carrying the named annotation
+func (g *Generator) funcsWithAnnotation(file *ast.GMXFile, name string) []*ast.FuncDecl {
+	var funcs []*ast.FuncDecl
+	if file.Script == nil {
+		return funcs
+	}
+	for _, fn := range file.Script.Funcs {
+		if fn.FindAnnotation(name) != nil {
+			funcs = append(funcs, fn)
+		}
+	}
+	return funcs
+}
+
+// hasFuncAnnotation checks if any script function carries the named annotation
+func (g *Generator) hasFuncAnnotation(file *ast.GMXFile, name string) bool {
+	return len(g.funcsWithAnnotation(file, name)) > 0
+}
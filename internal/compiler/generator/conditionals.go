@@ -0,0 +1,157 @@
+package generator
+
+import (
+	"fmt"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// selectCompileTime returns the file restricted to the script declarations
+// whose #if condition holds for this build; files without #if blocks are
+// returned as is
+func (g *Generator) selectCompileTime(file *ast.GMXFile) (*ast.GMXFile, error) {
+	conditional := false
+	for _, v := range file.Vars {
+		conditional = conditional || v.Cond != nil
+	}
+	if file.Script != nil {
+		for _, v := range file.Script.Vars {
+			conditional = conditional || v.Cond != nil
+		}
+		for _, fn := range file.Script.Funcs {
+			conditional = conditional || fn.Cond != nil
+		}
+	}
+	if !conditional {
+		return file, nil
+	}
+
+	selected := *file
+	vars, err := g.selectVars(file, file.Vars)
+	if err != nil {
+		return nil, err
+	}
+	selected.Vars = vars
+
+	if file.Script != nil {
+		script := *file.Script
+		if script.Vars, err = g.selectVars(file, file.Script.Vars); err != nil {
+			return nil, err
+		}
+		script.Funcs = nil
+		declared := make(map[string]int)
+		for _, fn := range file.Script.Funcs {
+			keep, err := g.holds(file, fn.Cond)
+			if err != nil {
+				return nil, fmt.Errorf("func %s (line %d): %w", fn.Name, fn.Line, err)
+			}
+			if !keep {
+				continue
+			}
+			if line, dup := declared[fn.Name]; dup {
+				return nil, fmt.Errorf("func %s is declared at lines %d and %d by #if branches that both hold for this build", fn.Name, line, fn.Line)
+			}
+			declared[fn.Name] = fn.Line
+			script.Funcs = append(script.Funcs, fn)
+		}
+		selected.Script = &script
+	}
+
+	return &selected, nil
+}
+
+// selectVars keeps the variables whose #if condition holds
+func (g *Generator) selectVars(file *ast.GMXFile, vars []*ast.VarDecl) ([]*ast.VarDecl, error) {
+	var kept []*ast.VarDecl
+	for _, v := range vars {
+		keep, err := g.holds(file, v.Cond)
+		if err != nil {
+			return nil, fmt.Errorf("variable %s (line %d): %w", v.Name, v.Line, err)
+		}
+		if keep {
+			kept = append(kept, v)
+		}
+	}
+	return kept, nil
+}
+
+// holds evaluates a #if condition: comparisons of provider(Service) or env
+// with a string, combined with &&, || and !
+func (g *Generator) holds(file *ast.GMXFile, cond ast.Expression) (bool, error) {
+	switch e := cond.(type) {
+	case nil:
+		return true, nil
+	case *ast.BoolLit:
+		return e.Value, nil
+	case *ast.UnaryExpr:
+		if e.Op == "!" {
+			v, err := g.holds(file, e.Operand)
+			return !v, err
+		}
+	case *ast.BinaryExpr:
+		switch e.Op {
+		case "&&", "||":
+			left, err := g.holds(file, e.Left)
+			if err != nil {
+				return false, err
+			}
+			right, err := g.holds(file, e.Right)
+			if err != nil {
+				return false, err
+			}
+			if e.Op == "&&" {
+				return left && right, nil
+			}
+			return left || right, nil
+		case "==", "!=":
+			left, err := g.condValue(file, e.Left)
+			if err != nil {
+				return false, err
+			}
+			right, err := g.condValue(file, e.Right)
+			if err != nil {
+				return false, err
+			}
+			return (left == right) == (e.Op == "=="), nil
+		}
+	}
+	return false, fmt.Errorf("unsupported #if condition: expected provider(Service) or env compared to a string")
+}
+
+// condValue returns the compile-time value of an operand of a #if comparison
+func (g *Generator) condValue(file *ast.GMXFile, expr ast.Expression) (string, error) {
+	switch e := expr.(type) {
+	case *ast.StringLit:
+		if e.Parts != nil {
+			return "", fmt.Errorf("#if conditions cannot interpolate strings")
+		}
+		return e.Value, nil
+	case *ast.Ident:
+		if e.Name == "env" {
+			if g.opts.Dev {
+				return "dev", nil
+			}
+			return "prod", nil
+		}
+	case *ast.CallExpr:
+		fn, ok := e.Function.(*ast.Ident)
+		if !ok || fn.Name != "provider" || len(e.Args) != 1 {
+			break
+		}
+		name, ok := e.Args[0].(*ast.Ident)
+		if !ok {
+			break
+		}
+		for _, svc := range file.Services {
+			if svc.Name == name.Name {
+				return svc.Provider, nil
+			}
+		}
+		// Apps without a Database service run on the fallback SQLite database
+		if name.Name == "Database" && g.findDatabaseService(file.Services) == nil {
+			return "sqlite", nil
+		}
+		return "", fmt.Errorf("provider(%s): unknown service %s", name.Name, name.Name)
+	}
+	return "", fmt.Errorf("unsupported #if condition: expected provider(Service) or env compared to a string")
+}
@@ -0,0 +1,90 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+)
+
+const conditionalScript = `#if provider(Database) == "postgres" {
+  func searchTasks(q: string) error {
+    return nil
+  }
+} else {
+  func searchTasks(q: string) error {
+    let found = q
+    return nil
+  }
+}
+#if env == "dev" && provider(Mailer) != "smtp" {
+  let DEBUG = true
+}
+`
+
+func TestSelectCompileTime(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		dev      bool
+		wantLine int // line of the kept searchTasks variant
+		wantVars int
+	}{
+		{"postgres", "postgres", false, 2, 0},
+		{"sqlite", "sqlite", false, 6, 0},
+		{"dev", "sqlite", true, 6, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			services := "service Database {\n  provider: \"" + tt.provider + "\"\n  url: string @env(\"DATABASE_URL\")\n}\nservice Mailer {\n  provider: \"http\"\n}\n"
+			file := strictFile(t, services+conditionalScript, "")
+			offset := strings.Count(services, "\n")
+
+			selected, err := NewWithOptions(Options{Dev: tt.dev}).selectCompileTime(file)
+			if err != nil {
+				t.Fatalf("selectCompileTime: %v", err)
+			}
+			if len(selected.Script.Funcs) != 1 || selected.Script.Funcs[0].Line != tt.wantLine+offset {
+				t.Errorf("expected the searchTasks variant of line %d, got %+v", tt.wantLine+offset, selected.Script.Funcs)
+			}
+			if len(selected.Script.Vars) != tt.wantVars {
+				t.Errorf("expected %d vars, got %d", tt.wantVars, len(selected.Script.Vars))
+			}
+			if len(file.Script.Funcs) != 2 {
+				t.Error("selection must not modify the parsed file")
+			}
+		})
+	}
+}
+
+func TestSelectCompileTimeErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{
+			"unknown service",
+			"#if provider(Cache) == \"redis\" {\n  let X = 1\n}",
+			"variable X (line 2): provider(Cache): unknown service Cache",
+		},
+		{
+			"unsupported condition",
+			"#if len(\"a\") > 0 {\n  func a() error {\n    return nil\n  }\n}",
+			"func a (line 2): unsupported #if condition",
+		},
+		{
+			"overlapping branches",
+			"#if env == \"prod\" {\n  func a() error {\n    return nil\n  }\n}\n#if provider(Database) == \"sqlite\" {\n  func a() error {\n    return nil\n  }\n}",
+			"func a is declared at lines 2 and 7 by #if branches that both hold for this build",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New().Generate(strictFile(t, tt.script, ""))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package generator
+
+import (
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/resolver"
+	"sort"
+)
+
+// ServerPort is the port the generated server listens on without a server block
+const ServerPort = 8080
+
+// FallbackSQLitePath is the database file opened, relative to the working
+// directory, when no Database service is declared
+const FallbackSQLitePath = "gmx.db"
+
+// EnvVar is an environment variable read by the generated server
+type EnvVar struct {
+	Name     string
+	Service  string // declaring service, "server" for the server block, "" for built-in variables
+	Required bool   // the server refuses to start without it
+	Default  string // value used when unset, for optional variables
+}
+
+// DeployInfo describes what the generated server needs at runtime, for
+// deployment configuration
+type DeployInfo struct {
+	Port int
+	// EnvVars are sorted by name
+	EnvVars []EnvVar
+	// SQLitePath is the fallback database file, "" when a Database service is declared
+	SQLitePath string
+	// AssetPaths are the long-cached static paths served by the binary
+	AssetPaths []string
+}
+
+// DeployInfo derives the runtime needs of the server generated for a resolved file
+func (g *Generator) DeployInfo(resolved *resolver.ResolvedFile) DeployInfo {
+	file := resolved.Main
+	info := DeployInfo{Port: serverPort(file)}
+
+	for _, svc := range file.Services {
+		for _, field := range svc.Fields {
+			if field.EnvVar == "" {
+				continue
+			}
+			v := EnvVar{Name: field.EnvVar, Service: svc.Name, Required: true}
+			if def := serviceFieldDefault(field); def != nil {
+				v.Required, v.Default = false, *def
+			} else if g.opts.Dev && svc.Provider == "smtp" {
+				v.Required = false
+			}
+			info.EnvVars = append(info.EnvVars, v)
+		}
+	}
+	if file.Server != nil {
+		for _, options := range [][]*ast.ServerOption{file.Server.Options, file.Server.TLS} {
+			for _, opt := range options {
+				if opt.EnvVar != "" {
+					info.EnvVars = append(info.EnvVars, EnvVar{Name: opt.EnvVar, Service: "server", Required: opt.Required, Default: opt.Value})
+				}
+			}
+		}
+	}
+	if svc := g.findTracingService(file.Services); svc != nil {
+		for _, v := range tracingEnvVars {
+			v.Service = svc.Name
+			info.EnvVars = append(info.EnvVars, v)
+		}
+	}
+	if g.hasPIIFields(file) {
+		// "production" disables the anonymization task
+		info.EnvVars = append(info.EnvVars, EnvVar{Name: "GMX_ENV"})
+	}
+	sort.Slice(info.EnvVars, func(i, j int) bool { return info.EnvVars[i].Name < info.EnvVars[j].Name })
+
+	if len(file.Models) > 0 && g.findDatabaseService(file.Services) == nil {
+		info.SQLitePath = FallbackSQLitePath
+	}
+	if g.bundleStyles(file, resolved.Components) != "" {
+		info.AssetPaths = append(info.AssetPaths, appCSSPath)
+	}
+	return info
+}
@@ -0,0 +1,103 @@
+package generator
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/resolver"
+)
+
+func TestDeployInfo(t *testing.T) {
+	file := &ast.GMXFile{
+		Models: []*ast.ModelDecl{{Name: "Task", Fields: []*ast.FieldDecl{{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{{Name: "pk"}}}}}},
+		Services: []*ast.ServiceDecl{
+			{Name: "Mailer", Provider: "smtp", Fields: []*ast.ServiceField{
+				{Name: "port", Type: "string", EnvVar: "SMTP_PORT", Annotations: []*ast.Annotation{{Name: "default", Args: map[string]string{"_": "587"}}}},
+				{Name: "host", Type: "string", EnvVar: "SMTP_HOST"},
+				{Name: "from", Type: "string"},
+			}},
+		},
+	}
+
+	info := New().DeployInfo(&resolver.ResolvedFile{Main: file})
+	want := []EnvVar{
+		{Name: "SMTP_HOST", Service: "Mailer", Required: true},
+		{Name: "SMTP_PORT", Service: "Mailer", Default: "587"},
+	}
+	if !reflect.DeepEqual(info.EnvVars, want) {
+		t.Errorf("EnvVars = %+v, want %+v", info.EnvVars, want)
+	}
+	if info.Port != ServerPort {
+		t.Errorf("Port = %d, want %d", info.Port, ServerPort)
+	}
+	if info.SQLitePath != FallbackSQLitePath {
+		t.Errorf("SQLitePath = %q, want the fallback database", info.SQLitePath)
+	}
+
+	// Dev builds catch mail: SMTP credentials become optional
+	dev := NewWithOptions(Options{Dev: true}).DeployInfo(&resolver.ResolvedFile{Main: file})
+	if dev.EnvVars[0].Required {
+		t.Error("SMTP_HOST should be optional in dev builds")
+	}
+}
+
+func TestDeployInfoDatabaseService(t *testing.T) {
+	file := &ast.GMXFile{
+		Models: []*ast.ModelDecl{{Name: "Task"}},
+		Services: []*ast.ServiceDecl{
+			{Name: "Database", Provider: "postgres", Fields: []*ast.ServiceField{{Name: "url", Type: "string", EnvVar: "DATABASE_URL"}}},
+		},
+	}
+
+	info := New().DeployInfo(&resolver.ResolvedFile{Main: file})
+	if info.SQLitePath != "" {
+		t.Errorf("SQLitePath = %q, want none with a Database service", info.SQLitePath)
+	}
+	if len(info.EnvVars) != 1 || info.EnvVars[0].Name != "DATABASE_URL" || !info.EnvVars[0].Required {
+		t.Errorf("EnvVars = %+v, want required DATABASE_URL", info.EnvVars)
+	}
+}
+
+func TestDeployInfoServer(t *testing.T) {
+	file := &ast.GMXFile{
+		Server: &ast.ServerDecl{
+			Options: []*ast.ServerOption{
+				{Name: "port", Value: "3000", EnvVar: "PORT"},
+				{Name: "readTimeout", Value: "10s"},
+			},
+			TLS: []*ast.ServerOption{
+				{Name: "cert", EnvVar: "TLS_CERT", Required: true},
+				{Name: "key", EnvVar: "TLS_KEY", Required: true},
+			},
+		},
+	}
+
+	info := New().DeployInfo(&resolver.ResolvedFile{Main: file})
+	if info.Port != 3000 {
+		t.Errorf("Port = %d, want the default of the port option", info.Port)
+	}
+	want := []EnvVar{
+		{Name: "PORT", Service: "server", Default: "3000"},
+		{Name: "TLS_CERT", Service: "server", Required: true},
+		{Name: "TLS_KEY", Service: "server", Required: true},
+	}
+	if !reflect.DeepEqual(info.EnvVars, want) {
+		t.Errorf("EnvVars = %+v, want %+v", info.EnvVars, want)
+	}
+}
+
+func TestDeployInfoTracing(t *testing.T) {
+	file := &ast.GMXFile{
+		Services: []*ast.ServiceDecl{{Name: "Tracing", Provider: "tracing"}},
+	}
+
+	info := New().DeployInfo(&resolver.ResolvedFile{Main: file})
+	if len(info.EnvVars) != len(tracingEnvVars) {
+		t.Fatalf("EnvVars = %+v, want the OTEL_* variables", info.EnvVars)
+	}
+	want := EnvVar{Name: "OTEL_EXPORTER_OTLP_ENDPOINT", Service: "Tracing", Default: "http://localhost:4318"}
+	if info.EnvVars[0] != want {
+		t.Errorf("EnvVars[0] = %+v, want %+v", info.EnvVars[0], want)
+	}
+}
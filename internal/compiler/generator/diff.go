@@ -0,0 +1,404 @@
+package generator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/resolver"
+)
+
+// Difference is a semantic change between two versions of an app
+type Difference struct {
+	Op      string // "+" added, "-" removed, "~" changed
+	Kind    string // "model", "field", "index", "func", "route", "service" or "schema"
+	Subject string // Task, Task.title, POST /api/createTask, add column tasks.priority...
+	Detail  string // declaration, or "before → after" of a change
+	// Breaking tells why the change breaks the clients of the API: a removed
+	// route, a renamed JSON field, a narrowed validation; "" when compatible
+	Breaking string
+}
+
+func (d Difference) String() string {
+	s := d.Op + " " + d.Kind + " " + d.Subject
+	if d.Detail != "" {
+		s += ": " + d.Detail
+	}
+	return s
+}
+
+// Diff returns the declarations added, removed and changed between two
+// versions of an app, then the schema changes gmx migrate would write for
+// them. Destructive schema changes are marked in their detail, and changes
+// breaking the clients of the API carry the reason.
+func (g *Generator) Diff(previous, current *resolver.ResolvedFile) ([]Difference, error) {
+	var diffs []Difference
+	diffs = append(diffs, diffModels(previous.Main.Models, current.Main.Models)...)
+	diffs = append(diffs, diffFuncs(scriptFuncs(previous.Main), scriptFuncs(current.Main))...)
+	diffs = append(diffs, diffRoutes(g.Routes(previous), g.Routes(current))...)
+	diffs = append(diffs, diffServices(previous.Main.Services, current.Main.Services)...)
+
+	before, err := g.Schema(previous)
+	if err != nil {
+		return nil, err
+	}
+	after, err := g.Schema(current)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := DiffSchema(before, after)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range changes {
+		d := Difference{Op: "~", Kind: "schema", Subject: c.String()}
+		if c.Destructive() {
+			d.Detail = "loses data"
+		}
+		diffs = append(diffs, d)
+	}
+	return diffs, nil
+}
+
+// scriptFuncs returns the script functions of a file
+func scriptFuncs(file *ast.GMXFile) []*ast.FuncDecl {
+	if file.Script == nil {
+		return nil
+	}
+	return file.Script.Funcs
+}
+
+// diffModels compares models by name, then their annotations, fields and indexes
+func diffModels(previous, current []*ast.ModelDecl) []Difference {
+	var diffs []Difference
+	before := make(map[string]*ast.ModelDecl)
+	for _, m := range previous {
+		before[m.Name] = m
+	}
+	seen := make(map[string]bool)
+	for _, nm := range current {
+		seen[nm.Name] = true
+		om, ok := before[nm.Name]
+		if !ok {
+			diffs = append(diffs, Difference{Op: "+", Kind: "model", Subject: nm.Name, Detail: fmt.Sprintf("%d fields", len(nm.Fields))})
+			continue
+		}
+		if a, b := annotationText(om.Annotations), annotationText(nm.Annotations); a != b {
+			diffs = append(diffs, Difference{Op: "~", Kind: "model", Subject: nm.Name, Detail: changeText(a, b)})
+		}
+		diffs = append(diffs, diffFields(om, nm)...)
+		diffs = append(diffs, diffIndexes(om, nm)...)
+	}
+	for _, om := range previous {
+		if !seen[om.Name] {
+			diffs = append(diffs, Difference{Op: "-", Kind: "model", Subject: om.Name, Breaking: "model removed"})
+		}
+	}
+	return diffs
+}
+
+// diffFields compares the fields of a model by name, following @renamedFrom
+func diffFields(om, nm *ast.ModelDecl) []Difference {
+	var diffs []Difference
+	renamed := make(map[string]bool)
+	for _, nf := range nm.Fields {
+		subject := nm.Name + "." + nf.Name
+		of := om.FindField(nf.Name)
+		if ann := nf.FindAnnotation("renamedFrom"); of == nil && ann != nil && nm.FindField(ann.SimpleArg()) == nil {
+			if of = om.FindField(ann.SimpleArg()); of != nil {
+				renamed[of.Name] = true
+				diffs = append(diffs, Difference{Op: "~", Kind: "field", Subject: subject, Detail: "renamed from " + of.Name,
+					Breaking: fmt.Sprintf("JSON field %s renamed to %s", of.Name, nf.Name)})
+			}
+		}
+		if of == nil {
+			diffs = append(diffs, Difference{Op: "+", Kind: "field", Subject: subject, Detail: fieldText(nf)})
+			continue
+		}
+		if a, b := fieldText(withoutRename(of)), fieldText(withoutRename(nf)); a != b {
+			diffs = append(diffs, Difference{Op: "~", Kind: "field", Subject: subject, Detail: a + " → " + b, Breaking: narrowedField(of, nf)})
+		}
+	}
+	for _, of := range om.Fields {
+		if nm.FindField(of.Name) == nil && !renamed[of.Name] {
+			diffs = append(diffs, Difference{Op: "-", Kind: "field", Subject: nm.Name + "." + of.Name, Detail: fieldText(of),
+				Breaking: fmt.Sprintf("JSON field %s removed", of.Name)})
+		}
+	}
+	return diffs
+}
+
+// withoutRename returns a field without its @renamedFrom, which only tells
+// where its data comes from
+func withoutRename(f *ast.FieldDecl) *ast.FieldDecl {
+	if f.FindAnnotation("renamedFrom") == nil {
+		return f
+	}
+	copied := *f
+	copied.Annotations = nil
+	for _, ann := range f.Annotations {
+		if ann.Name != "renamedFrom" {
+			copied.Annotations = append(copied.Annotations, ann)
+		}
+	}
+	return &copied
+}
+
+// narrowedField tells how a field accepts fewer values than before: another
+// type, a removed enum value, a stricter bound or a new format; "" when
+// every value accepted before still is
+func narrowedField(of, nf *ast.FieldDecl) string {
+	switch {
+	case of.Type == "string" && nf.Type == "enum":
+		return "only accepts " + strings.Join(nf.Variants, ", ")
+	case of.Type != nf.Type:
+		return fmt.Sprintf("type changed from %s to %s", of.TypeString(), nf.TypeString())
+	}
+	for _, variant := range of.Variants {
+		if !enumHasVariant(nf, variant) {
+			return "value " + variant + " removed"
+		}
+	}
+	if before, after, ok := raisedBound(of, nf, "min", 1); ok {
+		return "@min raised " + boundChange(before, after)
+	}
+	if before, after, ok := raisedBound(of, nf, "max", -1); ok {
+		return "@max lowered " + boundChange(before, after)
+	}
+	for _, name := range []string{"email", "unique"} {
+		if of.FindAnnotation(name) == nil && nf.FindAnnotation(name) != nil {
+			return "@" + name + " added"
+		}
+	}
+	return ""
+}
+
+// raisedBound reports whether a @min (sign 1) or @max (sign -1) bound of a
+// field is stricter than before, or new
+func raisedBound(of, nf *ast.FieldDecl, name string, sign float64) (before, after string, ok bool) {
+	na := nf.FindAnnotation(name)
+	if na == nil {
+		return "", "", false
+	}
+	oa := of.FindAnnotation(name)
+	if oa == nil {
+		return "", na.SimpleArg(), true
+	}
+	ov, errOld := strconv.ParseFloat(oa.SimpleArg(), 64)
+	nv, errNew := strconv.ParseFloat(na.SimpleArg(), 64)
+	if errOld != nil || errNew != nil {
+		return "", "", false
+	}
+	return oa.SimpleArg(), na.SimpleArg(), (nv-ov)*sign > 0
+}
+
+// boundChange renders the change of a bound: "from 3 to 5", or "to 5" for a new one
+func boundChange(before, after string) string {
+	if before == "" {
+		return "to " + after
+	}
+	return "from " + before + " to " + after
+}
+
+// diffIndexes compares the indexes of a model by name
+func diffIndexes(om, nm *ast.ModelDecl) []Difference {
+	var diffs []Difference
+	before := make(map[string]modelIndex)
+	for _, i := range modelIndexes(om) {
+		before[i.name] = i
+	}
+	seen := make(map[string]bool)
+	for _, ni := range modelIndexes(nm) {
+		seen[ni.name] = true
+		oi, ok := before[ni.name]
+		switch {
+		case !ok:
+			diffs = append(diffs, Difference{Op: "+", Kind: "index", Subject: nm.Name + "." + ni.name, Detail: indexText(ni)})
+		case indexText(oi) != indexText(ni):
+			diffs = append(diffs, Difference{Op: "~", Kind: "index", Subject: nm.Name + "." + ni.name, Detail: indexText(oi) + " → " + indexText(ni)})
+		}
+	}
+	for _, oi := range modelIndexes(om) {
+		if !seen[oi.name] {
+			diffs = append(diffs, Difference{Op: "-", Kind: "index", Subject: nm.Name + "." + oi.name, Detail: indexText(oi)})
+		}
+	}
+	return diffs
+}
+
+// diffFuncs compares script functions by name: their signature and annotations
+func diffFuncs(previous, current []*ast.FuncDecl) []Difference {
+	var diffs []Difference
+	before := make(map[string]*ast.FuncDecl)
+	for _, fn := range previous {
+		before[fn.Name] = fn
+	}
+	seen := make(map[string]bool)
+	for _, nf := range current {
+		seen[nf.Name] = true
+		of, ok := before[nf.Name]
+		switch {
+		case !ok:
+			diffs = append(diffs, Difference{Op: "+", Kind: "func", Subject: nf.Name, Detail: funcText(nf)})
+		case funcText(of) != funcText(nf):
+			diffs = append(diffs, Difference{Op: "~", Kind: "func", Subject: nf.Name, Detail: funcText(of) + " → " + funcText(nf), Breaking: narrowedFunc(of, nf)})
+		}
+	}
+	for _, of := range previous {
+		if !seen[of.Name] {
+			diffs = append(diffs, Difference{Op: "-", Kind: "func", Subject: of.Name, Detail: funcText(of)})
+		}
+	}
+	return diffs
+}
+
+// narrowedFunc tells how a function called by clients accepts fewer calls
+// than before: a parameter added, removed or retyped, a new access check,
+// an answer switched to or from JSON; "" when every call accepted before still is
+func narrowedFunc(of, nf *ast.FuncDecl) string {
+	before := make(map[string]string)
+	for _, p := range of.Params {
+		before[p.Name] = p.Type
+	}
+	after := make(map[string]bool)
+	for _, p := range nf.Params {
+		after[p.Name] = true
+		typ, ok := before[p.Name]
+		switch {
+		case !ok:
+			return "parameter " + p.Name + " added"
+		case typ != p.Type:
+			return fmt.Sprintf("parameter %s changed from %s to %s", p.Name, typ, p.Type)
+		}
+	}
+	for _, p := range of.Params {
+		if !after[p.Name] {
+			return "parameter " + p.Name + " removed"
+		}
+	}
+	for _, name := range []string{"auth", "role", "signed", "require2fa"} {
+		if of.FindAnnotation(name) == nil && nf.FindAnnotation(name) != nil {
+			return "@" + name + " added"
+		}
+	}
+	// The answer switches between HTML fragments and JSON
+	switch {
+	case of.FindAnnotation("json") == nil && nf.FindAnnotation("json") != nil:
+		return "@json added"
+	case of.FindAnnotation("json") != nil && nf.FindAnnotation("json") == nil:
+		return "@json removed"
+	}
+	return ""
+}
+
+// diffRoutes compares the route tables by path
+func diffRoutes(previous, current []Route) []Difference {
+	var diffs []Difference
+	before := make(map[string]Route)
+	for _, r := range previous {
+		before[r.Path] = r
+	}
+	seen := make(map[string]bool)
+	for _, nr := range current {
+		seen[nr.Path] = true
+		or, ok := before[nr.Path]
+		switch {
+		case !ok:
+			diffs = append(diffs, Difference{Op: "+", Kind: "route", Subject: nr.Method + " " + nr.Path, Detail: nr.Source})
+		case or.Method != nr.Method || or.Source != nr.Source:
+			d := Difference{Op: "~", Kind: "route", Subject: nr.Path, Detail: or.Method + " " + or.Source + " → " + nr.Method + " " + nr.Source}
+			if or.Method != nr.Method {
+				d.Breaking = "method changed from " + or.Method + " to " + nr.Method
+			}
+			diffs = append(diffs, d)
+		}
+	}
+	for _, or := range previous {
+		if !seen[or.Path] {
+			diffs = append(diffs, Difference{Op: "-", Kind: "route", Subject: or.Method + " " + or.Path, Detail: or.Source, Breaking: "route removed"})
+		}
+	}
+	return diffs
+}
+
+// diffServices compares services by name: their provider and environment variables
+func diffServices(previous, current []*ast.ServiceDecl) []Difference {
+	var diffs []Difference
+	before := make(map[string]*ast.ServiceDecl)
+	for _, s := range previous {
+		before[s.Name] = s
+	}
+	seen := make(map[string]bool)
+	for _, ns := range current {
+		seen[ns.Name] = true
+		os, ok := before[ns.Name]
+		switch {
+		case !ok:
+			diffs = append(diffs, Difference{Op: "+", Kind: "service", Subject: ns.Name, Detail: serviceText(ns)})
+		case serviceText(os) != serviceText(ns):
+			diffs = append(diffs, Difference{Op: "~", Kind: "service", Subject: ns.Name, Detail: serviceText(os) + " → " + serviceText(ns)})
+		}
+	}
+	for _, os := range previous {
+		if !seen[os.Name] {
+			diffs = append(diffs, Difference{Op: "-", Kind: "service", Subject: os.Name, Detail: serviceText(os)})
+		}
+	}
+	return diffs
+}
+
+// annotationText renders annotations as written: @pk @default(uuid_v4)
+func annotationText(annotations []*ast.Annotation) string {
+	parts := make([]string, len(annotations))
+	for i, ann := range annotations {
+		parts[i] = ann.String()
+	}
+	return strings.Join(parts, " ")
+}
+
+// changeText renders a change of annotations, "(none)" standing for no annotation
+func changeText(before, after string) string {
+	if before == "" {
+		before = "(none)"
+	}
+	if after == "" {
+		after = "(none)"
+	}
+	return before + " → " + after
+}
+
+// fieldText renders a field as declared: string @min(3)
+func fieldText(f *ast.FieldDecl) string {
+	return strings.TrimSpace(f.TypeString() + " " + annotationText(f.Annotations))
+}
+
+// indexText renders an index: unique (owner_id, slug)
+func indexText(i modelIndex) string {
+	text := "(" + strings.Join(i.fields, ", ") + ")"
+	if i.unique {
+		return "unique " + text
+	}
+	return text
+}
+
+// funcText renders the signature of a function and its annotations:
+// @auth createTask(title: string) error
+func funcText(fn *ast.FuncDecl) string {
+	params := make([]string, len(fn.Params))
+	for i, p := range fn.Params {
+		params[i] = p.Name + ": " + p.Type
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s %s(%s) %s", annotationText(fn.Annotations), fn.Name, strings.Join(params, ", "), fn.ReturnType))
+}
+
+// serviceText renders the provider of a service and its environment variables
+func serviceText(s *ast.ServiceDecl) string {
+	parts := []string{s.Provider}
+	for _, field := range s.Fields {
+		if field.EnvVar != "" {
+			parts = append(parts, "$"+field.EnvVar)
+		}
+	}
+	return strings.Join(parts, " ")
+}
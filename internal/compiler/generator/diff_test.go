@@ -0,0 +1,155 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/lang"
+	"github.com/btouchard/gmx/internal/compiler/resolver"
+	"github.com/btouchard/gmx/internal/compiler/script"
+)
+
+const diffScriptSrc = `model Task {
+  id: uuid @pk @default(uuid_v4)
+  title: string @min(3)
+  done: bool @default(false)
+}
+
+service Mailer {
+  provider: "smtp"
+  host: string @env("SMTP_HOST")
+}
+
+func toggleTask(id: uuid) error {
+  return nil
+}`
+
+// diffTestFile parses a script into a resolved file
+func diffTestFile(t *testing.T, src string) *resolver.ResolvedFile {
+	t.Helper()
+	parsed, errs := script.ParseVersion(src, 0, lang.Version{Major: 1, Minor: 1})
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	return &resolver.ResolvedFile{Main: &ast.GMXFile{
+		Models:   parsed.Models,
+		Services: parsed.Services,
+		Script:   &ast.ScriptBlock{Funcs: parsed.Funcs, Models: parsed.Models},
+	}}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{"unchanged", diffScriptSrc, nil},
+		{
+			"field added",
+			strings.Replace(diffScriptSrc, "  done:", "  priority: int @default(0)\n  done:", 1),
+			[]string{"+ field Task.priority: int @default(0)", "~ schema add column tasks.priority"},
+		},
+		{
+			"field changed",
+			strings.Replace(diffScriptSrc, "@min(3)", "@min(3) @max(120)", 1),
+			[]string{"~ field Task.title: string @min(3) → string @min(3) @max(120)"},
+		},
+		{
+			"field removed",
+			strings.Replace(diffScriptSrc, "  done: bool @default(false)\n", "", 1),
+			[]string{"- field Task.done: bool @default(false)", "~ schema drop column tasks.done: loses data"},
+		},
+		{
+			"model annotation and index",
+			strings.Replace(strings.Replace(diffScriptSrc, "model Task {", "@timestamps\nmodel Task {", 1), "done: bool @default(false)", "done: bool @default(false) @index", 1),
+			[]string{"~ model Task: (none) → @timestamps", "+ index Task.idx_tasks_done: (done)", "~ schema add index idx_tasks_done"},
+		},
+		{
+			"model added",
+			diffScriptSrc + "\n\nmodel Tag {\n  id: int @pk\n  label: string\n}",
+			[]string{"+ model Tag: 2 fields", "~ schema create table tags"},
+		},
+		{
+			"func changed",
+			strings.Replace(diffScriptSrc, "func toggleTask(id: uuid)", "@auth\nfunc toggleTask(id: uuid, done: bool)", 1),
+			[]string{"~ func toggleTask: toggleTask(id: uuid) error → @auth toggleTask(id: uuid, done: bool) error"},
+		},
+		{
+			"func renamed",
+			strings.Replace(diffScriptSrc, "toggleTask", "completeTask", 1),
+			[]string{"+ func completeTask", "+ route POST /api/completeTask", "- func toggleTask", "- route PATCH /api/toggleTask"},
+		},
+		{
+			"service changed",
+			strings.Replace(diffScriptSrc, `"SMTP_HOST"`, `"MAIL_HOST"`, 1),
+			[]string{"~ service Mailer: smtp $SMTP_HOST → smtp $MAIL_HOST"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diffs, err := New().Diff(diffTestFile(t, diffScriptSrc), diffTestFile(t, tt.src))
+			if err != nil {
+				t.Fatalf("Diff failed: %v", err)
+			}
+			lines := make([]string, len(diffs))
+			for i, d := range diffs {
+				lines[i] = d.String()
+			}
+			got := strings.Join(lines, "\n")
+			if len(tt.want) == 0 && got != "" {
+				t.Errorf("expected no differences, got:\n%s", got)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("differences missing %q, got:\n%s", want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestDiffBreaking(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		subject  string
+		breaking string
+	}{
+		{"min raised", strings.Replace(diffScriptSrc, "@min(3)", "@min(5)", 1), "Task.title", "@min raised from 3 to 5"},
+		{"min lowered", strings.Replace(diffScriptSrc, "@min(3)", "@min(1)", 1), "Task.title", ""},
+		{"max added", strings.Replace(diffScriptSrc, "@min(3)", "@min(3) @max(120)", 1), "Task.title", "@max lowered to 120"},
+		{"email added", strings.Replace(diffScriptSrc, "@min(3)", "@min(3) @email", 1), "Task.title", "@email added"},
+		{"type", strings.Replace(diffScriptSrc, "done: bool", "done: int", 1), "Task.done", "type changed from bool to int"},
+		{"string to enum", strings.Replace(diffScriptSrc, "title: string @min(3)", "title: enum(a, b)", 1), "Task.title", "only accepts a, b"},
+		{"field removed", strings.Replace(diffScriptSrc, "  done: bool @default(false)\n", "", 1), "Task.done", "JSON field done removed"},
+		{"field renamed", strings.Replace(diffScriptSrc, "done: bool", `completed: bool @renamedFrom("done")`, 1), "Task.completed", "JSON field done renamed to completed"},
+		{"field added", strings.Replace(diffScriptSrc, "  done:", "  notes: string\n  done:", 1), "Task.notes", ""},
+		{"parameter added", strings.Replace(diffScriptSrc, "toggleTask(id: uuid)", "toggleTask(id: uuid, force: bool)", 1), "toggleTask", "parameter force added"},
+		{"auth added", strings.Replace(diffScriptSrc, "func toggleTask", "@auth\nfunc toggleTask", 1), "toggleTask", "@auth added"},
+		{"json added", strings.Replace(diffScriptSrc, "func toggleTask", "@json\nfunc toggleTask", 1), "toggleTask", "@json added"},
+		{"require2fa added", strings.Replace(diffScriptSrc, "func toggleTask", "@require2fa\nfunc toggleTask", 1), "toggleTask", "@require2fa added"},
+		{"route removed", strings.Replace(diffScriptSrc, "toggleTask", "completeTask", 1), "PATCH /api/toggleTask", "route removed"},
+		{"model removed", strings.Replace(diffScriptSrc, "model Task", "model Todo", 1), "Task", "model removed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diffs, err := New().Diff(diffTestFile(t, diffScriptSrc), diffTestFile(t, tt.src))
+			if err != nil {
+				t.Fatalf("Diff failed: %v", err)
+			}
+			for _, d := range diffs {
+				if d.Subject == tt.subject && d.Kind != "schema" {
+					if d.Breaking != tt.breaking {
+						t.Errorf("%s: breaking = %q, want %q", d, d.Breaking, tt.breaking)
+					}
+					return
+				}
+			}
+			t.Errorf("no difference for %s in %v", tt.subject, diffs)
+		})
+	}
+}
@@ -0,0 +1,510 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// accountDeletionModel is the generated model storing the accounts whose
+// deletion is pending, until their purge
+const accountDeletionModel = "AccountDeletion"
+
+// defaultDeletionGrace is the time between the deletion request of an account
+// and its purge, unless the session service sets deletionGrace
+const defaultDeletionGrace = "30 * 24 * time.Hour"
+
+// Built-in endpoints rendering the account settings of the session user,
+// changing their email address, confirming the change from the emailed link,
+// and deleting their account
+const (
+	accountPath             = "/_gmx/account"
+	accountEmailPath        = "/_gmx/account/email"
+	accountEmailConfirmPath = "/_gmx/account/email/confirm"
+	accountDeletePath       = "/_gmx/account/delete"
+)
+
+// accountRoutes are the built-in account endpoints
+var accountRoutes = []Route{
+	{Method: "GET", Path: accountPath, Handler: "handleAccount"},
+	{Method: "POST", Path: accountEmailPath, Handler: "handleAccountEmail"},
+	{Method: "GET", Path: accountEmailConfirmPath, Handler: "handleAccountEmailConfirm"},
+	{Method: "POST", Path: accountDeletePath, Handler: "handleAccountDelete"},
+}
+
+// findAccountModel returns the model of the user accounts, marked @account
+func (g *Generator) findAccountModel(file *ast.GMXFile) *ast.ModelDecl {
+	for _, model := range file.Models {
+		if model.FindAnnotation("account") != nil {
+			return model
+		}
+	}
+	return nil
+}
+
+// hasAccounts checks if a model marked @account gets the email change and
+// account deletion flows
+func (g *Generator) hasAccounts(file *ast.GMXFile) bool {
+	return g.findAccountModel(file) != nil
+}
+
+// validateAccounts checks that the @account model names a string @email field
+// and has a key the sessions identify users by, that the flows have a session,
+// a mailer and a script, and that the generated model and endpoints are free
+func (g *Generator) validateAccounts(file *ast.GMXFile) error {
+	var account *ast.ModelDecl
+	for _, model := range file.Models {
+		ann := model.FindAnnotation("account")
+		if ann == nil {
+			continue
+		}
+		if account != nil {
+			return fmt.Errorf("model %s: only one model is @account, and %s already is", model.Name, account.Name)
+		}
+		account = model
+
+		email := ann.SimpleArg()
+		if len(ann.Args) != 1 || email == "" {
+			return fmt.Errorf("model %s: @account names the email field of the account, as in @account(email)", model.Name)
+		}
+		field := findModelField(model, email)
+		if field == nil {
+			return fmt.Errorf("model %s: @account(%s) names no field of the model", model.Name, email)
+		}
+		if field.Type != "string" || field.FindAnnotation("email") == nil {
+			return fmt.Errorf("model %s: @account(%s) requires a string field with @email", model.Name, email)
+		}
+		if pk := modelPKField(model); pk == nil || (pk.Type != "uuid" && pk.Type != "string") {
+			return fmt.Errorf("model %s: @account requires a uuid or string primary key, the ID sessions identify users by", model.Name)
+		}
+		if model.FindAnnotation("repository") != nil {
+			return fmt.Errorf("model %s: @account does not apply to @repository models, whose records the purge cannot delete", model.Name)
+		}
+		if g.findSessionService(file.Services) == nil {
+			return fmt.Errorf("model %s: @account requires a service with provider \"session\"", model.Name)
+		}
+		if g.findLockoutMailer(file) == nil {
+			return fmt.Errorf("model %s: @account requires an smtp service with a send method, which mails the confirmation links", model.Name)
+		}
+		if file.Script == nil || len(file.Script.Funcs) == 0 {
+			return fmt.Errorf("model %s: @account requires script functions signing users in with ctx.login", model.Name)
+		}
+		// The purge runs without a request to take the tenant from
+		if g.hasRowLevelSecurity(file) {
+			return fmt.Errorf("model %s: @account does not apply with row-level security, which needs the tenant of a request", model.Name)
+		}
+	}
+
+	svc := g.findSessionService(file.Services)
+	if account == nil {
+		if svc != nil && findServiceField(svc, "deletionGrace") != nil {
+			return fmt.Errorf("service %s: deletionGrace applies to an @account model", svc.Name)
+		}
+		return nil
+	}
+	for _, model := range file.Models {
+		if model.Name == accountDeletionModel {
+			return fmt.Errorf("line %d: model %s collides with the model storing account deletions; rename it", model.Line, model.Name)
+		}
+	}
+	for _, fn := range file.Script.Funcs {
+		for _, route := range accountRoutes {
+			if "handle"+utils.Capitalize(fn.Name) == route.Handler {
+				return fmt.Errorf("line %d: function %s collides with the built-in %s endpoint; rename it", fn.Line, fn.Name, route.Path)
+			}
+		}
+	}
+	return nil
+}
+
+// findModelField returns the field of a model with the given name, if any
+func findModelField(model *ast.ModelDecl, name string) *ast.FieldDecl {
+	for _, field := range model.Fields {
+		if field.Name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+// withAccountModels returns the file with the model storing the pending
+// account deletions, declared and migrated like the others
+func (g *Generator) withAccountModels(file *ast.GMXFile) *ast.GMXFile {
+	if !g.hasAccounts(file) {
+		return file
+	}
+	withModel := *file
+	withModel.Models = append(append([]*ast.ModelDecl{}, file.Models...), &ast.ModelDecl{
+		Name: accountDeletionModel,
+		Fields: []*ast.FieldDecl{
+			{Name: "owner", Type: "string", Annotations: []*ast.Annotation{
+				{Name: "pk", Args: map[string]string{}},
+			}},
+			{Name: "requestedAt", Type: "datetime"},
+			{Name: "purgeAt", Type: "datetime", Annotations: []*ast.Annotation{
+				{Name: "index", Args: map[string]string{}},
+			}},
+		},
+	})
+	return &withModel
+}
+
+// genAccounts generates the email change confirmed by a signed link, the
+// deletion of accounts after a grace period during which signing in keeps
+// them, the purge of their records and the built-in account endpoints
+func (g *Generator) genAccounts(file *ast.GMXFile) string {
+	var b strings.Builder
+	model := g.findAccountModel(file)
+	mailer := g.findLockoutMailer(file)
+	serverSessions := g.hasServerSessions(file)
+
+	emailField := findModelField(model, model.FindAnnotation("account").SimpleArg())
+	email := utils.ToPascalCase(emailField.Name)
+	emailColumn := utils.ToSnakeCase(emailField.Name)
+	pkField := modelPKField(model)
+	pk := utils.ToPascalCase(pkField.Name)
+	pkColumn := utils.ToSnakeCase(pkField.Name)
+
+	b.WriteString("// accountGrace is the time between the deletion request of an account and\n")
+	b.WriteString("// its purge, during which signing in keeps the account; the deletionGrace\n")
+	b.WriteString("// field of the session service changes it\n")
+	b.WriteString(fmt.Sprintf("var accountGrace = %s\n\n", defaultDeletionGrace))
+
+	b.WriteString("// emailChangeTTL is how long the link confirming a new email address is valid\n")
+	b.WriteString("const emailChangeTTL = 24 * time.Hour\n\n")
+
+	b.WriteString(fmt.Sprintf("// accountMailer sends the confirmation links and notices of account changes;\n// set to the %s service at startup\n", mailer.Name))
+	b.WriteString(fmt.Sprintf("var accountMailer %sService\n\n", mailer.Name))
+
+	b.WriteString("// accountGraceText returns the grace period of account deletions in days, or\n")
+	b.WriteString("// as a duration when it is not a whole number of days\n")
+	b.WriteString("func accountGraceText() string {\n")
+	b.WriteString("\tday := 24 * time.Hour\n")
+	b.WriteString("\tswitch {\n")
+	b.WriteString("\tcase accountGrace == day:\n")
+	b.WriteString("\t\treturn \"1 day\"\n")
+	b.WriteString("\tcase accountGrace > 0 && accountGrace%day == 0:\n")
+	b.WriteString("\t\treturn fmt.Sprintf(\"%d days\", accountGrace/day)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn accountGrace.String()\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// sendAccountMail mails an account notice, logging its failure\n")
+	b.WriteString("func sendAccountMail(to, subject, body string) {\n")
+	b.WriteString("\tif strings.ContainsAny(to, \"\\r\\n\") {\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif err := accountMailer.Send(to, subject, body); err != nil {\n")
+	b.WriteString("\t\tslog.Error(\"account: mailing\", \"to\", to, \"error\", err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// accountDeleting reports whether the account of a user awaits its purge;\n")
+	b.WriteString("// when the database fails, it is taken to\n")
+	b.WriteString("func accountDeleting(user string) bool {\n")
+	b.WriteString("\tvar pending int64\n")
+	b.WriteString(fmt.Sprintf("\tif err := db.Model(&%s{}).Where(map[string]any{\"owner\": user}).Count(&pending).Error; err != nil {\n", accountDeletionModel))
+	b.WriteString("\t\tslog.Error(\"account: loading deletion\", \"user\", user, \"error\", err)\n")
+	b.WriteString("\t\treturn true\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn pending > 0\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// activeSession returns a session, or an empty one once its user asked for\n")
+	b.WriteString("// the deletion of their account: sessions on other devices close too\n")
+	b.WriteString("func activeSession(s gmxSession) gmxSession {\n")
+	b.WriteString("\tif s.User != \"\" && accountDeleting(s.User) {\n")
+	b.WriteString("\t\treturn gmxSession{}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn s\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// restoreAccount cancels the pending deletion of the account of a user\n")
+	b.WriteString("// signing in\n")
+	b.WriteString("func restoreAccount(user string) {\n")
+	b.WriteString(fmt.Sprintf("\tres := db.Where(map[string]any{\"owner\": user}).Delete(&%s{})\n", accountDeletionModel))
+	b.WriteString("\tif res.Error != nil {\n")
+	b.WriteString("\t\tslog.Error(\"account: cancelling deletion\", \"user\", user, \"error\", res.Error)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif res.RowsAffected > 0 {\n")
+	b.WriteString("\t\tslog.Info(\"audit: account deletion cancelled\", \"user\", user)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// purgeAccounts deletes the accounts whose grace period is over, with their\n")
+	b.WriteString("// records; the scheduler runs it hourly\n")
+	b.WriteString("func purgeAccounts(ctx *GMXContext) error {\n")
+	b.WriteString(fmt.Sprintf("\tvar due []%s\n", accountDeletionModel))
+	b.WriteString("\tif err := ctx.DB.Where(\"purge_at <= ?\", time.Now()).Find(&due).Error; err != nil {\n")
+	b.WriteString("\t\treturn err\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tfor _, deletion := range due {\n")
+	b.WriteString("\t\tif err := purgeAccount(ctx.DB, deletion.Owner); err != nil {\n")
+	b.WriteString("\t\t\treturn fmt.Errorf(\"purging %s: %w\", deletion.Owner, err)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tctx.Log.Info(\"audit: account purged\", \"user\", deletion.Owner)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn nil\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// purgeAccount deletes an account, the records related to it, its second\n")
+	b.WriteString("// factor, notifications, sessions and accepted terms, and its pending\n")
+	b.WriteString("// deletion, at once\n")
+	b.WriteString("func purgeAccount(conn *gorm.DB, user string) error {\n")
+	b.WriteString("\treturn conn.Transaction(func(tx *gorm.DB) error {\n")
+	b.WriteString("\t\t// Related records go first, for their foreign keys\n")
+	var owned []string
+	for _, related := range file.Models {
+		if related == model || related.FindAnnotation("repository") != nil {
+			continue
+		}
+		for _, field := range related.Fields {
+			if field.Type == model.Name && field.FindAnnotation("relation") != nil {
+				owned = append(owned, fmt.Sprintf("tx.Where(map[string]any{%q: user}).Delete(&%s{})", utils.ToSnakeCase(field.Name)+"_id", related.Name))
+			}
+		}
+	}
+	if g.hasTwoFactor(file) {
+		owned = append(owned,
+			fmt.Sprintf("tx.Where(map[string]any{\"owner\": user}).Delete(&%s{})", twoFactorModel),
+			fmt.Sprintf("tx.Where(map[string]any{\"owner\": user}).Delete(&%s{})", backupCodeModel))
+	}
+	if g.hasNotifications(file) {
+		owned = append(owned, fmt.Sprintf("tx.Where(map[string]any{\"recipient\": user}).Delete(&%s{})", notificationModel))
+	}
+	if g.sessionStoreOf(file) == "database" {
+		owned = append(owned, fmt.Sprintf("tx.Where(map[string]any{\"user\": user}).Delete(&%s{})", sessionModel))
+	}
+	if g.hasConsent(file) {
+		owned = append(owned, fmt.Sprintf("tx.Where(map[string]any{\"owner\": user}).Delete(&%s{})", consentModel))
+	}
+	for _, del := range owned {
+		b.WriteString(fmt.Sprintf("\t\tif err := %s.Error; err != nil {\n", del))
+		b.WriteString("\t\t\treturn err\n")
+		b.WriteString("\t\t}\n")
+	}
+	b.WriteString("\t\t// The account is loaded first, for its delete hooks to run\n")
+	b.WriteString(fmt.Sprintf("\t\tvar account %s\n", model.Name))
+	b.WriteString(fmt.Sprintf("\t\tif err := tx.Where(map[string]any{%q: user}).Limit(1).Find(&account).Error; err != nil {\n", pkColumn))
+	b.WriteString("\t\t\treturn err\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString(fmt.Sprintf("\t\tif account.%s != \"\" {\n", pk))
+	b.WriteString("\t\t\tif err := tx.Delete(&account).Error; err != nil {\n")
+	b.WriteString("\t\t\t\treturn err\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString(fmt.Sprintf("\t\treturn tx.Where(map[string]any{\"owner\": user}).Delete(&%s{}).Error\n", accountDeletionModel))
+	b.WriteString("\t})\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// loadAccount loads the account of the session user, answering the request\n")
+	b.WriteString("// when it cannot\n")
+	b.WriteString(fmt.Sprintf("func loadAccount(w http.ResponseWriter, r *http.Request, user string) (%s, bool) {\n", model.Name))
+	b.WriteString(fmt.Sprintf("\tvar account %s\n", model.Name))
+	b.WriteString(fmt.Sprintf("\tif err := db.Where(map[string]any{%q: user}).Limit(1).Find(&account).Error; err != nil {\n", pkColumn))
+	b.WriteString("\t\trequestLogger(r).Error(\"account: loading\", \"error\", err)\n")
+	b.WriteString("\t\thttp.Error(w, \"Internal Server Error\", http.StatusInternalServerError)\n")
+	b.WriteString("\t\treturn account, false\n")
+	b.WriteString("\t}\n")
+	b.WriteString(fmt.Sprintf("\tif account.%s == \"\" {\n", pk))
+	b.WriteString("\t\thttp.Error(w, \"Not Found\", http.StatusNotFound)\n")
+	b.WriteString("\t\treturn account, false\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn account, true\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// emailChangeSignature signs the link moving the account of a user from an\n")
+	b.WriteString("// email address to another until exp, in Unix seconds\n")
+	b.WriteString("func emailChangeSignature(user, from, to, exp string) string {\n")
+	b.WriteString("\treturn signSession(\"email\\n\" + user + \"\\n\" + from + \"\\n\" + to + \"\\n\" + exp)\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// absoluteURL returns the URL of a path of the app on the host and scheme\n")
+	b.WriteString("// of a request, for the links of emails\n")
+	b.WriteString("func absoluteURL(r *http.Request, path string, query url.Values) string {\n")
+	b.WriteString("\tscheme := \"http\"\n")
+	b.WriteString("\tif r.TLS != nil || r.Header.Get(\"X-Forwarded-Proto\") == \"https\" {\n")
+	b.WriteString("\t\tscheme = \"https\"\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn scheme + \"://\" + r.Host + path + \"?\" + query.Encode()\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// renderAccountPage renders the outcome of an email change link\n")
+	b.WriteString("func renderAccountPage(w http.ResponseWriter, status int, message string) {\n")
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/html; charset=utf-8\")\n")
+	b.WriteString("\tw.WriteHeader(status)\n")
+	b.WriteString("\tfmt.Fprintf(w, `<!DOCTYPE html><html><head><meta charset=\"utf-8\"><title>Your account</title></head><body>`+\n")
+	b.WriteString("\t\t`<p class=\"gmx-account\">%s</p><p><a href=\"/\">Back to the app</a></p></body></html>`, template.HTMLEscapeString(message))\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// handleAccount renders the account settings fragment of the session user:\n")
+	b.WriteString("// the email change form and the account deletion form\n")
+	b.WriteString("func handleAccount(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString(genMethodGuard("Get"))
+	b.WriteString("\ts := readSession(r)\n")
+	b.WriteString("\tif s.User == \"\" {\n")
+	b.WriteString("\t\thttp.Error(w, \"Unauthorized\", http.StatusUnauthorized)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\taccount, ok := loadAccount(w, r, s.User)\n")
+	b.WriteString("\tif !ok {\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/html; charset=utf-8\")\n")
+	b.WriteString("\tw.Header().Set(\"Cache-Control\", \"no-store\")\n")
+	b.WriteString("\tfmt.Fprintf(w, `<div class=\"gmx-account\" id=\"gmx-account\">`+\n")
+	b.WriteString(fmt.Sprintf("\t\t`<form hx-post=%q hx-target=\"#gmx-account-status\">`+\n", accountEmailPath))
+	b.WriteString("\t\t`<label>Email address <input type=\"email\" name=\"email\" value=\"%s\" autocomplete=\"email\" required></label> `+\n")
+	b.WriteString("\t\t`<button>Change</button></form>`+\n")
+	b.WriteString(fmt.Sprintf("\t\t`<form hx-post=%q hx-target=\"#gmx-account-status\" hx-confirm=\"Delete your account? Signing in within %%s keeps it.\">`+\n", accountDeletePath))
+	b.WriteString("\t\t`<label>Type your email address to delete your account <input type=\"email\" name=\"confirm\" required></label> `+\n")
+	b.WriteString("\t\t`<button>Delete my account</button></form>`+\n")
+	b.WriteString("\t\t`<p id=\"gmx-account-status\" role=\"status\"></p></div>`,\n")
+	b.WriteString(fmt.Sprintf("\t\ttemplate.HTMLEscapeString(account.%s), accountGraceText())\n", email))
+	b.WriteString("}\n\n")
+
+	b.WriteString("// handleAccountEmail mails a link confirming a new email address to that\n")
+	b.WriteString("// address; the answer is the same whether another account uses it or not\n")
+	b.WriteString("func handleAccountEmail(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString(genTwoFactorOwner())
+	b.WriteString("\tto := strings.TrimSpace(r.FormValue(\"email\"))\n")
+	b.WriteString("\tif !isValidEmail(to) {\n")
+	b.WriteString("\t\thttp.Error(w, \"Invalid email address\", http.StatusUnprocessableEntity)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\taccount, ok := loadAccount(w, r, s.User)\n")
+	b.WriteString("\tif !ok {\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString(fmt.Sprintf("\tif strings.EqualFold(account.%s, to) {\n", email))
+	b.WriteString("\t\thttp.Error(w, \"This is already your email address\", http.StatusUnprocessableEntity)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tvar taken int64\n")
+	b.WriteString(fmt.Sprintf("\tif err := db.Model(&%s{}).Where(map[string]any{%q: to}).Count(&taken).Error; err != nil {\n", model.Name, emailColumn))
+	b.WriteString("\t\trequestLogger(r).Error(\"account: checking email\", \"error\", err)\n")
+	b.WriteString("\t\thttp.Error(w, \"Internal Server Error\", http.StatusInternalServerError)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\t// Addresses of other accounts get no link, without telling the requester\n")
+	b.WriteString("\tif taken == 0 {\n")
+	b.WriteString("\t\texp := strconv.FormatInt(time.Now().Add(emailChangeTTL).Unix(), 10)\n")
+	b.WriteString(fmt.Sprintf("\t\tlink := absoluteURL(r, %q, url.Values{\n", accountEmailConfirmPath))
+	b.WriteString(fmt.Sprintf("\t\t\t\"user\": {s.User}, \"from\": {account.%s}, \"email\": {to}, \"exp\": {exp},\n", email))
+	b.WriteString(fmt.Sprintf("\t\t\t\"sig\": {emailChangeSignature(s.User, account.%s, to, exp)},\n", email))
+	b.WriteString("\t\t})\n")
+	b.WriteString("\t\tgo sendAccountMail(to, \"Confirm your new email address\",\n")
+	b.WriteString("\t\t\t\"Follow this link within 24 hours to make \"+to+\" the email address of your account:\\r\\n\"+link+\"\\r\\n\"+\n")
+	b.WriteString("\t\t\t\t\"If you did not ask for this change, ignore this message.\")\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\trequestLogger(r).Info(\"audit: email change requested\", \"user\", s.User)\n")
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/html; charset=utf-8\")\n")
+	b.WriteString("\tfmt.Fprintf(w, \"We sent a link to %s: your email address changes once you follow it.\", template.HTMLEscapeString(to))\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// handleAccountEmailConfirm moves an account to the new email address of a\n")
+	b.WriteString("// signed link, once: the link names the address it moves the account from\n")
+	b.WriteString("func handleAccountEmailConfirm(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString(genMethodGuard("Get"))
+	b.WriteString("\tq := r.URL.Query()\n")
+	b.WriteString("\tuser, from, to, exp := q.Get(\"user\"), q.Get(\"from\"), q.Get(\"email\"), q.Get(\"exp\")\n")
+	b.WriteString("\tif !hmac.Equal([]byte(q.Get(\"sig\")), []byte(emailChangeSignature(user, from, to, exp))) {\n")
+	b.WriteString("\t\trenderAccountPage(w, http.StatusForbidden, \"This link is invalid.\")\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif expires, err := strconv.ParseInt(exp, 10, 64); err != nil || time.Now().Unix() > expires {\n")
+	b.WriteString("\t\trenderAccountPage(w, http.StatusGone, \"This link has expired: ask for a new one from your account settings.\")\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\t// The account itself has the address once the link is used\n")
+	b.WriteString("\tvar taken int64\n")
+	b.WriteString(fmt.Sprintf("\tif err := db.Model(&%s{}).Where(map[string]any{%q: to}).Not(map[string]any{%q: user}).Count(&taken).Error; err != nil {\n", model.Name, emailColumn, pkColumn))
+	b.WriteString("\t\trequestLogger(r).Error(\"account: checking email\", \"error\", err)\n")
+	b.WriteString("\t\thttp.Error(w, \"Internal Server Error\", http.StatusInternalServerError)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif taken > 0 {\n")
+	b.WriteString("\t\trenderAccountPage(w, http.StatusConflict, \"Another account uses this email address.\")\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString(fmt.Sprintf("\tres := db.Model(&%s{}).Where(map[string]any{%q: user, %q: from}).Update(%q, to)\n", model.Name, pkColumn, emailColumn, emailColumn))
+	b.WriteString("\tif res.Error != nil {\n")
+	b.WriteString("\t\trequestLogger(r).Error(\"account: changing email\", \"error\", res.Error)\n")
+	b.WriteString("\t\thttp.Error(w, \"Internal Server Error\", http.StatusInternalServerError)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif res.RowsAffected == 0 {\n")
+	b.WriteString("\t\trenderAccountPage(w, http.StatusGone, \"This link was already used.\")\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\trequestLogger(r).Info(\"audit: email address changed\", \"user\", user)\n")
+	b.WriteString("\t// The previous address hears of the change, in case it was not its owner's\n")
+	b.WriteString("\tgo sendAccountMail(from, \"Your email address was changed\",\n")
+	b.WriteString("\t\t\"The email address of your account is now \"+to+\".\\r\\nIf you did not make this change, contact us right away.\")\n")
+	b.WriteString("\trenderAccountPage(w, http.StatusOK, \"Your email address is now \"+to+\".\")\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// handleAccountDelete schedules the purge of the account of the session user\n")
+	b.WriteString("// after the grace period, and signs them out\n")
+	b.WriteString("func handleAccountDelete(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString(genTwoFactorOwner())
+	b.WriteString("\taccount, ok := loadAccount(w, r, s.User)\n")
+	b.WriteString("\tif !ok {\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString(fmt.Sprintf("\tif !strings.EqualFold(strings.TrimSpace(r.FormValue(\"confirm\")), account.%s) {\n", email))
+	b.WriteString("\t\thttp.Error(w, \"Type the email address of your account to delete it\", http.StatusUnprocessableEntity)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tnow := time.Now()\n")
+	b.WriteString(fmt.Sprintf("\tdeletion := %s{Owner: s.User, RequestedAt: now, PurgeAt: now.Add(accountGrace)}\n", accountDeletionModel))
+	b.WriteString("\tif err := db.Save(&deletion).Error; err != nil {\n")
+	b.WriteString("\t\trequestLogger(r).Error(\"account: requesting deletion\", \"error\", err)\n")
+	b.WriteString("\t\thttp.Error(w, \"Internal Server Error\", http.StatusInternalServerError)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\trequestLogger(r).Info(\"audit: account deletion requested\", \"user\", s.User, \"purge_at\", deletion.PurgeAt)\n")
+	b.WriteString(fmt.Sprintf("\tgo sendAccountMail(account.%s, \"Your account will be deleted\",\n", email))
+	b.WriteString("\t\t\"Your account and its data will be deleted on \"+deletion.PurgeAt.Format(\"January 2, 2006\")+\".\\r\\n\"+\n")
+	b.WriteString("\t\t\t\"To keep your account, sign in before then.\")\n")
+	if serverSessions {
+		b.WriteString("\tdropSession(r)\n")
+	}
+	b.WriteString("\tclearSession(w)\n")
+	b.WriteString("\tif r.Header.Get(\"HX-Request\") == \"true\" {\n")
+	b.WriteString("\t\tw.Header().Set(\"HX-Redirect\", \"/\")\n")
+	b.WriteString("\t\tw.WriteHeader(http.StatusNoContent)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\thttp.Redirect(w, r, \"/\", http.StatusSeeOther)\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// genAccountConfig generates the lines of configure<Session> reading the
+// grace period of account deletions
+func genAccountConfig(svc *ast.ServiceDecl) string {
+	if findServiceField(svc, "deletionGrace") == nil {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("\tgrace, err := time.ParseDuration(cfg.DeletionGrace)\n")
+	b.WriteString("\tif err != nil || grace < 0 {\n")
+	b.WriteString(fmt.Sprintf("\t\tlog.Fatalf(\"service %s: invalid deletion grace %%q: expected a duration such as 720h\", cfg.DeletionGrace)\n", svc.Name))
+	b.WriteString("\t}\n")
+	b.WriteString("\taccountGrace = grace\n")
+	return b.String()
+}
+
+// accountMailerAssign returns the statement of main handing the mailer
+// service to the account notices, if an @account model sends them
+func (g *Generator) accountMailerAssign(file *ast.GMXFile) string {
+	if !g.hasAccounts(file) {
+		return ""
+	}
+	return fmt.Sprintf("\taccountMailer = %sSvc\n", utils.LowerFirst(g.findLockoutMailer(file).Name))
+}
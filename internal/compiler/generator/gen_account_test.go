@@ -0,0 +1,180 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// accountTestFile returns the lockout test file, whose mailer sends the
+// account notices, with an @account User model and a Note related to it
+func accountTestFile() *ast.GMXFile {
+	file := lockoutTestFile(true)
+	file.Models = []*ast.ModelDecl{
+		{
+			Name:        "User",
+			Annotations: []*ast.Annotation{{Name: "account", Args: map[string]string{"_": "email"}}},
+			Fields: []*ast.FieldDecl{
+				{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{{Name: "pk"}, {Name: "default", Args: map[string]string{"_": "uuid_v4"}}}},
+				{Name: "email", Type: "string", Annotations: []*ast.Annotation{{Name: "email"}, {Name: "unique"}}},
+			},
+		},
+		{
+			Name: "Note",
+			Fields: []*ast.FieldDecl{
+				{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{{Name: "pk"}, {Name: "default", Args: map[string]string{"_": "uuid_v4"}}}},
+				{Name: "userId", Type: "uuid"},
+				{Name: "user", Type: "User", Annotations: []*ast.Annotation{{Name: "relation", Args: map[string]string{"references": "[id]"}}}},
+			},
+		},
+	}
+	file.Template = &ast.TemplateBlock{Source: `{{accountSettings}}`}
+	return file
+}
+
+func TestGenerator_Accounts(t *testing.T) {
+	file := accountTestFile()
+	file.Services[0].Fields = append(file.Services[0].Fields, &ast.ServiceField{Name: "deletionGrace", Type: "string", EnvVar: "DELETION_GRACE"})
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	expected := []string{
+		"type AccountDeletion struct {",
+		"\tmux.HandleFunc(\"/_gmx/account/email/confirm\", handleAccountEmailConfirm)\n",
+		"\tmux.HandleFunc(\"/_gmx/account/delete\", handleAccountDelete)\n",
+		"\t\t\"accountSettings\": func() template.HTML {\n",
+		// The grace period comes from the session service, the mailer from main
+		"\tgrace, err := time.ParseDuration(cfg.DeletionGrace)\n",
+		"\taccountMailer = mailerSvc\n",
+		// The sessions of accounts pending deletion close; signing in keeps the account
+		"\treturn activeSession(decodeSession(string(raw)))\n",
+		"\trestoreAccount(userID)\n",
+		// The change link is single-use: it moves the account from the address it names
+		"Where(map[string]any{\"id\": user, \"email\": from}).Update(\"email\", to)",
+		// The purge runs hourly and deletes the related records first
+		"\t{\"purgeAccounts\", cronSchedule{",
+		"tx.Where(map[string]any{\"user_id\": user}).Delete(&Note{})",
+		"\tstartSchedules()\n",
+	}
+	for _, exp := range expected {
+		if !strings.Contains(code, exp) {
+			t.Errorf("Generated code missing %q", exp)
+		}
+	}
+}
+
+func TestGenerator_AccountsWithoutDeletionGrace(t *testing.T) {
+	code, err := New().Generate(accountTestFile())
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+	if !strings.Contains(code, "var accountGrace = 30 * 24 * time.Hour") {
+		t.Error("Generated code missing the default grace period")
+	}
+	if strings.Contains(code, "cfg.DeletionGrace") {
+		t.Error("configure should not read a deletionGrace field the service does not declare")
+	}
+}
+
+func TestGenerator_AccountErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(file *ast.GMXFile)
+		wantErr string
+	}{
+		{
+			name: "without field name",
+			modify: func(file *ast.GMXFile) {
+				file.Models[0].Annotations[0].Args = map[string]string{}
+			},
+			wantErr: "model User: @account names the email field of the account, as in @account(email)",
+		},
+		{
+			name: "unknown field",
+			modify: func(file *ast.GMXFile) {
+				file.Models[0].Annotations[0].Args["_"] = "mail"
+			},
+			wantErr: "model User: @account(mail) names no field of the model",
+		},
+		{
+			name: "field without @email",
+			modify: func(file *ast.GMXFile) {
+				file.Models[0].Fields[1].Annotations = nil
+			},
+			wantErr: "model User: @account(email) requires a string field with @email",
+		},
+		{
+			name: "integer key",
+			modify: func(file *ast.GMXFile) {
+				file.Models[0].Fields[0].Type = "int"
+				file.Models[0].Fields[0].Annotations = []*ast.Annotation{{Name: "pk"}}
+			},
+			wantErr: "model User: @account requires a uuid or string primary key",
+		},
+		{
+			name: "without mailer",
+			modify: func(file *ast.GMXFile) {
+				file.Services = file.Services[:1]
+			},
+			wantErr: "model User: @account requires an smtp service with a send method",
+		},
+		{
+			name: "without session service",
+			modify: func(file *ast.GMXFile) {
+				file.Services = file.Services[1:]
+				file.Script.Funcs = file.Script.Funcs[:1]
+			},
+			wantErr: "model User: @account requires a service with provider \"session\"",
+		},
+		{
+			name: "two account models",
+			modify: func(file *ast.GMXFile) {
+				file.Models[1].Annotations = []*ast.Annotation{{Name: "account", Args: map[string]string{"_": "email"}}}
+			},
+			wantErr: "model Note: only one model is @account, and User already is",
+		},
+		{
+			name: "colliding model",
+			modify: func(file *ast.GMXFile) {
+				file.Models[1].Name = "AccountDeletion"
+			},
+			wantErr: "model AccountDeletion collides with the model storing account deletions",
+		},
+		{
+			name: "colliding function",
+			modify: func(file *ast.GMXFile) {
+				file.Script.Funcs[0].Name = "accountDelete"
+			},
+			wantErr: "function accountDelete collides with the built-in /_gmx/account/delete endpoint",
+		},
+		{
+			name: "deletion grace without @account",
+			modify: func(file *ast.GMXFile) {
+				file.Models[0].Annotations = nil
+				file.Template = &ast.TemplateBlock{Source: `<p>Hello</p>`}
+				file.Services[0].Fields = append(file.Services[0].Fields, &ast.ServiceField{Name: "deletionGrace", Type: "string"})
+			},
+			wantErr: "service Auth: deletionGrace applies to an @account model",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := accountTestFile()
+			tt.modify(file)
+			_, err := New().Generate(file)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package generator
+
+import (
+	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+	"strings"
+)
+
+// isPIIField reports whether a field is marked @pii or @sensitive
+func isPIIField(field *ast.FieldDecl) bool {
+	for _, ann := range field.Annotations {
+		if ann.Name == "pii" || ann.Name == "sensitive" {
+			return true
+		}
+	}
+	return false
+}
+
+// piiFields returns the @pii/@sensitive fields of a model
+func piiFields(model *ast.ModelDecl) []*ast.FieldDecl {
+	var fields []*ast.FieldDecl
+	for _, field := range model.Fields {
+		if isPIIField(field) {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// hasPIIFields checks if any model declares @pii or @sensitive fields
+func (g *Generator) hasPIIFields(file *ast.GMXFile) bool {
+	return g.hasFieldMatch(file, isPIIField)
+}
+
+// fakeKind picks the kind of fake data used to replace a sensitive field,
+// from its type, annotations and name
+func fakeKind(field *ast.FieldDecl) (string, error) {
+	switch field.Type {
+	case "int":
+		return "int", nil
+	case "float":
+		return "float", nil
+	case "datetime":
+		return "date", nil
+	case "string", "password":
+	default:
+		return "", fmt.Errorf("cannot anonymize %s field %q", field.Type, field.Name)
+	}
+
+	for _, ann := range field.Annotations {
+		if ann.Name == "email" {
+			return "email", nil
+		}
+	}
+
+	name := strings.ToLower(field.Name)
+	switch {
+	case strings.Contains(name, "email"):
+		return "email", nil
+	case strings.Contains(name, "firstname"):
+		return "firstName", nil
+	case strings.Contains(name, "lastname"), strings.Contains(name, "surname"):
+		return "lastName", nil
+	case strings.Contains(name, "name"):
+		return "fullName", nil
+	case strings.Contains(name, "phone"):
+		return "phone", nil
+	case strings.Contains(name, "address"), strings.Contains(name, "street"):
+		return "address", nil
+	case strings.Contains(name, "city"):
+		return "city", nil
+	}
+	return "text", nil
+}
+
+// validatePIIFields checks that every sensitive field can be anonymized in place
+func (g *Generator) validatePIIFields(file *ast.GMXFile) error {
+	for _, model := range file.Models {
+		fields := piiFields(model)
+		if len(fields) == 0 {
+			continue
+		}
+		if modelPKField(model) == nil {
+			return fmt.Errorf("model %s: @pii fields require a @pk field to be anonymized", model.Name)
+		}
+		for _, field := range fields {
+			if _, err := fakeKind(field); err != nil {
+				return fmt.Errorf("model %s: %w", model.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// genAnonymizer generates the `anonymize` admin task, which rewrites every
+// @pii/@sensitive field with realistic fake data, batch by batch
+func (g *Generator) genAnonymizer(models []*ast.ModelDecl) string {
+	var b strings.Builder
+
+	b.WriteString("var (\n")
+	b.WriteString("\tfakeFirstNames = []string{\"Alice\", \"Bruno\", \"Chloé\", \"David\", \"Emma\", \"Farid\", \"Gabrielle\", \"Hugo\", \"Inès\", \"Jules\", \"Karim\", \"Léa\"}\n")
+	b.WriteString("\tfakeLastNames  = []string{\"Martin\", \"Bernard\", \"Dubois\", \"Thomas\", \"Robert\", \"Richard\", \"Petit\", \"Durand\", \"Leroy\", \"Moreau\"}\n")
+	b.WriteString("\tfakeCities     = []string{\"Paris\", \"Lyon\", \"Marseille\", \"Toulouse\", \"Nantes\", \"Lille\", \"Bordeaux\", \"Rennes\"}\n")
+	b.WriteString("\tfakeWords      = []string{\"lorem\", \"ipsum\", \"dolor\", \"sit\", \"amet\", \"consectetur\", \"adipiscing\", \"elit\", \"sed\", \"do\"}\n")
+	b.WriteString(")\n\n")
+
+	b.WriteString("// fakeValue returns fake data of the given kind; n keeps generated values unique\n")
+	b.WriteString("func fakeValue(kind string, n int) interface{} {\n")
+	b.WriteString("\tpick := func(values []string) string { return values[mrand.IntN(len(values))] }\n")
+	b.WriteString("\tswitch kind {\n")
+	b.WriteString("\tcase \"email\":\n")
+	b.WriteString("\t\treturn fmt.Sprintf(\"user%d@example.com\", n)\n")
+	b.WriteString("\tcase \"firstName\":\n")
+	b.WriteString("\t\treturn pick(fakeFirstNames)\n")
+	b.WriteString("\tcase \"lastName\":\n")
+	b.WriteString("\t\treturn pick(fakeLastNames)\n")
+	b.WriteString("\tcase \"fullName\":\n")
+	b.WriteString("\t\treturn pick(fakeFirstNames) + \" \" + pick(fakeLastNames)\n")
+	b.WriteString("\tcase \"phone\":\n")
+	b.WriteString("\t\treturn fmt.Sprintf(\"+33 6 %02d %02d %02d %02d\", mrand.IntN(100), mrand.IntN(100), mrand.IntN(100), n%100)\n")
+	b.WriteString("\tcase \"address\":\n")
+	b.WriteString("\t\treturn fmt.Sprintf(\"%d rue %s\", 1+mrand.IntN(200), pick(fakeLastNames))\n")
+	b.WriteString("\tcase \"city\":\n")
+	b.WriteString("\t\treturn pick(fakeCities)\n")
+	b.WriteString("\tcase \"int\":\n")
+	b.WriteString("\t\treturn mrand.IntN(1000)\n")
+	b.WriteString("\tcase \"float\":\n")
+	b.WriteString("\t\treturn mrand.Float64() * 1000\n")
+	b.WriteString("\tcase \"date\":\n")
+	b.WriteString("\t\treturn time.Now().AddDate(0, 0, -mrand.IntN(3650))\n")
+	b.WriteString("\tdefault:\n")
+	b.WriteString("\t\treturn fmt.Sprintf(\"%s %s %s %d\", pick(fakeWords), pick(fakeWords), pick(fakeWords), n)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// anonymizeDatabase rewrites every @pii/@sensitive field with fake data\n")
+	b.WriteString("func anonymizeDatabase() error {\n")
+	b.WriteString("\tif os.Getenv(\"GMX_ENV\") == \"production\" {\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"refusing to anonymize a production database (GMX_ENV=production)\")\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\tn := 0\n")
+
+	for _, model := range models {
+		fields := piiFields(model)
+		if len(fields) == 0 {
+			continue
+		}
+		plural := utils.LowerFirst(model.Name) + "s"
+		b.WriteString(fmt.Sprintf("\tvar %s []%s\n", plural, model.Name))
+		b.WriteString(fmt.Sprintf("\tif err := db.FindInBatches(&%s, 500, func(tx *gorm.DB, batch int) error {\n", plural))
+		b.WriteString(fmt.Sprintf("\t\tfor i := range %s {\n", plural))
+		b.WriteString("\t\t\tn++\n")
+		b.WriteString("\t\t\tupdates := map[string]interface{}{\n")
+		for _, field := range fields {
+			kind, _ := fakeKind(field) // validated in validatePIIFields
+			b.WriteString(fmt.Sprintf("\t\t\t\t%q: fakeValue(%q, n),\n", utils.ToPascalCase(field.Name), kind))
+		}
+		b.WriteString("\t\t\t}\n")
+		b.WriteString(fmt.Sprintf("\t\t\tif err := db.Model(&%s[i]).UpdateColumns(updates).Error; err != nil {\n", plural))
+		b.WriteString("\t\t\t\treturn err\n")
+		b.WriteString("\t\t\t}\n")
+		b.WriteString("\t\t}\n")
+		b.WriteString("\t\treturn nil\n")
+		b.WriteString("\t}).Error; err != nil {\n")
+		b.WriteString(fmt.Sprintf("\t\treturn fmt.Errorf(\"anonymizing %s: %%w\", err)\n", model.Name))
+		b.WriteString("\t}\n")
+	}
+
+	b.WriteString("\n\tslog.Info(\"database anonymized\", \"records\", n)\n")
+	b.WriteString("\treturn nil\n")
+	b.WriteString("}\n")
+
+	return b.String()
+}
@@ -0,0 +1,111 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+func TestFakeKind(t *testing.T) {
+	ann := func(name string) []*ast.Annotation { return []*ast.Annotation{{Name: name, Args: map[string]string{}}} }
+
+	tests := []struct {
+		field    *ast.FieldDecl
+		expected string
+	}{
+		{&ast.FieldDecl{Name: "contact", Type: "string", Annotations: ann("email")}, "email"},
+		{&ast.FieldDecl{Name: "workEmail", Type: "string"}, "email"},
+		{&ast.FieldDecl{Name: "firstName", Type: "string"}, "firstName"},
+		{&ast.FieldDecl{Name: "lastName", Type: "string"}, "lastName"},
+		{&ast.FieldDecl{Name: "displayName", Type: "string"}, "fullName"},
+		{&ast.FieldDecl{Name: "phone", Type: "string"}, "phone"},
+		{&ast.FieldDecl{Name: "streetAddress", Type: "string"}, "address"},
+		{&ast.FieldDecl{Name: "city", Type: "string"}, "city"},
+		{&ast.FieldDecl{Name: "bio", Type: "string"}, "text"},
+		{&ast.FieldDecl{Name: "age", Type: "int"}, "int"},
+		{&ast.FieldDecl{Name: "salary", Type: "float"}, "float"},
+		{&ast.FieldDecl{Name: "birthDate", Type: "datetime"}, "date"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.field.Name, func(t *testing.T) {
+			kind, err := fakeKind(tt.field)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if kind != tt.expected {
+				t.Errorf("fakeKind(%s) = %q, want %q", tt.field.Name, kind, tt.expected)
+			}
+		})
+	}
+
+	if _, err := fakeKind(&ast.FieldDecl{Name: "active", Type: "bool"}); err == nil {
+		t.Error("expected error for bool field")
+	}
+}
+
+func TestGenerator_Anonymizer(t *testing.T) {
+	pii := []*ast.Annotation{{Name: "pii", Args: map[string]string{}}}
+	file := &ast.GMXFile{
+		Models: []*ast.ModelDecl{
+			{
+				Name: "Customer",
+				Fields: []*ast.FieldDecl{
+					{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{{Name: "pk", Args: map[string]string{}}}},
+					{Name: "fullName", Type: "string", Annotations: pii},
+					{Name: "phone", Type: "string", Annotations: []*ast.Annotation{{Name: "sensitive", Args: map[string]string{}}}},
+					{Name: "plan", Type: "string"},
+				},
+			},
+			{
+				Name:   "Plan",
+				Fields: []*ast.FieldDecl{{Name: "label", Type: "string"}},
+			},
+		},
+	}
+
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	expected := []string{
+		"func anonymizeDatabase() error",
+		`if os.Getenv("GMX_ENV") == "production" {`,
+		"db.FindInBatches(&customers, 500,",
+		`"FullName": fakeValue("fullName", n),`,
+		`"Phone":    fakeValue("phone", n),`,
+		`if len(os.Args) > 1 && os.Args[1] == "anonymize" {`,
+		`mrand "math/rand/v2"`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(code, exp) {
+			t.Errorf("Generated code missing %q", exp)
+		}
+	}
+	if strings.Contains(code, `"Plan":`) || strings.Contains(code, "&plans") {
+		t.Error("fields without @pii should not be anonymized")
+	}
+}
+
+func TestGenerator_AnonymizerRequiresPrimaryKey(t *testing.T) {
+	file := &ast.GMXFile{
+		Models: []*ast.ModelDecl{
+			{
+				Name: "Customer",
+				Fields: []*ast.FieldDecl{
+					{Name: "fullName", Type: "string", Annotations: []*ast.Annotation{{Name: "pii", Args: map[string]string{}}}},
+				},
+			},
+		},
+	}
+
+	_, err := New().Generate(file)
+	if err == nil || !strings.Contains(err.Error(), "require a @pk field") {
+		t.Errorf("expected missing @pk error, got %v", err)
+	}
+}
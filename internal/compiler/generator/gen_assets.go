@@ -0,0 +1,190 @@
+package generator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/resolver"
+	"sort"
+	"strings"
+)
+
+// appCSSPath serves the stylesheet bundled from the page and component styles
+const appCSSPath = "/assets/app.css"
+
+// bundleStyles aggregates the page styles and the component styles of a
+// multi-file build into one stylesheet, keeping the first copy of each rule;
+// it returns "" for single-file builds and builds without styles
+func (g *Generator) bundleStyles(file *ast.GMXFile, components map[string]*resolver.ComponentInfo) string {
+	if len(components) == 0 && len(file.Pages) == 0 {
+		return ""
+	}
+
+	sources := []string{}
+	if file.Style != nil {
+		sources = append(sources, file.Style.Source)
+	}
+	for _, page := range file.Pages {
+		if page.Style != nil {
+			sources = append(sources, page.Style.Source)
+		}
+	}
+	names := make([]string, 0, len(components))
+	for name := range components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if style := components[name].File.Style; style != nil {
+			sources = append(sources, style.Source)
+		}
+	}
+
+	var b strings.Builder
+	seen := make(map[string]bool)
+	for _, src := range sources {
+		for _, rule := range splitCSSRules(src) {
+			key := strings.Join(strings.Fields(rule), " ")
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			b.WriteString(rule)
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// splitCSSRules splits a stylesheet into its top-level rules and at-rules,
+// dropping the comments between them
+func splitCSSRules(src string) []string {
+	var rules []string
+	depth, start := 0, -1
+	var quote byte
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := strings.Index(src[i+2:], "*/")
+			if end == -1 {
+				i = len(src)
+			} else {
+				i += end + 3
+			}
+			continue
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '{':
+			depth++
+		case c == '}':
+			if depth > 0 {
+				depth--
+			}
+		}
+
+		if start == -1 && c > ' ' {
+			start = i
+		}
+		if start != -1 && depth == 0 && (c == '}' || c == ';') {
+			rules = append(rules, strings.TrimSpace(src[start:i+1]))
+			start = -1
+		}
+	}
+	if start != -1 {
+		if rest := strings.TrimSpace(src[start:]); rest != "" {
+			rules = append(rules, rest)
+		}
+	}
+	return rules
+}
+
+// cssHash returns the content hash versioning the bundled stylesheet URL
+func cssHash(css string) string {
+	sum := sha256.Sum256([]byte(css))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// styleSheets holds the styles of a multi-file page: critical rules inlined
+// in the page, and the bundle served at appCSSPath
+type styleSheets struct {
+	critical string
+	bundle   string
+}
+
+// empty reports whether the page has no multi-file styles
+func (s styleSheets) empty() bool {
+	return s.critical == "" && s.bundle == ""
+}
+
+// headTags returns the <head> tags loading the styles; with critical rules
+// inlined, the bundle is preloaded and applied once downloaded
+func (s styleSheets) headTags(indent string) string {
+	var b strings.Builder
+	if s.critical != "" {
+		b.WriteString(indent + "<style>\n")
+		b.WriteString(indent + "/* GMX Critical Styles */\n")
+		b.WriteString(indent + s.critical + "\n")
+		b.WriteString(indent + "</style>\n")
+	}
+	if s.bundle == "" {
+		return b.String()
+	}
+	href := fmt.Sprintf("%s?v=%s", appCSSPath, cssHash(s.bundle))
+	if s.critical != "" {
+		b.WriteString(fmt.Sprintf("%s<link rel=\"preload\" href=\"%s\" as=\"style\" onload=\"this.onload=null;this.rel='stylesheet'\">\n", indent, href))
+		b.WriteString(fmt.Sprintf("%s<noscript><link rel=\"stylesheet\" href=\"%s\"></noscript>\n", indent, href))
+	} else {
+		b.WriteString(fmt.Sprintf("%s<link rel=\"stylesheet\" href=\"%s\">\n", indent, href))
+	}
+	return b.String()
+}
+
+// genStyleBundle generates the bundled stylesheet and its handler; the
+// versioned URL is cached for good, the bare one is revalidated by ETag
+func (g *Generator) genStyleBundle(css string) string {
+	var b strings.Builder
+
+	b.WriteString("// appCSS is the stylesheet bundled from the page and component styles\n")
+	b.WriteString("const appCSS = ")
+	b.WriteString(escapeTemplateString(css))
+	b.WriteString("\n\n")
+	b.WriteString("// appCSSHash is the content hash versioning the stylesheet URL\n")
+	b.WriteString(fmt.Sprintf("const appCSSHash = %q\n\n", cssHash(css)))
+
+	b.WriteString(fmt.Sprintf("// handleAppCSS serves the bundled stylesheet at %s\n", appCSSPath))
+	b.WriteString("func handleAppCSS(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString("\tif r.Method != http.MethodGet && r.Method != http.MethodHead {\n")
+	b.WriteString("\t\thttp.Error(w, \"Method Not Allowed\", http.StatusMethodNotAllowed)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\tetag := `\"` + appCSSHash + `\"`\n")
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/css; charset=utf-8\")\n")
+	b.WriteString("\tw.Header().Set(\"ETag\", etag)\n")
+	b.WriteString("\tif r.URL.Query().Get(\"v\") == appCSSHash {\n")
+	b.WriteString("\t\tw.Header().Set(\"Cache-Control\", \"public, max-age=31536000, immutable\")\n")
+	b.WriteString("\t} else {\n")
+	b.WriteString("\t\tw.Header().Set(\"Cache-Control\", \"no-cache\")\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif r.Header.Get(\"If-None-Match\") == etag {\n")
+	b.WriteString("\t\tw.WriteHeader(http.StatusNotModified)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif r.Method == http.MethodHead {\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif _, err := w.Write([]byte(appCSS)); err != nil {\n")
+	b.WriteString(fmt.Sprintf("\t\trequestLogger(r).Error(\"serving %s\", \"error\", err)\n", appCSSPath))
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
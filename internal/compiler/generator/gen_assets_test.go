@@ -0,0 +1,108 @@
+package generator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/resolver"
+)
+
+func TestSplitCSSRules(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{
+			name: "rules",
+			src:  ".a { color: red; }\n.b { color: blue; }",
+			want: []string{".a { color: red; }", ".b { color: blue; }"},
+		},
+		{
+			name: "comments between rules are dropped",
+			src:  "/* header */\n.a { color: red; }\n/* b */ .b {}",
+			want: []string{".a { color: red; }", ".b {}"},
+		},
+		{
+			name: "at-rules",
+			src:  "@import url(\"x.css\");\n@media (min-width: 40rem) { .a { padding: 0; } }",
+			want: []string{"@import url(\"x.css\");", "@media (min-width: 40rem) { .a { padding: 0; } }"},
+		},
+		{
+			name: "braces in strings",
+			src:  ".a::after { content: \"}\"; }",
+			want: []string{".a::after { content: \"}\"; }"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitCSSRules(tt.src); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitCSSRules() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func styleBundleTestFile() *resolver.ResolvedFile {
+	component := func(style string) *resolver.ComponentInfo {
+		return &resolver.ComponentInfo{File: &ast.GMXFile{
+			Template: &ast.TemplateBlock{Source: "<div class=\"card\">{{.}}</div>"},
+			Style:    &ast.StyleBlock{Source: style},
+		}}
+	}
+	return &resolver.ResolvedFile{
+		Main: &ast.GMXFile{
+			Template: &ast.TemplateBlock{Source: "<main>{{template \"Card\" .}}</main>"},
+			Style:    &ast.StyleBlock{Source: "main { margin: 0 auto; }"},
+		},
+		Components: map[string]*resolver.ComponentInfo{
+			"Card":  component(".card { padding: 1rem; }"),
+			"Badge": component(".card {\n  padding: 1rem;\n}\n.badge { color: red; }"),
+		},
+	}
+}
+
+func TestBundleStyles(t *testing.T) {
+	resolved := styleBundleTestFile()
+	got := New().bundleStyles(resolved.Main, resolved.Components)
+	want := "main { margin: 0 auto; }\n.card {\n  padding: 1rem;\n}\n.badge { color: red; }\n"
+	if got != want {
+		t.Errorf("bundleStyles() = %q, want %q", got, want)
+	}
+
+	if New().bundleStyles(resolved.Main, nil) != "" {
+		t.Error("single-file builds should keep their inline style")
+	}
+}
+
+func TestGenerateResolvedStyleBundle(t *testing.T) {
+	code, err := New().GenerateResolved(styleBundleTestFile())
+	if err != nil {
+		t.Fatalf("GenerateResolved failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	expected := []string{
+		"const appCSS = ",
+		"const appCSSHash = ",
+		`<link rel="stylesheet" href="/assets/app.css?v=`,
+		"func handleAppCSS(w http.ResponseWriter, r *http.Request)",
+		`mux.HandleFunc("/assets/app.css", handleAppCSS)`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(code, exp) {
+			t.Errorf("Generated code missing %q", exp)
+		}
+	}
+	if strings.Contains(code, "<style>") {
+		t.Error("component styles should not be inlined in the page")
+	}
+	if strings.Count(code, "padding: 1rem") != 1 {
+		t.Error("duplicated component rules should be bundled once")
+	}
+}
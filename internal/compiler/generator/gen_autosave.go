@@ -0,0 +1,351 @@
+package generator
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// draftModel is the generated model storing the drafts of the @autosave forms
+const draftModel = "Draft"
+
+// autosavePathPrefix is the prefix of the built-in endpoints restoring and saving drafts
+const autosavePathPrefix = "/_gmx/autosave/"
+
+// autosaveCall matches {{autosave "func"}} calls
+var autosaveCall = regexp.MustCompile(`\{\{-?\s*autosave\s+"([^"]*)"`)
+
+// autosaveScript marks the forms edited since their last save, which the
+// autosave element polls, and fills the fields of a form from its restored draft
+const autosaveScript = `<script>
+(function() {
+  document.addEventListener('input', function(e) {
+    var form = e.target.form;
+    if (form && form.querySelector('.gmx-autosave')) form.dataset.gmxDirty = 'true';
+  });
+  document.addEventListener('htmx:afterRequest', function(e) {
+    var elt = e.detail.elt;
+    if (elt.classList.contains('gmx-autosave') && e.detail.successful) delete elt.closest('form').dataset.gmxDirty;
+  });
+  document.addEventListener('htmx:load', function(e) {
+    var elt = e.detail.elt;
+    if (!elt.classList || !elt.classList.contains('gmx-autosave') || !elt.dataset.gmxDraft) return;
+    var draft = new URLSearchParams(elt.dataset.gmxDraft);
+    Array.prototype.forEach.call(elt.closest('form').elements, function(field) {
+      if (!field.name || !draft.has(field.name)) return;
+      var values = draft.getAll(field.name);
+      if (field.type === 'checkbox' || field.type === 'radio') {
+        field.checked = values.indexOf(field.value) !== -1;
+      } else if (field.type !== 'file' && field.type !== 'hidden') {
+        field.value = values[0];
+      }
+    });
+  });
+})();
+</script>`
+
+// hasAutosave checks if a script function keeps drafts of its forms with @autosave
+func (g *Generator) hasAutosave(file *ast.GMXFile) bool {
+	return g.hasFuncAnnotation(file, "autosave")
+}
+
+// autosaveInterval returns the interval between the saves of an @autosave(30s) draft
+func autosaveInterval(fn *ast.FuncDecl) (time.Duration, error) {
+	raw := fn.FindAnnotation("autosave").SimpleArg()
+	d, err := time.ParseDuration(raw)
+	if err != nil || d < time.Second {
+		return 0, fmt.Errorf("invalid autosave interval %q (expected a duration of at least 1s, such as 30s)", raw)
+	}
+	return d, nil
+}
+
+// htmxInterval renders a duration in the units of an htmx every trigger
+func htmxInterval(d time.Duration) string {
+	if d%time.Second == 0 {
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+	return fmt.Sprintf("%dms", d/time.Millisecond)
+}
+
+// autosaveHandler returns the name of the handler restoring the draft of a form
+func autosaveHandler(fn *ast.FuncDecl) string {
+	return "handle" + utils.Capitalize(fn.Name) + "Autosave"
+}
+
+// autosaveRoutes returns the endpoints restoring and saving the drafts of the @autosave functions
+func (g *Generator) autosaveRoutes(file *ast.GMXFile) []Route {
+	var routes []Route
+	for _, fn := range g.funcsWithAnnotation(file, "autosave") {
+		routes = append(routes,
+			Route{Method: "GET", Path: autosavePathPrefix + fn.Name, Handler: autosaveHandler(fn)},
+			Route{Method: "POST", Path: autosavePathPrefix + fn.Name + "/save", Handler: autosaveHandler(fn) + "Save"})
+	}
+	return routes
+}
+
+// autosaveFields returns the parameters of a function stored in its drafts;
+// uploaded files and passwords are not kept
+func autosaveFields(fn *ast.FuncDecl) []string {
+	var fields []string
+	for _, param := range fn.Params {
+		if param.Type != "bytes" && !isSecretParam(param) {
+			fields = append(fields, param.Name)
+		}
+	}
+	return fields
+}
+
+// isSecretParam reports whether a parameter carries a password or a secret,
+// by its type or its name
+func isSecretParam(param *ast.Param) bool {
+	name := strings.ToLower(param.Name)
+	return param.Type == "password" || strings.Contains(name, "password") || strings.Contains(name, "secret")
+}
+
+// validateAutosave checks that the @autosave functions are form handlers
+// whose drafts can be stored and restored
+func (g *Generator) validateAutosave(file *ast.GMXFile) error {
+	funcs := g.funcsWithAnnotation(file, "autosave")
+	forms := make(map[string]bool)
+	for _, fn := range funcs {
+		forms[fn.Name] = true
+		if _, err := autosaveInterval(fn); err != nil {
+			return fmt.Errorf("function %s: %w", fn.Name, err)
+		}
+		if fn.ReturnType != "" && fn.ReturnType != "error" {
+			return fmt.Errorf("function %s: @autosave applies to handlers, functions returning error", fn.Name)
+		}
+		if method := handlerMethod(fn); method == "Get" || method == "Delete" {
+			return fmt.Errorf("function %s: @autosave applies to form handlers, not %s requests", fn.Name, strings.ToUpper(method))
+		}
+		if fn.FindAnnotation("signed") != nil {
+			return fmt.Errorf("function %s: @autosave does not apply to @signed links", fn.Name)
+		}
+		// A sign-in form would keep its credentials in the draft
+		if fn.FindAnnotation("login") != nil {
+			return fmt.Errorf("function %s: @autosave does not apply to @login forms", fn.Name)
+		}
+		if len(autosaveFields(fn)) == 0 {
+			return fmt.Errorf("function %s: @autosave requires parameters other than bytes and passwords, the fields stored in the draft", fn.Name)
+		}
+		if file.Template == nil {
+			return fmt.Errorf("function %s: @autosave requires a <template> section with its form", fn.Name)
+		}
+	}
+	if len(funcs) > 0 {
+		for _, model := range file.Models {
+			if model.Name == draftModel {
+				return fmt.Errorf("line %d: model %s collides with the model storing the autosaved drafts; rename it", model.Line, model.Name)
+			}
+		}
+		routes := g.autosaveRoutes(file)
+		for _, fn := range file.Script.Funcs {
+			for _, route := range routes {
+				if "handle"+utils.Capitalize(fn.Name) == route.Handler {
+					return fmt.Errorf("line %d: function %s collides with the built-in %s endpoint; rename it", fn.Line, fn.Name, route.Path)
+				}
+			}
+		}
+	}
+
+	for _, src := range pageTemplateSources(file) {
+		for _, m := range autosaveCall.FindAllStringSubmatch(src, -1) {
+			if !forms[m[1]] {
+				return fmt.Errorf("template: {{autosave %q}} is not a script function with @autosave", m[1])
+			}
+		}
+	}
+	return nil
+}
+
+// withDraftModel returns the file with the model storing the drafts of its
+// @autosave forms, so that it is declared and migrated like the others
+func (g *Generator) withDraftModel(file *ast.GMXFile) *ast.GMXFile {
+	if !g.hasAutosave(file) {
+		return file
+	}
+	withModel := *file
+	withModel.Models = append(append([]*ast.ModelDecl{}, file.Models...), &ast.ModelDecl{
+		Name: draftModel,
+		Fields: []*ast.FieldDecl{
+			{Name: "id", Type: "string", Annotations: []*ast.Annotation{
+				{Name: "pk", Args: map[string]string{}},
+			}},
+			{Name: "data", Type: "string"},
+			{Name: "updatedAt", Type: "datetime"},
+		},
+	})
+	return &withModel
+}
+
+// injectAutosaveFields adds {{autosave "func"}} to every form posting to an
+// @autosave function, unless the template already places it explicitly
+func injectAutosaveFields(src string, funcs []*ast.FuncDecl) string {
+	for _, fn := range funcs {
+		call := fmt.Sprintf("{{autosave %q}}", fn.Name)
+		if strings.Contains(src, call) {
+			continue
+		}
+		re := regexp.MustCompile(`<form\b[^>]*\{\{\s*route\s+["` + "`" + `]` + regexp.QuoteMeta(fn.Name) + `["` + "`" + `]\s*\}\}[^>]*>`)
+		src = re.ReplaceAllString(src, "${0}"+call)
+	}
+	return src
+}
+
+// injectAutosaveScript adds the script restoring the drafts at the end of a page
+func injectAutosaveScript(src string) string {
+	if idx := strings.LastIndex(strings.ToLower(src), "</body>"); idx != -1 {
+		return src[:idx] + autosaveScript + "\n" + src[idx:]
+	}
+	return src + "\n" + autosaveScript
+}
+
+// genAutosave generates the {{autosave}} template function, the endpoints
+// restoring and saving the drafts of each @autosave form, and the cleanup of
+// stale drafts
+func (g *Generator) genAutosave(file *ast.GMXFile) string {
+	var b strings.Builder
+	funcs := g.funcsWithAnnotation(file, "autosave")
+
+	b.WriteString("// autosaveTTL is how long a draft is kept after its last save\n")
+	b.WriteString("const autosaveTTL = 7 * 24 * time.Hour\n\n")
+
+	b.WriteString("// autosaveCookie names the drafts of a browser\n")
+	b.WriteString("const autosaveCookie = \"gmx_autosave\"\n\n")
+
+	b.WriteString("// autosaveForms maps the @autosave functions to the htmx interval of\n")
+	b.WriteString("// their saves and the fields stored in their drafts\n")
+	b.WriteString("var autosaveForms = map[string]struct {\n")
+	b.WriteString("\tInterval string\n")
+	b.WriteString("\tFields   []string\n")
+	b.WriteString("}{\n")
+	for _, fn := range funcs {
+		interval, _ := autosaveInterval(fn) // validated in validateAutosave
+		fields := make([]string, 0, len(fn.Params))
+		for _, field := range autosaveFields(fn) {
+			fields = append(fields, fmt.Sprintf("%q", field))
+		}
+		b.WriteString(fmt.Sprintf("\t%q: {%q, []string{%s}},\n", fn.Name, htmxInterval(interval), strings.Join(fields, ", ")))
+	}
+	b.WriteString("}\n\n")
+
+	b.WriteString("// autosaveLoader returns the placeholder restoring the draft of a form\n")
+	b.WriteString("func autosaveLoader(form string) (template.HTML, error) {\n")
+	b.WriteString("\tif _, ok := autosaveForms[form]; !ok {\n")
+	b.WriteString("\t\treturn \"\", fmt.Errorf(\"autosave: %s is not an @autosave function\", form)\n")
+	b.WriteString("\t}\n")
+	b.WriteString(fmt.Sprintf("\treturn template.HTML(`<div hx-get=\"%s` + form + `\" hx-trigger=\"load\" hx-target=\"this\" hx-swap=\"outerHTML\"></div>`), nil\n", autosavePathPrefix))
+	b.WriteString("}\n\n")
+
+	b.WriteString("// autosaveOwner returns the value of the autosave cookie, which names the\n")
+	b.WriteString("// drafts of the browser, setting a new one when it is missing\n")
+	b.WriteString("func autosaveOwner(w http.ResponseWriter, r *http.Request) string {\n")
+	b.WriteString("\tif cookie, err := r.Cookie(autosaveCookie); err == nil && cookie.Value != \"\" {\n")
+	b.WriteString("\t\treturn cookie.Value\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tid := make([]byte, 16)\n")
+	b.WriteString("\trand.Read(id)\n")
+	b.WriteString("\tvalue := fmt.Sprintf(\"%x\", id)\n")
+	b.WriteString("\thttp.SetCookie(w, &http.Cookie{\n")
+	b.WriteString("\t\tName:     autosaveCookie,\n")
+	b.WriteString("\t\tValue:    value,\n")
+	b.WriteString("\t\tPath:     \"/\",\n")
+	b.WriteString("\t\tMaxAge:   int(autosaveTTL.Seconds()),\n")
+	b.WriteString("\t\tHttpOnly: true,\n")
+	b.WriteString("\t\tSameSite: http.SameSiteLaxMode,\n")
+	b.WriteString("\t})\n")
+	b.WriteString("\treturn value\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// autosaveDraft returns the id of the draft of a form in a browser\n")
+	if g.findSessionService(file.Services) != nil {
+		// A shared browser does not hand the drafts of a user to the next one
+		b.WriteString("// for its signed-in user, if any\n")
+		b.WriteString("func autosaveDraft(r *http.Request, form, owner string) string {\n")
+		b.WriteString("\tif user := readSession(r).User; user != \"\" {\n")
+		b.WriteString("\t\treturn form + \"/\" + owner + \"/\" + user\n")
+		b.WriteString("\t}\n")
+	} else {
+		b.WriteString("func autosaveDraft(r *http.Request, form, owner string) string {\n")
+	}
+	b.WriteString("\treturn form + \"/\" + owner\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// renderAutosave renders the element saving a form every interval while it\n")
+	b.WriteString("// is edited, carrying the draft the page script restores into the form\n")
+	b.WriteString("func renderAutosave(w http.ResponseWriter, r *http.Request, form string) {\n")
+	b.WriteString("\tif r.Method != http.MethodGet {\n")
+	b.WriteString("\t\thttp.Error(w, \"Method Not Allowed\", http.StatusMethodNotAllowed)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tvar draft Draft\n")
+	b.WriteString("\tif cookie, err := r.Cookie(autosaveCookie); err == nil {\n")
+	b.WriteString("\t\tif err := db.Where(\"id = ?\", autosaveDraft(r, form, cookie.Value)).Limit(1).Find(&draft).Error; err != nil {\n")
+	b.WriteString("\t\t\trequestLogger(r).Error(\"autosave: loading the draft\", \"form\", form, \"error\", err)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/html; charset=utf-8\")\n")
+	b.WriteString(fmt.Sprintf("\tfmt.Fprintf(w, `<div class=\"gmx-autosave\" hx-post=\"%s%%s/save\" hx-trigger=\"every %%s [this.closest('form').dataset.gmxDirty]\" hx-include=\"closest form\" hx-swap=\"none\" data-gmx-draft=\"%%s\"></div>`,\n", autosavePathPrefix))
+	b.WriteString("\t\tform, autosaveForms[form].Interval, template.HTMLEscapeString(draft.Data))\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// saveAutosave stores the posted fields of a form as the draft of the browser\n")
+	b.WriteString("func saveAutosave(w http.ResponseWriter, r *http.Request, form string) {\n")
+	b.WriteString("\tif r.Method != http.MethodPost {\n")
+	b.WriteString("\t\thttp.Error(w, \"Method Not Allowed\", http.StatusMethodNotAllowed)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif err := r.ParseMultipartForm(1 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {\n")
+	b.WriteString("\t\thttp.Error(w, \"Invalid form data\", http.StatusBadRequest)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tvalues := url.Values{}\n")
+	b.WriteString("\tfor _, field := range autosaveForms[form].Fields {\n")
+	b.WriteString("\t\tif vs, ok := r.PostForm[field]; ok {\n")
+	b.WriteString("\t\t\tvalues[field] = vs\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tdraft := Draft{ID: autosaveDraft(r, form, autosaveOwner(w, r)), Data: values.Encode(), UpdatedAt: time.Now()}\n")
+	b.WriteString("\tif err := db.Save(&draft).Error; err != nil {\n")
+	b.WriteString("\t\trequestLogger(r).Error(\"autosave: saving the draft\", \"form\", form, \"error\", err)\n")
+	b.WriteString("\t\thttp.Error(w, \"Internal Server Error\", http.StatusInternalServerError)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tw.WriteHeader(http.StatusNoContent)\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// dropAutosave deletes the draft of a form once it is submitted\n")
+	b.WriteString("func dropAutosave(r *http.Request, form string) {\n")
+	b.WriteString("\tcookie, err := r.Cookie(autosaveCookie)\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif err := db.Where(\"id = ?\", autosaveDraft(r, form, cookie.Value)).Delete(&Draft{}).Error; err != nil {\n")
+	b.WriteString("\t\trequestLogger(r).Error(\"autosave: dropping the draft\", \"form\", form, \"error\", err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// cleanupAutosaves deletes the drafts left unsaved for autosaveTTL; the\n")
+	b.WriteString("// scheduler runs it hourly\n")
+	b.WriteString("func cleanupAutosaves(ctx *GMXContext) error {\n")
+	b.WriteString("\treturn ctx.DB.Where(\"updated_at < ?\", time.Now().Add(-autosaveTTL)).Delete(&Draft{}).Error\n")
+	b.WriteString("}\n\n")
+
+	for _, fn := range funcs {
+		handler := autosaveHandler(fn)
+		b.WriteString(fmt.Sprintf("// %s restores the draft of the %s forms\n", handler, fn.Name))
+		b.WriteString(fmt.Sprintf("func %s(w http.ResponseWriter, r *http.Request) {\n", handler))
+		b.WriteString(fmt.Sprintf("\trenderAutosave(w, r, %q)\n", fn.Name))
+		b.WriteString("}\n\n")
+		b.WriteString(fmt.Sprintf("// %sSave stores the draft of the %s forms\n", handler, fn.Name))
+		b.WriteString(fmt.Sprintf("func %sSave(w http.ResponseWriter, r *http.Request) {\n", handler))
+		b.WriteString(fmt.Sprintf("\tsaveAutosave(w, r, %q)\n", fn.Name))
+		b.WriteString("}\n\n")
+	}
+
+	return b.String()
+}
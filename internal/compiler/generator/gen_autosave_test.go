@@ -0,0 +1,120 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+)
+
+const autosaveScriptSrc = `model Post {
+  id: uuid @pk @default(uuid_v4)
+  title: string
+  body: string
+}
+@autosave(30s)
+func createPost(title: string, body: string, cover: bytes) error {
+  const post = Post{title: title, body: body}
+  try post.save()
+  return nil
+}`
+
+const autosaveTemplateSrc = `<form hx-post="{{route "createPost"}}"><input name="title"><textarea name="body"></textarea></form>`
+
+func TestGenerateAutosave(t *testing.T) {
+	code, err := New().Generate(scriptTestFile(t, autosaveScriptSrc, autosaveTemplateSrc))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, want := range []string{
+		`"autosave":`,
+		"type Draft struct {",
+		"db.AutoMigrate(&Post{}, &Draft{})",
+		// Stale drafts are deleted hourly by the scheduler
+		"\t{\"cleanupAutosaves\", cronSchedule{",
+		"\tstartSchedules()\n",
+		`mux.HandleFunc("/_gmx/autosave/createPost", handleCreatePostAutosave)`,
+		`mux.HandleFunc("/_gmx/autosave/createPost/save", handleCreatePostAutosaveSave)`,
+		// Uploaded files are not stored in drafts
+		`"createPost": {"30s", []string{"title", "body"}},`,
+		// The form restores its draft, which is dropped once submitted
+		`<form hx-post="{{route "createPost"}}">{{autosave "createPost"}}`,
+		`dropAutosave(r, "createPost")`,
+		`return ctx.DB.Where("updated_at < ?", time.Now().Add(-autosaveTTL)).Delete(&Draft{}).Error`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+}
+
+func TestGenerateAutosaveExplicitPlacement(t *testing.T) {
+	file := scriptTestFile(t, autosaveScriptSrc, autosaveTemplateSrc)
+	file.Template.Source = `<form hx-post="{{route "createPost"}}"><input name="title">{{autosave "createPost"}}</form>`
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if strings.Count(code, `{{autosave "createPost"}}`) != 1 {
+		t.Errorf("a form placing {{autosave}} itself should not get a second one")
+	}
+}
+
+func TestGenerateAutosaveWithSession(t *testing.T) {
+	src := "service Auth {\n  provider: \"session\"\n  secret: string @env(\"SESSION_SECRET\")\n}\n" +
+		strings.Replace(autosaveScriptSrc, "cover: bytes", "cover: bytes, editPassword: string, apiSecret: string", 1)
+	code, err := New().Generate(scriptTestFile(t, src, autosaveTemplateSrc))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	for _, want := range []string{
+		// Passwords and secrets are not stored in drafts
+		`"createPost": {"30s", []string{"title", "body"}},`,
+		// The drafts of a shared browser are kept apart for each user
+		"\tif user := readSession(r).User; user != \"\" {\n\t\treturn form + \"/\" + owner + \"/\" + user\n",
+		"\tdraft := Draft{ID: autosaveDraft(r, form, autosaveOwner(w, r)), Data: values.Encode(), UpdatedAt: time.Now()}\n",
+		`db.Where("id = ?", autosaveDraft(r, form, cookie.Value)).Delete(&Draft{})`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+	goInModule(t, map[string]string{"main.go": code}, "vet", ".")
+}
+
+func TestValidateAutosave(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		template string
+		wantErr  string
+	}{
+		{"invalid interval", strings.Replace(autosaveScriptSrc, "@autosave(30s)", "@autosave(soon)", 1), autosaveTemplateSrc, `function createPost: invalid autosave interval "soon"`},
+		{"short interval", strings.Replace(autosaveScriptSrc, "@autosave(30s)", "@autosave(500ms)", 1), autosaveTemplateSrc, "expected a duration of at least 1s"},
+		{"get handler", strings.Replace(autosaveScriptSrc, "createPost", "listPosts", 1), autosaveTemplateSrc, "function listPosts: @autosave applies to form handlers, not GET requests"},
+		{"no fields", strings.Replace(autosaveScriptSrc, "title: string, body: string, cover: bytes", "cover: bytes, password: string", 1), autosaveTemplateSrc, "@autosave requires parameters other than bytes and passwords"},
+		{
+			"login",
+			"service Auth {\n  provider: \"session\"\n  secret: string @env(\"SESSION_SECRET\")\n}\n" + strings.Replace(autosaveScriptSrc, "@autosave(30s)", "@autosave(30s)\n@login(title)", 1),
+			autosaveTemplateSrc,
+			"function createPost: @autosave does not apply to @login forms",
+		},
+		{"model", strings.Replace(autosaveScriptSrc, "model Post", "model Draft", 1), autosaveTemplateSrc, "line 1: model Draft collides with the model storing the autosaved drafts"},
+		{"func", autosaveScriptSrc + "\nfunc createPostAutosave() error {\n  return nil\n}", autosaveTemplateSrc, "function createPostAutosave collides with the built-in /_gmx/autosave/createPost endpoint"},
+		{"unknown form", autosaveScriptSrc, `{{autosave "editPost"}}`, `template: {{autosave "editPost"}} is not a script function with @autosave`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := scriptTestFile(t, tt.src, autosaveTemplateSrc)
+			file.Template.Source = tt.template
+			_, err := New().Generate(file)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
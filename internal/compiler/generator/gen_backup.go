@@ -0,0 +1,253 @@
+package generator
+
+import (
+	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+	"strings"
+)
+
+// backupFields lists the config fields a backup service must declare
+var backupFields = []string{"every", "keep", "dir"}
+
+// findBackupService returns the service using the "backup" provider, if any
+func (g *Generator) findBackupService(services []*ast.ServiceDecl) *ast.ServiceDecl {
+	for _, svc := range services {
+		if svc.Provider == "backup" {
+			return svc
+		}
+	}
+	return nil
+}
+
+// backupDBProvider returns the database provider a backup job dumps (sqlite when no Database service is declared)
+func (g *Generator) backupDBProvider(file *ast.GMXFile) string {
+	if dbService := g.findDatabaseService(file.Services); dbService != nil {
+		return dbService.Provider
+	}
+	return "sqlite"
+}
+
+// backupTakesDBURL reports whether the backups of a postgres database are
+// started with its configured URL: without a standby to follow, nor a url of
+// the backup service to connect with
+func backupTakesDBURL(backup, db *ast.ServiceDecl) bool {
+	return db != nil && db.Provider == "postgres" && findServiceField(db, "standby") == nil && findServiceField(backup, "url") == nil
+}
+
+// backupStorageService returns the storage service the archives are written
+// through: the first s3 or local service declaring upload, download and delete
+func (g *Generator) backupStorageService(services []*ast.ServiceDecl) *ast.ServiceDecl {
+	for _, svc := range services {
+		if isStorageService(svc) && providerDeclares(svc, "upload") && providerDeclares(svc, "download") && providerDeclares(svc, "delete") {
+			return svc
+		}
+	}
+	return nil
+}
+
+// backupStorageAssign returns the statement of main handing the storage
+// service to the backups, if any
+func (g *Generator) backupStorageAssign(file *ast.GMXFile) string {
+	svc := g.findBackupService(file.Services)
+	storage := g.backupStorageService(file.Services)
+	if svc == nil || storage == nil {
+		return ""
+	}
+	return fmt.Sprintf("\t%sStorage = %sSvc\n", utils.LowerFirst(svc.Name), utils.LowerFirst(storage.Name))
+}
+
+// validateBackupService checks that a backup service is complete, has a storage
+// service to write to and targets a supported database
+func (g *Generator) validateBackupService(file *ast.GMXFile) error {
+	svc := g.findBackupService(file.Services)
+	if svc == nil {
+		return nil
+	}
+	if len(file.Models) == 0 {
+		return fmt.Errorf("service %s: backup provider requires at least one model", svc.Name)
+	}
+	for _, name := range backupFields {
+		if findServiceField(svc, name) == nil {
+			return fmt.Errorf("service %s: backup provider requires a `%s` field", svc.Name, name)
+		}
+	}
+	if provider := g.backupDBProvider(file); provider != "sqlite" && provider != "postgres" {
+		return fmt.Errorf("service %s: backups are not supported for %s databases", svc.Name, provider)
+	}
+	if g.backupStorageService(file.Services) == nil {
+		return fmt.Errorf("service %s: backups are written to a storage service declaring upload, download and delete", svc.Name)
+	}
+	hasURL := findServiceField(svc, "url") != nil
+	if hasURL && g.backupDBProvider(file) != "postgres" {
+		return fmt.Errorf("service %s: url is the connection of pg_dump, for postgres databases", svc.Name)
+	}
+	// The application role is bound by the policies; a role bypassing them
+	// would lift them for every request
+	if !hasURL && g.hasRowLevelSecurity(file) {
+		return fmt.Errorf("service %s: with row-level security, pg_dump connects with the url field of the backup service, as a BYPASSRLS role distinct from the application's", svc.Name)
+	}
+	return nil
+}
+
+// genBackupJob generates the backups the scheduler runs: a SQLite snapshot
+// (VACUUM INTO) or a pg_dump archive, written through the storage service
+// with count-based retention
+func (g *Generator) genBackupJob(svc *ast.ServiceDecl, services []*ast.ServiceDecl) string {
+	var b strings.Builder
+
+	dbService := g.findDatabaseService(services)
+	storage := g.backupStorageService(services)
+	provider := "sqlite"
+	if dbService != nil {
+		provider = dbService.Provider
+	}
+	ownURL := findServiceField(svc, "url") != nil
+	storageVar := utils.LowerFirst(svc.Name) + "Storage"
+
+	b.WriteString(fmt.Sprintf("// %s is the storage service the backups are written to; set at startup\n", storageVar))
+	b.WriteString(fmt.Sprintf("var %s %sService\n\n", storageVar, storage.Name))
+
+	// Without a standby, the database dumped is always the configured one
+	withURL := backupTakesDBURL(svc, dbService)
+	if withURL {
+		b.WriteString(fmt.Sprintf("// start%s schedules the database backups according to the %s service,\n", svc.Name, svc.Name))
+		b.WriteString("// dumping the database at dbURL\n")
+		b.WriteString(fmt.Sprintf("func start%s(cfg *%sConfig, dbURL string) {\n", svc.Name, svc.Name))
+	} else {
+		b.WriteString(fmt.Sprintf("// start%s schedules the database backups according to the %s service\n", svc.Name, svc.Name))
+		b.WriteString(fmt.Sprintf("func start%s(cfg *%sConfig) {\n", svc.Name, svc.Name))
+	}
+	b.WriteString("\tevery, err := time.ParseDuration(cfg.Every)\n")
+	b.WriteString("\tif err != nil || every <= 0 {\n")
+	b.WriteString("\t\tlog.Fatalf(\"invalid backup interval %q: expected a positive duration\", cfg.Every)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tkeep, err := strconv.Atoi(cfg.Keep)\n")
+	b.WriteString("\tif err != nil || keep < 1 {\n")
+	b.WriteString("\t\tlog.Fatalf(\"invalid backup retention %q: expected a positive count\", cfg.Keep)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tscheduledFuncs = append(scheduledFuncs, scheduledFunc{\n")
+	b.WriteString("\t\tname:     \"backupDatabase\",\n")
+	b.WriteString("\t\tschedule: cronSchedule{every: every},\n")
+	b.WriteString("\t\trun: func(ctx *GMXContext) error {\n")
+	switch {
+	case withURL:
+		b.WriteString("\t\t\treturn backupDatabase(ctx, dbURL, cfg.Dir, keep)\n")
+	case ownURL:
+		b.WriteString("\t\t\t// The role of the backups, which bypasses row-level security\n")
+		b.WriteString("\t\t\treturn backupDatabase(ctx, cfg.Url, cfg.Dir, keep)\n")
+	case provider == "postgres":
+		b.WriteString("\t\t\t// The database in use, which follows the switchovers\n")
+		b.WriteString("\t\t\treturn backupDatabase(ctx, dbTargets[dbActive], cfg.Dir, keep)\n")
+	default:
+		b.WriteString("\t\t\treturn backupDatabase(ctx, cfg.Dir, keep)\n")
+	}
+	b.WriteString("\t\t},\n")
+	b.WriteString("\t})\n")
+	b.WriteString("}\n\n")
+
+	ext := ".db"
+	if provider == "postgres" {
+		ext = ".dump"
+		b.WriteString("// backupDatabase writes a pg_dump archive of the database at dbURL into the\n")
+		b.WriteString("// storage service under dir, then prunes the archives beyond keep\n")
+		b.WriteString("func backupDatabase(ctx *GMXContext, dbURL, dir string, keep int) error {\n")
+	} else {
+		b.WriteString("// backupDatabase writes a consistent snapshot of the database into the\n")
+		b.WriteString("// storage service under dir, then prunes the archives beyond keep\n")
+		b.WriteString("func backupDatabase(ctx *GMXContext, dir string, keep int) error {\n")
+	}
+	b.WriteString("\ttmp, err := os.MkdirTemp(\"\", \"gmx-backup-*\")\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\treturn err\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tdefer os.RemoveAll(tmp)\n")
+	b.WriteString(fmt.Sprintf("\tfile := filepath.Join(tmp, \"backup%s\")\n", ext))
+	if provider == "postgres" {
+		b.WriteString("\tenv, err := pgDumpEnv(dbURL)\n")
+		b.WriteString("\tif err != nil {\n")
+		b.WriteString("\t\treturn err\n")
+		b.WriteString("\t}\n")
+		b.WriteString("\t// The connection settings go through the environment, out of the process list\n")
+		b.WriteString("\tcmd := exec.CommandContext(ctx.Request.Context(), \"pg_dump\", \"--format=custom\", \"--file=\"+file)\n")
+		b.WriteString("\tcmd.Env = env\n")
+		b.WriteString("\tif out, err := cmd.CombinedOutput(); err != nil {\n")
+		b.WriteString("\t\treturn fmt.Errorf(\"pg_dump: %w: %s\", err, out)\n")
+		b.WriteString("\t}\n")
+	} else {
+		b.WriteString("\tif err := ctx.DB.Exec(\"VACUUM INTO ?\", file).Error; err != nil {\n")
+		b.WriteString("\t\treturn fmt.Errorf(\"vacuum into %s: %w\", file, err)\n")
+		b.WriteString("\t}\n")
+	}
+	b.WriteString("\tdata, err := os.ReadFile(file)\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\treturn err\n")
+	b.WriteString("\t}\n")
+	b.WriteString(fmt.Sprintf("\tkey := path.Join(dir, \"backup-\"+time.Now().UTC().Format(\"20060102T150405Z\")+%q)\n", ext))
+	b.WriteString(fmt.Sprintf("\tif err := %s.Upload(key, Blob(data)); err != nil {\n", storageVar))
+	b.WriteString("\t\treturn fmt.Errorf(\"writing %s: %w\", key, err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tctx.Log.Info(\"backup written\", \"key\", key)\n")
+	b.WriteString("\treturn pruneBackups(dir, key, keep)\n")
+	b.WriteString("}\n\n")
+
+	if provider == "postgres" {
+		b.WriteString("// pgDumpEnv returns the environment of a pg_dump connecting to dbURL: its\n")
+		b.WriteString("// settings, the password included, go in the PG* variables\n")
+		b.WriteString("func pgDumpEnv(dbURL string) ([]string, error) {\n")
+		b.WriteString("\tu, err := url.Parse(dbURL)\n")
+		b.WriteString("\tif err != nil || u.Scheme != \"postgres\" && u.Scheme != \"postgresql\" {\n")
+		b.WriteString("\t\t// The URL holds the password: it is left out of the error\n")
+		b.WriteString("\t\treturn nil, errors.New(\"pg_dump: the database url is not a postgres:// url\")\n")
+		b.WriteString("\t}\n")
+		b.WriteString("\tenv := os.Environ()\n")
+		b.WriteString("\tpassword, _ := u.User.Password()\n")
+		b.WriteString("\tfor name, value := range map[string]string{\n")
+		b.WriteString("\t\t\"PGHOST\":     u.Hostname(),\n")
+		b.WriteString("\t\t\"PGPORT\":     u.Port(),\n")
+		b.WriteString("\t\t\"PGUSER\":     u.User.Username(),\n")
+		b.WriteString("\t\t\"PGPASSWORD\": password,\n")
+		b.WriteString("\t\t\"PGDATABASE\": strings.TrimPrefix(u.Path, \"/\"),\n")
+		b.WriteString("\t\t\"PGSSLMODE\":  u.Query().Get(\"sslmode\"),\n")
+		b.WriteString("\t} {\n")
+		b.WriteString("\t\tif value != \"\" {\n")
+		b.WriteString("\t\t\tenv = append(env, name+\"=\"+value)\n")
+		b.WriteString("\t\t}\n")
+		b.WriteString("\t}\n")
+		b.WriteString("\treturn env, nil\n")
+		b.WriteString("}\n\n")
+	}
+
+	b.WriteString("// pruneBackups records the archive key in the index of dir, then removes the\n")
+	b.WriteString("// oldest archives so that at most keep remain; storage services do not list\n")
+	b.WriteString("// their keys, so the index keeps them, oldest first\n")
+	b.WriteString("func pruneBackups(dir, key string, keep int) error {\n")
+	b.WriteString("\tindex := path.Join(dir, \"index\")\n")
+	b.WriteString("\tvar keys []string\n")
+	b.WriteString(fmt.Sprintf("\tdata, err := %s.Download(index)\n", storageVar))
+	b.WriteString("\tswitch {\n")
+	b.WriteString("\tcase err == nil:\n")
+	b.WriteString("\t\tkeys = strings.Fields(string(data))\n")
+	b.WriteString("\tcase !errors.Is(err, fs.ErrNotExist):\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"reading the backup index: %w\", err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tkeys = append(keys, key)\n")
+	b.WriteString("\tvar pruneErr error\n")
+	b.WriteString("\tfor len(keys) > keep {\n")
+	b.WriteString(fmt.Sprintf("\t\tif pruneErr = %s.Delete(keys[0]); pruneErr != nil {\n", storageVar))
+	b.WriteString("\t\t\tbreak\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tkeys = keys[1:]\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\t// The index keeps an archive that failed to be removed, for the next run\n")
+	b.WriteString(fmt.Sprintf("\tif err := %s.Upload(index, Blob(strings.Join(keys, \"\\n\")+\"\\n\")); err != nil {\n", storageVar))
+	b.WriteString("\t\treturn fmt.Errorf(\"writing the backup index: %w\", err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif pruneErr != nil {\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"removing an old backup: %w\", pruneErr)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn nil\n")
+	b.WriteString("}\n")
+
+	return b.String()
+}
@@ -0,0 +1,202 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+func backupTestFile(dbProvider string) *ast.GMXFile {
+	def := func(v string) []*ast.Annotation {
+		return []*ast.Annotation{{Name: "default", Args: map[string]string{"_": v}}}
+	}
+	return &ast.GMXFile{
+		Services: []*ast.ServiceDecl{
+			{
+				Name:     "Database",
+				Provider: dbProvider,
+				Fields:   []*ast.ServiceField{{Name: "url", Type: "string", EnvVar: "DATABASE_URL"}},
+			},
+			{
+				Name:     "Backup",
+				Provider: "backup",
+				Fields: []*ast.ServiceField{
+					{Name: "every", Type: "string", Annotations: def("24h")},
+					{Name: "keep", Type: "string", Annotations: def("7")},
+					{Name: "dir", Type: "string", EnvVar: "BACKUP_DIR", Annotations: def("backups")},
+				},
+			},
+			storageService("local"),
+		},
+		Models: []*ast.ModelDecl{
+			{Name: "Task", Fields: []*ast.FieldDecl{{Name: "title", Type: "string"}}},
+		},
+	}
+}
+
+func TestGenerator_BackupJob(t *testing.T) {
+	tests := []struct {
+		provider   string
+		expected   []string
+		unexpected []string
+	}{
+		{
+			provider: "sqlite",
+			expected: []string{
+				"func startBackup(cfg *BackupConfig) {",
+				"\tstartBackup(backupCfg)\n",
+				`ctx.DB.Exec("VACUUM INTO ?", file)`,
+				`time.Now().UTC().Format("20060102T150405Z")+".db")`,
+			},
+			unexpected: []string{"pg_dump", `"os/exec"`},
+		},
+		{
+			provider: "postgres",
+			expected: []string{
+				"func startBackup(cfg *BackupConfig, dbURL string) {",
+				"\tstartBackup(backupCfg, databaseCfg.Url)\n",
+				// The password stays out of the process list
+				`cmd := exec.CommandContext(ctx.Request.Context(), "pg_dump", "--format=custom", "--file="+file)`,
+				"\tcmd.Env = env\n",
+				`"PGPASSWORD": password,`,
+				`"PGDATABASE": strings.TrimPrefix(u.Path, "/"),`,
+				`"os/exec"`,
+			},
+			unexpected: []string{"VACUUM INTO", "file, dbURL)"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			code, err := New().Generate(backupTestFile(tt.provider))
+			if err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+			if !isValidGo(code) {
+				t.Errorf("Generated code is not valid Go:\n%s", code)
+			}
+
+			expected := append([]string{
+				// The archives are written through the storage service
+				"var backupStorage FilesService",
+				"\tbackupStorage = filesSvc\n",
+				"\tif err := backupStorage.Upload(key, Blob(data)); err != nil {\n",
+				"func pruneBackups(dir, key string, keep int) error {",
+				"\tcase !errors.Is(err, fs.ErrNotExist):\n",
+				// The backups run with the other scheduled runs
+				"\t\tschedule: cronSchedule{every: every},\n",
+				"\tstartSchedules()\n",
+				`cfg.Every = "24h"`,
+				`"io/fs"`,
+				`"path"`,
+				`"path/filepath"`,
+				`"strconv"`,
+			}, tt.expected...)
+			for _, exp := range expected {
+				if !strings.Contains(code, exp) {
+					t.Errorf("Generated code missing %q", exp)
+				}
+			}
+			// Backups are registered before the schedulers start
+			if strings.Index(code, "\tstartBackup(") > strings.Index(code, "\tstartSchedules()") {
+				t.Error("expected the backups to be registered before the schedulers start")
+			}
+			for _, unexpected := range append([]string{"time.NewTicker(every)"}, tt.unexpected...) {
+				if strings.Contains(code, unexpected) {
+					t.Errorf("Generated code should not contain %q", unexpected)
+				}
+			}
+		})
+	}
+}
+
+// withBackupURL gives pg_dump a connection of its own
+func withBackupURL(f *ast.GMXFile) {
+	f.Services[1].Fields = append(f.Services[1].Fields, &ast.ServiceField{Name: "url", Type: "string", EnvVar: "BACKUP_DATABASE_URL"})
+}
+
+// withRowLevelSecurity enforces the policies in a postgres database
+func withRowLevelSecurity(f *ast.GMXFile) {
+	f.Services[0].Provider = "postgres"
+	f.Services[0].Fields = append(f.Services[0].Fields, &ast.ServiceField{Name: "tenantHeader", Type: "string", EnvVar: "TENANT_HEADER"})
+}
+
+func TestGenerator_BackupRowLevelSecurity(t *testing.T) {
+	file := backupTestFile("postgres")
+	withRowLevelSecurity(file)
+	withBackupURL(file)
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+	// pg_dump connects as the role of the backups, not as the application
+	for _, want := range []string{
+		"func startBackup(cfg *BackupConfig) {",
+		"\tstartBackup(backupCfg)\n",
+		"\t\t\treturn backupDatabase(ctx, cfg.Url, cfg.Dir, keep)\n",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("Generated code missing %q", want)
+		}
+	}
+	if strings.Contains(code, "startBackup(backupCfg, databaseCfg.Url)") {
+		t.Error("unexpected backups of the application URL")
+	}
+}
+
+func TestGenerator_BackupValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(*ast.GMXFile)
+		errMsg string
+	}{
+		{
+			name:   "missing field",
+			mutate: func(f *ast.GMXFile) { f.Services[1].Fields = f.Services[1].Fields[:2] },
+			errMsg: "requires a `dir` field",
+		},
+		{
+			name:   "no models",
+			mutate: func(f *ast.GMXFile) { f.Models = nil },
+			errMsg: "requires at least one model",
+		},
+		{
+			name:   "unsupported database",
+			mutate: func(f *ast.GMXFile) { f.Services[0].Provider = "mysql" },
+			errMsg: "not supported for mysql",
+		},
+		{
+			name:   "no storage",
+			mutate: func(f *ast.GMXFile) { f.Services[2].Methods = f.Services[2].Methods[:2] },
+			errMsg: "service Backup: backups are written to a storage service declaring upload, download and delete",
+		},
+		{
+			name:   "url without postgres",
+			mutate: withBackupURL,
+			errMsg: "service Backup: url is the connection of pg_dump, for postgres databases",
+		},
+		{
+			name:   "row-level security without url",
+			mutate: withRowLevelSecurity,
+			errMsg: "service Backup: with row-level security, pg_dump connects with the url field of the backup service, as a BYPASSRLS role distinct from the application's",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := backupTestFile("sqlite")
+			tt.mutate(file)
+			_, err := New().Generate(file)
+			if err == nil {
+				t.Fatal("expected validation error")
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package generator
+
+import (
+	"fmt"
+	"go/format"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/resolver"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// benchSeedRows is the number of records seeded per model before benchmarking
+const benchSeedRows = 100
+
+// GenerateBenchmarks returns a Go test file, in the package of the generated
+// server, benchmarking the page and each GET handler against an in-memory
+// SQLite database seeded by the model factories
+func (g *Generator) GenerateBenchmarks(resolved *resolver.ResolvedFile) (string, error) {
+	file, err := g.selectCompileTime(resolved.Main)
+	if err != nil {
+		return "", err
+	}
+
+	funcs := make(map[string]*ast.FuncDecl)
+	if file.Script != nil {
+		for _, fn := range file.Script.Funcs {
+			funcs["handle"+utils.Capitalize(fn.Name)] = fn
+		}
+	}
+
+	var benchmarks strings.Builder
+	for _, route := range g.Routes(resolved) {
+		if route.Handler == "handleIndex" {
+			benchmarks.WriteString(genHandlerBenchmark("Index", route, nil, nil))
+			continue
+		}
+		fn, ok := funcs[route.Handler]
+		if !ok || route.Method != "GET" || !benchmarkable(fn, file.Models) {
+			continue
+		}
+		benchmarks.WriteString(genHandlerBenchmark(utils.Capitalize(fn.Name), route, fn, file.Models))
+	}
+
+	var b strings.Builder
+	b.WriteString("// Code generated by gmx build -with-benchmarks. DO NOT EDIT.\n\n")
+	b.WriteString(fmt.Sprintf("package %s\n\n", g.packageName()))
+	b.WriteString("import (\n")
+	b.WriteString("\t\"net/http\"\n")
+	b.WriteString("\t\"net/http/httptest\"\n")
+	if strings.Contains(benchmarks.String(), "url.Values") {
+		b.WriteString("\t\"net/url\"\n")
+	}
+	b.WriteString("\t\"testing\"\n\n")
+	b.WriteString("\t\"gorm.io/driver/sqlite\"\n")
+	b.WriteString("\t\"gorm.io/gorm\"\n")
+	b.WriteString("\t\"gorm.io/gorm/logger\"\n")
+	b.WriteString(")\n\n")
+	b.WriteString(g.genBenchSetup(file.Models))
+	b.WriteString(benchmarks.String())
+
+	formatted, err := format.Source([]byte(b.String()))
+	if err != nil {
+		return "", fmt.Errorf("formatting benchmarks: %w", err)
+	}
+	return string(formatted), nil
+}
+
+// benchmarkable reports whether a GET handler can run without a session or an
+// upload, with every parameter filled from the seeded data
+func benchmarkable(fn *ast.FuncDecl, models []*ast.ModelDecl) bool {
+	if fn.FindAnnotation("auth") != nil || fn.FindAnnotation("role") != nil {
+		return false
+	}
+	for _, param := range fn.Params {
+		if param.Type == "bytes" || (param.Type == "uuid" && seededModel(param, models) == "") {
+			return false
+		}
+	}
+	return true
+}
+
+// genBenchSetup generates benchSetup, which swaps the database for a seeded
+// in-memory one and returns the ID of the first record of each model
+func (g *Generator) genBenchSetup(models []*ast.ModelDecl) string {
+	return genSeedSetup("bench", "b *testing.B", benchSeedRows, models, models, "")
+}
+
+// genSeedSetup generates <prefix>Setup, taking the testing value tb, which
+// replaces the database with an in-memory SQLite one where the migrated models
+// exist and the seeded ones have rows records, runs the configure statements,
+// and returns the ID of the first record of each seeded model
+func genSeedSetup(prefix, tb string, rows int, seeded, migrated []*ast.ModelDecl, configure string) string {
+	var b strings.Builder
+	t := strings.Fields(tb)[0]
+
+	b.WriteString(fmt.Sprintf("// %sSeedRows is the number of records seeded per model\nconst %sSeedRows = %d\n\n", prefix, prefix, rows))
+
+	b.WriteString(fmt.Sprintf("// %sSetup replaces the database with an in-memory SQLite one seeded by the\n", prefix))
+	b.WriteString("// model factories, and returns the ID of the first record of each model\n")
+	b.WriteString(fmt.Sprintf("func %sSetup(%s) map[string]string {\n", prefix, tb))
+	b.WriteString(fmt.Sprintf("\t%s.Helper()\n", t))
+	b.WriteString("\tmemDB, err := gorm.Open(sqlite.Open(\"file::memory:\"), &gorm.Config{Logger: logger.Discard})\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString(fmt.Sprintf("\t\t%s.Fatalf(\"opening in-memory database: %%v\", err)\n", t))
+	b.WriteString("\t}\n")
+	// Every connection to :memory: opens a distinct, empty database
+	b.WriteString("\tsqlDB, err := memDB.DB()\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString(fmt.Sprintf("\t\t%s.Fatalf(\"opening in-memory database: %%v\", err)\n", t))
+	b.WriteString("\t}\n")
+	b.WriteString("\tsqlDB.SetMaxOpenConns(1)\n")
+	b.WriteString(fmt.Sprintf("\t%s.Cleanup(func() {\n", t))
+	b.WriteString("\t\tif err := sqlDB.Close(); err != nil {\n")
+	b.WriteString(fmt.Sprintf("\t\t\t%s.Errorf(\"closing in-memory database: %%v\", err)\n", t))
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t})\n")
+	b.WriteString("\tids := make(map[string]string)\n")
+	if len(migrated) == 0 {
+		b.WriteString("\tdb = memDB\n")
+		b.WriteString(configure)
+		b.WriteString("\treturn ids\n")
+		b.WriteString("}\n\n")
+		return b.String()
+	}
+
+	var migrate []string
+	for _, model := range migrated {
+		migrate = append(migrate, "&"+model.Name+"{}")
+	}
+	b.WriteString(fmt.Sprintf("\tif err := memDB.AutoMigrate(%s); err != nil {\n", strings.Join(migrate, ", ")))
+	b.WriteString(fmt.Sprintf("\t\t%s.Fatalf(\"migrating: %%v\", err)\n", t))
+	b.WriteString("\t}\n")
+	b.WriteString("\tdb = memDB\n\n")
+
+	for _, model := range seeded {
+		b.WriteString(fmt.Sprintf("\tfor i := 0; i < %sSeedRows; i++ {\n", prefix))
+		b.WriteString(fmt.Sprintf("\t\tobj := factory.New%s()\n", model.Name))
+		// Repositories store their records themselves
+		if model.FindAnnotation("repository") != nil {
+			b.WriteString(fmt.Sprintf("\t\tif err := %sRepository.Save(db, obj); err != nil {\n", utils.LowerFirst(model.Name)))
+		} else {
+			b.WriteString("\t\tif err := db.Create(obj).Error; err != nil {\n")
+		}
+		b.WriteString(fmt.Sprintf("\t\t\t%s.Fatalf(\"seeding %s: %%v\", err)\n", t, model.Name))
+		b.WriteString("\t\t}\n")
+		if pk := uuidPrimaryKey(model); pk != "" {
+			b.WriteString(fmt.Sprintf("\t\tif i == 0 {\n\t\t\tids[%q] = obj.%s\n\t\t}\n", model.Name, pk))
+		}
+		b.WriteString("\t}\n")
+	}
+	b.WriteString(configure)
+	b.WriteString("\treturn ids\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// uuidPrimaryKey returns the Go field of the uuid primary key of a model,
+// empty when its key is of another type
+func uuidPrimaryKey(model *ast.ModelDecl) string {
+	if pk := modelPKField(model); pk != nil && pk.Type == "uuid" {
+		return utils.ToPascalCase(pk.Name)
+	}
+	return ""
+}
+
+// genHandlerBenchmark generates the benchmark of one handler, called directly
+// with a GET request carrying its parameters in the query string
+func genHandlerBenchmark(name string, route Route, fn *ast.FuncDecl, models []*ast.ModelDecl) string {
+	var b strings.Builder
+
+	b.WriteString(fmt.Sprintf("func Benchmark%s(b *testing.B) {\n", name))
+	if fn == nil || len(fn.Params) == 0 {
+		b.WriteString("\tbenchSetup(b)\n")
+		b.WriteString(fmt.Sprintf("\ttarget := %q\n", route.Path))
+	} else {
+		b.WriteString("\tids := benchSetup(b)\n")
+		b.WriteString("\tquery := url.Values{}\n")
+		for _, param := range fn.Params {
+			b.WriteString(fmt.Sprintf("\tquery.Set(%q, %s)\n", param.Name, benchParamValue(param, models)))
+		}
+		b.WriteString(fmt.Sprintf("\ttarget := %q + \"?\" + query.Encode()\n", route.Path))
+	}
+	b.WriteString("\n\tb.ReportAllocs()\n")
+	b.WriteString("\tb.ResetTimer()\n")
+	b.WriteString("\tfor i := 0; i < b.N; i++ {\n")
+	b.WriteString("\t\trec := httptest.NewRecorder()\n")
+	b.WriteString(fmt.Sprintf("\t\t%s(rec, httptest.NewRequest(http.MethodGet, target, nil))\n", route.Handler))
+	b.WriteString("\t\tif rec.Code >= http.StatusBadRequest {\n")
+	b.WriteString("\t\t\tb.Fatalf(\"GET %s: status %d: %s\", target, rec.Code, rec.Body.String())\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// benchParamValue returns the Go expression of a valid value for a handler
+// parameter; uuid parameters take the ID of a seeded record
+func benchParamValue(param *ast.Param, models []*ast.ModelDecl) string {
+	switch param.Type {
+	case "uuid":
+		return fmt.Sprintf("ids[%q]", seededModel(param, models))
+	case "int":
+		return `"1"`
+	case "money":
+		return `"1.00"`
+	case "bool":
+		return `"true"`
+	default:
+		return `"bench"`
+	}
+}
+
+// seededModel returns the model whose seeded ID fills a uuid parameter: the
+// one its name starts with (taskId → Task), else the first with a uuid key
+func seededModel(param *ast.Param, models []*ast.ModelDecl) string {
+	fallback := ""
+	for _, model := range models {
+		if uuidPrimaryKey(model) == "" {
+			continue
+		}
+		if strings.HasPrefix(strings.ToLower(param.Name), strings.ToLower(model.Name)) {
+			return model.Name
+		}
+		if fallback == "" {
+			fallback = model.Name
+		}
+	}
+	return fallback
+}
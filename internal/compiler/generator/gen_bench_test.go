@@ -0,0 +1,93 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/resolver"
+)
+
+func TestGenerateBenchmarks(t *testing.T) {
+	file := strictFile(t, `model Task {
+  id: uuid @pk @default(uuid_v4)
+  title: string
+}
+service Database {
+  provider: "sqlite"
+  url: string @env("DATABASE_URL")
+}
+service Auth {
+  provider: "session"
+  secret: string @env("SESSION_SECRET")
+}
+
+func listTasks() error {
+  let tasks = try Task.all()
+  return render(tasks)
+}
+
+func getTask(taskId: uuid, page: int) error {
+  let task = try Task.find(taskId)
+  return render(task)
+}
+
+@auth
+func getPrivateTasks() error {
+  let tasks = try Task.all()
+  return render(tasks)
+}
+
+func addTask(title: string) error {
+  const task = Task{title: title}
+  try task.save()
+  return render(task)
+}
+`, `<p>tasks</p>`)
+
+	code, err := New().GenerateBenchmarks(&resolver.ResolvedFile{Main: file})
+	if err != nil {
+		t.Fatalf("generate benchmarks: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Fatalf("generated benchmarks are not valid Go:\n%s", code)
+	}
+
+	for _, want := range []string{
+		"func BenchmarkIndex(b *testing.B) {",
+		"func BenchmarkListTasks(b *testing.B) {",
+		`handleListTasks(rec, httptest.NewRequest(http.MethodGet, target, nil))`,
+		`query.Set("taskId", ids["Task"])`,
+		`query.Set("page", "1")`,
+		"obj := factory.NewTask()",
+		"b.ReportAllocs()",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in benchmarks", want)
+		}
+	}
+
+	// Mutations and handlers behind a session are not benchmarked
+	for _, unwanted := range []string{"BenchmarkAddTask", "BenchmarkGetPrivateTasks"} {
+		if strings.Contains(code, unwanted) {
+			t.Errorf("expected no %s", unwanted)
+		}
+	}
+}
+
+func TestGenerateBenchmarksWithoutModels(t *testing.T) {
+	file := strictFile(t, `func getStatus() error {
+  return nil
+}
+`, `<p>status</p>`)
+
+	code, err := New().GenerateBenchmarks(&resolver.ResolvedFile{Main: file})
+	if err != nil {
+		t.Fatalf("generate benchmarks: %v", err)
+	}
+	if strings.Contains(code, `"net/url"`) || strings.Contains(code, "AutoMigrate") {
+		t.Errorf("expected no query or seeding without models:\n%s", code)
+	}
+	if !strings.Contains(code, "func BenchmarkGetStatus(b *testing.B) {") {
+		t.Errorf("expected BenchmarkGetStatus:\n%s", code)
+	}
+}
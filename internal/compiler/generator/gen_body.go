@@ -0,0 +1,138 @@
+package generator
+
+import (
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// needsRequestBody checks if a handler wrapper reads parameters, which may
+// come from a JSON or form body whatever the method
+func (g *Generator) needsRequestBody(file *ast.GMXFile) bool {
+	if file.Script == nil {
+		return false
+	}
+	for _, fn := range file.Script.Funcs {
+		if !isHandler(fn) {
+			continue
+		}
+		if len(fn.Params) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// genRequestBodyHelpers generates the content negotiation of request bodies:
+// JSON and form bodies are merged into r.Form, where the handler wrappers
+// read their parameters; other media types are rejected
+func (g *Generator) genRequestBodyHelpers() string {
+	var b strings.Builder
+
+	b.WriteString("// maxRequestBody caps the JSON and urlencoded bodies decoded by handlers\n")
+	b.WriteString("const maxRequestBody = 10 << 20\n\n")
+
+	b.WriteString("// errUnsupportedMediaType rejects request bodies that are neither JSON nor a form\n")
+	b.WriteString("var errUnsupportedMediaType = errors.New(\"unsupported media type\")\n\n")
+
+	b.WriteString("// parseRequestBody merges the body of a request into r.Form, whatever its\n")
+	b.WriteString("// method: JSON objects by field, forms as parsed by net/http\n")
+	b.WriteString("func parseRequestBody(w http.ResponseWriter, r *http.Request) error {\n")
+	b.WriteString("\tif err := r.ParseForm(); err != nil {\n")
+	b.WriteString("\t\treturn err\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tcontentType := r.Header.Get(\"Content-Type\")\n")
+	b.WriteString("\tif contentType == \"\" {\n")
+	b.WriteString("\t\tif r.ContentLength != 0 {\n")
+	b.WriteString("\t\t\treturn errUnsupportedMediaType\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\treturn nil\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tmediaType, _, err := mime.ParseMediaType(contentType)\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\treturn errUnsupportedMediaType\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\tswitch mediaType {\n")
+	b.WriteString("\tcase \"application/json\":\n")
+	b.WriteString("\t\tvalues, err := decodeJSONBody(http.MaxBytesReader(w, r.Body, maxRequestBody))\n")
+	b.WriteString("\t\tif err != nil {\n")
+	b.WriteString("\t\t\treturn err\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tmergeBodyValues(r, values)\n")
+	b.WriteString("\t\treturn nil\n")
+	b.WriteString("\tcase \"application/x-www-form-urlencoded\":\n")
+	b.WriteString("\t\t// net/http only parses the bodies of POST, PUT and PATCH\n")
+	b.WriteString("\t\tif r.Method == http.MethodDelete {\n")
+	b.WriteString("\t\t\tbody, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))\n")
+	b.WriteString("\t\t\tif err != nil {\n")
+	b.WriteString("\t\t\t\treturn err\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t\tvalues, err := url.ParseQuery(string(body))\n")
+	b.WriteString("\t\t\tif err != nil {\n")
+	b.WriteString("\t\t\t\treturn err\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t\tmergeBodyValues(r, values)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\treturn nil\n")
+	b.WriteString("\tcase \"multipart/form-data\":\n")
+	b.WriteString("\t\treturn r.ParseMultipartForm(32 << 20)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn errUnsupportedMediaType\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// decodeJSONBody flattens a JSON object to form values: scalars are\n")
+	b.WriteString("// formatted as in a form, arrays become repeated values\n")
+	b.WriteString("func decodeJSONBody(body io.Reader) (url.Values, error) {\n")
+	b.WriteString("\tvar fields map[string]any\n")
+	b.WriteString("\tdec := json.NewDecoder(body)\n")
+	b.WriteString("\tdec.UseNumber()\n")
+	b.WriteString("\tif err := dec.Decode(&fields); err != nil && err != io.EOF {\n")
+	b.WriteString("\t\treturn nil, err\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tvalues := url.Values{}\n")
+	b.WriteString("\tfor name, field := range fields {\n")
+	b.WriteString("\t\titems, ok := field.([]any)\n")
+	b.WriteString("\t\tif !ok {\n")
+	b.WriteString("\t\t\titems = []any{field}\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tfor _, item := range items {\n")
+	b.WriteString("\t\t\tswitch v := item.(type) {\n")
+	b.WriteString("\t\t\tcase nil:\n")
+	b.WriteString("\t\t\tcase string:\n")
+	b.WriteString("\t\t\t\tvalues.Add(name, v)\n")
+	b.WriteString("\t\t\tcase json.Number, bool:\n")
+	b.WriteString("\t\t\t\tvalues.Add(name, fmt.Sprint(v))\n")
+	b.WriteString("\t\t\tdefault:\n")
+	b.WriteString("\t\t\t\treturn nil, fmt.Errorf(\"field %s: nested values are not supported\", name)\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn values, nil\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// mergeBodyValues adds body values to r.Form ahead of the query string, as net/http does\n")
+	b.WriteString("func mergeBodyValues(r *http.Request, values url.Values) {\n")
+	b.WriteString("\tfor name, vs := range values {\n")
+	b.WriteString("\t\tr.PostForm[name] = append(r.PostForm[name], vs...)\n")
+	b.WriteString("\t\tr.Form[name] = append(vs, r.Form[name]...)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// genRequestBodyParse generates the decoding of the request body in a handler
+// wrapper: 415 for media types it cannot read, 400 for malformed bodies
+func genRequestBodyParse() string {
+	var b strings.Builder
+	b.WriteString("\t// Decode the request body: JSON or form\n")
+	b.WriteString("\tif err := parseRequestBody(w, r); err != nil {\n")
+	b.WriteString("\t\tif errors.Is(err, errUnsupportedMediaType) {\n")
+	b.WriteString("\t\t\thttp.Error(w, \"Unsupported Media Type\", http.StatusUnsupportedMediaType)\n")
+	b.WriteString("\t\t\treturn\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\thttp.Error(w, \"Invalid request body\", http.StatusBadRequest)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	return b.String()
+}
@@ -0,0 +1,64 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateRequestBodyParsing(t *testing.T) {
+	file := strictFile(t, `model Task {
+  id: uuid @pk @default(uuid_v4)
+  title: string
+}
+func updateTask(id: uuid, title: string) error {
+  let task = try Task.find(id)
+  task.title = title
+  try task.save()
+  return render(task)
+}
+func listTasks() error {
+  let tasks = try Task.all()
+  return render(tasks)
+}`, `<ul>{{range .Tasks}}<li>{{.Title}}</li>{{end}}</ul>`)
+
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, want := range []string{
+		"func parseRequestBody(w http.ResponseWriter, r *http.Request) error {",
+		"values, err := decodeJSONBody(http.MaxBytesReader(w, r.Body, maxRequestBody))",
+		// net/http leaves DELETE bodies unread
+		"if r.Method == http.MethodDelete {",
+		"http.Error(w, \"Unsupported Media Type\", http.StatusUnsupportedMediaType)",
+		`"mime"`,
+		`"net/url"`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+
+	// Only handlers with parameters decode the body
+	if got := strings.Count(code, "if err := parseRequestBody(w, r); err != nil {"); got != 1 {
+		t.Errorf("expected 1 body decoding, got %d", got)
+	}
+}
+
+func TestGenerateWithoutRequestBody(t *testing.T) {
+	file := strictFile(t, `func ping() error {
+  return nil
+}`, `<p>ok</p>`)
+
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if strings.Contains(code, "parseRequestBody") || strings.Contains(code, `"mime"`) {
+		t.Error("handlers without parameters should not decode the body")
+	}
+}
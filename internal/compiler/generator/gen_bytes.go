@@ -0,0 +1,217 @@
+package generator
+
+import (
+	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"strconv"
+	"strings"
+)
+
+// defaultBlobParamMaxSize caps uploaded bytes parameters when no field declares @maxSize
+const defaultBlobParamMaxSize = 1 << 20
+
+// byteSizeUnits are the suffixes accepted by @maxSize, in bytes
+var byteSizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// isBytesField reports whether a field holds a binary payload (data: bytes),
+// images included (photo: image)
+func isBytesField(field *ast.FieldDecl) bool {
+	return field.Type == "bytes" || isImageField(field)
+}
+
+// needsBlob checks if the file uses the Blob type, in models, script parameters or storage services
+func (g *Generator) needsBlob(file *ast.GMXFile) bool {
+	if g.hasFieldMatch(file, isBytesField) || g.hasStorage(file, "s3", "upload", "download") || g.hasStorage(file, "local", "upload", "download") {
+		return true
+	}
+	if file.Script == nil {
+		return false
+	}
+	for _, fn := range file.Script.Funcs {
+		for _, param := range fn.Params {
+			if param.Type == "bytes" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseByteSize parses a @maxSize value: 65536, "64KB" or "1MB"
+func parseByteSize(size string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(size))
+	factor := int64(1)
+	for _, unit := range byteSizeUnits {
+		if n, ok := strings.CutSuffix(s, unit.suffix); ok {
+			s, factor = strings.TrimSpace(n), unit.factor
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q: use a positive number of bytes, KB or MB", size)
+	}
+	return n * factor, nil
+}
+
+// maxSizeOf returns the @maxSize of a bytes field in bytes, or 0 when unbounded
+func maxSizeOf(field *ast.FieldDecl) int64 {
+	for _, ann := range field.Annotations {
+		if ann.Name == "maxSize" {
+			if n, err := parseByteSize(ann.SimpleArg()); err == nil {
+				return n
+			}
+		}
+	}
+	return 0
+}
+
+// validateBytesFields checks that @maxSize is used on bytes fields with a valid size
+func (g *Generator) validateBytesFields(file *ast.GMXFile) error {
+	for _, model := range file.Models {
+		for _, field := range model.Fields {
+			for _, ann := range field.Annotations {
+				if ann.Name != "maxSize" {
+					continue
+				}
+				if !isBytesField(field) {
+					return fmt.Errorf("model %s: @maxSize requires a bytes field, got %s %q", model.Name, field.Type, field.Name)
+				}
+				if _, err := parseByteSize(ann.SimpleArg()); err != nil {
+					return fmt.Errorf("model %s: @maxSize of %q: %w", model.Name, field.Name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// blobParamMaxSize returns the upload limit of bytes parameters: the largest
+// @maxSize of the file's bytes fields, or defaultBlobParamMaxSize
+func (g *Generator) blobParamMaxSize(file *ast.GMXFile) int64 {
+	var limit int64
+	for _, model := range file.Models {
+		for _, field := range model.Fields {
+			limit = max(limit, maxSizeOf(field))
+		}
+	}
+	if limit == 0 {
+		return defaultBlobParamMaxSize
+	}
+	return limit
+}
+
+// genBlobHelpers generates the Blob type of bytes fields, kept out of JSON
+// and printed as its size in templates, with streaming read/write helpers
+func (g *Generator) genBlobHelpers(file *ast.GMXFile) string {
+	var b strings.Builder
+
+	b.WriteString("// Blob is a small binary payload of a bytes field\n")
+	b.WriteString("type Blob []byte\n\n")
+
+	b.WriteString(fmt.Sprintf("// blobParamMaxSize caps uploaded bytes parameters\nconst blobParamMaxSize = %d\n\n", g.blobParamMaxSize(file)))
+
+	b.WriteString("// errBlobTooLarge reports a payload over its size limit\n")
+	b.WriteString("var errBlobTooLarge = errors.New(\"payload too large\")\n\n")
+
+	b.WriteString("// String prints the payload size, never its content: {{.Avatar}} → \"12.5 KB\"\n")
+	b.WriteString("func (b Blob) String() string {\n")
+	b.WriteString("\tswitch n := len(b); {\n")
+	b.WriteString("\tcase n >= 1<<20:\n")
+	b.WriteString("\t\treturn fmt.Sprintf(\"%.1f MB\", float64(n)/(1<<20))\n")
+	b.WriteString("\tcase n >= 1<<10:\n")
+	b.WriteString("\t\treturn fmt.Sprintf(\"%.1f KB\", float64(n)/(1<<10))\n")
+	b.WriteString("\tdefault:\n")
+	b.WriteString("\t\treturn fmt.Sprintf(\"%d B\", n)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// Scan implements sql.Scanner, copying the driver's buffer\n")
+	b.WriteString("func (b *Blob) Scan(value any) error {\n")
+	b.WriteString("\tswitch v := value.(type) {\n")
+	b.WriteString("\tcase nil:\n")
+	b.WriteString("\t\t*b = nil\n")
+	b.WriteString("\tcase []byte:\n")
+	b.WriteString("\t\t*b = append(Blob(nil), v...)\n")
+	b.WriteString("\tcase string:\n")
+	b.WriteString("\t\t*b = Blob(v)\n")
+	b.WriteString("\tdefault:\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"scanning bytes: unsupported type %T\", value)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn nil\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// Value implements driver.Valuer\n")
+	b.WriteString("func (b Blob) Value() (driver.Value, error) {\n")
+	b.WriteString("\tif b == nil {\n")
+	b.WriteString("\t\treturn nil, nil\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn []byte(b), nil\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// GormDataType stores the payload as BLOB (bytea on PostgreSQL)\n")
+	b.WriteString("func (Blob) GormDataType() string {\n")
+	b.WriteString("\treturn \"bytes\"\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// WriteTo streams the payload to w\n")
+	b.WriteString("func (b Blob) WriteTo(w io.Writer) (int64, error) {\n")
+	b.WriteString("\tn, err := w.Write(b)\n")
+	b.WriteString("\treturn int64(n), err\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// readBlob reads a payload from r, stopping as soon as it exceeds maxSize bytes\n")
+	b.WriteString("func readBlob(r io.Reader, maxSize int64) (Blob, error) {\n")
+	b.WriteString("\tdata, err := io.ReadAll(io.LimitReader(r, maxSize+1))\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\treturn nil, fmt.Errorf(\"reading payload: %w\", err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif int64(len(data)) > maxSize {\n")
+	b.WriteString("\t\treturn nil, fmt.Errorf(\"%w: maximum size is %d bytes\", errBlobTooLarge, maxSize)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn Blob(data), nil\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// genBlobParam generates the extraction of a bytes parameter from the
+// uploaded file of the same name
+func genBlobParam(name string) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("\t%sFile, _, err := r.FormFile(%q)\n", name, name))
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString(fmt.Sprintf("\t\thttp.Error(w, \"Missing required file: %s\", http.StatusBadRequest)\n", name))
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString(fmt.Sprintf("\t%s, err := readBlob(%sFile, blobParamMaxSize)\n", name, name))
+	b.WriteString(fmt.Sprintf("\tif closeErr := %sFile.Close(); err == nil {\n", name))
+	b.WriteString("\t\terr = closeErr\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif errors.Is(err, errBlobTooLarge) {\n")
+	b.WriteString(fmt.Sprintf("\t\thttp.Error(w, \"File too large: %s\", http.StatusRequestEntityTooLarge)\n", name))
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString(fmt.Sprintf("\t\thttp.Error(w, \"Invalid file parameter: %s\", http.StatusBadRequest)\n", name))
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	return b.String()
+}
+
+// blobValidation returns the Validate() check of a bytes field's @maxSize
+func blobValidation(recv, fieldName string, field *ast.FieldDecl) []string {
+	maxSize := maxSizeOf(field)
+	if maxSize == 0 {
+		return nil
+	}
+	return []string{fmt.Sprintf("\tif len(%s.%s) > %d {\n\t\t%s\n\t}", recv, fieldName, maxSize,
+		validationReturn(field.Name, fmt.Sprintf("maximum size is %d bytes, got %%d", maxSize), "len("+recv+"."+fieldName+")"))}
+}
@@ -0,0 +1,120 @@
+package generator
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{"65536", 65536, false},
+		{"64KB", 64 << 10, false},
+		{"64kb", 64 << 10, false},
+		{"2 MB", 2 << 20, false},
+		{"512B", 512, false},
+		{"0", 0, true},
+		{"-1KB", 0, true},
+		{"1.5MB", 0, true},
+		{"big", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.size, func(t *testing.T) {
+			got, err := parseByteSize(tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseByteSize(%q) error = %v, wantErr %v", tt.size, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseByteSize(%q) = %d, want %d", tt.size, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateBytesField(t *testing.T) {
+	file := &ast.GMXFile{
+		Models: []*ast.ModelDecl{
+			{
+				Name: "Attachment",
+				Fields: []*ast.FieldDecl{
+					{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{{Name: "pk"}}},
+					{Name: "data", Type: "bytes", Annotations: []*ast.Annotation{
+						{Name: "maxSize", Args: map[string]string{"_": "64KB"}},
+					}},
+				},
+			},
+		},
+		Script: &ast.ScriptBlock{
+			Funcs: []*ast.FuncDecl{
+				{Name: "upload", Params: []*ast.Param{{Name: "data", Type: "bytes"}}, Body: []ast.Statement{}},
+			},
+		},
+	}
+
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	if !regexp.MustCompile(`Data\s+Blob\s+` + "`json:\"-\"`").MatchString(code) {
+		t.Error("bytes field should have the Blob type and be excluded from JSON")
+	}
+
+	expected := []string{
+		"type Blob []byte",
+		"func (b Blob) String() string",
+		"func (b Blob) WriteTo(w io.Writer) (int64, error)",
+		"func readBlob(r io.Reader, maxSize int64) (Blob, error)",
+		"const blobParamMaxSize = 65536",
+		"if len(a.Data) > 65536 {",
+		`dataFile, _, err := r.FormFile("data")`,
+		"data, err := readBlob(dataFile, blobParamMaxSize)",
+		"http.StatusRequestEntityTooLarge",
+		"func upload(ctx *GMXContext, data Blob) error",
+		`"errors"`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(code, exp) {
+			t.Errorf("Generated code missing %q", exp)
+		}
+	}
+}
+
+func TestGenerateBytesFieldErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		field   *ast.FieldDecl
+		wantErr string
+	}{
+		{
+			name:    "maxSize on a string",
+			field:   &ast.FieldDecl{Name: "name", Type: "string", Annotations: []*ast.Annotation{{Name: "maxSize", Args: map[string]string{"_": "1KB"}}}},
+			wantErr: "@maxSize requires a bytes field",
+		},
+		{
+			name:    "invalid size",
+			field:   &ast.FieldDecl{Name: "data", Type: "bytes", Annotations: []*ast.Annotation{{Name: "maxSize", Args: map[string]string{"_": "lots"}}}},
+			wantErr: "invalid size",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := &ast.GMXFile{Models: []*ast.ModelDecl{{Name: "Attachment", Fields: []*ast.FieldDecl{tt.field}}}}
+			_, err := New().Generate(file)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// redisPingTimeout bounds the connection check of a redis service at startup
+const redisPingTimeout = "5 * time.Second"
+
+// cacheMethods lists the methods a redis service may declare; time to
+// live are in seconds, 0 keeping the key until it is deleted
+var cacheMethods = map[string]providerMethod{
+	"get":    {params: []string{"string"}, ret: "string", goSig: "(key string) (string, error)"},
+	"set":    {params: []string{"string", "string", "int"}, ret: "error", goSig: "(key, value string, ttl int) error"},
+	"delete": {params: []string{"string"}, ret: "error", goSig: "(key string) error"},
+	"expire": {params: []string{"string", "int"}, ret: "error", goSig: "(key string, ttl int) error"},
+}
+
+// redisClient returns the package variable holding the connection pool of a
+// redis service, which the remember blocks of the script read
+func redisClient(svc *ast.ServiceDecl) string {
+	return utils.LowerFirst(svc.Name) + "Redis"
+}
+
+// cacheServiceNames returns the names of the redis services
+func (g *Generator) cacheServiceNames(file *ast.GMXFile) []string {
+	var names []string
+	for _, svc := range file.Services {
+		if svc.Provider == "redis" {
+			names = append(names, svc.Name)
+		}
+	}
+	return names
+}
+
+// hasRedisPoolSize checks if a redis service sizes its connection pool
+func (g *Generator) hasRedisPoolSize(file *ast.GMXFile) bool {
+	for _, svc := range file.Services {
+		if svc.Provider == "redis" && findServiceField(svc, "poolSize") != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// validateCacheServices checks that the redis services declare their server
+// and the signatures of their methods
+func (g *Generator) validateCacheServices(file *ast.GMXFile) error {
+	for _, svc := range file.Services {
+		if svc.Provider != "redis" {
+			continue
+		}
+		if findServiceField(svc, "url") == nil {
+			return fmt.Errorf("service %s: redis provider requires a `url` field", svc.Name)
+		}
+		if err := checkProviderMethods(svc, cacheMethods, "get, set, delete or expire"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// genRedisClient generates the connection pool of a redis service and, with
+// methods, its implementation
+func (g *Generator) genRedisClient(svc *ast.ServiceDecl) string {
+	var b strings.Builder
+
+	client := redisClient(svc)
+	b.WriteString(fmt.Sprintf("// %s is the connection pool of the %s service\n", client, svc.Name))
+	b.WriteString(fmt.Sprintf("var %s *redis.Client\n\n", client))
+
+	b.WriteString(fmt.Sprintf("// connect%s opens the connection pool of the %s service; a cache only\n", svc.Name, svc.Name))
+	b.WriteString("// speeds reads up, so an unreachable server is logged rather than fatal\n")
+	b.WriteString(fmt.Sprintf("func connect%s(cfg *%sConfig) {\n", svc.Name, svc.Name))
+	b.WriteString("\topts, err := redis.ParseURL(cfg.Url)\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString(fmt.Sprintf("\t\tlog.Fatalf(\"service %s: invalid Redis URL: %%v\", err)\n", svc.Name))
+	b.WriteString("\t}\n")
+	if findServiceField(svc, "poolSize") != nil {
+		b.WriteString("\tif cfg.PoolSize != \"\" {\n")
+		b.WriteString("\t\tsize, err := strconv.Atoi(cfg.PoolSize)\n")
+		b.WriteString("\t\tif err != nil || size < 1 {\n")
+		b.WriteString(fmt.Sprintf("\t\t\tlog.Fatalf(\"service %s: invalid pool size %%q: expected a positive count\", cfg.PoolSize)\n", svc.Name))
+		b.WriteString("\t\t}\n")
+		b.WriteString("\t\topts.PoolSize = size\n")
+		b.WriteString("\t}\n")
+	}
+	b.WriteString(fmt.Sprintf("\t%s = redis.NewClient(opts)\n\n", client))
+	b.WriteString(fmt.Sprintf("\tctx, cancel := context.WithTimeout(context.Background(), %s)\n", redisPingTimeout))
+	b.WriteString("\tdefer cancel()\n")
+	b.WriteString(fmt.Sprintf("\tif err := %s.Ping(ctx).Err(); err != nil {\n", client))
+	b.WriteString(fmt.Sprintf("\t\tslog.Warn(\"Redis unreachable\", \"service\", %q, \"error\", err)\n", svc.Name))
+	b.WriteString("\t}\n")
+	b.WriteString("}\n")
+
+	if len(svc.Methods) == 0 {
+		return b.String()
+	}
+
+	implName := utils.LowerFirst(svc.Name) + "Impl"
+	b.WriteString("\n")
+	b.WriteString(g.genProviderInterface(svc, cacheMethods))
+	b.WriteString("\n")
+	b.WriteString(fmt.Sprintf("// %s reads and writes the keys of the %s service in Redis\n", implName, svc.Name))
+	b.WriteString(fmt.Sprintf("type %s struct {\n", implName))
+	b.WriteString(fmt.Sprintf("\tconfig *%sConfig\n", svc.Name))
+	b.WriteString("}\n\n")
+
+	for _, method := range svc.Methods {
+		if method.Body != nil {
+			b.WriteString(g.genScriptServiceMethod("s", implName, method))
+			continue
+		}
+		b.WriteString(fmt.Sprintf("func (s *%s) %s%s {\n", implName, utils.ToPascalCase(method.Name), cacheMethods[method.Name].goSig))
+		switch method.Name {
+		case "get":
+			b.WriteString(fmt.Sprintf("\tvalue, err := %s.Get(context.Background(), key).Result()\n", client))
+			b.WriteString("\t// A missing key reads as empty\n")
+			b.WriteString("\tif errors.Is(err, redis.Nil) {\n")
+			b.WriteString("\t\treturn \"\", nil\n")
+			b.WriteString("\t}\n")
+			b.WriteString("\treturn value, err\n")
+		case "set":
+			b.WriteString(fmt.Sprintf("\treturn %s.Set(context.Background(), key, value, time.Duration(ttl)*time.Second).Err()\n", client))
+		case "delete":
+			b.WriteString(fmt.Sprintf("\treturn %s.Del(context.Background(), key).Err()\n", client))
+		case "expire":
+			b.WriteString(fmt.Sprintf("\treturn %s.Expire(context.Background(), key, time.Duration(ttl)*time.Second).Err()\n", client))
+		}
+		b.WriteString("}\n\n")
+	}
+
+	b.WriteString(fmt.Sprintf("// new%sService creates the Redis instance of %sService\n", svc.Name, svc.Name))
+	b.WriteString(fmt.Sprintf("func new%sService(cfg *%sConfig) %sService {\n", svc.Name, svc.Name, svc.Name))
+	b.WriteString(fmt.Sprintf("\treturn &%s{config: cfg}\n", implName))
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// genCacheHelpers generates cacheRemember, which the remember blocks of the
+// script call with the records they load
+func (g *Generator) genCacheHelpers() string {
+	var b strings.Builder
+
+	b.WriteString("// cacheRemember returns the records cached under key, or loads them and caches\n")
+	b.WriteString("// them for ttl; a cache only speeds reads up, so a Redis failure is logged and\n")
+	b.WriteString("// the records loaded, while a failing load is returned and not cached\n")
+	b.WriteString("func cacheRemember[T any](client *redis.Client, key string, ttl time.Duration, load func() (T, error)) (T, error) {\n")
+	b.WriteString("\tctx := context.Background()\n")
+	b.WriteString("\tdata, err := client.Get(ctx, key).Bytes()\n")
+	b.WriteString("\tif err == nil {\n")
+	b.WriteString("\t\tvar cached T\n")
+	b.WriteString("\t\t// Records cached before a model changed may not decode: they are loaded again\n")
+	b.WriteString("\t\tif err := json.Unmarshal(data, &cached); err == nil {\n")
+	b.WriteString("\t\t\treturn cached, nil\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t} else if !errors.Is(err, redis.Nil) {\n")
+	b.WriteString("\t\tslog.Warn(\"cache: reading\", \"key\", key, \"error\", err)\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\trecords, err := load()\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\treturn records, err\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif data, err := json.Marshal(records); err != nil {\n")
+	b.WriteString("\t\tslog.Warn(\"cache: encoding\", \"key\", key, \"error\", err)\n")
+	b.WriteString("\t} else if err := client.Set(ctx, key, data, ttl).Err(); err != nil {\n")
+	b.WriteString("\t\tslog.Warn(\"cache: writing\", \"key\", key, \"error\", err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn records, nil\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
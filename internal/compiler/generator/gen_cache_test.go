@@ -0,0 +1,148 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+func cacheFile() *ast.GMXFile {
+	return &ast.GMXFile{
+		Models: []*ast.ModelDecl{
+			{Name: "Task", Fields: []*ast.FieldDecl{
+				{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{{Name: "pk"}}},
+				{Name: "done", Type: "bool"},
+			}},
+		},
+		Services: []*ast.ServiceDecl{{
+			Name:     "Cache",
+			Provider: "redis",
+			Fields: []*ast.ServiceField{
+				{Name: "url", Type: "string", EnvVar: "REDIS_URL"},
+				{Name: "poolSize", Type: "string", EnvVar: "REDIS_POOL_SIZE", Annotations: []*ast.Annotation{{Name: "default", Args: map[string]string{"_": "10"}}}},
+			},
+			Methods: []*ast.ServiceMethod{
+				{Name: "get", Params: []*ast.Param{{Name: "key", Type: "string"}}, ReturnType: "string"},
+				{Name: "set", Params: []*ast.Param{{Name: "key", Type: "string"}, {Name: "value", Type: "string"}, {Name: "ttl", Type: "int"}}, ReturnType: "error"},
+				{Name: "delete", Params: []*ast.Param{{Name: "key", Type: "string"}}, ReturnType: "error"},
+				{Name: "expire", Params: []*ast.Param{{Name: "key", Type: "string"}, {Name: "ttl", Type: "int"}}, ReturnType: "error"},
+			},
+		}},
+		Script: &ast.ScriptBlock{Funcs: []*ast.FuncDecl{{
+			Name:       "listOpen",
+			ReturnType: "error",
+			Body: []ast.Statement{
+				&ast.LetStmt{Name: "tasks", Value: &ast.TryExpr{Expr: &ast.RememberExpr{
+					Cache: "Cache",
+					Key:   &ast.StringLit{Value: "tasks:open"},
+					TTL:   &ast.DurationLit{Value: "5m"},
+					Body: []ast.Statement{&ast.ReturnStmt{Value: &ast.CallExpr{
+						Function: &ast.MemberExpr{Object: &ast.Ident{Name: "Task"}, Property: "where"},
+						Args:     []ast.Expression{&ast.NamedArg{Name: "done", Value: &ast.BoolLit{Value: false}}},
+					}}},
+				}}},
+				&ast.ReturnStmt{Value: &ast.CallExpr{Function: &ast.Ident{Name: "render"}, Args: []ast.Expression{&ast.Ident{Name: "tasks"}}}},
+			},
+		}}},
+	}
+}
+
+func TestGenerator_Cache(t *testing.T) {
+	code, err := New().Generate(cacheFile())
+	if err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+	for _, want := range []string{
+		"\t\"github.com/redis/go-redis/v9\"\n",
+		"var cacheRedis *redis.Client\n",
+		"\topts.PoolSize = size\n",
+		"\tcacheCfg := initCache()\n\tconnectCache(cacheCfg)\n",
+		"\tGet(key string) (string, error)\n",
+		"\tif errors.Is(err, redis.Nil) {\n",
+		"return cacheRedis.Set(context.Background(), key, value, time.Duration(ttl)*time.Second).Err()",
+		"return cacheRedis.Expire(context.Background(), key, time.Duration(ttl)*time.Second).Err()",
+		"func cacheRemember[T any](client *redis.Client, key string, ttl time.Duration, load func() (T, error)) (T, error) {",
+		`tasks, err := cacheRemember(cacheRedis, "tasks:open", 5*time.Minute, func() ([]Task, error) {`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in generated code", want)
+		}
+	}
+	if strings.Contains(code, "(stub)") {
+		t.Error("expected the redis methods to be implemented, not stubbed")
+	}
+}
+
+func TestGenerator_CacheWithoutMethods(t *testing.T) {
+	file := cacheFile()
+	svc := file.Services[0]
+	svc.Fields = svc.Fields[:1]
+	svc.Methods = nil
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+	// Remember blocks still reach the connection pool
+	if !strings.Contains(code, "connectCache(cacheCfg)") || strings.Contains(code, "CacheService") {
+		t.Error("expected the connection pool without a service interface")
+	}
+	if strings.Contains(code, "strconv.Atoi(cfg.PoolSize)") {
+		t.Error("unexpected pool size parsing without a poolSize field")
+	}
+}
+
+func TestGenerator_CacheErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(file *ast.GMXFile)
+		wantErr string
+	}{
+		{
+			name: "missing url",
+			modify: func(file *ast.GMXFile) {
+				file.Services[0].Fields = file.Services[0].Fields[1:]
+			},
+			wantErr: "service Cache: redis provider requires a `url` field",
+		},
+		{
+			name: "unknown method",
+			modify: func(file *ast.GMXFile) {
+				svc := file.Services[0]
+				svc.Methods = append(svc.Methods, &ast.ServiceMethod{Name: "incr", Params: []*ast.Param{{Name: "key", Type: "string"}}, ReturnType: "int"})
+			},
+			wantErr: "service Cache: unsupported redis method incr (expected get, set, delete or expire, or a script body)",
+		},
+		{
+			name: "signature",
+			modify: func(file *ast.GMXFile) {
+				file.Services[0].Methods[1].Params = file.Services[0].Methods[1].Params[:2]
+			},
+			wantErr: "service Cache: method set takes (string, string, int) and returns error",
+		},
+		{
+			name: "remember on another provider",
+			modify: func(file *ast.GMXFile) {
+				file.Services[0].Provider = "memcached"
+			},
+			wantErr: "Cache.remember: Cache is not a redis service",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := cacheFile()
+			tt.modify(file)
+			_, err := New().Generate(file)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package generator
+
+import (
+	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"strings"
+)
+
+// Dev-only page setting the faults injected into HTTP service requests
+const (
+	chaosPath     = "/__gmx/chaos"
+	chaosSavePath = "/__gmx/chaos/save"
+)
+
+// hasChaos checks if a dev build injects faults into HTTP service clients
+func (g *Generator) hasChaos(file *ast.GMXFile) bool {
+	return g.opts.Dev && g.hasServiceWithProvider(file, "http")
+}
+
+// chaosEnvPrefix returns the prefix of the environment variables setting the
+// faults of a service: GMX_CHAOS_GITHUB for GitHub
+func chaosEnvPrefix(svc *ast.ServiceDecl) string {
+	return "GMX_CHAOS_" + strings.ToUpper(svc.Name)
+}
+
+// genChaos generates the fault injection of dev builds: a transport delaying
+// or failing the requests of each HTTP service client, set from the
+// environment on startup and changed at chaosPath while the server runs
+func (g *Generator) genChaos(services []*ast.ServiceDecl) string {
+	var b strings.Builder
+
+	var names []string
+	for _, svc := range services {
+		if svc.Provider == "http" {
+			names = append(names, fmt.Sprintf("%q", svc.Name))
+		}
+	}
+
+	b.WriteString("// chaosFault is the fault injected into the requests of an HTTP service\n")
+	b.WriteString("type chaosFault struct {\n")
+	b.WriteString("\tLatency   time.Duration\n")
+	b.WriteString("\tErrorRate float64\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// chaosServices lists the HTTP services, in declaration order\n")
+	b.WriteString(fmt.Sprintf("var chaosServices = []string{%s}\n\n", strings.Join(names, ", ")))
+
+	b.WriteString("// chaosFaults holds the fault of each HTTP service\n")
+	b.WriteString("var (\n")
+	b.WriteString("\tchaosMu     sync.Mutex\n")
+	b.WriteString("\tchaosFaults = make(map[string]chaosFault)\n")
+	b.WriteString(")\n\n")
+
+	b.WriteString("// parseChaosFault parses a latency such as 500ms and an error rate between\n")
+	b.WriteString("// 0 and 1; empty values inject nothing\n")
+	b.WriteString("func parseChaosFault(latency, errorRate string) (chaosFault, error) {\n")
+	b.WriteString("\tvar fault chaosFault\n")
+	b.WriteString("\tif latency != \"\" {\n")
+	b.WriteString("\t\td, err := time.ParseDuration(latency)\n")
+	b.WriteString("\t\tif err != nil || d < 0 {\n")
+	b.WriteString("\t\t\treturn fault, fmt.Errorf(\"invalid latency %q, expected a duration such as 500ms\", latency)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tfault.Latency = d\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif errorRate != \"\" {\n")
+	b.WriteString("\t\trate, err := strconv.ParseFloat(errorRate, 64)\n")
+	b.WriteString("\t\tif err != nil || rate < 0 || rate > 1 {\n")
+	b.WriteString("\t\t\treturn fault, fmt.Errorf(\"invalid error rate %q, expected a number between 0 and 1\", errorRate)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tfault.ErrorRate = rate\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn fault, nil\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// loadChaosFaults sets the fault of each service from <prefix>_LATENCY and\n")
+	b.WriteString("// <prefix>_ERROR_RATE, GMX_CHAOS applying to every service\n")
+	b.WriteString("func loadChaosFaults() {\n")
+	b.WriteString("\tprefixes := map[string]string{\n")
+	for _, svc := range services {
+		if svc.Provider == "http" {
+			b.WriteString(fmt.Sprintf("\t\t%q: %q,\n", svc.Name, chaosEnvPrefix(svc)))
+		}
+	}
+	b.WriteString("\t}\n")
+	b.WriteString("\tfor _, name := range chaosServices {\n")
+	b.WriteString("\t\tlatency, errorRate := os.Getenv(\"GMX_CHAOS_LATENCY\"), os.Getenv(\"GMX_CHAOS_ERROR_RATE\")\n")
+	b.WriteString("\t\tif v := os.Getenv(prefixes[name] + \"_LATENCY\"); v != \"\" {\n")
+	b.WriteString("\t\t\tlatency = v\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tif v := os.Getenv(prefixes[name] + \"_ERROR_RATE\"); v != \"\" {\n")
+	b.WriteString("\t\t\terrorRate = v\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tfault, err := parseChaosFault(latency, errorRate)\n")
+	b.WriteString("\t\tif err != nil {\n")
+	b.WriteString("\t\t\tlog.Fatalf(\"chaos: %s: %v\", name, err)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tchaosFaults[name] = fault\n")
+	b.WriteString("\t\tif fault != (chaosFault{}) {\n")
+	b.WriteString(fmt.Sprintf("\t\t\tslog.Info(\"Dev mode: service requests are delayed and fail (see %s)\", \"service\", name, \"latency\", fault.Latency, \"error_rate\", fault.ErrorRate)\n", chaosPath))
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// chaosTransport injects the fault of its service into each request\n")
+	b.WriteString("type chaosTransport struct {\n")
+	b.WriteString("\tservice string\n")
+	b.WriteString("\tnext    http.RoundTripper\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("func (t *chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {\n")
+	b.WriteString("\tchaosMu.Lock()\n")
+	b.WriteString("\tfault := chaosFaults[t.service]\n")
+	b.WriteString("\tchaosMu.Unlock()\n\n")
+	b.WriteString("\t// A RoundTripper closes the request body, even when failing\n")
+	b.WriteString("\tfail := func(err error) (*http.Response, error) {\n")
+	b.WriteString("\t\tif req.Body != nil {\n")
+	b.WriteString("\t\t\t_ = req.Body.Close()\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\treturn nil, err\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif fault.Latency > 0 {\n")
+	b.WriteString("\t\ttimer := time.NewTimer(fault.Latency)\n")
+	b.WriteString("\t\tselect {\n")
+	b.WriteString("\t\tcase <-timer.C:\n")
+	b.WriteString("\t\tcase <-req.Context().Done():\n")
+	b.WriteString("\t\t\ttimer.Stop()\n")
+	b.WriteString("\t\t\treturn fail(req.Context().Err())\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif fault.ErrorRate > 0 && mrand.Float64() < fault.ErrorRate {\n")
+	b.WriteString(fmt.Sprintf("\t\treturn fail(fmt.Errorf(\"chaos: injected failure of the %%s service (see %s)\", t.service))\n", chaosPath))
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn t.next.RoundTrip(req)\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// handleChaos lists the HTTP services with a form to change the fault of each\n")
+	b.WriteString("func handleChaos(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString("\tif r.Method != http.MethodGet {\n")
+	b.WriteString("\t\thttp.Error(w, \"Method Not Allowed\", http.StatusMethodNotAllowed)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif !isLocalRequest(r) {\n")
+	b.WriteString("\t\thttp.Error(w, \"Forbidden\", http.StatusForbidden)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\t// The forms submit the CSRF token themselves\n")
+	b.WriteString("\tcsrfToken := generateCSRFToken()\n")
+	b.WriteString("\tif cookie, err := r.Cookie(\"_csrf\"); err == nil {\n")
+	b.WriteString("\t\tcsrfToken = cookie.Value\n")
+	b.WriteString("\t} else {\n")
+	b.WriteString("\t\thttp.SetCookie(w, &http.Cookie{\n")
+	b.WriteString("\t\t\tName:     \"_csrf\",\n")
+	b.WriteString("\t\t\tValue:    csrfToken,\n")
+	b.WriteString("\t\t\tPath:     \"/\",\n")
+	b.WriteString("\t\t\tHttpOnly: false,\n")
+	b.WriteString("\t\t\tSameSite: http.SameSiteStrictMode,\n")
+	b.WriteString("\t\t\tSecure:   r.TLS != nil,\n")
+	b.WriteString("\t\t})\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\tchaosMu.Lock()\n")
+	b.WriteString("\tfaults := make(map[string]chaosFault, len(chaosFaults))\n")
+	b.WriteString("\tfor name, fault := range chaosFaults {\n")
+	b.WriteString("\t\tfaults[name] = fault\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tchaosMu.Unlock()\n\n")
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/html; charset=utf-8\")\n")
+	b.WriteString("\tfmt.Fprint(w, `<!DOCTYPE html><html><head><meta charset=\"utf-8\"><title>Chaos</title></head>`+\n")
+	b.WriteString("\t\t`<body style=\"font-family: sans-serif; max-width: 48rem; margin: 2rem auto\"><h1>Chaos</h1>`+\n")
+	b.WriteString("\t\t`<p>Faults injected into the requests of the HTTP services. An empty latency or error rate injects nothing.</p>`)\n")
+	b.WriteString("\tfor _, name := range chaosServices {\n")
+	b.WriteString("\t\tfault := faults[name]\n")
+	b.WriteString("\t\tlatency := \"\"\n")
+	b.WriteString("\t\tif fault.Latency > 0 {\n")
+	b.WriteString("\t\t\tlatency = fault.Latency.String()\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\terrorRate := \"\"\n")
+	b.WriteString("\t\tif fault.ErrorRate > 0 {\n")
+	b.WriteString("\t\t\terrorRate = strconv.FormatFloat(fault.ErrorRate, 'g', -1, 64)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString(fmt.Sprintf("\t\tfmt.Fprintf(w, `<form method=\"post\" action=%q><h2>%%s</h2>`+\n", chaosSavePath))
+	b.WriteString("\t\t\t`<label>Latency <input name=\"latency\" placeholder=\"500ms\" value=\"%s\"></label> `+\n")
+	b.WriteString("\t\t\t`<label>Error rate <input name=\"errorRate\" type=\"number\" min=\"0\" max=\"1\" step=\"any\" placeholder=\"0.2\" value=\"%s\"></label>`+\n")
+	b.WriteString("\t\t\t`<input type=\"hidden\" name=\"service\" value=\"%s\"><input type=\"hidden\" name=\"_csrf\" value=\"%s\"> <button>Save</button></form>`,\n")
+	b.WriteString("\t\t\tname, latency, errorRate, name, csrfToken)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tfmt.Fprint(w, `</body></html>`)\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// handleChaosSave sets the fault of a service submitted from the chaos page\n")
+	b.WriteString("func handleChaosSave(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString("\tif r.Method != http.MethodPost {\n")
+	b.WriteString("\t\thttp.Error(w, \"Method Not Allowed\", http.StatusMethodNotAllowed)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif !isLocalRequest(r) {\n")
+	b.WriteString("\t\thttp.Error(w, \"Forbidden\", http.StatusForbidden)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\tname := r.FormValue(\"service\")\n")
+	b.WriteString("\tfault, err := parseChaosFault(strings.TrimSpace(r.FormValue(\"latency\")), strings.TrimSpace(r.FormValue(\"errorRate\")))\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\thttp.Error(w, err.Error(), http.StatusBadRequest)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tchaosMu.Lock()\n")
+	b.WriteString("\t_, ok := chaosFaults[name]\n")
+	b.WriteString("\tif ok {\n")
+	b.WriteString("\t\tchaosFaults[name] = fault\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tchaosMu.Unlock()\n")
+	b.WriteString("\tif !ok {\n")
+	b.WriteString("\t\thttp.Error(w, fmt.Sprintf(\"Unknown service %q\", name), http.StatusBadRequest)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\trequestLogger(r).Info(\"chaos: faults changed\", \"service\", name, \"latency\", fault.Latency, \"error_rate\", fault.ErrorRate)\n")
+	b.WriteString(fmt.Sprintf("\thttp.Redirect(w, r, %q, http.StatusSeeOther)\n", chaosPath))
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
@@ -0,0 +1,82 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+func TestGenerator_Chaos(t *testing.T) {
+	file := &ast.GMXFile{
+		Models: []*ast.ModelDecl{
+			{Name: "Note", Fields: []*ast.FieldDecl{
+				{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{{Name: "pk"}}},
+			}},
+		},
+		Services: []*ast.ServiceDecl{
+			{Name: "GitHub", Provider: "http", Fields: []*ast.ServiceField{{Name: "baseUrl", Type: "string", EnvVar: "GITHUB_API_URL"}}},
+			{Name: "Stripe", Provider: "http", Fields: []*ast.ServiceField{{Name: "baseUrl", Type: "string", EnvVar: "STRIPE_API_URL"}}},
+		},
+		Template: &ast.TemplateBlock{Source: "<h1>Hello</h1>"},
+	}
+
+	tests := []struct {
+		name       string
+		dev        bool
+		expected   []string
+		unexpected []string
+	}{
+		{
+			name: "dev",
+			dev:  true,
+			expected: []string{
+				`var chaosServices = []string{"GitHub", "Stripe"}`,
+				`"GitHub": "GMX_CHAOS_GITHUB",`,
+				`http:   &http.Client{Timeout: 30 * time.Second, Transport: &chaosTransport{service: "Stripe", next: http.DefaultTransport}},`,
+				"\tloadChaosFaults()\n",
+				`case <-req.Context().Done():`,
+				`mux.HandleFunc("/__gmx/chaos", handleChaos)`,
+				`mux.HandleFunc("/__gmx/chaos/save", handleChaosSave)`,
+				`"strconv"`,
+			},
+		},
+		{
+			name:       "production",
+			dev:        false,
+			expected:   []string{`http:   &http.Client{Timeout: 30 * time.Second},`},
+			unexpected: []string{"chaosTransport", "loadChaosFaults", chaosPath},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := NewWithOptions(Options{Dev: tt.dev}).Generate(file)
+			if err != nil {
+				t.Fatalf("Generate() error: %v", err)
+			}
+			if !isValidGo(code) {
+				t.Errorf("Generated code is not valid Go:\n%s", code)
+			}
+			for _, want := range tt.expected {
+				if !strings.Contains(code, want) {
+					t.Errorf("expected %q in generated code", want)
+				}
+			}
+			for _, unwanted := range tt.unexpected {
+				if strings.Contains(code, unwanted) {
+					t.Errorf("unexpected %q in generated code", unwanted)
+				}
+			}
+		})
+	}
+
+	// Without HTTP services, dev builds have no chaos page
+	code, err := NewWithOptions(Options{Dev: true}).Generate(&ast.GMXFile{Models: file.Models, Template: file.Template})
+	if err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	if strings.Contains(code, "chaosTransport") {
+		t.Error("unexpected chaosTransport without HTTP services")
+	}
+}
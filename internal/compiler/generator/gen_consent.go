@@ -0,0 +1,255 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// consentModel is the generated model storing the versions of the terms each
+// user accepted, and when
+const consentModel = "Consent"
+
+// Built-in endpoints asking the session user to accept the current version of
+// the terms, and recording their acceptance
+const (
+	consentPath       = "/_gmx/consent"
+	consentAcceptPath = "/_gmx/consent/accept"
+)
+
+// consentRoutes are the built-in consent endpoints
+var consentRoutes = []Route{
+	{Method: "GET", Path: consentPath, Handler: "handleConsent"},
+	{Method: "POST", Path: consentAcceptPath, Handler: "handleConsentAccept"},
+}
+
+// hasConsent checks if the session service versions the terms users accept,
+// which is the case when it declares a `policyVersion` field
+func (g *Generator) hasConsent(file *ast.GMXFile) bool {
+	svc := g.findSessionService(file.Services)
+	return svc != nil && findServiceField(svc, "policyVersion") != nil
+}
+
+// hasPolicyURL checks if the session service links the consent page to the
+// text of the terms with a `policyUrl` field
+func (g *Generator) hasPolicyURL(file *ast.GMXFile) bool {
+	svc := g.findSessionService(file.Services)
+	return svc != nil && findServiceField(svc, "policyUrl") != nil
+}
+
+// validateConsent checks that the policy fields of the session service are
+// strings, and that the generated model and endpoints are free
+func (g *Generator) validateConsent(file *ast.GMXFile) error {
+	svc := g.findSessionService(file.Services)
+	if svc == nil {
+		return nil
+	}
+	for _, name := range []string{"policyVersion", "policyUrl"} {
+		if field := findServiceField(svc, name); field != nil && field.Type != "string" {
+			return fmt.Errorf("service %s: field %s must be a string", svc.Name, name)
+		}
+	}
+	if !g.hasConsent(file) {
+		if g.hasPolicyURL(file) {
+			return fmt.Errorf("service %s: policyUrl applies with a policyVersion field, the version of the terms users accept", svc.Name)
+		}
+		return nil
+	}
+	for _, model := range file.Models {
+		if model.Name == consentModel {
+			return fmt.Errorf("line %d: model %s collides with the model storing the accepted terms; rename it", model.Line, model.Name)
+		}
+	}
+	if file.Script == nil {
+		return nil
+	}
+	for _, fn := range file.Script.Funcs {
+		for _, route := range consentRoutes {
+			if "handle"+utils.Capitalize(fn.Name) == route.Handler {
+				return fmt.Errorf("line %d: function %s collides with the built-in %s endpoint; rename it", fn.Line, fn.Name, route.Path)
+			}
+		}
+	}
+	return nil
+}
+
+// withConsentModel returns the file with the model storing the accepted terms,
+// declared and migrated like the others; it is exported with the personal data
+// of its user
+func (g *Generator) withConsentModel(file *ast.GMXFile) *ast.GMXFile {
+	if !g.hasConsent(file) {
+		return file
+	}
+	model := &ast.ModelDecl{
+		Name: consentModel,
+		Fields: []*ast.FieldDecl{
+			{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{
+				{Name: "pk", Args: map[string]string{}},
+				{Name: "default", Args: map[string]string{"_": "uuid_v4"}},
+			}},
+			{Name: "owner", Type: "string", Annotations: []*ast.Annotation{
+				{Name: "index", Args: map[string]string{}},
+			}},
+			{Name: "version", Type: "string"},
+			{Name: "acceptedAt", Type: "datetime"},
+		},
+	}
+	if g.hasPersonalData(file) {
+		model.Annotations = []*ast.Annotation{{Name: "personalData", Args: map[string]string{"_": "owner"}}}
+	}
+	withModel := *file
+	withModel.Models = append(append([]*ast.ModelDecl{}, file.Models...), model)
+	return &withModel
+}
+
+// genConsent generates the middleware sending signed-in users to the consent
+// page until they accept the current version of the terms, and the built-in
+// consent endpoints
+func (g *Generator) genConsent(file *ast.GMXFile) string {
+	var b strings.Builder
+
+	b.WriteString("// policyVersion is the version of the terms users accept to use the app; set\n")
+	b.WriteString("// by the session service, empty to ask for no consent\n")
+	b.WriteString("var policyVersion string\n\n")
+
+	b.WriteString("// policyURL links the consent page to the text of the terms; set by the\n")
+	b.WriteString("// session service\n")
+	b.WriteString("var policyURL string\n\n")
+
+	b.WriteString("// consentAccepted caches the users who accepted the current version, which\n")
+	b.WriteString("// they keep accepting until the next restart changes it\n")
+	b.WriteString("var consentAccepted sync.Map\n\n")
+
+	b.WriteString("// hasConsented reports whether a user accepted the current version of the\n")
+	b.WriteString("// terms; when the database fails, the request goes on and its own queries\n")
+	b.WriteString("// answer the error\n")
+	b.WriteString("func hasConsented(r *http.Request, user string) bool {\n")
+	b.WriteString("\tif _, ok := consentAccepted.Load(user); ok {\n")
+	b.WriteString("\t\treturn true\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tvar accepted int64\n")
+	b.WriteString(fmt.Sprintf("\tif err := db.Model(&%s{}).Where(map[string]any{\"owner\": user, \"version\": policyVersion}).Count(&accepted).Error; err != nil {\n", consentModel))
+	b.WriteString("\t\trequestLogger(r).Error(\"consent: loading\", \"error\", err)\n")
+	b.WriteString("\t\treturn true\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif accepted > 0 {\n")
+	b.WriteString("\t\tconsentAccepted.Store(user, true)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn accepted > 0\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// consentNext returns the path to go back to once the terms are accepted:\n")
+	b.WriteString("// a path of the app, never another site\n")
+	b.WriteString("func consentNext(next string) string {\n")
+	b.WriteString("\tif !strings.HasPrefix(next, \"/\") || strings.HasPrefix(next, \"//\") || strings.HasPrefix(next, \"/\\\\\") {\n")
+	b.WriteString("\t\treturn \"/\"\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn next\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// consentExempt reports whether a path is served without the current terms\n")
+	b.WriteString("// accepted: the built-in endpoints, which let users export their data or\n")
+	b.WriteString("// delete their account, the assets, and the probes\n")
+	b.WriteString("func consentExempt(path string) bool {\n")
+	b.WriteString("\treturn strings.HasPrefix(path, \"/_gmx/\") || strings.HasPrefix(path, \"/__gmx/\") || strings.HasPrefix(path, \"/assets/\") ||\n")
+	b.WriteString(fmt.Sprintf("\t\tpath == %q || path == %q\n", healthPath, metricsPath))
+	b.WriteString("}\n\n")
+
+	b.WriteString("// requireConsent sends signed-in users to the consent page until they accept\n")
+	b.WriteString("// the current version of the terms; admins impersonating a user do not\n")
+	b.WriteString("// accept them in their name\n")
+	b.WriteString("func requireConsent(next http.Handler) http.Handler {\n")
+	b.WriteString("\treturn http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString("\t\tif policyVersion == \"\" || consentExempt(r.URL.Path) {\n")
+	b.WriteString("\t\t\tnext.ServeHTTP(w, r)\n")
+	b.WriteString("\t\t\treturn\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\ts := readSession(r)\n")
+	b.WriteString("\t\tif s.User == \"\" || s.Impersonator != \"\" || hasConsented(r, s.User) {\n")
+	b.WriteString("\t\t\tnext.ServeHTTP(w, r)\n")
+	b.WriteString("\t\t\treturn\n")
+	b.WriteString("\t\t}\n\n")
+	b.WriteString("\t\t// htmx requests send the browser to the consent page, back to the page\n")
+	b.WriteString("\t\t// they came from\n")
+	b.WriteString("\t\tif r.Header.Get(\"HX-Request\") == \"true\" {\n")
+	b.WriteString("\t\t\tback := \"/\"\n")
+	b.WriteString("\t\t\tif current, err := url.Parse(r.Header.Get(\"HX-Current-URL\")); err == nil {\n")
+	b.WriteString("\t\t\t\tback = current.RequestURI()\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString(fmt.Sprintf("\t\t\tw.Header().Set(\"HX-Redirect\", %q+url.QueryEscape(consentNext(back)))\n", consentPath+"?next="))
+	b.WriteString("\t\t\tw.WriteHeader(http.StatusNoContent)\n")
+	b.WriteString("\t\t\treturn\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tif r.Method != http.MethodGet && r.Method != http.MethodHead {\n")
+	b.WriteString("\t\t\thttp.Error(w, \"Accept the updated terms to continue\", http.StatusForbidden)\n")
+	b.WriteString("\t\t\treturn\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString(fmt.Sprintf("\t\thttp.Redirect(w, r, %q+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)\n", consentPath+"?next="))
+	b.WriteString("\t})\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// handleConsent asks the session user to accept the current version of the\n")
+	b.WriteString("// terms, or sends them on when there is nothing to accept\n")
+	b.WriteString("func handleConsent(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString(genMethodGuard("Get"))
+	b.WriteString("\tnext := consentNext(r.URL.Query().Get(\"next\"))\n")
+	b.WriteString("\ts := readSession(r)\n")
+	b.WriteString("\tif policyVersion == \"\" || s.User == \"\" || s.Impersonator != \"\" || hasConsented(r, s.User) {\n")
+	b.WriteString("\t\thttp.Redirect(w, r, next, http.StatusSeeOther)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\ttoken := \"\"\n")
+	b.WriteString("\tif cookie, err := r.Cookie(\"_csrf\"); err == nil {\n")
+	b.WriteString("\t\ttoken = cookie.Value\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tterms := \"\"\n")
+	b.WriteString("\tif policyURL != \"\" {\n")
+	b.WriteString("\t\tterms = `<p><a href=\"` + template.HTMLEscapeString(policyURL) + `\" target=\"_blank\" rel=\"noopener\">Read the terms</a></p>`\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/html; charset=utf-8\")\n")
+	b.WriteString("\tw.Header().Set(\"Cache-Control\", \"no-store\")\n")
+	b.WriteString("\tfmt.Fprintf(w, `<!DOCTYPE html><html><head><meta charset=\"utf-8\"><title>Updated terms</title></head><body>`+\n")
+	b.WriteString(fmt.Sprintf("\t\t`<form class=\"gmx-consent\" method=\"post\" action=%q><input type=\"hidden\" name=\"_csrf\" value=\"%%s\">`+\n", consentAcceptPath))
+	b.WriteString("\t\t`<input type=\"hidden\" name=\"next\" value=\"%s\"><p>Accept version %s of our terms to continue.</p>%s`+\n")
+	b.WriteString("\t\t`<button>I accept</button></form></body></html>`,\n")
+	b.WriteString("\t\ttemplate.HTMLEscapeString(token), template.HTMLEscapeString(next), template.HTMLEscapeString(policyVersion), terms)\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// handleConsentAccept records that the session user accepted the current\n")
+	b.WriteString("// version of the terms, and sends them back where they were going\n")
+	b.WriteString("func handleConsentAccept(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString(genTwoFactorOwner())
+	b.WriteString("\tnext := consentNext(r.FormValue(\"next\"))\n")
+	b.WriteString("\tif policyVersion == \"\" || hasConsented(r, s.User) {\n")
+	b.WriteString("\t\thttp.Redirect(w, r, next, http.StatusSeeOther)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString(fmt.Sprintf("\tconsent := &%s{Owner: s.User, Version: policyVersion, AcceptedAt: time.Now()}\n", consentModel))
+	b.WriteString("\tif err := db.Create(consent).Error; err != nil {\n")
+	b.WriteString("\t\trequestLogger(r).Error(\"consent: recording\", \"error\", err)\n")
+	b.WriteString("\t\thttp.Error(w, \"Internal Server Error\", http.StatusInternalServerError)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tconsentAccepted.Store(s.User, true)\n")
+	b.WriteString("\trequestLogger(r).Info(\"audit: terms accepted\", \"user\", s.User, \"version\", policyVersion)\n")
+	b.WriteString("\thttp.Redirect(w, r, next, http.StatusSeeOther)\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// genConsentConfig generates the lines of configure<Session> reading the
+// version of the terms and the link to their text
+func genConsentConfig(svc *ast.ServiceDecl) string {
+	if findServiceField(svc, "policyVersion") == nil {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("\tpolicyVersion = strings.TrimSpace(cfg.PolicyVersion)\n")
+	if findServiceField(svc, "policyUrl") != nil {
+		b.WriteString("\tpolicyURL = cfg.PolicyUrl\n")
+	}
+	return b.String()
+}
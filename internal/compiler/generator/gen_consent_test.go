@@ -0,0 +1,147 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// consentServices declares a session service versioning the terms users accept
+const consentServices = `service Auth {
+  provider: "session"
+  secret: string @env("SESSION_SECRET")
+  admins: string @env("ADMIN_USERS")
+  policyVersion: string @env("POLICY_VERSION") @default("2024-05")
+  policyUrl: string @default("/terms")
+}
+`
+
+func TestGenerateConsent(t *testing.T) {
+	file := strictFile(t, strictModels+consentServices+`
+func createTask(title: string) error {
+  const task = Task{title: title}
+  try task.save()
+  return render(task)
+}
+`, `<form hx-post="{{route "createTask"}}"></form><footer>Terms v{{policyVersion}}, <a href="{{policyUrl}}">read</a></footer>`)
+
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Fatalf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, want := range []string{
+		// Accepted versions are stored by a generated model
+		"type Consent struct {",
+		// The session service sets the version and the link to the terms
+		"\tpolicyVersion = strings.TrimSpace(cfg.PolicyVersion)\n",
+		"\tpolicyURL = cfg.PolicyUrl\n",
+		// Every request of a signed-in user goes through the consent check
+		"csrfProtect(securityHeaders(requireConsent(mux)))",
+		"\tmux.HandleFunc(\"/_gmx/consent\", handleConsent)\n",
+		"\tmux.HandleFunc(\"/_gmx/consent/accept\", handleConsentAccept)\n",
+		"\tif err := db.Model(&Consent{}).Where(map[string]any{\"owner\": user, \"version\": policyVersion}).Count(&accepted).Error; err != nil {\n",
+		// htmx requests redirect the browser, back to the page they came from
+		"\t\t\tw.Header().Set(\"HX-Redirect\", \"/_gmx/consent?next=\"+url.QueryEscape(consentNext(back)))\n",
+		// Admins impersonating a user do not accept in their name
+		"\t\tif s.User == \"\" || s.Impersonator != \"\" || hasConsented(r, s.User) {\n",
+		// Each acceptance is audited
+		"\trequestLogger(r).Info(\"audit: terms accepted\", \"user\", s.User, \"version\", policyVersion)\n",
+		// Templates display the current version and its text
+		"\t\t\"policyVersion\": func() string { return policyVersion },\n",
+		"\t\t\"policyUrl\":     func() string { return policyURL },\n",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("Generated code missing %q", want)
+		}
+	}
+	// Pages never load the accepted terms
+	if strings.Contains(code, "Find(&data.Consents)") {
+		t.Error("the index page should not load the accepted terms")
+	}
+}
+
+func TestGenerateConsentWithAccounts(t *testing.T) {
+	file := accountTestFile()
+	file.Services[0].Fields = append(file.Services[0].Fields, &ast.ServiceField{Name: "policyVersion", Type: "string", EnvVar: "POLICY_VERSION"})
+
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Fatalf("Generated code is not valid Go:\n%s", code)
+	}
+	// Purged accounts leave no accepted terms behind
+	if !strings.Contains(code, "\t\tif err := tx.Where(map[string]any{\"owner\": user}).Delete(&Consent{}).Error; err != nil {\n") {
+		t.Error("expected purgeAccount to delete the accepted terms")
+	}
+}
+
+func TestGenerateConsentWithPersonalData(t *testing.T) {
+	file := personalDataTestFile()
+	file.Services[0].Fields = append(file.Services[0].Fields, &ast.ServiceField{Name: "policyVersion", Type: "string", EnvVar: "POLICY_VERSION"})
+
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	// The accepted terms are part of the personal data of their user
+	if !strings.Contains(code, "conn.Where(map[string]any{\"owner\": user}).Find(&consentRecords)") {
+		t.Error("expected the export to include the accepted terms")
+	}
+}
+
+func TestGenerateConsentErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(file *ast.GMXFile)
+		wantErr string
+	}{
+		{
+			name: "non-string version",
+			modify: func(file *ast.GMXFile) {
+				findServiceField(file.Services[1], "policyVersion").Type = "int"
+			},
+			wantErr: "service Auth: field policyVersion must be a string",
+		},
+		{
+			name: "link without a version",
+			modify: func(file *ast.GMXFile) {
+				svc := file.Services[1]
+				svc.Fields = append(svc.Fields[:2], svc.Fields[3:]...)
+			},
+			wantErr: "service Auth: policyUrl applies with a policyVersion field",
+		},
+		{
+			name: "colliding model",
+			modify: func(file *ast.GMXFile) {
+				file.Models[0].Name = "Consent"
+				file.Models[0].Line = 3
+			},
+			wantErr: "line 3: model Consent collides with the model storing the accepted terms",
+		},
+		{
+			name: "colliding function",
+			modify: func(file *ast.GMXFile) {
+				file.Script.Funcs = append(file.Script.Funcs, &ast.FuncDecl{Name: "consentAccept", ReturnType: "error", Line: 12})
+			},
+			wantErr: "line 12: function consentAccept collides with the built-in /_gmx/consent/accept endpoint",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := strictFile(t, strictModels+consentServices, `<p>Hello</p>`)
+			tt.modify(file)
+			_, err := New().Generate(file)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
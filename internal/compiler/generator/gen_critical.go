@@ -0,0 +1,177 @@
+package generator
+
+import (
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/resolver"
+	"regexp"
+	"strings"
+	"text/template/parse"
+)
+
+var (
+	classAttrRegex = regexp.MustCompile(`\bclass\s*=\s*["']([^"']*)["']`)
+	idAttrRegex    = regexp.MustCompile(`\bid\s*=\s*["']([^"']*)["']`)
+	tagRegex       = regexp.MustCompile(`<([a-zA-Z][a-zA-Z0-9-]*)`)
+
+	selectorNoiseRegex = regexp.MustCompile(`::?[\w-]+(\([^)]*\))?|\[[^\]]*\]`)
+	selectorClassRegex = regexp.MustCompile(`\.([\w-]+)`)
+	selectorIDRegex    = regexp.MustCompile(`#([\w-]+)`)
+	selectorTagRegex   = regexp.MustCompile(`(?:^|[\s>+~])([a-zA-Z][\w-]*)`)
+)
+
+// usedSelectors lists the classes, ids and tags of an initial render
+type usedSelectors struct {
+	classes map[string]bool
+	ids     map[string]bool
+	tags    map[string]bool
+}
+
+// splitCriticalCSS moves the bundled rules matching the initial render of
+// the page into the critical stylesheet; the rest stays lazy-loaded
+func (g *Generator) splitCriticalCSS(file *ast.GMXFile, components map[string]*resolver.ComponentInfo, fragments map[string]*resolver.FragmentInfo, bundle string) styleSheets {
+	if file.Template == nil {
+		return styleSheets{bundle: bundle}
+	}
+
+	src := file.Template.Source + g.genComponentTemplates(components) + g.genFragmentTemplates(fragments)
+	used, err := initialRenderSelectors(resolver.RewriteFragmentCalls(src))
+	if err != nil {
+		// The template reports its parse error at startup; keep every rule lazy-loaded meanwhile
+		return styleSheets{bundle: bundle}
+	}
+
+	var critical, rest strings.Builder
+	splitCSSInto(bundle, used, &critical, &rest)
+	return styleSheets{critical: critical.String(), bundle: rest.String()}
+}
+
+// splitCSSInto writes each rule of css to critical or rest; @media blocks are
+// split rule by rule and other at-rules are always critical
+func splitCSSInto(css string, used usedSelectors, critical, rest *strings.Builder) {
+	for _, rule := range splitCSSRules(css) {
+		open := strings.Index(rule, "{")
+		if open == -1 || !strings.HasSuffix(rule, "}") {
+			critical.WriteString(rule + "\n")
+			continue
+		}
+		prelude := strings.TrimSpace(rule[:open])
+
+		if strings.HasPrefix(prelude, "@media") {
+			var c, r strings.Builder
+			splitCSSInto(rule[open+1:len(rule)-1], used, &c, &r)
+			if c.Len() > 0 {
+				critical.WriteString(prelude + " {\n" + c.String() + "}\n")
+			}
+			if r.Len() > 0 {
+				rest.WriteString(prelude + " {\n" + r.String() + "}\n")
+			}
+			continue
+		}
+
+		if strings.HasPrefix(prelude, "@") || used.matchesAny(prelude) {
+			critical.WriteString(rule + "\n")
+		} else {
+			rest.WriteString(rule + "\n")
+		}
+	}
+}
+
+// matchesAny reports whether one selector of a selector list may match the
+// initial render: all its classes, ids and tags must be used
+func (u usedSelectors) matchesAny(selectorList string) bool {
+	for _, selector := range strings.Split(selectorList, ",") {
+		selector = selectorNoiseRegex.ReplaceAllString(selector, "")
+		if u.matches(selector) {
+			return true
+		}
+	}
+	return false
+}
+
+func (u usedSelectors) matches(selector string) bool {
+	for _, m := range selectorClassRegex.FindAllStringSubmatch(selector, -1) {
+		if !u.classes[m[1]] {
+			return false
+		}
+	}
+	for _, m := range selectorIDRegex.FindAllStringSubmatch(selector, -1) {
+		if !u.ids[m[1]] {
+			return false
+		}
+	}
+	for _, m := range selectorTagRegex.FindAllStringSubmatch(selector, -1) {
+		if !u.tags[strings.ToLower(m[1])] {
+			return false
+		}
+	}
+	return true
+}
+
+// initialRenderSelectors walks the page template from its root, following
+// {{template}} calls, and collects what the initial render can produce;
+// both branches of conditionals are considered
+func initialRenderSelectors(src string) (usedSelectors, error) {
+	used := usedSelectors{
+		classes: make(map[string]bool),
+		ids:     make(map[string]bool),
+		// Tags of the page wrapper
+		tags: map[string]bool{"html": true, "head": true, "body": true},
+	}
+
+	tree := parse.New("page")
+	tree.Mode = parse.SkipFuncCheck | parse.ParseComments
+	treeSet := make(map[string]*parse.Tree)
+	if _, err := tree.Parse(src, "", "", treeSet); err != nil {
+		return used, err
+	}
+
+	var text strings.Builder
+	visited := make(map[string]bool)
+	var walk func(node parse.Node)
+	walkBranch := func(list, elseList *parse.ListNode) {
+		walk(list)
+		if elseList != nil {
+			walk(elseList)
+		}
+	}
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.TextNode:
+			text.Write(n.Text)
+		case *parse.ActionNode:
+			text.WriteString(" ") // dynamic values are unknown at generation time
+		case *parse.IfNode:
+			walkBranch(n.List, n.ElseList)
+		case *parse.RangeNode:
+			walkBranch(n.List, n.ElseList)
+		case *parse.WithNode:
+			walkBranch(n.List, n.ElseList)
+		case *parse.TemplateNode:
+			if t, ok := treeSet[n.Name]; ok && !visited[n.Name] {
+				visited[n.Name] = true
+				walk(t.Root)
+			}
+		}
+	}
+	if root, ok := treeSet["page"]; ok {
+		walk(root.Root)
+	}
+
+	html := text.String()
+	for _, m := range classAttrRegex.FindAllStringSubmatch(html, -1) {
+		for _, class := range strings.Fields(m[1]) {
+			used.classes[class] = true
+		}
+	}
+	for _, m := range idAttrRegex.FindAllStringSubmatch(html, -1) {
+		used.ids[strings.TrimSpace(m[1])] = true
+	}
+	for _, m := range tagRegex.FindAllStringSubmatch(html, -1) {
+		used.tags[strings.ToLower(m[1])] = true
+	}
+	return used, nil
+}
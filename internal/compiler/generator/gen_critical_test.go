@@ -0,0 +1,90 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/resolver"
+)
+
+func TestInitialRenderSelectors(t *testing.T) {
+	src := `<main id="app">{{if .Ready}}<ul class="list {{if .Dense}}dense{{end}}">{{range .Items}}{{template "Row" .}}{{end}}</ul>{{end}}</main>
+{{define "Row"}}<li class="row">{{.}}</li>{{end}}
+{{define "Unused"}}<aside class="modal"></aside>{{end}}`
+
+	used, err := initialRenderSelectors(src)
+	if err != nil {
+		t.Fatalf("initialRenderSelectors failed: %v", err)
+	}
+
+	tests := []struct {
+		selector string
+		want     bool
+	}{
+		{"#app", true},
+		{"ul.list.dense", true},
+		{"main li.row:hover", true},
+		{".list > .row::before", true},
+		{"a[href], .row", true},
+		{"body", true},
+		{".modal", false},
+		{"aside", false},
+		{"#app .modal", false},
+	}
+	for _, tt := range tests {
+		if got := used.matchesAny(tt.selector); got != tt.want {
+			t.Errorf("matchesAny(%q) = %v, want %v", tt.selector, got, tt.want)
+		}
+	}
+}
+
+func TestSplitCriticalCSS(t *testing.T) {
+	file := &ast.GMXFile{Template: &ast.TemplateBlock{Source: `<div class="card">{{template "Badge" .}}</div>`}}
+	components := map[string]*resolver.ComponentInfo{
+		"Badge": {File: &ast.GMXFile{Template: &ast.TemplateBlock{Source: `<span class="badge"></span>`}}},
+	}
+	bundle := ".card { padding: 1rem; }\n.modal { display: none; }\n@font-face { font-family: x; }\n@media (min-width: 40rem) { .badge { color: red; } .modal { width: 50%; } }\n"
+
+	styles := New().splitCriticalCSS(file, components, nil, bundle)
+
+	for _, exp := range []string{".card { padding: 1rem; }", "@font-face", "@media (min-width: 40rem) {\n.badge { color: red; }\n}"} {
+		if !strings.Contains(styles.critical, exp) {
+			t.Errorf("critical CSS missing %q:\n%s", exp, styles.critical)
+		}
+	}
+	for _, exp := range []string{".modal { display: none; }", "@media (min-width: 40rem) {\n.modal { width: 50%; }\n}"} {
+		if !strings.Contains(styles.bundle, exp) {
+			t.Errorf("lazy-loaded CSS missing %q:\n%s", exp, styles.bundle)
+		}
+	}
+	if strings.Contains(styles.critical, ".modal") || strings.Contains(styles.bundle, ".card") {
+		t.Error("rules should be in exactly one stylesheet")
+	}
+}
+
+func TestGenerateResolvedCriticalCSS(t *testing.T) {
+	resolved := styleBundleTestFile()
+	resolved.Components["Badge"].File.Style.Source += "\n.unused { color: blue; }"
+
+	code, err := NewWithOptions(Options{CriticalCSS: true}).GenerateResolved(resolved)
+	if err != nil {
+		t.Fatalf("GenerateResolved failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	expected := []string{
+		"/* GMX Critical Styles */",
+		`<link rel="preload" href="/assets/app.css?v=`,
+		`<noscript><link rel="stylesheet" href="/assets/app.css?v=`,
+		"const appCSS = `.badge { color: red; }\n.unused { color: blue; }\n`",
+		`mux.HandleFunc("/assets/app.css", handleAppCSS)`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(code, exp) {
+			t.Errorf("Generated code missing %q", exp)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package generator
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// Attempts and first retry delay of the runs of the "db" job provider
+const (
+	defaultJobAttempts = 5
+	defaultJobBackoff  = "30*time.Second"
+)
+
+// dbJobQueueFields lists the config fields a "db" jobs service may declare
+var dbJobQueueFields = []string{"workers", "attempts", "backoff"}
+
+// jobLease is how long a claimed run stays with its worker: a run still
+// running past it is taken as lost with its instance, and claimed again
+const jobLease = "10 * time.Minute"
+
+// validateDBJobQueue checks that a "db" jobs service only sets the workers,
+// attempts and backoff of the database queue
+func validateDBJobQueue(svc *ast.ServiceDecl) error {
+	for _, field := range svc.Fields {
+		if !slices.Contains(dbJobQueueFields, field.Name) {
+			return fmt.Errorf("service %s: the db jobs provider takes workers, attempts and backoff fields, not %s", svc.Name, field.Name)
+		}
+		if field.Type != "string" {
+			return fmt.Errorf("service %s: field %s of the db jobs provider must be a string", svc.Name, field.Name)
+		}
+	}
+	if len(svc.Methods) > 0 {
+		return fmt.Errorf("service %s: the db jobs provider takes no methods; mark the functions to run with @job", svc.Name)
+	}
+	return nil
+}
+
+// genDBJobQueueConfig generates the reading of the workers, attempts and
+// backoff of a "db" jobs service
+func genDBJobQueueConfig(svc *ast.ServiceDecl) string {
+	var b strings.Builder
+
+	b.WriteString(fmt.Sprintf("// jobQueueConfig reads the workers, attempts and backoff of the %s service\n", svc.Name))
+	b.WriteString(fmt.Sprintf("func jobQueueConfig(cfg *%sConfig) (workers, attempts int, backoff time.Duration) {\n", svc.Name))
+	b.WriteString(fmt.Sprintf("\tworkers, attempts, backoff = %d, %d, %s\n", defaultJobWorkers, defaultJobAttempts, defaultJobBackoff))
+	if findServiceField(svc, "workers") != nil {
+		b.WriteString("\tif cfg.Workers != \"\" {\n")
+		b.WriteString("\t\tn, err := strconv.Atoi(cfg.Workers)\n")
+		b.WriteString("\t\tif err != nil || n < 1 {\n")
+		b.WriteString("\t\t\tlog.Fatalf(\"invalid job workers %q: expected a positive count\", cfg.Workers)\n")
+		b.WriteString("\t\t}\n")
+		b.WriteString("\t\tworkers = n\n")
+		b.WriteString("\t}\n")
+	}
+	if findServiceField(svc, "attempts") != nil {
+		b.WriteString("\tif cfg.Attempts != \"\" {\n")
+		b.WriteString("\t\tn, err := strconv.Atoi(cfg.Attempts)\n")
+		b.WriteString("\t\tif err != nil || n < 1 {\n")
+		b.WriteString("\t\t\tlog.Fatalf(\"invalid job attempts %q: expected a positive count\", cfg.Attempts)\n")
+		b.WriteString("\t\t}\n")
+		b.WriteString("\t\tattempts = n\n")
+		b.WriteString("\t}\n")
+	}
+	if findServiceField(svc, "backoff") != nil {
+		b.WriteString("\tif cfg.Backoff != \"\" {\n")
+		b.WriteString("\t\td, err := time.ParseDuration(cfg.Backoff)\n")
+		b.WriteString("\t\tif err != nil || d <= 0 {\n")
+		b.WriteString("\t\t\tlog.Fatalf(\"invalid job backoff %q: expected a positive duration such as 30s\", cfg.Backoff)\n")
+		b.WriteString("\t\t}\n")
+		b.WriteString("\t\tbackoff = d\n")
+		b.WriteString("\t}\n")
+	}
+	b.WriteString("\treturn workers, attempts, backoff\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// genDBJobQueueState generates the state of the database queue
+func genDBJobQueueState() string {
+	var b strings.Builder
+
+	b.WriteString("// jobLease is how long a claimed run stays with its worker: a run still\n")
+	b.WriteString("// running past it is taken as lost with its instance, and claimed again\n")
+	b.WriteString("const jobLease = " + jobLease + "\n\n")
+
+	b.WriteString("// jobQueue holds the workers running the runs of the @job functions stored\n")
+	b.WriteString("// in the database; it is closed on shutdown, once the server stopped\n")
+	b.WriteString("// answering requests\n")
+	b.WriteString("var jobQueue struct {\n")
+	b.WriteString("\tsync.RWMutex\n")
+	b.WriteString("\tclosed   bool\n")
+	b.WriteString("\tattempts int           // runs failing this many times are dead letters\n")
+	b.WriteString("\tbackoff  time.Duration // delay before the first retry, doubled by each failure\n")
+	b.WriteString("\twake     chan struct{} // signalled by enqueued runs, so that an idle worker polls\n")
+	b.WriteString("\tstop     chan struct{}\n")
+	b.WriteString("\tworkers  sync.WaitGroup\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// genDBJobWorkers generates the workers of the database queue: each polls the
+// table for a due run, claims it, runs it, then deletes it, retries it with
+// an exponential backoff, or keeps it as a dead letter once out of attempts
+func (g *Generator) genDBJobWorkers(file *ast.GMXFile) string {
+	var b strings.Builder
+
+	b.WriteString("// startJobWorkers starts the workers running the stored jobs, retrying\n")
+	b.WriteString("// the failed ones up to attempts times\n")
+	b.WriteString("func startJobWorkers(workers, attempts int, backoff time.Duration) {\n")
+	b.WriteString("\tjobQueue.attempts, jobQueue.backoff = attempts, backoff\n")
+	b.WriteString("\tjobQueue.wake = make(chan struct{}, workers)\n")
+	b.WriteString("\tjobQueue.stop = make(chan struct{})\n")
+	if g.hasMetrics(file) {
+		b.WriteString("\tmetricsMu.Lock()\n")
+		b.WriteString("\tjobWorkers = workers\n")
+		b.WriteString("\tmetricsMu.Unlock()\n")
+	}
+	b.WriteString("\tfor i := 0; i < workers; i++ {\n")
+	b.WriteString("\t\tjobQueue.workers.Add(1)\n")
+	b.WriteString("\t\tgo func() {\n")
+	b.WriteString("\t\t\tdefer jobQueue.workers.Done()\n")
+	b.WriteString("\t\t\tpollJobs()\n")
+	b.WriteString("\t\t}()\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// enqueueJob stores a run of the @job function name, with its arguments\n")
+	b.WriteString("// encoded as JSON, for a worker to claim; a server shutting down is an error\n")
+	b.WriteString("func enqueueJob(ctx *GMXContext, name string, args []any, run func(ctx *GMXContext) error) error {\n")
+	b.WriteString("\tjobQueue.RLock()\n")
+	b.WriteString("\tdefer jobQueue.RUnlock()\n")
+	b.WriteString("\tif jobQueue.closed {\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"enqueue %s: the server is shutting down\", name)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif err := storeJob(ctx, name, args); err != nil {\n")
+	b.WriteString("\t\treturn err\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tselect {\n")
+	b.WriteString("\tcase jobQueue.wake <- struct{}{}:\n")
+	b.WriteString("\tdefault:\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn nil\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// pollJobs runs the due jobs one after the other, then waits a second or\n")
+	b.WriteString("// an enqueued run before polling again, until the queue is closed\n")
+	b.WriteString("func pollJobs() {\n")
+	b.WriteString("\tticker := time.NewTicker(time.Second)\n")
+	b.WriteString("\tdefer ticker.Stop()\n")
+	b.WriteString("\tfor {\n")
+	b.WriteString("\t\tfor {\n")
+	b.WriteString("\t\t\tselect {\n")
+	b.WriteString("\t\t\tcase <-jobQueue.stop:\n")
+	b.WriteString("\t\t\t\treturn\n")
+	b.WriteString("\t\t\tdefault:\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t\tstored, ok := claimJob()\n")
+	b.WriteString("\t\t\tif !ok {\n")
+	b.WriteString("\t\t\t\tbreak\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t\tif stored != nil {\n")
+	b.WriteString("\t\t\t\trunStoredJob(stored)\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tselect {\n")
+	b.WriteString("\t\tcase <-jobQueue.stop:\n")
+	b.WriteString("\t\t\treturn\n")
+	b.WriteString("\t\tcase <-jobQueue.wake:\n")
+	b.WriteString("\t\tcase <-ticker.C:\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// claimJob claims the oldest due run: a queued one, or a running one whose\n")
+	b.WriteString("// lease expired. The claim counts an attempt and only succeeds for one\n")
+	b.WriteString("// worker, across instances sharing the database; ok is false without due\n")
+	b.WriteString("// runs, and the run nil when another worker claimed it first\n")
+	b.WriteString(fmt.Sprintf("func claimJob() (stored *%s, ok bool) {\n", storedJobModel))
+	b.WriteString(g.genHoldDB(file, "\t"))
+	b.WriteString(fmt.Sprintf("\tvar due []%s\n", storedJobModel))
+	b.WriteString("\tnow := time.Now()\n")
+	b.WriteString("\tif err := db.WithContext(jobQueueCtx).Where(\"status IN ? AND scheduled_at <= ?\", []string{\"queued\", \"running\"}, now).Order(\"scheduled_at\").Limit(1).Find(&due).Error; err != nil {\n")
+	b.WriteString("\t\tslog.Error(\"jobs: polling the stored runs\", \"error\", err)\n")
+	b.WriteString("\t\treturn nil, false\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif len(due) == 0 {\n")
+	b.WriteString("\t\treturn nil, false\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tstored = &due[0]\n")
+	b.WriteString(fmt.Sprintf("\tclaim := db.WithContext(jobQueueCtx).Model(&%s{}).Where(\"id = ? AND attempts = ?\", stored.ID, stored.Attempts).\n", storedJobModel))
+	b.WriteString("\t\tUpdates(map[string]any{\"status\": \"running\", \"attempts\": stored.Attempts + 1, \"scheduled_at\": now.Add(jobLease)})\n")
+	b.WriteString("\tif claim.Error != nil {\n")
+	b.WriteString("\t\tslog.Error(\"jobs: claiming a stored run\", \"job\", stored.Name, \"error\", claim.Error)\n")
+	b.WriteString("\t\treturn nil, false\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif claim.RowsAffected != 1 {\n")
+	b.WriteString("\t\treturn nil, true\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tstored.Attempts++\n")
+	b.WriteString("\treturn stored, true\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// runStoredJob runs a claimed run, then deletes it once done, schedules its\n")
+	b.WriteString("// retry after a failure, or keeps it as a dead letter with its last error\n")
+	b.WriteString("// once out of attempts\n")
+	b.WriteString(fmt.Sprintf("func runStoredJob(stored *%s) {\n", storedJobModel))
+	b.WriteString(g.genHoldDB(file, "\t"))
+	b.WriteString("\tvar err error\n")
+	b.WriteString("\tif stored.Attempts > jobQueue.attempts {\n")
+	b.WriteString("\t\t// Its lease expired on each attempt: the run brings its instance down\n")
+	b.WriteString("\t\terr = fmt.Errorf(\"the run was lost with its worker %d time(s)\", stored.Attempts-1)\n")
+	b.WriteString("\t} else if job, loadErr := storedQueuedJob(*stored); loadErr != nil {\n")
+	b.WriteString("\t\t// Retrying would fail the same way\n")
+	b.WriteString("\t\terr = loadErr\n")
+	b.WriteString("\t\tstored.Attempts = jobQueue.attempts\n")
+	b.WriteString("\t} else if err = executeJob(job); err == nil {\n")
+	b.WriteString(fmt.Sprintf("\t\tif err := db.Where(\"id = ?\", stored.ID).Delete(&%s{}).Error; err != nil {\n", storedJobModel))
+	b.WriteString("\t\t\tslog.Error(\"jobs: deleting a finished run\", \"job\", stored.Name, \"error\", err)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString(fmt.Sprintf("\tfailed := db.Model(&%s{}).Where(\"id = ?\", stored.ID)\n", storedJobModel))
+	b.WriteString("\tif stored.Attempts >= jobQueue.attempts {\n")
+	b.WriteString("\t\tslog.Error(\"job failed for good: kept as a dead letter\", \"job\", stored.Name, \"id\", stored.ID, \"attempts\", stored.Attempts, \"error\", err)\n")
+	b.WriteString("\t\tfailed = failed.Updates(map[string]any{\"status\": \"dead\", \"last_error\": err.Error()})\n")
+	b.WriteString("\t} else {\n")
+	b.WriteString("\t\tretry := jobBackoff(stored.Attempts)\n")
+	b.WriteString("\t\tslog.Warn(\"job failed: retrying\", \"job\", stored.Name, \"attempt\", stored.Attempts, \"retry_in\", retry, \"error\", err)\n")
+	b.WriteString("\t\tfailed = failed.Updates(map[string]any{\"status\": \"queued\", \"last_error\": err.Error(), \"scheduled_at\": time.Now().Add(retry)})\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif failed.Error != nil {\n")
+	b.WriteString("\t\tslog.Error(\"jobs: recording a failed run\", \"job\", stored.Name, \"error\", failed.Error)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// jobBackoff returns the delay before retrying a run after its attempt-th\n")
+	b.WriteString("// failure: the backoff doubled by each earlier failure, up to an hour, with\n")
+	b.WriteString("// up to a quarter more at random so that failed runs do not retry together\n")
+	b.WriteString("func jobBackoff(attempt int) time.Duration {\n")
+	b.WriteString("\tdelay := time.Hour\n")
+	b.WriteString("\tif attempt <= 20 {\n")
+	b.WriteString("\t\tif d := jobQueue.backoff << (attempt - 1); d > 0 && d < delay {\n")
+	b.WriteString("\t\t\tdelay = d\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn delay + time.Duration(mrand.Int64N(int64(delay)/4+1))\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
@@ -0,0 +1,91 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+func dbQueueService(fields ...*ast.ServiceField) *ast.ServiceDecl {
+	return &ast.ServiceDecl{Name: "Jobs", Provider: "db", Fields: fields}
+}
+
+func TestGenerator_DBJobQueue(t *testing.T) {
+	file := queueFile(dbQueueService(
+		&ast.ServiceField{Name: "attempts", Type: "string", EnvVar: "JOB_ATTEMPTS"},
+		&ast.ServiceField{Name: "backoff", Type: "string", EnvVar: "JOB_BACKOFF"},
+	))
+	file.Observability = metricsObservability
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, want := range []string{
+		"type BackgroundJob struct {",
+		"func jobQueueConfig(cfg *JobsConfig) (workers, attempts int, backoff time.Duration) {",
+		"\tworkers, attempts, backoff = 4, 5, 30*time.Second\n",
+		"d, err := time.ParseDuration(cfg.Backoff)",
+		"\tstartJobWorkers(jobQueueConfig(jobsCfg))\n",
+		"\tif err := storeJob(ctx, name, args); err != nil {\n",
+		`stored := &BackgroundJob{Name: name, Args: string(encoded), User: ctx.User, Tenant: ctx.Tenant, Status: "queued", ScheduledAt: time.Now()}`,
+		// A claim counts an attempt, guarded by the attempts read
+		`Where("id = ? AND attempts = ?", stored.ID, stored.Attempts)`,
+		`Updates(map[string]any{"status": "running", "attempts": stored.Attempts + 1, "scheduled_at": now.Add(jobLease)})`,
+		`failed = failed.Updates(map[string]any{"status": "dead", "last_error": err.Error()})`,
+		`failed = failed.Updates(map[string]any{"status": "queued", "last_error": err.Error(), "scheduled_at": time.Now().Add(retry)})`,
+		"\t\tif d := jobQueue.backoff << (attempt - 1); d > 0 && d < delay {\n",
+		"\tclose(jobQueue.stop)\n",
+		`fmt.Fprintf(&b, "gmx_job_queue_depth %d\n", queuedJobs)`,
+		`fmt.Fprintf(&b, "gmx_job_dead %d\n", deadJobs)`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in generated code", want)
+		}
+	}
+	for _, unwanted := range []string{"chan queuedJob", "runQueuedJob", "refillJobQueue", "cfg.Workers"} {
+		if strings.Contains(code, unwanted) {
+			t.Errorf("unexpected %q in generated code", unwanted)
+		}
+	}
+}
+
+func TestGenerator_DBJobQueueErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		services []*ast.ServiceDecl
+		wantErr  string
+	}{
+		{
+			name:     "overflow field",
+			services: []*ast.ServiceDecl{dbQueueService(&ast.ServiceField{Name: "overflow", Type: "string"})},
+			wantErr:  "service Jobs: the db jobs provider takes workers, attempts and backoff fields, not overflow",
+		},
+		{
+			name:     "field type",
+			services: []*ast.ServiceDecl{dbQueueService(&ast.ServiceField{Name: "attempts", Type: "int"})},
+			wantErr:  "service Jobs: field attempts of the db jobs provider must be a string",
+		},
+		{
+			name: "two queues",
+			services: []*ast.ServiceDecl{
+				dbQueueService(),
+				{Name: "Workers", Provider: "jobs"},
+			},
+			wantErr: `services Jobs, Workers: the @job functions run on a single queue`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New().Generate(queueFile(tt.services...))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
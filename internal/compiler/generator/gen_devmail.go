@@ -0,0 +1,134 @@
+package generator
+
+import (
+	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+	"strings"
+)
+
+// devMailPath is the dev-only page listing caught mail
+const devMailPath = "/__gmx/mail"
+
+// hasDevMail checks if a dev build catches mail for an SMTP service with methods
+func (g *Generator) hasDevMail(file *ast.GMXFile) bool {
+	if !g.opts.Dev {
+		return false
+	}
+	for _, svc := range file.Services {
+		if svc.Provider == "smtp" && len(svc.Methods) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// genDevMailImpl generates the dev implementation of a mailer service,
+// which stores messages in the dev mailbox instead of sending them
+func (g *Generator) genDevMailImpl(svc *ast.ServiceDecl) string {
+	var b strings.Builder
+
+	implName := utils.LowerFirst(svc.Name) + "Impl"
+
+	b.WriteString(fmt.Sprintf("// %s is a dev implementation of %sService that catches mail\n", implName, svc.Name))
+	b.WriteString(fmt.Sprintf("type %s struct {\n", implName))
+	b.WriteString(fmt.Sprintf("\tconfig *%sConfig\n", svc.Name))
+	b.WriteString("}\n\n")
+
+	for _, method := range svc.Methods {
+		if method.Body != nil {
+			b.WriteString(g.genScriptServiceMethod("m", implName, method))
+			continue
+		}
+		if method.Name != "send" {
+			continue
+		}
+		b.WriteString(fmt.Sprintf("func (m *%s) %s(", implName, utils.ToPascalCase(method.Name)))
+		for i, param := range method.Params {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(fmt.Sprintf("%s %s", param.Name, g.mapType(param.Type)))
+		}
+		b.WriteString(") error {\n")
+		b.WriteString("\tfrom := \"noreply@localhost\"\n")
+		if fieldExists(svc, "from") {
+			b.WriteString("\tif m.config.From != \"\" {\n")
+			b.WriteString("\t\tfrom = m.config.From\n")
+			b.WriteString("\t}\n")
+		}
+		b.WriteString(fmt.Sprintf("\tdevMailbox.add(devMail{Service: %q, From: from, To: to, Subject: subject, Body: body, Sent: time.Now()})\n", svc.Name))
+		b.WriteString(fmt.Sprintf("\tslog.Info(\"dev mail caught (see %s)\", \"to\", to, \"subject\", subject)\n", devMailPath))
+		b.WriteString("\treturn nil\n")
+		b.WriteString("}\n\n")
+	}
+
+	b.WriteString(fmt.Sprintf("// new%sService creates a new dev mail catcher instance of %sService\n", svc.Name, svc.Name))
+	b.WriteString(fmt.Sprintf("func new%sService(cfg *%sConfig) %sService {\n", svc.Name, svc.Name, svc.Name))
+	b.WriteString(fmt.Sprintf("\treturn &%s{config: cfg}\n", implName))
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// genDevMailbox generates the in-memory store shared by dev mailers and
+// the page listing caught messages
+func (g *Generator) genDevMailbox() string {
+	var b strings.Builder
+
+	b.WriteString("// devMail is a message caught by a dev mailer\n")
+	b.WriteString("type devMail struct {\n")
+	b.WriteString("\tService string\n")
+	b.WriteString("\tFrom    string\n")
+	b.WriteString("\tTo      string\n")
+	b.WriteString("\tSubject string\n")
+	b.WriteString("\tBody    string\n")
+	b.WriteString("\tSent    time.Time\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// devMailStore keeps caught messages in memory, newest first\n")
+	b.WriteString("type devMailStore struct {\n")
+	b.WriteString("\tmu       sync.Mutex\n")
+	b.WriteString("\tmessages []devMail\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// devMailbox holds every message sent by dev mailers\n")
+	b.WriteString("var devMailbox devMailStore\n\n")
+
+	b.WriteString("func (s *devMailStore) add(m devMail) {\n")
+	b.WriteString("\ts.mu.Lock()\n")
+	b.WriteString("\tdefer s.mu.Unlock()\n")
+	b.WriteString("\ts.messages = append([]devMail{m}, s.messages...)\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("func (s *devMailStore) list() []devMail {\n")
+	b.WriteString("\ts.mu.Lock()\n")
+	b.WriteString("\tdefer s.mu.Unlock()\n")
+	b.WriteString("\treturn append([]devMail(nil), s.messages...)\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("var devMailTmpl = template.Must(template.New(\"devmail\").Parse(`<!DOCTYPE html>\n")
+	b.WriteString("<html><head><meta charset=\"utf-8\"><title>Dev mail</title></head>\n")
+	b.WriteString("<body style=\"font-family: sans-serif; max-width: 48rem; margin: 2rem auto\">\n")
+	b.WriteString("<h1>Dev mail ({{len .}})</h1>\n")
+	b.WriteString("{{range .}}<article style=\"border-top: 1px solid #ccc; padding: 1rem 0\">\n")
+	b.WriteString("<h2>{{.Subject}}</h2>\n")
+	b.WriteString("<p><small>{{.Sent.Format \"2006-01-02 15:04:05\"}} &middot; {{.Service}} &middot; from {{.From}} to {{.To}}</small></p>\n")
+	b.WriteString("<pre style=\"white-space: pre-wrap\">{{.Body}}</pre>\n")
+	b.WriteString("</article>{{else}}<p>No mail sent yet.</p>{{end}}\n")
+	b.WriteString("</body></html>`))\n\n")
+
+	b.WriteString("// handleDevMail lists the messages caught by dev mailers\n")
+	b.WriteString("func handleDevMail(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString("\tif r.Method != http.MethodGet {\n")
+	b.WriteString("\t\thttp.Error(w, \"Method Not Allowed\", http.StatusMethodNotAllowed)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/html; charset=utf-8\")\n")
+	b.WriteString("\tif err := devMailTmpl.Execute(w, devMailbox.list()); err != nil {\n")
+	b.WriteString("\t\trequestLogger(r).Error(\"dev mail page\", \"error\", err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
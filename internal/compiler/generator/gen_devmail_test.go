@@ -0,0 +1,97 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+func devMailTestFile() *ast.GMXFile {
+	return &ast.GMXFile{
+		Services: []*ast.ServiceDecl{
+			{
+				Name:     "Mailer",
+				Provider: "smtp",
+				Fields: []*ast.ServiceField{
+					{Name: "host", Type: "string", EnvVar: "SMTP_HOST"},
+					{Name: "from", Type: "string", EnvVar: "SMTP_FROM"},
+				},
+				Methods: []*ast.ServiceMethod{
+					{
+						Name: "send",
+						Params: []*ast.Param{
+							{Name: "to", Type: "string"},
+							{Name: "subject", Type: "string"},
+							{Name: "body", Type: "string"},
+						},
+						ReturnType: "error",
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestGenerator_DevMail(t *testing.T) {
+	tests := []struct {
+		name       string
+		dev        bool
+		expected   []string
+		unexpected []string
+	}{
+		{
+			name: "dev",
+			dev:  true,
+			expected: []string{
+				"devMailbox.add(devMail{Service: \"Mailer\", From: from, To: to, Subject: subject, Body: body, Sent: time.Now()})",
+				"func handleDevMail(w http.ResponseWriter, r *http.Request)",
+				`mux.HandleFunc("/__gmx/mail", handleDevMail)`,
+				`"sync"`,
+				`"html/template"`,
+			},
+			unexpected: []string{"smtp.SendMail", `"net/smtp"`, `log.Fatal("missing required env var: SMTP_HOST")`},
+		},
+		{
+			name:       "production",
+			dev:        false,
+			expected:   []string{"smtp.SendMail", `"net/smtp"`, `log.Fatal("missing required env var: SMTP_HOST")`},
+			unexpected: []string{"devMailbox", "/__gmx/mail"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := NewWithOptions(Options{Dev: tt.dev}).Generate(devMailTestFile())
+			if err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+			if !isValidGo(code) {
+				t.Errorf("Generated code is not valid Go:\n%s", code)
+			}
+			for _, exp := range tt.expected {
+				if !strings.Contains(code, exp) {
+					t.Errorf("Generated code missing %q", exp)
+				}
+			}
+			for _, unexpected := range tt.unexpected {
+				if strings.Contains(code, unexpected) {
+					t.Errorf("Generated code should not contain %q", unexpected)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerator_DevMailWithoutMailer(t *testing.T) {
+	file := devMailTestFile()
+	file.Services[0].Methods = nil
+
+	code, err := NewWithOptions(Options{Dev: true}).Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if strings.Contains(code, "handleDevMail") {
+		t.Error("Dev mailbox should not be generated without a mailer method")
+	}
+}
@@ -0,0 +1,136 @@
+package generator
+
+import (
+	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+	"strings"
+)
+
+// isEnumField reports whether a field takes one of a list of values: enum(pending, done)
+func isEnumField(field *ast.FieldDecl) bool {
+	return field.Type == "enum"
+}
+
+// enumTypeName returns the Go type of an enum field: Task.status → TaskStatus
+func enumTypeName(model *ast.ModelDecl, field *ast.FieldDecl) string {
+	return model.Name + utils.ToPascalCase(field.Name)
+}
+
+// enumConstName returns the Go constant of a variant: TaskStatusPending
+func enumConstName(model *ast.ModelDecl, field *ast.FieldDecl, variant string) string {
+	return enumTypeName(model, field) + utils.ToPascalCase(variant)
+}
+
+// validateEnums checks the enum fields: distinct variants, a @default among
+// them and a Go type which does not collide with a model
+func (g *Generator) validateEnums(file *ast.GMXFile) error {
+	models := make(map[string]bool)
+	for _, model := range file.Models {
+		models[model.Name] = true
+	}
+	for _, model := range file.Models {
+		for _, field := range model.Fields {
+			if !isEnumField(field) {
+				continue
+			}
+			if len(field.Variants) == 0 {
+				return fmt.Errorf("line %d: field %s.%s: enum lists its values, such as enum(pending, done)", field.Line, model.Name, field.Name)
+			}
+			if typ := enumTypeName(model, field); models[typ] {
+				return fmt.Errorf("line %d: field %s.%s: its Go type %s collides with model %s; rename one of them", field.Line, model.Name, field.Name, typ, typ)
+			}
+			consts := make(map[string]string)
+			for _, variant := range field.Variants {
+				name := enumConstName(model, field, variant)
+				if other, ok := consts[name]; ok {
+					return fmt.Errorf("line %d: field %s.%s: variants %s and %s are both %s in Go", field.Line, model.Name, field.Name, other, variant, name)
+				}
+				consts[name] = variant
+			}
+			for _, ann := range field.Annotations {
+				switch ann.Name {
+				case "default":
+					if !enumHasVariant(field, ann.SimpleArg()) {
+						return fmt.Errorf("line %d: field %s.%s: @default(%s) is not one of %s", field.Line, model.Name, field.Name, ann.SimpleArg(), strings.Join(field.Variants, ", "))
+					}
+				case "min", "max", "email":
+					return fmt.Errorf("line %d: field %s.%s: @%s does not apply to enum fields, which take one of their values", field.Line, model.Name, field.Name, ann.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// enumHasVariant reports whether value is a variant of an enum field
+func enumHasVariant(field *ast.FieldDecl, value string) bool {
+	for _, variant := range field.Variants {
+		if variant == value {
+			return true
+		}
+	}
+	return false
+}
+
+// genEnums generates the string types of the enum fields of a model, with a
+// constant per variant and a Valid method
+func (g *Generator) genEnums(model *ast.ModelDecl) string {
+	var b strings.Builder
+	for _, field := range model.Fields {
+		if !isEnumField(field) {
+			continue
+		}
+		typ := enumTypeName(model, field)
+		b.WriteString(fmt.Sprintf("// %s is the %s of a %s\n", typ, field.Name, model.Name))
+		b.WriteString(fmt.Sprintf("type %s string\n\n", typ))
+		b.WriteString("const (\n")
+		for _, variant := range field.Variants {
+			b.WriteString(fmt.Sprintf("\t%s %s = %q\n", enumConstName(model, field, variant), typ, variant))
+		}
+		b.WriteString(")\n\n")
+
+		consts := make([]string, len(field.Variants))
+		for i, variant := range field.Variants {
+			consts[i] = enumConstName(model, field, variant)
+		}
+		b.WriteString(fmt.Sprintf("// Valid reports whether the value is one of the variants of %s\n", typ))
+		b.WriteString(fmt.Sprintf("func (v %s) Valid() bool {\n", typ))
+		b.WriteString("\tswitch v {\n")
+		b.WriteString(fmt.Sprintf("\tcase %s:\n", strings.Join(consts, ", ")))
+		b.WriteString("\t\treturn true\n")
+		b.WriteString("\t}\n")
+		b.WriteString("\treturn false\n")
+		b.WriteString("}\n\n")
+	}
+	return b.String()
+}
+
+// enumValidation returns the Validate check of an enum field; an empty value
+// is left to the @default the database sets on create
+func enumValidation(recv, fieldName string, field *ast.FieldDecl) string {
+	cond := fmt.Sprintf("!%s.%s.Valid()", recv, fieldName)
+	if field.FindAnnotation("default") != nil {
+		cond = fmt.Sprintf("%s.%s != \"\" && %s", recv, fieldName, cond)
+	}
+	return fmt.Sprintf("\tif %s {\n\t\t%s\n\t}", cond, validationReturn(field.Name, "must be one of "+strings.Join(field.Variants, ", ")+", got %q", recv+"."+fieldName))
+}
+
+// enumCheck returns the SQL condition restricting a column to the variants
+// of its enum: status IN ('pending','done')
+func enumCheck(column string, variants []string) string {
+	values := make([]string, len(variants))
+	for i, variant := range variants {
+		values[i] = "'" + variant + "'"
+	}
+	return column + " IN (" + strings.Join(values, ",") + ")"
+}
+
+// enumFactoryValue returns the Go expression picking a random variant
+func enumFactoryValue(model *ast.ModelDecl, field *ast.FieldDecl) string {
+	consts := make([]string, len(field.Variants))
+	for i, variant := range field.Variants {
+		consts[i] = enumConstName(model, field, variant)
+	}
+	return fmt.Sprintf("[]%s{%s}[mrand.IntN(%d)]", enumTypeName(model, field), strings.Join(consts, ", "), len(consts))
+}
@@ -0,0 +1,62 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+)
+
+const enumsScriptSrc = `model Task {
+  id: uuid @pk @default(uuid_v4)
+  status: enum(pending, in_progress, done) @default(pending)
+  priority: enum(low, high)
+}`
+
+func TestGenerateEnums(t *testing.T) {
+	code, err := New().Generate(scriptTestFile(t, enumsScriptSrc, ""))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, want := range []string{
+		"type TaskStatus string",
+		`TaskStatusInProgress TaskStatus = "in_progress"`,
+		"func (v TaskStatus) Valid() bool {",
+		"case TaskStatusPending, TaskStatusInProgress, TaskStatusDone:",
+		"Status TaskStatus `gorm:\"default:pending;check:status IN ('pending','in_progress','done')\" json:\"status\"`",
+		"Priority TaskPriority `gorm:\"check:priority IN ('low','high')\" json:\"priority\"`",
+		// An empty value takes the @default on create
+		`if t.Status != "" && !t.Status.Valid() {`,
+		`if !t.Priority.Valid() {`,
+		`Message: fmt.Sprintf("must be one of low, high, got %q", t.Priority)`,
+	} {
+		if !strings.Contains(strings.Join(strings.Fields(code), " "), strings.Join(strings.Fields(want), " ")) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+}
+
+func TestValidateEnums(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{"no variants", strings.Replace(enumsScriptSrc, "enum(low, high)", "enum()", 1), "enum lists its values"},
+		{"default", strings.Replace(enumsScriptSrc, "@default(pending)", "@default(started)", 1), "@default(started) is not one of pending, in_progress, done"},
+		{"same constant", strings.Replace(enumsScriptSrc, "enum(low, high)", "enum(very_low, veryLow)", 1), "variants very_low and veryLow are both TaskPriorityVeryLow in Go"},
+		{"annotation", strings.Replace(enumsScriptSrc, "enum(low, high)", "enum(low, high) @max(4)", 1), "@max does not apply to enum fields"},
+		{"type collision", enumsScriptSrc + "\nmodel TaskStatus {\n  id: int @pk\n}", "its Go type TaskStatus collides with model TaskStatus"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New().Generate(scriptTestFile(t, tt.src, ""))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
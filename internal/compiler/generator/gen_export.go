@@ -0,0 +1,305 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// personalDataExportPath is the built-in endpoint answering the personal data
+// of the session user, as JSON or as a zip of CSV files
+const personalDataExportPath = "/_gmx/export"
+
+// personalDataRoutes are the built-in personal data endpoints
+var personalDataRoutes = []Route{
+	{Method: "GET", Path: personalDataExportPath, Handler: "handlePersonalDataExport"},
+}
+
+// personalDataModels returns the models marked @personalData, whose records
+// the export gathers
+func (g *Generator) personalDataModels(file *ast.GMXFile) []*ast.ModelDecl {
+	var models []*ast.ModelDecl
+	for _, model := range file.Models {
+		if model.FindAnnotation("personalData") != nil {
+			models = append(models, model)
+		}
+	}
+	return models
+}
+
+// hasPersonalData checks if models marked @personalData get the export endpoint
+func (g *Generator) hasPersonalData(file *ast.GMXFile) bool {
+	return len(g.personalDataModels(file)) > 0
+}
+
+// personalDataOwner returns the field naming the user a record belongs to: the
+// field of @personalData(field), the primary key of the users themselves
+func personalDataOwner(model *ast.ModelDecl) *ast.FieldDecl {
+	if name := model.FindAnnotation("personalData").SimpleArg(); name != "" {
+		return findModelField(model, name)
+	}
+	return modelPKField(model)
+}
+
+// personalDataFields returns the exported fields of a model: its primary key
+// and @personalData fields when it marks some, its other fields otherwise.
+// Password and bytes fields never leave the server, and relations are
+// exported as the records of their own model.
+func personalDataFields(file *ast.GMXFile, model *ast.ModelDecl) []*ast.FieldDecl {
+	marked := false
+	for _, field := range model.Fields {
+		if field.FindAnnotation("personalData") != nil {
+			marked = true
+		}
+	}
+	var fields []*ast.FieldDecl
+	for _, field := range model.Fields {
+		if field.Type == "password" || isBytesField(field) || isRelationField(file, field) {
+			continue
+		}
+		if marked && field.FindAnnotation("personalData") == nil && field.FindAnnotation("pk") == nil {
+			continue
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// validatePersonalData checks that each @personalData model names a field the
+// session user is found by, that its marked fields can be exported, and that
+// the export has a session and a free endpoint
+func (g *Generator) validatePersonalData(file *ast.GMXFile) error {
+	for _, model := range file.Models {
+		ann := model.FindAnnotation("personalData")
+		if ann == nil {
+			for _, field := range model.Fields {
+				if field.FindAnnotation("personalData") != nil {
+					return fmt.Errorf("line %d: model %s: field %s is @personalData, which requires @personalData on the model", field.Line, model.Name, field.Name)
+				}
+			}
+			continue
+		}
+
+		owner := ann.SimpleArg()
+		if len(ann.Args) > 1 || (len(ann.Args) == 1 && owner == "") {
+			return fmt.Errorf("model %s: @personalData takes the field naming the user of a record, as in @personalData(userId)", model.Name)
+		}
+		field := personalDataOwner(model)
+		switch {
+		case owner != "" && field == nil:
+			return fmt.Errorf("model %s: @personalData(%s) names no field of the model", model.Name, owner)
+		case owner != "" && field.Type != "uuid" && field.Type != "string":
+			return fmt.Errorf("model %s: @personalData(%s) requires a uuid or string field, the ID sessions identify users by", model.Name, owner)
+		case owner == "" && (field == nil || (field.Type != "uuid" && field.Type != "string")):
+			return fmt.Errorf("model %s: @personalData without a field requires a uuid or string primary key, the ID sessions identify users by", model.Name)
+		}
+		for _, field := range model.Fields {
+			if field.FindAnnotation("personalData") != nil && (field.Type == "password" || isBytesField(field)) {
+				return fmt.Errorf("line %d: model %s: field %s: @personalData does not apply to password and bytes fields, which never leave the server", field.Line, model.Name, field.Name)
+			}
+		}
+		if model.FindAnnotation("repository") != nil {
+			return fmt.Errorf("model %s: @personalData does not apply to @repository models, whose records the export cannot load", model.Name)
+		}
+		if g.findSessionService(file.Services) == nil {
+			return fmt.Errorf("model %s: @personalData requires a service with provider \"session\"", model.Name)
+		}
+	}
+
+	if !g.hasPersonalData(file) || file.Script == nil {
+		return nil
+	}
+	for _, fn := range file.Script.Funcs {
+		for _, route := range personalDataRoutes {
+			if "handle"+utils.Capitalize(fn.Name) == route.Handler {
+				return fmt.Errorf("line %d: function %s collides with the built-in %s endpoint; rename it", fn.Line, fn.Name, route.Path)
+			}
+		}
+	}
+	return nil
+}
+
+// genPersonalDataExport generates the export of the @personalData records of
+// the session user: loaded through the connection of the request, filtered by
+// the tenant and the read rules of the policies, encoded as their models
+// encode to JSON, and answered as JSON or as a zip of CSV files
+func (g *Generator) genPersonalDataExport(file *ast.GMXFile) string {
+	var b strings.Builder
+	hasRLS := g.hasRowLevelSecurity(file)
+
+	b.WriteString("// personalDataSet holds the exported records of a model, as the JSON values of\n")
+	b.WriteString("// their exported fields\n")
+	b.WriteString("type personalDataSet struct {\n")
+	b.WriteString("\tModel   string\n")
+	b.WriteString("\tFields  []string\n")
+	b.WriteString("\tRecords []map[string]json.RawMessage\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// appendPersonalData appends the records of a model to an export, encoded as\n")
+	b.WriteString("// the model encodes them to JSON and kept to the exported fields\n")
+	b.WriteString("func appendPersonalData(sets []personalDataSet, model string, fields []string, records any) ([]personalDataSet, error) {\n")
+	b.WriteString("\traw, err := json.Marshal(records)\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\treturn sets, err\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tvar objects []map[string]json.RawMessage\n")
+	b.WriteString("\tif err := json.Unmarshal(raw, &objects); err != nil {\n")
+	b.WriteString("\t\treturn sets, err\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tset := personalDataSet{Model: model, Fields: fields, Records: []map[string]json.RawMessage{}}\n")
+	b.WriteString("\tfor _, object := range objects {\n")
+	b.WriteString("\t\trecord := make(map[string]json.RawMessage, len(fields))\n")
+	b.WriteString("\t\tfor _, field := range fields {\n")
+	b.WriteString("\t\t\trecord[field] = object[field]\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tset.Records = append(set.Records, record)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn append(sets, set), nil\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// loadPersonalData loads the @personalData records of a user, model by model\n")
+	b.WriteString("func loadPersonalData(r *http.Request, user string) ([]personalDataSet, error) {\n")
+	if hasRLS {
+		b.WriteString("\t// The request's connection carries the row-level security settings\n")
+		b.WriteString("\tconn := requestDB(r)\n")
+	} else {
+		b.WriteString("\tconn := db.WithContext(r.Context())\n")
+	}
+	needsCtx := false
+	for _, model := range g.personalDataModels(file) {
+		if model.Policy != nil && model.Policy.FindRule("read") != nil {
+			needsCtx = true
+		}
+	}
+	if needsCtx {
+		b.WriteString("\tctx := &GMXContext{DB: conn, Request: r, Log: requestLogger(r), User: user")
+		if hasRLS {
+			b.WriteString(", Tenant: requestTenant(r)")
+		}
+		b.WriteString("}\n")
+	}
+	b.WriteString("\tvar sets []personalDataSet\n")
+	b.WriteString("\tvar err error\n")
+	for _, model := range g.personalDataModels(file) {
+		records := utils.LowerFirst(model.Name) + "Records"
+		cond := fmt.Sprintf("%q: user", utils.ToSnakeCase(personalDataOwner(model).Name))
+		if hasRLS {
+			for _, field := range model.Fields {
+				if field.FindAnnotation("scoped") != nil {
+					cond += fmt.Sprintf(", %q: requestTenant(r)", utils.ToSnakeCase(field.Name))
+					break
+				}
+			}
+		}
+		var fields []string
+		for _, field := range personalDataFields(file, model) {
+			fields = append(fields, fmt.Sprintf("%q", field.Name))
+		}
+
+		b.WriteString("\n")
+		b.WriteString(fmt.Sprintf("\tvar %s []%s\n", records, model.Name))
+		b.WriteString(fmt.Sprintf("\tif err := conn.Where(map[string]any{%s}).Find(&%s).Error; err != nil {\n", cond, records))
+		b.WriteString(fmt.Sprintf("\t\treturn nil, fmt.Errorf(\"loading %s: %%w\", err)\n", model.Name))
+		b.WriteString("\t}\n")
+		if model.Policy != nil && model.Policy.FindRule("read") != nil {
+			b.WriteString(fmt.Sprintf("\t%s = %sReadable(ctx, %s)\n", records, model.Name, records))
+		}
+		b.WriteString(fmt.Sprintf("\tif sets, err = appendPersonalData(sets, %q, []string{%s}, %s); err != nil {\n", model.Name, strings.Join(fields, ", "), records))
+		b.WriteString("\t\treturn nil, err\n")
+		b.WriteString("\t}\n")
+	}
+	b.WriteString("\treturn sets, nil\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// personalDataCSVValue returns a JSON value as a CSV cell: strings unquoted,\n")
+	b.WriteString("// null empty, other values as JSON\n")
+	b.WriteString("func personalDataCSVValue(raw json.RawMessage) string {\n")
+	b.WriteString("\tvar s string\n")
+	b.WriteString("\tif json.Unmarshal(raw, &s) == nil {\n")
+	b.WriteString("\t\treturn s\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif len(raw) == 0 || string(raw) == \"null\" {\n")
+	b.WriteString("\t\treturn \"\"\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn string(raw)\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// writePersonalDataCSV writes an export as a zip of CSV files, one per model\n")
+	b.WriteString("// with a header row of its fields\n")
+	b.WriteString("func writePersonalDataCSV(w io.Writer, sets []personalDataSet) error {\n")
+	b.WriteString("\tarchive := zip.NewWriter(w)\n")
+	b.WriteString("\tfor _, set := range sets {\n")
+	b.WriteString("\t\tf, err := archive.Create(set.Model + \".csv\")\n")
+	b.WriteString("\t\tif err != nil {\n")
+	b.WriteString("\t\t\treturn err\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tcw := csv.NewWriter(f)\n")
+	b.WriteString("\t\tcw.Write(set.Fields)\n")
+	b.WriteString("\t\tfor _, record := range set.Records {\n")
+	b.WriteString("\t\t\trow := make([]string, len(set.Fields))\n")
+	b.WriteString("\t\t\tfor i, field := range set.Fields {\n")
+	b.WriteString("\t\t\t\trow[i] = personalDataCSVValue(record[field])\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t\tcw.Write(row)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tif cw.Flush(); cw.Error() != nil {\n")
+	b.WriteString("\t\t\treturn cw.Error()\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn archive.Close()\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// handlePersonalDataExport answers the @personalData records of the session\n")
+	b.WriteString("// user as a JSON download, or as a zip of CSV files with ?format=csv; each\n")
+	b.WriteString("// export is audited\n")
+	b.WriteString("func handlePersonalDataExport(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString(genMethodGuard("Get"))
+	b.WriteString("\ts := readSession(r)\n")
+	b.WriteString("\tif s.User == \"\" {\n")
+	b.WriteString("\t\thttp.Error(w, \"Unauthorized\", http.StatusUnauthorized)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\t// An admin impersonating a user does not take their data away\n")
+	b.WriteString("\tif s.Impersonator != \"\" {\n")
+	b.WriteString("\t\thttp.Error(w, \"Forbidden\", http.StatusForbidden)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tformat := r.URL.Query().Get(\"format\")\n")
+	b.WriteString("\tif format == \"\" {\n")
+	b.WriteString("\t\tformat = \"json\"\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif format != \"json\" && format != \"csv\" {\n")
+	b.WriteString("\t\thttp.Error(w, \"Unsupported format: expected json or csv\", http.StatusBadRequest)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tsets, err := loadPersonalData(r, s.User)\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\trequestLogger(r).Error(\"personal data export\", \"error\", err)\n")
+	b.WriteString("\t\thttp.Error(w, \"Internal Server Error\", http.StatusInternalServerError)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\trequestLogger(r).Info(\"audit: personal data exported\", \"user\", s.User, \"format\", format)\n")
+	b.WriteString("\tw.Header().Set(\"Cache-Control\", \"no-store\")\n")
+	b.WriteString("\tif format == \"csv\" {\n")
+	b.WriteString("\t\tw.Header().Set(\"Content-Type\", \"application/zip\")\n")
+	b.WriteString("\t\tw.Header().Set(\"Content-Disposition\", `attachment; filename=\"personal-data.zip\"`)\n")
+	b.WriteString("\t\tif err := writePersonalDataCSV(w, sets); err != nil {\n")
+	b.WriteString("\t\t\trequestLogger(r).Error(\"personal data export\", \"error\", err)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tdata := make(map[string][]map[string]json.RawMessage, len(sets))\n")
+	b.WriteString("\tfor _, set := range sets {\n")
+	b.WriteString("\t\tdata[set.Model] = set.Records\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"application/json\")\n")
+	b.WriteString("\tw.Header().Set(\"Content-Disposition\", `attachment; filename=\"personal-data.json\"`)\n")
+	b.WriteString("\tenc := json.NewEncoder(w)\n")
+	b.WriteString("\tenc.SetIndent(\"\", \"  \")\n")
+	b.WriteString("\tenc.Encode(map[string]any{\"user\": s.User, \"exportedAt\": time.Now().UTC().Format(time.RFC3339), \"data\": data})\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
@@ -0,0 +1,172 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// personalDataTestFile returns the session test file with a @personalData User
+// model and a Note model exporting its text, found by the user it belongs to
+func personalDataTestFile() *ast.GMXFile {
+	file := sessionTestFile(false)
+	file.Template = &ast.TemplateBlock{Source: `<a href="/_gmx/export">Export</a>`}
+	file.Models = []*ast.ModelDecl{
+		{
+			Name:        "User",
+			Annotations: []*ast.Annotation{{Name: "personalData"}},
+			Fields: []*ast.FieldDecl{
+				{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{{Name: "pk"}, {Name: "default", Args: map[string]string{"_": "uuid_v4"}}}},
+				{Name: "email", Type: "string", Annotations: []*ast.Annotation{{Name: "email"}}},
+				{Name: "password", Type: "password"},
+			},
+		},
+		{
+			Name:        "Note",
+			Annotations: []*ast.Annotation{{Name: "personalData", Args: map[string]string{"_": "userId"}}},
+			Fields: []*ast.FieldDecl{
+				{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{{Name: "pk"}, {Name: "default", Args: map[string]string{"_": "uuid_v4"}}}},
+				{Name: "text", Type: "string", Annotations: []*ast.Annotation{{Name: "personalData"}}},
+				{Name: "draft", Type: "string"},
+				{Name: "userId", Type: "uuid"},
+				{Name: "user", Type: "User", Annotations: []*ast.Annotation{{Name: "relation", Args: map[string]string{"references": "[id]"}}}},
+			},
+		},
+	}
+	return file
+}
+
+func TestGenerator_PersonalData(t *testing.T) {
+	code, err := New().Generate(personalDataTestFile())
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	expected := []string{
+		"\t\"archive/zip\"\n",
+		"\t\"encoding/csv\"\n",
+		"\tmux.HandleFunc(\"/_gmx/export\", handlePersonalDataExport)\n",
+		// Users are found by their key, notes by the user they belong to
+		"conn.Where(map[string]any{\"id\": user}).Find(&userRecords)",
+		"conn.Where(map[string]any{\"user_id\": user}).Find(&noteRecords)",
+		// Passwords and relations are left out, as are the fields a model does not mark
+		"appendPersonalData(sets, \"User\", []string{\"id\", \"email\"}, userRecords)",
+		"appendPersonalData(sets, \"Note\", []string{\"id\", \"text\"}, noteRecords)",
+		// An impersonating admin cannot export, and each export is audited
+		"\tif s.Impersonator != \"\" {\n\t\thttp.Error(w, \"Forbidden\", http.StatusForbidden)",
+		"requestLogger(r).Info(\"audit: personal data exported\", \"user\", s.User, \"format\", format)",
+		"`attachment; filename=\"personal-data.zip\"`",
+	}
+	for _, exp := range expected {
+		if !strings.Contains(code, exp) {
+			t.Errorf("Generated code missing %q", exp)
+		}
+	}
+}
+
+func TestGenerator_PersonalDataRowLevelSecurity(t *testing.T) {
+	file := rlsTestFile("postgres")
+	file.Models[0].Annotations = []*ast.Annotation{{Name: "personalData", Args: map[string]string{"_": "userId"}}}
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	// Records are read through the connection of the request, in its tenant,
+	// and filtered by the read rule of the policy
+	for _, want := range []string{
+		"\tconn := requestDB(r)\n",
+		"ctx := &GMXContext{DB: conn, Request: r, Log: requestLogger(r), User: user, Tenant: requestTenant(r)}",
+		"conn.Where(map[string]any{\"user_id\": user, \"tenant_id\": requestTenant(r)}).Find(&taskRecords)",
+		"\ttaskRecords = TaskReadable(ctx, taskRecords)\n",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("Generated code missing %q", want)
+		}
+	}
+}
+
+func TestGenerator_PersonalDataErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(file *ast.GMXFile)
+		wantErr string
+	}{
+		{
+			name: "unknown field",
+			modify: func(file *ast.GMXFile) {
+				file.Models[1].Annotations[0].Args["_"] = "ownerId"
+			},
+			wantErr: "model Note: @personalData(ownerId) names no field of the model",
+		},
+		{
+			name: "integer field",
+			modify: func(file *ast.GMXFile) {
+				file.Models[1].Fields[3].Type = "int"
+			},
+			wantErr: "model Note: @personalData(userId) requires a uuid or string field",
+		},
+		{
+			name: "integer key",
+			modify: func(file *ast.GMXFile) {
+				file.Models[0].Fields[0].Type = "int"
+				file.Models[0].Fields[0].Annotations = []*ast.Annotation{{Name: "pk"}}
+			},
+			wantErr: "model User: @personalData without a field requires a uuid or string primary key",
+		},
+		{
+			name: "password field",
+			modify: func(file *ast.GMXFile) {
+				file.Models[0].Fields[2].Annotations = []*ast.Annotation{{Name: "personalData"}}
+			},
+			wantErr: "model User: field password: @personalData does not apply to password and bytes fields",
+		},
+		{
+			name: "field without model annotation",
+			modify: func(file *ast.GMXFile) {
+				file.Models[1].Annotations = nil
+			},
+			wantErr: "model Note: field text is @personalData, which requires @personalData on the model",
+		},
+		{
+			name: "repository model",
+			modify: func(file *ast.GMXFile) {
+				file.Models[0].Annotations = append(file.Models[0].Annotations, &ast.Annotation{Name: "repository", Args: map[string]string{"_": "UserRepo"}})
+			},
+			wantErr: "model User: @personalData does not apply to @repository models",
+		},
+		{
+			name: "without session service",
+			modify: func(file *ast.GMXFile) {
+				file.Services = nil
+				file.Script.Funcs = nil
+			},
+			wantErr: "model User: @personalData requires a service with provider \"session\"",
+		},
+		{
+			name: "colliding function",
+			modify: func(file *ast.GMXFile) {
+				file.Script.Funcs[0].Name = "personalDataExport"
+			},
+			wantErr: "function personalDataExport collides with the built-in /_gmx/export endpoint",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := personalDataTestFile()
+			tt.modify(file)
+			_, err := New().Generate(file)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+func TestFactoryValue(t *testing.T) {
+	ann := func(name, arg string) *ast.Annotation {
+		return &ast.Annotation{Name: name, Args: map[string]string{"_": arg}}
+	}
+
+	tests := []struct {
+		name     string
+		field    *ast.FieldDecl
+		expected string
+	}{
+		{"plain string", &ast.FieldDecl{Name: "title", Type: "string"}, "factoryString(3, 15)"},
+		{"string bounds", &ast.FieldDecl{Name: "title", Type: "string", Annotations: []*ast.Annotation{ann("min", "5"), ann("max", "8")}}, "factoryString(5, 8)"},
+		{"large max capped", &ast.FieldDecl{Name: "title", Type: "string", Annotations: []*ast.Annotation{ann("max", "255")}}, "factoryString(3, 15)"},
+		{"short max", &ast.FieldDecl{Name: "code", Type: "string", Annotations: []*ast.Annotation{ann("max", "2")}}, "factoryString(2, 2)"},
+		{"password min", &ast.FieldDecl{Name: "password", Type: "password", Annotations: []*ast.Annotation{ann("min", "8")}}, "factoryString(8, 20)"},
+		{"email", &ast.FieldDecl{Name: "email", Type: "string", Annotations: []*ast.Annotation{ann("email", "")}}, `fmt.Sprintf("user%d@example.com", factorySeq.Add(1))`},
+		{"int bounds", &ast.FieldDecl{Name: "age", Type: "int", Annotations: []*ast.Annotation{ann("min", "18"), ann("max", "99")}}, "18 + mrand.IntN(82)"},
+		{"plain int", &ast.FieldDecl{Name: "count", Type: "int"}, "mrand.IntN(1001)"},
+		{"bool", &ast.FieldDecl{Name: "done", Type: "bool"}, "mrand.IntN(2) == 1"},
+		{"uuid pk", &ast.FieldDecl{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{ann("pk", ""), ann("default", "uuid_v4")}}, ""},
+		{"default", &ast.FieldDecl{Name: "status", Type: "string", Annotations: []*ast.Annotation{ann("default", "open")}}, ""},
+		{"relation", &ast.FieldDecl{Name: "author", Type: "User"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := factoryValue(tt.field); got != tt.expected {
+				t.Errorf("factoryValue() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGenerator_Factories(t *testing.T) {
+	file := &ast.GMXFile{
+		Models: []*ast.ModelDecl{
+			{
+				Name: "Task",
+				Fields: []*ast.FieldDecl{
+					{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{{Name: "pk", Args: map[string]string{}}}},
+					{Name: "title", Type: "string", Annotations: []*ast.Annotation{{Name: "min", Args: map[string]string{"_": "3"}}}},
+					{Name: "done", Type: "bool"},
+				},
+			},
+		},
+	}
+
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	expected := []string{
+		"var factory factories",
+		"func (factories) NewTask(overrides ...func(*Task)) *Task {",
+		"Title: factoryString(3, 15),",
+		"Done:  mrand.IntN(2) == 1,",
+		"override(t)",
+		`"sync/atomic"`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(code, exp) {
+			t.Errorf("Generated code missing %q", exp)
+		}
+	}
+}
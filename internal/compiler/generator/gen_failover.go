@@ -0,0 +1,224 @@
+package generator
+
+import (
+	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"strings"
+)
+
+// dbSwitchoverPath is the admin endpoint triggering a database switchover
+const dbSwitchoverPath = "/_gmx/db/switchover"
+
+// hasDatabaseStandby checks if the Database service declares a standby URL
+func (g *Generator) hasDatabaseStandby(file *ast.GMXFile) bool {
+	if len(file.Models) == 0 {
+		return false
+	}
+	svc := g.findDatabaseService(file.Services)
+	return svc != nil && findServiceField(svc, "standby") != nil
+}
+
+// genHoldDB returns the statements holding the database for the rest of a
+// unit of work, so that a switchover waits for it; nothing without a standby.
+// Units of work hold it once: one nested in another would deadlock with a
+// pending switchover
+func (g *Generator) genHoldDB(file *ast.GMXFile, indent string) string {
+	if !g.hasDatabaseStandby(file) {
+		return ""
+	}
+	return indent + "release := holdDB()\n" + indent + "defer release()\n"
+}
+
+// streamPaths returns the paths of the server-sent events streams, held open
+// for as long as their client stays
+func (g *Generator) streamPaths(file *ast.GMXFile) []string {
+	var paths []string
+	for _, model := range liveModels(file) {
+		paths = append(paths, liveEventsPrefix+model.Name)
+	}
+	if g.hasNotifications(file) {
+		paths = append(paths, notificationsStreamPath)
+	}
+	return paths
+}
+
+// genDatabaseFailover generates the primary/standby switchover: a read lock held
+// by each request and background run drains in-flight work before the GORM
+// pool is re-pointed
+func (g *Generator) genDatabaseFailover(file *ast.GMXFile) string {
+	var b strings.Builder
+
+	svc := g.findDatabaseService(file.Services)
+
+	b.WriteString("// dbSwitch serializes database switchovers with in-flight requests\n")
+	b.WriteString("var dbSwitch sync.RWMutex\n\n")
+
+	b.WriteString("// dbTargets holds the primary and standby URLs; dbActive indexes the one in use\n")
+	b.WriteString("var (\n")
+	b.WriteString("\tdbTargets [2]string\n")
+	b.WriteString("\tdbActive  int\n")
+	b.WriteString("\tdbRoles   = [2]string{\"primary\", \"standby\"}\n")
+	b.WriteString(")\n\n")
+
+	b.WriteString("// openDatabase opens a GORM connection for the configured provider\n")
+	b.WriteString("func openDatabase(url string) (*gorm.DB, error) {\n")
+	open := "sqlite.Open(url)"
+	switch svc.Provider {
+	case "postgres":
+		open = "postgres.Open(url)"
+	case "mysql":
+		open = "mysql.Open(url)"
+	}
+	if plugins := g.gormPlugins(file); len(plugins) > 0 {
+		// The standby's queries are timed and traced as the primary's
+		b.WriteString(fmt.Sprintf("\tconn, err := gorm.Open(%s, %s)\n", open, g.gormConfig(file)))
+		b.WriteString("\tif err != nil {\n")
+		b.WriteString("\t\treturn nil, err\n")
+		b.WriteString("\t}\n")
+		for _, plugin := range plugins[:len(plugins)-1] {
+			b.WriteString(fmt.Sprintf("\tif err := conn.Use(%s); err != nil {\n", plugin))
+			b.WriteString("\t\treturn nil, err\n")
+			b.WriteString("\t}\n")
+		}
+		b.WriteString(fmt.Sprintf("\treturn conn, conn.Use(%s)\n", plugins[len(plugins)-1]))
+	} else {
+		b.WriteString(fmt.Sprintf("\treturn gorm.Open(%s, %s)\n", open, g.gormConfig(file)))
+	}
+	b.WriteString("}\n\n")
+
+	b.WriteString("// holdDB holds the database read lock for a unit of work, a request or a\n")
+	b.WriteString("// background run, until release: db is not switched meanwhile, and a\n")
+	b.WriteString("// switchover waits for the units of work in flight\n")
+	b.WriteString("func holdDB() (release func()) {\n")
+	b.WriteString("\tdbSwitch.RLock()\n")
+	b.WriteString("\treturn dbSwitch.RUnlock\n")
+	b.WriteString("}\n\n")
+
+	streams := g.streamPaths(file)
+	if len(streams) > 0 {
+		b.WriteString("// streamHold releases the database hold of a server-sent events stream at\n")
+		b.WriteString("// its first flush: the stream then takes the database around its own queries\n")
+		b.WriteString("type streamHold struct {\n")
+		b.WriteString("\thttp.ResponseWriter\n")
+		b.WriteString("\trelease func()\n")
+		b.WriteString("\tonce    sync.Once\n")
+		b.WriteString("}\n\n")
+		b.WriteString("func (w *streamHold) done() {\n")
+		b.WriteString("\tw.once.Do(w.release)\n")
+		b.WriteString("}\n\n")
+		b.WriteString("func (w *streamHold) Flush() {\n")
+		b.WriteString("\tw.done()\n")
+		b.WriteString("\tif f, ok := w.ResponseWriter.(http.Flusher); ok {\n")
+		b.WriteString("\t\tf.Flush()\n")
+		b.WriteString("\t}\n")
+		b.WriteString("}\n\n")
+	}
+
+	b.WriteString("// dbDrain holds the database for each request, so a switchover waits for in-flight work\n")
+	b.WriteString("func dbDrain(next http.Handler) http.Handler {\n")
+	b.WriteString("\treturn http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString(fmt.Sprintf("\t\tif r.URL.Path == %q {\n", dbSwitchoverPath))
+	b.WriteString("\t\t\tnext.ServeHTTP(w, r)\n")
+	b.WriteString("\t\t\treturn\n")
+	b.WriteString("\t\t}\n")
+	if len(streams) > 0 {
+		// An open stream would otherwise stall a switchover, and every request
+		// queued behind it, until its client leaves
+		var conds []string
+		for _, path := range streams {
+			conds = append(conds, fmt.Sprintf("r.URL.Path == %q", path))
+		}
+		b.WriteString(fmt.Sprintf("\t\tif %s {\n", strings.Join(conds, " || ")))
+		b.WriteString("\t\t\tstream := &streamHold{ResponseWriter: w, release: holdDB()}\n")
+		b.WriteString("\t\t\tdefer stream.done()\n")
+		b.WriteString("\t\t\tnext.ServeHTTP(stream, r)\n")
+		b.WriteString("\t\t\treturn\n")
+		b.WriteString("\t\t}\n")
+	}
+	b.WriteString(g.genHoldDB(file, "\t\t"))
+	b.WriteString("\t\tnext.ServeHTTP(w, r)\n")
+	b.WriteString("\t})\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// closeDatabase closes the pool of a database target\n")
+	b.WriteString("func closeDatabase(conn *gorm.DB, target int) {\n")
+	b.WriteString("\tif sqlDB, err := conn.DB(); err == nil {\n")
+	b.WriteString("\t\tif err := sqlDB.Close(); err != nil {\n")
+	b.WriteString("\t\t\tslog.Error(\"closing the database\", \"role\", dbRoles[target], \"error\", err)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// switchDatabase drains in-flight requests and background runs, then\n")
+	b.WriteString("// re-points the pool to the other target\n")
+	b.WriteString("func switchDatabase() error {\n")
+	b.WriteString("\tdbSwitch.Lock()\n")
+	b.WriteString("\tdefer dbSwitch.Unlock()\n\n")
+	b.WriteString("\tnext := 1 - dbActive\n")
+	b.WriteString("\tnewDB, err := openDatabase(dbTargets[next])\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"opening %s database: %w\", dbRoles[next], err)\n")
+	b.WriteString("\t}\n")
+	// The target gets the schema of the build the way the primary did: its
+	// versioned migrations, or AutoMigrate without them
+	if g.hasMigrations(file) {
+		b.WriteString("\tif err := runMigrations(newDB); err != nil {\n")
+	} else {
+		var models []string
+		for _, model := range file.Models {
+			models = append(models, "&"+model.Name+"{}")
+		}
+		b.WriteString(fmt.Sprintf("\tif err := newDB.AutoMigrate(%s); err != nil {\n", strings.Join(models, ", ")))
+	}
+	b.WriteString("\t\tcloseDatabase(newDB, next)\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"migrating %s database: %w\", dbRoles[next], err)\n")
+	b.WriteString("\t}\n")
+	if g.hasRowLevelSecurity(file) {
+		b.WriteString("\tif err := enableRowLevelSecurity(newDB); err != nil {\n")
+		b.WriteString("\t\tcloseDatabase(newDB, next)\n")
+		b.WriteString("\t\treturn fmt.Errorf(\"enabling row-level security on %s database: %w\", dbRoles[next], err)\n")
+		b.WriteString("\t}\n")
+	}
+	b.WriteString("\tcloseDatabase(db, dbActive)\n")
+	b.WriteString("\tdb = newDB\n")
+	b.WriteString("\tdbActive = next\n")
+	b.WriteString("\tslog.Info(\"database switched\", \"role\", dbRoles[next])\n")
+	b.WriteString("\treturn nil\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// watchDatabaseSwitchover triggers a switchover on each SIGHUP\n")
+	b.WriteString("func watchDatabaseSwitchover() {\n")
+	b.WriteString("\tsig := make(chan os.Signal, 1)\n")
+	b.WriteString("\tsignal.Notify(sig, syscall.SIGHUP)\n")
+	b.WriteString("\tfor range sig {\n")
+	b.WriteString("\t\tif err := switchDatabase(); err != nil {\n")
+	b.WriteString("\t\t\tslog.Error(\"database switchover failed\", \"error\", err)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	// The admin endpoint reuses the session admins when impersonation is configured
+	if g.hasImpersonation(file) {
+		b.WriteString("// handleDatabaseSwitchover lets an admin trigger a switchover over HTTP\n")
+		b.WriteString("func handleDatabaseSwitchover(w http.ResponseWriter, r *http.Request) {\n")
+		b.WriteString("\tif r.Method != http.MethodPost {\n")
+		b.WriteString("\t\thttp.Error(w, \"Method Not Allowed\", http.StatusMethodNotAllowed)\n")
+		b.WriteString("\t\treturn\n")
+		b.WriteString("\t}\n\n")
+		b.WriteString("\ts := readSession(r)\n")
+		b.WriteString("\tif s.Impersonator != \"\" || !sessionAdmins[s.User] {\n")
+		b.WriteString("\t\thttp.Error(w, \"Forbidden\", http.StatusForbidden)\n")
+		b.WriteString("\t\treturn\n")
+		b.WriteString("\t}\n\n")
+		b.WriteString("\trequestLogger(r).Info(\"audit: database switchover requested\", \"user\", s.User)\n")
+		b.WriteString("\tif err := switchDatabase(); err != nil {\n")
+		b.WriteString("\t\trequestLogger(r).Error(\"database switchover failed\", \"error\", err)\n")
+		b.WriteString("\t\thttp.Error(w, \"Switchover failed\", http.StatusServiceUnavailable)\n")
+		b.WriteString("\t\treturn\n")
+		b.WriteString("\t}\n")
+		b.WriteString("\tw.WriteHeader(http.StatusNoContent)\n")
+		b.WriteString("}\n\n")
+	}
+
+	return b.String()
+}
@@ -0,0 +1,385 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+func failoverTestFile(provider string) *ast.GMXFile {
+	return &ast.GMXFile{
+		Services: []*ast.ServiceDecl{
+			{
+				Name:     "Database",
+				Provider: provider,
+				Fields: []*ast.ServiceField{
+					{Name: "url", Type: "string", EnvVar: "DATABASE_URL"},
+					{Name: "standby", Type: "string", EnvVar: "DATABASE_STANDBY_URL"},
+				},
+			},
+		},
+		Models: []*ast.ModelDecl{
+			{Name: "Task", Fields: []*ast.FieldDecl{{Name: "title", Type: "string"}}},
+		},
+	}
+}
+
+func TestGenerator_DatabaseFailover(t *testing.T) {
+	tests := []struct {
+		provider string
+		open     string
+	}{
+		{"sqlite", "return gorm.Open(sqlite.Open(url), &gorm.Config{})"},
+		{"postgres", "return gorm.Open(postgres.Open(url), &gorm.Config{})"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			code, err := New().Generate(failoverTestFile(tt.provider))
+			if err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+			if !isValidGo(code) {
+				t.Errorf("Generated code is not valid Go:\n%s", code)
+			}
+
+			expected := []string{
+				tt.open,
+				"var dbSwitch sync.RWMutex",
+				"dbTargets = [2]string{databaseCfg.Url, databaseCfg.Standby}",
+				"go watchDatabaseSwitchover()",
+				"signal.Notify(sig, syscall.SIGHUP)",
+				"if err := newDB.AutoMigrate(&Task{}); err != nil {",
+				"csrfProtect(securityHeaders(dbDrain(mux)))",
+				`"os/signal"`,
+				`"sync"`,
+				`"syscall"`,
+			}
+			for _, exp := range expected {
+				if !strings.Contains(code, exp) {
+					t.Errorf("Generated code missing %q", exp)
+				}
+			}
+
+			// Without session admins there is no HTTP trigger
+			if strings.Contains(code, "func handleDatabaseSwitchover") {
+				t.Error("switchover endpoint requires session admins")
+			}
+		})
+	}
+}
+
+func TestGenerator_DatabaseFailoverAdminEndpoint(t *testing.T) {
+	file := failoverTestFile("sqlite")
+	file.Services = append(file.Services, &ast.ServiceDecl{
+		Name:     "Auth",
+		Provider: "session",
+		Fields: []*ast.ServiceField{
+			{Name: "secret", Type: "string", EnvVar: "SESSION_SECRET"},
+			{Name: "admins", Type: "string", EnvVar: "ADMIN_USERS"},
+		},
+	})
+
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, exp := range []string{
+		"func handleDatabaseSwitchover(w http.ResponseWriter, r *http.Request)",
+		`mux.HandleFunc("/_gmx/db/switchover", handleDatabaseSwitchover)`,
+		`if r.URL.Path == "/_gmx/db/switchover" {`,
+	} {
+		if !strings.Contains(code, exp) {
+			t.Errorf("Generated code missing %q", exp)
+		}
+	}
+}
+
+func TestGenerator_NoFailoverWithoutStandby(t *testing.T) {
+	file := failoverTestFile("sqlite")
+	file.Services[0].Fields = file.Services[0].Fields[:1]
+
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	for _, unexpected := range []string{"dbDrain", "switchDatabase", "holdDB"} {
+		if strings.Contains(code, unexpected) {
+			t.Errorf("Generated code should not contain %q without a standby URL", unexpected)
+		}
+	}
+}
+
+func TestGenerator_DatabaseFailoverHoldsBackgroundRuns(t *testing.T) {
+	withStandby := func(file *ast.GMXFile) *ast.GMXFile {
+		failover := failoverTestFile("sqlite")
+		file.Services = append(file.Services, failover.Services...)
+		if len(file.Models) == 0 {
+			file.Models = failover.Models
+		}
+		return file
+	}
+	hold := "\trelease := holdDB()\n\tdefer release()\n"
+
+	tests := []struct {
+		name     string
+		file     *ast.GMXFile
+		expected []string
+	}{
+		{
+			name: "jobs and schedules",
+			file: withStandby(scheduleFile()),
+			expected: []string{
+				"func runQueuedJob(job queuedJob) {\n" + hold,
+				"func runScheduled(name string, run func(ctx *GMXContext) error) {\n" + hold,
+				"\tjob.ctx.DB = db.WithContext(jobQueueCtx)\n",
+			},
+		},
+		{
+			name: "database sessions",
+			file: withStandby(sessionStoreFile("database")),
+			expected: []string{
+				"func deleteExpiredSessions() {\n" + hold,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := New().Generate(tt.file)
+			if err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+			if !isValidGo(code) {
+				t.Errorf("Generated code is not valid Go:\n%s", code)
+			}
+			for _, exp := range tt.expected {
+				if !strings.Contains(code, exp) {
+					t.Errorf("Generated code missing %q", exp)
+				}
+			}
+		})
+	}
+}
+
+// failoverJobTest runs in the generated package: a switchover waits for the
+// running job, and the job queued behind it runs against the standby
+const failoverJobTest = `package main
+
+import (
+	"log/slog"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSwitchoverWithQueuedJob(t *testing.T) {
+	dir := t.TempDir()
+	dbTargets = [2]string{filepath.Join(dir, "primary.db"), filepath.Join(dir, "standby.db")}
+	var err error
+	if db, err = openDatabase(dbTargets[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&Task{}); err != nil {
+		t.Fatal(err)
+	}
+	startJobWorkers(1, 10)
+
+	ctx := &GMXContext{DB: db, Request: httptest.NewRequest("POST", "/", nil), Log: slog.Default()}
+	running, finish := make(chan struct{}), make(chan struct{})
+	if err := enqueueJob(ctx, "block", nil, func(*GMXContext) error {
+		close(running)
+		<-finish
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	<-running
+	// Queued behind the running job, while the primary is in use
+	if err := enqueueAddTask(ctx, "queued"); err != nil {
+		t.Fatal(err)
+	}
+
+	switched := make(chan error, 1)
+	go func() { switched <- switchDatabase() }()
+	select {
+	case <-switched:
+		t.Fatal("the switchover did not wait for the running job")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(finish)
+	if err := <-switched; err != nil {
+		t.Fatalf("switchDatabase() error: %v", err)
+	}
+
+	var count int64
+	for deadline := time.Now().Add(5 * time.Second); count == 0 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		release := holdDB()
+		db.Model(&Task{}).Count(&count)
+		release()
+	}
+	if count != 1 {
+		t.Errorf("expected the queued job to write to the standby, found %d task(s)", count)
+	}
+}
+`
+
+func TestGenerateSwitchoverWithQueuedJob(t *testing.T) {
+	src := `service Database {
+  provider: "sqlite"
+  url:      string @env("DATABASE_URL")
+  standby:  string @env("DATABASE_STANDBY_URL")
+}
+model Task {
+  id:    uuid @pk @default(uuid_v4)
+  title: string
+}
+@job
+func addTask(title: string) error {
+  const task = Task{title: title}
+  try task.save()
+  return nil
+}
+func createTask(title: string) error {
+  enqueue addTask(title)
+  return nil
+}`
+	code, err := New().Generate(scriptTestFile(t, src, "<p>{{.CSRFToken}}</p>"))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	goInModule(t, map[string]string{"main.go": code, "main_test.go": failoverJobTest}, "test", ".")
+}
+
+// failoverStreamTest runs in the generated package: an open events stream
+// does not stall a switchover, nor the requests behind it
+const failoverStreamTest = `package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSwitchoverWithOpenStream(t *testing.T) {
+	dir := t.TempDir()
+	dbTargets = [2]string{filepath.Join(dir, "primary.db"), filepath.Join(dir, "standby.db")}
+	var err error
+	if db, err = openDatabase(dbTargets[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&Task{}); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events/Task", handleTaskEvents)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		var count int64
+		db.Model(&Task{}).Count(&count)
+	})
+	srv := httptest.NewServer(dbDrain(mux))
+	defer srv.Close()
+
+	stream, err := http.Get(srv.URL + "/events/Task")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+	go bufio.NewReader(stream.Body).ReadString('\n')
+
+	switched := make(chan error, 1)
+	go func() { switched <- switchDatabase() }()
+	select {
+	case err := <-switched:
+		if err != nil {
+			t.Fatalf("switchDatabase() error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the switchover waited for the open stream")
+	}
+	if dbActive != 1 {
+		t.Errorf("expected the standby to be active, got %s", dbRoles[dbActive])
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatalf("request behind the open stream: %v", err)
+	}
+	resp.Body.Close()
+}
+`
+
+func TestGenerateSwitchoverWithOpenStream(t *testing.T) {
+	src := `service Database {
+  provider: "sqlite"
+  url:      string @env("DATABASE_URL")
+  standby:  string @env("DATABASE_STANDBY_URL")
+}
+` + liveScript
+	code, err := New().Generate(scriptTestFile(t, src, `<ul>{{range .Tasks}}<li>{{.Title}}</li>{{end}}</ul>`))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !strings.Contains(code, `if r.URL.Path == "/events/Task" {`) {
+		t.Error("expected the events stream to be released from the request hold")
+	}
+	goInModule(t, map[string]string{"main.go": code, "main_test.go": failoverStreamTest}, "test", ".")
+}
+
+// failoverMigrationsTest runs in the generated package: a switchover applies
+// the versioned migrations of the build to the standby
+const failoverMigrationsTest = `package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSwitchoverWithMigrations(t *testing.T) {
+	dir := t.TempDir()
+	dbTargets = [2]string{filepath.Join(dir, "primary.db"), filepath.Join(dir, "standby.db")}
+	var err error
+	if db, err = openDatabase(dbTargets[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := runMigrations(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := switchDatabase(); err != nil {
+		t.Fatalf("switchDatabase() error: %v", err)
+	}
+	if applied, err := appliedMigration(db); err != nil || applied != 1 {
+		t.Errorf("expected migration 1 on the standby, got %d (%v)", applied, err)
+	}
+	if err := db.Create(&Task{Title: "migrated"}).Error; err != nil {
+		t.Errorf("writing to the standby: %v", err)
+	}
+}
+`
+
+func TestGenerateSwitchoverWithMigrations(t *testing.T) {
+	file := failoverTestFile("sqlite")
+	file.Template = &ast.TemplateBlock{Source: `<ul>{{range .Tasks}}<li>{{.Title}}</li>{{end}}</ul>`}
+	migrations := []Migration{{Version: 1, Name: "init", Up: "CREATE TABLE \"tasks\" (\n  \"title\" text\n);\n", Down: "DROP TABLE \"tasks\";\n"}}
+	code, err := NewWithOptions(Options{Migrations: migrations}).Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !strings.Contains(code, "\tif err := runMigrations(newDB); err != nil {\n\t\tcloseDatabase(newDB, next)\n") {
+		t.Error("expected the standby to be migrated with the migrations of the build")
+	}
+	if strings.Contains(code, "AutoMigrate(") {
+		t.Error("builds with migrations should not run AutoMigrate")
+	}
+	goInModule(t, map[string]string{"main.go": code, "main_test.go": failoverMigrationsTest}, "test", ".")
+}
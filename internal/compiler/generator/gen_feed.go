@@ -0,0 +1,256 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// activityModel is the model storing the activity feed, migrated with the others
+const activityModel = "Activity"
+
+// feedPath is the built-in endpoint rendering a page of the activity feed
+const feedPath = "/_gmx/feed"
+
+// feedHooks maps the events a @feedItem records to the GORM hook recording
+// them, in the same transaction as the change
+var feedHooks = map[string]string{"create": "AfterCreate", "update": "AfterUpdate", "delete": "AfterDelete"}
+
+// feedItems returns the @feedItem annotations of a model
+func feedItems(model *ast.ModelDecl) []*ast.Annotation {
+	var items []*ast.Annotation
+	for _, ann := range model.Annotations {
+		if ann.Name == "feedItem" {
+			items = append(items, ann)
+		}
+	}
+	return items
+}
+
+// feedEvent returns the event a @feedItem records: create unless given by on:
+func feedEvent(ann *ast.Annotation) string {
+	if on := ann.Args["on"]; on != "" {
+		return on
+	}
+	return "create"
+}
+
+// hasActivityFeed checks if a model records its events in the activity feed
+func (g *Generator) hasActivityFeed(file *ast.GMXFile) bool {
+	for _, model := range file.Models {
+		if len(feedItems(model)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// validateFeedItems checks that each @feedItem records a known event of a
+// model saved through GORM, with placeholders naming its fields
+func (g *Generator) validateFeedItems(file *ast.GMXFile) error {
+	if !g.hasActivityFeed(file) {
+		return nil
+	}
+	if file.Script != nil {
+		for _, fn := range file.Script.Funcs {
+			if "handle"+utils.Capitalize(fn.Name) == "handleActivityFeed" {
+				return fmt.Errorf("line %d: function %s collides with the built-in %s endpoint; rename it", fn.Line, fn.Name, feedPath)
+			}
+		}
+	}
+	for _, model := range file.Models {
+		if model.Name == activityModel {
+			return fmt.Errorf("line %d: model %s collides with the model storing the activity feed; rename it", model.Line, model.Name)
+		}
+		items := feedItems(model)
+		if len(items) == 0 {
+			continue
+		}
+		if model.FindAnnotation("repository") != nil {
+			return fmt.Errorf("model %s: @feedItem is recorded by GORM hooks, which a @repository does not run", model.Name)
+		}
+		if modelPKField(model) == nil {
+			return fmt.Errorf("model %s: @feedItem requires a @pk field, which identifies the record in the feed", model.Name)
+		}
+		seen := make(map[string]bool)
+		for _, ann := range items {
+			event := feedEvent(ann)
+			if feedHooks[event] == "" {
+				return fmt.Errorf("model %s: @feedItem(on: %s) is not an event (expected create, update or delete)", model.Name, event)
+			}
+			if seen[event] {
+				return fmt.Errorf("model %s: several @feedItem record the %s event", model.Name, event)
+			}
+			seen[event] = true
+			if _, _, err := feedMessage(model, ann); err != nil {
+				return fmt.Errorf("model %s: @feedItem: %w", model.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// feedMessage converts the message of a @feedItem to a format string and the
+// Go fields filling its placeholders: "created {task.title}" → "created %v", t.Title
+func feedMessage(model *ast.ModelDecl, ann *ast.Annotation) (string, []string, error) {
+	msg := ann.SimpleArg()
+	if msg == "" {
+		return "", nil, fmt.Errorf("a message is required, such as @feedItem(\"created {%s.title}\")", utils.LowerFirst(model.Name))
+	}
+	fields := make(map[string]bool)
+	for _, field := range model.Fields {
+		fields[field.Name] = true
+	}
+
+	var format strings.Builder
+	var args []string
+	record := utils.LowerFirst(model.Name) + "."
+	for {
+		open := strings.IndexByte(msg, '{')
+		if open < 0 {
+			break
+		}
+		end := strings.IndexByte(msg[open:], '}')
+		if end < 0 {
+			return "", nil, fmt.Errorf("unclosed placeholder in %q", ann.SimpleArg())
+		}
+		placeholder := msg[open+1 : open+end]
+		field, ok := strings.CutPrefix(placeholder, record)
+		if !ok || !fields[field] {
+			return "", nil, fmt.Errorf("placeholder {%s} is not a field of %s, such as {%stitle}", placeholder, model.Name, record)
+		}
+		format.WriteString(strings.ReplaceAll(msg[:open], "%", "%%"))
+		format.WriteString("%v")
+		args = append(args, utils.ReceiverName(model.Name)+"."+utils.ToPascalCase(field))
+		msg = msg[open+end+1:]
+	}
+	format.WriteString(strings.ReplaceAll(msg, "%", "%%"))
+	return format.String(), args, nil
+}
+
+// withActivityModel returns the file with the model storing its activity
+// feed, so that it is declared, migrated and loaded like the other models
+func (g *Generator) withActivityModel(file *ast.GMXFile) *ast.GMXFile {
+	if !g.hasActivityFeed(file) {
+		return file
+	}
+	withModel := *file
+	withModel.Models = append(append([]*ast.ModelDecl{}, file.Models...), &ast.ModelDecl{
+		Name: activityModel,
+		Fields: []*ast.FieldDecl{
+			{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{
+				{Name: "pk", Args: map[string]string{}},
+				{Name: "default", Args: map[string]string{"_": "uuid_v4"}},
+			}},
+			{Name: "subject", Type: "string"},
+			{Name: "subjectId", Type: "string"},
+			{Name: "event", Type: "string"},
+			{Name: "message", Type: "string"},
+			{Name: "actor", Type: "string"},
+			{Name: "createdAt", Type: "datetime"},
+		},
+	})
+	return &withModel
+}
+
+// genFeedHooks generates the GORM hooks recording the @feedItem events of a model
+func (g *Generator) genFeedHooks(model *ast.ModelDecl) string {
+	if len(feedItems(model)) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	recv := utils.ReceiverName(model.Name)
+	pk := utils.ToPascalCase(modelPKField(model).Name)
+	for _, ann := range feedItems(model) {
+		event := feedEvent(ann)
+		format, args, _ := feedMessage(model, ann) // validated in validateFeedItems
+		message := fmt.Sprintf("%q", format)
+		if len(args) > 0 {
+			message = fmt.Sprintf("fmt.Sprintf(%q, %s)", format, strings.Join(args, ", "))
+		}
+		b.WriteString(fmt.Sprintf("// %s records in the activity feed that a %s was %sd\n", feedHooks[event], model.Name, event))
+		b.WriteString(fmt.Sprintf("func (%s *%s) %s(tx *gorm.DB) error {\n", recv, model.Name, feedHooks[event]))
+		b.WriteString(fmt.Sprintf("\treturn recordActivity(tx, %q, fmt.Sprint(%s.%s), %q, %s)\n", model.Name, recv, pk, event, message))
+		b.WriteString("}\n\n")
+	}
+	return b.String()
+}
+
+// genActivityFeed generates recordActivity, called by the model hooks, and
+// the endpoint rendering the feed one page at a time
+func (g *Generator) genActivityFeed(hasSession bool) string {
+	var b strings.Builder
+
+	b.WriteString("// activityFeedPageSize is the number of activities per page of the feed\n")
+	b.WriteString("const activityFeedPageSize = 20\n\n")
+
+	b.WriteString("// activityActorKey carries the session user to the hooks recording activities\n")
+	b.WriteString("const activityActorKey = \"gmx:actor\"\n\n")
+
+	b.WriteString("// recordActivity appends an activity to the feed, in the transaction of the change\n")
+	b.WriteString("func recordActivity(tx *gorm.DB, subject, subjectID, event, message string) error {\n")
+	b.WriteString("\tactor, _ := tx.Get(activityActorKey)\n")
+	b.WriteString("\tuser, _ := actor.(string)\n")
+	b.WriteString(fmt.Sprintf("\tactivity := &%s{Subject: subject, SubjectId: subjectID, Event: event, Message: message, Actor: user, CreatedAt: time.Now()}\n", activityModel))
+	b.WriteString("\treturn tx.Session(&gorm.Session{NewDB: true}).Create(activity).Error\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// handleActivityFeed renders a page of the activity feed, newest first, with a\n")
+	b.WriteString("// button loading the next page in its place; ?subject=Task keeps one model\n")
+	b.WriteString("func handleActivityFeed(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString("\tif r.Method != http.MethodGet {\n")
+	b.WriteString("\t\thttp.Error(w, \"Method Not Allowed\", http.StatusMethodNotAllowed)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	if hasSession {
+		b.WriteString("\tif readSession(r).User == \"\" {\n")
+		b.WriteString("\t\thttp.Error(w, \"Unauthorized\", http.StatusUnauthorized)\n")
+		b.WriteString("\t\treturn\n")
+		b.WriteString("\t}\n\n")
+	}
+	b.WriteString("\tpage, err := strconv.Atoi(r.URL.Query().Get(\"page\"))\n")
+	b.WriteString("\tif err != nil || page < 1 {\n")
+	b.WriteString("\t\tpage = 1\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tquery := db.Order(\"created_at desc\").Offset((page - 1) * activityFeedPageSize).Limit(activityFeedPageSize + 1)\n")
+	b.WriteString("\tsubject := r.URL.Query().Get(\"subject\")\n")
+	b.WriteString("\tif subject != \"\" {\n")
+	b.WriteString("\t\tquery = query.Where(map[string]any{\"subject\": subject})\n")
+	b.WriteString("\t}\n")
+	b.WriteString(fmt.Sprintf("\tvar activities []%s\n", activityModel))
+	b.WriteString("\tif err := query.Find(&activities).Error; err != nil {\n")
+	b.WriteString("\t\trequestLogger(r).Error(\"activity feed\", \"error\", err)\n")
+	b.WriteString("\t\thttp.Error(w, \"Internal Server Error\", http.StatusInternalServerError)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\t// The extra activity tells whether a next page exists\n")
+	b.WriteString("\tmore := len(activities) > activityFeedPageSize\n")
+	b.WriteString("\tif more {\n")
+	b.WriteString("\t\tactivities = activities[:activityFeedPageSize]\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/html; charset=utf-8\")\n")
+	b.WriteString("\tif page == 1 && len(activities) == 0 {\n")
+	b.WriteString("\t\tfmt.Fprint(w, `<p class=\"gmx-feed-empty\">No activity yet</p>`)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tfor _, a := range activities {\n")
+	b.WriteString("\t\tfmt.Fprintf(w, `<article class=\"gmx-feed-item\" data-subject=\"%s\" data-event=\"%s\"><time datetime=\"%s\">%s</time> `,\n")
+	b.WriteString("\t\t\ttemplate.HTMLEscapeString(a.Subject), a.Event, a.CreatedAt.Format(time.RFC3339), a.CreatedAt.Format(\"2006-01-02 15:04\"))\n")
+	b.WriteString("\t\tif a.Actor != \"\" {\n")
+	b.WriteString("\t\t\tfmt.Fprintf(w, `<strong>%s</strong> `, template.HTMLEscapeString(a.Actor))\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tfmt.Fprintf(w, `%s</article>`, template.HTMLEscapeString(a.Message))\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif more {\n")
+	b.WriteString("\t\tnext := url.Values{\"page\": {strconv.Itoa(page + 1)}}\n")
+	b.WriteString("\t\tif subject != \"\" {\n")
+	b.WriteString("\t\t\tnext.Set(\"subject\", subject)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString(fmt.Sprintf("\t\tfmt.Fprintf(w, `<button class=\"gmx-feed-more\" hx-get=\"%s?%%s\" hx-swap=\"outerHTML\">Load more</button>`, template.HTMLEscapeString(next.Encode()))\n", feedPath))
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
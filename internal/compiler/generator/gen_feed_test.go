@@ -0,0 +1,97 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+)
+
+const feedScript = `@feedItem("created {task.title} (100%)")
+@feedItem("completed {task.title}", on: update)
+model Task {
+  id: uuid @pk @default(uuid_v4)
+  title: string
+  done: bool
+}
+func createTask(title: string) error {
+  const task = Task{title: title}
+  try task.save()
+  return render(task)
+}`
+
+func TestGenerateActivityFeed(t *testing.T) {
+	file := scriptTestFile(t, notifySession+feedScript, signedTemplateSrc)
+	file.Template.Source = `<aside>{{activityFeed}}</aside>`
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, want := range []string{
+		// Stored by a generated model, migrated with the others
+		"type Activity struct {",
+		"db.AutoMigrate(&Task{}, &Activity{})",
+		"func (t *Task) AfterCreate(tx *gorm.DB) error {",
+		`return recordActivity(tx, "Task", fmt.Sprint(t.ID), "create", fmt.Sprintf("created %v (100%%)", t.Title))`,
+		"func (t *Task) AfterUpdate(tx *gorm.DB) error {",
+		`return recordActivity(tx, "Task", fmt.Sprint(t.ID), "update", fmt.Sprintf("completed %v", t.Title))`,
+		// Activities share the transaction of the change and name the session user
+		"return tx.Session(&gorm.Session{NewDB: true}).Create(activity).Error",
+		"ctx.DB = ctx.DB.Set(activityActorKey, ctx.User).Session(&gorm.Session{})",
+		`"activityFeed": func() template.HTML {`,
+		`mux.HandleFunc("/_gmx/feed", handleActivityFeed)`,
+		`next := url.Values{"page": {strconv.Itoa(page + 1)}}`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+
+	if strings.Contains(code, "AfterDelete") {
+		t.Error("only the events with a @feedItem are recorded")
+	}
+}
+
+func TestGenerateActivityFeedWithoutSession(t *testing.T) {
+	code, err := New().Generate(scriptTestFile(t, feedScript, signedTemplateSrc))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+	if strings.Contains(code, "activityActorKey, ctx.User") || strings.Contains(code, "readSession") {
+		t.Error("without a session service, activities have no actor and the feed is public")
+	}
+}
+
+func TestValidateFeedItems(t *testing.T) {
+	const task = "model Task {\n  id: uuid @pk\n  title: string\n}"
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{"no message", "@feedItem\n" + task, `model Task: @feedItem: a message is required, such as @feedItem("created {task.title}")`},
+		{"unknown field", `@feedItem("created {task.name}")` + "\n" + task, "placeholder {task.name} is not a field of Task, such as {task.title}"},
+		{"other record", `@feedItem("created {user.title}")` + "\n" + task, "placeholder {user.title} is not a field of Task"},
+		{"unclosed", `@feedItem("created {task.title")` + "\n" + task, `unclosed placeholder in "created {task.title"`},
+		{"unknown event", `@feedItem("read {task.title}", on: read)` + "\n" + task, "@feedItem(on: read) is not an event (expected create, update or delete)"},
+		{"duplicate event", "@feedItem(\"a\")\n@feedItem(\"b\", on: create)\n" + task, "model Task: several @feedItem record the create event"},
+		{"no pk", "@feedItem(\"created\")\nmodel Task {\n  title: string\n}", "model Task: @feedItem requires a @pk field"},
+		{"repository", "@feedItem(\"created\")\n@repository(\"TaskRepo\")\n" + task, "model Task: @feedItem is recorded by GORM hooks, which a @repository does not run"},
+		{"model", "@feedItem(\"created\")\n" + task + "\nmodel Activity {\n  id: uuid @pk\n}", "line 6: model Activity collides with the model storing the activity feed"},
+		{"func", "@feedItem(\"created\")\n" + task + "\nfunc activityFeed() error {\n  return nil\n}", "line 6: function activityFeed collides with the built-in /_gmx/feed endpoint"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New().Generate(scriptTestFile(t, tt.src, signedTemplateSrc))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/btouchard/gmx/internal/compiler/ast"
 	"github.com/btouchard/gmx/internal/compiler/utils"
 	"strings"
+	"time"
 )
 
 // genHandlers generates HTTP handlers
@@ -14,57 +15,15 @@ func (g *Generator) genHandlers(file *ast.GMXFile, routes map[string]string) str
 	// Build a set of script function names for quick lookup
 	scriptFuncs := g.scriptFuncNames(file)
 
-	// Generate handleIndex
-	b.WriteString("func handleIndex(w http.ResponseWriter, r *http.Request) {\n")
-
-	// Get or create CSRF token
-	b.WriteString("\t// Get or create CSRF token\n")
-	b.WriteString("\tcsrfToken := \"\"\n")
-	b.WriteString("\tif cookie, err := r.Cookie(\"_csrf\"); err == nil {\n")
-	b.WriteString("\t\tcsrfToken = cookie.Value\n")
-	b.WriteString("\t} else {\n")
-	b.WriteString("\t\tcsrfToken = generateCSRFToken()\n")
-	b.WriteString("\t\thttp.SetCookie(w, &http.Cookie{\n")
-	b.WriteString("\t\t\tName:     \"_csrf\",\n")
-	b.WriteString("\t\t\tValue:    csrfToken,\n")
-	b.WriteString("\t\t\tPath:     \"/\",\n")
-	b.WriteString("\t\t\tHttpOnly: false,\n")
-	b.WriteString("\t\t\tSameSite: http.SameSiteStrictMode,\n")
-	b.WriteString("\t\t\tSecure:   r.TLS != nil,\n")
-	b.WriteString("\t\t})\n")
-	b.WriteString("\t}\n\n")
-
-	if len(file.Models) > 0 {
-		b.WriteString("\tdata := PageData{\n")
-		b.WriteString("\t\tCSRFToken: csrfToken,\n")
-
-		// Fetch data for each model
-		for _, model := range file.Models {
-			b.WriteString(fmt.Sprintf("\t\t// Fetch %s from database\n", model.Name))
-			// For now, just initialize empty slices
-			// In a real implementation, we'd fetch from DB
-			b.WriteString(fmt.Sprintf("\t\t%ss: []%s{},\n", model.Name, model.Name))
-		}
-
-		b.WriteString("\t}\n\n")
-		b.WriteString("\t// Fetch data from database\n")
-		for _, model := range file.Models {
-			b.WriteString(fmt.Sprintf("\tdb.Find(&data.%ss)\n", model.Name))
+	// Generate handleIndex, or one handler per page of a directory build
+	if len(file.Pages) > 0 {
+		for _, page := range file.Pages {
+			b.WriteString(g.genPageHandler(file, pageHandler(page), pageTemplateName(page)))
 		}
-		b.WriteString("\n")
 	} else {
-		b.WriteString("\tdata := PageData{\n")
-		b.WriteString("\t\tCSRFToken: csrfToken,\n")
-		b.WriteString("\t}\n\n")
+		b.WriteString(g.genPageHandler(file, "handleIndex", ""))
 	}
 
-	b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/html; charset=utf-8\")\n")
-	b.WriteString("\tif err := tmpl.Execute(w, data); err != nil {\n")
-	b.WriteString("\t\tlog.Printf(\"template error: %v\", err)\n")
-	b.WriteString("\t\thttp.Error(w, \"Internal Server Error\", http.StatusInternalServerError)\n")
-	b.WriteString("\t}\n")
-	b.WriteString("}\n\n")
-
 	// Generate stub handlers for each route ONLY if there's NO matching script function
 	for routeName := range routes {
 		// Skip if there's a script function with the same name
@@ -114,19 +73,115 @@ func (g *Generator) genHandlers(file *ast.GMXFile, routes map[string]string) str
 	return b.String()
 }
 
+// genPageHandler generates a handler rendering the page data with the named
+// template, or with the whole page template when tmplName is empty
+func (g *Generator) genPageHandler(file *ast.GMXFile, name, tmplName string) string {
+	var b strings.Builder
+
+	b.WriteString(fmt.Sprintf("func %s(w http.ResponseWriter, r *http.Request) {\n", name))
+
+	// "/" matches every path: other pages of a directory build are not found
+	if tmplName != "" && name == "handleIndex" {
+		b.WriteString("\tif r.URL.Path != \"/\" {\n")
+		b.WriteString("\t\thttp.NotFound(w, r)\n")
+		b.WriteString("\t\treturn\n")
+		b.WriteString("\t}\n\n")
+	}
+
+	// Get or create CSRF token
+	b.WriteString("\t// Get or create CSRF token\n")
+	b.WriteString("\tcsrfToken := \"\"\n")
+	b.WriteString("\tif cookie, err := r.Cookie(\"_csrf\"); err == nil {\n")
+	b.WriteString("\t\tcsrfToken = cookie.Value\n")
+	b.WriteString("\t} else {\n")
+	b.WriteString("\t\tcsrfToken = generateCSRFToken()\n")
+	b.WriteString("\t\thttp.SetCookie(w, &http.Cookie{\n")
+	b.WriteString("\t\t\tName:     \"_csrf\",\n")
+	b.WriteString("\t\t\tValue:    csrfToken,\n")
+	b.WriteString("\t\t\tPath:     \"/\",\n")
+	b.WriteString("\t\t\tHttpOnly: false,\n")
+	b.WriteString("\t\t\tSameSite: http.SameSiteStrictMode,\n")
+	b.WriteString("\t\t\tSecure:   r.TLS != nil,\n")
+	b.WriteString("\t\t})\n")
+	b.WriteString("\t}\n\n")
+
+	if len(file.Models) > 0 {
+		if g.hasRowLevelSecurity(file) {
+			b.WriteString("\t// The request's connection carries the row-level security settings\n")
+			b.WriteString("\tdb := requestDB(r)\n\n")
+		}
+		b.WriteString("\tdata := PageData{\n")
+		b.WriteString("\t\tCSRFToken: csrfToken,\n")
+
+		// Fetch data for each model
+		for _, model := range file.Models {
+			b.WriteString(fmt.Sprintf("\t\t// Fetch %s from database\n", model.Name))
+			// For now, just initialize empty slices
+			// In a real implementation, we'd fetch from DB
+			b.WriteString(fmt.Sprintf("\t\t%ss: []%s{},\n", model.Name, model.Name))
+		}
+
+		b.WriteString("\t}\n\n")
+		b.WriteString("\t// Fetch data from database\n")
+		credentials := g.credentialModels(file)
+		for _, model := range file.Models {
+			if credentials[model.Name] {
+				continue
+			}
+			db := preloadDB(model)
+			if model.FindAnnotation("repository") != nil {
+				b.WriteString(fmt.Sprintf("\tif objs, err := %sRepository.All(%s); err != nil {\n", utils.LowerFirst(model.Name), db))
+				b.WriteString(fmt.Sprintf("\t\trequestLogger(r).Error(\"loading records\", \"model\", %q, \"error\", err)\n", model.Name))
+				b.WriteString("\t} else {\n")
+				b.WriteString(fmt.Sprintf("\t\tdata.%ss = objs\n", model.Name))
+				b.WriteString("\t}\n")
+				continue
+			}
+			b.WriteString(fmt.Sprintf("\t%s.Find(&data.%ss)\n", db, model.Name))
+		}
+		b.WriteString("\n")
+		b.WriteString(g.genPolicyPageFilter(file))
+	} else {
+		b.WriteString("\tdata := PageData{\n")
+		b.WriteString("\t\tCSRFToken: csrfToken,\n")
+		b.WriteString("\t}\n\n")
+	}
+
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/html; charset=utf-8\")\n")
+	if tmplName == "" {
+		b.WriteString("\tif err := tmpl.Execute(w, data); err != nil {\n")
+	} else {
+		b.WriteString(fmt.Sprintf("\tif err := tmpl.ExecuteTemplate(w, %q, data); err != nil {\n", tmplName))
+	}
+	b.WriteString("\t\trequestLogger(r).Error(\"template error\", \"error\", err)\n")
+	b.WriteString("\t\thttp.Error(w, \"Internal Server Error\", http.StatusInternalServerError)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
 // genScriptHandlers generates HTTP handler wrappers for transpiled script functions
-func (g *Generator) genScriptHandlers(scriptBlock *ast.ScriptBlock) string {
+func (g *Generator) genScriptHandlers(file *ast.GMXFile) string {
 	var b strings.Builder
 
-	for _, fn := range scriptBlock.Funcs {
+	hasSession := g.findSessionService(file.Services) != nil
+	hasPolicies := g.hasPolicies(file)
+	hasRLS := g.hasRowLevelSecurity(file)
+	hasTracing := g.hasTracing(file)
+
+	for _, fn := range file.Script.Funcs {
 		// Only generate HTTP handlers for functions that return error (handlers)
-		// Functions with other return types are utility functions, not handlers
-		if fn.ReturnType != "" && fn.ReturnType != "error" {
+		// Functions with other return types are utility functions, not handlers,
+		// and @job functions run on the job queue
+		if !isHandler(fn) {
 			continue
 		}
 
 		handlerName := "handle" + utils.Capitalize(fn.Name)
-		expectedMethod := inferHTTPMethod(fn.Name)
+		expectedMethod := handlerMethod(fn)
+		jsonAPI := fn.FindAnnotation("json") != nil
+		handlerStart := b.Len()
 
 		b.WriteString(fmt.Sprintf("func %s(w http.ResponseWriter, r *http.Request) {\n", handlerName))
 
@@ -137,15 +192,118 @@ func (g *Generator) genScriptHandlers(scriptBlock *ast.ScriptBlock) string {
 		b.WriteString("\t\treturn\n")
 		b.WriteString("\t}\n\n")
 
+		// Links to @signed functions replace CSRF tokens with their signature
+		signed := fn.FindAnnotation("signed") != nil
+		if signed {
+			b.WriteString(genSignedRouteCheck(fn))
+		}
+
+		// Honeypot and time-to-submit checks are cheaper than a captcha round-trip
+		if fn.FindAnnotation("honeypot") != nil {
+			delay, _ := honeypotDelay(fn) // validated in validateFuncAnnotations
+			b.WriteString("\t// Spam heuristics\n")
+			b.WriteString(fmt.Sprintf("\tif err := checkHoneypot(r, %d*time.Second); err != nil {\n", delay))
+			b.WriteString("\t\trequestLogger(r).Warn(\"honeypot rejected\", \"error\", err)\n")
+			b.WriteString("\t\thttp.Error(w, \"Bad Request\", http.StatusBadRequest)\n")
+			b.WriteString("\t\treturn\n")
+			b.WriteString("\t}\n\n")
+		}
+
+		// Captcha verification runs before any business logic
+		if provider := captchaProviderName(fn); provider != "" {
+			b.WriteString("\t// Captcha verification\n")
+			b.WriteString(fmt.Sprintf("\tif err := verifyCaptcha(r, %q); err != nil {\n", provider))
+			b.WriteString("\t\trequestLogger(r).Warn(\"captcha rejected\", \"error\", err)\n")
+			b.WriteString("\t\thttp.Error(w, \"Captcha verification failed\", http.StatusForbidden)\n")
+			b.WriteString("\t\treturn\n")
+			b.WriteString("\t}\n\n")
+		}
+
+		timeout, _ := handlerTimeout(fn) // validated in validateFuncAnnotations
+		if timeout > 0 {
+			b.WriteString(genHandlerDeadline(timeout))
+		}
+
+		if hasRLS {
+			b.WriteString("\t// The request's connection carries the row-level security settings\n")
+			b.WriteString("\tdb := requestDB(r)\n")
+		}
+		// @require2fa reads whether the session entered its second factor
+		require2fa := fn.FindAnnotation("require2fa") != nil
+		if require2fa {
+			b.WriteString("\treqSession := readSession(r)\n")
+		}
 		b.WriteString("\tctx := &GMXContext{\n")
-		b.WriteString("\t\tDB:      db,\n")
+		// Queries carry the deadline of the handler and its span
+		if timeout > 0 || hasTracing {
+			b.WriteString("\t\tDB:      db.WithContext(r.Context()),\n")
+		} else {
+			b.WriteString("\t\tDB:      db,\n")
+		}
 		b.WriteString("\t\tWriter:  w,\n")
 		b.WriteString("\t\tRequest: r,\n")
+		b.WriteString("\t\tLog:     requestLogger(r),\n")
+		if hasRLS {
+			b.WriteString("\t\tTenant:  requestTenant(r),\n")
+		}
+		if require2fa {
+			b.WriteString("\t\tUser:    reqSession.User,\n")
+		} else if hasSession {
+			b.WriteString("\t\tUser:    readSession(r).User,\n")
+		}
 		b.WriteString("\t}\n\n")
+		if hasSession && g.hasActivityFeed(file) {
+			b.WriteString("\t// Activities recorded by the model hooks name the session user\n")
+			b.WriteString("\tctx.DB = ctx.DB.Set(activityActorKey, ctx.User).Session(&gorm.Session{})\n\n")
+		}
+
+		// Access control from @auth, @role and @require2fa, declared on the function or its route group
+		if fn.FindAnnotation("auth") != nil || fn.FindAnnotation("role") != nil || require2fa {
+			b.WriteString("\t// Access control\n")
+			b.WriteString("\tif ctx.User == \"\" {\n")
+			b.WriteString("\t\thttp.Error(w, \"Unauthorized\", http.StatusUnauthorized)\n")
+			b.WriteString("\t\treturn\n")
+			b.WriteString("\t}\n")
+			if fn.FindAnnotation("role") != nil {
+				b.WriteString("\tif !sessionAdmins[ctx.User] {\n")
+				b.WriteString("\t\thttp.Error(w, \"Forbidden\", http.StatusForbidden)\n")
+				b.WriteString("\t\treturn\n")
+				b.WriteString("\t}\n")
+			}
+			if require2fa {
+				b.WriteString("\tif !reqSession.Verified {\n")
+				b.WriteString("\t\thttp.Error(w, \"Two-factor authentication required\", http.StatusForbidden)\n")
+				b.WriteString("\t\treturn\n")
+				b.WriteString("\t}\n")
+			}
+			b.WriteString("\n")
+		}
+
+		// Signed parameters are read from the verified query string only
+		if len(fn.Params) > 0 && !signed {
+			b.WriteString(genRequestBodyParse())
+		}
 
 		// Extract parameters from request
 		for _, param := range fn.Params {
 			b.WriteString(fmt.Sprintf("\t// Extract parameter: %s\n", param.Name))
+
+			// Lists collect every value of a repeated form field and may be empty
+			if param.Type == "string[]" {
+				b.WriteString("\tif err := r.ParseForm(); err != nil {\n")
+				b.WriteString("\t\thttp.Error(w, \"Invalid form data\", http.StatusBadRequest)\n")
+				b.WriteString("\t\treturn\n")
+				b.WriteString("\t}\n")
+				b.WriteString(fmt.Sprintf("\t%s := StringList(r.Form[%q])\n", param.Name, param.Name))
+				continue
+			}
+
+			// Binary payloads are streamed from the uploaded file of the same name
+			if param.Type == "bytes" {
+				b.WriteString(genBlobParam(param.Name))
+				continue
+			}
+
 			b.WriteString(fmt.Sprintf("\t%s := r.PathValue(%q)\n", param.Name, param.Name))
 			b.WriteString(fmt.Sprintf("\tif %s == \"\" {\n", param.Name))
 			b.WriteString(fmt.Sprintf("\t\t%s = r.FormValue(%q)\n", param.Name, param.Name))
@@ -170,6 +328,12 @@ func (g *Generator) genScriptHandlers(scriptBlock *ast.ScriptBlock) string {
 				b.WriteString("\t\thttp.Error(w, \"Invalid integer parameter\", http.StatusBadRequest)\n")
 				b.WriteString("\t\treturn\n")
 				b.WriteString("\t}\n")
+			case "money":
+				b.WriteString(fmt.Sprintf("\t%sMoney, err := parseMoney(%s)\n", param.Name, param.Name))
+				b.WriteString("\tif err != nil {\n")
+				b.WriteString("\t\thttp.Error(w, \"Invalid amount parameter\", http.StatusBadRequest)\n")
+				b.WriteString("\t\treturn\n")
+				b.WriteString("\t}\n")
 			case "bool":
 				b.WriteString(fmt.Sprintf("\t%sBool, err := strconv.ParseBool(%s)\n", param.Name, param.Name))
 				b.WriteString("\tif err != nil {\n")
@@ -181,24 +345,122 @@ func (g *Generator) genScriptHandlers(scriptBlock *ast.ScriptBlock) string {
 		b.WriteString("\n")
 
 		// Call the business logic function
-		b.WriteString(fmt.Sprintf("\tif err := %s(ctx", fn.Name))
+		var call strings.Builder
+		call.WriteString(fn.Name + "(ctx")
 		for _, param := range fn.Params {
 			if param.Type == "int" {
-				b.WriteString(fmt.Sprintf(", %sInt", param.Name))
+				call.WriteString(fmt.Sprintf(", %sInt", param.Name))
 			} else if param.Type == "bool" {
-				b.WriteString(fmt.Sprintf(", %sBool", param.Name))
+				call.WriteString(fmt.Sprintf(", %sBool", param.Name))
+			} else if param.Type == "money" {
+				call.WriteString(fmt.Sprintf(", %sMoney", param.Name))
 			} else {
-				b.WriteString(fmt.Sprintf(", %s", param.Name))
+				call.WriteString(fmt.Sprintf(", %s", param.Name))
 			}
 		}
-		b.WriteString("); err != nil {\n")
-		b.WriteString("\t\tlog.Printf(\"handler error: %v\", err)\n")
-		b.WriteString("\t\thttp.Error(w, \"Internal Server Error\", http.StatusInternalServerError)\n")
-		b.WriteString("\t\treturn\n")
-		b.WriteString("\t}\n")
+		call.WriteString(")")
+		callStr := call.String()
+
+		// Handlers publishing events commit them with their writes
+		if g.publishesInHandler(file, fn) {
+			callStr = outboxTransaction(callStr)
+		}
+
+		login := fn.FindAnnotation("login") != nil
+		if login {
+			b.WriteString(genLoginCheck(fn))
+		}
+
+		once := fn.FindAnnotation("once") != nil
+		if once {
+			b.WriteString(genOnceClaim())
+		}
+
+		// @async functions run in the background, followed by their job fragment
+		if fn.FindAnnotation("async") != nil {
+			b.WriteString(genJobStart(fn, callStr))
+		} else {
+			b.WriteString(fmt.Sprintf("\tif err := %s; err != nil {\n", callStr))
+			if once {
+				b.WriteString("\t\treleaseSubmission(submission)\n")
+			}
+			if login {
+				b.WriteString("\t\tloginFailed(r, loginAccount)\n")
+			}
+			// JSON clients get the status of the failure with its message
+			if jsonAPI || fn.FindAnnotation("negotiate") != nil {
+				b.WriteString(genJSONErrorAnswer(jsonAPI))
+			}
+			if jsonAPI {
+				b.WriteString("\t}\n")
+			} else {
+				b.WriteString(genErrorAnswer(fn, timeout, hasPolicies))
+			}
+		}
+		if login {
+			b.WriteString("\n\t// A successful sign-in forgets the failures of its account\n")
+			b.WriteString("\tloginAccounts.reset(loginAccount)\n")
+		}
+		if fn.FindAnnotation("autosave") != nil {
+			b.WriteString("\n\t// The form is submitted: its draft is no longer needed\n")
+			b.WriteString(fmt.Sprintf("\tdropAutosave(r, %q)\n", fn.Name))
+		}
 		b.WriteString("}\n\n")
+
+		// Every error of a @json handler, from its parameters to its access control, is JSON
+		if jsonAPI {
+			handler := strings.ReplaceAll(b.String()[handlerStart:], "http.Error(w, ", "jsonError(w, ")
+			generated := b.String()[:handlerStart]
+			b.Reset()
+			b.WriteString(generated)
+			b.WriteString(handler)
+		}
+	}
+
+	return b.String()
+}
+
+// genJSONErrorAnswer generates the answer of a handler error to JSON clients:
+// all clients of @json functions, those asking for JSON for @negotiate ones
+func genJSONErrorAnswer(always bool) string {
+	if always {
+		return "\t\twriteJSONError(w, r, err)\n\t\treturn\n"
 	}
+	var b strings.Builder
+	b.WriteString("\t\tif negotiateJSON(w, r) {\n")
+	b.WriteString("\t\t\twriteJSONError(w, r, err)\n")
+	b.WriteString("\t\t\treturn\n")
+	b.WriteString("\t\t}\n")
+	return b.String()
+}
 
+// genErrorAnswer generates the answer of a handler error to browsers: the
+// validation error fragment, or the status of the failure
+func genErrorAnswer(fn *ast.FuncDecl, timeout time.Duration, hasPolicies bool) string {
+	var b strings.Builder
+	if timeout > 0 {
+		b.WriteString("\t\tif errors.Is(r.Context().Err(), context.DeadlineExceeded) {\n")
+		b.WriteString(fmt.Sprintf("\t\t\trequestLogger(r).Error(\"handler timed out\", \"handler\", %q, \"timeout\", %q, \"error\", err)\n", fn.Name, timeout.String()))
+		b.WriteString("\t\t\thttp.Error(w, \"Service Unavailable\", http.StatusServiceUnavailable)\n")
+		b.WriteString("\t\t\treturn\n")
+		b.WriteString("\t\t}\n")
+	}
+	if hasPolicies {
+		b.WriteString("\t\tif errors.Is(err, errForbidden) {\n")
+		b.WriteString("\t\t\thttp.Error(w, \"Forbidden\", http.StatusForbidden)\n")
+		b.WriteString("\t\t\treturn\n")
+		b.WriteString("\t\t}\n")
+	}
+	// Validation errors are shown next to the form field, others are logged with the request ID
+	b.WriteString("\t\tvar verr *ValidationError\n")
+	b.WriteString("\t\tif errors.As(err, &verr) {\n")
+	b.WriteString("\t\t\trenderValidationError(w, verr)\n")
+	b.WriteString("\t\t\treturn\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString(fmt.Sprintf("\t\trequestLogger(r).Error(\"handler error\", \"handler\", %q, \"error\", err)\n", fn.Name))
+	b.WriteString("\t\thttp.Error(w, \"Internal Server Error\", http.StatusInternalServerError)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
 	return b.String()
 }
 
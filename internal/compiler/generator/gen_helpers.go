@@ -1,10 +1,37 @@
 package generator
 
 import (
+	"fmt"
 	"github.com/btouchard/gmx/internal/compiler/ast"
+	"sort"
+	"strconv"
 	"strings"
 )
 
+// defaultHoneypotDelay is the minimum time-to-submit, in seconds, enforced by a bare @honeypot
+const defaultHoneypotDelay = 2
+
+// captchaProvider describes how to verify a token against a captcha provider
+type captchaProvider struct {
+	VerifyURL string // server-side verification endpoint
+	Field     string // form field carrying the widget token
+	SecretEnv string // env var holding the secret key
+}
+
+// captchaProviders lists the providers supported by @captcha(...)
+var captchaProviders = map[string]captchaProvider{
+	"turnstile": {
+		VerifyURL: "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+		Field:     "cf-turnstile-response",
+		SecretEnv: "TURNSTILE_SECRET_KEY",
+	},
+	"hcaptcha": {
+		VerifyURL: "https://api.hcaptcha.com/siteverify",
+		Field:     "h-captcha-response",
+		SecretEnv: "HCAPTCHA_SECRET_KEY",
+	},
+}
+
 // genHelpers generates shared helper functions needed by the models
 func (g *Generator) genHelpers(file *ast.GMXFile) string {
 	var b strings.Builder
@@ -49,6 +76,39 @@ func (g *Generator) genHelpers(file *ast.GMXFile) string {
 		b.WriteString("}\n\n")
 	}
 
+	// Validate() and script error(...) return validation errors
+	if len(file.Models) > 0 || file.Script != nil {
+		b.WriteString(g.genValidationError())
+	}
+
+	if g.hasJSONField(file) {
+		b.WriteString(g.genJSONHelpers())
+	}
+
+	if g.needsStringList(file) {
+		b.WriteString(g.genListHelpers())
+	}
+
+	if g.needsBlob(file) {
+		b.WriteString(g.genBlobHelpers(file))
+	}
+
+	if g.needsMoney(file) {
+		b.WriteString(g.genMoneyHelpers())
+	}
+
+	if g.hasPasswordField(file) {
+		b.WriteString("// checkPassword compares a bcrypt hash with a plaintext candidate in constant time\n")
+		b.WriteString("func checkPassword(hash, plain string) bool {\n")
+		b.WriteString("\treturn bcrypt.CompareHashAndPassword([]byte(hash), []byte(plain)) == nil\n")
+		b.WriteString("}\n\n")
+
+		b.WriteString("// secureCompare compares two secrets in constant time\n")
+		b.WriteString("func secureCompare(a, b string) bool {\n")
+		b.WriteString("\treturn subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1\n")
+		b.WriteString("}\n\n")
+	}
+
 	// UUID validation helper (for security)
 	if needsUUIDValidation {
 		b.WriteString("// isValidUUID checks if a string is a valid UUID v4 format\n")
@@ -71,6 +131,35 @@ func (g *Generator) genHelpers(file *ast.GMXFile) string {
 		b.WriteString("}\n\n")
 	}
 
+	if sessionSvc := g.findSessionService(file.Services); sessionSvc != nil {
+		b.WriteString(g.genSessionHelpers(sessionSvc, g.hasImpersonation(file), g.hasAccounts(file), g.genHoldDB(file, "\t")))
+	}
+
+	if g.hasFuncAnnotation(file, "honeypot") {
+		b.WriteString(g.genHoneypotHelpers(file.Template != nil))
+	}
+
+	if g.hasFuncAnnotation(file, "once") {
+		b.WriteString(g.genOnceHelpers(file.Template != nil))
+	}
+
+	if captchaFuncs := g.funcsWithAnnotation(file, "captcha"); len(captchaFuncs) > 0 {
+		b.WriteString(g.genCaptchaHelpers(captchaFuncs))
+	}
+
+	if g.hasJSONResponses(file) {
+		b.WriteString(g.genNegotiationHelpers())
+		b.WriteString(g.genJSONErrorHelpers(g.hasPolicies(file), len(file.Models) > 0))
+	}
+
+	if g.needsRequestBody(file) {
+		b.WriteString(g.genRequestBodyHelpers())
+	}
+
+	if g.hasFuncAnnotation(file, "signed") {
+		b.WriteString(g.genSignedRouteHelpers(file))
+	}
+
 	// CSRF token generation (always included for security)
 	b.WriteString("// generateCSRFToken generates a cryptographically secure random token\n")
 	b.WriteString("func generateCSRFToken() string {\n")
@@ -132,3 +221,233 @@ func (g *Generator) genHelpers(file *ast.GMXFile) string {
 
 	return b.String()
 }
+
+// hasJSONResponses checks if script functions answer JSON, always (@json) or
+// to the clients asking for it (@negotiate)
+func (g *Generator) hasJSONResponses(file *ast.GMXFile) bool {
+	return g.hasFuncAnnotation(file, "negotiate") || g.hasFuncAnnotation(file, "json")
+}
+
+// genNegotiationHelpers generates the Accept-header negotiation of @negotiate
+// functions: JSON for API clients, the HTML fragment for browsers and HTMX
+func (g *Generator) genNegotiationHelpers() string {
+	var b strings.Builder
+
+	b.WriteString("// negotiateJSON reports whether the client prefers JSON to an HTML fragment:\n")
+	b.WriteString("// HTMX requests always get HTML, others the first of text/html or application/json\n")
+	b.WriteString("// listed in their Accept header\n")
+	b.WriteString("func negotiateJSON(w http.ResponseWriter, r *http.Request) bool {\n")
+	b.WriteString("\tw.Header().Add(\"Vary\", \"Accept\")\n")
+	b.WriteString("\tif r.Header.Get(\"HX-Request\") == \"true\" {\n")
+	b.WriteString("\t\treturn false\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tfor _, part := range strings.Split(r.Header.Get(\"Accept\"), \",\") {\n")
+	b.WriteString("\t\tmediaType, _, _ := strings.Cut(part, \";\")\n")
+	b.WriteString("\t\tswitch strings.TrimSpace(mediaType) {\n")
+	b.WriteString("\t\tcase \"application/json\":\n")
+	b.WriteString("\t\t\treturn true\n")
+	b.WriteString("\t\tcase \"text/html\":\n")
+	b.WriteString("\t\t\treturn false\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn false\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// writeJSON answers data as a JSON document\n")
+	b.WriteString("func writeJSON(w http.ResponseWriter, data interface{}) error {\n")
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"application/json\")\n")
+	b.WriteString("\treturn json.NewEncoder(w).Encode(data)\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// genJSONErrorHelpers generates the errors answered to JSON clients, with the
+// status of the failure instead of the HTML fragment of a validation error
+func (g *Generator) genJSONErrorHelpers(withPolicies, withModels bool) string {
+	var b strings.Builder
+
+	b.WriteString("// jsonError answers an error message as a JSON document: {\"error\": message}\n")
+	b.WriteString("func jsonError(w http.ResponseWriter, message string, status int) {\n")
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"application/json\")\n")
+	b.WriteString("\tw.Header().Set(\"X-Content-Type-Options\", \"nosniff\")\n")
+	b.WriteString("\tw.WriteHeader(status)\n")
+	b.WriteString("\tjson.NewEncoder(w).Encode(map[string]string{\"error\": message})\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// writeJSONError answers the error of a handler as JSON: 422 with the field of a\n")
+	b.WriteString("// validation error")
+	if withPolicies {
+		b.WriteString(", 403 for a policy denial")
+	}
+	if withModels {
+		b.WriteString(", 404 for a missing record")
+	}
+	b.WriteString(",\n")
+	b.WriteString("// 503 past the deadline of the request and 500 otherwise, both logged with the request ID\n")
+	b.WriteString("func writeJSONError(w http.ResponseWriter, r *http.Request, err error) {\n")
+	b.WriteString("\tvar verr *ValidationError\n")
+	b.WriteString("\tswitch {\n")
+	b.WriteString("\tcase errors.As(err, &verr):\n")
+	b.WriteString("\t\tbody := map[string]string{\"error\": verr.Message}\n")
+	b.WriteString("\t\tif verr.Field != \"\" {\n")
+	b.WriteString("\t\t\tbody[\"field\"] = verr.Field\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tw.Header().Set(\"Content-Type\", \"application/json\")\n")
+	b.WriteString("\t\tw.WriteHeader(http.StatusUnprocessableEntity)\n")
+	b.WriteString("\t\tjson.NewEncoder(w).Encode(body)\n")
+	if withPolicies {
+		b.WriteString("\tcase errors.Is(err, errForbidden):\n")
+		b.WriteString("\t\tjsonError(w, \"Forbidden\", http.StatusForbidden)\n")
+	}
+	if withModels {
+		b.WriteString("\tcase errors.Is(err, gorm.ErrRecordNotFound):\n")
+		b.WriteString("\t\tjsonError(w, \"Not Found\", http.StatusNotFound)\n")
+	}
+	b.WriteString("\tcase errors.Is(err, context.DeadlineExceeded):\n")
+	b.WriteString("\t\trequestLogger(r).Error(\"handler timed out\", \"error\", err)\n")
+	b.WriteString("\t\tjsonError(w, \"Service Unavailable\", http.StatusServiceUnavailable)\n")
+	b.WriteString("\tdefault:\n")
+	b.WriteString("\t\trequestLogger(r).Error(\"handler error\", \"error\", err)\n")
+	b.WriteString("\t\tjsonError(w, \"Internal Server Error\", http.StatusInternalServerError)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// captchaProviderName returns the provider named in a @captcha annotation
+func captchaProviderName(fn *ast.FuncDecl) string {
+	ann := fn.FindAnnotation("captcha")
+	if ann == nil {
+		return ""
+	}
+	return strings.Trim(ann.SimpleArg(), "\"")
+}
+
+// genCaptchaHelpers generates the verifyCaptcha helper for the providers used by @captcha functions
+func (g *Generator) genCaptchaHelpers(funcs []*ast.FuncDecl) string {
+	var b strings.Builder
+
+	used := make(map[string]bool)
+	for _, fn := range funcs {
+		used[captchaProviderName(fn)] = true
+	}
+	var names []string
+	for name := range used {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	b.WriteString("// captchaProviders maps provider names to verification endpoint, token field and secret env var\n")
+	b.WriteString("var captchaProviders = map[string]struct{ verifyURL, field, secretEnv string }{\n")
+	for _, name := range names {
+		p := captchaProviders[name]
+		b.WriteString(fmt.Sprintf("\t%q: {%q, %q, %q},\n", name, p.VerifyURL, p.Field, p.SecretEnv))
+	}
+	b.WriteString("}\n\n")
+
+	b.WriteString("// verifyCaptcha checks the captcha token submitted with the request against the provider API\n")
+	b.WriteString("func verifyCaptcha(r *http.Request, provider string) error {\n")
+	b.WriteString("\tcfg, ok := captchaProviders[provider]\n")
+	b.WriteString("\tif !ok {\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"unsupported captcha provider: %s\", provider)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tsecret := os.Getenv(cfg.secretEnv)\n")
+	b.WriteString("\tif secret == \"\" {\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"missing captcha secret: %s\", cfg.secretEnv)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\ttoken := r.FormValue(cfg.field)\n")
+	b.WriteString("\tif token == \"\" {\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"missing captcha token\")\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\tclient := &http.Client{Timeout: 10 * time.Second}\n")
+	b.WriteString("\tresp, err := client.PostForm(cfg.verifyURL, url.Values{\"secret\": {secret}, \"response\": {token}})\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"captcha verification request: %w\", err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tdefer resp.Body.Close()\n\n")
+	b.WriteString("\tvar result struct {\n")
+	b.WriteString("\t\tSuccess bool `json:\"success\"`\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif err := json.NewDecoder(resp.Body).Decode(&result); err != nil {\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"decoding captcha response: %w\", err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif !result.Success {\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"captcha token rejected\")\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn nil\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// honeypotDelay returns the minimum time-to-submit, in seconds, configured by @honeypot(min: N)
+func honeypotDelay(fn *ast.FuncDecl) (int, error) {
+	ann := fn.FindAnnotation("honeypot")
+	if ann == nil {
+		return 0, nil
+	}
+	raw, ok := ann.Args["min"]
+	if !ok {
+		return defaultHoneypotDelay, nil
+	}
+	delay, err := strconv.Atoi(raw)
+	if err != nil || delay < 0 {
+		return 0, fmt.Errorf("invalid honeypot delay %q (expected a number of seconds)", raw)
+	}
+	return delay, nil
+}
+
+// genHoneypotHelpers generates the signed form timestamp and trap field checks used by @honeypot
+func (g *Generator) genHoneypotHelpers(withTemplate bool) string {
+	var b strings.Builder
+
+	b.WriteString("// honeypotMaxAge is how long a form timestamp is accepted after its render\n")
+	b.WriteString("const honeypotMaxAge = 24 * time.Hour\n\n")
+
+	b.WriteString("// honeypotSign returns the HMAC signature of a form timestamp; the prefix\n")
+	b.WriteString("// keeps it apart from the other signatures made with the session secret\n")
+	b.WriteString("func honeypotSign(ts string) string {\n")
+	b.WriteString("\tmac := hmac.New(sha256.New, sessionSecret)\n")
+	b.WriteString("\tmac.Write([]byte(\"honeypot\\n\" + ts))\n")
+	b.WriteString("\treturn hex.EncodeToString(mac.Sum(nil))\n")
+	b.WriteString("}\n\n")
+
+	if withTemplate {
+		b.WriteString("// honeypotField renders the hidden trap input and the signed render timestamp\n")
+		b.WriteString("func honeypotField() template.HTML {\n")
+		b.WriteString("\tts := strconv.FormatInt(time.Now().Unix(), 10)\n")
+		b.WriteString("\treturn template.HTML(`<div style=\"position:absolute;left:-10000px\" aria-hidden=\"true\">` +\n")
+		b.WriteString("\t\t`<input type=\"text\" name=\"gmx_website\" tabindex=\"-1\" autocomplete=\"off\"></div>` +\n")
+		b.WriteString("\t\t`<input type=\"hidden\" name=\"gmx_ts\" value=\"` + ts + `\">` +\n")
+		b.WriteString("\t\t`<input type=\"hidden\" name=\"gmx_sig\" value=\"` + honeypotSign(ts) + `\">`)\n")
+		b.WriteString("}\n\n")
+	}
+
+	b.WriteString("// checkHoneypot rejects submissions that fill the trap field, arrive faster\n")
+	b.WriteString("// than minDelay or replay a form rendered more than honeypotMaxAge ago\n")
+	b.WriteString("func checkHoneypot(r *http.Request, minDelay time.Duration) error {\n")
+	b.WriteString("\tif r.FormValue(\"gmx_website\") != \"\" {\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"honeypot field filled\")\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tts := r.FormValue(\"gmx_ts\")\n")
+	b.WriteString("\tif !hmac.Equal([]byte(r.FormValue(\"gmx_sig\")), []byte(honeypotSign(ts))) {\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"invalid form timestamp\")\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tissued, err := strconv.ParseInt(ts, 10, 64)\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"invalid form timestamp: %w\", err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\telapsed := time.Since(time.Unix(issued, 0))\n")
+	b.WriteString("\tif elapsed < minDelay {\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"form submitted too fast (%s)\", elapsed)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif elapsed > honeypotMaxAge {\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"form timestamp expired (%s)\", elapsed)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn nil\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
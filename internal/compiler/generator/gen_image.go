@@ -0,0 +1,508 @@
+package generator
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// imagePathPrefix is the prefix of the built-in endpoints serving image fields
+const imagePathPrefix = "/_gmx/image/"
+
+// defaultImageSizes are the variants of an image field without @sizes
+var defaultImageSizes = []imageSize{{Name: "thumb", Width: 200}}
+
+// maxImageWidth bounds the width of an image variant, in pixels
+const maxImageWidth = 4096
+
+// imageSize is a variant of an image field: @sizes(thumb: 160)
+type imageSize struct {
+	Name  string
+	Width int
+}
+
+// isImageField reports whether a field holds an uploaded image (photo: image)
+func isImageField(field *ast.FieldDecl) bool {
+	return field.Type == "image"
+}
+
+// hasImages checks if a model declares an image field
+func (g *Generator) hasImages(file *ast.GMXFile) bool {
+	return g.hasFieldMatch(file, isImageField)
+}
+
+// imageSizes returns the variants of an image field, narrowest first
+func imageSizes(field *ast.FieldDecl) ([]imageSize, error) {
+	ann := field.FindAnnotation("sizes")
+	if ann == nil {
+		return defaultImageSizes, nil
+	}
+	var sizes []imageSize
+	for name, raw := range ann.Args {
+		width, err := strconv.Atoi(raw)
+		if name == "_" || err != nil || width < 1 || width > maxImageWidth {
+			return nil, fmt.Errorf("@sizes expects named widths in pixels, up to %d, such as @sizes(thumb: 160, medium: 640)", maxImageWidth)
+		}
+		sizes = append(sizes, imageSize{Name: name, Width: width})
+	}
+	if len(sizes) == 0 {
+		return nil, fmt.Errorf("@sizes requires at least one size, such as @sizes(thumb: 160)")
+	}
+	sort.Slice(sizes, func(i, j int) bool {
+		if sizes[i].Width != sizes[j].Width {
+			return sizes[i].Width < sizes[j].Width
+		}
+		return sizes[i].Name < sizes[j].Name
+	})
+	return sizes, nil
+}
+
+// imageDigestField returns the name of the field storing the digest of the
+// image whose variants were queued
+func imageDigestField(field *ast.FieldDecl) string {
+	return field.Name + "Digest"
+}
+
+// imageResizeJob returns the name of the job writing the variants of an image field
+func imageResizeJob(model *ast.ModelDecl, field *ast.FieldDecl) string {
+	return "resize" + model.Name + utils.ToPascalCase(field.Name)
+}
+
+// imageStorageService returns the storage service the image variants are
+// written through: the first s3 or local service declaring upload and download
+func (g *Generator) imageStorageService(file *ast.GMXFile) *ast.ServiceDecl {
+	for _, svc := range file.Services {
+		if isStorageService(svc) && providerDeclares(svc, "upload") && providerDeclares(svc, "download") {
+			return svc
+		}
+	}
+	return nil
+}
+
+// imageStorageAssign returns the statement of main handing the storage
+// service to the image variants, if any
+func (g *Generator) imageStorageAssign(file *ast.GMXFile) string {
+	svc := g.imageStorageService(file)
+	if svc == nil || !g.hasImages(file) {
+		return ""
+	}
+	return fmt.Sprintf("\timageStorage = %sSvc\n", utils.LowerFirst(svc.Name))
+}
+
+// imageHandler returns the name of the handler serving an image field
+func imageHandler(model *ast.ModelDecl, field *ast.FieldDecl) string {
+	return "handle" + model.Name + utils.Capitalize(field.Name) + "Image"
+}
+
+// imageRoutes returns the endpoints serving the image fields and their variants
+func imageRoutes(file *ast.GMXFile) []Route {
+	var routes []Route
+	for _, model := range file.Models {
+		for _, field := range model.Fields {
+			if isImageField(field) {
+				routes = append(routes, Route{
+					Method:  "GET",
+					Path:    imagePathPrefix + model.Name + "/" + field.Name + "/{id}",
+					Handler: imageHandler(model, field),
+				})
+			}
+		}
+	}
+	return routes
+}
+
+// validateImageFields checks the sizes of the image fields, the storage
+// service of their variants and the names of the fields, methods, jobs and
+// endpoints generated for them
+func (g *Generator) validateImageFields(file *ast.GMXFile) error {
+	storage := g.imageStorageService(file)
+	var jobs []string
+	for _, model := range file.Models {
+		for _, field := range model.Fields {
+			if field.FindAnnotation("sizes") != nil && !isImageField(field) {
+				return fmt.Errorf("line %d: @sizes applies to image fields, %s.%s is %s", field.Line, model.Name, field.Name, field.Type)
+			}
+			if !isImageField(field) {
+				continue
+			}
+			if _, err := imageSizes(field); err != nil {
+				return fmt.Errorf("line %d: field %s.%s: %w", field.Line, model.Name, field.Name, err)
+			}
+			if modelPKField(model) == nil {
+				return fmt.Errorf("line %d: model %s requires a @pk field to serve its image field %s", model.Line, model.Name, field.Name)
+			}
+			if storage == nil {
+				return fmt.Errorf("line %d: image field %s.%s writes its variants through a storage service; declare a service with provider \"s3\" or \"local\" and upload and download methods", field.Line, model.Name, field.Name)
+			}
+			if other := model.FindField(imageDigestField(field)); other != nil {
+				return fmt.Errorf("line %d: field %s of model %s collides with the digest of image field %s; rename it", other.Line, other.Name, model.Name, field.Name)
+			}
+			jobs = append(jobs, imageResizeJob(model, field))
+			for _, method := range []string{"URL", "Srcset"} {
+				goName := utils.ToPascalCase(field.Name) + method
+				for _, other := range model.Fields {
+					if utils.ToPascalCase(other.Name) == goName {
+						return fmt.Errorf("line %d: field %s of model %s collides with the generated %s method of image field %s; rename it", other.Line, other.Name, model.Name, goName, field.Name)
+					}
+				}
+			}
+		}
+	}
+
+	if file.Script == nil {
+		return nil
+	}
+	routes := imageRoutes(file)
+	for _, fn := range file.Script.Funcs {
+		if slices.Contains(jobs, fn.Name) {
+			return fmt.Errorf("line %d: function %s collides with the job resizing an image field; rename it", fn.Line, fn.Name)
+		}
+		for _, route := range routes {
+			if "handle"+utils.Capitalize(fn.Name) == route.Handler {
+				return fmt.Errorf("line %d: function %s collides with the built-in %s endpoint; rename it", fn.Line, fn.Name, route.Path)
+			}
+		}
+	}
+	return nil
+}
+
+// withImageDigests returns the file with the field storing the digest of each
+// image field, which names its variants in the storage service
+func (g *Generator) withImageDigests(file *ast.GMXFile) *ast.GMXFile {
+	if !g.hasImages(file) {
+		return file
+	}
+	withDigests := *file
+	withDigests.Models = make([]*ast.ModelDecl, len(file.Models))
+	for i, model := range file.Models {
+		withDigests.Models[i] = model
+		var digests []*ast.FieldDecl
+		for _, field := range model.Fields {
+			if isImageField(field) {
+				digests = append(digests, &ast.FieldDecl{Name: imageDigestField(field), Type: "string", Line: field.Line})
+			}
+		}
+		if len(digests) > 0 {
+			copied := *model
+			copied.Fields = append(append([]*ast.FieldDecl{}, model.Fields...), digests...)
+			withDigests.Models[i] = &copied
+		}
+	}
+	return &withDigests
+}
+
+// imageValidation returns the Validate() check rejecting an image field whose
+// content is not a JPEG, PNG or GIF image
+func imageValidation(recv, fieldName string, field *ast.FieldDecl) string {
+	return fmt.Sprintf("\tif len(%s.%s) > 0 && imageContentType(%s.%s) == \"\" {\n\t\t%s\n\t}", recv, fieldName, recv, fieldName,
+		validationReturn(field.Name, "must be a JPEG, PNG or GIF image"))
+}
+
+// genImages generates the resize jobs of the image fields, the hooks queueing
+// them, their URL helpers and the endpoints serving them
+func (g *Generator) genImages(file *ast.GMXFile) string {
+	var b strings.Builder
+	storage := g.imageStorageService(file)
+	canDelete := providerDeclares(storage, "delete")
+
+	b.WriteString("// imageStorage is the storage service the image variants are written to\n")
+	b.WriteString(fmt.Sprintf("var imageStorage %sService\n\n", storage.Name))
+
+	b.WriteString("// imageVariantKey returns the storage key of a variant of an image, named\n")
+	b.WriteString("// after the digest of the image it was made from\n")
+	b.WriteString("func imageVariantKey(model, field string, id any, digest, size string) string {\n")
+	b.WriteString("\treturn \"images/\" + model + \"/\" + field + \"/\" + url.PathEscape(fmt.Sprint(id)) + \"/\" + digest + \"/\" + size\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// imageJobContext returns the context queuing a resize job from a save; runs\n")
+	b.WriteString("// stored in the database are stored in its transaction\n")
+	b.WriteString("func imageJobContext(tx *gorm.DB) *GMXContext {\n")
+	b.WriteString("\treq := &http.Request{Method: http.MethodGet, URL: &url.URL{Path: \"/\"}, Header: http.Header{}}\n")
+	b.WriteString("\treturn &GMXContext{\n")
+	b.WriteString("\t\tDB:      tx.Session(&gorm.Session{NewDB: true}),\n")
+	b.WriteString("\t\tWriter:  queuedResponse{header: http.Header{}},\n")
+	b.WriteString("\t\tRequest: req.WithContext(tx.Statement.Context),\n")
+	b.WriteString("\t\tLog:     slog.Default(),\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// imageContentType returns the media type of a JPEG, PNG or GIF image, \"\" for other content\n")
+	b.WriteString("func imageContentType(data []byte) string {\n")
+	b.WriteString("\tswitch contentType := http.DetectContentType(data); contentType {\n")
+	b.WriteString("\tcase \"image/jpeg\", \"image/png\", \"image/gif\":\n")
+	b.WriteString("\t\treturn contentType\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn \"\"\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// imageDigest identifies the content of an image, \"\" when empty\n")
+	b.WriteString("func imageDigest(data []byte) string {\n")
+	b.WriteString("\tif len(data) == 0 {\n")
+	b.WriteString("\t\treturn \"\"\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn fmt.Sprintf(\"%x\", sha256.Sum256(data))[:16]\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// resizeImage scales src down to width pixels, keeping its aspect ratio, by\n")
+	b.WriteString("// averaging the source pixels under each pixel; narrower images are kept as is\n")
+	b.WriteString("func resizeImage(src image.Image, width int) image.Image {\n")
+	b.WriteString("\tbounds := src.Bounds()\n")
+	b.WriteString("\tif bounds.Dx() <= width {\n")
+	b.WriteString("\t\treturn src\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\theight := max(1, bounds.Dy()*width/bounds.Dx())\n")
+	b.WriteString("\tdst := image.NewRGBA64(image.Rect(0, 0, width, height))\n")
+	b.WriteString("\tfor y := 0; y < height; y++ {\n")
+	b.WriteString("\t\ty0 := bounds.Min.Y + y*bounds.Dy()/height\n")
+	b.WriteString("\t\ty1 := max(y0+1, bounds.Min.Y+(y+1)*bounds.Dy()/height)\n")
+	b.WriteString("\t\tfor x := 0; x < width; x++ {\n")
+	b.WriteString("\t\t\tx0 := bounds.Min.X + x*bounds.Dx()/width\n")
+	b.WriteString("\t\t\tx1 := max(x0+1, bounds.Min.X+(x+1)*bounds.Dx()/width)\n")
+	b.WriteString("\t\t\tvar sr, sg, sb, sa, n uint64\n")
+	b.WriteString("\t\t\tfor sy := y0; sy < y1; sy++ {\n")
+	b.WriteString("\t\t\t\tfor sx := x0; sx < x1; sx++ {\n")
+	b.WriteString("\t\t\t\t\tr, g, b, a := src.At(sx, sy).RGBA()\n")
+	b.WriteString("\t\t\t\t\tsr, sg, sb, sa, n = sr+uint64(r), sg+uint64(g), sb+uint64(b), sa+uint64(a), n+1\n")
+	b.WriteString("\t\t\t\t}\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t\tdst.SetRGBA64(x, y, color.RGBA64{R: uint16(sr / n), G: uint16(sg / n), B: uint16(sb / n), A: uint16(sa / n)})\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn dst\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// encodeImage encodes a variant as JPEG for JPEG sources, as PNG otherwise\n")
+	b.WriteString("// to keep their transparency\n")
+	b.WriteString("func encodeImage(img image.Image, format string) (Blob, error) {\n")
+	b.WriteString("\tvar buf bytes.Buffer\n")
+	b.WriteString("\tvar err error\n")
+	b.WriteString("\tif format == \"jpeg\" {\n")
+	b.WriteString("\t\terr = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})\n")
+	b.WriteString("\t} else {\n")
+	b.WriteString("\t\terr = png.Encode(&buf, img)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn Blob(buf.Bytes()), err\n")
+	b.WriteString("}\n\n")
+
+	hasRLS := g.hasRowLevelSecurity(file)
+	hasSession := g.findSessionService(file.Services) != nil
+	for _, model := range file.Models {
+		var images []*ast.FieldDecl
+		for _, field := range model.Fields {
+			if isImageField(field) {
+				images = append(images, field)
+			}
+		}
+		if len(images) == 0 {
+			continue
+		}
+		recv := utils.ReceiverName(model.Name)
+		pk := modelPKField(model)
+		pkGo, pkColumn := utils.ToPascalCase(pk.Name), utils.ToSnakeCase(pk.Name)
+
+		b.WriteString(fmt.Sprintf("// AfterSave queues the resizing of the %s images changed by the save; the\n", model.Name))
+		b.WriteString("// digest is recorded once queued, so that a failed enqueue is retried by the next save\n")
+		b.WriteString(fmt.Sprintf("func (%s *%s) AfterSave(tx *gorm.DB) error {\n", recv, model.Name))
+		for _, field := range images {
+			goName := utils.ToPascalCase(field.Name)
+			digestGo := utils.ToPascalCase(imageDigestField(field))
+			b.WriteString(fmt.Sprintf("\tif digest := imageDigest(%s.%s); digest != %s.%s {\n", recv, goName, recv, digestGo))
+			enqueue := fmt.Sprintf("enqueue%s(imageJobContext(tx), %s.%s, digest, %s.%s, %s.%s)", utils.Capitalize(imageResizeJob(model, field)), recv, pkGo, recv, digestGo, recv, goName)
+			if canDelete {
+				b.WriteString(fmt.Sprintf("\t\terr := %s\n", enqueue))
+			} else {
+				b.WriteString("\t\t// Without a delete method, the variants of a removed image are kept\n")
+				b.WriteString("\t\tvar err error\n")
+				b.WriteString("\t\tif digest != \"\" {\n")
+				b.WriteString(fmt.Sprintf("\t\t\terr = %s\n", enqueue))
+				b.WriteString("\t\t}\n")
+			}
+			b.WriteString("\t\tif err != nil {\n")
+			b.WriteString(fmt.Sprintf("\t\t\tslog.Warn(\"image: resize not queued\", \"image\", \"%s.%s\", \"id\", %s.%s, \"error\", err)\n", model.Name, field.Name, recv, pkGo))
+			b.WriteString(fmt.Sprintf("\t\t} else if err := tx.Session(&gorm.Session{NewDB: true}).Model(&%s{}).Where(%q, %s.%s).UpdateColumn(%q, digest).Error; err != nil {\n", model.Name, pkColumn+" = ?", recv, pkGo, utils.ToSnakeCase(imageDigestField(field))))
+			b.WriteString("\t\t\treturn err\n")
+			b.WriteString("\t\t} else {\n")
+			b.WriteString(fmt.Sprintf("\t\t\t%s.%s = digest\n", recv, digestGo))
+			b.WriteString("\t\t}\n")
+			b.WriteString("\t}\n")
+		}
+		b.WriteString("\treturn nil\n")
+		b.WriteString("}\n\n")
+
+		for _, field := range images {
+			goName := utils.ToPascalCase(field.Name)
+			sizes, _ := imageSizes(field) // validated in validateImageFields
+			resize := imageResizeJob(model, field)
+			pkType := g.mapType(pk.Type)
+			key := fmt.Sprintf("imageVariantKey(%q, %q, id, %%s, variant.size)", model.Name, field.Name)
+
+			b.WriteString(fmt.Sprintf("// enqueue%s queues the resizing of a %s %s whose digest changed from previous\n", utils.Capitalize(resize), model.Name, field.Name))
+			b.WriteString(fmt.Sprintf("func enqueue%s(ctx *GMXContext, id %s, digest, previous string, data Blob) error {\n", utils.Capitalize(resize), pkType))
+			b.WriteString(fmt.Sprintf("\treturn enqueueJob(ctx, %q, []any{id, digest, previous}, func(ctx *GMXContext) error {\n", resize))
+			b.WriteString(fmt.Sprintf("\t\treturn %s(ctx, id, digest, previous, data)\n", resize))
+			b.WriteString("\t})\n")
+			b.WriteString("}\n\n")
+
+			b.WriteString(fmt.Sprintf("// runStored%s runs a stored resizing of a %s %s, reloading the image\n", utils.Capitalize(resize), model.Name, field.Name))
+			b.WriteString(fmt.Sprintf("func runStored%s(ctx *GMXContext, args []json.RawMessage) error {\n", utils.Capitalize(resize)))
+			b.WriteString(fmt.Sprintf("\tvar id %s\n", pkType))
+			b.WriteString("\tvar digest, previous string\n")
+			b.WriteString("\tif err := decodeJobArgs(args, &id, &digest, &previous); err != nil {\n")
+			b.WriteString("\t\treturn err\n")
+			b.WriteString("\t}\n")
+			b.WriteString(fmt.Sprintf("\treturn %s(ctx, id, digest, previous, nil)\n", resize))
+			b.WriteString("}\n\n")
+
+			variants := make([]string, len(sizes))
+			for i, size := range sizes {
+				variants[i] = fmt.Sprintf("{%q, %d}", size.Name, size.Width)
+			}
+			if canDelete {
+				b.WriteString(fmt.Sprintf("// %s writes the variants of a %s %s to the storage service, then\n", resize, model.Name, field.Name))
+				b.WriteString("// deletes those of the previous image; errors fail the job, to be retried\n")
+			} else {
+				b.WriteString(fmt.Sprintf("// %s writes the variants of a %s %s to the storage service;\n", resize, model.Name, field.Name))
+				b.WriteString("// errors fail the job, to be retried\n")
+			}
+			b.WriteString(fmt.Sprintf("func %s(ctx *GMXContext, id %s, digest, previous string, data Blob) error {\n", resize, pkType))
+			b.WriteString(fmt.Sprintf("\tvariants := []struct {\n\t\tsize  string\n\t\twidth int\n\t}{%s}\n", strings.Join(variants, ", ")))
+			b.WriteString("\tif digest != \"\" && data == nil {\n")
+			b.WriteString("\t\t// Stored runs reload the image, unless it changed since\n")
+			b.WriteString(fmt.Sprintf("\t\tobj := &%s{}\n", model.Name))
+			b.WriteString(fmt.Sprintf("\t\terr := ctx.DB.First(obj, %q, id).Error\n", pkColumn+" = ?"))
+			b.WriteString(fmt.Sprintf("\t\tif errors.Is(err, gorm.ErrRecordNotFound) || err == nil && imageDigest(obj.%s) != digest {\n", goName))
+			b.WriteString("\t\t\treturn nil\n")
+			b.WriteString("\t\t}\n")
+			b.WriteString("\t\tif err != nil {\n")
+			b.WriteString("\t\t\treturn err\n")
+			b.WriteString("\t\t}\n")
+			b.WriteString(fmt.Sprintf("\t\tdata = obj.%s\n", goName))
+			b.WriteString("\t}\n")
+			b.WriteString("\tif digest != \"\" {\n")
+			b.WriteString("\t\tsrc, format, err := image.Decode(bytes.NewReader(data))\n")
+			b.WriteString("\t\tif err != nil {\n")
+			b.WriteString(fmt.Sprintf("\t\t\treturn fmt.Errorf(\"decoding the %s %s %%v: %%w\", id, err)\n", model.Name, field.Name))
+			b.WriteString("\t\t}\n")
+			b.WriteString("\t\tfor _, variant := range variants {\n")
+			b.WriteString("\t\t\tencoded, err := encodeImage(resizeImage(src, variant.width), format)\n")
+			b.WriteString("\t\t\tif err != nil {\n")
+			b.WriteString(fmt.Sprintf("\t\t\t\treturn fmt.Errorf(\"encoding the %%s variant of the %s %s %%v: %%w\", variant.size, id, err)\n", model.Name, field.Name))
+			b.WriteString("\t\t\t}\n")
+			b.WriteString(fmt.Sprintf("\t\t\tif err := imageStorage.Upload(%s, encoded); err != nil {\n", fmt.Sprintf(key, "digest")))
+			b.WriteString("\t\t\t\treturn err\n")
+			b.WriteString("\t\t\t}\n")
+			b.WriteString("\t\t}\n")
+			b.WriteString("\t}\n")
+			if canDelete {
+				b.WriteString("\tif previous != \"\" && previous != digest {\n")
+				b.WriteString("\t\tfor _, variant := range variants {\n")
+				b.WriteString(fmt.Sprintf("\t\t\tif err := imageStorage.Delete(%s); err != nil {\n", fmt.Sprintf(key, "previous")))
+				b.WriteString("\t\t\t\treturn err\n")
+				b.WriteString("\t\t\t}\n")
+				b.WriteString("\t\t}\n")
+				b.WriteString("\t}\n")
+			}
+			b.WriteString("\treturn nil\n")
+			b.WriteString("}\n\n")
+
+			path := imagePathPrefix + model.Name + "/" + field.Name + "/"
+			names := make([]string, len(sizes))
+			for i, size := range sizes {
+				names[i] = fmt.Sprintf("%q", size.Name)
+			}
+			b.WriteString(fmt.Sprintf("// %sURL returns the URL of the %s, or of its variant %s; \"\" without an image\n", goName, field.Name, strings.Join(names, ", ")))
+			b.WriteString(fmt.Sprintf("func (%s %s) %sURL(size string) string {\n", recv, model.Name, goName))
+			b.WriteString(fmt.Sprintf("\tif imageContentType(%s.%s) == \"\" {\n", recv, goName))
+			b.WriteString("\t\treturn \"\"\n")
+			b.WriteString("\t}\n")
+			b.WriteString(fmt.Sprintf("\tu := %q + url.PathEscape(fmt.Sprint(%s.%s)) + \"?v=\" + %s.%s\n", path, recv, pkGo, recv, utils.ToPascalCase(imageDigestField(field))))
+			b.WriteString("\tif size != \"\" {\n")
+			b.WriteString("\t\tu += \"&size=\" + url.QueryEscape(size)\n")
+			b.WriteString("\t}\n")
+			b.WriteString("\treturn u\n")
+			b.WriteString("}\n\n")
+
+			var srcset []string
+			for _, size := range sizes {
+				srcset = append(srcset, fmt.Sprintf("%s.%sURL(%q) + \" %dw\"", recv, goName, size.Name, size.Width))
+			}
+			b.WriteString(fmt.Sprintf("// %sSrcset returns the srcset of the %s variants; \"\" without an image\n", goName, field.Name))
+			b.WriteString(fmt.Sprintf("func (%s %s) %sSrcset() string {\n", recv, model.Name, goName))
+			b.WriteString(fmt.Sprintf("\tif imageContentType(%s.%s) == \"\" {\n", recv, goName))
+			b.WriteString("\t\treturn \"\"\n")
+			b.WriteString("\t}\n")
+			b.WriteString(fmt.Sprintf("\treturn %s\n", strings.Join(srcset, " + \", \" + ")))
+			b.WriteString("}\n\n")
+
+			handler := imageHandler(model, field)
+			b.WriteString(fmt.Sprintf("// %s serves the %s of a %s, or its ?size= variant\n", handler, field.Name, model.Name))
+			b.WriteString(fmt.Sprintf("func %s(w http.ResponseWriter, r *http.Request) {\n", handler))
+			b.WriteString("\tif r.Method != http.MethodGet {\n")
+			b.WriteString("\t\thttp.Error(w, \"Method Not Allowed\", http.StatusMethodNotAllowed)\n")
+			b.WriteString("\t\treturn\n")
+			b.WriteString("\t}\n\n")
+			if hasRLS {
+				b.WriteString("\t// The request's connection carries the row-level security settings\n")
+				b.WriteString("\tdb := requestDB(r)\n")
+			}
+			if model.Policy != nil {
+				b.WriteString("\t// Only the records the policy lets the user read are served\n")
+				b.WriteString("\tctx := &GMXContext{DB: db, Writer: w, Request: r, Log: requestLogger(r)")
+				if hasRLS {
+					b.WriteString(", Tenant: requestTenant(r)")
+				}
+				if hasSession {
+					b.WriteString(", User: readSession(r).User")
+				}
+				b.WriteString("}\n")
+				b.WriteString(fmt.Sprintf("\tobj, err := %sFindAuthorized(ctx, r.PathValue(\"id\"))\n", model.Name))
+				b.WriteString("\tif errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, errForbidden) {\n")
+			} else {
+				b.WriteString(fmt.Sprintf("\tobj := &%s{}\n", model.Name))
+				b.WriteString(fmt.Sprintf("\terr := db.First(obj, %q, r.PathValue(\"id\")).Error\n", pkColumn+" = ?"))
+				b.WriteString("\tif errors.Is(err, gorm.ErrRecordNotFound) {\n")
+			}
+			b.WriteString("\t\thttp.NotFound(w, r)\n")
+			b.WriteString("\t\treturn\n")
+			b.WriteString("\t}\n")
+			b.WriteString("\tif err != nil {\n")
+			b.WriteString(fmt.Sprintf("\t\trequestLogger(r).Error(\"image: loading\", \"image\", \"%s.%s\", \"error\", err)\n", model.Name, field.Name))
+			b.WriteString("\t\thttp.Error(w, \"Internal Server Error\", http.StatusInternalServerError)\n")
+			b.WriteString("\t\treturn\n")
+			b.WriteString("\t}\n\n")
+			b.WriteString(fmt.Sprintf("\tdata := obj.%s\n", goName))
+			b.WriteString("\tswitch size := r.URL.Query().Get(\"size\"); size {\n")
+			b.WriteString("\tcase \"\":\n")
+			b.WriteString(fmt.Sprintf("\tcase %s:\n", strings.Join(names, ", ")))
+			b.WriteString("\t\t// Variants not written yet fall back to the image itself\n")
+			b.WriteString(fmt.Sprintf("\t\tif variant, err := imageStorage.Download(imageVariantKey(%q, %q, obj.%s, imageDigest(obj.%s), size)); err == nil && len(variant) > 0 {\n", model.Name, field.Name, pkGo, goName))
+			b.WriteString("\t\t\tdata = variant\n")
+			b.WriteString("\t\t}\n")
+			b.WriteString("\tdefault:\n")
+			b.WriteString("\t\thttp.NotFound(w, r)\n")
+			b.WriteString("\t\treturn\n")
+			b.WriteString("\t}\n")
+			b.WriteString("\tcontentType := imageContentType(data)\n")
+			b.WriteString("\tif contentType == \"\" {\n")
+			b.WriteString("\t\thttp.NotFound(w, r)\n")
+			b.WriteString("\t\treturn\n")
+			b.WriteString("\t}\n")
+			b.WriteString("\tw.Header().Set(\"Content-Type\", contentType)\n")
+			b.WriteString("\t// URLs carry the digest of their image: a current one can be cached\n")
+			b.WriteString(fmt.Sprintf("\tif v := r.URL.Query().Get(\"v\"); v != \"\" && v == obj.%s {\n", utils.ToPascalCase(imageDigestField(field))))
+			b.WriteString("\t\tw.Header().Set(\"Cache-Control\", \"private, max-age=86400\")\n")
+			b.WriteString("\t} else {\n")
+			b.WriteString("\t\tw.Header().Set(\"Cache-Control\", \"no-cache\")\n")
+			b.WriteString("\t}\n")
+			b.WriteString("\tdata.WriteTo(w)\n")
+			b.WriteString("}\n\n")
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,170 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+const imageScriptSrc = `model Post {
+  id: uuid @pk @default(uuid_v4)
+  title: string
+  photo: image @sizes(medium: 640, thumb: 160)
+}
+func createPost(title: string, photo: bytes) error {
+  const post = Post{title: title, photo: photo}
+  try post.save()
+  return nil
+}`
+
+const imageTemplateSrc = `{{range .Posts}}<img src="{{.PhotoURL "thumb"}}" srcset="{{.PhotoSrcset}}">{{end}}`
+
+// imageTestFile returns the image script with the local storage service the
+// variants are written to
+func imageTestFile(t *testing.T, src string) *ast.GMXFile {
+	t.Helper()
+	file := scriptTestFile(t, src, imageTemplateSrc)
+	file.Services = append(file.Services, storageService("local"))
+	return file
+}
+
+func TestGenerateImages(t *testing.T) {
+	code, err := New().Generate(imageTestFile(t, imageScriptSrc))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, want := range []string{
+		// Images are stored with the digest naming their variants
+		"Photo       Blob",
+		"PhotoDigest string",
+		`return &ValidationError{Field: "photo", Message: "must be a JPEG, PNG or GIF image"}`,
+		"imageStorage = filesSvc\n",
+		// Saves queue the resizing of changed images on the job queue
+		"func (p *Post) AfterSave(tx *gorm.DB) error {",
+		"err := enqueueResizePostPhoto(imageJobContext(tx), p.ID, digest, p.PhotoDigest, p.Photo)",
+		`} else if err := tx.Session(&gorm.Session{NewDB: true}).Model(&Post{}).Where("id = ?", p.ID).UpdateColumn("photo_digest", digest).Error; err != nil {`,
+		`return enqueueJob(ctx, "resizePostPhoto", []any{id, digest, previous}, func(ctx *GMXContext) error {`,
+		"startJobWorkers(",
+		// The job writes the variants, narrowest first, through the storage service
+		`}{{"thumb", 160}, {"medium", 640}}`,
+		`if err := imageStorage.Upload(imageVariantKey("Post", "photo", id, digest, variant.size), encoded); err != nil {`,
+		`if err := imageStorage.Delete(imageVariantKey("Post", "photo", id, previous, variant.size)); err != nil {`,
+		`if variant, err := imageStorage.Download(imageVariantKey("Post", "photo", obj.ID, imageDigest(obj.Photo), size)); err == nil && len(variant) > 0 {`,
+		// Templates get URL and srcset helpers
+		`u := "/_gmx/image/Post/photo/" + url.PathEscape(fmt.Sprint(p.ID)) + "?v=" + p.PhotoDigest`,
+		`return p.PhotoURL("thumb") + " 160w" + ", " + p.PhotoURL("medium") + " 640w"`,
+		`mux.HandleFunc("/_gmx/image/Post/photo/{id}", handlePostPhotoImage)`,
+		`err := db.First(obj, "id = ?", r.PathValue("id")).Error`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+}
+
+func TestGenerateImagesDefaultSize(t *testing.T) {
+	code, err := New().Generate(imageTestFile(t, strings.Replace(imageScriptSrc, " @sizes(medium: 640, thumb: 160)", "", 1)))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !strings.Contains(code, `}{{"thumb", 200}}`) || strings.Contains(code, `"medium"`) {
+		t.Errorf("an image field without @sizes should get a single 200px thumb variant")
+	}
+}
+
+func TestGenerateImagesStoredJobs(t *testing.T) {
+	file := imageTestFile(t, imageScriptSrc)
+	file.Services = append(file.Services, &ast.ServiceDecl{Name: "Jobs", Provider: "db"})
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	// Stored runs are queued in the transaction of the save and reload the image
+	for _, want := range []string{
+		`"resizePostPhoto": runStoredResizePostPhoto,`,
+		"if err := decodeJobArgs(args, &id, &digest, &previous); err != nil {",
+		"return resizePostPhoto(ctx, id, digest, previous, nil)",
+		"DB:      tx.Session(&gorm.Session{NewDB: true}),",
+		`if errors.Is(err, gorm.ErrRecordNotFound) || err == nil && imageDigest(obj.Photo) != digest {`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+}
+
+func TestGenerateImagesWithoutDelete(t *testing.T) {
+	file := imageTestFile(t, imageScriptSrc)
+	svc := file.Services[len(file.Services)-1]
+	svc.Methods = svc.Methods[:2]
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	// Removed images keep their variants, and are not queued
+	if strings.Contains(code, "imageStorage.Delete") {
+		t.Errorf("a storage service without delete should keep the previous variants")
+	}
+	if !strings.Contains(code, "\t\tif digest != \"\" {\n\t\t\terr = enqueueResizePostPhoto(") {
+		t.Errorf("a removed image should not be queued without a delete method")
+	}
+}
+
+func TestGenerateImagesPolicy(t *testing.T) {
+	file := policyTestFile(false, "read")
+	file.Models[0].Fields = append(file.Models[0].Fields, &ast.FieldDecl{Name: "cover", Type: "image"})
+	file.Services = append(file.Services, storageService("local"))
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	// Only the images of records the policy lets the user read are served
+	for _, want := range []string{
+		"ctx := &GMXContext{DB: db, Writer: w, Request: r, Log: requestLogger(r), User: readSession(r).User}",
+		`obj, err := TaskFindAuthorized(ctx, r.PathValue("id"))`,
+		"if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, errForbidden) {",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+}
+
+func TestValidateImageFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{"sizes on bytes", strings.Replace(imageScriptSrc, "title: string", "title: bytes @sizes(thumb: 100)", 1), "@sizes applies to image fields, Post.title is bytes"},
+		{"invalid width", strings.Replace(imageScriptSrc, "thumb: 160", "thumb: tiny", 1), "field Post.photo: @sizes expects named widths in pixels"},
+		{"zero width", strings.Replace(imageScriptSrc, "thumb: 160", "thumb: 0", 1), "@sizes expects named widths in pixels"},
+		{"no pk", strings.Replace(imageScriptSrc, " @pk", "", 1), "model Post requires a @pk field to serve its image field photo"},
+		{"digest field", strings.Replace(imageScriptSrc, "title: string", "photoDigest: string", 1), "field photoDigest of model Post collides with the digest of image field photo"},
+		{"method field", strings.Replace(imageScriptSrc, "title: string", "photoSrcset: string", 1), "field photoSrcset of model Post collides with the generated PhotoSrcset method of image field photo"},
+		{"func", imageScriptSrc + "\nfunc postPhotoImage() error {\n  return nil\n}", "function postPhotoImage collides with the built-in /_gmx/image/Post/photo/{id} endpoint"},
+		{"job", imageScriptSrc + "\nfunc resizePostPhoto() error {\n  return nil\n}", "function resizePostPhoto collides with the job resizing an image field"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New().Generate(imageTestFile(t, tt.src))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	_, err := New().Generate(scriptTestFile(t, imageScriptSrc, imageTemplateSrc))
+	if err == nil || !strings.Contains(err.Error(), "image field Post.photo writes its variants through a storage service") {
+		t.Errorf("expected an error for an image field without storage service, got %v", err)
+	}
+}
@@ -18,12 +18,12 @@ func (g *Generator) genImports(file *ast.GMXFile) string {
 				// Native Go imports will be added to the import block below
 				b.WriteString(fmt.Sprintf("// Native Go import: %s as %s\n", imp.Path, imp.Alias))
 			} else if imp.Default != "" {
-				// Component import (Vue-style default import)
-				b.WriteString(fmt.Sprintf("// TODO: Component import: %s from %s\n", imp.Default, imp.Path))
+				// Component import (Vue-style default import), expanded by the resolver
+				b.WriteString(fmt.Sprintf("// Component import: %s from %s\n", imp.Default, imp.Path))
 			} else if len(imp.Members) > 0 {
 				// Destructured import
 				membersStr := strings.Join(imp.Members, ", ")
-				b.WriteString(fmt.Sprintf("// TODO: Destructured import: %s from %s\n", membersStr, imp.Path))
+				b.WriteString(fmt.Sprintf("// Destructured import: %s from %s\n", membersStr, imp.Path))
 			}
 		}
 		b.WriteString("\n")
@@ -31,26 +31,214 @@ func (g *Generator) genImports(file *ast.GMXFile) string {
 
 	b.WriteString("import (\n")
 
+	// Personal data exports answer CSV files in a zip archive
+	hasPersonalData := g.hasPersonalData(file)
+	if hasPersonalData {
+		b.WriteString("\t\"archive/zip\"\n")
+	}
+
+	// Image variants are decoded from and encoded into buffers,
+	// as are the bodies recorded by dev builds and the objects sent to S3
+	hasImages := g.hasImages(file)
+	hasS3 := g.hasStorage(file, "s3")
+	hasLocal := g.hasStorage(file, "local")
+	hasLocalSigned := g.hasStorage(file, "local", "signedUrl")
+	hasTracing := g.hasTracing(file)
+	if hasImages || g.opts.Dev || hasS3 {
+		b.WriteString("\t\"bytes\"\n")
+	}
+
+	// Honeypot timestamps, session cookies, S3 requests and local storage URLs are signed with HMAC-SHA256
+	hasSession := g.findSessionService(file.Services) != nil
+	needsHMAC := g.hasFuncAnnotation(file, "honeypot") || hasSession || hasS3 || hasLocalSigned
+	if needsHMAC {
+		b.WriteString("\t\"crypto/hmac\"\n")
+	}
+
+	// TOTP codes of two-factor authentication are HMAC-SHA1 digests of their step
+	hasTwoFactor := g.hasTwoFactor(file)
+	if hasTwoFactor {
+		b.WriteString("\t\"crypto/sha1\"\n")
+	}
+
+	// The graceful shutdown, @timeout handlers and HTTP clients carry deadlines; string[]
+	// columns encode their value per dialect; requests carry their row-level security connection
+	needsList := g.needsStringList(file)
+	hasTimeout := g.hasFuncAnnotation(file, "timeout")
+	b.WriteString("\t\"context\"\n")
+
 	// Always include crypto/rand for CSRF token generation (and UUID if needed)
 	b.WriteString("\t\"crypto/rand\"\n")
+
+	// Image URLs carry the digest of their image, @once submissions the digest of their form
+	hasOnce := g.hasFuncAnnotation(file, "once")
+	if needsHMAC || hasImages || hasOnce {
+		b.WriteString("\t\"crypto/sha256\"\n")
+	}
+
+	// json and string[] fields implement sql.Scanner and driver.Valuer
+	hasJSON := g.hasJSONField(file)
+	needsBlob := g.needsBlob(file)
+	if hasJSON || needsList || needsBlob {
+		b.WriteString("\t\"database/sql/driver\"\n")
+	}
+
+	// Authenticator secrets are encoded in base32, their steps in big-endian
+	if hasTwoFactor {
+		b.WriteString("\t\"encoding/base32\"\n")
+	}
+
+	// Sessions kept in their cookie are encoded in base64, as are the QR codes of two-factor authentication
+	store := g.sessionStoreOf(file)
+	if store == "cookie" || hasTwoFactor {
+		b.WriteString("\t\"encoding/base64\"\n")
+	}
+	if hasTwoFactor {
+		b.WriteString("\t\"encoding/binary\"\n")
+	}
+	if hasPersonalData {
+		b.WriteString("\t\"encoding/csv\"\n")
+	}
+
+	// Constant-time comparison for password and secret checks
+	if g.hasPasswordField(file) {
+		b.WriteString("\t\"crypto/subtle\"\n")
+	}
+
+	if needsHMAC {
+		b.WriteString("\t\"encoding/hex\"\n")
+	}
+
+	// Captcha verification decodes the provider's JSON response; json and string[] fields are encoded as JSON,
+	// as are the responses of @json functions and @negotiate ones to JSON clients, the recordings of dev builds
+	// and cached records; handlers decode JSON request bodies; personal data is exported as its models encode to JSON,
+	// spans in OTLP/HTTP JSON; the runs of @job functions stored in the database keep their arguments as JSON,
+	// published events their payload
+	hasNegotiation := g.hasJSONResponses(file)
+	needsBody := g.needsRequestBody(file)
+	hasRedis := g.hasServiceWithProvider(file, "redis")
+	if g.hasFuncAnnotation(file, "captcha") || hasJSON || needsList || hasNegotiation || needsBody || g.opts.Dev || hasRedis || hasPersonalData || g.hasQueuedJobs(file) || g.hasOutbox(file) {
+		b.WriteString("\t\"encoding/json\"\n")
+	}
+
+	// Oversized bytes uploads are told apart from malformed ones, expired deadlines,
+	// policy denials, unsupported request bodies, expired links and validation errors from other errors;
+	// the query log leaves out records not found, caches and session stores their missing keys,
+	// backups their missing index
+	hasQueryLog := g.hasQueryLog(file)
+	hasBackup := g.findBackupService(file.Services) != nil
+	if needsBlob || hasTimeout || g.hasPolicies(file) || needsBody || g.hasFuncAnnotation(file, "signed") || g.hasScriptHandlers(file) || hasQueryLog || hasRedis || g.hasServerSessions(file) || hasBackup {
+		b.WriteString("\t\"errors\"\n")
+	}
+
+	// Dev builds take the --profile, --record and --self-check flags
+	if g.opts.Dev {
+		b.WriteString("\t\"flag\"\n")
+	}
+
 	b.WriteString("\t\"fmt\"\n")
 
-	// Add io for HTTP client
-	if g.hasServiceWithProvider(file, "http") {
+	// Uploaded images are decoded, resized and encoded as JPEG or PNG
+	if hasImages {
+		b.WriteString("\t\"image\"\n")
+		b.WriteString("\t\"image/color\"\n")
+		b.WriteString("\t_ \"image/gif\"\n")
+		b.WriteString("\t\"image/jpeg\"\n")
+		b.WriteString("\t\"image/png\"\n")
+	}
+
+	// Add io for HTTP client, bytes payload streaming, request bodies and their recording, S3 responses, personal data archives
+	// and the metrics endpoint
+	hasMetrics := g.hasMetrics(file)
+	if g.hasServiceWithProvider(file, "http") || needsBlob || needsBody || g.opts.Dev || hasS3 || hasPersonalData || hasMetrics {
 		b.WriteString("\t\"io\"\n")
 	}
 
+	// A missing object reads as fs.ErrNotExist from S3 as from a local directory
+	if hasS3 || hasBackup {
+		b.WriteString("\t\"io/fs\"\n")
+	}
+
 	b.WriteString("\t\"log\"\n")
+	b.WriteString("\t\"log/slog\"\n")
+
+	// Overflow check of parsed money amounts
+	needsMoney := g.needsMoney(file)
+	if needsMoney {
+		b.WriteString("\t\"math\"\n")
+	}
+
+	// Media types of request bodies
+	if needsBody {
+		b.WriteString("\t\"mime\"\n")
+	}
+
+	// Random data for model factories and the anonymization task, and the
+	// failures injected into HTTP service requests of dev builds
+	hasChaos := g.hasChaos(file)
+	if len(file.Models) > 0 || hasChaos {
+		b.WriteString("\tmrand \"math/rand/v2\"\n")
+	}
+
+	// Dev builds check that profiling clients are local, then serve net/http/pprof;
+	// the server block joins its host and port; sign-in lockouts key client addresses
+	hasLoginLockout := g.hasLoginLockout(file)
+	if g.opts.Dev || file.Server != nil || hasLoginLockout {
+		b.WriteString("\t\"net\"\n")
+	}
+
 	b.WriteString("\t\"net/http\"\n")
 
-	// Add net/smtp for SMTP service
-	if g.hasServiceWithProvider(file, "smtp") {
+	if g.opts.Dev {
+		b.WriteString("\thttppprof \"net/http/pprof\"\n")
+	}
+
+	// Add net/url for captcha verification requests, session encoding, request bodies, feed pages, wizard and autosaved drafts, image URLs
+	// and the redacted forms of dev recordings; storage URLs;
+	// the topic paths of delivered events; the database URL of pg_dump backups
+	if g.hasFuncAnnotation(file, "captcha") || hasSession || needsBody || g.hasActivityFeed(file) || g.hasWizards(file) || g.hasAutosave(file) || hasImages || g.opts.Dev || hasS3 || hasLocalSigned || g.hasOutbox(file) ||
+		hasBackup && g.backupDBProvider(file) == "postgres" {
+		b.WriteString("\t\"net/url\"\n")
+	}
+
+	// Add net/smtp for SMTP service (dev builds catch mail in memory)
+	if g.hasServiceWithProvider(file, "smtp") && !g.opts.Dev {
 		b.WriteString("\t\"net/smtp\"\n")
 	}
 
-	// Add os import if services use @env
-	if g.hasServicesWithEnv(file) {
-		b.WriteString("\t\"os\"\n")
+	// Logs are written to stderr; services and the server block read @env, dev builds
+	// their CPU profile file and local storage its files
+	b.WriteString("\t\"os\"\n")
+
+	// pg_dump backups shell out to the PostgreSQL client
+	if hasBackup && g.backupDBProvider(file) == "postgres" {
+		b.WriteString("\t\"os/exec\"\n")
+	}
+
+	// Graceful shutdown and signal-triggered database switchover
+	hasStandby := g.hasDatabaseStandby(file)
+	b.WriteString("\t\"os/signal\"\n")
+
+	// Storage keys are checked as clean paths; backup keys are joined to their directory
+	if hasLocal || hasBackup {
+		b.WriteString("\t\"path\"\n")
+	}
+
+	// Backup archives, dev recordings and stored objects are named on disk
+	if hasBackup || g.opts.Dev || hasLocal {
+		b.WriteString("\t\"path/filepath\"\n")
+	}
+	// The query log finds the script line of a query in the call stack
+	if hasQueryLog {
+		b.WriteString("\t\"runtime\"\n")
+	}
+	// Metrics are answered in a stable order and observed into sorted buckets
+	if hasQueryLog || hasMetrics {
+		b.WriteString("\t\"sort\"\n")
+	}
+
+	if g.opts.Dev {
+		b.WriteString("\t\"runtime/pprof\"\n")
 	}
 
 	// Conditionally add regexp for email validation
@@ -61,23 +249,75 @@ func (g *Generator) genImports(file *ast.GMXFile) string {
 		b.WriteString("\t\"regexp\"\n")
 	}
 
-	// Conditionally add strconv for script parameter parsing
-	if g.needsStrconv(file) {
+	// Conditionally add strconv for script parameter parsing, the error rates of dev builds,
+	// the size of the job queue, the query log settings, the expiry of storage URLs and the
+	// pool size of redis services, the lockout settings and the Retry-After of locked sign-ins,
+	// the expiry of email change links, two-factor setups and session cookies, the status codes and bounds of metrics
+	hasAccounts := g.hasAccounts(file)
+	if g.needsStrconv(file) || needsMoney || hasChaos || g.hasQueuedJobs(file) && g.findJobQueueService(file.Services) != nil || hasQueryLog || hasS3 || hasLocalSigned || g.hasRedisPoolSize(file) || hasLoginLockout || hasAccounts || hasTwoFactor || hasMetrics || store == "cookie" {
 		b.WriteString("\t\"strconv\"\n")
 	}
 
-	if file.Template != nil {
+	// Session cookies are split on their signature separator, money amounts on their
+	// decimal point; list items render into a buffer; PostgreSQL arrays are parsed by hand;
+	// Accept headers are split into media types; profile names are cut from their path;
+	// migrations are split into statements; S3 canonical requests are joined; metrics are written into a builder;
+	// OTEL_SDK_DISABLED is read regardless of case; the backup index is split into keys
+	if hasSession || g.hasItemIsolation(file) || needsMoney || needsList || hasNegotiation || g.opts.Dev || g.hasTypeahead(file) || g.hasLive(file) || g.hasMigrations(file) || hasS3 || hasMetrics || hasTracing || hasBackup {
+		b.WriteString("\t\"strings\"\n")
+	}
+
+	// The impersonation banner, two-factor page, account pages, consent page, dev mailbox, notification list,
+	// activity feed, typeahead matches and job messages escape user data even without a template section
+	hasDevMail := g.hasDevMail(file)
+	hasNotifications := g.hasNotifications(file)
+	hasJobs := g.hasJobs(file)
+	hasConsent := g.hasConsent(file)
+	if file.Template != nil || g.hasImpersonation(file) || hasTwoFactor || hasAccounts || hasConsent || hasDevMail || hasNotifications || g.hasActivityFeed(file) || g.hasTypeahead(file) || hasJobs {
 		b.WriteString("\t\"html/template\"\n")
 	}
 
-	if len(file.Models) > 0 || g.hasServiceWithProvider(file, "http") {
-		b.WriteString("\t\"time\"\n")
+	if hasStandby || hasDevMail || len(file.Settings) > 0 || hasNotifications || g.hasLive(file) || g.hasWizards(file) || hasJobs || hasChaos || g.hasQueuedJobs(file) || g.hasSchedules(file) || hasOnce || store == "memory" || g.hasFailureLimiter(file) || hasMetrics || hasConsent {
+		b.WriteString("\t\"sync\"\n")
+	}
+
+	// Unique sequence for model factories, queued requests of the load shedder, dev recordings
+	if len(file.Models) > 0 || g.findLoadShedService(file.Services) != nil || g.opts.Dev {
+		b.WriteString("\t\"sync/atomic\"\n")
+	}
+
+	b.WriteString("\t\"syscall\"\n")
+
+	// The shutdown deadline bounds in-flight requests
+	b.WriteString("\t\"time\"\n")
+
+	// Typeahead matches are highlighted rune by rune
+	if g.hasTypeahead(file) {
+		b.WriteString("\t\"unicode/utf8\"\n")
+	}
+
+	// bcrypt for password field hashing
+	if g.hasPasswordField(file) {
+		b.WriteString("\t\"golang.org/x/crypto/bcrypt\"\n")
 	}
 
 	// Database imports
 	if len(file.Models) > 0 {
 		b.WriteString("\t\"gorm.io/gorm\"\n")
 
+		// Aliased, as script code may name a variable logger
+		if hasQueryLog {
+			b.WriteString("\tgormlogger \"gorm.io/gorm/logger\"\n")
+		}
+
+		// json and string[] fields pick their column type per dialect
+		if needsList {
+			b.WriteString("\t\"gorm.io/gorm/clause\"\n")
+		}
+		if hasJSON || needsList {
+			b.WriteString("\t\"gorm.io/gorm/schema\"\n")
+		}
+
 		// Determine which database driver to import
 		dbService := g.findDatabaseService(file.Services)
 		if dbService != nil {
@@ -95,6 +335,32 @@ func (g *Generator) genImports(file *ast.GMXFile) string {
 		}
 	}
 
+	// Redis client of the cache services and the redis session store
+	if hasRedis || store == "redis" {
+		b.WriteString("\t\"github.com/redis/go-redis/v9\"\n")
+	}
+
+	// QR codes of the authenticator secrets
+	if hasTwoFactor {
+		b.WriteString("\t\"github.com/skip2/go-qrcode\"\n")
+	}
+
+	// OpenTelemetry SDK of the tracing service; aliased, as script code may
+	// name a variable trace or resource
+	if hasTracing {
+		b.WriteString("\t\"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp\"\n")
+		b.WriteString("\t\"go.opentelemetry.io/otel\"\n")
+		b.WriteString("\totelattribute \"go.opentelemetry.io/otel/attribute\"\n")
+		b.WriteString("\t\"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp\"\n")
+		b.WriteString("\totelpropagation \"go.opentelemetry.io/otel/propagation\"\n")
+		b.WriteString("\totelresource \"go.opentelemetry.io/otel/sdk/resource\"\n")
+		b.WriteString("\tsdktrace \"go.opentelemetry.io/otel/sdk/trace\"\n")
+		b.WriteString("\toteltrace \"go.opentelemetry.io/otel/trace\"\n")
+		if len(file.Models) > 0 {
+			b.WriteString("\t\"github.com/uptrace/opentelemetry-go-extra/otelgorm\"\n")
+		}
+	}
+
 	// Add native Go imports from GMX import declarations
 	for _, imp := range file.Imports {
 		if imp.IsNative {
@@ -0,0 +1,135 @@
+package generator
+
+import (
+	"fmt"
+	gotoken "go/token"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// modelIndex is an index of a model table, from a field @index or a
+// model @@index / @@unique
+type modelIndex struct {
+	name   string
+	fields []string // in index order
+	unique bool
+}
+
+// modelIndexes returns the indexes of a model: field @index first, then the
+// @@index and @@unique of the model body. Unnamed indexes are named by GORM's
+// convention, idx_<table>_<columns>.
+func modelIndexes(model *ast.ModelDecl) []modelIndex {
+	table := tableName(model.Name)
+	var indexes []modelIndex
+	for _, field := range model.Fields {
+		ann := field.FindAnnotation("index")
+		if ann == nil {
+			continue
+		}
+		name := ann.Args["name"]
+		if name == "" {
+			name = indexName(table, utils.ToSnakeCase(field.Name))
+		}
+		indexes = append(indexes, modelIndex{name: name, fields: []string{field.Name}})
+	}
+	for _, decl := range model.Indexes {
+		name := decl.Name
+		if name == "" {
+			columns := make([]string, len(decl.Fields))
+			for i, field := range decl.Fields {
+				columns[i] = utils.ToSnakeCase(field)
+			}
+			name = indexName(table, strings.Join(columns, "_"))
+		}
+		indexes = append(indexes, modelIndex{name: name, fields: decl.Fields, unique: decl.Unique})
+	}
+	return indexes
+}
+
+// validateIndexes checks the indexes of the models: indexed fields are
+// columns of the model and index names are unique across the database
+func (g *Generator) validateIndexes(file *ast.GMXFile) error {
+	// The fields of @timestamps can be indexed
+	file = g.withTimestamps(file)
+	owners := make(map[string]string)
+	for _, model := range file.Models {
+		for _, field := range model.Fields {
+			ann := field.FindAnnotation("index")
+			if ann == nil {
+				continue
+			}
+			for key := range ann.Args {
+				if key != "name" {
+					return fmt.Errorf("line %d: field %s.%s: @index takes an optional name, such as @index(name: \"idx_%s\")", field.Line, model.Name, field.Name, utils.ToSnakeCase(field.Name))
+				}
+			}
+			if !columnTypes[field.Type] {
+				return fmt.Errorf("line %d: field %s.%s: @index applies to columns, not to the relation %s", field.Line, model.Name, field.Name, field.Type)
+			}
+			for _, other := range []string{"pk", "unique"} {
+				if field.FindAnnotation(other) != nil {
+					return fmt.Errorf("line %d: field %s.%s is already indexed by @%s; remove @index", field.Line, model.Name, field.Name, other)
+				}
+			}
+		}
+		for _, decl := range model.Indexes {
+			seen := make(map[string]bool)
+			for _, name := range decl.Fields {
+				field := model.FindField(name)
+				if field == nil {
+					return fmt.Errorf("line %d: model %s: %s refers to unknown field %s", decl.Line, model.Name, decl.TokenLiteral(), name)
+				}
+				if !columnTypes[field.Type] {
+					return fmt.Errorf("line %d: model %s: %s applies to columns, not to the relation %s", decl.Line, model.Name, decl.TokenLiteral(), name)
+				}
+				if seen[name] {
+					return fmt.Errorf("line %d: model %s: %s lists %s twice", decl.Line, model.Name, decl.TokenLiteral(), name)
+				}
+				seen[name] = true
+			}
+		}
+		// The migrations name the index of a @unique column idx_<table>_<column>
+		indexes := modelIndexes(model)
+		for _, field := range model.Fields {
+			if field.FindAnnotation("unique") != nil {
+				indexes = append(indexes, modelIndex{name: indexName(tableName(model.Name), utils.ToSnakeCase(field.Name))})
+			}
+		}
+		for _, index := range indexes {
+			if !gotoken.IsIdentifier(index.name) {
+				return fmt.Errorf("model %s: invalid index name %q (letters, digits and underscores)", model.Name, index.name)
+			}
+			// PostgreSQL and SQLite name indexes per database, not per table
+			if other, ok := owners[index.name]; ok {
+				return fmt.Errorf("model %s: index %s is already declared by model %s; name one of them with name: \"...\"", model.Name, index.name, other)
+			}
+			owners[index.name] = model.Name
+		}
+	}
+	return nil
+}
+
+// indexTags returns the GORM tags adding a field to the indexes of its model;
+// fields of composite indexes carry their position as priority
+func indexTags(model *ast.ModelDecl, field *ast.FieldDecl) []string {
+	var tags []string
+	for _, index := range modelIndexes(model) {
+		for i, name := range index.fields {
+			if name != field.Name {
+				continue
+			}
+			key := "index"
+			if index.unique {
+				key = "uniqueIndex"
+			}
+			tag := key + ":" + index.name
+			if len(index.fields) > 1 {
+				tag += fmt.Sprintf(",priority:%d", i+1)
+			}
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
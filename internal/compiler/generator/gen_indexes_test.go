@@ -0,0 +1,63 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+)
+
+const indexesScriptSrc = `model Task {
+  id: uuid @pk @default(uuid_v4)
+  ownerId: string @index
+  status: string @index(name: "idx_task_status")
+  slug: string
+  owner: User
+  @@index([ownerId, status])
+  @@unique([ownerId, slug], name: "idx_owner_slug")
+}`
+
+func TestGenerateIndexes(t *testing.T) {
+	code, err := New().Generate(scriptTestFile(t, indexesScriptSrc, ""))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, want := range []string{
+		// Unnamed indexes follow GORM's idx_<table>_<columns> convention
+		"OwnerId string `gorm:\"index:idx_tasks_owner_id;index:idx_tasks_owner_id_status,priority:1;uniqueIndex:idx_owner_slug,priority:1\" json:\"ownerId\"`",
+		"Status string `gorm:\"index:idx_task_status;index:idx_tasks_owner_id_status,priority:2\" json:\"status\"`",
+		"Slug string `gorm:\"uniqueIndex:idx_owner_slug,priority:2\" json:\"slug\"`",
+	} {
+		if !strings.Contains(strings.Join(strings.Fields(code), " "), want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+}
+
+func TestValidateIndexes(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{"argument", strings.Replace(indexesScriptSrc, "@index\n", "@index(hash)\n", 1), "@index takes an optional name"},
+		{"relation", strings.Replace(indexesScriptSrc, "owner: User", "owner: User @index", 1), "@index applies to columns, not to the relation User"},
+		{"unique", strings.Replace(indexesScriptSrc, "slug: string", "slug: string @unique @index", 1), "field Task.slug is already indexed by @unique"},
+		{"unknown field", strings.Replace(indexesScriptSrc, "[ownerId, status]", "[ownerId, state]", 1), "@@index refers to unknown field state"},
+		{"relation field", strings.Replace(indexesScriptSrc, "[ownerId, status]", "[owner, status]", 1), "@@index applies to columns, not to the relation owner"},
+		{"twice", strings.Replace(indexesScriptSrc, "[ownerId, status]", "[status, status]", 1), "@@index lists status twice"},
+		{"name", strings.Replace(indexesScriptSrc, `"idx_task_status"`, `"idx-status"`, 1), `invalid index name "idx-status"`},
+		{"shared name", indexesScriptSrc + "\nmodel Tag {\n  id: int @pk\n  label: string @index(name: \"idx_owner_slug\")\n}", "index idx_owner_slug is already declared by model Task"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New().Generate(scriptTestFile(t, tt.src, ""))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// jobModel is the generated model storing the state of the @async functions' jobs
+const jobModel = "Job"
+
+// jobsPathPrefix is the prefix of the built-in endpoint reporting the state of a job
+const jobsPathPrefix = "/_gmx/jobs/"
+
+// jobPollInterval is the htmx interval at which the fragment of a running job refreshes
+const jobPollInterval = "1s"
+
+// hasJobs checks if a script function runs as a background job with @async
+func (g *Generator) hasJobs(file *ast.GMXFile) bool {
+	return g.hasFuncAnnotation(file, "async")
+}
+
+// jobRoutes returns the endpoint reporting the state of a job
+func (g *Generator) jobRoutes(file *ast.GMXFile) []Route {
+	if !g.hasJobs(file) {
+		return nil
+	}
+	return []Route{{Method: "GET", Path: jobsPathPrefix + "{id}", Handler: "handleJobStatus"}}
+}
+
+// validateJobs checks that the @async functions are handlers whose work can
+// outlive their request
+func (g *Generator) validateJobs(file *ast.GMXFile) error {
+	funcs := g.funcsWithAnnotation(file, "async")
+	for _, fn := range funcs {
+		if len(fn.FindAnnotation("async").Args) > 0 {
+			return fmt.Errorf("function %s: @async takes no arguments", fn.Name)
+		}
+		if fn.ReturnType != "" && fn.ReturnType != "error" {
+			return fmt.Errorf("function %s: @async applies to handlers, functions returning error", fn.Name)
+		}
+		if fn.FindAnnotation("timeout") != nil {
+			return fmt.Errorf("function %s: @async functions outlive their request; check ctx.cancelled() instead of @timeout", fn.Name)
+		}
+		if fn.FindAnnotation("negotiate") != nil || fn.FindAnnotation("json") != nil {
+			return fmt.Errorf("function %s: @async functions answer with the progress of their job, not JSON", fn.Name)
+		}
+	}
+	if len(funcs) == 0 {
+		return nil
+	}
+
+	// Jobs run on the shared pool once the request and its pinned connection are gone
+	if g.hasRowLevelSecurity(file) {
+		return fmt.Errorf("function %s: @async does not apply with row-level security, whose connection ends with the request", funcs[0].Name)
+	}
+	for _, model := range file.Models {
+		if model.Name == jobModel {
+			return fmt.Errorf("line %d: model %s collides with the model storing the jobs of the @async functions; rename it", model.Line, model.Name)
+		}
+	}
+	routes := g.jobRoutes(file)
+	for _, fn := range file.Script.Funcs {
+		for _, route := range routes {
+			if "handle"+utils.Capitalize(fn.Name) == route.Handler {
+				return fmt.Errorf("line %d: function %s collides with the built-in %s endpoint; rename it", fn.Line, fn.Name, route.Path)
+			}
+		}
+	}
+	return nil
+}
+
+// withJobModel returns the file with the model storing the jobs of its
+// @async functions, so that it is declared and migrated like the others
+func (g *Generator) withJobModel(file *ast.GMXFile) *ast.GMXFile {
+	if !g.hasJobs(file) {
+		return file
+	}
+	withModel := *file
+	withModel.Models = append(append([]*ast.ModelDecl{}, file.Models...), &ast.ModelDecl{
+		Name: jobModel,
+		Fields: []*ast.FieldDecl{
+			{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{
+				{Name: "pk", Args: map[string]string{}},
+				{Name: "default", Args: map[string]string{"_": "uuid_v4"}},
+			}},
+			{Name: "name", Type: "string"},
+			{Name: "owner", Type: "string"},
+			{Name: "status", Type: "string"},
+			{Name: "percent", Type: "int"},
+			{Name: "message", Type: "string"},
+			{Name: "createdAt", Type: "datetime"},
+			{Name: "updatedAt", Type: "datetime"},
+		},
+	})
+	return &withModel
+}
+
+// genJobStart generates the start of an @async function's job in its handler,
+// in place of the call answering the request
+func genJobStart(fn *ast.FuncDecl, call string) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("\tif !startJob(w, ctx, %q, func(ctx *GMXContext) error {\n", fn.Name))
+	b.WriteString(fmt.Sprintf("\t\treturn %s\n", call))
+	b.WriteString("\t}) {\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	return b.String()
+}
+
+// genJobs generates the background jobs of the @async functions: their start,
+// the progress they report, the fragment following them and its endpoint, the
+// cleanup of finished jobs and the wait for running ones on shutdown
+func (g *Generator) genJobs(file *ast.GMXFile) string {
+	var b strings.Builder
+	hasSession := g.findSessionService(file.Services) != nil
+
+	b.WriteString("// jobTTL is how long the state of a finished job is kept\n")
+	b.WriteString("const jobTTL = 24 * time.Hour\n\n")
+
+	b.WriteString("// jobsCtx is the context of the running jobs, cancelled on shutdown so that\n")
+	b.WriteString("// ctx.cancelled() lets them stop early\n")
+	b.WriteString("var jobsCtx, cancelJobs = context.WithCancel(context.Background())\n\n")
+
+	b.WriteString("// runningJobs are the jobs of this process, which the shutdown waits for\n")
+	b.WriteString("var runningJobs struct {\n")
+	b.WriteString("\tsync.WaitGroup\n")
+	b.WriteString("\tids sync.Map\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// jobResponse is the writer of a running job: the browser follows the\n")
+	b.WriteString("// fragment of the job, so what the function renders is dropped\n")
+	b.WriteString("type jobResponse struct {\n")
+	b.WriteString("\theader http.Header\n")
+	b.WriteString("}\n\n")
+	b.WriteString("func (r jobResponse) Header() http.Header       { return r.header }\n")
+	b.WriteString("func (jobResponse) Write(p []byte) (int, error) { return len(p), nil }\n")
+	b.WriteString("func (jobResponse) WriteHeader(int)             {}\n\n")
+
+	b.WriteString("// Progress records the completion percentage of a running job: job.progress(40)\n")
+	b.WriteString("func (job *Job) Progress(percent int) {\n")
+	b.WriteString("\tjob.Percent = max(0, min(percent, 100))\n")
+	b.WriteString("\tif err := db.Model(job).Update(\"percent\", job.Percent).Error; err != nil {\n")
+	b.WriteString("\t\tslog.Error(\"job: recording progress\", \"job\", job.Name, \"id\", job.ID, \"error\", err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// startJob records a job of the @async function name and runs it in the\n")
+	b.WriteString("// background, answering with the fragment following its progress\n")
+	b.WriteString("func startJob(w http.ResponseWriter, ctx *GMXContext, name string, run func(ctx *GMXContext) error) bool {\n")
+	b.WriteString("\tjob := &Job{Name: name, Owner: ctx.User, Status: \"running\"}\n")
+	b.WriteString("\tif err := db.Create(job).Error; err != nil {\n")
+	b.WriteString("\t\tctx.Log.Error(\"job: starting\", \"job\", name, \"error\", err)\n")
+	b.WriteString("\t\thttp.Error(w, \"Internal Server Error\", http.StatusInternalServerError)\n")
+	b.WriteString("\t\treturn false\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\t// The job outlives the request\n")
+	b.WriteString("\tjobCtx := *ctx\n")
+	b.WriteString("\tjobCtx.Job = job\n")
+	b.WriteString("\tjobCtx.Writer = jobResponse{header: http.Header{}}\n")
+	b.WriteString("\tjobCtx.Request = ctx.Request.WithContext(jobsCtx)\n")
+	b.WriteString("\tjobCtx.DB = ctx.DB.WithContext(jobsCtx)\n")
+	b.WriteString("\trunningJobs.Add(1)\n")
+	b.WriteString("\trunningJobs.ids.Store(job.ID, true)\n")
+	b.WriteString("\tgo runJob(&jobCtx, run)\n\n")
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/html; charset=utf-8\")\n")
+	b.WriteString("\tw.WriteHeader(http.StatusAccepted)\n")
+	b.WriteString("\twriteJob(w, job)\n")
+	b.WriteString("\treturn true\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// runJob runs the function of a job and records its outcome\n")
+	b.WriteString("func runJob(ctx *GMXContext, run func(ctx *GMXContext) error) {\n")
+	b.WriteString("\tjob := ctx.Job\n")
+	b.WriteString("\tdefer runningJobs.Done()\n")
+	b.WriteString("\tdefer runningJobs.ids.Delete(job.ID)\n")
+	if hold := g.genHoldDB(file, "\t"); hold != "" {
+		b.WriteString(hold)
+		b.WriteString("\t// The database is the one in use when the job runs, not when it started\n")
+		if hasSession && g.hasActivityFeed(file) {
+			b.WriteString("\tctx.DB = db.WithContext(jobsCtx).Set(activityActorKey, ctx.User).Session(&gorm.Session{})\n")
+		} else {
+			b.WriteString("\tctx.DB = db.WithContext(jobsCtx)\n")
+		}
+	}
+	b.WriteString("\n")
+	b.WriteString("\terr := func() (err error) {\n")
+	b.WriteString("\t\t// A panicking job fails alone instead of stopping the server\n")
+	b.WriteString("\t\tdefer func() {\n")
+	b.WriteString("\t\t\tif p := recover(); p != nil {\n")
+	b.WriteString("\t\t\t\terr = fmt.Errorf(\"panic: %v\", p)\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t}()\n")
+	b.WriteString("\t\treturn run(ctx)\n")
+	b.WriteString("\t}()\n\n")
+	b.WriteString("\t// Validation errors are shown to the user, others are logged\n")
+	b.WriteString("\tvar verr *ValidationError\n")
+	b.WriteString("\tswitch {\n")
+	b.WriteString("\tcase errors.As(err, &verr):\n")
+	b.WriteString("\t\tjob.Status, job.Message = \"failed\", verr.Message\n")
+	b.WriteString("\tcase err != nil:\n")
+	b.WriteString("\t\tctx.Log.Error(\"job failed\", \"job\", job.Name, \"id\", job.ID, \"error\", err)\n")
+	b.WriteString("\t\tjob.Status, job.Message = \"failed\", \"The job failed\"\n")
+	b.WriteString("\tcase jobsCtx.Err() != nil:\n")
+	b.WriteString("\t\t// The function returned early from ctx.cancelled()\n")
+	b.WriteString("\t\tjob.Status, job.Message = \"failed\", \"The job was interrupted\"\n")
+	b.WriteString("\tdefault:\n")
+	b.WriteString("\t\tjob.Status, job.Percent = \"done\", 100\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif err := db.Save(job).Error; err != nil {\n")
+	b.WriteString("\t\tctx.Log.Error(\"job: recording its outcome\", \"job\", job.Name, \"id\", job.ID, \"error\", err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// writeJob writes the fragment of a job: a progress bar polling its state\n")
+	b.WriteString("// while it runs, then its outcome\n")
+	b.WriteString("func writeJob(w http.ResponseWriter, job *Job) {\n")
+	b.WriteString("\tswitch job.Status {\n")
+	b.WriteString("\tcase \"running\":\n")
+	b.WriteString(fmt.Sprintf("\t\tfmt.Fprintf(w, `<div class=\"gmx-job\" hx-get=\"%s%%s\" hx-trigger=\"every %s\" hx-swap=\"outerHTML\" role=\"status\"><progress max=\"100\" value=\"%%d\">%%d%%%%</progress></div>`, job.ID, job.Percent, job.Percent)\n", jobsPathPrefix, jobPollInterval))
+	b.WriteString("\tcase \"failed\":\n")
+	b.WriteString("\t\tfmt.Fprintf(w, `<div class=\"gmx-job gmx-job-failed\" role=\"alert\">%s</div>`, template.HTMLEscapeString(job.Message))\n")
+	b.WriteString("\tdefault:\n")
+	b.WriteString("\t\tfmt.Fprint(w, `<div class=\"gmx-job gmx-job-done\" role=\"status\"><progress max=\"100\" value=\"100\">100%</progress></div>`)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// handleJobStatus answers the fragment of a job; a finished job triggers the\n")
+	b.WriteString("// gmx:job-done or gmx:job-failed event, which lets the page refresh its data\n")
+	b.WriteString("func handleJobStatus(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString("\tif r.Method != http.MethodGet {\n")
+	b.WriteString("\t\thttp.Error(w, \"Method Not Allowed\", http.StatusMethodNotAllowed)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tvar job Job\n")
+	b.WriteString("\tif err := db.Where(\"id = ?\", r.PathValue(\"id\")).Limit(1).Find(&job).Error; err != nil {\n")
+	b.WriteString("\t\trequestLogger(r).Error(\"jobs: loading a job\", \"id\", r.PathValue(\"id\"), \"error\", err)\n")
+	b.WriteString("\t\thttp.Error(w, \"Internal Server Error\", http.StatusInternalServerError)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	if hasSession {
+		b.WriteString("\t// The jobs started by a signed-in user are theirs only\n")
+		b.WriteString("\tif job.ID == \"\" || job.Owner != \"\" && job.Owner != readSession(r).User {\n")
+	} else {
+		b.WriteString("\tif job.ID == \"\" {\n")
+	}
+	b.WriteString("\t\thttp.NotFound(w, r)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif job.Status != \"running\" {\n")
+	b.WriteString("\t\tw.Header().Set(\"HX-Trigger\", fmt.Sprintf(`{\"gmx:job-%s\": {\"name\": %q}}`, job.Status, job.Name))\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/html; charset=utf-8\")\n")
+	b.WriteString("\twriteJob(w, &job)\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// cleanupJobs deletes the jobs finished for jobTTL, hourly\n")
+	b.WriteString("func cleanupJobs() {\n")
+	b.WriteString("\tfor {\n")
+	b.WriteString("\t\tdeleteFinishedJobs()\n")
+	b.WriteString("\t\ttime.Sleep(time.Hour)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+	b.WriteString("// deleteFinishedJobs deletes the jobs finished for jobTTL\n")
+	b.WriteString("func deleteFinishedJobs() {\n")
+	b.WriteString(g.genHoldDB(file, "\t"))
+	b.WriteString("\tif err := db.Where(\"status <> ? AND updated_at < ?\", \"running\", time.Now().Add(-jobTTL)).Delete(&Job{}).Error; err != nil {\n")
+	b.WriteString("\t\tslog.Error(\"jobs: deleting finished jobs\", \"error\", err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// stopJobs cancels the running jobs and waits for them until the shutdown\n")
+	b.WriteString("// deadline; the jobs still running then are recorded as interrupted\n")
+	b.WriteString("func stopJobs(deadline context.Context) {\n")
+	b.WriteString("\tcancelJobs()\n")
+	b.WriteString("\tdone := make(chan struct{})\n")
+	b.WriteString("\tgo func() {\n")
+	b.WriteString("\t\trunningJobs.Wait()\n")
+	b.WriteString("\t\tclose(done)\n")
+	b.WriteString("\t}()\n")
+	b.WriteString("\tselect {\n")
+	b.WriteString("\tcase <-done:\n")
+	b.WriteString("\tcase <-deadline.Done():\n")
+	b.WriteString("\t\tvar ids []string\n")
+	b.WriteString("\t\trunningJobs.ids.Range(func(id, _ any) bool {\n")
+	b.WriteString("\t\t\tids = append(ids, id.(string))\n")
+	b.WriteString("\t\t\treturn true\n")
+	b.WriteString("\t\t})\n")
+	b.WriteString("\t\tinterrupted := map[string]any{\"status\": \"failed\", \"message\": \"The job was interrupted\"}\n")
+	b.WriteString("\t\tif err := db.Model(&Job{}).Where(\"id IN ?\", ids).Updates(interrupted).Error; err != nil {\n")
+	b.WriteString("\t\t\tslog.Error(\"jobs: recording interrupted jobs\", \"error\", err)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
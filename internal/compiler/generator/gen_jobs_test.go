@@ -0,0 +1,89 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+)
+
+const jobsScriptSrc = `model Task {
+  id: uuid @pk @default(uuid_v4)
+  title: string
+}
+@async
+func importTasks(title: string, count: int) error {
+  const task = Task{title: title}
+  try task.save()
+  job.progress(50)
+  return nil
+}`
+
+const jobsTemplateSrc = `<form hx-post="{{route "importTasks"}}" hx-target="#status"></form><div id="status"></div>`
+
+func TestGenerateJobs(t *testing.T) {
+	code, err := New().Generate(scriptTestFile(t, jobsScriptSrc, jobsTemplateSrc))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, want := range []string{
+		"type Job struct {",
+		"db.AutoMigrate(&Task{}, &Job{})",
+		"go cleanupJobs()",
+		`mux.HandleFunc("/_gmx/jobs/{id}", handleJobStatus)`,
+		// The handler starts the job instead of calling the function
+		"if !startJob(w, ctx, \"importTasks\", func(ctx *GMXContext) error {\n\t\treturn importTasks(ctx, title, countInt)\n\t}) {",
+		"ctx.Job.Progress(50)",
+		"func (job *Job) Progress(percent int) {",
+		// The fragment polls while the job runs
+		`hx-get="/_gmx/jobs/%s" hx-trigger="every 1s"`,
+		"w.WriteHeader(http.StatusAccepted)",
+		// Running jobs are waited for on shutdown
+		"stopJobs(shutdownCtx)",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+	// Anonymous jobs are reached by their id alone
+	if strings.Contains(code, "job.Owner != readSession(r).User") {
+		t.Errorf("jobs without a session service should not check their owner")
+	}
+}
+
+func TestGenerateJobsSession(t *testing.T) {
+	src := "service Auth {\n  provider: \"session\"\n  secret: string @env(\"SESSION_SECRET\")\n}\n" + jobsScriptSrc
+	code, err := New().Generate(scriptTestFile(t, src, jobsTemplateSrc))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !strings.Contains(code, `if job.ID == "" || job.Owner != "" && job.Owner != readSession(r).User {`) {
+		t.Errorf("the jobs of a signed-in user should be theirs only")
+	}
+}
+
+func TestValidateJobs(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{"arguments", strings.Replace(jobsScriptSrc, "@async", "@async(fast)", 1), "@async takes no arguments"},
+		{"utility", strings.Replace(jobsScriptSrc, "count: int) error", "count: int) string", 1), "@async applies to handlers"},
+		{"timeout", strings.Replace(jobsScriptSrc, "@async", "@async\n@timeout(3s)", 1), "check ctx.cancelled() instead of @timeout"},
+		{"negotiate", strings.Replace(jobsScriptSrc, "@async", "@async\n@negotiate", 1), "not JSON"},
+		{"model", strings.Replace(jobsScriptSrc, "model Task", "model Job", 1), "model Job collides with the model storing the jobs"},
+		{"endpoint", jobsScriptSrc + "\nfunc jobStatus() error {\n  return nil\n}", "function jobStatus collides with the built-in /_gmx/jobs/{id} endpoint"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New().Generate(scriptTestFile(t, tt.src, jobsTemplateSrc))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
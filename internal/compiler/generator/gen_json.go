@@ -0,0 +1,87 @@
+package generator
+
+import (
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"strings"
+)
+
+// isJSONField reports whether a field holds free-form JSON attributes (meta: json)
+func isJSONField(field *ast.FieldDecl) bool {
+	return field.Type == "json"
+}
+
+// hasJSONField checks if any model declares a json field
+func (g *Generator) hasJSONField(file *ast.GMXFile) bool {
+	return g.hasFieldMatch(file, isJSONField)
+}
+
+// genJSONHelpers generates the JSON type of json fields: a map stored as
+// JSONB on PostgreSQL and JSON elsewhere, readable key by key from scripts
+// (task.meta["color"]) and templates ({{.Meta.color}})
+func (g *Generator) genJSONHelpers() string {
+	var b strings.Builder
+
+	b.WriteString("// JSON holds free-form attributes of a json field\n")
+	b.WriteString("type JSON map[string]any\n\n")
+
+	b.WriteString("// Set writes a key, allocating the map of a record that has none yet\n")
+	b.WriteString("func (j *JSON) Set(key string, value any) {\n")
+	b.WriteString("\tif *j == nil {\n")
+	b.WriteString("\t\t*j = JSON{}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\t(*j)[key] = value\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// Scan implements sql.Scanner; NULL scans as a nil map\n")
+	b.WriteString("func (j *JSON) Scan(value any) error {\n")
+	b.WriteString("\tvar data []byte\n")
+	b.WriteString("\tswitch v := value.(type) {\n")
+	b.WriteString("\tcase nil:\n")
+	b.WriteString("\t\t*j = nil\n")
+	b.WriteString("\t\treturn nil\n")
+	b.WriteString("\tcase []byte:\n")
+	b.WriteString("\t\tdata = v\n")
+	b.WriteString("\tcase string:\n")
+	b.WriteString("\t\tdata = []byte(v)\n")
+	b.WriteString("\tdefault:\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"scanning JSON: unsupported type %T\", value)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn json.Unmarshal(data, j)\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// Value implements driver.Valuer\n")
+	b.WriteString("func (j JSON) Value() (driver.Value, error) {\n")
+	b.WriteString("\tif j == nil {\n")
+	b.WriteString("\t\treturn nil, nil\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tdata, err := json.Marshal(j)\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\treturn nil, fmt.Errorf(\"encoding JSON: %w\", err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn string(data), nil\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// GormDataType declares the map as a column rather than a relation\n")
+	b.WriteString("func (JSON) GormDataType() string {\n")
+	b.WriteString("\treturn \"json\"\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// GormDBDataType stores json fields as JSONB on PostgreSQL\n")
+	b.WriteString("func (JSON) GormDBDataType(db *gorm.DB, field *schema.Field) string {\n")
+	b.WriteString("\tif db.Dialector.Name() == \"postgres\" {\n")
+	b.WriteString("\t\treturn \"JSONB\"\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn \"JSON\"\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// jsonString encodes a value for templates: {{json .Meta}} → {\"color\":\"red\"}\n")
+	b.WriteString("func jsonString(v any) (string, error) {\n")
+	b.WriteString("\tdata, err := json.Marshal(v)\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\treturn \"\", err\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn string(data), nil\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
@@ -0,0 +1,73 @@
+package generator
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+func TestGenerateJSONField(t *testing.T) {
+	file := &ast.GMXFile{
+		Models: []*ast.ModelDecl{
+			{
+				Name: "Task",
+				Fields: []*ast.FieldDecl{
+					{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{{Name: "pk"}}},
+					{Name: "meta", Type: "json"},
+				},
+			},
+		},
+		Template: &ast.TemplateBlock{Source: `{{range .Tasks}}<li data-meta="{{json .Meta}}">{{.Meta.color}}</li>{{end}}`},
+	}
+
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	if !regexp.MustCompile(`Meta\s+JSON\s+` + "`json:\"meta\"`").MatchString(code) {
+		t.Error("json field should have the JSON type")
+	}
+	if !regexp.MustCompile(`"json":\s+jsonString,`).MatchString(code) {
+		t.Error("templates should get the json helper")
+	}
+
+	expected := []string{
+		"type JSON map[string]any",
+		"func (j *JSON) Set(key string, value any)",
+		"func (j *JSON) Scan(value any) error",
+		"func (j JSON) Value() (driver.Value, error)",
+		`return "JSONB"`,
+		`"database/sql/driver"`,
+		`"encoding/json"`,
+		`"gorm.io/gorm/schema"`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(code, exp) {
+			t.Errorf("Generated code missing %q", exp)
+		}
+	}
+}
+
+func TestGenerateWithoutJSONField(t *testing.T) {
+	file := &ast.GMXFile{
+		Models: []*ast.ModelDecl{
+			{Name: "Task", Fields: []*ast.FieldDecl{{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{{Name: "pk"}}}}},
+		},
+	}
+
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	for _, unexpected := range []string{"type JSON", `"gorm.io/gorm/schema"`, `"database/sql/driver"`} {
+		if strings.Contains(code, unexpected) {
+			t.Errorf("Generated code should not contain %q without json fields", unexpected)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package generator
+
+import (
+	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"strings"
+)
+
+// listElemTypes are the primitive types a list column can hold; other
+// X[] fields are relations to the model X
+var listElemTypes = map[string]bool{
+	"string": true, "int": true, "float": true, "bool": true,
+	"uuid": true, "datetime": true, "password": true, "json": true,
+}
+
+// isListField reports whether a field is a string[] column
+func isListField(field *ast.FieldDecl) bool {
+	return field.Type == "string[]"
+}
+
+// needsStringList checks if the file uses the StringList type, in models or script parameters
+func (g *Generator) needsStringList(file *ast.GMXFile) bool {
+	if g.hasFieldMatch(file, isListField) {
+		return true
+	}
+	if file.Script == nil {
+		return false
+	}
+	for _, fn := range file.Script.Funcs {
+		for _, param := range fn.Params {
+			if param.Type == "string[]" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validateListFields rejects list columns of primitives other than string
+func (g *Generator) validateListFields(file *ast.GMXFile) error {
+	for _, model := range file.Models {
+		for _, field := range model.Fields {
+			elem, isArray := strings.CutSuffix(field.Type, "[]")
+			if isArray && elem != "string" && listElemTypes[elem] {
+				return fmt.Errorf("model %s: field %q: only string[] list columns are supported, got %s", model.Name, field.Name, field.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// genListHelpers generates the StringList type of string[] fields: a text[]
+// array on PostgreSQL and a JSON array elsewhere, filtered with listContains
+func (g *Generator) genListHelpers() string {
+	var b strings.Builder
+
+	b.WriteString("// StringList is a string[] column: text[] on PostgreSQL, a JSON array elsewhere\n")
+	b.WriteString("type StringList []string\n\n")
+
+	b.WriteString("// Contains reports whether the list holds v\n")
+	b.WriteString("func (l StringList) Contains(v string) bool {\n")
+	b.WriteString("\tfor _, s := range l {\n")
+	b.WriteString("\t\tif s == v {\n")
+	b.WriteString("\t\t\treturn true\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn false\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// Scan implements sql.Scanner for both PostgreSQL arrays and JSON arrays\n")
+	b.WriteString("func (l *StringList) Scan(value any) error {\n")
+	b.WriteString("\tvar s string\n")
+	b.WriteString("\tswitch v := value.(type) {\n")
+	b.WriteString("\tcase nil:\n")
+	b.WriteString("\t\t*l = nil\n")
+	b.WriteString("\t\treturn nil\n")
+	b.WriteString("\tcase []byte:\n")
+	b.WriteString("\t\ts = string(v)\n")
+	b.WriteString("\tcase string:\n")
+	b.WriteString("\t\ts = v\n")
+	b.WriteString("\tdefault:\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"scanning string[]: unsupported type %T\", value)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif strings.HasPrefix(s, \"{\") {\n")
+	b.WriteString("\t\tlist, err := parsePGArray(s)\n")
+	b.WriteString("\t\tif err != nil {\n")
+	b.WriteString("\t\t\treturn err\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\t*l = list\n")
+	b.WriteString("\t\treturn nil\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn json.Unmarshal([]byte(s), l)\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// Value implements driver.Valuer with the JSON encoding\n")
+	b.WriteString("func (l StringList) Value() (driver.Value, error) {\n")
+	b.WriteString("\tif l == nil {\n")
+	b.WriteString("\t\treturn nil, nil\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tdata, err := json.Marshal(l)\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\treturn nil, fmt.Errorf(\"encoding string[]: %w\", err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn string(data), nil\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// GormValue writes the list as a text[] literal on PostgreSQL\n")
+	b.WriteString("func (l StringList) GormValue(ctx context.Context, db *gorm.DB) clause.Expr {\n")
+	b.WriteString("\tif db.Dialector.Name() == \"postgres\" {\n")
+	b.WriteString("\t\treturn clause.Expr{SQL: \"CAST(? AS text[])\", Vars: []any{l.pgArray()}}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tv, err := l.Value()\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\tdb.AddError(err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn clause.Expr{SQL: \"?\", Vars: []any{v}}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// GormDataType declares the slice as a column rather than a relation\n")
+	b.WriteString("func (StringList) GormDataType() string {\n")
+	b.WriteString("\treturn \"string[]\"\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// GormDBDataType stores lists as text[] on PostgreSQL and JSON elsewhere\n")
+	b.WriteString("func (StringList) GormDBDataType(db *gorm.DB, field *schema.Field) string {\n")
+	b.WriteString("\tif db.Dialector.Name() == \"postgres\" {\n")
+	b.WriteString("\t\treturn \"text[]\"\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn \"JSON\"\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// pgArray encodes the list as a PostgreSQL array literal: {\"a\",\"b c\"}\n")
+	b.WriteString("func (l StringList) pgArray() string {\n")
+	b.WriteString("\tescape := strings.NewReplacer(`\\`, `\\\\`, `\"`, `\\\"`)\n")
+	b.WriteString("\tquoted := make([]string, len(l))\n")
+	b.WriteString("\tfor i, s := range l {\n")
+	b.WriteString("\t\tquoted[i] = `\"` + escape.Replace(s) + `\"`\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn \"{\" + strings.Join(quoted, \",\") + \"}\"\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// parsePGArray decodes a PostgreSQL text[] literal: {a,\"b c\",NULL}; NULL items are dropped\n")
+	b.WriteString("func parsePGArray(s string) (StringList, error) {\n")
+	b.WriteString("\tif len(s) < 2 || s[0] != '{' || s[len(s)-1] != '}' {\n")
+	b.WriteString("\t\treturn nil, fmt.Errorf(\"scanning string[]: invalid array %q\", s)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tbody := s[1 : len(s)-1]\n")
+	b.WriteString("\tlist := StringList{}\n")
+	b.WriteString("\tif body == \"\" {\n")
+	b.WriteString("\t\treturn list, nil\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tvar item strings.Builder\n")
+	b.WriteString("\tquoted, inQuotes := false, false\n")
+	b.WriteString("\tflush := func() {\n")
+	b.WriteString("\t\tif quoted || item.String() != \"NULL\" {\n")
+	b.WriteString("\t\t\tlist = append(list, item.String())\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\titem.Reset()\n")
+	b.WriteString("\t\tquoted = false\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tfor i := 0; i < len(body); i++ {\n")
+	b.WriteString("\t\tswitch c := body[i]; {\n")
+	b.WriteString("\t\tcase inQuotes && c == '\\\\' && i+1 < len(body):\n")
+	b.WriteString("\t\t\ti++\n")
+	b.WriteString("\t\t\titem.WriteByte(body[i])\n")
+	b.WriteString("\t\tcase c == '\"':\n")
+	b.WriteString("\t\t\tinQuotes = !inQuotes\n")
+	b.WriteString("\t\t\tquoted = true\n")
+	b.WriteString("\t\tcase c == ',' && !inQuotes:\n")
+	b.WriteString("\t\t\tflush()\n")
+	b.WriteString("\t\tdefault:\n")
+	b.WriteString("\t\t\titem.WriteByte(c)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tflush()\n")
+	b.WriteString("\treturn list, nil\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// listContains filters records whose string[] column holds value\n")
+	b.WriteString("func listContains(column, value string) func(*gorm.DB) *gorm.DB {\n")
+	b.WriteString("\treturn func(db *gorm.DB) *gorm.DB {\n")
+	b.WriteString("\t\tswitch db.Dialector.Name() {\n")
+	b.WriteString("\t\tcase \"postgres\":\n")
+	b.WriteString("\t\t\treturn db.Where(\"? = ANY(\"+column+\")\", value)\n")
+	b.WriteString("\t\tcase \"mysql\":\n")
+	b.WriteString("\t\t\treturn db.Where(\"JSON_CONTAINS(\"+column+\", JSON_QUOTE(?))\", value)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\treturn db.Where(\"EXISTS (SELECT 1 FROM json_each(\"+column+\") WHERE json_each.value = ?)\", value)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
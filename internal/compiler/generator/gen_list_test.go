@@ -0,0 +1,71 @@
+package generator
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+func TestGenerateListField(t *testing.T) {
+	file := &ast.GMXFile{
+		Models: []*ast.ModelDecl{
+			{
+				Name: "Task",
+				Fields: []*ast.FieldDecl{
+					{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{{Name: "pk"}}},
+					{Name: "tags", Type: "string[]"},
+				},
+			},
+		},
+		Script: &ast.ScriptBlock{
+			Funcs: []*ast.FuncDecl{
+				{Name: "createTask", Params: []*ast.Param{{Name: "tags", Type: "string[]"}}, Body: []ast.Statement{}},
+			},
+		},
+	}
+
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	if !regexp.MustCompile(`Tags\s+StringList\s+` + "`json:\"tags\"`").MatchString(code) {
+		t.Error("string[] field should have the StringList type")
+	}
+
+	expected := []string{
+		"type StringList []string",
+		"func (l *StringList) Scan(value any) error",
+		"func (l StringList) GormValue(ctx context.Context, db *gorm.DB) clause.Expr",
+		`return "text[]"`,
+		"func parsePGArray(s string) (StringList, error)",
+		"func listContains(column, value string) func(*gorm.DB) *gorm.DB",
+		`tags := StringList(r.Form["tags"])`,
+		"func createTask(ctx *GMXContext, tags StringList) error",
+		`"gorm.io/gorm/clause"`,
+		`"context"`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(code, exp) {
+			t.Errorf("Generated code missing %q", exp)
+		}
+	}
+}
+
+func TestGenerateListFieldErrors(t *testing.T) {
+	file := &ast.GMXFile{
+		Models: []*ast.ModelDecl{
+			{Name: "Task", Fields: []*ast.FieldDecl{{Name: "scores", Type: "int[]"}}},
+		},
+	}
+
+	_, err := New().Generate(file)
+	if err == nil || !strings.Contains(err.Error(), "only string[] list columns are supported") {
+		t.Errorf("expected an unsupported list error, got %v", err)
+	}
+}
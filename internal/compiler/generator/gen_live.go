@@ -0,0 +1,252 @@
+package generator
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// liveEventsPrefix is the prefix of the server-sent events endpoints of the
+// @live models, followed by the model name
+const liveEventsPrefix = "/events/"
+
+// liveSSEScript loads the htmx extension receiving server-sent events
+const liveSSEScript = `<script src="https://unpkg.com/htmx-ext-sse@2.2.2/sse.js"></script>`
+
+// liveRootTag matches the opening tag of the root element of a list item
+var liveRootTag = regexp.MustCompile(`^\s*<([a-zA-Z][a-zA-Z0-9-]*)[^>]*>`)
+
+// liveIDAttr matches an id attribute in an opening tag
+var liveIDAttr = regexp.MustCompile(`\sid\s*=`)
+
+// liveWrappers are the elements wrapping a created item of the given root
+// element when it is sent: the HTML parser drops table rows and cells outside
+// a table, and options outside a select
+var liveWrappers = map[string]string{"tr": "tbody", "td": "tr", "th": "tr", "li": "ul", "option": "select"}
+
+// liveModels returns the models with a @live annotation
+func liveModels(file *ast.GMXFile) []*ast.ModelDecl {
+	var models []*ast.ModelDecl
+	for _, model := range file.Models {
+		if model.FindAnnotation("live") != nil {
+			models = append(models, model)
+		}
+	}
+	return models
+}
+
+// hasLive checks if a model is updated live on the open pages
+func (g *Generator) hasLive(file *ast.GMXFile) bool {
+	return len(liveModels(file)) > 0
+}
+
+// liveRoutes returns the server-sent events endpoints of the @live models
+func liveRoutes(file *ast.GMXFile) []Route {
+	var routes []Route
+	for _, model := range liveModels(file) {
+		routes = append(routes, Route{Method: "GET", Path: liveEventsPrefix + model.Name, Handler: "handle" + model.Name + "Events"})
+	}
+	return routes
+}
+
+// pageTemplateSources returns the sources of the page templates of the file
+func pageTemplateSources(file *ast.GMXFile) []string {
+	var sources []string
+	for _, page := range file.Pages {
+		sources = append(sources, page.Template.Source)
+	}
+	if len(file.Pages) == 0 && file.Template != nil {
+		sources = append(sources, file.Template.Source)
+	}
+	return sources
+}
+
+// liveItem returns the list item body of a @live model in the page templates
+func liveItem(file *ast.GMXFile, model *ast.ModelDecl) (string, bool) {
+	for _, src := range pageTemplateSources(file) {
+		if _, bodyStart, bodyEnd, ok := findRangeBlock(src, "{{range ."+model.Name+"s}}"); ok {
+			return src[bodyStart:bodyEnd], true
+		}
+	}
+	return "", false
+}
+
+// validateLive checks that the changes of each @live model can be sent to
+// every page: its list items are identified by its @pk, and nothing hides
+// some records from some users
+func (g *Generator) validateLive(file *ast.GMXFile) error {
+	for _, model := range liveModels(file) {
+		pk := modelPKField(model)
+		if pk == nil || (pk.Type != "uuid" && pk.Type != "string" && pk.Type != "int") {
+			return fmt.Errorf("model %s: @live requires a uuid, string or int @pk field, which identifies its list items", model.Name)
+		}
+		if model.Policy != nil && model.Policy.FindRule("read") != nil {
+			return fmt.Errorf("model %s: @live sends every change to every page, which its read policy forbids", model.Name)
+		}
+		if g.hasRowLevelSecurity(file) {
+			return fmt.Errorf("model %s: @live sends every change to every page, across the tenants of row-level security", model.Name)
+		}
+		item, ok := liveItem(file, model)
+		if !ok {
+			return fmt.Errorf("model %s: @live updates the {{range .%ss}} list of the page, which the template does not have", model.Name, model.Name)
+		}
+		root := liveRootTag.FindString(item)
+		if root == "" {
+			return fmt.Errorf("model %s: @live requires the items of {{range .%ss}} to be a single element", model.Name, model.Name)
+		}
+		if liveIDAttr.MatchString(root) {
+			return fmt.Errorf("model %s: @live identifies the items of {{range .%ss}}; remove the id of their root element", model.Name, model.Name)
+		}
+	}
+	if file.Script != nil {
+		for _, fn := range file.Script.Funcs {
+			for _, route := range liveRoutes(file) {
+				if "handle"+utils.Capitalize(fn.Name) == route.Handler {
+					return fmt.Errorf("line %d: function %s collides with the built-in %s endpoint; rename it", fn.Line, fn.Name, route.Path)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// injectLiveID identifies the list item of a @live model by its @pk, so that
+// its updates replace it on the other pages
+func injectLiveID(body string, model *ast.ModelDecl) string {
+	loc := liveRootTag.FindStringSubmatchIndex(body)
+	if loc == nil {
+		return body
+	}
+	id := fmt.Sprintf(` id="gmx-%s-{{.%s}}"`, model.Name, utils.ToPascalCase(modelPKField(model).Name))
+	return body[:loc[3]] + id + body[loc[3]:]
+}
+
+// injectLiveConnections connects the page to the events of the @live models
+// before </body>, loading the htmx extension receiving them
+func injectLiveConnections(src string, models []*ast.ModelDecl) string {
+	var b strings.Builder
+	b.WriteString(liveSSEScript + "\n")
+	for _, model := range models {
+		// The items carry their own out-of-band swaps
+		b.WriteString(fmt.Sprintf(`<div hx-ext="sse" sse-connect="%s%s" sse-swap="%s" hx-swap="none" hidden></div>`+"\n", liveEventsPrefix, model.Name, model.Name))
+	}
+	if idx := strings.LastIndex(strings.ToLower(src), "</body>"); idx != -1 {
+		return src[:idx] + b.String() + src[idx:]
+	}
+	return src + "\n" + b.String()
+}
+
+// genLive generates the broadcasts of the changes of the @live models,
+// called by their Save and Delete helpers, and their events endpoints
+func (g *Generator) genLive(file *ast.GMXFile) string {
+	var b strings.Builder
+
+	b.WriteString("// liveWrappers wrap the created items of each @live model: out-of-band\n")
+	b.WriteString("// swaps insert the content of their element\n")
+	b.WriteString("var liveWrappers = map[string]string{\n")
+	for _, model := range liveModels(file) {
+		item, _ := liveItem(file, model) // validated in validateLive
+		wrapper := liveWrappers[strings.ToLower(liveRootTag.FindStringSubmatch(item)[1])]
+		if wrapper == "" {
+			wrapper = "div"
+		}
+		b.WriteString(fmt.Sprintf("\t%q: %q,\n", model.Name, wrapper))
+	}
+	b.WriteString("}\n\n")
+
+	b.WriteString("// liveStreams holds the open events streams of each @live model\n")
+	b.WriteString("var liveStreams struct {\n")
+	b.WriteString("\tsync.Mutex\n")
+	b.WriteString("\tbyModel map[string]map[chan string]bool\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// publishLive sends a message to the open events streams of model; a stream\n")
+	b.WriteString("// too slow to keep up misses it rather than blocking the change\n")
+	b.WriteString("func publishLive(model, message string) {\n")
+	b.WriteString("\tliveStreams.Lock()\n")
+	b.WriteString("\tdefer liveStreams.Unlock()\n")
+	b.WriteString("\tfor stream := range liveStreams.byModel[model] {\n")
+	b.WriteString("\t\tselect {\n")
+	b.WriteString("\t\tcase stream <- message:\n")
+	b.WriteString("\t\tdefault:\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// broadcastLive sends a saved record to the open pages: a created item is\n")
+	b.WriteString("// inserted at the end of their list, an updated one replaces its previous version\n")
+	b.WriteString("func broadcastLive(model string, obj any, created bool) {\n")
+	b.WriteString("\tvar buf strings.Builder\n")
+	b.WriteString("\tif err := tmpl.ExecuteTemplate(&buf, model, obj); err != nil {\n")
+	b.WriteString("\t\tslog.Error(\"live: rendering\", \"model\", model, \"error\", err)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\titem := strings.TrimSpace(buf.String())\n")
+	b.WriteString("\tif created {\n")
+	b.WriteString("\t\twrapper := liveWrappers[model]\n")
+	b.WriteString("\t\tpublishLive(model, fmt.Sprintf(`<template><%s hx-swap-oob=\"beforebegin:#gmx-live-%s\">%s</%s></template>`, wrapper, model, item, wrapper))\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tid := ` id=\"gmx-` + model + \"-\"\n")
+	b.WriteString("\tpublishLive(model, \"<template>\"+strings.Replace(item, id, ` hx-swap-oob=\"true\"`+id, 1)+\"</template>\")\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// broadcastLiveDelete removes a deleted record from the open pages\n")
+	b.WriteString("func broadcastLiveDelete(model, id string) {\n")
+	b.WriteString("\tpublishLive(model, fmt.Sprintf(`<template><div id=\"gmx-%s-%s\" hx-swap-oob=\"delete\"></div></template>`, model, template.HTMLEscapeString(id)))\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// streamLive sends the changes of model as server-sent events named after it\n")
+	b.WriteString("func streamLive(w http.ResponseWriter, r *http.Request, model string) {\n")
+	b.WriteString("\tif r.Method != http.MethodGet {\n")
+	b.WriteString("\t\thttp.Error(w, \"Method Not Allowed\", http.StatusMethodNotAllowed)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tflusher, ok := w.(http.Flusher)\n")
+	b.WriteString("\tif !ok {\n")
+	b.WriteString("\t\thttp.Error(w, \"Streaming unsupported\", http.StatusInternalServerError)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\tstream := make(chan string, 16)\n")
+	b.WriteString("\tliveStreams.Lock()\n")
+	b.WriteString("\tif liveStreams.byModel == nil {\n")
+	b.WriteString("\t\tliveStreams.byModel = make(map[string]map[chan string]bool)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif liveStreams.byModel[model] == nil {\n")
+	b.WriteString("\t\tliveStreams.byModel[model] = make(map[chan string]bool)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tliveStreams.byModel[model][stream] = true\n")
+	b.WriteString("\tliveStreams.Unlock()\n")
+	b.WriteString("\tdefer func() {\n")
+	b.WriteString("\t\tliveStreams.Lock()\n")
+	b.WriteString("\t\tdelete(liveStreams.byModel[model], stream)\n")
+	b.WriteString("\t\tliveStreams.Unlock()\n")
+	b.WriteString("\t}()\n\n")
+	b.WriteString(genStreamDeadline(file))
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/event-stream\")\n")
+	b.WriteString("\tw.Header().Set(\"Cache-Control\", \"no-cache\")\n")
+	b.WriteString("\tflusher.Flush()\n")
+	b.WriteString("\tfor {\n")
+	b.WriteString("\t\tselect {\n")
+	b.WriteString("\t\tcase <-r.Context().Done():\n")
+	b.WriteString("\t\t\treturn\n")
+	b.WriteString("\t\tcase message := <-stream:\n")
+	b.WriteString("\t\t\t// Each line of the message is a data line of the event\n")
+	b.WriteString("\t\t\tfmt.Fprintf(w, \"event: %s\\ndata: %s\\n\\n\", model, strings.ReplaceAll(message, \"\\n\", \"\\ndata: \"))\n")
+	b.WriteString("\t\t\tflusher.Flush()\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	for _, model := range liveModels(file) {
+		b.WriteString(fmt.Sprintf("// handle%sEvents streams the changes of the %s records to the open pages\n", model.Name, model.Name))
+		b.WriteString(fmt.Sprintf("func handle%sEvents(w http.ResponseWriter, r *http.Request) {\n", model.Name))
+		b.WriteString(fmt.Sprintf("\tstreamLive(w, r, %q)\n", model.Name))
+		b.WriteString("}\n\n")
+	}
+
+	return b.String()
+}
@@ -0,0 +1,96 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+)
+
+const liveScript = `@live
+model Task {
+  id: uuid @pk @default(uuid_v4)
+  title: string
+}
+func createTask(title: string) error {
+  const task = Task{title: title}
+  try task.save()
+  return render(task)
+}`
+
+func TestGenerateLive(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     []string
+	}{
+		{"list", `<ul>{{range .Tasks}}<li class="task">{{.Title}}</li>{{end}}</ul>`, []string{
+			// Items are identified, created ones are inserted before the marker
+			`{{define "Task"}}<li id="gmx-Task-{{.ID}}" class="task">{{.Title}}</li>{{end}}`,
+			`<ul>{{range .Tasks}}{{renderItem "Task" .}}{{end}}<template id="gmx-live-Task"></template></ul>`,
+			`<div hx-ext="sse" sse-connect="/events/Task" sse-swap="Task" hx-swap="none" hidden></div>`,
+			`"Task": "ul",`,
+		}},
+		{"table", "<table>{{range .Tasks}}\n  <tr><td>{{.Title}}</td></tr>\n{{end}}</table>", []string{
+			`<tr id="gmx-Task-{{.ID}}"><td>{{.Title}}</td></tr>`,
+			`"Task": "tbody",`,
+		}},
+		{"cards", `{{range .Tasks}}<article>{{if .Title}}{{.Title}}{{end}}</article>{{end}}`, []string{
+			`<article id="gmx-Task-{{.ID}}">{{if .Title}}{{.Title}}{{end}}</article>`,
+			`"Task": "div",`,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := scriptTestFile(t, liveScript, signedTemplateSrc)
+			file.Template.Source = tt.template
+			code, err := New().Generate(file)
+			if err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+			if !isValidGo(code) {
+				t.Errorf("Generated code is not valid Go:\n%s", code)
+			}
+
+			want := append(tt.want,
+				`mux.HandleFunc("/events/Task", handleTaskEvents)`,
+				`broadcastLive("Task", obj, created)`,
+				`broadcastLiveDelete("Task", fmt.Sprint(obj.ID))`,
+				`w.Header().Set("Content-Type", "text/event-stream")`,
+				"htmx-ext-sse",
+			)
+			for _, w := range want {
+				if !strings.Contains(code, w) {
+					t.Errorf("generated code missing %q", w)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateLive(t *testing.T) {
+	const task = "@live\nmodel Task {\n  id: uuid @pk\n  title: string\n}\n"
+	tests := []struct {
+		name     string
+		src      string
+		template string
+		wantErr  string
+	}{
+		{"no list", task, `<p>{{.CSRFToken}}</p>`, "model Task: @live updates the {{range .Tasks}} list of the page, which the template does not have"},
+		{"text items", task, `{{range .Tasks}}{{.Title}}, {{end}}`, "model Task: @live requires the items of {{range .Tasks}} to be a single element"},
+		{"item id", task, `{{range .Tasks}}<p id="t-{{.ID}}">{{.Title}}</p>{{end}}`, "remove the id of their root element"},
+		{"no pk", "@live\nmodel Task {\n  title: string\n}\n", `{{range .Tasks}}<p>{{.Title}}</p>{{end}}`, "model Task: @live requires a uuid, string or int @pk field"},
+		{"read policy", task + "policy Task {\n  read: true\n}\n", `{{range .Tasks}}<p>{{.Title}}</p>{{end}}`, "model Task: @live sends every change to every page, which its read policy forbids"},
+		{"func", task + "func taskEvents() error {\n  return nil\n}", `{{range .Tasks}}<p>{{.Title}}</p>{{end}}`, "line 6: function taskEvents collides with the built-in /events/Task endpoint"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := scriptTestFile(t, tt.src, signedTemplateSrc)
+			file.Template.Source = tt.template
+			_, err := New().Generate(file)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// loadShedFields lists the config fields a load-shedding service must declare
+var loadShedFields = []string{"limit", "queue", "wait"}
+
+// healthPath is the health check endpoint, served ahead of shed requests
+const healthPath = "/healthz"
+
+// findLoadShedService returns the service using the "loadshed" provider, if any
+func (g *Generator) findLoadShedService(services []*ast.ServiceDecl) *ast.ServiceDecl {
+	for _, svc := range services {
+		if svc.Provider == "loadshed" {
+			return svc
+		}
+	}
+	return nil
+}
+
+// validateLoadShedService checks that a load-shedding service declares its limits
+func (g *Generator) validateLoadShedService(file *ast.GMXFile) error {
+	svc := g.findLoadShedService(file.Services)
+	if svc == nil {
+		return nil
+	}
+	for _, name := range loadShedFields {
+		if findServiceField(svc, name) == nil {
+			return fmt.Errorf("service %s: loadshed provider requires a `%s` field", svc.Name, name)
+		}
+	}
+	return nil
+}
+
+// genLoadShedder generates the load-shedding middleware: at most `limit`
+// requests are served at once, up to `queue` more wait for a slot during
+// `wait`, and the rest are answered 503 with Retry-After
+func (g *Generator) genLoadShedder(svc *ast.ServiceDecl) string {
+	var b strings.Builder
+
+	b.WriteString("// loadShedder bounds the requests in flight and the time spent queuing for a slot\n")
+	b.WriteString("type loadShedder struct {\n")
+	b.WriteString("\tslots      chan struct{}\n")
+	b.WriteString("\tqueued     atomic.Int64\n")
+	b.WriteString("\tqueue      int64\n")
+	b.WriteString("\twait       time.Duration\n")
+	b.WriteString("\tretryAfter string\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString(fmt.Sprintf("// new%sShedder builds the load shedder configured by the %s service\n", svc.Name, svc.Name))
+	b.WriteString(fmt.Sprintf("func new%sShedder(cfg *%sConfig) *loadShedder {\n", svc.Name, svc.Name))
+	b.WriteString("\tlimit, err := strconv.Atoi(cfg.Limit)\n")
+	b.WriteString("\tif err != nil || limit < 1 {\n")
+	b.WriteString("\t\tlog.Fatalf(\"invalid load-shedding limit %q: expected a positive count\", cfg.Limit)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tqueue, err := strconv.Atoi(cfg.Queue)\n")
+	b.WriteString("\tif err != nil || queue < 0 {\n")
+	b.WriteString("\t\tlog.Fatalf(\"invalid load-shedding queue %q: expected a count\", cfg.Queue)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\twait, err := time.ParseDuration(cfg.Wait)\n")
+	b.WriteString("\tif err != nil || wait < 0 {\n")
+	b.WriteString("\t\tlog.Fatalf(\"invalid load-shedding wait %q: expected a duration such as 500ms\", cfg.Wait)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\t// Clients retry once a full queue has had time to drain\n")
+	b.WriteString("\tretryAfter := int((wait + time.Second - 1) / time.Second)\n")
+	b.WriteString("\tif retryAfter < 1 {\n")
+	b.WriteString("\t\tretryAfter = 1\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn &loadShedder{\n")
+	b.WriteString("\t\tslots:      make(chan struct{}, limit),\n")
+	b.WriteString("\t\tqueue:      int64(queue),\n")
+	b.WriteString("\t\twait:       wait,\n")
+	b.WriteString("\t\tretryAfter: strconv.Itoa(retryAfter),\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// middleware admits a request once a slot is free, and sheds it when the queue\n")
+	b.WriteString("// is full or the wait expires; health checks are always served\n")
+	b.WriteString("func (s *loadShedder) middleware(next http.Handler) http.Handler {\n")
+	b.WriteString("\treturn http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString(fmt.Sprintf("\t\tif r.URL.Path == %q {\n", healthPath))
+	b.WriteString("\t\t\tnext.ServeHTTP(w, r)\n")
+	b.WriteString("\t\t\treturn\n")
+	b.WriteString("\t\t}\n\n")
+	b.WriteString("\t\tselect {\n")
+	b.WriteString("\t\tcase s.slots <- struct{}{}:\n")
+	b.WriteString("\t\tdefault:\n")
+	b.WriteString("\t\t\tif !s.enqueue(r) {\n")
+	b.WriteString("\t\t\t\tw.Header().Set(\"Retry-After\", s.retryAfter)\n")
+	b.WriteString("\t\t\t\thttp.Error(w, \"Service Unavailable\", http.StatusServiceUnavailable)\n")
+	b.WriteString("\t\t\t\treturn\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tdefer func() { <-s.slots }()\n")
+	b.WriteString("\t\tnext.ServeHTTP(w, r)\n")
+	b.WriteString("\t})\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// enqueue waits for a slot and reports whether one was taken before the\n")
+	b.WriteString("// wait expired; a full queue or a departed client gives up at once\n")
+	b.WriteString("func (s *loadShedder) enqueue(r *http.Request) bool {\n")
+	b.WriteString("\tdefer s.queued.Add(-1)\n")
+	b.WriteString("\tif s.queued.Add(1) > s.queue {\n")
+	b.WriteString("\t\treturn false\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\ttimer := time.NewTimer(s.wait)\n")
+	b.WriteString("\tdefer timer.Stop()\n")
+	b.WriteString("\tselect {\n")
+	b.WriteString("\tcase s.slots <- struct{}{}:\n")
+	b.WriteString("\t\treturn true\n")
+	b.WriteString("\tcase <-timer.C:\n")
+	b.WriteString("\t\treturn false\n")
+	b.WriteString("\tcase <-r.Context().Done():\n")
+	b.WriteString("\t\treturn false\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// handleHealth answers health checks, which load shedding never rejects\n")
+	b.WriteString("func handleHealth(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/plain; charset=utf-8\")\n")
+	b.WriteString("\tif _, err := w.Write([]byte(\"ok\\n\")); err != nil {\n")
+	b.WriteString("\t\trequestLogger(r).Error(\"health check\", \"error\", err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// loadShedVar returns the variable holding the load shedder of a service
+func loadShedVar(svc *ast.ServiceDecl) string {
+	return utils.LowerFirst(svc.Name) + "Shedder"
+}
@@ -0,0 +1,69 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+func loadShedTestFile(fields ...string) *ast.GMXFile {
+	svc := &ast.ServiceDecl{Name: "Shedding", Provider: "loadshed"}
+	for _, name := range fields {
+		svc.Fields = append(svc.Fields, &ast.ServiceField{
+			Name:        name,
+			Type:        "string",
+			Annotations: []*ast.Annotation{{Name: "default", Args: map[string]string{"_": "1"}}},
+		})
+	}
+	return &ast.GMXFile{
+		Services: []*ast.ServiceDecl{svc},
+		Template: &ast.TemplateBlock{Source: "<h1>Hello</h1>"},
+	}
+}
+
+func TestGenerator_LoadShedding(t *testing.T) {
+	code, err := New().Generate(loadShedTestFile("limit", "queue", "wait"))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, exp := range []string{
+		"func newSheddingShedder(cfg *SheddingConfig) *loadShedder",
+		"sheddingShedder := newSheddingShedder(sheddingCfg)",
+		`srv := &http.Server{Addr: ":8080", Handler: logRequests(sheddingShedder.middleware(csrfProtect(securityHeaders(mux))))}`,
+		`w.Header().Set("Retry-After", s.retryAfter)`,
+		"http.StatusServiceUnavailable",
+		`if r.URL.Path == "/healthz" {`,
+		`mux.HandleFunc("/healthz", handleHealth)`,
+		`"sync/atomic"`,
+		`"strconv"`,
+		`"time"`,
+	} {
+		if !strings.Contains(code, exp) {
+			t.Errorf("Generated code missing %q", exp)
+		}
+	}
+}
+
+func TestGenerator_NoLoadShedding(t *testing.T) {
+	code, err := New().Generate(&ast.GMXFile{Template: &ast.TemplateBlock{Source: "<h1>Hello</h1>"}})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	for _, unexpected := range []string{"loadShedder", "/healthz", "Retry-After"} {
+		if strings.Contains(code, unexpected) {
+			t.Errorf("Generated code contains %q without a loadshed service", unexpected)
+		}
+	}
+}
+
+func TestGenerator_LoadSheddingMissingField(t *testing.T) {
+	_, err := New().Generate(loadShedTestFile("limit", "queue"))
+	if err == nil || !strings.Contains(err.Error(), "loadshed provider requires a `wait` field") {
+		t.Errorf("expected a missing wait field error, got %v", err)
+	}
+}
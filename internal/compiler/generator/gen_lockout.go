@@ -0,0 +1,323 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// Failed sign-ins locking an account, and the window they are counted in
+// and the account stays locked for, unless the session service sets them
+const (
+	defaultLockoutAttempts = 5
+	defaultLockoutWindow   = "15 * time.Minute"
+)
+
+// lockoutAddressFactor multiplies the failures locking an account into those
+// locking a client address, which may sign in for several accounts
+const lockoutAddressFactor = 4
+
+// lockoutFields are the session fields configuring the lockout of @login functions
+var lockoutFields = []string{"lockoutAttempts", "lockoutWindow"}
+
+// hasLoginLockout checks if a @login function tracks failed sign-ins
+func (g *Generator) hasLoginLockout(file *ast.GMXFile) bool {
+	return g.hasFuncAnnotation(file, "login")
+}
+
+// hasFailureLimiter checks if failures lock accounts or second factors
+func (g *Generator) hasFailureLimiter(file *ast.GMXFile) bool {
+	return g.hasLoginLockout(file) || g.hasTwoFactor(file)
+}
+
+// findLockoutMailer returns the SMTP service sending lockout notices: the
+// first one declaring a send method
+func (g *Generator) findLockoutMailer(file *ast.GMXFile) *ast.ServiceDecl {
+	for _, svc := range file.Services {
+		if svc.Provider != "smtp" {
+			continue
+		}
+		for _, method := range svc.Methods {
+			if method.Name == "send" && method.Body == nil {
+				return svc
+			}
+		}
+	}
+	return nil
+}
+
+// lockoutNoticeMailer returns the SMTP service sending lockout notices, which
+// go to the address stored for the locked account: without an @account model
+// to look accounts up in, no notice is sent
+func (g *Generator) lockoutNoticeMailer(file *ast.GMXFile) *ast.ServiceDecl {
+	if g.findAccountModel(file) == nil {
+		return nil
+	}
+	return g.findLockoutMailer(file)
+}
+
+// validateLoginLockout checks that @login functions name a string parameter
+// identifying the account, and that the lockout fields have a sign-in to lock
+func (g *Generator) validateLoginLockout(file *ast.GMXFile) error {
+	for _, fn := range g.funcsWithAnnotation(file, "login") {
+		account := fn.FindAnnotation("login").SimpleArg()
+		if account == "" {
+			return fmt.Errorf("function %s: @login names the parameter identifying the account, as in @login(email)", fn.Name)
+		}
+		if fn.ReturnType != "" && fn.ReturnType != "error" {
+			return fmt.Errorf("function %s: @login applies to handlers, functions returning error", fn.Name)
+		}
+		if fn.FindAnnotation("async") != nil {
+			return fmt.Errorf("function %s: @login counts the failures of the sign-in; it cannot be @async", fn.Name)
+		}
+		if g.findSessionService(file.Services) == nil {
+			return fmt.Errorf("function %s: @login requires a service with provider \"session\"", fn.Name)
+		}
+		param := findParam(fn, account)
+		if param == nil {
+			return fmt.Errorf("function %s: @login(%s) names no parameter of the function", fn.Name, account)
+		}
+		if param.Type != "string" {
+			return fmt.Errorf("function %s: @login(%s) requires a string parameter, not %s", fn.Name, account, param.Type)
+		}
+	}
+
+	svc := g.findSessionService(file.Services)
+	if svc == nil || g.hasLoginLockout(file) {
+		return nil
+	}
+	for _, name := range lockoutFields {
+		if findServiceField(svc, name) != nil {
+			return fmt.Errorf("service %s: %s applies to @login functions", svc.Name, name)
+		}
+	}
+	return nil
+}
+
+// findParam returns the parameter of a function with the given name, if any
+func findParam(fn *ast.FuncDecl, name string) *ast.Param {
+	for _, param := range fn.Params {
+		if param.Name == name {
+			return param
+		}
+	}
+	return nil
+}
+
+// genFailureLimiter generates the failure counter shared by the lockout of
+// sign-ins and the throttling of second factors
+func (g *Generator) genFailureLimiter() string {
+	var b strings.Builder
+
+	b.WriteString("// failureLimiterSweep is the number of tracked keys past which recording a\n")
+	b.WriteString("// failure first drops the expired ones\n")
+	b.WriteString("const failureLimiterSweep = 10000\n\n")
+
+	b.WriteString("// failureLimiter locks a key, such as an account or a client address, once\n")
+	b.WriteString("// it piles up max failures within window, and keeps it locked for window\n")
+	b.WriteString("type failureLimiter struct {\n")
+	b.WriteString("\tmu       sync.Mutex\n")
+	b.WriteString("\tmax      int\n")
+	b.WriteString("\twindow   time.Duration\n")
+	b.WriteString("\tfailures map[string]failureCount\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// failureCount counts the failures of a key since the first one\n")
+	b.WriteString("type failureCount struct {\n")
+	b.WriteString("\tcount int\n")
+	b.WriteString("\tsince time.Time\n")
+	b.WriteString("\tuntil time.Time // end of the lockout, once locked\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// newFailureLimiter builds a limiter locking a key for window after max failures\n")
+	b.WriteString("func newFailureLimiter(max int, window time.Duration) *failureLimiter {\n")
+	b.WriteString("\treturn &failureLimiter{max: max, window: window, failures: map[string]failureCount{}}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// locked returns how long a key stays locked, zero when it is not\n")
+	b.WriteString("func (l *failureLimiter) locked(key string) time.Duration {\n")
+	b.WriteString("\tl.mu.Lock()\n")
+	b.WriteString("\tdefer l.mu.Unlock()\n")
+	b.WriteString("\tif wait := time.Until(l.failures[key].until); wait > 0 {\n")
+	b.WriteString("\t\treturn wait\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn 0\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// fail counts a failure of a key and reports whether it locked the key\n")
+	b.WriteString("func (l *failureLimiter) fail(key string) bool {\n")
+	b.WriteString("\tl.mu.Lock()\n")
+	b.WriteString("\tdefer l.mu.Unlock()\n")
+	b.WriteString("\tnow := time.Now()\n")
+	b.WriteString("\tif len(l.failures) >= failureLimiterSweep {\n")
+	b.WriteString("\t\tfor k, f := range l.failures {\n")
+	b.WriteString("\t\t\tif now.Sub(f.since) > l.window && now.After(f.until) {\n")
+	b.WriteString("\t\t\t\tdelete(l.failures, k)\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tf := l.failures[key]\n")
+	b.WriteString("\tif now.Sub(f.since) > l.window && now.After(f.until) {\n")
+	b.WriteString("\t\tf = failureCount{since: now}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tf.count++\n")
+	b.WriteString("\tlocking := f.count == l.max\n")
+	b.WriteString("\tif locking {\n")
+	b.WriteString("\t\tf.until = now.Add(l.window)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tl.failures[key] = f\n")
+	b.WriteString("\treturn locking\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// reset forgets the failures of a key\n")
+	b.WriteString("func (l *failureLimiter) reset(key string) {\n")
+	b.WriteString("\tl.mu.Lock()\n")
+	b.WriteString("\tdefer l.mu.Unlock()\n")
+	b.WriteString("\tdelete(l.failures, key)\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// genLoginLockout generates the lockout of accounts and client addresses
+// piling up failed sign-ins, and the notice sent to locked accounts
+func (g *Generator) genLoginLockout(file *ast.GMXFile) string {
+	var b strings.Builder
+	mailer := g.lockoutNoticeMailer(file)
+
+	b.WriteString(fmt.Sprintf("// loginAccounts locks an account after %d failed sign-ins within 15 minutes,\n", defaultLockoutAttempts))
+	b.WriteString(fmt.Sprintf("// loginAddresses a client address after %d times as many, whatever the accounts;\n", lockoutAddressFactor))
+	b.WriteString("// the lockoutAttempts and lockoutWindow fields of the session service change both\n")
+	b.WriteString("var (\n")
+	b.WriteString(fmt.Sprintf("\tloginAccounts  = newFailureLimiter(%d, %s)\n", defaultLockoutAttempts, defaultLockoutWindow))
+	b.WriteString(fmt.Sprintf("\tloginAddresses = newFailureLimiter(%d, %s)\n", lockoutAddressFactor*defaultLockoutAttempts, defaultLockoutWindow))
+	b.WriteString(")\n\n")
+
+	if mailer != nil {
+		b.WriteString(fmt.Sprintf("// lockoutMailer sends the lockout notices; set to the %s service at startup\n", mailer.Name))
+		b.WriteString(fmt.Sprintf("var lockoutMailer %sService\n\n", mailer.Name))
+	}
+
+	b.WriteString("// clientAddress returns the address of the client of a request, without its\n")
+	b.WriteString("// port; behind a proxy, every client has the address of the proxy\n")
+	b.WriteString("func clientAddress(r *http.Request) string {\n")
+	b.WriteString("\thost, _, err := net.SplitHostPort(r.RemoteAddr)\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\treturn r.RemoteAddr\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn host\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// loginRetryAfter returns the seconds before sign-ins to an account, or from\n")
+	b.WriteString("// the client of a request, are accepted again; empty when they are\n")
+	b.WriteString("func loginRetryAfter(r *http.Request, account string) string {\n")
+	b.WriteString("\twait := loginAccounts.locked(account)\n")
+	b.WriteString("\tif addr := loginAddresses.locked(clientAddress(r)); addr > wait {\n")
+	b.WriteString("\t\twait = addr\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif wait <= 0 {\n")
+	b.WriteString("\t\treturn \"\"\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn strconv.Itoa(int((wait + time.Second - 1) / time.Second))\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// loginFailed counts a failed sign-in to an account from the client of a\n")
+	b.WriteString("// request, and records the lockouts it causes\n")
+	b.WriteString("func loginFailed(r *http.Request, account string) {\n")
+	b.WriteString("\taddr := clientAddress(r)\n")
+	b.WriteString("\tif loginAddresses.fail(addr) {\n")
+	b.WriteString("\t\trequestLogger(r).Info(\"audit: sign-ins locked\", \"address\", addr)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif loginAccounts.fail(account) {\n")
+	b.WriteString("\t\trequestLogger(r).Info(\"audit: sign-ins locked\", \"account\", account, \"address\", addr)\n")
+	if mailer != nil {
+		b.WriteString("\t\tgo notifyLockout(account)\n")
+	}
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	if mailer != nil {
+		model := g.findAccountModel(file)
+		email := findModelField(model, model.FindAnnotation("account").SimpleArg())
+		pk := utils.ToPascalCase(modelPKField(model).Name)
+		b.WriteString(fmt.Sprintf("// notifyLockout tells the owner of a locked account that failed sign-ins\n// locked it, at the address stored for the %s whose %s is account: the\n", model.Name, email.Name))
+		b.WriteString("// typed account may be anybody's address, and names no one when unknown\n")
+		b.WriteString("func notifyLockout(account string) {\n")
+		b.WriteString(fmt.Sprintf("\tvar user %s\n", model.Name))
+		// Accounts are counted in lower case, whatever the case of the stored address
+		b.WriteString(fmt.Sprintf("\tif err := db.Where(\"LOWER(%s) = ?\", account).Limit(1).Find(&user).Error; err != nil {\n", utils.ToSnakeCase(email.Name)))
+		b.WriteString("\t\tslog.Error(\"lockout: loading the account\", \"error\", err)\n")
+		b.WriteString("\t\treturn\n")
+		b.WriteString("\t}\n")
+		b.WriteString(fmt.Sprintf("\tto := user.%s\n", utils.ToPascalCase(email.Name)))
+		b.WriteString(fmt.Sprintf("\tif user.%s == \"\" || to == \"\" || strings.ContainsAny(to, \"\\r\\n\") {\n", pk))
+		b.WriteString("\t\treturn\n")
+		b.WriteString("\t}\n")
+		b.WriteString("\tbody := fmt.Sprintf(\"After %d failed sign-in attempts, sign-ins to your account are locked for %s.\\r\\n\"+\n")
+		b.WriteString("\t\t\"If these attempts were not yours, change your password once the lock is over.\", loginAccounts.max, loginAccounts.window)\n")
+		b.WriteString("\tif err := lockoutMailer.Send(to, \"Your account is locked\", body); err != nil {\n")
+		b.WriteString("\t\tslog.Error(\"lockout: notifying\", \"to\", to, \"error\", err)\n")
+		b.WriteString("\t}\n")
+		b.WriteString("}\n\n")
+	}
+
+	return b.String()
+}
+
+// genLockoutConfig generates the lines of configure<Session> reading the
+// lockout fields of the session service
+func genLockoutConfig(svc *ast.ServiceDecl) string {
+	attempts := findServiceField(svc, "lockoutAttempts") != nil
+	window := findServiceField(svc, "lockoutWindow") != nil
+	if !attempts && !window {
+		return ""
+	}
+
+	var b strings.Builder
+	if attempts {
+		b.WriteString("\tattempts, err := strconv.Atoi(cfg.LockoutAttempts)\n")
+		b.WriteString("\tif err != nil || attempts < 1 {\n")
+		b.WriteString(fmt.Sprintf("\t\tlog.Fatalf(\"service %s: invalid lockout attempts %%q: expected a positive count\", cfg.LockoutAttempts)\n", svc.Name))
+		b.WriteString("\t}\n")
+	} else {
+		b.WriteString(fmt.Sprintf("\tattempts := %d\n", defaultLockoutAttempts))
+	}
+	if window {
+		b.WriteString("\twindow, err := time.ParseDuration(cfg.LockoutWindow)\n")
+		b.WriteString("\tif err != nil || window <= 0 {\n")
+		b.WriteString(fmt.Sprintf("\t\tlog.Fatalf(\"service %s: invalid lockout window %%q: expected a duration such as 15m\", cfg.LockoutWindow)\n", svc.Name))
+		b.WriteString("\t}\n")
+	} else {
+		b.WriteString(fmt.Sprintf("\twindow := %s\n", defaultLockoutWindow))
+	}
+	b.WriteString("\tloginAccounts = newFailureLimiter(attempts, window)\n")
+	b.WriteString(fmt.Sprintf("\tloginAddresses = newFailureLimiter(%d*attempts, window)\n", lockoutAddressFactor))
+	return b.String()
+}
+
+// genLoginCheck generates the refusal of a @login handler while its account
+// or client is locked; loginAccount keys the failures of the account
+func genLoginCheck(fn *ast.FuncDecl) string {
+	var b strings.Builder
+	account := fn.FindAnnotation("login").SimpleArg()
+	b.WriteString("\t// Sign-ins are refused while the account or the client is locked\n")
+	b.WriteString(fmt.Sprintf("\tloginAccount := strings.ToLower(strings.TrimSpace(%s))\n", account))
+	b.WriteString("\tif retry := loginRetryAfter(r, loginAccount); retry != \"\" {\n")
+	b.WriteString("\t\tw.Header().Set(\"Retry-After\", retry)\n")
+	b.WriteString("\t\thttp.Error(w, \"Too many failed sign-ins, try again later\", http.StatusTooManyRequests)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	return b.String()
+}
+
+// lockoutMailerAssign returns the statement of main handing the mailer
+// service to the lockout notices, if they are sent
+func (g *Generator) lockoutMailerAssign(file *ast.GMXFile) string {
+	mailer := g.lockoutNoticeMailer(file)
+	if mailer == nil || !g.hasLoginLockout(file) {
+		return ""
+	}
+	return fmt.Sprintf("\tlockoutMailer = %sSvc\n", utils.LowerFirst(mailer.Name))
+}
@@ -0,0 +1,207 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// lockoutTestFile returns a session service and a @login function signing in
+// by email, with a mailer when withMailer is set
+func lockoutTestFile(withMailer bool) *ast.GMXFile {
+	file := sessionTestFile(false)
+	file.Template = &ast.TemplateBlock{Source: `<p>Hello</p>`}
+	file.Script.Funcs = append(file.Script.Funcs, &ast.FuncDecl{
+		Name:        "signIn",
+		Params:      []*ast.Param{{Name: "email", Type: "string"}, {Name: "password", Type: "string"}},
+		ReturnType:  "error",
+		Annotations: []*ast.Annotation{{Name: "login", Args: map[string]string{"_": "email"}}},
+		Body:        []ast.Statement{},
+	})
+	if withMailer {
+		file.Services = append(file.Services, &ast.ServiceDecl{
+			Name:     "Mailer",
+			Provider: "smtp",
+			Fields:   []*ast.ServiceField{{Name: "host", Type: "string", EnvVar: "SMTP_HOST"}},
+			Methods: []*ast.ServiceMethod{{
+				Name:       "send",
+				Params:     []*ast.Param{{Name: "to", Type: "string"}, {Name: "subject", Type: "string"}, {Name: "body", Type: "string"}},
+				ReturnType: "error",
+			}},
+		})
+	}
+	return file
+}
+
+func TestGenerator_LoginLockout(t *testing.T) {
+	code, err := New().Generate(accountTestFile())
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	expected := []string{
+		"func newFailureLimiter(max int, window time.Duration) *failureLimiter {",
+		"\tloginAccounts  = newFailureLimiter(5, 15*time.Minute)\n",
+		"\tloginAddresses = newFailureLimiter(20, 15*time.Minute)\n",
+		// The handler refuses locked sign-ins, counts failures and forgets them on success
+		"\tloginAccount := strings.ToLower(strings.TrimSpace(email))\n",
+		"\tif retry := loginRetryAfter(r, loginAccount); retry != \"\" {\n",
+		"\t\tloginFailed(r, loginAccount)\n",
+		"\tloginAccounts.reset(loginAccount)\n",
+		// Locked accounts are told by the mailer, at their stored address
+		"var lockoutMailer MailerService",
+		"\tlockoutMailer = mailerSvc\n",
+		"\t\tgo notifyLockout(account)\n",
+		"\tif err := db.Where(\"LOWER(email) = ?\", account).Limit(1).Find(&user).Error; err != nil {\n",
+		"\tif user.ID == \"\" || to == \"\" || strings.ContainsAny(to, \"\\r\\n\") {\n",
+		"\tif err := lockoutMailer.Send(to, \"Your account is locked\", body); err != nil {\n",
+		"\t\"net\"\n",
+	}
+	for _, exp := range expected {
+		if !strings.Contains(code, exp) {
+			t.Errorf("Generated code missing %q", exp)
+		}
+	}
+	// Without lockout fields, the defaults stay
+	if strings.Contains(code, "cfg.LockoutAttempts") {
+		t.Error("configure should not read lockout fields the service does not declare")
+	}
+}
+
+func TestGenerator_LoginLockoutWithoutNotice(t *testing.T) {
+	tests := []struct {
+		name string
+		file *ast.GMXFile
+	}{
+		{"without mailer", lockoutTestFile(false)},
+		// The typed account may be anybody's address: without accounts to
+		// look it up in, nobody is told
+		{"without account model", lockoutTestFile(true)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := New().Generate(tt.file)
+			if err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+			if !isValidGo(code) {
+				t.Errorf("Generated code is not valid Go:\n%s", code)
+			}
+			if !strings.Contains(code, "func loginFailed(r *http.Request, account string) {") {
+				t.Error("Generated code missing loginFailed")
+			}
+			for _, unexpected := range []string{"lockoutMailer", "notifyLockout"} {
+				if strings.Contains(code, unexpected) {
+					t.Errorf("Generated code should not contain %q", unexpected)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerator_LockoutConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		fields   []string
+		expected []string
+	}{
+		{"attempts and window", []string{"lockoutAttempts", "lockoutWindow"}, []string{
+			"\tattempts, err := strconv.Atoi(cfg.LockoutAttempts)\n",
+			"\twindow, err := time.ParseDuration(cfg.LockoutWindow)\n",
+		}},
+		{"window only", []string{"lockoutWindow"}, []string{
+			"\tattempts := 5\n",
+			"\twindow, err := time.ParseDuration(cfg.LockoutWindow)\n",
+		}},
+		{"attempts only", []string{"lockoutAttempts"}, []string{
+			"\tattempts, err := strconv.Atoi(cfg.LockoutAttempts)\n",
+			"\twindow := 15 * time.Minute\n",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := lockoutTestFile(false)
+			for _, name := range tt.fields {
+				file.Services[0].Fields = append(file.Services[0].Fields, &ast.ServiceField{Name: name, Type: "string", EnvVar: strings.ToUpper(name)})
+			}
+			code, err := New().Generate(file)
+			if err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+			if !isValidGo(code) {
+				t.Errorf("Generated code is not valid Go:\n%s", code)
+			}
+			expected := append([]string{
+				"\tloginAccounts = newFailureLimiter(attempts, window)\n",
+				"\tloginAddresses = newFailureLimiter(4*attempts, window)\n",
+			}, tt.expected...)
+			for _, exp := range expected {
+				if !strings.Contains(code, exp) {
+					t.Errorf("Generated code missing %q", exp)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerator_LoginLockoutErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(file *ast.GMXFile)
+		wantErr string
+	}{
+		{
+			name: "without parameter name",
+			modify: func(file *ast.GMXFile) {
+				file.Script.Funcs[1].Annotations[0].Args = map[string]string{}
+			},
+			wantErr: "function signIn: @login names the parameter identifying the account, as in @login(email)",
+		},
+		{
+			name: "unknown parameter",
+			modify: func(file *ast.GMXFile) {
+				file.Script.Funcs[1].Annotations[0].Args["_"] = "username"
+			},
+			wantErr: "function signIn: @login(username) names no parameter of the function",
+		},
+		{
+			name: "non-string parameter",
+			modify: func(file *ast.GMXFile) {
+				file.Script.Funcs[1].Params[0].Type = "uuid"
+			},
+			wantErr: "function signIn: @login(email) requires a string parameter, not uuid",
+		},
+		{
+			name: "without session service",
+			modify: func(file *ast.GMXFile) {
+				file.Services = nil
+			},
+			wantErr: "function signIn: @login requires a service with provider \"session\"",
+		},
+		{
+			name: "lockout fields without @login",
+			modify: func(file *ast.GMXFile) {
+				file.Script.Funcs[1].Annotations = nil
+				file.Services[0].Fields = append(file.Services[0].Fields, &ast.ServiceField{Name: "lockoutWindow", Type: "string"})
+			},
+			wantErr: "service Auth: lockoutWindow applies to @login functions",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := lockoutTestFile(false)
+			tt.modify(file)
+			_, err := New().Generate(file)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
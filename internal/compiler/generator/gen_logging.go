@@ -0,0 +1,124 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// requestIDHeader carries the ID of a request: kept when a proxy sets it,
+// generated otherwise, and answered to the client
+const requestIDHeader = "X-Request-ID"
+
+// genLogging generates the structured logging of the application: the
+// default slog logger, JSON lines in production and text in dev builds, and
+// the middleware giving each request an ID and logging its outcome
+func (g *Generator) genLogging() string {
+	var b strings.Builder
+
+	b.WriteString("// setupLogging sets the default slog logger, which the log package writes\n")
+	if g.opts.Dev {
+		b.WriteString("// through too: text lines from the debug level in dev builds\n")
+		b.WriteString("func setupLogging() {\n")
+		b.WriteString("\tslog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})))\n")
+	} else {
+		b.WriteString("// through too: JSON lines from the info level\n")
+		b.WriteString("func setupLogging() {\n")
+		b.WriteString("\tslog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))\n")
+	}
+	b.WriteString("}\n\n")
+
+	b.WriteString("// requestLoggerKey is the context key of the logger of a request\n")
+	b.WriteString("type requestLoggerKey struct{}\n\n")
+
+	b.WriteString("// requestLogger returns the logger of a request, carrying its ID; the\n")
+	b.WriteString("// default logger for a request logRequests did not see\n")
+	b.WriteString("func requestLogger(r *http.Request) *slog.Logger {\n")
+	b.WriteString("\treturn contextLogger(r.Context())\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// contextLogger returns the logger of the request a context belongs to, as\n")
+	b.WriteString("// the queries of a handler do; the default logger outside of requests\n")
+	b.WriteString("func contextLogger(ctx context.Context) *slog.Logger {\n")
+	b.WriteString("\tif logger, ok := ctx.Value(requestLoggerKey{}).(*slog.Logger); ok {\n")
+	b.WriteString("\t\treturn logger\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn slog.Default()\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// newRequestID returns a random request ID\n")
+	b.WriteString("func newRequestID() string {\n")
+	b.WriteString("\tb := make([]byte, 8)\n")
+	b.WriteString("\trand.Read(b)\n")
+	b.WriteString("\treturn fmt.Sprintf(\"%x\", b)\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// validRequestID reports whether the request ID of a proxy is safe to log and\n")
+	b.WriteString("// answer: up to 64 letters, digits, dashes and underscores\n")
+	b.WriteString("func validRequestID(id string) bool {\n")
+	b.WriteString("\tif id == \"\" || len(id) > 64 {\n")
+	b.WriteString("\t\treturn false\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tfor _, c := range id {\n")
+	b.WriteString("\t\tif !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {\n")
+	b.WriteString("\t\t\treturn false\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn true\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// statusWriter keeps the status of a response for the request log\n")
+	b.WriteString("type statusWriter struct {\n")
+	b.WriteString("\thttp.ResponseWriter\n")
+	b.WriteString("\tstatus int\n")
+	b.WriteString("}\n\n")
+	b.WriteString("func (w *statusWriter) WriteHeader(status int) {\n")
+	b.WriteString("\tif w.status == 0 {\n")
+	b.WriteString("\t\tw.status = status\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tw.ResponseWriter.WriteHeader(status)\n")
+	b.WriteString("}\n\n")
+	b.WriteString("func (w *statusWriter) Write(p []byte) (int, error) {\n")
+	b.WriteString("\tif w.status == 0 {\n")
+	b.WriteString("\t\tw.status = http.StatusOK\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn w.ResponseWriter.Write(p)\n")
+	b.WriteString("}\n\n")
+	b.WriteString("// Flush lets server-sent events through the request log\n")
+	b.WriteString("func (w *statusWriter) Flush() {\n")
+	b.WriteString("\tif f, ok := w.ResponseWriter.(http.Flusher); ok {\n")
+	b.WriteString("\t\tf.Flush()\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+	b.WriteString("// Unwrap lets http.ResponseController reach the underlying writer\n")
+	b.WriteString("func (w *statusWriter) Unwrap() http.ResponseWriter {\n")
+	b.WriteString("\treturn w.ResponseWriter\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// logRequests gives each request an ID, answered in " + requestIDHeader + ", and a\n")
+	b.WriteString("// logger carrying it, then logs the method, path, status and duration of the\n")
+	b.WriteString("// request: server errors at the error level, others at the info level\n")
+	b.WriteString("func logRequests(next http.Handler) http.Handler {\n")
+	b.WriteString("\treturn http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString("\t\tstart := time.Now()\n")
+	b.WriteString(fmt.Sprintf("\t\tid := r.Header.Get(%q)\n", requestIDHeader))
+	b.WriteString("\t\tif !validRequestID(id) {\n")
+	b.WriteString("\t\t\tid = newRequestID()\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString(fmt.Sprintf("\t\tw.Header().Set(%q, id)\n", requestIDHeader))
+	b.WriteString("\t\tlogger := slog.Default().With(\"request_id\", id)\n")
+	b.WriteString("\t\tsw := &statusWriter{ResponseWriter: w}\n")
+	b.WriteString("\t\tnext.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), requestLoggerKey{}, logger)))\n")
+	b.WriteString("\t\tif sw.status == 0 {\n")
+	b.WriteString("\t\t\tsw.status = http.StatusOK\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tlevel := slog.LevelInfo\n")
+	b.WriteString("\t\tif sw.status >= http.StatusInternalServerError {\n")
+	b.WriteString("\t\t\tlevel = slog.LevelError\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tlogger.Log(r.Context(), level, \"request\", \"method\", r.Method, \"path\", r.URL.Path,\n")
+	b.WriteString("\t\t\t\"status\", sw.status, \"duration\", time.Since(start).Round(time.Microsecond))\n")
+	b.WriteString("\t})\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
@@ -0,0 +1,80 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateLogging(t *testing.T) {
+	tests := []struct {
+		name    string
+		dev     bool
+		handler string
+	}{
+		{"production", false, "slog.NewJSONHandler(os.Stderr, nil)"},
+		{"dev", true, "slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := strictFile(t, strictModels+`
+func createTask(title: string) error {
+  const task = Task{title: title}
+  try task.save()
+  log.info("created task", task.id, ctx.user)
+  return render(task)
+}
+`, `<form hx-post="{{route "createTask"}}"></form>`)
+
+			code, err := NewWithOptions(Options{Dev: tt.dev}).Generate(file)
+			if err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+			if !isValidGo(code) {
+				t.Fatalf("Generated code is not valid Go:\n%s", code)
+			}
+
+			for _, want := range []string{
+				tt.handler,
+				"\tsetupLogging()\n",
+				// Requests are logged outside every other middleware, with their ID
+				"logRequests(",
+				"\t\tlogger := slog.Default().With(\"request_id\", id)\n",
+				"\t\tw.Header().Set(\"X-Request-ID\", id)\n",
+				// Scripts log through the logger of the request
+				"\t\tLog:     requestLogger(r),\n",
+				"Log     *slog.Logger",
+				`ctx.Log.Info("created task", "task.id", task.ID, "ctx.user", ctx.User)`,
+				`requestLogger(r).Error("handler error", "handler", "createTask", "error", err)`,
+			} {
+				if !strings.Contains(code, want) {
+					t.Errorf("Generated code missing %q", want)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateLoggingWithLoggerService(t *testing.T) {
+	// init<Service>() of a service named Logger builds next to the logging setup
+	file := scriptTestFile(t, `service Logger {
+  provider: "smtp"
+  host: string @env("SMTP_HOST")
+  pass: string @env("SMTP_PASS")
+  func send(to: string, subject: string, body: string) error
+}
+model Task {
+  id:    uuid @pk @default(uuid_v4)
+  title: string
+}`, "<p>{{.CSRFToken}}</p>")
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	for _, want := range []string{"func initLogger() *LoggerConfig {", "func setupLogging() {"} {
+		if !strings.Contains(code, want) {
+			t.Errorf("Generated code missing %q", want)
+		}
+	}
+	goInModule(t, map[string]string{"main.go": code}, "vet", ".")
+}
@@ -7,11 +7,31 @@ import (
 	"strings"
 )
 
-// genMain generates the main function
-func (g *Generator) genMain(file *ast.GMXFile, routes map[string]string) string {
+// genMain generates the main function; hasStyleBundle registers the bundled stylesheet route
+func (g *Generator) genMain(file *ast.GMXFile, routes map[string]string, hasStyleBundle bool) string {
 	var b strings.Builder
 
-	b.WriteString("func main() {\n")
+	if g.packageName() == "main" {
+		b.WriteString("func main() {\n")
+	} else {
+		b.WriteString("// Main starts the application server; call it from the host program's main\n")
+		b.WriteString("func Main() {\n")
+	}
+	b.WriteString("\tsetupLogging()\n\n")
+
+	// Dev builds profile the whole run with --profile cpu.out, and record
+	// requests with --record dir
+	if g.opts.Dev {
+		b.WriteString("\tflag.Parse()\n")
+		b.WriteString("\tif *profileFlag != \"\" {\n")
+		b.WriteString("\t\tdefer startCPUProfile(*profileFlag)()\n")
+		b.WriteString("\t}\n\n")
+	}
+
+	// Faults of the HTTP service clients, from GMX_CHAOS_* variables
+	if g.hasChaos(file) {
+		b.WriteString("\tloadChaosFaults()\n\n")
+	}
 
 	// Find Database service if it exists
 	dbService := g.findDatabaseService(file.Services)
@@ -19,31 +39,65 @@ func (g *Generator) genMain(file *ast.GMXFile, routes map[string]string) string
 	// Initialize services
 	if len(file.Services) > 0 {
 		for _, svc := range file.Services {
-			varName := strings.ToLower(svc.Name[:1]) + svc.Name[1:] + "Cfg"
+			varName := utils.LowerFirst(svc.Name) + "Cfg"
 			b.WriteString(fmt.Sprintf("\t%s := init%s()\n", varName, svc.Name))
+			if svc.Provider == "redis" {
+				b.WriteString(fmt.Sprintf("\tconnect%s(%s)\n", svc.Name, varName))
+			}
 
 			// If service has methods, create the service instance
 			if len(svc.Methods) > 0 {
 				if svc.Provider == "smtp" {
-					svcVarName := strings.ToLower(svc.Name[:1]) + svc.Name[1:] + "Svc"
+					svcVarName := utils.LowerFirst(svc.Name) + "Svc"
 					b.WriteString(fmt.Sprintf("\t%s := new%sService(%s)\n", svcVarName, svc.Name, varName))
 				} else if svc.Provider == "http" {
 					// HTTP clients use different factory name
-					clientVarName := strings.ToLower(svc.Name[:1]) + svc.Name[1:] + "Client"
+					clientVarName := utils.LowerFirst(svc.Name) + "Client"
 					b.WriteString(fmt.Sprintf("\t%s := new%sClient(%s)\n", clientVarName, svc.Name, varName))
 				} else {
 					// Generic services
-					svcVarName := strings.ToLower(svc.Name[:1]) + svc.Name[1:] + "Svc"
+					svcVarName := utils.LowerFirst(svc.Name) + "Svc"
 					b.WriteString(fmt.Sprintf("\t%s := new%sService(%s)\n", svcVarName, svc.Name, varName))
 				}
 			} else if svc.Provider == "http" {
 				// HTTP client without methods
-				clientVarName := strings.ToLower(svc.Name[:1]) + svc.Name[1:] + "Client"
+				clientVarName := utils.LowerFirst(svc.Name) + "Client"
 				b.WriteString(fmt.Sprintf("\t%s := new%sClient(%s)\n", clientVarName, svc.Name, varName))
 			}
 		}
 		b.WriteString("\n")
 
+		// Spans are exported from the start, to the collector of the OTEL_* variables
+		if tracingSvc := g.findTracingService(file.Services); tracingSvc != nil {
+			varName := utils.LowerFirst(tracingSvc.Name) + "Cfg"
+			b.WriteString(fmt.Sprintf("\tstart%s(%s)\n\n", tracingSvc.Name, varName))
+		}
+
+		// Load shedding wraps every other middleware
+		if shedSvc := g.findLoadShedService(file.Services); shedSvc != nil {
+			varName := utils.LowerFirst(shedSvc.Name) + "Cfg"
+			b.WriteString(fmt.Sprintf("\t%s := new%sShedder(%s)\n\n", loadShedVar(shedSvc), shedSvc.Name, varName))
+		}
+
+		// Session service loads its secret (and admins) into package state
+		if sessionSvc := g.findSessionService(file.Services); sessionSvc != nil {
+			varName := utils.LowerFirst(sessionSvc.Name) + "Cfg"
+			b.WriteString(fmt.Sprintf("\tconfigure%s(%s)\n", sessionSvc.Name, varName))
+			b.WriteString(g.lockoutMailerAssign(file))
+			b.WriteString(g.accountMailerAssign(file))
+			b.WriteString("\n")
+		}
+
+		// Image variants and backups are written through the storage service
+		if assign := g.imageStorageAssign(file) + g.backupStorageAssign(file); assign != "" {
+			b.WriteString(assign + "\n")
+		}
+
+		// Script code calls the services through package variables
+		if assigns := g.scriptServiceAssigns(file); assigns != "" {
+			b.WriteString(assigns + "\n")
+		}
+
 		// Suppress unused variable warnings
 		for _, svc := range file.Services {
 			// Skip Database service config vars only if they're actually used (when models exist)
@@ -51,15 +105,15 @@ func (g *Generator) genMain(file *ast.GMXFile, routes map[string]string) string
 				continue
 			}
 
-			varName := strings.ToLower(svc.Name[:1]) + svc.Name[1:] + "Cfg"
+			varName := utils.LowerFirst(svc.Name) + "Cfg"
 			b.WriteString(fmt.Sprintf("\t_ = %s\n", varName))
 
 			if len(svc.Methods) > 0 || svc.Provider == "http" {
 				if svc.Provider == "http" {
-					clientVarName := strings.ToLower(svc.Name[:1]) + svc.Name[1:] + "Client"
+					clientVarName := utils.LowerFirst(svc.Name) + "Client"
 					b.WriteString(fmt.Sprintf("\t_ = %s\n", clientVarName))
 				} else {
-					svcVarName := strings.ToLower(svc.Name[:1]) + svc.Name[1:] + "Svc"
+					svcVarName := utils.LowerFirst(svc.Name) + "Svc"
 					b.WriteString(fmt.Sprintf("\t_ = %s\n", svcVarName))
 				}
 			}
@@ -73,86 +127,203 @@ func (g *Generator) genMain(file *ast.GMXFile, routes map[string]string) string
 
 		if dbService != nil {
 			// Use Database service configuration
-			dbVarName := strings.ToLower(dbService.Name[:1]) + dbService.Name[1:] + "Cfg"
+			dbVarName := utils.LowerFirst(dbService.Name) + "Cfg"
+
+			// Queries are logged from the start, migrations included
+			if g.hasQueryLog(file) {
+				b.WriteString(g.genQueryLogStart(file))
+			}
 
 			// Determine the driver based on provider
 			switch dbService.Provider {
 			case "postgres":
-				b.WriteString(fmt.Sprintf("\tdb, err = gorm.Open(postgres.Open(%s.Url), &gorm.Config{})\n", dbVarName))
+				b.WriteString(fmt.Sprintf("\tdb, err = gorm.Open(postgres.Open(%s.Url), %s)\n", dbVarName, g.gormConfig(file)))
 			case "mysql":
-				b.WriteString(fmt.Sprintf("\tdb, err = gorm.Open(mysql.Open(%s.Url), &gorm.Config{})\n", dbVarName))
+				b.WriteString(fmt.Sprintf("\tdb, err = gorm.Open(mysql.Open(%s.Url), %s)\n", dbVarName, g.gormConfig(file)))
 			default: // sqlite
-				b.WriteString(fmt.Sprintf("\tdb, err = gorm.Open(sqlite.Open(%s.Url), &gorm.Config{})\n", dbVarName))
+				b.WriteString(fmt.Sprintf("\tdb, err = gorm.Open(sqlite.Open(%s.Url), %s)\n", dbVarName, g.gormConfig(file)))
 			}
 		} else {
 			// Fallback to hardcoded SQLite for backward compatibility
-			b.WriteString("\tdb, err = gorm.Open(sqlite.Open(\"gmx.db\"), &gorm.Config{})\n")
+			b.WriteString(fmt.Sprintf("\tdb, err = gorm.Open(sqlite.Open(%q), &gorm.Config{})\n", FallbackSQLitePath))
 		}
 
 		b.WriteString("\tif err != nil {\n")
 		b.WriteString("\t\tlog.Fatal(\"failed to connect database:\", err)\n")
 		b.WriteString("\t}\n\n")
 
-		// AutoMigrate all models
-		b.WriteString("\tdb.AutoMigrate(")
-		for i, model := range file.Models {
-			if i > 0 {
-				b.WriteString(", ")
+		// Queries are timed from the start, migrations included, and traced
+		// within requests
+		for _, plugin := range g.gormPlugins(file) {
+			b.WriteString(fmt.Sprintf("\tif err := db.Use(%s); err != nil {\n", plugin))
+			b.WriteString("\t\tlog.Fatal(\"failed to instrument database:\", err)\n")
+			b.WriteString("\t}\n\n")
+		}
+
+		// Backups join the scheduled runs, which start once the server is set up;
+		// a postgres database without a standby is dumped at its configured URL
+		if backupSvc := g.findBackupService(file.Services); backupSvc != nil {
+			backupVarName := utils.LowerFirst(backupSvc.Name) + "Cfg"
+			if backupTakesDBURL(backupSvc, dbService) {
+				b.WriteString(fmt.Sprintf("\tstart%s(%s, %sCfg.Url)\n\n", backupSvc.Name, backupVarName, utils.LowerFirst(dbService.Name)))
+			} else {
+				b.WriteString(fmt.Sprintf("\tstart%s(%s)\n\n", backupSvc.Name, backupVarName))
 			}
-			b.WriteString(fmt.Sprintf("&%s{}", model.Name))
 		}
-		b.WriteString(")\n\n")
-	}
 
-	// Build a map of all routes to register (avoid duplicates)
-	routesToRegister := make(map[string]string) // path -> handlerName
+		// Primary/standby targets for runtime switchover
+		if g.hasDatabaseStandby(file) {
+			dbVarName := utils.LowerFirst(dbService.Name) + "Cfg"
+			b.WriteString(fmt.Sprintf("\tdbTargets = [2]string{%s.Url, %s.Standby}\n", dbVarName, dbVarName))
+			b.WriteString("\tgo watchDatabaseSwitchover()\n\n")
+		}
 
-	// Create mux
-	b.WriteString("\tmux := http.NewServeMux()\n")
+		if g.hasMigrations(file) {
+			// `<binary> migrate down` reverts the last migration, then exits
+			b.WriteString("\tif len(os.Args) > 2 && os.Args[1] == \"migrate\" && os.Args[2] == \"down\" {\n")
+			b.WriteString("\t\tif err := revertMigration(db); err != nil {\n")
+			b.WriteString("\t\t\tlog.Fatalf(\"migrate down: %v\", err)\n")
+			b.WriteString("\t\t}\n")
+			b.WriteString("\t\treturn\n")
+			b.WriteString("\t}\n")
+			b.WriteString("\tif err := runMigrations(db); err != nil {\n")
+			b.WriteString("\t\tlog.Fatal(\"failed to migrate database:\", err)\n")
+			b.WriteString("\t}\n\n")
+		} else {
+			// AutoMigrate all models
+			b.WriteString("\tdb.AutoMigrate(")
+			for i, model := range file.Models {
+				if i > 0 {
+					b.WriteString(", ")
+				}
+				b.WriteString(fmt.Sprintf("&%s{}", model.Name))
+			}
+			b.WriteString(")\n\n")
+		}
 
-	// Register index route
-	b.WriteString("\tmux.HandleFunc(\"/\", handleIndex)\n")
+		// Expired sessions of the database store are deleted in the background
+		if g.sessionStoreOf(file) == "database" {
+			b.WriteString("\tgo cleanupSessions()\n\n")
+		}
 
-	// Register template routes
-	// If a script function exists for the route, use the script handler wrapper
-	// Otherwise use the stub handler
-	for routeName, path := range routes {
-		handlerName := "handle" + utils.Capitalize(routeName)
-		routesToRegister[path] = handlerName
-	}
+		// Finished jobs are deleted in the background
+		if g.hasJobs(file) {
+			b.WriteString("\tgo cleanupJobs()\n\n")
+		}
 
-	// Register script function handlers that are NOT in template routes
-	if file.Script != nil && file.Script.Funcs != nil {
-		for _, fn := range file.Script.Funcs {
-			// Skip utility functions (non-error return type)
-			if fn.ReturnType != "" && fn.ReturnType != "error" {
-				continue
-			}
-			// Check if this function is already registered via template route
-			found := false
-			for routeName := range routes {
-				if routeName == fn.Name {
-					found = true
-					break
-				}
-			}
-			// If not found in template routes, register a default route
-			if !found {
-				apiPath := "/api/" + fn.Name
-				handlerName := "handle" + utils.Capitalize(fn.Name)
-				routesToRegister[apiPath] = handlerName
-			}
+		// Row-level security policies follow the migrated tables
+		if g.hasRowLevelSecurity(file) {
+			dbVarName := utils.LowerFirst(dbService.Name) + "Cfg"
+			b.WriteString(fmt.Sprintf("\ttenantHeader = %s.TenantHeader\n", dbVarName))
+			b.WriteString("\tif err := enableRowLevelSecurity(db); err != nil {\n")
+			b.WriteString("\t\tlog.Fatal(\"failed to enable row-level security:\", err)\n")
+			b.WriteString("\t}\n\n")
 		}
+
+		// `<binary> anonymize` rewrites sensitive fields, then exits
+		if g.hasPIIFields(file) {
+			b.WriteString("\tif len(os.Args) > 1 && os.Args[1] == \"anonymize\" {\n")
+			b.WriteString("\t\tif err := anonymizeDatabase(); err != nil {\n")
+			b.WriteString("\t\t\tlog.Fatalf(\"anonymize: %v\", err)\n")
+			b.WriteString("\t\t}\n")
+			b.WriteString("\t\treturn\n")
+			b.WriteString("\t}\n\n")
+		}
+	}
+
+	// Workers of the @job functions, drained at shutdown
+	if g.hasQueuedJobs(file) {
+		b.WriteString(g.genJobQueueStart(file))
+		b.WriteString("\n")
+	}
+
+	// Relay of the published events, including those left by a previous run
+	if g.hasOutbox(file) {
+		b.WriteString(g.genOutboxStart(file))
+		b.WriteString("\n")
 	}
 
-	// Output all route registrations
-	for path, handlerName := range routesToRegister {
-		b.WriteString(fmt.Sprintf("\tmux.HandleFunc(%q, %s)\n", path, handlerName))
+	// Schedulers of the @schedule functions, which may queue jobs, and of the built-in cleanups
+	if g.hasSchedules(file) {
+		b.WriteString("\tstartSchedules()\n\n")
+	}
+
+	// Create mux and register the route table
+	b.WriteString("\tmux := http.NewServeMux()\n")
+	for _, route := range g.routeTable(file, routes, hasStyleBundle) {
+		// Each route but the metrics endpoint is counted, timed and traced
+		b.WriteString(fmt.Sprintf("\tmux.HandleFunc(%q, %s)\n", route.Path, g.instrumentedHandler(file, route)))
 	}
 
 	b.WriteString("\n")
-	b.WriteString("\tfmt.Println(\"GMX server starting on :8080\")\n")
-	b.WriteString("\tlog.Fatal(http.ListenAndServe(\":8080\", csrfProtect(securityHeaders(mux))))\n")
+	// Dev builds stop on the problems a first click would hit
+	if g.opts.Dev {
+		b.WriteString(g.genSelfCheckCall(file))
+	}
+	handler := "mux"
+	// Signed-in users accept the current terms before reaching the app
+	if g.hasConsent(file) {
+		handler = "requireConsent(" + handler + ")"
+	}
+	if g.hasRowLevelSecurity(file) {
+		handler = "rlsConnection(" + handler + ")"
+	}
+	if g.hasDatabaseStandby(file) {
+		handler = "dbDrain(" + handler + ")"
+	}
+	handler = "csrfProtect(securityHeaders(" + handler + "))"
+	// Dev builds record requests as the client sent them, with --record
+	if g.opts.Dev {
+		handler = "recordRequests(" + handler + ")"
+	}
+	if shedSvc := g.findLoadShedService(file.Services); shedSvc != nil {
+		handler = loadShedVar(shedSvc) + ".middleware(" + handler + ")"
+	}
+	// Every request is logged, shed ones included
+	handler = "logRequests(" + handler + ")"
+
+	// Without a server block the server listens on ServerPort
+	if file.Server == nil {
+		b.WriteString(fmt.Sprintf("\tsrv := &http.Server{Addr: \":%d\", Handler: %s}\n", ServerPort, handler))
+		b.WriteString(fmt.Sprintf("\tfmt.Println(\"GMX server starting on :%d\")\n", ServerPort))
+		if g.hasDevMail(file) {
+			b.WriteString(fmt.Sprintf("\tfmt.Println(\"Dev mode: outgoing mail is caught at http://localhost:%d%s\")\n", ServerPort, devMailPath))
+		}
+		if g.opts.Dev {
+			b.WriteString(fmt.Sprintf("\tfmt.Println(\"Dev mode: profiles are served to localhost at http://localhost:%d%s\")\n", ServerPort, pprofPath))
+		}
+		if g.hasChaos(file) {
+			b.WriteString(fmt.Sprintf("\tfmt.Println(\"Dev mode: faults of HTTP services are set at http://localhost:%d%s\")\n", ServerPort, chaosPath))
+		}
+		b.WriteString(fmt.Sprintf("\tserveUntilSignal(srv, srv.ListenAndServe, %s)\n", goDuration(defaultShutdownTimeout)))
+		b.WriteString("}\n")
+		return b.String()
+	}
+
+	// The server block sets the address, timeouts and TLS files
+	b.WriteString(fmt.Sprintf("\tsrv := newServer(%s)\n", handler))
+	b.WriteString("\tfmt.Println(\"GMX server starting on \" + srv.Addr)\n")
+	if g.opts.Dev {
+		b.WriteString("\t_, port, _ := net.SplitHostPort(srv.Addr)\n")
+		if g.hasDevMail(file) {
+			b.WriteString(fmt.Sprintf("\tfmt.Println(\"Dev mode: outgoing mail is caught at http://localhost:\" + port + %q)\n", devMailPath))
+		}
+		b.WriteString(fmt.Sprintf("\tfmt.Println(\"Dev mode: profiles are served to localhost at http://localhost:\" + port + %q)\n", pprofPath))
+		if g.hasChaos(file) {
+			b.WriteString(fmt.Sprintf("\tfmt.Println(\"Dev mode: faults of HTTP services are set at http://localhost:\" + port + %q)\n", chaosPath))
+		}
+	}
+	b.WriteString("\tlisten := srv.ListenAndServe\n")
+	if file.Server.TLS != nil {
+		b.WriteString("\tif cert, key := serverTLSFiles(); cert != \"\" {\n")
+		b.WriteString("\t\tlisten = func() error { return srv.ListenAndServeTLS(cert, key) }\n")
+		b.WriteString("\t}\n")
+	}
+	timeout := goDuration(defaultShutdownTimeout)
+	if file.Server.FindOption("shutdownTimeout") != nil {
+		timeout = "serverShutdownTimeout()"
+	}
+	b.WriteString(fmt.Sprintf("\tserveUntilSignal(srv, listen, %s)\n", timeout))
 	b.WriteString("}\n")
 
 	return b.String()
@@ -0,0 +1,282 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// metricsPath is the endpoint answering the metrics in the Prometheus text
+// format
+const metricsPath = "/metrics"
+
+// metricsBuckets are the upper bounds, in seconds, of the latency histograms
+const metricsBuckets = "0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10"
+
+// queryOperations are the GORM callback processors timed by the metrics
+// plugin, with the callback running their query
+var queryOperations = []struct{ processor, callback string }{
+	{"Create", "gorm:create"},
+	{"Query", "gorm:query"},
+	{"Update", "gorm:update"},
+	{"Delete", "gorm:delete"},
+	{"Row", "gorm:row"},
+	{"Raw", "gorm:raw"},
+}
+
+// hasMetrics checks if the observability block turns metrics on
+func (g *Generator) hasMetrics(file *ast.GMXFile) bool {
+	if file.Observability == nil {
+		return false
+	}
+	opt := file.Observability.FindOption("metrics")
+	return opt != nil && opt.Value == "true"
+}
+
+// validateMetrics checks that the metrics endpoint is free
+func (g *Generator) validateMetrics(file *ast.GMXFile) error {
+	if !g.hasMetrics(file) {
+		return nil
+	}
+	for _, page := range file.Pages {
+		if page.Route == metricsPath {
+			return fmt.Errorf("page %s is served at %s, where observability serves the metrics; rename it", page.Path, metricsPath)
+		}
+	}
+	if file.Script == nil {
+		return nil
+	}
+	for _, fn := range file.Script.Funcs {
+		if "handle"+utils.Capitalize(fn.Name) == "handleMetrics" {
+			return fmt.Errorf("line %d: function %s collides with the built-in %s endpoint; rename it", fn.Line, fn.Name, metricsPath)
+		}
+	}
+	return nil
+}
+
+// genMetrics generates the metrics of the application: the requests, latency
+// and in-flight requests of each route, the duration of database queries
+// through a GORM plugin, the depth and latency of the job queue, and the
+// endpoint answering them to Prometheus
+func (g *Generator) genMetrics(file *ast.GMXFile) string {
+	var b strings.Builder
+
+	b.WriteString("// metricsBuckets are the upper bounds, in seconds, of the latency histograms\n")
+	b.WriteString("var metricsBuckets = []float64{" + metricsBuckets + "}\n\n")
+
+	b.WriteString("// metricsHistogram counts observations per bucket, with their sum\n")
+	b.WriteString("type metricsHistogram struct {\n")
+	b.WriteString("\tcounts []uint64 // per bucket, the +Inf one last\n")
+	b.WriteString("\tcount  uint64\n")
+	b.WriteString("\tsum    float64\n")
+	b.WriteString("}\n\n")
+	b.WriteString("func (h *metricsHistogram) observe(seconds float64) {\n")
+	b.WriteString("\tif h.counts == nil {\n")
+	b.WriteString("\t\th.counts = make([]uint64, len(metricsBuckets)+1)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\ti := sort.SearchFloat64s(metricsBuckets, seconds)\n")
+	b.WriteString("\th.counts[i]++\n")
+	b.WriteString("\th.count++\n")
+	b.WriteString("\th.sum += seconds\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// routeMetrics are the metrics of a route of the mux\n")
+	b.WriteString("type routeMetrics struct {\n")
+	b.WriteString("\tinFlight int64\n")
+	b.WriteString("\trequests map[[2]string]uint64         // method, status code\n")
+	b.WriteString("\tlatency  map[string]*metricsHistogram // method\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// metricsMu guards the metrics of the routes and queries\n")
+	b.WriteString("var (\n")
+	b.WriteString("\tmetricsMu      sync.Mutex\n")
+	b.WriteString("\trouteMetricsOf = map[string]*routeMetrics{}\n")
+	b.WriteString("\tqueryLatency   = map[[2]string]*metricsHistogram{} // operation, table\n")
+	b.WriteString(")\n\n")
+
+	b.WriteString("// metricsMethod returns the method label of a request: clients choose the\n")
+	b.WriteString("// method, so unknown ones share a label\n")
+	b.WriteString("func metricsMethod(method string) string {\n")
+	b.WriteString("\tswitch method {\n")
+	b.WriteString("\tcase http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:\n")
+	b.WriteString("\t\treturn method\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn \"OTHER\"\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// instrumentRoute counts the requests of a route by method and status, times\n")
+	b.WriteString("// them and tracks those in flight; a panicking handler counts as a 500\n")
+	b.WriteString("func instrumentRoute(route string, next http.HandlerFunc) http.HandlerFunc {\n")
+	b.WriteString("\tm := &routeMetrics{requests: map[[2]string]uint64{}, latency: map[string]*metricsHistogram{}}\n")
+	b.WriteString("\tmetricsMu.Lock()\n")
+	b.WriteString("\trouteMetricsOf[route] = m\n")
+	b.WriteString("\tmetricsMu.Unlock()\n")
+	b.WriteString("\treturn func(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString("\t\tstart := time.Now()\n")
+	b.WriteString("\t\tmethod := metricsMethod(r.Method)\n")
+	b.WriteString("\t\tmetricsMu.Lock()\n")
+	b.WriteString("\t\tm.inFlight++\n")
+	b.WriteString("\t\tmetricsMu.Unlock()\n")
+	b.WriteString("\t\tsw := &statusWriter{ResponseWriter: w}\n")
+	b.WriteString("\t\tdefer func() {\n")
+	b.WriteString("\t\t\tp := recover()\n")
+	b.WriteString("\t\t\tstatus := sw.status\n")
+	b.WriteString("\t\t\tif p != nil {\n")
+	b.WriteString("\t\t\t\tstatus = http.StatusInternalServerError\n")
+	b.WriteString("\t\t\t} else if status == 0 {\n")
+	b.WriteString("\t\t\t\tstatus = http.StatusOK\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t\tmetricsMu.Lock()\n")
+	b.WriteString("\t\t\tm.inFlight--\n")
+	b.WriteString("\t\t\tm.requests[[2]string{method, strconv.Itoa(status)}]++\n")
+	b.WriteString("\t\t\th := m.latency[method]\n")
+	b.WriteString("\t\t\tif h == nil {\n")
+	b.WriteString("\t\t\t\th = &metricsHistogram{}\n")
+	b.WriteString("\t\t\t\tm.latency[method] = h\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t\th.observe(time.Since(start).Seconds())\n")
+	b.WriteString("\t\t\tmetricsMu.Unlock()\n")
+	b.WriteString("\t\t\tif p != nil {\n")
+	b.WriteString("\t\t\t\tpanic(p)\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t}()\n")
+	b.WriteString("\t\tnext(sw, r)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	if len(file.Models) > 0 {
+		b.WriteString(g.genMetricsPlugin())
+	}
+
+	b.WriteString("// sortedMetricsKeys returns the keys of a map of series in order, for a\n")
+	b.WriteString("// stable output\n")
+	b.WriteString("func sortedMetricsKeys[K interface{ ~string | ~[2]string }, V any](m map[K]V) []K {\n")
+	b.WriteString("\tkeys := make([]K, 0, len(m))\n")
+	b.WriteString("\tfor k := range m {\n")
+	b.WriteString("\t\tkeys = append(keys, k)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tsort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })\n")
+	b.WriteString("\treturn keys\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// writeMetricsHistogram writes the buckets, sum and count of a histogram\n")
+	b.WriteString("func writeMetricsHistogram(b *strings.Builder, name, labels string, h *metricsHistogram) {\n")
+	b.WriteString("\tvar cumulative uint64\n")
+	b.WriteString("\tfor i, bound := range metricsBuckets {\n")
+	b.WriteString("\t\tcumulative += h.counts[i]\n")
+	b.WriteString("\t\tfmt.Fprintf(b, \"%s_bucket{%s,le=%q} %d\\n\", name, labels, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tfmt.Fprintf(b, \"%s_bucket{%s,le=\\\"+Inf\\\"} %d\\n\", name, labels, h.count)\n")
+	b.WriteString("\tfmt.Fprintf(b, \"%s_sum{%s} %s\\n\", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))\n")
+	b.WriteString("\tfmt.Fprintf(b, \"%s_count{%s} %d\\n\", name, labels, h.count)\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// handleMetrics answers the metrics in the Prometheus text format\n")
+	b.WriteString("func handleMetrics(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString(genMethodGuard("Get"))
+	b.WriteString("\tvar b strings.Builder\n")
+	if g.hasStoredJobs(file) {
+		// Counted before locking, as the metrics plugin times the queries
+		b.WriteString(g.genStoredJobCounts(file))
+	}
+	b.WriteString("\tmetricsMu.Lock()\n")
+	b.WriteString("\troutes := sortedMetricsKeys(routeMetricsOf)\n\n")
+	b.WriteString("\tb.WriteString(\"# HELP gmx_http_requests_total Requests answered, by route, method and status code.\\n\")\n")
+	b.WriteString("\tb.WriteString(\"# TYPE gmx_http_requests_total counter\\n\")\n")
+	b.WriteString("\tfor _, route := range routes {\n")
+	b.WriteString("\t\tm := routeMetricsOf[route]\n")
+	b.WriteString("\t\tfor _, key := range sortedMetricsKeys(m.requests) {\n")
+	b.WriteString("\t\t\tfmt.Fprintf(&b, \"gmx_http_requests_total{route=%q,method=%q,code=%q} %d\\n\", route, key[0], key[1], m.requests[key])\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\tb.WriteString(\"# HELP gmx_http_request_duration_seconds Time to answer requests, by route and method.\\n\")\n")
+	b.WriteString("\tb.WriteString(\"# TYPE gmx_http_request_duration_seconds histogram\\n\")\n")
+	b.WriteString("\tfor _, route := range routes {\n")
+	b.WriteString("\t\tm := routeMetricsOf[route]\n")
+	b.WriteString("\t\tfor _, method := range sortedMetricsKeys(m.latency) {\n")
+	b.WriteString("\t\t\twriteMetricsHistogram(&b, \"gmx_http_request_duration_seconds\", fmt.Sprintf(\"route=%q,method=%q\", route, method), m.latency[method])\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\tb.WriteString(\"# HELP gmx_http_requests_in_flight Requests being answered, by route.\\n\")\n")
+	b.WriteString("\tb.WriteString(\"# TYPE gmx_http_requests_in_flight gauge\\n\")\n")
+	b.WriteString("\tfor _, route := range routes {\n")
+	b.WriteString("\t\tfmt.Fprintf(&b, \"gmx_http_requests_in_flight{route=%q} %d\\n\", route, routeMetricsOf[route].inFlight)\n")
+	b.WriteString("\t}\n")
+	if len(file.Models) > 0 {
+		b.WriteString("\n")
+		b.WriteString("\tb.WriteString(\"# HELP gmx_db_query_duration_seconds Time of database queries, by operation and table.\\n\")\n")
+		b.WriteString("\tb.WriteString(\"# TYPE gmx_db_query_duration_seconds histogram\\n\")\n")
+		b.WriteString("\tfor _, key := range sortedMetricsKeys(queryLatency) {\n")
+		b.WriteString("\t\twriteMetricsHistogram(&b, \"gmx_db_query_duration_seconds\", fmt.Sprintf(\"operation=%q,table=%q\", key[0], key[1]), queryLatency[key])\n")
+		b.WriteString("\t}\n")
+	}
+	if g.hasQueuedJobs(file) {
+		b.WriteString("\n")
+		b.WriteString(g.genJobQueueMetrics(file))
+	}
+	b.WriteString("\tmetricsMu.Unlock()\n\n")
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/plain; version=0.0.4; charset=utf-8\")\n")
+	b.WriteString("\tw.Header().Set(\"Cache-Control\", \"no-store\")\n")
+	b.WriteString("\tio.WriteString(w, b.String())\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// genMetricsPlugin generates the GORM plugin timing the queries of each
+// operation, registered around the callback running the query
+func (g *Generator) genMetricsPlugin() string {
+	var b strings.Builder
+
+	b.WriteString("// queryStartKey holds the start of a query in its statement\n")
+	b.WriteString("const queryStartKey = \"gmx:metrics_start\"\n\n")
+
+	b.WriteString("// metricsPlugin is a GORM plugin timing the queries of each operation and table\n")
+	b.WriteString("type metricsPlugin struct{}\n\n")
+	b.WriteString("func (metricsPlugin) Name() string { return \"gmx:metrics\" }\n\n")
+	b.WriteString("// Initialize registers the timer of each operation around the callback running its query\n")
+	b.WriteString("func (metricsPlugin) Initialize(db *gorm.DB) error {\n")
+	b.WriteString("\tcb := db.Callback()\n")
+	b.WriteString("\tfor _, err := range []error{\n")
+	for _, op := range queryOperations {
+		name := strings.ToLower(op.processor)
+		b.WriteString(fmt.Sprintf("\t\tcb.%s().Before(%q).Register(\"gmx:metrics_start_%s\", startQueryTimer),\n", op.processor, op.callback, name))
+		b.WriteString(fmt.Sprintf("\t\tcb.%s().After(%q).Register(\"gmx:metrics_observe_%s\", observeQuery(%q)),\n", op.processor, op.callback, name, name))
+	}
+	b.WriteString("\t} {\n")
+	b.WriteString("\t\tif err != nil {\n")
+	b.WriteString("\t\t\treturn err\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn nil\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("func startQueryTimer(tx *gorm.DB) {\n")
+	b.WriteString("\ttx.InstanceSet(queryStartKey, time.Now())\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// observeQuery returns the callback recording the duration of the queries of\n")
+	b.WriteString("// an operation, by table\n")
+	b.WriteString("func observeQuery(operation string) func(*gorm.DB) {\n")
+	b.WriteString("\treturn func(tx *gorm.DB) {\n")
+	b.WriteString("\t\tstart, ok := tx.InstanceGet(queryStartKey)\n")
+	b.WriteString("\t\tif !ok {\n")
+	b.WriteString("\t\t\treturn\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tseconds := time.Since(start.(time.Time)).Seconds()\n")
+	b.WriteString("\t\tkey := [2]string{operation, tx.Statement.Table}\n")
+	b.WriteString("\t\tmetricsMu.Lock()\n")
+	b.WriteString("\t\th := queryLatency[key]\n")
+	b.WriteString("\t\tif h == nil {\n")
+	b.WriteString("\t\t\th = &metricsHistogram{}\n")
+	b.WriteString("\t\t\tqueryLatency[key] = h\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\th.observe(seconds)\n")
+	b.WriteString("\t\tmetricsMu.Unlock()\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
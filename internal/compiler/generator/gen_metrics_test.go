@@ -0,0 +1,119 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// metricsObservability is the observability block turning metrics on
+var metricsObservability = &ast.ObservabilityDecl{Options: []*ast.ServerOption{{Name: "metrics", Value: "true"}}}
+
+func TestGenerateMetrics(t *testing.T) {
+	file := strictFile(t, strictModels+`
+func createTask(title: string) error {
+  const task = Task{title: title}
+  try task.save()
+  return render(task)
+}
+`, `<form hx-post="{{route "createTask"}}"></form>`)
+	file.Observability = metricsObservability
+
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Fatalf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, want := range []string{
+		// Each route is counted and timed, the metrics endpoint aside
+		"\tmux.HandleFunc(\"/api/createTask\", instrumentRoute(\"/api/createTask\", handleCreateTask))\n",
+		"\tmux.HandleFunc(\"/metrics\", handleMetrics)\n",
+		"\t\t\tm.requests[[2]string{method, strconv.Itoa(status)}]++\n",
+		"# TYPE gmx_http_request_duration_seconds histogram",
+		"# TYPE gmx_http_requests_in_flight gauge",
+		// Queries are timed by a GORM plugin, migrations included
+		"\tif err := db.Use(metricsPlugin{}); err != nil {\n",
+		"\t\tcb.Query().Before(\"gorm:query\").Register(\"gmx:metrics_start_query\", startQueryTimer),\n",
+		"\t\tcb.Query().After(\"gorm:query\").Register(\"gmx:metrics_observe_query\", observeQuery(\"query\")),\n",
+		"# TYPE gmx_db_query_duration_seconds histogram",
+		"text/plain; version=0.0.4; charset=utf-8",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("Generated code missing %q", want)
+		}
+	}
+	if strings.Contains(code, "instrumentRoute(\"/metrics\"") {
+		t.Error("the metrics endpoint should not count itself")
+	}
+}
+
+func TestGenerateMetricsOff(t *testing.T) {
+	file := strictFile(t, strictModels, `<p>Hello</p>`)
+	file.Observability = &ast.ObservabilityDecl{Options: []*ast.ServerOption{{Name: "metrics", Value: "false"}}}
+
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	for _, unwanted := range []string{"instrumentRoute", "metricsPlugin", "handleMetrics"} {
+		if strings.Contains(code, unwanted) {
+			t.Errorf("Generated code should not contain %q without metrics", unwanted)
+		}
+	}
+}
+
+func TestGenerateMetricsFailover(t *testing.T) {
+	file := failoverTestFile("postgres")
+	file.Observability = metricsObservability
+
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Fatalf("Generated code is not valid Go:\n%s", code)
+	}
+	// The standby's queries are timed once it takes over
+	if !strings.Contains(code, "\treturn conn, conn.Use(metricsPlugin{})\n") {
+		t.Error("expected openDatabase to register the metrics plugin")
+	}
+}
+
+func TestGenerateMetricsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(file *ast.GMXFile)
+		wantErr string
+	}{
+		{
+			name: "colliding function",
+			modify: func(file *ast.GMXFile) {
+				file.Script.Funcs = append(file.Script.Funcs, &ast.FuncDecl{Name: "metrics", ReturnType: "error", Line: 7})
+			},
+			wantErr: "line 7: function metrics collides with the built-in /metrics endpoint",
+		},
+		{
+			name: "colliding page",
+			modify: func(file *ast.GMXFile) {
+				file.Pages = []*ast.Page{{Path: "/app/metrics.gmx", Route: "/metrics", Template: &ast.TemplateBlock{Source: `<p>Metrics</p>`}}}
+			},
+			wantErr: "page /app/metrics.gmx is served at /metrics",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := strictFile(t, strictModels, `<p>Hello</p>`)
+			file.Observability = metricsObservability
+			tt.modify(file)
+			_, err := New().Generate(file)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// migrationTable records the migrations applied to a database
+const migrationTable = "gmx_schema_migrations"
+
+// hasMigrations checks if the build applies SQL migrations instead of AutoMigrate
+func (g *Generator) hasMigrations(file *ast.GMXFile) bool {
+	return len(g.opts.Migrations) > 0 && len(file.Models) > 0
+}
+
+// genMigrations generates the table names the migrations were written for,
+// the embedded migrations and their runner
+func (g *Generator) genMigrations(file *ast.GMXFile) string {
+	var b strings.Builder
+
+	// GORM pluralizes irregular names on its own: the tables are pinned to
+	// the names of the migrations
+	for _, model := range file.Models {
+		b.WriteString(fmt.Sprintf("// TableName is the table of %s in the migrations\n", model.Name))
+		b.WriteString(fmt.Sprintf("func (%s) TableName() string {\n", model.Name))
+		b.WriteString(fmt.Sprintf("\treturn %q\n", tableName(model.Name)))
+		b.WriteString("}\n\n")
+	}
+
+	migrations := append([]Migration(nil), g.opts.Migrations...)
+	SortMigrations(migrations)
+
+	b.WriteString("// migration is a versioned schema change, written by gmx migrate\n")
+	b.WriteString("type migration struct {\n")
+	b.WriteString("\tversion  int\n")
+	b.WriteString("\tname     string\n")
+	b.WriteString("\tup, down string\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// migrations are applied in order, each one once\n")
+	b.WriteString("var migrations = []migration{\n")
+	for _, m := range migrations {
+		b.WriteString(fmt.Sprintf("\t{%d, %q, %q, %q},\n", m.Version, m.Name, m.Up, m.Down))
+	}
+	b.WriteString("}\n\n")
+
+	b.WriteString("// migrationStatements splits the SQL of a migration into statements,\n")
+	b.WriteString("// each ending with a semicolon at the end of a line\n")
+	b.WriteString("func migrationStatements(sql string) []string {\n")
+	b.WriteString("\tvar statements []string\n")
+	b.WriteString("\tfor _, chunk := range strings.Split(sql, \";\\n\") {\n")
+	b.WriteString("\t\tvar lines []string\n")
+	b.WriteString("\t\tfor _, line := range strings.Split(chunk, \"\\n\") {\n")
+	b.WriteString("\t\t\tif trimmed := strings.TrimSpace(line); trimmed != \"\" && !strings.HasPrefix(trimmed, \"--\") {\n")
+	b.WriteString("\t\t\t\tlines = append(lines, line)\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tif statement := strings.TrimSuffix(strings.TrimSpace(strings.Join(lines, \"\\n\")), \";\"); statement != \"\" {\n")
+	b.WriteString("\t\t\tstatements = append(statements, statement)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn statements\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// appliedMigration returns the version of the last migration applied to the database\n")
+	b.WriteString("func appliedMigration(conn *gorm.DB) (int, error) {\n")
+	b.WriteString(fmt.Sprintf("\tif err := conn.Exec(\"CREATE TABLE IF NOT EXISTS %s (version INTEGER PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at TIMESTAMP NOT NULL)\").Error; err != nil {\n", migrationTable))
+	b.WriteString("\t\treturn 0, err\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tvar version int\n")
+	b.WriteString(fmt.Sprintf("\terr := conn.Raw(\"SELECT COALESCE(MAX(version), 0) FROM %s\").Scan(&version).Error\n", migrationTable))
+	b.WriteString("\treturn version, err\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// execMigration runs the statements of a migration in a transaction, then records it\n")
+	b.WriteString("func execMigration(conn *gorm.DB, m migration, sql string, record func(tx *gorm.DB) error) error {\n")
+	b.WriteString("\treturn conn.Transaction(func(tx *gorm.DB) error {\n")
+	b.WriteString("\t\tfor _, statement := range migrationStatements(sql) {\n")
+	b.WriteString("\t\t\tif err := tx.Exec(statement).Error; err != nil {\n")
+	b.WriteString("\t\t\t\treturn fmt.Errorf(\"migration %04d_%s: %w\", m.version, m.name, err)\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\treturn record(tx)\n")
+	b.WriteString("\t})\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// runMigrations applies the migrations a database has not seen yet\n")
+	b.WriteString("func runMigrations(conn *gorm.DB) error {\n")
+	b.WriteString("\tapplied, err := appliedMigration(conn)\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\treturn err\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tfor _, m := range migrations {\n")
+	b.WriteString("\t\tif m.version <= applied {\n")
+	b.WriteString("\t\t\tcontinue\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\terr := execMigration(conn, m, m.up, func(tx *gorm.DB) error {\n")
+	b.WriteString(fmt.Sprintf("\t\t\treturn tx.Exec(\"INSERT INTO %s (version, name, applied_at) VALUES (?, ?, ?)\", m.version, m.name, time.Now()).Error\n", migrationTable))
+	b.WriteString("\t\t})\n")
+	b.WriteString("\t\tif err != nil {\n")
+	b.WriteString("\t\t\treturn err\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tslog.Info(\"migration applied\", \"version\", m.version, \"name\", m.name)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn nil\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// revertMigration reverts the last migration applied to a database\n")
+	b.WriteString("func revertMigration(conn *gorm.DB) error {\n")
+	b.WriteString("\tapplied, err := appliedMigration(conn)\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\treturn err\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tfor _, m := range migrations {\n")
+	b.WriteString("\t\tif m.version != applied {\n")
+	b.WriteString("\t\t\tcontinue\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\terr := execMigration(conn, m, m.down, func(tx *gorm.DB) error {\n")
+	b.WriteString(fmt.Sprintf("\t\t\treturn tx.Exec(\"DELETE FROM %s WHERE version = ?\", m.version).Error\n", migrationTable))
+	b.WriteString("\t\t})\n")
+	b.WriteString("\t\tif err != nil {\n")
+	b.WriteString("\t\t\treturn err\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tslog.Info(\"migration reverted\", \"version\", m.version, \"name\", m.name)\n")
+	b.WriteString("\t\treturn nil\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif applied == 0 {\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"no migration to revert\")\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn fmt.Errorf(\"migration %d is not part of this build\", applied)\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
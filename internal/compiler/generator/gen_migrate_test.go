@@ -0,0 +1,94 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// migrationsTestFile returns a file with a model and a page listing it
+func migrationsTestFile() *ast.GMXFile {
+	return &ast.GMXFile{
+		Models: []*ast.ModelDecl{{
+			Name: "Category",
+			Fields: []*ast.FieldDecl{
+				{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{{Name: "pk"}}},
+				{Name: "name", Type: "string"},
+			},
+		}},
+		Template: &ast.TemplateBlock{Source: `<ul>{{range .Categorys}}<li>{{.Name}}</li>{{end}}</ul>`},
+	}
+}
+
+func TestGenerateMigrations(t *testing.T) {
+	migrations := []Migration{
+		{Version: 2, Name: "add_categories_slug", Up: "ALTER TABLE \"categories\" ADD COLUMN \"slug\" text;\n", Down: "ALTER TABLE \"categories\" DROP COLUMN \"slug\";\n"},
+		{Version: 1, Name: "init", Up: "CREATE TABLE \"categories\" (\n  \"id\" text\n);\n", Down: "DROP TABLE \"categories\";\n"},
+	}
+	code, err := NewWithOptions(Options{Migrations: migrations}).Generate(migrationsTestFile())
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, want := range []string{
+		// Tables keep the names the migrations were written for
+		"func (Category) TableName() string {\n\treturn \"categories\"\n}",
+		// Migrations are embedded in version order
+		`{1, "init", "CREATE TABLE \"categories\" (\n  \"id\" text\n);\n", "DROP TABLE \"categories\";\n"},` + "\n\t{2, ",
+		"if err := runMigrations(db); err != nil {",
+		`if len(os.Args) > 2 && os.Args[1] == "migrate" && os.Args[2] == "down" {`,
+		`"INSERT INTO gmx_schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+	if strings.Contains(code, "db.AutoMigrate(") {
+		t.Errorf("builds with migrations should not run AutoMigrate")
+	}
+}
+
+func TestGenerateWithoutMigrations(t *testing.T) {
+	code, err := New().Generate(migrationsTestFile())
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !strings.Contains(code, "db.AutoMigrate(&Category{})") || strings.Contains(code, "runMigrations") {
+		t.Errorf("builds without migrations should keep AutoMigrate")
+	}
+}
+
+func TestValidateMigrations(t *testing.T) {
+	tests := []struct {
+		name       string
+		migrations []Migration
+		annotate   func(*ast.GMXFile)
+		wantErr    string
+	}{
+		{"duplicate version", []Migration{{Version: 1, Name: "init"}, {Version: 1, Name: "again"}}, nil, "migrations 0001_init and 0001_again share version 1"},
+		{"zero version", []Migration{{Version: 0, Name: "init"}}, nil, "migration 0000_init: versions start at 1"},
+		{"renamed model", nil, func(f *ast.GMXFile) {
+			f.Models[0].Annotations = []*ast.Annotation{{Name: "renamedFrom", Args: map[string]string{"_": "old name"}}}
+		}, "model Category: @renamedFrom takes the previous model name"},
+		{"renamed field", nil, func(f *ast.GMXFile) {
+			f.Models[0].Fields[1].Annotations = []*ast.Annotation{{Name: "renamedFrom"}}
+		}, "field Category.name: @renamedFrom takes the previous field name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := migrationsTestFile()
+			if tt.annotate != nil {
+				tt.annotate(file)
+			}
+			_, err := NewWithOptions(Options{Migrations: tt.migrations}).Generate(file)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package generator
+
+import (
+	"strings"
+)
+
+// rawTextTags are elements whose content is copied as-is by minifyHTML
+var rawTextTags = []string{"pre", "textarea", "script"}
+
+// minifyHTML strips HTML comments and collapses whitespace runs of a page
+// template; template actions and <pre>, <textarea> and <script> contents
+// are kept verbatim, and <style> contents are minified as CSS
+func minifyHTML(src string) string {
+	var b strings.Builder
+	space := false // a whitespace run is pending
+	flushSpace := func() {
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+	}
+
+	for i := 0; i < len(src); {
+		rest := src[i:]
+		switch {
+		case strings.HasPrefix(rest, "{{"):
+			end := strings.Index(rest, "}}")
+			if end == -1 {
+				end = len(rest) - 2
+			}
+			flushSpace()
+			b.WriteString(rest[:end+2])
+			i += end + 2
+			continue
+		case strings.HasPrefix(rest, "<!--"):
+			end := strings.Index(rest, "-->")
+			if end == -1 {
+				return b.String()
+			}
+			i += end + 3
+			continue
+		case rest[0] == '<':
+			if tag, ok := openingTag(rest, "style"); ok {
+				end := closingTagIndex(rest, "style")
+				flushSpace()
+				b.WriteString(tag)
+				b.WriteString(minifyCSS(rest[len(tag):end]))
+				i += end
+				continue
+			}
+			if raw := rawTextElement(rest); raw != "" {
+				flushSpace()
+				b.WriteString(raw)
+				i += len(raw)
+				continue
+			}
+		case isHTMLSpace(rest[0]):
+			space = true
+			i++
+			continue
+		}
+		flushSpace()
+		b.WriteByte(src[i])
+		i++
+	}
+	return b.String()
+}
+
+// rawTextElement returns the whole element starting src when it is one of
+// rawTextTags, or ""
+func rawTextElement(src string) string {
+	for _, name := range rawTextTags {
+		if _, ok := openingTag(src, name); ok {
+			end := closingTagIndex(src, name)
+			return src[:end+len(closingTag(src[end:]))]
+		}
+	}
+	return ""
+}
+
+// openingTag returns the opening tag starting src when it opens the named element
+func openingTag(src, name string) (string, bool) {
+	if len(src) < len(name)+2 || !strings.EqualFold(src[1:len(name)+1], name) {
+		return "", false
+	}
+	if c := src[len(name)+1]; c != '>' && !isHTMLSpace(c) {
+		return "", false
+	}
+	end := strings.IndexByte(src, '>')
+	if end == -1 {
+		return "", false
+	}
+	return src[:end+1], true
+}
+
+// closingTagIndex returns the index of the named element's closing tag in src,
+// or len(src) when it is missing
+func closingTagIndex(src, name string) int {
+	end := strings.Index(strings.ToLower(src), "</"+name)
+	if end == -1 {
+		return len(src)
+	}
+	return end
+}
+
+// closingTag returns the closing tag starting src, or ""
+func closingTag(src string) string {
+	end := strings.IndexByte(src, '>')
+	if end == -1 {
+		return src
+	}
+	return src[:end+1]
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// minifyCSS strips comments and insignificant whitespace from a stylesheet;
+// strings and template actions are kept verbatim
+func minifyCSS(src string) string {
+	var out []byte
+	space := false // a whitespace run is pending
+	flushSpace := func() {
+		if space && len(out) > 0 && strings.IndexByte("{};,>:", out[len(out)-1]) == -1 {
+			out = append(out, ' ')
+		}
+		space = false
+	}
+
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end == -1 {
+				return string(out)
+			}
+			i += end + 3
+			space = true
+		case strings.HasPrefix(src[i:], "{{"):
+			end := strings.Index(src[i:], "}}")
+			if end == -1 {
+				end = len(src) - i - 2
+			}
+			flushSpace()
+			out = append(out, src[i:i+end+2]...)
+			i += end + 1
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(src) && src[end] != c {
+				if src[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			end = min(end, len(src)-1)
+			flushSpace()
+			out = append(out, src[i:end+1]...)
+			i = end
+		case isHTMLSpace(c):
+			space = true
+		case strings.IndexByte("{};,>", c) != -1:
+			// Whitespace around separators is insignificant; so is the last semicolon of a block
+			space = false
+			if c == '}' && len(out) > 0 && out[len(out)-1] == ';' {
+				out = out[:len(out)-1]
+			}
+			out = append(out, c)
+		default:
+			flushSpace()
+			out = append(out, c)
+		}
+	}
+	return string(out)
+}
@@ -0,0 +1,116 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+func TestMinifyCSS(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "whitespace and last semicolon",
+			src:  ".card {\n  padding: 1rem;\n  margin: 0 auto;\n}\n",
+			want: ".card{padding:1rem;margin:0 auto}",
+		},
+		{
+			name: "comments",
+			src:  "/* layout */\n.a { color: red; } /* end */",
+			want: ".a{color:red}",
+		},
+		{
+			name: "selectors",
+			src:  "ul > li,\n.a :hover { x: y }",
+			want: "ul>li,.a :hover{x:y}",
+		},
+		{
+			name: "strings and calc",
+			src:  ".a::after { content: \"  }  \"; width: calc(100% - 2rem); }",
+			want: ".a::after{content:\"  }  \";width:calc(100% - 2rem)}",
+		},
+		{
+			name: "media",
+			src:  "@media (min-width: 40rem) {\n  .a { padding: 0; }\n}",
+			want: "@media (min-width:40rem){.a{padding:0}}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := minifyCSS(tt.src); got != tt.want {
+				t.Errorf("minifyCSS() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMinifyHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "whitespace runs",
+			src:  "<ul>\n    <li>a</li>\n    <li>b</li>\n</ul>\n",
+			want: "<ul> <li>a</li> <li>b</li> </ul>",
+		},
+		{
+			name: "comments",
+			src:  "<div><!-- note -->\n  <p>x</p></div>",
+			want: "<div> <p>x</p></div>",
+		},
+		{
+			name: "template actions",
+			src:  "<p>\n  {{if .Done}}  done  {{end}}\n  {{ printf \"%s   %s\" .A .B }}</p>",
+			want: "<p> {{if .Done}} done {{end}} {{ printf \"%s   %s\" .A .B }}</p>",
+		},
+		{
+			name: "raw text elements",
+			src:  "<pre>\n  a\n    b\n</pre>\n<script>\n  var x = 1;\n</script>",
+			want: "<pre>\n  a\n    b\n</pre> <script>\n  var x = 1;\n</script>",
+		},
+		{
+			name: "style",
+			src:  "<style>\n  .a { color: red; }\n</style>",
+			want: "<style>.a{color:red}</style>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := minifyHTML(tt.src); got != tt.want {
+				t.Errorf("minifyHTML() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateMinify(t *testing.T) {
+	file := &ast.GMXFile{
+		Template: &ast.TemplateBlock{Source: "<ul>\n  <!-- tasks -->\n  <li>{{.CSRFToken}}</li>\n</ul>"},
+		Style:    &ast.StyleBlock{Source: ".card {\n  padding: 1rem;\n}"},
+	}
+
+	code, err := NewWithOptions(Options{Minify: true}).Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, exp := range []string{"<ul> <li>{{.CSRFToken}}</li> </ul>", ".card{padding:1rem}"} {
+		if !strings.Contains(code, exp) {
+			t.Errorf("Generated code missing %q", exp)
+		}
+	}
+	if strings.Contains(code, "<!-- tasks -->") {
+		t.Error("HTML comments should be stripped")
+	}
+}
@@ -0,0 +1,193 @@
+package generator
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// modalKinds are the dialogs {{modal}} opens
+var modalKinds = []string{"Confirm"}
+
+// modalPathPrefix is the prefix of the built-in endpoints rendering and closing the dialogs
+const modalPathPrefix = "/_gmx/modal/"
+
+// modalRoutes are the built-in endpoints of the dialogs
+var modalRoutes = []Route{
+	{Method: "GET", Path: modalPathPrefix + "Confirm", Handler: "handleConfirmModal"},
+	{Method: "GET", Path: modalPathPrefix + "close", Handler: "handleModalClose"},
+}
+
+// modalCall matches {{modal "Kind" "message" "action"}} calls, the action
+// being captured when the message is a literal
+var modalCall = regexp.MustCompile(`\{\{-?\s*modal\s+"([^"]*)"(?:\s+"(?:[^"\\]|\\.)*"\s+"([^"]*)")?`)
+
+// modalScript opens the dialogs of the {{modal}} triggers instead of the
+// browser prompt of hx-confirm, traps the focus inside them and closes
+// them with Escape; their trigger sends its request once confirmed
+const modalScript = `<style>
+.gmx-modal { position: fixed; inset: 0; display: flex; align-items: center; justify-content: center; background: rgba(0, 0, 0, 0.4); z-index: 1000; }
+.gmx-modal-dialog { background: #fff; padding: 1.5rem; border-radius: 0.5rem; max-width: 28rem; }
+.gmx-modal-actions { display: flex; justify-content: flex-end; gap: 0.5rem; margin-top: 1rem; }
+</style>
+<script>
+(function() {
+  var pending = null;
+  function currentModal() {
+    var modals = document.querySelectorAll('.gmx-modal');
+    return modals[modals.length - 1];
+  }
+  document.addEventListener('htmx:confirm', function(e) {
+    var kind = e.detail.elt.getAttribute('data-gmx-modal');
+    if (!kind) return;
+    e.preventDefault();
+    pending = {trigger: e.detail.elt, issueRequest: e.detail.issueRequest};
+    htmx.ajax('GET', '/_gmx/modal/' + kind + '?message=' + encodeURIComponent(e.detail.question), {target: 'body', swap: 'beforeend'}).then(function() {
+      var modal = currentModal();
+      if (modal) modal.querySelector('[data-gmx-modal-cancel]').focus();
+    });
+  });
+  document.addEventListener('click', function(e) {
+    var button = e.target.closest('[data-gmx-modal-confirm], [data-gmx-modal-cancel]');
+    if (!button || !pending) return;
+    var request = pending;
+    pending = null;
+    request.trigger.focus();
+    if (button.hasAttribute('data-gmx-modal-confirm')) {
+      button.closest('.gmx-modal').remove();
+      request.issueRequest(true);
+    }
+  });
+  document.addEventListener('keydown', function(e) {
+    var modal = currentModal();
+    if (!modal) return;
+    if (e.key === 'Escape') {
+      modal.querySelector('[data-gmx-modal-cancel]').click();
+      return;
+    }
+    if (e.key !== 'Tab') return;
+    var buttons = modal.querySelectorAll('button');
+    var first = buttons[0], last = buttons[buttons.length - 1];
+    if (!modal.contains(document.activeElement) || (!e.shiftKey && document.activeElement === last)) {
+      first.focus();
+      e.preventDefault();
+    } else if (e.shiftKey && document.activeElement === first) {
+      last.focus();
+      e.preventDefault();
+    }
+  });
+})();
+</script>`
+
+// hasModal checks if a page template opens a dialog with {{modal}}
+func (g *Generator) hasModal(file *ast.GMXFile) bool {
+	for _, src := range pageTemplateSources(file) {
+		if modalCall.MatchString(src) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateModals checks the dialogs and actions of the {{modal}} calls
+func (g *Generator) validateModals(file *ast.GMXFile) error {
+	if !g.hasModal(file) {
+		return nil
+	}
+	handlers := make(map[string]bool)
+	if file.Script != nil {
+		for _, fn := range file.Script.Funcs {
+			if isHandler(fn) {
+				handlers[fn.Name] = true
+			}
+			for _, route := range modalRoutes {
+				if "handle"+utils.Capitalize(fn.Name) == route.Handler {
+					return fmt.Errorf("line %d: function %s collides with the built-in %s endpoint; rename it", fn.Line, fn.Name, route.Path)
+				}
+			}
+		}
+	}
+	for _, src := range pageTemplateSources(file) {
+		for _, m := range modalCall.FindAllStringSubmatch(src, -1) {
+			known := false
+			for _, kind := range modalKinds {
+				known = known || m[1] == kind
+			}
+			if !known {
+				return fmt.Errorf("template: {{modal %q}} is not a dialog (expected %s)", m[1], strings.Join(modalKinds, ", "))
+			}
+			if m[2] != "" && !handlers[m[2]] {
+				return fmt.Errorf("template: {{modal %q}} confirms %s, which is not a script function", m[1], m[2])
+			}
+		}
+	}
+	return nil
+}
+
+// injectModalScript adds the script of the dialogs at the end of a page
+func injectModalScript(src string) string {
+	if idx := strings.LastIndex(strings.ToLower(src), "</body>"); idx != -1 {
+		return src[:idx] + modalScript + "\n" + src[idx:]
+	}
+	return src + "\n" + modalScript
+}
+
+// genModals generates the {{modal}} template function and the endpoints
+// rendering and closing the dialogs
+func (g *Generator) genModals(file *ast.GMXFile) string {
+	var b strings.Builder
+
+	b.WriteString("// modalActions maps the script functions to the method and path of their route\n")
+	b.WriteString("var modalActions = map[string][2]string{\n")
+	if file.Script != nil {
+		for _, fn := range file.Script.Funcs {
+			if isHandler(fn) {
+				b.WriteString(fmt.Sprintf("\t%q: {%q, %q},\n", fn.Name, strings.ToLower(handlerMethod(fn)), routePath(fn)))
+			}
+		}
+	}
+	b.WriteString("}\n\n")
+
+	b.WriteString("// modalTrigger returns the attributes of an element sending the request of\n")
+	b.WriteString("// the action function once the message is confirmed in a dialog\n")
+	b.WriteString("func modalTrigger(kind, message, action string) (template.HTMLAttr, error) {\n")
+	b.WriteString("\tif kind != \"Confirm\" {\n")
+	b.WriteString("\t\treturn \"\", fmt.Errorf(\"modal: unknown dialog %q, expected Confirm\", kind)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\troute, ok := modalActions[action]\n")
+	b.WriteString("\tif !ok {\n")
+	b.WriteString("\t\treturn \"\", fmt.Errorf(\"modal: action %q is not a script function\", action)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn template.HTMLAttr(fmt.Sprintf(`hx-%s=\"%s\" hx-confirm=\"%s\" data-gmx-modal=\"%s\"`,\n")
+	b.WriteString("\t\troute[0], route[1], template.HTMLEscapeString(message), kind)), nil\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// handleConfirmModal renders the confirmation dialog of ?message=; the\n")
+	b.WriteString("// page script sends the request of its trigger once confirmed\n")
+	b.WriteString("func handleConfirmModal(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString("\tif r.Method != http.MethodGet {\n")
+	b.WriteString("\t\thttp.Error(w, \"Method Not Allowed\", http.StatusMethodNotAllowed)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/html; charset=utf-8\")\n")
+	b.WriteString("\tfmt.Fprintf(w, `<div class=\"gmx-modal\" role=\"alertdialog\" aria-modal=\"true\" aria-labelledby=\"gmx-modal-message\">`+\n")
+	b.WriteString("\t\t`<div class=\"gmx-modal-dialog\"><p id=\"gmx-modal-message\">%s</p><div class=\"gmx-modal-actions\">`+\n")
+	b.WriteString(fmt.Sprintf("\t\t`<button type=\"button\" data-gmx-modal-cancel hx-get=\"%sclose\" hx-target=\"closest .gmx-modal\" hx-swap=\"outerHTML\">Cancel</button>`+\n", modalPathPrefix))
+	b.WriteString("\t\t`<button type=\"button\" data-gmx-modal-confirm>Confirm</button></div></div></div>`,\n")
+	b.WriteString("\t\ttemplate.HTMLEscapeString(r.URL.Query().Get(\"message\")))\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// handleModalClose answers a cancelled dialog with no content, which removes it\n")
+	b.WriteString("func handleModalClose(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString("\tif r.Method != http.MethodGet {\n")
+	b.WriteString("\t\thttp.Error(w, \"Method Not Allowed\", http.StatusMethodNotAllowed)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/html; charset=utf-8\")\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
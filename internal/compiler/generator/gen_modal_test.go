@@ -0,0 +1,69 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+)
+
+const modalScriptSrc = `model Task {
+  id: uuid @pk @default(uuid_v4)
+  title: string
+}
+func deleteTask(id: uuid) error {
+  let task = try Task.find(id)
+  try task.delete()
+  return nil
+}`
+
+func TestGenerateModal(t *testing.T) {
+	file := scriptTestFile(t, modalScriptSrc, signedTemplateSrc)
+	file.Template.Source = `<ul>{{range .Tasks}}<li><button {{modal "Confirm" "Delete this task?" "deleteTask"}} hx-vals='{"id": "{{.ID}}"}' hx-target="closest li">Delete</button></li>{{end}}</ul>`
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, want := range []string{
+		`"modal":`,
+		`"deleteTask": {"delete", "/api/deleteTask"},`,
+		"hx-%s=\"%s\" hx-confirm=\"%s\" data-gmx-modal=\"%s\"",
+		`mux.HandleFunc("/_gmx/modal/Confirm", handleConfirmModal)`,
+		`mux.HandleFunc("/_gmx/modal/close", handleModalClose)`,
+		`role="alertdialog" aria-modal="true"`,
+		// The page opens the dialog instead of the browser prompt
+		"document.addEventListener('htmx:confirm', function(e) {",
+		"request.issueRequest(true);",
+		"if (e.key === 'Escape') {",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+}
+
+func TestValidateModals(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		template string
+		wantErr  string
+	}{
+		{"unknown dialog", modalScriptSrc, `<button {{modal "Alert" "Deleted" "deleteTask"}}>x</button>`, `template: {{modal "Alert"}} is not a dialog (expected Confirm)`},
+		{"unknown action", modalScriptSrc, `<button {{modal "Confirm" "Delete?" "removeTask"}}>x</button>`, `template: {{modal "Confirm"}} confirms removeTask, which is not a script function`},
+		{"func", modalScriptSrc + "\nfunc confirmModal() error {\n  return nil\n}", `<button {{modal "Confirm" "Delete?" "deleteTask"}}>x</button>`, "line 10: function confirmModal collides with the built-in /_gmx/modal/Confirm endpoint"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := scriptTestFile(t, tt.src, signedTemplateSrc)
+			file.Template.Source = tt.template
+			_, err := New().Generate(file)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
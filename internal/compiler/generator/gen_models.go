@@ -15,14 +15,29 @@ func (g *Generator) genModels(models []*ast.ModelDecl) string {
 		if i > 0 {
 			b.WriteString("\n")
 		}
+		b.WriteString(g.genEnums(model))
 		b.WriteString(fmt.Sprintf("type %s struct {\n", model.Name))
 
 		for _, field := range model.Fields {
 			// Convert field name to PascalCase
 			fieldName := utils.ToPascalCase(field.Name)
 			goType := g.mapType(field.Type)
+			if isMoneyField(field) {
+				goType = "Money"
+			}
+			if isEnumField(field) {
+				goType = enumTypeName(model, field)
+			}
 			jsonTag := field.Name
-			gormTags := g.genGormTags(field, model.Name)
+			if field.Type == "password" {
+				// Password hashes must never leave the server
+				jsonTag = "-"
+			}
+			if isBytesField(field) {
+				// Binary payloads are served on purpose, not embedded in JSON
+				jsonTag = "-"
+			}
+			gormTags := g.genGormTags(field, model)
 
 			// Build the tag string
 			var tags []string
@@ -34,6 +49,17 @@ func (g *Generator) genModels(models []*ast.ModelDecl) string {
 
 			b.WriteString(fmt.Sprintf("\t%s %s `%s`\n", fieldName, goType, tagString))
 		}
+		// The hashes loaded from the database, which GORM does not map
+		var stored []string
+		for _, field := range model.Fields {
+			if field.Type == "password" {
+				stored = append(stored, fmt.Sprintf("\t%s string\n", storedHashField(utils.ToPascalCase(field.Name))))
+			}
+		}
+		if len(stored) > 0 {
+			b.WriteString("\n\t// Password hashes as loaded from the database, never hashed again\n")
+			b.WriteString(strings.Join(stored, ""))
+		}
 
 		b.WriteString("}\n")
 
@@ -49,20 +75,105 @@ func (g *Generator) genModels(models []*ast.ModelDecl) string {
 		if beforeCreate != "" {
 			b.WriteString(beforeCreate)
 		}
+
+		// Generate BeforeSave hook (password hashing) and the AfterFind hook
+		// recording the loaded hashes
+		beforeSave := g.genBeforeSave(model)
+		if beforeSave != "" {
+			b.WriteString(beforeSave)
+			b.WriteString(g.genAfterFind(model))
+		}
+
+		// Generate the hooks recording @feedItem events
+		b.WriteString(g.genFeedHooks(model))
 	}
 
 	return b.String()
 }
 
+// timestampFields are the fields @timestamps adds to a model, with the GORM
+// tag keeping each of them up to date
+var timestampFields = []struct{ name, tag string }{
+	{"createdAt", "autoCreateTime"},
+	{"updatedAt", "autoUpdateTime"},
+}
+
+// validateTimestamps checks the models marked @timestamps: the annotation
+// takes no arguments and the fields it adds are not declared by hand
+func (g *Generator) validateTimestamps(file *ast.GMXFile) error {
+	for _, model := range file.Models {
+		ann := model.FindAnnotation("timestamps")
+		if ann == nil {
+			continue
+		}
+		if len(ann.Args) > 0 {
+			return fmt.Errorf("line %d: model %s: @timestamps takes no arguments", model.Line, model.Name)
+		}
+		for _, ts := range timestampFields {
+			if field := model.FindField(ts.name); field != nil {
+				return fmt.Errorf("line %d: model %s: field %s is added by @timestamps; remove it", field.Line, model.Name, ts.name)
+			}
+		}
+	}
+	return nil
+}
+
+// withTimestamps returns the file with the createdAt and updatedAt fields of
+// the models marked @timestamps, set by GORM on create and on every save
+func (g *Generator) withTimestamps(file *ast.GMXFile) *ast.GMXFile {
+	withFields := *file
+	withFields.Models = make([]*ast.ModelDecl, len(file.Models))
+	for i, model := range file.Models {
+		withFields.Models[i] = model
+		if model.FindAnnotation("timestamps") == nil {
+			continue
+		}
+		copied := *model
+		copied.Fields = append([]*ast.FieldDecl{}, model.Fields...)
+		for _, ts := range timestampFields {
+			copied.Fields = append(copied.Fields, &ast.FieldDecl{
+				Name:        ts.name,
+				Type:        "datetime",
+				Annotations: []*ast.Annotation{{Name: ts.tag, Args: map[string]string{}}},
+				Line:        model.Line,
+			})
+		}
+		withFields.Models[i] = &copied
+	}
+	return &withFields
+}
+
 // genValidation generates a Validate() method for a model
 func (g *Generator) genValidation(model *ast.ModelDecl) string {
 	var validations []string
 
 	// Scan fields for validation annotations
+	recv := utils.ReceiverName(model.Name)
 	for _, field := range model.Fields {
 		fieldName := utils.ToPascalCase(field.Name)
 		fieldType := field.Type
 
+		// Money bounds are given in currency units and checked in cents
+		if isMoneyField(field) {
+			validations = append(validations, moneyValidation(recv, fieldName, field)...)
+			continue
+		}
+		if isEnumField(field) {
+			validations = append(validations, enumValidation(recv, fieldName, field))
+			continue
+		}
+		if fieldType == "password" {
+			validations = append(validations, passwordValidation(recv, fieldName, field))
+			continue
+		}
+		if isBytesField(field) {
+			validations = append(validations, blobValidation(recv, fieldName, field)...)
+			if isImageField(field) {
+				validations = append(validations, imageValidation(recv, fieldName, field))
+			}
+			continue
+		}
+
 		for _, ann := range field.Annotations {
 			switch ann.Name {
 			case "min":
@@ -70,16 +181,12 @@ func (g *Generator) genValidation(model *ast.ModelDecl) string {
 				if minVal != "" {
 					// For string fields, check length
 					if fieldType == "string" {
-						validations = append(validations, fmt.Sprintf(
-							"\tif len(%s.%s) < %s {\n\t\treturn fmt.Errorf(\"%s: minimum length is %s, got %%d\", len(%s.%s))\n\t}",
-							utils.ReceiverName(model.Name), fieldName, minVal, field.Name, minVal, utils.ReceiverName(model.Name), fieldName,
-						))
+						validations = append(validations, fmt.Sprintf("\tif len(%s.%s) < %s {\n\t\t%s\n\t}", recv, fieldName, minVal,
+							validationReturn(field.Name, "minimum length is "+minVal+", got %d", "len("+recv+"."+fieldName+")")))
 					} else if fieldType == "int" || fieldType == "float" {
 						// For numeric fields, check value
-						validations = append(validations, fmt.Sprintf(
-							"\tif %s.%s < %s {\n\t\treturn fmt.Errorf(\"%s: minimum value is %s, got %%v\", %s.%s)\n\t}",
-							utils.ReceiverName(model.Name), fieldName, minVal, field.Name, minVal, utils.ReceiverName(model.Name), fieldName,
-						))
+						validations = append(validations, fmt.Sprintf("\tif %s.%s < %s {\n\t\t%s\n\t}", recv, fieldName, minVal,
+							validationReturn(field.Name, "minimum value is "+minVal+", got %v", recv+"."+fieldName)))
 					}
 				}
 
@@ -88,24 +195,18 @@ func (g *Generator) genValidation(model *ast.ModelDecl) string {
 				if maxVal != "" {
 					// For string fields, check length
 					if fieldType == "string" {
-						validations = append(validations, fmt.Sprintf(
-							"\tif len(%s.%s) > %s {\n\t\treturn fmt.Errorf(\"%s: maximum length is %s, got %%d\", len(%s.%s))\n\t}",
-							utils.ReceiverName(model.Name), fieldName, maxVal, field.Name, maxVal, utils.ReceiverName(model.Name), fieldName,
-						))
+						validations = append(validations, fmt.Sprintf("\tif len(%s.%s) > %s {\n\t\t%s\n\t}", recv, fieldName, maxVal,
+							validationReturn(field.Name, "maximum length is "+maxVal+", got %d", "len("+recv+"."+fieldName+")")))
 					} else if fieldType == "int" || fieldType == "float" {
 						// For numeric fields, check value
-						validations = append(validations, fmt.Sprintf(
-							"\tif %s.%s > %s {\n\t\treturn fmt.Errorf(\"%s: maximum value is %s, got %%v\", %s.%s)\n\t}",
-							utils.ReceiverName(model.Name), fieldName, maxVal, field.Name, maxVal, utils.ReceiverName(model.Name), fieldName,
-						))
+						validations = append(validations, fmt.Sprintf("\tif %s.%s > %s {\n\t\t%s\n\t}", recv, fieldName, maxVal,
+							validationReturn(field.Name, "maximum value is "+maxVal+", got %v", recv+"."+fieldName)))
 					}
 				}
 
 			case "email":
-				validations = append(validations, fmt.Sprintf(
-					"\tif %s.%s != \"\" && !isValidEmail(%s.%s) {\n\t\treturn fmt.Errorf(\"%s: invalid email format\")\n\t}",
-					utils.ReceiverName(model.Name), fieldName, utils.ReceiverName(model.Name), fieldName, field.Name,
-				))
+				validations = append(validations, fmt.Sprintf("\tif %s.%s != \"\" && !isValidEmail(%s.%s) {\n\t\t%s\n\t}", recv, fieldName, recv, fieldName,
+					validationReturn(field.Name, "invalid email format")))
 			}
 		}
 	}
@@ -127,6 +228,58 @@ func (g *Generator) genValidation(model *ast.ModelDecl) string {
 	return b.String()
 }
 
+// maxPasswordBytes is the longest password bcrypt hashes: it rejects or
+// truncates longer ones
+const maxPasswordBytes = 72
+
+// storedHashField returns the unexported field holding the hash of a password
+// field as loaded from the database
+func storedHashField(fieldName string) string {
+	return "stored" + fieldName
+}
+
+// passwordValidation returns the Validate() check of a password field: its
+// length, bounded by bcrypt and its @min/@max, is checked on the plaintext
+// only, as a loaded record holds the hash
+func passwordValidation(recv, fieldName string, field *ast.FieldDecl) string {
+	value := recv + "." + fieldName
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("\tif %s != %s.%s {\n", value, recv, storedHashField(fieldName)))
+	b.WriteString(fmt.Sprintf("\t\tif len(%s) > %d {\n\t\t\t%s\n\t\t}\n", value, maxPasswordBytes,
+		validationReturn(field.Name, fmt.Sprintf("maximum length is %d bytes, got %%d", maxPasswordBytes), "len("+value+")")))
+	if minVal := annotationArg(field, "min"); minVal != "" {
+		b.WriteString(fmt.Sprintf("\t\tif len(%s) < %s {\n\t\t\t%s\n\t\t}\n", value, minVal,
+			validationReturn(field.Name, "minimum length is "+minVal+", got %d", "len("+value+")")))
+	}
+	if maxVal := annotationArg(field, "max"); maxVal != "" {
+		b.WriteString(fmt.Sprintf("\t\tif len(%s) > %s {\n\t\t\t%s\n\t\t}\n", value, maxVal,
+			validationReturn(field.Name, "maximum length is "+maxVal+", got %d", "len("+value+")")))
+	}
+	b.WriteString("\t}")
+	return b.String()
+}
+
+// annotationArg returns the argument of a field annotation, or "" when the
+// field does not carry it
+func annotationArg(field *ast.FieldDecl, name string) string {
+	if ann := field.FindAnnotation(name); ann != nil {
+		return ann.SimpleArg()
+	}
+	return ""
+}
+
+// modelPKField returns the @pk field of a model
+func modelPKField(model *ast.ModelDecl) *ast.FieldDecl {
+	for _, field := range model.Fields {
+		for _, ann := range field.Annotations {
+			if ann.Name == "pk" {
+				return field
+			}
+		}
+	}
+	return nil
+}
+
 // genBeforeCreate generates a GORM BeforeCreate hook for a model
 func (g *Generator) genBeforeCreate(model *ast.ModelDecl) string {
 	var uuidFields []string
@@ -159,16 +312,79 @@ func (g *Generator) genBeforeCreate(model *ast.ModelDecl) string {
 	return b.String()
 }
 
+// genBeforeSave generates a GORM BeforeSave hook that hashes password fields with bcrypt.
+// The hashes loaded from the database are left untouched so re-saving a loaded record is
+// safe; any other value, even shaped like a hash, is a password to hash.
+func (g *Generator) genBeforeSave(model *ast.ModelDecl) string {
+	var passwordFields []string
+	for _, field := range model.Fields {
+		if field.Type == "password" {
+			passwordFields = append(passwordFields, utils.ToPascalCase(field.Name))
+		}
+	}
+
+	if len(passwordFields) == 0 {
+		return ""
+	}
+
+	recv := utils.ReceiverName(model.Name)
+	var b strings.Builder
+	b.WriteString("// BeforeSave is a GORM hook that hashes password fields before persisting\n")
+	b.WriteString(fmt.Sprintf("func (%s *%s) BeforeSave(tx *gorm.DB) error {\n", recv, model.Name))
+	for _, fieldName := range passwordFields {
+		b.WriteString(fmt.Sprintf("\tif %s.%s != \"\" && %s.%s != %s.%s {\n", recv, fieldName, recv, fieldName, recv, storedHashField(fieldName)))
+		b.WriteString("\t\t// bcrypt would reject or truncate a longer password\n")
+		b.WriteString(fmt.Sprintf("\t\tif len(%s.%s) > %d {\n", recv, fieldName, maxPasswordBytes))
+		b.WriteString(fmt.Sprintf("\t\t\treturn fmt.Errorf(\"hashing %s: the password is longer than %d bytes\")\n", fieldName, maxPasswordBytes))
+		b.WriteString("\t\t}\n")
+		b.WriteString(fmt.Sprintf("\t\thash, err := bcrypt.GenerateFromPassword([]byte(%s.%s), bcrypt.DefaultCost)\n", recv, fieldName))
+		b.WriteString("\t\tif err != nil {\n")
+		b.WriteString(fmt.Sprintf("\t\t\treturn fmt.Errorf(\"hashing %s: %%w\", err)\n", fieldName))
+		b.WriteString("\t\t}\n")
+		b.WriteString(fmt.Sprintf("\t\t%s.%s = string(hash)\n", recv, fieldName))
+		b.WriteString(fmt.Sprintf("\t\t%s.%s = %s.%s\n", recv, storedHashField(fieldName), recv, fieldName))
+		b.WriteString("\t}\n")
+	}
+	b.WriteString("\treturn nil\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// genAfterFind generates a GORM AfterFind hook recording the password hashes
+// of a loaded record, the only values BeforeSave does not hash again
+func (g *Generator) genAfterFind(model *ast.ModelDecl) string {
+	recv := utils.ReceiverName(model.Name)
+	var b strings.Builder
+	b.WriteString("// AfterFind is a GORM hook that records the password hashes loaded from the database\n")
+	b.WriteString(fmt.Sprintf("func (%s *%s) AfterFind(tx *gorm.DB) error {\n", recv, model.Name))
+	for _, field := range model.Fields {
+		if field.Type == "password" {
+			fieldName := utils.ToPascalCase(field.Name)
+			b.WriteString(fmt.Sprintf("\t%s.%s = %s.%s\n", recv, storedHashField(fieldName), recv, fieldName))
+		}
+	}
+	b.WriteString("\treturn nil\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
 // genGormTags generates GORM tags for a field
-func (g *Generator) genGormTags(field *ast.FieldDecl, modelName string) string {
+func (g *Generator) genGormTags(field *ast.FieldDecl, model *ast.ModelDecl) string {
 	var tags []string
 
 	for _, ann := range field.Annotations {
 		switch ann.Name {
+		case "money":
+			tags = append(tags, "type:bigint")
 		case "pk":
 			tags = append(tags, "primaryKey")
 		case "unique":
 			tags = append(tags, "unique")
+		case "autoCreateTime", "autoUpdateTime":
+			// Fields added by @timestamps
+			tags = append(tags, ann.Name)
 		case "default":
 			if val := ann.SimpleArg(); val != "" {
 				tags = append(tags, fmt.Sprintf("default:%s", val))
@@ -185,6 +401,11 @@ func (g *Generator) genGormTags(field *ast.FieldDecl, modelName string) string {
 			}
 		}
 	}
+	tags = append(tags, indexTags(model, field)...)
+	if isEnumField(field) {
+		// GORM names the constraint chk_<table>_<column>, as the migrations do
+		tags = append(tags, "check:"+enumCheck(utils.ToSnakeCase(field.Name), field.Variants))
+	}
 
 	return strings.Join(tags, ";")
 }
@@ -196,6 +417,8 @@ func (g *Generator) mapType(gmxType string) string {
 		return "string"
 	case "string":
 		return "string"
+	case "password":
+		return "string"
 	case "int":
 		return "int"
 	case "float":
@@ -204,6 +427,12 @@ func (g *Generator) mapType(gmxType string) string {
 		return "bool"
 	case "datetime":
 		return "time.Time"
+	case "json":
+		return "JSON"
+	case "string[]":
+		return "StringList"
+	case "bytes", "image":
+		return "Blob"
 	default:
 		// Check if it's an array type (e.g., "Post[]")
 		if strings.HasSuffix(gmxType, "[]") {
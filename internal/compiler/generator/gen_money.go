@@ -0,0 +1,181 @@
+package generator
+
+import (
+	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"strconv"
+	"strings"
+)
+
+// isMoneyField reports whether a field is an amount stored in cents (int @money)
+func isMoneyField(field *ast.FieldDecl) bool {
+	for _, ann := range field.Annotations {
+		if ann.Name == "money" {
+			return true
+		}
+	}
+	return false
+}
+
+// needsMoney checks if the file uses the Money type, in models or script parameters
+func (g *Generator) needsMoney(file *ast.GMXFile) bool {
+	if g.hasFieldMatch(file, isMoneyField) {
+		return true
+	}
+	if file.Script == nil {
+		return false
+	}
+	for _, fn := range file.Script.Funcs {
+		for _, param := range fn.Params {
+			if param.Type == "money" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// moneyCents converts a decimal amount with at most two decimals to cents: "12.5" → 1250
+func moneyCents(amount string) (int64, error) {
+	units, cents, hasCents := strings.Cut(amount, ".")
+	neg := strings.HasPrefix(units, "-")
+	units = strings.TrimPrefix(units, "-")
+
+	var total int64
+	if units != "" || !hasCents {
+		u, err := strconv.ParseInt(units, 10, 64)
+		if err != nil || u < 0 {
+			return 0, fmt.Errorf("invalid amount %q", amount)
+		}
+		total = u * 100
+	}
+	if hasCents {
+		if cents == "" || len(cents) > 2 {
+			return 0, fmt.Errorf("invalid amount %q: use at most two decimals", amount)
+		}
+		if len(cents) == 1 {
+			cents += "0"
+		}
+		c, err := strconv.ParseInt(cents, 10, 64)
+		if err != nil || c < 0 {
+			return 0, fmt.Errorf("invalid amount %q", amount)
+		}
+		total += c
+	}
+	if neg {
+		total = -total
+	}
+	return total, nil
+}
+
+// validateMoneyFields checks that @money is used on int fields with valid amount bounds
+func (g *Generator) validateMoneyFields(file *ast.GMXFile) error {
+	for _, model := range file.Models {
+		for _, field := range model.Fields {
+			if !isMoneyField(field) {
+				continue
+			}
+			if field.Type != "int" {
+				return fmt.Errorf("model %s: @money requires an int field (amounts are stored in cents), got %s %q", model.Name, field.Type, field.Name)
+			}
+			for _, ann := range field.Annotations {
+				if ann.Name != "min" && ann.Name != "max" {
+					continue
+				}
+				if _, err := moneyCents(ann.SimpleArg()); err != nil {
+					return fmt.Errorf("model %s: @%s of %q: %w", model.Name, ann.Name, field.Name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// genMoneyHelpers generates the Money type, which keeps amounts in integer
+// cents, with its formatting and parsing helpers
+func (g *Generator) genMoneyHelpers() string {
+	var b strings.Builder
+
+	b.WriteString("// Money is an amount in cents, stored in a BIGINT column to avoid float rounding\n")
+	b.WriteString("type Money int64\n\n")
+
+	b.WriteString("// String formats the amount with two decimals: Money(1250) → \"12.50\"\n")
+	b.WriteString("func (m Money) String() string {\n")
+	b.WriteString("\tsign := \"\"\n")
+	b.WriteString("\tif m < 0 {\n")
+	b.WriteString("\t\tsign = \"-\"\n")
+	b.WriteString("\t\tm = -m\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn fmt.Sprintf(\"%s%d.%02d\", sign, m/100, m%100)\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// parseMoney parses a decimal amount with at most two decimals: \"12.5\" → Money(1250)\n")
+	b.WriteString("func parseMoney(s string) (Money, error) {\n")
+	b.WriteString("\tunits, cents, hasCents := strings.Cut(strings.TrimSpace(s), \".\")\n")
+	b.WriteString("\tneg := strings.HasPrefix(units, \"-\")\n")
+	b.WriteString("\tunits = strings.TrimPrefix(units, \"-\")\n\n")
+	b.WriteString("\tvar m Money\n")
+	b.WriteString("\tif units != \"\" || !hasCents {\n")
+	b.WriteString("\t\tu, err := strconv.ParseUint(units, 10, 63)\n")
+	b.WriteString("\t\tif err != nil || u > math.MaxInt64/100 {\n")
+	b.WriteString("\t\t\treturn 0, fmt.Errorf(\"invalid amount %q\", s)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tm = Money(u * 100)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif hasCents {\n")
+	b.WriteString("\t\tif len(cents) == 1 {\n")
+	b.WriteString("\t\t\tcents += \"0\"\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tc, err := strconv.ParseUint(cents, 10, 8)\n")
+	b.WriteString("\t\tif err != nil || len(cents) != 2 {\n")
+	b.WriteString("\t\t\treturn 0, fmt.Errorf(\"invalid amount %q: use at most two decimals\", s)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tm += Money(c)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif neg {\n")
+	b.WriteString("\t\tm = -m\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn m, nil\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// formatMoney formats an amount followed by its currency: {{money .Price \"EUR\"}} → \"12.50 EUR\"\n")
+	b.WriteString("func formatMoney(m Money, currency string) string {\n")
+	b.WriteString("\treturn m.String() + \" \" + currency\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// moneyValidation returns the Validate() checks of a @money field's bounds,
+// given in currency units and compared in cents
+func moneyValidation(recv, fieldName string, field *ast.FieldDecl) []string {
+	var checks []string
+	for _, ann := range field.Annotations {
+		var op, label string
+		switch ann.Name {
+		case "min":
+			op, label = "<", "minimum"
+		case "max":
+			op, label = ">", "maximum"
+		default:
+			continue
+		}
+		cents, err := moneyCents(ann.SimpleArg())
+		if err != nil {
+			continue // rejected by validateMoneyFields
+		}
+		checks = append(checks, fmt.Sprintf("\tif %s.%s %s %d {\n\t\t%s\n\t}", recv, fieldName, op, cents,
+			validationReturn(field.Name, label+" amount is "+formatCents(cents)+", got %s", recv+"."+fieldName)))
+	}
+	return checks
+}
+
+// formatCents formats cents at generation time, like the generated Money.String
+func formatCents(cents int64) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
@@ -0,0 +1,129 @@
+package generator
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+func TestMoneyCents(t *testing.T) {
+	tests := []struct {
+		amount  string
+		want    int64
+		wantErr bool
+	}{
+		{"12", 1200, false},
+		{"12.5", 1250, false},
+		{"12.05", 1205, false},
+		{".5", 50, false},
+		{"-3.20", -320, false},
+		{"0", 0, false},
+		{"12.345", 0, true},
+		{"12.", 0, true},
+		{"abc", 0, true},
+		{"", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.amount, func(t *testing.T) {
+			got, err := moneyCents(tt.amount)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("moneyCents(%q) error = %v, wantErr %v", tt.amount, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("moneyCents(%q) = %d, want %d", tt.amount, got, tt.want)
+			}
+		})
+	}
+}
+
+func moneyTestFile() *ast.GMXFile {
+	return &ast.GMXFile{
+		Models: []*ast.ModelDecl{
+			{
+				Name: "Product",
+				Fields: []*ast.FieldDecl{
+					{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{{Name: "pk"}}},
+					{Name: "price", Type: "int", Annotations: []*ast.Annotation{
+						{Name: "money"},
+						{Name: "min", Args: map[string]string{"_": "0.5"}},
+						{Name: "max", Args: map[string]string{"_": "10000"}},
+					}},
+				},
+			},
+		},
+		Script: &ast.ScriptBlock{
+			Funcs: []*ast.FuncDecl{
+				{Name: "setPrice", Params: []*ast.Param{{Name: "amount", Type: "money"}}, Body: []ast.Statement{}},
+			},
+		},
+		Template: &ast.TemplateBlock{Source: `{{range .Products}}{{money .Price "EUR"}}{{end}}`},
+	}
+}
+
+func TestGenerateMoneyField(t *testing.T) {
+	code, err := New().Generate(moneyTestFile())
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	if !regexp.MustCompile(`Price\s+Money\s+`).MatchString(code) {
+		t.Error("money field should have the Money type")
+	}
+
+	expected := []string{
+		"type Money int64",
+		"func (m Money) String() string",
+		"func parseMoney(s string) (Money, error)",
+		"`gorm:\"type:bigint\" json:\"price\"`",
+		"if p.Price < 50 {",
+		`return &ValidationError{Field: "price", Message: fmt.Sprintf("maximum amount is 10000.00, got %s", p.Price)}`,
+		"Price: Money(50 + mrand.Int64N(999951)),",
+		`"money":`,
+		"amountMoney, err := parseMoney(amount)",
+		"func setPrice(ctx *GMXContext, amount Money) error",
+		`"math"`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(code, exp) {
+			t.Errorf("Generated code missing %q", exp)
+		}
+	}
+}
+
+func TestGenerateMoneyFieldErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		field   *ast.FieldDecl
+		wantErr string
+	}{
+		{
+			name:    "non-int field",
+			field:   &ast.FieldDecl{Name: "price", Type: "float", Annotations: []*ast.Annotation{{Name: "money"}}},
+			wantErr: "@money requires an int field",
+		},
+		{
+			name: "bound with three decimals",
+			field: &ast.FieldDecl{Name: "price", Type: "int", Annotations: []*ast.Annotation{
+				{Name: "money"},
+				{Name: "min", Args: map[string]string{"_": "0.125"}},
+			}},
+			wantErr: "use at most two decimals",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := &ast.GMXFile{Models: []*ast.ModelDecl{{Name: "Product", Fields: []*ast.FieldDecl{tt.field}}}}
+			_, err := New().Generate(file)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// notificationModel is the model storing in-app notifications, migrated with the others
+const notificationModel = "Notification"
+
+// Built-in endpoints listing the notifications of the session user, counting
+// the unread ones, streaming that count and marking notifications as read
+const (
+	notificationsPath       = "/_gmx/notifications"
+	notificationsCountPath  = "/_gmx/notifications/count"
+	notificationsStreamPath = "/_gmx/notifications/stream"
+	notificationsReadPath   = "/_gmx/notifications/read"
+)
+
+// notificationRoutes are the built-in notification endpoints
+var notificationRoutes = []Route{
+	{Method: "GET", Path: notificationsPath, Handler: "handleNotifications"},
+	{Method: "GET", Path: notificationsCountPath, Handler: "handleNotificationCount"},
+	{Method: "GET", Path: notificationsStreamPath, Handler: "handleNotificationStream"},
+	{Method: "POST", Path: notificationsReadPath, Handler: "handleNotificationsRead"},
+}
+
+// notifyCalls returns the notify(...) calls of the script functions; a script
+// function named notify replaces the builtin
+func notifyCalls(file *ast.GMXFile) []*ast.CallExpr {
+	if file.Script == nil {
+		return nil
+	}
+	for _, fn := range file.Script.Funcs {
+		if fn.Name == "notify" {
+			return nil
+		}
+	}
+	var calls []*ast.CallExpr
+	for _, fn := range file.Script.Funcs {
+		ast.Inspect(fn, func(n ast.Node) bool {
+			if call, ok := n.(*ast.CallExpr); ok {
+				if ident, ok := call.Function.(*ast.Ident); ok && ident.Name == "notify" {
+					calls = append(calls, call)
+				}
+			}
+			return true
+		})
+	}
+	return calls
+}
+
+// hasNotifications checks if the script notifies users, which generates the
+// notification model and endpoints
+func (g *Generator) hasNotifications(file *ast.GMXFile) bool {
+	return len(notifyCalls(file)) > 0
+}
+
+// validateNotifications checks that notified users can read their
+// notifications, and that notify(...) calls are complete
+func (g *Generator) validateNotifications(file *ast.GMXFile) error {
+	calls := notifyCalls(file)
+	if len(calls) == 0 {
+		return nil
+	}
+	if g.findSessionService(file.Services) == nil {
+		return fmt.Errorf("line %d: notify requires a service with provider \"session\", whose user reads the notifications", calls[0].Line)
+	}
+	for _, model := range file.Models {
+		if model.Name == notificationModel {
+			return fmt.Errorf("line %d: model %s collides with the model storing the notifications; rename it", model.Line, model.Name)
+		}
+	}
+	for _, fn := range file.Script.Funcs {
+		for _, route := range notificationRoutes {
+			if "handle"+utils.Capitalize(fn.Name) == route.Handler {
+				return fmt.Errorf("line %d: function %s collides with the built-in %s endpoint; rename it", fn.Line, fn.Name, route.Path)
+			}
+		}
+	}
+	for _, call := range calls {
+		if len(call.Args) != 3 {
+			return fmt.Errorf("line %d: notify takes a user, a message and a link, got %d arguments", call.Line, len(call.Args))
+		}
+	}
+	return nil
+}
+
+// withNotificationModel returns the file with the model storing its
+// notifications, so that it is declared, migrated and loaded like the others
+func (g *Generator) withNotificationModel(file *ast.GMXFile) *ast.GMXFile {
+	if !g.hasNotifications(file) {
+		return file
+	}
+	withModel := *file
+	withModel.Models = append(append([]*ast.ModelDecl{}, file.Models...), &ast.ModelDecl{
+		Name: notificationModel,
+		Fields: []*ast.FieldDecl{
+			{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{
+				{Name: "pk", Args: map[string]string{}},
+				{Name: "default", Args: map[string]string{"_": "uuid_v4"}},
+			}},
+			{Name: "recipient", Type: "string"},
+			{Name: "message", Type: "string"},
+			{Name: "link", Type: "string"},
+			{Name: "read", Type: "bool"},
+			{Name: "createdAt", Type: "datetime"},
+		},
+	})
+	return &withModel
+}
+
+// genNotifications generates notify, which stores a notification and wakes the
+// streams of its user, and the built-in notification endpoints
+func (g *Generator) genNotifications(file *ast.GMXFile) string {
+	var b strings.Builder
+
+	b.WriteString("// notificationPollInterval is how often badges poll, and streams check for\n")
+	b.WriteString("// notifications stored by other instances\n")
+	b.WriteString("const notificationPollInterval = 30 * time.Second\n\n")
+
+	b.WriteString("// notificationStreams wakes the open notification streams of each user\n")
+	b.WriteString("var notificationStreams struct {\n")
+	b.WriteString("\tsync.Mutex\n")
+	b.WriteString("\tbyUser map[string]map[chan struct{}]bool\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// notify stores an in-app notification for user, linking to link, and\n")
+	b.WriteString("// pushes the new unread count to their open streams\n")
+	b.WriteString("func notify(user, message, link string) error {\n")
+	b.WriteString("\t// Links are rendered as is: no javascript: URLs\n")
+	b.WriteString("\tif link != \"\" && !strings.HasPrefix(link, \"/\") && !strings.HasPrefix(link, \"https://\") && !strings.HasPrefix(link, \"http://\") {\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"notify %s: link %q is neither a path nor an http(s) URL\", user, link)\n")
+	b.WriteString("\t}\n")
+	b.WriteString(fmt.Sprintf("\tn := &%s{Recipient: user, Message: message, Link: link, CreatedAt: time.Now()}\n", notificationModel))
+	b.WriteString("\tif err := db.Create(n).Error; err != nil {\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"notify %s: %w\", user, err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tnotificationStreams.Lock()\n")
+	b.WriteString("\tfor wake := range notificationStreams.byUser[user] {\n")
+	b.WriteString("\t\tselect {\n")
+	b.WriteString("\t\tcase wake <- struct{}{}:\n")
+	b.WriteString("\t\tdefault:\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tnotificationStreams.Unlock()\n")
+	b.WriteString("\treturn nil\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// unreadNotifications counts the unread notifications of user\n")
+	b.WriteString("func unreadNotifications(user string) int64 {\n")
+	b.WriteString("\tvar n int64\n")
+	b.WriteString(fmt.Sprintf("\tif err := db.Model(&%s{}).Where(map[string]any{\"recipient\": user, \"read\": false}).Count(&n).Error; err != nil {\n", notificationModel))
+	b.WriteString("\t\tslog.Error(\"notifications: counting\", \"error\", err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn n\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// renderNotificationBadge renders the unread count of user, refreshed by polling\n")
+	b.WriteString("// and after notifications are marked as read\n")
+	b.WriteString("func renderNotificationBadge(user string) string {\n")
+	b.WriteString(fmt.Sprintf("\treturn fmt.Sprintf(`<span class=\"gmx-notifications\" hx-get=%q hx-trigger=\"every %%s, gmx-notifications-read from:body\" hx-swap=\"outerHTML\">%%d</span>`,\n", notificationsCountPath))
+	b.WriteString("\t\tnotificationPollInterval, unreadNotifications(user))\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// handleNotificationCount renders the unread count badge of the session user\n")
+	b.WriteString("func handleNotificationCount(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString(genNotificationUser("Get"))
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/html; charset=utf-8\")\n")
+	b.WriteString("\tfmt.Fprint(w, renderNotificationBadge(user))\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// handleNotificationStream sends the unread count of the session user as\n")
+	b.WriteString("// server-sent \"notifications\" events, whenever it changes\n")
+	b.WriteString("func handleNotificationStream(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString(genNotificationUser("Get"))
+	b.WriteString("\tflusher, ok := w.(http.Flusher)\n")
+	b.WriteString("\tif !ok {\n")
+	b.WriteString("\t\thttp.Error(w, \"Streaming unsupported\", http.StatusInternalServerError)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\twake := make(chan struct{}, 1)\n")
+	b.WriteString("\tnotificationStreams.Lock()\n")
+	b.WriteString("\tif notificationStreams.byUser == nil {\n")
+	b.WriteString("\t\tnotificationStreams.byUser = make(map[string]map[chan struct{}]bool)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif notificationStreams.byUser[user] == nil {\n")
+	b.WriteString("\t\tnotificationStreams.byUser[user] = make(map[chan struct{}]bool)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tnotificationStreams.byUser[user][wake] = true\n")
+	b.WriteString("\tnotificationStreams.Unlock()\n")
+	b.WriteString("\tdefer func() {\n")
+	b.WriteString("\t\tnotificationStreams.Lock()\n")
+	b.WriteString("\t\tdelete(notificationStreams.byUser[user], wake)\n")
+	b.WriteString("\t\tif len(notificationStreams.byUser[user]) == 0 {\n")
+	b.WriteString("\t\t\tdelete(notificationStreams.byUser, user)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tnotificationStreams.Unlock()\n")
+	b.WriteString("\t}()\n\n")
+	b.WriteString(genStreamDeadline(file))
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/event-stream\")\n")
+	b.WriteString("\tw.Header().Set(\"Cache-Control\", \"no-cache\")\n")
+	b.WriteString("\tticker := time.NewTicker(notificationPollInterval)\n")
+	b.WriteString("\tdefer ticker.Stop()\n")
+	b.WriteString("\tflusher.Flush()\n")
+	b.WriteString("\tlast := int64(-1)\n")
+	b.WriteString("\tfor {\n")
+	if g.hasDatabaseStandby(file) {
+		// The stream holds the database around its counts only, so that a
+		// switchover does not wait for it
+		b.WriteString("\t\trelease := holdDB()\n")
+		b.WriteString("\t\tn := unreadNotifications(user)\n")
+		b.WriteString("\t\trelease()\n")
+		b.WriteString("\t\tif n != last {\n")
+	} else {
+		b.WriteString("\t\tif n := unreadNotifications(user); n != last {\n")
+	}
+	b.WriteString("\t\t\tfmt.Fprintf(w, \"event: notifications\\ndata: %d\\n\\n\", n)\n")
+	b.WriteString("\t\t\tflusher.Flush()\n")
+	b.WriteString("\t\t\tlast = n\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tselect {\n")
+	b.WriteString("\t\tcase <-r.Context().Done():\n")
+	b.WriteString("\t\t\treturn\n")
+	b.WriteString("\t\tcase <-wake:\n")
+	b.WriteString("\t\tcase <-ticker.C:\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// handleNotifications renders the latest notifications of the session user\n")
+	b.WriteString("func handleNotifications(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString(genNotificationUser("Get"))
+	b.WriteString("\twriteNotificationList(w, user)\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// handleNotificationsRead marks a notification of the session user as read,\n")
+	b.WriteString("// or all of them without an id, then renders the refreshed list\n")
+	b.WriteString("func handleNotificationsRead(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString(genNotificationUser("Post"))
+	b.WriteString("\twhere := map[string]any{\"recipient\": user, \"read\": false}\n")
+	b.WriteString("\tif id := r.FormValue(\"id\"); id != \"\" {\n")
+	b.WriteString("\t\twhere[\"id\"] = id\n")
+	b.WriteString("\t}\n")
+	b.WriteString(fmt.Sprintf("\tif err := db.Model(&%s{}).Where(where).Update(\"read\", true).Error; err != nil {\n", notificationModel))
+	b.WriteString("\t\trequestLogger(r).Error(\"notifications: marking as read\", \"error\", err)\n")
+	b.WriteString("\t\thttp.Error(w, \"Internal Server Error\", http.StatusInternalServerError)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\t// Badges refresh on this event\n")
+	b.WriteString("\tw.Header().Set(\"HX-Trigger\", \"gmx-notifications-read\")\n")
+	b.WriteString("\twriteNotificationList(w, user)\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// writeNotificationList renders the 50 latest notifications of user, with a\n")
+	b.WriteString("// button marking each unread one as read\n")
+	b.WriteString("func writeNotificationList(w http.ResponseWriter, user string) {\n")
+	b.WriteString(fmt.Sprintf("\tvar notifications []%s\n", notificationModel))
+	b.WriteString("\tif err := db.Where(map[string]any{\"recipient\": user}).Order(\"created_at desc\").Limit(50).Find(&notifications).Error; err != nil {\n")
+	b.WriteString("\t\tslog.Error(\"notifications: loading\", \"error\", err)\n")
+	b.WriteString("\t\thttp.Error(w, \"Internal Server Error\", http.StatusInternalServerError)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/html; charset=utf-8\")\n")
+	b.WriteString("\tfmt.Fprint(w, `<div class=\"gmx-notification-list\">`)\n")
+	b.WriteString(fmt.Sprintf("\tfmt.Fprint(w, `<button hx-post=%q hx-target=\"closest .gmx-notification-list\" hx-swap=\"outerHTML\">Mark all as read</button><ul>`)\n", notificationsReadPath))
+	b.WriteString("\tfor _, n := range notifications {\n")
+	b.WriteString("\t\tif n.Read {\n")
+	b.WriteString("\t\t\tfmt.Fprintf(w, `<li><a href=\"%s\">%s</a></li>`, template.HTMLEscapeString(n.Link), template.HTMLEscapeString(n.Message))\n")
+	b.WriteString("\t\t\tcontinue\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tfmt.Fprintf(w, `<li class=\"unread\"><a href=\"%s\">%s</a> `+\n")
+	b.WriteString(fmt.Sprintf("\t\t\t`<button hx-post=\"%s?id=%%s\" hx-target=\"closest .gmx-notification-list\" hx-swap=\"outerHTML\">Mark as read</button></li>`,\n", notificationsReadPath))
+	b.WriteString("\t\t\ttemplate.HTMLEscapeString(n.Link), template.HTMLEscapeString(n.Message), url.QueryEscape(n.ID))\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tfmt.Fprint(w, `</ul></div>`)\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// genNotificationUser generates the method check of a notification endpoint
+// and reads its user from the session: notifications are private
+func genNotificationUser(method string) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("\tif r.Method != http.Method%s {\n", method))
+	b.WriteString("\t\thttp.Error(w, \"Method Not Allowed\", http.StatusMethodNotAllowed)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\tuser := readSession(r).User\n")
+	b.WriteString("\tif user == \"\" {\n")
+	b.WriteString("\t\thttp.Error(w, \"Unauthorized\", http.StatusUnauthorized)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	return b.String()
+}
@@ -0,0 +1,103 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+)
+
+const notifySession = `service Auth {
+  provider: "session"
+  secret: string @env("SESSION_SECRET")
+}
+`
+
+const notifyScript = `model Task {
+  id: uuid @pk @default(uuid_v4)
+  title: string
+  assignee: string
+}
+func assignTask(id: uuid, assignee: string) error {
+  let task = try Task.find(id)
+  task.assignee = assignee
+  try task.save()
+  try notify(assignee, "Task assigned", "/tasks")
+  return render(task)
+}`
+
+func TestGenerateNotifications(t *testing.T) {
+	file := scriptTestFile(t, notifySession+notifyScript, signedTemplateSrc)
+	file.Template.Source = `<header>{{notificationBadge}}</header>`
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, want := range []string{
+		// Stored by a generated model, migrated with the others
+		"type Notification struct {",
+		"db.AutoMigrate(&Task{}, &Notification{})",
+		`if err := notify(assignee, "Task assigned", "/tasks"); err != nil {`,
+		"func notify(user, message, link string) error {",
+		`"notificationBadge": func() template.HTML {`,
+		`mux.HandleFunc("/_gmx/notifications", handleNotifications)`,
+		`mux.HandleFunc("/_gmx/notifications/count", handleNotificationCount)`,
+		`mux.HandleFunc("/_gmx/notifications/stream", handleNotificationStream)`,
+		`mux.HandleFunc("/_gmx/notifications/read", handleNotificationsRead)`,
+		// Each user only reads their own notifications
+		"user := readSession(r).User",
+		`where := map[string]any{"recipient": user, "read": false}`,
+		`w.Header().Set("Content-Type", "text/event-stream")`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+}
+
+func TestGenerateNotifyOverride(t *testing.T) {
+	src := notifySession + notifyScript + `
+func notify(user: string, message: string, link: string) error {
+  return nil
+}`
+	code, err := New().Generate(scriptTestFile(t, src, signedTemplateSrc))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	// A script function named notify replaces the builtin
+	if strings.Contains(code, "type Notification struct {") || strings.Contains(code, "handleNotifications") {
+		t.Error("notify declared by the script should not generate the notification subsystem")
+	}
+	if !strings.Contains(code, `if err := notify(ctx, assignee, "Task assigned", "/tasks"); err != nil {`) {
+		t.Error("expected the override to be called with the context")
+	}
+	// Anyone could send notifications through its handler
+	if strings.Contains(code, "handleNotify") {
+		t.Error("unexpected handler for the notify override")
+	}
+	goInModule(t, map[string]string{"main.go": code}, "vet", ".")
+}
+
+func TestValidateNotifications(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{"without session", notifyScript, "line 10: notify requires a service with provider \"session\""},
+		{"arguments", notifySession + "func ping() error {\n  try notify(ctx.user, \"pong\")\n  return nil\n}", "line 6: notify takes a user, a message and a link, got 2 arguments"},
+		{"model", notifySession + notifyScript + "\nmodel Notification {\n  id: uuid @pk\n}", "model Notification collides with the model storing the notifications"},
+		{"handler", notifySession + notifyScript + "\nfunc notifications() error {\n  return nil\n}", "function notifications collides with the built-in /_gmx/notifications endpoint"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New().Generate(scriptTestFile(t, tt.src, signedTemplateSrc))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
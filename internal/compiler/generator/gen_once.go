@@ -0,0 +1,109 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// onceWindow is how long a submission of an @once form is remembered
+const onceWindow = "10 * time.Minute"
+
+// injectOnceFields adds {{once}} to every form posting to an @once function,
+// unless the form already places it explicitly
+func injectOnceFields(src string, funcs []*ast.FuncDecl) string {
+	for _, fn := range funcs {
+		src = injectFormField(src, fn, "{{once}}")
+	}
+	return src
+}
+
+// genOnceClaim returns the statements of an @once handler answering a
+// duplicate submission without calling the function again
+func genOnceClaim() string {
+	var b strings.Builder
+	b.WriteString("\t// A double-clicked form is only handled once\n")
+	b.WriteString("\tsubmission, first := claimSubmission(r)\n")
+	b.WriteString("\tif !first {\n")
+	b.WriteString("\t\trequestLogger(r).Info(\"duplicate submission ignored\", \"path\", r.URL.Path)\n")
+	b.WriteString("\t\t// HTMX keeps the fragment swapped by the first submission\n")
+	b.WriteString("\t\tif r.Header.Get(\"HX-Request\") != \"\" {\n")
+	b.WriteString("\t\t\tw.WriteHeader(http.StatusNoContent)\n")
+	b.WriteString("\t\t\treturn\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\thttp.Error(w, \"Duplicate submission\", http.StatusConflict)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	return b.String()
+}
+
+// genOnceHelpers generates the form nonce of @once functions and the
+// submissions remembered per visitor, keyed by their CSRF cookie
+func (g *Generator) genOnceHelpers(withTemplate bool) string {
+	var b strings.Builder
+
+	b.WriteString("// submissionWindow is how long a form submission is remembered\n")
+	b.WriteString(fmt.Sprintf("const submissionWindow = %s\n\n", onceWindow))
+
+	b.WriteString("// submissions keeps the time of each form submission, by visitor and form content\n")
+	b.WriteString("var submissions = struct {\n")
+	b.WriteString("\tsync.Mutex\n")
+	b.WriteString("\tm map[string]time.Time\n")
+	b.WriteString("}{m: make(map[string]time.Time)}\n\n")
+
+	if withTemplate {
+		b.WriteString("// onceField renders the hidden nonce identifying a rendering of the form\n")
+		b.WriteString("func onceField() template.HTML {\n")
+		b.WriteString("\tnonce := make([]byte, 16)\n")
+		b.WriteString("\trand.Read(nonce)\n")
+		b.WriteString("\treturn template.HTML(fmt.Sprintf(`<input type=\"hidden\" name=\"gmx_nonce\" value=\"%x\">`, nonce))\n")
+		b.WriteString("}\n\n")
+	}
+
+	b.WriteString("// claimSubmission records a form submission, returning false when the same\n")
+	b.WriteString("// visitor already sent the same form with the same values; requests without\n")
+	b.WriteString("// a nonce, such as API clients, are never duplicates\n")
+	b.WriteString("func claimSubmission(r *http.Request) (string, bool) {\n")
+	b.WriteString("\tif r.FormValue(\"gmx_nonce\") == \"\" {\n")
+	b.WriteString("\t\treturn \"\", true\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tvisitor := \"\"\n")
+	b.WriteString("\tif cookie, err := r.Cookie(\"_csrf\"); err == nil {\n")
+	b.WriteString("\t\tvisitor = cookie.Value\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tkey := fmt.Sprintf(\"%x\", sha256.Sum256([]byte(r.URL.Path+\"\\n\"+visitor+\"\\n\"+r.Form.Encode())))\n\n")
+	b.WriteString("\tsubmissions.Lock()\n")
+	b.WriteString("\tdefer submissions.Unlock()\n")
+	b.WriteString("\tif at, ok := submissions.m[key]; ok && time.Since(at) <= submissionWindow {\n")
+	b.WriteString("\t\treturn key, false\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tsubmissions.m[key] = time.Now()\n")
+	b.WriteString("\treturn key, true\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// releaseSubmission forgets a submission that failed, so that it can be retried\n")
+	b.WriteString("func releaseSubmission(key string) {\n")
+	b.WriteString("\tif key == \"\" {\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tsubmissions.Lock()\n")
+	b.WriteString("\tdefer submissions.Unlock()\n")
+	b.WriteString("\tdelete(submissions.m, key)\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// sweepSubmissions forgets the submissions older than submissionWindow; the\n")
+	b.WriteString("// scheduler runs it every minute\n")
+	b.WriteString("func sweepSubmissions(ctx *GMXContext) error {\n")
+	b.WriteString("\tsubmissions.Lock()\n")
+	b.WriteString("\tdefer submissions.Unlock()\n")
+	b.WriteString("\tfor key, at := range submissions.m {\n")
+	b.WriteString("\t\tif time.Since(at) > submissionWindow {\n")
+	b.WriteString("\t\t\tdelete(submissions.m, key)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn nil\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
@@ -0,0 +1,139 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+func TestInjectOnceFields(t *testing.T) {
+	funcs := []*ast.FuncDecl{{Name: "createTask"}}
+
+	tests := []struct {
+		name     string
+		src      string
+		expected string
+	}{
+		{
+			name:     "form posting to the function",
+			src:      `<form hx-post="{{route "createTask"}}" hx-target="#list"><input name="title"></form>`,
+			expected: `<form hx-post="{{route "createTask"}}" hx-target="#list">{{once}}<input name="title"></form>`,
+		},
+		{
+			name:     "other form untouched",
+			src:      "<form hx-post=\"{{route `createTasks`}}\"></form>",
+			expected: "<form hx-post=\"{{route `createTasks`}}\"></form>",
+		},
+		{
+			name:     "explicit placement kept",
+			src:      `<form hx-post="{{route "createTask"}}"><input name="title">{{once}}</form>`,
+			expected: `<form hx-post="{{route "createTask"}}"><input name="title">{{once}}</form>`,
+		},
+		{
+			name:     "explicit placement in another form",
+			src:      `<form hx-post="{{route "signIn"}}">{{once}}</form><form hx-post="{{route "createTask"}}"></form>`,
+			expected: `<form hx-post="{{route "signIn"}}">{{once}}</form><form hx-post="{{route "createTask"}}">{{once}}</form>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := injectOnceFields(tt.src, funcs); got != tt.expected {
+				t.Errorf("injectOnceFields() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func onceFile() *ast.GMXFile {
+	return &ast.GMXFile{
+		Script: &ast.ScriptBlock{Funcs: []*ast.FuncDecl{
+			{
+				Name:        "createTask",
+				Params:      []*ast.Param{{Name: "title", Type: "string"}},
+				ReturnType:  "error",
+				Body:        []ast.Statement{&ast.ReturnStmt{Value: &ast.Ident{Name: "nil"}}},
+				Annotations: []*ast.Annotation{{Name: "once"}},
+			},
+		}},
+		Template: &ast.TemplateBlock{Source: `<form hx-post="{{route "createTask"}}"><input name="title"></form>`},
+	}
+}
+
+func TestGenerator_Once(t *testing.T) {
+	code, err := New().Generate(onceFile())
+	if err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+	for _, want := range []string{
+		`<form hx-post="{{route "createTask"}}">{{once}}<input name="title">`,
+		`"once": onceField,`,
+		"\tsubmission, first := claimSubmission(r)\n",
+		"\t\t\tw.WriteHeader(http.StatusNoContent)\n",
+		"\t\thttp.Error(w, \"Duplicate submission\", http.StatusConflict)\n",
+		"\t\treleaseSubmission(submission)\n",
+		"const submissionWindow = 10 * time.Minute",
+		"\t{\"sweepSubmissions\", cronSchedule{",
+		"\tstartSchedules()\n",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in generated code", want)
+		}
+	}
+	// Expired submissions are swept by the scheduler, not on every claim
+	claimFn := code[strings.Index(code, "func claimSubmission("):]
+	claimFn = claimFn[:strings.Index(claimFn, "\n}\n")]
+	if strings.Contains(claimFn, "range submissions.m") {
+		t.Errorf("expected claimSubmission not to scan the submissions:\n%s", claimFn)
+	}
+	// The submission is claimed once the parameters are valid, just before the call
+	claim := strings.Index(code, "claimSubmission(r)\n")
+	if claim < strings.Index(code, "Missing required parameter: title") || claim > strings.Index(code, "if err := createTask(ctx, title)") {
+		t.Error("expected the submission to be claimed between the parameter checks and the call")
+	}
+}
+
+func TestGenerator_OnceErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(fn *ast.FuncDecl)
+		wantErr string
+	}{
+		{
+			name: "arguments",
+			modify: func(fn *ast.FuncDecl) {
+				fn.Annotations[0].Args = map[string]string{"_": "5m"}
+			},
+			wantErr: "function createTask: @once takes no arguments",
+		},
+		{
+			name: "return type",
+			modify: func(fn *ast.FuncDecl) {
+				fn.ReturnType = "string"
+			},
+			wantErr: "function createTask: @once applies to handlers, functions returning error",
+		},
+		{
+			name: "job",
+			modify: func(fn *ast.FuncDecl) {
+				fn.Annotations = append(fn.Annotations, &ast.Annotation{Name: "job"})
+			},
+			wantErr: "function createTask: @job functions run in the background, not as handlers; remove @once",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := onceFile()
+			tt.modify(file.Script.Funcs[0])
+			_, err := New().Generate(file)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,328 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// outboxModel is the generated model storing the published events until the
+// relay delivers them
+const outboxModel = "OutboxEvent"
+
+// Events the relay reads at once, and the longest wait between two
+// deliveries of a failing event
+const (
+	outboxBatchSize  = 100
+	outboxMaxBackoff = "10 * time.Minute"
+)
+
+// publishCalls returns the publish(...) calls of the script functions; a
+// script function named publish replaces the builtin
+func publishCalls(file *ast.GMXFile) []*ast.CallExpr {
+	if file.Script == nil {
+		return nil
+	}
+	for _, fn := range file.Script.Funcs {
+		if fn.Name == "publish" {
+			return nil
+		}
+	}
+	var calls []*ast.CallExpr
+	for _, fn := range file.Script.Funcs {
+		calls = append(calls, funcPublishCalls(fn)...)
+	}
+	return calls
+}
+
+// funcPublishCalls returns the publish(...) calls of one script function
+func funcPublishCalls(fn *ast.FuncDecl) []*ast.CallExpr {
+	var calls []*ast.CallExpr
+	ast.Inspect(fn, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok {
+			if ident, ok := call.Function.(*ast.Ident); ok && ident.Name == "publish" {
+				calls = append(calls, call)
+			}
+		}
+		return true
+	})
+	return calls
+}
+
+// hasOutbox checks if the script publishes events, which generates the
+// outbox model and its relay
+func (g *Generator) hasOutbox(file *ast.GMXFile) bool {
+	return len(publishCalls(file)) > 0
+}
+
+// publishesInHandler reports whether a handler publishes events, so that it
+// runs in a transaction committing them with its writes
+func (g *Generator) publishesInHandler(file *ast.GMXFile, fn *ast.FuncDecl) bool {
+	return g.hasOutbox(file) && len(funcPublishCalls(fn)) > 0
+}
+
+// findEventsService returns the service the relay delivers the events to
+func (g *Generator) findEventsService(services []*ast.ServiceDecl) *ast.ServiceDecl {
+	for _, svc := range services {
+		if svc.Provider == "events" {
+			return svc
+		}
+	}
+	return nil
+}
+
+// validateOutbox checks that published events have a service to be delivered
+// to, that the "events" service only sets its url, and that publish(...)
+// calls are complete
+func (g *Generator) validateOutbox(file *ast.GMXFile) error {
+	svc := g.findEventsService(file.Services)
+	if svc != nil {
+		for _, other := range file.Services {
+			if other != svc && other.Provider == "events" {
+				return fmt.Errorf("service %s: the events provider is already used by %s; declare a single events service", other.Name, svc.Name)
+			}
+		}
+		for _, field := range svc.Fields {
+			if field.Name != "url" {
+				return fmt.Errorf("service %s: the events provider takes a url field, not %s", svc.Name, field.Name)
+			}
+			if field.Type != "string" {
+				return fmt.Errorf("service %s: field url of the events provider must be a string", svc.Name)
+			}
+		}
+		if findServiceField(svc, "url") == nil {
+			return fmt.Errorf("service %s: the events provider needs a url field, where the relay posts the events", svc.Name)
+		}
+		if len(svc.Methods) > 0 {
+			return fmt.Errorf("service %s: the events provider takes no methods; publish events with publish(topic, payload)", svc.Name)
+		}
+	}
+
+	calls := publishCalls(file)
+	if len(calls) == 0 {
+		return nil
+	}
+	if svc == nil {
+		return fmt.Errorf("line %d: publish requires a service with provider \"events\", where the relay delivers the events", calls[0].Line)
+	}
+	for _, model := range file.Models {
+		if model.Name == outboxModel {
+			return fmt.Errorf("line %d: model %s collides with the model storing the published events; rename it", model.Line, model.Name)
+		}
+	}
+	for _, call := range calls {
+		if len(call.Args) != 2 {
+			return fmt.Errorf("line %d: publish takes a topic and a payload, got %d arguments", call.Line, len(call.Args))
+		}
+	}
+	return nil
+}
+
+// withOutboxModel returns the file with the model storing its published
+// events, so that it is declared, migrated and loaded like the others
+func (g *Generator) withOutboxModel(file *ast.GMXFile) *ast.GMXFile {
+	if !g.hasOutbox(file) {
+		return file
+	}
+	withModel := *file
+	withModel.Models = append(append([]*ast.ModelDecl{}, file.Models...), &ast.ModelDecl{
+		Name: outboxModel,
+		Fields: []*ast.FieldDecl{
+			{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{
+				{Name: "pk", Args: map[string]string{}},
+				{Name: "default", Args: map[string]string{"_": "uuid_v4"}},
+			}},
+			{Name: "topic", Type: "string"},
+			{Name: "payload", Type: "string"},
+			{Name: "attempts", Type: "int"},
+			{Name: "lastError", Type: "string"},
+			{Name: "scheduledAt", Type: "datetime"},
+			{Name: "createdAt", Type: "datetime", Annotations: []*ast.Annotation{
+				{Name: "index", Args: map[string]string{}},
+			}},
+		},
+	})
+	return &withModel
+}
+
+// outboxTransaction wraps the call of a handler publishing events, so that
+// its writes and its events are committed together
+func outboxTransaction(call string) string {
+	return fmt.Sprintf("inOutboxTransaction(ctx, func(ctx *GMXContext) error { return %s })", call)
+}
+
+// genOutboxStart returns the statement of main starting the relay
+func (g *Generator) genOutboxStart(file *ast.GMXFile) string {
+	svc := g.findEventsService(file.Services)
+	return fmt.Sprintf("\tstartOutboxRelay(%sCfg.Url)\n", utils.LowerFirst(svc.Name))
+}
+
+// genOutbox generates publish, which records an event in the outbox with the
+// writes of its transaction, and the relay delivering the committed events
+func (g *Generator) genOutbox(file *ast.GMXFile) string {
+	var b strings.Builder
+	svc := g.findEventsService(file.Services)
+
+	b.WriteString("// Events the relay reads at once; it also polls for the events of other\n")
+	b.WriteString("// instances and those waiting for a new delivery attempt\n")
+	b.WriteString("const (\n")
+	b.WriteString(fmt.Sprintf("\toutboxBatchSize    = %d\n", outboxBatchSize))
+	b.WriteString("\toutboxPollInterval = time.Second\n")
+	b.WriteString(")\n\n")
+
+	b.WriteString("// outboxRelay delivers the events of the outbox to the events service\n")
+	b.WriteString("var outboxRelay struct {\n")
+	b.WriteString("\turl    string\n")
+	b.WriteString("\tclient *http.Client\n")
+	b.WriteString("\twake   chan struct{}\n")
+	b.WriteString("\tstop   chan struct{}\n")
+	b.WriteString("\tdone   chan struct{}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// publish records an event in the outbox, within the transaction of ctx:\n")
+	b.WriteString("// the relay delivers it once the writes it comes with are committed\n")
+	b.WriteString("func publish(ctx *GMXContext, topic string, payload any) error {\n")
+	b.WriteString("\tdata, err := json.Marshal(payload)\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"publish %s: %w\", topic, err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tnow := time.Now()\n")
+	b.WriteString(fmt.Sprintf("\tevent := &%s{Topic: topic, Payload: string(data), ScheduledAt: now, CreatedAt: now}\n", outboxModel))
+	b.WriteString("\tif err := ctx.DB.Create(event).Error; err != nil {\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"publish %s: %w\", topic, err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn nil\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// inOutboxTransaction runs fn in a transaction, so that the events it\n")
+	b.WriteString("// publishes are committed with its writes or not at all, then wakes the relay\n")
+	b.WriteString("func inOutboxTransaction(ctx *GMXContext, fn func(ctx *GMXContext) error) error {\n")
+	b.WriteString("\terr := ctx.DB.Transaction(func(tx *gorm.DB) error {\n")
+	b.WriteString("\t\ttxCtx := *ctx\n")
+	b.WriteString("\t\ttxCtx.DB = tx\n")
+	b.WriteString("\t\treturn fn(&txCtx)\n")
+	b.WriteString("\t})\n")
+	b.WriteString("\tif err == nil && outboxRelay.wake != nil {\n")
+	b.WriteString("\t\tselect {\n")
+	b.WriteString("\t\tcase outboxRelay.wake <- struct{}{}:\n")
+	b.WriteString("\t\tdefault:\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn err\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// startOutboxRelay starts delivering the events of the outbox to endpoint,\n")
+	b.WriteString(fmt.Sprintf("// the url of the %s service; without it, the events wait in the outbox\n", svc.Name))
+	b.WriteString("func startOutboxRelay(endpoint string) {\n")
+	b.WriteString("\tif endpoint == \"\" {\n")
+	b.WriteString(fmt.Sprintf("\t\tslog.Warn(\"the url of the %s service is not set: published events wait in the outbox\")\n", svc.Name))
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\toutboxRelay.url = strings.TrimSuffix(endpoint, \"/\")\n")
+	b.WriteString("\toutboxRelay.client = &http.Client{Timeout: 10 * time.Second}\n")
+	b.WriteString("\toutboxRelay.wake = make(chan struct{}, 1)\n")
+	b.WriteString("\toutboxRelay.stop = make(chan struct{})\n")
+	b.WriteString("\toutboxRelay.done = make(chan struct{})\n")
+	b.WriteString("\tgo func() {\n")
+	b.WriteString("\t\tdefer close(outboxRelay.done)\n")
+	b.WriteString("\t\tticker := time.NewTicker(outboxPollInterval)\n")
+	b.WriteString("\t\tdefer ticker.Stop()\n")
+	b.WriteString("\t\tfor {\n")
+	b.WriteString("\t\t\trelayOutbox()\n")
+	b.WriteString("\t\t\tselect {\n")
+	b.WriteString("\t\t\tcase <-outboxRelay.stop:\n")
+	b.WriteString("\t\t\t\treturn\n")
+	b.WriteString("\t\t\tcase <-outboxRelay.wake:\n")
+	b.WriteString("\t\t\tcase <-ticker.C:\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}()\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// relayOutbox delivers the committed events in the order they were\n")
+	b.WriteString("// published; a failed delivery holds back the events after it until its\n")
+	b.WriteString("// next attempt, so that receivers see them in order\n")
+	b.WriteString("func relayOutbox() {\n")
+	b.WriteString(g.genHoldDB(file, "\t"))
+	b.WriteString("\tfor {\n")
+	b.WriteString(fmt.Sprintf("\t\tvar events []%s\n", outboxModel))
+	b.WriteString("\t\tif err := db.Order(\"created_at\").Limit(outboxBatchSize).Find(&events).Error; err != nil {\n")
+	b.WriteString("\t\t\tslog.Error(\"reading the outbox\", \"error\", err)\n")
+	b.WriteString("\t\t\treturn\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tfor i := range events {\n")
+	b.WriteString("\t\t\tevent := &events[i]\n")
+	b.WriteString("\t\t\tif event.ScheduledAt.After(time.Now()) {\n")
+	b.WriteString("\t\t\t\treturn\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t\tselect {\n")
+	b.WriteString("\t\t\tcase <-outboxRelay.stop:\n")
+	b.WriteString("\t\t\t\treturn\n")
+	b.WriteString("\t\t\tdefault:\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t\tif err := deliverEvent(event); err != nil {\n")
+	b.WriteString("\t\t\t\tevent.Attempts++\n")
+	b.WriteString("\t\t\t\tslog.Warn(\"delivering an event\", \"event\", event.ID, \"topic\", event.Topic, \"attempt\", event.Attempts, \"error\", err)\n")
+	b.WriteString("\t\t\t\tretry := map[string]any{\"attempts\": event.Attempts, \"last_error\": err.Error(), \"scheduled_at\": time.Now().Add(outboxBackoff(event.Attempts))}\n")
+	b.WriteString("\t\t\t\tif err := db.Model(event).Updates(retry).Error; err != nil {\n")
+	b.WriteString("\t\t\t\t\tslog.Error(\"rescheduling an event\", \"event\", event.ID, \"error\", err)\n")
+	b.WriteString("\t\t\t\t}\n")
+	b.WriteString("\t\t\t\treturn\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t\t// A failed delete delivers the event again, which its key lets receivers drop\n")
+	b.WriteString("\t\t\tif err := db.Delete(event).Error; err != nil {\n")
+	b.WriteString("\t\t\t\tslog.Error(\"removing a delivered event\", \"event\", event.ID, \"error\", err)\n")
+	b.WriteString("\t\t\t\treturn\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tif len(events) < outboxBatchSize {\n")
+	b.WriteString("\t\t\treturn\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// deliverEvent posts the payload of an event to the path of its topic,\n")
+	b.WriteString("// keyed by the event ID: delivery is at least once, and receivers drop the\n")
+	b.WriteString("// keys they already processed\n")
+	b.WriteString(fmt.Sprintf("func deliverEvent(event *%s) error {\n", outboxModel))
+	b.WriteString("\treq, err := http.NewRequest(\"POST\", outboxRelay.url+\"/\"+url.PathEscape(event.Topic), strings.NewReader(event.Payload))\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\treturn err\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treq.Header.Set(\"Content-Type\", \"application/json\")\n")
+	b.WriteString("\treq.Header.Set(\"Idempotency-Key\", event.ID)\n")
+	b.WriteString("\tresp, err := outboxRelay.client.Do(req)\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\treturn err\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tresp.Body.Close()\n")
+	b.WriteString("\tif resp.StatusCode >= 300 {\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"%s answered %s\", req.URL.Redacted(), resp.Status)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn nil\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// outboxBackoff is the wait before the next delivery of an event failing\n")
+	b.WriteString("// for the given number of times: doubling from a second, capped\n")
+	b.WriteString("func outboxBackoff(attempts int) time.Duration {\n")
+	b.WriteString(fmt.Sprintf("\treturn min(time.Second<<min(attempts-1, 10), %s)\n", outboxMaxBackoff))
+	b.WriteString("}\n\n")
+
+	b.WriteString("// stopOutboxRelay stops the relay after its current delivery, waiting for\n")
+	b.WriteString("// it until ctx expires; undelivered events stay in the outbox\n")
+	b.WriteString("func stopOutboxRelay(ctx context.Context) {\n")
+	b.WriteString("\tif outboxRelay.stop == nil {\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tclose(outboxRelay.stop)\n")
+	b.WriteString("\tselect {\n")
+	b.WriteString("\tcase <-outboxRelay.done:\n")
+	b.WriteString("\tcase <-ctx.Done():\n")
+	b.WriteString("\t\tslog.Warn(\"shutdown deadline passed while delivering an event\")\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
@@ -0,0 +1,124 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+)
+
+const outboxScriptSrc = `model Task {
+  id: uuid @pk @default(uuid_v4)
+  title: string
+}
+service Events {
+  provider: "events"
+  url: string @env("EVENTS_URL")
+}
+func createTask(title: string) error {
+  const task = Task{title: title}
+  try task.save()
+  try publish("task.created", task)
+  return nil
+}
+func listTasks() error {
+  return nil
+}`
+
+const outboxTemplateSrc = `<form hx-post="{{route "createTask"}}"></form>`
+
+func TestGenerateOutbox(t *testing.T) {
+	code, err := New().Generate(scriptTestFile(t, outboxScriptSrc, outboxTemplateSrc))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, want := range []string{
+		"type OutboxEvent struct {",
+		"db.AutoMigrate(&Task{}, &OutboxEvent{})",
+		// The event is written with the writes of the handler
+		`if err := publish(ctx, "task.created", task); err != nil {`,
+		"func publish(ctx *GMXContext, topic string, payload any) error {",
+		"\tif err := ctx.DB.Create(event).Error; err != nil {\n",
+		"if err := inOutboxTransaction(ctx, func(ctx *GMXContext) error { return createTask(ctx, title) }); err != nil {",
+		"\terr := ctx.DB.Transaction(func(tx *gorm.DB) error {\n",
+		// The relay delivers in order, keyed by the event ID
+		"\tstartOutboxRelay(eventsCfg.Url)\n",
+		`db.Order("created_at").Limit(outboxBatchSize).Find(&events)`,
+		`req.Header.Set("Idempotency-Key", event.ID)`,
+		`"scheduled_at": time.Now().Add(outboxBackoff(event.Attempts))`,
+		"\treturn min(time.Second<<min(attempts-1, 10), 10*time.Minute)\n",
+		"\tstopOutboxRelay(shutdownCtx)\n",
+		"\t\"net/url\"\n",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in generated code", want)
+		}
+	}
+
+	// Handlers publishing nothing run outside a transaction
+	if strings.Contains(code, "return listTasks(ctx)") {
+		t.Error("expected listTasks to be called without the outbox transaction")
+	}
+}
+
+func TestGenerateOutboxCustomPublish(t *testing.T) {
+	src := strings.Replace(outboxScriptSrc, "func listTasks() error {", "func publish(topic: string, record: Task) error {", 1)
+	src = strings.Replace(src, "  provider: \"events\"\n  url: string @env(\"EVENTS_URL\")\n", "  provider: \"custom\"\n", 1)
+	code, err := New().Generate(scriptTestFile(t, src, outboxTemplateSrc))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	for _, unwanted := range []string{"OutboxEvent", "inOutboxTransaction", "startOutboxRelay"} {
+		if strings.Contains(code, unwanted) {
+			t.Errorf("unexpected %q in generated code: the script declares publish", unwanted)
+		}
+	}
+	// Called as the other script functions, but not served
+	if !strings.Contains(code, `if err := publish(ctx, "task.created", task); err != nil {`) {
+		t.Error("expected the custom publish to be called with the context")
+	}
+	if strings.Contains(code, "handlePublish") {
+		t.Error("unexpected handler for the custom publish")
+	}
+	goInModule(t, map[string]string{"main.go": code}, "vet", ".")
+}
+
+func TestGenerateOutboxErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{
+			name:    "no events service",
+			src:     strings.Replace(outboxScriptSrc, `provider: "events"`, `provider: "custom"`, 1),
+			wantErr: `line 12: publish requires a service with provider "events"`,
+		},
+		{
+			name:    "missing payload",
+			src:     strings.Replace(outboxScriptSrc, `publish("task.created", task)`, `publish("task.created")`, 1),
+			wantErr: "line 12: publish takes a topic and a payload, got 1 arguments",
+		},
+		{
+			name:    "unknown field",
+			src:     strings.Replace(outboxScriptSrc, "url: string", "topic: string", 1),
+			wantErr: "service Events: the events provider takes a url field, not topic",
+		},
+		{
+			name:    "model collision",
+			src:     strings.Replace(outboxScriptSrc, "model Task {", "model OutboxEvent {\n  id: uuid @pk @default(uuid_v4)\n}\nmodel Task {", 1),
+			wantErr: "model OutboxEvent collides with the model storing the published events",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New().Generate(scriptTestFile(t, tt.src, outboxTemplateSrc))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package generator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template/parse"
+	"unicode"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// pageTemplateName returns the template a directory build defines for a page
+func pageTemplateName(page *ast.Page) string {
+	return "page:" + page.Route
+}
+
+// hoistPageDefines moves the {{define}} blocks of a page template out of its
+// body, itself defined as the page's template, where they would nest. Each is
+// defined under the page's name, page:/tasks#TaskRow, which the page's own
+// references follow, and keeps its plain name for render() and the other pages
+func hoistPageDefines(page *ast.Page, src string) (body, defines string) {
+	tree := parse.New("page")
+	tree.Mode = parse.SkipFuncCheck | parse.ParseComments
+	treeSet := make(map[string]*parse.Tree)
+	// A page that does not parse reports its errors when the templates load
+	if _, err := tree.Parse(src, "", "", treeSet); err != nil || len(treeSet) < 2 {
+		return src, ""
+	}
+
+	names := make([]string, 0, len(treeSet)-1)
+	for name := range treeSet {
+		if name != "page" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	prefix := pageTemplateName(page) + "#"
+	qualify := func(src string) string {
+		for _, name := range names {
+			src = strings.ReplaceAll(src, fmt.Sprintf("{{template %q", name), fmt.Sprintf("{{template %q", prefix+name))
+		}
+		return src
+	}
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(fmt.Sprintf("{{define %q}}%s{{end}}\n", prefix+name, qualify(treeSet[name].Root.String())))
+		b.WriteString(fmt.Sprintf("{{define %q}}{{template %q .}}{{end}}\n", name, prefix+name))
+	}
+	return qualify(treeSet["page"].Root.String()), b.String()
+}
+
+// pageHandler returns the handler serving a page of a directory build: the
+// root page keeps handleIndex, /tasks/archive is served by handlePageTasksArchive
+func pageHandler(page *ast.Page) string {
+	if page.Route == "/" {
+		return "handleIndex"
+	}
+	var b strings.Builder
+	b.WriteString("handlePage")
+	upper := true
+	for _, r := range page.Route {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// validatePages checks that the pages of a directory build get distinct handlers
+func (g *Generator) validatePages(file *ast.GMXFile) error {
+	handlers := make(map[string]string) // handler → route
+	for _, page := range file.Pages {
+		name := pageHandler(page)
+		if other, ok := handlers[name]; ok {
+			return fmt.Errorf("pages %s and %s are both served by %s; rename one of them", other, page.Route, name)
+		}
+		handlers[name] = page.Route
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package generator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/resolver"
+)
+
+func pagesTestFile() *resolver.ResolvedFile {
+	home := &ast.TemplateBlock{Source: "<h1>Home</h1>"}
+	tasks := &ast.TemplateBlock{Source: "<ul>{{range .Tasks}}<li>{{.Title}}</li>{{end}}</ul>"}
+	return &resolver.ResolvedFile{Main: &ast.GMXFile{
+		Models: []*ast.ModelDecl{{Name: "Task", Fields: []*ast.FieldDecl{
+			{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{{Name: "pk"}}},
+			{Name: "title", Type: "string"},
+			{Name: "createdAt", Type: "datetime"},
+		}}},
+		Template: &ast.TemplateBlock{Source: home.Source + "\n" + tasks.Source},
+		Pages: []*ast.Page{
+			{Route: "/", Path: "/app/index.gmx", Template: home},
+			{Route: "/tasks/archive", Path: "/app/tasks/archive.gmx", Template: tasks, Style: &ast.StyleBlock{Source: "ul { margin: 0; }"}},
+		},
+	}}
+}
+
+func TestPageHandler(t *testing.T) {
+	tests := []struct {
+		route string
+		want  string
+	}{
+		{"/", "handleIndex"},
+		{"/about", "handlePageAbout"},
+		{"/tasks/archive", "handlePageTasksArchive"},
+		{"/user-settings", "handlePageUserSettings"},
+	}
+
+	for _, tt := range tests {
+		if got := pageHandler(&ast.Page{Route: tt.route}); got != tt.want {
+			t.Errorf("pageHandler(%q) = %q, want %q", tt.route, got, tt.want)
+		}
+	}
+}
+
+func TestRoutesPages(t *testing.T) {
+	routes := New().Routes(pagesTestFile())
+	want := []Route{
+		{Method: "*", Path: "/", Handler: "handleIndex", Source: "page"},
+		{Method: "GET", Path: appCSSPath, Handler: "handleAppCSS", Source: "built-in"},
+		{Method: "*", Path: "/tasks/archive", Handler: "handlePageTasksArchive", Source: "page"},
+	}
+	if !reflect.DeepEqual(routes, want) {
+		t.Errorf("Routes() =\n%+v\nwant\n%+v", routes, want)
+	}
+}
+
+func TestGeneratePages(t *testing.T) {
+	code, err := New().GenerateResolved(pagesTestFile())
+	if err != nil {
+		t.Fatalf("GenerateResolved() error: %v", err)
+	}
+
+	for _, want := range []string{
+		`{{define "page:/"}}`,
+		`{{define "page:/tasks/archive"}}`,
+		`{{renderItem "Task" .}}`,
+		`tmpl.ExecuteTemplate(w, "page:/", data)`,
+		`tmpl.ExecuteTemplate(w, "page:/tasks/archive", data)`,
+		"func handlePageTasksArchive(w http.ResponseWriter, r *http.Request) {",
+		`mux.HandleFunc("/tasks/archive", handlePageTasksArchive)`,
+		"ul { margin: 0; }",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in generated code", want)
+		}
+	}
+	// "/" matches every path; the root page only serves itself
+	if !strings.Contains(code, "if r.URL.Path != \"/\" {\n\t\thttp.NotFound(w, r)") {
+		t.Error("expected the root page to answer 404 for unknown paths")
+	}
+}
+
+func TestValidatePages(t *testing.T) {
+	file := &ast.GMXFile{Pages: []*ast.Page{{Route: "/user-settings"}, {Route: "/user_settings"}}}
+	err := New().validatePages(file)
+	if err == nil || !strings.Contains(err.Error(), "pages /user-settings and /user_settings are both served by handlePageUserSettings") {
+		t.Errorf("validatePages() = %v, want a handler collision", err)
+	}
+}
+
+// pageDefinesTest runs in the generated package: the page templates, with
+// their own {{define}} blocks, load and render
+const pageDefinesTest = `package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPageDefines(t *testing.T) {
+	var out strings.Builder
+	data := map[string]any{"Tasks": []Task{{Title: "Write"}}}
+	if err := tmpl.ExecuteTemplate(&out, "page:/tasks", data); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "<li><b>!</b>Write</li>") {
+		t.Errorf("page:/tasks rendered %q", out.String())
+	}
+	out.Reset()
+	if err := tmpl.ExecuteTemplate(&out, "TaskRow", Task{Title: "Ship"}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "<li><b>!</b>Ship</li>" {
+		t.Errorf("TaskRow rendered %q", out.String())
+	}
+}
+`
+
+func TestGeneratePageDefines(t *testing.T) {
+	tasks := &ast.TemplateBlock{Source: `{{define "TaskRow"}}<li>{{template "Badge" .}}{{.Title}}</li>{{end}}{{define "Badge"}}<b>!</b>{{end}}<ul>{{range .Tasks}}{{template "TaskRow" .}}{{end}}</ul>`}
+	home := &ast.TemplateBlock{Source: `<h1>Home</h1>{{fragment "tasks/TaskRow" .}}`}
+	file := pagesTestFile()
+	file.Main.Template = &ast.TemplateBlock{Source: home.Source + "\n" + tasks.Source}
+	file.Main.Pages = []*ast.Page{
+		{Route: "/", Path: "/app/index.gmx", Template: home},
+		{Route: "/tasks", Path: "/app/tasks.gmx", Template: tasks},
+	}
+	// Exported by the resolver for the home page
+	file.Fragments = map[string]*resolver.FragmentInfo{
+		"tasks/TaskRow": {Name: "tasks/TaskRow", Source: `<li>{{template "tasks/Badge" .}}{{.Title}}</li>`, Path: "/app/tasks.gmx"},
+		"tasks/Badge":   {Name: "tasks/Badge", Source: "<b>!</b>", Path: "/app/tasks.gmx"},
+	}
+
+	code, err := New().GenerateResolved(file)
+	if err != nil {
+		t.Fatalf("GenerateResolved() error: %v", err)
+	}
+	for _, want := range []string{
+		// Hoisted out of the page, under the page's name and their own
+		`{{define "page:/tasks#TaskRow"}}<li>{{template "page:/tasks#Badge" .}}{{.Title}}</li>{{end}}`,
+		`{{define "TaskRow"}}{{template "page:/tasks#TaskRow" .}}{{end}}`,
+		`{{template "page:/tasks#TaskRow" .}}`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in generated code", want)
+		}
+	}
+	// The template set loads at startup
+	goInModule(t, map[string]string{"main.go": code, "main_test.go": pageDefinesTest}, "test", ".")
+}
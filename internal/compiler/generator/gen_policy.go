@@ -0,0 +1,92 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/script"
+)
+
+// hasPolicies reports whether a model declares a policy
+func (g *Generator) hasPolicies(file *ast.GMXFile) bool {
+	for _, model := range file.Models {
+		if model.Policy != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePolicies checks that the rules of model policies can be enforced:
+// ctx.user comes from the session cookie, role(admin) from its admins list,
+// and write rules look the stored record up by its @pk field
+func (g *Generator) validatePolicies(file *ast.GMXFile) error {
+	for _, model := range file.Models {
+		policy := model.Policy
+		if policy == nil {
+			continue
+		}
+		for _, role := range script.PolicyRoles(policy) {
+			if role != "admin" {
+				return fmt.Errorf("policy %s: unsupported role %q (expected admin)", model.Name, role)
+			}
+			if !g.hasImpersonation(file) {
+				return fmt.Errorf("policy %s: role(admin) requires a session service with an `admins` field", model.Name)
+			}
+		}
+		if usesSessionUser(policy) && g.findSessionService(file.Services) == nil {
+			return fmt.Errorf("policy %s: ctx.user requires a service with provider \"session\"", model.Name)
+		}
+		if modelPKField(model) == nil {
+			for _, rule := range policy.Rules {
+				if rule.Action != "read" {
+					return fmt.Errorf("policy %s: the %s rule checks the stored record, which requires a @pk field", model.Name, rule.Action)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// usesSessionUser reports whether a policy rule reads ctx.user
+func usesSessionUser(policy *ast.PolicyDecl) bool {
+	found := false
+	ast.Inspect(policy, func(n ast.Node) bool {
+		if c, ok := n.(*ast.CtxExpr); ok && c.Field == "user" {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// genPolicyPageFilter drops the records of the page data that the read rule
+// of their model's policy hides from the requesting user
+func (g *Generator) genPolicyPageFilter(file *ast.GMXFile) string {
+	var readable []*ast.ModelDecl
+	for _, model := range file.Models {
+		if model.Policy != nil && model.Policy.FindRule("read") != nil {
+			readable = append(readable, model)
+		}
+	}
+	if len(readable) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("\t// Keep the records the policies let the user read\n")
+	b.WriteString("\tctx := &GMXContext{DB: db, Writer: w, Request: r, Log: requestLogger(r)")
+	if g.hasRowLevelSecurity(file) {
+		b.WriteString(", Tenant: requestTenant(r)")
+	}
+	if g.findSessionService(file.Services) != nil {
+		b.WriteString(", User: readSession(r).User")
+	}
+	b.WriteString("}\n")
+	for _, model := range readable {
+		b.WriteString(fmt.Sprintf("\tdata.%ss = %sReadable(ctx, data.%ss)\n", model.Name, model.Name, model.Name))
+	}
+	b.WriteString("\n")
+	return b.String()
+}
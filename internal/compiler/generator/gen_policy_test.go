@@ -0,0 +1,186 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// policyTestFile returns a file whose Task model has the given policy rules,
+// conditions being ctx.user or role(admin)
+func policyTestFile(withAdmins bool, actions ...string) *ast.GMXFile {
+	file := sessionTestFile(withAdmins)
+	file.Template = &ast.TemplateBlock{Source: `<ul>{{range .Tasks}}<li>{{.Title}}</li>{{end}}</ul>`}
+	policy := &ast.PolicyDecl{Model: "Task"}
+	for _, action := range actions {
+		var cond ast.Expression = &ast.BinaryExpr{Left: &ast.CtxExpr{Field: "user"}, Op: "!=", Right: &ast.StringLit{Value: ""}}
+		if action == "delete" {
+			cond = &ast.CallExpr{Function: &ast.Ident{Name: "role"}, Args: []ast.Expression{&ast.Ident{Name: "admin"}}}
+		}
+		policy.Rules = append(policy.Rules, &ast.PolicyRule{Action: action, Cond: cond})
+	}
+	file.Models = []*ast.ModelDecl{{
+		Name: "Task",
+		Fields: []*ast.FieldDecl{
+			{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{{Name: "pk"}}},
+			{Name: "title", Type: "string"},
+		},
+		Policy: policy,
+	}}
+	return file
+}
+
+func TestGeneratePolicy(t *testing.T) {
+	code, err := New().Generate(policyTestFile(true, "read", "delete"))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, want := range []string{
+		`"errors"`,
+		"func authorizeTask(ctx *GMXContext, action string, task *Task) error {",
+		"allowed = sessionAdmins[ctx.User]",
+		// Denials answer 403 instead of 500
+		"if errors.Is(err, errForbidden) {\n\t\t\thttp.Error(w, \"Forbidden\", http.StatusForbidden)",
+		// The page only shows the records the read rule allows
+		"ctx := &GMXContext{DB: db, Writer: w, Request: r, Log: requestLogger(r), User: readSession(r).User}",
+		"data.Tasks = TaskReadable(ctx, data.Tasks)",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+}
+
+func TestGeneratePolicyWithoutReadRule(t *testing.T) {
+	code, err := New().Generate(policyTestFile(true, "delete"))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if strings.Contains(code, "data.Tasks = TaskReadable") {
+		t.Error("the page should not filter records without a read rule")
+	}
+}
+
+func TestGeneratePolicyImportedWithoutScript(t *testing.T) {
+	file := policyTestFile(true, "read")
+	file.Script = nil
+
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !strings.Contains(code, "func TaskReadable(ctx *GMXContext, objs []Task) []Task {") {
+		t.Error("policy helpers should be generated for a model imported without script")
+	}
+}
+
+// policyLimitTest checks that a limit counts the records the read rule keeps,
+// past pages of records it hides
+const policyLimitTest = `package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestWhereAuthorizedLimit(t *testing.T) {
+	conn, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "app.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.AutoMigrate(&Task{}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 150; i++ {
+		if err := conn.Create(&Task{Title: fmt.Sprintf("hidden %03d", i), Archived: true}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, title := range []string{"shown 1", "shown 2", "shown 3"} {
+		if err := conn.Create(&Task{Title: title}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := &GMXContext{DB: conn}
+
+	tasks, err := TaskWhereAuthorized(ctx, 2, queryOrder("title", false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 2 || tasks[0].Title != "shown 1" || tasks[1].Title != "shown 2" {
+		t.Errorf("limit 2: got %v", tasks)
+	}
+	if tasks, _ := TaskWhereAuthorized(ctx, 0); len(tasks) != 3 {
+		t.Errorf("no limit: got %d tasks, want 3", len(tasks))
+	}
+	task, err := TaskFirstAuthorized(ctx, queryOrder("title", true))
+	if err != nil || task.Title != "shown 3" {
+		t.Errorf("first: got %v, %v", task, err)
+	}
+}
+`
+
+func TestGeneratePolicyLimit(t *testing.T) {
+	src := `model Task {
+  id: uuid @pk @default(uuid_v4)
+  title: string
+  archived: bool
+}
+
+policy Task { read: task.archived == false }`
+	code, err := New().Generate(scriptTestFile(t, src, "<ul>{{range .Tasks}}<li>{{.Title}}</li>{{end}}</ul>"))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	goInModule(t, map[string]string{"main.go": code, "main_test.go": policyLimitTest}, "test", ".")
+}
+
+func TestValidatePolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    func() *ast.GMXFile
+		wantErr string
+	}{
+		{
+			name:    "role without admins",
+			file:    func() *ast.GMXFile { return policyTestFile(false, "delete") },
+			wantErr: "policy Task: role(admin) requires a session service with an `admins` field",
+		},
+		{
+			name: "ctx.user without session",
+			file: func() *ast.GMXFile {
+				file := policyTestFile(false, "read")
+				file.Services = nil
+				return file
+			},
+			wantErr: `policy Task: ctx.user requires a service with provider "session"`,
+		},
+		{
+			name: "write rule without pk",
+			file: func() *ast.GMXFile {
+				file := policyTestFile(false, "update")
+				file.Models[0].Fields = file.Models[0].Fields[1:]
+				return file
+			},
+			wantErr: "policy Task: the update rule checks the stored record, which requires a @pk field",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New().Generate(tt.file())
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	gmxerrors "github.com/btouchard/gmx/internal/compiler/errors"
+	"github.com/btouchard/gmx/internal/compiler/resolver"
+	"github.com/btouchard/gmx/internal/compiler/script"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// preloadDB returns the connection the records of a model are loaded with:
+// db, preloading the relations of its @preload
+func preloadDB(model *ast.ModelDecl) string {
+	db := "db"
+	for _, relation := range model.Preloads() {
+		db += fmt.Sprintf(".Preload(%q)", utils.ToPascalCase(relation))
+	}
+	return db
+}
+
+// isRelationField reports whether a field refers to a model of the file:
+// user: User or posts: Post[]
+func isRelationField(file *ast.GMXFile, field *ast.FieldDecl) bool {
+	target := strings.TrimSuffix(field.Type, "[]")
+	for _, model := range file.Models {
+		if model.Name == target {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePreloads checks that @preload lists relation fields of its model
+func (g *Generator) validatePreloads(file *ast.GMXFile) error {
+	for _, model := range file.Models {
+		ann := model.FindAnnotation("preload")
+		if ann == nil {
+			continue
+		}
+		if len(ann.Args) != 1 || ann.SimpleArg() == "" {
+			return fmt.Errorf("line %d: model %s: @preload takes relation fields, such as @preload(user) or @preload([user, tags])", model.Line, model.Name)
+		}
+		seen := make(map[string]bool)
+		for _, name := range model.Preloads() {
+			field := model.FindField(name)
+			if field == nil {
+				return fmt.Errorf("line %d: model %s: @preload refers to unknown field %s", model.Line, model.Name, name)
+			}
+			if !isRelationField(file, field) {
+				return fmt.Errorf("line %d: model %s: @preload applies to relations, not to the %s field %s", model.Line, model.Name, field.Type, name)
+			}
+			// Preloaded records skip the policy of their model
+			related := strings.TrimSuffix(field.Type, "[]")
+			for _, other := range file.Models {
+				if other.Name == related && script.HasReadRule(other.Policy) {
+					return fmt.Errorf("line %d: model %s: @preload(%s) would load %s records past the read rule of their policy", model.Line, model.Name, name, related)
+				}
+			}
+			if seen[name] {
+				return fmt.Errorf("line %d: model %s: @preload lists %s twice", model.Line, model.Name, name)
+			}
+			seen[name] = true
+		}
+	}
+	return nil
+}
+
+// Warnings reports the N+1 query patterns of a file, which compile but load
+// records one query at a time or not at all: model queries inside script
+// loops, and relations a page template reads from records loaded without them
+func (g *Generator) Warnings(file *ast.GMXFile) []string {
+	warnings := gmxerrors.NewErrorList()
+	if file.Script != nil {
+		g.loopQueryWarnings(file, warnings)
+	}
+	if file.Template != nil {
+		funcs := template.FuncMap{}
+		for _, name := range g.templateFuncNames(file) {
+			funcs[name] = func() string { return "" }
+		}
+		// The pages of a directory build are checked in their own files
+		if len(file.Pages) > 0 {
+			for _, page := range file.Pages {
+				templatePreloadWarnings(file, page.Template, page.Path, funcs, warnings)
+			}
+		} else {
+			templatePreloadWarnings(file, file.Template, g.opts.Source, funcs, warnings)
+		}
+	}
+
+	msgs := make([]string, len(warnings.Errors))
+	for i, w := range warnings.Errors {
+		msgs[i] = w.Error()
+	}
+	return msgs
+}
+
+// loopQueryWarnings reports the model queries run once per iteration of a
+// for loop
+func (g *Generator) loopQueryWarnings(file *ast.GMXFile, warnings *gmxerrors.ErrorList) {
+	models := make(map[string]bool)
+	for _, model := range file.Models {
+		models[model.Name] = true
+	}
+	reported := make(map[*ast.CallExpr]bool)
+	for _, fn := range file.Script.Funcs {
+		ast.Inspect(fn, func(node ast.Node) bool {
+			loop, ok := node.(*ast.ForStmt)
+			if !ok {
+				return true
+			}
+			for _, stmt := range loop.Body {
+				ast.Inspect(stmt, func(node ast.Node) bool {
+					call, ok := node.(*ast.CallExpr)
+					if !ok || reported[call] {
+						return true
+					}
+					member, ok := call.Function.(*ast.MemberExpr)
+					if !ok {
+						return true
+					}
+					// The root call of a query chain names the model: Task.where(...)
+					if obj, ok := member.Object.(*ast.Ident); ok && models[obj.Name] && modelLoads[member.Property] {
+						reported[call] = true
+						warnings.Add(gmxerrors.Position{File: g.opts.Source, Line: call.Line}, "n+1",
+							fmt.Sprintf("%s.%s() in a for loop queries the database once per iteration; load the records before the loop, or their relation with include:", obj.Name, member.Property))
+					}
+					return true
+				})
+			}
+			return true
+		})
+	}
+}
+
+// modelLoads are the model methods which load records from the database
+var modelLoads = map[string]bool{"find": true, "all": true, "where": true, "first": true}
+
+// templatePreloadWarnings reports the relations a page template reads from
+// the records of {{range .Tasks}}, which the page loads without them
+func templatePreloadWarnings(file *ast.GMXFile, block *ast.TemplateBlock, path string, funcs template.FuncMap, warnings *gmxerrors.ErrorList) {
+	src := resolver.RewriteFragmentCalls(block.Source)
+	tmpl, err := template.New("page").Funcs(funcs).Parse(src)
+	if err != nil || tmpl.Tree == nil {
+		// Parse errors are reported by validateTemplates
+		return
+	}
+	c := &preloadChecker{
+		file:     file,
+		reported: make(map[string]bool),
+		report: func(offset int, msg string) {
+			warnings.Add(templatePosition(block, path, src, offset), "n+1", msg)
+		},
+	}
+	c.walk(tmpl.Tree.Root, nil, true)
+}
+
+// preloadChecker walks a page template, tracking the model of the records
+// ranged over, and reports the relations read from them that are not preloaded
+type preloadChecker struct {
+	file     *ast.GMXFile
+	reported map[string]bool // Model.relation already reported
+	report   func(offset int, msg string)
+}
+
+// walk checks node where dot is a record of model, or the page data when
+// page is set; dot is unknown otherwise
+func (c *preloadChecker) walk(node parse.Node, model *ast.ModelDecl, page bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			c.walk(child, model, page)
+		}
+	case *parse.ActionNode:
+		c.pipe(n.Pipe, model)
+	case *parse.IfNode:
+		c.pipe(n.Pipe, model)
+		c.walk(n.List, model, page)
+		c.walk(n.ElseList, model, page)
+	case *parse.RangeNode:
+		c.pipe(n.Pipe, model)
+		var records *ast.ModelDecl
+		if page {
+			records = c.rangedModel(n.Pipe)
+		}
+		c.walk(n.List, records, false)
+		c.walk(n.ElseList, model, page)
+	case *parse.WithNode:
+		c.pipe(n.Pipe, model)
+		c.walk(n.List, nil, false)
+		c.walk(n.ElseList, model, page)
+	case *parse.TemplateNode:
+		c.pipe(n.Pipe, model)
+	}
+}
+
+// pipe reports the relations of model read by the commands of a pipeline
+func (c *preloadChecker) pipe(pipe *parse.PipeNode, model *ast.ModelDecl) {
+	if pipe == nil || model == nil {
+		return
+	}
+	for _, cmd := range pipe.Cmds {
+		for _, arg := range cmd.Args {
+			switch a := arg.(type) {
+			case *parse.FieldNode:
+				// The position of .User.Name is that of its last identifier
+				offset := int(a.Pos)
+				for _, ident := range a.Ident[:len(a.Ident)-1] {
+					offset -= len(ident) + 1
+				}
+				c.field(a.Ident[0], offset, model)
+			case *parse.PipeNode:
+				c.pipe(a, model)
+			}
+		}
+	}
+}
+
+// field reports a relation of model which its records are loaded without
+func (c *preloadChecker) field(name string, offset int, model *ast.ModelDecl) {
+	for _, field := range model.Fields {
+		if utils.ToPascalCase(field.Name) != name || !isRelationField(c.file, field) {
+			continue
+		}
+		for _, preload := range model.Preloads() {
+			if preload == field.Name {
+				return
+			}
+		}
+		// The records of a model with a read rule are never preloaded
+		related := strings.TrimSuffix(field.Type, "[]")
+		for _, other := range c.file.Models {
+			if other.Name == related && script.HasReadRule(other.Policy) {
+				return
+			}
+		}
+		key := model.Name + "." + field.Name
+		if c.reported[key] {
+			return
+		}
+		c.reported[key] = true
+		c.report(offset, fmt.Sprintf("{{range .%ss}} reads .%s, which the page loads without it: add @preload(%s) to model %s", model.Name, name, field.Name, model.Name))
+		return
+	}
+}
+
+// rangedModel returns the model of {{range .Tasks}} over the page data, or nil
+func (c *preloadChecker) rangedModel(pipe *parse.PipeNode) *ast.ModelDecl {
+	if len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return nil
+	}
+	field, ok := pipe.Cmds[0].Args[0].(*parse.FieldNode)
+	if !ok || len(field.Ident) != 1 {
+		return nil
+	}
+	for _, model := range c.file.Models {
+		if field.Ident[0] == model.Name+"s" {
+			return model
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+)
+
+const preloadScriptSrc = `model User {
+  id: uuid @pk @default(uuid_v4)
+  name: string
+  tasks: Task[]
+}
+
+@preload(user)
+model Task {
+  id: uuid @pk @default(uuid_v4)
+  title: string
+  userId: uuid
+  user: User @relation(references: [id])
+}`
+
+func TestGeneratePreload(t *testing.T) {
+	code, err := New().Generate(scriptTestFile(t, preloadScriptSrc, "{{range .Tasks}}{{.User.Name}}{{end}}"))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, want := range []string{
+		"\tdb.Preload(\"User\").Find(&data.Tasks)\n",
+		"\tdb.Find(&data.Users)\n",
+		"if err := db.Preload(\"User\").Find(&objs).Error; err != nil {",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in generated code", want)
+		}
+	}
+}
+
+func TestValidatePreloads(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{"unknown field", strings.Replace(preloadScriptSrc, "@preload(user)", "@preload(owner)", 1), "model Task: @preload refers to unknown field owner"},
+		{"column", strings.Replace(preloadScriptSrc, "@preload(user)", "@preload(title)", 1), "model Task: @preload applies to relations, not to the string field title"},
+		{"twice", strings.Replace(preloadScriptSrc, "@preload(user)", "@preload([user, user])", 1), "model Task: @preload lists user twice"},
+		{"no relation", strings.Replace(preloadScriptSrc, "@preload(user)", "@preload", 1), "model Task: @preload takes relation fields"},
+		{"read policy", preloadScriptSrc + "\npolicy User { read: user.id == ctx.user }", "model Task: @preload(user) would load User records past the read rule of their policy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New().Generate(scriptTestFile(t, tt.src, ""))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	src := strings.Replace(preloadScriptSrc, "@preload(user)", "@preload([user])", 1)
+	src = strings.Replace(src, "model User {", "@preload(tasks)\nmodel User {", 1)
+	if _, err := New().Generate(scriptTestFile(t, src, "")); err != nil {
+		t.Errorf("expected relations to be preloaded, got %v", err)
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	loop := preloadScriptSrc + `
+
+func notify(ids: string[]) error {
+  for id in ids {
+    let task = try Task.find(id)
+    let done = try Task.where(title: task.title).first()
+  }
+  return nil
+}`
+
+	tests := []struct {
+		name string
+		src  string
+		tmpl string
+		want []string
+	}{
+		{"preloaded", preloadScriptSrc, "{{range .Tasks}}{{.User.Name}}{{end}}", nil},
+		{
+			"relation not preloaded",
+			strings.Replace(preloadScriptSrc, "@preload(user)", "", 1),
+			"<ul>\n{{range .Tasks}}<li>{{.Title}} {{.User.Name}} {{.User.ID}}</li>{{end}}\n</ul>",
+			[]string{"[n+1] app.gmx:21:34: {{range .Tasks}} reads .User, which the page loads without it: add @preload(user) to model Task"},
+		},
+		{
+			"has-many relation",
+			preloadScriptSrc,
+			"{{range .Users}}{{range .Tasks}}{{.Title}}{{end}}{{end}}",
+			[]string{"[n+1] app.gmx:20:25: {{range .Users}} reads .Tasks, which the page loads without it: add @preload(tasks) to model User"},
+		},
+		{"page fields", preloadScriptSrc, "{{.Tasks}}{{with .Users}}{{.Tasks}}{{end}}", nil},
+		{
+			// @preload(user) would be refused
+			"relation with a read rule",
+			strings.Replace(preloadScriptSrc, "@preload(user)", "", 1) + "\npolicy User { read: user.id == ctx.user }",
+			"{{range .Tasks}}{{.User.Name}}{{end}}",
+			nil,
+		},
+		{
+			"queries in a loop",
+			loop,
+			"",
+			[]string{
+				"[n+1] app.gmx:17: Task.find() in a for loop queries the database once per iteration; load the records before the loop, or their relation with include:",
+				"[n+1] app.gmx:18: Task.where() in a for loop queries the database once per iteration; load the records before the loop, or their relation with include:",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := scriptTestFile(t, tt.src, tt.tmpl)
+			file.Template.StartLine = 19 // the page template starts on line 20
+			got := NewWithOptions(Options{Source: "app.gmx"}).Warnings(file)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got warnings:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
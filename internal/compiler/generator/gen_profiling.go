@@ -0,0 +1,77 @@
+package generator
+
+import (
+	"strings"
+)
+
+// pprofPath is the dev-only prefix serving the net/http/pprof profiles
+const pprofPath = "/__gmx/pprof/"
+
+// genProfiling generates the dev-only profiling support: the pprof handler,
+// restricted to requests from the local machine, and the --profile flag
+// writing a CPU profile of the whole run
+func (g *Generator) genProfiling() string {
+	var b strings.Builder
+
+	b.WriteString("// profileFlag names the file receiving a CPU profile of the run, written when the server stops\n")
+	b.WriteString("var profileFlag = flag.String(\"profile\", \"\", \"write a CPU profile to this file when the server stops\")\n\n")
+
+	b.WriteString("// startCPUProfile profiles the server until the returned function, called\n")
+	b.WriteString("// once it has shut down, writes the profile to path\n")
+	b.WriteString("func startCPUProfile(path string) func() {\n")
+	b.WriteString("\tf, err := os.Create(path)\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\tlog.Fatalf(\"profile: %v\", err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif err := pprof.StartCPUProfile(f); err != nil {\n")
+	b.WriteString("\t\tlog.Fatalf(\"profile: %v\", err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn func() {\n")
+	b.WriteString("\t\tpprof.StopCPUProfile()\n")
+	b.WriteString("\t\tif err := f.Close(); err != nil {\n")
+	b.WriteString("\t\t\tlog.Fatalf(\"profile: %v\", err)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tslog.Info(\"CPU profile written\", \"path\", path)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// isLocalRequest reports whether a request comes straight from the local\n")
+	b.WriteString("// machine; proxied requests are refused, whatever their origin\n")
+	b.WriteString("func isLocalRequest(r *http.Request) bool {\n")
+	b.WriteString("\tif r.Header.Get(\"X-Forwarded-For\") != \"\" || r.Header.Get(\"Forwarded\") != \"\" {\n")
+	b.WriteString("\t\treturn false\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\thost, _, err := net.SplitHostPort(r.RemoteAddr)\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\treturn false\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tip := net.ParseIP(host)\n")
+	b.WriteString("\treturn ip != nil && ip.IsLoopback()\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// handlePprof serves the net/http/pprof profiles to local clients:\n")
+	b.WriteString("// go tool pprof http://localhost:8080" + pprofPath + "heap\n")
+	b.WriteString("func handlePprof(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString("\tif !isLocalRequest(r) {\n")
+	b.WriteString("\t\thttp.Error(w, \"Forbidden\", http.StatusForbidden)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\tswitch name := strings.TrimPrefix(r.URL.Path, " + `"` + pprofPath + `"` + "); name {\n")
+	b.WriteString("\tcase \"cmdline\":\n")
+	b.WriteString("\t\thttppprof.Cmdline(w, r)\n")
+	b.WriteString("\tcase \"profile\":\n")
+	b.WriteString("\t\thttppprof.Profile(w, r)\n")
+	b.WriteString("\tcase \"symbol\":\n")
+	b.WriteString("\t\thttppprof.Symbol(w, r)\n")
+	b.WriteString("\tcase \"trace\":\n")
+	b.WriteString("\t\thttppprof.Trace(w, r)\n")
+	b.WriteString("\tdefault:\n")
+	b.WriteString("\t\t// Index serves the named profiles found under its own /debug/pprof/ prefix\n")
+	b.WriteString("\t\tr = r.Clone(r.Context())\n")
+	b.WriteString("\t\tr.URL.Path = \"/debug/pprof/\" + name\n")
+	b.WriteString("\t\thttppprof.Index(w, r)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
@@ -0,0 +1,56 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+func TestGenerator_Profiling(t *testing.T) {
+	tests := []struct {
+		name       string
+		dev        bool
+		expected   []string
+		unexpected []string
+	}{
+		{
+			name: "dev",
+			dev:  true,
+			expected: []string{
+				`var profileFlag = flag.String("profile", "", "write a CPU profile to this file when the server stops")`,
+				"if *profileFlag != \"\" {\n\t\tdefer startCPUProfile(*profileFlag)()",
+				"pprof.StartCPUProfile(f)",
+				"return ip != nil && ip.IsLoopback()",
+				`mux.HandleFunc("/__gmx/pprof/", handlePprof)`,
+				`httppprof "net/http/pprof"`,
+				`"runtime/pprof"`,
+			},
+		},
+		{
+			name:       "production",
+			dev:        false,
+			unexpected: []string{"profileFlag", "handlePprof", "pprof", "flag.Parse()"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := &ast.GMXFile{Template: &ast.TemplateBlock{Source: "<h1>Hello</h1>"}}
+			code, err := NewWithOptions(Options{Dev: tt.dev}).Generate(file)
+			if err != nil {
+				t.Fatalf("Generate() error: %v", err)
+			}
+			for _, want := range tt.expected {
+				if !strings.Contains(code, want) {
+					t.Errorf("expected %q in generated code", want)
+				}
+			}
+			for _, unwanted := range tt.unexpected {
+				if strings.Contains(code, unwanted) {
+					t.Errorf("unexpected %q in generated code", unwanted)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package generator
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// queryLogFields lists the Database service fields configuring the query log
+var queryLogFields = []string{"logLevel", "slowQuery", "redactParams"}
+
+// queryLogLevels are the accepted values of logLevel, from the quietest
+var queryLogLevels = []string{"silent", "error", "warn", "info"}
+
+// Query log settings of a Database service leaving a field out
+const (
+	defaultQueryLogLevel = "warn"
+	defaultSlowQuery     = "200ms"
+	defaultRedactParams  = "true"
+)
+
+// queryLineComment matches the source-map comments of the transpiler: // gmx:12
+var queryLineComment = regexp.MustCompile(`^\s*// gmx:(\d+)$`)
+
+// queryFuncDecl matches the declaration line of a top-level function
+var queryFuncDecl = regexp.MustCompile(`^func (\w+)\(`)
+
+// hasQueryLog checks if the Database service configures the query log
+func (g *Generator) hasQueryLog(file *ast.GMXFile) bool {
+	if len(file.Models) == 0 {
+		return false
+	}
+	svc := g.findDatabaseService(file.Services)
+	if svc == nil {
+		return false
+	}
+	for _, name := range queryLogFields {
+		if findServiceField(svc, name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// gormConfig returns the GORM configuration the generated code opens the
+// database with
+func (g *Generator) gormConfig(file *ast.GMXFile) string {
+	if g.hasQueryLog(file) {
+		return "&gorm.Config{Logger: queryLog}"
+	}
+	return "&gorm.Config{}"
+}
+
+// validateQueryLog checks the type and the default values of the query log
+// fields, which the server otherwise rejects at startup
+func (g *Generator) validateQueryLog(file *ast.GMXFile) error {
+	svc := g.findDatabaseService(file.Services)
+	if svc == nil {
+		return nil
+	}
+	for _, name := range queryLogFields {
+		field := findServiceField(svc, name)
+		if field == nil {
+			continue
+		}
+		if field.Type != "string" {
+			return fmt.Errorf("service %s: field %s must be a string", svc.Name, name)
+		}
+		def := serviceFieldDefault(field)
+		if def == nil {
+			continue
+		}
+		if err := checkQueryLogValue(name, *def); err != nil {
+			return fmt.Errorf("service %s: %w", svc.Name, err)
+		}
+	}
+	return nil
+}
+
+// checkQueryLogValue checks a value of a query log field
+func checkQueryLogValue(name, value string) error {
+	switch name {
+	case "logLevel":
+		for _, level := range queryLogLevels {
+			if value == level {
+				return nil
+			}
+		}
+		return fmt.Errorf("invalid log level %q, expected one of %s", value, strings.Join(queryLogLevels, ", "))
+	case "slowQuery":
+		if d, err := time.ParseDuration(value); err != nil || d < 0 {
+			return fmt.Errorf("invalid slow query threshold %q, expected a duration such as 200ms", value)
+		}
+	case "redactParams":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("invalid redactParams %q, expected true or false", value)
+		}
+	}
+	return nil
+}
+
+// genQueryLog generates the GORM logger of the Database service: failed
+// queries, queries slower than the threshold and, at the info level, every
+// query are logged with the .gmx line of the script statement running them
+func (g *Generator) genQueryLog(file *ast.GMXFile) string {
+	var b strings.Builder
+
+	svc := g.findDatabaseService(file.Services)
+	source := "gmx"
+	if g.opts.Source != "" {
+		source = filepath.Base(g.opts.Source)
+	}
+
+	b.WriteString("// queryLogger logs the queries of the database as sql: lines with key=value fields\n")
+	b.WriteString("type queryLogger struct {\n")
+	b.WriteString("\tlevel  gormlogger.LogLevel\n")
+	b.WriteString("\tslow   time.Duration\n")
+	b.WriteString("\tredact bool\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// queryLog is the logger the database is opened with\n")
+	b.WriteString("var queryLog *queryLogger\n\n")
+
+	b.WriteString(fmt.Sprintf("// newQueryLogger builds the query logger configured by the %s service\n", svc.Name))
+	b.WriteString(fmt.Sprintf("func newQueryLogger(cfg *%sConfig) *queryLogger {\n", svc.Name))
+	level, slow, redact := strconv.Quote(defaultQueryLogLevel), strconv.Quote(defaultSlowQuery), strconv.Quote(defaultRedactParams)
+	if findServiceField(svc, "logLevel") != nil {
+		level = "cfg.LogLevel"
+	}
+	if findServiceField(svc, "slowQuery") != nil {
+		slow = "cfg.SlowQuery"
+	}
+	if findServiceField(svc, "redactParams") != nil {
+		redact = "cfg.RedactParams"
+	}
+	b.WriteString("\tl := &queryLogger{}\n")
+	b.WriteString(fmt.Sprintf("\tswitch %s {\n", level))
+	b.WriteString("\tcase \"silent\":\n")
+	b.WriteString("\t\tl.level = gormlogger.Silent\n")
+	b.WriteString("\tcase \"error\":\n")
+	b.WriteString("\t\tl.level = gormlogger.Error\n")
+	b.WriteString("\tcase \"warn\", \"\":\n")
+	b.WriteString("\t\tl.level = gormlogger.Warn\n")
+	b.WriteString("\tcase \"info\":\n")
+	b.WriteString("\t\tl.level = gormlogger.Info\n")
+	b.WriteString("\tdefault:\n")
+	b.WriteString(fmt.Sprintf("\t\tlog.Fatalf(\"invalid query log level %%q: expected one of %s\", %s)\n", strings.Join(queryLogLevels, ", "), level))
+	b.WriteString("\t}\n")
+	b.WriteString("\tvar err error\n")
+	b.WriteString(fmt.Sprintf("\tif l.slow, err = time.ParseDuration(%s); err != nil || l.slow < 0 {\n", slow))
+	b.WriteString(fmt.Sprintf("\t\tlog.Fatalf(\"invalid slow query threshold %%q: expected a duration such as 200ms\", %s)\n", slow))
+	b.WriteString("\t}\n")
+	b.WriteString(fmt.Sprintf("\tif l.redact, err = strconv.ParseBool(%s); err != nil {\n", redact))
+	b.WriteString(fmt.Sprintf("\t\tlog.Fatalf(\"invalid query parameter redaction %%q: expected true or false\", %s)\n", redact))
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn l\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// LogMode returns a copy of the logger at the given level\n")
+	b.WriteString("func (l *queryLogger) LogMode(level gormlogger.LogLevel) gormlogger.Interface {\n")
+	b.WriteString("\tc := *l\n")
+	b.WriteString("\tc.level = level\n")
+	b.WriteString("\treturn &c\n")
+	b.WriteString("}\n\n")
+
+	for _, level := range []string{"Info", "Warn", "Error"} {
+		b.WriteString(fmt.Sprintf("// %s logs a message of GORM from the %s level\n", level, strings.ToLower(level)))
+		b.WriteString(fmt.Sprintf("func (l *queryLogger) %s(ctx context.Context, msg string, data ...interface{}) {\n", level))
+		b.WriteString(fmt.Sprintf("\tif l.level >= gormlogger.%s {\n", level))
+		b.WriteString(fmt.Sprintf("\t\tcontextLogger(ctx).%s(\"sql: \"+fmt.Sprintf(msg, data...))\n", level))
+		b.WriteString("\t}\n")
+		b.WriteString("}\n\n")
+	}
+
+	b.WriteString("// Trace logs a query once it ran: failed from the error level, slow from\n")
+	b.WriteString("// the warn level, and every query at the info level\n")
+	b.WriteString("func (l *queryLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {\n")
+	b.WriteString("\tif l.level <= gormlogger.Silent {\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\telapsed := time.Since(begin)\n")
+	b.WriteString("\tswitch {\n")
+	b.WriteString("\tcase err != nil && l.level >= gormlogger.Error && !errors.Is(err, gorm.ErrRecordNotFound):\n")
+	b.WriteString("\t\tsql, rows := fc()\n")
+	b.WriteString("\t\tcontextLogger(ctx).Error(\"sql: query failed\", \"source\", querySource(), \"duration\", elapsed.Round(time.Microsecond), \"rows\", rows, \"query\", sql, \"error\", err)\n")
+	b.WriteString("\tcase l.slow > 0 && elapsed > l.slow && l.level >= gormlogger.Warn:\n")
+	b.WriteString("\t\tsql, rows := fc()\n")
+	b.WriteString("\t\tcontextLogger(ctx).Warn(\"sql: slow query\", \"source\", querySource(), \"duration\", elapsed.Round(time.Microsecond), \"threshold\", l.slow, \"rows\", rows, \"query\", sql)\n")
+	b.WriteString("\tcase l.level >= gormlogger.Info:\n")
+	b.WriteString("\t\tsql, rows := fc()\n")
+	b.WriteString("\t\tcontextLogger(ctx).Info(\"sql: query\", \"source\", querySource(), \"duration\", elapsed.Round(time.Microsecond), \"rows\", rows, \"query\", sql)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// ParamsFilter leaves the query parameters out of the log when they are redacted\n")
+	b.WriteString("func (l *queryLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {\n")
+	b.WriteString("\tif l.redact {\n")
+	b.WriteString("\t\treturn sql, nil\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn sql, params\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// querySourceFile is the generated file, whose script functions querySourceLines maps\n")
+	b.WriteString("var _, querySourceFile, _, _ = runtime.Caller(0)\n\n")
+
+	b.WriteString("// querySource returns the .gmx line of the script statement running a query,\n")
+	b.WriteString("// found in the call stack, or - for the queries of the generated code\n")
+	b.WriteString("func querySource() string {\n")
+	b.WriteString("\tpcs := make([]uintptr, 32)\n")
+	b.WriteString("\tframes := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])\n")
+	b.WriteString("\tfor {\n")
+	b.WriteString("\t\tframe, more := frames.Next()\n")
+	b.WriteString("\t\tif frame.File == querySourceFile {\n")
+	b.WriteString("\t\t\ti := sort.Search(len(querySourceLines), func(i int) bool { return querySourceLines[i][0] > frame.Line }) - 1\n")
+	b.WriteString("\t\t\tif i >= 0 && querySourceLines[i][1] > 0 {\n")
+	b.WriteString(fmt.Sprintf("\t\t\t\treturn fmt.Sprintf(\"%s:%%d\", querySourceLines[i][1])\n", source))
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tif !more {\n")
+	b.WriteString("\t\t\treturn \"-\"\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// genQuerySourceLines generates the source map of the query log from the
+// formatted code: the line of each // gmx:N comment of the script functions,
+// and the closing line of these functions, mapped to 0. Appended to the end
+// of the code, it leaves the lines it maps unchanged.
+func (g *Generator) genQuerySourceLines(file *ast.GMXFile, code string) string {
+	funcs := make(map[string]bool)
+	if file.Script != nil {
+		for _, fn := range file.Script.Funcs {
+			funcs[fn.Name] = true
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("\n// querySourceLines maps the lines of the script functions to their .gmx line,\n")
+	b.WriteString("// from the // gmx:N comments; a function ends at a line mapped to 0\n")
+	b.WriteString("var querySourceLines = [][2]int{\n")
+	inFunc := false
+	for i, line := range strings.Split(code, "\n") {
+		if m := queryFuncDecl.FindStringSubmatch(line); m != nil {
+			inFunc = funcs[m[1]]
+		}
+		if !inFunc {
+			continue
+		}
+		if m := queryLineComment.FindStringSubmatch(line); m != nil {
+			b.WriteString(fmt.Sprintf("\t{%d, %s},\n", i+1, m[1]))
+		} else if line == "}" {
+			b.WriteString(fmt.Sprintf("\t{%d, 0},\n", i+1))
+			inFunc = false
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// genQueryLogStart returns the statement of main building the query logger,
+// before the database is opened
+func (g *Generator) genQueryLogStart(file *ast.GMXFile) string {
+	svc := g.findDatabaseService(file.Services)
+	return fmt.Sprintf("\tqueryLog = newQueryLogger(%sCfg)\n", utils.LowerFirst(svc.Name))
+}
@@ -0,0 +1,101 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const queryLogScript = `model Task {
+  id: uuid @pk @default(uuid_v4)
+  title: string
+}
+service Database {
+  provider: "sqlite"
+  url: string @env("DATABASE_URL")
+  slowQuery: string @env("DB_SLOW_QUERY") @default("500ms")
+}
+func createTask(title: string) error {
+  const task = Task{title: title}
+  try task.save()
+  return render(task)
+}`
+
+func TestGenerator_QueryLog(t *testing.T) {
+	file := strictFile(t, queryLogScript, "<form hx-post=\"{{route `createTask`}}\"></form>")
+	code, err := NewWithOptions(Options{Source: "app/tasks.gmx"}).Generate(file)
+	if err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, want := range []string{
+		"\tqueryLog = newQueryLogger(databaseCfg)\n",
+		"db, err = gorm.Open(sqlite.Open(databaseCfg.Url), &gorm.Config{Logger: queryLog})",
+		`if l.slow, err = time.ParseDuration(cfg.SlowQuery); err != nil || l.slow < 0 {`,
+		`if l.redact, err = strconv.ParseBool("true"); err != nil {`,
+		`switch "warn" {`,
+		`return fmt.Sprintf("tasks.gmx:%d", querySourceLines[i][1])`,
+		`gormlogger "gorm.io/gorm/logger"`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in generated code", want)
+		}
+	}
+
+	// Each mapped line is the // gmx:N comment of the statement below it
+	lines := strings.Split(code, "\n")
+	for _, gmxLine := range []string{"11", "12", "13"} {
+		comment := "\t// gmx:" + gmxLine
+		goLine := 0
+		for i, line := range lines {
+			if line == comment {
+				goLine = i + 1
+			}
+		}
+		if goLine == 0 {
+			t.Fatalf("expected %q in generated code", comment)
+		}
+		if want := fmt.Sprintf("\t{%d, %s},\n", goLine, gmxLine); !strings.Contains(code, want) {
+			t.Errorf("expected %q in querySourceLines", want)
+		}
+	}
+	if !strings.HasSuffix(code, ", 0},\n}\n") {
+		t.Errorf("expected querySourceLines to end the code with the end of createTask:\n%s", code[strings.LastIndex(code, "var querySourceLines"):])
+	}
+
+	// Without query log fields, GORM keeps its default logger
+	file = strictFile(t, strings.Replace(queryLogScript, "  slowQuery: string @env(\"DB_SLOW_QUERY\") @default(\"500ms\")\n", "", 1), "")
+	code, err = New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	if strings.Contains(code, "queryLog") || !strings.Contains(code, "&gorm.Config{})") {
+		t.Error("expected no query log without query log fields")
+	}
+}
+
+func TestGenerator_QueryLogErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		field   string
+		wantErr string
+	}{
+		{"log level", `logLevel: string @default("debug")`, `service Database: invalid log level "debug", expected one of silent, error, warn, info`},
+		{"slow query", `slowQuery: string @default("fast")`, `service Database: invalid slow query threshold "fast"`},
+		{"redact params", `redactParams: string @default("maybe")`, `service Database: invalid redactParams "maybe", expected true or false`},
+		{"field type", `slowQuery: int`, "service Database: field slowQuery must be a string"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := strings.Replace(queryLogScript, `slowQuery: string @env("DB_SLOW_QUERY") @default("500ms")`, tt.field, 1)
+			_, err := New().Generate(strictFile(t, src, ""))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
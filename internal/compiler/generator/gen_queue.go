@@ -0,0 +1,691 @@
+package generator
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// Workers and queue size of the @job functions without a "jobs" service
+const (
+	defaultJobWorkers = 4
+	defaultJobQueue   = 100
+)
+
+// jobQueueFields lists the config fields a "jobs" service may declare
+var jobQueueFields = []string{"workers", "queue", "overflow"}
+
+// jobOverflowPolicies are what enqueue does with a run finding the queue
+// full: fail, wait for room, drop the run, or store it in the database
+var jobOverflowPolicies = []string{"reject", "block", "drop", "persist"}
+
+// storedJobModel is the generated model storing the runs of the @job
+// functions kept in the database: all of them with the "db" provider, those
+// finding the queue full with the persist policy
+const storedJobModel = "BackgroundJob"
+
+// queuedJobExclusive lists the handler annotations which do not apply to
+// @job functions, run in the background rather than served
+var queuedJobExclusive = []string{"async", "timeout", "negotiate", "json", "signed", "autosave", "captcha", "honeypot", "once", "auth", "role", "require2fa", "login"}
+
+// builtinOverrides lists the builtins a script function replaces by taking
+// their name, called by the script only
+var builtinOverrides = []string{"notify", "publish"}
+
+// isHandler reports whether a script function is served as an HTTP handler:
+// functions returning error are, except the @job functions run by the job
+// queue, the @schedule functions run by the scheduler and the overrides of
+// builtins; other return types are utilities
+func isHandler(fn *ast.FuncDecl) bool {
+	return (fn.ReturnType == "" || fn.ReturnType == "error") && fn.FindAnnotation("job") == nil && fn.FindAnnotation("schedule") == nil && !slices.Contains(builtinOverrides, fn.Name)
+}
+
+// hasQueuedJobs checks if jobs run on the job queue: script functions with
+// @job, and the resizing of image fields
+func (g *Generator) hasQueuedJobs(file *ast.GMXFile) bool {
+	return g.hasFuncAnnotation(file, "job") || g.hasImages(file)
+}
+
+// findJobQueueService returns the service configuring the job queue, using
+// the "jobs" provider for the in-process queue or "db" for the database one
+func (g *Generator) findJobQueueService(services []*ast.ServiceDecl) *ast.ServiceDecl {
+	for _, svc := range services {
+		if svc.Provider == "jobs" || svc.Provider == "db" {
+			return svc
+		}
+	}
+	return nil
+}
+
+// hasDBJobQueue checks if the @job functions run from the database, with a
+// service using the "db" provider
+func (g *Generator) hasDBJobQueue(file *ast.GMXFile) bool {
+	svc := g.findJobQueueService(file.Services)
+	return g.hasQueuedJobs(file) && svc != nil && svc.Provider == "db"
+}
+
+// jobOverflow returns the overflow policy of a "jobs" service, chosen at
+// build time by its overflow field: overflow: string @default("block")
+func jobOverflow(svc *ast.ServiceDecl) string {
+	if field := findServiceField(svc, "overflow"); field != nil {
+		if def := serviceFieldDefault(field); def != nil {
+			return *def
+		}
+	}
+	return "reject"
+}
+
+// jobOverflowOf returns the overflow policy of the in-process job queue of a
+// file, or "" when the jobs run from the database
+func (g *Generator) jobOverflowOf(file *ast.GMXFile) string {
+	if svc := g.findJobQueueService(file.Services); svc != nil {
+		if svc.Provider == "db" {
+			return ""
+		}
+		return jobOverflow(svc)
+	}
+	return "reject"
+}
+
+// hasStoredJobs checks if runs of the @job functions are stored in the
+// database: the "db" provider stores them all, the persist policy those
+// finding the queue full
+func (g *Generator) hasStoredJobs(file *ast.GMXFile) bool {
+	return g.hasDBJobQueue(file) || g.hasQueuedJobs(file) && g.jobOverflowOf(file) == "persist"
+}
+
+// validateQueuedJobs checks that the @job functions can run in the background,
+// and that the "jobs" service only sets the workers, queue size and overflow
+// policy
+func (g *Generator) validateQueuedJobs(file *ast.GMXFile) error {
+	var queues []string
+	for _, svc := range file.Services {
+		if svc.Provider == "jobs" || svc.Provider == "db" {
+			queues = append(queues, svc.Name)
+		}
+	}
+	if len(queues) > 1 {
+		return fmt.Errorf("services %s: the @job functions run on a single queue; declare one service with provider \"jobs\" or \"db\"", strings.Join(queues, ", "))
+	}
+	if svc := g.findJobQueueService(file.Services); svc != nil && svc.Provider == "db" {
+		if err := validateDBJobQueue(svc); err != nil {
+			return err
+		}
+	} else if svc != nil {
+		for _, field := range svc.Fields {
+			if !slices.Contains(jobQueueFields, field.Name) {
+				return fmt.Errorf("service %s: the jobs provider takes workers, queue and overflow fields, not %s", svc.Name, field.Name)
+			}
+			if field.Type != "string" {
+				return fmt.Errorf("service %s: field %s of the jobs provider must be a string", svc.Name, field.Name)
+			}
+		}
+		if field := findServiceField(svc, "overflow"); field != nil && (field.EnvVar != "" || serviceFieldDefault(field) == nil) {
+			return fmt.Errorf("service %s: the overflow policy is chosen at build time: declare `overflow: string @default(\"block\")` without @env", svc.Name)
+		}
+		if policy := jobOverflow(svc); !slices.Contains(jobOverflowPolicies, policy) {
+			return fmt.Errorf("service %s: unknown overflow policy %q (expected %s)", svc.Name, policy, strings.Join(jobOverflowPolicies, ", "))
+		}
+		if len(svc.Methods) > 0 {
+			return fmt.Errorf("service %s: the jobs provider takes no methods; mark the functions to run with @job", svc.Name)
+		}
+	}
+
+	if g.hasStoredJobs(file) {
+		for _, model := range file.Models {
+			if model.Name == storedJobModel {
+				return fmt.Errorf("line %d: model %s collides with the model storing the queued jobs; rename it", model.Line, model.Name)
+			}
+		}
+	}
+
+	funcs := g.funcsWithAnnotation(file, "job")
+	if len(funcs) == 0 {
+		return nil
+	}
+	routes := make(map[string]string)
+	if file.Template != nil {
+		routes = g.genRouteRegistry(file.Template.Source)
+	}
+	for _, fn := range funcs {
+		if len(fn.FindAnnotation("job").Args) > 0 {
+			return fmt.Errorf("function %s: @job takes no arguments; set the workers in a service with provider \"jobs\"", fn.Name)
+		}
+		if fn.ReturnType != "" && fn.ReturnType != "error" {
+			return fmt.Errorf("function %s: @job applies to functions returning error", fn.Name)
+		}
+		for _, name := range queuedJobExclusive {
+			if fn.FindAnnotation(name) != nil {
+				return fmt.Errorf("function %s: @job functions run in the background, not as handlers; remove @%s", fn.Name, name)
+			}
+		}
+		if _, ok := routes[fn.Name]; ok {
+			return fmt.Errorf("function %s: @job functions have no route; queue them with enqueue %s(...) instead of {{route `%s`}}", fn.Name, fn.Name, fn.Name)
+		}
+	}
+
+	// Queued jobs run on the shared pool once the request and its pinned connection are gone
+	if g.hasRowLevelSecurity(file) {
+		return fmt.Errorf("function %s: @job does not apply with row-level security, whose connection ends with the request", funcs[0].Name)
+	}
+	return nil
+}
+
+// withStoredJobModel returns the file with the model storing the runs of the
+// @job functions kept in the database, so that it is declared and migrated
+// like the others
+func (g *Generator) withStoredJobModel(file *ast.GMXFile) *ast.GMXFile {
+	if !g.hasStoredJobs(file) {
+		return file
+	}
+	withModel := *file
+	withModel.Models = append(append([]*ast.ModelDecl{}, file.Models...), &ast.ModelDecl{
+		Name: storedJobModel,
+		Fields: []*ast.FieldDecl{
+			{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{
+				{Name: "pk", Args: map[string]string{}},
+				{Name: "default", Args: map[string]string{"_": "uuid_v4"}},
+			}},
+			{Name: "name", Type: "string"},
+			{Name: "args", Type: "string"},
+			{Name: "user", Type: "string"},
+			{Name: "tenant", Type: "string"},
+			{Name: "status", Type: "string"},
+			{Name: "attempts", Type: "int"},
+			{Name: "lastError", Type: "string"},
+			{Name: "scheduledAt", Type: "datetime", Annotations: []*ast.Annotation{
+				{Name: "index", Args: map[string]string{}},
+			}},
+			{Name: "createdAt", Type: "datetime"},
+		},
+	})
+	return &withModel
+}
+
+// genJobQueueStart returns the statement of main starting the job workers,
+// configured by the "jobs" service if any
+func (g *Generator) genJobQueueStart(file *ast.GMXFile) string {
+	if svc := g.findJobQueueService(file.Services); svc != nil {
+		return fmt.Sprintf("\tstartJobWorkers(jobQueueConfig(%sCfg))\n", utils.LowerFirst(svc.Name))
+	}
+	return fmt.Sprintf("\tstartJobWorkers(%d, %d)\n", defaultJobWorkers, defaultJobQueue)
+}
+
+// genJobQueue generates the queue of the @job functions: in process, a
+// buffered channel read by a pool of workers, which the shutdown drains; with
+// the "db" provider, a table polled by the workers
+func (g *Generator) genJobQueue(file *ast.GMXFile) string {
+	var b strings.Builder
+	dbQueue := g.hasDBJobQueue(file)
+
+	if svc := g.findJobQueueService(file.Services); svc != nil && dbQueue {
+		b.WriteString(genDBJobQueueConfig(svc))
+	} else if svc != nil {
+		b.WriteString(fmt.Sprintf("// jobQueueConfig reads the workers and queue size of the %s service\n", svc.Name))
+		b.WriteString(fmt.Sprintf("func jobQueueConfig(cfg *%sConfig) (workers, size int) {\n", svc.Name))
+		b.WriteString(fmt.Sprintf("\tworkers, size = %d, %d\n", defaultJobWorkers, defaultJobQueue))
+		if findServiceField(svc, "workers") != nil {
+			b.WriteString("\tif cfg.Workers != \"\" {\n")
+			b.WriteString("\t\tn, err := strconv.Atoi(cfg.Workers)\n")
+			b.WriteString("\t\tif err != nil || n < 1 {\n")
+			b.WriteString("\t\t\tlog.Fatalf(\"invalid job workers %q: expected a positive count\", cfg.Workers)\n")
+			b.WriteString("\t\t}\n")
+			b.WriteString("\t\tworkers = n\n")
+			b.WriteString("\t}\n")
+		}
+		if findServiceField(svc, "queue") != nil {
+			b.WriteString("\tif cfg.Queue != \"\" {\n")
+			b.WriteString("\t\tn, err := strconv.Atoi(cfg.Queue)\n")
+			b.WriteString("\t\tif err != nil || n < 0 {\n")
+			b.WriteString("\t\t\tlog.Fatalf(\"invalid job queue size %q: expected a count\", cfg.Queue)\n")
+			b.WriteString("\t\t}\n")
+			b.WriteString("\t\tsize = n\n")
+			b.WriteString("\t}\n")
+		}
+		b.WriteString("\treturn workers, size\n")
+		b.WriteString("}\n\n")
+	}
+
+	overflow := g.jobOverflowOf(file)
+	metrics := g.hasMetrics(file)
+
+	b.WriteString("// queuedJob is a run of a @job function waiting for a worker\n")
+	b.WriteString("type queuedJob struct {\n")
+	b.WriteString("\tname   string\n")
+	b.WriteString("\tctx    *GMXContext\n")
+	b.WriteString("\tqueued time.Time\n")
+	b.WriteString("\trun    func(ctx *GMXContext) error\n")
+	b.WriteString("}\n\n")
+
+	if dbQueue {
+		b.WriteString(genDBJobQueueState())
+	} else {
+		b.WriteString("// jobQueue holds the queued runs of the @job functions; it is closed on\n")
+		b.WriteString("// shutdown, once the server stopped answering requests\n")
+		b.WriteString("var jobQueue struct {\n")
+		b.WriteString("\tsync.RWMutex\n")
+		b.WriteString("\tjobs     chan queuedJob\n")
+		b.WriteString("\tclosed   bool\n")
+		if overflow == "block" {
+			b.WriteString("\tstopping chan struct{} // closed first on shutdown, waking the runs waiting for room\n")
+		}
+		b.WriteString("\tworkers  sync.WaitGroup\n")
+		b.WriteString("}\n\n")
+	}
+
+	b.WriteString("// jobQueueCtx is the context of the queued jobs, cancelled at the shutdown\n")
+	b.WriteString("// deadline so that ctx.cancelled() lets them stop early\n")
+	b.WriteString("var jobQueueCtx, cancelJobQueue = context.WithCancel(context.Background())\n\n")
+
+	b.WriteString("// queuedResponse is the writer of a queued job, which runs once its request\n")
+	b.WriteString("// has been answered: what the function renders is dropped\n")
+	b.WriteString("type queuedResponse struct {\n")
+	b.WriteString("\theader http.Header\n")
+	b.WriteString("}\n\n")
+	b.WriteString("func (r queuedResponse) Header() http.Header       { return r.header }\n")
+	b.WriteString("func (queuedResponse) Write(p []byte) (int, error) { return len(p), nil }\n")
+	b.WriteString("func (queuedResponse) WriteHeader(int)             {}\n\n")
+
+	if metrics {
+		b.WriteString(genJobQueueMetricsState(overflow))
+	}
+
+	if dbQueue {
+		b.WriteString(g.genDBJobWorkers(file))
+	} else {
+		b.WriteString("// startJobWorkers starts the workers running the queued jobs, which wait\n")
+		b.WriteString("// in a queue of size runs\n")
+		b.WriteString("func startJobWorkers(workers, size int) {\n")
+		b.WriteString("\tjobQueue.jobs = make(chan queuedJob, size)\n")
+		if overflow == "block" {
+			b.WriteString("\tjobQueue.stopping = make(chan struct{})\n")
+		}
+		if metrics {
+			b.WriteString("\tmetricsMu.Lock()\n")
+			b.WriteString("\tjobWorkers = workers\n")
+			b.WriteString("\tmetricsMu.Unlock()\n")
+		}
+		b.WriteString("\tfor i := 0; i < workers; i++ {\n")
+		b.WriteString("\t\tjobQueue.workers.Add(1)\n")
+		b.WriteString("\t\tgo func() {\n")
+		b.WriteString("\t\t\tdefer jobQueue.workers.Done()\n")
+		b.WriteString("\t\t\tfor job := range jobQueue.jobs {\n")
+		b.WriteString("\t\t\t\trunQueuedJob(job)\n")
+		b.WriteString("\t\t\t}\n")
+		b.WriteString("\t\t}()\n")
+		b.WriteString("\t}\n")
+		if overflow == "persist" {
+			b.WriteString("\tgo refillJobQueue()\n")
+		}
+		b.WriteString("}\n\n")
+
+		b.WriteString("// enqueueJob queues a run of the @job function name, with a copy of the\n")
+		b.WriteString("// context of the caller; a server shutting down is an error, and " + jobOverflowDoc[overflow] + "\n")
+		b.WriteString("func enqueueJob(ctx *GMXContext, name string, args []any, run func(ctx *GMXContext) error) error {\n")
+		b.WriteString("\t// The job outlives the request\n")
+		b.WriteString("\tjobCtx := *ctx\n")
+		if g.hasJobs(file) {
+			b.WriteString("\tjobCtx.Job = nil\n")
+		}
+		b.WriteString("\tjobCtx.Writer = queuedResponse{header: http.Header{}}\n")
+		b.WriteString("\tjobCtx.Request = ctx.Request.WithContext(jobQueueCtx)\n")
+		b.WriteString("\tjob := queuedJob{name: name, ctx: &jobCtx, queued: time.Now(), run: run}\n")
+		b.WriteString("\n")
+		b.WriteString("\tjobQueue.RLock()\n")
+		b.WriteString("\tdefer jobQueue.RUnlock()\n")
+		b.WriteString("\tif jobQueue.closed {\n")
+		b.WriteString("\t\treturn fmt.Errorf(\"enqueue %s: the server is shutting down\", name)\n")
+		b.WriteString("\t}\n")
+		b.WriteString("\tselect {\n")
+		b.WriteString("\tcase jobQueue.jobs <- job:\n")
+		b.WriteString("\t\treturn nil\n")
+		switch overflow {
+		case "block":
+			b.WriteString("\tcase <-ctx.Request.Context().Done():\n")
+			b.WriteString("\t\treturn fmt.Errorf(\"enqueue %s: the job queue stayed full: %w\", name, ctx.Request.Context().Err())\n")
+			b.WriteString("\tcase <-jobQueue.stopping:\n")
+			b.WriteString("\t\treturn fmt.Errorf(\"enqueue %s: the server is shutting down\", name)\n")
+		case "drop":
+			b.WriteString("\tdefault:\n")
+			if metrics {
+				b.WriteString("\t\tmetricsMu.Lock()\n")
+				b.WriteString("\t\tjobsDropped[name]++\n")
+				b.WriteString("\t\tmetricsMu.Unlock()\n")
+			}
+			b.WriteString("\t\tctx.Log.Warn(\"job queue full: dropped a run\", \"job\", name)\n")
+			b.WriteString("\t\treturn nil\n")
+		case "persist":
+			b.WriteString("\tdefault:\n")
+			b.WriteString("\t\treturn storeJob(ctx, name, args)\n")
+		default:
+			b.WriteString("\tdefault:\n")
+			b.WriteString("\t\treturn fmt.Errorf(\"enqueue %s: the job queue is full\", name)\n")
+		}
+		b.WriteString("\t}\n")
+		b.WriteString("}\n\n")
+	}
+
+	b.WriteString("// decodeJobArgs decodes the JSON-encoded arguments of a stored run of a @job\n")
+	b.WriteString("// function into dst\n")
+	b.WriteString("func decodeJobArgs(args []json.RawMessage, dst ...any) error {\n")
+	b.WriteString("\tif len(args) != len(dst) {\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"stored with %d argument(s), expected %d\", len(args), len(dst))\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tfor i, arg := range args {\n")
+	b.WriteString("\t\tif err := json.Unmarshal(arg, dst[i]); err != nil {\n")
+	b.WriteString("\t\t\treturn fmt.Errorf(\"argument %d: %w\", i+1, err)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn nil\n")
+	b.WriteString("}\n\n")
+
+	if g.hasStoredJobs(file) {
+		b.WriteString(g.genStoredJobs(file))
+	}
+	if overflow == "persist" {
+		b.WriteString(genStoredJobsRefill(g.genHoldDB(file, "\t")))
+	}
+
+	b.WriteString("// executeJob runs a job and returns its error; a panicking job fails alone\n")
+	b.WriteString("// instead of stopping its worker\n")
+	b.WriteString("func executeJob(job queuedJob) error {\n")
+	if metrics {
+		b.WriteString("\tstart := time.Now()\n")
+	}
+	if len(file.Models) > 0 {
+		b.WriteString("\t// The database is the one in use when the job runs, not when it was queued\n")
+		b.WriteString("\tjob.ctx.DB = db.WithContext(jobQueueCtx)\n")
+	}
+	b.WriteString("\terr := func() (err error) {\n")
+	b.WriteString("\t\tdefer func() {\n")
+	b.WriteString("\t\t\tif p := recover(); p != nil {\n")
+	b.WriteString("\t\t\t\terr = fmt.Errorf(\"panic: %v\", p)\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t}()\n")
+	b.WriteString("\t\treturn job.run(job.ctx)\n")
+	b.WriteString("\t}()\n")
+	if metrics {
+		b.WriteString("\tobserveJob(job.name, start.Sub(job.queued), time.Since(start))\n")
+	}
+	b.WriteString("\treturn err\n")
+	b.WriteString("}\n\n")
+
+	if !dbQueue {
+		b.WriteString("// runQueuedJob runs a queued job and logs its error\n")
+		b.WriteString("func runQueuedJob(job queuedJob) {\n")
+		b.WriteString(g.genHoldDB(file, "\t"))
+		b.WriteString("\tstart := time.Now()\n")
+		b.WriteString("\tif err := executeJob(job); err != nil {\n")
+		b.WriteString("\t\tslog.Error(\"job failed\", \"job\", job.name, \"duration\", time.Since(start).Round(time.Millisecond), \"error\", err)\n")
+		b.WriteString("\t}\n")
+		b.WriteString("}\n\n")
+	}
+
+	b.WriteString("// drainJobQueue stops queuing jobs and lets the workers run the queued ones\n")
+	b.WriteString("// until the shutdown deadline, when the running jobs are cancelled\n")
+	b.WriteString("func drainJobQueue(deadline context.Context) {\n")
+	if overflow == "block" {
+		b.WriteString("\tclose(jobQueue.stopping)\n")
+	}
+	b.WriteString("\tjobQueue.Lock()\n")
+	b.WriteString("\tjobQueue.closed = true\n")
+	if dbQueue {
+		b.WriteString("\tclose(jobQueue.stop)\n")
+	} else {
+		b.WriteString("\tclose(jobQueue.jobs)\n")
+	}
+	b.WriteString("\tjobQueue.Unlock()\n\n")
+	b.WriteString("\tdrained := make(chan struct{})\n")
+	b.WriteString("\tgo func() {\n")
+	b.WriteString("\t\tjobQueue.workers.Wait()\n")
+	b.WriteString("\t\tclose(drained)\n")
+	b.WriteString("\t}()\n")
+	b.WriteString("\tselect {\n")
+	b.WriteString("\tcase <-drained:\n")
+	b.WriteString("\tcase <-deadline.Done():\n")
+	b.WriteString("\t\tcancelJobQueue()\n")
+	if dbQueue {
+		b.WriteString("\t\tslog.Warn(\"jobs: shutdown deadline reached with jobs running; they are retried after a restart\")\n")
+	} else {
+		b.WriteString("\t\tslog.Warn(\"jobs: shutdown deadline reached with queued jobs not run\", \"jobs\", len(jobQueue.jobs))\n")
+	}
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// jobOverflowDoc completes the doc comment of enqueueJob with what each
+// overflow policy does with a run finding the queue full
+var jobOverflowDoc = map[string]string{
+	"reject":  "so is a full\n// queue",
+	"block":   "a full queue makes\n// the caller wait for room until its request ends",
+	"drop":    "a full queue drops\n// the run with a warning",
+	"persist": "a full queue stores\n// the run in the database, moved into the queue once it has room",
+}
+
+// genStoredJobs generates the storage of the runs kept in the database, and
+// their runners decoding the arguments
+func (g *Generator) genStoredJobs(file *ast.GMXFile) string {
+	var b strings.Builder
+
+	b.WriteString("// storedJobRunners run the stored runs of each @job function and image resizing\n")
+	b.WriteString("var storedJobRunners = map[string]func(ctx *GMXContext, args []json.RawMessage) error{\n")
+	for _, fn := range g.funcsWithAnnotation(file, "job") {
+		b.WriteString(fmt.Sprintf("\t%q: runStored%s,\n", fn.Name, utils.Capitalize(fn.Name)))
+	}
+	for _, model := range file.Models {
+		for _, field := range model.Fields {
+			if isImageField(field) {
+				name := imageResizeJob(model, field)
+				b.WriteString(fmt.Sprintf("\t%q: runStored%s,\n", name, utils.Capitalize(name)))
+			}
+		}
+	}
+	b.WriteString("}\n\n")
+
+	b.WriteString("// storeJob stores a run of the @job function name, with its arguments\n")
+	b.WriteString("// encoded as JSON\n")
+	b.WriteString("func storeJob(ctx *GMXContext, name string, args []any) error {\n")
+	b.WriteString("\tencoded, err := json.Marshal(args)\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"enqueue %s: encoding the arguments: %w\", name, err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString(fmt.Sprintf("\tstored := &%s{Name: name, Args: string(encoded), User: ctx.User, Tenant: ctx.Tenant, Status: \"queued\", ScheduledAt: time.Now()}\n", storedJobModel))
+	b.WriteString("\tif err := ctx.DB.Create(stored).Error; err != nil {\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"enqueue %s: storing the run: %w\", name, err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn nil\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// storedQueuedJob rebuilds a stored run, with a fresh context carrying its\n")
+	b.WriteString("// user and tenant\n")
+	b.WriteString(fmt.Sprintf("func storedQueuedJob(stored %s) (queuedJob, error) {\n", storedJobModel))
+	b.WriteString("\trunner, ok := storedJobRunners[stored.Name]\n")
+	b.WriteString("\tif !ok {\n")
+	b.WriteString("\t\treturn queuedJob{}, fmt.Errorf(\"%s is not a @job function\", stored.Name)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tvar args []json.RawMessage\n")
+	b.WriteString("\tif err := json.Unmarshal([]byte(stored.Args), &args); err != nil {\n")
+	b.WriteString("\t\treturn queuedJob{}, fmt.Errorf(\"decoding the arguments of %s: %w\", stored.Name, err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treq, err := http.NewRequestWithContext(jobQueueCtx, http.MethodGet, \"/\", nil)\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\treturn queuedJob{}, err\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tctx := &GMXContext{\n")
+	b.WriteString("\t\tTenant:  stored.Tenant,\n")
+	b.WriteString("\t\tUser:    stored.User,\n")
+	b.WriteString("\t\tWriter:  queuedResponse{header: http.Header{}},\n")
+	b.WriteString("\t\tRequest: req,\n")
+	b.WriteString("\t\tLog:     slog.With(\"job\", stored.Name),\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn queuedJob{name: stored.Name, ctx: ctx, queued: stored.ScheduledAt, run: func(ctx *GMXContext) error {\n")
+	b.WriteString("\t\treturn runner(ctx, args)\n")
+	b.WriteString("\t}}, nil\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// genStoredJobsRefill generates the refill of the queue of the persist
+// policy, which moves the stored runs back into it as it empties; hold holds
+// the database during a refill
+func genStoredJobsRefill(hold string) string {
+	var b strings.Builder
+
+	b.WriteString("// refillJobQueue moves the stored runs into the queue every second, until\n")
+	b.WriteString("// the queue is closed\n")
+	b.WriteString("func refillJobQueue() {\n")
+	b.WriteString("\tticker := time.NewTicker(time.Second)\n")
+	b.WriteString("\tdefer ticker.Stop()\n")
+	b.WriteString("\tfor range ticker.C {\n")
+	b.WriteString("\t\tif !refillStoredJobs() {\n")
+	b.WriteString("\t\t\treturn\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// refillStoredJobs moves the oldest stored runs into the room left in the\n")
+	b.WriteString("// queue, and reports false once it is closed; a run is claimed by deleting\n")
+	b.WriteString("// its row, so that it runs once when instances share the database\n")
+	b.WriteString("func refillStoredJobs() bool {\n")
+	b.WriteString(hold)
+	b.WriteString("\tjobQueue.RLock()\n")
+	b.WriteString("\tdefer jobQueue.RUnlock()\n")
+	b.WriteString("\tif jobQueue.closed {\n")
+	b.WriteString("\t\treturn false\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\troom := cap(jobQueue.jobs) - len(jobQueue.jobs)\n")
+	b.WriteString("\tif cap(jobQueue.jobs) == 0 {\n")
+	b.WriteString("\t\t// An unbuffered queue hands runs to idle workers only\n")
+	b.WriteString("\t\troom = 1\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif room == 0 {\n")
+	b.WriteString("\t\treturn true\n")
+	b.WriteString("\t}\n")
+	b.WriteString(fmt.Sprintf("\tvar stored []%s\n", storedJobModel))
+	b.WriteString("\tif err := db.WithContext(jobQueueCtx).Order(\"scheduled_at\").Limit(room).Find(&stored).Error; err != nil {\n")
+	b.WriteString("\t\tslog.Error(\"jobs: loading the stored runs\", \"error\", err)\n")
+	b.WriteString("\t\treturn true\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tfor _, row := range stored {\n")
+	b.WriteString(fmt.Sprintf("\t\tclaim := db.WithContext(jobQueueCtx).Where(\"id = ?\", row.ID).Delete(&%s{})\n", storedJobModel))
+	b.WriteString("\t\tif claim.Error != nil || claim.RowsAffected != 1 {\n")
+	b.WriteString("\t\t\tcontinue\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tjob, err := storedQueuedJob(row)\n")
+	b.WriteString("\t\tif err != nil {\n")
+	b.WriteString("\t\t\tslog.Error(\"jobs: dropped a stored run\", \"job\", row.Name, \"error\", err)\n")
+	b.WriteString("\t\t\tcontinue\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tselect {\n")
+	b.WriteString("\t\tcase jobQueue.jobs <- job:\n")
+	b.WriteString("\t\tdefault:\n")
+	b.WriteString("\t\t\t// Enqueued runs took the room meanwhile: the run waits for the next refill\n")
+	b.WriteString("\t\t\tif err := db.WithContext(jobQueueCtx).Create(&row).Error; err != nil {\n")
+	b.WriteString("\t\t\t\tslog.Error(\"jobs: dropped a stored run\", \"job\", row.Name, \"error\", err)\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t\treturn true\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn true\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// genJobQueueMetricsState generates the metrics of the job queue, guarded by
+// the mutex of the other metrics, and the function recording a run
+func genJobQueueMetricsState(overflow string) string {
+	var b strings.Builder
+
+	b.WriteString("// The metrics of the job queue, guarded by metricsMu\n")
+	b.WriteString("var (\n")
+	b.WriteString("\tjobWorkers  int\n")
+	b.WriteString("\tjobWait     = map[string]*metricsHistogram{} // job\n")
+	b.WriteString("\tjobDuration = map[string]*metricsHistogram{} // job\n")
+	if overflow == "drop" {
+		b.WriteString("\tjobsDropped = map[string]uint64{}            // job\n")
+	}
+	b.WriteString(")\n\n")
+
+	b.WriteString("// observeJob records the time a run waited in the queue and the time it ran\n")
+	b.WriteString("func observeJob(name string, wait, run time.Duration) {\n")
+	b.WriteString("\tmetricsMu.Lock()\n")
+	b.WriteString("\tdefer metricsMu.Unlock()\n")
+	b.WriteString("\tfor _, m := range []struct {\n")
+	b.WriteString("\t\thistograms map[string]*metricsHistogram\n")
+	b.WriteString("\t\tseconds    float64\n")
+	b.WriteString("\t}{{jobWait, wait.Seconds()}, {jobDuration, run.Seconds()}} {\n")
+	b.WriteString("\t\th := m.histograms[name]\n")
+	b.WriteString("\t\tif h == nil {\n")
+	b.WriteString("\t\t\th = &metricsHistogram{}\n")
+	b.WriteString("\t\t\tm.histograms[name] = h\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\th.observe(m.seconds)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// genJobQueueMetrics generates the part of handleMetrics writing the metrics
+// of the job queue, with the metrics mutex held
+func (g *Generator) genJobQueueMetrics(file *ast.GMXFile) string {
+	var b strings.Builder
+
+	b.WriteString("\tb.WriteString(\"# HELP gmx_job_workers Workers running the queued jobs.\\n\")\n")
+	b.WriteString("\tb.WriteString(\"# TYPE gmx_job_workers gauge\\n\")\n")
+	b.WriteString("\tfmt.Fprintf(&b, \"gmx_job_workers %d\\n\", jobWorkers)\n\n")
+	b.WriteString("\tb.WriteString(\"# HELP gmx_job_queue_depth Runs waiting in the job queue.\\n\")\n")
+	b.WriteString("\tb.WriteString(\"# TYPE gmx_job_queue_depth gauge\\n\")\n")
+	if g.hasDBJobQueue(file) {
+		b.WriteString("\tfmt.Fprintf(&b, \"gmx_job_queue_depth %d\\n\", queuedJobs)\n\n")
+		b.WriteString("\tb.WriteString(\"# HELP gmx_job_dead Runs kept as dead letters once out of attempts.\\n\")\n")
+		b.WriteString("\tb.WriteString(\"# TYPE gmx_job_dead gauge\\n\")\n")
+		b.WriteString("\tfmt.Fprintf(&b, \"gmx_job_dead %d\\n\", deadJobs)\n\n")
+	} else {
+		b.WriteString("\tfmt.Fprintf(&b, \"gmx_job_queue_depth %d\\n\", len(jobQueue.jobs))\n\n")
+	}
+	if g.jobOverflowOf(file) == "persist" {
+		b.WriteString("\tb.WriteString(\"# HELP gmx_job_queue_stored Runs stored in the database until the job queue has room.\\n\")\n")
+		b.WriteString("\tb.WriteString(\"# TYPE gmx_job_queue_stored gauge\\n\")\n")
+		b.WriteString("\tfmt.Fprintf(&b, \"gmx_job_queue_stored %d\\n\", storedJobs)\n\n")
+	}
+	b.WriteString("\tb.WriteString(\"# HELP gmx_job_wait_seconds Time runs waited in the queue, by job.\\n\")\n")
+	b.WriteString("\tb.WriteString(\"# TYPE gmx_job_wait_seconds histogram\\n\")\n")
+	b.WriteString("\tfor _, job := range sortedMetricsKeys(jobWait) {\n")
+	b.WriteString("\t\twriteMetricsHistogram(&b, \"gmx_job_wait_seconds\", fmt.Sprintf(\"job=%q\", job), jobWait[job])\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\tb.WriteString(\"# HELP gmx_job_duration_seconds Time to run jobs, by job.\\n\")\n")
+	b.WriteString("\tb.WriteString(\"# TYPE gmx_job_duration_seconds histogram\\n\")\n")
+	b.WriteString("\tfor _, job := range sortedMetricsKeys(jobDuration) {\n")
+	b.WriteString("\t\twriteMetricsHistogram(&b, \"gmx_job_duration_seconds\", fmt.Sprintf(\"job=%q\", job), jobDuration[job])\n")
+	b.WriteString("\t}\n")
+	if g.jobOverflowOf(file) == "drop" {
+		b.WriteString("\n")
+		b.WriteString("\tb.WriteString(\"# HELP gmx_jobs_dropped_total Runs dropped by a full job queue, by job.\\n\")\n")
+		b.WriteString("\tb.WriteString(\"# TYPE gmx_jobs_dropped_total counter\\n\")\n")
+		b.WriteString("\tfor _, job := range sortedMetricsKeys(jobsDropped) {\n")
+		b.WriteString("\t\tfmt.Fprintf(&b, \"gmx_jobs_dropped_total{job=%q} %d\\n\", job, jobsDropped[job])\n")
+		b.WriteString("\t}\n")
+	}
+
+	return b.String()
+}
+
+// genStoredJobCounts generates the counts of the runs stored in the database
+// which handleMetrics reports: those waiting for room in the queue with the
+// persist policy, the queued and dead ones with the "db" provider
+func (g *Generator) genStoredJobCounts(file *ast.GMXFile) string {
+	if g.hasDBJobQueue(file) {
+		return fmt.Sprintf("\tvar queuedJobs, deadJobs int64\n"+
+			"\tdb.Model(&%[1]s{}).Where(\"status = ?\", \"queued\").Count(&queuedJobs)\n"+
+			"\tdb.Model(&%[1]s{}).Where(\"status = ?\", \"dead\").Count(&deadJobs)\n", storedJobModel)
+	}
+	return fmt.Sprintf("\tvar storedJobs int64\n\tdb.Model(&%s{}).Count(&storedJobs)\n", storedJobModel)
+}
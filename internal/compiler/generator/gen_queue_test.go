@@ -0,0 +1,300 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+func queueFile(services ...*ast.ServiceDecl) *ast.GMXFile {
+	return &ast.GMXFile{
+		Models: []*ast.ModelDecl{
+			{Name: "User", Fields: []*ast.FieldDecl{
+				{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{{Name: "pk"}}},
+				{Name: "email", Type: "string"},
+			}},
+		},
+		Services: services,
+		Script: &ast.ScriptBlock{Funcs: []*ast.FuncDecl{
+			{
+				Name:        "sendWelcomeEmail",
+				Params:      []*ast.Param{{Name: "id", Type: "uuid"}},
+				ReturnType:  "error",
+				Annotations: []*ast.Annotation{{Name: "job"}},
+				Body:        []ast.Statement{&ast.ReturnStmt{Value: &ast.Ident{Name: "nil"}}},
+			},
+			{
+				Name:       "signUp",
+				Params:     []*ast.Param{{Name: "id", Type: "uuid"}},
+				ReturnType: "error",
+				Body: []ast.Statement{
+					&ast.EnqueueStmt{Call: &ast.CallExpr{Function: &ast.Ident{Name: "sendWelcomeEmail"}, Args: []ast.Expression{&ast.Ident{Name: "id"}}}},
+					&ast.ReturnStmt{Value: &ast.Ident{Name: "nil"}},
+				},
+			},
+		}},
+		Template: &ast.TemplateBlock{Source: "<form hx-post=\"{{route `signUp`}}\"></form>"},
+	}
+}
+
+func TestGenerator_JobQueue(t *testing.T) {
+	jobs := &ast.ServiceDecl{Name: "Jobs", Provider: "jobs", Fields: []*ast.ServiceField{
+		{Name: "workers", Type: "string", EnvVar: "JOB_WORKERS"},
+	}}
+
+	tests := []struct {
+		name       string
+		file       *ast.GMXFile
+		expected   []string
+		unexpected []string
+	}{
+		{
+			name: "default pool",
+			file: queueFile(),
+			expected: []string{
+				"func enqueueSendWelcomeEmail(ctx *GMXContext, id string) error {",
+				"if err := enqueueSendWelcomeEmail(ctx, id); err != nil {",
+				"\tstartJobWorkers(4, 100)\n",
+				"\tdrainJobQueue(shutdownCtx)\n",
+				"\tjob.ctx.DB = db.WithContext(jobQueueCtx)\n",
+				`return fmt.Errorf("enqueue %s: the job queue is full", name)`,
+				"func decodeJobArgs(args []json.RawMessage, dst ...any) error {",
+			},
+			// Without metrics, the run of a job is not timed
+			unexpected: []string{"handleSendWelcomeEmail", "jobQueueConfig", "jobCtx.Job", "BackgroundJob", "observeJob", "func executeJob(job queuedJob) error {\n\tstart := time.Now()\n"},
+		},
+		{
+			name: "jobs service",
+			file: queueFile(jobs),
+			expected: []string{
+				"func jobQueueConfig(cfg *JobsConfig) (workers, size int) {",
+				"n, err := strconv.Atoi(cfg.Workers)",
+				"\tstartJobWorkers(jobQueueConfig(jobsCfg))\n",
+			},
+			unexpected: []string{"cfg.Queue"},
+		},
+		{
+			name: "block overflow",
+			file: queueFile(overflowService("block")),
+			expected: []string{
+				"case <-ctx.Request.Context().Done():",
+				"case <-jobQueue.stopping:",
+				"\tclose(jobQueue.stopping)\n",
+			},
+			unexpected: []string{"the job queue is full", "BackgroundJob"},
+		},
+		{
+			name: "drop overflow",
+			file: queueFile(overflowService("drop")),
+			expected: []string{
+				`ctx.Log.Warn("job queue full: dropped a run", "job", name)`,
+			},
+			unexpected: []string{"the job queue is full", "jobsDropped"},
+		},
+		{
+			name: "persist overflow",
+			file: queueFile(overflowService("persist")),
+			expected: []string{
+				"type BackgroundJob struct {",
+				"\t\treturn storeJob(ctx, name, args)\n",
+				"\t\"sendWelcomeEmail\": runStoredSendWelcomeEmail,\n",
+				"func runStoredSendWelcomeEmail(ctx *GMXContext, args []json.RawMessage) error {",
+				"\tgo refillJobQueue()\n",
+				`claim := db.WithContext(jobQueueCtx).Where("id = ?", row.ID).Delete(&BackgroundJob{})`,
+			},
+			unexpected: []string{"the job queue is full"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := New().Generate(tt.file)
+			if err != nil {
+				t.Fatalf("Generate() error: %v", err)
+			}
+			if !isValidGo(code) {
+				t.Errorf("Generated code is not valid Go:\n%s", code)
+			}
+			for _, want := range tt.expected {
+				if !strings.Contains(code, want) {
+					t.Errorf("expected %q in generated code", want)
+				}
+			}
+			for _, unwanted := range tt.unexpected {
+				if strings.Contains(code, unwanted) {
+					t.Errorf("unexpected %q in generated code", unwanted)
+				}
+			}
+			// The workers start before the server, and drain before the database closes
+			if strings.Index(code, "startJobWorkers(") > strings.LastIndex(code, "serveUntilSignal(srv") {
+				t.Error("expected the job workers to start before the server")
+			}
+		})
+	}
+}
+
+func overflowService(policy string) *ast.ServiceDecl {
+	return &ast.ServiceDecl{Name: "Jobs", Provider: "jobs", Fields: []*ast.ServiceField{
+		{Name: "overflow", Type: "string", Annotations: []*ast.Annotation{{Name: "default", Args: map[string]string{"_": `"` + policy + `"`}}}},
+	}}
+}
+
+func TestGenerator_JobQueueMetrics(t *testing.T) {
+	tests := []struct {
+		policy     string
+		expected   []string
+		unexpected []string
+	}{
+		{
+			policy: "reject",
+			expected: []string{
+				"\tobserveJob(job.name, start.Sub(job.queued), time.Since(start))\n",
+				"\tjobWorkers = workers\n",
+				`fmt.Fprintf(&b, "gmx_job_queue_depth %d\n", len(jobQueue.jobs))`,
+				`writeMetricsHistogram(&b, "gmx_job_wait_seconds", fmt.Sprintf("job=%q", job), jobWait[job])`,
+				`writeMetricsHistogram(&b, "gmx_job_duration_seconds", fmt.Sprintf("job=%q", job), jobDuration[job])`,
+			},
+			unexpected: []string{"gmx_jobs_dropped_total", "gmx_job_queue_stored"},
+		},
+		{
+			policy:   "drop",
+			expected: []string{"\t\tjobsDropped[name]++\n", "gmx_jobs_dropped_total{job=%q} %d"},
+		},
+		{
+			policy:   "persist",
+			expected: []string{"\tvar storedJobs int64\n\tdb.Model(&BackgroundJob{}).Count(&storedJobs)\n\tmetricsMu.Lock()\n", "gmx_job_queue_stored %d"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			file := queueFile(overflowService(tt.policy))
+			file.Observability = metricsObservability
+			code, err := New().Generate(file)
+			if err != nil {
+				t.Fatalf("Generate() error: %v", err)
+			}
+			if !isValidGo(code) {
+				t.Errorf("Generated code is not valid Go:\n%s", code)
+			}
+			for _, want := range tt.expected {
+				if !strings.Contains(code, want) {
+					t.Errorf("expected %q in generated code", want)
+				}
+			}
+			for _, unwanted := range tt.unexpected {
+				if strings.Contains(code, unwanted) {
+					t.Errorf("unexpected %q in generated code", unwanted)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerator_JobQueueErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(file *ast.GMXFile)
+		wantErr string
+	}{
+		{
+			name: "annotation argument",
+			modify: func(file *ast.GMXFile) {
+				file.Script.Funcs[0].Annotations[0].Args = map[string]string{"_": "2"}
+			},
+			wantErr: "function sendWelcomeEmail: @job takes no arguments",
+		},
+		{
+			name: "return type",
+			modify: func(file *ast.GMXFile) {
+				file.Script.Funcs[0].ReturnType = "string"
+			},
+			wantErr: "function sendWelcomeEmail: @job applies to functions returning error",
+		},
+		{
+			name: "handler annotation",
+			modify: func(file *ast.GMXFile) {
+				file.Script.Funcs[0].Annotations = append(file.Script.Funcs[0].Annotations, &ast.Annotation{Name: "auth"})
+			},
+			wantErr: "function sendWelcomeEmail: @job functions run in the background, not as handlers; remove @auth",
+		},
+		{
+			name: "route",
+			modify: func(file *ast.GMXFile) {
+				file.Template.Source = "<button hx-post=\"{{route `sendWelcomeEmail`}}\"></button>"
+			},
+			wantErr: "function sendWelcomeEmail: @job functions have no route",
+		},
+		{
+			name: "unknown service field",
+			modify: func(file *ast.GMXFile) {
+				file.Services = []*ast.ServiceDecl{{Name: "Jobs", Provider: "jobs", Fields: []*ast.ServiceField{{Name: "retries", Type: "string"}}}}
+			},
+			wantErr: "service Jobs: the jobs provider takes workers, queue and overflow fields, not retries",
+		},
+		{
+			name: "service field type",
+			modify: func(file *ast.GMXFile) {
+				file.Services = []*ast.ServiceDecl{{Name: "Jobs", Provider: "jobs", Fields: []*ast.ServiceField{{Name: "workers", Type: "int"}}}}
+			},
+			wantErr: "service Jobs: field workers of the jobs provider must be a string",
+		},
+		{
+			name: "overflow from the environment",
+			modify: func(file *ast.GMXFile) {
+				file.Services = []*ast.ServiceDecl{{Name: "Jobs", Provider: "jobs", Fields: []*ast.ServiceField{{Name: "overflow", Type: "string", EnvVar: "JOB_OVERFLOW"}}}}
+			},
+			wantErr: "service Jobs: the overflow policy is chosen at build time",
+		},
+		{
+			name: "unknown overflow policy",
+			modify: func(file *ast.GMXFile) {
+				file.Services = []*ast.ServiceDecl{overflowService("retry")}
+			},
+			wantErr: `service Jobs: unknown overflow policy "retry" (expected reject, block, drop, persist)`,
+		},
+		{
+			name: "stored job model collision",
+			modify: func(file *ast.GMXFile) {
+				file.Services = []*ast.ServiceDecl{overflowService("persist")}
+				file.Models = append(file.Models, &ast.ModelDecl{Name: "BackgroundJob", Line: 3, Fields: []*ast.FieldDecl{{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{{Name: "pk"}}}}})
+			},
+			wantErr: "line 3: model BackgroundJob collides with the model storing the queued jobs",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := queueFile()
+			tt.modify(file)
+			_, err := New().Generate(file)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestGenerator_JobQueueWithoutJobs(t *testing.T) {
+	// A queue service without @job functions has nothing to start
+	for _, svc := range []*ast.ServiceDecl{
+		{Name: "Jobs", Provider: "jobs", Fields: []*ast.ServiceField{{Name: "workers", Type: "string", EnvVar: "JOB_WORKERS"}}},
+		dbQueueService(&ast.ServiceField{Name: "attempts", Type: "string", EnvVar: "JOB_ATTEMPTS"}),
+	} {
+		t.Run(svc.Provider, func(t *testing.T) {
+			file := queueFile(svc)
+			signUp := file.Script.Funcs[1]
+			signUp.Body = signUp.Body[1:]
+			file.Script.Funcs = file.Script.Funcs[1:]
+			code, err := New().Generate(file)
+			if err != nil {
+				t.Fatalf("Generate() error: %v", err)
+			}
+			if strings.Contains(code, "startJobWorkers(") {
+				t.Error("unexpected job workers without @job functions")
+			}
+			goInModule(t, map[string]string{"main.go": code}, "vet", ".")
+		})
+	}
+}
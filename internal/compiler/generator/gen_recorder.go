@@ -0,0 +1,230 @@
+package generator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// recordedPlaceholder replaces the sensitive values of a recording
+const recordedPlaceholder = "[redacted]"
+
+// recordRedactedFields returns the lowercased names of the password,
+// @sensitive and @pii fields of the models, whose values recordings replace
+func recordRedactedFields(file *ast.GMXFile) []string {
+	seen := map[string]bool{"password": true}
+	for _, model := range file.Models {
+		for _, field := range model.Fields {
+			if field.Type == "password" || field.FindAnnotation("sensitive") != nil || field.FindAnnotation("pii") != nil {
+				seen[strings.ToLower(field.Name)] = true
+			}
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// genRecorder generates the dev-only request recorder: with --record dir,
+// each request and its response are written to dir as JSON, credentials
+// and sensitive fields redacted, for gmx replay to send them again
+func (g *Generator) genRecorder(file *ast.GMXFile) string {
+	var b strings.Builder
+
+	b.WriteString("// recordFlag names the directory receiving the recorded requests, which gmx replay sends again\n")
+	b.WriteString("var recordFlag = flag.String(\"record\", \"\", \"record requests and responses to this directory, for gmx replay\")\n\n")
+
+	b.WriteString("// recordedBodyLimit bounds the request and response bodies a recording keeps\n")
+	b.WriteString("const recordedBodyLimit = 1 << 20\n\n")
+
+	b.WriteString("// recordedExchange is a request and its response, as --record writes them\n")
+	b.WriteString("type recordedExchange struct {\n")
+	b.WriteString("\tTime      time.Time   `json:\"time\"`\n")
+	b.WriteString("\tMethod    string      `json:\"method\"`\n")
+	b.WriteString("\tURL       string      `json:\"url\"`\n")
+	b.WriteString("\tHeader    http.Header `json:\"header\"`\n")
+	b.WriteString("\tBody      string      `json:\"body,omitempty\"`\n")
+	b.WriteString("\tTruncated bool        `json:\"truncated,omitempty\"` // body over recordedBodyLimit, not kept\n")
+	b.WriteString("\tStatus    int         `json:\"status\"`\n")
+	b.WriteString("\tResponse  string      `json:\"response,omitempty\"`\n")
+	b.WriteString("\tDuration  string      `json:\"duration\"`\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// recordSeq orders the recordings of a run\n")
+	b.WriteString("var recordSeq atomic.Int64\n\n")
+
+	var quoted []string
+	for _, name := range recordRedactedFields(file) {
+		quoted = append(quoted, fmt.Sprintf("%q: true", name))
+	}
+	b.WriteString("// recordRedacted lists the password, @sensitive and @pii fields, lowercased\n")
+	b.WriteString(fmt.Sprintf("var recordRedacted = map[string]bool{%s}\n\n", strings.Join(quoted, ", ")))
+
+	b.WriteString("// recordSkippedHeaders are the request headers a recording drops: credentials\n")
+	b.WriteString("var recordSkippedHeaders = map[string]bool{\"Cookie\": true, \"Authorization\": true, \"Proxy-Authorization\": true, \"X-Csrf-Token\": true}\n\n")
+
+	b.WriteString("// redactedField reports whether a recording hides the value of a form,\n")
+	b.WriteString("// query or JSON field: sensitive model fields, and names mentioning a\n")
+	b.WriteString("// password, a token or a secret\n")
+	b.WriteString("func redactedField(name string) bool {\n")
+	b.WriteString("\tlower := strings.ToLower(name)\n")
+	b.WriteString("\tif recordRedacted[lower] {\n")
+	b.WriteString("\t\treturn true\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tfor _, part := range []string{\"password\", \"token\", \"secret\", \"csrf\"} {\n")
+	b.WriteString("\t\tif strings.Contains(lower, part) {\n")
+	b.WriteString("\t\t\treturn true\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn false\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// redactValues replaces the sensitive values of a form or query string\n")
+	b.WriteString("func redactValues(values url.Values) url.Values {\n")
+	b.WriteString("\tfor key := range values {\n")
+	b.WriteString("\t\tif redactedField(key) {\n")
+	b.WriteString(fmt.Sprintf("\t\t\tvalues[key] = []string{%q}\n", recordedPlaceholder))
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn values\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// sanitizeRecordedBody returns the body a recording keeps: forms and JSON\n")
+	b.WriteString("// objects with their sensitive values replaced, text as is; other bodies,\n")
+	b.WriteString("// such as file uploads, are not kept\n")
+	b.WriteString("func sanitizeRecordedBody(contentType string, body []byte) string {\n")
+	b.WriteString("\tmediaType, _, _ := strings.Cut(contentType, \";\")\n")
+	b.WriteString("\tmediaType = strings.TrimSpace(mediaType)\n")
+	b.WriteString("\tswitch {\n")
+	b.WriteString("\tcase mediaType == \"application/x-www-form-urlencoded\":\n")
+	b.WriteString("\t\tvalues, err := url.ParseQuery(string(body))\n")
+	b.WriteString("\t\tif err != nil {\n")
+	b.WriteString("\t\t\treturn \"\"\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\treturn redactValues(values).Encode()\n")
+	b.WriteString("\tcase mediaType == \"application/json\":\n")
+	b.WriteString("\t\tvar fields map[string]any\n")
+	b.WriteString("\t\tif err := json.Unmarshal(body, &fields); err != nil {\n")
+	b.WriteString("\t\t\treturn \"\"\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tfor key := range fields {\n")
+	b.WriteString("\t\t\tif redactedField(key) {\n")
+	b.WriteString(fmt.Sprintf("\t\t\t\tfields[key] = %q\n", recordedPlaceholder))
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tdata, err := json.Marshal(fields)\n")
+	b.WriteString("\t\tif err != nil {\n")
+	b.WriteString("\t\t\treturn \"\"\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\treturn string(data)\n")
+	b.WriteString("\tcase strings.HasPrefix(mediaType, \"text/\"):\n")
+	b.WriteString("\t\treturn string(body)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn \"\"\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// recordingWriter keeps the status and the start of the body of a response\n")
+	b.WriteString("type recordingWriter struct {\n")
+	b.WriteString("\thttp.ResponseWriter\n")
+	b.WriteString("\tstatus int\n")
+	b.WriteString("\tbody   bytes.Buffer\n")
+	b.WriteString("}\n\n")
+	b.WriteString("func (w *recordingWriter) WriteHeader(status int) {\n")
+	b.WriteString("\tif w.status == 0 {\n")
+	b.WriteString("\t\tw.status = status\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tw.ResponseWriter.WriteHeader(status)\n")
+	b.WriteString("}\n\n")
+	b.WriteString("func (w *recordingWriter) Write(p []byte) (int, error) {\n")
+	b.WriteString("\tif w.status == 0 {\n")
+	b.WriteString("\t\tw.status = http.StatusOK\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif room := recordedBodyLimit - w.body.Len(); room > 0 {\n")
+	b.WriteString("\t\tw.body.Write(p[:min(len(p), room)])\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn w.ResponseWriter.Write(p)\n")
+	b.WriteString("}\n\n")
+	b.WriteString("// Flush keeps server-sent event streams flowing through the recorder\n")
+	b.WriteString("func (w *recordingWriter) Flush() {\n")
+	b.WriteString("\tif f, ok := w.ResponseWriter.(http.Flusher); ok {\n")
+	b.WriteString("\t\tf.Flush()\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+	b.WriteString("// Unwrap lets http.ResponseController reach the underlying writer\n")
+	b.WriteString("func (w *recordingWriter) Unwrap() http.ResponseWriter {\n")
+	b.WriteString("\treturn w.ResponseWriter\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// recordRequests writes each request and its response to the --record\n")
+	b.WriteString("// directory, one JSON file per request in arrival order; without the flag\n")
+	b.WriteString("// it returns next unchanged\n")
+	b.WriteString("func recordRequests(next http.Handler) http.Handler {\n")
+	b.WriteString("\tdir := *recordFlag\n")
+	b.WriteString("\tif dir == \"\" {\n")
+	b.WriteString("\t\treturn next\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif err := os.MkdirAll(dir, 0o755); err != nil {\n")
+	b.WriteString("\t\tlog.Fatalf(\"record: %v\", err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tslog.Info(\"Dev mode: recording requests, replayed by gmx replay\", \"dir\", dir)\n\n")
+	b.WriteString("\treturn http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString("\t\t// The dev pages are not part of the app\n")
+	b.WriteString("\t\tif strings.HasPrefix(r.URL.Path, \"/__gmx/\") {\n")
+	b.WriteString("\t\t\tnext.ServeHTTP(w, r)\n")
+	b.WriteString("\t\t\treturn\n")
+	b.WriteString("\t\t}\n\n")
+	b.WriteString("\t\tbody, err := io.ReadAll(io.LimitReader(r.Body, recordedBodyLimit+1))\n")
+	b.WriteString("\t\tif err != nil {\n")
+	b.WriteString("\t\t\thttp.Error(w, \"Bad Request\", http.StatusBadRequest)\n")
+	b.WriteString("\t\t\treturn\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\t// The handler reads the body again, past the recorded part if it is longer\n")
+	b.WriteString("\t\tr.Body = struct {\n")
+	b.WriteString("\t\t\tio.Reader\n")
+	b.WriteString("\t\t\tio.Closer\n")
+	b.WriteString("\t\t}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}\n\n")
+	b.WriteString("\t\texchange := recordedExchange{Time: time.Now(), Method: r.Method, URL: r.URL.Path, Header: http.Header{}}\n")
+	b.WriteString("\t\tif r.URL.RawQuery != \"\" {\n")
+	b.WriteString("\t\t\texchange.URL += \"?\" + redactValues(r.URL.Query()).Encode()\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tfor key, values := range r.Header {\n")
+	b.WriteString("\t\t\tif !recordSkippedHeaders[key] {\n")
+	b.WriteString("\t\t\t\texchange.Header[key] = values\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tif len(body) > recordedBodyLimit {\n")
+	b.WriteString("\t\t\texchange.Truncated = true\n")
+	b.WriteString("\t\t} else {\n")
+	b.WriteString("\t\t\texchange.Body = sanitizeRecordedBody(r.Header.Get(\"Content-Type\"), body)\n")
+	b.WriteString("\t\t}\n\n")
+	b.WriteString("\t\trec := &recordingWriter{ResponseWriter: w}\n")
+	b.WriteString("\t\tnext.ServeHTTP(rec, r)\n")
+	b.WriteString("\t\texchange.Status = rec.status\n")
+	b.WriteString("\t\tif exchange.Status == 0 {\n")
+	b.WriteString("\t\t\texchange.Status = http.StatusOK\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\texchange.Response = rec.body.String()\n")
+	b.WriteString("\t\texchange.Duration = time.Since(exchange.Time).String()\n\n")
+	b.WriteString("\t\t// Recordings are read by people too: & and < stay as they are\n")
+	b.WriteString("\t\tvar data bytes.Buffer\n")
+	b.WriteString("\t\tenc := json.NewEncoder(&data)\n")
+	b.WriteString("\t\tenc.SetEscapeHTML(false)\n")
+	b.WriteString("\t\tenc.SetIndent(\"\", \"  \")\n")
+	b.WriteString("\t\tif err := enc.Encode(exchange); err != nil {\n")
+	b.WriteString("\t\t\trequestLogger(r).Error(\"record: encoding\", \"error\", err)\n")
+	b.WriteString("\t\t\treturn\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tname := fmt.Sprintf(\"%s-%06d.json\", exchange.Time.Format(\"20060102-150405\"), recordSeq.Add(1))\n")
+	b.WriteString("\t\tif err := os.WriteFile(filepath.Join(dir, name), data.Bytes(), 0o600); err != nil {\n")
+	b.WriteString("\t\t\trequestLogger(r).Error(\"record: writing\", \"error\", err)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t})\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
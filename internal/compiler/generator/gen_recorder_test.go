@@ -0,0 +1,71 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+func TestGenerator_Recorder(t *testing.T) {
+	file := &ast.GMXFile{
+		Models: []*ast.ModelDecl{
+			{Name: "Customer", Fields: []*ast.FieldDecl{
+				{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{{Name: "pk"}}},
+				{Name: "fullName", Type: "string", Annotations: []*ast.Annotation{{Name: "pii"}}},
+				{Name: "apiKey", Type: "string", Annotations: []*ast.Annotation{{Name: "sensitive"}}},
+				{Name: "city", Type: "string"},
+			}},
+		},
+		Template: &ast.TemplateBlock{Source: "<h1>Hello</h1>"},
+	}
+
+	tests := []struct {
+		name       string
+		dev        bool
+		expected   []string
+		unexpected []string
+	}{
+		{
+			name: "dev",
+			dev:  true,
+			expected: []string{
+				`var recordFlag = flag.String("record", "", "record requests and responses to this directory, for gmx replay")`,
+				`var recordRedacted = map[string]bool{"apikey": true, "fullname": true, "password": true}`,
+				"srv := &http.Server{Addr: \":8080\", Handler: logRequests(recordRequests(csrfProtect(securityHeaders(mux))))}",
+				`if strings.HasPrefix(r.URL.Path, "/__gmx/") {`,
+				`exchange.URL += "?" + redactValues(r.URL.Query()).Encode()`,
+				`func (w *recordingWriter) Flush() {`,
+				`"path/filepath"`,
+				`"net/url"`,
+			},
+		},
+		{
+			name:       "production",
+			dev:        false,
+			unexpected: []string{"recordFlag", "recordRequests", "recordingWriter"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := NewWithOptions(Options{Dev: tt.dev}).Generate(file)
+			if err != nil {
+				t.Fatalf("Generate() error: %v", err)
+			}
+			if !isValidGo(code) {
+				t.Errorf("Generated code is not valid Go:\n%s", code)
+			}
+			for _, want := range tt.expected {
+				if !strings.Contains(code, want) {
+					t.Errorf("expected %q in generated code", want)
+				}
+			}
+			for _, unwanted := range tt.unexpected {
+				if strings.Contains(code, unwanted) {
+					t.Errorf("unexpected %q in generated code", unwanted)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package generator
+
+import (
+	"fmt"
+	gotoken "go/token"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// validateModelAnnotations checks the model annotations: only @repository
+// exists, naming a Go type of package main or a generic type of a native
+// import instantiated with the model
+func (g *Generator) validateModelAnnotations(file *ast.GMXFile) error {
+	for _, model := range file.Models {
+		for _, ann := range model.Annotations {
+			if ann.Name != "repository" && ann.Name != "feedItem" && ann.Name != "typeahead" && ann.Name != "live" && ann.Name != "renamedFrom" && ann.Name != "timestamps" && ann.Name != "preload" && ann.Name != "account" && ann.Name != "personalData" {
+				return fmt.Errorf("model %s: unknown annotation @%s (expected @repository, @feedItem, @typeahead, @live, @renamedFrom, @timestamps, @preload, @account or @personalData)", model.Name, ann.Name)
+			}
+		}
+		ann := model.FindAnnotation("repository")
+		if ann == nil {
+			continue
+		}
+		typeName := ann.SimpleArg()
+		if len(ann.Args) != 1 || typeName == "" {
+			return fmt.Errorf("model %s: @repository takes the Go type implementing %sRepository, such as @repository(\"%sRepo\")", model.Name, model.Name, model.Name)
+		}
+		pkg, name, qualified := strings.Cut(typeName, ".")
+		if !qualified {
+			name, pkg = pkg, ""
+		}
+		if !gotoken.IsIdentifier(name) || (qualified && !gotoken.IsIdentifier(pkg)) {
+			return fmt.Errorf("model %s: invalid repository type %q", model.Name, typeName)
+		}
+		if qualified && !hasNativeImport(file, pkg) {
+			return fmt.Errorf("model %s: repository %s refers to package %s, which is not imported (import \"...\" as %s)", model.Name, typeName, pkg, pkg)
+		}
+	}
+	return nil
+}
+
+// hasNativeImport reports whether a Go package is imported under the alias
+func hasNativeImport(file *ast.GMXFile, alias string) bool {
+	for _, imp := range file.Imports {
+		if imp.IsNative && imp.Alias == alias {
+			return true
+		}
+	}
+	return false
+}
+
+// genRepositories generates the repository interface of each model marked
+// @repository, and the value its ORM helpers forward to
+func (g *Generator) genRepositories(models []*ast.ModelDecl) string {
+	var b strings.Builder
+
+	for _, model := range models {
+		ann := model.FindAnnotation("repository")
+		if ann == nil {
+			continue
+		}
+		name := model.Name
+		repoVar := utils.LowerFirst(name) + "Repository"
+
+		b.WriteString(fmt.Sprintf("// %sRepository stores %s records in place of the default GORM helpers\n", name, name))
+		b.WriteString(fmt.Sprintf("type %sRepository interface {\n", name))
+		b.WriteString(fmt.Sprintf("\tFind(db *gorm.DB, id string) (*%s, error)\n", name))
+		b.WriteString(fmt.Sprintf("\tAll(db *gorm.DB) ([]%s, error)\n", name))
+		b.WriteString(fmt.Sprintf("\tWhere(db *gorm.DB, scopes ...func(*gorm.DB) *gorm.DB) ([]%s, error)\n", name))
+		b.WriteString(fmt.Sprintf("\tSave(db *gorm.DB, obj *%s) error\n", name))
+		b.WriteString(fmt.Sprintf("\tDelete(db *gorm.DB, obj *%s) error\n", name))
+		b.WriteString("}\n\n")
+
+		// A type from another package cannot name the model: it is generic over it
+		impl := ann.SimpleArg()
+		if strings.Contains(impl, ".") {
+			impl += "[" + name + "]"
+		}
+		b.WriteString(fmt.Sprintf("// %s is the %sRepository declared with @repository\n", repoVar, name))
+		b.WriteString(fmt.Sprintf("var %s %sRepository = &%s{}\n\n", repoVar, name, impl))
+	}
+
+	return b.String()
+}
@@ -0,0 +1,109 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+const repositorySrc = `@repository("%s")
+model Task {
+  id: uuid @pk @default(uuid_v4)
+  title: string
+}
+
+model Tag {
+  id: uuid @pk @default(uuid_v4)
+}
+
+func listTasks() error {
+  let tasks = try Task.all()
+  return render(tasks)
+}
+`
+
+func TestGenerateRepository(t *testing.T) {
+	tests := []struct {
+		name string
+		repo string
+		impl string
+	}{
+		{"same package", "TaskRepo", "var taskRepository TaskRepository = &TaskRepo{}"},
+		{"native import", "repos.SQL", "var taskRepository TaskRepository = &repos.SQL[Task]{}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := strictFile(t, strings.Replace(repositorySrc, "%s", tt.repo, 1), `<p>tasks</p>`)
+			file.Imports = []*ast.ImportDecl{{Path: "example.com/repos", Alias: "repos", IsNative: true}}
+
+			code, err := New().Generate(file)
+			if err != nil {
+				t.Fatalf("generate: %v", err)
+			}
+
+			for _, want := range []string{
+				"type TaskRepository interface {",
+				"Where(db *gorm.DB, scopes ...func(*gorm.DB) *gorm.DB) ([]Task, error)",
+				tt.impl,
+				"return taskRepository.All(db)",
+				"return taskRepository.Save(db, obj)",
+				"if objs, err := taskRepository.All(db); err != nil {",
+			} {
+				if !strings.Contains(code, want) {
+					t.Errorf("expected %q in generated code", want)
+				}
+			}
+
+			// Models without @repository keep the GORM helpers
+			if !strings.Contains(code, "if err := db.Find(&objs).Error; err != nil {") {
+				t.Error("expected Tag to keep the GORM helpers")
+			}
+			if strings.Contains(code, "TagRepository") {
+				t.Error("expected no repository for Tag")
+			}
+		})
+	}
+}
+
+func TestGenerateRepositoryErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{
+			"unknown annotation",
+			`@cached model Task { id: uuid @pk }`,
+			"model Task: unknown annotation @cached",
+		},
+		{
+			"missing type",
+			`@repository model Task { id: uuid @pk }`,
+			"@repository takes the Go type implementing TaskRepository",
+		},
+		{
+			"invalid type",
+			`@repository("task-repo") model Task { id: uuid @pk }`,
+			`invalid repository type "task-repo"`,
+		},
+		{
+			"package not imported",
+			`@repository("repos.SQL") model Task { id: uuid @pk }`,
+			"refers to package repos, which is not imported",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New().Generate(strictFile(t, tt.script, `<p>tasks</p>`))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
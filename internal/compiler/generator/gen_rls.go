@@ -0,0 +1,280 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// Row-level security settings, read by the generated PostgreSQL policies
+const (
+	rlsTenantSetting = "current_setting('app.tenant_id', true)"
+	rlsUserSetting   = "current_setting('app.user_id', true)"
+	rlsAdminSetting  = "current_setting('app.is_admin', true)"
+)
+
+// hasRowLevelSecurity checks if the Database service declares a tenantHeader,
+// which turns on row-level security for the models
+func (g *Generator) hasRowLevelSecurity(file *ast.GMXFile) bool {
+	if len(file.Models) == 0 {
+		return false
+	}
+	svc := g.findDatabaseService(file.Services)
+	return svc != nil && findServiceField(svc, "tenantHeader") != nil
+}
+
+// validateRowLevelSecurity checks that the database can enforce the policies
+func (g *Generator) validateRowLevelSecurity(file *ast.GMXFile) error {
+	svc := g.findDatabaseService(file.Services)
+	if svc == nil || findServiceField(svc, "tenantHeader") == nil {
+		return nil
+	}
+	if svc.Provider != "postgres" {
+		return fmt.Errorf("service %s: tenantHeader enables row-level security, which requires provider \"postgres\", got %q", svc.Name, svc.Provider)
+	}
+	return nil
+}
+
+// rlsPolicies returns the row-level security policies of a model's table: a
+// permissive base, then restrictive policies for its @scoped field and the
+// rules of its policy that translate to SQL. The actions whose rule does not
+// translate are returned apart; the ORM helpers still check them.
+func rlsPolicies(model *ast.ModelDecl) (policies []string, skipped []string) {
+	var tenantField *ast.FieldDecl
+	for _, field := range model.Fields {
+		for _, ann := range field.Annotations {
+			if ann.Name == "scoped" && tenantField == nil {
+				tenantField = field
+			}
+		}
+	}
+	if tenantField == nil && model.Policy == nil {
+		return nil, nil
+	}
+
+	policies = append(policies, "AS PERMISSIVE FOR ALL USING (true) WITH CHECK (true)")
+	if tenantField != nil {
+		cond := fmt.Sprintf("%s::text = %s", utils.ToSnakeCase(tenantField.Name), rlsTenantSetting)
+		policies = append(policies, fmt.Sprintf("AS RESTRICTIVE FOR ALL USING (%s) WITH CHECK (%s)", cond, cond))
+	}
+	if model.Policy == nil {
+		return policies, nil
+	}
+
+	record := utils.LowerFirst(model.Name)
+	for _, rule := range model.Policy.Rules {
+		cond, ok := rlsCondition(rule.Cond, record)
+		if !ok {
+			skipped = append(skipped, rule.Action)
+			continue
+		}
+		switch rule.Action {
+		case "read":
+			policies = append(policies, fmt.Sprintf("AS RESTRICTIVE FOR SELECT USING (%s)", cond))
+		case "create":
+			policies = append(policies, fmt.Sprintf("AS RESTRICTIVE FOR INSERT WITH CHECK (%s)", cond))
+		case "update":
+			// Like the ORM helpers, the rule sees the row as stored
+			policies = append(policies, fmt.Sprintf("AS RESTRICTIVE FOR UPDATE USING (%s)", cond))
+		case "delete":
+			policies = append(policies, fmt.Sprintf("AS RESTRICTIVE FOR DELETE USING (%s)", cond))
+		}
+	}
+	return policies, skipped
+}
+
+// rlsOperators maps the script operators a policy condition may use to SQL
+var rlsOperators = map[string]string{
+	"==": "=", "!=": "<>", "<": "<", "<=": "<=", ">": ">", ">=": ">=",
+	"&&": "AND", "||": "OR",
+}
+
+// rlsCondition translates a policy condition to SQL: fields of the record,
+// ctx.user, ctx.tenant, role(admin), literals and their comparisons. It
+// reports false for anything else, such as calls or interpolated strings.
+func rlsCondition(expr ast.Expression, record string) (string, bool) {
+	switch e := expr.(type) {
+	case *ast.BoolLit:
+		if e.Value {
+			return "true", true
+		}
+		return "false", true
+	case *ast.IntLit:
+		return e.Value, true
+	case *ast.StringLit:
+		if e.Parts != nil {
+			return "", false
+		}
+		return "'" + strings.ReplaceAll(e.Value, "'", "''") + "'", true
+	case *ast.CtxExpr:
+		switch e.Field {
+		case "user":
+			return rlsUserSetting, true
+		case "tenant":
+			return rlsTenantSetting, true
+		}
+	case *ast.MemberExpr:
+		if obj, ok := e.Object.(*ast.Ident); ok && obj.Name == record {
+			return utils.ToSnakeCase(e.Property), true
+		}
+	case *ast.CallExpr:
+		// validatePolicies only lets role(admin) through
+		if fn, ok := e.Function.(*ast.Ident); ok && fn.Name == "role" {
+			return rlsAdminSetting + " = 'true'", true
+		}
+	case *ast.UnaryExpr:
+		operand, ok := rlsCondition(e.Operand, record)
+		if !ok {
+			return "", false
+		}
+		switch e.Op {
+		case "!":
+			return "NOT (" + operand + ")", true
+		case "-":
+			return "-" + operand, true
+		}
+	case *ast.BinaryExpr:
+		op, ok := rlsOperators[e.Op]
+		if !ok {
+			return "", false
+		}
+		left, ok := rlsCondition(e.Left, record)
+		if !ok {
+			return "", false
+		}
+		right, ok := rlsCondition(e.Right, record)
+		if !ok {
+			return "", false
+		}
+		// Settings are text: compare columns of any type as text
+		_, leftCtx := e.Left.(*ast.CtxExpr)
+		_, rightCtx := e.Right.(*ast.CtxExpr)
+		if _, ok := e.Left.(*ast.MemberExpr); ok && rightCtx {
+			left += "::text"
+		}
+		if _, ok := e.Right.(*ast.MemberExpr); ok && leftCtx {
+			right += "::text"
+		}
+		return "(" + left + " " + op + " " + right + ")", true
+	}
+	return "", false
+}
+
+// genRowLevelSecurity generates the PostgreSQL row-level security policies of
+// the models and the middleware pinning each request to a connection whose
+// settings carry the tenant and user the policies compare rows against
+func (g *Generator) genRowLevelSecurity(file *ast.GMXFile) string {
+	var b strings.Builder
+
+	b.WriteString("// tenantHeader names the request header carrying the tenant, set by the gateway\n")
+	b.WriteString("var tenantHeader string\n\n")
+
+	b.WriteString("// requestTenant returns the tenant of a request, exposed as ctx.Tenant\n")
+	b.WriteString("func requestTenant(r *http.Request) string {\n")
+	b.WriteString("\treturn r.Header.Get(tenantHeader)\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// rlsPolicies lists the row-level security policies of each model's table,\n")
+	b.WriteString("// from its @scoped field and policy rules\n")
+	b.WriteString("var rlsPolicies = []struct {\n")
+	b.WriteString("\tmodel    string\n")
+	b.WriteString("\tpolicies []string\n")
+	b.WriteString("}{\n")
+	for _, model := range file.Models {
+		policies, skipped := rlsPolicies(model)
+		if len(policies) == 0 {
+			continue
+		}
+		b.WriteString(fmt.Sprintf("\t{%q, []string{\n", model.Name))
+		for _, policy := range policies {
+			b.WriteString(fmt.Sprintf("\t\t%q,\n", policy))
+		}
+		for _, action := range skipped {
+			b.WriteString(fmt.Sprintf("\t\t// %s: rule not expressible in SQL, checked by the ORM helpers only\n", action))
+		}
+		b.WriteString("\t}},\n")
+	}
+	b.WriteString("}\n\n")
+
+	b.WriteString("// enableRowLevelSecurity (re)creates the policies of the tables, forced on\n")
+	b.WriteString("// their owner too so that the application role cannot bypass them\n")
+	b.WriteString("func enableRowLevelSecurity(db *gorm.DB) error {\n")
+	b.WriteString("\treturn db.Transaction(func(tx *gorm.DB) error {\n")
+	b.WriteString("\t\tfor _, rls := range rlsPolicies {\n")
+	b.WriteString("\t\t\ttable := tx.NamingStrategy.TableName(rls.model)\n")
+	b.WriteString("\t\t\tquoted := tx.Statement.Quote(table)\n")
+	b.WriteString("\t\t\tvar stale []string\n")
+	b.WriteString("\t\t\tif err := tx.Raw(\"SELECT policyname FROM pg_policies WHERE schemaname = current_schema() AND tablename = ? AND policyname LIKE 'gmx\\\\_%'\", table).Scan(&stale).Error; err != nil {\n")
+	b.WriteString("\t\t\t\treturn err\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t\tfor _, name := range stale {\n")
+	b.WriteString("\t\t\t\tif err := tx.Exec(\"DROP POLICY \" + tx.Statement.Quote(name) + \" ON \" + quoted).Error; err != nil {\n")
+	b.WriteString("\t\t\t\t\treturn err\n")
+	b.WriteString("\t\t\t\t}\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t\tif err := tx.Exec(\"ALTER TABLE \" + quoted + \" ENABLE ROW LEVEL SECURITY, FORCE ROW LEVEL SECURITY\").Error; err != nil {\n")
+	b.WriteString("\t\t\t\treturn err\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t\tfor i, policy := range rls.policies {\n")
+	b.WriteString("\t\t\t\tif err := tx.Exec(fmt.Sprintf(\"CREATE POLICY gmx_%d ON %s %s\", i, quoted, policy)).Error; err != nil {\n")
+	b.WriteString("\t\t\t\t\treturn fmt.Errorf(\"%s: %w\", table, err)\n")
+	b.WriteString("\t\t\t\t}\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\treturn nil\n")
+	b.WriteString("\t})\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// setRLSSettings sets the settings the policies read on a connection\n")
+	b.WriteString("func setRLSSettings(conn *gorm.DB, tenant, user string, admin bool) error {\n")
+	b.WriteString("\treturn conn.Exec(\"SELECT set_config('app.tenant_id', ?, false), set_config('app.user_id', ?, false), set_config('app.is_admin', ?, false)\", tenant, user, fmt.Sprint(admin)).Error\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// rlsConnKey is the request context key of the connection pinned by rlsConnection\n")
+	b.WriteString("type rlsConnKey struct{}\n\n")
+
+	b.WriteString("// rlsConnection pins a connection to each request and sets its tenant and\n")
+	b.WriteString("// user; the settings are cleared before the connection returns to the pool\n")
+	b.WriteString("func rlsConnection(next http.Handler) http.Handler {\n")
+	b.WriteString("\treturn http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {\n")
+	if g.findSessionService(file.Services) != nil {
+		b.WriteString("\t\tuser := readSession(r).User\n")
+	} else {
+		b.WriteString("\t\tuser := \"\"\n")
+	}
+	admin := "false"
+	if g.hasImpersonation(file) {
+		admin = "sessionAdmins[user]"
+	}
+	b.WriteString("\t\terr := db.WithContext(r.Context()).Connection(func(conn *gorm.DB) error {\n")
+	b.WriteString(fmt.Sprintf("\t\t\tif err := setRLSSettings(conn, requestTenant(r), user, %s); err != nil {\n", admin))
+	b.WriteString("\t\t\t\treturn err\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t\t// Cleared even when the client went away\n")
+	b.WriteString("\t\t\tdefer func() {\n")
+	b.WriteString("\t\t\t\tif err := setRLSSettings(conn.WithContext(context.Background()), \"\", \"\", false); err != nil {\n")
+	b.WriteString("\t\t\t\t\trequestLogger(r).Error(\"row-level security: clearing settings\", \"error\", err)\n")
+	b.WriteString("\t\t\t\t}\n")
+	b.WriteString("\t\t\t}()\n")
+	b.WriteString("\t\t\tnext.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), rlsConnKey{}, conn)))\n")
+	b.WriteString("\t\t\treturn nil\n")
+	b.WriteString("\t\t})\n")
+	b.WriteString("\t\tif err != nil {\n")
+	b.WriteString("\t\t\trequestLogger(r).Error(\"row-level security\", \"error\", err)\n")
+	b.WriteString("\t\t\thttp.Error(w, \"Internal Server Error\", http.StatusInternalServerError)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t})\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// requestDB returns the connection pinned to the request by rlsConnection\n")
+	b.WriteString("func requestDB(r *http.Request) *gorm.DB {\n")
+	b.WriteString("\tif conn, ok := r.Context().Value(rlsConnKey{}).(*gorm.DB); ok {\n")
+	b.WriteString("\t\treturn conn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn db\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
@@ -0,0 +1,123 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// rlsTestFile returns a postgres file with a @scoped Task model whose policy
+// lets the owner update and admins delete
+func rlsTestFile(provider string) *ast.GMXFile {
+	file := policyTestFile(true, "delete")
+	task := file.Models[0]
+	task.Fields = append(task.Fields,
+		&ast.FieldDecl{Name: "tenantId", Type: "uuid", Annotations: []*ast.Annotation{{Name: "scoped"}}},
+		&ast.FieldDecl{Name: "userId", Type: "string"},
+	)
+	task.Policy.Rules = append(task.Policy.Rules,
+		&ast.PolicyRule{Action: "update", Cond: &ast.BinaryExpr{
+			Left:  &ast.MemberExpr{Object: &ast.Ident{Name: "task"}, Property: "userId"},
+			Op:    "==",
+			Right: &ast.CtxExpr{Field: "user"},
+		}},
+		&ast.PolicyRule{Action: "read", Cond: &ast.CallExpr{Function: &ast.Ident{Name: "visible"}}},
+	)
+	file.Services = append(file.Services, &ast.ServiceDecl{
+		Name:     "Database",
+		Provider: provider,
+		Fields: []*ast.ServiceField{
+			{Name: "url", Type: "string", EnvVar: "DATABASE_URL"},
+			{Name: "tenantHeader", Type: "string", EnvVar: "TENANT_HEADER"},
+		},
+	})
+	return file
+}
+
+func TestGenerateRowLevelSecurity(t *testing.T) {
+	code, err := New().Generate(rlsTestFile("postgres"))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, want := range []string{
+		`"AS PERMISSIVE FOR ALL USING (true) WITH CHECK (true)"`,
+		`"AS RESTRICTIVE FOR ALL USING (tenant_id::text = current_setting('app.tenant_id', true)) WITH CHECK (tenant_id::text = current_setting('app.tenant_id', true))"`,
+		`"AS RESTRICTIVE FOR DELETE USING (current_setting('app.is_admin', true) = 'true')"`,
+		`"AS RESTRICTIVE FOR UPDATE USING ((user_id::text = current_setting('app.user_id', true)))"`,
+		"// read: rule not expressible in SQL, checked by the ORM helpers only",
+		"ENABLE ROW LEVEL SECURITY, FORCE ROW LEVEL SECURITY",
+		"tenantHeader = databaseCfg.TenantHeader",
+		"if err := enableRowLevelSecurity(db); err != nil {",
+		"if err := setRLSSettings(conn, requestTenant(r), user, sessionAdmins[user]); err != nil {",
+		// Handlers query through the request's connection
+		"db := requestDB(r)",
+		"Tenant:  requestTenant(r),",
+		"csrfProtect(securityHeaders(rlsConnection(mux)))",
+		`"context"`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+}
+
+func TestGenerateWithoutRowLevelSecurity(t *testing.T) {
+	file := rlsTestFile("postgres")
+	file.Services[1].Fields = file.Services[1].Fields[:1]
+
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if strings.Contains(code, "rlsConnection") || strings.Contains(code, "requestDB") {
+		t.Error("row-level security should only be generated with a tenantHeader field")
+	}
+}
+
+func TestValidateRowLevelSecurity(t *testing.T) {
+	_, err := New().Generate(rlsTestFile("sqlite"))
+	want := `service Database: tenantHeader enables row-level security, which requires provider "postgres", got "sqlite"`
+	if err == nil || err.Error() != want {
+		t.Errorf("expected %q, got %v", want, err)
+	}
+}
+
+func TestRLSCondition(t *testing.T) {
+	field := func(name string) ast.Expression {
+		return &ast.MemberExpr{Object: &ast.Ident{Name: "task"}, Property: name}
+	}
+	tests := []struct {
+		name string
+		expr ast.Expression
+		want string
+		ok   bool
+	}{
+		{"bool", &ast.BoolLit{Value: true}, "true", true},
+		{"quoted string", &ast.StringLit{Value: "it's"}, "'it''s'", true},
+		{"interpolated string", &ast.StringLit{Value: "{x}", Parts: []ast.StringPart{{IsExpr: true}}}, "", false},
+		{"field", field("ownerId"), "owner_id", true},
+		{"other record", &ast.MemberExpr{Object: &ast.Ident{Name: "post"}, Property: "id"}, "", false},
+		{"tenant", &ast.BinaryExpr{Left: &ast.CtxExpr{Field: "tenant"}, Op: "==", Right: field("orgId")}, "(current_setting('app.tenant_id', true) = org_id::text)", true},
+		{"logic", &ast.BinaryExpr{
+			Left:  &ast.UnaryExpr{Op: "!", Operand: field("archived")},
+			Op:    "||",
+			Right: &ast.BinaryExpr{Left: field("priority"), Op: ">=", Right: &ast.IntLit{Value: "3"}},
+		}, "(NOT (archived) OR (priority >= 3))", true},
+		{"arithmetic", &ast.BinaryExpr{Left: field("a"), Op: "+", Right: field("b")}, "", false},
+		{"call", &ast.CallExpr{Function: &ast.Ident{Name: "len"}}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := rlsCondition(tt.expr, "task")
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("rlsCondition() = %q, %v; want %q, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
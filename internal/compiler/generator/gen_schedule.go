@@ -0,0 +1,351 @@
+package generator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// cronFields are the fields of a @schedule expression, with their range
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// cronShortcuts are the named @schedule expressions
+var cronShortcuts = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+	"@yearly":  "0 0 1 1 *",
+}
+
+// cronSpec is a parsed @schedule expression: the bits of the minutes, hours,
+// days of the month, months and days of the week it matches
+type cronSpec struct {
+	fields         [5]uint64
+	domAny, dowAny bool
+}
+
+// parseCron parses a five-field cron expression, "*/5 * * * *", or one of
+// the cronShortcuts
+func parseCron(expr string) (cronSpec, error) {
+	var spec cronSpec
+	if shortcut, ok := cronShortcuts[expr]; ok {
+		expr = shortcut
+	}
+	parts := strings.Fields(expr)
+	if len(parts) != len(cronFields) {
+		return spec, fmt.Errorf("expected 5 fields (minute hour day-of-month month day-of-week), got %d", len(parts))
+	}
+	for i, part := range parts {
+		field := cronFields[i]
+		for _, item := range strings.Split(part, ",") {
+			bits, err := parseCronItem(item, field.min, field.max)
+			if err != nil {
+				return spec, fmt.Errorf("%s field %q: %w", field.name, part, err)
+			}
+			spec.fields[i] |= bits
+		}
+	}
+	// Sunday is both 0 and 7
+	if spec.fields[4]&(1<<7) != 0 {
+		spec.fields[4] = spec.fields[4]&^(1<<7) | 1
+	}
+	// As in cron, a restricted day of the month or of the week is enough to
+	// match, unless the other day field starts with *
+	spec.domAny = strings.HasPrefix(parts[2], "*")
+	spec.dowAny = strings.HasPrefix(parts[4], "*")
+	return spec, nil
+}
+
+// parseCronItem returns the bits of an item of a cron field: *, n, a-b, each
+// optionally followed by a /step
+func parseCronItem(item string, min, max int) (uint64, error) {
+	rng, step, hasStep := strings.Cut(item, "/")
+	every := 1
+	if hasStep {
+		n, err := strconv.Atoi(step)
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("invalid step %q", step)
+		}
+		every = n
+	}
+
+	lo, hi := min, max
+	switch {
+	case rng == "*":
+	case strings.Contains(rng, "-"):
+		a, b, _ := strings.Cut(rng, "-")
+		var err error
+		if lo, err = cronValue(a, min, max); err != nil {
+			return 0, err
+		}
+		if hi, err = cronValue(b, min, max); err != nil {
+			return 0, err
+		}
+		if lo > hi {
+			return 0, fmt.Errorf("range %s ends before it starts", rng)
+		}
+	default:
+		var err error
+		if lo, err = cronValue(rng, min, max); err != nil {
+			return 0, err
+		}
+		// n/step runs from n to the end of the range
+		if !hasStep {
+			hi = lo
+		}
+	}
+
+	var bits uint64
+	for v := lo; v <= hi; v += every {
+		bits |= 1 << uint(v)
+	}
+	return bits, nil
+}
+
+// cronValue parses a number of a cron field within its range
+func cronValue(s string, min, max int) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if n < min || n > max {
+		return 0, fmt.Errorf("value %d out of range %d-%d", n, min, max)
+	}
+	return n, nil
+}
+
+// hasSchedules checks if script functions run on a schedule with @schedule,
+// accounts pending deletion are purged, stale autosaved drafts deleted,
+// expired @once submissions forgotten or the database backed up
+func (g *Generator) hasSchedules(file *ast.GMXFile) bool {
+	return g.hasFuncAnnotation(file, "schedule") || g.hasAccounts(file) || g.hasAutosave(file) || g.hasFuncAnnotation(file, "once") ||
+		g.findBackupService(file.Services) != nil
+}
+
+// validateSchedules checks that the @schedule functions have a valid cron
+// expression and can run without a request
+func (g *Generator) validateSchedules(file *ast.GMXFile) error {
+	funcs := g.funcsWithAnnotation(file, "schedule")
+	if len(funcs) == 0 {
+		return nil
+	}
+	routes := make(map[string]string)
+	if file.Template != nil {
+		routes = g.genRouteRegistry(file.Template.Source)
+	}
+	for _, fn := range funcs {
+		annotation := fn.FindAnnotation("schedule")
+		expr := annotation.SimpleArg()
+		if len(annotation.Args) != 1 || expr == "" {
+			return fmt.Errorf("function %s: @schedule takes a cron expression, @schedule(\"*/5 * * * *\")", fn.Name)
+		}
+		if _, err := parseCron(expr); err != nil {
+			return fmt.Errorf("function %s: invalid @schedule %q: %v", fn.Name, expr, err)
+		}
+		if fn.ReturnType != "" && fn.ReturnType != "error" {
+			return fmt.Errorf("function %s: @schedule applies to functions returning error", fn.Name)
+		}
+		if len(fn.Params) > 0 {
+			return fmt.Errorf("function %s: @schedule functions take no parameters; the scheduler calls them without arguments", fn.Name)
+		}
+		for _, name := range append([]string{"job"}, queuedJobExclusive...) {
+			if fn.FindAnnotation(name) != nil {
+				return fmt.Errorf("function %s: @schedule functions run in the background, not as handlers; remove @%s", fn.Name, name)
+			}
+		}
+		if _, ok := routes[fn.Name]; ok {
+			return fmt.Errorf("function %s: @schedule functions have no route; remove {{route `%s`}}", fn.Name, fn.Name)
+		}
+	}
+
+	// Scheduled runs have no request to take the tenant from
+	if g.hasRowLevelSecurity(file) {
+		return fmt.Errorf("function %s: @schedule does not apply with row-level security, which needs the tenant of a request", funcs[0].Name)
+	}
+	return nil
+}
+
+// genSchedules generates the scheduler of the @schedule functions: one
+// goroutine per function waiting for its next run, which the shutdown stops
+func (g *Generator) genSchedules(file *ast.GMXFile) string {
+	var b strings.Builder
+
+	b.WriteString("// cronSchedule is a @schedule expression: the bits of the minutes, hours,\n")
+	b.WriteString("// days of the month, months and days of the week it matches; or, for the\n")
+	b.WriteString("// intervals of services, the time between runs\n")
+	b.WriteString("type cronSchedule struct {\n")
+	b.WriteString("\tminute, hour, dom, month, dow uint64\n")
+	b.WriteString("\tdomAny, dowAny                bool // day fields starting with *\n")
+	b.WriteString("\tevery                         time.Duration\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// matchesDay reports whether the schedule runs on the day of t: on a day of\n")
+	b.WriteString("// the month or of the week it lists when it restricts both, as cron does\n")
+	b.WriteString("func (s cronSchedule) matchesDay(t time.Time) bool {\n")
+	b.WriteString("\tdom := s.dom&(1<<uint(t.Day())) != 0\n")
+	b.WriteString("\tdow := s.dow&(1<<uint(t.Weekday())) != 0\n")
+	b.WriteString("\tif s.domAny || s.dowAny {\n")
+	b.WriteString("\t\treturn dom && dow\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn dom || dow\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// next returns the first minute after t the schedule matches, in the local\n")
+	b.WriteString("// time zone, or the zero time when none comes within five years; an\n")
+	b.WriteString("// interval runs again once it elapsed after t\n")
+	b.WriteString("func (s cronSchedule) next(t time.Time) time.Time {\n")
+	b.WriteString("\tif s.every > 0 {\n")
+	b.WriteString("\t\treturn t.Add(s.every)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tt = t.Truncate(time.Minute).Add(time.Minute)\n")
+	b.WriteString("\tfor limit := t.AddDate(5, 0, 0); t.Before(limit); {\n")
+	b.WriteString("\t\tswitch {\n")
+	b.WriteString("\t\tcase s.month&(1<<uint(t.Month())) == 0:\n")
+	b.WriteString("\t\t\tt = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())\n")
+	b.WriteString("\t\tcase !s.matchesDay(t):\n")
+	b.WriteString("\t\t\tt = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())\n")
+	b.WriteString("\t\tcase s.hour&(1<<uint(t.Hour())) == 0:\n")
+	b.WriteString("\t\t\tt = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())\n")
+	b.WriteString("\t\tcase s.minute&(1<<uint(t.Minute())) == 0:\n")
+	b.WriteString("\t\t\tt = t.Add(time.Minute)\n")
+	b.WriteString("\t\tdefault:\n")
+	b.WriteString("\t\t\treturn t\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn time.Time{}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// scheduledFunc is a function run on a schedule\n")
+	b.WriteString("type scheduledFunc struct {\n")
+	b.WriteString("\tname     string\n")
+	b.WriteString("\tschedule cronSchedule\n")
+	b.WriteString("\trun      func(ctx *GMXContext) error\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// scheduledFuncs are the @schedule functions, the purge of deleted accounts,\n")
+	b.WriteString("// the cleanup of stale autosaved drafts and the sweep of expired @once\n")
+	b.WriteString("// submissions, with their schedule; the backups of a Backup service are\n")
+	b.WriteString("// added at startup, their interval being a setting\n")
+	b.WriteString("var scheduledFuncs = []scheduledFunc{\n")
+	scheduled := func(name, expr string) {
+		spec, _ := parseCron(expr) // validated in validateSchedules
+		b.WriteString(fmt.Sprintf("\t// %s\n", expr))
+		b.WriteString(fmt.Sprintf("\t{%q, cronSchedule{minute: %#x, hour: %#x, dom: %#x, month: %#x, dow: %#x, domAny: %t, dowAny: %t}, %s},\n",
+			name, spec.fields[0], spec.fields[1], spec.fields[2], spec.fields[3], spec.fields[4], spec.domAny, spec.dowAny, name))
+	}
+	for _, fn := range g.funcsWithAnnotation(file, "schedule") {
+		scheduled(fn.Name, fn.FindAnnotation("schedule").SimpleArg())
+	}
+	if g.hasAccounts(file) {
+		scheduled("purgeAccounts", "@hourly")
+	}
+	if g.hasAutosave(file) {
+		scheduled("cleanupAutosaves", "@hourly")
+	}
+	if g.hasFuncAnnotation(file, "once") {
+		scheduled("sweepSubmissions", "* * * * *")
+	}
+	b.WriteString("}\n\n")
+
+	b.WriteString("// scheduleCtx is the context of the scheduled runs, cancelled at the\n")
+	b.WriteString("// shutdown deadline so that ctx.cancelled() lets them stop early\n")
+	b.WriteString("var scheduleCtx, cancelSchedules = context.WithCancel(context.Background())\n\n")
+
+	b.WriteString("// stopScheduling is closed on shutdown: no run starts afterwards\n")
+	b.WriteString("var stopScheduling = make(chan struct{})\n\n")
+
+	b.WriteString("// scheduledRuns tracks the schedulers, which the shutdown waits for\n")
+	b.WriteString("var scheduledRuns sync.WaitGroup\n\n")
+
+	b.WriteString("// scheduledResponse is the writer of a scheduled run, which answers no\n")
+	b.WriteString("// request: what the function renders is dropped\n")
+	b.WriteString("type scheduledResponse struct {\n")
+	b.WriteString("\theader http.Header\n")
+	b.WriteString("}\n\n")
+	b.WriteString("func (r scheduledResponse) Header() http.Header       { return r.header }\n")
+	b.WriteString("func (scheduledResponse) Write(p []byte) (int, error) { return len(p), nil }\n")
+	b.WriteString("func (scheduledResponse) WriteHeader(int)             {}\n\n")
+
+	b.WriteString("// startSchedules starts a scheduler per @schedule function; a run lasting\n")
+	b.WriteString("// past the next scheduled minute skips it rather than overlapping\n")
+	b.WriteString("func startSchedules() {\n")
+	b.WriteString("\tfor _, fn := range scheduledFuncs {\n")
+	b.WriteString("\t\tscheduledRuns.Add(1)\n")
+	b.WriteString("\t\tgo func() {\n")
+	b.WriteString("\t\t\tdefer scheduledRuns.Done()\n")
+	b.WriteString("\t\t\tfor {\n")
+	b.WriteString("\t\t\t\tnext := fn.schedule.next(time.Now())\n")
+	b.WriteString("\t\t\t\tif next.IsZero() {\n")
+	b.WriteString("\t\t\t\t\tslog.Warn(\"schedule: no run within five years\", \"schedule\", fn.name)\n")
+	b.WriteString("\t\t\t\t\treturn\n")
+	b.WriteString("\t\t\t\t}\n")
+	b.WriteString("\t\t\t\ttimer := time.NewTimer(time.Until(next))\n")
+	b.WriteString("\t\t\t\tselect {\n")
+	b.WriteString("\t\t\t\tcase <-timer.C:\n")
+	b.WriteString("\t\t\t\t\trunScheduled(fn.name, fn.run)\n")
+	b.WriteString("\t\t\t\tcase <-stopScheduling:\n")
+	b.WriteString("\t\t\t\t\ttimer.Stop()\n")
+	b.WriteString("\t\t\t\t\treturn\n")
+	b.WriteString("\t\t\t\t}\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t}()\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// runScheduled runs a @schedule function with a fresh context, without user\n")
+	b.WriteString("// or tenant, and logs its error; a panicking run fails alone\n")
+	b.WriteString("func runScheduled(name string, run func(ctx *GMXContext) error) {\n")
+	b.WriteString(g.genHoldDB(file, "\t"))
+	b.WriteString("\tstart := time.Now()\n")
+	b.WriteString("\terr := func() (err error) {\n")
+	b.WriteString("\t\tdefer func() {\n")
+	b.WriteString("\t\t\tif p := recover(); p != nil {\n")
+	b.WriteString("\t\t\t\terr = fmt.Errorf(\"panic: %v\", p)\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t}()\n")
+	b.WriteString("\t\treq, err := http.NewRequestWithContext(scheduleCtx, http.MethodGet, \"/\", nil)\n")
+	b.WriteString("\t\tif err != nil {\n")
+	b.WriteString("\t\t\treturn err\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tctx := &GMXContext{Writer: scheduledResponse{header: http.Header{}}, Request: req, Log: slog.With(\"schedule\", name)}\n")
+	if len(file.Models) > 0 {
+		b.WriteString("\t\tctx.DB = db.WithContext(scheduleCtx)\n")
+	}
+	b.WriteString("\t\treturn run(ctx)\n")
+	b.WriteString("\t}()\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\tslog.Error(\"schedule failed\", \"schedule\", name, \"duration\", time.Since(start).Round(time.Millisecond), \"error\", err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// stopSchedules stops the schedulers and lets the running runs finish until\n")
+	b.WriteString("// the shutdown deadline, when they are cancelled\n")
+	b.WriteString("func stopSchedules(deadline context.Context) {\n")
+	b.WriteString("\tclose(stopScheduling)\n")
+	b.WriteString("\tstopped := make(chan struct{})\n")
+	b.WriteString("\tgo func() {\n")
+	b.WriteString("\t\tscheduledRuns.Wait()\n")
+	b.WriteString("\t\tclose(stopped)\n")
+	b.WriteString("\t}()\n")
+	b.WriteString("\tselect {\n")
+	b.WriteString("\tcase <-stopped:\n")
+	b.WriteString("\tcase <-deadline.Done():\n")
+	b.WriteString("\t\tcancelSchedules()\n")
+	b.WriteString("\t\tslog.Warn(\"schedules: shutdown deadline reached with scheduled runs still running\")\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
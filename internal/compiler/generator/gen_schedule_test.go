@@ -0,0 +1,152 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    cronSpec
+		wantErr string
+	}{
+		{expr: "*/15 * * * *", want: cronSpec{fields: [5]uint64{1 | 1<<15 | 1<<30 | 1<<45, 0xffffff, 0xfffffffe, 0x1ffe, 0x7f}, domAny: true, dowAny: true}},
+		{expr: "0 9-17/4 * * 1-5", want: cronSpec{fields: [5]uint64{1, 1<<9 | 1<<13 | 1<<17, 0xfffffffe, 0x1ffe, 0x3e}, domAny: true}},
+		{expr: "30 2 1,15 * 7", want: cronSpec{fields: [5]uint64{1 << 30, 1 << 2, 1<<1 | 1<<15, 0x1ffe, 1}}},
+		{expr: "5/20 * * * *", want: cronSpec{fields: [5]uint64{1<<5 | 1<<25 | 1<<45, 0xffffff, 0xfffffffe, 0x1ffe, 0x7f}, domAny: true, dowAny: true}},
+		{expr: "@daily", want: cronSpec{fields: [5]uint64{1, 1, 0xfffffffe, 0x1ffe, 0x7f}, domAny: true, dowAny: true}},
+		{expr: "* * *", wantErr: "expected 5 fields (minute hour day-of-month month day-of-week), got 3"},
+		{expr: "60 * * * *", wantErr: `minute field "60": value 60 out of range 0-59`},
+		{expr: "* * 0 * *", wantErr: `day of month field "0": value 0 out of range 1-31`},
+		{expr: "*/0 * * * *", wantErr: `minute field "*/0": invalid step "0"`},
+		{expr: "* 5-2 * * *", wantErr: `hour field "5-2": range 5-2 ends before it starts`},
+		{expr: "* * * jan *", wantErr: `month field "jan": invalid value "jan"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := parseCron(tt.expr)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseCron() error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("parseCron() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func scheduleFile() *ast.GMXFile {
+	file := queueFile()
+	file.Script.Funcs = append(file.Script.Funcs, &ast.FuncDecl{
+		Name:        "cleanupExpired",
+		ReturnType:  "error",
+		Annotations: []*ast.Annotation{{Name: "schedule", Args: map[string]string{"_": "*/5 * * * *"}}},
+		Body:        []ast.Statement{&ast.ReturnStmt{Value: &ast.Ident{Name: "nil"}}},
+	})
+	return file
+}
+
+func TestGenerator_Schedules(t *testing.T) {
+	code, err := New().Generate(scheduleFile())
+	if err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+	for _, want := range []string{
+		"\t// */5 * * * *\n",
+		`{"cleanupExpired", cronSchedule{minute: 0x84210842108421, hour: 0xffffff, dom: 0xfffffffe, month: 0x1ffe, dow: 0x7f, domAny: true, dowAny: true}, cleanupExpired},`,
+		"ctx.DB = db.WithContext(scheduleCtx)",
+		// Runs log with the name of their schedule
+		`Log: slog.With("schedule", name)}`,
+		"\tstartSchedules()\n",
+		"\tstopSchedules(shutdownCtx)\n",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in generated code", want)
+		}
+	}
+	if strings.Contains(code, "handleCleanupExpired") {
+		t.Error("expected no handler for a @schedule function")
+	}
+	// Schedules start after the job workers they may queue to, and stop before them
+	if strings.Index(code, "\tstartSchedules()") < strings.Index(code, "\tstartJobWorkers(") {
+		t.Error("expected the schedules to start after the job workers")
+	}
+	if strings.Index(code, "\tstopSchedules(") > strings.Index(code, "\tdrainJobQueue(") {
+		t.Error("expected the schedules to stop before the job queue drains")
+	}
+}
+
+func TestGenerator_ScheduleErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(fn *ast.FuncDecl, file *ast.GMXFile)
+		wantErr string
+	}{
+		{
+			name: "missing expression",
+			modify: func(fn *ast.FuncDecl, _ *ast.GMXFile) {
+				fn.Annotations[0].Args = map[string]string{}
+			},
+			wantErr: "function cleanupExpired: @schedule takes a cron expression",
+		},
+		{
+			name: "invalid expression",
+			modify: func(fn *ast.FuncDecl, _ *ast.GMXFile) {
+				fn.Annotations[0].Args["_"] = "every 5 minutes"
+			},
+			wantErr: `function cleanupExpired: invalid @schedule "every 5 minutes": expected 5 fields`,
+		},
+		{
+			name: "parameters",
+			modify: func(fn *ast.FuncDecl, _ *ast.GMXFile) {
+				fn.Params = []*ast.Param{{Name: "id", Type: "uuid"}}
+			},
+			wantErr: "function cleanupExpired: @schedule functions take no parameters",
+		},
+		{
+			name: "return type",
+			modify: func(fn *ast.FuncDecl, _ *ast.GMXFile) {
+				fn.ReturnType = "int"
+			},
+			wantErr: "function cleanupExpired: @schedule applies to functions returning error",
+		},
+		{
+			name: "job annotation",
+			modify: func(fn *ast.FuncDecl, _ *ast.GMXFile) {
+				fn.Annotations = append(fn.Annotations, &ast.Annotation{Name: "job"})
+			},
+			wantErr: "function cleanupExpired: @schedule functions run in the background, not as handlers; remove @job",
+		},
+		{
+			name: "route",
+			modify: func(_ *ast.FuncDecl, file *ast.GMXFile) {
+				file.Template.Source = "<button hx-post=\"{{route `cleanupExpired`}}\"></button>"
+			},
+			wantErr: "function cleanupExpired: @schedule functions have no route",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := scheduleFile()
+			tt.modify(file.Script.Funcs[2], file)
+			_, err := New().Generate(file)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// selfCheckTimeout bounds the ping of each service by the startup self-check
+const selfCheckTimeout = "5 * time.Second"
+
+// samplePath returns a request path matching a route pattern, its wildcards
+// replaced by a segment: /_gmx/jobs/{id} → /_gmx/jobs/x
+func samplePath(pattern string) string {
+	var b strings.Builder
+	for {
+		start := strings.Index(pattern, "{")
+		end := strings.Index(pattern, "}")
+		if start < 0 || end < start {
+			b.WriteString(pattern)
+			return b.String()
+		}
+		b.WriteString(pattern[:start])
+		// {$} only anchors the end of the path
+		if pattern[start:end+1] != "{$}" {
+			b.WriteString("x")
+		}
+		pattern = pattern[end+1:]
+	}
+}
+
+// genSelfCheckCall returns the statement of main running the self-check of
+// dev builds, given the URLs of the HTTP services
+func (g *Generator) genSelfCheckCall(file *ast.GMXFile) string {
+	var services []string
+	for _, svc := range file.Services {
+		if svc.Provider == "http" {
+			services = append(services, fmt.Sprintf("{%q, %sCfg.BaseUrl}", svc.Name, utils.LowerFirst(svc.Name)))
+		}
+	}
+	var b strings.Builder
+	b.WriteString("\tif *selfCheckFlag {\n")
+	if len(services) == 0 {
+		b.WriteString("\t\tselfCheck(mux, nil)\n")
+	} else {
+		b.WriteString(fmt.Sprintf("\t\tselfCheck(mux, [][2]string{%s})\n", strings.Join(services, ", ")))
+	}
+	b.WriteString("\t}\n\n")
+	return b.String()
+}
+
+// genSelfCheck generates the startup self-check of dev builds: it renders the
+// pages and fragments with zero-value data, resolves every route through the
+// mux and pings the services, then stops the server with every problem found
+// instead of answering 500 at the first click
+func (g *Generator) genSelfCheck(file *ast.GMXFile, routes map[string]string, hasStyleBundle bool) string {
+	var b strings.Builder
+
+	b.WriteString("// selfCheckFlag runs the startup self-check, skipped with -self-check=false\n")
+	b.WriteString("var selfCheckFlag = flag.Bool(\"self-check\", true, \"render the templates, resolve the routes and ping the services on startup\")\n\n")
+
+	b.WriteString("// selfCheck renders the pages and fragments with zero-value data, checks that\n")
+	b.WriteString("// each route reaches its handler and pings the database and the HTTP services,\n")
+	b.WriteString("// given by name and base URL; the problems found stop the server together\n")
+	b.WriteString("func selfCheck(mux *http.ServeMux, services [][2]string) {\n")
+	b.WriteString("\tvar problems []string\n")
+
+	if file.Template != nil {
+		b.WriteString("\n\t// Fragments inside an empty range are only rendered here\n")
+		b.WriteString("\tfor _, check := range []struct {\n")
+		b.WriteString("\t\tname string\n")
+		b.WriteString("\t\tdata any\n")
+		b.WriteString("\t}{\n")
+		if len(file.Pages) > 0 {
+			for _, page := range file.Pages {
+				b.WriteString(fmt.Sprintf("\t\t{%q, PageData{}},\n", pageTemplateName(page)))
+			}
+		} else {
+			b.WriteString("\t\t{\"page\", PageData{}},\n")
+		}
+		for _, model := range file.Models {
+			b.WriteString(fmt.Sprintf("\t\t{%q, &%s{}},\n", model.Name, model.Name))
+		}
+		// Generated with the models and the script, as genHelpers does
+		if len(file.Models) > 0 || file.Script != nil {
+			b.WriteString("\t\t{\"ValidationError\", &ValidationError{}},\n")
+		}
+		b.WriteString("\t} {\n")
+		b.WriteString("\t\tif tmpl.Lookup(check.name) == nil {\n")
+		b.WriteString("\t\t\tcontinue\n")
+		b.WriteString("\t\t}\n")
+		b.WriteString("\t\tif err := tmpl.ExecuteTemplate(io.Discard, check.name, check.data); err != nil {\n")
+		b.WriteString("\t\t\tproblems = append(problems, fmt.Sprintf(\"template %s: %v\", check.name, err))\n")
+		b.WriteString("\t\t}\n")
+		b.WriteString("\t}\n")
+	}
+
+	b.WriteString("\n\t// Each route is served by its own handler, not a stub or a broader pattern\n")
+	b.WriteString("\tfor _, route := range []struct {\n")
+	b.WriteString("\t\tpattern, sample, stub string\n")
+	b.WriteString("\t}{\n")
+	for _, route := range g.routeTable(file, routes, hasStyleBundle) {
+		stub := ""
+		if route.Source == "template route" {
+			stub = strings.TrimPrefix(route.Handler, "handle")
+		}
+		b.WriteString(fmt.Sprintf("\t\t{%q, %q, %q},\n", route.Path, samplePath(route.Path), utils.LowerFirst(stub)))
+	}
+	b.WriteString("\t} {\n")
+	b.WriteString("\t\tif route.stub != \"\" {\n")
+	b.WriteString("\t\t\tproblems = append(problems, fmt.Sprintf(\"route %s: no script function %s, a stub handler answers\", route.pattern, route.stub))\n")
+	b.WriteString("\t\t\tcontinue\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\treq, err := http.NewRequest(http.MethodGet, route.sample, nil)\n")
+	b.WriteString("\t\tif err != nil {\n")
+	b.WriteString("\t\t\tproblems = append(problems, fmt.Sprintf(\"route %s: %v\", route.pattern, err))\n")
+	b.WriteString("\t\t\tcontinue\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tif _, pattern := mux.Handler(req); pattern != route.pattern {\n")
+	b.WriteString("\t\t\tproblems = append(problems, fmt.Sprintf(\"route %s: %s reaches %q instead\", route.pattern, route.sample, pattern))\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+
+	if len(file.Models) > 0 {
+		b.WriteString("\n\tif sqlDB, err := db.DB(); err != nil {\n")
+		b.WriteString("\t\tproblems = append(problems, fmt.Sprintf(\"database: %v\", err))\n")
+		b.WriteString("\t} else if err := sqlDB.Ping(); err != nil {\n")
+		b.WriteString("\t\tproblems = append(problems, fmt.Sprintf(\"database: %v\", err))\n")
+		b.WriteString("\t}\n")
+	}
+
+	b.WriteString("\n\t// Any answer shows the service is reachable, whatever its status\n")
+	b.WriteString(fmt.Sprintf("\tclient := &http.Client{Timeout: %s}\n", selfCheckTimeout))
+	b.WriteString("\tfor _, svc := range services {\n")
+	b.WriteString("\t\tname, url := svc[0], svc[1]\n")
+	b.WriteString("\t\tif url == \"\" {\n")
+	b.WriteString("\t\t\tproblems = append(problems, fmt.Sprintf(\"service %s: no base URL\", name))\n")
+	b.WriteString("\t\t\tcontinue\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tresp, err := client.Get(url)\n")
+	b.WriteString("\t\tif err != nil {\n")
+	b.WriteString("\t\t\tproblems = append(problems, fmt.Sprintf(\"service %s: %v\", name, err))\n")
+	b.WriteString("\t\t\tcontinue\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tresp.Body.Close()\n")
+	b.WriteString("\t}\n\n")
+
+	b.WriteString("\tif len(problems) > 0 {\n")
+	b.WriteString("\t\tlog.Fatalf(\"self-check: %d problem(s), -self-check=false skips the check:\\n  %s\", len(problems), strings.Join(problems, \"\\n  \"))\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
@@ -0,0 +1,120 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+func TestSamplePath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{"/api/toggleTask", "/api/toggleTask"},
+		{"/_gmx/jobs/{id}", "/_gmx/jobs/x"},
+		{"/files/{path...}", "/files/x"},
+		{"/tasks/{id}/edit", "/tasks/x/edit"},
+		{"/{$}", "/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			if got := samplePath(tt.pattern); got != tt.want {
+				t.Errorf("samplePath(%q) = %q, want %q", tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerator_SelfCheck(t *testing.T) {
+	file := &ast.GMXFile{
+		Models: []*ast.ModelDecl{
+			{Name: "Task", Fields: []*ast.FieldDecl{
+				{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{{Name: "pk"}}},
+				{Name: "title", Type: "string"},
+			}},
+		},
+		Services: []*ast.ServiceDecl{
+			{Name: "GitHub", Provider: "http", Fields: []*ast.ServiceField{
+				{Name: "baseUrl", Type: "string", EnvVar: "GITHUB_API_URL"},
+			}},
+		},
+		Script: &ast.ScriptBlock{Funcs: []*ast.FuncDecl{
+			{Name: "listTasks", ReturnType: "error", Body: []ast.Statement{&ast.ReturnStmt{Value: &ast.Ident{Name: "nil"}}}},
+		}},
+		Template: &ast.TemplateBlock{Source: "<div hx-get=\"{{route `listTasks`}}\"></div><button hx-post=\"{{route `archive`}}\"></button>"},
+	}
+
+	tests := []struct {
+		name       string
+		dev        bool
+		expected   []string
+		unexpected []string
+	}{
+		{
+			name: "dev",
+			dev:  true,
+			expected: []string{
+				`var selfCheckFlag = flag.Bool("self-check", true, "render the templates, resolve the routes and ping the services on startup")`,
+				"\t\tselfCheck(mux, [][2]string{{\"GitHub\", gitHubCfg.BaseUrl}})\n",
+				"\t\t{\"page\", PageData{}},\n",
+				"\t\t{\"Task\", &Task{}},\n",
+				"\t\t{\"/api/archive\", \"/api/archive\", \"archive\"},\n",
+				"\t\t{\"/api/listTasks\", \"/api/listTasks\", \"\"},\n",
+				"} else if err := sqlDB.Ping(); err != nil {",
+			},
+		},
+		{
+			name:       "production",
+			dev:        false,
+			unexpected: []string{"selfCheck"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := NewWithOptions(Options{Dev: tt.dev}).Generate(file)
+			if err != nil {
+				t.Fatalf("Generate() error: %v", err)
+			}
+			if !isValidGo(code) {
+				t.Errorf("Generated code is not valid Go:\n%s", code)
+			}
+			for _, want := range tt.expected {
+				if !strings.Contains(code, want) {
+					t.Errorf("expected %q in generated code", want)
+				}
+			}
+			for _, unwanted := range tt.unexpected {
+				if strings.Contains(code, unwanted) {
+					t.Errorf("unexpected %q in generated code", unwanted)
+				}
+			}
+		})
+	}
+
+	// The check runs once the routes are registered, before the server starts
+	code, err := NewWithOptions(Options{Dev: true}).Generate(file)
+	if err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	check := strings.Index(code, "\tif *selfCheckFlag {")
+	if check < strings.LastIndex(code, "\tmux.HandleFunc(") || check > strings.LastIndex(code, "serveUntilSignal(srv") {
+		t.Error("expected the self-check between the route registration and the server start")
+	}
+}
+
+func TestGenerator_SelfCheckTemplateOnly(t *testing.T) {
+	// Without models nor script, there is no ValidationError to render
+	file := &ast.GMXFile{Template: &ast.TemplateBlock{Source: "<h1>Hello</h1>"}}
+	code, err := NewWithOptions(Options{Dev: true}).Generate(file)
+	if err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	if strings.Contains(code, "ValidationError") {
+		t.Error("unexpected ValidationError in the self-check of a template-only page")
+	}
+	goInModule(t, map[string]string{"main.go": code}, "vet", ".")
+}
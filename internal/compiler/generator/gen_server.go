@@ -0,0 +1,213 @@
+package generator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// serverTimeouts maps the timeout options of the server block to their http.Server field
+var serverTimeouts = []struct {
+	option string
+	field  string
+}{
+	{"readTimeout", "ReadTimeout"},
+	{"writeTimeout", "WriteTimeout"},
+	{"idleTimeout", "IdleTimeout"},
+}
+
+// defaultShutdownTimeout is how long in-flight requests may run once the
+// server stops, without a shutdownTimeout option
+const defaultShutdownTimeout = 10 * time.Second
+
+// serverPort returns the port the server listens on without an environment
+// override: the port option of the server block, or ServerPort
+func serverPort(file *ast.GMXFile) int {
+	if file.Server == nil {
+		return ServerPort
+	}
+	if opt := file.Server.FindOption("port"); opt != nil && opt.Value != "" {
+		if port, err := strconv.Atoi(opt.Value); err == nil {
+			return port
+		}
+	}
+	return ServerPort
+}
+
+// hasStreamWriteTimeout checks if the server block sets a write timeout,
+// which server-sent event streams clear to stay open
+func hasStreamWriteTimeout(file *ast.GMXFile) bool {
+	return file.Server != nil && file.Server.FindOption("writeTimeout") != nil
+}
+
+// genStreamDeadline generates the statement clearing the write deadline of a
+// server-sent event stream, "" when the server has no write timeout
+func genStreamDeadline(file *ast.GMXFile) string {
+	if !hasStreamWriteTimeout(file) {
+		return ""
+	}
+	return "\t// The stream outlives the write timeout of the server\n" +
+		"\thttp.NewResponseController(w).SetWriteDeadline(time.Time{})\n"
+}
+
+// genServerString generates the statements setting a string variable from a
+// server option: its literal value, or its environment variable
+func genServerString(b *strings.Builder, name string, opt *ast.ServerOption, def string) {
+	if opt != nil && opt.Value != "" {
+		def = opt.Value
+	}
+	b.WriteString(fmt.Sprintf("\t%s := %q\n", name, def))
+	if opt == nil || opt.EnvVar == "" {
+		return
+	}
+	b.WriteString(fmt.Sprintf("\tif v := os.Getenv(%q); v != \"\" {\n", opt.EnvVar))
+	b.WriteString(fmt.Sprintf("\t\t%s = v\n", name))
+	if opt.Required {
+		b.WriteString("\t} else {\n")
+		b.WriteString(fmt.Sprintf("\t\tlog.Fatal(\"missing required env var: %s\")\n", opt.EnvVar))
+	}
+	b.WriteString("\t}\n")
+}
+
+// genServerDuration generates the statements setting a duration variable from
+// a server option: its literal value, or its environment variable
+func genServerDuration(b *strings.Builder, name string, opt *ast.ServerOption) {
+	d, _ := time.ParseDuration(opt.Value)
+	if opt.Required {
+		b.WriteString(fmt.Sprintf("\tvar %s time.Duration\n", name))
+	} else {
+		b.WriteString(fmt.Sprintf("\t%s := %s\n", name, goDuration(d)))
+	}
+	if opt.EnvVar == "" {
+		return
+	}
+	b.WriteString(fmt.Sprintf("\tif v := os.Getenv(%q); v != \"\" {\n", opt.EnvVar))
+	b.WriteString("\t\td, err := time.ParseDuration(v)\n")
+	b.WriteString("\t\tif err != nil {\n")
+	b.WriteString(fmt.Sprintf("\t\t\tlog.Fatalf(\"invalid %s: %%v\", err)\n", opt.EnvVar))
+	b.WriteString("\t\t}\n")
+	b.WriteString(fmt.Sprintf("\t\t%s = d\n", name))
+	if opt.Required {
+		b.WriteString("\t} else {\n")
+		b.WriteString(fmt.Sprintf("\t\tlog.Fatal(\"missing required env var: %s\")\n", opt.EnvVar))
+	}
+	b.WriteString("\t}\n")
+}
+
+// genServer generates newServer, which configures the http.Server of the
+// server block, serverTLSFiles for its tls block and serverShutdownTimeout
+// for its shutdownTimeout option
+func (g *Generator) genServer(file *ast.GMXFile) string {
+	var b strings.Builder
+	server := file.Server
+
+	b.WriteString("// newServer configures the HTTP server from the server block\n")
+	b.WriteString("func newServer(handler http.Handler) *http.Server {\n")
+	genServerString(&b, "host", server.FindOption("host"), "")
+	genServerString(&b, "port", server.FindOption("port"), strconv.Itoa(ServerPort))
+	for _, timeout := range serverTimeouts {
+		if opt := server.FindOption(timeout.option); opt != nil {
+			genServerDuration(&b, timeout.option, opt)
+		}
+	}
+	b.WriteString("\treturn &http.Server{\n")
+	b.WriteString("\t\tAddr:    net.JoinHostPort(host, port),\n")
+	b.WriteString("\t\tHandler: handler,\n")
+	for _, timeout := range serverTimeouts {
+		if server.FindOption(timeout.option) != nil {
+			b.WriteString(fmt.Sprintf("\t\t%s: %s,\n", timeout.field, timeout.option))
+		}
+	}
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	if server.TLS != nil {
+		b.WriteString("// serverTLSFiles returns the certificate and key files of the tls block;\n")
+		b.WriteString("// the server falls back to plain HTTP when both are empty\n")
+		b.WriteString("func serverTLSFiles() (string, string) {\n")
+		genServerString(&b, "cert", server.FindTLSOption("cert"), "")
+		genServerString(&b, "key", server.FindTLSOption("key"), "")
+		b.WriteString("\tif (cert == \"\") != (key == \"\") {\n")
+		b.WriteString("\t\tlog.Fatal(\"tls requires both a certificate and a key\")\n")
+		b.WriteString("\t}\n")
+		b.WriteString("\treturn cert, key\n")
+		b.WriteString("}\n\n")
+	}
+
+	if opt := server.FindOption("shutdownTimeout"); opt != nil {
+		b.WriteString("// serverShutdownTimeout returns how long in-flight requests may run once\n")
+		b.WriteString("// the server stops\n")
+		b.WriteString("func serverShutdownTimeout() time.Duration {\n")
+		genServerDuration(&b, "shutdownTimeout", opt)
+		b.WriteString("\treturn shutdownTimeout\n")
+		b.WriteString("}\n\n")
+	}
+
+	return b.String()
+}
+
+// genGracefulShutdown generates serveUntilSignal, which serves until SIGINT
+// or SIGTERM, then lets in-flight requests finish, closes the database and
+// exports the last spans
+func (g *Generator) genGracefulShutdown(file *ast.GMXFile) string {
+	var b strings.Builder
+
+	b.WriteString("// serveUntilSignal runs listen until SIGINT or SIGTERM, then stops accepting\n")
+	b.WriteString("// connections and gives in-flight requests up to timeout to finish; open\n")
+	b.WriteString("// streams are closed at the deadline\n")
+	b.WriteString("func serveUntilSignal(srv *http.Server, listen func() error, timeout time.Duration) {\n")
+	b.WriteString("\tctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)\n")
+	b.WriteString("\tdefer stop()\n")
+	b.WriteString("\tserveErr := make(chan error, 1)\n")
+	b.WriteString("\tgo func() {\n")
+	b.WriteString("\t\tserveErr <- listen()\n")
+	b.WriteString("\t}()\n")
+	b.WriteString("\tselect {\n")
+	b.WriteString("\tcase err := <-serveErr:\n")
+	b.WriteString("\t\tlog.Fatal(err)\n")
+	b.WriteString("\tcase <-ctx.Done():\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\t// A second signal kills the process\n")
+	b.WriteString("\tstop()\n\n")
+	b.WriteString("\tfmt.Println(\"GMX server shutting down\")\n")
+	b.WriteString("\tshutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)\n")
+	b.WriteString("\tdefer cancel()\n")
+	b.WriteString("\tif err := srv.Shutdown(shutdownCtx); err != nil {\n")
+	b.WriteString("\t\tslog.Error(\"shutdown\", \"error\", err)\n")
+	b.WriteString("\t\tsrv.Close()\n")
+	b.WriteString("\t}\n")
+	if g.hasSchedules(file) {
+		b.WriteString("\n\t// Scheduled runs stop first: they may start or queue jobs\n")
+		b.WriteString("\tstopSchedules(shutdownCtx)\n")
+	}
+	if g.hasJobs(file) {
+		b.WriteString("\n\t// Running jobs share the deadline of in-flight requests\n")
+		b.WriteString("\tstopJobs(shutdownCtx)\n")
+	}
+	if g.hasQueuedJobs(file) {
+		b.WriteString("\n\t// Queued jobs run until the deadline, before the database closes\n")
+		b.WriteString("\tdrainJobQueue(shutdownCtx)\n")
+	}
+	if g.hasOutbox(file) {
+		b.WriteString("\n\t// Undelivered events stay in the outbox for the next start\n")
+		b.WriteString("\tstopOutboxRelay(shutdownCtx)\n")
+	}
+	if len(file.Models) > 0 {
+		b.WriteString("\n\t// Closing the database checkpoints the SQLite write-ahead log\n")
+		b.WriteString("\tif sqlDB, err := db.DB(); err == nil {\n")
+		b.WriteString("\t\tif err := sqlDB.Close(); err != nil {\n")
+		b.WriteString("\t\t\tslog.Error(\"closing the database\", \"error\", err)\n")
+		b.WriteString("\t\t}\n")
+		b.WriteString("\t}\n")
+	}
+	if g.hasTracing(file) {
+		b.WriteString("\n\t// The spans of the last requests are exported last\n")
+		b.WriteString("\tflushSpans(shutdownCtx)\n")
+	}
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
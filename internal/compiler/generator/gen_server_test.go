@@ -0,0 +1,97 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+const serverTemplateSrc = `<ul>{{range .Tasks}}<li>{{.Title}}</li>{{end}}</ul>`
+
+func TestGenerateServer(t *testing.T) {
+	file := scriptTestFile(t, `server {
+  port: @env("PORT") @default(3000),
+  readTimeout: 10s,
+  writeTimeout: @env("WRITE_TIMEOUT"),
+  shutdownTimeout: @env("SHUTDOWN_TIMEOUT") @default(30s),
+  tls: { cert: @env("TLS_CERT") @default(""), key: @env("TLS_KEY") @default("") }
+}
+@live
+model Task {
+  id: uuid @pk @default(uuid_v4)
+  title: string
+}`, serverTemplateSrc)
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, want := range []string{
+		"\t\"net\"\n",
+		"func newServer(handler http.Handler) *http.Server {",
+		"port := \"3000\"\n\tif v := os.Getenv(\"PORT\"); v != \"\" {",
+		"readTimeout := 10 * time.Second",
+		"var writeTimeout time.Duration",
+		`log.Fatalf("invalid WRITE_TIMEOUT: %v", err)`,
+		`log.Fatal("missing required env var: WRITE_TIMEOUT")`,
+		"Addr:         net.JoinHostPort(host, port),",
+		"WriteTimeout: writeTimeout,",
+		"func serverTLSFiles() (string, string) {",
+		"srv := newServer(logRequests(csrfProtect(securityHeaders(mux))))",
+		"if cert, key := serverTLSFiles(); cert != \"\" {\n\t\tlisten = func() error { return srv.ListenAndServeTLS(cert, key) }",
+		"func serverShutdownTimeout() time.Duration {\n\tshutdownTimeout := 30 * time.Second",
+		"serveUntilSignal(srv, listen, serverShutdownTimeout())",
+		// Live streams outlive the write timeout
+		"http.NewResponseController(w).SetWriteDeadline(time.Time{})",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+	for _, unwanted := range []string{"IdleTimeout:", "http.ListenAndServe(\":8080\""} {
+		if strings.Contains(code, unwanted) {
+			t.Errorf("generated code should not contain %q", unwanted)
+		}
+	}
+}
+
+func TestGenerateWithoutServer(t *testing.T) {
+	code, err := New().Generate(scriptTestFile(t, "model Task {\n  id: uuid @pk @default(uuid_v4)\n  title: string\n}", serverTemplateSrc))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !strings.Contains(code, `srv := &http.Server{Addr: ":8080", Handler: logRequests(csrfProtect(securityHeaders(mux)))}`) || strings.Contains(code, "newServer") {
+		t.Errorf("files without a server block keep listening on :8080")
+	}
+}
+
+func TestGenerateGracefulShutdown(t *testing.T) {
+	code, err := New().Generate(scriptTestFile(t, "model Task {\n  id: uuid @pk @default(uuid_v4)\n  title: string\n}", serverTemplateSrc))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	for _, want := range []string{
+		"signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)",
+		"shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)",
+		"if err := srv.Shutdown(shutdownCtx); err != nil {",
+		"if sqlDB, err := db.DB(); err == nil {",
+		"serveUntilSignal(srv, srv.ListenAndServe, 10*time.Second)",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+
+	// Without models there is no database to close
+	code, err = New().Generate(&ast.GMXFile{Template: &ast.TemplateBlock{Source: "<h1>Hello</h1>"}})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !strings.Contains(code, "srv.Shutdown(shutdownCtx)") || strings.Contains(code, "db.DB()") {
+		t.Errorf("files without models shut down without closing a database")
+	}
+}
@@ -3,6 +3,7 @@ package generator
 import (
 	"fmt"
 	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/script"
 	"github.com/btouchard/gmx/internal/compiler/utils"
 	"strings"
 )
@@ -10,8 +11,16 @@ import (
 // genServices generates service config structs, init functions, and interfaces
 func (g *Generator) genServices(services []*ast.ServiceDecl) string {
 	var b strings.Builder
+	var hasS3, hasLocal, hasLocalSigned, hasRedis bool
+	traced := g.findTracingService(services) != nil
 
 	for i, svc := range services {
+		if len(svc.Methods) > 0 {
+			hasS3 = hasS3 || svc.Provider == "s3"
+			hasLocal = hasLocal || svc.Provider == "local"
+			hasLocalSigned = hasLocalSigned || svc.Provider == "local" && providerDeclares(svc, "signedUrl")
+		}
+		hasRedis = hasRedis || svc.Provider == "redis"
 		if i > 0 {
 			b.WriteString("\n")
 		}
@@ -28,12 +37,49 @@ func (g *Generator) genServices(services []*ast.ServiceDecl) string {
 			if len(svc.Methods) > 0 {
 				b.WriteString(g.genServiceInterface(svc))
 				b.WriteString("\n")
-				b.WriteString(g.genSMTPImpl(svc))
+				if g.opts.Dev {
+					b.WriteString(g.genDevMailImpl(svc))
+				} else {
+					b.WriteString(g.genSMTPImpl(svc))
+				}
 				b.WriteString("\n")
 			}
 		case "http":
-			b.WriteString(g.genHTTPClient(svc))
+			b.WriteString(g.genHTTPClient(svc, traced))
+			b.WriteString("\n")
+		case "backup":
+			b.WriteString(g.genBackupJob(svc, services))
 			b.WriteString("\n")
+		case "loadshed":
+			b.WriteString(g.genLoadShedder(svc))
+			b.WriteString("\n")
+		case "tracing":
+			b.WriteString(g.genTracingStart(svc))
+			b.WriteString("\n")
+		case "custom":
+			// Hand-written implementation, wired through Register<Name>
+			if len(svc.Methods) > 0 {
+				b.WriteString(g.genServiceInterface(svc))
+				b.WriteString("\n")
+				b.WriteString(g.genServiceRegistration(svc))
+				b.WriteString("\n")
+			}
+		case "s3", "local":
+			if len(svc.Methods) > 0 {
+				b.WriteString(g.genProviderInterface(svc, storageMethods))
+				b.WriteString("\n")
+				if svc.Provider == "s3" {
+					b.WriteString(g.genS3Impl(svc))
+				} else {
+					b.WriteString(g.genLocalStorageImpl(svc))
+				}
+				b.WriteString("\n")
+			}
+		case "redis":
+			b.WriteString(g.genRedisClient(svc))
+			b.WriteString("\n")
+		case "jobs", "db":
+			// Job queue settings, read by jobQueueConfig
 		case "postgres", "sqlite", "mysql":
 			// Database — no interface/stub needed, handled in genMain
 		default:
@@ -47,6 +93,56 @@ func (g *Generator) genServices(services []*ast.ServiceDecl) string {
 		}
 	}
 
+	// Storage helpers are shared by the services of a provider
+	if hasS3 {
+		b.WriteString("\n")
+		b.WriteString(g.genS3Helpers())
+	}
+	if hasLocal {
+		b.WriteString("\n")
+		b.WriteString(g.genLocalStorageHelpers(hasLocalSigned))
+	}
+	if hasRedis {
+		b.WriteString("\n")
+		b.WriteString(g.genCacheHelpers())
+	}
+
+	return b.String()
+}
+
+// scriptServices returns the services whose methods script code calls: those
+// main creates an instance of, HTTP clients aside
+func (g *Generator) scriptServices(file *ast.GMXFile) []*ast.ServiceDecl {
+	if file.Script == nil || len(file.Script.Funcs) == 0 {
+		return nil
+	}
+	var services []*ast.ServiceDecl
+	for _, svc := range file.Services {
+		if len(svc.Methods) > 0 && svc.Provider != "http" {
+			services = append(services, svc)
+		}
+	}
+	return services
+}
+
+// genScriptServiceVars generates the package variables through which script
+// code calls the services
+func (g *Generator) genScriptServiceVars(file *ast.GMXFile) string {
+	var b strings.Builder
+	for _, svc := range g.scriptServices(file) {
+		b.WriteString(fmt.Sprintf("// %s is the %s service called by the script; set at startup\n", script.ServiceVar(svc.Name), svc.Name))
+		b.WriteString(fmt.Sprintf("var %s %sService\n\n", script.ServiceVar(svc.Name), svc.Name))
+	}
+	return b.String()
+}
+
+// scriptServiceAssigns returns the statements of main handing the service
+// instances to the script
+func (g *Generator) scriptServiceAssigns(file *ast.GMXFile) string {
+	var b strings.Builder
+	for _, svc := range g.scriptServices(file) {
+		b.WriteString(fmt.Sprintf("\t%s = %sSvc\n", script.ServiceVar(svc.Name), utils.LowerFirst(svc.Name)))
+	}
 	return b.String()
 }
 
@@ -78,15 +174,29 @@ func (g *Generator) genServiceInit(svc *ast.ServiceDecl) string {
 	b.WriteString(fmt.Sprintf("\t\tProvider: %q,\n", svc.Provider))
 	b.WriteString("\t}\n")
 
-	// Load env vars
+	// Load defaults and env vars; a field with @default is optional
 	for _, field := range svc.Fields {
-		if field.EnvVar != "" {
-			fieldName := utils.ToPascalCase(field.Name)
-			b.WriteString(fmt.Sprintf("\tcfg.%s = os.Getenv(%q)\n", fieldName, field.EnvVar))
-			b.WriteString(fmt.Sprintf("\tif cfg.%s == \"\" {\n", fieldName))
-			b.WriteString(fmt.Sprintf("\t\tlog.Fatal(\"missing required env var: %s\")\n", field.EnvVar))
+		fieldName := utils.ToPascalCase(field.Name)
+		def := serviceFieldDefault(field)
+		if def != nil {
+			b.WriteString(fmt.Sprintf("\tcfg.%s = %q\n", fieldName, *def))
+		}
+		if field.EnvVar == "" {
+			continue
+		}
+		if def != nil {
+			b.WriteString(fmt.Sprintf("\tif v := os.Getenv(%q); v != \"\" {\n", field.EnvVar))
+			b.WriteString(fmt.Sprintf("\t\tcfg.%s = v\n", fieldName))
 			b.WriteString("\t}\n")
+			continue
+		}
+		b.WriteString(fmt.Sprintf("\tcfg.%s = os.Getenv(%q)\n", fieldName, field.EnvVar))
+		if g.opts.Dev && svc.Provider == "smtp" {
+			continue // the dev mail catcher needs no SMTP credentials
 		}
+		b.WriteString(fmt.Sprintf("\tif cfg.%s == \"\" {\n", fieldName))
+		b.WriteString(fmt.Sprintf("\t\tlog.Fatal(\"missing required env var: %s\")\n", field.EnvVar))
+		b.WriteString("\t}\n")
 	}
 
 	b.WriteString("\treturn cfg\n")
@@ -94,6 +204,17 @@ func (g *Generator) genServiceInit(svc *ast.ServiceDecl) string {
 	return b.String()
 }
 
+// serviceFieldDefault returns the value of a service field's @default annotation, if any
+func serviceFieldDefault(field *ast.ServiceField) *string {
+	for _, ann := range field.Annotations {
+		if ann.Name == "default" {
+			def := strings.Trim(ann.SimpleArg(), "\"")
+			return &def
+		}
+	}
+	return nil
+}
+
 // genServiceInterface generates the interface for a service with methods
 func (g *Generator) genServiceInterface(svc *ast.ServiceDecl) string {
 	var b strings.Builder
@@ -131,15 +252,19 @@ func (g *Generator) genServiceInterface(svc *ast.ServiceDecl) string {
 func (g *Generator) genServiceStub(svc *ast.ServiceDecl) string {
 	var b strings.Builder
 
-	stubName := strings.ToLower(svc.Name[:1]) + svc.Name[1:] + "Stub"
+	stubName := utils.LowerFirst(svc.Name) + "Stub"
 
 	b.WriteString(fmt.Sprintf("// %s is a stub implementation of %sService\n", stubName, svc.Name))
 	b.WriteString(fmt.Sprintf("type %s struct {\n", stubName))
 	b.WriteString(fmt.Sprintf("\tconfig *%sConfig\n", svc.Name))
 	b.WriteString("}\n\n")
 
-	// Generate stub methods
+	// Generate stub methods; methods with a script body get their transpiled implementation
 	for _, method := range svc.Methods {
+		if method.Body != nil {
+			b.WriteString(g.genScriptServiceMethod("s", stubName, method))
+			continue
+		}
 		methodName := utils.ToPascalCase(method.Name)
 		b.WriteString(fmt.Sprintf("func (s *%s) %s(", stubName, methodName))
 
@@ -159,7 +284,7 @@ func (g *Generator) genServiceStub(svc *ast.ServiceDecl) string {
 		}
 
 		b.WriteString(" {\n")
-		b.WriteString(fmt.Sprintf("\tlog.Printf(\"[%%s] %s.%s called (stub)\", s.config.Provider)\n", svc.Name, methodName))
+		b.WriteString(fmt.Sprintf("\tslog.Info(\"%s.%s called (stub)\", \"provider\", s.config.Provider)\n", svc.Name, methodName))
 
 		// Return appropriate zero value
 		if method.ReturnType != "" {
@@ -208,11 +333,37 @@ func fieldExists(svc *ast.ServiceDecl, name string) bool {
 	return false
 }
 
+// genServiceRegistration generates the registration point of a custom service,
+// whose implementation is hand-written Go code outside the generated file
+func (g *Generator) genServiceRegistration(svc *ast.ServiceDecl) string {
+	var b strings.Builder
+
+	implVar := utils.LowerFirst(svc.Name) + "Impl"
+
+	b.WriteString(fmt.Sprintf("// %s is the %sService registered with Register%s\n", implVar, svc.Name, svc.Name))
+	b.WriteString(fmt.Sprintf("var %s %sService\n\n", implVar, svc.Name))
+
+	b.WriteString(fmt.Sprintf("// Register%s wires a hand-written %sService; call it from an init function\n", svc.Name, svc.Name))
+	b.WriteString(fmt.Sprintf("func Register%s(impl %sService) {\n", svc.Name, svc.Name))
+	b.WriteString(fmt.Sprintf("\t%s = impl\n", implVar))
+	b.WriteString("}\n\n")
+
+	b.WriteString(fmt.Sprintf("// new%sService returns the registered %sService\n", svc.Name, svc.Name))
+	b.WriteString(fmt.Sprintf("func new%sService(cfg *%sConfig) %sService {\n", svc.Name, svc.Name, svc.Name))
+	b.WriteString(fmt.Sprintf("\tif %s == nil {\n", implVar))
+	b.WriteString(fmt.Sprintf("\t\tlog.Fatal(\"service %s: no implementation registered (call Register%s from an init function)\")\n", svc.Name, svc.Name))
+	b.WriteString("\t}\n")
+	b.WriteString(fmt.Sprintf("\treturn %s\n", implVar))
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
 // genSMTPImpl generates the SMTP implementation for a mailer service
 func (g *Generator) genSMTPImpl(svc *ast.ServiceDecl) string {
 	var b strings.Builder
 
-	implName := strings.ToLower(svc.Name[:1]) + svc.Name[1:] + "Impl"
+	implName := utils.LowerFirst(svc.Name) + "Impl"
 
 	b.WriteString(fmt.Sprintf("// %s is an SMTP implementation of %sService\n", implName, svc.Name))
 	b.WriteString(fmt.Sprintf("type %s struct {\n", implName))
@@ -221,6 +372,10 @@ func (g *Generator) genSMTPImpl(svc *ast.ServiceDecl) string {
 
 	// Generate Send method (assuming it's the standard mailer signature)
 	for _, method := range svc.Methods {
+		if method.Body != nil {
+			b.WriteString(g.genScriptServiceMethod("m", implName, method))
+			continue
+		}
 		if method.Name == "send" {
 			methodName := utils.ToPascalCase(method.Name)
 			b.WriteString(fmt.Sprintf("func (m *%s) %s(", implName, methodName))
@@ -284,8 +439,9 @@ func (g *Generator) genSMTPImpl(svc *ast.ServiceDecl) string {
 	return b.String()
 }
 
-// genHTTPClient generates an HTTP client for external API services
-func (g *Generator) genHTTPClient(svc *ast.ServiceDecl) string {
+// genHTTPClient generates an HTTP client for external API services; traced
+// clients send their requests in the spans of the requests making them
+func (g *Generator) genHTTPClient(svc *ast.ServiceDecl, traced bool) string {
 	var b strings.Builder
 
 	clientName := svc.Name + "Client"
@@ -301,14 +457,27 @@ func (g *Generator) genHTTPClient(svc *ast.ServiceDecl) string {
 	b.WriteString(fmt.Sprintf("func new%sClient(cfg *%sConfig) *%s {\n", svc.Name, svc.Name, clientName))
 	b.WriteString(fmt.Sprintf("\treturn &%s{\n", clientName))
 	b.WriteString("\t\tconfig: cfg,\n")
-	b.WriteString("\t\thttp:   &http.Client{Timeout: 30 * time.Second},\n")
+	if g.opts.Dev {
+		// Dev builds inject the faults set at chaosPath
+		transport := tracingTransportOf(svc, fmt.Sprintf("&chaosTransport{service: %q, next: http.DefaultTransport}", svc.Name), traced)
+		b.WriteString(fmt.Sprintf("\t\thttp:   &http.Client{Timeout: 30 * time.Second, Transport: %s},\n", transport))
+	} else if traced {
+		b.WriteString(fmt.Sprintf("\t\thttp:   &http.Client{Timeout: 30 * time.Second, Transport: %s},\n", tracingTransportOf(svc, "http.DefaultTransport", traced)))
+	} else {
+		b.WriteString("\t\thttp:   &http.Client{Timeout: 30 * time.Second},\n")
+	}
 	b.WriteString("\t}\n")
 	b.WriteString("}\n\n")
 
 	// GET method
 	b.WriteString(fmt.Sprintf("// Get makes a GET request to the API\n"))
 	b.WriteString(fmt.Sprintf("func (c *%s) Get(path string) (*http.Response, error) {\n", clientName))
-	b.WriteString("\treq, err := http.NewRequest(\"GET\", c.config.BaseUrl+path, nil)\n")
+	b.WriteString("\treturn c.GetContext(context.Background(), path)\n")
+	b.WriteString("}\n\n")
+	b.WriteString("// GetContext makes a GET request to the API, cancelled with ctx: pass\n")
+	b.WriteString("// ctx.Request.Context() to honor the deadline of a @timeout handler\n")
+	b.WriteString(fmt.Sprintf("func (c *%s) GetContext(ctx context.Context, path string) (*http.Response, error) {\n", clientName))
+	b.WriteString("\treq, err := http.NewRequestWithContext(ctx, \"GET\", c.config.BaseUrl+path, nil)\n")
 	b.WriteString("\tif err != nil {\n")
 	b.WriteString("\t\treturn nil, err\n")
 	b.WriteString("\t}\n")
@@ -326,7 +495,11 @@ func (g *Generator) genHTTPClient(svc *ast.ServiceDecl) string {
 	// POST method
 	b.WriteString(fmt.Sprintf("// Post makes a POST request to the API\n"))
 	b.WriteString(fmt.Sprintf("func (c *%s) Post(path string, body io.Reader) (*http.Response, error) {\n", clientName))
-	b.WriteString("\treq, err := http.NewRequest(\"POST\", c.config.BaseUrl+path, body)\n")
+	b.WriteString("\treturn c.PostContext(context.Background(), path, body)\n")
+	b.WriteString("}\n\n")
+	b.WriteString("// PostContext makes a POST request to the API, cancelled with ctx\n")
+	b.WriteString(fmt.Sprintf("func (c *%s) PostContext(ctx context.Context, path string, body io.Reader) (*http.Response, error) {\n", clientName))
+	b.WriteString("\treq, err := http.NewRequestWithContext(ctx, \"POST\", c.config.BaseUrl+path, body)\n")
 	b.WriteString("\tif err != nil {\n")
 	b.WriteString("\t\treturn nil, err\n")
 	b.WriteString("\t}\n")
@@ -342,5 +515,40 @@ func (g *Generator) genHTTPClient(svc *ast.ServiceDecl) string {
 	b.WriteString("\treturn c.http.Do(req)\n")
 	b.WriteString("}\n")
 
+	for _, method := range svc.Methods {
+		if method.Body != nil {
+			b.WriteString("\n")
+			b.WriteString(g.genScriptServiceMethod("c", clientName, method))
+		}
+	}
+
+	return b.String()
+}
+
+// genScriptServiceMethod generates a service method whose body is written in GMX Script;
+// `config` in the body refers to the receiver's configuration
+func (g *Generator) genScriptServiceMethod(recv, typeName string, method *ast.ServiceMethod) string {
+	var b strings.Builder
+
+	returnType := ""
+	if method.ReturnType != "" {
+		returnType = g.mapType(method.ReturnType)
+	}
+
+	b.WriteString(fmt.Sprintf("func (%s *%s) %s(", recv, typeName, utils.ToPascalCase(method.Name)))
+	for i, param := range method.Params {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(fmt.Sprintf("%s %s", param.Name, g.mapType(param.Type)))
+	}
+	b.WriteString(")")
+	if returnType != "" {
+		b.WriteString(" " + returnType)
+	}
+	b.WriteString(" {\n")
+	b.WriteString(script.TranspileServiceMethod(method, returnType, recv+".config"))
+	b.WriteString("}\n\n")
+
 	return b.String()
 }
@@ -0,0 +1,630 @@
+package generator
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// findSessionService returns the service using the "session" provider, if any
+func (g *Generator) findSessionService(services []*ast.ServiceDecl) *ast.ServiceDecl {
+	for _, svc := range services {
+		if svc.Provider == "session" {
+			return svc
+		}
+	}
+	return nil
+}
+
+// findServiceField returns the named config field of a service, if declared
+func findServiceField(svc *ast.ServiceDecl, name string) *ast.ServiceField {
+	for _, field := range svc.Fields {
+		if field.Name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+// hasImpersonation checks if the session service enables admin impersonation,
+// which is the case when it declares an `admins` field
+func (g *Generator) hasImpersonation(file *ast.GMXFile) bool {
+	svc := g.findSessionService(file.Services)
+	return svc != nil && findServiceField(svc, "admins") != nil
+}
+
+// sessionModel is the generated model storing the sessions of the database store
+const sessionModel = "Session"
+
+// defaultSessionTTL is the inactivity after which a stored session expires,
+// and the lifetime of a session cookie, when the session service declares no ttl
+const defaultSessionTTL = "24 * time.Hour"
+
+// sessionStores are the places a session service keeps its sessions: the
+// signed cookie itself, or the server under a random ID the cookie carries
+var sessionStores = []string{"cookie", "memory", "database", "redis"}
+
+// sessionStore returns where a session service keeps its sessions, chosen at
+// build time by its store field: store: string @default("redis")
+func sessionStore(svc *ast.ServiceDecl) string {
+	if field := findServiceField(svc, "store"); field != nil {
+		if def := serviceFieldDefault(field); def != nil {
+			return *def
+		}
+	}
+	return "cookie"
+}
+
+// sessionStoreOf returns the session store of a file, or "" without a session service
+func (g *Generator) sessionStoreOf(file *ast.GMXFile) string {
+	if svc := g.findSessionService(file.Services); svc != nil {
+		return sessionStore(svc)
+	}
+	return ""
+}
+
+// hasServerSessions checks if the sessions are kept on the server rather
+// than in their cookie
+func (g *Generator) hasServerSessions(file *ast.GMXFile) bool {
+	store := g.sessionStoreOf(file)
+	return store != "" && store != "cookie"
+}
+
+// validateSessionService checks that a session service can sign its cookies
+// and reach its store
+func (g *Generator) validateSessionService(file *ast.GMXFile) error {
+	svc := g.findSessionService(file.Services)
+	if svc == nil {
+		return nil
+	}
+	secret := findServiceField(svc, "secret")
+	if secret == nil || secret.EnvVar == "" {
+		return fmt.Errorf("service %s: session provider requires a `secret: string @env(...)` field", svc.Name)
+	}
+
+	store := sessionStore(svc)
+	if field := findServiceField(svc, "store"); field != nil && (field.EnvVar != "" || serviceFieldDefault(field) == nil) {
+		return fmt.Errorf("service %s: the session store is chosen at build time: declare `store: string @default(\"redis\")` without @env", svc.Name)
+	}
+	if !slices.Contains(sessionStores, store) {
+		return fmt.Errorf("service %s: unknown session store %q (expected %s)", svc.Name, store, strings.Join(sessionStores, ", "))
+	}
+	if store == "redis" && findServiceField(svc, "url") == nil {
+		return fmt.Errorf("service %s: the redis session store requires a `url` field", svc.Name)
+	}
+	if store == "database" {
+		for _, model := range file.Models {
+			if model.Name == sessionModel {
+				return fmt.Errorf("line %d: model %s collides with the model storing the sessions; rename it", model.Line, model.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// withSessionModel returns the file with the model storing the sessions of
+// the database store, so that it is declared and migrated like the others
+func (g *Generator) withSessionModel(file *ast.GMXFile) *ast.GMXFile {
+	if g.sessionStoreOf(file) != "database" {
+		return file
+	}
+	fields := []*ast.FieldDecl{
+		{Name: "id", Type: "string", Annotations: []*ast.Annotation{
+			{Name: "pk", Args: map[string]string{}},
+		}},
+		{Name: "user", Type: "string"},
+		{Name: "impersonator", Type: "string"},
+		{Name: "reason", Type: "string"},
+	}
+	if g.hasTwoFactor(file) {
+		fields = append(fields, &ast.FieldDecl{Name: "pending", Type: "string"}, &ast.FieldDecl{Name: "verified", Type: "bool"})
+	}
+	fields = append(fields, &ast.FieldDecl{Name: "expiresAt", Type: "datetime", Annotations: []*ast.Annotation{
+		{Name: "index", Args: map[string]string{}},
+	}})
+	withModel := *file
+	withModel.Models = append(append([]*ast.ModelDecl{}, file.Models...), &ast.ModelDecl{Name: sessionModel, Fields: fields})
+	return &withModel
+}
+
+// credentialModels returns the generated models holding session IDs, second
+// factors, pending account deletions and accepted terms, which pages never load
+func (g *Generator) credentialModels(file *ast.GMXFile) map[string]bool {
+	models := map[string]bool{}
+	if g.sessionStoreOf(file) == "database" {
+		models[sessionModel] = true
+	}
+	if g.hasTwoFactor(file) {
+		models[twoFactorModel] = true
+		models[backupCodeModel] = true
+	}
+	if g.hasAccounts(file) {
+		models[accountDeletionModel] = true
+	}
+	if g.hasConsent(file) {
+		models[consentModel] = true
+	}
+	return models
+}
+
+// genSessionHelpers generates the session cookie used to populate ctx.User,
+// holding the signed session or the signed ID of a session kept by the server;
+// with accounts, the sessions of accounts pending deletion read as empty;
+// hold holds the database during a cleanup of the database store
+func (g *Generator) genSessionHelpers(svc *ast.ServiceDecl, withImpersonation, accounts bool, hold string) string {
+	var b strings.Builder
+	store := sessionStore(svc)
+	twoFactor := sessionTwoFactor(svc)
+
+	b.WriteString("// sessionSecret signs the session cookie; set by configure" + svc.Name + "\n")
+	b.WriteString("var sessionSecret []byte\n\n")
+
+	if withImpersonation {
+		b.WriteString("// sessionAdmins lists the user IDs allowed to impersonate other users\n")
+		b.WriteString("var sessionAdmins = map[string]bool{}\n\n")
+	}
+
+	if store == "cookie" {
+		b.WriteString("// sessionTTL is the lifetime of a session cookie from its sign-in, even in\n")
+		b.WriteString("// a browser never closed\n")
+		b.WriteString(fmt.Sprintf("var sessionTTL = %s\n\n", defaultSessionTTL))
+	} else {
+		b.WriteString("// sessionTTL is the inactivity after which a stored session expires; each\n")
+		b.WriteString("// request reading the session extends it\n")
+		b.WriteString(fmt.Sprintf("var sessionTTL = %s\n\n", defaultSessionTTL))
+		b.WriteString(fmt.Sprintf("// sessions keeps the sessions of the %s service\n", svc.Name))
+		b.WriteString("var sessions sessionStore\n\n")
+	}
+
+	b.WriteString(fmt.Sprintf("// configure%s loads the session settings from the %s service\n", svc.Name, svc.Name))
+	b.WriteString(fmt.Sprintf("func configure%s(cfg *%sConfig) {\n", svc.Name, svc.Name))
+	b.WriteString("\tsessionSecret = []byte(cfg.Secret)\n")
+	if withImpersonation {
+		b.WriteString("\tfor _, id := range strings.Split(cfg.Admins, \",\") {\n")
+		b.WriteString("\t\tif id = strings.TrimSpace(id); id != \"\" {\n")
+		b.WriteString("\t\t\tsessionAdmins[id] = true\n")
+		b.WriteString("\t\t}\n")
+		b.WriteString("\t}\n")
+	}
+	if findServiceField(svc, "ttl") != nil {
+		b.WriteString("\tttl, err := time.ParseDuration(cfg.Ttl)\n")
+		b.WriteString("\tif err != nil || ttl <= 0 {\n")
+		b.WriteString(fmt.Sprintf("\t\tlog.Fatalf(\"service %s: invalid session ttl %%q: expected a duration such as 24h\", cfg.Ttl)\n", svc.Name))
+		b.WriteString("\t}\n")
+		b.WriteString("\tsessionTTL = ttl\n")
+	}
+	if twoFactor {
+		b.WriteString("\t// The issuer prefixes the account name in authenticator apps\n")
+		b.WriteString("\tif cfg.Issuer == \"\" || strings.Contains(cfg.Issuer, \":\") {\n")
+		b.WriteString(fmt.Sprintf("\t\tlog.Fatalf(\"service %s: invalid two-factor issuer %%q: expected a name without a colon\", cfg.Issuer)\n", svc.Name))
+		b.WriteString("\t}\n")
+		b.WriteString("\ttwoFactorIssuer = cfg.Issuer\n")
+	}
+	b.WriteString(genLockoutConfig(svc))
+	b.WriteString(genAccountConfig(svc))
+	b.WriteString(genConsentConfig(svc))
+	switch store {
+	case "memory":
+		b.WriteString("\tsessions = &memorySessionStore{sessions: map[string]memorySession{}}\n")
+	case "database":
+		b.WriteString("\tsessions = databaseSessionStore{}\n")
+	case "redis":
+		b.WriteString("\topts, err := redis.ParseURL(cfg.Url)\n")
+		b.WriteString("\tif err != nil {\n")
+		b.WriteString(fmt.Sprintf("\t\tlog.Fatalf(\"service %s: invalid Redis URL: %%v\", err)\n", svc.Name))
+		b.WriteString("\t}\n")
+		b.WriteString("\tsessions = redisSessionStore{client: redis.NewClient(opts)}\n")
+	}
+	b.WriteString("}\n\n")
+
+	b.WriteString("// gmxSession is the content of the session\n")
+	b.WriteString("type gmxSession struct {\n")
+	b.WriteString("\tUser         string // effective user, exposed as ctx.User\n")
+	b.WriteString("\tImpersonator string // admin acting as User, empty outside impersonation\n")
+	b.WriteString("\tReason       string // audited reason given when impersonation started\n")
+	if twoFactor {
+		b.WriteString("\tPending      string // user who signed in, until they enter their second factor\n")
+		b.WriteString("\tVerified     bool   // the session entered a second factor, as @require2fa requires\n")
+	}
+	b.WriteString("}\n\n")
+
+	b.WriteString("// encodeSession encodes a session as a query string\n")
+	b.WriteString("func encodeSession(s gmxSession) string {\n")
+	if twoFactor {
+		b.WriteString("\tvalues := url.Values{\"u\": {s.User}, \"i\": {s.Impersonator}, \"r\": {s.Reason}, \"p\": {s.Pending}}\n")
+		b.WriteString("\tif s.Verified {\n")
+		b.WriteString("\t\tvalues.Set(\"v\", \"1\")\n")
+		b.WriteString("\t}\n")
+		b.WriteString("\treturn values.Encode()\n")
+	} else {
+		b.WriteString("\treturn url.Values{\"u\": {s.User}, \"i\": {s.Impersonator}, \"r\": {s.Reason}}.Encode()\n")
+	}
+	b.WriteString("}\n\n")
+
+	b.WriteString("// decodeSession decodes a session encoded by encodeSession, returning an empty session if it is malformed\n")
+	b.WriteString("func decodeSession(raw string) gmxSession {\n")
+	b.WriteString("\tvalues, err := url.ParseQuery(raw)\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\treturn gmxSession{}\n")
+	b.WriteString("\t}\n")
+	if twoFactor {
+		b.WriteString("\treturn gmxSession{User: values.Get(\"u\"), Impersonator: values.Get(\"i\"), Reason: values.Get(\"r\"), Pending: values.Get(\"p\"), Verified: values.Get(\"v\") == \"1\"}\n")
+	} else {
+		b.WriteString("\treturn gmxSession{User: values.Get(\"u\"), Impersonator: values.Get(\"i\"), Reason: values.Get(\"r\")}\n")
+	}
+	b.WriteString("}\n\n")
+
+	b.WriteString("// signSession returns the HMAC signature of an encoded session payload\n")
+	b.WriteString("func signSession(payload string) string {\n")
+	b.WriteString("\tmac := hmac.New(sha256.New, sessionSecret)\n")
+	b.WriteString("\tmac.Write([]byte(payload))\n")
+	b.WriteString("\treturn hex.EncodeToString(mac.Sum(nil))\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// sessionPayload returns the payload of the session cookie, or \"\" if it is missing or tampered with\n")
+	b.WriteString("func sessionPayload(r *http.Request) string {\n")
+	b.WriteString("\tcookie, err := r.Cookie(\"gmx_session\")\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\treturn \"\"\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tpayload, sig, ok := strings.Cut(cookie.Value, \".\")\n")
+	b.WriteString("\tif !ok || !hmac.Equal([]byte(sig), []byte(signSession(payload))) {\n")
+	b.WriteString("\t\treturn \"\"\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn payload\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// setSessionCookie stores a payload in a signed, HTTP-only cookie\n")
+	b.WriteString("func setSessionCookie(w http.ResponseWriter, payload string) {\n")
+	b.WriteString("\thttp.SetCookie(w, &http.Cookie{\n")
+	b.WriteString("\t\tName:     \"gmx_session\",\n")
+	b.WriteString("\t\tValue:    payload + \".\" + signSession(payload),\n")
+	b.WriteString("\t\tPath:     \"/\",\n")
+	b.WriteString("\t\tHttpOnly: true,\n")
+	b.WriteString("\t\tSameSite: http.SameSiteLaxMode,\n")
+	b.WriteString("\t})\n")
+	b.WriteString("}\n\n")
+
+	if store == "cookie" {
+		b.WriteString("// readSession decodes the session cookie, returning an empty session if it is missing,\n")
+		b.WriteString("// tampered with or past its expiry\n")
+		b.WriteString("func readSession(r *http.Request) gmxSession {\n")
+		b.WriteString("\traw, err := base64.RawURLEncoding.DecodeString(sessionPayload(r))\n")
+		b.WriteString("\tif err != nil {\n")
+		b.WriteString("\t\treturn gmxSession{}\n")
+		b.WriteString("\t}\n")
+		b.WriteString("\t// The expiry is signed with the session: a copied cookie stops working with it\n")
+		b.WriteString("\tvalues, err := url.ParseQuery(string(raw))\n")
+		b.WriteString("\tif err != nil {\n")
+		b.WriteString("\t\treturn gmxSession{}\n")
+		b.WriteString("\t}\n")
+		b.WriteString("\tif expires, err := strconv.ParseInt(values.Get(\"e\"), 10, 64); err != nil || time.Now().Unix() > expires {\n")
+		b.WriteString("\t\treturn gmxSession{}\n")
+		b.WriteString("\t}\n")
+		if accounts {
+			b.WriteString("\treturn activeSession(decodeSession(string(raw)))\n")
+		} else {
+			b.WriteString("\treturn decodeSession(string(raw))\n")
+		}
+		b.WriteString("}\n\n")
+
+		b.WriteString("// writeSession stores the session in the signed cookie, until sessionTTL from now\n")
+		b.WriteString("func writeSession(w http.ResponseWriter, s gmxSession) {\n")
+		b.WriteString("\traw := encodeSession(s) + \"&e=\" + strconv.FormatInt(time.Now().Add(sessionTTL).Unix(), 10)\n")
+		b.WriteString("\tsetSessionCookie(w, base64.RawURLEncoding.EncodeToString([]byte(raw)))\n")
+		b.WriteString("}\n\n")
+	} else {
+		b.WriteString(g.genSessionStore(store, twoFactor, accounts, hold))
+	}
+
+	b.WriteString("// clearSession removes the session cookie\n")
+	b.WriteString("func clearSession(w http.ResponseWriter) {\n")
+	b.WriteString("\thttp.SetCookie(w, &http.Cookie{Name: \"gmx_session\", Value: \"\", Path: \"/\", MaxAge: -1, HttpOnly: true})\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// genSessionStore generates the sessions kept by the server: the store
+// interface, its implementation, and the session cookie reading and writing
+// the signed ID of a session
+func (g *Generator) genSessionStore(store string, twoFactor, accounts bool, hold string) string {
+	var b strings.Builder
+
+	b.WriteString("// sessionStore keeps the sessions under their random ID; Load extends a\n")
+	b.WriteString("// session by ttl, so that it expires after ttl without requests\n")
+	b.WriteString("type sessionStore interface {\n")
+	b.WriteString("\tLoad(id string, ttl time.Duration) (gmxSession, bool, error)\n")
+	b.WriteString("\tSave(id string, s gmxSession, ttl time.Duration) error\n")
+	b.WriteString("\tDelete(id string) error\n")
+	b.WriteString("}\n\n")
+
+	switch store {
+	case "memory":
+		b.WriteString("// memorySessionStore keeps the sessions in memory: they are lost on restart\n")
+		b.WriteString("// and not shared between instances\n")
+		b.WriteString("type memorySessionStore struct {\n")
+		b.WriteString("\tmu       sync.Mutex\n")
+		b.WriteString("\tsessions map[string]memorySession\n")
+		b.WriteString("}\n\n")
+		b.WriteString("// memorySession is a session of the memory store with its expiry\n")
+		b.WriteString("type memorySession struct {\n")
+		b.WriteString("\tsession gmxSession\n")
+		b.WriteString("\texpires time.Time\n")
+		b.WriteString("}\n\n")
+		b.WriteString("func (m *memorySessionStore) Load(id string, ttl time.Duration) (gmxSession, bool, error) {\n")
+		b.WriteString("\tm.mu.Lock()\n")
+		b.WriteString("\tdefer m.mu.Unlock()\n")
+		b.WriteString("\tentry, ok := m.sessions[id]\n")
+		b.WriteString("\tif !ok || time.Now().After(entry.expires) {\n")
+		b.WriteString("\t\tdelete(m.sessions, id)\n")
+		b.WriteString("\t\treturn gmxSession{}, false, nil\n")
+		b.WriteString("\t}\n")
+		b.WriteString("\tentry.expires = time.Now().Add(ttl)\n")
+		b.WriteString("\tm.sessions[id] = entry\n")
+		b.WriteString("\treturn entry.session, true, nil\n")
+		b.WriteString("}\n\n")
+		b.WriteString("func (m *memorySessionStore) Save(id string, s gmxSession, ttl time.Duration) error {\n")
+		b.WriteString("\tm.mu.Lock()\n")
+		b.WriteString("\tdefer m.mu.Unlock()\n")
+		b.WriteString("\tnow := time.Now()\n")
+		b.WriteString("\t// Expired sessions are swept as new ones open\n")
+		b.WriteString("\tfor key, entry := range m.sessions {\n")
+		b.WriteString("\t\tif now.After(entry.expires) {\n")
+		b.WriteString("\t\t\tdelete(m.sessions, key)\n")
+		b.WriteString("\t\t}\n")
+		b.WriteString("\t}\n")
+		b.WriteString("\tm.sessions[id] = memorySession{session: s, expires: now.Add(ttl)}\n")
+		b.WriteString("\treturn nil\n")
+		b.WriteString("}\n\n")
+		b.WriteString("func (m *memorySessionStore) Delete(id string) error {\n")
+		b.WriteString("\tm.mu.Lock()\n")
+		b.WriteString("\tdefer m.mu.Unlock()\n")
+		b.WriteString("\tdelete(m.sessions, id)\n")
+		b.WriteString("\treturn nil\n")
+		b.WriteString("}\n\n")
+	case "database":
+		b.WriteString("// databaseSessionStore keeps the sessions in the database, shared between instances\n")
+		b.WriteString("type databaseSessionStore struct{}\n\n")
+		b.WriteString("func (databaseSessionStore) Load(id string, ttl time.Duration) (gmxSession, bool, error) {\n")
+		b.WriteString("\tvar row Session\n")
+		b.WriteString("\tif err := db.Where(\"id = ? AND expires_at > ?\", id, time.Now()).Limit(1).Find(&row).Error; err != nil || row.ID == \"\" {\n")
+		b.WriteString("\t\treturn gmxSession{}, false, err\n")
+		b.WriteString("\t}\n")
+		b.WriteString("\t// The expiry is written once it moves by a tenth of ttl, not on every request\n")
+		b.WriteString("\tif expires := time.Now().Add(ttl); expires.Sub(row.ExpiresAt) > ttl/10 {\n")
+		b.WriteString("\t\tif err := db.Model(&Session{}).Where(\"id = ?\", id).Update(\"expires_at\", expires).Error; err != nil {\n")
+		b.WriteString("\t\t\treturn gmxSession{}, false, err\n")
+		b.WriteString("\t\t}\n")
+		b.WriteString("\t}\n")
+		if twoFactor {
+			b.WriteString("\treturn gmxSession{User: row.User, Impersonator: row.Impersonator, Reason: row.Reason, Pending: row.Pending, Verified: row.Verified}, true, nil\n")
+		} else {
+			b.WriteString("\treturn gmxSession{User: row.User, Impersonator: row.Impersonator, Reason: row.Reason}, true, nil\n")
+		}
+		b.WriteString("}\n\n")
+		b.WriteString("func (databaseSessionStore) Save(id string, s gmxSession, ttl time.Duration) error {\n")
+		if twoFactor {
+			b.WriteString("\treturn db.Create(&Session{ID: id, User: s.User, Impersonator: s.Impersonator, Reason: s.Reason, Pending: s.Pending, Verified: s.Verified, ExpiresAt: time.Now().Add(ttl)}).Error\n")
+		} else {
+			b.WriteString("\treturn db.Create(&Session{ID: id, User: s.User, Impersonator: s.Impersonator, Reason: s.Reason, ExpiresAt: time.Now().Add(ttl)}).Error\n")
+		}
+		b.WriteString("}\n\n")
+		b.WriteString("func (databaseSessionStore) Delete(id string) error {\n")
+		b.WriteString("\treturn db.Where(\"id = ?\", id).Delete(&Session{}).Error\n")
+		b.WriteString("}\n\n")
+		b.WriteString("// cleanupSessions deletes the expired sessions, hourly\n")
+		b.WriteString("func cleanupSessions() {\n")
+		b.WriteString("\tfor {\n")
+		b.WriteString("\t\tdeleteExpiredSessions()\n")
+		b.WriteString("\t\ttime.Sleep(time.Hour)\n")
+		b.WriteString("\t}\n")
+		b.WriteString("}\n\n")
+		b.WriteString("// deleteExpiredSessions deletes the sessions past their expiry\n")
+		b.WriteString("func deleteExpiredSessions() {\n")
+		b.WriteString(hold)
+		b.WriteString("\tif err := db.Where(\"expires_at < ?\", time.Now()).Delete(&Session{}).Error; err != nil {\n")
+		b.WriteString("\t\tslog.Error(\"session: deleting expired sessions\", \"error\", err)\n")
+		b.WriteString("\t}\n")
+		b.WriteString("}\n\n")
+	case "redis":
+		b.WriteString("// redisSessionStore keeps the sessions in Redis, shared between instances,\n")
+		b.WriteString("// which expires them\n")
+		b.WriteString("type redisSessionStore struct {\n")
+		b.WriteString("\tclient *redis.Client\n")
+		b.WriteString("}\n\n")
+		b.WriteString("func (s redisSessionStore) Load(id string, ttl time.Duration) (gmxSession, bool, error) {\n")
+		b.WriteString("\traw, err := s.client.GetEx(context.Background(), \"gmx:session:\"+id, ttl).Result()\n")
+		b.WriteString("\tif errors.Is(err, redis.Nil) {\n")
+		b.WriteString("\t\treturn gmxSession{}, false, nil\n")
+		b.WriteString("\t}\n")
+		b.WriteString("\tif err != nil {\n")
+		b.WriteString("\t\treturn gmxSession{}, false, err\n")
+		b.WriteString("\t}\n")
+		b.WriteString("\treturn decodeSession(raw), true, nil\n")
+		b.WriteString("}\n\n")
+		b.WriteString("func (s redisSessionStore) Save(id string, session gmxSession, ttl time.Duration) error {\n")
+		b.WriteString("\treturn s.client.Set(context.Background(), \"gmx:session:\"+id, encodeSession(session), ttl).Err()\n")
+		b.WriteString("}\n\n")
+		b.WriteString("func (s redisSessionStore) Delete(id string) error {\n")
+		b.WriteString("\treturn s.client.Del(context.Background(), \"gmx:session:\"+id).Err()\n")
+		b.WriteString("}\n\n")
+	}
+
+	b.WriteString("// readSession loads the session whose ID the cookie carries, extending it,\n")
+	b.WriteString("// or returns an empty session if it is missing, tampered with or expired\n")
+	b.WriteString("func readSession(r *http.Request) gmxSession {\n")
+	b.WriteString("\tid := sessionPayload(r)\n")
+	b.WriteString("\tif id == \"\" {\n")
+	b.WriteString("\t\treturn gmxSession{}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\ts, ok, err := sessions.Load(id, sessionTTL)\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\trequestLogger(r).Error(\"session: loading\", \"error\", err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif !ok {\n")
+	b.WriteString("\t\treturn gmxSession{}\n")
+	b.WriteString("\t}\n")
+	if accounts {
+		b.WriteString("\treturn activeSession(s)\n")
+	} else {
+		b.WriteString("\treturn s\n")
+	}
+	b.WriteString("}\n\n")
+
+	b.WriteString("// writeSession stores the session under a new random ID, carried by the signed cookie\n")
+	b.WriteString("func writeSession(w http.ResponseWriter, s gmxSession) {\n")
+	b.WriteString("\tbuf := make([]byte, 32)\n")
+	b.WriteString("\trand.Read(buf)\n")
+	b.WriteString("\tid := hex.EncodeToString(buf)\n")
+	b.WriteString("\tif err := sessions.Save(id, s, sessionTTL); err != nil {\n")
+	b.WriteString("\t\tslog.Error(\"session: saving\", \"error\", err)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tsetSessionCookie(w, id)\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// dropSession deletes the stored session of the request, before it is\n")
+	b.WriteString("// replaced or closed\n")
+	b.WriteString("func dropSession(r *http.Request) {\n")
+	b.WriteString("\tif id := sessionPayload(r); id != \"\" {\n")
+	b.WriteString("\t\tif err := sessions.Delete(id); err != nil {\n")
+	b.WriteString("\t\t\trequestLogger(r).Error(\"session: deleting\", \"error\", err)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// genSessionContextMethods generates ctx.login(id) and ctx.logout() for script
+// functions; a stored session is replaced on login, against session fixation,
+// and waits for the second factor of users with two-factor authentication;
+// signing in cancels the pending deletion of the account
+func (g *Generator) genSessionContextMethods(serverSessions, twoFactor, accounts bool) string {
+	var b strings.Builder
+
+	if twoFactor {
+		b.WriteString("// Login opens a session for the given user ID; users with two-factor\n")
+		b.WriteString("// authentication are sent to enter their code first, ctx.User staying empty\n")
+	} else {
+		b.WriteString("// Login opens a session for the given user ID\n")
+	}
+	b.WriteString("func (ctx *GMXContext) Login(userID string) {\n")
+	if serverSessions {
+		b.WriteString("\tdropSession(ctx.Request)\n")
+	}
+	if twoFactor {
+		b.WriteString("\tif twoFactorEnabled(userID) {\n")
+		b.WriteString("\t\twriteSession(ctx.Writer, gmxSession{Pending: userID})\n")
+		b.WriteString(fmt.Sprintf("\t\tctx.Writer.Header().Set(\"HX-Redirect\", %q)\n", twoFactorPath))
+		b.WriteString("\t\tctx.User = \"\"\n")
+		b.WriteString("\t\treturn\n")
+		b.WriteString("\t}\n")
+	}
+	if accounts {
+		b.WriteString("\trestoreAccount(userID)\n")
+	}
+	b.WriteString("\twriteSession(ctx.Writer, gmxSession{User: userID})\n")
+	b.WriteString("\tctx.User = userID\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// Logout closes the current session, including any impersonation\n")
+	b.WriteString("func (ctx *GMXContext) Logout() {\n")
+	if serverSessions {
+		b.WriteString("\tdropSession(ctx.Request)\n")
+	}
+	b.WriteString("\tclearSession(ctx.Writer)\n")
+	b.WriteString("\tctx.User = \"\"\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// impersonationRoutes are the built-in impersonation endpoints
+var impersonationRoutes = []Route{
+	{Method: "POST", Path: "/_gmx/impersonate", Handler: "handleImpersonate"},
+	{Method: "POST", Path: "/_gmx/impersonate/stop", Handler: "handleStopImpersonating"},
+	{Method: "GET", Path: "/_gmx/impersonation", Handler: "handleImpersonationBanner"},
+}
+
+// genImpersonationHandlers generates the admin-only impersonation flow:
+// start with an audited reason, banner fragment, and one-click revert; the
+// second factor entered by the admin carries over
+func (g *Generator) genImpersonationHandlers(serverSessions, twoFactor bool) string {
+	var b strings.Builder
+	verified := ""
+	if twoFactor {
+		verified = ", Verified: s.Verified"
+	}
+
+	b.WriteString("// handleImpersonate lets an admin act as another user, with a mandatory audited reason\n")
+	b.WriteString("func handleImpersonate(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString("\tif r.Method != http.MethodPost {\n")
+	b.WriteString("\t\thttp.Error(w, \"Method Not Allowed\", http.StatusMethodNotAllowed)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\ts := readSession(r)\n")
+	b.WriteString("\tif s.Impersonator != \"\" {\n")
+	b.WriteString("\t\thttp.Error(w, \"Already impersonating a user\", http.StatusConflict)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif !sessionAdmins[s.User] {\n")
+	b.WriteString("\t\thttp.Error(w, \"Forbidden\", http.StatusForbidden)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\ttarget := r.FormValue(\"user\")\n")
+	b.WriteString("\treason := r.FormValue(\"reason\")\n")
+	b.WriteString("\tif target == \"\" || reason == \"\" {\n")
+	b.WriteString("\t\thttp.Error(w, \"Missing required parameter: user and reason\", http.StatusBadRequest)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\trequestLogger(r).Info(\"audit: impersonation started\", \"admin\", s.User, \"user\", target, \"reason\", reason)\n")
+	if serverSessions {
+		b.WriteString("\tdropSession(r)\n")
+	}
+	b.WriteString(fmt.Sprintf("\twriteSession(w, gmxSession{User: target, Impersonator: s.User, Reason: reason%s})\n", verified))
+	b.WriteString("\tw.Header().Set(\"HX-Redirect\", \"/\")\n")
+	b.WriteString("\tw.WriteHeader(http.StatusNoContent)\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// handleStopImpersonating restores the admin's own session\n")
+	b.WriteString("func handleStopImpersonating(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString("\tif r.Method != http.MethodPost {\n")
+	b.WriteString("\t\thttp.Error(w, \"Method Not Allowed\", http.StatusMethodNotAllowed)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\ts := readSession(r)\n")
+	b.WriteString("\tif s.Impersonator == \"\" {\n")
+	b.WriteString("\t\thttp.Error(w, \"Not impersonating\", http.StatusBadRequest)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\trequestLogger(r).Info(\"audit: impersonation ended\", \"admin\", s.Impersonator, \"user\", s.User)\n")
+	if serverSessions {
+		b.WriteString("\tdropSession(r)\n")
+	}
+	b.WriteString(fmt.Sprintf("\twriteSession(w, gmxSession{User: s.Impersonator%s})\n", verified))
+	b.WriteString("\tw.Header().Set(\"HX-Redirect\", \"/\")\n")
+	b.WriteString("\tw.WriteHeader(http.StatusNoContent)\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// handleImpersonationBanner renders the impersonation banner fragment, or nothing outside impersonation\n")
+	b.WriteString("func handleImpersonationBanner(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString("\tif r.Method != http.MethodGet {\n")
+	b.WriteString("\t\thttp.Error(w, \"Method Not Allowed\", http.StatusMethodNotAllowed)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/html; charset=utf-8\")\n")
+	b.WriteString("\ts := readSession(r)\n")
+	b.WriteString("\tif s.Impersonator == \"\" {\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tfmt.Fprintf(w, `<div class=\"gmx-impersonation\" role=\"alert\">Viewing as <strong>%s</strong> (%s) `+\n")
+	b.WriteString("\t\t`<button hx-post=\"/_gmx/impersonate/stop\">Stop impersonating</button></div>`,\n")
+	b.WriteString("\t\ttemplate.HTMLEscapeString(s.User), template.HTMLEscapeString(s.Reason))\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
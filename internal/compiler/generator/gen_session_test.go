@@ -0,0 +1,307 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+func sessionTestFile(withAdmins bool) *ast.GMXFile {
+	fields := []*ast.ServiceField{
+		{Name: "secret", Type: "string", EnvVar: "SESSION_SECRET"},
+	}
+	if withAdmins {
+		fields = append(fields, &ast.ServiceField{Name: "admins", Type: "string", EnvVar: "ADMIN_USERS"})
+	}
+	return &ast.GMXFile{
+		Services: []*ast.ServiceDecl{
+			{Name: "Auth", Provider: "session", Fields: fields},
+		},
+		Script: &ast.ScriptBlock{
+			Funcs: []*ast.FuncDecl{
+				{Name: "signOut", Body: []ast.Statement{}},
+			},
+		},
+		Template: &ast.TemplateBlock{Source: `{{impersonationBanner}}<p>Hello</p>`},
+	}
+}
+
+func TestGenerator_SessionService(t *testing.T) {
+	file := sessionTestFile(false)
+	file.Template = &ast.TemplateBlock{Source: `<p>Hello</p>`}
+
+	gen := New()
+	code, err := gen.Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	expected := []string{
+		"func configureAuth(cfg *AuthConfig)",
+		"configureAuth(authCfg)",
+		"func readSession(r *http.Request) gmxSession",
+		"func writeSession(w http.ResponseWriter, s gmxSession)",
+		"User:    readSession(r).User,",
+		"func (ctx *GMXContext) Login(userID string)",
+		"func (ctx *GMXContext) Logout()",
+		`"encoding/base64"`,
+		`"strings"`,
+		// The cookie carries a signed expiry
+		"var sessionTTL = 24 * time.Hour",
+		`raw := encodeSession(s) + "&e=" + strconv.FormatInt(time.Now().Add(sessionTTL).Unix(), 10)`,
+		`if expires, err := strconv.ParseInt(values.Get("e"), 10, 64); err != nil || time.Now().Unix() > expires {`,
+		`"strconv"`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(code, exp) {
+			t.Errorf("Generated code missing %q", exp)
+		}
+	}
+
+	for _, unexpected := range []string{"sessionAdmins", "handleImpersonate", "/_gmx/"} {
+		if strings.Contains(code, unexpected) {
+			t.Errorf("Generated code should not contain %q without an admins field", unexpected)
+		}
+	}
+	// The cookie store keeps the session in the cookie itself
+	for _, unexpected := range []string{"sessionStore", "dropSession"} {
+		if strings.Contains(code, unexpected) {
+			t.Errorf("Generated code should not contain %q with the cookie store", unexpected)
+		}
+	}
+}
+
+// sessionCookieTest checks that a session cookie past its signed expiry is refused
+const sessionCookieTest = `package main
+
+import (
+	"encoding/base64"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func readWritten(s gmxSession) gmxSession {
+	rec := httptest.NewRecorder()
+	writeSession(rec, s)
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(rec.Result().Cookies()[0])
+	return readSession(r)
+}
+
+func TestSessionCookieExpiry(t *testing.T) {
+	sessionSecret = []byte("secret")
+	if got := readWritten(gmxSession{User: "u1"}).User; got != "u1" {
+		t.Fatalf("fresh cookie: got user %q", got)
+	}
+
+	// A signed cookie without an expiry is refused too
+	rec := httptest.NewRecorder()
+	setSessionCookie(rec, base64.RawURLEncoding.EncodeToString([]byte(encodeSession(gmxSession{User: "u1"}))))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(rec.Result().Cookies()[0])
+	if got := readSession(r).User; got != "" {
+		t.Errorf("cookie without expiry: got user %q", got)
+	}
+
+	sessionTTL = -time.Minute
+	if got := readWritten(gmxSession{User: "u1"}).User; got != "" {
+		t.Errorf("expired cookie: got user %q", got)
+	}
+}
+`
+
+func TestGenerateSessionCookieExpiry(t *testing.T) {
+	file := sessionTestFile(false)
+	file.Template = &ast.TemplateBlock{Source: `<p>Hello</p>`}
+	file.Models = []*ast.ModelDecl{{Name: "Task", Fields: []*ast.FieldDecl{{Name: "title", Type: "string"}}}}
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	goInModule(t, map[string]string{"main.go": code, "main_test.go": sessionCookieTest}, "test", ".")
+}
+
+func TestGenerator_Impersonation(t *testing.T) {
+	gen := New()
+	code, err := gen.Generate(sessionTestFile(true))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	expected := []string{
+		"sessionAdmins[id] = true",
+		"if !sessionAdmins[s.User] {",
+		`requestLogger(r).Info("audit: impersonation started", "admin", s.User, "user", target, "reason", reason)`,
+		`requestLogger(r).Info("audit: impersonation ended", "admin", s.Impersonator, "user", s.User)`,
+		"writeSession(w, gmxSession{User: s.Impersonator})",
+		`mux.HandleFunc("/_gmx/impersonate", handleImpersonate)`,
+		`mux.HandleFunc("/_gmx/impersonate/stop", handleStopImpersonating)`,
+		`mux.HandleFunc("/_gmx/impersonation", handleImpersonationBanner)`,
+		`"impersonationBanner": func() template.HTML`,
+		"template.HTMLEscapeString(s.User)",
+	}
+	for _, exp := range expected {
+		if !strings.Contains(code, exp) {
+			t.Errorf("Generated code missing %q", exp)
+		}
+	}
+}
+
+func TestGenerator_SessionServiceRequiresSecret(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []*ast.ServiceField
+	}{
+		{"no secret field", nil},
+		{"secret without env", []*ast.ServiceField{{Name: "secret", Type: "string"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := &ast.GMXFile{
+				Services: []*ast.ServiceDecl{{Name: "Auth", Provider: "session", Fields: tt.fields}},
+			}
+			_, err := New().Generate(file)
+			if err == nil {
+				t.Fatal("expected error for session service without secret")
+			}
+			if !strings.Contains(err.Error(), "requires a `secret") {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// sessionStoreFile returns a session service keeping its sessions in store
+func sessionStoreFile(store string) *ast.GMXFile {
+	file := sessionTestFile(true)
+	def := func(v string) []*ast.Annotation {
+		return []*ast.Annotation{{Name: "default", Args: map[string]string{"_": v}}}
+	}
+	svc := file.Services[0]
+	svc.Fields = append(svc.Fields,
+		&ast.ServiceField{Name: "store", Type: "string", Annotations: def(store)},
+		&ast.ServiceField{Name: "ttl", Type: "string", EnvVar: "SESSION_TTL", Annotations: def("2h")},
+	)
+	if store == "redis" {
+		svc.Fields = append(svc.Fields, &ast.ServiceField{Name: "url", Type: "string", EnvVar: "REDIS_URL"})
+	}
+	return file
+}
+
+func TestGenerator_SessionStores(t *testing.T) {
+	tests := []struct {
+		store    string
+		expected []string
+	}{
+		{"memory", []string{
+			"sessions = &memorySessionStore{sessions: map[string]memorySession{}}",
+			"func (m *memorySessionStore) Load(id string, ttl time.Duration) (gmxSession, bool, error) {",
+			"entry.expires = time.Now().Add(ttl)",
+			"\t\"sync\"\n",
+		}},
+		{"database", []string{
+			"sessions = databaseSessionStore{}",
+			"type Session struct {",
+			"func (databaseSessionStore) Load(id string, ttl time.Duration) (gmxSession, bool, error) {",
+			`Update("expires_at", expires)`,
+			"\tgo cleanupSessions()\n",
+		}},
+		{"redis", []string{
+			"sessions = redisSessionStore{client: redis.NewClient(opts)}",
+			`s.client.GetEx(context.Background(), "gmx:session:"+id, ttl)`,
+			`s.client.Set(context.Background(), "gmx:session:"+id, encodeSession(session), ttl)`,
+			"\t\"github.com/redis/go-redis/v9\"\n",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.store, func(t *testing.T) {
+			code, err := New().Generate(sessionStoreFile(tt.store))
+			if err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+			if !isValidGo(code) {
+				t.Errorf("Generated code is not valid Go:\n%s", code)
+			}
+
+			expected := append([]string{
+				"var sessionTTL = 24 * time.Hour",
+				"ttl, err := time.ParseDuration(cfg.Ttl)",
+				"\tsessionTTL = ttl\n",
+				"type sessionStore interface {",
+				"s, ok, err := sessions.Load(id, sessionTTL)",
+				"if err := sessions.Save(id, s, sessionTTL); err != nil {",
+				// A login replaces the stored session of the request
+				"\tdropSession(ctx.Request)\n\twriteSession(ctx.Writer, gmxSession{User: userID})\n",
+				"\tdropSession(ctx.Request)\n\tclearSession(ctx.Writer)\n",
+				"\tdropSession(r)\n\twriteSession(w, gmxSession{User: s.Impersonator})\n",
+			}, tt.expected...)
+			for _, exp := range expected {
+				if !strings.Contains(code, exp) {
+					t.Errorf("Generated code missing %q", exp)
+				}
+			}
+			if strings.Contains(code, "encoding/base64") {
+				t.Error("stored sessions should not encode their cookie in base64")
+			}
+		})
+	}
+}
+
+func TestGenerator_SessionStoreErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(file *ast.GMXFile)
+		wantErr string
+	}{
+		{
+			name: "unknown store",
+			modify: func(file *ast.GMXFile) {
+				findServiceField(file.Services[0], "store").Annotations[0].Args["_"] = "memcached"
+			},
+			wantErr: `service Auth: unknown session store "memcached" (expected cookie, memory, database, redis)`,
+		},
+		{
+			name: "store from the environment",
+			modify: func(file *ast.GMXFile) {
+				findServiceField(file.Services[0], "store").EnvVar = "SESSION_STORE"
+			},
+			wantErr: "service Auth: the session store is chosen at build time",
+		},
+		{
+			name: "redis without url",
+			modify: func(file *ast.GMXFile) {
+				findServiceField(file.Services[0], "store").Annotations[0].Args["_"] = "redis"
+			},
+			wantErr: "service Auth: the redis session store requires a `url` field",
+		},
+		{
+			name: "session model",
+			modify: func(file *ast.GMXFile) {
+				findServiceField(file.Services[0], "store").Annotations[0].Args["_"] = "database"
+				file.Models = append(file.Models, &ast.ModelDecl{Name: "Session", Line: 4})
+			},
+			wantErr: "line 4: model Session collides with the model storing the sessions; rename it",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := sessionStoreFile("memory")
+			tt.modify(file)
+			_, err := New().Generate(file)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package generator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// settingModel is the model storing the settings, migrated with the others
+const settingModel = "Setting"
+
+// Built-in admin pages listing the settings and saving a change
+const (
+	settingsAdminPath = "/_gmx/settings"
+	settingsSavePath  = "/_gmx/settings/save"
+)
+
+// hasSettingsAdmin checks if admins may edit the settings, which is the case
+// when the session service declares an `admins` field
+func (g *Generator) hasSettingsAdmin(file *ast.GMXFile) bool {
+	return len(file.Settings) > 0 && g.hasImpersonation(file)
+}
+
+// validateSettings checks that settings have a unique name and a default of
+// their type, and that nothing else uses the names they generate
+func (g *Generator) validateSettings(file *ast.GMXFile) error {
+	if len(file.Settings) == 0 {
+		return nil
+	}
+	for _, model := range file.Models {
+		if model.Name == settingModel {
+			return fmt.Errorf("line %d: model %s collides with the model storing the settings; rename it", model.Line, model.Name)
+		}
+	}
+
+	taken := make(map[string]string)
+	for _, v := range file.Vars {
+		taken[v.Name] = "variable"
+	}
+	if file.Script != nil {
+		for _, fn := range file.Script.Funcs {
+			taken[fn.Name] = "func"
+		}
+	}
+	for _, setting := range file.Settings {
+		if kind, ok := taken[setting.Name]; ok {
+			return fmt.Errorf("line %d: setting %s collides with the %s of the same name, which its accessor would redeclare", setting.Line, setting.Name, kind)
+		}
+		taken[setting.Name] = "setting"
+
+		if err := checkSettingDefault(setting); err != nil {
+			return fmt.Errorf("line %d: setting %s: %v", setting.Line, setting.Name, err)
+		}
+	}
+	return nil
+}
+
+// checkSettingDefault checks that the default of a setting parses as its type
+func checkSettingDefault(setting *ast.SettingDecl) error {
+	value := setting.Default()
+	if value == "" {
+		return nil
+	}
+	var err error
+	switch setting.Type {
+	case "int":
+		_, err = strconv.Atoi(value)
+	case "float":
+		_, err = strconv.ParseFloat(value, 64)
+	case "bool":
+		_, err = strconv.ParseBool(value)
+	}
+	if err != nil {
+		return fmt.Errorf("@default(%s) is not a valid %s", value, setting.Type)
+	}
+	return nil
+}
+
+// withSettingModel returns the file with the model storing its settings, so
+// that it is declared, migrated and loaded like the other models
+func (g *Generator) withSettingModel(file *ast.GMXFile) *ast.GMXFile {
+	if len(file.Settings) == 0 {
+		return file
+	}
+	withModel := *file
+	withModel.Models = append(append([]*ast.ModelDecl{}, file.Models...), &ast.ModelDecl{
+		Name: settingModel,
+		Fields: []*ast.FieldDecl{
+			{Name: "name", Type: "string", Annotations: []*ast.Annotation{{Name: "pk", Args: map[string]string{}}}},
+			{Name: "value", Type: "string"},
+		},
+	})
+	return &withModel
+}
+
+// genSettings generates the settings cache and the typed accessor of each
+// setting, named after it: supportEmail() string
+func (g *Generator) genSettings(file *ast.GMXFile) string {
+	var b strings.Builder
+
+	b.WriteString("// settingDecls lists the declared settings with their type and default, as stored\n")
+	b.WriteString("var settingDecls = []struct{ Name, Type, Default string }{\n")
+	for _, setting := range file.Settings {
+		b.WriteString(fmt.Sprintf("\t{%q, %q, %q},\n", setting.Name, setting.Type, setting.Default()))
+	}
+	b.WriteString("}\n\n")
+
+	b.WriteString("// settingsTTL bounds how long a change made by another instance goes unseen\n")
+	b.WriteString("const settingsTTL = time.Minute\n\n")
+
+	b.WriteString("// settingsCache keeps the stored settings in memory until they expire or change\n")
+	b.WriteString("var settingsCache struct {\n")
+	b.WriteString("\tsync.RWMutex\n")
+	b.WriteString("\tvalues   map[string]string\n")
+	b.WriteString("\tloadedAt time.Time\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// settingValue returns the stored value of a setting, or its declared default\n")
+	b.WriteString("func settingValue(name string) string {\n")
+	b.WriteString("\tsettingsCache.RLock()\n")
+	b.WriteString("\tvalues, loadedAt := settingsCache.values, settingsCache.loadedAt\n")
+	b.WriteString("\tsettingsCache.RUnlock()\n")
+	b.WriteString("\tif values == nil || time.Since(loadedAt) > settingsTTL {\n")
+	b.WriteString("\t\tvalues = loadSettings()\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif value, ok := values[name]; ok {\n")
+	b.WriteString("\t\treturn value\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tfor _, decl := range settingDecls {\n")
+	b.WriteString("\t\tif decl.Name == name {\n")
+	b.WriteString("\t\t\treturn decl.Default\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn \"\"\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// loadSettings reads the stored settings into the cache; when the database\n")
+	b.WriteString("// fails, the defaults apply and the next read tries again\n")
+	b.WriteString("func loadSettings() map[string]string {\n")
+	b.WriteString(fmt.Sprintf("\tvar rows []%s\n", settingModel))
+	b.WriteString("\tif err := db.Find(&rows).Error; err != nil {\n")
+	b.WriteString("\t\tslog.Error(\"settings: loading\", \"error\", err)\n")
+	b.WriteString("\t\treturn nil\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tvalues := make(map[string]string, len(rows))\n")
+	b.WriteString("\tfor _, row := range rows {\n")
+	b.WriteString("\t\tvalues[row.Name] = row.Value\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tsettingsCache.Lock()\n")
+	b.WriteString("\tsettingsCache.values, settingsCache.loadedAt = values, time.Now()\n")
+	b.WriteString("\tsettingsCache.Unlock()\n")
+	b.WriteString("\treturn values\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// invalidateSettings drops the cached settings; the next read reloads them\n")
+	b.WriteString("func invalidateSettings() {\n")
+	b.WriteString("\tsettingsCache.Lock()\n")
+	b.WriteString("\tsettingsCache.values = nil\n")
+	b.WriteString("\tsettingsCache.Unlock()\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// validSetting checks that a value parses as the type of the setting\n")
+	b.WriteString("func validSetting(name, value string) error {\n")
+	b.WriteString("\tfor _, decl := range settingDecls {\n")
+	b.WriteString("\t\tif decl.Name != name {\n")
+	b.WriteString("\t\t\tcontinue\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tvar err error\n")
+	b.WriteString("\t\tswitch decl.Type {\n")
+	b.WriteString("\t\tcase \"int\":\n")
+	b.WriteString("\t\t\t_, err = strconv.Atoi(value)\n")
+	b.WriteString("\t\tcase \"float\":\n")
+	b.WriteString("\t\t\t_, err = strconv.ParseFloat(value, 64)\n")
+	b.WriteString("\t\tcase \"bool\":\n")
+	b.WriteString("\t\t\t_, err = strconv.ParseBool(value)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\treturn err\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn fmt.Errorf(\"unknown setting %q\", name)\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// saveSetting stores the value of a setting and invalidates the cache\n")
+	b.WriteString("func saveSetting(name, value string) error {\n")
+	b.WriteString(fmt.Sprintf("\tif err := db.Save(&%s{Name: name, Value: value}).Error; err != nil {\n", settingModel))
+	b.WriteString("\t\treturn err\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tinvalidateSettings()\n")
+	b.WriteString("\treturn nil\n")
+	b.WriteString("}\n\n")
+
+	// Stored values were validated on save; the parse errors cannot happen
+	for _, setting := range file.Settings {
+		b.WriteString(fmt.Sprintf("// %s returns the %s setting\n", setting.Name, setting.Name))
+		switch setting.Type {
+		case "int":
+			b.WriteString(fmt.Sprintf("func %s() int {\n", setting.Name))
+			b.WriteString(fmt.Sprintf("\tv, _ := strconv.Atoi(settingValue(%q))\n", setting.Name))
+			b.WriteString("\treturn v\n")
+		case "float":
+			b.WriteString(fmt.Sprintf("func %s() float64 {\n", setting.Name))
+			b.WriteString(fmt.Sprintf("\tv, _ := strconv.ParseFloat(settingValue(%q), 64)\n", setting.Name))
+			b.WriteString("\treturn v\n")
+		case "bool":
+			b.WriteString(fmt.Sprintf("func %s() bool {\n", setting.Name))
+			b.WriteString(fmt.Sprintf("\tv, _ := strconv.ParseBool(settingValue(%q))\n", setting.Name))
+			b.WriteString("\treturn v\n")
+		default:
+			b.WriteString(fmt.Sprintf("func %s() string {\n", setting.Name))
+			b.WriteString(fmt.Sprintf("\treturn settingValue(%q)\n", setting.Name))
+		}
+		b.WriteString("}\n\n")
+	}
+
+	if g.hasSettingsAdmin(file) {
+		b.WriteString(g.genSettingsAdmin())
+	}
+
+	return b.String()
+}
+
+// genSettingsAdmin generates the admin pages listing the settings and saving
+// a change, with an audit log entry
+func (g *Generator) genSettingsAdmin() string {
+	var b strings.Builder
+
+	b.WriteString("// handleSettingsAdmin lists the settings with a form to change each of them\n")
+	b.WriteString("func handleSettingsAdmin(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString("\tif r.Method != http.MethodGet {\n")
+	b.WriteString("\t\thttp.Error(w, \"Method Not Allowed\", http.StatusMethodNotAllowed)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\ts := readSession(r)\n")
+	b.WriteString("\tif s.Impersonator != \"\" || !sessionAdmins[s.User] {\n")
+	b.WriteString("\t\thttp.Error(w, \"Forbidden\", http.StatusForbidden)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\t// The forms submit the CSRF token themselves\n")
+	b.WriteString("\tcsrfToken := generateCSRFToken()\n")
+	b.WriteString("\tif cookie, err := r.Cookie(\"_csrf\"); err == nil {\n")
+	b.WriteString("\t\tcsrfToken = cookie.Value\n")
+	b.WriteString("\t} else {\n")
+	b.WriteString("\t\thttp.SetCookie(w, &http.Cookie{\n")
+	b.WriteString("\t\t\tName:     \"_csrf\",\n")
+	b.WriteString("\t\t\tValue:    csrfToken,\n")
+	b.WriteString("\t\t\tPath:     \"/\",\n")
+	b.WriteString("\t\t\tHttpOnly: false,\n")
+	b.WriteString("\t\t\tSameSite: http.SameSiteStrictMode,\n")
+	b.WriteString("\t\t\tSecure:   r.TLS != nil,\n")
+	b.WriteString("\t\t})\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/html; charset=utf-8\")\n")
+	b.WriteString("\tfmt.Fprint(w, `<!DOCTYPE html><html><head><meta charset=\"utf-8\"><title>Settings</title></head><body><h1>Settings</h1>`)\n")
+	b.WriteString("\tfor _, decl := range settingDecls {\n")
+	b.WriteString("\t\tinputType := \"text\"\n")
+	b.WriteString("\t\tif decl.Type == \"int\" || decl.Type == \"float\" {\n")
+	b.WriteString("\t\t\tinputType = \"number\"\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString(fmt.Sprintf("\t\tfmt.Fprintf(w, `<form method=\"post\" action=%q><label>%%s (%%s) <input name=\"value\" type=\"%%s\" step=\"any\" value=\"%%s\"></label>`+\n", settingsSavePath))
+	b.WriteString("\t\t\t`<input type=\"hidden\" name=\"name\" value=\"%s\"><input type=\"hidden\" name=\"_csrf\" value=\"%s\"> <button>Save</button></form>`,\n")
+	b.WriteString("\t\t\tdecl.Name, decl.Type, inputType, template.HTMLEscapeString(settingValue(decl.Name)), decl.Name, csrfToken)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tfmt.Fprint(w, `</body></html>`)\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// handleSettingsSave stores the value of a setting submitted by an admin\n")
+	b.WriteString("func handleSettingsSave(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString("\tif r.Method != http.MethodPost {\n")
+	b.WriteString("\t\thttp.Error(w, \"Method Not Allowed\", http.StatusMethodNotAllowed)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\ts := readSession(r)\n")
+	b.WriteString("\tif s.Impersonator != \"\" || !sessionAdmins[s.User] {\n")
+	b.WriteString("\t\thttp.Error(w, \"Forbidden\", http.StatusForbidden)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\tname, value := r.FormValue(\"name\"), r.FormValue(\"value\")\n")
+	b.WriteString("\tif err := validSetting(name, value); err != nil {\n")
+	b.WriteString("\t\thttp.Error(w, fmt.Sprintf(\"Invalid setting %s: %v\", name, err), http.StatusBadRequest)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif err := saveSetting(name, value); err != nil {\n")
+	b.WriteString("\t\trequestLogger(r).Error(\"settings: saving\", \"error\", err)\n")
+	b.WriteString("\t\thttp.Error(w, \"Internal Server Error\", http.StatusInternalServerError)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\trequestLogger(r).Info(\"audit: setting changed\", \"setting\", name, \"user\", s.User)\n")
+	b.WriteString(fmt.Sprintf("\thttp.Redirect(w, r, %q, http.StatusSeeOther)\n", settingsAdminPath))
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
@@ -0,0 +1,104 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+)
+
+const settingsScript = `setting supportEmail: string @default("help@example.com")
+setting maxUploads: int @default(10)
+model Task {
+  id: uuid @pk @default(uuid_v4)
+  title: string
+}
+func createTask(title: string) error {
+  if maxUploads() < 1 {
+    return error("uploads are closed")
+  }
+  const task = Task{title: title}
+  try task.save()
+  return render(task)
+}`
+
+const settingsTemplateSrc = `<p>{{.CSRFToken}}</p>`
+
+func TestGenerateSettings(t *testing.T) {
+	code, err := New().Generate(scriptTestFile(t, settingsScript, settingsTemplateSrc))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, want := range []string{
+		// Stored by a generated model, migrated with the others
+		"type Setting struct {",
+		"db.AutoMigrate(&Task{}, &Setting{})",
+		`{"supportEmail", "string", "help@example.com"},`,
+		"func supportEmail() string {",
+		"func maxUploads() int {",
+		"if maxUploads() < 1 {",
+		"if values == nil || time.Since(loadedAt) > settingsTTL {",
+		"invalidateSettings()",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+
+	// Without session admins, nobody may edit the settings over HTTP
+	if strings.Contains(code, "handleSettingsAdmin") {
+		t.Error("the settings admin requires a session service with admins")
+	}
+}
+
+func TestGenerateSettingsAdmin(t *testing.T) {
+	session := `service Auth {
+  provider: "session"
+  secret: string @env("SESSION_SECRET")
+  admins: string @env("ADMINS")
+}
+`
+	code, err := New().Generate(scriptTestFile(t, session+settingsScript, settingsTemplateSrc))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, want := range []string{
+		`mux.HandleFunc("/_gmx/settings", handleSettingsAdmin)`,
+		`mux.HandleFunc("/_gmx/settings/save", handleSettingsSave)`,
+		"if s.Impersonator != \"\" || !sessionAdmins[s.User] {",
+		"if err := validSetting(name, value); err != nil {",
+		`requestLogger(r).Info("audit: setting changed", "setting", name, "user", s.User)`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+}
+
+func TestValidateSettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{"invalid default", `setting maxUploads: int @default(ten)`, "line 1: setting maxUploads: @default(ten) is not a valid int"},
+		{"duplicate", "setting maxUploads: int\nsetting maxUploads: int", "line 2: setting maxUploads collides with the setting of the same name"},
+		{"func", "setting ping: bool\nfunc ping() error {\n  return nil\n}", "line 1: setting ping collides with the func of the same name"},
+		{"model", "setting ping: bool\nmodel Setting {\n  id: uuid @pk\n}", "line 2: model Setting collides with the model storing the settings"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New().Generate(scriptTestFile(t, tt.src, settingsTemplateSrc))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// handlerMethod returns the HTTP method of a handler function: @signed
+// functions answer the GET of a link, others follow their name
+func handlerMethod(fn *ast.FuncDecl) string {
+	if fn.FindAnnotation("signed") != nil {
+		return "Get"
+	}
+	return inferHTTPMethod(fn.Name)
+}
+
+// signedRouteCalls returns the signedRoute(...) calls of the script functions
+func signedRouteCalls(file *ast.GMXFile) []*ast.CallExpr {
+	if file.Script == nil {
+		return nil
+	}
+	var calls []*ast.CallExpr
+	for _, fn := range file.Script.Funcs {
+		ast.Inspect(fn, func(n ast.Node) bool {
+			if call, ok := n.(*ast.CallExpr); ok {
+				if ident, ok := call.Function.(*ast.Ident); ok && ident.Name == "signedRoute" {
+					calls = append(calls, call)
+				}
+			}
+			return true
+		})
+	}
+	return calls
+}
+
+// validateSignedRoutes checks that @signed functions can be linked to and that
+// signedRoute(...) targets one of them
+func (g *Generator) validateSignedRoutes(file *ast.GMXFile) error {
+	signed := make(map[string]*ast.FuncDecl)
+	for _, fn := range g.funcsWithAnnotation(file, "signed") {
+		if g.findSessionService(file.Services) == nil {
+			return fmt.Errorf("function %s: @signed requires a service with provider \"session\", whose secret signs the URLs", fn.Name)
+		}
+		if fn.ReturnType != "" && fn.ReturnType != "error" {
+			return fmt.Errorf("function %s: @signed applies to handler functions returning error", fn.Name)
+		}
+		// The other parameters would be read from the query string unsigned
+		if len(fn.Params) != 1 {
+			return fmt.Errorf("function %s: @signed takes one parameter, carried and signed in the URL, got %d", fn.Name, len(fn.Params))
+		}
+		if t := fn.Params[0].Type; t == "bytes" || t == "string[]" {
+			return fmt.Errorf("function %s: the signed parameter %s cannot be of type %s", fn.Name, fn.Params[0].Name, t)
+		}
+		signed[fn.Name] = fn
+	}
+
+	for _, call := range signedRouteCalls(file) {
+		if len(call.Args) != 3 {
+			return fmt.Errorf("line %d: signedRoute takes a function name, a value and a duration, got %d arguments", call.Line, len(call.Args))
+		}
+		name, ok := call.Args[0].(*ast.StringLit)
+		if !ok || name.Parts != nil {
+			return fmt.Errorf("line %d: the first argument of signedRoute must be a function name literal", call.Line)
+		}
+		if signed[name.Value] == nil {
+			return fmt.Errorf("line %d: signedRoute targets %s, which is not a @signed function", call.Line, name.Value)
+		}
+	}
+	return nil
+}
+
+// genSignedRouteHelpers generates signedRoute, which issues expiring links to
+// the @signed functions, and the verification of their handler wrappers
+func (g *Generator) genSignedRouteHelpers(file *ast.GMXFile) string {
+	var b strings.Builder
+
+	b.WriteString("// signedRoutes maps the @signed functions to their path and signed parameter\n")
+	b.WriteString("var signedRoutes = map[string][2]string{\n")
+	for _, fn := range g.funcsWithAnnotation(file, "signed") {
+		b.WriteString(fmt.Sprintf("\t%q: {%q, %q},\n", fn.Name, routePath(fn), fn.Params[0].Name))
+	}
+	b.WriteString("}\n\n")
+
+	b.WriteString("// errSignedRouteExpired tells expired links apart from forged ones\n")
+	b.WriteString("var errSignedRouteExpired = errors.New(\"signed URL expired\")\n\n")
+
+	b.WriteString("// signRoute returns the signature of a link to a @signed function; the\n")
+	b.WriteString("// prefix keeps it apart from session signatures made with the same secret\n")
+	b.WriteString("func signRoute(name, value, exp string) string {\n")
+	b.WriteString("\tmac := hmac.New(sha256.New, sessionSecret)\n")
+	b.WriteString("\tmac.Write([]byte(\"signed-route\\n\" + name + \"\\n\" + value + \"\\n\" + exp))\n")
+	b.WriteString("\treturn hex.EncodeToString(mac.Sum(nil))\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// signedRoute returns a link to a @signed function carrying value as its\n")
+	b.WriteString("// signed parameter, valid for ttl\n")
+	b.WriteString("func signedRoute(name string, value any, ttl time.Duration) string {\n")
+	b.WriteString("\troute := signedRoutes[name]\n")
+	b.WriteString("\tv, exp := fmt.Sprint(value), strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)\n")
+	b.WriteString("\tquery := url.Values{route[1]: {v}, \"exp\": {exp}, \"sig\": {signRoute(name, v, exp)}}\n")
+	b.WriteString("\treturn route[0] + \"?\" + query.Encode()\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// verifySignedRoute checks the signature and expiry of a link to a @signed function\n")
+	b.WriteString("func verifySignedRoute(r *http.Request, name string) error {\n")
+	b.WriteString("\tquery := r.URL.Query()\n")
+	b.WriteString("\tv, exp := query.Get(signedRoutes[name][1]), query.Get(\"exp\")\n")
+	b.WriteString("\tif !hmac.Equal([]byte(query.Get(\"sig\")), []byte(signRoute(name, v, exp))) {\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"invalid signature\")\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\texpires, err := strconv.ParseInt(exp, 10, 64)\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\treturn fmt.Errorf(\"invalid expiry: %w\", err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif time.Now().Unix() > expires {\n")
+	b.WriteString("\t\treturn errSignedRouteExpired\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn nil\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// genSignedRouteCheck generates the verification of a @signed function's link
+// in its handler wrapper: 410 once expired, 403 when forged
+func genSignedRouteCheck(fn *ast.FuncDecl) string {
+	var b strings.Builder
+	b.WriteString("\t// Signed URL\n")
+	b.WriteString(fmt.Sprintf("\tif err := verifySignedRoute(r, %q); err != nil {\n", fn.Name))
+	b.WriteString("\t\tif errors.Is(err, errSignedRouteExpired) {\n")
+	b.WriteString("\t\t\thttp.Error(w, \"Link expired\", http.StatusGone)\n")
+	b.WriteString("\t\t\treturn\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\trequestLogger(r).Warn(\"signed URL rejected\", \"error\", err)\n")
+	b.WriteString("\t\thttp.Error(w, \"Forbidden\", http.StatusForbidden)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	return b.String()
+}
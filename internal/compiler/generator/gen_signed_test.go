@@ -0,0 +1,85 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+)
+
+const signedSession = `service Auth {
+  provider: "session"
+  secret: string @env("SESSION_SECRET")
+}
+`
+
+const signedScript = `model Sub {
+  id: uuid @pk @default(uuid_v4)
+  email: string
+}
+func createSub(email: string) error {
+  const sub = Sub{email: email}
+  try sub.save()
+  let link = signedRoute("unsubscribe", sub.id, 24h)
+  return render(link)
+}
+@signed
+func unsubscribe(id: uuid) error {
+  let sub = try Sub.find(id)
+  try sub.delete()
+  return nil
+}`
+
+const signedTemplateSrc = `<form hx-post="{{route "createSub"}}"></form>`
+
+func TestGenerateSignedRoutes(t *testing.T) {
+	code, err := New().Generate(scriptTestFile(t, signedSession+signedScript, signedTemplateSrc))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, want := range []string{
+		`"unsubscribe": {"/api/unsubscribe", "id"},`,
+		"link := signedRoute(\"unsubscribe\", sub.ID, 24*time.Hour)",
+		"func verifySignedRoute(r *http.Request, name string) error {",
+		// Signed links are followed with GET and checked before anything else
+		"if r.Method != http.MethodGet {",
+		"if err := verifySignedRoute(r, \"unsubscribe\"); err != nil {",
+		"http.Error(w, \"Link expired\", http.StatusGone)",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+
+	// The signed parameter is not overridden by a request body
+	handler := code[strings.Index(code, "func handleUnsubscribe("):]
+	handler = handler[:strings.Index(handler, "\n}\n")]
+	if strings.Contains(handler, "parseRequestBody") {
+		t.Error("@signed handlers should read their parameters from the query string only")
+	}
+}
+
+func TestValidateSignedRoutes(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{"without session", signedScript, "function unsubscribe: @signed requires a service with provider \"session\""},
+		{"without parameter", signedSession + "@signed\nfunc confirm() error {\n  return nil\n}", "function confirm: @signed takes one parameter, carried and signed in the URL, got 0"},
+		{"several parameters", signedSession + "@signed\nfunc confirm(id: uuid, email: string) error {\n  return nil\n}", "function confirm: @signed takes one parameter, carried and signed in the URL, got 2"},
+		{"unsigned target", signedSession + "func confirm(id: uuid) error {\n  return nil\n}\nfunc send() error {\n  let link = signedRoute(\"confirm\", 1, 1h)\n  return nil\n}", "line 9: signedRoute targets confirm, which is not a @signed function"},
+		{"arguments", signedSession + "func send() error {\n  let link = signedRoute(\"confirm\")\n  return nil\n}", "signedRoute takes a function name, a value and a duration, got 1 arguments"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New().Generate(scriptTestFile(t, tt.src, signedTemplateSrc))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,491 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// storagePathPrefix is where local storage services serve their signed URLs
+const storagePathPrefix = "/_gmx/storage/"
+
+// s3Timeout bounds each request to an S3 bucket
+const s3Timeout = "60 * time.Second"
+
+// storageFields lists the config fields each storage provider must declare
+var storageFields = map[string][]string{
+	"s3":    {"bucket", "region", "accessKey", "secretKey"},
+	"local": {"dir"},
+}
+
+// providerMethod is a method implemented by a provider: its GMX parameter
+// types and return type, and its Go signature
+type providerMethod struct {
+	params []string
+	ret    string
+	goSig  string
+}
+
+// storageMethods lists the methods a storage service may declare
+var storageMethods = map[string]providerMethod{
+	"upload":    {params: []string{"string", "bytes"}, ret: "error", goSig: "(key string, data Blob) error"},
+	"download":  {params: []string{"string"}, ret: "bytes", goSig: "(key string) (Blob, error)"},
+	"delete":    {params: []string{"string"}, ret: "error", goSig: "(key string) error"},
+	"signedUrl": {params: []string{"string", "int"}, ret: "string", goSig: "(key string, seconds int) string"},
+}
+
+// isStorageService checks if a service stores files in S3 or a local directory
+func isStorageService(svc *ast.ServiceDecl) bool {
+	_, ok := storageFields[svc.Provider]
+	return ok
+}
+
+// providerDeclares checks if a service declares a method its provider implements
+func providerDeclares(svc *ast.ServiceDecl, name string) bool {
+	for _, method := range svc.Methods {
+		if method.Name == name && method.Body == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// hasStorage checks if a service of the given storage provider declares one
+// of the methods, or any method when none is given
+func (g *Generator) hasStorage(file *ast.GMXFile, provider string, methods ...string) bool {
+	for _, svc := range file.Services {
+		if svc.Provider != provider {
+			continue
+		}
+		if len(methods) == 0 {
+			return len(svc.Methods) > 0
+		}
+		for _, name := range methods {
+			if providerDeclares(svc, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validateStorageServices checks the fields and method signatures of the s3
+// and local storage services
+func (g *Generator) validateStorageServices(file *ast.GMXFile) error {
+	for _, svc := range file.Services {
+		if !isStorageService(svc) {
+			continue
+		}
+		for _, name := range storageFields[svc.Provider] {
+			if findServiceField(svc, name) == nil {
+				return fmt.Errorf("service %s: %s provider requires a `%s` field", svc.Name, svc.Provider, name)
+			}
+		}
+		if err := checkProviderMethods(svc, storageMethods, "upload, download, delete or signedUrl"); err != nil {
+			return err
+		}
+		// Local signed URLs are served by the app, which signs them with the secret
+		if svc.Provider == "local" && providerDeclares(svc, "signedUrl") && findServiceField(svc, "secret") == nil {
+			return fmt.Errorf("service %s: signedUrl on a local provider requires a `secret` field", svc.Name)
+		}
+	}
+	return nil
+}
+
+// checkProviderMethods checks the signatures of the methods a service
+// declares without a body, which its provider implements
+func checkProviderMethods(svc *ast.ServiceDecl, methods map[string]providerMethod, expected string) error {
+	for _, method := range svc.Methods {
+		// Methods with a script body are transpiled like on any service
+		if method.Body != nil {
+			continue
+		}
+		want, ok := methods[method.Name]
+		if !ok {
+			return fmt.Errorf("service %s: unsupported %s method %s (expected %s, or a script body)", svc.Name, svc.Provider, method.Name, expected)
+		}
+		var params []string
+		for _, param := range method.Params {
+			params = append(params, param.Type)
+		}
+		if strings.Join(params, ",") != strings.Join(want.params, ",") || method.ReturnType != want.ret {
+			return fmt.Errorf("service %s: method %s takes (%s) and returns %s", svc.Name, method.Name, strings.Join(want.params, ", "), want.ret)
+		}
+	}
+	return nil
+}
+
+// storageRoutes lists the endpoints serving the signed URLs of local storage services
+func storageRoutes(file *ast.GMXFile) []Route {
+	var routes []Route
+	for _, svc := range file.Services {
+		if svc.Provider == "local" && providerDeclares(svc, "signedUrl") {
+			routes = append(routes, Route{Method: "GET", Path: storagePathPrefix + svc.Name + "/{key...}", Handler: "handle" + svc.Name + "Storage"})
+		}
+	}
+	return routes
+}
+
+// genProviderInterface generates the interface of a service whose provider
+// implements methods, with their Go signatures: the download of a storage
+// service also returns the error of the transfer
+func (g *Generator) genProviderInterface(svc *ast.ServiceDecl, methods map[string]providerMethod) string {
+	var b strings.Builder
+
+	b.WriteString(fmt.Sprintf("// %sService defines the interface for the %s service\n", svc.Name, svc.Name))
+	b.WriteString(fmt.Sprintf("type %sService interface {\n", svc.Name))
+	for _, method := range svc.Methods {
+		if method.Body == nil {
+			b.WriteString(fmt.Sprintf("\t%s%s\n", utils.ToPascalCase(method.Name), methods[method.Name].goSig))
+			continue
+		}
+		var params []string
+		for _, param := range method.Params {
+			params = append(params, param.Name+" "+g.mapType(param.Type))
+		}
+		sig := fmt.Sprintf("\t%s(%s)", utils.ToPascalCase(method.Name), strings.Join(params, ", "))
+		if method.ReturnType != "" {
+			sig += " " + g.mapType(method.ReturnType)
+		}
+		b.WriteString(sig + "\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// genS3Helpers generates the S3 client shared by the s3 storage services:
+// requests signed with AWS Signature Version 4 and presigned URLs
+func (g *Generator) genS3Helpers() string {
+	var b strings.Builder
+
+	b.WriteString("// s3Client sends the requests of the s3 storage services\n")
+	b.WriteString(fmt.Sprintf("var s3Client = &http.Client{Timeout: %s}\n\n", s3Timeout))
+
+	b.WriteString("// s3Bucket addresses a bucket on AWS or on an S3-compatible endpoint\n")
+	b.WriteString("type s3Bucket struct {\n")
+	b.WriteString("\tbase      *url.URL // URL of the bucket, objects are addressed below it\n")
+	b.WriteString("\tregion    string\n")
+	b.WriteString("\taccessKey string\n")
+	b.WriteString("\tsecretKey string\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// newS3Bucket addresses an AWS bucket by virtual host, or a bucket of an\n")
+	b.WriteString("// S3-compatible endpoint by path\n")
+	b.WriteString("func newS3Bucket(endpoint, region, bucket, accessKey, secretKey string) s3Bucket {\n")
+	b.WriteString("\tbase := &url.URL{Scheme: \"https\", Host: bucket + \".s3.\" + region + \".amazonaws.com\"}\n")
+	b.WriteString("\tif endpoint != \"\" {\n")
+	b.WriteString("\t\tu, err := url.Parse(strings.TrimSuffix(endpoint, \"/\"))\n")
+	b.WriteString("\t\tif err != nil || u.Host == \"\" {\n")
+	b.WriteString("\t\t\tlog.Fatalf(\"invalid S3 endpoint %q\", endpoint)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tbase = &url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path + \"/\" + bucket}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn s3Bucket{base: base, region: region, accessKey: accessKey, secretKey: secretKey}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// objectURL returns the URL of an object, its path encoded as signed\n")
+	b.WriteString("func (b s3Bucket) objectURL(key string) *url.URL {\n")
+	b.WriteString("\tu := *b.base\n")
+	b.WriteString("\tu.Path += \"/\" + key\n")
+	b.WriteString("\tu.RawPath = s3Escape(u.Path)\n")
+	b.WriteString("\treturn &u\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// s3Escape encodes a path as AWS canonical requests do: every byte but the\n")
+	b.WriteString("// unreserved characters and the slashes\n")
+	b.WriteString("func s3Escape(p string) string {\n")
+	b.WriteString("\tvar b strings.Builder\n")
+	b.WriteString("\tfor i := 0; i < len(p); i++ {\n")
+	b.WriteString("\t\tc := p[i]\n")
+	b.WriteString("\t\tif c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.IndexByte(\"-_.~/\", c) >= 0 {\n")
+	b.WriteString("\t\t\tb.WriteByte(c)\n")
+	b.WriteString("\t\t} else {\n")
+	b.WriteString("\t\t\tfmt.Fprintf(&b, \"%%%02X\", c)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn b.String()\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// signature signs a canonical request with the key derived for its date and region\n")
+	b.WriteString("func (b s3Bucket) signature(amzDate, scope, canonical string) string {\n")
+	b.WriteString("\tsum := sha256.Sum256([]byte(canonical))\n")
+	b.WriteString("\ttoSign := \"AWS4-HMAC-SHA256\\n\" + amzDate + \"\\n\" + scope + \"\\n\" + hex.EncodeToString(sum[:])\n")
+	b.WriteString("\tkey := []byte(\"AWS4\" + b.secretKey)\n")
+	b.WriteString("\tfor _, part := range []string{amzDate[:8], b.region, \"s3\", \"aws4_request\", toSign} {\n")
+	b.WriteString("\t\tmac := hmac.New(sha256.New, key)\n")
+	b.WriteString("\t\tmac.Write([]byte(part))\n")
+	b.WriteString("\t\tkey = mac.Sum(nil)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn hex.EncodeToString(key)\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// do sends a signed request for an object, returning the response of a successful one\n")
+	b.WriteString("func (b s3Bucket) do(method, key string, body []byte) (*http.Response, error) {\n")
+	b.WriteString("\treq, err := http.NewRequest(method, b.objectURL(key).String(), bytes.NewReader(body))\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\treturn nil, err\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif body != nil {\n")
+	b.WriteString("\t\treq.Header.Set(\"Content-Type\", http.DetectContentType(body))\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tsum := sha256.Sum256(body)\n")
+	b.WriteString("\tpayloadHash := hex.EncodeToString(sum[:])\n")
+	b.WriteString("\tamzDate := time.Now().UTC().Format(\"20060102T150405Z\")\n")
+	b.WriteString("\tscope := amzDate[:8] + \"/\" + b.region + \"/s3/aws4_request\"\n")
+	b.WriteString("\treq.Header.Set(\"X-Amz-Content-Sha256\", payloadHash)\n")
+	b.WriteString("\treq.Header.Set(\"X-Amz-Date\", amzDate)\n")
+	b.WriteString("\tcanonical := strings.Join([]string{\n")
+	b.WriteString("\t\tmethod,\n")
+	b.WriteString("\t\treq.URL.EscapedPath(),\n")
+	b.WriteString("\t\t\"\",\n")
+	b.WriteString("\t\t\"host:\" + req.URL.Host + \"\\nx-amz-content-sha256:\" + payloadHash + \"\\nx-amz-date:\" + amzDate + \"\\n\",\n")
+	b.WriteString("\t\t\"host;x-amz-content-sha256;x-amz-date\",\n")
+	b.WriteString("\t\tpayloadHash,\n")
+	b.WriteString("\t}, \"\\n\")\n")
+	b.WriteString("\treq.Header.Set(\"Authorization\", \"AWS4-HMAC-SHA256 Credential=\"+b.accessKey+\"/\"+scope+\n")
+	b.WriteString("\t\t\", SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=\"+b.signature(amzDate, scope, canonical))\n\n")
+	b.WriteString("\tresp, err := s3Client.Do(req)\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\treturn nil, err\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif resp.StatusCode >= 300 {\n")
+	b.WriteString("\t\tmsg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))\n")
+	b.WriteString("\t\tresp.Body.Close()\n")
+	b.WriteString("\t\terr := fmt.Errorf(\"s3 %s %s: %s: %s\", method, key, resp.Status, bytes.TrimSpace(msg))\n")
+	b.WriteString("\t\tif resp.StatusCode == http.StatusNotFound {\n")
+	b.WriteString("\t\t\t// Like a missing file of the local provider\n")
+	b.WriteString("\t\t\terr = fmt.Errorf(\"%w: %w\", err, fs.ErrNotExist)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\treturn nil, err\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn resp, nil\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// presign returns a URL granting anyone GET access to an object until it expires\n")
+	b.WriteString("func (b s3Bucket) presign(key string, expires time.Duration, now time.Time) string {\n")
+	b.WriteString("\tu := b.objectURL(key)\n")
+	b.WriteString("\tamzDate := now.UTC().Format(\"20060102T150405Z\")\n")
+	b.WriteString("\tscope := amzDate[:8] + \"/\" + b.region + \"/s3/aws4_request\"\n")
+	b.WriteString("\tu.RawQuery = url.Values{\n")
+	b.WriteString("\t\t\"X-Amz-Algorithm\":     {\"AWS4-HMAC-SHA256\"},\n")
+	b.WriteString("\t\t\"X-Amz-Credential\":    {b.accessKey + \"/\" + scope},\n")
+	b.WriteString("\t\t\"X-Amz-Date\":          {amzDate},\n")
+	b.WriteString("\t\t\"X-Amz-Expires\":       {strconv.Itoa(int(expires.Seconds()))},\n")
+	b.WriteString("\t\t\"X-Amz-SignedHeaders\": {\"host\"},\n")
+	b.WriteString("\t}.Encode()\n")
+	b.WriteString("\tcanonical := strings.Join([]string{\"GET\", u.EscapedPath(), u.RawQuery, \"host:\" + u.Host + \"\\n\", \"host\", \"UNSIGNED-PAYLOAD\"}, \"\\n\")\n")
+	b.WriteString("\tu.RawQuery += \"&X-Amz-Signature=\" + b.signature(amzDate, scope, canonical)\n")
+	b.WriteString("\treturn u.String()\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// genS3Impl generates the implementation of an s3 storage service
+func (g *Generator) genS3Impl(svc *ast.ServiceDecl) string {
+	var b strings.Builder
+
+	implName := utils.LowerFirst(svc.Name) + "Impl"
+
+	b.WriteString(fmt.Sprintf("// %s stores the objects of the %s service in an S3 bucket\n", implName, svc.Name))
+	b.WriteString(fmt.Sprintf("type %s struct {\n", implName))
+	b.WriteString(fmt.Sprintf("\tconfig *%sConfig\n", svc.Name))
+	b.WriteString("\tbucket s3Bucket\n")
+	b.WriteString("}\n\n")
+
+	for _, method := range svc.Methods {
+		if method.Body != nil {
+			b.WriteString(g.genScriptServiceMethod("s", implName, method))
+			continue
+		}
+		b.WriteString(fmt.Sprintf("func (s *%s) %s%s {\n", implName, utils.ToPascalCase(method.Name), storageMethods[method.Name].goSig))
+		switch method.Name {
+		case "upload":
+			b.WriteString("\tresp, err := s.bucket.do(http.MethodPut, key, data)\n")
+			b.WriteString("\tif err != nil {\n")
+			b.WriteString("\t\treturn err\n")
+			b.WriteString("\t}\n")
+			b.WriteString("\treturn resp.Body.Close()\n")
+		case "download":
+			b.WriteString("\tresp, err := s.bucket.do(http.MethodGet, key, nil)\n")
+			b.WriteString("\tif err != nil {\n")
+			b.WriteString("\t\treturn nil, err\n")
+			b.WriteString("\t}\n")
+			b.WriteString("\tdefer resp.Body.Close()\n")
+			b.WriteString("\tdata, err := io.ReadAll(resp.Body)\n")
+			b.WriteString("\treturn Blob(data), err\n")
+		case "delete":
+			b.WriteString("\tresp, err := s.bucket.do(http.MethodDelete, key, nil)\n")
+			b.WriteString("\tif err != nil {\n")
+			b.WriteString("\t\treturn err\n")
+			b.WriteString("\t}\n")
+			b.WriteString("\treturn resp.Body.Close()\n")
+		case "signedUrl":
+			b.WriteString("\treturn s.bucket.presign(key, time.Duration(seconds)*time.Second, time.Now())\n")
+		}
+		b.WriteString("}\n\n")
+	}
+
+	endpoint := "\"\""
+	if findServiceField(svc, "endpoint") != nil {
+		endpoint = "cfg.Endpoint"
+	}
+	b.WriteString(fmt.Sprintf("// new%sService creates the S3 instance of %sService\n", svc.Name, svc.Name))
+	b.WriteString(fmt.Sprintf("func new%sService(cfg *%sConfig) %sService {\n", svc.Name, svc.Name, svc.Name))
+	b.WriteString(fmt.Sprintf("\treturn &%s{config: cfg, bucket: newS3Bucket(%s, cfg.Region, cfg.Bucket, cfg.AccessKey, cfg.SecretKey)}\n", implName, endpoint))
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// genLocalStorageHelpers generates the key resolution shared by the local
+// storage services, and the signature of their URLs
+func (g *Generator) genLocalStorageHelpers(withSignedURLs bool) string {
+	var b strings.Builder
+
+	b.WriteString("// storagePath returns the file of an object inside a storage directory,\n")
+	b.WriteString("// rejecting the keys that are not clean relative paths\n")
+	b.WriteString("func storagePath(dir, key string) (string, error) {\n")
+	b.WriteString("\tif key == \"\" || path.Clean(\"/\"+key) != \"/\"+key {\n")
+	b.WriteString("\t\treturn \"\", fmt.Errorf(\"invalid storage key %q\", key)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn filepath.Join(dir, filepath.FromSlash(key)), nil\n")
+	b.WriteString("}\n\n")
+
+	if withSignedURLs {
+		b.WriteString("// storageSign returns the HMAC signature of a local storage URL\n")
+		b.WriteString("func storageSign(secret []byte, key, expires string) string {\n")
+		b.WriteString("\tmac := hmac.New(sha256.New, secret)\n")
+		b.WriteString("\tmac.Write([]byte(key + \"\\n\" + expires))\n")
+		b.WriteString("\treturn hex.EncodeToString(mac.Sum(nil))\n")
+		b.WriteString("}\n\n")
+	}
+
+	return b.String()
+}
+
+// genLocalStorageImpl generates the implementation of a local storage
+// service and, with signedUrl, the handler serving its signed URLs
+func (g *Generator) genLocalStorageImpl(svc *ast.ServiceDecl) string {
+	var b strings.Builder
+
+	implName := utils.LowerFirst(svc.Name) + "Impl"
+	signed := providerDeclares(svc, "signedUrl")
+
+	b.WriteString(fmt.Sprintf("// %s stores the objects of the %s service in a directory\n", implName, svc.Name))
+	b.WriteString(fmt.Sprintf("type %s struct {\n", implName))
+	b.WriteString(fmt.Sprintf("\tconfig *%sConfig\n", svc.Name))
+	b.WriteString("}\n\n")
+
+	for _, method := range svc.Methods {
+		if method.Body != nil {
+			b.WriteString(g.genScriptServiceMethod("s", implName, method))
+			continue
+		}
+		b.WriteString(fmt.Sprintf("func (s *%s) %s%s {\n", implName, utils.ToPascalCase(method.Name), storageMethods[method.Name].goSig))
+		switch method.Name {
+		case "upload":
+			b.WriteString("\tfile, err := storagePath(s.config.Dir, key)\n")
+			b.WriteString("\tif err != nil {\n")
+			b.WriteString("\t\treturn err\n")
+			b.WriteString("\t}\n")
+			b.WriteString("\tif err := os.MkdirAll(filepath.Dir(file), 0o750); err != nil {\n")
+			b.WriteString("\t\treturn err\n")
+			b.WriteString("\t}\n")
+			b.WriteString("\t// Readers never see a partial file: it is renamed once written\n")
+			b.WriteString("\ttmp, err := os.CreateTemp(filepath.Dir(file), \".upload-*\")\n")
+			b.WriteString("\tif err != nil {\n")
+			b.WriteString("\t\treturn err\n")
+			b.WriteString("\t}\n")
+			b.WriteString("\tdefer os.Remove(tmp.Name())\n")
+			b.WriteString("\tif _, err := tmp.Write(data); err != nil {\n")
+			b.WriteString("\t\ttmp.Close()\n")
+			b.WriteString("\t\treturn err\n")
+			b.WriteString("\t}\n")
+			b.WriteString("\tif err := tmp.Close(); err != nil {\n")
+			b.WriteString("\t\treturn err\n")
+			b.WriteString("\t}\n")
+			b.WriteString("\treturn os.Rename(tmp.Name(), file)\n")
+		case "download":
+			b.WriteString("\tfile, err := storagePath(s.config.Dir, key)\n")
+			b.WriteString("\tif err != nil {\n")
+			b.WriteString("\t\treturn nil, err\n")
+			b.WriteString("\t}\n")
+			b.WriteString("\tdata, err := os.ReadFile(file)\n")
+			b.WriteString("\treturn Blob(data), err\n")
+		case "delete":
+			b.WriteString("\tfile, err := storagePath(s.config.Dir, key)\n")
+			b.WriteString("\tif err != nil {\n")
+			b.WriteString("\t\treturn err\n")
+			b.WriteString("\t}\n")
+			b.WriteString("\t// Like S3, deleting a missing object succeeds\n")
+			b.WriteString("\tif err := os.Remove(file); err != nil && !os.IsNotExist(err) {\n")
+			b.WriteString("\t\treturn err\n")
+			b.WriteString("\t}\n")
+			b.WriteString("\treturn nil\n")
+		case "signedUrl":
+			b.WriteString("\texpires := strconv.FormatInt(time.Now().Add(time.Duration(seconds)*time.Second).Unix(), 10)\n")
+			b.WriteString("\tquery := url.Values{\"expires\": {expires}, \"sig\": {storageSign([]byte(s.config.Secret), key, expires)}}\n")
+			b.WriteString(fmt.Sprintf("\treturn %q + (&url.URL{Path: key}).EscapedPath() + \"?\" + query.Encode()\n", storagePathPrefix+svc.Name+"/"))
+		}
+		b.WriteString("}\n\n")
+	}
+
+	if signed {
+		storageVar := utils.LowerFirst(svc.Name) + "Storage"
+		b.WriteString(fmt.Sprintf("// %s is the %s service whose signed URLs handle%sStorage serves\n", storageVar, svc.Name, svc.Name))
+		b.WriteString(fmt.Sprintf("var %s *%s\n\n", storageVar, implName))
+
+		b.WriteString(fmt.Sprintf("// handle%sStorage serves a file of the %s service to the holders of an unexpired signed URL\n", svc.Name, svc.Name))
+		b.WriteString(fmt.Sprintf("func handle%sStorage(w http.ResponseWriter, r *http.Request) {\n", svc.Name))
+		b.WriteString("\tif r.Method != http.MethodGet && r.Method != http.MethodHead {\n")
+		b.WriteString("\t\thttp.Error(w, \"Method Not Allowed\", http.StatusMethodNotAllowed)\n")
+		b.WriteString("\t\treturn\n")
+		b.WriteString("\t}\n")
+		b.WriteString("\tkey := r.PathValue(\"key\")\n")
+		b.WriteString("\texpires := r.URL.Query().Get(\"expires\")\n")
+		b.WriteString(fmt.Sprintf("\tif !hmac.Equal([]byte(r.URL.Query().Get(\"sig\")), []byte(storageSign([]byte(%s.config.Secret), key, expires))) {\n", storageVar))
+		b.WriteString("\t\thttp.Error(w, \"Forbidden\", http.StatusForbidden)\n")
+		b.WriteString("\t\treturn\n")
+		b.WriteString("\t}\n")
+		b.WriteString("\tif deadline, err := strconv.ParseInt(expires, 10, 64); err != nil || time.Now().Unix() > deadline {\n")
+		b.WriteString("\t\thttp.Error(w, \"Link expired\", http.StatusGone)\n")
+		b.WriteString("\t\treturn\n")
+		b.WriteString("\t}\n")
+		b.WriteString(fmt.Sprintf("\tfile, err := storagePath(%s.config.Dir, key)\n", storageVar))
+		b.WriteString("\tif err != nil {\n")
+		b.WriteString("\t\thttp.NotFound(w, r)\n")
+		b.WriteString("\t\treturn\n")
+		b.WriteString("\t}\n")
+		b.WriteString("\tf, err := os.Open(file)\n")
+		b.WriteString("\tif err != nil {\n")
+		b.WriteString("\t\thttp.NotFound(w, r)\n")
+		b.WriteString("\t\treturn\n")
+		b.WriteString("\t}\n")
+		b.WriteString("\tdefer f.Close()\n")
+		b.WriteString("\tinfo, err := f.Stat()\n")
+		b.WriteString("\tif err != nil || info.IsDir() {\n")
+		b.WriteString("\t\thttp.NotFound(w, r)\n")
+		b.WriteString("\t\treturn\n")
+		b.WriteString("\t}\n")
+		b.WriteString("\thttp.ServeContent(w, r, info.Name(), info.ModTime(), f)\n")
+		b.WriteString("}\n\n")
+	}
+
+	b.WriteString(fmt.Sprintf("// new%sService creates the local directory instance of %sService\n", svc.Name, svc.Name))
+	b.WriteString(fmt.Sprintf("func new%sService(cfg *%sConfig) %sService {\n", svc.Name, svc.Name, svc.Name))
+	b.WriteString("\tif err := os.MkdirAll(cfg.Dir, 0o750); err != nil {\n")
+	b.WriteString(fmt.Sprintf("\t\tlog.Fatalf(\"creating %s directory: %%v\", err)\n", svc.Name))
+	b.WriteString("\t}\n")
+	if signed {
+		b.WriteString(fmt.Sprintf("\t%s = &%s{config: cfg}\n", utils.LowerFirst(svc.Name)+"Storage", implName))
+		b.WriteString(fmt.Sprintf("\treturn %s\n", utils.LowerFirst(svc.Name)+"Storage"))
+	} else {
+		b.WriteString(fmt.Sprintf("\treturn &%s{config: cfg}\n", implName))
+	}
+	b.WriteString("}\n")
+
+	return b.String()
+}
@@ -0,0 +1,161 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+func storageService(provider string) *ast.ServiceDecl {
+	svc := &ast.ServiceDecl{
+		Name:     "Files",
+		Provider: provider,
+		Methods: []*ast.ServiceMethod{
+			{Name: "upload", Params: []*ast.Param{{Name: "key", Type: "string"}, {Name: "data", Type: "bytes"}}, ReturnType: "error"},
+			{Name: "download", Params: []*ast.Param{{Name: "key", Type: "string"}}, ReturnType: "bytes"},
+			{Name: "delete", Params: []*ast.Param{{Name: "key", Type: "string"}}, ReturnType: "error"},
+			{Name: "signedUrl", Params: []*ast.Param{{Name: "key", Type: "string"}, {Name: "seconds", Type: "int"}}, ReturnType: "string"},
+		},
+	}
+	names := []string{"dir", "secret"}
+	if provider == "s3" {
+		names = []string{"bucket", "region", "endpoint", "accessKey", "secretKey"}
+	}
+	for _, name := range names {
+		svc.Fields = append(svc.Fields, &ast.ServiceField{Name: name, Type: "string", EnvVar: strings.ToUpper(name)})
+	}
+	return svc
+}
+
+func storageFile(svc *ast.ServiceDecl) *ast.GMXFile {
+	return &ast.GMXFile{
+		Models: []*ast.ModelDecl{
+			{Name: "Doc", Fields: []*ast.FieldDecl{{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{{Name: "pk"}}}}},
+		},
+		Services: []*ast.ServiceDecl{svc},
+	}
+}
+
+func TestGenerator_Storage(t *testing.T) {
+	tests := []struct {
+		provider   string
+		expected   []string
+		unexpected []string
+	}{
+		{
+			provider: "s3",
+			expected: []string{
+				"\tDownload(key string) (Blob, error)\n",
+				"\tSignedUrl(key string, seconds int) string\n",
+				"return &filesImpl{config: cfg, bucket: newS3Bucket(cfg.Endpoint, cfg.Region, cfg.Bucket, cfg.AccessKey, cfg.SecretKey)}",
+				"resp, err := s.bucket.do(http.MethodPut, key, data)",
+				"return s.bucket.presign(key, time.Duration(seconds)*time.Second, time.Now())",
+				"\"host;x-amz-content-sha256;x-amz-date\",",
+			},
+			unexpected: []string{"storagePath", "/_gmx/storage/"},
+		},
+		{
+			provider: "local",
+			expected: []string{
+				"\tUpload(key string, data Blob) error\n",
+				"return os.Rename(tmp.Name(), file)",
+				`return "/_gmx/storage/Files/" + (&url.URL{Path: key}).EscapedPath() + "?" + query.Encode()`,
+				"mux.HandleFunc(\"/_gmx/storage/Files/{key...}\", handleFilesStorage)",
+				"\tfilesStorage = &filesImpl{config: cfg}\n",
+			},
+			unexpected: []string{"s3Bucket", "(stub)"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			code, err := New().Generate(storageFile(storageService(tt.provider)))
+			if err != nil {
+				t.Fatalf("Generate() error: %v", err)
+			}
+			if !isValidGo(code) {
+				t.Errorf("Generated code is not valid Go:\n%s", code)
+			}
+			for _, want := range tt.expected {
+				if !strings.Contains(code, want) {
+					t.Errorf("expected %q in generated code", want)
+				}
+			}
+			for _, unwanted := range tt.unexpected {
+				if strings.Contains(code, unwanted) {
+					t.Errorf("unexpected %q in generated code", unwanted)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerator_StorageWithoutEndpoint(t *testing.T) {
+	svc := storageService("s3")
+	svc.Fields = append(svc.Fields[:2], svc.Fields[3:]...)
+	svc.Methods = svc.Methods[2:3]
+	code, err := New().Generate(storageFile(svc))
+	if err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+	if !strings.Contains(code, `newS3Bucket("", cfg.Region,`) {
+		t.Error("expected the AWS endpoint when no endpoint field is declared")
+	}
+}
+
+func TestGenerator_StorageErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		modify   func(svc *ast.ServiceDecl)
+		wantErr  string
+	}{
+		{
+			name:     "missing field",
+			provider: "s3",
+			modify: func(svc *ast.ServiceDecl) {
+				svc.Fields = svc.Fields[1:]
+			},
+			wantErr: "service Files: s3 provider requires a `bucket` field",
+		},
+		{
+			name:     "unknown method",
+			provider: "local",
+			modify: func(svc *ast.ServiceDecl) {
+				svc.Methods = append(svc.Methods, &ast.ServiceMethod{Name: "list", ReturnType: "string"})
+			},
+			wantErr: "service Files: unsupported local method list (expected upload, download, delete or signedUrl, or a script body)",
+		},
+		{
+			name:     "signature",
+			provider: "s3",
+			modify: func(svc *ast.ServiceDecl) {
+				svc.Methods[1].ReturnType = "string"
+			},
+			wantErr: "service Files: method download takes (string) and returns bytes",
+		},
+		{
+			name:     "local signed URL without secret",
+			provider: "local",
+			modify: func(svc *ast.ServiceDecl) {
+				svc.Fields = svc.Fields[:1]
+			},
+			wantErr: "service Files: signedUrl on a local provider requires a `secret` field",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := storageService(tt.provider)
+			tt.modify(svc)
+			_, err := New().Generate(storageFile(svc))
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/btouchard/gmx/internal/compiler/ast"
 	"github.com/btouchard/gmx/internal/compiler/resolver"
 	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -34,7 +35,7 @@ func (g *Generator) genRouteRegistry(templateSource string) map[string]string {
 }
 
 // genTemplateInit generates the template initialization code with FuncMap
-func (g *Generator) genTemplateInit(routes map[string]string) string {
+func (g *Generator) genTemplateInit(file *ast.GMXFile, routes map[string]string) string {
 	var b strings.Builder
 
 	b.WriteString("var tmpl *template.Template\n\n")
@@ -54,6 +55,66 @@ func (g *Generator) genTemplateInit(routes map[string]string) string {
 	b.WriteString("\t\t\t}\n")
 	b.WriteString("\t\t\treturn \"/api/\" + name\n")
 	b.WriteString("\t\t},\n")
+	b.WriteString(g.genInputTypeFunc(file.Models))
+	if g.hasFuncAnnotation(file, "honeypot") {
+		b.WriteString("\t\t\"honeypot\": honeypotField,\n")
+	}
+	if g.hasFuncAnnotation(file, "once") {
+		b.WriteString("\t\t\"once\": onceField,\n")
+	}
+	if g.hasItemIsolation(file) {
+		b.WriteString("\t\t\"renderItem\": renderItem,\n")
+	}
+	if g.needsMoney(file) {
+		b.WriteString("\t\t\"money\": formatMoney,\n")
+	}
+	if g.hasJSONField(file) {
+		b.WriteString("\t\t\"json\": jsonString,\n")
+	}
+	if g.hasNotifications(file) {
+		b.WriteString("\t\t\"notificationBadge\": func() template.HTML {\n")
+		b.WriteString(fmt.Sprintf("\t\t\treturn template.HTML(`<span hx-get=%q hx-trigger=\"load\" hx-swap=\"outerHTML\"></span>`)\n", notificationsCountPath))
+		b.WriteString("\t\t},\n")
+	}
+	if g.hasTypeahead(file) {
+		b.WriteString("\t\t\"typeahead\": typeaheadInput,\n")
+		b.WriteString("\t\t\"highlight\": highlightMatch,\n")
+	}
+	if g.hasModal(file) {
+		b.WriteString("\t\t\"modal\": modalTrigger,\n")
+	}
+	if g.hasWizards(file) {
+		b.WriteString("\t\t\"wizard\": wizardLoader,\n")
+	}
+	if g.hasAutosave(file) {
+		b.WriteString("\t\t\"autosave\": autosaveLoader,\n")
+	}
+	if g.hasActivityFeed(file) {
+		b.WriteString("\t\t\"activityFeed\": func() template.HTML {\n")
+		b.WriteString(fmt.Sprintf("\t\t\treturn template.HTML(`<div class=\"gmx-feed\"><div hx-get=%q hx-trigger=\"load\" hx-swap=\"outerHTML\"></div></div>`)\n", feedPath))
+		b.WriteString("\t\t},\n")
+	}
+	if g.hasImpersonation(file) {
+		b.WriteString("\t\t\"impersonationBanner\": func() template.HTML {\n")
+		b.WriteString("\t\t\treturn template.HTML(`<div hx-get=\"/_gmx/impersonation\" hx-trigger=\"load\" hx-swap=\"outerHTML\"></div>`)\n")
+		b.WriteString("\t\t},\n")
+	}
+	if g.hasTwoFactor(file) {
+		b.WriteString("\t\t\"twoFactorSetup\": func() template.HTML {\n")
+		b.WriteString(fmt.Sprintf("\t\t\treturn template.HTML(`<div class=\"gmx-2fa\" id=\"gmx-2fa\" hx-get=%q hx-trigger=\"load\" hx-swap=\"outerHTML\"></div>`)\n", twoFactorSetupPath))
+		b.WriteString("\t\t},\n")
+	}
+	if g.hasAccounts(file) {
+		b.WriteString("\t\t\"accountSettings\": func() template.HTML {\n")
+		b.WriteString(fmt.Sprintf("\t\t\treturn template.HTML(`<div class=\"gmx-account\" id=\"gmx-account\" hx-get=%q hx-trigger=\"load\" hx-swap=\"outerHTML\"></div>`)\n", accountPath))
+		b.WriteString("\t\t},\n")
+	}
+	if g.hasConsent(file) {
+		b.WriteString("\t\t\"policyVersion\": func() string { return policyVersion },\n")
+	}
+	if g.hasPolicyURL(file) {
+		b.WriteString("\t\t\"policyUrl\": func() string { return policyURL },\n")
+	}
 	b.WriteString("\t}\n\n")
 	b.WriteString("\ttmpl = template.Must(template.New(\"page\").Funcs(funcMap).Parse(pageTemplate))\n")
 	b.WriteString("}\n")
@@ -61,17 +122,177 @@ func (g *Generator) genTemplateInit(routes map[string]string) string {
 	return b.String()
 }
 
+// hasItemIsolation checks if model lists of the template render each item
+// in isolation through renderItem
+func (g *Generator) hasItemIsolation(file *ast.GMXFile) bool {
+	return file.Template != nil && len(file.Models) > 0
+}
+
+// genRenderItem generates renderItem, which renders one list item into a
+// buffer so that an error or a panic only replaces that item with a fallback
+func (g *Generator) genRenderItem() string {
+	var b strings.Builder
+
+	b.WriteString("// renderItemFallback replaces a list item that failed to render\n")
+	b.WriteString("const renderItemFallback = template.HTML(`<div class=\"gmx-render-error\" role=\"alert\">Failed to render this item</div>`)\n\n")
+
+	b.WriteString("// renderItem executes the named template for one list item; on error or\n")
+	b.WriteString("// panic it logs and renders renderItemFallback so the list goes on\n")
+	b.WriteString("func renderItem(name string, data interface{}) (out template.HTML) {\n")
+	b.WriteString("\tdefer func() {\n")
+	b.WriteString("\t\tif r := recover(); r != nil {\n")
+	b.WriteString("\t\t\tslog.Error(\"template: item panicked\", \"template\", name, \"panic\", r)\n")
+	b.WriteString("\t\t\tout = renderItemFallback\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}()\n\n")
+	b.WriteString("\tvar buf strings.Builder\n")
+	b.WriteString("\tif err := tmpl.ExecuteTemplate(&buf, name, data); err != nil {\n")
+	b.WriteString("\t\tslog.Error(\"template: failed to render item\", \"template\", name, \"error\", err)\n")
+	b.WriteString("\t\treturn renderItemFallback\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn template.HTML(buf.String())\n")
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// genInputTypeFunc generates the "inputType" template function, which returns the
+// HTML input type for a model field: {{inputType "User" "password"}} → "password"
+func (g *Generator) genInputTypeFunc(models []*ast.ModelDecl) string {
+	var b strings.Builder
+
+	b.WriteString("\t\t\"inputType\": func(model, field string) string {\n")
+	b.WriteString("\t\t\ttypes := map[string]string{\n")
+	for _, model := range models {
+		for _, field := range model.Fields {
+			if inputType := htmlInputType(field); inputType != "text" {
+				b.WriteString(fmt.Sprintf("\t\t\t\t%q: %q,\n", model.Name+"."+field.Name, inputType))
+			}
+		}
+	}
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t\tif t, ok := types[model+\".\"+field]; ok {\n")
+	b.WriteString("\t\t\t\treturn t\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t\treturn \"text\"\n")
+	b.WriteString("\t\t},\n")
+
+	return b.String()
+}
+
+// htmlInputType maps a model field to the HTML input type used in forms
+func htmlInputType(field *ast.FieldDecl) string {
+	switch field.Type {
+	case "password":
+		return "password"
+	case "int", "float":
+		return "number"
+	case "bool":
+		return "checkbox"
+	case "datetime":
+		return "datetime-local"
+	case "bytes", "image":
+		return "file"
+	}
+	for _, ann := range field.Annotations {
+		if ann.Name == "email" {
+			return "email"
+		}
+	}
+	return "text"
+}
+
 // genTemplateConst generates the pageTemplate constant with full HTML structure
-func (g *Generator) genTemplateConst(file *ast.GMXFile, components map[string]*resolver.ComponentInfo) string {
+func (g *Generator) genTemplateConst(file *ast.GMXFile, components map[string]*resolver.ComponentInfo, fragments map[string]*resolver.FragmentInfo, styles styleSheets) string {
 	var b strings.Builder
 
-	// Check if the template already contains a full HTML page
-	templateSrc := ""
-	if file.Template != nil {
-		templateSrc = file.Template.Source
+	var htmlStr string
+	if len(file.Pages) > 0 {
+		// Directory builds define one template per page, executed by its handler
+		var pages strings.Builder
+		for _, page := range file.Pages {
+			src := injectHoneypotFields(page.Template.Source, g.funcsWithAnnotation(file, "honeypot"))
+			src = injectOnceFields(src, g.funcsWithAnnotation(file, "once"))
+			src = injectAutosaveFields(src, g.funcsWithAnnotation(file, "autosave"))
+			if g.hasLive(file) {
+				src = injectLiveConnections(src, liveModels(file))
+			}
+			if g.hasModal(file) {
+				src = injectModalScript(src)
+			}
+			if g.hasAutosave(file) {
+				src = injectAutosaveScript(src)
+			}
+			src, defines := hoistPageDefines(page, src)
+			pages.WriteString(fmt.Sprintf("{{define %q}}", pageTemplateName(page)))
+			pages.WriteString(g.pageHTML(src, page.Style, styles))
+			pages.WriteString("{{end}}\n")
+			pages.WriteString(defines)
+		}
+		htmlStr = pages.String()
+	} else {
+		templateSrc := ""
+		if file.Template != nil {
+			templateSrc = injectHoneypotFields(file.Template.Source, g.funcsWithAnnotation(file, "honeypot"))
+			templateSrc = injectOnceFields(templateSrc, g.funcsWithAnnotation(file, "once"))
+			templateSrc = injectAutosaveFields(templateSrc, g.funcsWithAnnotation(file, "autosave"))
+		}
+		if g.hasLive(file) {
+			templateSrc = injectLiveConnections(templateSrc, liveModels(file))
+		}
+		if g.hasModal(file) {
+			templateSrc = injectModalScript(templateSrc)
+		}
+		if g.hasAutosave(file) {
+			templateSrc = injectAutosaveScript(templateSrc)
+		}
+		htmlStr = g.pageHTML(templateSrc, file.Style, styles)
 	}
 
-	// Case-insensitive check for existing HTML structure
+	// Extract model range blocks into {{define}} sub-templates
+	if len(file.Models) > 0 {
+		htmlStr = g.extractModelFragments(htmlStr, file.Models)
+	}
+
+	// Append component template definitions
+	if len(components) > 0 {
+		htmlStr += "\n" + g.genComponentTemplates(components)
+	}
+
+	// Append fragments of other pages, then turn {{fragment}} calls into {{template}} calls
+	if len(fragments) > 0 {
+		htmlStr += "\n" + g.genFragmentTemplates(fragments)
+	}
+
+	// Wizard steps render with their default form unless the page defines it
+	if g.hasWizards(file) {
+		htmlStr += "\n" + genWizardTemplates(file, htmlStr)
+	}
+
+	// Handlers render their validation errors with the ValidationError
+	// fragment, which the template may define itself
+	if g.hasScriptHandlers(file) && !strings.Contains(htmlStr, `define "ValidationError"`) {
+		htmlStr += "\n" + validationErrorTemplate
+	}
+	htmlStr = resolver.RewriteFragmentCalls(htmlStr)
+
+	if g.opts.Minify {
+		htmlStr = minifyHTML(htmlStr)
+	}
+
+	// Use const with string concatenation to handle backticks
+	b.WriteString("const pageTemplate = ")
+	b.WriteString(escapeTemplateString(htmlStr))
+	b.WriteString("\n")
+
+	return b.String()
+}
+
+// pageHTML wraps a page template into a full HTML document, or completes the
+// document it already is, with its styles and the CSRF protection
+func (g *Generator) pageHTML(templateSrc string, style *ast.StyleBlock, styles styleSheets) string {
+	// Check if the template already contains a full HTML page
+	// (case-insensitive)
 	lowerSrc := strings.ToLower(templateSrc)
 	hasFullHTML := strings.Contains(lowerSrc, "<!doctype") || strings.Contains(lowerSrc, "<html")
 
@@ -79,16 +300,30 @@ func (g *Generator) genTemplateConst(file *ast.GMXFile, components map[string]*r
 
 	if hasFullHTML {
 		// Template already has full HTML - use it as-is, only inject CSS if needed
-		// Merge component styles
-		componentStyles := g.genComponentStyles(components)
+		// Component styles are bundled into a stylesheet; only single-file styles are inlined
 		allStyles := ""
-		if file.Style != nil && file.Style.Source != "" {
-			allStyles = file.Style.Source + "\n" + componentStyles
-		} else {
-			allStyles = componentStyles
+		if style != nil {
+			allStyles = style.Source
 		}
 
-		if allStyles != "" {
+		if !styles.empty() {
+			// Inject the multi-file stylesheets and CSRF protection before </head>
+			headEndIdx := strings.Index(templateSrc, "</head>")
+			if headEndIdx == -1 {
+				headEndIdx = strings.Index(templateSrc, "</HEAD>")
+			}
+
+			if headEndIdx != -1 {
+				var html strings.Builder
+				html.WriteString(templateSrc[:headEndIdx])
+				html.WriteString(styles.headTags("  "))
+				html.WriteString(htmxHeadTags("  "))
+				html.WriteString(templateSrc[headEndIdx:])
+				htmlStr = html.String()
+			} else {
+				htmlStr = templateSrc
+			}
+		} else if allStyles != "" {
 			// Find </head> and inject style before it
 			headEndIdx := strings.Index(templateSrc, "</head>")
 			if headEndIdx == -1 {
@@ -104,17 +339,7 @@ func (g *Generator) genTemplateConst(file *ast.GMXFile, components map[string]*r
 				html.WriteString("  " + allStyles + "\n")
 				html.WriteString("  </style>\n")
 				// Inject CSRF protection
-				html.WriteString("  <meta name=\"csrf-token\" content=\"{{.CSRFToken}}\">\n")
-				html.WriteString("  <script>\n")
-				html.WriteString("    document.addEventListener('DOMContentLoaded', function() {\n")
-				html.WriteString("      document.body.addEventListener('htmx:configRequest', function(e) {\n")
-				html.WriteString("        var token = document.querySelector('meta[name=\"csrf-token\"]');\n")
-				html.WriteString("        if (token) {\n")
-				html.WriteString("          e.detail.headers['X-CSRF-Token'] = token.content;\n")
-				html.WriteString("        }\n")
-				html.WriteString("      });\n")
-				html.WriteString("    });\n")
-				html.WriteString("  </script>\n")
+				html.WriteString(htmxHeadTags("  "))
 				html.WriteString(templateSrc[headEndIdx:])
 				htmlStr = html.String()
 			} else {
@@ -132,17 +357,7 @@ func (g *Generator) genTemplateConst(file *ast.GMXFile, components map[string]*r
 				// Inject CSRF protection before </head>
 				var html strings.Builder
 				html.WriteString(templateSrc[:headEndIdx])
-				html.WriteString("  <meta name=\"csrf-token\" content=\"{{.CSRFToken}}\">\n")
-				html.WriteString("  <script>\n")
-				html.WriteString("    document.addEventListener('DOMContentLoaded', function() {\n")
-				html.WriteString("      document.body.addEventListener('htmx:configRequest', function(e) {\n")
-				html.WriteString("        var token = document.querySelector('meta[name=\"csrf-token\"]');\n")
-				html.WriteString("        if (token) {\n")
-				html.WriteString("          e.detail.headers['X-CSRF-Token'] = token.content;\n")
-				html.WriteString("        }\n")
-				html.WriteString("      });\n")
-				html.WriteString("    });\n")
-				html.WriteString("  </script>\n")
+				html.WriteString(htmxHeadTags("  "))
 				html.WriteString(templateSrc[headEndIdx:])
 				htmlStr = html.String()
 			} else {
@@ -161,17 +376,16 @@ func (g *Generator) genTemplateConst(file *ast.GMXFile, components map[string]*r
 		html.WriteString("    <script src=\"https://cdn.tailwindcss.com\"></script>\n")
 		html.WriteString("    <script src=\"https://unpkg.com/htmx.org@2.0.4\"></script>\n")
 
-		// Merge component styles
-		componentStyles := g.genComponentStyles(components)
+		// Component styles are bundled into a stylesheet; only single-file styles are inlined
 		allStyles := ""
-		if file.Style != nil && file.Style.Source != "" {
-			allStyles = file.Style.Source + "\n" + componentStyles
-		} else {
-			allStyles = componentStyles
+		if style != nil {
+			allStyles = style.Source
 		}
 
-		// Inject CSS if present
-		if allStyles != "" {
+		// Inject CSS if present; multi-file builds load the bundled stylesheets
+		if !styles.empty() {
+			html.WriteString(styles.headTags("    "))
+		} else if allStyles != "" {
 			html.WriteString("    <style>\n")
 			html.WriteString("    /* GMX Scoped Styles */\n")
 			html.WriteString("    " + allStyles + "\n")
@@ -179,17 +393,7 @@ func (g *Generator) genTemplateConst(file *ast.GMXFile, components map[string]*r
 		}
 
 		// Inject CSRF protection (always included)
-		html.WriteString("    <meta name=\"csrf-token\" content=\"{{.CSRFToken}}\">\n")
-		html.WriteString("    <script>\n")
-		html.WriteString("      document.addEventListener('DOMContentLoaded', function() {\n")
-		html.WriteString("        document.body.addEventListener('htmx:configRequest', function(e) {\n")
-		html.WriteString("          var token = document.querySelector('meta[name=\"csrf-token\"]');\n")
-		html.WriteString("          if (token) {\n")
-		html.WriteString("            e.detail.headers['X-CSRF-Token'] = token.content;\n")
-		html.WriteString("          }\n")
-		html.WriteString("        });\n")
-		html.WriteString("      });\n")
-		html.WriteString("    </script>\n")
+		html.WriteString(htmxHeadTags("    "))
 
 		html.WriteString("</head>\n")
 		html.WriteString("<body class=\"p-4\">\n")
@@ -204,21 +408,35 @@ func (g *Generator) genTemplateConst(file *ast.GMXFile, components map[string]*r
 		htmlStr = html.String()
 	}
 
-	// Extract model range blocks into {{define}} sub-templates
-	if len(file.Models) > 0 {
-		htmlStr = g.extractModelFragments(htmlStr, file.Models)
-	}
+	return htmlStr
+}
 
-	// Append component template definitions
-	if len(components) > 0 {
-		htmlStr += "\n" + g.genComponentTemplates(components)
+// htmxHeadTags returns the head tags sending the CSRF token with htmx requests,
+// and letting htmx swap validation errors (422) like successful responses
+func htmxHeadTags(indent string) string {
+	lines := []string{
+		`<meta name="csrf-token" content="{{.CSRFToken}}">`,
+		`<script>`,
+		`  document.addEventListener('DOMContentLoaded', function() {`,
+		`    document.body.addEventListener('htmx:configRequest', function(e) {`,
+		`      var token = document.querySelector('meta[name="csrf-token"]');`,
+		`      if (token) {`,
+		`        e.detail.headers['X-CSRF-Token'] = token.content;`,
+		`      }`,
+		`    });`,
+		`    document.body.addEventListener('htmx:beforeSwap', function(e) {`,
+		`      if (e.detail.xhr.status === 422) {`,
+		`        e.detail.shouldSwap = true;`,
+		`        e.detail.isError = false;`,
+		`      }`,
+		`    });`,
+		`  });`,
+		`</script>`,
+	}
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(indent + line + "\n")
 	}
-
-	// Use const with string concatenation to handle backticks
-	b.WriteString("const pageTemplate = ")
-	b.WriteString(escapeTemplateString(htmlStr))
-	b.WriteString("\n")
-
 	return b.String()
 }
 
@@ -245,7 +463,9 @@ func escapeTemplateString(s string) string {
 
 // extractModelFragments finds {{range .ModelNames}} blocks in the template,
 // extracts their body into {{define "Model"}} sub-templates, and replaces the
-// range body with {{template "Model" .}} so that renderFragment can reuse them.
+// range body with {{renderItem "Model" .}} so that renderFragment can reuse them.
+// The items of @live models are identified, and followed by the marker before
+// which other clients insert the created ones.
 func (g *Generator) extractModelFragments(htmlStr string, models []*ast.ModelDecl) string {
 	var defines strings.Builder
 	defines.WriteString("\n<!-- ========== Model Fragment Templates ========== -->\n")
@@ -253,63 +473,27 @@ func (g *Generator) extractModelFragments(htmlStr string, models []*ast.ModelDec
 
 	for _, model := range models {
 		// Simple plural: ModelName + "s" (matches genPageData convention)
-		plural := model.Name + "s"
-		rangeOpen := "{{range ." + plural + "}}"
-
-		startIdx := strings.Index(htmlStr, rangeOpen)
-		if startIdx == -1 {
+		rangeOpen := "{{range ." + model.Name + "s}}"
+		startIdx, bodyStart, bodyEnd, ok := findRangeBlock(htmlStr, rangeOpen)
+		if !ok {
 			continue
 		}
+		body := htmlStr[bodyStart:bodyEnd]
 
-		// Find the matching {{end}} by counting nesting depth
-		bodyStart := startIdx + len(rangeOpen)
-		depth := 1
-		pos := bodyStart
-		for pos < len(htmlStr) && depth > 0 {
-			nextOpen := strings.Index(htmlStr[pos:], "{{range ")
-			nextIf := strings.Index(htmlStr[pos:], "{{if ")
-			nextWith := strings.Index(htmlStr[pos:], "{{with ")
-			nextBlock := strings.Index(htmlStr[pos:], "{{block ")
-			nextEnd := strings.Index(htmlStr[pos:], "{{end}}")
-
-			if nextEnd == -1 {
-				break
-			}
+		// Replace range body with {{renderItem "Model" .}}, isolating failures per item
+		replacement := rangeOpen + "{{renderItem " + fmt.Sprintf("%q", model.Name) + " .}}" + "{{end}}"
+		if model.FindAnnotation("live") != nil {
+			body = injectLiveID(body, model)
+			replacement += fmt.Sprintf("<template id=\"gmx-live-%s\"></template>", model.Name)
+		}
 
-			// Find the nearest opening block before this {{end}}
-			minOpen := nextEnd // default: no opener before this end
-			for _, idx := range []int{nextOpen, nextIf, nextWith, nextBlock} {
-				if idx >= 0 && idx < minOpen {
-					minOpen = idx
-				}
-			}
+		// Create {{define "Model"}} block
+		defines.WriteString(fmt.Sprintf("\n{{define %q}}", model.Name))
+		defines.WriteString(body)
+		defines.WriteString("{{end}}\n")
+		hasDefines = true
 
-			if minOpen < nextEnd {
-				// An opening block comes before this {{end}}, increase depth
-				depth++
-				pos += minOpen + 2 // skip past "{{"
-			} else {
-				// This {{end}} closes a block
-				depth--
-				if depth == 0 {
-					bodyEnd := pos + nextEnd
-					body := htmlStr[bodyStart:bodyEnd]
-
-					// Create {{define "Model"}} block
-					defines.WriteString(fmt.Sprintf("\n{{define %q}}", model.Name))
-					defines.WriteString(body)
-					defines.WriteString("{{end}}\n")
-					hasDefines = true
-
-					// Replace range body with {{template "Model" .}}
-					replacement := rangeOpen + "{{template " + fmt.Sprintf("%q", model.Name) + " .}}" + "{{end}}"
-					original := htmlStr[startIdx : bodyEnd+len("{{end}}")]
-					htmlStr = strings.Replace(htmlStr, original, replacement, 1)
-					break
-				}
-				pos += nextEnd + len("{{end}}")
-			}
-		}
+		htmlStr = htmlStr[:startIdx] + replacement + htmlStr[bodyEnd+len("{{end}}"):]
 	}
 
 	if hasDefines {
@@ -319,16 +503,71 @@ func (g *Generator) extractModelFragments(htmlStr string, models []*ast.ModelDec
 	return htmlStr
 }
 
+// findRangeBlock locates the first block opened by rangeOpen in htmlStr: where
+// it starts, where its body starts, and where the {{end}} closing it starts
+func findRangeBlock(htmlStr, rangeOpen string) (int, int, int, bool) {
+	startIdx := strings.Index(htmlStr, rangeOpen)
+	if startIdx == -1 {
+		return 0, 0, 0, false
+	}
+
+	// Find the matching {{end}} by counting nesting depth
+	bodyStart := startIdx + len(rangeOpen)
+	depth := 1
+	pos := bodyStart
+	for pos < len(htmlStr) && depth > 0 {
+		nextOpen := strings.Index(htmlStr[pos:], "{{range ")
+		nextIf := strings.Index(htmlStr[pos:], "{{if ")
+		nextWith := strings.Index(htmlStr[pos:], "{{with ")
+		nextBlock := strings.Index(htmlStr[pos:], "{{block ")
+		nextEnd := strings.Index(htmlStr[pos:], "{{end}}")
+
+		if nextEnd == -1 {
+			break
+		}
+
+		// Find the nearest opening block before this {{end}}
+		minOpen := nextEnd // default: no opener before this end
+		for _, idx := range []int{nextOpen, nextIf, nextWith, nextBlock} {
+			if idx >= 0 && idx < minOpen {
+				minOpen = idx
+			}
+		}
+
+		if minOpen < nextEnd {
+			// An opening block comes before this {{end}}, increase depth
+			depth++
+			pos += minOpen + 2 // skip past "{{"
+		} else {
+			// This {{end}} closes a block
+			depth--
+			if depth == 0 {
+				return startIdx, bodyStart, pos + nextEnd, true
+			}
+			pos += nextEnd + len("{{end}}")
+		}
+	}
+	return 0, 0, 0, false
+}
+
 // genComponentTemplates generates {{define}} blocks for each component
 func (g *Generator) genComponentTemplates(components map[string]*resolver.ComponentInfo) string {
 	if len(components) == 0 {
 		return ""
 	}
 
+	// Sorted so that the generated code is stable across builds
+	names := make([]string, 0, len(components))
+	for name := range components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
 	var b strings.Builder
 	b.WriteString("\n<!-- ========== Component Templates ========== -->\n\n")
 
-	for name, info := range components {
+	for _, name := range names {
+		info := components[name]
 		if info.File.Template == nil {
 			continue
 		}
@@ -342,23 +581,54 @@ func (g *Generator) genComponentTemplates(components map[string]*resolver.Compon
 	return b.String()
 }
 
-// genComponentStyles merges all component styles
-func (g *Generator) genComponentStyles(components map[string]*resolver.ComponentInfo) string {
-	if len(components) == 0 {
-		return ""
+// genFragmentTemplates generates {{define}} blocks for the fragments exported by other pages
+func (g *Generator) genFragmentTemplates(fragments map[string]*resolver.FragmentInfo) string {
+	names := make([]string, 0, len(fragments))
+	for name := range fragments {
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
 	var b strings.Builder
+	b.WriteString("\n<!-- ========== Page Fragments ========== -->\n\n")
+
+	for _, name := range names {
+		info := fragments[name]
+		b.WriteString(fmt.Sprintf("<!-- Fragment: %s (from %s) -->\n", name, info.Path))
+		b.WriteString(fmt.Sprintf("{{define %q}}", name))
+		b.WriteString(info.Source)
+		b.WriteString("{{end}}\n\n")
+	}
 
-	for name, info := range components {
-		if info.File.Style == nil || info.File.Style.Source == "" {
-			continue
-		}
+	return b.String()
+}
 
-		b.WriteString(fmt.Sprintf("\n/* Component: %s */\n", name))
-		b.WriteString(info.File.Style.Source)
-		b.WriteString("\n")
+// injectHoneypotFields adds {{honeypot}} to every form posting to a @honeypot function,
+// unless the form already places it explicitly
+func injectHoneypotFields(src string, funcs []*ast.FuncDecl) string {
+	for _, fn := range funcs {
+		src = injectFormField(src, fn, "{{honeypot}}")
 	}
+	return src
+}
 
-	return b.String()
+// injectFormField adds field after the opening tag of every form posting to
+// fn, unless the form already places it explicitly
+func injectFormField(src string, fn *ast.FuncDecl, field string) string {
+	re := regexp.MustCompile(`<form\b[^>]*\{\{\s*route\s+["` + "`" + `]` + regexp.QuoteMeta(fn.Name) + `["` + "`" + `]\s*\}\}[^>]*>`)
+	var out strings.Builder
+	last := 0
+	for _, loc := range re.FindAllStringIndex(src, -1) {
+		out.WriteString(src[last:loc[1]])
+		last = loc[1]
+		body := src[loc[1]:]
+		if end := strings.Index(strings.ToLower(body), "</form>"); end != -1 {
+			body = body[:end]
+		}
+		if !strings.Contains(body, field) {
+			out.WriteString(field)
+		}
+	}
+	out.WriteString(src[last:])
+	return out.String()
 }
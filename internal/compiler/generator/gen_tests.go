@@ -0,0 +1,307 @@
+package generator
+
+import (
+	"fmt"
+	"go/format"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/resolver"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// testSeedRows is the number of records seeded per model before each test
+const testSeedRows = 3
+
+// testCSRFToken is the double-submit token carried by the test requests
+const testCSRFToken = "gmx-test-csrf-token"
+
+// GenerateTests returns a Go test file, in the package of the generated
+// server, with a table test per handler run through the CSRF protection of
+// the server against an in-memory SQLite database seeded by the model
+// factories: method guard, CSRF rejection, missing parameters, access
+// control and a happy path
+func (g *Generator) GenerateTests(resolved *resolver.ResolvedFile) (string, error) {
+	file, err := g.selectCompileTime(resolved.Main)
+	if err != nil {
+		return "", err
+	}
+
+	funcs := make(map[string]*ast.FuncDecl)
+	if file.Script != nil {
+		for _, fn := range file.Script.Funcs {
+			funcs["handle"+utils.Capitalize(fn.Name)] = fn
+		}
+	}
+
+	// Pages and script handlers are tested; template routes without a function are stubs
+	var routes []Route
+	var tests strings.Builder
+	for _, route := range g.Routes(resolved) {
+		if route.Source == "page" {
+			routes = append(routes, route)
+			tests.WriteString(genPageTest(route))
+			continue
+		}
+		fn, ok := funcs[route.Handler]
+		if !ok || !strings.HasPrefix(route.Source, "func ") {
+			continue
+		}
+		routes = append(routes, route)
+		tests.WriteString(genHandlerTest(route, fn, file.Models))
+	}
+
+	var b strings.Builder
+	b.WriteString("// Code generated by gmx build -with-tests. DO NOT EDIT.\n\n")
+	b.WriteString(fmt.Sprintf("package %s\n\n", g.packageName()))
+	b.WriteString("import (\n")
+	b.WriteString("\t\"net/http\"\n")
+	b.WriteString("\t\"net/http/httptest\"\n")
+	b.WriteString("\t\"net/url\"\n")
+	b.WriteString("\t\"strings\"\n")
+	b.WriteString("\t\"testing\"\n\n")
+	b.WriteString("\t\"gorm.io/driver/sqlite\"\n")
+	b.WriteString("\t\"gorm.io/gorm\"\n")
+	b.WriteString("\t\"gorm.io/gorm/logger\"\n")
+	b.WriteString(")\n\n")
+	migrated := g.withGeneratedModels(file).Models
+	b.WriteString(genSeedSetup("test", "t *testing.T", testSeedRows, file.Models, migrated, g.genTestConfigure(file)))
+	b.WriteString(genTestServer(routes))
+	b.WriteString(tests.String())
+
+	formatted, err := format.Source([]byte(b.String()))
+	if err != nil {
+		return "", fmt.Errorf("formatting tests: %w", err)
+	}
+	return string(formatted), nil
+}
+
+// genTestConfigure generates the configuration of the session service in
+// testSetup, with its defaults and test values for the string fields without one
+func (g *Generator) genTestConfigure(file *ast.GMXFile) string {
+	svc := g.findSessionService(file.Services)
+	if svc == nil {
+		return ""
+	}
+	var fields []string
+	for _, field := range svc.Fields {
+		if field.Type != "string" {
+			continue
+		}
+		fields = append(fields, fmt.Sprintf("%s: %q", utils.ToPascalCase(field.Name), testConfigValue(field)))
+	}
+	return fmt.Sprintf("\tconfigure%s(&%sConfig{%s})\n", svc.Name, svc.Name, strings.Join(fields, ", "))
+}
+
+// testConfigValue returns the value of a session field in tests: its
+// default, else a valid value for the fields configure parses
+func testConfigValue(field *ast.ServiceField) string {
+	if def := serviceFieldDefault(field); def != nil {
+		return *def
+	}
+	switch field.Name {
+	case "secret":
+		return "gmx-test-secret"
+	case "ttl":
+		return "24h"
+	case "issuer":
+		return "GMX Test"
+	case "url":
+		return "redis://localhost:6379/0"
+	case "lockoutAttempts":
+		return "5"
+	case "lockoutWindow":
+		return "15m"
+	case "deletionGrace":
+		return "720h"
+	}
+	return ""
+}
+
+// genTestServer generates the server the tests send their requests to, and
+// the helpers building those requests and checking their status
+func genTestServer(routes []Route) string {
+	var b strings.Builder
+
+	b.WriteString(fmt.Sprintf("// testCSRFToken is the double-submit token of the test requests\nconst testCSRFToken = %q\n\n", testCSRFToken))
+
+	b.WriteString("// testServer returns the tested handlers behind the CSRF protection and\n")
+	b.WriteString("// security headers of the server\n")
+	b.WriteString("func testServer() http.Handler {\n")
+	b.WriteString("\tmux := http.NewServeMux()\n")
+	for _, route := range routes {
+		b.WriteString(fmt.Sprintf("\tmux.HandleFunc(%q, %s)\n", route.Path, route.Handler))
+	}
+	b.WriteString("\treturn csrfProtect(securityHeaders(mux))\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// handlerTest is a request to a handler and the status it expects; zero\n")
+	b.WriteString("// expects a success\n")
+	b.WriteString("type handlerTest struct {\n")
+	b.WriteString("\tname   string\n")
+	b.WriteString("\tmethod string\n")
+	b.WriteString("\tform   url.Values\n")
+	b.WriteString("\tcsrf   bool\n")
+	b.WriteString("\twant   int\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// runHandlerTests sends the requests of tests to path: the form goes in the\n")
+	b.WriteString("// body of POST and PATCH requests, in the query string of the others, and\n")
+	b.WriteString("// csrf requests carry the token of their cookie\n")
+	b.WriteString("func runHandlerTests(t *testing.T, path string, tests []handlerTest) {\n")
+	b.WriteString("\tt.Helper()\n")
+	b.WriteString("\tfor _, tt := range tests {\n")
+	b.WriteString("\t\tt.Run(tt.name, func(t *testing.T) {\n")
+	b.WriteString("\t\t\ttarget, body := path, \"\"\n")
+	b.WriteString("\t\t\tif tt.method == http.MethodPost || tt.method == http.MethodPatch {\n")
+	b.WriteString("\t\t\t\tbody = tt.form.Encode()\n")
+	b.WriteString("\t\t\t} else if len(tt.form) > 0 {\n")
+	b.WriteString("\t\t\t\ttarget += \"?\" + tt.form.Encode()\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t\treq := httptest.NewRequest(tt.method, target, strings.NewReader(body))\n")
+	b.WriteString("\t\t\tif body != \"\" {\n")
+	b.WriteString("\t\t\t\treq.Header.Set(\"Content-Type\", \"application/x-www-form-urlencoded\")\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t\tif tt.csrf {\n")
+	b.WriteString("\t\t\t\treq.AddCookie(&http.Cookie{Name: \"_csrf\", Value: testCSRFToken})\n")
+	b.WriteString("\t\t\t\treq.Header.Set(\"X-CSRF-Token\", testCSRFToken)\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t\trec := httptest.NewRecorder()\n")
+	b.WriteString("\t\t\ttestServer().ServeHTTP(rec, req)\n")
+	b.WriteString("\t\t\tif tt.want == 0 && rec.Code >= http.StatusBadRequest || tt.want != 0 && rec.Code != tt.want {\n")
+	b.WriteString("\t\t\t\tt.Errorf(\"%s %s: status %d, want %s: %s\", tt.method, target, rec.Code, wantStatus(tt.want), rec.Body.String())\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t})\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// wantStatus describes the status a handler test expects\n")
+	b.WriteString("func wantStatus(code int) string {\n")
+	b.WriteString("\tif code == 0 {\n")
+	b.WriteString("\t\treturn \"a success\"\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn http.StatusText(code)\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// genPageTest generates the test of a page, rendered from the seeded data
+func genPageTest(route Route) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("func Test%s(t *testing.T) {\n", strings.TrimPrefix(route.Handler, "handle")))
+	b.WriteString("\ttestSetup(t)\n")
+	b.WriteString(fmt.Sprintf("\trunHandlerTests(t, %q, []handlerTest{\n", route.Path))
+	b.WriteString("\t\t{name: \"renders\", method: http.MethodGet},\n")
+	b.WriteString("\t})\n")
+	b.WriteString("}\n\n")
+	return b.String()
+}
+
+// genHandlerTest generates the table test of a script handler
+func genHandlerTest(route Route, fn *ast.FuncDecl, models []*ast.ModelDecl) string {
+	var b strings.Builder
+	method := "http.Method" + utils.Capitalize(strings.ToLower(route.Method))
+	mutating := route.Method != "GET"
+
+	// Parameters the handler requires, filled with valid values
+	var values []string
+	var required []string
+	seeded := true
+	for _, param := range fn.Params {
+		value := testParamValue(fn, param, models)
+		if param.Type == "uuid" && seededModel(param, models) == "" || param.Type == "bytes" {
+			seeded = false
+			continue
+		}
+		values = append(values, fmt.Sprintf("%q: {%s}", param.Name, value))
+		if param.Type != "string[]" {
+			required = append(required, param.Name)
+		}
+	}
+
+	// Checks running before the parameters are read take over from the other cases
+	access := fn.FindAnnotation("auth") != nil || fn.FindAnnotation("role") != nil || fn.FindAnnotation("require2fa") != nil
+	signed := fn.FindAnnotation("signed") != nil
+	verified := fn.FindAnnotation("captcha") != nil || fn.FindAnnotation("honeypot") != nil
+
+	b.WriteString(fmt.Sprintf("func Test%s(t *testing.T) {\n", utils.Capitalize(fn.Name)))
+	if strings.Contains(strings.Join(values, ""), "ids[") {
+		b.WriteString("\tids := testSetup(t)\n")
+	} else {
+		b.WriteString("\ttestSetup(t)\n")
+	}
+	b.WriteString(fmt.Sprintf("\tvalid := func() url.Values { return url.Values{%s} }\n", strings.Join(values, ", ")))
+	if len(required) > 0 && !access && !signed && !verified {
+		b.WriteString("\twithout := func(name string) url.Values {\n")
+		b.WriteString("\t\tform := valid()\n")
+		b.WriteString("\t\tform.Del(name)\n")
+		b.WriteString("\t\treturn form\n")
+		b.WriteString("\t}\n")
+	}
+	b.WriteString("\n")
+	b.WriteString(fmt.Sprintf("\trunHandlerTests(t, %q, []handlerTest{\n", route.Path))
+
+	wrongMethod := "http.MethodGet"
+	if !mutating {
+		wrongMethod = "http.MethodPost"
+	}
+	b.WriteString(fmt.Sprintf("\t\t{name: \"method guard\", method: %s, form: valid(), csrf: true, want: http.StatusMethodNotAllowed},\n", wrongMethod))
+	if mutating {
+		b.WriteString(fmt.Sprintf("\t\t{name: \"csrf rejected\", method: %s, form: valid(), want: http.StatusForbidden},\n", method))
+	}
+	switch {
+	case signed:
+		b.WriteString(fmt.Sprintf("\t\t{name: \"unsigned link\", method: %s, form: valid(), csrf: true, want: http.StatusForbidden},\n", method))
+	case access:
+		b.WriteString(fmt.Sprintf("\t\t{name: \"signed out\", method: %s, form: valid(), csrf: true, want: http.StatusUnauthorized},\n", method))
+	case !verified:
+		for _, name := range required {
+			b.WriteString(fmt.Sprintf("\t\t{name: \"missing %s\", method: %s, form: without(%q), csrf: true, want: http.StatusBadRequest},\n", name, method, name))
+		}
+		// A sign-in only succeeds with the credentials of an existing account
+		if seeded && fn.FindAnnotation("login") == nil {
+			b.WriteString(fmt.Sprintf("\t\t{name: \"happy path\", method: %s, form: valid(), csrf: true},\n", method))
+		}
+	}
+	b.WriteString("\t})\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// testParamValue returns the Go expression of a valid value for a handler
+// parameter; uuid parameters take the ID of a seeded record
+func testParamValue(fn *ast.FuncDecl, param *ast.Param, models []*ast.ModelDecl) string {
+	switch param.Type {
+	case "uuid":
+		return fmt.Sprintf("ids[%q]", testSeededModel(fn, param, models))
+	case "int", "money", "bool":
+		return benchParamValue(param, models)
+	}
+	if strings.Contains(strings.ToLower(param.Name), "email") {
+		return `"user@example.com"`
+	}
+	return `"test value"`
+}
+
+// testSeededModel returns the model whose seeded ID fills a uuid parameter:
+// the one its name starts with (taskId → Task), else the longest one the
+// function names (id of deleteTask → Task), else the first with a uuid key
+func testSeededModel(fn *ast.FuncDecl, param *ast.Param, models []*ast.ModelDecl) string {
+	name := seededModel(param, models)
+	if name == "" || strings.HasPrefix(strings.ToLower(param.Name), strings.ToLower(name)) {
+		return name
+	}
+	named := ""
+	for _, model := range models {
+		if uuidPrimaryKey(model) != "" && len(model.Name) > len(named) &&
+			strings.Contains(strings.ToLower(fn.Name), strings.ToLower(model.Name)) {
+			named = model.Name
+		}
+	}
+	if named != "" {
+		return named
+	}
+	return name
+}
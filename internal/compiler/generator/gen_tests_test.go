@@ -0,0 +1,107 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/resolver"
+)
+
+func TestGenerateTests(t *testing.T) {
+	file := strictFile(t, `model User {
+  id: uuid @pk @default(uuid_v4)
+  email: string
+}
+model Task {
+  id: uuid @pk @default(uuid_v4)
+  title: string
+}
+service Database {
+  provider: "sqlite"
+  url: string @env("DATABASE_URL")
+}
+service Auth {
+  provider: "session"
+  secret: string @env("SESSION_SECRET")
+  ttl: string @default("12h")
+}
+
+func getTask(id: uuid, page: int) error {
+  let task = try Task.find(id)
+  return render(task)
+}
+
+@auth
+func getPrivateTasks() error {
+  let tasks = try Task.all()
+  return render(tasks)
+}
+
+func addUser(email: string, tags: string[]) error {
+  const user = User{email: email}
+  try user.save()
+  return render(user)
+}
+`, `<p>tasks</p>`)
+
+	code, err := New().GenerateTests(&resolver.ResolvedFile{Main: file})
+	if err != nil {
+		t.Fatalf("generate tests: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Fatalf("generated tests are not valid Go:\n%s", code)
+	}
+
+	for _, want := range []string{
+		"func testSetup(t *testing.T) map[string]string {",
+		"obj := factory.NewTask()",
+		// The session service is configured with its defaults and test values
+		`configureAuth(&AuthConfig{Secret: "gmx-test-secret", Ttl: "12h"})`,
+		`mux.HandleFunc("/", handleIndex)`,
+		"return csrfProtect(securityHeaders(mux))",
+		"func TestIndex(t *testing.T) {",
+		// The id of getTask is a seeded Task, not the first model
+		`valid := func() url.Values { return url.Values{"id": {ids["Task"]}, "page": {"1"}} }`,
+		`{name: "method guard", method: http.MethodPost, form: valid(), csrf: true, want: http.StatusMethodNotAllowed},`,
+		`{name: "missing page", method: http.MethodGet, form: without("page"), csrf: true, want: http.StatusBadRequest},`,
+		`{name: "signed out", method: http.MethodGet, form: valid(), csrf: true, want: http.StatusUnauthorized},`,
+		`{name: "csrf rejected", method: http.MethodPost, form: valid(), want: http.StatusForbidden},`,
+		`"email": {"user@example.com"}`,
+		`{name: "happy path", method: http.MethodPost, form: valid(), csrf: true},`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in tests", want)
+		}
+	}
+
+	// Lists may be empty, and handlers behind a session have no happy path without one
+	if strings.Contains(code, `"missing tags"`) {
+		t.Error("expected no missing-parameter test for a list")
+	}
+	private := code[strings.Index(code, "func TestGetPrivateTasks"):]
+	private = private[:strings.Index(private, "\n}\n")]
+	if strings.Contains(private, "happy path") {
+		t.Errorf("expected no happy path behind @auth:\n%s", private)
+	}
+}
+
+func TestGenerateTestsWithoutModels(t *testing.T) {
+	file := strictFile(t, `func getStatus() error {
+  return nil
+}
+`, `<p>status</p>`)
+
+	code, err := New().GenerateTests(&resolver.ResolvedFile{Main: file})
+	if err != nil {
+		t.Fatalf("generate tests: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Fatalf("generated tests are not valid Go:\n%s", code)
+	}
+	if strings.Contains(code, "AutoMigrate") || strings.Contains(code, "configure") {
+		t.Errorf("expected no seeding or configuration without models and services:\n%s", code)
+	}
+	if !strings.Contains(code, "func TestGetStatus(t *testing.T) {") {
+		t.Errorf("expected TestGetStatus:\n%s", code)
+	}
+}
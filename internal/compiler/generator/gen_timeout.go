@@ -0,0 +1,56 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// handlerTimeout returns the deadline of a @timeout(3s) function, 0 without one
+func handlerTimeout(fn *ast.FuncDecl) (time.Duration, error) {
+	ann := fn.FindAnnotation("timeout")
+	if ann == nil {
+		return 0, nil
+	}
+	raw := ann.SimpleArg()
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q (expected a duration such as 3s or 500ms)", raw)
+	}
+	return d, nil
+}
+
+// goDuration renders a duration as a Go expression in its largest exact unit:
+// 3s → 3 * time.Second, 1.5s → 1500 * time.Millisecond
+func goDuration(d time.Duration) string {
+	units := []struct {
+		size time.Duration
+		name string
+	}{
+		{time.Hour, "time.Hour"},
+		{time.Minute, "time.Minute"},
+		{time.Second, "time.Second"},
+		{time.Millisecond, "time.Millisecond"},
+		{time.Microsecond, "time.Microsecond"},
+	}
+	for _, unit := range units {
+		if d%unit.size == 0 {
+			return fmt.Sprintf("%d * %s", d/unit.size, unit.name)
+		}
+	}
+	return fmt.Sprintf("%d * time.Nanosecond", d)
+}
+
+// genHandlerDeadline generates the deadline of a @timeout handler: the request
+// context expires after d, and database queries and outgoing requests made
+// with it are cancelled
+func genHandlerDeadline(d time.Duration) string {
+	var b strings.Builder
+	b.WriteString("\t// Deadline from @timeout\n")
+	b.WriteString(fmt.Sprintf("\treqCtx, cancel := context.WithTimeout(r.Context(), %s)\n", goDuration(d)))
+	b.WriteString("\tdefer cancel()\n")
+	b.WriteString("\tr = r.WithContext(reqCtx)\n\n")
+	return b.String()
+}
@@ -0,0 +1,93 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+func TestGoDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{3 * time.Second, "3 * time.Second"},
+		{1500 * time.Millisecond, "1500 * time.Millisecond"},
+		{2 * time.Minute, "2 * time.Minute"},
+		{90 * time.Second, "90 * time.Second"},
+		{time.Hour, "1 * time.Hour"},
+		{1500 * time.Nanosecond, "1500 * time.Nanosecond"},
+	}
+
+	for _, tt := range tests {
+		if got := goDuration(tt.d); got != tt.want {
+			t.Errorf("goDuration(%s) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func timeoutFunc(arg, returnType string) *ast.FuncDecl {
+	return &ast.FuncDecl{
+		Name:        "listTasks",
+		ReturnType:  returnType,
+		Body:        []ast.Statement{},
+		Annotations: []*ast.Annotation{{Name: "timeout", Args: map[string]string{"_": arg}}},
+	}
+}
+
+func TestGenerateTimeout(t *testing.T) {
+	file := &ast.GMXFile{
+		Models: []*ast.ModelDecl{{Name: "Task", Fields: []*ast.FieldDecl{
+			{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{{Name: "pk"}}},
+		}}},
+		Script:   &ast.ScriptBlock{Funcs: []*ast.FuncDecl{timeoutFunc("3s", "error")}},
+		Template: &ast.TemplateBlock{Source: `<div hx-get="{{route "listTasks"}}"></div>`},
+	}
+
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, want := range []string{
+		"reqCtx, cancel := context.WithTimeout(r.Context(), 3*time.Second)",
+		"r = r.WithContext(reqCtx)",
+		"DB:      db.WithContext(r.Context()),",
+		"if errors.Is(r.Context().Err(), context.DeadlineExceeded) {",
+		`requestLogger(r).Error("handler timed out", "handler", "listTasks", "timeout", "3s", "error", err)`,
+		"func (ctx *GMXContext) Cancelled() bool {",
+		`"context"`,
+		`"errors"`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("Generated code missing %q", want)
+		}
+	}
+}
+
+func TestGenerateTimeoutErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		fn      *ast.FuncDecl
+		wantErr string
+	}{
+		{"not a duration", timeoutFunc("soon", "error"), `function listTasks: invalid timeout "soon"`},
+		{"zero", timeoutFunc("0s", "error"), `function listTasks: invalid timeout "0s"`},
+		{"not a handler", timeoutFunc("3s", "string"), "function listTasks: @timeout applies to handlers"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := &ast.GMXFile{Script: &ast.ScriptBlock{Funcs: []*ast.FuncDecl{tt.fn}}}
+			_, err := New().Generate(file)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,430 @@
+package generator
+
+import (
+	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	gmxerrors "github.com/btouchard/gmx/internal/compiler/errors"
+	"github.com/btouchard/gmx/internal/compiler/resolver"
+	goast "go/ast"
+	goparser "go/parser"
+	gotoken "go/token"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"unicode/utf8"
+)
+
+var (
+	// templateErrRegex splits a text/template parse error: "template: page:12: unexpected EOF"
+	templateErrRegex = regexp.MustCompile(`^template: [^:]*:(\d+): (.*)$`)
+	// templateQuotedRegex finds the quoted name of a parse error: function "titl" not defined
+	templateQuotedRegex = regexp.MustCompile(`"([^"]+)"`)
+	// templateBlockRegex finds the actions opening and closing a template block
+	templateBlockRegex = regexp.MustCompile(`\{\{-?\s*(if|range|with|block|define|end)\b`)
+)
+
+// templateFuncNames lists the functions of the generated template FuncMap;
+// it must stay in sync with genTemplateInit
+func (g *Generator) templateFuncNames(file *ast.GMXFile) []string {
+	names := []string{"route", "inputType"}
+	if g.hasFuncAnnotation(file, "honeypot") {
+		names = append(names, "honeypot")
+	}
+	if g.hasFuncAnnotation(file, "once") {
+		names = append(names, "once")
+	}
+	if g.hasItemIsolation(file) {
+		names = append(names, "renderItem")
+	}
+	if g.needsMoney(file) {
+		names = append(names, "money")
+	}
+	if g.hasJSONField(file) {
+		names = append(names, "json")
+	}
+	if g.hasNotifications(file) {
+		names = append(names, "notificationBadge")
+	}
+	if g.hasTypeahead(file) {
+		names = append(names, "typeahead", "highlight")
+	}
+	if g.hasModal(file) {
+		names = append(names, "modal")
+	}
+	if g.hasWizards(file) {
+		names = append(names, "wizard")
+	}
+	if g.hasAutosave(file) {
+		names = append(names, "autosave")
+	}
+	if g.hasActivityFeed(file) {
+		names = append(names, "activityFeed")
+	}
+	if g.hasImpersonation(file) {
+		names = append(names, "impersonationBanner")
+	}
+	if g.hasTwoFactor(file) {
+		names = append(names, "twoFactorSetup")
+	}
+	if g.hasAccounts(file) {
+		names = append(names, "accountSettings")
+	}
+	if g.hasConsent(file) {
+		names = append(names, "policyVersion")
+	}
+	if g.hasPolicyURL(file) {
+		names = append(names, "policyUrl")
+	}
+	return names
+}
+
+// validateTemplates parses the page template and the component templates the
+// way init() will, and checks the fields read from the page data and model
+// fragments against the generated types; diagnostics point into the .gmx files
+func (g *Generator) validateTemplates(file *ast.GMXFile, components map[string]*resolver.ComponentInfo, code string) error {
+	funcs := template.FuncMap{}
+	for _, name := range g.templateFuncNames(file) {
+		funcs[name] = func() string { return "" }
+	}
+
+	errs := gmxerrors.NewErrorList()
+	if file.Template != nil {
+		types := generatedStructs(code)
+		roots := map[string]string{"page": "PageData"}
+		for _, model := range file.Models {
+			roots[model.Name] = model.Name
+		}
+		// The pages of a directory build are checked in their own files
+		if len(file.Pages) > 0 {
+			for _, page := range file.Pages {
+				checkTemplateBlock(page.Template, page.Path, funcs, types, roots, errs)
+			}
+		} else {
+			checkTemplateBlock(file.Template, g.opts.Source, funcs, types, roots, errs)
+		}
+	}
+
+	names := make([]string, 0, len(components))
+	for name := range components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		info := components[name]
+		if info.File.Template != nil {
+			checkTemplateBlock(info.File.Template, info.Path, funcs, nil, nil, errs)
+		}
+	}
+
+	if errs.HasErrors() {
+		return errs
+	}
+	return nil
+}
+
+// checkTemplateBlock reports the parse error of a template block, or the
+// unknown fields read from the data of its root templates (name → Go type)
+func checkTemplateBlock(block *ast.TemplateBlock, path string, funcs template.FuncMap, types map[string]*goStruct, roots map[string]string, errs *gmxerrors.ErrorList) {
+	src := resolver.RewriteFragmentCalls(block.Source)
+	report := func(offset int, msg string) {
+		errs.Add(templatePosition(block, path, src, offset), "template", msg)
+	}
+
+	tmpl, err := template.New("page").Funcs(funcs).Parse(src)
+	if err != nil {
+		offset, msg := templateErrorOffset(src, err.Error())
+		report(offset, msg)
+		return
+	}
+	if types == nil {
+		return
+	}
+
+	for _, t := range tmpl.Templates() {
+		root, ok := roots[t.Name()]
+		if !ok || t.Tree == nil {
+			continue
+		}
+		c := &fieldChecker{types: types, root: types[root], report: report}
+		c.walk(t.Tree.Root, c.root)
+	}
+}
+
+// templateErrorOffset locates a text/template parse error in src: unclosed
+// blocks point at their opening action, other errors at the quoted name of
+// the message on the reported line, or at its first action
+func templateErrorOffset(src, errMsg string) (int, string) {
+	m := templateErrRegex.FindStringSubmatch(errMsg)
+	if m == nil {
+		return 0, errMsg
+	}
+	line, _ := strconv.Atoi(m[1])
+	msg := m[2]
+
+	if strings.Contains(msg, "unexpected EOF") {
+		if offset, keyword, ok := unclosedBlock(src); ok {
+			return offset, fmt.Sprintf("unclosed {{%s}}: missing {{end}}", keyword)
+		}
+	}
+
+	start := lineOffset(src, line)
+	end := strings.IndexByte(src[start:], '\n')
+	if end == -1 {
+		end = len(src) - start
+	}
+	text := src[start : start+end]
+	if q := templateQuotedRegex.FindStringSubmatch(msg); q != nil {
+		if i := strings.Index(text, q[1]); i != -1 {
+			return start + i, msg
+		}
+	}
+	if i := strings.Index(text, "{{"); i != -1 {
+		return start + i, msg
+	}
+	return start, msg
+}
+
+// unclosedBlock returns the offset and keyword of the innermost block action
+// of src without a matching {{end}}
+func unclosedBlock(src string) (int, string, bool) {
+	type open struct {
+		offset  int
+		keyword string
+	}
+	var stack []open
+	for _, m := range templateBlockRegex.FindAllStringSubmatchIndex(src, -1) {
+		keyword := src[m[2]:m[3]]
+		if keyword != "end" {
+			stack = append(stack, open{m[0], keyword})
+		} else if len(stack) > 0 {
+			stack = stack[:len(stack)-1]
+		}
+	}
+	if len(stack) == 0 {
+		return 0, "", false
+	}
+	last := stack[len(stack)-1]
+	return last.offset, last.keyword, true
+}
+
+// lineOffset returns the byte offset of the 1-based line of src
+func lineOffset(src string, line int) int {
+	offset := 0
+	for i := 1; i < line; i++ {
+		next := strings.IndexByte(src[offset:], '\n')
+		if next == -1 {
+			return len(src)
+		}
+		offset += next + 1
+	}
+	return offset
+}
+
+// templatePosition translates a byte offset of a template block into its
+// line and column in the .gmx file; columns count characters
+func templatePosition(block *ast.TemplateBlock, path, src string, offset int) gmxerrors.Position {
+	before := src[:offset]
+	line := strings.Count(before, "\n") + 1
+	column := utf8.RuneCountInString(before[strings.LastIndexByte(before, '\n')+1:]) + 1
+	if line == 1 {
+		column += block.StartColumn
+	}
+	return gmxerrors.Position{File: path, Line: block.StartLine + line, Column: column}
+}
+
+// goStruct lists the fields and methods of a generated struct type
+type goStruct struct {
+	fields   map[string]string // field name → Go type expression
+	methods  map[string]bool
+	embedded bool // embedded fields promote members the checker cannot see
+}
+
+// has reports whether the struct exposes a field or method name
+func (s *goStruct) has(name string) bool {
+	_, isField := s.fields[name]
+	return isField || s.methods[name] || s.embedded
+}
+
+// generatedStructs collects the struct types of the generated code with
+// their fields and methods
+func generatedStructs(code string) map[string]*goStruct {
+	structs := make(map[string]*goStruct)
+	f, err := goparser.ParseFile(gotoken.NewFileSet(), "main.go", code, goparser.SkipObjectResolution)
+	if err != nil {
+		return structs
+	}
+
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*goast.GenDecl)
+		if !ok || gen.Tok != gotoken.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			ts := spec.(*goast.TypeSpec)
+			st, ok := ts.Type.(*goast.StructType)
+			if !ok {
+				continue
+			}
+			s := &goStruct{fields: make(map[string]string), methods: make(map[string]bool)}
+			for _, field := range st.Fields.List {
+				if len(field.Names) == 0 {
+					s.embedded = true
+				}
+				for _, name := range field.Names {
+					s.fields[name.Name] = goTypeString(field.Type)
+				}
+			}
+			structs[ts.Name.Name] = s
+		}
+	}
+
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*goast.FuncDecl)
+		if !ok || fn.Recv == nil || len(fn.Recv.List) == 0 {
+			continue
+		}
+		recv := fn.Recv.List[0].Type
+		if star, ok := recv.(*goast.StarExpr); ok {
+			recv = star.X
+		}
+		if ident, ok := recv.(*goast.Ident); ok {
+			if s, ok := structs[ident.Name]; ok {
+				s.methods[fn.Name.Name] = true
+			}
+		}
+	}
+	return structs
+}
+
+// goTypeString renders the type expressions the checker follows: T, *T and []T
+func goTypeString(expr goast.Expr) string {
+	switch t := expr.(type) {
+	case *goast.Ident:
+		return t.Name
+	case *goast.StarExpr:
+		return "*" + goTypeString(t.X)
+	case *goast.ArrayType:
+		return "[]" + goTypeString(t.Elt)
+	}
+	return ""
+}
+
+// fieldChecker walks a template tree, tracking the struct type of dot where
+// it is known, and reports the fields that type does not have
+type fieldChecker struct {
+	types  map[string]*goStruct
+	root   *goStruct
+	report func(offset int, msg string)
+}
+
+// walk checks node with dot of type dot; nil means unknown
+func (c *fieldChecker) walk(node parse.Node, dot *goStruct) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			c.walk(child, dot)
+		}
+	case *parse.ActionNode:
+		c.pipe(n.Pipe, dot)
+	case *parse.IfNode:
+		c.pipe(n.Pipe, dot)
+		c.walk(n.List, dot)
+		c.walk(n.ElseList, dot)
+	case *parse.RangeNode:
+		c.pipe(n.Pipe, dot)
+		c.walk(n.List, c.elemType(n.Pipe, dot))
+		c.walk(n.ElseList, dot)
+	case *parse.WithNode:
+		c.pipe(n.Pipe, dot)
+		c.walk(n.List, nil)
+		c.walk(n.ElseList, dot)
+	case *parse.TemplateNode:
+		c.pipe(n.Pipe, dot)
+	}
+}
+
+// pipe checks the fields read by the commands of a pipeline
+func (c *fieldChecker) pipe(pipe *parse.PipeNode, dot *goStruct) {
+	if pipe == nil {
+		return
+	}
+	for _, cmd := range pipe.Cmds {
+		for _, arg := range cmd.Args {
+			switch a := arg.(type) {
+			case *parse.FieldNode:
+				c.field(a.Ident[0], int(a.Pos), dot)
+			case *parse.VariableNode:
+				if a.Ident[0] == "$" && len(a.Ident) > 1 {
+					c.field(a.Ident[1], int(a.Pos), c.root)
+				}
+			case *parse.PipeNode:
+				c.pipe(a, dot)
+			}
+		}
+	}
+}
+
+// field reports name unless dot is unknown or has it
+func (c *fieldChecker) field(name string, offset int, dot *goStruct) {
+	if dot == nil || dot.has(name) {
+		return
+	}
+	c.report(offset, fmt.Sprintf("unknown field .%s", name)+c.suggestion(name, dot))
+}
+
+// suggestion proposes the field of dot closest to a misspelled name
+func (c *fieldChecker) suggestion(name string, dot *goStruct) string {
+	best, bestDist := "", 3
+	for field := range dot.fields {
+		if d := editDistance(strings.ToLower(name), strings.ToLower(field)); d < bestDist || (d == bestDist && field < best) {
+			best, bestDist = field, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean .%s?)", best)
+}
+
+// elemType returns the element type ranged over by {{range .Field}}, or nil
+func (c *fieldChecker) elemType(pipe *parse.PipeNode, dot *goStruct) *goStruct {
+	if dot == nil || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return nil
+	}
+	field, ok := pipe.Cmds[0].Args[0].(*parse.FieldNode)
+	if !ok || len(field.Ident) != 1 {
+		return nil
+	}
+	elem, ok := strings.CutPrefix(dot.fields[field.Ident[0]], "[]")
+	if !ok {
+		return nil
+	}
+	return c.types[strings.TrimPrefix(elem, "*")]
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,103 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/resolver"
+)
+
+func tmplCheckFile(src string) *ast.GMXFile {
+	return &ast.GMXFile{
+		Models: []*ast.ModelDecl{
+			{Name: "Task", Fields: []*ast.FieldDecl{
+				{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{{Name: "pk"}}},
+				{Name: "title", Type: "string", Annotations: []*ast.Annotation{{Name: "min", Args: map[string]string{"_": "1"}}}},
+			}},
+		},
+		// Template content starts on line 10 of the .gmx file, column 3
+		Template: &ast.TemplateBlock{Source: src, StartLine: 9, StartColumn: 2},
+	}
+}
+
+func TestValidateTemplateErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"unknown field in range", "<ul>\n{{range .Tasks}}<li>{{.Titl}}</li>{{end}}\n</ul>", "[template] app.gmx:11:23: unknown field .Titl (did you mean .Title?)"},
+		{"unknown page field", "<p>{{.Taks}}</p>", "[template] app.gmx:10:8: unknown field .Taks (did you mean .Tasks?)"},
+		{"unknown root variable", "{{range .Tasks}}{{$.Token}}{{end}}", "[template] app.gmx:10:22: unknown field .Token"},
+		{"unknown field in model define", "{{define \"Task\"}}\n  {{.Done}}\n{{end}}", "[template] app.gmx:11:5: unknown field .Done"},
+		{"unclosed range", "<ul>\n  {{range .Tasks}}\n    <li>{{.Title}}</li>\n</ul>", "[template] app.gmx:11:3: unclosed {{range}}: missing {{end}}"},
+		{"undefined function", "<p>\n  {{upper .CSRFToken}}\n</p>", "[template] app.gmx:11:5: function \"upper\" not defined"},
+		{"unexpected end", "<p>{{.CSRFToken}}</p>\n{{end}}", "[template] app.gmx:11:1: unexpected {{end}}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewWithOptions(Options{Source: "app.gmx"}).Generate(tmplCheckFile(tt.src))
+			if err == nil {
+				t.Fatal("expected a template error")
+			}
+			if err.Error() != tt.want {
+				t.Errorf("got %q, want %q", err.Error(), tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTemplateAccepts(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{"page and model fields", `<form>{{.CSRFToken}}</form><ul>{{range .Tasks}}<li id="{{.ID}}">{{.Title}}</li>{{end}}</ul>`},
+		{"range variables", `{{range $i, $t := .Tasks}}{{$i}} {{$t.Title}} {{.Title}} {{$.CSRFToken}}{{end}}`},
+		{"with block is not checked", `{{with .Tasks}}{{.Anything}}{{end}}`},
+		{"unknown define is not checked", `{{define "row"}}{{.Anything}}{{end}}`},
+		{"generated funcs", `{{route "save"}} {{inputType "Task" "title"}} {{len .Tasks}}`},
+		{"model method", `{{range .Tasks}}{{.Validate}}{{end}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New().Generate(tmplCheckFile(tt.src)); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateTemplateMultipleErrors(t *testing.T) {
+	_, err := New().Generate(tmplCheckFile("{{range .Tasks}}{{.Titl}}{{end}}\n{{.Taks}}"))
+	if err == nil {
+		t.Fatal("expected template errors")
+	}
+	if lines := strings.Split(err.Error(), "\n"); len(lines) != 2 {
+		t.Errorf("expected one diagnostic per unknown field, got %q", err.Error())
+	}
+}
+
+func TestValidateComponentTemplate(t *testing.T) {
+	resolved := &resolver.ResolvedFile{
+		Main: &ast.GMXFile{Template: &ast.TemplateBlock{Source: `{{template "Card" .}}`}},
+		Components: map[string]*resolver.ComponentInfo{
+			"Card": {
+				Name: "Card",
+				Path: "/app/card.gmx",
+				File: &ast.GMXFile{Template: &ast.TemplateBlock{Source: "<div>\n  {{if .Open}}open\n</div>", StartLine: 4}},
+			},
+		},
+	}
+
+	_, err := New().GenerateResolved(resolved)
+	if err == nil {
+		t.Fatal("expected a component template error")
+	}
+	if want := "[template] /app/card.gmx:6:3: unclosed {{if}}: missing {{end}}"; err.Error() != want {
+		t.Errorf("got %q, want %q", err.Error(), want)
+	}
+}
@@ -0,0 +1,208 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// The "tracing" provider exports a span per request, and per query and HTTP
+// service call made while answering it, to an OpenTelemetry collector through
+// the OpenTelemetry SDK: otelhttp traces the routes and HTTP services, otelgorm
+// the queries, and the OTLP/HTTP exporter reads the standard OTEL_* variables.
+
+// tracingFields lists the config fields of the tracing provider
+var tracingFields = []string{"serviceName"}
+
+// tracingEnvVars are the OTEL_* variables read by the SDK, with their default
+var tracingEnvVars = []EnvVar{
+	{Name: "OTEL_EXPORTER_OTLP_ENDPOINT", Default: "http://localhost:4318"},
+	{Name: "OTEL_EXPORTER_OTLP_HEADERS"},
+	{Name: "OTEL_EXPORTER_OTLP_PROTOCOL", Default: "http/protobuf"},
+	{Name: "OTEL_EXPORTER_OTLP_TIMEOUT", Default: "10000"},
+	{Name: "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"},
+	{Name: "OTEL_SDK_DISABLED", Default: "false"},
+	{Name: "OTEL_RESOURCE_ATTRIBUTES"},
+	{Name: "OTEL_SERVICE_NAME"},
+	{Name: "OTEL_TRACES_SAMPLER"},
+}
+
+// findTracingService returns the service using the "tracing" provider, if any
+func (g *Generator) findTracingService(services []*ast.ServiceDecl) *ast.ServiceDecl {
+	for _, svc := range services {
+		if svc.Provider == "tracing" {
+			return svc
+		}
+	}
+	return nil
+}
+
+// hasTracing checks if a service exports traces
+func (g *Generator) hasTracing(file *ast.GMXFile) bool {
+	return g.findTracingService(file.Services) != nil
+}
+
+// validateTracingService checks that the tracing service is single and only
+// names the traced application
+func (g *Generator) validateTracingService(file *ast.GMXFile) error {
+	svc := g.findTracingService(file.Services)
+	if svc == nil {
+		return nil
+	}
+	for _, other := range file.Services {
+		if other != svc && other.Provider == "tracing" {
+			return fmt.Errorf("service %s: the tracing provider is already used by %s; declare a single tracing service", other.Name, svc.Name)
+		}
+	}
+	for _, field := range svc.Fields {
+		if field.Name != "serviceName" {
+			return fmt.Errorf("service %s: the tracing provider takes a %s field, not %s; the exporter reads the OTEL_* variables", svc.Name, strings.Join(tracingFields, ", "), field.Name)
+		}
+		if field.Type != "string" {
+			return fmt.Errorf("service %s: field %s of the tracing provider must be a string", svc.Name, field.Name)
+		}
+	}
+	if len(svc.Methods) > 0 {
+		return fmt.Errorf("service %s: the tracing provider takes no methods", svc.Name)
+	}
+	return nil
+}
+
+// genTracingStart generates start<Name>, which installs the tracer provider
+// of the SDK, exporting spans to the collector of the OTEL_* variables
+func (g *Generator) genTracingStart(svc *ast.ServiceDecl) string {
+	var b strings.Builder
+
+	b.WriteString(fmt.Sprintf("// start%s installs the tracer provider exporting spans to the collector of\n", svc.Name))
+	b.WriteString("// the OTEL_* variables; OTEL_SDK_DISABLED=true turns tracing off\n")
+	b.WriteString(fmt.Sprintf("func start%s(cfg *%sConfig) {\n", svc.Name, svc.Name))
+	b.WriteString("\tif strings.EqualFold(os.Getenv(\"OTEL_SDK_DISABLED\"), \"true\") {\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tctx := context.Background()\n")
+	b.WriteString("\tif protocol := os.Getenv(\"OTEL_EXPORTER_OTLP_PROTOCOL\"); protocol != \"\" && protocol != \"http/protobuf\" {\n")
+	b.WriteString("\t\tslog.Warn(\"tracing: spans are exported as http/protobuf\", \"protocol\", protocol)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\texporter, err := otlptracehttp.New(ctx)\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString(fmt.Sprintf("\t\tlog.Fatalf(\"service %s: creating the OTLP exporter: %%v\", err)\n", svc.Name))
+	b.WriteString("\t}\n")
+	b.WriteString("\t// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES name the application first\n")
+	b.WriteString("\tserviceName := \"unknown_service\"\n")
+	if findServiceField(svc, "serviceName") != nil {
+		b.WriteString("\tif cfg.ServiceName != \"\" {\n")
+		b.WriteString("\t\tserviceName = cfg.ServiceName\n")
+		b.WriteString("\t}\n")
+	}
+	b.WriteString("\tres, err := otelresource.New(ctx,\n")
+	b.WriteString("\t\totelresource.WithTelemetrySDK(),\n")
+	b.WriteString("\t\totelresource.WithAttributes(otelattribute.String(\"service.name\", serviceName)),\n")
+	b.WriteString("\t\totelresource.WithFromEnv(),\n")
+	b.WriteString("\t)\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\tslog.Warn(\"tracing: reading the resource attributes\", \"error\", err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\toptions := []sdktrace.TracerProviderOption{sdktrace.WithBatcher(exporter), sdktrace.WithResource(res)}\n")
+	b.WriteString("\t// Without a sampler of its own, a trace starts at a request\n")
+	b.WriteString("\tif os.Getenv(\"OTEL_TRACES_SAMPLER\") == \"\" {\n")
+	b.WriteString("\t\toptions = append(options, sdktrace.WithSampler(sdktrace.ParentBased(serverRootSampler{})))\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\ttracerProvider = sdktrace.NewTracerProvider(options...)\n")
+	b.WriteString("\totel.SetTracerProvider(tracerProvider)\n")
+	b.WriteString("\totel.SetTextMapPropagator(otelpropagation.NewCompositeTextMapPropagator(otelpropagation.TraceContext{}, otelpropagation.Baggage{}))\n")
+	b.WriteString("\totel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {\n")
+	b.WriteString("\t\tslog.Warn(\"tracing: OpenTelemetry error\", \"error\", err)\n")
+	b.WriteString("\t}))\n")
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// genTracing generates the tracer provider, the sampler starting traces at
+// requests, the server span of each route and its flush at shutdown
+func (g *Generator) genTracing() string {
+	var b strings.Builder
+
+	b.WriteString("// tracerProvider exports the spans, nil when tracing is off\n")
+	b.WriteString("var tracerProvider *sdktrace.TracerProvider\n\n")
+
+	b.WriteString("// serverRootSampler starts traces at the requests the application answers:\n")
+	b.WriteString("// queries and service calls made outside a request are left out\n")
+	b.WriteString("type serverRootSampler struct{}\n\n")
+
+	b.WriteString("func (serverRootSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {\n")
+	b.WriteString("\tif p.Kind != oteltrace.SpanKindServer {\n")
+	b.WriteString("\t\treturn sdktrace.SamplingResult{Decision: sdktrace.Drop}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn sdktrace.SamplingResult{Decision: sdktrace.RecordAndSample}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("func (serverRootSampler) Description() string { return \"ServerRootSampler\" }\n\n")
+
+	b.WriteString("// traceRoute answers the requests of a route in a server span, child of the\n")
+	b.WriteString("// traceparent header of the request; the request logger carries the trace ID\n")
+	b.WriteString("func traceRoute(route string, next http.HandlerFunc) http.HandlerFunc {\n")
+	b.WriteString("\tlogged := func(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString("\t\tif span := oteltrace.SpanContextFromContext(r.Context()); span.IsValid() {\n")
+	b.WriteString("\t\t\tr = r.WithContext(context.WithValue(r.Context(), requestLoggerKey{}, requestLogger(r).With(\"trace_id\", span.TraceID().String())))\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tnext(w, r)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn otelhttp.NewHandler(http.HandlerFunc(logged), route,\n")
+	b.WriteString("\t\totelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string { return r.Method + \" \" + route }),\n")
+	b.WriteString("\t).ServeHTTP\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// flushSpans exports the spans still waiting, until ctx expires\n")
+	b.WriteString("func flushSpans(ctx context.Context) {\n")
+	b.WriteString("\tif tracerProvider == nil {\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif err := tracerProvider.Shutdown(ctx); err != nil {\n")
+	b.WriteString("\t\tslog.Warn(\"tracing: spans left unexported at shutdown\", \"error\", err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// gormPlugins returns the GORM plugins registered on each opened database:
+// the metrics and the otelgorm tracing instrumentation, which leaves the query
+// values out of the spans
+func (g *Generator) gormPlugins(file *ast.GMXFile) []string {
+	var plugins []string
+	if g.hasMetrics(file) {
+		plugins = append(plugins, "metricsPlugin{}")
+	}
+	if g.hasTracing(file) {
+		plugins = append(plugins, "otelgorm.NewPlugin(otelgorm.WithoutQueryVariables(), otelgorm.WithoutMetrics())")
+	}
+	return plugins
+}
+
+// instrumentedHandler wraps the handler of a route in the metrics and tracing
+// of the routes; the metrics endpoint is neither counted nor traced
+func (g *Generator) instrumentedHandler(file *ast.GMXFile, route Route) string {
+	handler := route.Handler
+	if route.Path == metricsPath && g.hasMetrics(file) {
+		return handler
+	}
+	if g.hasTracing(file) {
+		handler = fmt.Sprintf("traceRoute(%q, %s)", route.Path, handler)
+	}
+	if g.hasMetrics(file) {
+		handler = fmt.Sprintf("instrumentRoute(%q, %s)", route.Path, handler)
+	}
+	return handler
+}
+
+// tracingTransportOf wraps the transport of an HTTP service client in the
+// otelhttp transport, sending its requests in client spans, when a service
+// exports traces
+func tracingTransportOf(svc *ast.ServiceDecl, transport string, traced bool) string {
+	if !traced {
+		return transport
+	}
+	return fmt.Sprintf("otelhttp.NewTransport(%s, otelhttp.WithSpanOptions(oteltrace.WithAttributes(otelattribute.String(\"peer.service\", %q))))", transport, svc.Name)
+}
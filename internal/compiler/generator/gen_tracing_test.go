@@ -0,0 +1,181 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// tracingServices declares a tracing service and an HTTP service whose calls
+// it traces
+const tracingServices = `service Tracing {
+  provider: "tracing"
+  serviceName: string @default("tasks")
+}
+service GitHub {
+  provider: "http"
+  baseUrl: string @env("GITHUB_API_URL")
+}
+`
+
+func TestGenerateTracing(t *testing.T) {
+	file := strictFile(t, strictModels+tracingServices+`
+func createTask(title: string) error {
+  const task = Task{title: title}
+  try task.save()
+  return render(task)
+}
+`, `<form hx-post="{{route "createTask"}}"></form>`)
+
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Fatalf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, want := range []string{
+		// Each route answers in a server span of otelhttp, child of the traceparent header
+		"\tmux.HandleFunc(\"/api/createTask\", traceRoute(\"/api/createTask\", handleCreateTask))\n",
+		"\treturn otelhttp.NewHandler(http.HandlerFunc(logged), route,\n",
+		"\totel.SetTextMapPropagator(otelpropagation.NewCompositeTextMapPropagator(otelpropagation.TraceContext{}, otelpropagation.Baggage{}))\n",
+		// The SDK exporter reads the OTEL_* variables, the service name defaulting to the field
+		"\tstartTracing(tracingCfg)\n",
+		"\texporter, err := otlptracehttp.New(ctx)\n",
+		"\t\tserviceName = cfg.ServiceName\n",
+		"\ttracerProvider = sdktrace.NewTracerProvider(options...)\n",
+		// Queries run with the context of the request, in spans of otelgorm
+		"\t\tDB:      db.WithContext(r.Context()),\n",
+		"\tif err := db.Use(otelgorm.NewPlugin(otelgorm.WithoutQueryVariables(), otelgorm.WithoutMetrics())); err != nil {\n",
+		// HTTP service calls are traced by the otelhttp transport
+		"Transport: otelhttp.NewTransport(http.DefaultTransport, otelhttp.WithSpanOptions(oteltrace.WithAttributes(otelattribute.String(\"peer.service\", \"GitHub\"))))",
+		// The last spans are exported at shutdown
+		"\tflushSpans(shutdownCtx)\n",
+		"\t\"github.com/uptrace/opentelemetry-go-extra/otelgorm\"\n",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("Generated code missing %q", want)
+		}
+	}
+	// The spans are exported by the SDK alone
+	for _, unwanted := range []string{"traceparent()", "sendSpans", "tracingPlugin"} {
+		if strings.Contains(code, unwanted) {
+			t.Errorf("Generated code should not contain %q", unwanted)
+		}
+	}
+}
+
+func TestGenerateTracingWithMetrics(t *testing.T) {
+	file := strictFile(t, strictModels+tracingServices+`
+func createTask(title: string) error {
+  const task = Task{title: title}
+  try task.save()
+  return render(task)
+}
+`, `<form hx-post="{{route "createTask"}}"></form>`)
+	file.Observability = metricsObservability
+
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Fatalf("Generated code is not valid Go:\n%s", code)
+	}
+	for _, want := range []string{
+		"\tmux.HandleFunc(\"/api/createTask\", instrumentRoute(\"/api/createTask\", traceRoute(\"/api/createTask\", handleCreateTask)))\n",
+		"\tmux.HandleFunc(\"/metrics\", handleMetrics)\n",
+		"\tif err := db.Use(metricsPlugin{}); err != nil {\n",
+		"\tif err := db.Use(otelgorm.NewPlugin(otelgorm.WithoutQueryVariables(), otelgorm.WithoutMetrics())); err != nil {\n",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("Generated code missing %q", want)
+		}
+	}
+}
+
+func TestGenerateTracingFailover(t *testing.T) {
+	file := failoverTestFile("postgres")
+	file.Services = append(file.Services, &ast.ServiceDecl{Name: "Tracing", Provider: "tracing"})
+	file.Observability = metricsObservability
+
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Fatalf("Generated code is not valid Go:\n%s", code)
+	}
+	// The standby's queries are timed and traced once it takes over
+	if !strings.Contains(code, "\tif err := conn.Use(metricsPlugin{}); err != nil {\n\t\treturn nil, err\n\t}\n\treturn conn, conn.Use(otelgorm.NewPlugin(otelgorm.WithoutQueryVariables(), otelgorm.WithoutMetrics()))\n") {
+		t.Error("expected openDatabase to register the metrics and tracing plugins")
+	}
+	// Without a serviceName field, only the OTEL_* variables name the application
+	if strings.Contains(code, "cfg.ServiceName") {
+		t.Error("the service name should not read an undeclared field")
+	}
+}
+
+func TestGenerateTracingDev(t *testing.T) {
+	file := strictFile(t, strictModels+tracingServices, `<p>Hello</p>`)
+
+	code, err := NewWithOptions(Options{Dev: true}).Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	// Injected faults show in the spans of the calls
+	want := "Transport: otelhttp.NewTransport(&chaosTransport{service: \"GitHub\", next: http.DefaultTransport}, "
+	if !strings.Contains(code, want) {
+		t.Errorf("Generated code missing %q", want)
+	}
+}
+
+func TestGenerateTracingErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(svc *ast.ServiceDecl, file *ast.GMXFile)
+		wantErr string
+	}{
+		{
+			name: "unknown field",
+			modify: func(svc *ast.ServiceDecl, file *ast.GMXFile) {
+				svc.Fields = append(svc.Fields, &ast.ServiceField{Name: "endpoint", Type: "string", EnvVar: "OTLP_URL"})
+			},
+			wantErr: "service Tracing: the tracing provider takes a serviceName field, not endpoint",
+		},
+		{
+			name: "non-string field",
+			modify: func(svc *ast.ServiceDecl, file *ast.GMXFile) {
+				svc.Fields[0].Type = "int"
+			},
+			wantErr: "service Tracing: field serviceName of the tracing provider must be a string",
+		},
+		{
+			name: "methods",
+			modify: func(svc *ast.ServiceDecl, file *ast.GMXFile) {
+				svc.Methods = []*ast.ServiceMethod{{Name: "span"}}
+			},
+			wantErr: "service Tracing: the tracing provider takes no methods",
+		},
+		{
+			name: "second tracing service",
+			modify: func(svc *ast.ServiceDecl, file *ast.GMXFile) {
+				file.Services = append(file.Services, &ast.ServiceDecl{Name: "Traces", Provider: "tracing"})
+			},
+			wantErr: "service Traces: the tracing provider is already used by Tracing",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := strictFile(t, strictModels+tracingServices, `<p>Hello</p>`)
+			tt.modify(file.Services[1], file)
+			_, err := New().Generate(file)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,452 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// Generated models storing the authenticator secret of each user with
+// two-factor authentication, and their unused backup codes
+const (
+	twoFactorModel  = "TwoFactor"
+	backupCodeModel = "BackupCode"
+)
+
+// Built-in endpoints asking a pending session for its code, verifying it,
+// and turning two-factor authentication on and off for the session user
+const (
+	twoFactorPath        = "/_gmx/2fa"
+	twoFactorVerifyPath  = "/_gmx/2fa/verify"
+	twoFactorSetupPath   = "/_gmx/2fa/setup"
+	twoFactorEnablePath  = "/_gmx/2fa/enable"
+	twoFactorDisablePath = "/_gmx/2fa/disable"
+)
+
+// twoFactorRoutes are the built-in two-factor authentication endpoints
+var twoFactorRoutes = []Route{
+	{Method: "GET", Path: twoFactorPath, Handler: "handleTwoFactor"},
+	{Method: "POST", Path: twoFactorVerifyPath, Handler: "handleTwoFactorVerify"},
+	{Method: "GET", Path: twoFactorSetupPath, Handler: "handleTwoFactorSetup"},
+	{Method: "POST", Path: twoFactorEnablePath, Handler: "handleTwoFactorEnable"},
+	{Method: "POST", Path: twoFactorDisablePath, Handler: "handleTwoFactorDisable"},
+}
+
+// sessionTwoFactor checks if a session service enables two-factor
+// authentication, which is the case when it declares an `issuer` field
+func sessionTwoFactor(svc *ast.ServiceDecl) bool {
+	return findServiceField(svc, "issuer") != nil
+}
+
+// hasTwoFactor checks if the session service enables two-factor authentication
+func (g *Generator) hasTwoFactor(file *ast.GMXFile) bool {
+	svc := g.findSessionService(file.Services)
+	return svc != nil && sessionTwoFactor(svc)
+}
+
+// validateTwoFactor checks that @require2fa functions have a second factor to
+// require, and that the generated models and endpoints are free
+func (g *Generator) validateTwoFactor(file *ast.GMXFile) error {
+	for _, fn := range g.funcsWithAnnotation(file, "require2fa") {
+		if len(fn.FindAnnotation("require2fa").Args) > 0 {
+			return fmt.Errorf("function %s: @require2fa takes no arguments", fn.Name)
+		}
+		if fn.ReturnType != "" && fn.ReturnType != "error" {
+			return fmt.Errorf("function %s: @require2fa applies to handlers, functions returning error", fn.Name)
+		}
+		if !g.hasTwoFactor(file) {
+			return fmt.Errorf("function %s: @require2fa requires a session service with an `issuer` field, which enables two-factor authentication", fn.Name)
+		}
+	}
+	if !g.hasTwoFactor(file) {
+		return nil
+	}
+	for _, model := range file.Models {
+		if model.Name == twoFactorModel || model.Name == backupCodeModel {
+			return fmt.Errorf("line %d: model %s collides with the models storing two-factor authentication; rename it", model.Line, model.Name)
+		}
+	}
+	if file.Script != nil {
+		for _, fn := range file.Script.Funcs {
+			for _, route := range twoFactorRoutes {
+				if "handle"+utils.Capitalize(fn.Name) == route.Handler {
+					return fmt.Errorf("line %d: function %s collides with the built-in %s endpoint; rename it", fn.Line, fn.Name, route.Path)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// withTwoFactorModels returns the file with the models storing the
+// authenticator secrets and backup codes, declared and migrated like the others
+func (g *Generator) withTwoFactorModels(file *ast.GMXFile) *ast.GMXFile {
+	if !g.hasTwoFactor(file) {
+		return file
+	}
+	withModels := *file
+	withModels.Models = append(append([]*ast.ModelDecl{}, file.Models...),
+		&ast.ModelDecl{
+			Name: twoFactorModel,
+			Fields: []*ast.FieldDecl{
+				{Name: "owner", Type: "string", Annotations: []*ast.Annotation{
+					{Name: "pk", Args: map[string]string{}},
+				}},
+				{Name: "secret", Type: "string"},
+				{Name: "lastStep", Type: "int"},
+				{Name: "enabledAt", Type: "datetime"},
+			},
+		},
+		&ast.ModelDecl{
+			Name: backupCodeModel,
+			Fields: []*ast.FieldDecl{
+				{Name: "hash", Type: "string", Annotations: []*ast.Annotation{
+					{Name: "pk", Args: map[string]string{}},
+				}},
+				{Name: "owner", Type: "string", Annotations: []*ast.Annotation{
+					{Name: "index", Args: map[string]string{}},
+				}},
+			},
+		})
+	return &withModels
+}
+
+// genTwoFactor generates the TOTP codes (RFC 6238), the backup codes, the
+// throttling of wrong codes and the built-in endpoints of two-factor
+// authentication
+func (g *Generator) genTwoFactor(file *ast.GMXFile) string {
+	var b strings.Builder
+	serverSessions := g.hasServerSessions(file)
+
+	b.WriteString("// twoFactorIssuer names the application in authenticator apps; set by the\n")
+	b.WriteString("// session service\n")
+	b.WriteString("var twoFactorIssuer string\n\n")
+
+	b.WriteString("// twoFactorSetupTTL is how long the secret of the setup form can be confirmed\n")
+	b.WriteString("const twoFactorSetupTTL = 10 * time.Minute\n\n")
+
+	b.WriteString("// totpCode returns the 6-digit TOTP code of a key for a 30-second step\n")
+	b.WriteString("func totpCode(key []byte, step int64) string {\n")
+	b.WriteString("\tmsg := make([]byte, 8)\n")
+	b.WriteString("\tbinary.BigEndian.PutUint64(msg, uint64(step))\n")
+	b.WriteString("\tmac := hmac.New(sha1.New, key)\n")
+	b.WriteString("\tmac.Write(msg)\n")
+	b.WriteString("\tsum := mac.Sum(nil)\n")
+	b.WriteString("\toffset := sum[len(sum)-1] & 0x0f\n")
+	b.WriteString("\tcode := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff\n")
+	b.WriteString("\treturn fmt.Sprintf(\"%06d\", code%1000000)\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// checkTOTP returns the step of the code matching a base32 secret, one step\n")
+	b.WriteString("// of clock drift apart at most\n")
+	b.WriteString("func checkTOTP(secret, code string) (int64, bool) {\n")
+	b.WriteString("\tkey, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)\n")
+	b.WriteString("\tif err != nil || len(code) != 6 {\n")
+	b.WriteString("\t\treturn 0, false\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tnow := time.Now().Unix() / 30\n")
+	b.WriteString("\tfor _, step := range []int64{now - 1, now, now + 1} {\n")
+	b.WriteString("\t\tif hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {\n")
+	b.WriteString("\t\t\treturn step, true\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn 0, false\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// backupCodeHash returns the digest a backup code is stored under\n")
+	b.WriteString("func backupCodeHash(code string) string {\n")
+	b.WriteString("\tsum := sha256.Sum256([]byte(code))\n")
+	b.WriteString("\treturn hex.EncodeToString(sum[:])\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// twoFactorEnabled reports whether a user turned two-factor authentication\n")
+	b.WriteString("// on; when the database fails, the second factor is required\n")
+	b.WriteString("func twoFactorEnabled(user string) bool {\n")
+	b.WriteString(fmt.Sprintf("\tvar tf %s\n", twoFactorModel))
+	b.WriteString("\tif err := db.Where(map[string]any{\"owner\": user}).Limit(1).Find(&tf).Error; err != nil {\n")
+	b.WriteString("\t\tslog.Error(\"2fa: loading\", \"user\", user, \"error\", err)\n")
+	b.WriteString("\t\treturn true\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn tf.Owner != \"\"\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// verifySecondFactor checks a code against the authenticator of a user,\n")
+	b.WriteString("// then against their backup codes; neither is accepted twice\n")
+	b.WriteString("func verifySecondFactor(user, code string) (bool, error) {\n")
+	b.WriteString("\tcode = strings.ToLower(strings.NewReplacer(\" \", \"\", \"-\", \"\").Replace(code))\n")
+	b.WriteString(fmt.Sprintf("\tvar tf %s\n", twoFactorModel))
+	b.WriteString("\tif err := db.Where(map[string]any{\"owner\": user}).Limit(1).Find(&tf).Error; err != nil || tf.Owner == \"\" {\n")
+	b.WriteString("\t\treturn false, err\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif step, ok := checkTOTP(tf.Secret, code); ok {\n")
+	b.WriteString(fmt.Sprintf("\t\tres := db.Model(&%s{}).Where(\"owner = ? AND last_step < ?\", user, step).Update(\"last_step\", step)\n", twoFactorModel))
+	b.WriteString("\t\treturn res.RowsAffected == 1, res.Error\n")
+	b.WriteString("\t}\n")
+	b.WriteString(fmt.Sprintf("\tres := db.Where(map[string]any{\"hash\": backupCodeHash(code), \"owner\": user}).Delete(&%s{})\n", backupCodeModel))
+	b.WriteString("\treturn res.RowsAffected == 1, res.Error\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// enableTwoFactor stores the confirmed secret of a user, whose step is used,\n")
+	b.WriteString("// and replaces their backup codes, returned once\n")
+	b.WriteString("func enableTwoFactor(user, secret string, step int64) ([]string, error) {\n")
+	b.WriteString("\tcodes := make([]string, 10)\n")
+	b.WriteString(fmt.Sprintf("\trows := make([]%s, len(codes))\n", backupCodeModel))
+	b.WriteString("\tfor i := range codes {\n")
+	b.WriteString("\t\tbuf := make([]byte, 5)\n")
+	b.WriteString("\t\trand.Read(buf)\n")
+	b.WriteString("\t\traw := hex.EncodeToString(buf)\n")
+	b.WriteString("\t\tcodes[i] = raw[:5] + \"-\" + raw[5:]\n")
+	b.WriteString(fmt.Sprintf("\t\trows[i] = %s{Hash: backupCodeHash(raw), Owner: user}\n", backupCodeModel))
+	b.WriteString("\t}\n")
+	b.WriteString("\terr := db.Transaction(func(tx *gorm.DB) error {\n")
+	b.WriteString(fmt.Sprintf("\t\tif err := tx.Save(&%s{Owner: user, Secret: secret, LastStep: int(step), EnabledAt: time.Now()}).Error; err != nil {\n", twoFactorModel))
+	b.WriteString("\t\t\treturn err\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString(fmt.Sprintf("\t\tif err := tx.Where(map[string]any{\"owner\": user}).Delete(&%s{}).Error; err != nil {\n", backupCodeModel))
+	b.WriteString("\t\t\treturn err\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\treturn tx.Create(&rows).Error\n")
+	b.WriteString("\t})\n")
+	b.WriteString("\treturn codes, err\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// disableTwoFactor deletes the secret and backup codes of a user\n")
+	b.WriteString("func disableTwoFactor(user string) error {\n")
+	b.WriteString("\treturn db.Transaction(func(tx *gorm.DB) error {\n")
+	b.WriteString(fmt.Sprintf("\t\tif err := tx.Where(map[string]any{\"owner\": user}).Delete(&%s{}).Error; err != nil {\n", twoFactorModel))
+	b.WriteString("\t\t\treturn err\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString(fmt.Sprintf("\t\treturn tx.Where(map[string]any{\"owner\": user}).Delete(&%s{}).Error\n", backupCodeModel))
+	b.WriteString("\t})\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// twoFactorFailures locks the second factor of a user after 5 wrong codes\n")
+	b.WriteString("// within 15 minutes, against guessing\n")
+	b.WriteString("var twoFactorFailures = newFailureLimiter(5, 15*time.Minute)\n\n")
+
+	b.WriteString("// recordTwoFactor counts a wrong code of a user, or forgets their wrong codes\n")
+	b.WriteString("// once they enter a right one\n")
+	b.WriteString("func recordTwoFactor(user string, ok bool) {\n")
+	b.WriteString("\tif ok {\n")
+	b.WriteString("\t\ttwoFactorFailures.reset(user)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif twoFactorFailures.fail(user) {\n")
+	b.WriteString("\t\tslog.Info(\"audit: two-factor authentication locked\", \"user\", user)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// renderTwoFactorPage renders the code form of a pending session, with the\n")
+	b.WriteString("// error of the previous attempt if any\n")
+	b.WriteString("func renderTwoFactorPage(w http.ResponseWriter, r *http.Request, status int, message string) {\n")
+	b.WriteString("\ttoken := \"\"\n")
+	b.WriteString("\tif cookie, err := r.Cookie(\"_csrf\"); err == nil {\n")
+	b.WriteString("\t\ttoken = cookie.Value\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif message != \"\" {\n")
+	b.WriteString("\t\tmessage = `<p class=\"gmx-2fa-error\" role=\"alert\">` + template.HTMLEscapeString(message) + `</p>`\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/html; charset=utf-8\")\n")
+	b.WriteString("\tw.WriteHeader(status)\n")
+	b.WriteString("\tfmt.Fprintf(w, `<!DOCTYPE html><html><head><meta charset=\"utf-8\"><title>Two-factor authentication</title></head><body>`+\n")
+	b.WriteString(fmt.Sprintf("\t\t`<form class=\"gmx-2fa\" method=\"post\" action=%q><input type=\"hidden\" name=\"_csrf\" value=\"%%s\">%%s`+\n", twoFactorVerifyPath))
+	b.WriteString("\t\t`<label>Authentication or backup code <input name=\"code\" autocomplete=\"one-time-code\" required autofocus></label> `+\n")
+	b.WriteString("\t\t`<button>Verify</button></form></body></html>`, template.HTMLEscapeString(token), message)\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// handleTwoFactor asks a pending session for the code of its user\n")
+	b.WriteString("func handleTwoFactor(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString(genMethodGuard("Get"))
+	b.WriteString("\tif readSession(r).Pending == \"\" {\n")
+	b.WriteString("\t\thttp.Redirect(w, r, \"/\", http.StatusSeeOther)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\trenderTwoFactorPage(w, r, http.StatusOK, \"\")\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// handleTwoFactorVerify opens the session of a pending user entering a right\n")
+	b.WriteString("// authentication or backup code\n")
+	b.WriteString("func handleTwoFactorVerify(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString(genMethodGuard("Post"))
+	b.WriteString("\ts := readSession(r)\n")
+	b.WriteString("\tif s.Pending == \"\" {\n")
+	b.WriteString("\t\thttp.Error(w, \"Unauthorized\", http.StatusUnauthorized)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif twoFactorFailures.locked(s.Pending) > 0 {\n")
+	b.WriteString("\t\trenderTwoFactorPage(w, r, http.StatusTooManyRequests, \"Too many wrong codes, try again later\")\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tok, err := verifySecondFactor(s.Pending, r.FormValue(\"code\"))\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\trequestLogger(r).Error(\"2fa: verifying\", \"error\", err)\n")
+	b.WriteString("\t\thttp.Error(w, \"Internal Server Error\", http.StatusInternalServerError)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\trecordTwoFactor(s.Pending, ok)\n")
+	b.WriteString("\tif !ok {\n")
+	b.WriteString("\t\trenderTwoFactorPage(w, r, http.StatusUnauthorized, \"Wrong code\")\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	if g.hasAccounts(file) {
+		b.WriteString("\trestoreAccount(s.Pending)\n")
+	}
+	if serverSessions {
+		b.WriteString("\tdropSession(r)\n")
+	}
+	b.WriteString("\twriteSession(w, gmxSession{User: s.Pending, Verified: true})\n")
+	b.WriteString("\tif r.Header.Get(\"HX-Request\") == \"true\" {\n")
+	b.WriteString("\t\tw.Header().Set(\"HX-Redirect\", \"/\")\n")
+	b.WriteString("\t\tw.WriteHeader(http.StatusNoContent)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\thttp.Redirect(w, r, \"/\", http.StatusSeeOther)\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// handleTwoFactorSetup renders the two-factor authentication fragment of the\n")
+	b.WriteString("// session user: a new secret and its QR code to confirm with a first code, or\n")
+	b.WriteString("// the form turning it off\n")
+	b.WriteString("func handleTwoFactorSetup(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString(genMethodGuard("Get"))
+	b.WriteString("\tuser := readSession(r).User\n")
+	b.WriteString("\tif user == \"\" {\n")
+	b.WriteString("\t\thttp.Error(w, \"Unauthorized\", http.StatusUnauthorized)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/html; charset=utf-8\")\n")
+	b.WriteString("\tw.Header().Set(\"Cache-Control\", \"no-store\")\n")
+	b.WriteString("\tif twoFactorEnabled(user) {\n")
+	b.WriteString("\t\tfmt.Fprint(w, `<div class=\"gmx-2fa\" id=\"gmx-2fa\"><p>Two-factor authentication is on.</p>`+\n")
+	b.WriteString(fmt.Sprintf("\t\t\t`<form hx-post=%q hx-target=\"#gmx-2fa\" hx-swap=\"outerHTML\">`+\n", twoFactorDisablePath))
+	b.WriteString("\t\t\t`<input name=\"code\" autocomplete=\"one-time-code\" placeholder=\"Authentication or backup code\" required> `+\n")
+	b.WriteString("\t\t\t`<button>Turn off</button></form></div>`)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\t// The secret travels signed with the form until a first code confirms it,\n")
+	b.WriteString("\t// within twoFactorSetupTTL\n")
+	b.WriteString("\tbuf := make([]byte, 20)\n")
+	b.WriteString("\trand.Read(buf)\n")
+	b.WriteString("\tsecret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf)\n")
+	b.WriteString("\turi := \"otpauth://totp/\" + url.PathEscape(twoFactorIssuer+\":\"+user) + \"?\" + url.Values{\"secret\": {secret}, \"issuer\": {twoFactorIssuer}}.Encode()\n")
+	b.WriteString("\texp := strconv.FormatInt(time.Now().Add(twoFactorSetupTTL).Unix(), 10)\n")
+	b.WriteString("\tpng, err := qrcode.Encode(uri, qrcode.Medium, 256)\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\trequestLogger(r).Error(\"2fa: QR code\", \"error\", err)\n")
+	b.WriteString("\t\thttp.Error(w, \"Internal Server Error\", http.StatusInternalServerError)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tfmt.Fprintf(w, `<div class=\"gmx-2fa\" id=\"gmx-2fa\"><img src=\"data:image/png;base64,%s\" width=\"256\" height=\"256\" alt=\"QR code for your authenticator app\">`+\n")
+	b.WriteString("\t\t`<p>Scan the QR code with your authenticator app, or enter the key <code>%s</code>, then the code it shows.</p>`+\n")
+	b.WriteString(fmt.Sprintf("\t\t`<form hx-post=%q hx-target=\"#gmx-2fa\" hx-swap=\"outerHTML\">`+\n", twoFactorEnablePath))
+	b.WriteString("\t\t`<input type=\"hidden\" name=\"secret\" value=\"%s\"><input type=\"hidden\" name=\"exp\" value=\"%s\"><input type=\"hidden\" name=\"signature\" value=\"%s\">`+\n")
+	b.WriteString("\t\t`<input name=\"code\" autocomplete=\"one-time-code\" inputmode=\"numeric\" placeholder=\"123456\" required> `+\n")
+	b.WriteString("\t\t`<button>Turn on</button></form></div>`,\n")
+	b.WriteString("\t\tbase64.StdEncoding.EncodeToString(png), secret, secret, exp, signSession(\"2fa:\"+user+\":\"+secret+\":\"+exp))\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// handleTwoFactorEnable turns two-factor authentication on once the user\n")
+	b.WriteString("// enters a first code of the new secret, and shows their backup codes once\n")
+	b.WriteString("func handleTwoFactorEnable(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString(genTwoFactorOwner())
+	b.WriteString("\t// A second setup form would replace the secret and the backup codes\n")
+	b.WriteString("\tif twoFactorEnabled(s.User) {\n")
+	b.WriteString("\t\thttp.Error(w, \"Two-factor authentication is already on\", http.StatusConflict)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tsecret, exp := r.FormValue(\"secret\"), r.FormValue(\"exp\")\n")
+	b.WriteString("\tif !hmac.Equal([]byte(r.FormValue(\"signature\")), []byte(signSession(\"2fa:\"+s.User+\":\"+secret+\":\"+exp))) {\n")
+	b.WriteString("\t\thttp.Error(w, \"Invalid secret\", http.StatusBadRequest)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif expires, err := strconv.ParseInt(exp, 10, 64); err != nil || time.Now().Unix() > expires {\n")
+	b.WriteString("\t\thttp.Error(w, \"This setup has expired, start again\", http.StatusGone)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tstep, ok := checkTOTP(secret, r.FormValue(\"code\"))\n")
+	b.WriteString("\tif !ok {\n")
+	b.WriteString("\t\thttp.Error(w, \"Wrong code\", http.StatusUnprocessableEntity)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tcodes, err := enableTwoFactor(s.User, secret, step)\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\trequestLogger(r).Error(\"2fa: enabling\", \"error\", err)\n")
+	b.WriteString("\t\thttp.Error(w, \"Internal Server Error\", http.StatusInternalServerError)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\trequestLogger(r).Info(\"audit: two-factor authentication turned on\", \"user\", s.User)\n")
+	b.WriteString("\t// The code just entered is the second factor of this session\n")
+	if serverSessions {
+		b.WriteString("\tdropSession(r)\n")
+	}
+	b.WriteString("\twriteSession(w, gmxSession{User: s.User, Verified: true})\n")
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/html; charset=utf-8\")\n")
+	b.WriteString("\tw.Header().Set(\"Cache-Control\", \"no-store\")\n")
+	b.WriteString("\tvar list strings.Builder\n")
+	b.WriteString("\tfor _, code := range codes {\n")
+	b.WriteString("\t\tlist.WriteString(\"<li><code>\" + code + \"</code></li>\")\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tfmt.Fprintf(w, `<div class=\"gmx-2fa\" id=\"gmx-2fa\"><p>Two-factor authentication is on. Keep these backup codes somewhere safe: `+\n")
+	b.WriteString("\t\t`each one signs you in once without your authenticator app.</p><ul>%s</ul></div>`, list.String())\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// handleTwoFactorDisable turns two-factor authentication off, given a code\n")
+	b.WriteString("func handleTwoFactorDisable(w http.ResponseWriter, r *http.Request) {\n")
+	b.WriteString(genTwoFactorOwner())
+	b.WriteString("\tif twoFactorFailures.locked(s.User) > 0 {\n")
+	b.WriteString("\t\thttp.Error(w, \"Too many wrong codes, try again later\", http.StatusTooManyRequests)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tok, err := verifySecondFactor(s.User, r.FormValue(\"code\"))\n")
+	b.WriteString("\tif err == nil && ok {\n")
+	b.WriteString("\t\terr = disableTwoFactor(s.User)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\trequestLogger(r).Error(\"2fa: disabling\", \"error\", err)\n")
+	b.WriteString("\t\thttp.Error(w, \"Internal Server Error\", http.StatusInternalServerError)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\trecordTwoFactor(s.User, ok)\n")
+	b.WriteString("\tif !ok {\n")
+	b.WriteString("\t\thttp.Error(w, \"Wrong code\", http.StatusUnprocessableEntity)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\trequestLogger(r).Info(\"audit: two-factor authentication turned off\", \"user\", s.User)\n")
+	if serverSessions {
+		b.WriteString("\tdropSession(r)\n")
+	}
+	b.WriteString("\twriteSession(w, gmxSession{User: s.User})\n")
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/html; charset=utf-8\")\n")
+	b.WriteString(fmt.Sprintf("\tfmt.Fprint(w, `<div class=\"gmx-2fa\" id=\"gmx-2fa\" hx-get=%q hx-trigger=\"load\" hx-swap=\"outerHTML\"></div>`)\n", twoFactorSetupPath))
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// genMethodGuard generates the method check of a built-in endpoint
+func genMethodGuard(method string) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("\tif r.Method != http.Method%s {\n", method))
+	b.WriteString("\t\thttp.Error(w, \"Method Not Allowed\", http.StatusMethodNotAllowed)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	return b.String()
+}
+
+// genTwoFactorOwner generates the checks of the endpoints changing the second
+// factor or the account of the session user: an admin impersonating them may not
+func genTwoFactorOwner() string {
+	var b strings.Builder
+	b.WriteString(genMethodGuard("Post"))
+	b.WriteString("\ts := readSession(r)\n")
+	b.WriteString("\tif s.User == \"\" {\n")
+	b.WriteString("\t\thttp.Error(w, \"Unauthorized\", http.StatusUnauthorized)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif s.Impersonator != \"\" {\n")
+	b.WriteString("\t\thttp.Error(w, \"Forbidden\", http.StatusForbidden)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	return b.String()
+}
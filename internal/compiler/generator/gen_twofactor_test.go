@@ -0,0 +1,130 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// twoFactorTestFile returns a session service with two-factor authentication
+// and a handler requiring it
+func twoFactorTestFile() *ast.GMXFile {
+	file := sessionStoreFile("database")
+	file.Services[0].Fields = append(file.Services[0].Fields,
+		&ast.ServiceField{Name: "issuer", Type: "string", EnvVar: "TOTP_ISSUER"})
+	file.Script.Funcs = append(file.Script.Funcs, &ast.FuncDecl{
+		Name:        "wipe",
+		ReturnType:  "error",
+		Annotations: []*ast.Annotation{{Name: "require2fa", Args: map[string]string{}}},
+		Body:        []ast.Statement{},
+	})
+	file.Template = &ast.TemplateBlock{Source: `{{twoFactorSetup}}<p>Hello</p>`}
+	return file
+}
+
+func TestGenerator_TwoFactor(t *testing.T) {
+	code, err := New().Generate(twoFactorTestFile())
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	expected := []string{
+		"twoFactorIssuer = cfg.Issuer",
+		"func totpCode(key []byte, step int64) string {",
+		"func checkTOTP(secret, code string) (int64, bool) {",
+		"func verifySecondFactor(user, code string) (bool, error) {",
+		"type TwoFactor struct {",
+		"type BackupCode struct {",
+		`mux.HandleFunc("/_gmx/2fa/verify", handleTwoFactorVerify)`,
+		`mux.HandleFunc("/_gmx/2fa/enable", handleTwoFactorEnable)`,
+		"\tPending      string",
+		"Pending: row.Pending, Verified: row.Verified",
+		// A login with a second factor waits for the code
+		"\t\twriteSession(ctx.Writer, gmxSession{Pending: userID})\n",
+		"ctx.Writer.Header().Set(\"HX-Redirect\", \"/_gmx/2fa\")",
+		"\tif !reqSession.Verified {\n",
+		`"github.com/skip2/go-qrcode"`,
+		// A setup confirms a fresh secret, once
+		"\tif twoFactorEnabled(s.User) {\n\t\thttp.Error(w, \"Two-factor authentication is already on\", http.StatusConflict)\n",
+		"signSession(\"2fa:\"+user+\":\"+secret+\":\"+exp)",
+		"\tif expires, err := strconv.ParseInt(exp, 10, 64); err != nil || time.Now().Unix() > expires {\n",
+		`"strconv"`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(code, exp) {
+			t.Errorf("Generated code missing %q", exp)
+		}
+	}
+
+	// Pages never load the credentials of every user
+	for _, unexpected := range []string{"&data.TwoFactors", "&data.BackupCodes", "&data.Sessions"} {
+		if strings.Contains(code, unexpected) {
+			t.Errorf("Generated code should not contain %q", unexpected)
+		}
+	}
+}
+
+func TestGenerator_WithoutTwoFactor(t *testing.T) {
+	code, err := New().Generate(sessionStoreFile("database"))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	for _, unexpected := range []string{"totpCode", "TwoFactor", "Pending", "go-qrcode"} {
+		if strings.Contains(code, unexpected) {
+			t.Errorf("Generated code should not contain %q without an issuer field", unexpected)
+		}
+	}
+}
+
+func TestGenerator_TwoFactorErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(file *ast.GMXFile)
+		wantErr string
+	}{
+		{
+			name: "without issuer",
+			modify: func(file *ast.GMXFile) {
+				svc := file.Services[0]
+				svc.Fields = svc.Fields[:len(svc.Fields)-1]
+			},
+			wantErr: "function wipe: @require2fa requires a session service with an `issuer` field",
+		},
+		{
+			name: "with arguments",
+			modify: func(file *ast.GMXFile) {
+				file.Script.Funcs[1].Annotations[0].Args["_"] = "admin"
+			},
+			wantErr: "function wipe: @require2fa takes no arguments",
+		},
+		{
+			name: "on a function",
+			modify: func(file *ast.GMXFile) {
+				file.Script.Funcs[1].ReturnType = "string"
+			},
+			wantErr: "function wipe: @require2fa applies to handlers",
+		},
+		{
+			name: "model collision",
+			modify: func(file *ast.GMXFile) {
+				file.Models = append(file.Models, &ast.ModelDecl{Name: "BackupCode", Line: 7})
+			},
+			wantErr: "line 7: model BackupCode collides with the models storing two-factor authentication; rename it",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := twoFactorTestFile()
+			tt.modify(file)
+			_, err := New().Generate(file)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// typeaheadPathPrefix is the prefix of the built-in endpoints searching the
+// @typeahead models, followed by the model name
+const typeaheadPathPrefix = "/_gmx/typeahead/"
+
+// typeaheadModels returns the models with a @typeahead annotation
+func typeaheadModels(file *ast.GMXFile) []*ast.ModelDecl {
+	var models []*ast.ModelDecl
+	for _, model := range file.Models {
+		if model.FindAnnotation("typeahead") != nil {
+			models = append(models, model)
+		}
+	}
+	return models
+}
+
+// hasTypeahead checks if a model is searched as you type
+func (g *Generator) hasTypeahead(file *ast.GMXFile) bool {
+	return len(typeaheadModels(file)) > 0
+}
+
+// typeaheadFields returns the fields a @typeahead(fields: [a, b]) searches
+func typeaheadFields(ann *ast.Annotation) []string {
+	var fields []string
+	for _, field := range strings.Split(ann.Args["fields"], ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// typeaheadRoutes returns the built-in endpoints searching the @typeahead models
+func typeaheadRoutes(file *ast.GMXFile) []Route {
+	var routes []Route
+	for _, model := range typeaheadModels(file) {
+		routes = append(routes, Route{Method: "GET", Path: typeaheadPathPrefix + model.Name, Handler: "handle" + model.Name + "Typeahead"})
+	}
+	return routes
+}
+
+// validateTypeahead checks that each @typeahead searches text fields of its model
+func (g *Generator) validateTypeahead(file *ast.GMXFile) error {
+	for _, model := range typeaheadModels(file) {
+		ann := model.FindAnnotation("typeahead")
+		fields := typeaheadFields(ann)
+		if len(fields) == 0 {
+			return fmt.Errorf("model %s: @typeahead takes the fields to search, such as @typeahead(fields: [title])", model.Name)
+		}
+		for name := range ann.Args {
+			if name != "fields" && name != "empty" {
+				return fmt.Errorf("model %s: @typeahead takes fields: and empty:, not %s:", model.Name, name)
+			}
+		}
+		for _, name := range fields {
+			var field *ast.FieldDecl
+			for _, f := range model.Fields {
+				if f.Name == name {
+					field = f
+				}
+			}
+			if field == nil {
+				return fmt.Errorf("model %s: @typeahead field %s does not exist", model.Name, name)
+			}
+			if field.Type != "string" || isMoneyField(field) {
+				return fmt.Errorf("model %s: @typeahead field %s is a %s, only string fields are searched", model.Name, name, field.Type)
+			}
+		}
+	}
+	if file.Script != nil {
+		for _, fn := range file.Script.Funcs {
+			for _, route := range typeaheadRoutes(file) {
+				if "handle"+utils.Capitalize(fn.Name) == route.Handler {
+					return fmt.Errorf("line %d: function %s collides with the built-in %s endpoint; rename it", fn.Line, fn.Name, route.Path)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// genTypeahead generates the search input of the @typeahead models, the
+// endpoints rendering their matches, and the helpers they share
+func (g *Generator) genTypeahead(file *ast.GMXFile) string {
+	var b strings.Builder
+
+	b.WriteString("// typeaheadLimit is the number of matches a search input lists\n")
+	b.WriteString("const typeaheadLimit = 10\n\n")
+
+	b.WriteString("// typeaheadInput renders the search input of a @typeahead model: typing\n")
+	b.WriteString("// pauses of 300ms fetch its matches into the list below it\n")
+	b.WriteString("func typeaheadInput(model string) (template.HTML, error) {\n")
+	b.WriteString("\tswitch model {\n")
+	var names []string
+	for _, model := range typeaheadModels(file) {
+		names = append(names, fmt.Sprintf("%q", model.Name))
+	}
+	b.WriteString(fmt.Sprintf("\tcase %s:\n", strings.Join(names, ", ")))
+	b.WriteString("\tdefault:\n")
+	b.WriteString("\t\treturn \"\", fmt.Errorf(\"typeahead: model %q has no @typeahead\", model)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn template.HTML(fmt.Sprintf(`<div class=\"gmx-typeahead\">`+\n")
+	b.WriteString(fmt.Sprintf("\t\t`<input type=\"search\" name=\"q\" autocomplete=\"off\" hx-get=\"%s%%s\" hx-trigger=\"input changed delay:300ms, search\" hx-sync=\"this:replace\" hx-target=\"next .gmx-typeahead-results\">`+\n", typeaheadPathPrefix))
+	b.WriteString("\t\t`<ul class=\"gmx-typeahead-results\"></ul></div>`, model)), nil\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// typeaheadMatch keeps the records where one of columns contains q, ignoring case\n")
+	b.WriteString("func typeaheadMatch(q string, columns ...string) func(*gorm.DB) *gorm.DB {\n")
+	b.WriteString("\t// ! escapes the LIKE wildcards typed in q\n")
+	b.WriteString("\tpattern := \"%\" + strings.NewReplacer(\"!\", \"!!\", \"%\", \"!%\", \"_\", \"!_\").Replace(strings.ToLower(q)) + \"%\"\n")
+	b.WriteString("\tvar conds []string\n")
+	b.WriteString("\tvar args []any\n")
+	b.WriteString("\tfor _, column := range columns {\n")
+	b.WriteString("\t\tconds = append(conds, \"LOWER(\"+column+\") LIKE ? ESCAPE '!'\")\n")
+	b.WriteString("\t\targs = append(args, pattern)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn func(db *gorm.DB) *gorm.DB {\n")
+	b.WriteString("\t\treturn db.Where(strings.Join(conds, \" OR \"), args...)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// highlightMatch escapes text and marks the occurrences of q in it, ignoring case\n")
+	b.WriteString("func highlightMatch(text, q string) template.HTML {\n")
+	b.WriteString("\tvar b strings.Builder\n")
+	b.WriteString("\tstart := 0\n")
+	b.WriteString("\tfor i := 0; q != \"\" && i+len(q) <= len(text); {\n")
+	b.WriteString("\t\tif !strings.EqualFold(text[i:i+len(q)], q) {\n")
+	b.WriteString("\t\t\t_, size := utf8.DecodeRuneInString(text[i:])\n")
+	b.WriteString("\t\t\ti += size\n")
+	b.WriteString("\t\t\tcontinue\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tb.WriteString(template.HTMLEscapeString(text[start:i]))\n")
+	b.WriteString("\t\tb.WriteString(\"<mark>\" + template.HTMLEscapeString(text[i:i+len(q)]) + \"</mark>\")\n")
+	b.WriteString("\t\ti += len(q)\n")
+	b.WriteString("\t\tstart = i\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tb.WriteString(template.HTMLEscapeString(text[start:]))\n")
+	b.WriteString("\treturn template.HTML(b.String())\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// typeaheadEmpty renders the empty state of a search without matches\n")
+	b.WriteString("func typeaheadEmpty(w http.ResponseWriter, message string) {\n")
+	b.WriteString("\tfmt.Fprintf(w, `<li class=\"gmx-typeahead-empty\">%s</li>`, template.HTMLEscapeString(message))\n")
+	b.WriteString("}\n\n")
+
+	hasRLS := g.hasRowLevelSecurity(file)
+	hasSession := g.findSessionService(file.Services) != nil
+	for _, model := range typeaheadModels(file) {
+		ann := model.FindAnnotation("typeahead")
+		empty := ann.Args["empty"]
+		if empty == "" {
+			empty = "No results"
+		}
+		var columns, texts []string
+		for _, field := range typeaheadFields(ann) {
+			columns = append(columns, fmt.Sprintf("%q", utils.ToSnakeCase(field)))
+			texts = append(texts, fmt.Sprintf("highlightMatch(obj.%s, q)", utils.ToPascalCase(field)))
+		}
+		format := strings.TrimSuffix(strings.Repeat("%s · ", len(texts)), " · ")
+
+		b.WriteString(fmt.Sprintf("// handle%sTypeahead renders the %s records matching ?q=, as list items\n", model.Name, model.Name))
+		b.WriteString(fmt.Sprintf("func handle%sTypeahead(w http.ResponseWriter, r *http.Request) {\n", model.Name))
+		b.WriteString("\tif r.Method != http.MethodGet {\n")
+		b.WriteString("\t\thttp.Error(w, \"Method Not Allowed\", http.StatusMethodNotAllowed)\n")
+		b.WriteString("\t\treturn\n")
+		b.WriteString("\t}\n\n")
+		b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/html; charset=utf-8\")\n")
+		b.WriteString("\tq := strings.TrimSpace(r.URL.Query().Get(\"q\"))\n")
+		b.WriteString("\tif q == \"\" {\n")
+		b.WriteString("\t\t// A cleared input clears its matches\n")
+		b.WriteString("\t\treturn\n")
+		b.WriteString("\t}\n\n")
+		if hasRLS {
+			b.WriteString("\t// The request's connection carries the row-level security settings\n")
+			b.WriteString("\tdb := requestDB(r)\n")
+		}
+		match := fmt.Sprintf("typeaheadMatch(q, %s)", strings.Join(columns, ", "))
+		if model.Policy != nil {
+			b.WriteString("\t// Only the records the policy lets the user read are listed, up to the limit\n")
+			b.WriteString("\tctx := &GMXContext{DB: db, Writer: w, Request: r, Log: requestLogger(r)")
+			if hasRLS {
+				b.WriteString(", Tenant: requestTenant(r)")
+			}
+			if hasSession {
+				b.WriteString(", User: readSession(r).User")
+			}
+			b.WriteString("}\n")
+			b.WriteString(fmt.Sprintf("\tobjs, err := %sWhereAuthorized(ctx, typeaheadLimit, %s)\n", model.Name, match))
+		} else {
+			b.WriteString(fmt.Sprintf("\tobjs, err := %sWhere(db, %s, queryLimit(typeaheadLimit))\n", model.Name, match))
+		}
+		b.WriteString("\tif err != nil {\n")
+		b.WriteString(fmt.Sprintf("\t\trequestLogger(r).Error(\"typeahead\", \"model\", %q, \"error\", err)\n", model.Name))
+		b.WriteString("\t\thttp.Error(w, \"Internal Server Error\", http.StatusInternalServerError)\n")
+		b.WriteString("\t\treturn\n")
+		b.WriteString("\t}\n")
+		b.WriteString("\tif len(objs) == 0 {\n")
+		b.WriteString(fmt.Sprintf("\t\ttypeaheadEmpty(w, %q)\n", empty))
+		b.WriteString("\t\treturn\n")
+		b.WriteString("\t}\n")
+		b.WriteString("\tfor _, obj := range objs {\n")
+		if pk := modelPKField(model); pk != nil {
+			b.WriteString(fmt.Sprintf("\t\tfmt.Fprintf(w, `<li class=\"gmx-typeahead-item\" data-id=\"%%s\">%s</li>`, template.HTMLEscapeString(fmt.Sprint(obj.%s)), %s)\n",
+				format, utils.ToPascalCase(pk.Name), strings.Join(texts, ", ")))
+		} else {
+			b.WriteString(fmt.Sprintf("\t\tfmt.Fprintf(w, `<li class=\"gmx-typeahead-item\">%s</li>`, %s)\n", format, strings.Join(texts, ", ")))
+		}
+		b.WriteString("\t}\n")
+		b.WriteString("}\n\n")
+	}
+
+	return b.String()
+}
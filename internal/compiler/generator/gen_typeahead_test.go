@@ -0,0 +1,93 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+const typeaheadScript = `@typeahead(fields: [title, note], empty: "No task")
+model Task {
+  id: uuid @pk @default(uuid_v4)
+  title: string
+  note: string
+  done: bool
+}`
+
+func TestGenerateTypeahead(t *testing.T) {
+	file := scriptTestFile(t, typeaheadScript, signedTemplateSrc)
+	file.Template.Source = `<div>{{typeahead "Task"}}</div>`
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, want := range []string{
+		`"typeahead":`,
+		`"highlight":`,
+		`hx-trigger="input changed delay:300ms, search"`,
+		`mux.HandleFunc("/_gmx/typeahead/Task", handleTaskTypeahead)`,
+		// Searches go through the ORM helpers, generated without script functions
+		`objs, err := TaskWhere(db, typeaheadMatch(q, "title", "note"), queryLimit(typeaheadLimit))`,
+		`typeaheadEmpty(w, "No task")`,
+		"highlightMatch(obj.Title, q), highlightMatch(obj.Note, q)",
+		// Typed wildcards match literally
+		`conds = append(conds, "LOWER("+column+") LIKE ? ESCAPE '!'")`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+}
+
+func TestGenerateTypeaheadPolicy(t *testing.T) {
+	file := policyTestFile(false, "read")
+	file.Models[0].Annotations = []*ast.Annotation{{Name: "typeahead", Args: map[string]string{"fields": "title"}}}
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	// Only the records the policy lets the user read are listed
+	for _, want := range []string{
+		"ctx := &GMXContext{DB: db, Writer: w, Request: r, Log: requestLogger(r), User: readSession(r).User}",
+		`objs, err := TaskWhereAuthorized(ctx, typeaheadLimit, typeaheadMatch(q, "title"))`,
+		`typeaheadEmpty(w, "No results")`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+}
+
+func TestValidateTypeahead(t *testing.T) {
+	const task = "model Task {\n  id: uuid @pk\n  title: string\n  done: bool\n  secret: password\n}"
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{"no fields", "@typeahead\n" + task, "model Task: @typeahead takes the fields to search, such as @typeahead(fields: [title])"},
+		{"unknown field", "@typeahead(fields: [name])\n" + task, "model Task: @typeahead field name does not exist"},
+		{"bool field", "@typeahead(fields: [title, done])\n" + task, "model Task: @typeahead field done is a bool, only string fields are searched"},
+		{"password field", "@typeahead(fields: [secret])\n" + task, "model Task: @typeahead field secret is a password"},
+		{"unknown arg", "@typeahead(fields: [title], limit: 5)\n" + task, "model Task: @typeahead takes fields: and empty:, not limit:"},
+		{"func", "@typeahead(fields: [title])\n" + task + "\nfunc taskTypeahead() error {\n  return nil\n}", "line 8: function taskTypeahead collides with the built-in /_gmx/typeahead/Task endpoint"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New().Generate(scriptTestFile(t, tt.src, signedTemplateSrc))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package generator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// validationErrorTemplate is the default ValidationError fragment, rendered
+// with the *ValidationError a handler failed with
+const validationErrorTemplate = `{{define "ValidationError"}}<p class="gmx-validation-error" role="alert">{{.Message}}</p>{{end}}`
+
+// hasScriptHandlers checks if a script function is served as an HTTP handler
+func (g *Generator) hasScriptHandlers(file *ast.GMXFile) bool {
+	if file.Script == nil {
+		return false
+	}
+	for _, fn := range file.Script.Funcs {
+		if isHandler(fn) {
+			return true
+		}
+	}
+	return false
+}
+
+// validationReturn returns the Validate() statement rejecting a field, with
+// its message format and the arguments of the format
+func validationReturn(field, format string, args ...string) string {
+	message := strconv.Quote(format)
+	if len(args) > 0 {
+		message = fmt.Sprintf("fmt.Sprintf(%s, %s)", message, strings.Join(args, ", "))
+	}
+	return fmt.Sprintf("return &ValidationError{Field: %q, Message: %s}", field, message)
+}
+
+// genValidationError generates the error returned by Validate() and error(...),
+// which handlers show to the user instead of failing
+func (g *Generator) genValidationError() string {
+	var b strings.Builder
+	b.WriteString("// ValidationError is an error shown to the user: a field constraint\n")
+	b.WriteString("// Validate() rejected, or the message of a script error(...)\n")
+	b.WriteString("type ValidationError struct {\n")
+	b.WriteString("\tField   string // form field the error is about, empty for the whole form\n")
+	b.WriteString("\tMessage string\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("func (e *ValidationError) Error() string {\n")
+	b.WriteString("\tif e.Field == \"\" {\n")
+	b.WriteString("\t\treturn e.Message\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn e.Field + \": \" + e.Message\n")
+	b.WriteString("}\n\n")
+	return b.String()
+}
+
+// genRenderValidationError generates the response of handlers failing with a
+// ValidationError: the ValidationError fragment with a 422 status, retargeted
+// to the #<field>-error element next to the field, or #form-error
+func (g *Generator) genRenderValidationError() string {
+	var b strings.Builder
+	b.WriteString("// renderValidationError renders a validation error with a 422 status, swapped\n")
+	b.WriteString("// by htmx into the #<field>-error element, or #form-error without a field\n")
+	b.WriteString("func renderValidationError(w http.ResponseWriter, verr *ValidationError) {\n")
+	b.WriteString("\ttarget := \"#form-error\"\n")
+	b.WriteString("\tif verr.Field != \"\" {\n")
+	b.WriteString("\t\ttarget = \"#\" + verr.Field + \"-error\"\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/html; charset=utf-8\")\n")
+	b.WriteString("\tw.Header().Set(\"HX-Retarget\", target)\n")
+	b.WriteString("\tw.Header().Set(\"HX-Reswap\", \"innerHTML\")\n")
+	b.WriteString("\tw.WriteHeader(http.StatusUnprocessableEntity)\n")
+	b.WriteString("\tif err := tmpl.ExecuteTemplate(w, \"ValidationError\", verr); err != nil {\n")
+	b.WriteString("\t\tslog.Error(\"validation error template\", \"error\", err)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+	return b.String()
+}
@@ -0,0 +1,75 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+)
+
+const validationScript = `model Task {
+  id: uuid @pk @default(uuid_v4)
+  title: string @min(3)
+  email: string @email
+}
+func createTask(title: string) error {
+  if title == "nope" {
+    return error("nope is not allowed")
+  }
+  const task = Task{title: title}
+  try task.validate()
+  try task.save()
+  return render(task)
+}`
+
+func TestGenerateValidationErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     []string
+		notWant  []string
+	}{
+		{"default fragment", `<form hx-post="{{route "createTask"}}"></form>`, []string{
+			`{{define "ValidationError"}}<p class="gmx-validation-error" role="alert">{{.Message}}</p>{{end}}`,
+		}, nil},
+		{"own fragment", `<form hx-post="{{route "createTask"}}"></form>{{define "ValidationError"}}<em>{{.Message}}</em>{{end}}`, []string{
+			`{{define "ValidationError"}}<em>{{.Message}}</em>{{end}}`,
+		}, []string{
+			`class="gmx-validation-error"`,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := scriptTestFile(t, validationScript, signedTemplateSrc)
+			file.Template.Source = tt.template
+			code, err := New().Generate(file)
+			if err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+			if !isValidGo(code) {
+				t.Errorf("Generated code is not valid Go:\n%s", code)
+			}
+
+			want := append(tt.want,
+				// Model constraints name their field, error(...) is about the whole form
+				`return &ValidationError{Field: "title", Message: fmt.Sprintf("minimum length is 3, got %d", len(t.Title))}`,
+				`return &ValidationError{Field: "email", Message: "invalid email format"}`,
+				`return &ValidationError{Message: "nope is not allowed"}`,
+				"if errors.As(err, &verr) {\n\t\t\trenderValidationError(w, verr)",
+				`w.Header().Set("HX-Retarget", target)`,
+				"w.WriteHeader(http.StatusUnprocessableEntity)",
+				// htmx swaps 422 responses, which it ignores by default
+				"if (e.detail.xhr.status === 422) {",
+			)
+			for _, w := range want {
+				if !strings.Contains(code, w) {
+					t.Errorf("generated code missing %q", w)
+				}
+			}
+			for _, w := range tt.notWant {
+				if strings.Contains(code, w) {
+					t.Errorf("generated code should not contain %q", w)
+				}
+			}
+		})
+	}
+}
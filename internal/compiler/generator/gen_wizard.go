@@ -0,0 +1,450 @@
+package generator
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// wizardPathPrefix is the prefix of the built-in endpoints of the wizards
+const wizardPathPrefix = "/_gmx/wizard/"
+
+// wizardCall matches {{wizard "Name"}} calls
+var wizardCall = regexp.MustCompile(`\{\{-?\s*wizard\s+"([^"]*)"`)
+
+// hasWizards checks if the script declares a wizard
+func (g *Generator) hasWizards(file *ast.GMXFile) bool {
+	return len(file.Wizards) > 0
+}
+
+// wizardRoutes returns the endpoints rendering a wizard and moving between its steps
+func wizardRoutes(file *ast.GMXFile) []Route {
+	var routes []Route
+	for _, wizard := range file.Wizards {
+		handler := "handle" + wizard.Name + "Wizard"
+		routes = append(routes,
+			Route{Method: "GET", Path: wizardPathPrefix + wizard.Name, Handler: handler},
+			Route{Method: "POST", Path: wizardPathPrefix + wizard.Name + "/next", Handler: handler + "Next"},
+			Route{Method: "POST", Path: wizardPathPrefix + wizard.Name + "/back", Handler: handler + "Back"})
+	}
+	return routes
+}
+
+// wizardStepType returns the name of the struct validating a step
+func wizardStepType(wizard *ast.WizardDecl, step *ast.WizardStep) string {
+	return wizard.Name + utils.Capitalize(step.Name) + "Step"
+}
+
+// wizardStepTemplate returns the name of the template rendering a step
+func wizardStepTemplate(wizard *ast.WizardDecl, step *ast.WizardStep) string {
+	return wizard.Name + utils.Capitalize(step.Name)
+}
+
+// validateWizards checks that the wizards can render their steps and call
+// their finish function with the values of the steps
+func (g *Generator) validateWizards(file *ast.GMXFile) error {
+	if !g.hasWizards(file) {
+		return nil
+	}
+	funcs := make(map[string]*ast.FuncDecl)
+	if file.Script != nil {
+		for _, fn := range file.Script.Funcs {
+			funcs[fn.Name] = fn
+		}
+	}
+	wizards := make(map[string]bool)
+	for _, wizard := range file.Wizards {
+		if wizards[wizard.Name] {
+			return fmt.Errorf("line %d: wizard %s is declared twice", wizard.Line, wizard.Name)
+		}
+		wizards[wizard.Name] = true
+		if file.Template == nil {
+			return fmt.Errorf("line %d: wizard %s requires a <template> section rendering its steps", wizard.Line, wizard.Name)
+		}
+		for _, step := range wizard.Steps {
+			for _, model := range file.Models {
+				if model.Name == wizardStepType(wizard, step) {
+					return fmt.Errorf("line %d: step %s of wizard %s collides with model %s; rename one of them", step.Line, step.Name, wizard.Name, model.Name)
+				}
+			}
+		}
+
+		finish := funcs[wizard.Finish]
+		if finish == nil || (finish.ReturnType != "" && finish.ReturnType != "error") {
+			return fmt.Errorf("line %d: wizard %s finishes with %s, which is not a script function returning error", wizard.Line, wizard.Name, wizard.Finish)
+		}
+		if finish.FindAnnotation("signed") != nil {
+			return fmt.Errorf("line %d: wizard %s finishes with %s, which is @signed; signed links cannot receive the values of a wizard", wizard.Line, wizard.Name, wizard.Finish)
+		}
+		for _, param := range finish.Params {
+			field := wizard.FindField(param.Name)
+			if field == nil {
+				return fmt.Errorf("line %d: parameter %s of %s is not a field of wizard %s", finish.Line, param.Name, finish.Name, wizard.Name)
+			}
+			if field.Type != param.Type {
+				return fmt.Errorf("line %d: parameter %s of %s is %s, but wizard %s declares it as %s", finish.Line, param.Name, finish.Name, param.Type, wizard.Name, field.Type)
+			}
+		}
+
+		for _, fn := range funcs {
+			for _, route := range wizardRoutes(&ast.GMXFile{Wizards: []*ast.WizardDecl{wizard}}) {
+				if "handle"+utils.Capitalize(fn.Name) == route.Handler {
+					return fmt.Errorf("line %d: function %s collides with the built-in %s endpoint; rename it", fn.Line, fn.Name, route.Path)
+				}
+			}
+		}
+	}
+
+	for _, src := range pageTemplateSources(file) {
+		for _, m := range wizardCall.FindAllStringSubmatch(src, -1) {
+			if !wizards[m[1]] {
+				return fmt.Errorf("template: {{wizard %q}} is not a wizard declared in the script", m[1])
+			}
+		}
+	}
+	return nil
+}
+
+// genWizardTemplates generates the default fragment of each step: a form
+// posting to the next step, with the error placeholders of its fields; the
+// page may define its own fragment under the same name
+func genWizardTemplates(file *ast.GMXFile, page string) string {
+	var b strings.Builder
+	for _, wizard := range file.Wizards {
+		base := wizardPathPrefix + wizard.Name
+		for _, step := range wizard.Steps {
+			name := wizardStepTemplate(wizard, step)
+			if strings.Contains(page, fmt.Sprintf("define %q", name)) {
+				continue
+			}
+			b.WriteString(fmt.Sprintf("{{define %q}}<form class=\"gmx-wizard\" hx-post=\"%s/next\" hx-swap=\"outerHTML\">\n", name, base))
+			b.WriteString(fmt.Sprintf("<p class=\"gmx-wizard-progress\">Step {{.Number}} of {{.Count}}: %s</p>\n", step.Name))
+			for _, field := range step.Fields {
+				id := "gmx-wizard-" + field.Name
+				switch field.Type {
+				case "bool":
+					b.WriteString(fmt.Sprintf("<label><input id=%q name=%q type=\"checkbox\" value=\"true\"{{if eq (.Values.Get %q) \"true\"}} checked{{end}}> %s</label>\n", id, field.Name, field.Name, field.Name))
+				default:
+					b.WriteString(fmt.Sprintf("<label for=%q>%s</label>\n", id, field.Name))
+					b.WriteString(fmt.Sprintf("<input id=%q name=%q type=%q value=\"{{.Values.Get %q}}\">\n", id, field.Name, wizardInputType(field), field.Name))
+				}
+				b.WriteString(fmt.Sprintf("<div id=\"%s-error\"></div>\n", field.Name))
+			}
+			b.WriteString("<div id=\"form-error\"></div>\n")
+			b.WriteString("<div class=\"gmx-wizard-actions\">")
+			b.WriteString(fmt.Sprintf("{{if not .First}}<button type=\"button\" hx-post=\"%s/back\" hx-include=\"closest form\" hx-target=\"closest form\" hx-swap=\"outerHTML\">Back</button>{{end}}", base))
+			b.WriteString("<button type=\"submit\">{{if .Last}}Finish{{else}}Next{{end}}</button></div>\n")
+			b.WriteString("</form>{{end}}\n")
+		}
+	}
+	return b.String()
+}
+
+// wizardInputType returns the input type of a text or number field
+func wizardInputType(field *ast.FieldDecl) string {
+	if field.Type == "int" {
+		return "number"
+	}
+	for _, ann := range field.Annotations {
+		if ann.Name == "email" {
+			return "email"
+		}
+	}
+	return "text"
+}
+
+// genWizards generates the in-memory drafts of the wizards, the step structs
+// validating the posted values, and the endpoints moving between the steps
+func (g *Generator) genWizards(file *ast.GMXFile) string {
+	var b strings.Builder
+
+	b.WriteString("// wizardTTL is how long an unfinished wizard keeps its values\n")
+	b.WriteString("const wizardTTL = 24 * time.Hour\n\n")
+
+	b.WriteString("// wizardDraft holds the current step and the values of a wizard in progress\n")
+	b.WriteString("type wizardDraft struct {\n")
+	b.WriteString("\tStep    int\n")
+	b.WriteString("\tValues  url.Values\n")
+	b.WriteString("\tUpdated time.Time\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// wizardDrafts keeps the drafts in memory, by wizard and draft cookie\n")
+	b.WriteString("var wizardDrafts = struct {\n")
+	b.WriteString("\tsync.Mutex\n")
+	b.WriteString("\tm map[string]wizardDraft\n")
+	b.WriteString("}{m: make(map[string]wizardDraft)}\n\n")
+
+	b.WriteString("// wizardStep is a step of a wizard and the template rendering it\n")
+	b.WriteString("type wizardStep struct {\n")
+	b.WriteString("\tName     string\n")
+	b.WriteString("\tTemplate string\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// wizards lists the steps of each wizard, in order\n")
+	b.WriteString("var wizards = map[string][]wizardStep{\n")
+	for _, wizard := range file.Wizards {
+		b.WriteString(fmt.Sprintf("\t%q: {\n", wizard.Name))
+		for _, step := range wizard.Steps {
+			b.WriteString(fmt.Sprintf("\t\t{Name: %q, Template: %q},\n", step.Name, wizardStepTemplate(wizard, step)))
+		}
+		b.WriteString("\t},\n")
+	}
+	b.WriteString("}\n\n")
+
+	b.WriteString("// wizardView is the data of a step fragment\n")
+	b.WriteString("type wizardView struct {\n")
+	b.WriteString("\tWizard string\n")
+	b.WriteString("\tStep   string\n")
+	b.WriteString("\tNumber int // 1-based position of the step\n")
+	b.WriteString("\tCount  int\n")
+	b.WriteString("\tFirst  bool\n")
+	b.WriteString("\tLast   bool\n")
+	b.WriteString("\tValues url.Values // values of every step typed so far\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// wizardLoader returns the placeholder loading the current step of a wizard\n")
+	b.WriteString("func wizardLoader(name string) (template.HTML, error) {\n")
+	b.WriteString("\tif _, ok := wizards[name]; !ok {\n")
+	b.WriteString("\t\treturn \"\", fmt.Errorf(\"wizard: unknown wizard %q\", name)\n")
+	b.WriteString("\t}\n")
+	b.WriteString(fmt.Sprintf("\treturn template.HTML(`<div hx-get=\"%s` + name + `\" hx-trigger=\"load\" hx-swap=\"outerHTML\"></div>`), nil\n", wizardPathPrefix))
+	b.WriteString("}\n\n")
+
+	b.WriteString("// loadWizardDraft returns the draft named by the wizard cookie of the\n")
+	b.WriteString("// request and its key, starting a draft when it is missing or expired\n")
+	b.WriteString("func loadWizardDraft(w http.ResponseWriter, r *http.Request, wizard string) (string, wizardDraft) {\n")
+	b.WriteString("\tcookieName := \"gmx_wizard_\" + wizard\n")
+	b.WriteString("\twizardDrafts.Lock()\n")
+	b.WriteString("\tdefer wizardDrafts.Unlock()\n\n")
+	b.WriteString("\tnow := time.Now()\n")
+	b.WriteString("\tfor key, draft := range wizardDrafts.m {\n")
+	b.WriteString("\t\tif now.Sub(draft.Updated) > wizardTTL {\n")
+	b.WriteString("\t\t\tdelete(wizardDrafts.m, key)\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif cookie, err := r.Cookie(cookieName); err == nil {\n")
+	b.WriteString("\t\tkey := wizard + \"/\" + cookie.Value\n")
+	b.WriteString("\t\tif draft, ok := wizardDrafts.m[key]; ok {\n")
+	b.WriteString("\t\t\t// Handlers change a copy, stored back by saveWizardDraft\n")
+	b.WriteString("\t\t\tvalues := url.Values{}\n")
+	b.WriteString("\t\t\tfor name, vs := range draft.Values {\n")
+	b.WriteString("\t\t\t\tvalues[name] = append([]string(nil), vs...)\n")
+	b.WriteString("\t\t\t}\n")
+	b.WriteString("\t\t\tdraft.Values = values\n")
+	b.WriteString("\t\t\treturn key, draft\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString("\tid := make([]byte, 16)\n")
+	b.WriteString("\trand.Read(id)\n")
+	b.WriteString("\tvalue := fmt.Sprintf(\"%x\", id)\n")
+	b.WriteString("\thttp.SetCookie(w, &http.Cookie{\n")
+	b.WriteString("\t\tName:     cookieName,\n")
+	b.WriteString("\t\tValue:    value,\n")
+	b.WriteString("\t\tPath:     \"/\",\n")
+	b.WriteString("\t\tMaxAge:   int(wizardTTL.Seconds()),\n")
+	b.WriteString("\t\tHttpOnly: true,\n")
+	b.WriteString("\t\tSameSite: http.SameSiteLaxMode,\n")
+	b.WriteString("\t})\n")
+	b.WriteString("\tdraft := wizardDraft{Values: url.Values{}, Updated: now}\n")
+	b.WriteString("\twizardDrafts.m[wizard+\"/\"+value] = draft\n")
+	b.WriteString("\treturn wizard + \"/\" + value, draft\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// saveWizardDraft stores the changes of a draft\n")
+	b.WriteString("func saveWizardDraft(key string, draft wizardDraft) {\n")
+	b.WriteString("\twizardDrafts.Lock()\n")
+	b.WriteString("\tdefer wizardDrafts.Unlock()\n")
+	b.WriteString("\tdraft.Updated = time.Now()\n")
+	b.WriteString("\twizardDrafts.m[key] = draft\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// dropWizardDraft forgets the draft of a finished wizard\n")
+	b.WriteString("func dropWizardDraft(key string) {\n")
+	b.WriteString("\twizardDrafts.Lock()\n")
+	b.WriteString("\tdefer wizardDrafts.Unlock()\n")
+	b.WriteString("\tdelete(wizardDrafts.m, key)\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// renderWizardStep renders the fragment of the current step of a wizard\n")
+	b.WriteString("func renderWizardStep(w http.ResponseWriter, wizard string, draft wizardDraft) {\n")
+	b.WriteString("\tsteps := wizards[wizard]\n")
+	b.WriteString("\tstep := steps[draft.Step]\n")
+	b.WriteString("\tview := wizardView{\n")
+	b.WriteString("\t\tWizard: wizard,\n")
+	b.WriteString("\t\tStep:   step.Name,\n")
+	b.WriteString("\t\tNumber: draft.Step + 1,\n")
+	b.WriteString("\t\tCount:  len(steps),\n")
+	b.WriteString("\t\tFirst:  draft.Step == 0,\n")
+	b.WriteString("\t\tLast:   draft.Step == len(steps)-1,\n")
+	b.WriteString("\t\tValues: draft.Values,\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tw.Header().Set(\"Content-Type\", \"text/html; charset=utf-8\")\n")
+	b.WriteString("\tif err := tmpl.ExecuteTemplate(w, step.Template, view); err != nil {\n")
+	b.WriteString("\t\tslog.Error(\"template error\", \"wizard\", wizard, \"error\", err)\n")
+	b.WriteString("\t\thttp.Error(w, \"Internal Server Error\", http.StatusInternalServerError)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("// wizardResponse records the status written by the finish function of a wizard\n")
+	b.WriteString("type wizardResponse struct {\n")
+	b.WriteString("\thttp.ResponseWriter\n")
+	b.WriteString("\tstatus int\n")
+	b.WriteString("}\n\n")
+	b.WriteString("func (r *wizardResponse) WriteHeader(status int) {\n")
+	b.WriteString("\tr.status = status\n")
+	b.WriteString("\tr.ResponseWriter.WriteHeader(status)\n")
+	b.WriteString("}\n\n")
+
+	funcs := make(map[string]*ast.FuncDecl)
+	for _, fn := range file.Script.Funcs {
+		funcs[fn.Name] = fn
+	}
+	for _, wizard := range file.Wizards {
+		b.WriteString(g.genWizard(wizard, funcs[wizard.Finish]))
+	}
+
+	return b.String()
+}
+
+// genWizard generates the step structs and the endpoints of a wizard
+func (g *Generator) genWizard(wizard *ast.WizardDecl, finish *ast.FuncDecl) string {
+	var b strings.Builder
+	handler := "handle" + wizard.Name + "Wizard"
+	read := "read" + wizard.Name + "WizardStep"
+
+	// Each step validates its fields as a model would
+	for _, step := range wizard.Steps {
+		stepType := wizardStepType(wizard, step)
+		b.WriteString(fmt.Sprintf("// %s holds the values of step %s of wizard %s\n", stepType, step.Name, wizard.Name))
+		b.WriteString(fmt.Sprintf("type %s struct {\n", stepType))
+		for _, field := range step.Fields {
+			b.WriteString(fmt.Sprintf("\t%s %s\n", utils.ToPascalCase(field.Name), wizardGoType(field.Type)))
+		}
+		b.WriteString("}\n\n")
+		b.WriteString(g.genValidation(&ast.ModelDecl{Name: stepType, Fields: step.Fields}))
+	}
+
+	b.WriteString(fmt.Sprintf("// %s reads the fields of a step of wizard %s from the posted form into\n", read, wizard.Name))
+	b.WriteString("// the draft values, then validates them\n")
+	b.WriteString(fmt.Sprintf("func %s(step int, form url.Values, values url.Values) error {\n", read))
+	b.WriteString("\tswitch step {\n")
+	for i, step := range wizard.Steps {
+		stepType := wizardStepType(wizard, step)
+		recv := utils.ReceiverName(stepType)
+		b.WriteString(fmt.Sprintf("\tcase %d:\n", i))
+		b.WriteString(fmt.Sprintf("\t\tvar %s %s\n", recv, stepType))
+		for _, field := range step.Fields {
+			goField := recv + "." + utils.ToPascalCase(field.Name)
+			switch field.Type {
+			case "int":
+				b.WriteString(fmt.Sprintf("\t\tvalues.Set(%q, form.Get(%q))\n", field.Name, field.Name))
+				b.WriteString(fmt.Sprintf("\t\t%sInt, err := strconv.Atoi(form.Get(%q))\n", field.Name, field.Name))
+				b.WriteString("\t\tif err != nil {\n")
+				b.WriteString(fmt.Sprintf("\t\t\treturn &ValidationError{Field: %q, Message: \"must be a whole number\"}\n", field.Name))
+				b.WriteString("\t\t}\n")
+				b.WriteString(fmt.Sprintf("\t\t%s = %sInt\n", goField, field.Name))
+			case "bool":
+				b.WriteString("\t\t// Unchecked boxes are not posted\n")
+				b.WriteString(fmt.Sprintf("\t\t%s, _ = strconv.ParseBool(form.Get(%q))\n", goField, field.Name))
+				b.WriteString(fmt.Sprintf("\t\tvalues.Set(%q, strconv.FormatBool(%s))\n", field.Name, goField))
+			default:
+				b.WriteString(fmt.Sprintf("\t\t%s = form.Get(%q)\n", goField, field.Name))
+				b.WriteString(fmt.Sprintf("\t\tvalues.Set(%q, %s)\n", field.Name, goField))
+			}
+		}
+		if g.genValidation(&ast.ModelDecl{Name: stepType, Fields: step.Fields}) != "" {
+			b.WriteString(fmt.Sprintf("\t\treturn %s.Validate()\n", recv))
+		} else {
+			b.WriteString(fmt.Sprintf("\t\t_ = %s\n", recv))
+		}
+	}
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn nil\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString(fmt.Sprintf("// %s renders the current step of wizard %s\n", handler, wizard.Name))
+	b.WriteString(fmt.Sprintf("func %s(w http.ResponseWriter, r *http.Request) {\n", handler))
+	b.WriteString("\tif r.Method != http.MethodGet {\n")
+	b.WriteString("\t\thttp.Error(w, \"Method Not Allowed\", http.StatusMethodNotAllowed)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString(fmt.Sprintf("\t_, draft := loadWizardDraft(w, r, %q)\n", wizard.Name))
+	b.WriteString(fmt.Sprintf("\trenderWizardStep(w, %q, draft)\n", wizard.Name))
+	b.WriteString("}\n\n")
+
+	b.WriteString(fmt.Sprintf("// %sNext validates the posted step of wizard %s and renders the next\n", handler, wizard.Name))
+	b.WriteString(fmt.Sprintf("// one; the last step calls %s with the values of every step\n", wizard.Finish))
+	b.WriteString(fmt.Sprintf("func %sNext(w http.ResponseWriter, r *http.Request) {\n", handler))
+	b.WriteString("\tif r.Method != http.MethodPost {\n")
+	b.WriteString("\t\thttp.Error(w, \"Method Not Allowed\", http.StatusMethodNotAllowed)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif err := r.ParseForm(); err != nil {\n")
+	b.WriteString("\t\thttp.Error(w, \"Invalid form data\", http.StatusBadRequest)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString(fmt.Sprintf("\tkey, draft := loadWizardDraft(w, r, %q)\n", wizard.Name))
+	b.WriteString(fmt.Sprintf("\terr := %s(draft.Step, r.PostForm, draft.Values)\n", read))
+	b.WriteString("\tsaveWizardDraft(key, draft)\n")
+	b.WriteString("\tif err != nil {\n")
+	b.WriteString("\t\tvar verr *ValidationError\n")
+	b.WriteString("\t\tif errors.As(err, &verr) {\n")
+	b.WriteString("\t\t\trenderValidationError(w, verr)\n")
+	b.WriteString("\t\t\treturn\n")
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\trequestLogger(r).Error(\"wizard error\", \"error\", err)\n")
+	b.WriteString("\t\thttp.Error(w, \"Internal Server Error\", http.StatusInternalServerError)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString(fmt.Sprintf("\tif draft.Step < len(wizards[%q])-1 {\n", wizard.Name))
+	b.WriteString("\t\tdraft.Step++\n")
+	b.WriteString("\t\tsaveWizardDraft(key, draft)\n")
+	b.WriteString(fmt.Sprintf("\t\trenderWizardStep(w, %q, draft)\n", wizard.Name))
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString(fmt.Sprintf("\t// %s reads its parameters from the values of the steps; the draft\n", wizard.Finish))
+	b.WriteString("\t// is kept when it fails, so that the values can be corrected\n")
+	b.WriteString("\tfinish := r.Clone(r.Context())\n")
+	b.WriteString(fmt.Sprintf("\tfinish.Method = http.Method%s\n", handlerMethod(finish)))
+	b.WriteString("\tfinish.Form = draft.Values\n")
+	b.WriteString("\tfinish.PostForm = draft.Values\n")
+	b.WriteString("\trec := &wizardResponse{ResponseWriter: w, status: http.StatusOK}\n")
+	b.WriteString(fmt.Sprintf("\thandle%s(rec, finish)\n", utils.Capitalize(finish.Name)))
+	b.WriteString("\tif rec.status < http.StatusBadRequest {\n")
+	b.WriteString("\t\tdropWizardDraft(key)\n")
+	b.WriteString("\t}\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString(fmt.Sprintf("// %sBack keeps the values typed in the current step of wizard %s,\n", handler, wizard.Name))
+	b.WriteString("// unvalidated, and renders the previous step\n")
+	b.WriteString(fmt.Sprintf("func %sBack(w http.ResponseWriter, r *http.Request) {\n", handler))
+	b.WriteString("\tif r.Method != http.MethodPost {\n")
+	b.WriteString("\t\thttp.Error(w, \"Method Not Allowed\", http.StatusMethodNotAllowed)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tif err := r.ParseForm(); err != nil {\n")
+	b.WriteString("\t\thttp.Error(w, \"Invalid form data\", http.StatusBadRequest)\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t}\n\n")
+	b.WriteString(fmt.Sprintf("\tkey, draft := loadWizardDraft(w, r, %q)\n", wizard.Name))
+	b.WriteString(fmt.Sprintf("\t_ = %s(draft.Step, r.PostForm, draft.Values)\n", read))
+	b.WriteString("\tif draft.Step > 0 {\n")
+	b.WriteString("\t\tdraft.Step--\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\tsaveWizardDraft(key, draft)\n")
+	b.WriteString(fmt.Sprintf("\trenderWizardStep(w, %q, draft)\n", wizard.Name))
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+// wizardGoType returns the Go type of a wizard field
+func wizardGoType(fieldType string) string {
+	if fieldType == "int" || fieldType == "bool" {
+		return fieldType
+	}
+	return "string"
+}
@@ -0,0 +1,102 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+)
+
+const wizardScriptSrc = `model Member {
+  id: uuid @pk @default(uuid_v4)
+  name: string
+}
+wizard Onboarding {
+  step profile {
+    name: string @min(2)
+    email: string @email
+  }
+  step preferences {
+    age: int @min(13)
+    newsletter: bool
+  }
+  finish completeOnboarding
+}
+func completeOnboarding(name: string, age: int, newsletter: bool) error {
+  const member = Member{name: name}
+  try member.save()
+  return nil
+}`
+
+const wizardTemplateSrc = `<main>{{wizard "Onboarding"}}</main>`
+
+func TestGenerateWizard(t *testing.T) {
+	code, err := New().Generate(scriptTestFile(t, wizardScriptSrc, wizardTemplateSrc))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, want := range []string{
+		`"wizard":`,
+		`mux.HandleFunc("/_gmx/wizard/Onboarding", handleOnboardingWizard)`,
+		`mux.HandleFunc("/_gmx/wizard/Onboarding/next", handleOnboardingWizardNext)`,
+		`mux.HandleFunc("/_gmx/wizard/Onboarding/back", handleOnboardingWizardBack)`,
+		// Each step is validated as a model
+		"type OnboardingProfileStep struct {",
+		"func (o *OnboardingPreferencesStep) Validate() error {",
+		`return &ValidationError{Field: "age", Message: "must be a whole number"}`,
+		// Default step forms, with a Back button after the first step
+		`{{define "OnboardingProfile"}}<form class="gmx-wizard" hx-post="/_gmx/wizard/Onboarding/next"`,
+		`<input id="gmx-wizard-email" name="email" type="email" value="{{.Values.Get "email"}}">`,
+		`{{if not .First}}<button type="button" hx-post="/_gmx/wizard/Onboarding/back"`,
+		`cookieName := "gmx_wizard_" + wizard`,
+		"handleCompleteOnboarding(rec, finish)",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+}
+
+func TestGenerateWizardCustomStep(t *testing.T) {
+	file := scriptTestFile(t, wizardScriptSrc, wizardTemplateSrc)
+	file.Template.Source += `{{define "OnboardingProfile"}}<form hx-post="/_gmx/wizard/Onboarding/next"><input name="name"></form>{{end}}`
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if strings.Count(code, `{{define "OnboardingProfile"}}`) != 1 {
+		t.Errorf("a step defined by the page replaces its default form")
+	}
+	if !strings.Contains(code, `{{define "OnboardingPreferences"}}`) {
+		t.Errorf("steps not defined by the page keep their default form")
+	}
+}
+
+func TestValidateWizards(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		template string
+		wantErr  string
+	}{
+		{"unknown finish", strings.Replace(wizardScriptSrc, "finish completeOnboarding", "finish onboard", 1), `{{wizard "Onboarding"}}`, "line 5: wizard Onboarding finishes with onboard, which is not a script function returning error"},
+		{"unknown parameter", strings.Replace(wizardScriptSrc, "newsletter: bool) error", "plan: string) error", 1), `{{wizard "Onboarding"}}`, "parameter plan of completeOnboarding is not a field of wizard Onboarding"},
+		{"parameter type", strings.Replace(wizardScriptSrc, "age: int, newsletter", "age: string, newsletter", 1), `{{wizard "Onboarding"}}`, "parameter age of completeOnboarding is string, but wizard Onboarding declares it as int"},
+		{"step collides with model", strings.Replace(wizardScriptSrc, "model Member", "model OnboardingProfileStep", 1), `{{wizard "Onboarding"}}`, "step profile of wizard Onboarding collides with model OnboardingProfileStep"},
+		{"func", wizardScriptSrc + "\nfunc onboardingWizardNext() error {\n  return nil\n}", `{{wizard "Onboarding"}}`, "function onboardingWizardNext collides with the built-in /_gmx/wizard/Onboarding/next endpoint"},
+		{"unknown wizard", wizardScriptSrc, `{{wizard "Signup"}}`, `template: {{wizard "Signup"}} is not a wizard declared in the script`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := scriptTestFile(t, tt.src, wizardTemplateSrc)
+			file.Template.Source = tt.template
+			_, err := New().Generate(file)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -6,34 +6,250 @@ import (
 	"github.com/btouchard/gmx/internal/compiler/resolver"
 	"github.com/btouchard/gmx/internal/compiler/script"
 	"go/format"
+	gotoken "go/token"
 	"strings"
 )
 
 type Generator struct {
-	// Will hold state for more complex generation in later phases
+	opts Options
+}
+
+// Options tunes code generation for a build
+type Options struct {
+	// Dev targets local development: mail is caught instead of sent
+	Dev bool
+	// Package is the generated package name (default "main"); other
+	// packages export a Main() entry point instead of main()
+	Package string
+	// CriticalCSS inlines the bundled style rules used by the initial
+	// render of a multi-file page and lazy-loads the rest
+	CriticalCSS bool
+	// Minify strips insignificant whitespace and comments from the
+	// embedded page template and stylesheets
+	Minify bool
+	// Source is the path of the compiled .gmx file, reported by diagnostics
+	Source string
+	// Strict rejects implicit behaviors: unused declarations, routes the
+	// template does not reference, the fallback SQLite database and saves
+	// that skip model validation
+	Strict bool
+	// Migrations are the versioned SQL migrations of the app's database
+	// provider; the generated server applies them instead of AutoMigrate
+	Migrations []Migration
 }
 
 func New() *Generator {
 	return &Generator{}
 }
 
+// NewWithOptions creates a generator using the given build options
+func NewWithOptions(opts Options) *Generator {
+	return &Generator{opts: opts}
+}
+
+// withGeneratedModels returns the file with the models and fields the
+// generated code stores its state in, as they are declared and migrated
+func (g *Generator) withGeneratedModels(file *ast.GMXFile) *ast.GMXFile {
+	// @timestamps models get their createdAt and updatedAt fields
+	file = g.withTimestamps(file)
+
+	// Settings, notifications, the activity feed, autosaved drafts, jobs, the
+	// runs of the job queue kept in the database, the published events, the
+	// sessions of the database store, the second factors of users, the
+	// pending account deletions and the accepted terms are stored by
+	// generated models
+	file = g.withSettingModel(file)
+	file = g.withNotificationModel(file)
+	file = g.withActivityModel(file)
+	file = g.withDraftModel(file)
+	file = g.withJobModel(file)
+	file = g.withStoredJobModel(file)
+	file = g.withOutboxModel(file)
+	file = g.withSessionModel(file)
+	file = g.withTwoFactorModels(file)
+	file = g.withAccountModels(file)
+	file = g.withConsentModel(file)
+
+	// Image fields record the digest naming their stored variants
+	return g.withImageDigests(file)
+}
+
+// packageName returns the name of the generated package
+func (g *Generator) packageName() string {
+	if g.opts.Package == "" {
+		return "main"
+	}
+	return g.opts.Package
+}
+
 // GenerateResolved generates Go code from a resolved GMX file with imports
 func (g *Generator) GenerateResolved(resolved *resolver.ResolvedFile) (string, error) {
-	// Use the internal method on the merged Main file with components
-	return g.generateWithComponents(resolved.Main, resolved.Components)
+	// Use the internal method on the merged Main file with components and fragments of other pages
+	return g.generateWithComponents(resolved.Main, resolved.Components, resolved.Fragments)
 }
 
 // Generate takes a GMXFile AST and produces complete, compilable Go source code
 // This method is kept for backward compatibility (single-file compilation)
 func (g *Generator) Generate(file *ast.GMXFile) (string, error) {
 	// No components for single-file compilation
-	return g.generateWithComponents(file, nil)
+	return g.generateWithComponents(file, nil, nil)
 }
 
 // generateWithComponents is the internal implementation that handles both single-file and multi-file compilation
-func (g *Generator) generateWithComponents(file *ast.GMXFile, components map[string]*resolver.ComponentInfo) (string, error) {
+func (g *Generator) generateWithComponents(file *ast.GMXFile, components map[string]*resolver.ComponentInfo, fragments map[string]*resolver.FragmentInfo) (string, error) {
 	var b strings.Builder
 
+	if pkg := g.packageName(); !gotoken.IsIdentifier(pkg) {
+		return "", fmt.Errorf("invalid package name %q", pkg)
+	}
+
+	// Keep the #if declarations that hold for this build
+	file, err := g.selectCompileTime(file)
+	if err != nil {
+		return "", err
+	}
+
+	// Policies are enforced by the ORM helpers emitted with the script functions,
+	// even when the models come from imported files; typeahead searches use them too,
+	// and backups run with the GMXContext of the scheduled runs
+	if file.Script == nil && (g.hasPolicies(file) || g.hasTypeahead(file) || g.findBackupService(file.Services) != nil) {
+		withScript := *file
+		withScript.Script = &ast.ScriptBlock{Funcs: []*ast.FuncDecl{}}
+		file = &withScript
+	}
+
+	// Reject annotations the generator cannot honor
+	if err := g.validateQueuedJobs(file); err != nil {
+		return "", err
+	}
+	if err := g.validateOutbox(file); err != nil {
+		return "", err
+	}
+	if err := g.validateSchedules(file); err != nil {
+		return "", err
+	}
+	if err := g.validateFuncAnnotations(file); err != nil {
+		return "", err
+	}
+	if err := g.validateSignedRoutes(file); err != nil {
+		return "", err
+	}
+	if err := g.validateModelAnnotations(file); err != nil {
+		return "", err
+	}
+	if err := g.validateTimestamps(file); err != nil {
+		return "", err
+	}
+	if err := g.validateEnums(file); err != nil {
+		return "", err
+	}
+	if err := g.validatePreloads(file); err != nil {
+		return "", err
+	}
+	if err := g.validatePolicies(file); err != nil {
+		return "", err
+	}
+	if err := g.validateSettings(file); err != nil {
+		return "", err
+	}
+	if err := g.validateNotifications(file); err != nil {
+		return "", err
+	}
+	if err := g.validateFeedItems(file); err != nil {
+		return "", err
+	}
+	if err := g.validateTypeahead(file); err != nil {
+		return "", err
+	}
+	if err := g.validateLive(file); err != nil {
+		return "", err
+	}
+	if err := g.validateModals(file); err != nil {
+		return "", err
+	}
+	if err := g.validateWizards(file); err != nil {
+		return "", err
+	}
+	if err := g.validateAutosave(file); err != nil {
+		return "", err
+	}
+	if err := g.validateJobs(file); err != nil {
+		return "", err
+	}
+	if err := g.validateSessionService(file); err != nil {
+		return "", err
+	}
+	if err := g.validateTwoFactor(file); err != nil {
+		return "", err
+	}
+	if err := g.validateLoginLockout(file); err != nil {
+		return "", err
+	}
+	if err := g.validateAccounts(file); err != nil {
+		return "", err
+	}
+	if err := g.validatePersonalData(file); err != nil {
+		return "", err
+	}
+	if err := g.validateConsent(file); err != nil {
+		return "", err
+	}
+	if err := g.validateMetrics(file); err != nil {
+		return "", err
+	}
+	if err := g.validateBackupService(file); err != nil {
+		return "", err
+	}
+	if err := g.validateStorageServices(file); err != nil {
+		return "", err
+	}
+	if err := g.validateCacheServices(file); err != nil {
+		return "", err
+	}
+	if err := g.validateLoadShedService(file); err != nil {
+		return "", err
+	}
+	if err := g.validateTracingService(file); err != nil {
+		return "", err
+	}
+	if err := g.validateQueryLog(file); err != nil {
+		return "", err
+	}
+	if err := g.validateRowLevelSecurity(file); err != nil {
+		return "", err
+	}
+	if err := g.validatePIIFields(file); err != nil {
+		return "", err
+	}
+	if err := g.validateMoneyFields(file); err != nil {
+		return "", err
+	}
+	if err := g.validateListFields(file); err != nil {
+		return "", err
+	}
+	if err := g.validateBytesFields(file); err != nil {
+		return "", err
+	}
+	if err := g.validateImageFields(file); err != nil {
+		return "", err
+	}
+	if err := g.validateIndexes(file); err != nil {
+		return "", err
+	}
+	if err := g.validateMigrations(file); err != nil {
+		return "", err
+	}
+	if err := g.validatePages(file); err != nil {
+		return "", err
+	}
+	if g.opts.Strict {
+		if err := g.validateStrict(file); err != nil {
+			return "", err
+		}
+	}
+
+	file = g.withGeneratedModels(file)
+
 	// Compute routes ONCE at the beginning
 	var routes map[string]string
 	if file.Template != nil {
@@ -41,9 +257,20 @@ func (g *Generator) generateWithComponents(file *ast.GMXFile, components map[str
 	} else {
 		routes = make(map[string]string)
 	}
+	g.applyRouteGroups(file, routes)
+
+	// Multi-file builds serve their styles as one bundled stylesheet,
+	// optionally inlining the rules of the initial render
+	styles := styleSheets{bundle: g.bundleStyles(file, components)}
+	if g.opts.CriticalCSS && styles.bundle != "" {
+		styles = g.splitCriticalCSS(file, components, fragments, styles.bundle)
+	}
+	if g.opts.Minify {
+		styles.bundle = minifyCSS(styles.bundle)
+	}
 
 	// Package declaration
-	b.WriteString("package main\n\n")
+	b.WriteString("package " + g.packageName() + "\n\n")
 
 	// Imports
 	b.WriteString(g.genImports(file))
@@ -67,6 +294,14 @@ func (g *Generator) generateWithComponents(file *ast.GMXFile, components map[str
 		b.WriteString("// ========== Models ==========\n\n")
 		b.WriteString(g.genModels(file.Models))
 		b.WriteString("\n")
+
+		b.WriteString("// ========== Factories ==========\n\n")
+		b.WriteString(g.genFactories(file.Models))
+
+		if repos := g.genRepositories(file.Models); repos != "" {
+			b.WriteString("// ========== Repositories ==========\n\n")
+			b.WriteString(repos)
+		}
 	}
 
 	// Services (if any)
@@ -79,8 +314,8 @@ func (g *Generator) generateWithComponents(file *ast.GMXFile, components map[str
 	// Script (transpiled functions)
 	if file.Script != nil && file.Script.Funcs != nil {
 		b.WriteString("// ========== Script (Transpiled) ==========\n\n")
-		modelNames := g.extractModelNames(file.Models)
-		result := script.Transpile(file.Script, modelNames)
+		b.WriteString(g.genScriptServiceVars(file))
+		result := script.TranspileModels(file.Script, file.Models, g.cacheServiceNames(file), g.scriptServices(file))
 		if len(result.Errors) > 0 {
 			return "", fmt.Errorf("transpile errors: %v", result.Errors)
 		}
@@ -89,21 +324,32 @@ func (g *Generator) generateWithComponents(file *ast.GMXFile, components map[str
 
 		// Generate HTTP handler wrappers
 		b.WriteString("// ========== Script Handler Wrappers ==========\n\n")
-		b.WriteString(g.genScriptHandlers(file.Script))
+		b.WriteString(g.genScriptHandlers(file))
 		b.WriteString("\n")
+		if g.hasScriptHandlers(file) {
+			b.WriteString(g.genRenderValidationError())
+		}
+
+		if g.findSessionService(file.Services) != nil {
+			b.WriteString(g.genSessionContextMethods(g.hasServerSessions(file), g.hasTwoFactor(file), g.hasAccounts(file)))
+		}
 	}
 
 	// Template setup
 	if file.Template != nil {
 		b.WriteString("// ========== Template ==========\n\n")
-		b.WriteString(g.genTemplateInit(routes))
+		b.WriteString(g.genTemplateInit(file, routes))
 		b.WriteString("\n")
-		b.WriteString(g.genTemplateConst(file, components))
+		if g.hasItemIsolation(file) {
+			b.WriteString(g.genRenderItem())
+			b.WriteString("\n")
+		}
+		b.WriteString(g.genTemplateConst(file, components, fragments, styles))
 		b.WriteString("\n")
 	}
 
-	// Page Data struct
-	if len(file.Models) > 0 {
+	// Page Data struct (the index handler always renders one)
+	if len(file.Models) > 0 || file.Template != nil {
 		b.WriteString("// ========== Page Data ==========\n\n")
 		b.WriteString(g.genPageData(file.Models))
 		b.WriteString("\n")
@@ -115,6 +361,151 @@ func (g *Generator) generateWithComponents(file *ast.GMXFile, components map[str
 		b.WriteString("var db *gorm.DB\n\n")
 	}
 
+	// Runtime-tunable settings, cached in memory
+	if len(file.Settings) > 0 {
+		b.WriteString("// ========== Settings ==========\n\n")
+		b.WriteString(g.genSettings(file))
+	}
+
+	// In-app notifications and their endpoints
+	if g.hasNotifications(file) {
+		b.WriteString("// ========== Notifications ==========\n\n")
+		b.WriteString(g.genNotifications(file))
+	}
+
+	// Activity feed recorded by the @feedItem hooks
+	if g.hasActivityFeed(file) {
+		b.WriteString("// ========== Activity Feed ==========\n\n")
+		b.WriteString(g.genActivityFeed(g.findSessionService(file.Services) != nil))
+	}
+
+	// Search-as-you-type inputs of the @typeahead models
+	if g.hasTypeahead(file) {
+		b.WriteString("// ========== Typeahead ==========\n\n")
+		b.WriteString(g.genTypeahead(file))
+	}
+
+	// Server-sent changes of the @live models
+	if g.hasLive(file) {
+		b.WriteString("// ========== Live Updates ==========\n\n")
+		b.WriteString(g.genLive(file))
+	}
+
+	// Confirmation dialogs of the {{modal}} triggers
+	if g.hasModal(file) {
+		b.WriteString("// ========== Modals ==========\n\n")
+		b.WriteString(g.genModals(file))
+	}
+
+	// Multi-step forms of the wizard declarations
+	if g.hasWizards(file) {
+		b.WriteString("// ========== Wizards ==========\n\n")
+		b.WriteString(g.genWizards(file))
+	}
+
+	// Drafts of the @autosave forms
+	if g.hasAutosave(file) {
+		b.WriteString("// ========== Autosave ==========\n\n")
+		b.WriteString(g.genAutosave(file))
+	}
+
+	// Background jobs of the @async functions
+	if g.hasJobs(file) {
+		b.WriteString("// ========== Jobs ==========\n\n")
+		b.WriteString(g.genJobs(file))
+	}
+
+	// Worker pool of the @job functions
+	if g.hasQueuedJobs(file) {
+		b.WriteString("// ========== Job Queue ==========\n\n")
+		b.WriteString(g.genJobQueue(file))
+	}
+
+	// Outbox of the published events and its relay
+	if g.hasOutbox(file) {
+		b.WriteString("// ========== Outbox ==========\n\n")
+		b.WriteString(g.genOutbox(file))
+	}
+
+	// Scheduler of the @schedule functions
+	if g.hasSchedules(file) {
+		b.WriteString("// ========== Schedules ==========\n\n")
+		b.WriteString(g.genSchedules(file))
+	}
+
+	// Query log of the Database service
+	if g.hasQueryLog(file) {
+		b.WriteString("// ========== Query Log ==========\n\n")
+		b.WriteString(g.genQueryLog(file))
+	}
+
+	// Versioned SQL migrations replacing AutoMigrate
+	if g.hasMigrations(file) {
+		b.WriteString("// ========== Migrations ==========\n\n")
+		b.WriteString(g.genMigrations(file))
+	}
+
+	// Resized variants of the image fields
+	if g.hasImages(file) {
+		b.WriteString("// ========== Images ==========\n\n")
+		b.WriteString(g.genImages(file))
+	}
+
+	// Non-production anonymization task
+	if g.hasPIIFields(file) {
+		b.WriteString("// ========== Anonymization ==========\n\n")
+		b.WriteString(g.genAnonymizer(file.Models))
+		b.WriteString("\n")
+	}
+
+	// Primary/standby database switchover
+	if g.hasDatabaseStandby(file) {
+		b.WriteString("// ========== Database Failover ==========\n\n")
+		b.WriteString(g.genDatabaseFailover(file))
+	}
+
+	// PostgreSQL row-level security per tenant
+	if g.hasRowLevelSecurity(file) {
+		b.WriteString("// ========== Row-Level Security ==========\n\n")
+		b.WriteString(g.genRowLevelSecurity(file))
+	}
+
+	// Dev builds catch outgoing mail instead of sending it
+	if g.hasDevMail(file) {
+		b.WriteString("// ========== Dev Mail ==========\n\n")
+		b.WriteString(g.genDevMailbox())
+	}
+
+	// Dev builds serve profiles to the local machine
+	if g.opts.Dev {
+		b.WriteString("// ========== Profiling ==========\n\n")
+		b.WriteString(g.genProfiling())
+	}
+
+	// Dev builds inject faults into HTTP service requests
+	if g.hasChaos(file) {
+		b.WriteString("// ========== Chaos ==========\n\n")
+		b.WriteString(g.genChaos(file.Services))
+	}
+
+	// Dev builds record requests for gmx replay with --record
+	if g.opts.Dev {
+		b.WriteString("// ========== Request Recorder ==========\n\n")
+		b.WriteString(g.genRecorder(file))
+	}
+
+	// Dev builds check templates, routes and services on startup
+	if g.opts.Dev {
+		b.WriteString("// ========== Self-Check ==========\n\n")
+		b.WriteString(g.genSelfCheck(file, routes, styles.bundle != ""))
+	}
+
+	// Bundled stylesheet
+	if styles.bundle != "" {
+		b.WriteString("// ========== Assets ==========\n\n")
+		b.WriteString(g.genStyleBundle(styles.bundle))
+	}
+
 	// Handlers
 	if file.Template != nil {
 		b.WriteString("// ========== Handlers ==========\n\n")
@@ -122,9 +513,74 @@ func (g *Generator) generateWithComponents(file *ast.GMXFile, components map[str
 		b.WriteString("\n")
 	}
 
+	// Built-in impersonation flow
+	if g.hasImpersonation(file) {
+		b.WriteString("// ========== Impersonation ==========\n\n")
+		b.WriteString(g.genImpersonationHandlers(g.hasServerSessions(file), g.hasTwoFactor(file)))
+	}
+
+	// Failed sign-ins and wrong second factors lock their account
+	if g.hasFailureLimiter(file) {
+		b.WriteString("// ========== Lockout ==========\n\n")
+		b.WriteString(g.genFailureLimiter())
+		if g.hasLoginLockout(file) {
+			b.WriteString(g.genLoginLockout(file))
+		}
+	}
+
+	// Built-in two-factor authentication flow
+	if g.hasTwoFactor(file) {
+		b.WriteString("// ========== Two-Factor Authentication ==========\n\n")
+		b.WriteString(g.genTwoFactor(file))
+	}
+
+	// Built-in email change and account deletion flows
+	if g.hasAccounts(file) {
+		b.WriteString("// ========== Accounts ==========\n\n")
+		b.WriteString(g.genAccounts(file))
+	}
+
+	// Built-in export of the personal data of the session user
+	if g.hasPersonalData(file) {
+		b.WriteString("// ========== Personal Data Export ==========\n\n")
+		b.WriteString(g.genPersonalDataExport(file))
+	}
+
+	// Built-in consent to the current version of the terms
+	if g.hasConsent(file) {
+		b.WriteString("// ========== Consent ==========\n\n")
+		b.WriteString(g.genConsent(file))
+	}
+
+	// HTTP server options of the server block
+	if file.Server != nil {
+		b.WriteString("// ========== Server ==========\n\n")
+		b.WriteString(g.genServer(file))
+	}
+
+	// Structured logs, and the request IDs and logs of the middleware
+	b.WriteString("// ========== Logging ==========\n\n")
+	b.WriteString(g.genLogging())
+
+	// Prometheus metrics of the routes and queries
+	if g.hasMetrics(file) {
+		b.WriteString("// ========== Metrics ==========\n\n")
+		b.WriteString(g.genMetrics(file))
+	}
+
+	// OpenTelemetry spans of the routes, queries and HTTP service calls
+	if g.hasTracing(file) {
+		b.WriteString("// ========== Tracing ==========\n\n")
+		b.WriteString(g.genTracing())
+	}
+
+	// Graceful shutdown on SIGINT and SIGTERM
+	b.WriteString("// ========== Shutdown ==========\n\n")
+	b.WriteString(g.genGracefulShutdown(file))
+
 	// Main function
 	b.WriteString("// ========== Main ==========\n\n")
-	b.WriteString(g.genMain(file, routes))
+	b.WriteString(g.genMain(file, routes, styles.bundle != ""))
 
 	// Format the generated code
 	formatted, err := format.Source([]byte(b.String()))
@@ -133,5 +589,79 @@ func (g *Generator) generateWithComponents(file *ast.GMXFile, components map[str
 		return b.String(), fmt.Errorf("format error: %w", err)
 	}
 
+	// Template errors would otherwise only surface in init() at startup
+	if err := g.validateTemplates(file, components, string(formatted)); err != nil {
+		return "", err
+	}
+
+	// The source map of the query log follows the lines it maps
+	if g.hasQueryLog(file) {
+		return string(formatted) + g.genQuerySourceLines(file, string(formatted)), nil
+	}
 	return string(formatted), nil
 }
+
+// validateFuncAnnotations checks the arguments of script function annotations
+func (g *Generator) validateFuncAnnotations(file *ast.GMXFile) error {
+	for _, fn := range g.funcsWithAnnotation(file, "honeypot") {
+		if _, err := honeypotDelay(fn); err != nil {
+			return fmt.Errorf("function %s: %w", fn.Name, err)
+		}
+		if g.findSessionService(file.Services) == nil {
+			return fmt.Errorf("function %s: @honeypot requires a service with provider \"session\", whose secret signs the form timestamps", fn.Name)
+		}
+	}
+	for _, fn := range g.funcsWithAnnotation(file, "captcha") {
+		provider := captchaProviderName(fn)
+		if _, ok := captchaProviders[provider]; !ok {
+			return fmt.Errorf("function %s: unsupported captcha provider %q (expected turnstile or hcaptcha)", fn.Name, provider)
+		}
+	}
+	for _, fn := range g.funcsWithAnnotation(file, "timeout") {
+		if _, err := handlerTimeout(fn); err != nil {
+			return fmt.Errorf("function %s: %w", fn.Name, err)
+		}
+		if fn.ReturnType != "" && fn.ReturnType != "error" {
+			return fmt.Errorf("function %s: @timeout applies to handlers, functions returning error", fn.Name)
+		}
+	}
+	for _, fn := range g.funcsWithAnnotation(file, "negotiate") {
+		if len(fn.FindAnnotation("negotiate").Args) > 0 {
+			return fmt.Errorf("function %s: @negotiate takes no arguments", fn.Name)
+		}
+	}
+	for _, fn := range g.funcsWithAnnotation(file, "json") {
+		if len(fn.FindAnnotation("json").Args) > 0 {
+			return fmt.Errorf("function %s: @json takes no arguments", fn.Name)
+		}
+		if fn.ReturnType != "" && fn.ReturnType != "error" {
+			return fmt.Errorf("function %s: @json applies to handlers, functions returning error", fn.Name)
+		}
+		if fn.FindAnnotation("negotiate") != nil {
+			return fmt.Errorf("function %s: @json always answers JSON; @negotiate is redundant", fn.Name)
+		}
+	}
+	for _, fn := range g.funcsWithAnnotation(file, "once") {
+		if len(fn.FindAnnotation("once").Args) > 0 {
+			return fmt.Errorf("function %s: @once takes no arguments", fn.Name)
+		}
+		if fn.ReturnType != "" && fn.ReturnType != "error" {
+			return fmt.Errorf("function %s: @once applies to handlers, functions returning error", fn.Name)
+		}
+	}
+	// ctx.User comes from the session cookie; the admin role from its admins list
+	for _, fn := range g.funcsWithAnnotation(file, "auth") {
+		if g.findSessionService(file.Services) == nil {
+			return fmt.Errorf("function %s: @auth requires a service with provider \"session\"", fn.Name)
+		}
+	}
+	for _, fn := range g.funcsWithAnnotation(file, "role") {
+		if role := fn.FindAnnotation("role").SimpleArg(); role != "admin" {
+			return fmt.Errorf("function %s: unsupported role %q (expected admin)", fn.Name, role)
+		}
+		if !g.hasImpersonation(file) {
+			return fmt.Errorf("function %s: @role(admin) requires a session service with an `admins` field", fn.Name)
+		}
+	}
+	return nil
+}
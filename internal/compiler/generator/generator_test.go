@@ -4,10 +4,16 @@ import (
 	"fmt"
 	"go/parser"
 	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/lang"
+	"github.com/btouchard/gmx/internal/compiler/resolver"
+	"github.com/btouchard/gmx/internal/compiler/script"
 	"github.com/btouchard/gmx/internal/compiler/utils"
 )
 
@@ -363,6 +369,55 @@ func TestRelationGeneratesFK(t *testing.T) {
 	}
 }
 
+func TestGenerateTimestamps(t *testing.T) {
+	parsed, errs := script.Parse("@timestamps\nmodel Task {\n  id: uuid @pk @default(uuid_v4)\n  title: string\n}\n"+
+		"func rename(id: uuid, title: string) error {\n  let task = try Task.find(id)\n  task.title = title\n  try task.save()\n  return render(task.updatedAt)\n}", 0)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	code, err := New().Generate(&ast.GMXFile{Models: parsed.Models, Script: &ast.ScriptBlock{Funcs: parsed.Funcs}})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, want := range []string{
+		"CreatedAt time.Time `gorm:\"autoCreateTime\" json:\"createdAt\"`",
+		"UpdatedAt time.Time `gorm:\"autoUpdateTime\" json:\"updatedAt\"`",
+		"task.UpdatedAt); err != nil",
+	} {
+		if !strings.Contains(strings.Join(strings.Fields(code), " "), want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+}
+
+func TestValidateTimestamps(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{"arguments", "@timestamps(utc)\nmodel Task {\n  id: uuid @pk\n}", "@timestamps takes no arguments"},
+		{"declared field", "@timestamps\nmodel Task {\n  id: uuid @pk\n  createdAt: datetime\n}", "field createdAt is added by @timestamps; remove it"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, errs := script.Parse(tt.src, 0)
+			if len(errs) > 0 {
+				t.Fatalf("parse errors: %v", errs)
+			}
+			_, err := New().Generate(&ast.GMXFile{Models: parsed.Models})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
 func TestEscapeTemplateString(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -400,6 +455,7 @@ func TestMapType(t *testing.T) {
 		{"float", "float64"},
 		{"bool", "bool"},
 		{"datetime", "time.Time"},
+		{"password", "string"},
 		{"User", "User"},
 		{"Post[]", "[]Post"},
 	}
@@ -1086,7 +1142,7 @@ func TestSecureErrorHandling(t *testing.T) {
 	}
 
 	// Should log errors instead of exposing them
-	if !strings.Contains(code, "log.Printf(\"handler error: %v\", err)") {
+	if !strings.Contains(code, "requestLogger(r).Error(\"handler error\", \"handler\", \"createTask\", \"error\", err)") {
 		t.Error("Handler should log errors server-side")
 	}
 
@@ -1096,7 +1152,7 @@ func TestSecureErrorHandling(t *testing.T) {
 	}
 
 	// Template errors should also be logged
-	if !strings.Contains(code, "log.Printf(\"template error: %v\", err)") {
+	if !strings.Contains(code, `requestLogger(r).Error("template error", "error", err)`) {
 		t.Error("Template handler should log errors")
 	}
 
@@ -1158,6 +1214,25 @@ func TestParameterValidation(t *testing.T) {
 	}
 }
 
+// scriptTestFile parses the declarations and functions of a gmx 1.1 script,
+// served with the given page template
+func scriptTestFile(t *testing.T, src, tmpl string) *ast.GMXFile {
+	t.Helper()
+	parsed, errs := script.ParseVersion(src, 0, lang.Version{Major: 1, Minor: 1})
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	return &ast.GMXFile{
+		Models:   parsed.Models,
+		Services: parsed.Services,
+		Settings: parsed.Settings,
+		Wizards:  parsed.Wizards,
+		Server:   parsed.Server,
+		Script:   &ast.ScriptBlock{Funcs: parsed.Funcs, Models: parsed.Models},
+		Template: &ast.TemplateBlock{Source: tmpl},
+	}
+}
+
 // Helper function to validate Go syntax
 func isValidGo(code string) bool {
 	fset := token.NewFileSet()
@@ -1270,6 +1345,138 @@ func TestGenServiceInit(t *testing.T) {
 	}
 }
 
+func TestGenServiceMethodBody(t *testing.T) {
+	body := []ast.Statement{
+		&ast.ReturnStmt{
+			Value: &ast.StringLit{Parts: []ast.StringPart{
+				{IsExpr: true, Expr: &ast.MemberExpr{Object: &ast.Ident{Name: "config"}, Property: "prefix"}},
+				{Text: " "},
+				{IsExpr: true, Expr: &ast.Ident{Name: "msg"}},
+			}},
+			Line: 1,
+		},
+	}
+
+	tests := []struct {
+		provider string
+		expected string
+	}{
+		{provider: "webhook", expected: "func (s *notifierStub) Format(msg string) string {"},
+		{provider: "smtp", expected: "func (m *notifierImpl) Format(msg string) string {"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			file := &ast.GMXFile{
+				Services: []*ast.ServiceDecl{
+					{
+						Name:     "Notifier",
+						Provider: tt.provider,
+						Fields:   []*ast.ServiceField{{Name: "prefix", Type: "string", EnvVar: "NOTIFY_PREFIX"}},
+						Methods: []*ast.ServiceMethod{
+							{Name: "format", Params: []*ast.Param{{Name: "msg", Type: "string"}}, ReturnType: "string", Body: body},
+						},
+					},
+				},
+			}
+
+			code, err := New().Generate(file)
+			if err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+			if !isValidGo(code) {
+				t.Errorf("Generated code is not valid Go:\n%s", code)
+			}
+			for _, exp := range []string{tt.expected, ".config.Prefix, msg)"} {
+				if !strings.Contains(code, exp) {
+					t.Errorf("Generated code missing %q", exp)
+				}
+			}
+			if strings.Contains(code, "Notifier.Format called (stub)") {
+				t.Error("Method with a body should not be stubbed")
+			}
+		})
+	}
+}
+
+func TestGenServiceCustomProvider(t *testing.T) {
+	file := &ast.GMXFile{
+		Services: []*ast.ServiceDecl{
+			{
+				Name:     "Payments",
+				Provider: "custom",
+				Methods: []*ast.ServiceMethod{
+					{Name: "charge", Params: []*ast.Param{{Name: "amount", Type: "int"}}, ReturnType: "error"},
+				},
+			},
+		},
+	}
+
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	expected := []string{
+		"type PaymentsService interface",
+		"func RegisterPayments(impl PaymentsService) {",
+		"func newPaymentsService(cfg *PaymentsConfig) PaymentsService {",
+		"no implementation registered (call RegisterPayments from an init function)",
+		"paymentsSvc := newPaymentsService(paymentsCfg)",
+	}
+	for _, exp := range expected {
+		if !strings.Contains(code, exp) {
+			t.Errorf("Generated code missing %q", exp)
+		}
+	}
+	if strings.Contains(code, "paymentsStub") {
+		t.Error("Custom provider should not generate a stub")
+	}
+}
+
+func TestGenerateScriptServiceCalls(t *testing.T) {
+	src := `service Mailer {
+  provider: "smtp"
+  host: string @env("SMTP_HOST")
+  pass: string @env("SMTP_PASS")
+  func send(to: string, subject: string, body: string) error
+}
+func notify(to: string) error {
+  try Mailer.send(to, "Hello", "Welcome")
+  return nil
+}`
+	code, err := New().Generate(scriptTestFile(t, src, "<p>{{.CSRFToken}}</p>"))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	for _, exp := range []string{
+		"var mailerService MailerService",
+		"\tmailerService = mailerSvc\n",
+		`if err := mailerService.Send(to, "Hello", "Welcome"); err != nil {`,
+	} {
+		if !strings.Contains(code, exp) {
+			t.Errorf("Generated code missing %q", exp)
+		}
+	}
+
+	// Without script code, nothing reaches the service
+	file := scriptTestFile(t, strings.Split(src, "func notify")[0], "<p>{{.CSRFToken}}</p>")
+	code, err = New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if strings.Contains(code, "mailerService") {
+		t.Error("services are handed to the script only when it has functions")
+	}
+}
+
 func TestGenServiceInterface(t *testing.T) {
 	file := &ast.GMXFile{
 		Services: []*ast.ServiceDecl{
@@ -1317,7 +1524,7 @@ func TestGenServiceStub(t *testing.T) {
 		Services: []*ast.ServiceDecl{
 			{
 				Name:     "Storage",
-				Provider: "s3",
+				Provider: "gcs",
 				Methods: []*ast.ServiceMethod{
 					{
 						Name: "upload",
@@ -1353,7 +1560,7 @@ func TestGenServiceStub(t *testing.T) {
 	}
 
 	// Should log method call
-	if !strings.Contains(code, `log.Printf("[%s] Storage.Upload called (stub)"`) {
+	if !strings.Contains(code, `slog.Info("Storage.Upload called (stub)", "provider", s.config.Provider)`) {
 		t.Error("Generated code missing log statement in stub")
 	}
 
@@ -1375,9 +1582,9 @@ func TestGenServiceImportOs(t *testing.T) {
 				Provider: "redis",
 				Fields: []*ast.ServiceField{
 					{
-						Name:   "host",
+						Name:   "url",
 						Type:   "string",
-						EnvVar: "REDIS_HOST",
+						EnvVar: "REDIS_URL",
 					},
 				},
 			},
@@ -1395,7 +1602,7 @@ func TestGenServiceImportOs(t *testing.T) {
 		t.Error("Generated code should import os when services use @env")
 	}
 
-	// File without env should NOT import os
+	// File without env still imports os, as logs are written to stderr
 	fileWithoutEnv := &ast.GMXFile{
 		Services: []*ast.ServiceDecl{
 			{
@@ -1410,9 +1617,8 @@ func TestGenServiceImportOs(t *testing.T) {
 		t.Fatalf("Generate failed: %v", err)
 	}
 
-	// Should NOT import os when no @env
-	if strings.Contains(code2, `"os"`) {
-		t.Error("Generated code should not import os when services don't use @env")
+	if !strings.Contains(code2, `"os"`) || !strings.Contains(code2, "slog.NewJSONHandler(os.Stderr, nil)") {
+		t.Error("Generated code should import os for the logs written to stderr")
 	}
 }
 
@@ -2309,9 +2515,9 @@ func TestGenerateDefaultImportPlaceholder(t *testing.T) {
 		t.Errorf("Generated code is not valid Go:\n%s", code)
 	}
 
-	// Should contain TODO placeholder comment
-	if !strings.Contains(code, "// TODO: Component import: TaskItem from ./components/TaskItem.gmx") {
-		t.Error("Generated code missing TODO placeholder for default import")
+	// Should contain the import comment
+	if !strings.Contains(code, "// Component import: TaskItem from ./components/TaskItem.gmx") {
+		t.Error("Generated code missing comment for default import")
 	}
 }
 
@@ -2336,9 +2542,9 @@ func TestGenerateDestructuredImportPlaceholder(t *testing.T) {
 		t.Errorf("Generated code is not valid Go:\n%s", code)
 	}
 
-	// Should contain TODO placeholder comment
-	if !strings.Contains(code, "// TODO: Destructured import: sendEmail, MailerConfig from ./services/mailer.gmx") {
-		t.Error("Generated code missing TODO placeholder for destructured import")
+	// Should contain the import comment
+	if !strings.Contains(code, "// Destructured import: sendEmail, MailerConfig from ./services/mailer.gmx") {
+		t.Error("Generated code missing comment for destructured import")
 	}
 }
 
@@ -2387,11 +2593,11 @@ func TestGenerateMixedImports(t *testing.T) {
 	}
 
 	// Should contain all import comments
-	if !strings.Contains(code, "// TODO: Component import: TaskItem from ./components/TaskItem.gmx") {
-		t.Error("Generated code missing default import placeholder")
+	if !strings.Contains(code, "// Component import: TaskItem from ./components/TaskItem.gmx") {
+		t.Error("Generated code missing default import comment")
 	}
-	if !strings.Contains(code, "// TODO: Destructured import: sendEmail from ./services/mailer.gmx") {
-		t.Error("Generated code missing destructured import placeholder")
+	if !strings.Contains(code, "// Destructured import: sendEmail from ./services/mailer.gmx") {
+		t.Error("Generated code missing destructured import comment")
 	}
 	if !strings.Contains(code, "// Native Go import: github.com/stripe/stripe-go as Stripe") {
 		t.Error("Generated code missing native import comment")
@@ -2407,3 +2613,779 @@ func TestGenerateMixedImports(t *testing.T) {
 		t.Error("Generated code missing Task model")
 	}
 }
+
+func TestGeneratePasswordField(t *testing.T) {
+	file := &ast.GMXFile{
+		Models: []*ast.ModelDecl{
+			{
+				Name: "User",
+				Fields: []*ast.FieldDecl{
+					{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{{Name: "pk", Args: map[string]string{}}}},
+					{Name: "email", Type: "string", Annotations: []*ast.Annotation{{Name: "email", Args: map[string]string{}}}},
+					{Name: "password", Type: "password", Annotations: []*ast.Annotation{{Name: "min", Args: map[string]string{"_": "8"}}}},
+				},
+			},
+		},
+		Template: &ast.TemplateBlock{Source: `<input type="{{inputType "User" "password"}}" name="password">`},
+	}
+
+	gen := New()
+	code, err := gen.Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	expected := []string{
+		"Password string `json:\"-\"`",
+		"func (u *User) BeforeSave(tx *gorm.DB) error",
+		"if u.Password != \"\" && u.Password != u.storedPassword {",
+		"\tu.storedPassword = u.Password\n",
+		"func (u *User) AfterFind(tx *gorm.DB) error {",
+		"bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)",
+		"if len(u.Password) < 8",
+		"func checkPassword(hash, plain string) bool",
+		"func secureCompare(a, b string) bool",
+		"subtle.ConstantTimeCompare",
+		"\"golang.org/x/crypto/bcrypt\"",
+		"\"crypto/subtle\"",
+		"\"inputType\": func(model, field string) string",
+		"\"User.password\": \"password\"",
+		"\"User.email\":    \"email\"",
+	}
+	for _, exp := range expected {
+		if !strings.Contains(code, exp) {
+			t.Errorf("Generated code missing %q", exp)
+		}
+	}
+}
+
+func TestGeneratePasswordValidation(t *testing.T) {
+	file := &ast.GMXFile{
+		Models: []*ast.ModelDecl{
+			{
+				Name: "User",
+				Fields: []*ast.FieldDecl{
+					{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{{Name: "pk", Args: map[string]string{}}}},
+					{Name: "password", Type: "password", Annotations: []*ast.Annotation{
+						{Name: "min", Args: map[string]string{"_": "8"}},
+						{Name: "max", Args: map[string]string{"_": "32"}},
+					}},
+				},
+			},
+		},
+	}
+
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Fatalf("Generated code is not valid Go:\n%s", code)
+	}
+
+	expected := []string{
+		// Bounds apply to the plaintext: a loaded record holds the hash
+		"\tif u.Password != u.storedPassword {\n\t\tif len(u.Password) > 72 {\n",
+		"\t\tif len(u.Password) < 8 {\n",
+		"\t\tif len(u.Password) > 32 {\n",
+		// bcrypt is never handed a longer password
+		"\t\t\treturn fmt.Errorf(\"hashing Password: the password is longer than 72 bytes\")\n",
+	}
+	for _, exp := range expected {
+		if !strings.Contains(code, exp) {
+			t.Errorf("Generated code missing %q", exp)
+		}
+	}
+}
+
+func TestGenerateNoPasswordHelpersWithoutPasswordField(t *testing.T) {
+	file := &ast.GMXFile{
+		Models: []*ast.ModelDecl{
+			{
+				Name:   "Task",
+				Fields: []*ast.FieldDecl{{Name: "title", Type: "string"}},
+			},
+		},
+	}
+
+	gen := New()
+	code, err := gen.Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	for _, unexpected := range []string{"bcrypt", "crypto/subtle", "BeforeSave"} {
+		if strings.Contains(code, unexpected) {
+			t.Errorf("Generated code should not contain %q without password fields", unexpected)
+		}
+	}
+}
+
+func TestGenerateCaptchaHandler(t *testing.T) {
+	file := &ast.GMXFile{
+		Script: &ast.ScriptBlock{
+			Funcs: []*ast.FuncDecl{
+				{
+					Name:        "createContact",
+					Params:      []*ast.Param{{Name: "email", Type: "string"}},
+					Body:        []ast.Statement{},
+					Annotations: []*ast.Annotation{{Name: "captcha", Args: map[string]string{"_": "turnstile"}}},
+				},
+				{
+					Name:   "listContacts",
+					Params: []*ast.Param{},
+					Body:   []ast.Statement{},
+				},
+			},
+		},
+	}
+
+	gen := New()
+	code, err := gen.Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	expected := []string{
+		`if err := verifyCaptcha(r, "turnstile"); err != nil {`,
+		`http.Error(w, "Captcha verification failed", http.StatusForbidden)`,
+		"func verifyCaptcha(r *http.Request, provider string) error",
+		`"https://challenges.cloudflare.com/turnstile/v0/siteverify"`,
+		`"cf-turnstile-response"`,
+		`"TURNSTILE_SECRET_KEY"`,
+		`"encoding/json"`,
+		`"net/url"`,
+		`"os"`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(code, exp) {
+			t.Errorf("Generated code missing %q", exp)
+		}
+	}
+
+	if strings.Contains(code, "hcaptcha") {
+		t.Error("Generated code should only include the providers in use")
+	}
+	if strings.Count(code, "verifyCaptcha(r,") != 1 {
+		t.Error("Only the annotated function should verify the captcha")
+	}
+}
+
+func TestGenerateCaptchaUnknownProvider(t *testing.T) {
+	file := &ast.GMXFile{
+		Script: &ast.ScriptBlock{
+			Funcs: []*ast.FuncDecl{
+				{
+					Name:        "signup",
+					Body:        []ast.Statement{},
+					Annotations: []*ast.Annotation{{Name: "captcha", Args: map[string]string{"_": "recaptcha"}}},
+				},
+			},
+		},
+	}
+
+	gen := New()
+	_, err := gen.Generate(file)
+	if err == nil {
+		t.Fatal("expected error for unsupported captcha provider")
+	}
+	if !strings.Contains(err.Error(), "unsupported captcha provider") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// honeypotSession is the session service whose secret signs the honeypot timestamps
+var honeypotSession = &ast.ServiceDecl{
+	Name:     "Auth",
+	Provider: "session",
+	Fields:   []*ast.ServiceField{{Name: "secret", Type: "string", EnvVar: "SESSION_SECRET"}},
+}
+
+func TestGenerateHoneypot(t *testing.T) {
+	file := &ast.GMXFile{
+		Services: []*ast.ServiceDecl{honeypotSession},
+		Script: &ast.ScriptBlock{
+			Funcs: []*ast.FuncDecl{
+				{
+					Name:        "subscribe",
+					Params:      []*ast.Param{{Name: "email", Type: "string"}},
+					Body:        []ast.Statement{},
+					Annotations: []*ast.Annotation{{Name: "honeypot", Args: map[string]string{"min": "5"}}},
+				},
+			},
+		},
+		Template: &ast.TemplateBlock{Source: `<form hx-post="{{route "subscribe"}}"><input name="email"></form>`},
+	}
+
+	gen := New()
+	code, err := gen.Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	expected := []string{
+		"if err := checkHoneypot(r, 5*time.Second); err != nil {",
+		"func checkHoneypot(r *http.Request, minDelay time.Duration) error",
+		"func honeypotField() template.HTML",
+		`"honeypot": honeypotField,`,
+		`<form hx-post="{{route "subscribe"}}">{{honeypot}}<input name="email">`,
+		// Timestamps outlive restarts and instances, not a day
+		"\tmac := hmac.New(sha256.New, sessionSecret)\n\tmac.Write([]byte(\"honeypot\\n\" + ts))\n",
+		"\tif elapsed > honeypotMaxAge {\n",
+		`"crypto/hmac"`,
+		`"crypto/sha256"`,
+		`"encoding/hex"`,
+		`"strconv"`,
+		`"time"`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(code, exp) {
+			t.Errorf("Generated code missing %q", exp)
+		}
+	}
+}
+
+func TestInjectHoneypotFields(t *testing.T) {
+	funcs := []*ast.FuncDecl{{Name: "subscribe"}}
+
+	tests := []struct {
+		name     string
+		src      string
+		expected string
+	}{
+		{
+			name:     "double-quoted route",
+			src:      `<form hx-post="{{route "subscribe"}}" hx-swap="none"></form>`,
+			expected: `<form hx-post="{{route "subscribe"}}" hx-swap="none">{{honeypot}}</form>`,
+		},
+		{
+			name:     "backtick route",
+			src:      "<form hx-post=\"{{route `subscribe`}}\"></form>",
+			expected: "<form hx-post=\"{{route `subscribe`}}\">{{honeypot}}</form>",
+		},
+		{
+			name:     "other route untouched",
+			src:      `<form hx-post="{{route "subscribeAll"}}"></form>`,
+			expected: `<form hx-post="{{route "subscribeAll"}}"></form>`,
+		},
+		{
+			name:     "explicit placement kept",
+			src:      `<form hx-post="{{route "subscribe"}}">{{honeypot}}</form>`,
+			expected: `<form hx-post="{{route "subscribe"}}">{{honeypot}}</form>`,
+		},
+		{
+			name:     "explicit placement in another form",
+			src:      `<form hx-post="{{route "contact"}}">{{honeypot}}</form><form hx-post="{{route "subscribe"}}"></form>`,
+			expected: `<form hx-post="{{route "contact"}}">{{honeypot}}</form><form hx-post="{{route "subscribe"}}">{{honeypot}}</form>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := injectHoneypotFields(tt.src, funcs); got != tt.expected {
+				t.Errorf("injectHoneypotFields() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGenerateHoneypotInvalidDelay(t *testing.T) {
+	file := &ast.GMXFile{
+		Script: &ast.ScriptBlock{
+			Funcs: []*ast.FuncDecl{
+				{
+					Name:        "subscribe",
+					Body:        []ast.Statement{},
+					Annotations: []*ast.Annotation{{Name: "honeypot", Args: map[string]string{"min": "soon"}}},
+				},
+			},
+		},
+	}
+
+	gen := New()
+	if _, err := gen.Generate(file); err == nil {
+		t.Fatal("expected error for invalid honeypot delay")
+	}
+}
+
+func TestGenerateHoneypotWithoutSession(t *testing.T) {
+	file := &ast.GMXFile{
+		Script: &ast.ScriptBlock{
+			Funcs: []*ast.FuncDecl{
+				{
+					Name:        "subscribe",
+					Body:        []ast.Statement{},
+					Annotations: []*ast.Annotation{{Name: "honeypot", Args: map[string]string{}}},
+				},
+			},
+		},
+	}
+
+	_, err := New().Generate(file)
+	if err == nil || !strings.Contains(err.Error(), `function subscribe: @honeypot requires a service with provider "session", whose secret signs the form timestamps`) {
+		t.Errorf("expected the session requirement, got %v", err)
+	}
+}
+
+func TestGenServiceFieldDefault(t *testing.T) {
+	svc := &ast.ServiceDecl{
+		Name:     "Cache",
+		Provider: "redis",
+		Fields: []*ast.ServiceField{
+			{Name: "host", Type: "string", EnvVar: "REDIS_HOST", Annotations: []*ast.Annotation{{Name: "default", Args: map[string]string{"_": "localhost"}}}},
+			{Name: "prefix", Type: "string", Annotations: []*ast.Annotation{{Name: "default", Args: map[string]string{"_": "gmx"}}}},
+			{Name: "pass", Type: "string", EnvVar: "REDIS_PASS"},
+		},
+	}
+
+	gen := New()
+	code := gen.genServiceInit(svc)
+
+	expected := []string{
+		`cfg.Host = "localhost"`,
+		`if v := os.Getenv("REDIS_HOST"); v != "" {`,
+		`cfg.Prefix = "gmx"`,
+		`cfg.Pass = os.Getenv("REDIS_PASS")`,
+		`log.Fatal("missing required env var: REDIS_PASS")`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(code, exp) {
+			t.Errorf("Generated init missing %q:\n%s", exp, code)
+		}
+	}
+	if strings.Contains(code, "missing required env var: REDIS_HOST") {
+		t.Error("field with @default should not be required")
+	}
+}
+
+func TestGeneratePackageOption(t *testing.T) {
+	file := &ast.GMXFile{Template: &ast.TemplateBlock{Source: "<h1>Hello</h1>"}}
+
+	code, err := NewWithOptions(Options{Package: "web"}).Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !strings.HasPrefix(code, "package web\n") {
+		t.Errorf("expected package web, got:\n%s", code[:strings.Index(code, "\n")])
+	}
+	if !strings.Contains(code, "func Main() {") || strings.Contains(code, "func main() {") {
+		t.Error("non-main package should export Main() instead of main()")
+	}
+
+	if _, err := NewWithOptions(Options{Package: "my-app"}).Generate(file); err == nil {
+		t.Error("expected an error for an invalid package name")
+	}
+}
+
+func TestGenerateBuildsInArbitraryModule(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs go build")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go toolchain not available")
+	}
+
+	file := &ast.GMXFile{Template: &ast.TemplateBlock{Source: "<h1>Hello</h1>"}}
+	code, err := NewWithOptions(Options{Package: "web"}).Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":     "module example.com/acme/shop\n\ngo 1.24\n",
+		"main.go":    "package main\n\nimport \"example.com/acme/shop/web\"\n\nfunc main() { web.Main() }\n",
+		"web/web.go": code,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(goBin, "build", "./...")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOPROXY=off")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go build failed: %v\n%s", err, out)
+	}
+}
+
+// testGoMod is the module goInModule builds generated code in, unless the
+// files bring their own go.mod
+const testGoMod = "module example.com/app\n\ngo 1.24\n\nrequire (\n\tgorm.io/driver/sqlite v1.6.0\n\tgorm.io/gorm v1.31.1\n)\n"
+
+// goInModule writes files into a module requiring the gorm modules GMX is
+// tested against, and runs the go tool with args in it; modules are resolved
+// from the local module cache
+func goInModule(t *testing.T, files map[string]string, args ...string) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("runs the go tool")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go toolchain not available")
+	}
+
+	dir := t.TempDir()
+	if _, ok := files["go.mod"]; !ok {
+		files["go.mod"] = testGoMod
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(goBin, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOPROXY=off")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go %s failed: %v\n%s", strings.Join(args, " "), err, out)
+	}
+}
+
+func TestGenerateSagaBuilds(t *testing.T) {
+	src := `service Payments {
+  provider: "custom"
+  func charge(orderId: uuid) error
+  func refund(orderId: uuid) error
+}
+service Mailer {
+  provider: "smtp"
+  host: string @env("SMTP_HOST")
+  pass: string @env("SMTP_PASS")
+  func send(to: string, subject: string, body: string) error
+}
+model Order {
+  id: uuid @pk @default(uuid_v4)
+  title: string
+}
+func placeOrder(title: string) error {
+  const order = Order{title: title}
+  saga {
+    step {
+      try order.save()
+    } compensate {
+      try order.delete()
+    }
+    step {
+      try Payments.charge(order.id)
+    } compensate {
+      try Payments.refund(order.id)
+    }
+    step {
+      try Mailer.send(ctx.User, "Order placed", order.title)
+    }
+  }
+  return render(order)
+}`
+	code, err := New().Generate(scriptTestFile(t, src, "<p>{{.CSRFToken}}</p>"))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	goInModule(t, map[string]string{"main.go": code}, "build", ".")
+}
+
+func TestGenerateResolvedFragments(t *testing.T) {
+	resolved := &resolver.ResolvedFile{
+		Main: &ast.GMXFile{
+			Template: &ast.TemplateBlock{Source: `<ul>{{if .CSRFToken}}{{fragment "tasks/TaskRow" .}}{{end}}</ul>`},
+		},
+		Fragments: map[string]*resolver.FragmentInfo{
+			"tasks/TaskRow": {Name: "tasks/TaskRow", Source: `<li>{{template "tasks/Badge" .}}</li>`, Path: "/app/tasks.gmx"},
+			"tasks/Badge":   {Name: "tasks/Badge", Source: `<span>{{.ID}}</span>`, Path: "/app/tasks.gmx"},
+		},
+	}
+
+	code, err := New().GenerateResolved(resolved)
+	if err != nil {
+		t.Fatalf("GenerateResolved failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	expected := []string{
+		`{{if .CSRFToken}}{{template "tasks/TaskRow" .}}{{end}}`,
+		`{{define "tasks/TaskRow"}}<li>{{template "tasks/Badge" .}}</li>{{end}}`,
+		`{{define "tasks/Badge"}}<span>{{.ID}}</span>{{end}}`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(code, exp) {
+			t.Errorf("Generated code missing %q", exp)
+		}
+	}
+	if strings.Contains(code, "{{fragment") {
+		t.Error("fragment calls should be rewritten to template calls")
+	}
+	if strings.Index(code, `{{define "tasks/Badge"}}`) > strings.Index(code, `{{define "tasks/TaskRow"}}`) {
+		t.Error("fragments should be emitted in sorted order")
+	}
+}
+
+func TestGenerateRenderItemIsolation(t *testing.T) {
+	file := &ast.GMXFile{
+		Models: []*ast.ModelDecl{
+			{Name: "Task", Fields: []*ast.FieldDecl{{Name: "id", Type: "uuid", Annotations: []*ast.Annotation{{Name: "pk"}}}, {Name: "title", Type: "string"}}},
+		},
+		Template: &ast.TemplateBlock{Source: `<ul>{{range .Tasks}}<li>{{.Title}}</li>{{end}}</ul>`},
+	}
+
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	expected := []string{
+		`<ul>{{range .Tasks}}{{renderItem "Task" .}}{{end}}</ul>`,
+		`{{define "Task"}}<li>{{.Title}}</li>{{end}}`,
+		`"renderItem": renderItem,`,
+		"func renderItem(name string, data interface{}) (out template.HTML)",
+		"if r := recover(); r != nil",
+		"return renderItemFallback",
+		`"strings"`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(code, exp) {
+			t.Errorf("Generated code missing %q", exp)
+		}
+	}
+}
+
+func TestGenerateAccessControl(t *testing.T) {
+	session := &ast.ServiceDecl{Name: "Session", Provider: "session", Fields: []*ast.ServiceField{
+		{Name: "secret", Type: "string", EnvVar: "SESSION_SECRET"},
+		{Name: "admins", Type: "string", EnvVar: "ADMINS"},
+	}}
+	file := &ast.GMXFile{
+		Services: []*ast.ServiceDecl{session},
+		Script: &ast.ScriptBlock{Funcs: []*ast.FuncDecl{
+			{Name: "listUsers", RoutePrefix: "/admin", Annotations: []*ast.Annotation{{Name: "auth"}, {Name: "role", Args: map[string]string{"_": "admin"}}}},
+			{Name: "updateProfile", Annotations: []*ast.Annotation{{Name: "auth"}}},
+			{Name: "listPosts"},
+		}},
+	}
+
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !isValidGo(code) {
+		t.Errorf("Generated code is not valid Go:\n%s", code)
+	}
+
+	if got := strings.Count(code, `http.Error(w, "Unauthorized", http.StatusUnauthorized)`); got != 2 {
+		t.Errorf("expected the two @auth handlers to require a user, got %d checks", got)
+	}
+	if got := strings.Count(code, "if !sessionAdmins[ctx.User] {"); got != 1 {
+		t.Errorf("expected one @role(admin) check, got %d", got)
+	}
+	if !strings.Contains(code, `mux.HandleFunc("/admin/listUsers", handleListUsers)`) {
+		t.Error("expected the grouped handler under its prefix")
+	}
+}
+
+func TestGenerateAccessControlErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		services []*ast.ServiceDecl
+		ann      *ast.Annotation
+		want     string
+	}{
+		{"auth without session", nil, &ast.Annotation{Name: "auth"}, `@auth requires a service with provider "session"`},
+		{"unknown role", nil, &ast.Annotation{Name: "role", Args: map[string]string{"_": "editor"}}, `unsupported role "editor"`},
+		{
+			"role without admins",
+			[]*ast.ServiceDecl{{Name: "Session", Provider: "session", Fields: []*ast.ServiceField{{Name: "secret", Type: "string", EnvVar: "SESSION_SECRET"}}}},
+			&ast.Annotation{Name: "role", Args: map[string]string{"_": "admin"}},
+			"@role(admin) requires a session service with an `admins` field",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := &ast.GMXFile{
+				Services: tt.services,
+				Script:   &ast.ScriptBlock{Funcs: []*ast.FuncDecl{{Name: "listUsers", Annotations: []*ast.Annotation{tt.ann}}}},
+			}
+			_, err := New().Generate(file)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestGenerateNegotiate(t *testing.T) {
+	file := strictFile(t, "model Task {\n  id: uuid @pk\n  title: string\n}\n@negotiate\nfunc getTask(id: uuid) error {\n  let task = try Task.find(id)\n  return render(task)\n}",
+		"{{define \"Task\"}}<p>{{.Title}}</p>{{end}}")
+
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	for _, want := range []string{
+		"func negotiateJSON(w http.ResponseWriter, r *http.Request) bool {",
+		`w.Header().Add("Vary", "Accept")`,
+		"func writeJSON(w http.ResponseWriter, data interface{}) error {",
+		"return writeJSON(ctx.Writer, task)",
+		`"encoding/json"`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+
+	file.Script.Funcs[0].Annotations[0].Args = map[string]string{"_": "json"}
+	if _, err := New().Generate(file); err == nil || !strings.Contains(err.Error(), "@negotiate takes no arguments") {
+		t.Errorf("expected an argument error, got %v", err)
+	}
+}
+
+func TestGenerateJSON(t *testing.T) {
+	src := "model Task {\n  id: uuid @pk\n  title: string\n}\n@json\nfunc getTask(id: uuid) error {\n  let task = try Task.find(id)\n  return render(task)\n}\n@negotiate\nfunc findTask(id: uuid) error {\n  let task = try Task.find(id)\n  return render(task)\n}"
+	file := strictFile(t, src, "{{define \"Task\"}}<p>{{.Title}}</p>{{end}}")
+
+	code, err := New().Generate(file)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	json := code[strings.Index(code, "func handleGetTask("):strings.Index(code, "func handleFindTask(")]
+	for _, want := range []string{
+		`jsonError(w, "Missing required parameter: id", http.StatusBadRequest)`,
+		`jsonError(w, "Invalid ID format", http.StatusBadRequest)`,
+		"writeJSONError(w, r, err)",
+	} {
+		if !strings.Contains(json, want) {
+			t.Errorf("@json handler missing %q:\n%s", want, json)
+		}
+	}
+	if strings.Contains(json, "http.Error(") || strings.Contains(json, "renderValidationError") {
+		t.Errorf("@json handlers must answer every error as JSON:\n%s", json)
+	}
+	negotiated := code[strings.Index(code, "func handleFindTask("):]
+	if !strings.Contains(negotiated, "if negotiateJSON(w, r) {\n\t\t\twriteJSONError(w, r, err)") {
+		t.Errorf("@negotiate handlers must answer JSON errors to JSON clients:\n%s", negotiated)
+	}
+	for _, want := range []string{
+		"func jsonError(w http.ResponseWriter, message string, status int) {",
+		"func writeJSONError(w http.ResponseWriter, r *http.Request, err error) {",
+		"errors.Is(err, gorm.ErrRecordNotFound)",
+		"http.StatusUnprocessableEntity",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"arguments", strings.Replace(src, "@json", "@json(\"v1\")", 1), "@json takes no arguments"},
+		{"with negotiate", strings.Replace(src, "@json", "@json\n@negotiate", 1), "@negotiate is redundant"},
+		{"async", strings.Replace(src, "@json", "@json\n@async", 1), "not JSON"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New().Generate(strictFile(t, tt.src, "{{define \"Task\"}}<p>{{.Title}}</p>{{end}}"))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+// passwordHashTest runs in the generated package: a client submitting a
+// bcrypt-shaped password gets it hashed, and a loaded hash is saved as is
+const passwordHashTest = `package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestPasswordHashing(t *testing.T) {
+	var err error
+	if db, err = gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "app.db")), &gorm.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&User{}); err != nil {
+		t.Fatal(err)
+	}
+
+	forged, _ := bcrypt.GenerateFromPassword([]byte("chosen by the client"), bcrypt.MinCost)
+	user := User{Email: "ann@example.com", Password: string(forged)}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	var loaded User
+	if err := db.First(&loaded, "id = ?", user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Password == string(forged) || !checkPassword(loaded.Password, string(forged)) {
+		t.Fatal("expected the submitted hash to be hashed as a password")
+	}
+
+	hash := loaded.Password
+	loaded.Email = "bob@example.com"
+	if err := db.Save(&loaded).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Save(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	var saved User
+	if err := db.First(&saved, "id = ?", user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if saved.Password != hash {
+		t.Error("expected the loaded hash to be saved as is")
+	}
+}
+`
+
+func TestGeneratePasswordHashing(t *testing.T) {
+	src := `model User {
+  id:       uuid @pk @default(uuid_v4)
+  email:    string @email
+  password: password
+}`
+	code, err := New().Generate(scriptTestFile(t, src, `<ul>{{range .Users}}<li>{{.Email}}</li>{{end}}</ul>`))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	goMod := testGoMod + "\nrequire golang.org/x/crypto v0.54.0\n"
+	goInModule(t, map[string]string{"go.mod": goMod, "main.go": code, "main_test.go": passwordHashTest}, "test", ".")
+}
@@ -0,0 +1,230 @@
+package generator
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/resolver"
+)
+
+// IndexSuggestion is an index the model queries of a script would use, which
+// their model does not declare
+type IndexSuggestion struct {
+	Model   string
+	Fields  []string // indexed fields, in index order
+	Filter  []string // fields the queries compare, the @scoped field first
+	Order   string   // field the queries sort by, "" when they do not
+	Line    int      // .gmx line of the first query using the index
+	Queries int      // number of queries using the index
+}
+
+// Declaration returns the model body line declaring the index
+func (s IndexSuggestion) Declaration() string {
+	return "@@index([" + strings.Join(s.Fields, ", ") + "])"
+}
+
+// SuggestIndexes inspects the model queries of the script functions, their
+// where() conditions, order() and the tenant filter of @scoped models, and
+// returns the indexes they would use that no declared index covers. Lookups
+// by a @pk or @unique field are already indexed.
+func (g *Generator) SuggestIndexes(resolved *resolver.ResolvedFile) []IndexSuggestion {
+	file := resolved.Main
+	if selected, err := g.selectCompileTime(file); err == nil {
+		file = selected
+	}
+	// The fields of @timestamps are sorted by and can be indexed
+	file = g.withTimestamps(file)
+	if file.Script == nil {
+		return nil
+	}
+	models := make(map[string]*ast.ModelDecl)
+	for _, model := range file.Models {
+		models[model.Name] = model
+	}
+
+	byKey := make(map[string]*IndexSuggestion)
+	var suggestions []*IndexSuggestion
+	for _, fn := range file.Script.Funcs {
+		ast.Inspect(fn, func(node ast.Node) bool {
+			call, ok := node.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			model, calls := queryChain(call, models)
+			if model == nil {
+				return true
+			}
+			s := queryIndex(model, calls)
+			if s == nil || indexCovered(model, s) {
+				return false
+			}
+			key := s.Model + "(" + strings.Join(s.Fields, ",") + ")"
+			if known, ok := byKey[key]; ok {
+				known.Queries++
+				return false
+			}
+			s.Line = calls[0].Line
+			s.Queries = 1
+			byKey[key] = s
+			suggestions = append(suggestions, s)
+			// The inner calls of the chain belong to this query
+			return false
+		})
+	}
+
+	// An index whose fields start another suggested index is served by it
+	served := make(map[*IndexSuggestion]bool)
+	for _, s := range suggestions {
+		for _, other := range suggestions {
+			if other != s && other.Model == s.Model && len(other.Fields) > len(s.Fields) && hasFieldPrefix(other.Fields, s.Fields) {
+				other.Queries += s.Queries
+				if s.Line < other.Line {
+					other.Line = s.Line
+				}
+				served[s] = true
+				break
+			}
+		}
+	}
+	var kept []IndexSuggestion
+	for _, s := range suggestions {
+		if !served[s] {
+			kept = append(kept, *s)
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].Line < kept[j].Line })
+	return kept
+}
+
+// queryChain returns the model a query chain ends with call queries, and its
+// calls root first: Task.where(...).order(...) → Task, [where, order]
+func queryChain(call *ast.CallExpr, models map[string]*ast.ModelDecl) (*ast.ModelDecl, []*ast.CallExpr) {
+	member, ok := call.Function.(*ast.MemberExpr)
+	if !ok {
+		return nil, nil
+	}
+	switch obj := member.Object.(type) {
+	case *ast.Ident:
+		if model := models[obj.Name]; model != nil && modelLoads[member.Property] {
+			return model, []*ast.CallExpr{call}
+		}
+	case *ast.CallExpr:
+		if model, calls := queryChain(obj, models); model != nil && indexedQueryMethods[member.Property] {
+			return model, append(calls, call)
+		}
+	}
+	return nil, nil
+}
+
+// indexedQueryMethods are the methods chained after the root of a model query
+var indexedQueryMethods = map[string]bool{"where": true, "order": true, "limit": true, "first": true}
+
+// queryIndex returns the index a model query would use: the fields it
+// compares, the @scoped field first and the others sorted, then the field it
+// sorts by; nil when the query neither filters nor sorts
+func queryIndex(model *ast.ModelDecl, calls []*ast.CallExpr) *IndexSuggestion {
+	s := &IndexSuggestion{Model: model.Name}
+	var compared []string
+	seen := make(map[string]bool)
+	for _, call := range calls {
+		switch call.Function.(*ast.MemberExpr).Property {
+		case "find":
+			// find(id) looks up the primary key
+			return nil
+		case "where":
+			for _, arg := range call.Args {
+				named, ok := arg.(*ast.NamedArg)
+				if !ok || named.Name == "include" || seen[named.Name] || !isIndexable(model, named.Name) {
+					continue
+				}
+				seen[named.Name] = true
+				compared = append(compared, named.Name)
+			}
+		case "order":
+			if len(call.Args) > 0 && s.Order == "" {
+				if field, ok := call.Args[0].(*ast.Ident); ok && isIndexable(model, field.Name) {
+					s.Order = field.Name
+				}
+			}
+		}
+	}
+	sort.Strings(compared)
+	for _, field := range model.Fields {
+		if field.FindAnnotation("scoped") != nil {
+			s.Filter = append(s.Filter, field.Name)
+			for i, name := range compared {
+				if name == field.Name {
+					compared = append(compared[:i], compared[i+1:]...)
+					break
+				}
+			}
+			break
+		}
+	}
+	s.Filter = append(s.Filter, compared...)
+	if s.Order != "" && seen[s.Order] {
+		// Records compared to a single value are already in order
+		s.Order = ""
+	}
+
+	s.Fields = append(append([]string{}, s.Filter...), s.Order)
+	if s.Order == "" {
+		s.Fields = s.Fields[:len(s.Filter)]
+	}
+	if len(s.Fields) == 0 {
+		return nil
+	}
+	return s
+}
+
+// isIndexable reports whether a field of the model is a column an index can hold
+func isIndexable(model *ast.ModelDecl, name string) bool {
+	field := model.FindField(name)
+	return field != nil && columnTypes[field.Type] && field.Type != "string[]" && field.Type != "json" && field.Type != "bytes" && field.Type != "image"
+}
+
+// indexCovered reports whether the declared indexes of a model serve a
+// query: a compared @pk or @unique field, or an index starting with the
+// compared fields, in any order, then the sorted field
+func indexCovered(model *ast.ModelDecl, s *IndexSuggestion) bool {
+	for _, name := range s.Filter {
+		field := model.FindField(name)
+		if field.FindAnnotation("pk") != nil || field.FindAnnotation("unique") != nil {
+			return true
+		}
+	}
+	for _, index := range modelIndexes(model) {
+		if len(index.fields) < len(s.Fields) {
+			continue
+		}
+		filter := make(map[string]bool)
+		for _, name := range index.fields[:len(s.Filter)] {
+			filter[name] = true
+		}
+		covered := true
+		for _, name := range s.Filter {
+			covered = covered && filter[name]
+		}
+		if covered && (s.Order == "" || index.fields[len(s.Filter)] == s.Order) {
+			return true
+		}
+	}
+	// A sort alone is served by a @unique field
+	if len(s.Filter) == 0 {
+		if field := model.FindField(s.Order); field.FindAnnotation("pk") != nil || field.FindAnnotation("unique") != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// hasFieldPrefix reports whether fields starts with prefix
+func hasFieldPrefix(fields, prefix []string) bool {
+	for i, name := range prefix {
+		if fields[i] != name {
+			return false
+		}
+	}
+	return true
+}
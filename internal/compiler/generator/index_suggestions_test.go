@@ -0,0 +1,114 @@
+package generator
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/lang"
+	"github.com/btouchard/gmx/internal/compiler/resolver"
+	"github.com/btouchard/gmx/internal/compiler/script"
+)
+
+const indexModels = `model Task {
+  id: uuid @pk @default(uuid_v4)
+  tenantId: uuid @scoped
+  status: string
+  ownerId: uuid
+  email: string @unique
+  tags: string[]
+  createdAt: datetime
+}
+model Note {
+  id: uuid @pk @default(uuid_v4)
+  body: string
+  rank: int
+  @@index([body, rank])
+}
+`
+
+func TestSuggestIndexes(t *testing.T) {
+	tests := []struct {
+		name  string
+		funcs string
+		want  []IndexSuggestion
+	}{
+		{
+			name: "scoped composite",
+			funcs: `func list(owner: uuid) error {
+  let tasks = try Task.where(status: "open", ownerId: owner).order(createdAt, desc)
+  return render(tasks)
+}`,
+			want: []IndexSuggestion{{
+				Model:   "Task",
+				Fields:  []string{"tenantId", "ownerId", "status", "createdAt"},
+				Filter:  []string{"tenantId", "ownerId", "status"},
+				Order:   "createdAt",
+				Line:    17,
+				Queries: 1,
+			}},
+		},
+		{
+			name: "prefix merged",
+			funcs: `func list(owner: uuid) error {
+  let open = try Task.where(ownerId: owner)
+  let tasks = try Task.where(ownerId: owner).order(createdAt)
+  return render(tasks)
+}`,
+			want: []IndexSuggestion{{
+				Model:   "Task",
+				Fields:  []string{"tenantId", "ownerId", "createdAt"},
+				Filter:  []string{"tenantId", "ownerId"},
+				Order:   "createdAt",
+				Line:    17,
+				Queries: 2,
+			}},
+		},
+		{
+			name: "order of a compared field",
+			funcs: `func list() error {
+  let tasks = try Task.where(status: "open").order(status)
+  return render(tasks)
+}`,
+			want: []IndexSuggestion{{
+				Model:   "Task",
+				Fields:  []string{"tenantId", "status"},
+				Filter:  []string{"tenantId", "status"},
+				Line:    17,
+				Queries: 1,
+			}},
+		},
+		{
+			name: "served queries",
+			funcs: `func list(id: uuid, email: string, body: string) error {
+  let task = try Task.find(id)
+  let byEmail = try Task.where(email: email).first()
+  let notes = try Note.where(body: body).order(rank)
+  let sorted = try Note.all().order(id)
+  let tagged = try Note.where(body: body, tags: "a")
+  return render(task)
+}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, errs := script.ParseVersion(indexModels+tt.funcs, 0, lang.Version{Major: 1, Minor: 1})
+			if len(errs) > 0 {
+				t.Fatalf("parse errors: %v", errs)
+			}
+			file := &ast.GMXFile{Models: parsed.Models, Script: &ast.ScriptBlock{Funcs: parsed.Funcs}}
+			got := New().SuggestIndexes(&resolver.ResolvedFile{Main: file})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SuggestIndexes() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndexSuggestion_Declaration(t *testing.T) {
+	s := IndexSuggestion{Fields: []string{"tenantId", "createdAt"}}
+	if got, want := s.Declaration(), "@@index([tenantId, createdAt])"; got != want {
+		t.Errorf("Declaration() = %q, want %q", got, want)
+	}
+}
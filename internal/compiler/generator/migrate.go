@@ -0,0 +1,758 @@
+package generator
+
+import (
+	"fmt"
+	gotoken "go/token"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/resolver"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// MigrationProviders are the database providers gmx migrate writes SQL for
+var MigrationProviders = []string{"sqlite", "postgres", "mysql"}
+
+// columnTypes are the field types stored in a column; other types are relations
+var columnTypes = map[string]bool{
+	"uuid": true, "string": true, "password": true, "int": true, "float": true,
+	"bool": true, "datetime": true, "json": true, "string[]": true,
+	"bytes": true, "image": true, "enum": true,
+}
+
+// Schema is the snapshot of the tables of an app, written next to its
+// migrations and compared with the models by gmx migrate
+type Schema struct {
+	Version int            `json:"version"` // version of the last migration
+	Tables  []*SchemaTable `json:"tables"`
+}
+
+// SchemaTable is the table of a model
+type SchemaTable struct {
+	Model       string          `json:"model"`
+	Name        string          `json:"name"`
+	Columns     []*SchemaColumn `json:"columns"`
+	Indexes     []*SchemaIndex  `json:"indexes,omitempty"`
+	RenamedFrom string          `json:"-"` // previous model name, from @renamedFrom
+}
+
+// SchemaColumn is the column of a model field
+type SchemaColumn struct {
+	Field       string   `json:"field"`
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`             // field type, "money" for int @money, "string" for enums
+	Values      []string `json:"values,omitempty"` // variants of an enum, checked by a constraint
+	PrimaryKey  bool     `json:"primaryKey,omitempty"`
+	Unique      bool     `json:"unique,omitempty"`
+	Indexed     bool     `json:"indexed,omitempty"` // part of an @index, @@index or @@unique
+	Default     string   `json:"default,omitempty"` // SQL literal
+	RenamedFrom string   `json:"-"`                 // previous field name, from @renamedFrom
+}
+
+// SchemaIndex is an index of a table, from @index, @@index or @@unique
+type SchemaIndex struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Unique  bool     `json:"unique,omitempty"`
+}
+
+// Migration is a versioned schema change, as SQL for one provider
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// FileName returns the base name of the migration files: 0002_add_posts_photo
+func (m Migration) FileName() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// table returns the table of a model, or nil
+func (s *Schema) table(model string) *SchemaTable {
+	for _, t := range s.Tables {
+		if t.Model == model {
+			return t
+		}
+	}
+	return nil
+}
+
+// column returns the column of a field, or nil
+func (t *SchemaTable) column(field string) *SchemaColumn {
+	for _, c := range t.Columns {
+		if c.Field == field {
+			return c
+		}
+	}
+	return nil
+}
+
+// index returns the index of a name, or nil
+func (t *SchemaTable) index(name string) *SchemaIndex {
+	for _, i := range t.Indexes {
+		if i.Name == name {
+			return i
+		}
+	}
+	return nil
+}
+
+// DatabaseProvider returns the provider of the app's database service,
+// "sqlite" without one
+func (g *Generator) DatabaseProvider(resolved *resolver.ResolvedFile) string {
+	file := resolved.Main
+	if selected, err := g.selectCompileTime(file); err == nil {
+		file = selected
+	}
+	if svc := g.findDatabaseService(file.Services); svc != nil {
+		return svc.Provider
+	}
+	return "sqlite"
+}
+
+// Schema returns the tables the generated server stores its models in,
+// generated models included
+func (g *Generator) Schema(resolved *resolver.ResolvedFile) (*Schema, error) {
+	file, err := g.selectCompileTime(resolved.Main)
+	if err != nil {
+		return nil, err
+	}
+	file = g.withGeneratedModels(file)
+
+	schema := &Schema{}
+	for _, model := range file.Models {
+		table := &SchemaTable{Model: model.Name, Name: tableName(model.Name)}
+		if ann := model.FindAnnotation("renamedFrom"); ann != nil {
+			table.RenamedFrom = ann.SimpleArg()
+		}
+		for _, field := range model.Fields {
+			if !columnTypes[field.Type] {
+				continue
+			}
+			column := &SchemaColumn{Field: field.Name, Name: utils.ToSnakeCase(field.Name), Type: field.Type}
+			if isMoneyField(field) {
+				column.Type = "money"
+			}
+			if isEnumField(field) {
+				column.Type = "string"
+				column.Values = field.Variants
+			}
+			for _, ann := range field.Annotations {
+				switch ann.Name {
+				case "pk":
+					column.PrimaryKey = true
+				case "unique":
+					column.Unique = true
+				case "default":
+					column.Default = sqlDefault(ann.SimpleArg())
+				case "renamedFrom":
+					column.RenamedFrom = ann.SimpleArg()
+				}
+			}
+			table.Columns = append(table.Columns, column)
+		}
+		for _, mi := range modelIndexes(model) {
+			index := &SchemaIndex{Name: mi.name, Unique: mi.unique}
+			for _, field := range mi.fields {
+				column := table.column(field)
+				column.Indexed = true
+				index.Columns = append(index.Columns, column.Name)
+			}
+			table.Indexes = append(table.Indexes, index)
+		}
+		schema.Tables = append(schema.Tables, table)
+	}
+	return schema, nil
+}
+
+// tableName returns the table of a model, as named by GORM: Post → posts,
+// Category → categories
+func tableName(model string) string {
+	name := utils.ToSnakeCase(model)
+	switch {
+	case strings.HasSuffix(name, "y") && len(name) > 1 && !strings.ContainsRune("aeiou", rune(name[len(name)-2])):
+		return name[:len(name)-1] + "ies"
+	case strings.HasSuffix(name, "s"), strings.HasSuffix(name, "x"), strings.HasSuffix(name, "z"),
+		strings.HasSuffix(name, "ch"), strings.HasSuffix(name, "sh"):
+		return name + "es"
+	}
+	return name + "s"
+}
+
+// sqlDefault returns the SQL literal of a @default value; uuid_v4 is set by
+// the BeforeCreate hook, not the database
+func sqlDefault(value string) string {
+	switch value {
+	case "", "uuid_v4":
+		return ""
+	case "true", "false":
+		return value
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// changeKind is the kind of a schema change
+type changeKind int
+
+const (
+	createTable changeKind = iota
+	dropTable
+	renameTable
+	addColumn
+	dropColumn
+	renameColumn
+	alterColumns
+	createIndex
+	dropIndex
+	addIndex    // index of @index, @@index or @@unique
+	removeIndex // index of @index, @@index or @@unique
+)
+
+// SchemaChange is a change between two schemas
+type SchemaChange struct {
+	kind    changeKind
+	table   *SchemaTable    // table created or dropped, or the changed table after the change
+	from    string          // previous name of a renamed table or column
+	column  *SchemaColumn   // column added, dropped, renamed or indexed
+	index   *SchemaIndex    // index added or removed
+	altered []alteredColumn // columns whose type, default or enum values changed
+}
+
+// alteredColumn is a column before and after a change of type, default or enum values
+type alteredColumn struct {
+	before, after *SchemaColumn
+}
+
+// Destructive reports whether the change loses data: dropped tables and
+// columns, and columns changing type
+func (c SchemaChange) Destructive() bool {
+	switch c.kind {
+	case dropTable, dropColumn:
+		return true
+	case alterColumns:
+		for _, a := range c.altered {
+			if a.before.Type != a.after.Type {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// String describes the change: "add column posts.photo"
+func (c SchemaChange) String() string {
+	switch c.kind {
+	case createTable:
+		return "create table " + c.table.Name
+	case dropTable:
+		return "drop table " + c.table.Name
+	case renameTable:
+		return "rename table " + c.from + " to " + c.table.Name
+	case addColumn:
+		return "add column " + c.table.Name + "." + c.column.Name
+	case dropColumn:
+		return "drop column " + c.table.Name + "." + c.column.Name
+	case renameColumn:
+		return "rename column " + c.table.Name + "." + c.from + " to " + c.column.Name
+	case createIndex:
+		return "add unique index on " + c.table.Name + "." + c.column.Name
+	case dropIndex:
+		return "drop unique index on " + c.table.Name + "." + c.column.Name
+	case addIndex:
+		return "add index " + c.index.Name
+	case removeIndex:
+		return "drop index " + c.index.Name
+	}
+	var parts []string
+	for _, a := range c.altered {
+		switch {
+		case a.before.Type != a.after.Type:
+			parts = append(parts, fmt.Sprintf("change column %s.%s from %s to %s", c.table.Name, a.after.Name, a.before.Type, a.after.Type))
+		case !sameValues(a.before, a.after):
+			parts = append(parts, fmt.Sprintf("change values of %s.%s", c.table.Name, a.after.Name))
+		default:
+			parts = append(parts, fmt.Sprintf("change default of %s.%s", c.table.Name, a.after.Name))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// MigrationName names the migration of a list of changes: init for the
+// first one, the change itself when alone, update_schema otherwise
+func MigrationName(version int, changes []SchemaChange) string {
+	if version == 1 {
+		return "init"
+	}
+	if len(changes) != 1 {
+		return "update_schema"
+	}
+	var b strings.Builder
+	for _, r := range strings.ReplaceAll(changes[0].String(), "unique index on", "unique") {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == ' ' || r == '.' || r == '_':
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// DiffSchema returns the changes turning the previous schema into the current one.
+// Renames follow the @renamedFrom annotations; primary keys cannot change.
+func DiffSchema(previous, current *Schema) ([]SchemaChange, error) {
+	var created, renamed, changed, dropped []SchemaChange
+	matched := make(map[*SchemaTable]bool)
+	for _, nt := range current.Tables {
+		ot := previous.table(nt.Model)
+		if ot == nil && nt.RenamedFrom != "" && current.table(nt.RenamedFrom) == nil {
+			ot = previous.table(nt.RenamedFrom)
+		}
+		if ot == nil {
+			created = append(created, SchemaChange{kind: createTable, table: nt})
+			continue
+		}
+		matched[ot] = true
+		if ot.Name != nt.Name {
+			renamed = append(renamed, SchemaChange{kind: renameTable, table: nt, from: ot.Name})
+		}
+		columnChanges, err := diffColumns(ot, nt)
+		if err != nil {
+			return nil, err
+		}
+		changed = append(changed, columnChanges...)
+	}
+	for _, ot := range previous.Tables {
+		if !matched[ot] {
+			dropped = append(dropped, SchemaChange{kind: dropTable, table: ot})
+		}
+	}
+
+	var changes []SchemaChange
+	for _, group := range [][]SchemaChange{renamed, created, changed, dropped} {
+		changes = append(changes, group...)
+	}
+	return changes, nil
+}
+
+// diffColumns returns the changes of the columns of a table: renames, then
+// added, dropped and altered columns, indexes being dropped first and
+// created last
+func diffColumns(ot, nt *SchemaTable) ([]SchemaChange, error) {
+	var renamed, dropIndexes, added, dropped, createIndexes []SchemaChange
+	var altered []alteredColumn
+	matched := make(map[*SchemaColumn]bool)
+	for _, nc := range nt.Columns {
+		oc := ot.column(nc.Field)
+		if oc == nil && nc.RenamedFrom != "" && nt.column(nc.RenamedFrom) == nil {
+			oc = ot.column(nc.RenamedFrom)
+		}
+		if oc == nil {
+			if nc.PrimaryKey {
+				return nil, fmt.Errorf("model %s: adding the primary key %s to an existing table is not supported; write this migration by hand", nt.Model, nc.Field)
+			}
+			added = append(added, SchemaChange{kind: addColumn, table: nt, column: nc})
+			if nc.Unique {
+				createIndexes = append(createIndexes, SchemaChange{kind: createIndex, table: nt, column: nc})
+			}
+			continue
+		}
+		matched[oc] = true
+		if oc.PrimaryKey != nc.PrimaryKey || (nc.PrimaryKey && oc.Type != nc.Type) {
+			return nil, fmt.Errorf("model %s: changing the primary key %s is not supported; write this migration by hand", nt.Model, nc.Field)
+		}
+		if oc.Name != nc.Name {
+			renamed = append(renamed, SchemaChange{kind: renameColumn, table: nt, from: oc.Name, column: nc})
+		}
+		if oc.Unique && !nc.Unique {
+			dropIndexes = append(dropIndexes, SchemaChange{kind: dropIndex, table: nt, column: oc})
+		}
+		if oc.Type != nc.Type || oc.Default != nc.Default || !sameValues(oc, nc) {
+			altered = append(altered, alteredColumn{before: withName(oc, nc.Name), after: nc})
+		}
+		if !oc.Unique && nc.Unique {
+			createIndexes = append(createIndexes, SchemaChange{kind: createIndex, table: nt, column: nc})
+		}
+	}
+	for _, oc := range ot.Columns {
+		if matched[oc] {
+			continue
+		}
+		if oc.PrimaryKey {
+			return nil, fmt.Errorf("model %s: dropping the primary key %s is not supported; write this migration by hand", nt.Model, oc.Field)
+		}
+		if oc.Unique {
+			dropIndexes = append(dropIndexes, SchemaChange{kind: dropIndex, table: nt, column: oc})
+		}
+		dropped = append(dropped, SchemaChange{kind: dropColumn, table: nt, column: oc})
+	}
+
+	// SQLite rebuilds the table of altered columns without its indexes: they are recreated
+	for _, oi := range ot.Indexes {
+		if ni := nt.index(oi.Name); ni == nil || !sameIndex(oi, ni) || len(altered) > 0 {
+			// Dropped before the renames, so that reverting recreates it on its columns
+			renamed = append([]SchemaChange{{kind: removeIndex, table: nt, index: oi}}, renamed...)
+		}
+	}
+	for _, ni := range nt.Indexes {
+		if oi := ot.index(ni.Name); oi == nil || !sameIndex(oi, ni) || len(altered) > 0 {
+			createIndexes = append(createIndexes, SchemaChange{kind: addIndex, table: nt, index: ni})
+		}
+	}
+
+	var changes []SchemaChange
+	for _, group := range [][]SchemaChange{renamed, dropIndexes, added, dropped} {
+		changes = append(changes, group...)
+	}
+	if len(altered) > 0 {
+		changes = append(changes, SchemaChange{kind: alterColumns, table: nt, altered: altered})
+	}
+	return append(changes, createIndexes...), nil
+}
+
+// sameIndex reports whether two indexes cover the same columns the same way
+func sameIndex(a, b *SchemaIndex) bool {
+	return a.Unique == b.Unique && strings.Join(a.Columns, ",") == strings.Join(b.Columns, ",")
+}
+
+// sameValues reports whether two columns accept the same enum variants
+func sameValues(a, b *SchemaColumn) bool {
+	return strings.Join(a.Values, ",") == strings.Join(b.Values, ",")
+}
+
+// withName returns a copy of a column under another name
+func withName(c *SchemaColumn, name string) *SchemaColumn {
+	renamed := *c
+	renamed.Name = name
+	return &renamed
+}
+
+// inverse returns the change undoing c
+func (c SchemaChange) inverse() SchemaChange {
+	switch c.kind {
+	case createTable:
+		return SchemaChange{kind: dropTable, table: c.table}
+	case dropTable:
+		return SchemaChange{kind: createTable, table: c.table}
+	case renameTable:
+		previous := *c.table
+		previous.Name = c.from
+		return SchemaChange{kind: renameTable, table: &previous, from: c.table.Name}
+	case addColumn:
+		return SchemaChange{kind: dropColumn, table: c.table, column: c.column}
+	case dropColumn:
+		return SchemaChange{kind: addColumn, table: c.table, column: c.column}
+	case renameColumn:
+		return SchemaChange{kind: renameColumn, table: c.table, from: c.column.Name, column: withName(c.column, c.from)}
+	case createIndex:
+		return SchemaChange{kind: dropIndex, table: c.table, column: c.column}
+	case dropIndex:
+		return SchemaChange{kind: createIndex, table: c.table, column: c.column}
+	case addIndex:
+		return SchemaChange{kind: removeIndex, table: c.table, index: c.index}
+	case removeIndex:
+		return SchemaChange{kind: addIndex, table: c.table, index: c.index}
+	}
+	// The table keeps its other columns as they are after the change
+	previous := *c.table
+	previous.Columns = make([]*SchemaColumn, len(c.table.Columns))
+	copy(previous.Columns, c.table.Columns)
+	reverted := make([]alteredColumn, len(c.altered))
+	for i, a := range c.altered {
+		reverted[i] = alteredColumn{before: a.after, after: a.before}
+		for j, col := range previous.Columns {
+			if col == a.after {
+				previous.Columns[j] = a.before
+			}
+		}
+	}
+	return SchemaChange{kind: alterColumns, table: &previous, altered: reverted}
+}
+
+// MigrationSQL returns the SQL applying and reverting the changes on a provider
+func MigrationSQL(changes []SchemaChange, provider string) (up, down string) {
+	d := sqlDialect(provider)
+	var u, r strings.Builder
+	for _, c := range changes {
+		u.WriteString(d.change(c))
+	}
+	for i := len(changes) - 1; i >= 0; i-- {
+		r.WriteString(d.change(changes[i].inverse()))
+	}
+	return u.String(), r.String()
+}
+
+// sqlDialect writes the SQL of schema changes for a provider
+type sqlDialect string
+
+// quote quotes an identifier
+func (d sqlDialect) quote(name string) string {
+	if d == "mysql" {
+		return "`" + name + "`"
+	}
+	return `"` + name + `"`
+}
+
+// columnType returns the column type GORM migrates a field to
+func (d sqlDialect) columnType(c *SchemaColumn) string {
+	switch c.Type {
+	case "int":
+		if c.PrimaryKey {
+			return map[sqlDialect]string{"sqlite": "integer", "postgres": "bigserial", "mysql": "bigint AUTO_INCREMENT"}[d]
+		}
+		return map[sqlDialect]string{"sqlite": "integer", "postgres": "bigint", "mysql": "bigint"}[d]
+	case "money":
+		return "bigint"
+	case "float":
+		return map[sqlDialect]string{"sqlite": "real", "postgres": "decimal", "mysql": "double"}[d]
+	case "bool":
+		return map[sqlDialect]string{"sqlite": "numeric", "postgres": "boolean", "mysql": "boolean"}[d]
+	case "datetime":
+		return map[sqlDialect]string{"sqlite": "datetime", "postgres": "timestamptz", "mysql": "datetime(3)"}[d]
+	case "json":
+		return map[sqlDialect]string{"sqlite": "JSON", "postgres": "JSONB", "mysql": "JSON"}[d]
+	case "string[]":
+		return map[sqlDialect]string{"sqlite": "JSON", "postgres": "text[]", "mysql": "JSON"}[d]
+	case "bytes", "image":
+		return map[sqlDialect]string{"sqlite": "blob", "postgres": "bytea", "mysql": "longblob"}[d]
+	}
+	// MySQL cannot index or default text columns
+	if d == "mysql" {
+		if c.PrimaryKey || c.Unique || c.Indexed || c.Default != "" {
+			return "varchar(191)"
+		}
+		return "longtext"
+	}
+	return "text"
+}
+
+// columnDef returns the definition of a column of a table in CREATE TABLE
+// and ADD COLUMN, with the check of its enum values
+func (d sqlDialect) columnDef(table string, c *SchemaColumn) string {
+	def := d.quote(c.Name) + " " + d.columnType(c)
+	if d == "sqlite" && c.PrimaryKey && c.Type == "int" {
+		def += " PRIMARY KEY AUTOINCREMENT"
+	}
+	if c.Default != "" {
+		def += " DEFAULT " + c.Default
+	}
+	if len(c.Values) > 0 {
+		def += " " + d.check(table, c)
+	}
+	return def
+}
+
+// checkName returns the name of the check constraint of an enum column, as
+// named by GORM: chk_<table>_<column>
+func checkName(table, column string) string {
+	return "chk_" + table + "_" + column
+}
+
+// check returns the constraint restricting a column to its enum values
+func (d sqlDialect) check(table string, c *SchemaColumn) string {
+	return fmt.Sprintf("CONSTRAINT %s CHECK (%s)", d.quote(checkName(table, c.Name)), enumCheck(d.quote(c.Name), c.Values))
+}
+
+// indexName returns the default name of an index: idx_<table>_<columns>
+func indexName(table, column string) string {
+	return "idx_" + table + "_" + column
+}
+
+// createTable returns the statements creating a table and its unique indexes
+func (d sqlDialect) createTable(t *SchemaTable, name string) string {
+	var b strings.Builder
+	var defs, keys []string
+	for _, c := range t.Columns {
+		defs = append(defs, "  "+d.columnDef(t.Name, c))
+		if c.PrimaryKey && !(d == "sqlite" && c.Type == "int") {
+			keys = append(keys, d.quote(c.Name))
+		}
+	}
+	if len(keys) > 0 {
+		defs = append(defs, "  PRIMARY KEY ("+strings.Join(keys, ", ")+")")
+	}
+	b.WriteString(fmt.Sprintf("CREATE TABLE %s (\n%s\n);\n", d.quote(name), strings.Join(defs, ",\n")))
+	return b.String()
+}
+
+// createIndex returns the statement creating the unique index of a column
+func (d sqlDialect) createIndex(table string, c *SchemaColumn) string {
+	ifNotExists := " IF NOT EXISTS"
+	if d == "mysql" {
+		ifNotExists = ""
+	}
+	return fmt.Sprintf("CREATE UNIQUE INDEX%s %s ON %s (%s);\n", ifNotExists, d.quote(indexName(table, c.Name)), d.quote(table), d.quote(c.Name))
+}
+
+// addIndex returns the statement creating an index of @index, @@index or @@unique
+func (d sqlDialect) addIndex(table string, i *SchemaIndex) string {
+	unique, ifNotExists := "", " IF NOT EXISTS"
+	if i.Unique {
+		unique = " UNIQUE"
+	}
+	if d == "mysql" {
+		ifNotExists = ""
+	}
+	columns := make([]string, len(i.Columns))
+	for j, column := range i.Columns {
+		columns[j] = d.quote(column)
+	}
+	return fmt.Sprintf("CREATE%s INDEX%s %s ON %s (%s);\n", unique, ifNotExists, d.quote(i.Name), d.quote(table), strings.Join(columns, ", "))
+}
+
+// dropIndex returns the statement dropping an index by name
+func (d sqlDialect) dropIndex(table, name string) string {
+	if d == "mysql" {
+		return fmt.Sprintf("DROP INDEX %s ON %s;\n", d.quote(name), d.quote(table))
+	}
+	return fmt.Sprintf("DROP INDEX %s;\n", d.quote(name))
+}
+
+// change returns the statements of a schema change
+func (d sqlDialect) change(c SchemaChange) string {
+	table := d.quote(c.table.Name)
+	switch c.kind {
+	case createTable:
+		s := d.createTable(c.table, c.table.Name)
+		for _, col := range c.table.Columns {
+			if col.Unique {
+				s += d.createIndex(c.table.Name, col)
+			}
+		}
+		for _, index := range c.table.Indexes {
+			s += d.addIndex(c.table.Name, index)
+		}
+		return s
+	case dropTable:
+		return fmt.Sprintf("DROP TABLE %s;\n", table)
+	case renameTable:
+		return fmt.Sprintf("ALTER TABLE %s RENAME TO %s;\n", d.quote(c.from), table)
+	case addColumn:
+		return fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s;\n", table, d.columnDef(c.table.Name, c.column))
+	case dropColumn:
+		return fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;\n", table, d.quote(c.column.Name))
+	case renameColumn:
+		return fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s;\n", table, d.quote(c.from), d.quote(c.column.Name))
+	case createIndex:
+		return d.createIndex(c.table.Name, c.column)
+	case dropIndex:
+		return d.dropIndex(c.table.Name, indexName(c.table.Name, c.column.Name))
+	case addIndex:
+		return d.addIndex(c.table.Name, c.index)
+	case removeIndex:
+		return d.dropIndex(c.table.Name, c.index.Name)
+	}
+	return d.alterColumns(c)
+}
+
+// alterColumns returns the statements changing the type, default or enum
+// values of columns; SQLite cannot alter a column, so the table is rebuilt
+func (d sqlDialect) alterColumns(c SchemaChange) string {
+	table := d.quote(c.table.Name)
+	var b strings.Builder
+	if d == "sqlite" {
+		rebuilt := c.table.Name + "__gmx_rebuild"
+		columns := make([]string, len(c.table.Columns))
+		for i, col := range c.table.Columns {
+			columns[i] = d.quote(col.Name)
+		}
+		b.WriteString(d.createTable(c.table, rebuilt))
+		b.WriteString(fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s;\n", d.quote(rebuilt), strings.Join(columns, ", "), strings.Join(columns, ", "), table))
+		b.WriteString(fmt.Sprintf("DROP TABLE %s;\n", table))
+		b.WriteString(fmt.Sprintf("ALTER TABLE %s RENAME TO %s;\n", d.quote(rebuilt), table))
+		for _, col := range c.table.Columns {
+			if col.Unique {
+				b.WriteString(d.createIndex(c.table.Name, col))
+			}
+		}
+		return b.String()
+	}
+	for _, a := range c.altered {
+		column := d.quote(a.after.Name)
+		// The check of the previous values goes first, the new one last
+		changedValues := !sameValues(a.before, a.after)
+		if changedValues && len(a.before.Values) > 0 {
+			b.WriteString(d.dropCheck(c.table.Name, a.before.Name))
+		}
+		switch {
+		case a.before.Type != a.after.Type && d == "mysql":
+			after := *a.after
+			after.Values = nil
+			b.WriteString(fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s;\n", table, d.columnDef(c.table.Name, &after)))
+		default:
+			// PostgreSQL does not cast the previous default to the new type
+			if a.before.Default != "" && (a.before.Type != a.after.Type || a.before.Default != a.after.Default) {
+				b.WriteString(fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP DEFAULT;\n", table, column))
+			}
+			if a.before.Type != a.after.Type {
+				typ := d.columnType(a.after)
+				b.WriteString(fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s USING %s;\n", table, column, typ, castColumn(column, a.before.Type, a.after.Type, typ)))
+			}
+			if a.after.Default != "" && (a.before.Type != a.after.Type || a.before.Default != a.after.Default) {
+				b.WriteString(fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET DEFAULT %s;\n", table, column, a.after.Default))
+			}
+		}
+		if changedValues && len(a.after.Values) > 0 {
+			b.WriteString(fmt.Sprintf("ALTER TABLE %s ADD %s;\n", table, d.check(c.table.Name, a.after)))
+		}
+	}
+	return b.String()
+}
+
+// dropCheck returns the statement dropping the check constraint of an enum column
+func (d sqlDialect) dropCheck(table, column string) string {
+	if d == "mysql" {
+		return fmt.Sprintf("ALTER TABLE %s DROP CHECK %s;\n", d.quote(table), d.quote(checkName(table, column)))
+	}
+	return fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s;\n", d.quote(table), d.quote(checkName(table, column)))
+}
+
+// castColumn returns the PostgreSQL expression converting a column to
+// another type; booleans have no cast to or from numbers
+func castColumn(column, from, to, typ string) string {
+	switch {
+	case from == "bool" && (to == "int" || to == "money" || to == "float"):
+		return fmt.Sprintf("CASE WHEN %s THEN 1 ELSE 0 END", column)
+	case to == "bool" && (from == "int" || from == "money" || from == "float"):
+		return column + " <> 0"
+	}
+	return column + "::" + typ
+}
+
+// SortMigrations orders migrations by version
+func SortMigrations(migrations []Migration) {
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+}
+
+// validateMigrations checks the migrations given to the build: unique,
+// positive versions and @renamedFrom naming a model or field
+func (g *Generator) validateMigrations(file *ast.GMXFile) error {
+	seen := make(map[int]string)
+	for _, m := range g.opts.Migrations {
+		if m.Version < 1 {
+			return fmt.Errorf("migration %s: versions start at 1", m.FileName())
+		}
+		if other, ok := seen[m.Version]; ok {
+			return fmt.Errorf("migrations %s and %s share version %d", other, m.FileName(), m.Version)
+		}
+		seen[m.Version] = m.FileName()
+	}
+	for _, model := range file.Models {
+		if ann := model.FindAnnotation("renamedFrom"); ann != nil && !gotoken.IsIdentifier(ann.SimpleArg()) {
+			return fmt.Errorf("line %d: model %s: @renamedFrom takes the previous model name, such as @renamedFrom(\"Task\")", model.Line, model.Name)
+		}
+		for _, field := range model.Fields {
+			if ann := field.FindAnnotation("renamedFrom"); ann != nil && !gotoken.IsIdentifier(ann.SimpleArg()) {
+				return fmt.Errorf("line %d: field %s.%s: @renamedFrom takes the previous field name, such as @renamedFrom(\"title\")", field.Line, model.Name, field.Name)
+			}
+		}
+	}
+	return nil
+}
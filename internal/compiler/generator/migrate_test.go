@@ -0,0 +1,299 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/lang"
+	"github.com/btouchard/gmx/internal/compiler/resolver"
+	"github.com/btouchard/gmx/internal/compiler/script"
+)
+
+const migrateScriptSrc = `model Task {
+  id: uuid @pk @default(uuid_v4)
+  title: string
+  done: bool @default(false)
+  email: string @unique
+  owner: User
+}`
+
+// migrateSchema returns the schema of a script's models
+func migrateSchema(t *testing.T, src string) *Schema {
+	t.Helper()
+	parsed, errs := script.ParseVersion(src, 0, lang.Version{Major: 1, Minor: 1})
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	schema, err := New().Schema(&resolver.ResolvedFile{Main: &ast.GMXFile{Models: parsed.Models, Settings: parsed.Settings}})
+	if err != nil {
+		t.Fatalf("Schema failed: %v", err)
+	}
+	return schema
+}
+
+func TestSchema(t *testing.T) {
+	schema := migrateSchema(t, migrateScriptSrc+"\nsetting supportEmail: string")
+
+	// Generated models are migrated too
+	if len(schema.Tables) != 2 || schema.Tables[1].Name != "settings" {
+		t.Fatalf("expected the tasks and settings tables, got %+v", schema.Tables)
+	}
+	tasks := schema.Tables[0]
+	if tasks.Name != "tasks" || len(tasks.Columns) != 4 {
+		t.Fatalf("expected the tasks table without its relation, got %+v", tasks)
+	}
+	if id := tasks.Columns[0]; !id.PrimaryKey || id.Default != "" {
+		t.Errorf("uuid_v4 defaults are set by the BeforeCreate hook, got %+v", id)
+	}
+	if done := tasks.Columns[2]; done.Default != "false" {
+		t.Errorf("expected the default of done, got %+v", done)
+	}
+}
+
+func TestTableName(t *testing.T) {
+	tests := map[string]string{
+		"Task":          "tasks",
+		"Category":      "categories",
+		"Day":           "days",
+		"Box":           "boxes",
+		"Address":       "addresses",
+		"BlogPost":      "blog_posts",
+		"Notification":  "notifications",
+		"ActivityEntry": "activity_entries",
+	}
+	for model, want := range tests {
+		if got := tableName(model); got != want {
+			t.Errorf("tableName(%q) = %q, want %q", model, got, want)
+		}
+	}
+}
+
+func TestDiffSchema(t *testing.T) {
+	previous := migrateSchema(t, migrateScriptSrc)
+	tests := []struct {
+		name    string
+		src     string
+		want    []string
+		wantErr string
+	}{
+		{"none", migrateScriptSrc, nil, ""},
+		{"add column", strings.Replace(migrateScriptSrc, "done:", "priority: int\n  done:", 1), []string{"add column tasks.priority"}, ""},
+		{"drop column", strings.Replace(migrateScriptSrc, "  title: string\n", "", 1), []string{"drop column tasks.title"}, ""},
+		{"drop unique column", strings.Replace(migrateScriptSrc, "  email: string @unique\n", "", 1), []string{"drop unique index on tasks.email", "drop column tasks.email"}, ""},
+		{"rename column", strings.Replace(migrateScriptSrc, "title: string", `name: string @renamedFrom("title")`, 1), []string{"rename column tasks.title to name"}, ""},
+		{"rename table", strings.Replace(migrateScriptSrc, "model Task", `@renamedFrom("Task") model Todo`, 1), []string{"rename table tasks to todos"}, ""},
+		{"unique", strings.Replace(migrateScriptSrc, "title: string", "title: string @unique", 1), []string{"add unique index on tasks.title"}, ""},
+		{"type", strings.Replace(migrateScriptSrc, "done: bool @default(false)", "done: int", 1), []string{"change column tasks.done from bool to int"}, ""},
+		{"timestamps", strings.Replace(migrateScriptSrc, "model Task", "@timestamps model Task", 1), []string{"add column tasks.created_at", "add column tasks.updated_at"}, ""},
+		{"index", strings.Replace(migrateScriptSrc, "title: string", "title: string @index", 1), []string{"add index idx_tasks_title"}, ""},
+		{"enum", strings.Replace(migrateScriptSrc, "title: string", "title: enum(draft, published)", 1), []string{"change values of tasks.title"}, ""},
+		{"composite index", strings.Replace(migrateScriptSrc, "  owner: User\n", "  owner: User\n  @@index([title, done])\n", 1), []string{"add index idx_tasks_title_done"}, ""},
+		{"new table", migrateScriptSrc + "\nmodel Tag {\n  id: int @pk\n}", []string{"create table tags"}, ""},
+		{"drop table", "model Tag {\n  id: int @pk\n}", []string{"create table tags", "drop table tasks"}, ""},
+		{"primary key", strings.Replace(migrateScriptSrc, "id: uuid @pk", "id: int @pk", 1), nil, "model Task: changing the primary key id is not supported"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, err := DiffSchema(previous, migrateSchema(t, tt.src))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DiffSchema failed: %v", err)
+			}
+			var got []string
+			for _, c := range changes {
+				got = append(got, c.String())
+			}
+			if strings.Join(got, "; ") != strings.Join(tt.want, "; ") {
+				t.Errorf("changes = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrationSQL(t *testing.T) {
+	previous := migrateSchema(t, migrateScriptSrc)
+	current := migrateSchema(t, strings.Replace(migrateScriptSrc, "done: bool @default(false)", "done: int", 1))
+	changes, err := DiffSchema(previous, current)
+	if err != nil {
+		t.Fatalf("DiffSchema failed: %v", err)
+	}
+	if !changes[0].Destructive() {
+		t.Errorf("a column changing type should be destructive")
+	}
+
+	tests := []struct {
+		provider string
+		up       []string
+		down     []string
+	}{
+		// SQLite cannot alter a column: the table is rebuilt with its indexes
+		{"sqlite", []string{
+			`CREATE TABLE "tasks__gmx_rebuild" (`,
+			`INSERT INTO "tasks__gmx_rebuild" ("id", "title", "done", "email") SELECT "id", "title", "done", "email" FROM "tasks";`,
+			`ALTER TABLE "tasks__gmx_rebuild" RENAME TO "tasks";`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS "idx_tasks_email" ON "tasks" ("email");`,
+		}, []string{`"done" numeric DEFAULT false,`}},
+		{"postgres", []string{
+			`ALTER TABLE "tasks" ALTER COLUMN "done" DROP DEFAULT;`,
+			`ALTER TABLE "tasks" ALTER COLUMN "done" TYPE bigint USING CASE WHEN "done" THEN 1 ELSE 0 END;`,
+		}, []string{
+			`ALTER TABLE "tasks" ALTER COLUMN "done" TYPE boolean USING "done" <> 0;`,
+			`ALTER TABLE "tasks" ALTER COLUMN "done" SET DEFAULT false;`,
+		}},
+		{"mysql", []string{"ALTER TABLE `tasks` MODIFY COLUMN `done` bigint;"}, []string{"ALTER TABLE `tasks` MODIFY COLUMN `done` boolean DEFAULT false;"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			up, down := MigrationSQL(changes, tt.provider)
+			for _, want := range tt.up {
+				if !strings.Contains(up, want) {
+					t.Errorf("up migration missing %q:\n%s", want, up)
+				}
+			}
+			for _, want := range tt.down {
+				if !strings.Contains(down, want) {
+					t.Errorf("down migration missing %q:\n%s", want, down)
+				}
+			}
+		})
+	}
+}
+
+func TestMigrationSQLCreateTable(t *testing.T) {
+	changes, err := DiffSchema(&Schema{}, migrateSchema(t, "model Tag {\n  id: int @pk\n  name: string @unique\n}"))
+	if err != nil {
+		t.Fatalf("DiffSchema failed: %v", err)
+	}
+
+	tests := []struct {
+		provider string
+		want     []string
+	}{
+		{"sqlite", []string{`"id" integer PRIMARY KEY AUTOINCREMENT,`, `"name" text`}},
+		{"postgres", []string{`"id" bigserial,`, `PRIMARY KEY ("id")`}},
+		{"mysql", []string{"`id` bigint AUTO_INCREMENT,", "`name` varchar(191)", "CREATE UNIQUE INDEX `idx_tags_name` ON `tags` (`name`);"}},
+	}
+	for _, tt := range tests {
+		up, down := MigrationSQL(changes, tt.provider)
+		for _, want := range tt.want {
+			if !strings.Contains(up, want) {
+				t.Errorf("%s: up migration missing %q:\n%s", tt.provider, want, up)
+			}
+		}
+		if !strings.Contains(down, "DROP TABLE") {
+			t.Errorf("%s: the down migration should drop the table:\n%s", tt.provider, down)
+		}
+	}
+}
+
+func TestMigrationSQLIndexes(t *testing.T) {
+	src := strings.Replace(migrateScriptSrc, "  owner: User\n", "  owner: User\n  @@unique([title, done], name: \"idx_title_done\")\n", 1)
+	previous := migrateSchema(t, src)
+	// Renaming an indexed column recreates its index on the new name
+	current := migrateSchema(t, strings.Replace(strings.Replace(src, "title: string", `name: string @renamedFrom("title")`, 1), "[title, done]", "[name, done]", 1))
+	changes, err := DiffSchema(previous, current)
+	if err != nil {
+		t.Fatalf("DiffSchema failed: %v", err)
+	}
+
+	var names []string
+	for _, c := range changes {
+		names = append(names, c.String())
+	}
+	want := []string{"drop index idx_title_done", "rename column tasks.title to name", "add index idx_title_done"}
+	if strings.Join(names, "; ") != strings.Join(want, "; ") {
+		t.Fatalf("changes = %v, want %v", names, want)
+	}
+
+	tests := []struct {
+		provider string
+		up       []string
+		down     []string
+	}{
+		{"sqlite", []string{`DROP INDEX "idx_title_done";`, `CREATE UNIQUE INDEX IF NOT EXISTS "idx_title_done" ON "tasks" ("name", "done");`},
+			[]string{`CREATE UNIQUE INDEX IF NOT EXISTS "idx_title_done" ON "tasks" ("title", "done");`}},
+		{"mysql", []string{"DROP INDEX `idx_title_done` ON `tasks`;", "CREATE UNIQUE INDEX `idx_title_done` ON `tasks` (`name`, `done`);"},
+			[]string{"CREATE UNIQUE INDEX `idx_title_done` ON `tasks` (`title`, `done`);"}},
+	}
+	for _, tt := range tests {
+		up, down := MigrationSQL(changes, tt.provider)
+		for _, want := range tt.up {
+			if !strings.Contains(up, want) {
+				t.Errorf("%s: up migration missing %q:\n%s", tt.provider, want, up)
+			}
+		}
+		for _, want := range tt.down {
+			if !strings.Contains(down, want) {
+				t.Errorf("%s: down migration missing %q:\n%s", tt.provider, want, down)
+			}
+		}
+		// The index is recreated once its column has its previous name back
+		if strings.Index(down, "RENAME COLUMN") > strings.Index(down, "CREATE UNIQUE INDEX") {
+			t.Errorf("%s: the down migration should rename the column before recreating the index:\n%s", tt.provider, down)
+		}
+	}
+}
+
+func TestMigrationSQLEnums(t *testing.T) {
+	src := strings.Replace(migrateScriptSrc, "  owner: User\n", "  status: enum(pending, done) @default(pending)\n  owner: User\n", 1)
+	previous := migrateSchema(t, src)
+	if status := previous.table("Task").column("status"); status.Type != "string" || strings.Join(status.Values, ",") != "pending,done" {
+		t.Fatalf("expected a string column with the enum values, got %+v", status)
+	}
+	changes, err := DiffSchema(previous, migrateSchema(t, strings.Replace(src, "enum(pending, done)", "enum(pending, active, done)", 1)))
+	if err != nil {
+		t.Fatalf("DiffSchema failed: %v", err)
+	}
+	if len(changes) != 1 || changes[0].String() != "change values of tasks.status" || changes[0].Destructive() {
+		t.Fatalf("expected a change of the values, got %v", changes)
+	}
+
+	tests := []struct {
+		provider string
+		up       []string
+		down     []string
+	}{
+		{"sqlite", []string{`"status" text DEFAULT 'pending' CONSTRAINT "chk_tasks_status" CHECK ("status" IN ('pending','active','done'))`},
+			[]string{`CONSTRAINT "chk_tasks_status" CHECK ("status" IN ('pending','done'))`}},
+		{"postgres", []string{`ALTER TABLE "tasks" DROP CONSTRAINT "chk_tasks_status";`, `ALTER TABLE "tasks" ADD CONSTRAINT "chk_tasks_status" CHECK ("status" IN ('pending','active','done'));`},
+			[]string{`ALTER TABLE "tasks" ADD CONSTRAINT "chk_tasks_status" CHECK ("status" IN ('pending','done'));`}},
+		{"mysql", []string{"ALTER TABLE `tasks` DROP CHECK `chk_tasks_status`;", "ALTER TABLE `tasks` ADD CONSTRAINT `chk_tasks_status` CHECK (`status` IN ('pending','active','done'));"},
+			[]string{"ALTER TABLE `tasks` ADD CONSTRAINT `chk_tasks_status` CHECK (`status` IN ('pending','done'));"}},
+	}
+	for _, tt := range tests {
+		up, down := MigrationSQL(changes, tt.provider)
+		for _, want := range tt.up {
+			if !strings.Contains(up, want) {
+				t.Errorf("%s: up migration missing %q:\n%s", tt.provider, want, up)
+			}
+		}
+		for _, want := range tt.down {
+			if !strings.Contains(down, want) {
+				t.Errorf("%s: down migration missing %q:\n%s", tt.provider, want, down)
+			}
+		}
+		// Only the values changed: the default stays
+		if strings.Contains(up, "DROP DEFAULT") {
+			t.Errorf("%s: the default should be left alone:\n%s", tt.provider, up)
+		}
+	}
+}
+
+func TestMigrationName(t *testing.T) {
+	previous := migrateSchema(t, migrateScriptSrc)
+	changes, _ := DiffSchema(previous, migrateSchema(t, strings.Replace(migrateScriptSrc, "title: string", "title: string @unique", 1)))
+	if got := MigrationName(2, changes); got != "add_unique_tasks_title" {
+		t.Errorf("MigrationName = %q", got)
+	}
+	if got := MigrationName(1, changes); got != "init" {
+		t.Errorf("the first migration should be init, got %q", got)
+	}
+}
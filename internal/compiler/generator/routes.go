@@ -0,0 +1,179 @@
+package generator
+
+import (
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/resolver"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+	"sort"
+	"strings"
+)
+
+// anyMethod is the Method of routes whose handler accepts every HTTP method
+const anyMethod = "*"
+
+// Route is an endpoint registered by the generated server
+type Route struct {
+	Method  string // GET, POST, PATCH, DELETE, or "*" for any method
+	Path    string
+	Handler string // generated handler function
+	Source  string // origin: "page", "func toggleTask", "template route", "built-in"
+	Line    int    // .gmx line of the script function, 0 for other routes
+}
+
+// Routes returns the route table the generated server registers, sorted by path
+func (g *Generator) Routes(resolved *resolver.ResolvedFile) []Route {
+	file := resolved.Main
+	// An invalid #if condition fails generation; the table then lists every declaration
+	if selected, err := g.selectCompileTime(file); err == nil {
+		file = selected
+	}
+	routes := make(map[string]string)
+	if file.Template != nil {
+		routes = g.genRouteRegistry(file.Template.Source)
+	}
+	g.applyRouteGroups(file, routes)
+	return g.routeTable(file, routes, g.bundleStyles(file, resolved.Components) != "")
+}
+
+// routePath returns the path a script function is served at: /api/<name>,
+// or <prefix>/<name> inside a route group
+func routePath(fn *ast.FuncDecl) string {
+	if fn.RoutePrefix != "" {
+		return fn.RoutePrefix + "/" + fn.Name
+	}
+	return "/api/" + fn.Name
+}
+
+// applyRouteGroups moves the {{route}} paths of grouped functions under
+// their group prefix
+func (g *Generator) applyRouteGroups(file *ast.GMXFile, routes map[string]string) {
+	if file.Script == nil {
+		return
+	}
+	for _, fn := range file.Script.Funcs {
+		if _, ok := routes[fn.Name]; ok && fn.RoutePrefix != "" {
+			routes[fn.Name] = routePath(fn)
+		}
+	}
+}
+
+// routeTable lists the routes registered by genMain: the pages, the routes
+// referenced by {{route}}, the script functions, then the built-in endpoints
+func (g *Generator) routeTable(file *ast.GMXFile, routes map[string]string, hasStyleBundle bool) []Route {
+	table := map[string]Route{}
+	if len(file.Pages) > 0 {
+		for _, page := range file.Pages {
+			table[page.Route] = Route{Method: anyMethod, Path: page.Route, Handler: pageHandler(page), Source: "page"}
+		}
+	} else {
+		table["/"] = Route{Method: anyMethod, Path: "/", Handler: "handleIndex", Source: "page"}
+	}
+
+	// Script functions returning error are handlers, except @job functions;
+	// other return types are utilities
+	handlers := make(map[string]*ast.FuncDecl)
+	if file.Script != nil {
+		for _, fn := range file.Script.Funcs {
+			if isHandler(fn) {
+				handlers[fn.Name] = fn
+			}
+		}
+	}
+
+	// Template routes without a script function are served by a stub handler
+	for routeName, path := range routes {
+		route := Route{Method: anyMethod, Path: path, Handler: "handle" + utils.Capitalize(routeName), Source: "template route"}
+		if fn, ok := handlers[routeName]; ok {
+			route.Method = strings.ToUpper(handlerMethod(fn))
+			route.Source, route.Line = "func "+fn.Name, fn.Line
+		}
+		table[path] = route
+	}
+
+	// Script functions not referenced by the template get a default route
+	for name, fn := range handlers {
+		if _, found := routes[name]; found {
+			continue
+		}
+		path := routePath(fn)
+		table[path] = Route{
+			Method:  strings.ToUpper(handlerMethod(fn)),
+			Path:    path,
+			Handler: "handle" + utils.Capitalize(name),
+			Source:  "func " + name,
+			Line:    fn.Line,
+		}
+	}
+
+	var builtins []Route
+	if g.hasImpersonation(file) {
+		builtins = append(builtins, impersonationRoutes...)
+	}
+	if g.hasTwoFactor(file) {
+		builtins = append(builtins, twoFactorRoutes...)
+	}
+	if g.hasAccounts(file) {
+		builtins = append(builtins, accountRoutes...)
+	}
+	if g.hasPersonalData(file) {
+		builtins = append(builtins, personalDataRoutes...)
+	}
+	if g.hasConsent(file) {
+		builtins = append(builtins, consentRoutes...)
+	}
+	if g.hasDatabaseStandby(file) && g.hasImpersonation(file) {
+		builtins = append(builtins, Route{Method: "POST", Path: dbSwitchoverPath, Handler: "handleDatabaseSwitchover"})
+	}
+	if g.hasSettingsAdmin(file) {
+		builtins = append(builtins,
+			Route{Method: "GET", Path: settingsAdminPath, Handler: "handleSettingsAdmin"},
+			Route{Method: "POST", Path: settingsSavePath, Handler: "handleSettingsSave"})
+	}
+	if g.hasNotifications(file) {
+		builtins = append(builtins, notificationRoutes...)
+	}
+	if g.hasActivityFeed(file) {
+		builtins = append(builtins, Route{Method: "GET", Path: feedPath, Handler: "handleActivityFeed"})
+	}
+	builtins = append(builtins, typeaheadRoutes(file)...)
+	builtins = append(builtins, liveRoutes(file)...)
+	if g.hasModal(file) {
+		builtins = append(builtins, modalRoutes...)
+	}
+	builtins = append(builtins, wizardRoutes(file)...)
+	builtins = append(builtins, g.autosaveRoutes(file)...)
+	builtins = append(builtins, g.jobRoutes(file)...)
+	builtins = append(builtins, imageRoutes(file)...)
+	builtins = append(builtins, storageRoutes(file)...)
+	if g.hasDevMail(file) {
+		builtins = append(builtins, Route{Method: "GET", Path: devMailPath, Handler: "handleDevMail"})
+	}
+	if g.opts.Dev {
+		builtins = append(builtins, Route{Method: "GET", Path: pprofPath, Handler: "handlePprof"})
+	}
+	if g.hasChaos(file) {
+		builtins = append(builtins,
+			Route{Method: "GET", Path: chaosPath, Handler: "handleChaos"},
+			Route{Method: "POST", Path: chaosSavePath, Handler: "handleChaosSave"})
+	}
+	if g.findLoadShedService(file.Services) != nil {
+		builtins = append(builtins, Route{Method: "GET", Path: healthPath, Handler: "handleHealth"})
+	}
+	if hasStyleBundle {
+		builtins = append(builtins, Route{Method: "GET", Path: appCSSPath, Handler: "handleAppCSS"})
+	}
+	if g.hasMetrics(file) {
+		builtins = append(builtins, Route{Method: "GET", Path: metricsPath, Handler: "handleMetrics"})
+	}
+	for _, route := range builtins {
+		route.Source = "built-in"
+		table[route.Path] = route
+	}
+
+	sorted := make([]Route, 0, len(table))
+	for _, route := range table {
+		sorted = append(sorted, route)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+	return sorted
+}
@@ -0,0 +1,80 @@
+package generator
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/resolver"
+)
+
+func TestRoutes(t *testing.T) {
+	file := &ast.GMXFile{
+		Models: []*ast.ModelDecl{{Name: "Task"}},
+		Script: &ast.ScriptBlock{Funcs: []*ast.FuncDecl{
+			{Name: "toggleTask", ReturnType: "error", Line: 12},
+			{Name: "listTasks", Line: 20},
+			{Name: "formatTitle", ReturnType: "string", Line: 30},
+		}},
+		Template: &ast.TemplateBlock{Source: "<a hx-patch=\"{{route `toggleTask`}}\"></a><a href=\"{{route `about`}}\"></a>"},
+	}
+
+	routes := New().Routes(&resolver.ResolvedFile{Main: file})
+	want := []Route{
+		{Method: "*", Path: "/", Handler: "handleIndex", Source: "page"},
+		{Method: "*", Path: "/api/about", Handler: "handleAbout", Source: "template route"},
+		{Method: "GET", Path: "/api/listTasks", Handler: "handleListTasks", Source: "func listTasks", Line: 20},
+		{Method: "PATCH", Path: "/api/toggleTask", Handler: "handleToggleTask", Source: "func toggleTask", Line: 12},
+	}
+	if !reflect.DeepEqual(routes, want) {
+		t.Errorf("Routes() =\n%+v\nwant\n%+v", routes, want)
+	}
+}
+
+func TestRoutesBuiltins(t *testing.T) {
+	file := &ast.GMXFile{
+		Services: []*ast.ServiceDecl{{Name: "Mailer", Provider: "smtp", Methods: []*ast.ServiceMethod{{Name: "send", ReturnType: "error"}}}},
+	}
+
+	routes := NewWithOptions(Options{Dev: true}).Routes(&resolver.ResolvedFile{Main: file})
+	for _, path := range []string{devMailPath, pprofPath} {
+		var found bool
+		for _, route := range routes {
+			if route.Path == path {
+				found = true
+				if route.Source != "built-in" || route.Method != "GET" {
+					t.Errorf("%s route = %+v, want a GET built-in", path, route)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("Routes() = %+v, want %s in dev builds", routes, path)
+		}
+	}
+
+	for _, route := range New().Routes(&resolver.ResolvedFile{Main: file}) {
+		if route.Path == pprofPath {
+			t.Errorf("Routes() lists %s in a production build", pprofPath)
+		}
+	}
+}
+
+func TestRoutesGroups(t *testing.T) {
+	file := &ast.GMXFile{
+		Script: &ast.ScriptBlock{Funcs: []*ast.FuncDecl{
+			{Name: "listUsers", RoutePrefix: "/admin", Line: 5},
+			{Name: "deleteUser", RoutePrefix: "/admin", Line: 9},
+		}},
+		Template: &ast.TemplateBlock{Source: "<a hx-get=\"{{route `listUsers`}}\"></a>"},
+	}
+
+	routes := New().Routes(&resolver.ResolvedFile{Main: file})
+	want := []Route{
+		{Method: "*", Path: "/", Handler: "handleIndex", Source: "page"},
+		{Method: "DELETE", Path: "/admin/deleteUser", Handler: "handleDeleteUser", Source: "func deleteUser", Line: 9},
+		{Method: "GET", Path: "/admin/listUsers", Handler: "handleListUsers", Source: "func listUsers", Line: 5},
+	}
+	if !reflect.DeepEqual(routes, want) {
+		t.Errorf("Routes() =\n%+v\nwant\n%+v", routes, want)
+	}
+}
@@ -0,0 +1,175 @@
+package generator
+
+import (
+	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	gmxerrors "github.com/btouchard/gmx/internal/compiler/errors"
+	"sort"
+	"strings"
+)
+
+// validateStrict reports the behaviors the generator would otherwise add
+// implicitly: declarations nothing uses, routes created for script functions
+// the template does not reference, the fallback SQLite database, and model
+// saves that skip Validate()
+func (g *Generator) validateStrict(file *ast.GMXFile) error {
+	errs := gmxerrors.NewErrorList()
+	report := func(line int, format string, args ...any) {
+		errs.Add(gmxerrors.Position{File: g.opts.Source, Line: line}, "strict", fmt.Sprintf(format, args...))
+	}
+
+	if len(file.Models) > 0 && g.findDatabaseService(file.Services) == nil {
+		report(file.Models[0].Line, "no Database service: the app falls back to the SQLite file %s; declare a service Database", FallbackSQLitePath)
+	}
+
+	if file.Script != nil {
+		g.strictUnused(file, report)
+		g.strictImplicitRoutes(file, report)
+		g.strictValidate(file, report)
+	}
+
+	if errs.HasErrors() {
+		sort.SliceStable(errs.Errors, func(i, j int) bool { return errs.Errors[i].Pos.Line < errs.Errors[j].Pos.Line })
+		return errs
+	}
+	return nil
+}
+
+// strictUnused reports top-level variables and helper functions never
+// referenced by the script, and local variables never read in their function
+func (g *Generator) strictUnused(file *ast.GMXFile, report func(int, string, ...any)) {
+	refs := make(map[string]int)
+	countRefs := func(node ast.Node) bool {
+		if ident, ok := node.(*ast.Ident); ok {
+			refs[ident.Name]++
+		}
+		return true
+	}
+	for _, fn := range file.Script.Funcs {
+		ast.Inspect(fn, countRefs)
+	}
+	for _, v := range file.Script.Vars {
+		ast.Inspect(v, countRefs)
+	}
+	for _, svc := range file.Services {
+		for _, method := range svc.Methods {
+			for _, stmt := range method.Body {
+				ast.Inspect(stmt, countRefs)
+			}
+		}
+	}
+
+	for _, v := range file.Script.Vars {
+		if refs[v.Name] == 0 {
+			report(v.Line, "%s is declared but never used", v.Name)
+		}
+	}
+	for _, fn := range file.Script.Funcs {
+		// Handlers are used by their route; other return types are helpers
+		if fn.ReturnType != "" && fn.ReturnType != "error" && refs[fn.Name] == 0 {
+			report(fn.Line, "func %s returns %s but is never called", fn.Name, fn.ReturnType)
+		}
+		if fn.FindAnnotation("job") != nil && refs[fn.Name] == 0 {
+			report(fn.Line, "func %s is a @job function but is never enqueued", fn.Name)
+		}
+
+		locals := make(map[string]int)
+		var lets []*ast.LetStmt
+		ast.Inspect(fn, func(node ast.Node) bool {
+			switch n := node.(type) {
+			case *ast.LetStmt:
+				lets = append(lets, n)
+			case *ast.Ident:
+				locals[n.Name]++
+			}
+			return true
+		})
+		for _, let := range lets {
+			if locals[let.Name] == 0 {
+				report(let.Line, "variable %s of func %s is declared but never used", let.Name, fn.Name)
+			}
+		}
+	}
+}
+
+// strictImplicitRoutes reports handlers the template never links to with
+// {{route}}: the generator would still expose them at /api/<name>, or under
+// their route group prefix
+func (g *Generator) strictImplicitRoutes(file *ast.GMXFile, report func(int, string, ...any)) {
+	routes := make(map[string]string)
+	if file.Template != nil {
+		routes = g.genRouteRegistry(file.Template.Source)
+	}
+	for _, fn := range file.Script.Funcs {
+		if !isHandler(fn) {
+			continue
+		}
+		if _, ok := routes[fn.Name]; !ok {
+			report(fn.Line, "func %s is not referenced by {{route `%s`}}: it would be exposed implicitly at %s %s",
+				fn.Name, fn.Name, strings.ToUpper(handlerMethod(fn)), routePath(fn))
+		}
+	}
+}
+
+// strictValidate reports save() calls on models with validation rules when
+// the saved variable was not validated earlier in the function
+func (g *Generator) strictValidate(file *ast.GMXFile, report func(int, string, ...any)) {
+	validated := make(map[string]bool)
+	for _, model := range file.Models {
+		validated[model.Name] = g.genValidation(model) != ""
+	}
+
+	for _, fn := range file.Script.Funcs {
+		varTypes := make(map[string]string)
+		for _, param := range fn.Params {
+			varTypes[param.Name] = param.Type
+		}
+		checked := make(map[string]bool)
+
+		ast.Inspect(fn, func(node ast.Node) bool {
+			switch n := node.(type) {
+			case *ast.LetStmt:
+				if typ := modelTypeOf(n.Value); typ != "" {
+					varTypes[n.Name] = typ
+				}
+			case *ast.CallExpr:
+				member, ok := n.Function.(*ast.MemberExpr)
+				if !ok {
+					return true
+				}
+				obj, ok := member.Object.(*ast.Ident)
+				if !ok {
+					return true
+				}
+				switch member.Property {
+				case "validate":
+					checked[obj.Name] = true
+				case "save":
+					if typ := varTypes[obj.Name]; validated[typ] && !checked[obj.Name] {
+						report(n.Line, "%s.save() in func %s skips the %s validation rules; call try %s.validate() first", obj.Name, fn.Name, typ, obj.Name)
+					}
+				}
+			}
+			return true
+		})
+	}
+}
+
+// modelTypeOf returns the model built or loaded by a let value:
+// Task{...}, Task.find(id) or try Task.find(id)
+func modelTypeOf(expr ast.Expression) string {
+	if try, ok := expr.(*ast.TryExpr); ok {
+		expr = try.Expr
+	}
+	switch e := expr.(type) {
+	case *ast.StructLit:
+		return e.TypeName
+	case *ast.CallExpr:
+		if member, ok := e.Function.(*ast.MemberExpr); ok && member.Property == "find" {
+			if ident, ok := member.Object.(*ast.Ident); ok {
+				return ident.Name
+			}
+		}
+	}
+	return ""
+}
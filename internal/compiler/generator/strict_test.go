@@ -0,0 +1,124 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/script"
+)
+
+// strictFile parses a script and wraps it with a template into a GMXFile
+func strictFile(t *testing.T, src, tmpl string) *ast.GMXFile {
+	t.Helper()
+	parsed, errs := script.Parse(src, 0)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	return &ast.GMXFile{
+		Models:   parsed.Models,
+		Services: parsed.Services,
+		Script:   &ast.ScriptBlock{Vars: parsed.Vars, Funcs: parsed.Funcs},
+		Template: &ast.TemplateBlock{Source: tmpl},
+	}
+}
+
+const strictModels = `model Task {
+  id: uuid @pk @default(uuid_v4)
+  title: string @min(3)
+}
+service Database {
+  provider: "sqlite"
+  url: string @env("DATABASE_URL")
+}
+`
+
+func TestValidateStrict(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		tmpl   string
+		want   string
+	}{
+		{
+			"unused variable",
+			"let APP_NAME = \"demo\"",
+			"",
+			"[strict] app.gmx:9: APP_NAME is declared but never used",
+		},
+		{
+			"unused local",
+			"func listTasks() error {\n  let tasks = try Task.all()\n  let count = 0\n  return render(tasks)\n}",
+			"{{route `listTasks`}}",
+			"[strict] app.gmx:11: variable count of func listTasks is declared but never used",
+		},
+		{
+			"helper never called",
+			"func label(t: Task) string {\n  return t.title\n}",
+			"",
+			"[strict] app.gmx:9: func label returns string but is never called",
+		},
+		{
+			"job never enqueued",
+			"@job\nfunc archiveTasks() error {\n  return nil\n}",
+			"",
+			"[strict] app.gmx:10: func archiveTasks is a @job function but is never enqueued",
+		},
+		{
+			"implicit route",
+			"func deleteTask(id: uuid) error {\n  let task = try Task.find(id)\n  try task.delete()\n  return nil\n}",
+			"",
+			"[strict] app.gmx:9: func deleteTask is not referenced by {{route `deleteTask`}}: it would be exposed implicitly at DELETE /api/deleteTask",
+		},
+		{
+			"save without validate",
+			"func updateTask(id: uuid, title: string) error {\n  let task = try Task.find(id)\n  task.title = title\n  try task.save()\n  return nil\n}",
+			"{{route `updateTask`}}",
+			"[strict] app.gmx:12: task.save() in func updateTask skips the Task validation rules; call try task.validate() first",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := strictFile(t, strictModels+tt.script, tt.tmpl)
+			err := NewWithOptions(Options{Strict: true, Source: "app.gmx"}).validateStrict(file)
+			if err == nil {
+				t.Fatalf("expected %q, got no error", tt.want)
+			}
+			if err.Error() != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateStrictFallbackDatabase(t *testing.T) {
+	file := strictFile(t, "model Task {\n  id: uuid @pk\n}", "")
+	err := NewWithOptions(Options{Strict: true, Source: "app.gmx"}).validateStrict(file)
+	if err == nil || !strings.Contains(err.Error(), "app.gmx:1: no Database service: the app falls back to the SQLite file gmx.db") {
+		t.Errorf("expected a fallback database error, got %v", err)
+	}
+}
+
+func TestValidateStrictExplicitApp(t *testing.T) {
+	src := strictModels + `let maxTitle = 10
+func createTask(title: string) error {
+  if len(title) > maxTitle {
+    return error("title too long")
+  }
+  const task = Task{title: title}
+  try task.validate()
+  try task.save()
+  return render(task)
+}`
+	file := strictFile(t, src, "<form hx-post=\"{{route `createTask`}}\"></form>")
+	if err := NewWithOptions(Options{Strict: true}).validateStrict(file); err != nil {
+		t.Errorf("expected no strict errors, got:\n%v", err)
+	}
+
+	// Without -strict the same implicit app still compiles
+	file = strictFile(t, strictModels+"let unused = 1", "")
+	if _, err := New().Generate(file); err != nil && strings.Contains(err.Error(), "[strict]") {
+		t.Errorf("strict checks ran without Options.Strict: %v", err)
+	}
+}
@@ -126,7 +126,8 @@ model Post {
 		"db.AutoMigrate(&User{}, &Post{})",
 		"mux.HandleFunc(\"/\", handleIndex)",
 		"mux.HandleFunc(\"/api/createPost\", handleCreatePost)",
-		"http.ListenAndServe(\":8080\"",
+		"srv := &http.Server{Addr: \":8080\"",
+		"serveUntilSignal(srv, srv.ListenAndServe, 10*time.Second)",
 	}
 
 	for _, expected := range expectedElements {
@@ -496,8 +497,8 @@ func createTask(title: string) error {
 	expectedElements := []string{
 		"package main",
 		"// ========== GMX Imports ==========",
-		"// TODO: Component import: TaskItem from ./components/TaskItem.gmx",
-		"// TODO: Destructured import: sendEmail, MailerConfig from ./services/mailer.gmx",
+		"// Component import: TaskItem from ./components/TaskItem.gmx",
+		"// Destructured import: sendEmail, MailerConfig from ./services/mailer.gmx",
 		"// Native Go import: github.com/stripe/stripe-go as Stripe",
 		`Stripe "github.com/stripe/stripe-go"`,
 		"type Task struct",
@@ -0,0 +1,92 @@
+// Package lang describes the versions of the .gmx language and the syntax
+// each version introduces.
+//
+// A file selects its version with a pragma before its first section:
+//
+//	gmx 1.0
+//
+// Files without a pragma use Default, so existing files keep compiling when
+// a later version adds syntax. Syntax introduced by a version is gated: the
+// parser rejects it in files declaring an older version.
+package lang
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a language version, major.minor
+type Version struct {
+	Major int
+	Minor int
+}
+
+// Default is the version of files without a gmx pragma
+var Default = Version{Major: 1, Minor: 0}
+
+// Latest is the newest version this compiler understands
+var Latest = Version{Major: 1, Minor: 1}
+
+// Features maps the syntax gated by a version to the version introducing it
+var Features = map[string]Version{
+	"saga blocks":          {Major: 1, Minor: 1},
+	"policy blocks":        {Major: 1, Minor: 1},
+	"duration literals":    {Major: 1, Minor: 1},
+	"settings":             {Major: 1, Minor: 1},
+	"for loops":            {Major: 1, Minor: 1},
+	"named arguments":      {Major: 1, Minor: 1},
+	"wizards":              {Major: 1, Minor: 1},
+	"server blocks":        {Major: 1, Minor: 1},
+	"enqueue statements":   {Major: 1, Minor: 1},
+	"remember blocks":      {Major: 1, Minor: 1},
+	"observability blocks": {Major: 1, Minor: 1},
+}
+
+// Parse reads a "major.minor" version
+func Parse(s string) (Version, error) {
+	major, minor, ok := strings.Cut(s, ".")
+	if !ok {
+		return Version{}, fmt.Errorf("invalid gmx version %q (expected major.minor, e.g. %s)", s, Latest)
+	}
+	majorN, errMaj := strconv.Atoi(major)
+	minorN, errMin := strconv.Atoi(minor)
+	if errMaj != nil || errMin != nil || majorN < 0 || minorN < 0 {
+		return Version{}, fmt.Errorf("invalid gmx version %q (expected major.minor, e.g. %s)", s, Latest)
+	}
+	return Version{Major: majorN, Minor: minorN}, nil
+}
+
+// Supported reports an error when v is newer than the compiler or older
+// than the first language version
+func (v Version) Supported() error {
+	if Latest.Less(v) {
+		return fmt.Errorf("gmx %s requires a newer compiler (this compiler supports up to gmx %s)", v, Latest)
+	}
+	if v.Less(Default) {
+		return fmt.Errorf("unknown gmx version %s (the first version is gmx %s)", v, Default)
+	}
+	return nil
+}
+
+// Less reports whether v is older than other
+func (v Version) Less(other Version) bool {
+	if v.Major != other.Major {
+		return v.Major < other.Major
+	}
+	return v.Minor < other.Minor
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// Require reports an error when a file declaring v uses a gated feature
+// introduced by a later version
+func (v Version) Require(feature string) error {
+	since, ok := Features[feature]
+	if !ok || !v.Less(since) {
+		return nil
+	}
+	return fmt.Errorf("%s require gmx %s: declare `gmx %s` at the top of the file", feature, since, since)
+}
@@ -0,0 +1,72 @@
+package lang
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Version
+		wantErr bool
+	}{
+		{"1.0", Version{1, 0}, false},
+		{"1.12", Version{1, 12}, false},
+		{"2.3", Version{2, 3}, false},
+		{"1", Version{}, true},
+		{"1.x", Version{}, true},
+		{"-1.0", Version{}, true},
+		{"", Version{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := Parse(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Parse(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSupported(t *testing.T) {
+	if err := Default.Supported(); err != nil {
+		t.Errorf("default version should be supported: %v", err)
+	}
+	if err := Latest.Supported(); err != nil {
+		t.Errorf("latest version should be supported: %v", err)
+	}
+
+	newer := Version{Latest.Major, Latest.Minor + 1}
+	err := newer.Supported()
+	if err == nil {
+		t.Fatalf("gmx %s should require a newer compiler", newer)
+	}
+	if want := "gmx " + newer.String() + " requires a newer compiler (this compiler supports up to gmx " + Latest.String() + ")"; err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+
+	if err := (Version{0, 9}).Supported(); err == nil {
+		t.Error("gmx 0.9 should be rejected")
+	}
+}
+
+func TestRequire(t *testing.T) {
+	saved := Features
+	defer func() { Features = saved }()
+	Features = map[string]Version{"for loops": {1, 1}}
+
+	if err := (Version{1, 0}).Require("for loops"); err == nil || err.Error() != "for loops require gmx 1.1: declare `gmx 1.1` at the top of the file" {
+		t.Errorf("gmx 1.0 error = %v", err)
+	}
+	if err := (Version{1, 1}).Require("for loops"); err != nil {
+		t.Errorf("gmx 1.1 should allow for loops: %v", err)
+	}
+	if err := (Version{2, 0}).Require("for loops"); err != nil {
+		t.Errorf("gmx 2.0 should allow for loops: %v", err)
+	}
+	if err := (Version{1, 0}).Require("ungated syntax"); err != nil {
+		t.Errorf("ungated syntax should be allowed: %v", err)
+	}
+}
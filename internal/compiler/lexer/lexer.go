@@ -23,6 +23,10 @@ func New(input string) *Lexer {
 		line:   1,
 		column: 0,
 	}
+	// Editors may save files with a UTF-8 byte order mark: skip it
+	if strings.HasPrefix(input, "\uFEFF") {
+		l.readPosition = len("\uFEFF")
+	}
 	l.readChar()
 	return l
 }
@@ -166,6 +170,8 @@ func (l *Lexer) NextToken() token.Token {
 		tok = l.makeToken(token.RBRACKET, string(l.ch))
 	case '@':
 		tok = l.makeToken(token.AT, string(l.ch))
+	case '#':
+		tok = l.makeToken(token.HASH, string(l.ch))
 	case '"':
 		tok.Type = token.STRING
 		tok.Literal = l.readString()
@@ -260,6 +266,7 @@ func (l *Lexer) trySectionTag() token.Token {
 			tempPos := l.position
 			tempReadPos := l.readPosition
 			tempCh := l.ch
+			tempCol := l.column
 			for isLetter(l.ch) {
 				scopedWord += string(l.ch)
 				l.readChar()
@@ -271,6 +278,7 @@ func (l *Lexer) trySectionTag() token.Token {
 				l.position = tempPos
 				l.readPosition = tempReadPos
 				l.ch = tempCh
+				l.column = tempCol
 			}
 		}
 	default:
@@ -298,6 +306,12 @@ func (l *Lexer) trySectionTag() token.Token {
 	}
 	l.readChar() // consume '>'
 
+	// The content is trimmed: skip its leading whitespace to locate its first line
+	for unicode.IsSpace(l.ch) {
+		l.readChar()
+	}
+	contentPos := l.currentPos()
+
 	// Now read everything until we find the closing tag
 	pos := token.Position{Line: savedLine, Column: savedCol, Offset: savedPos}
 
@@ -309,9 +323,10 @@ func (l *Lexer) trySectionTag() token.Token {
 	}
 
 	return token.Token{
-		Type:    tokType,
-		Literal: content,
-		Pos:     pos,
+		Type:       tokType,
+		Literal:    content,
+		Pos:        pos,
+		ContentPos: contentPos,
 	}
 }
 
@@ -389,8 +404,9 @@ func (l *Lexer) skipWhitespaceAndComments() {
 func (l *Lexer) readIdentifier() string {
 	start := l.position
 
-	// Read characters while they are letters, digits, underscores, or hyphens
-	for isLetter(l.ch) || isDigit(l.ch) || l.ch == '-' {
+	// Read characters while they are letters, digits, underscores, or hyphens;
+	// like Go, identifiers may contain any Unicode letter or digit (tâche, 名前)
+	for isLetter(l.ch) || unicode.IsDigit(l.ch) || l.ch == '-' {
 		l.readChar()
 	}
 
@@ -461,6 +477,7 @@ func isLetter(ch rune) bool {
 	return unicode.IsLetter(ch) || ch == '_'
 }
 
+// isDigit only accepts ASCII digits: number literals must stay valid Go
 func isDigit(ch rune) bool {
-	return unicode.IsDigit(ch)
+	return '0' <= ch && ch <= '9'
 }
@@ -47,10 +47,10 @@ func TestMultiCharOperators(t *testing.T) {
 }
 
 func TestKeywords(t *testing.T) {
-	input := `func let const if else return true false model service task import as`
+	input := `func let const if else for return true false model service task import as`
 
 	expected := []token.TokenType{
-		token.FUNC, token.LET, token.CONST, token.IF, token.ELSE,
+		token.FUNC, token.LET, token.CONST, token.IF, token.ELSE, token.FOR,
 		token.RETURN, token.TRUE, token.FALSE, token.MODEL, token.SERVICE,
 		token.TASK, token.IMPORT, token.AS,
 	}
@@ -1305,3 +1305,102 @@ body { color: red; }
 		t.Errorf("expected non-scoped style, got SCOPED prefix")
 	}
 }
+
+func TestLexUnicodeIdentifiers(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"tâche", "tâche"},
+		{"État", "État"},
+		{"名前", "名前"},
+		{"prix_ht2", "prix_ht2"},
+		{"été٣", "été٣"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tok := New(tt.input + " x").NextToken()
+			if tok.Type != token.IDENT || tok.Literal != tt.want {
+				t.Errorf("expected IDENT %q, got %s %q", tt.want, tok.Type, tok.Literal)
+			}
+		})
+	}
+}
+
+func TestLexMultiByteStrings(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"accents", `"Tâche terminée"`, "Tâche terminée"},
+		{"emoji", `"✅ fait 🎉"`, "✅ fait 🎉"},
+		{"escaped quote", `"dit \"bonjour\" 👋"`, `dit \"bonjour\" 👋`},
+		{"backtick", "`日本語`", "日本語"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tok := New(tt.input).NextToken()
+			if tok.Type != token.STRING || tok.Literal != tt.want {
+				t.Errorf("expected STRING %q, got %s %q", tt.want, tok.Type, tok.Literal)
+			}
+		})
+	}
+}
+
+func TestLexColumnsCountRunes(t *testing.T) {
+	input := "let été = \"🎉🎉\" + x\nlet é = 1"
+
+	expected := []struct {
+		lit  string
+		line int
+		col  int
+	}{
+		{"let", 1, 1}, {"été", 1, 5}, {"=", 1, 9}, {"🎉🎉", 1, 11}, {"+", 1, 16}, {"x", 1, 18},
+		{"let", 2, 1}, {"é", 2, 5}, {"=", 2, 7}, {"1", 2, 9},
+	}
+
+	l := New(input)
+	for i, exp := range expected {
+		tok := l.NextToken()
+		if tok.Literal != exp.lit || tok.Pos.Line != exp.line || tok.Pos.Column != exp.col {
+			t.Errorf("test[%d] - expected %q at %d:%d, got %q at %d:%d",
+				i, exp.lit, exp.line, exp.col, tok.Literal, tok.Pos.Line, tok.Pos.Column)
+		}
+	}
+}
+
+func TestLexEmojiOutsideStringIsIllegal(t *testing.T) {
+	l := New("let 🎉 = 1")
+	l.NextToken()
+	tok := l.NextToken()
+	if tok.Type != token.ILLEGAL || tok.Literal != "🎉" {
+		t.Fatalf("expected ILLEGAL 🎉, got %s %q", tok.Type, tok.Literal)
+	}
+	if tok.Pos.Column != 5 {
+		t.Errorf("expected column 5, got %d", tok.Pos.Column)
+	}
+	if next := l.NextToken(); next.Type != token.ASSIGN {
+		t.Errorf("expected lexing to resume at '=', got %s %q", next.Type, next.Literal)
+	}
+}
+
+func TestLexNonASCIIDigitsAreNotNumbers(t *testing.T) {
+	tok := New("٣").NextToken()
+	if tok.Type == token.INT {
+		t.Errorf("expected Arabic-Indic digit not to lex as INT, got %q", tok.Literal)
+	}
+}
+
+func TestLexSkipsByteOrderMark(t *testing.T) {
+	l := New("\uFEFFmodel Task")
+	tok := l.NextToken()
+	if tok.Type != token.MODEL {
+		t.Fatalf("expected MODEL, got %s %q", tok.Type, tok.Literal)
+	}
+	if tok.Pos.Line != 1 || tok.Pos.Column != 1 {
+		t.Errorf("expected position 1:1, got %d:%d", tok.Pos.Line, tok.Pos.Column)
+	}
+}
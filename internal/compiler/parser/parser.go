@@ -3,6 +3,7 @@ package parser
 import (
 	"fmt"
 	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/lang"
 	"github.com/btouchard/gmx/internal/compiler/lexer"
 	"github.com/btouchard/gmx/internal/compiler/script"
 	"github.com/btouchard/gmx/internal/compiler/token"
@@ -78,20 +79,24 @@ func (p *Parser) synchronize() {
 // ParseGMXFile is the main entry point for parsing a .gmx file
 func (p *Parser) ParseGMXFile() *ast.GMXFile {
 	file := &ast.GMXFile{
+		Version:  lang.Default,
 		Models:   []*ast.ModelDecl{},
 		Services: []*ast.ServiceDecl{},
 		Vars:     []*ast.VarDecl{},
 	}
+	seenSection := false
+	seenPragma := false
 
 	// Parse all sections
 	for !p.curTokenIs(token.EOF) {
 		switch p.curToken.Type {
 		case token.RAW_GO:
+			seenSection = true
 			source := p.curToken.Literal
-			lineOffset := p.curToken.Pos.Line
+			lineOffset := p.curToken.ContentPos.Line - 1
 
 			// Parse the script using enhanced script parser
-			result, parseErrors := script.Parse(source, lineOffset)
+			result, parseErrors := script.ParseVersion(source, lineOffset, file.Version)
 
 			scriptBlock := &ast.ScriptBlock{
 				Source:    source,
@@ -110,21 +115,29 @@ func (p *Parser) ParseGMXFile() *ast.GMXFile {
 
 			file.Script = scriptBlock
 
-			// Extract imports, models, services, and vars to top-level for generator compatibility
+			// Extract imports, models, services, vars, and settings to top-level for generator compatibility
 			file.Imports = append(file.Imports, result.Imports...)
 			file.Models = append(file.Models, result.Models...)
 			file.Services = append(file.Services, result.Services...)
 			file.Vars = append(file.Vars, result.Vars...)
+			file.Settings = append(file.Settings, result.Settings...)
+			file.Wizards = append(file.Wizards, result.Wizards...)
+			file.Server = result.Server
+			file.Observability = result.Observability
 
 			p.nextToken()
 
 		case token.RAW_TEMPLATE:
+			seenSection = true
 			file.Template = &ast.TemplateBlock{
-				Source: p.curToken.Literal,
+				Source:      p.curToken.Literal,
+				StartLine:   p.curToken.ContentPos.Line - 1,
+				StartColumn: p.curToken.ContentPos.Column - 1,
 			}
 			p.nextToken()
 
 		case token.RAW_STYLE:
+			seenSection = true
 			content := p.curToken.Literal
 			scoped := false
 			// Check for SCOPED: prefix
@@ -133,8 +146,21 @@ func (p *Parser) ParseGMXFile() *ast.GMXFile {
 				content = content[len("SCOPED:"):]
 			}
 			file.Style = &ast.StyleBlock{
-				Source: content,
-				Scoped: scoped,
+				Source:    content,
+				Scoped:    scoped,
+				StartLine: p.curToken.ContentPos.Line - 1,
+			}
+			p.nextToken()
+
+		case token.IDENT:
+			if p.curToken.Literal == "gmx" {
+				if seenPragma {
+					p.addError("duplicate gmx version pragma")
+				} else if seenSection {
+					p.addError("the gmx version pragma must come before the first section")
+				}
+				seenPragma = true
+				p.parseVersionPragma(file)
 			}
 			p.nextToken()
 
@@ -145,3 +171,21 @@ func (p *Parser) ParseGMXFile() *ast.GMXFile {
 
 	return file
 }
+
+// parseVersionPragma reads the version of a `gmx 1.0` pragma into the file
+func (p *Parser) parseVersionPragma(file *ast.GMXFile) {
+	if !p.peekTokenIs(token.FLOAT) && !p.peekTokenIs(token.INT) {
+		p.addError(fmt.Sprintf("expected a language version after gmx (e.g. gmx %s), got %q", lang.Latest, p.peekToken.Literal))
+		return
+	}
+	p.nextToken()
+	version, err := lang.Parse(p.curToken.Literal)
+	if err == nil {
+		err = version.Supported()
+	}
+	if err != nil {
+		p.addError(err.Error())
+		return
+	}
+	file.Version = version
+}
@@ -2,6 +2,7 @@ package parser
 
 import (
 	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/lang"
 	"github.com/btouchard/gmx/internal/compiler/lexer"
 	"strings"
 	"testing"
@@ -2147,3 +2148,80 @@ func TestParseNoInfiniteLoopGarbage(t *testing.T) {
 		t.Error("expected Services slice to be initialized")
 	}
 }
+
+func TestParseSectionStartPositions(t *testing.T) {
+	input := `<script>
+
+model Task {
+  id: uuid @pk
+}
+service Mailer {
+  provider: "smtp"
+}
+</script>
+
+<template>  <p>{{.CSRFToken}}</p>
+</template>
+
+<style>
+  p { color: red; }
+</style>`
+	p := New(lexer.New(input))
+	file := p.ParseGMXFile()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	if file.Script.StartLine != 2 {
+		t.Errorf("expected script StartLine 2 (content on line 3), got %d", file.Script.StartLine)
+	}
+	if file.Models[0].Line != 3 {
+		t.Errorf("expected model Task on line 3, got %d", file.Models[0].Line)
+	}
+	if file.Services[0].Line != 6 {
+		t.Errorf("expected service Mailer on line 6, got %d", file.Services[0].Line)
+	}
+	if file.Template.StartLine != 10 || file.Template.StartColumn != 12 {
+		t.Errorf("expected template content at 11:13, got offsets %d:%d", file.Template.StartLine, file.Template.StartColumn)
+	}
+	if file.Style.StartLine != 14 {
+		t.Errorf("expected style StartLine 14 (content on line 15), got %d", file.Style.StartLine)
+	}
+}
+
+func TestParseVersionPragma(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    lang.Version
+		wantErr string
+	}{
+		{"no pragma", "<template>\n<p></p>\n</template>", lang.Default, ""},
+		{"declared version", "gmx 1.0\n\n<template>\n<p></p>\n</template>", lang.Version{Major: 1, Minor: 0}, ""},
+		{"newer than compiler", "gmx 9.0\n<template>\n<p></p>\n</template>", lang.Default, "1:5: gmx 9.0 requires a newer compiler"},
+		{"missing version", "gmx\n<template>\n<p></p>\n</template>", lang.Default, "1:1: expected a language version after gmx"},
+		{"malformed version", "gmx 2\n<template>\n<p></p>\n</template>", lang.Default, "1:5: invalid gmx version \"2\""},
+		{"after a section", "<template>\n<p></p>\n</template>\ngmx 1.0", lang.Default, "4:1: the gmx version pragma must come before the first section"},
+		{"duplicate", "gmx 1.0\ngmx 1.0\n<template>\n<p></p>\n</template>", lang.Default, "2:1: duplicate gmx version pragma"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.New(tt.input))
+			file := p.ParseGMXFile()
+			if tt.wantErr == "" {
+				if len(p.Errors()) > 0 {
+					t.Fatalf("parser errors: %v", p.Errors())
+				}
+			} else if len(p.Errors()) == 0 || !strings.Contains(p.Errors()[0], tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, p.Errors())
+			}
+			if file.Template == nil {
+				t.Error("the template section should still be parsed")
+			}
+			if file.Version != tt.want {
+				t.Errorf("Version = %s, want %s", file.Version, tt.want)
+			}
+		})
+	}
+}
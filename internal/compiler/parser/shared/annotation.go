@@ -9,6 +9,7 @@ import (
 // ParseAnnotation parses: @pk, @default(uuid_v4), @relation(references: [id])
 func (p *ParserCore) ParseAnnotation() *ast.Annotation {
 	if !p.expectPeek(token.IDENT) {
+		p.nextToken() // skip the bare @ so callers looping over annotations progress
 		return nil
 	}
 
@@ -91,6 +92,15 @@ func (p *ParserCore) parseAnnotationValue() string {
 		return val
 	}
 
+	// Number with a unit suffix, lexed as a number then an identifier: 3s, 500ms
+	if (p.curTokenIs(token.INT) || p.curTokenIs(token.FLOAT)) && p.peekTokenIs(token.IDENT) &&
+		p.peekToken.Pos.Line == p.curToken.Pos.Line && p.peekToken.Pos.Column == p.curToken.Pos.Column+len(p.curToken.Literal) {
+		val := p.curToken.Literal + p.peekToken.Literal
+		p.nextToken()
+		p.nextToken()
+		return val
+	}
+
 	// Simple value (identifier, number, boolean)
 	val := p.curToken.Literal
 	p.nextToken()
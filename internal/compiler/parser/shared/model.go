@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"github.com/btouchard/gmx/internal/compiler/ast"
 	"github.com/btouchard/gmx/internal/compiler/token"
+	"strings"
+	"unicode"
 )
 
 // ParseModelDecl parses: model Task { ... }
@@ -15,6 +17,7 @@ func (p *ParserCore) ParseModelDecl() *ast.ModelDecl {
 	model := &ast.ModelDecl{
 		Name:   p.curToken.Literal,
 		Fields: []*ast.FieldDecl{},
+		Line:   p.curToken.Pos.Line,
 	}
 
 	if !p.expectPeek(token.LBRACE) {
@@ -24,6 +27,12 @@ func (p *ParserCore) ParseModelDecl() *ast.ModelDecl {
 
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
 		prevPos := p.curToken.Pos
+		if p.curTokenIs(token.AT) && p.peekTokenIs(token.AT) {
+			if index := p.parseIndexDecl(); index != nil {
+				model.Indexes = append(model.Indexes, index)
+			}
+			continue
+		}
 		field := p.parseFieldDecl()
 		if field != nil {
 			model.Fields = append(model.Fields, field)
@@ -44,6 +53,82 @@ func (p *ParserCore) ParseModelDecl() *ast.ModelDecl {
 	return model
 }
 
+// parseIndexDecl parses: @@index([ownerId, status]), @@unique([ownerId, slug], name: "idx_owner_slug")
+func (p *ParserCore) parseIndexDecl() *ast.IndexDecl {
+	line := p.curToken.Pos.Line
+	p.nextToken() // move to the second @
+	ann := p.ParseAnnotation()
+	if ann == nil {
+		return nil
+	}
+	if ann.Name != "index" && ann.Name != "unique" {
+		p.addError(fmt.Sprintf("unknown model attribute @@%s (expected @@index or @@unique)", ann.Name))
+		return nil
+	}
+
+	index := &ast.IndexDecl{
+		Name:   ann.Args["name"],
+		Unique: ann.Name == "unique",
+		Line:   line,
+	}
+	for _, field := range strings.Split(ann.SimpleArg(), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			index.Fields = append(index.Fields, field)
+		}
+	}
+	for key := range ann.Args {
+		if key != "_" && key != "name" {
+			p.addError(fmt.Sprintf("@@%s: unknown argument %s (expected the fields and name)", ann.Name, key))
+		}
+	}
+	if len(index.Fields) == 0 {
+		p.addError(fmt.Sprintf("@@%s takes the indexed fields, such as @@%s([ownerId, status])", ann.Name, ann.Name))
+		return nil
+	}
+	return index
+}
+
+// parseEnumVariants parses the variants of an enum type, from ( to ):
+// (pending, active, done)
+func (p *ParserCore) parseEnumVariants() []string {
+	var variants []string
+	for {
+		p.nextToken()
+		if p.curTokenIs(token.RPAREN) { // enum() or a trailing comma
+			break
+		}
+		// Keywords are valid variants too: enum(draft, return)
+		if !isIdentifier(p.curToken.Literal) {
+			p.addError(fmt.Sprintf("enum variants are identifiers, such as enum(pending, done), got %q", p.curToken.Literal))
+			for !p.curTokenIs(token.RPAREN) && !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
+				p.nextToken()
+			}
+			return variants
+		}
+		variants = append(variants, p.curToken.Literal)
+		p.nextToken()
+		if p.curTokenIs(token.RPAREN) {
+			break
+		}
+		if !p.curTokenIs(token.COMMA) {
+			p.addError(fmt.Sprintf("expected , or ) in enum variants, got %q", p.curToken.Literal))
+			return variants
+		}
+	}
+	return variants
+}
+
+// isIdentifier reports whether s is a letter or underscore followed by
+// letters, digits and underscores
+func isIdentifier(s string) bool {
+	for i, r := range s {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return s != ""
+}
+
 // parseFieldDecl parses: title: string @min(3) @max(255)
 func (p *ParserCore) parseFieldDecl() *ast.FieldDecl {
 	if !p.curTokenIs(token.IDENT) {
@@ -54,6 +139,7 @@ func (p *ParserCore) parseFieldDecl() *ast.FieldDecl {
 	field := &ast.FieldDecl{
 		Name:        p.curToken.Literal,
 		Annotations: []*ast.Annotation{},
+		Line:        p.curToken.Pos.Line,
 	}
 
 	if !p.expectPeek(token.COLON) {
@@ -77,6 +163,12 @@ func (p *ParserCore) parseFieldDecl() *ast.FieldDecl {
 
 	field.Type = p.curToken.Literal
 
+	// Enum types list their variants: enum(pending, active, done)
+	if field.Type == "enum" && p.peekTokenIs(token.LPAREN) {
+		p.nextToken() // move to (
+		field.Variants = p.parseEnumVariants()
+	}
+
 	// Check for array type: Post[]
 	if p.peekTokenIs(token.LBRACKET) {
 		p.nextToken() // move to [
@@ -87,8 +179,8 @@ func (p *ParserCore) parseFieldDecl() *ast.FieldDecl {
 
 	p.nextToken() // move past type or ]
 
-	// Parse annotations
-	for p.curTokenIs(token.AT) && !p.curTokenIs(token.EOF) {
+	// Parse annotations, up to the @@index lines of the model
+	for p.curTokenIs(token.AT) && !p.peekTokenIs(token.AT) {
 		ann := p.ParseAnnotation()
 		if ann != nil {
 			field.Annotations = append(field.Annotations, ann)
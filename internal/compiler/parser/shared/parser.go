@@ -2,16 +2,22 @@ package shared
 
 import (
 	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/ast"
 	"github.com/btouchard/gmx/internal/compiler/lexer"
 	"github.com/btouchard/gmx/internal/compiler/token"
 )
 
+// MethodBodyParser parses a service method body starting at the current '{'
+// token, leaving the core on the token following the closing '}'
+type MethodBodyParser func(core *ParserCore) []ast.Statement
+
 // ParserCore contains shared parsing utilities used by both main parser and script parser
 type ParserCore struct {
-	l         *lexer.Lexer
-	curToken  token.Token
-	peekToken token.Token
-	errors    []string
+	l          *lexer.Lexer
+	curToken   token.Token
+	peekToken  token.Token
+	errors     []string
+	methodBody MethodBodyParser
 }
 
 // NewParserCore creates a new parser core from a lexer
@@ -76,3 +82,14 @@ func (p *ParserCore) GetCurrentToken() token.Token {
 func (p *ParserCore) GetPeekToken() token.Token {
 	return p.peekToken
 }
+
+// SetTokens restores the token state after a parent parser consumed tokens
+func (p *ParserCore) SetTokens(cur, peek token.Token) {
+	p.curToken = cur
+	p.peekToken = peek
+}
+
+// SetMethodBodyParser enables service method bodies, parsed by the given statement parser
+func (p *ParserCore) SetMethodBodyParser(fn MethodBodyParser) {
+	p.methodBody = fn
+}
@@ -2,6 +2,7 @@ package shared
 
 import (
 	"github.com/btouchard/gmx/internal/compiler/lexer"
+	"strings"
 	"testing"
 )
 
@@ -113,6 +114,26 @@ func TestParseServiceWithMethod(t *testing.T) {
 	}
 }
 
+func TestParseServiceMethodBodyWithoutBodyParser(t *testing.T) {
+	input := `service Notifier {
+  provider: "webhook"
+  func notify(msg: string) error { return nil }
+}`
+
+	p := NewParserCore(lexer.New(input))
+	p.ParseServiceDecl()
+
+	found := false
+	for _, err := range p.Errors() {
+		if strings.Contains(err, "service method bodies are only supported in script blocks") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected method body error, got %v", p.Errors())
+	}
+}
+
 func TestParseAnnotation(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -138,6 +159,12 @@ func TestParseAnnotation(t *testing.T) {
 			wantName: "relation",
 			wantArgs: map[string]string{"references": "id"},
 		},
+		{
+			name:     "duration argument",
+			input:    "@timeout(500ms)",
+			wantName: "timeout",
+			wantArgs: map[string]string{"_": "500ms"},
+		},
 	}
 
 	for _, tt := range tests {
@@ -238,3 +265,135 @@ func TestParseFieldMissingType(t *testing.T) {
 		t.Errorf("expected at least 2 fields, got %d", len(model.Fields))
 	}
 }
+
+func TestParseFieldIncompleteAnnotation(t *testing.T) {
+	input := `model Task {
+  done: bool
+  title: string @
+}`
+
+	l := lexer.New(input)
+	p := NewParserCore(l)
+
+	// A bare @, as typed in an editor, must not stall the field loop
+	model := p.ParseModelDecl()
+
+	if model == nil {
+		t.Fatal("expected partial model, got nil")
+	}
+
+	if len(p.Errors()) == 0 {
+		t.Error("expected error for incomplete annotation")
+	}
+
+	if len(model.Fields) != 2 {
+		t.Errorf("expected 2 fields, got %d", len(model.Fields))
+	}
+}
+
+func TestParseModelIndexes(t *testing.T) {
+	input := `model Task {
+  ownerId: string @index
+  status: string
+  slug: string
+  @@index([ownerId, status])
+  @@unique([ownerId, slug], name: "idx_owner_slug")
+}`
+
+	p := NewParserCore(lexer.New(input))
+	model := p.ParseModelDecl()
+
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	if len(model.Fields) != 3 || model.Fields[0].FindAnnotation("index") == nil {
+		t.Fatalf("expected 3 fields, ownerId with @index, got %d", len(model.Fields))
+	}
+	if len(model.Indexes) != 2 {
+		t.Fatalf("expected 2 indexes, got %d", len(model.Indexes))
+	}
+
+	index := model.Indexes[0]
+	if strings.Join(index.Fields, ",") != "ownerId,status" || index.Unique || index.Name != "" || index.Line != 5 {
+		t.Errorf("unexpected @@index: %+v", index)
+	}
+	unique := model.Indexes[1]
+	if strings.Join(unique.Fields, ",") != "ownerId,slug" || !unique.Unique || unique.Name != "idx_owner_slug" {
+		t.Errorf("unexpected @@unique: %+v", unique)
+	}
+}
+
+func TestParseModelIndexErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{"unknown attribute", "model Task {\n  title: string\n  @@id([title])\n}", "unknown model attribute @@id"},
+		{"no fields", "model Task {\n  title: string\n  @@index\n}", "@@index takes the indexed fields"},
+		{"unknown argument", "model Task {\n  title: string\n  @@index([title], type: hash)\n}", "unknown argument type"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewParserCore(lexer.New(tt.input))
+			p.ParseModelDecl()
+			if !strings.Contains(strings.Join(p.Errors(), "\n"), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, p.Errors())
+			}
+		})
+	}
+}
+
+func TestParseModelEnum(t *testing.T) {
+	input := `model Task {
+  status: enum(pending, active, done) @default(pending)
+  kind: enum(task, return,)
+  title: string
+}`
+
+	p := NewParserCore(lexer.New(input))
+	model := p.ParseModelDecl()
+
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	if len(model.Fields) != 3 {
+		t.Fatalf("expected 3 fields, got %d", len(model.Fields))
+	}
+	status := model.Fields[0]
+	if status.Type != "enum" || strings.Join(status.Variants, ",") != "pending,active,done" {
+		t.Errorf("unexpected enum field: %+v", status)
+	}
+	if ann := status.FindAnnotation("default"); ann == nil || ann.SimpleArg() != "pending" {
+		t.Errorf("expected @default(pending), got %+v", status.Annotations)
+	}
+	if got := status.TypeString(); got != "enum(pending, active, done)" {
+		t.Errorf("TypeString() = %q", got)
+	}
+	// Keywords are variants too, and a trailing comma is accepted
+	if kind := model.Fields[1]; strings.Join(kind.Variants, ",") != "task,return" {
+		t.Errorf("unexpected enum field: %+v", kind)
+	}
+}
+
+func TestParseModelEnumErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{"string variant", "model Task {\n  status: enum(\"in progress\")\n}", "enum variants are identifiers"},
+		{"missing comma", "model Task {\n  status: enum(pending done)\n}", "expected , or ) in enum variants"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewParserCore(lexer.New(tt.input))
+			p.ParseModelDecl()
+			if !strings.Contains(strings.Join(p.Errors(), "\n"), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, p.Errors())
+			}
+		})
+	}
+}
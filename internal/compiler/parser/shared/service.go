@@ -17,6 +17,7 @@ func (p *ParserCore) ParseServiceDecl() *ast.ServiceDecl {
 		Fields:   []*ast.ServiceField{},
 		Methods:  []*ast.ServiceMethod{},
 		Provider: "",
+		Line:     p.curToken.Pos.Line,
 	}
 
 	if !p.expectPeek(token.LBRACE) {
@@ -99,6 +100,7 @@ func (p *ParserCore) parseServiceField() *ast.ServiceField {
 }
 
 // parseServiceMethod parses: func send(to: string, subject: string, body: string) error
+// optionally followed by a { ... } body when a method body parser is set
 func (p *ParserCore) parseServiceMethod() *ast.ServiceMethod {
 	// Current token is FUNC
 	if !p.expectPeek(token.IDENT) {
@@ -153,5 +155,13 @@ func (p *ParserCore) parseServiceMethod() *ast.ServiceMethod {
 		p.nextToken()
 	}
 
+	if p.curTokenIs(token.LBRACE) {
+		if p.methodBody == nil {
+			p.addError("service method bodies are only supported in script blocks")
+			return method
+		}
+		method.Body = p.methodBody(p)
+	}
+
 	return method
 }
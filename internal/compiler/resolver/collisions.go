@@ -0,0 +1,185 @@
+package resolver
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// symbol records where a merged model, service or function comes from:
+// all of them share one Go namespace in the generated main.go
+type symbol struct {
+	kind   string // "model", "service" or "func"
+	origin string // absolute path of the declaring file
+	decl   any    // *ast.ModelDecl, *ast.ServiceDecl or *ast.FuncDecl
+}
+
+// declareMain seeds the symbol table with the declarations of the main file
+func (r *Resolver) declareMain(main *ast.GMXFile, mainPath string) {
+	for _, model := range main.Models {
+		r.symbols[model.Name] = symbol{"model", mainPath, model}
+	}
+	for _, service := range main.Services {
+		r.symbols[service.Name] = symbol{"service", mainPath, service}
+	}
+	if main.Script != nil {
+		for _, fn := range main.Script.Funcs {
+			r.symbols[fn.Name] = symbol{"func", mainPath, fn}
+		}
+	}
+}
+
+// claim reserves name for a declaration of the given kind, decl being the
+// node as declared in its file. It returns false when the name is already
+// taken: silently when the existing declaration is the same one (a file
+// imported twice, or an identical model), with an error otherwise
+func (r *Resolver) claim(name, kind, origin string, decl any, member string, imp *ast.ImportDecl) bool {
+	existing, ok := r.symbols[name]
+	if !ok {
+		r.symbols[name] = symbol{kind, origin, decl}
+		return true
+	}
+	if existing.kind == kind && sameDecl(existing.decl, decl) {
+		return false
+	}
+
+	hint := "rename one of them"
+	if imp.Default == "" {
+		hint = fmt.Sprintf("rename one of them or import it with an alias: import { %s as … } from %q", member, imp.Path)
+	}
+	r.addError("%s %s from %s collides with %s %s from %s; %s",
+		kind, name, r.relPath(origin), existing.kind, name, r.relPath(existing.origin), hint)
+	return false
+}
+
+// mergeModel adds an imported model to the resolved file under the given name
+func (r *Resolver) mergeModel(model *ast.ModelDecl, name, origin string, imp *ast.ImportDecl, resolved *ResolvedFile) {
+	if !r.claim(name, "model", origin, model, model.Name, imp) {
+		return
+	}
+	if name != model.Name {
+		renamed := *model
+		renamed.Name = name
+		model = &renamed
+	}
+	resolved.Main.Models = append(resolved.Main.Models, model)
+}
+
+// mergeService adds an imported service to the resolved file under the given name
+func (r *Resolver) mergeService(service *ast.ServiceDecl, name, origin string, imp *ast.ImportDecl, resolved *ResolvedFile) {
+	if !r.claim(name, "service", origin, service, service.Name, imp) {
+		return
+	}
+	if name != service.Name {
+		renamed := *service
+		renamed.Name = name
+		service = &renamed
+	}
+	resolved.Main.Services = append(resolved.Main.Services, service)
+}
+
+// mergeFunc adds an imported function to the resolved file under the given name
+func (r *Resolver) mergeFunc(fn *ast.FuncDecl, name, origin string, imp *ast.ImportDecl, resolved *ResolvedFile) {
+	if !r.claim(name, "func", origin, fn, fn.Name, imp) {
+		return
+	}
+	if name != fn.Name {
+		renamed := *fn
+		renamed.Name = name
+		fn = &renamed
+	}
+	if resolved.Main.Script == nil {
+		resolved.Main.Script = &ast.ScriptBlock{}
+	}
+	resolved.Main.Script.Funcs = append(resolved.Main.Script.Funcs, fn)
+}
+
+// checkAliasedModels rejects functions imported alongside a model they use
+// under an alias: their bodies still refer to the original model name
+func (r *Resolver) checkAliasedModels(imp *ast.ImportDecl, file *ast.GMXFile) {
+	if file.Script == nil {
+		return
+	}
+	aliased := make(map[string]string) // original model name → alias
+	for _, model := range file.Models {
+		if alias, ok := imp.Aliases[model.Name]; ok && alias != model.Name {
+			aliased[model.Name] = alias
+		}
+	}
+	if len(aliased) == 0 {
+		return
+	}
+
+	for _, fn := range file.Script.Funcs {
+		if !slices.Contains(imp.Members, fn.Name) {
+			continue
+		}
+		for _, name := range modelRefs(fn) {
+			if alias, ok := aliased[name]; ok {
+				r.addError("func %s uses model %s, which is imported as %s: functions keep the names of their own file; rename the model in %s instead",
+					fn.Name, name, alias, imp.Path)
+				break
+			}
+		}
+	}
+}
+
+// modelRefs lists the type and identifier names a function refers to
+func modelRefs(fn *ast.FuncDecl) []string {
+	var names []string
+	for _, param := range fn.Params {
+		names = append(names, strings.TrimSuffix(param.Type, "[]"))
+	}
+	ast.Inspect(fn, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.Ident:
+			names = append(names, n.Name)
+		case *ast.StructLit:
+			names = append(names, n.TypeName)
+		}
+		return true
+	})
+	return names
+}
+
+// sameDecl reports whether two declarations are interchangeable: the same
+// node, or models with the same fields, types and annotations
+func sameDecl(a, b any) bool {
+	if a == b {
+		return true
+	}
+	ma, ok := a.(*ast.ModelDecl)
+	if !ok {
+		return false
+	}
+	mb, ok := b.(*ast.ModelDecl)
+	return ok && modelSignature(ma) == modelSignature(mb)
+}
+
+// modelSignature renders a model's fields, ignoring source positions
+func modelSignature(m *ast.ModelDecl) string {
+	var b strings.Builder
+	for _, f := range m.Fields {
+		b.WriteString(f.Name + ":" + f.TypeString())
+		for _, a := range f.Annotations {
+			b.WriteString(fmt.Sprintf(" @%s%v", a.Name, a.Args))
+		}
+		b.WriteString(";")
+	}
+	return b.String()
+}
+
+// relPath shortens an absolute path for messages
+func (r *Resolver) relPath(path string) string {
+	base, err := filepath.Abs(r.basePath)
+	if err != nil {
+		return path
+	}
+	if rel, err := filepath.Rel(base, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
@@ -0,0 +1,164 @@
+package resolver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const todoComponent = `<script>
+model Task {
+  id: uuid @pk
+  title: string @min(3)
+}
+
+func createTask(title: string) error {
+  const task = Task{title: title}
+  try task.save()
+  return render(task)
+}
+
+func archiveAll() error {
+  return nil
+}
+</script>
+
+<template>
+<div>{{.Title}}</div>
+</template>`
+
+// resolveFiles writes the given files under a temporary directory and
+// resolves main.gmx
+func resolveFiles(t *testing.T, files map[string]string) (*ResolvedFile, []string) {
+	t.Helper()
+	tmpDir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mainPath := filepath.Join(tmpDir, "main.gmx")
+	return New(tmpDir).Resolve(parseFile(t, mainPath), mainPath)
+}
+
+func TestImportCollisions(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+		want string // substring of the single expected error
+	}{
+		{
+			"component model differs from page model",
+			"<script>\nimport Todo from \"./components/Todo.gmx\"\nmodel Task {\n  id: uuid @pk\n  name: string\n}\n</script>\n<template>{{template \"Todo\" .}}</template>",
+			"model Task from components/Todo.gmx collides with model Task from main.gmx; rename one of them",
+		},
+		{
+			"imported function clashes with page function",
+			"<script>\nimport { createTask } from \"./components/Todo.gmx\"\nfunc createTask(title: string) error {\n  return nil\n}\n</script>\n<template></template>",
+			"func createTask from components/Todo.gmx collides with func createTask from main.gmx; rename one of them or import it with an alias: import { createTask as … } from \"./components/Todo.gmx\"",
+		},
+		{
+			"imported model clashes with page function",
+			"<script>\nimport { Task } from \"./components/Todo.gmx\"\nfunc Task() error {\n  return nil\n}\n</script>\n<template></template>",
+			"model Task from components/Todo.gmx collides with func Task from main.gmx",
+		},
+		{
+			"two components define different models",
+			"<script>\nimport Todo from \"./components/Todo.gmx\"\nimport Board from \"./components/Board.gmx\"\n</script>\n<template></template>",
+			"model Task from components/Board.gmx collides with model Task from components/Todo.gmx",
+		},
+		{
+			"aliased model used by an imported function",
+			"<script>\nimport { Task as TodoTask, createTask } from \"./components/Todo.gmx\"\n</script>\n<template></template>",
+			"func createTask uses model Task, which is imported as TodoTask",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := resolveFiles(t, map[string]string{
+				"main.gmx":             tt.page,
+				"components/Todo.gmx":  todoComponent,
+				"components/Board.gmx": "<script>\nmodel Task {\n  id: int @pk\n  column: string\n}\n</script>\n<template><ul></ul></template>",
+			})
+			if len(errs) != 1 || !strings.Contains(errs[0], tt.want) {
+				t.Errorf("errors = %v, want one containing %q", errs, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportIdenticalDeclarationsMerge(t *testing.T) {
+	page := `<script>
+import Todo from "./components/Todo.gmx"
+import { createTask } from "./components/Todo.gmx"
+
+model Task {
+  id: uuid @pk
+  title: string @min(3)
+}
+</script>
+<template>{{template "Todo" .}}</template>`
+
+	resolved, errs := resolveFiles(t, map[string]string{
+		"main.gmx":            page,
+		"components/Todo.gmx": todoComponent,
+	})
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(resolved.Main.Models) != 1 {
+		t.Errorf("expected the identical Task models to merge into one, got %d", len(resolved.Main.Models))
+	}
+	if len(resolved.Main.Script.Funcs) != 1 || resolved.Main.Script.Funcs[0].Name != "createTask" {
+		t.Errorf("expected createTask to be imported once, got %d funcs", len(resolved.Main.Script.Funcs))
+	}
+}
+
+func TestImportAliases(t *testing.T) {
+	page := `<script>
+import { Task as TodoTask, archiveAll as archiveTodos } from "./components/Todo.gmx"
+
+model Task {
+  id: uuid @pk
+  name: string
+}
+
+func archiveAll() error {
+  return nil
+}
+</script>
+<template></template>`
+
+	resolved, errs := resolveFiles(t, map[string]string{
+		"main.gmx":            page,
+		"components/Todo.gmx": todoComponent,
+	})
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	var models []string
+	for _, m := range resolved.Main.Models {
+		models = append(models, m.Name)
+	}
+	if strings.Join(models, ",") != "Task,TodoTask" {
+		t.Errorf("models = %v, want [Task TodoTask]", models)
+	}
+	var funcs []string
+	for _, fn := range resolved.Main.Script.Funcs {
+		funcs = append(funcs, fn.Name)
+	}
+	if strings.Join(funcs, ",") != "archiveAll,archiveTodos" {
+		t.Errorf("funcs = %v, want [archiveAll archiveTodos]", funcs)
+	}
+
+	if resolved.Main.Models[1].Fields[1].Name != "title" {
+		t.Errorf("expected TodoTask to keep the fields of the component's Task")
+	}
+}
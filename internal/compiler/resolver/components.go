@@ -0,0 +1,260 @@
+package resolver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// ExpandComponentTags turns the tags of imported components into template
+// calls: <TaskItem /> renders the component with the current data, and its
+// one attribute, if any, is the data passed instead:
+//
+//	<TaskItem task="{{.}}" />  →  {{template "TaskItem" .}}
+//	<Badge label="New" />      →  {{template "Badge" "New"}}
+//
+// Tags of other names are left alone. The line count of src is preserved so
+// that template diagnostics keep pointing at the .gmx source.
+func ExpandComponentTags(src string, components map[string]*ComponentInfo) (string, error) {
+	if len(components) == 0 {
+		return src, nil
+	}
+
+	var b strings.Builder
+	pos := 0
+	for {
+		start, name := nextComponentTag(src, pos, components)
+		if start == -1 {
+			break
+		}
+		b.WriteString(src[pos:start])
+
+		tag, err := parseComponentTag(src, start, name)
+		if err != nil {
+			return "", fmt.Errorf("line %d: %w", lineAt(src, start), err)
+		}
+		b.WriteString(fmt.Sprintf("{{template %q %s}}", name, tag.data))
+		// Keep the lines the tag spanned, in a template comment that renders nothing
+		if n := strings.Count(src[start:tag.end], "\n"); n > 0 {
+			b.WriteString("{{/*" + strings.Repeat("\n", n) + "*/}}")
+		}
+		pos = tag.end
+	}
+	b.WriteString(src[pos:])
+	return b.String(), nil
+}
+
+// nextComponentTag returns the offset and name of the next tag of an imported
+// component at or after pos, or -1
+func nextComponentTag(src string, pos int, components map[string]*ComponentInfo) (int, string) {
+	for {
+		i := strings.IndexByte(src[pos:], '<')
+		if i == -1 {
+			return -1, ""
+		}
+		start := pos + i
+		nameEnd := start + 1
+		for nameEnd < len(src) && isTagNameByte(src[nameEnd]) {
+			nameEnd++
+		}
+		name := src[start+1 : nameEnd]
+		if _, ok := components[name]; ok && nameEnd < len(src) && isTagBoundary(src[nameEnd]) {
+			return start, name
+		}
+		pos = start + 1
+	}
+}
+
+// componentTag is a parsed component tag
+type componentTag struct {
+	data string // template pipeline passed to the component
+	end  int    // offset after the tag, and its closing tag if any
+}
+
+// parseComponentTag parses the component tag of src starting at start
+func parseComponentTag(src string, start int, name string) (componentTag, error) {
+	var attrs []string
+	data := "."
+	i := start + 1 + len(name)
+	for {
+		for i < len(src) && isSpace(src[i]) {
+			i++
+		}
+		if i >= len(src) {
+			return componentTag{}, fmt.Errorf("unclosed component tag <%s", name)
+		}
+		if strings.HasPrefix(src[i:], "/>") {
+			i += 2
+			break
+		}
+		if src[i] == '>' {
+			// <TaskItem></TaskItem> is the same as <TaskItem />; content is not passed
+			i++
+			closing := "</" + name + ">"
+			rest := strings.TrimLeft(src[i:], " \t\r\n")
+			if !strings.HasPrefix(rest, closing) {
+				return componentTag{}, fmt.Errorf("component <%s> cannot have content: write <%s />", name, name)
+			}
+			i = len(src) - len(rest) + len(closing)
+			break
+		}
+
+		attrStart := i
+		for i < len(src) && isAttrNameByte(src[i]) {
+			i++
+		}
+		attr := src[attrStart:i]
+		if attr == "" || i >= len(src) || src[i] != '=' || i+1 >= len(src) || (src[i+1] != '"' && src[i+1] != '\'') {
+			return componentTag{}, fmt.Errorf("component <%s>: expected attribute=\"value\", got %q", name, excerpt(src[attrStart:]))
+		}
+		value, end, ok := quotedValue(src, i+1)
+		if !ok {
+			return componentTag{}, fmt.Errorf("component <%s>: unterminated value of %s", name, attr)
+		}
+		i = end
+
+		pipeline, err := attrPipeline(value)
+		if err != nil {
+			return componentTag{}, fmt.Errorf("component <%s>: attribute %s: %w", name, attr, err)
+		}
+		attrs = append(attrs, attr)
+		data = pipeline
+	}
+
+	if len(attrs) > 1 {
+		sort.Strings(attrs)
+		return componentTag{}, fmt.Errorf("component <%s> takes one attribute, the data it renders, got %s", name, strings.Join(attrs, ", "))
+	}
+	return componentTag{data: data, end: i}, nil
+}
+
+// quotedValue reads the attribute value quoted at src[at], skipping over
+// template actions, which may contain the quote character themselves
+func quotedValue(src string, at int) (string, int, bool) {
+	quote := src[at]
+	i := at + 1
+	for i < len(src) {
+		if strings.HasPrefix(src[i:], "{{") {
+			end := strings.Index(src[i:], "}}")
+			if end == -1 {
+				return "", 0, false
+			}
+			i += end + 2
+			continue
+		}
+		if src[i] == quote {
+			return src[at+1 : i], i + 1, true
+		}
+		i++
+	}
+	return "", 0, false
+}
+
+// attrPipeline returns the template pipeline of an attribute value: the
+// action of "{{.Task}}", or the quoted text of a plain value
+func attrPipeline(value string) (string, error) {
+	trimmed := strings.TrimSpace(value)
+	if !strings.Contains(trimmed, "{{") {
+		return fmt.Sprintf("%q", value), nil
+	}
+	if strings.HasPrefix(trimmed, "{{") && strings.HasSuffix(trimmed, "}}") && strings.Count(trimmed, "{{") == 1 {
+		pipeline := strings.TrimSpace(strings.Trim(trimmed[2:len(trimmed)-2], "-"))
+		if pipeline == "" {
+			return "", fmt.Errorf("empty action")
+		}
+		return pipeline, nil
+	}
+	return "", fmt.Errorf("expected a single {{action}} or plain text, got %q", value)
+}
+
+// expandTemplate returns a copy of a template block with its component tags expanded
+func expandTemplate(block *ast.TemplateBlock, components map[string]*ComponentInfo) (*ast.TemplateBlock, error) {
+	src, err := ExpandComponentTags(block.Source, components)
+	if err != nil {
+		return nil, err
+	}
+	expanded := *block
+	expanded.Source = src
+	return &expanded, nil
+}
+
+// expandComponents expands the component tags of the main template, of the
+// pages of a directory build and of the component templates, reporting errors
+// against their files
+func (r *Resolver) expandComponents(resolved *ResolvedFile, mainPath string) {
+	if len(resolved.Components) == 0 {
+		return
+	}
+	for i, page := range resolved.Main.Pages {
+		block, err := expandTemplate(page.Template, resolved.Components)
+		if err != nil {
+			r.addError("%s: %v", r.relPath(page.Path), err)
+			continue
+		}
+		expanded := *page
+		expanded.Template = block
+		resolved.Main.Pages[i] = &expanded
+	}
+	if resolved.Main.Template != nil && len(resolved.Main.Pages) == 0 {
+		if block, err := expandTemplate(resolved.Main.Template, resolved.Components); err != nil {
+			r.addError("%s: %v", r.relPath(mainPath), err)
+		} else {
+			resolved.Main.Template = block
+		}
+	}
+
+	names := make([]string, 0, len(resolved.Components))
+	for name := range resolved.Components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		info := resolved.Components[name]
+		if info.File.Template == nil {
+			continue
+		}
+		block, err := expandTemplate(info.File.Template, resolved.Components)
+		if err != nil {
+			r.addError("%s: %v", r.relPath(info.Path), err)
+			continue
+		}
+		// The parsed file is cached and shared: expand into a copy
+		file := *info.File
+		file.Template = block
+		resolved.Components[name] = &ComponentInfo{File: &file, Path: info.Path, Name: info.Name}
+	}
+}
+
+// lineAt returns the 1-based line of an offset of src
+func lineAt(src string, offset int) int {
+	return strings.Count(src[:offset], "\n") + 1
+}
+
+// excerpt shortens the rest of a tag for messages
+func excerpt(s string) string {
+	if i := strings.IndexAny(s, " \t\r\n>"); i != -1 {
+		s = s[:i]
+	}
+	if len(s) > 20 {
+		s = s[:20] + "…"
+	}
+	return s
+}
+
+func isTagNameByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func isAttrNameByte(c byte) bool {
+	return isTagNameByte(c) || c == '-' || c == ':'
+}
+
+func isTagBoundary(c byte) bool {
+	return isSpace(c) || c == '/' || c == '>'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
@@ -0,0 +1,153 @@
+package resolver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/lexer"
+	"github.com/btouchard/gmx/internal/compiler/parser"
+)
+
+func TestExpandComponentTags(t *testing.T) {
+	components := map[string]*ComponentInfo{
+		"TaskItem": {Name: "TaskItem"},
+		"Badge":    {Name: "Badge"},
+	}
+
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"no attribute", `<ul><TaskItem /></ul>`, `<ul>{{template "TaskItem" .}}</ul>`},
+		{"action attribute", `<TaskItem task="{{.}}"/>`, `{{template "TaskItem" .}}`},
+		{"field attribute", `<TaskItem task="{{ .Task }}" />`, `{{template "TaskItem" .Task}}`},
+		{"quotes inside action", `<Badge label='{{index .Labels "new"}}' />`, `{{template "Badge" index .Labels "new"}}`},
+		{"plain text", `<Badge label="New" />`, `{{template "Badge" "New"}}`},
+		{"closing tag", `<Badge></Badge>`, `{{template "Badge" .}}`},
+		{"other tags untouched", `<TaskList /><Badger /><div>`, `<TaskList /><Badger /><div>`},
+		{"multi-line tag keeps lines", "<TaskItem\n  task=\"{{.}}\"\n/>\n<p>", "{{template \"TaskItem\" .}}{{/*\n\n*/}}\n<p>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExpandComponentTags(tt.src, components)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ExpandComponentTags(%q) = %q, want %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandComponentTags_Errors(t *testing.T) {
+	components := map[string]*ComponentInfo{"TaskItem": {Name: "TaskItem"}}
+
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"two attributes", `<TaskItem task="{{.}}" done="{{.Done}}" />`, "takes one attribute, the data it renders, got done, task"},
+		{"content", `<TaskItem><b>x</b></TaskItem>`, "cannot have content"},
+		{"unclosed", "<p>\n<TaskItem task=\"{{.}}\"", "line 2: unclosed component tag"},
+		{"mixed value", `<TaskItem task="a {{.}}" />`, "expected a single {{action}} or plain text"},
+		{"bare attribute", `<TaskItem done />`, `expected attribute="value"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ExpandComponentTags(tt.src, components)
+			if err == nil {
+				t.Fatalf("expected error containing %q", tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolve_ExpandsComponentTags(t *testing.T) {
+	tmpDir := t.TempDir()
+	files := map[string]string{
+		"components/TaskItem.gmx": `<template>
+<li class="task"><Badge label="{{.Title}}" /></li>
+</template>
+
+<style scoped>
+.task { padding: 1rem; }
+</style>`,
+		"components/Badge.gmx": `<template>
+<span class="badge">{{.}}</span>
+</template>`,
+	}
+	for name, content := range files {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mainSrc := `<script>
+import TaskItem from "./components/TaskItem.gmx"
+import Badge from "./components/Badge.gmx"
+</script>
+
+<template>
+<ul>{{range .Tasks}}<TaskItem task="{{.}}" />{{end}}</ul>
+</template>`
+	mainPath := filepath.Join(tmpDir, "main.gmx")
+	p := parser.New(lexer.New(mainSrc))
+	main := p.ParseGMXFile()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parse errors: %v", p.Errors())
+	}
+
+	resolved, errs := New(tmpDir).Resolve(main, mainPath)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	if got := resolved.Main.Template.Source; !strings.Contains(got, `{{range .Tasks}}{{template "TaskItem" .}}{{end}}`) {
+		t.Errorf("main template not expanded: %s", got)
+	}
+	if got := resolved.Components["TaskItem"].File.Template.Source; !strings.Contains(got, `{{template "Badge" .Title}}`) {
+		t.Errorf("component template not expanded: %s", got)
+	}
+	if style := resolved.Components["TaskItem"].File.Style; style == nil || !style.Scoped {
+		t.Error("component scoped style should be kept")
+	}
+}
+
+func TestResolve_ComponentTagErrorNamesFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "Badge.gmx"), []byte("<template><b>{{.}}</b></template>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mainSrc := `<script>
+import Badge from "./Badge.gmx"
+</script>
+
+<template>
+<Badge a="x" b="y" />
+</template>`
+	p := parser.New(lexer.New(mainSrc))
+	main := p.ParseGMXFile()
+
+	_, errs := New(tmpDir).Resolve(main, filepath.Join(tmpDir, "main.gmx"))
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %v", errs)
+	}
+	if !strings.Contains(errs[0], "main.gmx") || !strings.Contains(errs[0], "takes one attribute") {
+		t.Errorf("unexpected error: %s", errs[0])
+	}
+}
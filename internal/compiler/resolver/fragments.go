@@ -0,0 +1,166 @@
+package resolver
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template/parse"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// fragmentRefRegex matches {{fragment "page/Name" ...}} references to other pages' fragments
+var fragmentRefRegex = regexp.MustCompile(`\{\{(-?\s*)fragment\s+"([^"]+)"`)
+
+// FragmentInfo is a {{define}} block exported by another page
+type FragmentInfo struct {
+	Name   string // qualified name: "tasks/TaskRow"
+	Source string // define body, with references to its page's defines qualified
+	Path   string // absolute path of the defining page
+}
+
+// FragmentRefs returns the qualified fragment names referenced by a template source
+func FragmentRefs(src string) []string {
+	var refs []string
+	for _, match := range fragmentRefRegex.FindAllStringSubmatch(src, -1) {
+		refs = append(refs, match[2])
+	}
+	return refs
+}
+
+// HasFragmentRefs reports whether a file's template references fragments of other pages
+func HasFragmentRefs(file *ast.GMXFile) bool {
+	return file.Template != nil && len(FragmentRefs(file.Template.Source)) > 0
+}
+
+// RewriteFragmentCalls turns {{fragment "page/Name" .}} into {{template "page/Name" .}}
+func RewriteFragmentCalls(src string) string {
+	return fragmentRefRegex.ReplaceAllString(src, `{{${1}template "${2}"`)
+}
+
+// pageDefines parses a template source and returns the bodies of its {{define}} blocks
+func pageDefines(src string) (map[string]string, error) {
+	tree := parse.New("page")
+	tree.Mode = parse.SkipFuncCheck | parse.ParseComments
+	treeSet := make(map[string]*parse.Tree)
+	if _, err := tree.Parse(src, "", "", treeSet); err != nil {
+		return nil, err
+	}
+
+	defines := make(map[string]string)
+	for name, t := range treeSet {
+		if name == "page" {
+			continue
+		}
+		defines[name] = t.Root.String()
+	}
+	return defines, nil
+}
+
+// localDefineNames returns the names of the {{define}} blocks of a template source
+func localDefineNames(src string) map[string]bool {
+	names := make(map[string]bool)
+	defines, err := pageDefines(src)
+	if err != nil {
+		return names // the template itself reports parse errors at startup
+	}
+	for name := range defines {
+		names[name] = true
+	}
+	return names
+}
+
+// resolveFragments exports the fragments referenced by the main and component
+// templates, following references made by the exported fragments themselves
+func (r *Resolver) resolveFragments(resolved *ResolvedFile, mainDir string) {
+	var queue []string
+	taken := make(map[string]string) // template name -> origin, for collision diagnostics
+	if resolved.Main.Template != nil {
+		queue = append(queue, FragmentRefs(resolved.Main.Template.Source)...)
+		for name := range localDefineNames(resolved.Main.Template.Source) {
+			taken[name] = "the main template"
+		}
+	}
+	for name, info := range resolved.Components {
+		taken[name] = "component " + name
+		if info.File.Template != nil {
+			queue = append(queue, FragmentRefs(info.File.Template.Source)...)
+		}
+	}
+
+	exported := make(map[string]bool) // pages already visited
+	failed := make(map[string]bool)   // pages that could not be exported
+	for len(queue) > 0 {
+		ref := queue[0]
+		queue = queue[1:]
+
+		slash := strings.LastIndex(ref, "/")
+		if slash <= 0 || slash == len(ref)-1 {
+			r.addError("fragment %q must be qualified with its page: {{fragment \"page/Name\" .}}", ref)
+			continue
+		}
+		page, name := ref[:slash], ref[slash+1:]
+
+		if !exported[page] {
+			exported[page] = true
+			refs, err := r.exportPageFragments(page, mainDir, resolved, taken)
+			if err != nil {
+				failed[page] = true
+				r.addError("fragment %q: %v", ref, err)
+				continue
+			}
+			queue = append(queue, refs...)
+		}
+
+		if _, ok := resolved.Fragments[ref]; !ok && !failed[page] {
+			r.addError("fragment %q: %s.gmx has no {{define %q}} block", ref, page, name)
+		}
+	}
+}
+
+// exportPageFragments loads a page and exports all its {{define}} blocks under
+// qualified names, returning the fragment references found in their bodies
+func (r *Resolver) exportPageFragments(page, mainDir string, resolved *ResolvedFile, taken map[string]string) ([]string, error) {
+	absPath, err := filepath.Abs(filepath.Join(mainDir, page+".gmx"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve page %s: %w", page, err)
+	}
+	file, err := r.loadFile(absPath)
+	if err != nil {
+		return nil, err
+	}
+	if file.Template == nil {
+		return nil, fmt.Errorf("%s.gmx has no template", page)
+	}
+
+	defines, err := pageDefines(file.Template.Source)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s.gmx template: %w", page, err)
+	}
+
+	names := make([]string, 0, len(defines))
+	for name := range defines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var refs []string
+	for _, name := range names {
+		qualified := page + "/" + name
+		if origin, ok := taken[qualified]; ok {
+			return nil, fmt.Errorf("fragment %s from %s collides with a template defined by %s", qualified, absPath, origin)
+		}
+		taken[qualified] = absPath
+
+		// References between the page's own defines must follow them under their qualified names
+		body := defines[name]
+		for _, other := range names {
+			body = strings.ReplaceAll(body, fmt.Sprintf("{{template %q", other), fmt.Sprintf("{{template %q", page+"/"+other))
+		}
+		resolved.Fragments[qualified] = &FragmentInfo{Name: qualified, Source: body, Path: absPath}
+		refs = append(refs, FragmentRefs(body)...)
+	}
+	return refs, nil
+}
@@ -0,0 +1,203 @@
+package resolver
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LockFile is the name of the lock file kept next to the main .gmx file
+const LockFile = "gmx.lock"
+
+// Lock pins the content of the imported .gmx files and the versions of the
+// Go modules providing native imports, so that a build fails when they
+// change unexpectedly
+type Lock struct {
+	Files   map[string]string // slash path relative to the main file → "sha256:<hex>"
+	Modules map[string]Module // module path → pinned version
+}
+
+// Module is a Go module version with its go.sum checksums
+type Module struct {
+	Version string
+	Sum     string // h1: hash of the module content
+	ModSum  string // h1: hash of its go.mod file
+}
+
+// NewLock returns an empty lock
+func NewLock() *Lock {
+	return &Lock{Files: make(map[string]string), Modules: make(map[string]Module)}
+}
+
+// HashContent returns the content hash recorded for a file
+func HashContent(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// AddSources records the content hashes of the imported files, keyed by
+// their path relative to the directory of the main file
+func (l *Lock) AddSources(mainDir string, sources map[string]string) error {
+	for absPath, hash := range sources {
+		rel, err := filepath.Rel(mainDir, absPath)
+		if err != nil {
+			return fmt.Errorf("locating %s: %w", absPath, err)
+		}
+		l.Files[filepath.ToSlash(rel)] = hash
+	}
+	return nil
+}
+
+// AddModules records the go.sum checksums of the modules providing the
+// given import paths; standard library packages have no module and are skipped
+func (l *Lock) AddModules(goSum []byte, importPaths []string) {
+	sums := make(map[string]Module) // "path version" → checksums
+	scanner := bufio.NewScanner(strings.NewReader(string(goSum)))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		path, version, hash := fields[0], fields[1], fields[2]
+		if v, ok := strings.CutSuffix(version, "/go.mod"); ok {
+			m := sums[path+" "+v]
+			m.Version, m.ModSum = v, hash
+			sums[path+" "+v] = m
+		} else {
+			m := sums[path+" "+version]
+			m.Version, m.Sum = version, hash
+			sums[path+" "+version] = m
+		}
+	}
+
+	for _, importPath := range importPaths {
+		// The longest module path that is a prefix of the import path provides it
+		best := ""
+		var found Module
+		for key, m := range sums {
+			modPath := strings.SplitN(key, " ", 2)[0]
+			if m.Sum == "" || len(modPath) <= len(best) {
+				continue
+			}
+			if importPath == modPath || strings.HasPrefix(importPath, modPath+"/") {
+				best, found = modPath, m
+			}
+		}
+		if best != "" {
+			l.Modules[best] = found
+		}
+	}
+}
+
+// Mismatches lists the entries of l whose hash or version differs in current;
+// files and modules present in only one of the locks are not mismatches
+func (l *Lock) Mismatches(current *Lock) []string {
+	var diffs []string
+	for _, path := range sortedKeys(l.Files) {
+		if hash, ok := current.Files[path]; ok && hash != l.Files[path] {
+			diffs = append(diffs, fmt.Sprintf("%s: content changed", path))
+		}
+	}
+	for _, path := range sortedKeys(l.Modules) {
+		locked := l.Modules[path]
+		m, ok := current.Modules[path]
+		if !ok {
+			continue
+		}
+		if m.Version != locked.Version {
+			diffs = append(diffs, fmt.Sprintf("module %s: version %s, locked %s", path, m.Version, locked.Version))
+		} else if m.Sum != locked.Sum {
+			diffs = append(diffs, fmt.Sprintf("module %s %s: checksum changed", path, m.Version))
+		}
+	}
+	return diffs
+}
+
+// IsEmpty reports whether the lock pins nothing
+func (l *Lock) IsEmpty() bool {
+	return len(l.Files) == 0 && len(l.Modules) == 0
+}
+
+// GoSumLines returns the go.sum lines of the locked modules, so a fresh
+// build module resolves the same versions
+func (l *Lock) GoSumLines() string {
+	var b strings.Builder
+	for _, path := range sortedKeys(l.Modules) {
+		m := l.Modules[path]
+		b.WriteString(fmt.Sprintf("%s %s %s\n", path, m.Version, m.Sum))
+		if m.ModSum != "" {
+			b.WriteString(fmt.Sprintf("%s %s/go.mod %s\n", path, m.Version, m.ModSum))
+		}
+	}
+	return b.String()
+}
+
+// Format renders the lock file, one sorted entry per line
+func (l *Lock) Format() string {
+	var b strings.Builder
+	b.WriteString("# gmx.lock pins the imported .gmx files and Go modules of this app.\n")
+	b.WriteString("# Generated by gmx build: commit it, and rebuild with --update to accept changes.\n")
+	for _, path := range sortedKeys(l.Files) {
+		b.WriteString(fmt.Sprintf("file %s %s\n", path, l.Files[path]))
+	}
+	for _, path := range sortedKeys(l.Modules) {
+		m := l.Modules[path]
+		b.WriteString(fmt.Sprintf("module %s %s %s", path, m.Version, m.Sum))
+		if m.ModSum != "" {
+			b.WriteString(" " + m.ModSum)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// ParseLock reads the content of a lock file
+func ParseLock(data string) (*Lock, error) {
+	l := NewLock()
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		switch {
+		case fields[0] == "file" && len(fields) == 3:
+			l.Files[fields[1]] = fields[2]
+		case fields[0] == "module" && (len(fields) == 4 || len(fields) == 5):
+			m := Module{Version: fields[2], Sum: fields[3]}
+			if len(fields) == 5 {
+				m.ModSum = fields[4]
+			}
+			l.Modules[fields[1]] = m
+		default:
+			return nil, fmt.Errorf("%s:%d: invalid entry %q", LockFile, i+1, line)
+		}
+	}
+	return l, nil
+}
+
+// ReadLock reads a lock file; it returns nil without error when the file does not exist
+func ReadLock(path string) (*Lock, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return ParseLock(string(data))
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,112 @@
+package resolver
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestResolveRecordsSources(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(tmpDir, "components"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	badge := []byte("<template>\n<span>{{.}}</span>\n</template>")
+	badgePath := filepath.Join(tmpDir, "components", "Badge.gmx")
+	if err := os.WriteFile(badgePath, badge, 0644); err != nil {
+		t.Fatal(err)
+	}
+	mainPath := filepath.Join(tmpDir, "main.gmx")
+	mainContent := "<script>\nimport Badge from \"./components/Badge.gmx\"\n</script>\n\n<template>\n{{template \"Badge\" .}}\n</template>"
+	if err := os.WriteFile(mainPath, []byte(mainContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	resolved, errs := New(tmpDir).Resolve(parseFile(t, mainPath), mainPath)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	lock := NewLock()
+	if err := lock.AddSources(tmpDir, resolved.Sources); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"components/Badge.gmx": HashContent(badge)}
+	if !reflect.DeepEqual(lock.Files, want) {
+		t.Errorf("Files = %v, want %v", lock.Files, want)
+	}
+}
+
+func TestLockAddModules(t *testing.T) {
+	goSum := `github.com/jinzhu/inflection v1.0.0 h1:content=
+github.com/jinzhu/inflection v1.0.0/go.mod h1:gomod=
+github.com/jinzhu/now v1.1.5 h1:now=
+golang.org/x/text v0.20.0/go.mod h1:text=
+`
+	lock := NewLock()
+	lock.AddModules([]byte(goSum), []string{"github.com/jinzhu/inflection", "strings", "golang.org/x/text/unicode/norm"})
+
+	want := map[string]Module{
+		"github.com/jinzhu/inflection": {Version: "v1.0.0", Sum: "h1:content=", ModSum: "h1:gomod="},
+	}
+	if !reflect.DeepEqual(lock.Modules, want) {
+		t.Errorf("Modules = %+v, want %+v", lock.Modules, want)
+	}
+}
+
+func TestLockFormatRoundTrip(t *testing.T) {
+	lock := NewLock()
+	lock.Files["components/Badge.gmx"] = "sha256:aa"
+	lock.Files["b.gmx"] = "sha256:bb"
+	lock.Modules["github.com/jinzhu/inflection"] = Module{Version: "v1.0.0", Sum: "h1:x=", ModSum: "h1:y="}
+
+	content := lock.Format()
+	parsed, err := ParseLock(content)
+	if err != nil {
+		t.Fatalf("ParseLock: %v", err)
+	}
+	if !reflect.DeepEqual(parsed, lock) {
+		t.Errorf("round trip = %+v, want %+v", parsed, lock)
+	}
+	if parsed.Format() != content {
+		t.Errorf("Format is not stable:\n%s\n%s", parsed.Format(), content)
+	}
+
+	if _, err := ParseLock("file only-a-path\n"); err == nil {
+		t.Error("expected an error for a malformed entry")
+	}
+}
+
+func TestLockMismatches(t *testing.T) {
+	locked := NewLock()
+	locked.Files["a.gmx"] = "sha256:1"
+	locked.Files["removed.gmx"] = "sha256:2"
+	locked.Modules["example.com/m"] = Module{Version: "v1.0.0", Sum: "h1:a="}
+	locked.Modules["example.com/n"] = Module{Version: "v1.0.0", Sum: "h1:b="}
+
+	current := NewLock()
+	current.Files["a.gmx"] = "sha256:changed"
+	current.Files["added.gmx"] = "sha256:3"
+	current.Modules["example.com/m"] = Module{Version: "v1.1.0", Sum: "h1:c="}
+	current.Modules["example.com/n"] = Module{Version: "v1.0.0", Sum: "h1:tampered="}
+
+	want := []string{
+		"a.gmx: content changed",
+		"module example.com/m: version v1.1.0, locked v1.0.0",
+		"module example.com/n v1.0.0: checksum changed",
+	}
+	if got := locked.Mismatches(current); !reflect.DeepEqual(got, want) {
+		t.Errorf("Mismatches = %v, want %v", got, want)
+	}
+	if got := locked.Mismatches(locked); len(got) != 0 {
+		t.Errorf("a lock should match itself, got %v", got)
+	}
+}
+
+func TestReadLockMissing(t *testing.T) {
+	lock, err := ReadLock(filepath.Join(t.TempDir(), LockFile))
+	if lock != nil || err != nil {
+		t.Errorf("ReadLock of a missing file = %v, %v; want nil, nil", lock, err)
+	}
+}
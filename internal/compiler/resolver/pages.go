@@ -0,0 +1,198 @@
+package resolver
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// PageRoute returns the route of a page from its path relative to the
+// directory build: index.gmx → /, tasks/index.gmx → /tasks, about.gmx → /about
+func PageRoute(rel string) string {
+	rel = strings.TrimSuffix(filepath.ToSlash(rel), ".gmx")
+	if rel == "index" {
+		return "/"
+	}
+	return "/" + strings.TrimSuffix(rel, "/index")
+}
+
+// ResolveDir resolves a directory build: every .gmx file under dir that no
+// other file imports is a page, served at the route derived from its path
+// (files without a template only contribute declarations). The declarations
+// of all pages and of their imports are merged into one file, whose
+// template joins the page templates
+func (r *Resolver) ResolveDir(dir string) (*ResolvedFile, []string) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		r.addError("failed to resolve directory %s: %v", dir, err)
+		return nil, r.errors
+	}
+
+	// Hidden directories (.git, .cache) hold no pages
+	var paths []string
+	err = filepath.WalkDir(absDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && path != absDir && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && filepath.Ext(path) == ".gmx" {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		r.addError("failed to read directory %s: %v", dir, err)
+		return nil, r.errors
+	}
+
+	files := make(map[string]*ast.GMXFile, len(paths))
+	imported := make(map[string]bool)
+	for _, path := range paths {
+		file, err := r.loadFile(path)
+		if err != nil {
+			r.addError("%v", err)
+			continue
+		}
+		files[path] = file
+		for _, imp := range file.Imports {
+			if imp.IsNative {
+				continue
+			}
+			if absPath, err := r.resolvePath(imp.Path, filepath.Dir(path)); err == nil {
+				imported[absPath] = true
+			}
+		}
+	}
+	if len(r.errors) > 0 {
+		return nil, r.errors
+	}
+
+	resolved := &ResolvedFile{
+		Main:       &ast.GMXFile{},
+		Components: make(map[string]*ComponentInfo),
+		Fragments:  make(map[string]*FragmentInfo),
+	}
+	routes := make(map[string]string)  // route → page path
+	defines := make(map[string]string) // {{define}} name → page path
+	pages := make(map[string]bool)     // absolute paths of the pages
+	serverPath := ""                   // page declaring the server block
+	observabilityPath := ""            // page declaring the observability block
+	// WalkDir visits files in lexical order
+	for _, path := range paths {
+		file, ok := files[path]
+		if !ok || imported[path] {
+			continue
+		}
+		pages[path] = true
+		rel, err := filepath.Rel(absDir, path)
+		if err != nil {
+			rel = path
+		}
+		r.mergePage(file, path, filepath.ToSlash(rel), resolved)
+		if file.Server != nil {
+			if serverPath != "" {
+				r.addError("server is declared by both %s and %s; declare it in one page", r.relPath(serverPath), r.relPath(path))
+			} else {
+				serverPath = path
+				resolved.Main.Server = file.Server
+			}
+		}
+		if file.Observability != nil {
+			if observabilityPath != "" {
+				r.addError("observability is declared by both %s and %s; declare it in one page", r.relPath(observabilityPath), r.relPath(path))
+			} else {
+				observabilityPath = path
+				resolved.Main.Observability = file.Observability
+			}
+		}
+
+		if file.Template == nil {
+			continue
+		}
+		route := PageRoute(rel)
+		if other, ok := routes[route]; ok {
+			r.addError("pages %s and %s are both served at %s", r.relPath(other), r.relPath(path), route)
+			continue
+		}
+		routes[route] = path
+		// All pages share one template set
+		for name := range localDefineNames(file.Template.Source) {
+			if other, ok := defines[name]; ok {
+				r.addError("{{define %q}} is declared by both %s and %s; pages share one template set, rename one of them", name, r.relPath(other), r.relPath(path))
+				continue
+			}
+			defines[name] = path
+		}
+		resolved.Main.Pages = append(resolved.Main.Pages, &ast.Page{
+			Route:    route,
+			Path:     path,
+			Template: file.Template,
+			Style:    file.Style,
+		})
+	}
+	if len(resolved.Main.Pages) == 0 && len(r.errors) == 0 {
+		r.addError("no page in %s: expected .gmx files with a <template> section", dir)
+	}
+	sort.Slice(resolved.Main.Pages, func(i, j int) bool {
+		return resolved.Main.Pages[i].Route < resolved.Main.Pages[j].Route
+	})
+
+	// <TaskItem task="{{.}}" /> → {{template "TaskItem" .}}
+	r.expandComponents(resolved, absDir)
+	if len(resolved.Main.Pages) > 0 {
+		templates := make([]string, len(resolved.Main.Pages))
+		for i, page := range resolved.Main.Pages {
+			templates[i] = page.Template.Source
+		}
+		resolved.Main.Template = &ast.TemplateBlock{Source: strings.Join(templates, "\n")}
+	}
+
+	// Export the fragments referenced from the pages: {{fragment "tasks/TaskRow" .}}
+	r.resolveFragments(resolved, absDir)
+
+	resolved.Sources = make(map[string]string, len(r.hashes))
+	for path, hash := range r.hashes {
+		if !pages[path] {
+			resolved.Sources[path] = hash
+		}
+	}
+
+	return resolved, r.errors
+}
+
+// mergePage adds the declarations of a page and of the files it imports to
+// the merged file; pages share one namespace like imported files do
+func (r *Resolver) mergePage(file *ast.GMXFile, path, rel string, resolved *ResolvedFile) {
+	page := &ast.ImportDecl{Default: rel, Path: rel}
+	for _, model := range file.Models {
+		r.mergeModel(model, model.Name, path, page, resolved)
+	}
+	for _, service := range file.Services {
+		r.mergeService(service, service.Name, path, page, resolved)
+	}
+	if file.Script != nil {
+		for _, fn := range file.Script.Funcs {
+			r.mergeFunc(fn, fn.Name, path, page, resolved)
+		}
+	}
+	resolved.Main.Vars = append(resolved.Main.Vars, file.Vars...)
+	resolved.Main.Settings = append(resolved.Main.Settings, file.Settings...)
+	resolved.Main.Wizards = append(resolved.Main.Wizards, file.Wizards...)
+
+	for _, imp := range file.Imports {
+		if imp.IsNative {
+			if !r.hasImport(resolved.Main, imp) {
+				resolved.Main.Imports = append(resolved.Main.Imports, imp)
+			}
+			continue
+		}
+		if err := r.resolveImport(imp, filepath.Dir(path), resolved); err != nil {
+			r.addError("%s: failed to resolve import %s: %v", rel, imp.Path, err)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package resolver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// resolveDir writes the given files under a temporary directory and resolves
+// it as a directory build
+func resolveDir(t *testing.T, files map[string]string) (*ResolvedFile, []string) {
+	t.Helper()
+	tmpDir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return New(tmpDir).ResolveDir(tmpDir)
+}
+
+func TestPageRoute(t *testing.T) {
+	tests := []struct {
+		rel  string
+		want string
+	}{
+		{"index.gmx", "/"},
+		{"about.gmx", "/about"},
+		{"tasks/index.gmx", "/tasks"},
+		{"tasks/archive.gmx", "/tasks/archive"},
+		{"admin/users/index.gmx", "/admin/users"},
+	}
+
+	for _, tt := range tests {
+		if got := PageRoute(tt.rel); got != tt.want {
+			t.Errorf("PageRoute(%q) = %q, want %q", tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestResolveDir(t *testing.T) {
+	resolved, errs := resolveDir(t, map[string]string{
+		"index.gmx": `<script>
+model Task {
+  id: uuid @pk
+  title: string
+}
+</script>
+
+<template>
+<h1>Home</h1>
+</template>`,
+		"tasks/index.gmx": `<script>
+import Badge from "../components/Badge.gmx"
+
+func createTask(title: string) error {
+  const task = Task{title: title}
+  try task.save()
+  return render(task)
+}
+</script>
+
+<template>
+<ul>{{range .Tasks}}<li>{{template "Badge" .}}</li>{{end}}</ul>
+</template>
+
+<style>
+ul { margin: 0; }
+</style>`,
+		"components/Badge.gmx": `<template>
+<span>{{.Title}}</span>
+</template>`,
+		".drafts/index.gmx": `<template>
+<p>ignored</p>
+</template>`,
+	})
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	pages := resolved.Main.Pages
+	if len(pages) != 2 || pages[0].Route != "/" || pages[1].Route != "/tasks" {
+		t.Fatalf("expected pages / and /tasks, got %+v", pages)
+	}
+	if pages[1].Style == nil || filepath.Base(pages[1].Path) != "index.gmx" {
+		t.Errorf("expected /tasks to keep its style and path, got %+v", pages[1])
+	}
+	if _, ok := resolved.Components["Badge"]; !ok {
+		t.Error("expected the imported component to be resolved, not served as a page")
+	}
+	if len(resolved.Main.Models) != 1 || resolved.Main.Script == nil || len(resolved.Main.Script.Funcs) != 1 {
+		t.Errorf("expected the model and function of the pages to be merged, got %d models and script %+v", len(resolved.Main.Models), resolved.Main.Script)
+	}
+	if !strings.Contains(resolved.Main.Template.Source, "<h1>Home</h1>") || !strings.Contains(resolved.Main.Template.Source, "{{range .Tasks}}") {
+		t.Errorf("expected the template to join the pages, got %q", resolved.Main.Template.Source)
+	}
+	for path := range resolved.Sources {
+		if filepath.Base(filepath.Dir(path)) != "components" {
+			t.Errorf("expected only imported files in Sources, got %s", path)
+		}
+	}
+}
+
+func TestResolveDirErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr string
+	}{
+		{
+			"same route",
+			map[string]string{
+				"about.gmx":       "<template>\n<p>a</p>\n</template>",
+				"about/index.gmx": "<template>\n<p>b</p>\n</template>",
+			},
+			"pages about/index.gmx and about.gmx are both served at /about",
+		},
+		{
+			"define shared by two pages",
+			map[string]string{
+				"index.gmx": "<template>\n{{define \"row\"}}a{{end}}\n</template>",
+				"about.gmx": "<template>\n{{define \"row\"}}b{{end}}\n</template>",
+			},
+			`{{define "row"}} is declared by both about.gmx and index.gmx`,
+		},
+		{
+			"colliding functions",
+			map[string]string{
+				"index.gmx": "<script>\nfunc save() error {\n  return nil\n}\n</script>\n<template>\n<p>a</p>\n</template>",
+				"about.gmx": "<script>\nfunc save() error {\n  return nil\n}\n</script>\n<template>\n<p>b</p>\n</template>",
+			},
+			"func save from index.gmx collides with func save from about.gmx; rename one of them",
+		},
+		{
+			"no page",
+			map[string]string{
+				"models.gmx": "<script>\nmodel Task {\n  id: uuid @pk\n}\n</script>",
+			},
+			"no page in",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := resolveDir(t, tt.files)
+			if len(errs) == 0 || !strings.Contains(errs[0], tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, errs)
+			}
+		})
+	}
+}
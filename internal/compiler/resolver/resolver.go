@@ -22,6 +22,8 @@ type ComponentInfo struct {
 type ResolvedFile struct {
 	Main       *ast.GMXFile              // enriched main file (merged declarations)
 	Components map[string]*ComponentInfo // component metadata for templates
+	Fragments  map[string]*FragmentInfo  // other pages' fragments, by qualified name
+	Sources    map[string]string         // absolute path of every imported .gmx file → content hash
 }
 
 // Resolver handles recursive import resolution for .gmx files
@@ -29,6 +31,8 @@ type Resolver struct {
 	basePath string                  // directory of root .gmx file
 	parsed   map[string]*ast.GMXFile // cache: absolute path → parsed AST
 	loading  map[string]bool         // circular import detection
+	hashes   map[string]string       // absolute path → content hash of the loaded files
+	symbols  map[string]symbol       // merged models, services and funcs, by Go name
 	errors   []string
 }
 
@@ -38,6 +42,8 @@ func New(basePath string) *Resolver {
 		basePath: basePath,
 		parsed:   make(map[string]*ast.GMXFile),
 		loading:  make(map[string]bool),
+		hashes:   make(map[string]string),
+		symbols:  make(map[string]symbol),
 		errors:   []string{},
 	}
 }
@@ -96,6 +102,7 @@ func (r *Resolver) loadFile(absPath string) (*ast.GMXFile, error) {
 
 	// Cache the file
 	r.parsed[absPath] = file
+	r.hashes[absPath] = HashContent(data)
 
 	return file, nil
 }
@@ -105,12 +112,17 @@ func (r *Resolver) Resolve(main *ast.GMXFile, mainPath string) (*ResolvedFile, [
 	resolved := &ResolvedFile{
 		Main:       &ast.GMXFile{}, // create new file to accumulate merged declarations
 		Components: make(map[string]*ComponentInfo),
+		Fragments:  make(map[string]*FragmentInfo),
 	}
 
 	// Copy main file's non-import declarations
 	resolved.Main.Models = append([]*ast.ModelDecl{}, main.Models...)
 	resolved.Main.Services = append([]*ast.ServiceDecl{}, main.Services...)
 	resolved.Main.Vars = append([]*ast.VarDecl{}, main.Vars...)
+	resolved.Main.Settings = append([]*ast.SettingDecl{}, main.Settings...)
+	resolved.Main.Wizards = append([]*ast.WizardDecl{}, main.Wizards...)
+	resolved.Main.Server = main.Server
+	resolved.Main.Observability = main.Observability
 	resolved.Main.Template = main.Template
 	resolved.Main.Style = main.Style
 
@@ -123,6 +135,9 @@ func (r *Resolver) Resolve(main *ast.GMXFile, mainPath string) (*ResolvedFile, [
 		}
 	}
 
+	// Imported declarations share the namespace of the main file's
+	r.declareMain(main, mainPath)
+
 	// Get directory of main file for relative imports
 	mainDir := filepath.Dir(mainPath)
 
@@ -140,6 +155,19 @@ func (r *Resolver) Resolve(main *ast.GMXFile, mainPath string) (*ResolvedFile, [
 		}
 	}
 
+	// <TaskItem task="{{.}}" /> → {{template "TaskItem" .}}
+	r.expandComponents(resolved, mainPath)
+
+	// Export the fragments referenced from other pages: {{fragment "tasks/TaskRow" .}}
+	r.resolveFragments(resolved, mainDir)
+
+	resolved.Sources = make(map[string]string, len(r.hashes))
+	for path, hash := range r.hashes {
+		if path != mainPath {
+			resolved.Sources[path] = hash
+		}
+	}
+
 	return resolved, r.errors
 }
 
@@ -189,7 +217,7 @@ func (r *Resolver) resolveImport(imp *ast.ImportDecl, currentDir string, resolve
 		return r.resolveDefaultImport(imp, file, absPath, resolved)
 	} else if len(imp.Members) > 0 {
 		// Destructured import: specific exports
-		return r.resolveDestructuredImport(imp, file, resolved)
+		return r.resolveDestructuredImport(imp, file, absPath, resolved)
 	}
 
 	return nil
@@ -221,79 +249,35 @@ func (r *Resolver) resolveDefaultImport(imp *ast.ImportDecl, file *ast.GMXFile,
 
 	// Merge models (not functions - components are self-contained)
 	for _, model := range file.Models {
-		if !r.hasModel(resolved.Main, model.Name) {
-			resolved.Main.Models = append(resolved.Main.Models, model)
-		} else {
-			r.addError("warning: model %s already defined, skipping import from %s", model.Name, absPath)
-		}
+		r.mergeModel(model, model.Name, absPath, imp, resolved)
 	}
 
 	// Merge services
 	for _, service := range file.Services {
-		if !r.hasService(resolved.Main, service.Name) {
-			resolved.Main.Services = append(resolved.Main.Services, service)
-		} else {
-			r.addError("warning: service %s already defined, skipping import from %s", service.Name, absPath)
-		}
+		r.mergeService(service, service.Name, absPath, imp, resolved)
 	}
 
 	return nil
 }
 
 // resolveDestructuredImport handles: import { sendEmail, MailerConfig } from './services/mailer.gmx'
-func (r *Resolver) resolveDestructuredImport(imp *ast.ImportDecl, file *ast.GMXFile, resolved *ResolvedFile) error {
-	for _, memberName := range imp.Members {
-		found := false
-
-		// Try to find in models
-		for _, model := range file.Models {
-			if model.Name == memberName {
-				if !r.hasModel(resolved.Main, model.Name) {
-					resolved.Main.Models = append(resolved.Main.Models, model)
-					found = true
-				} else {
-					r.addError("warning: model %s already defined, skipping", model.Name)
-					found = true
-				}
-				break
-			}
-		}
-		if found {
-			continue
-		}
+// Members may be renamed with an alias: import { Task as TodoTask } from './todo.gmx'
+func (r *Resolver) resolveDestructuredImport(imp *ast.ImportDecl, file *ast.GMXFile, absPath string, resolved *ResolvedFile) error {
+	r.checkAliasedModels(imp, file)
 
-		// Try to find in services
-		for _, service := range file.Services {
-			if service.Name == memberName {
-				if !r.hasService(resolved.Main, service.Name) {
-					resolved.Main.Services = append(resolved.Main.Services, service)
-					found = true
-				} else {
-					r.addError("warning: service %s already defined, skipping", service.Name)
-					found = true
-				}
-				break
-			}
-		}
-		if found {
-			continue
-		}
-
-		// Try to find in functions
-		if file.Script != nil {
-			for _, fn := range file.Script.Funcs {
-				if fn.Name == memberName {
-					if resolved.Main.Script == nil {
-						resolved.Main.Script = &ast.ScriptBlock{}
-					}
-					resolved.Main.Script.Funcs = append(resolved.Main.Script.Funcs, fn)
-					found = true
-					break
-				}
-			}
+	for _, memberName := range imp.Members {
+		name := memberName
+		if alias, ok := imp.Aliases[memberName]; ok {
+			name = alias
 		}
 
-		if !found {
+		if model := findModel(file, memberName); model != nil {
+			r.mergeModel(model, name, absPath, imp, resolved)
+		} else if service := findService(file, memberName); service != nil {
+			r.mergeService(service, name, absPath, imp, resolved)
+		} else if fn := findFunc(file, memberName); fn != nil {
+			r.mergeFunc(fn, name, absPath, imp, resolved)
+		} else {
 			return fmt.Errorf("imported member %s not found in %s", memberName, imp.Path)
 		}
 	}
@@ -301,21 +285,33 @@ func (r *Resolver) resolveDestructuredImport(imp *ast.ImportDecl, file *ast.GMXF
 	return nil
 }
 
-// Helper functions for duplicate detection
-func (r *Resolver) hasModel(file *ast.GMXFile, name string) bool {
+// Helper functions to look up the exports of an imported file
+func findModel(file *ast.GMXFile, name string) *ast.ModelDecl {
 	for _, m := range file.Models {
 		if m.Name == name {
-			return true
+			return m
 		}
 	}
-	return false
+	return nil
 }
 
-func (r *Resolver) hasService(file *ast.GMXFile, name string) bool {
+func findService(file *ast.GMXFile, name string) *ast.ServiceDecl {
 	for _, s := range file.Services {
 		if s.Name == name {
-			return true
+			return s
 		}
 	}
-	return false
+	return nil
+}
+
+func findFunc(file *ast.GMXFile, name string) *ast.FuncDecl {
+	if file.Script == nil {
+		return nil
+	}
+	for _, fn := range file.Script.Funcs {
+		if fn.Name == name {
+			return fn
+		}
+	}
+	return nil
 }
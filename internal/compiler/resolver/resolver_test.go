@@ -623,3 +623,114 @@ import NoTemplate from "./notemplate.gmx"
 		t.Errorf("expected 'no template' error, got: %v", errors)
 	}
 }
+
+func TestResolveFragments(t *testing.T) {
+	tasksPage := `<template>
+{{define "TaskRow"}}<li>{{.Title}} {{template "Badge" .}}</li>{{end}}
+{{define "Badge"}}<span>{{.ID}}</span>{{end}}
+<ul>{{range .Tasks}}{{template "TaskRow" .}}{{end}}</ul>
+</template>`
+
+	tests := []struct {
+		name       string
+		main       string
+		pages      map[string]string
+		wantFrags  []string
+		wantSource map[string]string
+		wantErr    string
+	}{
+		{
+			name:      "exports page defines",
+			main:      `<template><ul>{{range .Tasks}}{{fragment "tasks/TaskRow" .}}{{end}}</ul></template>`,
+			pages:     map[string]string{"tasks.gmx": tasksPage},
+			wantFrags: []string{"tasks/Badge", "tasks/TaskRow"},
+			wantSource: map[string]string{
+				"tasks/TaskRow": `<li>{{.Title}} {{template "tasks/Badge" .}}</li>`,
+			},
+		},
+		{
+			name: "follows nested references",
+			main: `<template>{{fragment "a/Outer" .}}</template>`,
+			pages: map[string]string{
+				"a.gmx": `<template>{{define "Outer"}}<div>{{fragment "b/Inner" .}}</div>{{end}}</template>`,
+				"b.gmx": `<template>{{define "Inner"}}<p>inner</p>{{end}}</template>`,
+			},
+			wantFrags: []string{"a/Outer", "b/Inner"},
+		},
+		{
+			name:    "missing define",
+			main:    `<template>{{fragment "tasks/Missing" .}}</template>`,
+			pages:   map[string]string{"tasks.gmx": tasksPage},
+			wantErr: `tasks.gmx has no {{define "Missing"}} block`,
+		},
+		{
+			name:    "missing page",
+			main:    `<template>{{fragment "nowhere/Row" .}}</template>`,
+			wantErr: `fragment "nowhere/Row"`,
+		},
+		{
+			name:    "unqualified name",
+			main:    `<template>{{fragment "TaskRow" .}}</template>`,
+			wantErr: "must be qualified with its page",
+		},
+		{
+			name:    "collision with main template",
+			main:    `<template>{{define "tasks/Badge"}}x{{end}}{{fragment "tasks/TaskRow" .}}</template>`,
+			pages:   map[string]string{"tasks.gmx": tasksPage},
+			wantErr: "fragment tasks/Badge from",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			for name, content := range tt.pages {
+				if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			mainPath := filepath.Join(tmpDir, "main.gmx")
+			if err := os.WriteFile(mainPath, []byte(tt.main), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			r := New(tmpDir)
+			resolved, errs := r.Resolve(parseFile(t, mainPath), mainPath)
+
+			if tt.wantErr != "" {
+				if len(errs) == 0 {
+					t.Fatalf("expected error containing %q, got none", tt.wantErr)
+				}
+				if !strings.Contains(strings.Join(errs, "\n"), tt.wantErr) {
+					t.Errorf("expected error containing %q, got %v", tt.wantErr, errs)
+				}
+				return
+			}
+			if len(errs) > 0 {
+				t.Fatalf("unexpected errors: %v", errs)
+			}
+
+			if len(resolved.Fragments) != len(tt.wantFrags) {
+				t.Errorf("expected %d fragments, got %d", len(tt.wantFrags), len(resolved.Fragments))
+			}
+			for _, name := range tt.wantFrags {
+				if _, ok := resolved.Fragments[name]; !ok {
+					t.Errorf("fragment %q not exported", name)
+				}
+			}
+			for name, want := range tt.wantSource {
+				if got := resolved.Fragments[name].Source; got != want {
+					t.Errorf("fragment %q source = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRewriteFragmentCalls(t *testing.T) {
+	src := `{{range .Tasks}}{{- fragment "tasks/TaskRow" .}}{{end}}`
+	want := `{{range .Tasks}}{{- template "tasks/TaskRow" .}}{{end}}`
+	if got := RewriteFragmentCalls(src); got != want {
+		t.Errorf("RewriteFragmentCalls() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,212 @@
+package script
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/token"
+)
+
+// A remember block caches the records of an expensive query in a redis
+// service, which serves them until the time to live expires:
+//
+//	let tasks = try Cache.remember("tasks:open", 5m) {
+//	  return Task.where(done: false).order(createdAt, desc)
+//	}
+//
+// The block runs when the key is missing, and ends by returning a model
+// query or Model.find(); records are cached as JSON
+
+// isRememberCall reports whether a let value, possibly under try, calls
+// remember on a service: Cache.remember(...)
+func isRememberCall(expr ast.Expression) bool {
+	if try, ok := expr.(*ast.TryExpr); ok {
+		expr = try.Expr
+	}
+	call, ok := expr.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	member, ok := call.Function.(*ast.MemberExpr)
+	if !ok || member.Property != "remember" {
+		return false
+	}
+	_, ok = member.Object.(*ast.Ident)
+	return ok
+}
+
+// parseRememberBlock parses the block following Cache.remember(key, ttl) in
+// a let or const, replacing the call with a RememberExpr
+func (p *Parser) parseRememberBlock(value ast.Expression) ast.Expression {
+	p.requireFeature("remember blocks")
+	try, _ := value.(*ast.TryExpr)
+	if try != nil {
+		value = try.Expr
+	}
+	call := value.(*ast.CallExpr)
+	member := call.Function.(*ast.MemberExpr)
+	expr := &ast.RememberExpr{
+		Cache: member.Object.(*ast.Ident).Name,
+		Line:  call.Line,
+	}
+	if len(call.Args) == 2 {
+		expr.Key, expr.TTL = call.Args[0], call.Args[1]
+	} else {
+		p.error(fmt.Sprintf("%s.remember takes a key and a time to live, got %d arguments", expr.Cache, len(call.Args)))
+	}
+
+	p.nextToken() // '{'
+	expr.Body = p.parseBlockStatement()
+	if !p.curTokenIs(token.RBRACE) {
+		p.error("unterminated remember block")
+	}
+
+	if try != nil {
+		try.Expr = expr
+		return try
+	}
+	return expr
+}
+
+// isTry reports whether a value is a try expression
+func isTry(expr ast.Expression) bool {
+	_, ok := expr.(*ast.TryExpr)
+	return ok
+}
+
+// rememberValue returns the remember block a let value assigns, or nil
+func rememberValue(expr ast.Expression) *ast.RememberExpr {
+	if try, ok := expr.(*ast.TryExpr); ok {
+		expr = try.Expr
+	}
+	remember, _ := expr.(*ast.RememberExpr)
+	return remember
+}
+
+// recordsType returns the records a remember block caches, as tracked in
+// varTypes: "[]Task" for a query, "Task" for first() or find(); "" when
+// the block does not end by returning one
+func (t *Transpiler) recordsType(expr *ast.RememberExpr) string {
+	if !t.endsWithReturn(expr.Body) {
+		return ""
+	}
+	return t.queryType(expr.Body[len(expr.Body)-1].(*ast.ReturnStmt).Value)
+}
+
+// queryType returns the records a model query or Model.find() loads, as
+// tracked in varTypes, or "" for other values
+func (t *Transpiler) queryType(expr ast.Expression) string {
+	if try, ok := expr.(*ast.TryExpr); ok {
+		expr = try.Expr
+	}
+	call, ok := expr.(*ast.CallExpr)
+	if !ok {
+		return ""
+	}
+	if model, calls, ok := t.modelQuery(call); ok {
+		if queryMethod(calls[len(calls)-1]) == "first" {
+			return model
+		}
+		return "[]" + model
+	}
+	if member, ok := call.Function.(*ast.MemberExpr); ok && member.Property == "find" {
+		if ident, ok := member.Object.(*ast.Ident); ok && t.isModelType(ident.Name) {
+			return ident.Name
+		}
+	}
+	return ""
+}
+
+// transpileRememberLet assigns the records of a remember block, read from
+// the cache or loaded by the block inside a closure:
+//
+//	tasks, err := cacheRemember(cacheRedis, "tasks:open", 5 * time.Minute, func() ([]Task, error) {
+//		return TaskWhere(ctx.DB, queryWhere("done", false))
+//	})
+func (t *Transpiler) transpileRememberLet(stmt *ast.LetStmt, expr *ast.RememberExpr) {
+	client, ok := t.caches[expr.Cache]
+	switch {
+	case !ok:
+		t.errors = append(t.errors, fmt.Sprintf("line %d: %s.remember: %s is not a redis service", expr.Line, expr.Cache, expr.Cache))
+		return
+	case !isTry(stmt.Value):
+		t.errors = append(t.errors, fmt.Sprintf("line %d: %s.remember fails when its block does; use let %s = try %s.remember(...)", expr.Line, expr.Cache, stmt.Name, expr.Cache))
+		return
+	case expr.Key == nil:
+		return // reported by the parser
+	}
+	if _, ok := expr.TTL.(*ast.IntLit); ok {
+		t.errors = append(t.errors, fmt.Sprintf("line %d: %s.remember: the time to live is a duration, such as 5m", expr.Line, expr.Cache))
+		return
+	}
+	records := t.recordsType(expr)
+	if records == "" {
+		t.errors = append(t.errors, fmt.Sprintf("line %d: %s.remember: the block must end by returning a model query or Model.find()", expr.Line, expr.Cache))
+		return
+	}
+	goType := records
+	if !strings.HasPrefix(records, "[]") {
+		goType = "*" + records
+	}
+
+	t.emitIndent()
+	t.emitLineComment(stmt.Line)
+	t.emit("%s, err := cacheRemember(%s, %s, %s, func() (%s, error) {\n", stmt.Name, client, t.transpileExpr(expr.Key), t.transpileExpr(expr.TTL), goType)
+	t.indent++
+	outer := t.rememberType
+	t.rememberType = goType
+	for _, s := range expr.Body {
+		t.transpileStmt(s)
+	}
+	t.rememberType = outer
+	t.indent--
+	t.emitIndent()
+	t.emit("})\n")
+	t.emitIndent()
+	t.emit("if err != nil {\n")
+	t.indent++
+	t.emitIndent()
+	t.emit("%s\n", t.errReturn())
+	t.indent--
+	t.emitIndent()
+	t.emit("}\n")
+	t.varTypes[stmt.Name] = records
+}
+
+// transpileRememberReturn leaves a remember block with its records, or
+// with an error, which is not cached
+func (t *Transpiler) transpileRememberReturn(stmt *ast.ReturnStmt) {
+	switch {
+	case stmt.Value == nil || isRender(stmt.Value):
+		t.errors = append(t.errors, fmt.Sprintf("line %d: a remember block returns the records to cache; render after it", stmt.Line))
+	case isErrorValue(stmt.Value):
+		t.emit("return nil, %s\n", t.transpileExpr(stmt.Value))
+	case t.queryType(stmt.Value) == strings.TrimPrefix(t.rememberType, "*"):
+		t.emit("return %s\n", t.transpileExpr(stmt.Value))
+	default:
+		t.errors = append(t.errors, fmt.Sprintf("line %d: a remember block returns a model query or Model.find() loading its %s records", stmt.Line, strings.TrimPrefix(t.rememberType, "*")))
+	}
+}
+
+// isErrorValue reports whether a returned value is an error("...")
+func isErrorValue(expr ast.Expression) bool {
+	if _, ok := expr.(*ast.ErrorExpr); ok {
+		return true
+	}
+	if call, ok := expr.(*ast.CallExpr); ok {
+		if ident, ok := call.Function.(*ast.Ident); ok && ident.Name == "error" {
+			return true
+		}
+	}
+	return false
+}
+
+// errReturn returns the statement leaving the current function with err,
+// or the enclosing remember block without records
+func (t *Transpiler) errReturn() string {
+	if t.rememberType != "" {
+		return "return nil, err"
+	}
+	return "return err"
+}
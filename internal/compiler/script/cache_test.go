@@ -0,0 +1,190 @@
+package script
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/lang"
+)
+
+const rememberScript = `func listOpen() error {
+  let tasks = try Cache.remember("tasks:open", 5m) {
+    if ctx.user == "" {
+      return error("sign in")
+    }
+    return Task.where(done: false).order(title)
+  }
+  return render(tasks)
+}`
+
+// transpileRemember transpiles a script using remember blocks with a Task
+// model and a Cache redis service
+func transpileRemember(t *testing.T, input string) *TranspileResult {
+	t.Helper()
+	result, errs := ParseVersion(queryModels+input, 0, v11)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	return TranspileModels(&ast.ScriptBlock{Funcs: result.Funcs}, result.Models, []string{"Cache"}, nil)
+}
+
+func TestParseRemember(t *testing.T) {
+	result, errs := ParseVersion(rememberScript, 0, lang.Version{Major: 1, Minor: 1})
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+
+	body := result.Funcs[0].Body
+	if len(body) != 2 {
+		t.Fatalf("expected 2 statements, got %d", len(body))
+	}
+	let, ok := body[0].(*ast.LetStmt)
+	if !ok {
+		t.Fatalf("expected a LetStmt, got %T", body[0])
+	}
+	try, ok := let.Value.(*ast.TryExpr)
+	if !ok {
+		t.Fatalf("expected a TryExpr, got %T", let.Value)
+	}
+	remember, ok := try.Expr.(*ast.RememberExpr)
+	if !ok {
+		t.Fatalf("expected a RememberExpr, got %T", try.Expr)
+	}
+	if remember.Cache != "Cache" || remember.Line != 2 || len(remember.Body) != 2 {
+		t.Errorf("expected Cache at line 2 with 2 statements, got %s at line %d with %d", remember.Cache, remember.Line, len(remember.Body))
+	}
+	if _, ok := remember.TTL.(*ast.DurationLit); !ok {
+		t.Errorf("expected a duration as time to live, got %T", remember.TTL)
+	}
+}
+
+func TestParseRememberErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		version lang.Version
+		wantErr string
+	}{
+		{
+			"older language version",
+			"func f() error {\n  let tasks = try Cache.remember(\"k\", ttl) {\n    return Task.all()\n  }\n}",
+			lang.Default,
+			"remember blocks require gmx 1.1",
+		},
+		{
+			"missing time to live",
+			"func f() error {\n  let tasks = try Cache.remember(\"k\") {\n    return Task.all()\n  }\n}",
+			lang.Version{Major: 1, Minor: 1},
+			"Cache.remember takes a key and a time to live, got 1 arguments",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := ParseVersion(tt.input, 0, tt.version)
+			if len(errs) == 0 || !strings.Contains(errs[0], tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, errs)
+			}
+		})
+	}
+}
+
+func TestTranspileRemember(t *testing.T) {
+	out := transpileRemember(t, rememberScript)
+	if len(out.Errors) > 0 {
+		t.Fatalf("transpile errors: %v", out.Errors)
+	}
+	code := out.GoCode
+
+	for _, want := range []string{
+		`tasks, err := cacheRemember(cacheRedis, "tasks:open", 5 * time.Minute, func() ([]Task, error) {`,
+		"return nil, &ValidationError{Message: \"sign in\"}\n",
+		"return TaskWhere(ctx.DB, queryWhere(\"done\", false), queryOrder(\"title\", false))\n\t})\n",
+		// The records are a collection, rendered item by item
+		"for _, item := range tasks {",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in:\n%s", want, code)
+		}
+	}
+}
+
+func TestTranspileRememberRecord(t *testing.T) {
+	out := transpileRemember(t, `func show(id: uuid) error {
+  const task = try Cache.remember("task:{id}", 1h) {
+    let found = try Task.find(id)
+    return Task.find(found.id)
+  }
+  try task.save()
+  return render(task)
+}`)
+	if len(out.Errors) > 0 {
+		t.Fatalf("transpile errors: %v", out.Errors)
+	}
+	for _, want := range []string{
+		`task, err := cacheRemember(cacheRedis, fmt.Sprintf("task:%v", id), 1 * time.Hour, func() (*Task, error) {`,
+		// A failing try leaves the block without records
+		"found, err := TaskFind(ctx.DB, id)\n\t\tif err != nil {\n\t\t\treturn nil, err\n\t\t}\n",
+		"\tif err != nil {\n\t\treturn err\n\t}\n",
+		// The cached record is a Task: its instance methods are known
+		"TaskSave(ctx.DB, task)",
+	} {
+		if !strings.Contains(out.GoCode, want) {
+			t.Errorf("expected %q in:\n%s", want, out.GoCode)
+		}
+	}
+}
+
+func TestTranspileRememberErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{
+			"not a cache",
+			"func f() error {\n  let tasks = try Store.remember(\"k\", 5m) {\n    return Task.all()\n  }\n  return render(tasks)\n}",
+			"line 9: Store.remember: Store is not a redis service",
+		},
+		{
+			"without try",
+			"func f() error {\n  let tasks = Cache.remember(\"k\", 5m) {\n    return Task.all()\n  }\n  return render(tasks)\n}",
+			"line 9: Cache.remember fails when its block does; use let tasks = try Cache.remember(...)",
+		},
+		{
+			"number of seconds",
+			"func f() error {\n  let tasks = try Cache.remember(\"k\", 300) {\n    return Task.all()\n  }\n  return render(tasks)\n}",
+			"line 9: Cache.remember: the time to live is a duration, such as 5m",
+		},
+		{
+			"not returning a query",
+			"func f() error {\n  let tasks = try Cache.remember(\"k\", 5m) {\n    let all = try Task.all()\n    return all\n  }\n  return render(tasks)\n}",
+			"line 9: Cache.remember: the block must end by returning a model query or Model.find()",
+		},
+		{
+			"records of another type",
+			"func f(id: uuid) error {\n  let tasks = try Cache.remember(\"k\", 5m) {\n    if id == \"\" {\n      return Task.find(id)\n    }\n    return Task.all()\n  }\n  return render(tasks)\n}",
+			"line 11: a remember block returns a model query or Model.find() loading its []Task records",
+		},
+		{
+			"render inside the block",
+			"func f() error {\n  let tasks = try Cache.remember(\"k\", 5m) {\n    if ctx.user == \"\" {\n      return render(nil)\n    }\n    return Task.all()\n  }\n  return render(tasks)\n}",
+			"line 11: a remember block returns the records to cache; render after it",
+		},
+		{
+			"saga inside the block",
+			"func f() error {\n  let tasks = try Cache.remember(\"k\", 5m) {\n    saga {\n      step {\n      }\n    }\n    return Task.all()\n  }\n  return render(tasks)\n}",
+			"line 10: a saga cannot run in a remember block",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := transpileRemember(t, tt.input)
+			if len(out.Errors) == 0 || !strings.Contains(out.Errors[0], tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, out.Errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package script
+
+import "github.com/btouchard/gmx/internal/compiler/ast"
+
+// Complexity returns the cyclomatic complexity of a script function: 1 plus
+// one per branch point — if, for, && and ||, try, which returns early on error,
+// and remember, whose block only runs when the cache misses
+func Complexity(fn *ast.FuncDecl) int {
+	complexity := 1
+	ast.Inspect(fn, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.IfStmt, *ast.ForStmt, *ast.TryExpr, *ast.RememberExpr:
+			complexity++
+		case *ast.BinaryExpr:
+			if n.Op == "&&" || n.Op == "||" {
+				complexity++
+			}
+		}
+		return true
+	})
+	return complexity
+}
@@ -0,0 +1,101 @@
+package script
+
+import (
+	"fmt"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/token"
+)
+
+// parseCompileIf parses a compile-time conditional block; the generator keeps
+// the declarations whose condition holds for the app being built:
+//
+//	#if provider(Database) == "postgres" {
+//	  func searchTasks(q: string) error { ... }
+//	} else {
+//	  func searchTasks(q: string) error { ... }
+//	}
+//
+// outer is the condition of the enclosing block, nil at the top level
+func (p *Parser) parseCompileIf(result *ParseResult, outer ast.Expression) {
+	line := p.curToken.Pos.Line + p.lineOffset
+	if !p.expectPeek(token.IF) {
+		return
+	}
+	p.nextToken()
+	cond := p.parseExpression(LOWEST)
+	if cond == nil {
+		return
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return
+	}
+	if !p.parseCompileBlock(result, andCond(outer, cond, line)) {
+		return
+	}
+
+	if p.peekTokenIs(token.ELSE) {
+		p.nextToken()
+		if !p.expectPeek(token.LBRACE) {
+			return
+		}
+		p.parseCompileBlock(result, andCond(outer, &ast.UnaryExpr{Op: "!", Operand: cond, Line: line}, line))
+	}
+}
+
+// parseCompileBlock parses the declarations of a #if or else block, leaving
+// the parser on its closing brace
+func (p *Parser) parseCompileBlock(result *ParseResult, cond ast.Expression) bool {
+	p.nextToken() // move past '{'
+
+	var pending []*ast.Annotation
+	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
+		switch p.curToken.Type {
+		case token.AT:
+			ann := p.parseAnnotation()
+			if ann == nil {
+				p.nextToken() // skip the malformed annotation to ensure progress
+				continue
+			}
+			pending = append(pending, ann)
+
+		case token.FUNC:
+			if fn := p.parseFuncDecl(); fn != nil {
+				fn.Annotations = pending
+				fn.Cond = cond
+				result.Funcs = append(result.Funcs, fn)
+			}
+			pending = nil
+			p.nextToken() // Move past the closing brace
+
+		case token.LET, token.CONST:
+			if varDecl := p.parseVarDecl(p.curTokenIs(token.CONST)); varDecl != nil {
+				varDecl.Cond = cond
+				result.Vars = append(result.Vars, varDecl)
+			}
+			p.nextToken() // Move past the declaration
+
+		case token.HASH:
+			p.parseCompileIf(result, cond)
+			p.nextToken() // Move past the closing brace
+
+		default:
+			p.error(fmt.Sprintf("#if blocks may only contain func, let, const and nested #if declarations, got %s", p.curToken.Type))
+			p.nextToken()
+		}
+	}
+
+	if !p.curTokenIs(token.RBRACE) {
+		p.error("#if block is missing its closing }")
+		return false
+	}
+	return true
+}
+
+// andCond combines the condition of a nested block with its enclosing one
+func andCond(outer, cond ast.Expression, line int) ast.Expression {
+	if outer == nil {
+		return cond
+	}
+	return &ast.BinaryExpr{Left: outer, Op: "&&", Right: cond, Line: line}
+}
@@ -0,0 +1,80 @@
+package script
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+func TestParseCompileIf(t *testing.T) {
+	input := `#if provider(Database) == "postgres" {
+  let MODE = "fulltext"
+  @negotiate
+  func search(q: string) error {
+    return nil
+  }
+} else {
+  func search(q: string) error {
+    return nil
+  }
+  #if env == "dev" {
+    func reset() error {
+      return nil
+    }
+  }
+}
+
+func list() error {
+  return nil
+}`
+
+	result, errs := Parse(input, 0)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	if len(result.Funcs) != 4 || len(result.Vars) != 1 {
+		t.Fatalf("expected 4 funcs and 1 var, got %d and %d", len(result.Funcs), len(result.Vars))
+	}
+
+	pg, fallback, reset, list := result.Funcs[0], result.Funcs[1], result.Funcs[2], result.Funcs[3]
+	if cond, ok := pg.Cond.(*ast.BinaryExpr); !ok || cond.Op != "==" {
+		t.Errorf("expected the #if comparison on the postgres variant, got %#v", pg.Cond)
+	}
+	if pg.FindAnnotation("negotiate") == nil {
+		t.Error("annotations inside #if blocks must apply to their func")
+	}
+	if result.Vars[0].Cond != pg.Cond {
+		t.Error("declarations of the same block must share its condition")
+	}
+	if cond, ok := fallback.Cond.(*ast.UnaryExpr); !ok || cond.Op != "!" || cond.Operand != pg.Cond {
+		t.Errorf("expected the negated condition on the else variant, got %#v", fallback.Cond)
+	}
+	if cond, ok := reset.Cond.(*ast.BinaryExpr); !ok || cond.Op != "&&" || cond.Left != fallback.Cond {
+		t.Errorf("expected the nested condition to extend the else branch, got %#v", reset.Cond)
+	}
+	if list.Cond != nil {
+		t.Errorf("declarations after the block are unconditional, got %#v", list.Cond)
+	}
+}
+
+func TestParseCompileIfErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"missing if", "#provider(Database) {\n}", "expected next token to be IF"},
+		{"model inside", "#if env == \"dev\" {\n  model Task {\n    id: uuid @pk\n  }\n}", "#if blocks may only contain func, let, const and nested #if declarations"},
+		{"unterminated", "#if env == \"dev\" {\n  let x = 1\n", "#if block is missing its closing }"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := Parse(tt.input, 0)
+			if len(errs) == 0 || !strings.Contains(strings.Join(errs, "\n"), tt.want) {
+				t.Errorf("errors = %v, want one containing %q", errs, tt.want)
+			}
+		})
+	}
+}
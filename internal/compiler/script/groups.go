@@ -0,0 +1,99 @@
+package script
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/token"
+)
+
+// isGroupStart reports whether the current token opens a route group:
+// group is a contextual keyword, followed by the path prefix
+func (p *Parser) isGroupStart() bool {
+	return p.curTokenIs(token.IDENT) && p.curToken.Literal == "group" && p.peekTokenIs(token.STRING)
+}
+
+// parseGroupDecl parses a route group and returns its functions:
+//
+//	group "/admin" @auth @role(admin) {
+//	  func listUsers() error { ... }
+//	}
+//
+// The group annotations apply to every function, which may add its own or
+// override one of the same name; the functions are served under the prefix
+func (p *Parser) parseGroupDecl() []*ast.FuncDecl {
+	p.nextToken() // move to the prefix
+	prefix := strings.TrimSuffix(p.curToken.Literal, "/")
+	if !strings.HasPrefix(prefix, "/") || prefix == "" || strings.ContainsAny(prefix, " \t{}") {
+		p.error(fmt.Sprintf("invalid route group prefix %q: expected a path such as \"/admin\"", p.curToken.Literal))
+		return nil
+	}
+	p.nextToken()
+
+	var groupAnnotations []*ast.Annotation
+	for p.curTokenIs(token.AT) {
+		ann := p.parseAnnotation()
+		if ann == nil {
+			return nil
+		}
+		groupAnnotations = append(groupAnnotations, ann)
+	}
+	if !p.curTokenIs(token.LBRACE) {
+		p.error(fmt.Sprintf("expected { after route group %s, got %s", prefix, p.curToken.Type))
+		return nil
+	}
+	p.nextToken()
+
+	var funcs []*ast.FuncDecl
+	var pending []*ast.Annotation
+	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
+		switch p.curToken.Type {
+		case token.AT:
+			ann := p.parseAnnotation()
+			if ann == nil {
+				p.nextToken() // skip the malformed annotation to ensure progress
+				continue
+			}
+			pending = append(pending, ann)
+
+		case token.FUNC:
+			if fn := p.parseFuncDecl(); fn != nil {
+				fn.Annotations = mergeAnnotations(groupAnnotations, pending)
+				fn.RoutePrefix = prefix
+				funcs = append(funcs, fn)
+			}
+			pending = nil
+			p.nextToken() // Move past the closing brace
+
+		default:
+			p.error(fmt.Sprintf("route group %s may only contain func declarations, got %s", prefix, p.curToken.Type))
+			p.nextToken()
+		}
+	}
+
+	if !p.curTokenIs(token.RBRACE) {
+		p.error(fmt.Sprintf("route group %s is missing its closing }", prefix))
+		return nil
+	}
+	return funcs
+}
+
+// mergeAnnotations returns the group annotations followed by the function's
+// own, a function annotation replacing the group annotation of the same name
+func mergeAnnotations(group, own []*ast.Annotation) []*ast.Annotation {
+	merged := make([]*ast.Annotation, 0, len(group)+len(own))
+	for _, ann := range group {
+		overridden := false
+		for _, o := range own {
+			if o.Name == ann.Name {
+				overridden = true
+				break
+			}
+		}
+		if !overridden {
+			merged = append(merged, ann)
+		}
+	}
+	return append(merged, own...)
+}
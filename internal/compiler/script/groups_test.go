@@ -0,0 +1,76 @@
+package script
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRouteGroup(t *testing.T) {
+	input := `group "/admin/" @auth @role(admin) {
+  func listUsers() error {
+    return nil
+  }
+
+  @role(owner)
+  @captcha(turnstile)
+  func deleteUser(id: uuid) error {
+    return nil
+  }
+}
+
+func listPosts() error {
+  return nil
+}`
+
+	result, errs := Parse(input, 0)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	if len(result.Funcs) != 3 {
+		t.Fatalf("expected 3 funcs, got %d", len(result.Funcs))
+	}
+
+	annotations := func(i int) string {
+		var names []string
+		for _, ann := range result.Funcs[i].Annotations {
+			names = append(names, ann.Name+"("+ann.SimpleArg()+")")
+		}
+		return strings.Join(names, " ")
+	}
+
+	list, remove, posts := result.Funcs[0], result.Funcs[1], result.Funcs[2]
+	if list.RoutePrefix != "/admin" || remove.RoutePrefix != "/admin" {
+		t.Errorf("expected the trimmed /admin prefix, got %q and %q", list.RoutePrefix, remove.RoutePrefix)
+	}
+	if got := annotations(0); got != "auth() role(admin)" {
+		t.Errorf("listUsers annotations = %s", got)
+	}
+	if got := annotations(1); got != "auth() role(owner) captcha(turnstile)" {
+		t.Errorf("deleteUser annotations = %s, want its own role to override the group's", got)
+	}
+	if posts.RoutePrefix != "" || len(posts.Annotations) != 0 {
+		t.Errorf("functions after the group must not inherit it, got %q %v", posts.RoutePrefix, posts.Annotations)
+	}
+}
+
+func TestParseRouteGroupErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"relative prefix", "group \"admin\" {\n}", `invalid route group prefix "admin"`},
+		{"root prefix", "group \"/\" {\n}", `invalid route group prefix "/"`},
+		{"variable inside", "group \"/admin\" {\n  let x = 1\n}", "route group /admin may only contain func declarations"},
+		{"unterminated", "group \"/admin\" {\n  func a() error {\n    return nil\n  }\n", "route group /admin is missing its closing }"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := Parse(tt.input, 0)
+			if len(errs) == 0 || !strings.Contains(strings.Join(errs, "\n"), tt.want) {
+				t.Errorf("errors = %v, want one containing %q", errs, tt.want)
+			}
+		})
+	}
+}
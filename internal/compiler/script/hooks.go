@@ -0,0 +1,94 @@
+package script
+
+import (
+	"fmt"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/token"
+)
+
+// hookDecl is a before/after block wrapping the body of script functions:
+//
+//	before createTask, updateTask {
+//	  if ctx.User == "" {
+//	    return error("sign in first")
+//	  }
+//	}
+type hookDecl struct {
+	kind    string // "before" or "after"
+	targets []string
+	body    []ast.Statement
+	line    int
+}
+
+// isHookStart reports whether the current token opens a hook: before/after
+// are contextual keywords, followed by the name of a function
+func (p *Parser) isHookStart() bool {
+	return p.curTokenIs(token.IDENT) && (p.curToken.Literal == "before" || p.curToken.Literal == "after") &&
+		p.peekTokenIs(token.IDENT)
+}
+
+// parseHookDecl parses: before|after funcName[, funcName...] { statements }
+func (p *Parser) parseHookDecl() *hookDecl {
+	hook := &hookDecl{
+		kind: p.curToken.Literal,
+		line: p.curToken.Pos.Line + p.lineOffset,
+	}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	hook.targets = append(hook.targets, p.curToken.Literal)
+	for p.peekTokenIs(token.COMMA) {
+		p.nextToken() // consume comma
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		hook.targets = append(hook.targets, p.curToken.Literal)
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	hook.body = p.parseBlockStatement()
+	if !p.curTokenIs(token.RBRACE) {
+		p.error(fmt.Sprintf("expected } after %s hook body, got %s", hook.kind, p.curToken.Type))
+		return nil
+	}
+
+	return hook
+}
+
+// attachHooks splices the hook statements into their target functions, in
+// declaration order. Hooks only wrap handlers: their statements may return
+// an error, which helpers returning a value cannot propagate
+func attachHooks(funcs []*ast.FuncDecl, hooks []*hookDecl) []string {
+	var errs []string
+	// #if blocks may declare one variant of a function per branch
+	byName := make(map[string][]*ast.FuncDecl, len(funcs))
+	for _, fn := range funcs {
+		byName[fn.Name] = append(byName[fn.Name], fn)
+	}
+
+	for _, hook := range hooks {
+		for _, target := range hook.targets {
+			variants, ok := byName[target]
+			if !ok {
+				errs = append(errs, fmt.Sprintf("line %d: %s hook targets unknown func %s; hooks must be declared in the file of their function", hook.line, hook.kind, target))
+				continue
+			}
+			for _, fn := range variants {
+				if fn.ReturnType != "" && fn.ReturnType != "error" {
+					errs = append(errs, fmt.Sprintf("line %d: %s hook targets func %s, which returns %s: hooks only wrap handlers returning error", hook.line, hook.kind, target, fn.ReturnType))
+					break
+				}
+				if hook.kind == "before" {
+					fn.Before = append(fn.Before, hook.body...)
+				} else {
+					fn.After = append(fn.After, hook.body...)
+				}
+			}
+		}
+	}
+	return errs
+}
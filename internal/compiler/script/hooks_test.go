@@ -0,0 +1,78 @@
+package script
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseHooks(t *testing.T) {
+	input := `before createTask, deleteTask {
+  if ctx.User == "" {
+    return error("sign in first")
+  }
+}
+
+after createTask {
+  try audit.record("created")
+}
+
+func createTask(title: string) error {
+  return nil
+}
+
+func deleteTask(id: uuid) error {
+  return nil
+}`
+
+	result, errs := Parse(input, 0)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	if len(result.Funcs) != 2 {
+		t.Fatalf("expected 2 funcs, got %d", len(result.Funcs))
+	}
+
+	create, remove := result.Funcs[0], result.Funcs[1]
+	if len(create.Before) != 1 || len(create.After) != 1 {
+		t.Errorf("createTask: expected 1 before and 1 after statement, got %d and %d", len(create.Before), len(create.After))
+	}
+	if len(remove.Before) != 1 || len(remove.After) != 0 {
+		t.Errorf("deleteTask: expected 1 before statement only, got %d and %d", len(remove.Before), len(remove.After))
+	}
+	if len(create.Body) != 1 {
+		t.Errorf("hooks must not change the body, got %d statements", len(create.Body))
+	}
+}
+
+func TestParseHookErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			"unknown function",
+			"before createTask {\n  return nil\n}",
+			"line 1: before hook targets unknown func createTask",
+		},
+		{
+			"helper function",
+			"after label {\n  return nil\n}\nfunc label() string {\n  return \"x\"\n}",
+			"line 1: after hook targets func label, which returns string: hooks only wrap handlers returning error",
+		},
+		{
+			"missing body",
+			"before createTask\nfunc createTask() error {\n  return nil\n}",
+			"expected next token to be {",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := Parse(tt.input, 0)
+			if len(errs) == 0 || !strings.Contains(strings.Join(errs, "\n"), tt.want) {
+				t.Errorf("errors = %v, want one containing %q", errs, tt.want)
+			}
+		})
+	}
+}
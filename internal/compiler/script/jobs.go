@@ -0,0 +1,114 @@
+package script
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/token"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// isEnqueueStart reports whether the current token opens an enqueue statement:
+// enqueue is a contextual keyword, followed by the called function
+func (p *Parser) isEnqueueStart() bool {
+	return p.curTokenIs(token.IDENT) && p.curToken.Literal == "enqueue" && p.peekTokenIs(token.IDENT)
+}
+
+// parseEnqueueStatement parses enqueue sendWelcomeEmail(user.id)
+func (p *Parser) parseEnqueueStatement() *ast.EnqueueStmt {
+	stmt := &ast.EnqueueStmt{
+		Line: p.curToken.Pos.Line + p.lineOffset,
+	}
+	p.requireFeature("enqueue statements")
+
+	p.nextToken()
+	call, ok := p.parseExpression(LOWEST).(*ast.CallExpr)
+	if !ok {
+		p.error("enqueue takes a call of a @job function, such as enqueue sendWelcomeEmail(user.id)")
+		return nil
+	}
+	if _, ok := call.Function.(*ast.Ident); !ok {
+		p.error("enqueue takes a call of a @job function, such as enqueue sendWelcomeEmail(user.id)")
+		return nil
+	}
+	stmt.Call = call
+	return stmt
+}
+
+// enqueueHelper is the name of the helper queuing a run of a @job function
+func enqueueHelper(name string) string {
+	return "enqueue" + utils.Capitalize(name)
+}
+
+// storedJobRunner is the name of the runner of the stored runs of a @job
+// function, which decodes their arguments
+func storedJobRunner(name string) string {
+	return "runStored" + utils.Capitalize(name)
+}
+
+// transpileEnqueueStmt queues a run of a @job function through its helper;
+// a full queue, or a server shutting down, fails the enclosing function
+func (t *Transpiler) transpileEnqueueStmt(stmt *ast.EnqueueStmt) {
+	name := stmt.Call.Function.(*ast.Ident).Name
+	if t.returnType != "error" && t.sagaDepth == 0 {
+		t.errors = append(t.errors, fmt.Sprintf("line %d: enqueue requires a function returning error", stmt.Line))
+		return
+	}
+	// A function transpiled on its own does not know the other functions
+	if t.queued != nil {
+		fn, ok := t.queued[name]
+		if !ok {
+			t.errors = append(t.errors, fmt.Sprintf("line %d: enqueue %s: %s is not a @job function", stmt.Line, name, name))
+			return
+		}
+		if len(stmt.Call.Args) != len(fn.Params) {
+			t.errors = append(t.errors, fmt.Sprintf("line %d: enqueue %s: %s takes %d argument(s), got %d", stmt.Line, name, name, len(fn.Params), len(stmt.Call.Args)))
+			return
+		}
+	}
+
+	args := []string{"ctx"}
+	for _, arg := range stmt.Call.Args {
+		args = append(args, t.transpileExpr(arg))
+	}
+	t.emitIndent()
+	t.emitLineComment(stmt.Line)
+	t.emit("if err := %s(%s); err != nil {\n", enqueueHelper(name), strings.Join(args, ", "))
+	t.indent++
+	t.emitIndent()
+	t.emit("%s\n", t.errReturn())
+	t.indent--
+	t.emitIndent()
+	t.emit("}\n")
+}
+
+// genEnqueueHelper emits the helper queuing a run of a @job function, whose
+// arguments are evaluated when it is queued, and the runner of the runs
+// stored in the database with their arguments encoded as JSON
+func (t *Transpiler) genEnqueueHelper(fn *ast.FuncDecl) {
+	var params, args, vars []string
+	for _, param := range fn.Params {
+		params = append(params, fmt.Sprintf(", %s %s", param.Name, t.transpileType(param.Type)))
+		args = append(args, param.Name)
+		vars = append(vars, "&"+param.Name)
+	}
+	call := strings.Join(append([]string{"ctx"}, args...), ", ")
+	t.emit("// %s queues a run of the @job function %s\n", enqueueHelper(fn.Name), fn.Name)
+	t.emit("func %s(ctx *GMXContext%s) error {\n", enqueueHelper(fn.Name), strings.Join(params, ""))
+	t.emit("\treturn enqueueJob(ctx, %q, []any{%s}, func(ctx *GMXContext) error {\n", fn.Name, strings.Join(args, ", "))
+	t.emit("\t\treturn %s(%s)\n", fn.Name, call)
+	t.emit("\t})\n")
+	t.emit("}\n\n")
+
+	t.emit("// %s runs a stored run of the @job function %s\n", storedJobRunner(fn.Name), fn.Name)
+	t.emit("func %s(ctx *GMXContext, args []json.RawMessage) error {\n", storedJobRunner(fn.Name))
+	for _, param := range fn.Params {
+		t.emit("\tvar %s %s\n", param.Name, t.transpileType(param.Type))
+	}
+	t.emit("\tif err := decodeJobArgs(args%s); err != nil {\n", strings.Join(append([]string{""}, vars...), ", "))
+	t.emit("\t\treturn err\n")
+	t.emit("\t}\n")
+	t.emit("\treturn %s(%s)\n", fn.Name, call)
+	t.emit("}\n")
+}
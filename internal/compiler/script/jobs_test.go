@@ -0,0 +1,146 @@
+package script
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/lang"
+)
+
+const jobScript = `@job
+func sendWelcomeEmail(id: uuid, email: string) error {
+  return nil
+}
+
+func signUp(email: string) error {
+  const user = User{email: email}
+  try user.save()
+  enqueue sendWelcomeEmail(user.id, email)
+  return render(user)
+}`
+
+func TestParseEnqueue(t *testing.T) {
+	result, errs := ParseVersion(jobScript, 0, lang.Version{Major: 1, Minor: 1})
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+
+	body := result.Funcs[1].Body
+	if len(body) != 4 {
+		t.Fatalf("expected 4 statements, got %d", len(body))
+	}
+	stmt, ok := body[2].(*ast.EnqueueStmt)
+	if !ok {
+		t.Fatalf("expected an EnqueueStmt, got %T", body[2])
+	}
+	if name := stmt.Call.Function.(*ast.Ident).Name; name != "sendWelcomeEmail" {
+		t.Errorf("expected sendWelcomeEmail, got %s", name)
+	}
+	if len(stmt.Call.Args) != 2 || stmt.Line != 9 {
+		t.Errorf("expected 2 arguments at line 9, got %d at line %d", len(stmt.Call.Args), stmt.Line)
+	}
+
+	// enqueue stays usable as a name
+	if _, errs := ParseVersion("func f() error {\n  let enqueue = 1\n  return nil\n}", 0, lang.Version{Major: 1, Minor: 1}); len(errs) > 0 {
+		t.Errorf("expected enqueue as a variable name to parse, got %v", errs)
+	}
+}
+
+func TestParseEnqueueErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		version lang.Version
+		wantErr string
+	}{
+		{
+			"older language version",
+			"func f() error {\n  enqueue g()\n}",
+			lang.Default,
+			"enqueue statements require gmx 1.1",
+		},
+		{
+			"not a call",
+			"func f() error {\n  enqueue g\n}",
+			lang.Version{Major: 1, Minor: 1},
+			"enqueue takes a call of a @job function",
+		},
+		{
+			"method call",
+			"func f() error {\n  enqueue Mailer.send()\n}",
+			lang.Version{Major: 1, Minor: 1},
+			"enqueue takes a call of a @job function",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := ParseVersion(tt.input, 0, tt.version)
+			if len(errs) == 0 || !strings.Contains(errs[0], tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, errs)
+			}
+		})
+	}
+}
+
+func TestTranspileEnqueue(t *testing.T) {
+	result, errs := ParseVersion(jobScript, 0, lang.Version{Major: 1, Minor: 1})
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+
+	out := Transpile(&ast.ScriptBlock{Funcs: result.Funcs}, []string{"User"})
+	if len(out.Errors) > 0 {
+		t.Fatalf("transpile errors: %v", out.Errors)
+	}
+	for _, want := range []string{
+		"func enqueueSendWelcomeEmail(ctx *GMXContext, id string, email string) error {",
+		`return enqueueJob(ctx, "sendWelcomeEmail", []any{id, email}, func(ctx *GMXContext) error {`,
+		"return sendWelcomeEmail(ctx, id, email)",
+		"func runStoredSendWelcomeEmail(ctx *GMXContext, args []json.RawMessage) error {",
+		"if err := decodeJobArgs(args, &id, &email); err != nil {",
+		"if err := enqueueSendWelcomeEmail(ctx, user.ID, email); err != nil {",
+	} {
+		if !strings.Contains(out.GoCode, want) {
+			t.Errorf("expected %q in:\n%s", want, out.GoCode)
+		}
+	}
+}
+
+func TestTranspileEnqueueErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{
+			"not a @job function",
+			"func g() error {\n  return nil\n}\n\nfunc f() error {\n  enqueue g()\n  return nil\n}",
+			"line 6: enqueue g: g is not a @job function",
+		},
+		{
+			"argument count",
+			"@job\nfunc g(id: int) error {\n  return nil\n}\n\nfunc f() error {\n  enqueue g()\n  return nil\n}",
+			"line 7: enqueue g: g takes 1 argument(s), got 0",
+		},
+		{
+			"function not returning error",
+			"@job\nfunc g() error {\n  return nil\n}\n\nfunc f() string {\n  enqueue g()\n  return \"\"\n}",
+			"line 7: enqueue requires a function returning error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, errs := ParseVersion(tt.input, 0, lang.Version{Major: 1, Minor: 1})
+			if len(errs) > 0 {
+				t.Fatalf("parse errors: %v", errs)
+			}
+			out := Transpile(&ast.ScriptBlock{Funcs: result.Funcs}, nil)
+			if len(out.Errors) == 0 || !strings.Contains(out.Errors[0], tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, out.Errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package script
+
+// genSaveHelper emits the Save ORM helper of a model, which stores obj with
+// save; the changes of a @live model are then broadcast to the open pages
+func (t *Transpiler) genSaveHelper(model, save string) {
+	t.emit("func %sSave(db *gorm.DB, obj *%s) error {\n", model, model)
+	zero, live := t.live[model]
+	if !live {
+		t.emit("\treturn %s\n", save)
+		t.emit("}\n\n")
+		return
+	}
+	t.emit("\tcreated := obj.%s == %s\n", t.pkFields[model], zero)
+	t.emit("\tif err := %s; err != nil {\n", save)
+	t.emit("\t\treturn err\n")
+	t.emit("\t}\n")
+	t.emit("\tbroadcastLive(%q, obj, created)\n", model)
+	t.emit("\treturn nil\n")
+	t.emit("}\n\n")
+}
+
+// genDeleteHelper emits the Delete ORM helper of a model, which deletes obj
+// with del; the open pages then remove the item of a @live model
+func (t *Transpiler) genDeleteHelper(model, del string) {
+	t.emit("func %sDelete(db *gorm.DB, obj *%s) error {\n", model, model)
+	if _, live := t.live[model]; !live {
+		t.emit("\treturn %s\n", del)
+		t.emit("}\n\n")
+		return
+	}
+	t.emit("\tif err := %s; err != nil {\n", del)
+	t.emit("\t\treturn err\n")
+	t.emit("\t}\n")
+	t.emit("\tbroadcastLiveDelete(%q, fmt.Sprint(obj.%s))\n", model, t.pkFields[model])
+	t.emit("\treturn nil\n")
+	t.emit("}\n\n")
+}
@@ -0,0 +1,56 @@
+package script
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+func TestTranspileLiveHelpers(t *testing.T) {
+	tests := []struct {
+		name   string
+		models string
+		want   []string
+	}{
+		{"uuid", "@live\nmodel Task {\n  id: uuid @pk\n  title: string\n}", []string{
+			"created := obj.ID == \"\"\n\tif err := db.Save(obj).Error; err != nil {",
+			`broadcastLive("Task", obj, created)`,
+			`broadcastLiveDelete("Task", fmt.Sprint(obj.ID))`,
+		}},
+		{"int", "@live\nmodel Task {\n  num: int @pk\n}", []string{
+			"created := obj.Num == 0",
+		}},
+		{"repository", "@live\n@repository(\"TaskRepo\")\nmodel Task {\n  id: uuid @pk\n}", []string{
+			"if err := taskRepository.Save(db, obj); err != nil {",
+			"if err := taskRepository.Delete(db, obj); err != nil {",
+			`broadcastLive("Task", obj, created)`,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, errs := ParseVersion(tt.models, 0, v11)
+			if len(errs) > 0 {
+				t.Fatalf("parse errors: %v", errs)
+			}
+			out := TranspileModels(&ast.ScriptBlock{}, result.Models, nil, nil)
+			for _, want := range tt.want {
+				if !strings.Contains(out.GoCode, want) {
+					t.Errorf("expected %q in:\n%s", want, out.GoCode)
+				}
+			}
+		})
+	}
+}
+
+func TestTranspileHelpersWithoutLive(t *testing.T) {
+	result, errs := ParseVersion("model Task {\n  id: uuid @pk\n}", 0, v11)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	out := TranspileModels(&ast.ScriptBlock{}, result.Models, nil, nil)
+	if !strings.Contains(out.GoCode, "\treturn db.Save(obj).Error\n") || strings.Contains(out.GoCode, "broadcastLive") {
+		t.Errorf("models without @live are saved without broadcasts:\n%s", out.GoCode)
+	}
+}
@@ -0,0 +1,97 @@
+package script
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+// Scripts log through the logger of their context, which carries the
+// request ID:
+//
+//	log.info("created task", task.id) → ctx.Log.Info("created task", "task.id", task.ID)
+//
+// Values are keyed by their source, or by their name when named:
+//
+//	log.warn("quota reached", used: count) → ctx.Log.Warn("quota reached", "used", count)
+
+// logLevels maps the methods of log to those of slog.Logger
+var logLevels = map[string]string{"debug": "Debug", "info": "Info", "warn": "Warn", "error": "Error"}
+
+// logCall returns the method of a log.<method>(...) call, or false when call
+// does not log
+func (t *Transpiler) logCall(call *ast.CallExpr) (string, bool) {
+	member, ok := call.Function.(*ast.MemberExpr)
+	if !ok {
+		return "", false
+	}
+	ident, ok := member.Object.(*ast.Ident)
+	if !ok || ident.Name != "log" {
+		return "", false
+	}
+	// A parameter named log is not the logger
+	if _, shadowed := t.varTypes["log"]; shadowed {
+		return "", false
+	}
+	return member.Property, true
+}
+
+// transpileLogCall transpiles a log call into a call of the logger of the
+// context: the message first, then a key and a value per argument
+func (t *Transpiler) transpileLogCall(call *ast.CallExpr, method string) string {
+	level, ok := logLevels[method]
+	if !ok {
+		t.errors = append(t.errors, fmt.Sprintf("line %d: unknown log level log.%s (expected debug, info, warn or error)", call.Line, method))
+		return "nil"
+	}
+	if len(call.Args) == 0 {
+		t.errors = append(t.errors, fmt.Sprintf("line %d: log.%s takes a message", call.Line, method))
+		return "nil"
+	}
+
+	var msg string
+	switch arg := call.Args[0].(type) {
+	case *ast.StringLit:
+		msg = fmt.Sprintf("%q", arg.Value)
+	case *ast.NamedArg:
+		t.errors = append(t.errors, fmt.Sprintf("line %d: log.%s takes a message before its named arguments", call.Line, method))
+		return "nil"
+	default:
+		msg = fmt.Sprintf("fmt.Sprint(%s)", t.transpileExpr(arg))
+	}
+
+	args := []string{msg}
+	for i, arg := range call.Args[1:] {
+		if named, ok := arg.(*ast.NamedArg); ok {
+			args = append(args, fmt.Sprintf("%q", named.Name), t.transpileExpr(named.Value))
+			continue
+		}
+		args = append(args, fmt.Sprintf("%q", logKey(arg, i+1)), t.transpileExpr(arg))
+	}
+	return fmt.Sprintf("ctx.Log.%s(%s)", level, strings.Join(args, ", "))
+}
+
+// logKey returns the key of a positional log argument: its source for a
+// variable or a field (task.id), its position otherwise (arg1)
+func logKey(arg ast.Expression, pos int) string {
+	if path, ok := identPath(arg); ok {
+		return path
+	}
+	return fmt.Sprintf("arg%d", pos)
+}
+
+// identPath returns the source of a variable or a chain of fields on one
+func identPath(expr ast.Expression) (string, bool) {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return e.Name, true
+	case *ast.CtxExpr:
+		return "ctx." + e.Field, true
+	case *ast.MemberExpr:
+		if obj, ok := identPath(e.Object); ok {
+			return obj + "." + e.Property, true
+		}
+	}
+	return "", false
+}
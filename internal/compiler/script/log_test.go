@@ -0,0 +1,76 @@
+package script
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+func TestTranspileLog(t *testing.T) {
+	tests := []struct {
+		name string
+		call string
+		want string
+	}{
+		{"keyed by source", `log.info("created task", task.id)`, `ctx.Log.Info("created task", "task.id", task.ID)`},
+		{"named", `log.warn("quota reached", used: count)`, `ctx.Log.Warn("quota reached", "used", count)`},
+		{"keyed by position", `log.debug("sum", count + 1)`, `ctx.Log.Debug("sum", "arg1", count + 1)`},
+		{"error level", `log.error("import failed", ctx.user)`, `ctx.Log.Error("import failed", "ctx.user", ctx.User)`},
+		{"message expression", `log.info(task.title)`, `ctx.Log.Info(fmt.Sprint(task.Title))`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := "func f(count: int) error {\n  let task = try Task.find(\"1\")\n  " + tt.call + "\n  return nil\n}"
+			parsed, errs := ParseVersion(input, 0, v11)
+			if len(errs) > 0 {
+				t.Fatalf("parse errors: %v", errs)
+			}
+			result := Transpile(&ast.ScriptBlock{Funcs: parsed.Funcs}, []string{"Task"})
+			if len(result.Errors) > 0 {
+				t.Fatalf("transpile errors: %v", result.Errors)
+			}
+			if !strings.Contains(result.GoCode, tt.want) {
+				t.Errorf("expected %q in:\n%s", tt.want, result.GoCode)
+			}
+		})
+	}
+}
+
+func TestTranspileLogErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		call    string
+		wantErr string
+	}{
+		{"unknown level", `log.trace("x")`, "line 2: unknown log level log.trace (expected debug, info, warn or error)"},
+		{"without message", `log.info()`, "line 2: log.info takes a message"},
+		{"named message", `log.info(msg: "x")`, "line 2: log.info takes a message before its named arguments"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := "func f() error {\n  " + tt.call + "\n  return nil\n}"
+			parsed, errs := ParseVersion(input, 0, v11)
+			if len(errs) > 0 {
+				t.Fatalf("parse errors: %v", errs)
+			}
+			result := Transpile(&ast.ScriptBlock{Funcs: parsed.Funcs}, nil)
+			if len(result.Errors) != 1 || result.Errors[0] != tt.wantErr {
+				t.Errorf("expected %q, got %v", tt.wantErr, result.Errors)
+			}
+		})
+	}
+}
+
+func TestTranspileLogShadowed(t *testing.T) {
+	parsed, errs := Parse("func f() error {\n  let log = try Task.find(\"1\")\n  try log.delete()\n  return nil\n}", 0)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	result := Transpile(&ast.ScriptBlock{Funcs: parsed.Funcs}, []string{"Task"})
+	if len(result.Errors) > 0 || !strings.Contains(result.GoCode, "TaskDelete(ctx.DB, log)") {
+		t.Errorf("expected a record named log not to be the logger, got %v:\n%s", result.Errors, result.GoCode)
+	}
+}
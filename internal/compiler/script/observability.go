@@ -0,0 +1,63 @@
+package script
+
+import (
+	"fmt"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/token"
+)
+
+// The observability block configures the monitoring of the application,
+// decided at build time:
+//
+//	observability {
+//	  metrics: true
+//	}
+
+// observabilityOptions are the options of an observability block and the
+// kind of their value
+var observabilityOptions = map[string]string{
+	// Serves Prometheus metrics of the routes and queries at /metrics
+	"metrics": "bool",
+}
+
+// isObservabilityStart reports whether the current token opens the
+// observability block: observability is a contextual keyword, followed by a
+// brace
+func (p *Parser) isObservabilityStart() bool {
+	return p.curTokenIs(token.IDENT) && p.curToken.Literal == "observability" && p.peekTokenIs(token.LBRACE)
+}
+
+// parseObservabilityDecl parses observability { name: value ... }; it stops
+// on the closing brace
+func (p *Parser) parseObservabilityDecl() *ast.ObservabilityDecl {
+	obs := &ast.ObservabilityDecl{
+		Line: p.curToken.Pos.Line + p.lineOffset,
+	}
+	p.requireFeature("observability blocks")
+
+	p.nextToken() // move to {
+	p.nextToken()
+	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
+		if p.curTokenIs(token.COMMA) {
+			p.nextToken()
+			continue
+		}
+		opt := p.parseServerOption("observability", observabilityOptions, obs.Options)
+		if opt == nil {
+			return nil
+		}
+		// The instrumentation is generated or not: a variable cannot turn it on
+		if opt.EnvVar != "" {
+			p.errors = append(p.errors, fmt.Sprintf("line %d: observability: %s is decided at build time, write true or false", opt.Line, opt.Name))
+			return nil
+		}
+		obs.Options = append(obs.Options, opt)
+	}
+
+	if !p.curTokenIs(token.RBRACE) {
+		p.error("unterminated observability block")
+		return nil
+	}
+	return obs
+}
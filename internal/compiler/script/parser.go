@@ -3,6 +3,7 @@ package script
 import (
 	"fmt"
 	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/lang"
 	"github.com/btouchard/gmx/internal/compiler/lexer"
 	"github.com/btouchard/gmx/internal/compiler/parser/shared"
 	"github.com/btouchard/gmx/internal/compiler/token"
@@ -21,7 +22,7 @@ const (
 	SUM         // + -
 	PRODUCT     // * / %
 	UNARY       // ! -
-	CALL        // . ()
+	CALL        // . () []
 )
 
 var precedences = map[token.TokenType]int{
@@ -40,6 +41,7 @@ var precedences = map[token.TokenType]int{
 	token.PERCENT:  PRODUCT,
 	token.DOT:      CALL,
 	token.LPAREN:   CALL,
+	token.LBRACKET: CALL,
 }
 
 type Parser struct {
@@ -47,7 +49,8 @@ type Parser struct {
 	curToken   token.Token
 	peekToken  token.Token
 	errors     []string
-	lineOffset int // offset to add for source maps
+	lineOffset int          // offset to add for source maps
+	version    lang.Version // language version of the file, gates newer syntax
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
@@ -60,11 +63,15 @@ type (
 
 // ParseResult contains all parsed declarations from a script block
 type ParseResult struct {
-	Imports  []*ast.ImportDecl
-	Models   []*ast.ModelDecl
-	Services []*ast.ServiceDecl
-	Vars     []*ast.VarDecl
-	Funcs    []*ast.FuncDecl
+	Imports       []*ast.ImportDecl
+	Models        []*ast.ModelDecl
+	Services      []*ast.ServiceDecl
+	Vars          []*ast.VarDecl
+	Settings      []*ast.SettingDecl
+	Wizards       []*ast.WizardDecl
+	Server        *ast.ServerDecl
+	Observability *ast.ObservabilityDecl
+	Funcs         []*ast.FuncDecl
 }
 
 // initParseFns registers all prefix and infix parse functions on the parser.
@@ -101,14 +108,22 @@ func (p *Parser) initParseFns() {
 	p.registerInfix(token.OR, p.parseBinaryExpression)
 	p.registerInfix(token.DOT, p.parseMemberExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
+	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
 }
 
 // Parse takes the raw script source and returns parsed declarations (models, services, functions)
 func Parse(source string, lineOffset int) (*ParseResult, []string) {
+	return ParseVersion(source, lineOffset, lang.Default)
+}
+
+// ParseVersion parses a script block of a file declaring the given language
+// version; syntax introduced by a later version is reported as an error
+func ParseVersion(source string, lineOffset int, version lang.Version) (*ParseResult, []string) {
 	l := lexer.New(source)
 	p := &Parser{
 		l:          l,
 		lineOffset: lineOffset,
+		version:    version,
 		errors:     []string{},
 	}
 
@@ -118,18 +133,29 @@ func Parse(source string, lineOffset int) (*ParseResult, []string) {
 	p.nextToken()
 	p.nextToken()
 
-	// Parse all top-level declarations (import, model, service, let, const, func)
+	// Parse all top-level declarations (import, model, service, let, const, setting, func)
 	result := &ParseResult{
 		Imports:  []*ast.ImportDecl{},
 		Models:   []*ast.ModelDecl{},
 		Services: []*ast.ServiceDecl{},
 		Vars:     []*ast.VarDecl{},
+		Settings: []*ast.SettingDecl{},
+		Wizards:  []*ast.WizardDecl{},
 		Funcs:    []*ast.FuncDecl{},
 	}
 
 	// Track if we've seen non-import declarations for ordering validation
 	hasNonImport := false
 
+	// Annotations collected before a func or model declaration: @captcha(turnstile) func signup() error
+	var pendingAnnotations []*ast.Annotation
+
+	// before/after hooks, attached to their functions once all are parsed
+	var hooks []*hookDecl
+
+	// Policies, attached to their models once all are parsed
+	var policies []*ast.PolicyDecl
+
 	for p.curToken.Type != token.EOF {
 		switch p.curToken.Type {
 		case token.IMPORT:
@@ -149,8 +175,10 @@ func Parse(source string, lineOffset int) (*ParseResult, []string) {
 			hasNonImport = true
 			model := p.parseModelDecl()
 			if model != nil {
+				model.Annotations = pendingAnnotations
 				result.Models = append(result.Models, model)
 			}
+			pendingAnnotations = nil
 			// parseModelDecl already consumes the closing brace and moves past it
 
 		case token.SERVICE:
@@ -177,20 +205,105 @@ func Parse(source string, lineOffset int) (*ParseResult, []string) {
 			}
 			p.nextToken() // Move past the declaration
 
+		case token.AT:
+			hasNonImport = true
+			ann := p.parseAnnotation()
+			if ann == nil {
+				p.nextToken() // skip the malformed annotation to ensure progress
+				continue
+			}
+			pendingAnnotations = append(pendingAnnotations, ann)
+			if !p.curTokenIs(token.AT) && !p.curTokenIs(token.FUNC) && !p.curTokenIs(token.MODEL) {
+				p.error("annotations must be followed by a func or model declaration")
+				pendingAnnotations = nil
+			}
+			// parseAnnotation already moves past the annotation
+
+		case token.HASH:
+			hasNonImport = true
+			p.parseCompileIf(result, nil)
+			p.nextToken() // Move past the closing brace
+
 		case token.FUNC:
 			hasNonImport = true
 			fn := p.parseFuncDecl()
 			if fn != nil {
+				fn.Annotations = pendingAnnotations
 				result.Funcs = append(result.Funcs, fn)
 			}
+			pendingAnnotations = nil
 			p.nextToken() // Move past the closing brace
 
 		default:
+			if p.isGroupStart() {
+				hasNonImport = true
+				result.Funcs = append(result.Funcs, p.parseGroupDecl()...)
+				p.nextToken() // Move past the closing brace
+				continue
+			}
+			if p.isSettingStart() {
+				hasNonImport = true
+				if setting := p.parseSettingDecl(); setting != nil {
+					result.Settings = append(result.Settings, setting)
+				}
+				// parseSettingDecl already moves past the declaration
+				continue
+			}
+			if p.isPolicyStart() {
+				hasNonImport = true
+				if policy := p.parsePolicyDecl(); policy != nil {
+					policies = append(policies, policy)
+				}
+				p.nextToken() // Move past the closing brace
+				continue
+			}
+			if p.isWizardStart() {
+				hasNonImport = true
+				if wizard := p.parseWizardDecl(); wizard != nil {
+					result.Wizards = append(result.Wizards, wizard)
+				}
+				p.nextToken() // Move past the closing brace
+				continue
+			}
+			if p.isServerStart() {
+				hasNonImport = true
+				if result.Server != nil {
+					p.error("server is declared twice")
+				}
+				if server := p.parseServerDecl(); server != nil && result.Server == nil {
+					result.Server = server
+				}
+				p.nextToken() // Move past the closing brace
+				continue
+			}
+			if p.isObservabilityStart() {
+				hasNonImport = true
+				if result.Observability != nil {
+					p.error("observability is declared twice")
+				}
+				if obs := p.parseObservabilityDecl(); obs != nil && result.Observability == nil {
+					result.Observability = obs
+				}
+				p.nextToken() // Move past the closing brace
+				continue
+			}
+			if p.isHookStart() {
+				hasNonImport = true
+				if hook := p.parseHookDecl(); hook != nil {
+					hooks = append(hooks, hook)
+				}
+				p.nextToken() // Move past the closing brace
+				continue
+			}
 			p.error(fmt.Sprintf("expected import, model, service, let, const, or func declaration, got %s", p.curToken.Type))
 			p.nextToken()
 		}
 	}
 
+	p.errors = append(p.errors, attachHooks(result.Funcs, hooks)...)
+	p.errors = append(p.errors, attachPolicies(result.Models, policies)...)
+	p.errors = append(p.errors, checkReservedNames(result)...)
+
 	return result, p.errors
 }
 
@@ -200,7 +313,14 @@ func (p *Parser) nextToken() {
 }
 
 func (p *Parser) error(msg string) {
-	p.errors = append(p.errors, fmt.Sprintf("line %d: %s", p.curToken.Pos.Line, msg))
+	p.errors = append(p.errors, fmt.Sprintf("line %d: %s", p.curToken.Pos.Line+p.lineOffset, msg))
+}
+
+// requireFeature reports gated syntax used by a file declaring an older language version
+func (p *Parser) requireFeature(feature string) {
+	if err := p.version.Require(feature); err != nil {
+		p.error(err.Error())
+	}
 }
 
 func (p *Parser) peekError(t token.TokenType) {
@@ -225,19 +345,29 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 }
 
 func (p *Parser) peekPrecedence() int {
-	if p, ok := precedences[p.peekToken.Type]; ok {
-		return p
-	}
-	return LOWEST
+	return tokenPrecedence(p.peekToken)
 }
 
 func (p *Parser) curPrecedence() int {
-	if p, ok := precedences[p.curToken.Type]; ok {
+	return tokenPrecedence(p.curToken)
+}
+
+func tokenPrecedence(tok token.Token) int {
+	if isContainsOperator(tok) {
+		return EQUALS
+	}
+	if p, ok := precedences[tok.Type]; ok {
 		return p
 	}
 	return LOWEST
 }
 
+// isContainsOperator reports whether tok is the `contains` operator (tags contains "urgent").
+// It is a contextual keyword, so `contains` stays usable as an identifier
+func isContainsOperator(tok token.Token) bool {
+	return tok.Type == token.IDENT && tok.Literal == "contains"
+}
+
 func (p *Parser) registerPrefix(tokenType token.TokenType, fn prefixParseFn) {
 	p.prefixParseFns[tokenType] = fn
 }
@@ -313,7 +443,9 @@ func (p *Parser) parseDestructuredImport() *ast.ImportDecl {
 	}
 
 	// Parse first member
-	importDecl.Members = append(importDecl.Members, p.curToken.Literal)
+	if !p.parseImportMember(importDecl) {
+		return nil
+	}
 
 	// Parse remaining members
 	for p.peekTokenIs(token.COMMA) {
@@ -321,7 +453,9 @@ func (p *Parser) parseDestructuredImport() *ast.ImportDecl {
 		if !p.expectPeek(token.IDENT) {
 			return nil
 		}
-		importDecl.Members = append(importDecl.Members, p.curToken.Literal)
+		if !p.parseImportMember(importDecl) {
+			return nil
+		}
 	}
 
 	// Expect '}'
@@ -347,6 +481,24 @@ func (p *Parser) parseDestructuredImport() *ast.ImportDecl {
 	return importDecl
 }
 
+// parseImportMember records the current member, with its optional alias: Task as TodoTask
+func (p *Parser) parseImportMember(importDecl *ast.ImportDecl) bool {
+	member := p.curToken.Literal
+	importDecl.Members = append(importDecl.Members, member)
+	if !p.peekTokenIs(token.AS) {
+		return true
+	}
+	p.nextToken() // consume 'as'
+	if !p.expectPeek(token.IDENT) {
+		return false
+	}
+	if importDecl.Aliases == nil {
+		importDecl.Aliases = make(map[string]string)
+	}
+	importDecl.Aliases[member] = p.curToken.Literal
+	return true
+}
+
 // parseNativeImport parses: import "github.com/pkg" as Alias
 func (p *Parser) parseNativeImport() *ast.ImportDecl {
 	importDecl := &ast.ImportDecl{
@@ -425,7 +577,7 @@ func (p *Parser) parseFuncParams() []*ast.Param {
 	p.nextToken()
 
 	// Parse first param
-	param := &ast.Param{}
+	param := &ast.Param{Line: p.curToken.Pos.Line + p.lineOffset}
 	if !p.curTokenIs(token.IDENT) {
 		p.error(fmt.Sprintf("expected parameter name, got %s", p.curToken.Type))
 		return nil
@@ -439,7 +591,7 @@ func (p *Parser) parseFuncParams() []*ast.Param {
 	if !p.expectPeek(token.IDENT) && !p.expectPeekType() {
 		return nil
 	}
-	param.Type = p.curToken.Literal
+	param.Type = p.parseListSuffix(p.curToken.Literal)
 
 	params = append(params, param)
 
@@ -447,7 +599,7 @@ func (p *Parser) parseFuncParams() []*ast.Param {
 		p.nextToken()
 		p.nextToken()
 
-		param := &ast.Param{}
+		param := &ast.Param{Line: p.curToken.Pos.Line + p.lineOffset}
 		if !p.curTokenIs(token.IDENT) {
 			p.error(fmt.Sprintf("expected parameter name, got %s", p.curToken.Type))
 			return nil
@@ -461,7 +613,7 @@ func (p *Parser) parseFuncParams() []*ast.Param {
 		if !p.expectPeek(token.IDENT) && !p.expectPeekType() {
 			return nil
 		}
-		param.Type = p.curToken.Literal
+		param.Type = p.parseListSuffix(p.curToken.Literal)
 
 		params = append(params, param)
 	}
@@ -473,6 +625,18 @@ func (p *Parser) parseFuncParams() []*ast.Param {
 	return params
 }
 
+// parseListSuffix appends the [] of a list type (tags: string[]) to its element type
+func (p *Parser) parseListSuffix(typ string) string {
+	if !p.peekTokenIs(token.LBRACKET) {
+		return typ
+	}
+	p.nextToken()
+	if !p.expectPeek(token.RBRACKET) {
+		return typ
+	}
+	return typ + "[]"
+}
+
 // isTypeToken returns true if the token type represents a type keyword
 func (p *Parser) isTypeToken(t token.TokenType) bool {
 	return t == token.ERROR || t == token.STRING || t == token.IDENT || t == token.TASK
@@ -492,6 +656,7 @@ func (p *Parser) expectPeekType() bool {
 func (p *Parser) parseVarDecl(isConst bool) *ast.VarDecl {
 	varDecl := &ast.VarDecl{
 		IsConst: isConst,
+		Line:    p.curToken.Pos.Line + p.lineOffset,
 	}
 
 	// Expect variable name
@@ -557,7 +722,15 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseReturnStatement()
 	case token.IF:
 		return p.parseIfStatement()
+	case token.FOR:
+		return p.parseForStatement()
 	default:
+		if p.isSagaStart() {
+			return p.parseSagaStatement()
+		}
+		if p.isEnqueueStart() {
+			return p.parseEnqueueStatement()
+		}
 		return p.parseExpressionStatement()
 	}
 }
@@ -586,6 +759,10 @@ func (p *Parser) parseLetStatement(isConst bool) *ast.LetStmt {
 
 	stmt.Value = p.parseExpression(LOWEST)
 
+	if p.peekTokenIs(token.LBRACE) && isRememberCall(stmt.Value) {
+		stmt.Value = p.parseRememberBlock(stmt.Value)
+	}
+
 	return stmt
 }
 
@@ -594,13 +771,12 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStmt {
 		Line: p.curToken.Pos.Line + p.lineOffset,
 	}
 
-	p.nextToken()
-
-	// Check if it's a bare return
-	if p.curTokenIs(token.RBRACE) || p.curTokenIs(token.EOF) {
+	// Check if it's a bare return, leaving the closing brace to the enclosing block
+	if p.peekTokenIs(token.RBRACE) || p.peekTokenIs(token.EOF) {
 		return stmt
 	}
 
+	p.nextToken()
 	stmt.Value = p.parseExpression(LOWEST)
 
 	return stmt
@@ -633,6 +809,56 @@ func (p *Parser) parseIfStatement() *ast.IfStmt {
 	return stmt
 }
 
+// parseForStatement parses: for item in collection { ... }, or with the
+// index of the item: for i, item in collection { ... }
+func (p *Parser) parseForStatement() *ast.ForStmt {
+	stmt := &ast.ForStmt{
+		Line: p.curToken.Pos.Line + p.lineOffset,
+	}
+	p.requireFeature("for loops")
+
+	// Accept IDENT or TASK, like let: for task in tasks
+	p.nextToken()
+	if !p.curTokenIs(token.IDENT) && !p.curTokenIs(token.TASK) {
+		p.error(fmt.Sprintf("expected variable name after for, got %s", p.curToken.Type))
+		return nil
+	}
+	stmt.Value = p.curToken.Literal
+
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken() // consume ','
+		p.nextToken()
+		if !p.curTokenIs(token.IDENT) && !p.curTokenIs(token.TASK) {
+			p.error(fmt.Sprintf("expected variable name after ',', got %s", p.curToken.Type))
+			return nil
+		}
+		stmt.Index, stmt.Value = stmt.Value, p.curToken.Literal
+	}
+
+	// in is contextual: an identifier anywhere but in a for header
+	if !p.peekTokenIs(token.IDENT) || p.peekToken.Literal != "in" {
+		if stmt.Index == "" && stmt.Value == "in" {
+			p.error("expected variable name after for, got in")
+		} else {
+			p.error(fmt.Sprintf("expected in after the loop variable, got %s", p.peekToken.Literal))
+		}
+		return nil
+	}
+	p.nextToken()
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+	if stmt.Iterable == nil {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseBlockStatement()
+
+	return stmt
+}
+
 func (p *Parser) parseExpressionStatement() ast.Statement {
 	line := p.curToken.Pos.Line + p.lineOffset
 	expr := p.parseExpression(LOWEST)
@@ -674,6 +900,9 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	for !p.peekTokenIs(token.RBRACE) && !p.peekTokenIs(token.EOF) &&
 		!p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
 		infix := p.infixParseFns[p.peekToken.Type]
+		if isContainsOperator(p.peekToken) {
+			infix = p.parseBinaryExpression
+		}
 		if infix == nil {
 			return leftExp
 		}
@@ -693,7 +922,7 @@ func (p *Parser) parseIdentifier() ast.Expression {
 	}
 
 	// Check for struct literal: TypeName{...}
-	if p.peekTokenIs(token.LBRACE) && len(p.curToken.Literal) > 0 && unicode.IsUpper(rune(p.curToken.Literal[0])) {
+	if p.peekTokenIs(token.LBRACE) && len(p.curToken.Literal) > 0 && unicode.IsUpper([]rune(p.curToken.Literal)[0]) {
 		return p.parseStructLiteral(p.curToken.Literal)
 	}
 
@@ -758,12 +987,37 @@ func (p *Parser) parseStructLiteral(typeName string) ast.Expression {
 }
 
 func (p *Parser) parseIntLiteral() ast.Expression {
+	if p.isDurationUnit() {
+		return p.parseDurationLiteral()
+	}
 	return &ast.IntLit{
 		Value: p.curToken.Literal,
 		Line:  p.curToken.Pos.Line + p.lineOffset,
 	}
 }
 
+// durationUnits are the units a duration literal may end with
+var durationUnits = map[string]bool{"ms": true, "s": true, "m": true, "h": true}
+
+// isDurationUnit reports whether the integer is directly followed by a
+// duration unit, as in 24h
+func (p *Parser) isDurationUnit() bool {
+	return p.peekTokenIs(token.IDENT) && durationUnits[p.peekToken.Literal] &&
+		p.peekToken.Pos.Offset == p.curToken.Pos.Offset+len(p.curToken.Literal)
+}
+
+// parseDurationLiteral parses an integer and its unit: 24h, 30m, 500ms
+func (p *Parser) parseDurationLiteral() ast.Expression {
+	lit := &ast.DurationLit{
+		Value: p.curToken.Literal,
+		Line:  p.curToken.Pos.Line + p.lineOffset,
+	}
+	p.requireFeature("duration literals")
+	p.nextToken()
+	lit.Value += p.curToken.Literal
+	return lit
+}
+
 func (p *Parser) parseFloatLiteral() ast.Expression {
 	return &ast.FloatLit{
 		Value: p.curToken.Literal,
@@ -991,7 +1245,10 @@ func (p *Parser) parseMemberExpression(left ast.Expression) ast.Expression {
 		Line:   p.curToken.Pos.Line + p.lineOffset,
 	}
 
-	if !p.expectPeek(token.IDENT) {
+	// error is a keyword, and a property as in log.error(...)
+	if p.peekTokenIs(token.ERROR) {
+		p.nextToken()
+	} else if !p.expectPeek(token.IDENT) {
 		return nil
 	}
 
@@ -1000,6 +1257,22 @@ func (p *Parser) parseMemberExpression(left ast.Expression) ast.Expression {
 	return expr
 }
 
+func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
+	expr := &ast.IndexExpr{
+		Object: left,
+		Line:   p.curToken.Pos.Line + p.lineOffset,
+	}
+
+	p.nextToken()
+	expr.Index = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+
+	return expr
+}
+
 func (p *Parser) parseCallExpression(left ast.Expression) ast.Expression {
 	expr := &ast.CallExpr{
 		Function: left,
@@ -1013,12 +1286,12 @@ func (p *Parser) parseCallExpression(left ast.Expression) ast.Expression {
 	}
 
 	p.nextToken()
-	expr.Args = append(expr.Args, p.parseExpression(LOWEST))
+	expr.Args = append(expr.Args, p.parseCallArgument())
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
-		expr.Args = append(expr.Args, p.parseExpression(LOWEST))
+		expr.Args = append(expr.Args, p.parseCallArgument())
 	}
 
 	if !p.expectPeek(token.RPAREN) {
@@ -1028,6 +1301,23 @@ func (p *Parser) parseCallExpression(left ast.Expression) ast.Expression {
 	return expr
 }
 
+// parseCallArgument parses a call argument: an expression, or a named
+// argument such as done: false
+func (p *Parser) parseCallArgument() ast.Expression {
+	if !p.curTokenIs(token.IDENT) || !p.peekTokenIs(token.COLON) {
+		return p.parseExpression(LOWEST)
+	}
+	arg := &ast.NamedArg{
+		Name: p.curToken.Literal,
+		Line: p.curToken.Pos.Line + p.lineOffset,
+	}
+	p.requireFeature("named arguments")
+	p.nextToken() // :
+	p.nextToken()
+	arg.Value = p.parseExpression(LOWEST)
+	return arg
+}
+
 // ============ MODEL/SERVICE PARSING (delegated to shared package) ============
 
 // parseModelDecl delegates model parsing to the shared package
@@ -1038,6 +1328,17 @@ func (p *Parser) parseModelDecl() *ast.ModelDecl {
 	// Delegate to shared package
 	model := core.ParseModelDecl()
 
+	// The shared core counts lines from the start of the script block
+	if model != nil {
+		model.Line += p.lineOffset
+		for _, field := range model.Fields {
+			field.Line += p.lineOffset
+		}
+		for _, index := range model.Indexes {
+			index.Line += p.lineOffset
+		}
+	}
+
 	// Sync our state back from the core
 	p.curToken = core.GetCurrentToken()
 	p.peekToken = core.GetPeekToken()
@@ -1050,14 +1351,49 @@ func (p *Parser) parseModelDecl() *ast.ModelDecl {
 	return model
 }
 
+// parseAnnotation delegates annotation parsing to the shared package
+func (p *Parser) parseAnnotation() *ast.Annotation {
+	core := shared.NewParserCoreFromTokens(p.l, p.curToken, p.peekToken)
+
+	ann := core.ParseAnnotation()
+
+	p.curToken = core.GetCurrentToken()
+	p.peekToken = core.GetPeekToken()
+
+	for _, err := range core.Errors() {
+		p.errors = append(p.errors, err)
+	}
+
+	return ann
+}
+
 // parseServiceDecl delegates service parsing to the shared package
 func (p *Parser) parseServiceDecl() *ast.ServiceDecl {
 	// Create a shared parser core that wraps our current state
 	core := shared.NewParserCoreFromTokens(p.l, p.curToken, p.peekToken)
 
+	// Method bodies are GMX Script statements, parsed here
+	core.SetMethodBodyParser(func(c *shared.ParserCore) []ast.Statement {
+		p.curToken = c.GetCurrentToken()
+		p.peekToken = c.GetPeekToken()
+		body := p.parseBlockStatement()
+		if p.curTokenIs(token.RBRACE) {
+			p.nextToken() // consume }
+		} else {
+			p.error(fmt.Sprintf("expected } after service method body, got %s", p.curToken.Type))
+		}
+		c.SetTokens(p.curToken, p.peekToken)
+		return body
+	})
+
 	// Delegate to shared package
 	svc := core.ParseServiceDecl()
 
+	// The shared core counts lines from the start of the script block
+	if svc != nil {
+		svc.Line += p.lineOffset
+	}
+
 	// Sync our state back from the core
 	p.curToken = core.GetCurrentToken()
 	p.peekToken = core.GetPeekToken()
@@ -2,6 +2,7 @@ package script
 
 import (
 	"github.com/btouchard/gmx/internal/compiler/ast"
+	"strings"
 	"testing"
 )
 
@@ -225,6 +226,38 @@ func TestParseIfElse(t *testing.T) {
 	}
 }
 
+func TestParseIndexExpression(t *testing.T) {
+	input := `func test() error {
+		task.meta["color"] = task.meta["fallback"]
+		return nil
+	}`
+
+	result, errors := Parse(input, 0)
+
+	if len(errors) > 0 {
+		t.Fatalf("parse errors: %v", errors)
+	}
+
+	assignStmt, ok := result.Funcs[0].Body[0].(*ast.AssignStmt)
+	if !ok {
+		t.Fatalf("expected AssignStmt, got %T", result.Funcs[0].Body[0])
+	}
+
+	for _, expr := range []ast.Expression{assignStmt.Target, assignStmt.Value} {
+		indexExpr, ok := expr.(*ast.IndexExpr)
+		if !ok {
+			t.Fatalf("expected IndexExpr, got %T", expr)
+		}
+		member, ok := indexExpr.Object.(*ast.MemberExpr)
+		if !ok || member.Property != "meta" {
+			t.Errorf("expected task.meta as indexed object, got %#v", indexExpr.Object)
+		}
+		if _, ok := indexExpr.Index.(*ast.StringLit); !ok {
+			t.Errorf("expected StringLit as index, got %T", indexExpr.Index)
+		}
+	}
+}
+
 func TestParseAssignment(t *testing.T) {
 	input := `func test() error {
 		task.done = !task.done
@@ -1276,6 +1309,52 @@ func TestParseFuncParamsWithStringType(t *testing.T) {
 	}
 }
 
+func TestParseFuncParamsListType(t *testing.T) {
+	input := `func tag(id: uuid, tags: string[]) error {
+		return nil
+	}`
+
+	result, errors := Parse(input, 0)
+
+	if len(errors) > 0 {
+		t.Fatalf("parse errors: %v", errors)
+	}
+
+	params := result.Funcs[0].Params
+	if len(params) != 2 || params[1].Type != "string[]" {
+		t.Errorf("expected param (tags: string[]), got %+v", params)
+	}
+}
+
+func TestParseContainsOperator(t *testing.T) {
+	input := `func test() error {
+		let contains = task.tags contains "urgent" && ok
+		return nil
+	}`
+
+	result, errors := Parse(input, 0)
+
+	if len(errors) > 0 {
+		t.Fatalf("parse errors: %v", errors)
+	}
+
+	letStmt, ok := result.Funcs[0].Body[0].(*ast.LetStmt)
+	if !ok || letStmt.Name != "contains" {
+		t.Fatalf("expected `let contains`, got %#v", result.Funcs[0].Body[0])
+	}
+	and, ok := letStmt.Value.(*ast.BinaryExpr)
+	if !ok || and.Op != "&&" {
+		t.Fatalf("expected && at the root, got %#v", letStmt.Value)
+	}
+	contains, ok := and.Left.(*ast.BinaryExpr)
+	if !ok || contains.Op != "contains" {
+		t.Fatalf("expected contains on the left of &&, got %#v", and.Left)
+	}
+	if _, ok := contains.Left.(*ast.MemberExpr); !ok {
+		t.Errorf("expected task.tags as left operand, got %T", contains.Left)
+	}
+}
+
 // Test expectPeekType (50% coverage)
 func TestExpectPeekTypeErrorKeyword(t *testing.T) {
 	input := `func test() error {
@@ -2096,6 +2175,28 @@ func TestParseDestructuredImportMultipleMembers(t *testing.T) {
 	}
 }
 
+func TestParseDestructuredImportAliases(t *testing.T) {
+	input := `import { Task as TodoTask, createTask } from "./components/todo.gmx"`
+
+	result, errors := Parse(input, 0)
+
+	if len(errors) > 0 {
+		t.Fatalf("parse errors: %v", errors)
+	}
+
+	imp := result.Imports[0]
+	if len(imp.Members) != 2 || imp.Members[0] != "Task" || imp.Members[1] != "createTask" {
+		t.Fatalf("expected members [Task createTask], got %v", imp.Members)
+	}
+	if len(imp.Aliases) != 1 || imp.Aliases["Task"] != "TodoTask" {
+		t.Errorf("expected alias Task → TodoTask, got %v", imp.Aliases)
+	}
+
+	if _, errors := Parse(`import { Task as } from "./todo.gmx"`, 0); len(errors) == 0 {
+		t.Error("expected an error for a missing alias name")
+	}
+}
+
 func TestParseNativeGoImport(t *testing.T) {
 	input := `import "github.com/stripe/stripe-go" as Stripe`
 
@@ -2321,3 +2422,314 @@ func TestServiceOnly(t *testing.T) {
 		t.Fatalf("expected 1 service, got %d", len(result.Services))
 	}
 }
+
+func TestParseFuncAnnotations(t *testing.T) {
+	input := `@captcha(turnstile)
+func createContact(email: string) error {
+	return nil
+}
+
+func listContacts() error {
+	return nil
+}`
+
+	result, errors := Parse(input, 0)
+	if len(errors) > 0 {
+		t.Fatalf("parse errors: %v", errors)
+	}
+	if len(result.Funcs) != 2 {
+		t.Fatalf("expected 2 functions, got %d", len(result.Funcs))
+	}
+
+	ann := result.Funcs[0].FindAnnotation("captcha")
+	if ann == nil {
+		t.Fatal("expected @captcha annotation on createContact")
+	}
+	if ann.SimpleArg() != "turnstile" {
+		t.Errorf("expected provider 'turnstile', got %q", ann.SimpleArg())
+	}
+	if len(result.Funcs[1].Annotations) != 0 {
+		t.Errorf("expected no annotations on listContacts, got %d", len(result.Funcs[1].Annotations))
+	}
+}
+
+func TestParseFuncAnnotationWithoutFunc(t *testing.T) {
+	input := `@captcha(turnstile)
+let count = 0`
+
+	_, errors := Parse(input, 0)
+	if len(errors) == 0 {
+		t.Fatal("expected error for annotation not followed by a func")
+	}
+}
+
+func TestParseModelAnnotations(t *testing.T) {
+	input := `@repository("TaskRepo")
+model Task {
+  id: uuid @pk
+}
+
+model Tag {
+  id: uuid @pk
+}`
+
+	result, errors := Parse(input, 0)
+	if len(errors) > 0 {
+		t.Fatalf("parse errors: %v", errors)
+	}
+	if len(result.Models) != 2 {
+		t.Fatalf("expected 2 models, got %d", len(result.Models))
+	}
+
+	ann := result.Models[0].FindAnnotation("repository")
+	if ann == nil {
+		t.Fatal("expected @repository annotation on Task")
+	}
+	if ann.SimpleArg() != "TaskRepo" {
+		t.Errorf("expected repository 'TaskRepo', got %q", ann.SimpleArg())
+	}
+	if len(result.Models[1].Annotations) != 0 {
+		t.Errorf("expected no annotations on Tag, got %d", len(result.Models[1].Annotations))
+	}
+}
+
+func TestParseServiceMethodBody(t *testing.T) {
+	input := `service Notifier {
+  provider: "webhook"
+  prefix: string @default("[app]")
+  func format(msg: string) string {
+    return "{config.prefix} {msg}"
+  }
+  func ping() {
+    return
+  }
+  func notify(msg: string) error
+}
+
+func hello() error {
+  return nil
+}`
+
+	result, errors := Parse(input, 0)
+	if len(errors) > 0 {
+		t.Fatalf("parse errors: %v", errors)
+	}
+	if len(result.Services) != 1 || len(result.Funcs) != 1 {
+		t.Fatalf("expected 1 service and 1 func, got %d and %d", len(result.Services), len(result.Funcs))
+	}
+
+	methods := result.Services[0].Methods
+	if len(methods) != 3 {
+		t.Fatalf("expected 3 methods, got %d", len(methods))
+	}
+	if methods[0].ReturnType != "string" || len(methods[0].Body) != 1 {
+		t.Errorf("format: expected string return and 1 statement, got %q and %d", methods[0].ReturnType, len(methods[0].Body))
+	}
+	if ret, ok := methods[1].Body[0].(*ast.ReturnStmt); !ok || ret.Value != nil {
+		t.Errorf("ping: expected a bare return, got %#v", methods[1].Body[0])
+	}
+	if methods[2].Body != nil {
+		t.Errorf("notify: expected a signature-only method, got %d statements", len(methods[2].Body))
+	}
+}
+
+func TestParseReservedNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"go keyword func", "func select() error {\n  return nil\n}", `line 1: func "select" is a reserved Go keyword`},
+		{"builtin var", "let x = 1\nlet len = 2", `line 2: variable "len" shadows a Go builtin`},
+		{"generated name", "func renderFragment() error {\n  return nil\n}", `func "renderFragment" collides with generated code`},
+		{"handler param", "func save(r: string) error {\n  return nil\n}", `parameter "r" of func save is used by the generated handler`},
+		{"local err", "func save() error {\n  if true {\n    let err = 1\n  }\n  return nil\n}", `line 3: variable "err" of func save is used by the generated handler`},
+		{"orm helper", "model Task {\n  id: uuid @pk\n}\n\nfunc TaskFind() error {\n  return nil\n}", `line 5: func "TaskFind" collides with the generated ORM helper of model Task`},
+		{"generated method", "model Task {\n  id: uuid @pk\n  validate: bool\n}", `line 3: field "validate" of model Task collides with the generated Validate method`},
+		{"model name", "model Money {\n  id: uuid @pk\n}", `model "Money" collides with generated code`},
+		{"async job", "@async\nfunc importTasks() error {\n  let job = 1\n  return nil\n}", `line 3: variable "job" of func importTasks is the job of the @async function`},
+		{"enqueue helper", "@job\nfunc job() error {\n  return nil\n}", `line 2: func "job" collides with enqueueJob, which queues it`},
+		{"service variable", "service Mailer {\n  provider: \"smtp\"\n  func send(to: string, subject: string, body: string) error\n}\n\nfunc mailerService() error {\n  return nil\n}", `line 6: func "mailerService" collides with the variable of service Mailer`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errors := Parse(tt.input, 0)
+			if !strings.Contains(strings.Join(errors, "\n"), tt.want) {
+				t.Errorf("expected error containing %q, got %v", tt.want, errors)
+			}
+		})
+	}
+}
+
+func TestParseReservedNamesLineOffset(t *testing.T) {
+	_, errors := Parse("let len = 2", 10)
+	if len(errors) != 1 || !strings.HasPrefix(errors[0], "line 11:") {
+		t.Errorf("expected error at absolute line 11, got %v", errors)
+	}
+}
+
+func TestParseNonReservedNames(t *testing.T) {
+	input := `model Task {
+  id: uuid @pk
+  title: string
+}
+
+let count = 0
+
+func toggleTask(id: uuid, label: string) error {
+  let task = try Task.find(id)
+  return render(task)
+}`
+
+	_, errors := Parse(input, 0)
+	if len(errors) > 0 {
+		t.Errorf("expected no errors, got %v", errors)
+	}
+}
+
+func TestComplexity(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  int
+	}{
+		{"straight line", "func list() error {\n  return render(Task.all())\n}", 1},
+		{"try", "func toggle(id: uuid) error {\n  let task = try Task.find(id)\n  return render(task)\n}", 2},
+		{"if with condition", "func check(a: bool, b: bool) error {\n  if a && b || !a {\n    return error(\"no\")\n  } else {\n    if b {\n      return nil\n    }\n  }\n  return nil\n}", 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, errors := Parse(tt.input, 0)
+			if len(errors) > 0 {
+				t.Fatalf("parse errors: %v", errors)
+			}
+			if got := Complexity(result.Funcs[0]); got != tt.want {
+				t.Errorf("Complexity() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDurationLiteral(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		want string // Go code of the let value
+	}{
+		{"hours", `24h`, "24 * time.Hour"},
+		{"milliseconds", `500ms`, "500 * time.Millisecond"},
+		{"in a call", `signedRoute("confirm", id, 30m)`, `signedRoute("confirm", id, 30 * time.Minute)`},
+		// A unit must follow the number directly
+		{"spaced unit", `24 h`, "24\n"},
+		{"unknown unit", `3d`, "3\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := "func f(id: uuid) error {\n  let v = " + tt.expr + "\n  return nil\n}"
+			result, errs := ParseVersion(input, 0, v11)
+			if len(errs) > 0 {
+				t.Fatalf("parse errors: %v", errs)
+			}
+			out := Transpile(&ast.ScriptBlock{Funcs: result.Funcs}, nil)
+			if !strings.Contains(out.GoCode, "v := "+tt.want) {
+				t.Errorf("expected v := %s in:\n%s", tt.want, out.GoCode)
+			}
+		})
+	}
+
+	_, errs := Parse("func f() error {\n  let v = 24h\n  return nil\n}", 0)
+	if len(errs) != 1 || !strings.Contains(errs[0], "duration literals require gmx 1.1") {
+		t.Errorf("expected the duration literal to be gated, got %v", errs)
+	}
+}
+
+func TestParseForStatement(t *testing.T) {
+	input := `func f() error {
+  for i, task in tasks {
+    try task.save()
+  }
+  for tag in task.tags {
+  }
+  return nil
+}`
+	result, errs := ParseVersion(input, 0, v11)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	body := result.Funcs[0].Body
+	if len(body) != 3 {
+		t.Fatalf("expected 3 statements, got %d", len(body))
+	}
+
+	indexed, ok := body[0].(*ast.ForStmt)
+	if !ok {
+		t.Fatalf("expected a for statement, got %T", body[0])
+	}
+	if indexed.Index != "i" || indexed.Value != "task" || indexed.Line != 2 || len(indexed.Body) != 1 {
+		t.Errorf("expected for i, task at line 2 with 1 statement, got %+v", indexed)
+	}
+	plain := body[1].(*ast.ForStmt)
+	if plain.Index != "" || plain.Value != "tag" {
+		t.Errorf("expected for tag without index, got %+v", plain)
+	}
+	if _, ok := plain.Iterable.(*ast.MemberExpr); !ok {
+		t.Errorf("expected task.tags to parse as a member expression, got %T", plain.Iterable)
+	}
+}
+
+func TestParseForStatementErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		loop    string
+		wantErr string
+	}{
+		{"missing in", "for task tasks {\n  }", "expected in after the loop variable, got tasks"},
+		{"missing variable", "for in tasks {\n  }", "expected variable name after for, got in"},
+		{"missing block", "for task in tasks\n  return nil", "expected next token to be {"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := "func f() error {\n  " + tt.loop + "\n  return nil\n}"
+			_, errs := ParseVersion(input, 0, v11)
+			if !strings.Contains(strings.Join(errs, "\n"), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, errs)
+			}
+		})
+	}
+
+	_, errs := Parse("func f() error {\n  for task in tasks {\n  }\n  return nil\n}", 0)
+	if len(errs) != 1 || !strings.Contains(errs[0], "for loops require gmx 1.1") {
+		t.Errorf("expected the for loop to be gated, got %v", errs)
+	}
+}
+
+func TestParseInAsIdentifier(t *testing.T) {
+	// in is only a keyword in for headers: gmx 1.0 files may name things in
+	input := `model Stock {
+  id: uuid @pk @default(uuid_v4)
+  in: int
+}
+func restock(in: int) error {
+  let stock = try Stock.find("1")
+  stock.in = stock.in + in
+  try stock.save()
+  return nil
+}`
+	result, errs := Parse(input, 0)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	if len(result.Models) != 1 || result.Models[0].Fields[1].Name != "in" {
+		t.Errorf("expected a field named in, got %+v", result.Models)
+	}
+	if len(result.Funcs) != 1 || len(result.Funcs[0].Params) != 1 || result.Funcs[0].Params[0].Name != "in" {
+		t.Fatalf("expected a parameter named in, got %+v", result.Funcs)
+	}
+	if len(result.Funcs[0].Body) != 4 {
+		t.Errorf("expected 4 statements, got %d", len(result.Funcs[0].Body))
+	}
+}
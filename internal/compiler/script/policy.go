@@ -0,0 +1,284 @@
+package script
+
+import (
+	"fmt"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/token"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// A policy centralizes the authorization rules of a model:
+//
+//	policy Task {
+//	  read:   ctx.user != ""
+//	  update: task.userId == ctx.user
+//	  delete: role(admin)
+//	}
+//
+// Script code reaching the model through its ORM helpers is checked against
+// the rule of the action; actions without a rule are allowed.
+
+// policyActions are the actions a policy rule may restrict
+var policyActions = map[string]bool{"read": true, "create": true, "update": true, "delete": true}
+
+// isPolicyStart reports whether the current token opens a policy: policy is
+// a contextual keyword, followed by the name of a model
+func (p *Parser) isPolicyStart() bool {
+	return p.curTokenIs(token.IDENT) && p.curToken.Literal == "policy" && p.peekTokenIs(token.IDENT)
+}
+
+// parsePolicyDecl parses: policy Model { action: condition ... }; rules may
+// be separated by newlines or commas
+func (p *Parser) parsePolicyDecl() *ast.PolicyDecl {
+	policy := &ast.PolicyDecl{
+		Line: p.curToken.Pos.Line + p.lineOffset,
+	}
+	p.requireFeature("policy blocks")
+
+	p.nextToken() // move to the model name
+	policy.Model = p.curToken.Literal
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	p.nextToken()
+
+	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
+		if !p.curTokenIs(token.IDENT) || !policyActions[p.curToken.Literal] {
+			p.error(fmt.Sprintf("expected read, create, update or delete in policy %s, got %s", policy.Model, p.curToken.Literal))
+			return nil
+		}
+		rule := &ast.PolicyRule{
+			Action: p.curToken.Literal,
+			Line:   p.curToken.Pos.Line + p.lineOffset,
+		}
+		if policy.FindRule(rule.Action) != nil {
+			p.error(fmt.Sprintf("policy %s declares %s twice", policy.Model, rule.Action))
+			return nil
+		}
+		if !p.expectPeek(token.COLON) {
+			return nil
+		}
+		p.nextToken()
+		rule.Cond = p.parseExpression(LOWEST)
+		if rule.Cond == nil {
+			return nil
+		}
+		policy.Rules = append(policy.Rules, rule)
+
+		p.nextToken()
+		if p.curTokenIs(token.COMMA) {
+			p.nextToken()
+		}
+	}
+
+	if !p.curTokenIs(token.RBRACE) {
+		p.error(fmt.Sprintf("policy %s is missing its closing }", policy.Model))
+		return nil
+	}
+	return policy
+}
+
+// attachPolicies attaches each policy to its model, so that it follows the
+// model through imports
+func attachPolicies(models []*ast.ModelDecl, policies []*ast.PolicyDecl) []string {
+	var errs []string
+	byName := make(map[string]*ast.ModelDecl, len(models))
+	for _, model := range models {
+		byName[model.Name] = model
+	}
+
+	for _, policy := range policies {
+		model, ok := byName[policy.Model]
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Sprintf("line %d: policy targets unknown model %s; policies must be declared in the file of their model", policy.Line, policy.Model))
+		case model.Policy != nil:
+			errs = append(errs, fmt.Sprintf("line %d: model %s already has a policy (line %d); merge the rules into one block", policy.Line, policy.Model, model.Policy.Line))
+		default:
+			model.Policy = policy
+		}
+	}
+	return errs
+}
+
+// genPolicyHelpers emits the policy check of a model and the ORM helpers
+// script code calls instead of the plain ones: reads drop or reject the
+// records the policy hides, writes check the stored record first
+func (t *Transpiler) genPolicyHelpers(model string, policy *ast.PolicyDecl) {
+	record := utils.LowerFirst(policy.Model)
+	pk, pkColumn := t.pkFields[model], t.pkColumns[model]
+	if pk == "" {
+		pk, pkColumn = "ID", "id"
+	}
+
+	t.emit("// authorize%s checks an action on a %s against its policy\n", model, model)
+	t.emit("func authorize%s(ctx *GMXContext, action string, %s *%s) error {\n", model, record, model)
+	t.emit("\tallowed := true\n")
+	t.emit("\tswitch action {\n")
+	for _, rule := range policy.Rules {
+		t.emit("\tcase %q:\n", rule.Action)
+		t.emit("\t\tallowed = %s\n", t.transpilePolicyCond(rule.Cond, record, model))
+	}
+	t.emit("\t}\n")
+	t.emit("\tif !allowed {\n")
+	t.emit("\t\treturn fmt.Errorf(\"%%s %s: %%w\", action, errForbidden)\n", model)
+	t.emit("\t}\n")
+	t.emit("\treturn nil\n")
+	t.emit("}\n\n")
+
+	t.emit("// %sReadable keeps the %s records the policy lets ctx read\n", model, model)
+	t.emit("func %sReadable(ctx *GMXContext, objs []%s) []%s {\n", model, model, model)
+	t.emit("\treadable := objs[:0]\n")
+	t.emit("\tfor i := range objs {\n")
+	t.emit("\t\tif authorize%s(ctx, \"read\", &objs[i]) == nil {\n", model)
+	t.emit("\t\t\treadable = append(readable, objs[i])\n")
+	t.emit("\t\t}\n")
+	t.emit("\t}\n")
+	t.emit("\treturn readable\n")
+	t.emit("}\n\n")
+
+	t.emit("func %sFindAuthorized(ctx *GMXContext, id string) (*%s, error) {\n", model, model)
+	t.emit("\tobj, err := %sFind(ctx.DB, id)\n", model)
+	t.emit("\tif err != nil {\n")
+	t.emit("\t\treturn nil, err\n")
+	t.emit("\t}\n")
+	t.emit("\tif err := authorize%s(ctx, \"read\", obj); err != nil {\n", model)
+	t.emit("\t\treturn nil, err\n")
+	t.emit("\t}\n")
+	t.emit("\treturn obj, nil\n")
+	t.emit("}\n\n")
+
+	t.emit("func %sAllAuthorized(ctx *GMXContext) ([]%s, error) {\n", model, model)
+	t.emit("\tobjs, err := %sAll(ctx.DB)\n", model)
+	t.emit("\tif err != nil {\n")
+	t.emit("\t\treturn nil, err\n")
+	t.emit("\t}\n")
+	t.emit("\treturn %sReadable(ctx, objs), nil\n", model)
+	t.emit("}\n\n")
+
+	// The read rule runs on loaded records: a SQL limit would count the
+	// records it drops, so the records are loaded a page at a time instead
+	t.emit("// %sWhereAuthorized returns the %s records matching scopes the policy lets ctx\n", model, model)
+	t.emit("// read, the first limit of them when limit is positive\n")
+	t.emit("func %sWhereAuthorized(ctx *GMXContext, limit int, scopes ...func(*gorm.DB) *gorm.DB) ([]%s, error) {\n", model, model)
+	t.emit("\tif limit <= 0 {\n")
+	t.emit("\t\tobjs, err := %sWhere(ctx.DB, scopes...)\n", model)
+	t.emit("\t\tif err != nil {\n")
+	t.emit("\t\t\treturn nil, err\n")
+	t.emit("\t\t}\n")
+	t.emit("\t\treturn %sReadable(ctx, objs), nil\n", model)
+	t.emit("\t}\n")
+	t.emit("\tsize := max(limit, 100)\n")
+	t.emit("\tvar readable []%s\n", model)
+	t.emit("\tfor offset := 0; len(readable) < limit; offset += size {\n")
+	t.emit("\t\t// The primary key orders the ties, so that the pages do not overlap\n")
+	t.emit("\t\tpage := func(db *gorm.DB) *gorm.DB {\n")
+	t.emit("\t\t\treturn db.Order(%q).Offset(offset).Limit(size)\n", pkColumn)
+	t.emit("\t\t}\n")
+	t.emit("\t\tobjs, err := %sWhere(ctx.DB, append(scopes[:len(scopes):len(scopes)], page)...)\n", model)
+	t.emit("\t\tif err != nil {\n")
+	t.emit("\t\t\treturn nil, err\n")
+	t.emit("\t\t}\n")
+	t.emit("\t\treadable = append(readable, %sReadable(ctx, objs)...)\n", model)
+	t.emit("\t\tif len(objs) < size {\n")
+	t.emit("\t\t\tbreak\n")
+	t.emit("\t\t}\n")
+	t.emit("\t}\n")
+	t.emit("\tif len(readable) > limit {\n")
+	t.emit("\t\treadable = readable[:limit]\n")
+	t.emit("\t}\n")
+	t.emit("\treturn readable, nil\n")
+	t.emit("}\n\n")
+
+	t.emit("// %sFirstAuthorized returns the first %s matching scopes the policy lets ctx read\n", model, model)
+	t.emit("func %sFirstAuthorized(ctx *GMXContext, scopes ...func(*gorm.DB) *gorm.DB) (*%s, error) {\n", model, model)
+	t.emit("\tobjs, err := %sWhereAuthorized(ctx, 1, scopes...)\n", model)
+	t.emit("\tif err != nil {\n")
+	t.emit("\t\treturn nil, err\n")
+	t.emit("\t}\n")
+	t.emit("\tif len(objs) == 0 {\n")
+	t.emit("\t\treturn nil, gorm.ErrRecordNotFound\n")
+	t.emit("\t}\n")
+	t.emit("\treturn &objs[0], nil\n")
+	t.emit("}\n\n")
+
+	// The update rule sees the record as stored, not as modified by the
+	// request: a user cannot take over a record by rewriting its owner
+	t.emit("// %sSaveAuthorized saves a %s the policy lets ctx create, or update as stored\n", model, model)
+	t.emit("func %sSaveAuthorized(ctx *GMXContext, obj *%s) error {\n", model, model)
+	t.emit("\taction, checked := \"create\", obj\n")
+	t.emit("\tif stored, err := %sFind(ctx.DB, fmt.Sprint(obj.%s)); err == nil {\n", model, pk)
+	t.emit("\t\taction, checked = \"update\", stored\n")
+	t.emit("\t} else if !errors.Is(err, gorm.ErrRecordNotFound) {\n")
+	t.emit("\t\treturn err\n")
+	t.emit("\t}\n")
+	t.emit("\tif err := authorize%s(ctx, action, checked); err != nil {\n", model)
+	t.emit("\t\treturn err\n")
+	t.emit("\t}\n")
+	t.emit("\treturn %sSave(ctx.DB, obj)\n", model)
+	t.emit("}\n\n")
+
+	t.emit("// %sDeleteAuthorized deletes a %s the policy lets ctx delete, as stored\n", model, model)
+	t.emit("func %sDeleteAuthorized(ctx *GMXContext, obj *%s) error {\n", model, model)
+	t.emit("\tstored, err := %sFind(ctx.DB, fmt.Sprint(obj.%s))\n", model, pk)
+	t.emit("\tif err != nil {\n")
+	t.emit("\t\treturn err\n")
+	t.emit("\t}\n")
+	t.emit("\tif err := authorize%s(ctx, \"delete\", stored); err != nil {\n", model)
+	t.emit("\t\treturn err\n")
+	t.emit("\t}\n")
+	t.emit("\treturn %sDelete(ctx.DB, obj)\n", model)
+	t.emit("}\n\n")
+}
+
+// transpilePolicyCond converts a rule condition, the record being bound to
+// the lowercased model name; role(admin) checks the session admins list
+func (t *Transpiler) transpilePolicyCond(cond ast.Expression, record, model string) string {
+	t.varTypes = map[string]string{record: model}
+	t.inPolicy = true
+	defer func() { t.inPolicy = false }()
+	return t.transpileExpr(cond)
+}
+
+// HasReadRule reports whether a policy restricts which records can be read
+func HasReadRule(policy *ast.PolicyDecl) bool {
+	if policy == nil {
+		return false
+	}
+	for _, rule := range policy.Rules {
+		if rule.Action == "read" {
+			return true
+		}
+	}
+	return false
+}
+
+// PolicyRoles returns the roles a policy checks with role(...), in order of appearance
+func PolicyRoles(policy *ast.PolicyDecl) []string {
+	var roles []string
+	ast.Inspect(policy, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok {
+			if role, ok := roleCall(call); ok {
+				roles = append(roles, role)
+			}
+		}
+		return true
+	})
+	return roles
+}
+
+// roleCall returns the role checked by a role(name) call
+func roleCall(call *ast.CallExpr) (string, bool) {
+	fn, ok := call.Function.(*ast.Ident)
+	if !ok || fn.Name != "role" || len(call.Args) != 1 {
+		return "", false
+	}
+	switch arg := call.Args[0].(type) {
+	case *ast.Ident:
+		return arg.Name, true
+	case *ast.StringLit:
+		return arg.Value, true
+	}
+	return "", false
+}
@@ -0,0 +1,157 @@
+package script
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/lang"
+)
+
+var v11 = lang.Version{Major: 1, Minor: 1}
+
+const policyScript = `model Task {
+  id:     uuid   @pk
+  userId: string
+}
+
+policy Task { read: ctx.user != "" update: task.userId == ctx.user delete: role(admin) }
+
+func deleteTask(id: uuid) error {
+  let task = try Task.find(id)
+  try task.delete()
+  return nil
+}`
+
+func TestParsePolicy(t *testing.T) {
+	result, errs := ParseVersion(policyScript, 0, v11)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+
+	policy := result.Models[0].Policy
+	if policy == nil {
+		t.Fatal("expected the policy to be attached to model Task")
+	}
+	if policy.Line != 6 {
+		t.Errorf("expected policy at line 6, got %d", policy.Line)
+	}
+	var actions []string
+	for _, rule := range policy.Rules {
+		actions = append(actions, rule.Action)
+	}
+	if got := strings.Join(actions, ","); got != "read,update,delete" {
+		t.Errorf("expected rules read,update,delete, got %s", got)
+	}
+	if _, ok := policy.FindRule("delete").Cond.(*ast.CallExpr); !ok {
+		t.Errorf("expected role(admin) to parse as a call, got %T", policy.FindRule("delete").Cond)
+	}
+	if policy.FindRule("create") != nil {
+		t.Error("create has no rule")
+	}
+	if roles := PolicyRoles(policy); len(roles) != 1 || roles[0] != "admin" {
+		t.Errorf("expected roles [admin], got %v", roles)
+	}
+}
+
+func TestParsePolicyMultiLine(t *testing.T) {
+	input := `model Task {
+  id: uuid @pk
+}
+
+policy Task {
+  read:   true,
+  create: ctx.user != ""
+}`
+	result, errs := ParseVersion(input, 0, v11)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	if policy := result.Models[0].Policy; policy == nil || len(policy.Rules) != 2 {
+		t.Fatalf("expected 2 rules, got %+v", policy)
+	}
+}
+
+func TestParsePolicyErrors(t *testing.T) {
+	model := "model Task {\n  id: uuid @pk\n}\n"
+	tests := []struct {
+		name    string
+		input   string
+		version lang.Version
+		wantErr string
+	}{
+		{"unknown model", `policy Post { read: true }`, v11, "policy targets unknown model Post"},
+		{"unknown action", model + `policy Task { list: true }`, v11, "expected read, create, update or delete in policy Task, got list"},
+		{"duplicate action", model + `policy Task { read: true read: false }`, v11, "policy Task declares read twice"},
+		{"two policies", model + "policy Task { read: true }\npolicy Task { delete: false }", v11, "model Task already has a policy (line 4)"},
+		{"missing colon", model + `policy Task { read true }`, v11, "expected next token to be :"},
+		{"gated", model + `policy Task { read: true }`, lang.Default, "policy blocks require gmx 1.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := ParseVersion(tt.input, 0, tt.version)
+			if len(errs) == 0 {
+				t.Fatalf("expected error containing %q", tt.wantErr)
+			}
+			if !strings.Contains(strings.Join(errs, "\n"), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, errs)
+			}
+		})
+	}
+}
+
+func TestTranspilePolicy(t *testing.T) {
+	result, errs := ParseVersion(policyScript+`
+
+func listTasks() error {
+  let tasks = try Task.all()
+  return render(tasks)
+}`, 0, v11)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+
+	out := TranspileModels(&ast.ScriptBlock{Funcs: result.Funcs}, result.Models, nil, nil)
+	if len(out.Errors) > 0 {
+		t.Fatalf("transpile errors: %v", out.Errors)
+	}
+
+	for _, want := range []string{
+		`var errForbidden = errors.New("forbidden by policy")`,
+		"func authorizeTask(ctx *GMXContext, action string, task *Task) error {",
+		"allowed = ctx.User != \"\"",
+		"allowed = task.UserID == ctx.User",
+		"allowed = sessionAdmins[ctx.User]",
+		"if stored, err := TaskFind(ctx.DB, fmt.Sprint(obj.ID)); err == nil {",
+		"task, err := TaskFindAuthorized(ctx, id)",
+		"if err := TaskDeleteAuthorized(ctx, task); err != nil {",
+		"tasks, err := TaskAllAuthorized(ctx)",
+	} {
+		if !strings.Contains(out.GoCode, want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+	if strings.Contains(out.GoCode, "case \"create\"") {
+		t.Error("create has no rule and should not be checked")
+	}
+}
+
+func TestTranspilePolicyUnsupportedRole(t *testing.T) {
+	result, errs := ParseVersion("model Task {\n  id: uuid @pk\n}\npolicy Task { delete: role(editor) }", 0, v11)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+
+	out := TranspileModels(&ast.ScriptBlock{}, result.Models, nil, nil)
+	if len(out.Errors) != 1 || !strings.Contains(out.Errors[0], `unsupported role "editor"`) {
+		t.Errorf("expected an unsupported role error, got %v", out.Errors)
+	}
+}
+
+func TestPolicyHelperNamesReserved(t *testing.T) {
+	_, errs := ParseVersion("model Task {\n  id: uuid @pk\n}\nfunc authorizeTask() error {\n  return nil\n}", 0, v11)
+	if len(errs) != 1 || !strings.Contains(errs[0], "collides with the generated ORM helper of model Task") {
+		t.Errorf("expected a collision error, got %v", errs)
+	}
+}
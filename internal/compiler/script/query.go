@@ -0,0 +1,255 @@
+package script
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// A model query chains query methods on a model:
+//
+//	Task.where(done: false).order(createdAt, desc).limit(10)
+//	Task.where(userId: ctx.user).first()
+//
+// It transpiles to the Where ORM helper, or First when it ends with first(),
+// one GORM scope per condition, order and limit:
+//
+//	TaskWhere(ctx.DB, queryWhere("done", false), queryOrder("created_at", true), queryLimit(10))
+//
+// The helpers checking a policy take the limit apart, as it counts the
+// records the read rule keeps:
+//
+//	TaskWhereAuthorized(ctx, 10, queryWhere("done", false), queryOrder("created_at", true))
+//
+// include: loads a relation with the records, in the same round trips:
+//
+//	Task.all(include: user) → TaskWhere(ctx.DB, queryPreload("User"))
+
+// queryMethods are the methods a model query chains
+var queryMethods = map[string]bool{"all": true, "where": true, "order": true, "limit": true, "first": true}
+
+// queryMethod returns the method name of a call in a model query
+func queryMethod(call *ast.CallExpr) string {
+	return call.Function.(*ast.MemberExpr).Property
+}
+
+// modelQuery returns the model and the calls of the query chain expr ends,
+// root first, or false when expr does not query a model
+func (t *Transpiler) modelQuery(expr *ast.CallExpr) (string, []*ast.CallExpr, bool) {
+	member, ok := expr.Function.(*ast.MemberExpr)
+	if !ok || !queryMethods[member.Property] {
+		return "", nil, false
+	}
+	switch obj := member.Object.(type) {
+	case *ast.Ident:
+		if t.isModelType(obj.Name) {
+			return obj.Name, []*ast.CallExpr{expr}, true
+		}
+	case *ast.CallExpr:
+		if model, calls, ok := t.modelQuery(obj); ok {
+			return model, append(calls, expr), true
+		}
+	}
+	return "", nil, false
+}
+
+// transpileQuery converts a model query to the Where or First ORM helper,
+// through the helpers checking the policy of the model if it has one
+func (t *Transpiler) transpileQuery(model string, calls []*ast.CallExpr) string {
+	var scopes []string
+	helper := model + "Where"
+	// The policy helpers apply the limit to the records the read rule keeps
+	limit := "0"
+	for i, call := range calls {
+		method := queryMethod(call)
+		scopes = append(scopes, t.includeScopes(model, call)...)
+		switch method {
+		case "all":
+			if i > 0 {
+				t.errors = append(t.errors, fmt.Sprintf("line %d: all() starts a %s query, it cannot follow another method", call.Line, model))
+			}
+		case "where":
+			scopes = append(scopes, t.whereScopes(model, call)...)
+		case "order":
+			if scope := t.orderScope(model, call); scope != "" {
+				scopes = append(scopes, scope)
+			}
+		case "limit":
+			if len(call.Args) != 1 {
+				t.errors = append(t.errors, fmt.Sprintf("line %d: limit() takes a number of records, got %d arguments", call.Line, len(call.Args)))
+				continue
+			}
+			if t.policies[model] != nil {
+				limit = t.transpileExpr(call.Args[0])
+				continue
+			}
+			scopes = append(scopes, fmt.Sprintf("queryLimit(%s)", t.transpileExpr(call.Args[0])))
+		case "first":
+			if i != len(calls)-1 {
+				t.errors = append(t.errors, fmt.Sprintf("line %d: first() ends a %s query, it cannot be followed by another method", call.Line, model))
+			}
+			helper = model + "First"
+		}
+		if (method == "all" || method == "first") && len(call.Args) > len(includeArgs(call)) {
+			t.errors = append(t.errors, fmt.Sprintf("line %d: %s() only takes include: arguments", call.Line, method))
+		}
+	}
+
+	args := []string{"ctx.DB"}
+	if t.policies[model] != nil {
+		args[0] = "ctx"
+		if helper == model+"Where" {
+			args = append(args, limit)
+		}
+		helper += "Authorized"
+	}
+	return fmt.Sprintf("%s(%s)", helper, strings.Join(append(args, scopes...), ", "))
+}
+
+// whereScopes converts the conditions of where(...): named arguments compare
+// a field, `field contains value` filters a string[] field; && joins conditions
+func (t *Transpiler) whereScopes(model string, call *ast.CallExpr) []string {
+	var conds []ast.Expression
+	for _, arg := range call.Args {
+		conds = append(conds, splitConditions(arg)...)
+	}
+	var scopes []string
+	for _, cond := range conds {
+		if named, ok := cond.(*ast.NamedArg); ok {
+			if named.Name == "include" {
+				continue
+			}
+			if t.checkQueryField(model, named.Name, call.Line) {
+				scopes = append(scopes, fmt.Sprintf("queryWhere(%q, %s)", utils.ToSnakeCase(named.Name), t.transpileExpr(named.Value)))
+			}
+			continue
+		}
+		bin, ok := cond.(*ast.BinaryExpr)
+		var field *ast.Ident
+		if ok && bin.Op == "contains" {
+			field, _ = bin.Left.(*ast.Ident)
+		}
+		if field == nil {
+			t.errors = append(t.errors, fmt.Sprintf("line %d: %s.where() conditions must be `field: value` or `field contains value`", call.Line, model))
+			continue
+		}
+		if t.checkQueryField(model, field.Name, call.Line) {
+			scopes = append(scopes, fmt.Sprintf("listContains(%q, %s)", utils.ToSnakeCase(field.Name), t.transpileExpr(bin.Right)))
+		}
+	}
+	return scopes
+}
+
+// includeArgs returns the include: arguments of a query call
+func includeArgs(call *ast.CallExpr) []*ast.NamedArg {
+	var includes []*ast.NamedArg
+	for _, arg := range call.Args {
+		if named, ok := arg.(*ast.NamedArg); ok && named.Name == "include" {
+			includes = append(includes, named)
+		}
+	}
+	return includes
+}
+
+// includeScopes converts the include: arguments of a query call, each
+// naming a relation field of the model to preload
+func (t *Transpiler) includeScopes(model string, call *ast.CallExpr) []string {
+	var scopes []string
+	for _, include := range includeArgs(call) {
+		relation, ok := include.Value.(*ast.Ident)
+		if !ok {
+			t.errors = append(t.errors, fmt.Sprintf("line %d: include: takes a relation field of %s, such as include: user", call.Line, model))
+			continue
+		}
+		relations, ok := t.relations[model]
+		if ok && relations[relation.Name] == "" {
+			t.errors = append(t.errors, fmt.Sprintf("line %d: model %s has no relation %s", call.Line, model, relation.Name))
+			continue
+		}
+		// Preloaded records skip the policy of their model
+		if related := relations[relation.Name]; HasReadRule(t.policies[related]) {
+			t.errors = append(t.errors, fmt.Sprintf("line %d: include: %s would load %s records past the read rule of their policy; query them with %s.where(...)", call.Line, relation.Name, related, related))
+			continue
+		}
+		scopes = append(scopes, fmt.Sprintf("queryPreload(%q)", utils.ToPascalCase(relation.Name)))
+	}
+	return scopes
+}
+
+// orderScope converts order(field) and order(field, asc|desc)
+func (t *Transpiler) orderScope(model string, call *ast.CallExpr) string {
+	var field, dir *ast.Ident
+	if len(call.Args) == 1 || len(call.Args) == 2 {
+		field, _ = call.Args[0].(*ast.Ident)
+	}
+	if len(call.Args) == 2 {
+		dir, _ = call.Args[1].(*ast.Ident)
+	}
+	if field == nil || (len(call.Args) == 2 && (dir == nil || (dir.Name != "asc" && dir.Name != "desc"))) {
+		t.errors = append(t.errors, fmt.Sprintf("line %d: order() takes a field and an optional direction, asc or desc", call.Line))
+		return ""
+	}
+	t.checkQueryField(model, field.Name, call.Line)
+	return fmt.Sprintf("queryOrder(%q, %t)", utils.ToSnakeCase(field.Name), dir != nil && dir.Name == "desc")
+}
+
+// checkQueryField reports a query on a field the model does not declare;
+// models are only known by name when transpiling without their declarations
+func (t *Transpiler) checkQueryField(model, field string, line int) bool {
+	if fields, ok := t.fields[model]; ok && !fields[field] {
+		t.errors = append(t.errors, fmt.Sprintf("line %d: model %s has no field %s", line, model, field))
+		return false
+	}
+	return true
+}
+
+// genQueryScopes emits the GORM scopes model queries are made of
+func (t *Transpiler) genQueryScopes() {
+	t.emit("// queryWhere keeps the records whose column equals value\n")
+	t.emit("func queryWhere(column string, value any) func(*gorm.DB) *gorm.DB {\n")
+	t.emit("\treturn func(db *gorm.DB) *gorm.DB {\n")
+	t.emit("\t\treturn db.Where(map[string]any{column: value})\n")
+	t.emit("\t}\n")
+	t.emit("}\n\n")
+
+	t.emit("// queryOrder sorts the records by column, which comes from a model field\n")
+	t.emit("func queryOrder(column string, desc bool) func(*gorm.DB) *gorm.DB {\n")
+	t.emit("\tif desc {\n")
+	t.emit("\t\tcolumn += \" DESC\"\n")
+	t.emit("\t}\n")
+	t.emit("\treturn func(db *gorm.DB) *gorm.DB {\n")
+	t.emit("\t\treturn db.Order(column)\n")
+	t.emit("\t}\n")
+	t.emit("}\n\n")
+
+	t.emit("// queryPreload loads a relation of the records, named by its Go field\n")
+	t.emit("func queryPreload(relation string) func(*gorm.DB) *gorm.DB {\n")
+	t.emit("\treturn func(db *gorm.DB) *gorm.DB {\n")
+	t.emit("\t\treturn db.Preload(relation)\n")
+	t.emit("\t}\n")
+	t.emit("}\n\n")
+
+	t.emit("// queryLimit keeps the first n records\n")
+	t.emit("func queryLimit(n int) func(*gorm.DB) *gorm.DB {\n")
+	t.emit("\treturn func(db *gorm.DB) *gorm.DB {\n")
+	t.emit("\t\treturn db.Limit(n)\n")
+	t.emit("\t}\n")
+	t.emit("}\n\n")
+}
+
+// genFirstHelper emits the First ORM helper of a model, which goes through
+// its Where helper and thus through its repository if it has one
+func (t *Transpiler) genFirstHelper(model string) {
+	t.emit("func %sFirst(db *gorm.DB, scopes ...func(*gorm.DB) *gorm.DB) (*%s, error) {\n", model, model)
+	t.emit("\tobjs, err := %sWhere(db, append(scopes, queryLimit(1))...)\n", model)
+	t.emit("\tif err != nil {\n")
+	t.emit("\t\treturn nil, err\n")
+	t.emit("\t}\n")
+	t.emit("\tif len(objs) == 0 {\n")
+	t.emit("\t\treturn nil, gorm.ErrRecordNotFound\n")
+	t.emit("\t}\n")
+	t.emit("\treturn &objs[0], nil\n")
+	t.emit("}\n\n")
+}
@@ -0,0 +1,201 @@
+package script
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+const queryModels = `model Task {
+  id:        uuid @pk
+  title:     string
+  done:      bool
+  tags:      string[]
+  createdAt: datetime
+}
+`
+
+// transpileQueryFunc transpiles a function returning the given query
+func transpileQueryFunc(t *testing.T, models, query string) *TranspileResult {
+	t.Helper()
+	input := models + "func f(n: int) error {\n  let tasks = try " + query + "\n  return render(tasks)\n}"
+	result, errs := ParseVersion(input, 0, v11)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	return TranspileModels(&ast.ScriptBlock{Funcs: result.Funcs}, result.Models, nil, nil)
+}
+
+func TestTranspileModelQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"where", `Task.where(done: false)`, `TaskWhere(ctx.DB, queryWhere("done", false))`},
+		{"where several", `Task.where(done: false, title: "a")`, `TaskWhere(ctx.DB, queryWhere("done", false), queryWhere("title", "a"))`},
+		{"where contains", `Task.where(tags contains "urgent", done: true)`, `TaskWhere(ctx.DB, listContains("tags", "urgent"), queryWhere("done", true))`},
+		{"order limit", `Task.where(done: false).order(createdAt, desc).limit(n)`, `TaskWhere(ctx.DB, queryWhere("done", false), queryOrder("created_at", true), queryLimit(n))`},
+		{"order ascending", `Task.all().order(title)`, `TaskWhere(ctx.DB, queryOrder("title", false))`},
+		{"first", `Task.where(done: false).first()`, `TaskFirst(ctx.DB, queryWhere("done", false))`},
+		{"all", `Task.all()`, `TaskAll(ctx.DB)`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := transpileQueryFunc(t, queryModels, tt.query)
+			if len(result.Errors) > 0 {
+				t.Fatalf("transpile errors: %v", result.Errors)
+			}
+			if !strings.Contains(result.GoCode, "tasks, err := "+tt.want) {
+				t.Errorf("expected tasks, err := %s in:\n%s", tt.want, result.GoCode)
+			}
+		})
+	}
+}
+
+func TestTranspileModelQueryFirstIsRecord(t *testing.T) {
+	input := queryModels + `func f() error {
+  let task = try Task.where(done: false).first()
+  task.done = true
+  try task.save()
+  return nil
+}`
+	result, errs := ParseVersion(input, 0, v11)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	out := TranspileModels(&ast.ScriptBlock{Funcs: result.Funcs}, result.Models, nil, nil)
+	if len(out.Errors) > 0 {
+		t.Fatalf("transpile errors: %v", out.Errors)
+	}
+	// first() returns one record, saved through its ORM helper
+	for _, want := range []string{"if err := TaskSave(ctx.DB, task); err != nil {", "func TaskFirst(db *gorm.DB, scopes ...func(*gorm.DB) *gorm.DB) (*Task, error) {"} {
+		if !strings.Contains(out.GoCode, want) {
+			t.Errorf("expected %q in:\n%s", want, out.GoCode)
+		}
+	}
+}
+
+func TestTranspileModelQueryPolicy(t *testing.T) {
+	models := queryModels + "policy Task { read: ctx.user != \"\" }\n"
+	result := transpileQueryFunc(t, models, `Task.where(done: false).first()`)
+	if len(result.Errors) > 0 {
+		t.Fatalf("transpile errors: %v", result.Errors)
+	}
+	if !strings.Contains(result.GoCode, `TaskFirstAuthorized(ctx, queryWhere("done", false))`) {
+		t.Errorf("expected the query to check the policy:\n%s", result.GoCode)
+	}
+
+	// The limit counts the records the read rule keeps
+	result = transpileQueryFunc(t, models, `Task.where(done: false).limit(10)`)
+	if !strings.Contains(result.GoCode, `TaskWhereAuthorized(ctx, 10, queryWhere("done", false))`) {
+		t.Errorf("expected the limit to be passed to the policy helper:\n%s", result.GoCode)
+	}
+}
+
+const includeModels = `model User {
+  id:   uuid @pk
+  name: string
+}
+
+model Task {
+  id:     uuid @pk
+  title:  string
+  done:   bool
+  userId: uuid
+  user:   User @relation(references: [id])
+}
+`
+
+func TestTranspileModelQueryInclude(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"all", `Task.all(include: user)`, `TaskWhere(ctx.DB, queryPreload("User"))`},
+		{"where", `Task.where(done: false, include: user)`, `TaskWhere(ctx.DB, queryPreload("User"), queryWhere("done", false))`},
+		{"first", `Task.where(done: false).first(include: user)`, `TaskFirst(ctx.DB, queryWhere("done", false), queryPreload("User"))`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := transpileQueryFunc(t, includeModels, tt.query)
+			if len(result.Errors) > 0 {
+				t.Fatalf("transpile errors: %v", result.Errors)
+			}
+			if !strings.Contains(result.GoCode, "tasks, err := "+tt.want) {
+				t.Errorf("expected tasks, err := %s in:\n%s", tt.want, result.GoCode)
+			}
+		})
+	}
+}
+
+func TestTranspileModelQueryIncludePolicy(t *testing.T) {
+	// Preloaded users would skip the read rule of their policy
+	result := transpileQueryFunc(t, includeModels+"policy User { read: user.id == ctx.user }\n", `Task.all(include: user)`)
+	want := "line 15: include: user would load User records past the read rule of their policy; query them with User.where(...)"
+	if !strings.Contains(strings.Join(result.Errors, "\n"), want) {
+		t.Errorf("expected error containing %q, got %v", want, result.Errors)
+	}
+
+	// Rules on writes alone leave reads open
+	result = transpileQueryFunc(t, includeModels+"policy User { delete: role(admin) }\n", `Task.all(include: user)`)
+	if len(result.Errors) > 0 {
+		t.Errorf("transpile errors: %v", result.Errors)
+	}
+}
+
+func TestTranspileModelPreload(t *testing.T) {
+	models := strings.Replace(includeModels, "model Task {", "@preload(user)\nmodel Task {", 1)
+	result := transpileQueryFunc(t, models, `Task.all()`)
+	if len(result.Errors) > 0 {
+		t.Fatalf("transpile errors: %v", result.Errors)
+	}
+	// The ORM helpers of Task load its user; those of User are unchanged
+	for _, want := range []string{
+		`if err := db.Preload("User").First(&obj, "id = ?", id).Error; err != nil {`,
+		`if err := db.Preload("User").Find(&objs).Error; err != nil {`,
+		`if err := db.Preload("User").Scopes(scopes...).Find(&objs).Error; err != nil {`,
+		`if err := db.Find(&objs).Error; err != nil {`,
+	} {
+		if !strings.Contains(result.GoCode, want) {
+			t.Errorf("expected %q in:\n%s", want, result.GoCode)
+		}
+	}
+}
+
+func TestTranspileModelQueryErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		wantErr string
+	}{
+		{"unknown field", `Task.where(status: "open")`, "line 9: model Task has no field status"},
+		{"condition", `Task.where(done == false)`, "Task.where() conditions must be `field: value` or `field contains value`"},
+		{"direction", `Task.all().order(title, up)`, "order() takes a field and an optional direction, asc or desc"},
+		{"first not last", `Task.all().first().limit(1)`, "first() ends a Task query"},
+		{"all not first", `Task.where(done: true).all()`, "all() starts a Task query"},
+		{"limit", `Task.all().limit()`, "limit() takes a number of records, got 0 arguments"},
+		{"named argument", `Task.find(id: n)`, "named argument id: only Model.where() and log calls take named arguments"},
+		{"all arguments", `Task.all(done: true)`, "all() only takes include: arguments"},
+		{"include field", `Task.all(include: title)`, "line 9: model Task has no relation title"},
+		{"include expression", `Task.all(include: "tags")`, "include: takes a relation field of Task, such as include: user"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := transpileQueryFunc(t, queryModels, tt.query)
+			if !strings.Contains(strings.Join(result.Errors, "\n"), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, result.Errors)
+			}
+		})
+	}
+
+	_, errs := Parse("func f() error {\n  let tasks = try Task.where(done: false)\n  return nil\n}", 0)
+	if len(errs) != 1 || !strings.Contains(errs[0], "named arguments require gmx 1.1") {
+		t.Errorf("expected named arguments to be gated, got %v", errs)
+	}
+}
@@ -0,0 +1,284 @@
+package script
+
+import (
+	"fmt"
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// goKeywords cannot be used as Go identifiers
+var goKeywords = map[string]bool{
+	"break": true, "case": true, "chan": true, "const": true, "continue": true,
+	"default": true, "defer": true, "else": true, "fallthrough": true, "for": true,
+	"func": true, "go": true, "goto": true, "if": true, "import": true,
+	"interface": true, "map": true, "package": true, "range": true, "return": true,
+	"select": true, "struct": true, "switch": true, "type": true, "var": true,
+}
+
+// goPredeclared are the Go builtins the generated code relies on; declaring
+// them again shadows them for the whole package or function
+var goPredeclared = map[string]bool{
+	"any": true, "bool": true, "byte": true, "comparable": true, "complex64": true,
+	"complex128": true, "error": true, "float32": true, "float64": true, "int": true,
+	"int8": true, "int16": true, "int32": true, "int64": true, "rune": true,
+	"string": true, "uint": true, "uint8": true, "uint16": true, "uint32": true,
+	"uint64": true, "uintptr": true, "true": true, "false": true, "iota": true,
+	"nil": true, "append": true, "cap": true, "clear": true, "close": true,
+	"complex": true, "copy": true, "delete": true, "imag": true, "len": true,
+	"make": true, "max": true, "min": true, "new": true, "panic": true,
+	"print": true, "println": true, "real": true, "recover": true,
+}
+
+// generatedNames are package-level identifiers emitted by the generator
+var generatedNames = map[string]bool{
+	"main": true, "init": true, "db": true, "tmpl": true, "pageTemplate": true,
+	"PageData": true, "GMXContext": true, "Money": true, "JSON": true,
+	"StringList": true, "Blob": true, "factory": true, "factories": true,
+	"factorySeq": true, "factoryString": true, "renderFragment": true,
+	"renderItem": true, "generateUUID": true, "isValidEmail": true,
+	"isValidUUID": true, "scopedDB": true, "csrfProtect": true,
+	"generateCSRFToken": true, "securityHeaders": true, "initDatabase": true,
+	"parseMoney": true, "formatMoney": true, "readBlob": true,
+	"listContains": true, "jsonString": true, "errForbidden": true,
+	"queryWhere": true, "queryOrder": true, "queryLimit": true, "queryPreload": true,
+	"parseRequestBody": true, "decodeJSONBody": true, "mergeBodyValues": true,
+	"signedRoute": true, "signedRoutes": true, "signRoute": true, "verifySignedRoute": true,
+	"settingDecls": true, "settingsCache": true, "settingValue": true,
+	"loadSettings": true, "invalidateSettings": true, "saveSetting": true, "validSetting": true, "settingsTTL": true,
+	"notificationPollInterval": true, "notificationStreams": true, "unreadNotifications": true,
+	"renderNotificationBadge": true, "writeNotificationList": true,
+	"activityFeedPageSize": true, "activityActorKey": true, "recordActivity": true,
+	"typeaheadLimit": true, "typeaheadInput": true, "typeaheadMatch": true,
+	"typeaheadEmpty": true, "highlightMatch": true,
+	"liveWrappers": true, "liveStreams": true, "publishLive": true,
+	"broadcastLive": true, "broadcastLiveDelete": true, "streamLive": true,
+	"ValidationError": true, "renderValidationError": true,
+	"modalActions": true, "modalTrigger": true,
+	"wizardTTL": true, "wizardDraft": true, "wizardDrafts": true, "wizardStep": true,
+	"wizards": true, "wizardView": true, "wizardLoader": true, "loadWizardDraft": true,
+	"saveWizardDraft": true, "dropWizardDraft": true, "renderWizardStep": true, "wizardResponse": true,
+	"newServer": true, "serverTLSFiles": true,
+	"autosaveTTL": true, "autosaveCookie": true, "autosaveForms": true, "autosaveLoader": true,
+	"autosaveOwner": true, "autosaveDraft": true, "renderAutosave": true, "saveAutosave": true, "dropAutosave": true, "cleanupAutosaves": true,
+	"imageStorage": true, "imageVariantKey": true, "imageJobContext": true, "imageContentType": true,
+	"imageDigest": true, "resizeImage": true, "encodeImage": true,
+	"migration": true, "migrations": true, "migrationStatements": true, "appliedMigration": true,
+	"execMigration": true, "runMigrations": true, "revertMigration": true,
+	"jobTTL": true, "jobsCtx": true, "cancelJobs": true, "runningJobs": true, "jobResponse": true,
+	"startJob": true, "runJob": true, "writeJob": true, "cleanupJobs": true, "stopJobs": true,
+	"recordFlag": true, "recordedBodyLimit": true, "recordedExchange": true, "recordSeq": true,
+	"recordRedacted": true, "recordSkippedHeaders": true, "redactedField": true, "redactValues": true,
+	"sanitizeRecordedBody": true, "recordingWriter": true, "recordRequests": true,
+	"chaosFault": true, "chaosServices": true, "chaosMu": true, "chaosFaults": true, "parseChaosFault": true,
+	"loadChaosFaults": true, "chaosTransport": true, "handleChaos": true, "handleChaosSave": true,
+	"jobQueueConfig": true, "queuedJob": true, "jobQueue": true, "jobQueueCtx": true, "cancelJobQueue": true,
+	"queuedResponse": true, "startJobWorkers": true, "enqueueJob": true, "runQueuedJob": true, "drainJobQueue": true,
+	"decodeJobArgs": true, "storedJobRunners": true, "storeJob": true, "storedQueuedJob": true, "refillJobQueue": true,
+	"refillStoredJobs": true, "jobWorkers": true, "jobWait": true, "jobDuration": true, "jobsDropped": true, "observeJob": true,
+	"executeJob": true, "jobLease": true, "pollJobs": true, "claimJob": true, "runStoredJob": true, "jobBackoff": true,
+	"outboxBatchSize": true, "outboxPollInterval": true, "outboxRelay": true, "inOutboxTransaction": true,
+	"startOutboxRelay": true, "relayOutbox": true, "deliverEvent": true, "outboxBackoff": true, "stopOutboxRelay": true,
+	"cronSchedule": true, "scheduledFuncs": true, "scheduleCtx": true, "cancelSchedules": true, "stopScheduling": true,
+	"scheduledRuns": true, "scheduledResponse": true, "startSchedules": true, "runScheduled": true, "stopSchedules": true,
+	"selfCheckFlag": true, "selfCheck": true,
+	"s3Client": true, "s3Bucket": true, "newS3Bucket": true, "s3Escape": true, "storagePath": true, "storageSign": true,
+	"cacheRemember": true,
+	"sessionSecret": true, "sessionAdmins": true, "gmxSession": true, "encodeSession": true, "decodeSession": true,
+	"signSession": true, "sessionPayload": true, "setSessionCookie": true, "readSession": true, "writeSession": true,
+	"clearSession": true, "dropSession": true, "sessionTTL": true, "sessions": true, "sessionStore": true,
+	"memorySessionStore": true, "memorySession": true, "databaseSessionStore": true, "redisSessionStore": true, "cleanupSessions": true,
+	"twoFactorIssuer": true, "twoFactorSetupTTL": true, "totpCode": true, "checkTOTP": true, "backupCodeHash": true, "twoFactorEnabled": true,
+	"verifySecondFactor": true, "enableTwoFactor": true, "disableTwoFactor": true,
+	"twoFactorFailures": true, "recordTwoFactor": true, "renderTwoFactorPage": true,
+	"failureLimiterSweep": true, "failureLimiter": true, "failureCount": true, "newFailureLimiter": true,
+	"loginAccounts": true, "loginAddresses": true, "lockoutMailer": true, "clientAddress": true, "loginRetryAfter": true,
+	"loginFailed": true, "notifyLockout": true,
+	"accountGrace": true, "emailChangeTTL": true, "accountMailer": true, "accountGraceText": true, "sendAccountMail": true,
+	"accountDeleting": true, "activeSession": true, "restoreAccount": true, "purgeAccounts": true, "purgeAccount": true,
+	"loadAccount": true, "emailChangeSignature": true, "absoluteURL": true, "renderAccountPage": true,
+	"submissionWindow": true, "submissions": true, "onceField": true, "claimSubmission": true, "releaseSubmission": true,
+	"queryLogger": true, "queryLog": true, "newQueryLogger": true, "querySourceFile": true,
+	"querySource": true, "querySourceLines": true, "gormlogger": true,
+	"slog": true, "setupLogging": true, "requestLoggerKey": true, "requestLogger": true, "newRequestID": true,
+	"validRequestID": true, "statusWriter": true, "logRequests": true,
+	"personalDataSet": true, "appendPersonalData": true, "loadPersonalData": true, "personalDataCSVValue": true,
+	"writePersonalDataCSV": true, "zip": true, "csv": true,
+	"metricsBuckets": true, "metricsHistogram": true, "routeMetrics": true, "metricsMu": true, "routeMetricsOf": true,
+	"queryLatency": true, "metricsMethod": true, "instrumentRoute": true, "queryStartKey": true, "metricsPlugin": true,
+	"startQueryTimer": true, "observeQuery": true, "sortedMetricsKeys": true, "writeMetricsHistogram": true,
+	"tracerProvider": true, "serverRootSampler": true, "traceRoute": true, "flushSpans": true, "otel": true,
+	"otelattribute": true, "otelpropagation": true, "otelresource": true, "sdktrace": true, "oteltrace": true,
+	"otelhttp": true, "otelgorm": true, "otlptracehttp": true, "policyVersion": true, "policyURL": true,
+	"consentAccepted": true, "hasConsented": true,
+	"consentNext": true, "consentExempt": true, "requireConsent": true,
+}
+
+// generatedMethods are methods generated on every model; a field with the
+// same Go name would collide with them
+var generatedMethods = map[string]bool{
+	"Validate": true, "BeforeCreate": true, "BeforeSave": true,
+	"AfterCreate": true, "AfterUpdate": true, "AfterDelete": true, "AfterSave": true,
+	"TableName": true,
+}
+
+// handlerLocals are names the generated handlers and functions declare
+// around script parameters and local variables
+var handlerLocals = map[string]bool{
+	"ctx": true, "w": true, "r": true, "err": true,
+	"sagaCompensations": true, "sagaErr": true, "reqCtx": true, "cancel": true, "reqSession": true,
+	"loginAccount": true,
+}
+
+// ormHelperSuffixes are appended to model names for the generated ORM helpers (TaskFind)
+// and the helpers checking their policy (TaskFindAuthorized)
+var ormHelperSuffixes = []string{"Find", "All", "Where", "First", "Save", "Delete",
+	"Readable", "FindAuthorized", "AllAuthorized", "WhereAuthorized", "FirstAuthorized", "SaveAuthorized", "DeleteAuthorized"}
+
+// checkReservedNames reports declarations whose names would generate
+// uncompilable Go or shadow builtins and generated code
+func checkReservedNames(result *ParseResult) []string {
+	var errs []string
+	report := func(line int, format string, args ...any) {
+		errs = append(errs, fmt.Sprintf("line %d: %s", line, fmt.Sprintf(format, args...)))
+	}
+
+	ormHelpers := make(map[string]string)
+	for _, model := range result.Models {
+		if reason := reservedReason(model.Name); reason != "" {
+			report(model.Line, "model %q %s; rename it", model.Name, reason)
+		}
+		for _, suffix := range ormHelperSuffixes {
+			ormHelpers[model.Name+suffix] = model.Name
+		}
+		ormHelpers["authorize"+model.Name] = model.Name
+		for _, field := range model.Fields {
+			if goName := utils.ToPascalCase(field.Name); generatedMethods[goName] {
+				report(field.Line, "field %q of model %s collides with the generated %s method; rename it", field.Name, model.Name, goName)
+			}
+		}
+	}
+
+	// Script code calls the services with methods through a package variable
+	serviceVars := make(map[string]string)
+	for _, svc := range result.Services {
+		if len(svc.Methods) > 0 {
+			serviceVars[ServiceVar(svc.Name)] = svc.Name
+		}
+	}
+
+	for _, v := range result.Vars {
+		if reason := reservedReason(v.Name); reason != "" {
+			report(v.Line, "variable %q %s; rename it", v.Name, reason)
+		} else if svc, ok := serviceVars[v.Name]; ok {
+			report(v.Line, "variable %q collides with the variable of service %s; rename it", v.Name, svc)
+		}
+	}
+
+	for _, setting := range result.Settings {
+		if reason := reservedReason(setting.Name); reason != "" {
+			report(setting.Line, "setting %q %s; rename it", setting.Name, reason)
+		}
+	}
+
+	funcNames := make(map[string]bool)
+	for _, fn := range result.Funcs {
+		funcNames[fn.Name] = true
+	}
+
+	for _, fn := range result.Funcs {
+		if fn.FindAnnotation("job") != nil {
+			if helper := enqueueHelper(fn.Name); generatedNames[helper] || funcNames[helper] {
+				report(fn.Line, "func %q collides with %s, which queues it; rename it", fn.Name, helper)
+			}
+		}
+		if reason := reservedReason(fn.Name); reason != "" {
+			report(fn.Line, "func %q %s; rename it", fn.Name, reason)
+		} else if model, ok := ormHelpers[fn.Name]; ok {
+			report(fn.Line, "func %q collides with the generated ORM helper of model %s; rename it", fn.Name, model)
+		} else if svc, ok := serviceVars[fn.Name]; ok {
+			report(fn.Line, "func %q collides with the variable of service %s; rename it", fn.Name, svc)
+		}
+		for _, param := range fn.Params {
+			if reason := localReason(fn, param.Name); reason != "" {
+				report(param.Line, "parameter %q of func %s %s; rename it", param.Name, fn.Name, reason)
+			}
+		}
+		checkLocalNames(fn, fn.Body, report)
+	}
+
+	return errs
+}
+
+// checkLocalNames reports let/const names of a function body, nested blocks included
+func checkLocalNames(fn *ast.FuncDecl, body []ast.Statement, report func(int, string, ...any)) {
+	for _, stmt := range body {
+		// Statements that failed to parse are kept as typed nil pointers
+		switch s := stmt.(type) {
+		case *ast.LetStmt:
+			if s == nil {
+				continue
+			}
+			if reason := localReason(fn, s.Name); reason != "" {
+				report(s.Line, "variable %q of func %s %s; rename it", s.Name, fn.Name, reason)
+			}
+			if remember := rememberValue(s.Value); remember != nil {
+				checkLocalNames(fn, remember.Body, report)
+			}
+		case *ast.IfStmt:
+			if s == nil {
+				continue
+			}
+			checkLocalNames(fn, s.Consequence, report)
+			checkLocalNames(fn, s.Alternative, report)
+		case *ast.ForStmt:
+			if s == nil {
+				continue
+			}
+			for _, name := range []string{s.Index, s.Value} {
+				if reason := localReason(fn, name); name != "" && reason != "" {
+					report(s.Line, "variable %q of func %s %s; rename it", name, fn.Name, reason)
+				}
+			}
+			checkLocalNames(fn, s.Body, report)
+		case *ast.SagaStmt:
+			if s == nil {
+				continue
+			}
+			for _, step := range s.Steps {
+				checkLocalNames(fn, step.Body, report)
+				checkLocalNames(fn, step.Compensate, report)
+			}
+		}
+	}
+}
+
+// reservedReason explains why a package-level name is reserved, or returns ""
+func reservedReason(name string) string {
+	switch {
+	case goKeywords[name]:
+		return "is a reserved Go keyword"
+	case goPredeclared[name]:
+		return "shadows a Go builtin"
+	case generatedNames[name]:
+		return "collides with generated code"
+	}
+	return ""
+}
+
+// localReason explains why a parameter or local variable name of a function
+// is reserved, or returns ""
+func localReason(fn *ast.FuncDecl, name string) string {
+	if handlerLocals[name] {
+		return "is used by the generated handler"
+	}
+	if name == "job" && fn.FindAnnotation("async") != nil {
+		return "is the job of the @async function"
+	}
+	if goKeywords[name] {
+		return "is a reserved Go keyword"
+	}
+	if goPredeclared[name] {
+		return "shadows a Go builtin"
+	}
+	return ""
+}
@@ -0,0 +1,163 @@
+package script
+
+import (
+	"fmt"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/token"
+)
+
+// A saga runs steps touching several services, and undoes the steps that
+// succeeded when a later one fails:
+//
+//	saga {
+//	  step {
+//	    try order.save()
+//	  } compensate {
+//	    try order.delete()
+//	  }
+//	  step {
+//	    try Payments.charge(order.id)
+//	  }
+//	}
+
+// isSagaStart reports whether the current token opens a saga: saga is a
+// contextual keyword, followed by a block
+func (p *Parser) isSagaStart() bool {
+	return p.curTokenIs(token.IDENT) && p.curToken.Literal == "saga" && p.peekTokenIs(token.LBRACE)
+}
+
+// parseSagaStatement parses saga { step { ... } compensate { ... } ... };
+// each step may be followed by the compensation undoing it
+func (p *Parser) parseSagaStatement() *ast.SagaStmt {
+	stmt := &ast.SagaStmt{
+		Line: p.curToken.Pos.Line + p.lineOffset,
+	}
+	p.requireFeature("saga blocks")
+
+	p.nextToken() // consume '{'
+	p.nextToken()
+
+	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
+		if !p.curTokenIs(token.IDENT) || p.curToken.Literal != "step" {
+			p.error(fmt.Sprintf("expected step in saga, got %s", p.curToken.Literal))
+			return nil
+		}
+		step := &ast.SagaStep{
+			Line: p.curToken.Pos.Line + p.lineOffset,
+		}
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		step.Body = p.parseBlockStatement()
+
+		if p.peekTokenIs(token.IDENT) && p.peekToken.Literal == "compensate" {
+			p.nextToken()
+			if !p.expectPeek(token.LBRACE) {
+				return nil
+			}
+			step.Compensate = p.parseBlockStatement()
+		}
+
+		stmt.Steps = append(stmt.Steps, step)
+		p.nextToken()
+	}
+
+	if !p.curTokenIs(token.RBRACE) {
+		p.error("unterminated saga")
+		return nil
+	}
+	if len(stmt.Steps) == 0 {
+		p.error("saga requires at least one step")
+	}
+	return stmt
+}
+
+// transpileSagaStmt runs the saga steps in order inside a closure, so that a
+// failing try or returned error leaves it; the compensations of the steps that
+// succeeded then run in reverse order before the error is returned. A failing
+// compensation is logged and the next one still runs
+func (t *Transpiler) transpileSagaStmt(stmt *ast.SagaStmt) {
+	if t.returnType != "error" && t.sagaDepth == 0 {
+		t.errors = append(t.errors, fmt.Sprintf("line %d: saga requires a function returning error", stmt.Line))
+		return
+	}
+	if t.rememberType != "" {
+		t.errors = append(t.errors, fmt.Sprintf("line %d: a saga cannot run in a remember block", stmt.Line))
+		return
+	}
+
+	t.emitIndent()
+	t.emitLineComment(stmt.Line)
+	t.emit("{\n")
+	t.indent++
+	t.emitIndent()
+	t.emit("var sagaCompensations []func() error\n")
+	t.emitIndent()
+	t.emit("if sagaErr := func() error {\n")
+	t.indent++
+	t.sagaDepth++
+	for _, step := range stmt.Steps {
+		t.emitIndent()
+		t.emitLineComment(step.Line)
+		for _, s := range step.Body {
+			t.transpileStmt(s)
+		}
+		if len(step.Compensate) == 0 {
+			continue
+		}
+		t.emitIndent()
+		t.emit("sagaCompensations = append(sagaCompensations, func() error {\n")
+		t.indent++
+		for _, s := range step.Compensate {
+			t.transpileStmt(s)
+		}
+		if !t.endsWithReturn(step.Compensate) {
+			t.emitIndent()
+			t.emit("return nil\n")
+		}
+		t.indent--
+		t.emitIndent()
+		t.emit("})\n")
+	}
+	t.sagaDepth--
+	t.emitIndent()
+	t.emit("return nil\n")
+	t.indent--
+	t.emitIndent()
+	t.emit("}(); sagaErr != nil {\n")
+	t.indent++
+	t.emitIndent()
+	t.emit("for i := len(sagaCompensations) - 1; i >= 0; i-- {\n")
+	t.indent++
+	t.emitIndent()
+	t.emit("if err := sagaCompensations[i](); err != nil {\n")
+	t.emitIndent()
+	t.emit("\tctx.Log.Error(\"saga compensation failed\", \"func\", %q, \"error\", err)\n", t.currentFunc)
+	t.emitIndent()
+	t.emit("}\n")
+	t.indent--
+	t.emitIndent()
+	t.emit("}\n")
+	t.emitIndent()
+	t.emit("return sagaErr\n")
+	t.indent--
+	t.emitIndent()
+	t.emit("}\n")
+	t.indent--
+	t.emitIndent()
+	t.emit("}\n")
+}
+
+// isRender reports whether a returned value renders a response
+func isRender(expr ast.Expression) bool {
+	if _, ok := expr.(*ast.RenderExpr); ok {
+		return true
+	}
+	if call, ok := expr.(*ast.CallExpr); ok {
+		if ident, ok := call.Function.(*ast.Ident); ok && ident.Name == "render" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+package script
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/lang"
+)
+
+const sagaScript = `func placeOrder(title: string) error {
+  const order = Order{title: title}
+  saga {
+    step {
+      try order.save()
+    } compensate {
+      try order.delete()
+    }
+    step {
+      try Payments.charge(order.id)
+    }
+  }
+  return render(order)
+}
+service Payments {
+  provider: "custom"
+  func charge(orderId: uuid) error
+}
+model Order {
+  id: uuid @pk @default(uuid_v4)
+  title: string
+}`
+
+func TestParseSaga(t *testing.T) {
+	result, errs := ParseVersion(sagaScript, 0, lang.Version{Major: 1, Minor: 1})
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+
+	body := result.Funcs[0].Body
+	if len(body) != 3 {
+		t.Fatalf("expected 3 statements, got %d", len(body))
+	}
+	saga, ok := body[1].(*ast.SagaStmt)
+	if !ok {
+		t.Fatalf("expected a SagaStmt, got %T", body[1])
+	}
+	if len(saga.Steps) != 2 {
+		t.Fatalf("expected 2 steps, got %d", len(saga.Steps))
+	}
+	if len(saga.Steps[0].Body) != 1 || len(saga.Steps[0].Compensate) != 1 {
+		t.Errorf("step 1: expected 1 statement and 1 compensation, got %d and %d", len(saga.Steps[0].Body), len(saga.Steps[0].Compensate))
+	}
+	if saga.Steps[1].Compensate != nil {
+		t.Errorf("step 2: expected no compensation, got %d statements", len(saga.Steps[1].Compensate))
+	}
+	if saga.Line != 3 || saga.Steps[1].Line != 9 {
+		t.Errorf("expected saga at line 3 and step 2 at line 9, got %d and %d", saga.Line, saga.Steps[1].Line)
+	}
+}
+
+func TestParseSagaErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		version lang.Version
+		wantErr string
+	}{
+		{
+			"older language version",
+			"func f() error {\n  saga {\n    step {\n    }\n  }\n}",
+			lang.Default,
+			"saga blocks require gmx 1.1",
+		},
+		{
+			"no step",
+			"func f() error {\n  saga {\n  }\n}",
+			lang.Version{Major: 1, Minor: 1},
+			"saga requires at least one step",
+		},
+		{
+			"statement outside a step",
+			"func f() error {\n  saga {\n    let x = 1\n  }\n}",
+			lang.Version{Major: 1, Minor: 1},
+			"expected step in saga, got let",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := ParseVersion(tt.input, 0, tt.version)
+			if len(errs) == 0 || !strings.Contains(errs[0], tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, errs)
+			}
+		})
+	}
+}
+
+func TestTranspileSaga(t *testing.T) {
+	result, errs := ParseVersion(sagaScript, 0, lang.Version{Major: 1, Minor: 1})
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+
+	out := TranspileModels(&ast.ScriptBlock{Funcs: result.Funcs}, result.Models, nil, result.Services)
+	if len(out.Errors) > 0 {
+		t.Fatalf("transpile errors: %v", out.Errors)
+	}
+	code := out.GoCode
+
+	for _, want := range []string{
+		"var sagaCompensations []func() error",
+		"if err := paymentsService.Charge(order.ID); err != nil {",
+		"if sagaErr := func() error {",
+		"sagaCompensations = append(sagaCompensations, func() error {",
+		"if err := OrderDelete(ctx.DB, order); err != nil {",
+		"for i := len(sagaCompensations) - 1; i >= 0; i-- {",
+		`ctx.Log.Error("saga compensation failed", "func", "placeOrder", "error", err)`,
+		"return sagaErr",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in:\n%s", want, code)
+		}
+	}
+
+	// A compensation is registered once its step succeeded
+	if strings.Index(code, "OrderSave(ctx.DB, order)") > strings.Index(code, "sagaCompensations = append") {
+		t.Errorf("expected the compensation after the step:\n%s", code)
+	}
+	// The step without compensation registers nothing
+	if n := strings.Count(code, "sagaCompensations = append"); n != 1 {
+		t.Errorf("expected 1 registered compensation, got %d", n)
+	}
+}
+
+func TestTranspileSagaErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{
+			"render inside a step",
+			"func f() error {\n  saga {\n    step {\n      return render(nil)\n    }\n  }\n}",
+			"line 4: a saga step can only return an error; render after the saga",
+		},
+		{
+			"bare return inside a step",
+			"func f() error {\n  saga {\n    step {\n      return\n    }\n  }\n}",
+			"line 4: a saga step can only return an error",
+		},
+		{
+			"function not returning error",
+			"func f() string {\n  saga {\n    step {\n    }\n  }\n  return \"\"\n}",
+			"line 2: saga requires a function returning error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, errs := ParseVersion(tt.input, 0, lang.Version{Major: 1, Minor: 1})
+			if len(errs) > 0 {
+				t.Fatalf("parse errors: %v", errs)
+			}
+			out := TranspileFunction(result.Funcs[0], nil)
+			if len(out.Errors) == 0 || !strings.Contains(out.Errors[0], tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, out.Errors)
+			}
+		})
+	}
+}
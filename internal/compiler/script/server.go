@@ -0,0 +1,202 @@
+package script
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/token"
+)
+
+// The server block configures the HTTP server of the application. Options
+// are given literally or read from environment variables, commas between
+// them are optional:
+//
+//	server {
+//	  port: @env("PORT") @default(8080),
+//	  readTimeout: 10s,
+//	  tls: { cert: @env("TLS_CERT"), key: @env("TLS_KEY") }
+//	}
+
+// serverOptions are the options of a server block and the kind of their value
+var serverOptions = map[string]string{
+	"port":         "int",
+	"host":         "string",
+	"readTimeout":  "duration",
+	"writeTimeout": "duration",
+	"idleTimeout":  "duration",
+	// How long in-flight requests may run once the server stops
+	"shutdownTimeout": "duration",
+}
+
+// serverTLSOptions are the options of the tls block of a server
+var serverTLSOptions = map[string]string{"cert": "string", "key": "string"}
+
+// isServerStart reports whether the current token opens the server block:
+// server is a contextual keyword, followed by a brace
+func (p *Parser) isServerStart() bool {
+	return p.curTokenIs(token.IDENT) && p.curToken.Literal == "server" && p.peekTokenIs(token.LBRACE)
+}
+
+// parseServerDecl parses server { name: value ... tls: { cert: value key: value } };
+// it stops on the closing brace
+func (p *Parser) parseServerDecl() *ast.ServerDecl {
+	server := &ast.ServerDecl{
+		Line: p.curToken.Pos.Line + p.lineOffset,
+	}
+	p.requireFeature("server blocks")
+
+	p.nextToken() // move to {
+	p.nextToken()
+	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
+		if p.curTokenIs(token.COMMA) {
+			p.nextToken()
+			continue
+		}
+		if p.curTokenIs(token.IDENT) && p.curToken.Literal == "tls" && p.peekTokenIs(token.COLON) {
+			if server.TLS != nil {
+				p.error("server declares tls twice")
+				return nil
+			}
+			p.nextToken() // move to :
+			if !p.expectPeek(token.LBRACE) {
+				return nil
+			}
+			p.nextToken()
+			server.TLS = []*ast.ServerOption{}
+			for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
+				if p.curTokenIs(token.COMMA) {
+					p.nextToken()
+					continue
+				}
+				opt := p.parseServerOption("server tls", serverTLSOptions, server.TLS)
+				if opt == nil {
+					return nil
+				}
+				server.TLS = append(server.TLS, opt)
+			}
+			if !p.curTokenIs(token.RBRACE) {
+				p.error("unterminated tls block in server")
+				return nil
+			}
+			p.nextToken() // move past the closing brace of tls
+			continue
+		}
+		opt := p.parseServerOption("server", serverOptions, server.Options)
+		if opt == nil {
+			return nil
+		}
+		server.Options = append(server.Options, opt)
+	}
+
+	if !p.curTokenIs(token.RBRACE) {
+		p.error("unterminated server block")
+		return nil
+	}
+	if server.TLS != nil && (server.FindTLSOption("cert") == nil || server.FindTLSOption("key") == nil) {
+		p.error("server: tls requires a cert and a key")
+		return nil
+	}
+	return server
+}
+
+// parseServerOption parses name: value, where value is a literal of the kind
+// of the option or @env("VAR") with an optional @default(value); it stops on
+// the token following the value
+func (p *Parser) parseServerOption(block string, kinds map[string]string, seen []*ast.ServerOption) *ast.ServerOption {
+	if !p.curTokenIs(token.IDENT) {
+		p.error(fmt.Sprintf("expected an option in %s, got %s", block, p.curToken.Literal))
+		return nil
+	}
+	opt := &ast.ServerOption{
+		Name: p.curToken.Literal,
+		Line: p.curToken.Pos.Line + p.lineOffset,
+	}
+	kind, ok := kinds[opt.Name]
+	if !ok {
+		p.error(fmt.Sprintf("%s: unknown option %s", block, opt.Name))
+		return nil
+	}
+	for _, other := range seen {
+		if other.Name == opt.Name {
+			p.error(fmt.Sprintf("%s declares %s twice", block, opt.Name))
+			return nil
+		}
+	}
+	if !p.expectPeek(token.COLON) {
+		return nil
+	}
+	p.nextToken()
+
+	switch {
+	case p.curTokenIs(token.AT):
+		hasDefault := false
+		for p.curTokenIs(token.AT) {
+			ann := p.parseAnnotation()
+			if ann == nil {
+				return nil
+			}
+			switch ann.Name {
+			case "env":
+				opt.EnvVar = strings.Trim(ann.SimpleArg(), "\"")
+			case "default":
+				opt.Value = strings.Trim(ann.SimpleArg(), "\"")
+				hasDefault = true
+			default:
+				p.error(fmt.Sprintf("%s: %s: unknown annotation @%s, only @env and @default apply to options", block, opt.Name, ann.Name))
+				return nil
+			}
+		}
+		if opt.EnvVar == "" {
+			p.error(fmt.Sprintf("%s: %s: @default requires @env, write the value directly", block, opt.Name))
+			return nil
+		}
+		opt.Required = !hasDefault
+	case kind == "duration" && p.curTokenIs(token.INT) && p.isDurationUnit():
+		opt.Value = p.curToken.Literal + p.peekToken.Literal
+		p.nextToken()
+		p.nextToken()
+	case kind == "int" && p.curTokenIs(token.INT) && !p.isDurationUnit(),
+		kind == "string" && p.curTokenIs(token.STRING),
+		kind == "bool" && (p.curTokenIs(token.TRUE) || p.curTokenIs(token.FALSE)):
+		opt.Value = p.curToken.Literal
+		p.nextToken()
+	default:
+		p.error(fmt.Sprintf("%s: %s expects %s, got %s", block, opt.Name, serverKindName[kind], p.curToken.Literal))
+		return nil
+	}
+
+	if opt.Value != "" {
+		if err := checkServerValue(opt.Name, kind, opt.Value); err != nil {
+			p.errors = append(p.errors, fmt.Sprintf("line %d: %s: %v", opt.Line, block, err))
+			return nil
+		}
+	}
+	return opt
+}
+
+// serverKindName describes the kinds of option values in errors
+var serverKindName = map[string]string{
+	"int":      "a number",
+	"string":   "a string",
+	"duration": "a duration such as 10s",
+	"bool":     "true or false",
+}
+
+// checkServerValue checks a literal or default value against the kind of its option
+func checkServerValue(name, kind, value string) error {
+	switch kind {
+	case "int":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("%s must be a port between 1 and 65535, got %s", name, value)
+		}
+	case "duration":
+		if _, err := time.ParseDuration(value); err != nil {
+			return fmt.Errorf("%s must be a duration such as 10s, got %s", name, value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package script
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/lang"
+)
+
+const serverScript = `server {
+  port: @env("PORT") @default(8080),
+  host: "0.0.0.0",
+  readTimeout: 10s,
+  writeTimeout: @env("WRITE_TIMEOUT") @default(30s)
+  idleTimeout: 2m
+  shutdownTimeout: 15s
+  tls: {
+    cert: @env("TLS_CERT")
+    key: @env("TLS_KEY")
+  }
+}`
+
+func TestParseServer(t *testing.T) {
+	result, errs := ParseVersion(serverScript, 0, lang.Version{Major: 1, Minor: 1})
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	server := result.Server
+	if server == nil {
+		t.Fatal("expected a server block")
+	}
+	if len(server.Options) != 6 || len(server.TLS) != 2 {
+		t.Fatalf("expected 6 options and 2 tls options, got %d and %d", len(server.Options), len(server.TLS))
+	}
+
+	tests := []struct {
+		name     string
+		value    string
+		envVar   string
+		required bool
+	}{
+		{"port", "8080", "PORT", false},
+		{"host", "0.0.0.0", "", false},
+		{"readTimeout", "10s", "", false},
+		{"writeTimeout", "30s", "WRITE_TIMEOUT", false},
+		{"idleTimeout", "2m", "", false},
+		{"shutdownTimeout", "15s", "", false},
+	}
+	for _, tt := range tests {
+		opt := server.FindOption(tt.name)
+		if opt == nil {
+			t.Errorf("missing option %s", tt.name)
+			continue
+		}
+		if opt.Value != tt.value || opt.EnvVar != tt.envVar || opt.Required != tt.required {
+			t.Errorf("%s: got value %q, env %q, required %v", tt.name, opt.Value, opt.EnvVar, opt.Required)
+		}
+	}
+	if cert := server.FindTLSOption("cert"); cert == nil || cert.EnvVar != "TLS_CERT" || !cert.Required {
+		t.Errorf("expected a required TLS_CERT, got %+v", cert)
+	}
+}
+
+func TestParseServerErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		version lang.Version
+		wantErr string
+	}{
+		{"older language version", "server {\n  port: 8080\n}", lang.Default, "server blocks require gmx 1.1"},
+		{"unknown option", "server {\n  timeout: 10s\n}", lang.Version{Major: 1, Minor: 1}, "line 2: server: unknown option timeout"},
+		{"duplicate option", "server {\n  port: 8080\n  port: 8081\n}", lang.Version{Major: 1, Minor: 1}, "server declares port twice"},
+		{"port out of range", "server {\n  port: 70000\n}", lang.Version{Major: 1, Minor: 1}, "port must be a port between 1 and 65535, got 70000"},
+		{"duration without unit", "server {\n  readTimeout: 10\n}", lang.Version{Major: 1, Minor: 1}, "server: readTimeout expects a duration such as 10s, got 10"},
+		{"invalid default", "server {\n  idleTimeout: @env(\"IDLE\") @default(\"soon\")\n}", lang.Version{Major: 1, Minor: 1}, "idleTimeout must be a duration such as 10s, got soon"},
+		{"default without env", "server {\n  port: @default(8080)\n}", lang.Version{Major: 1, Minor: 1}, "server: port: @default requires @env, write the value directly"},
+		{"tls without key", "server {\n  tls: { cert: \"cert.pem\" }\n}", lang.Version{Major: 1, Minor: 1}, "server: tls requires a cert and a key"},
+		{"declared twice", "server {\n  port: 8080\n}\nserver {\n  port: 8081\n}", lang.Version{Major: 1, Minor: 1}, "line 4: server is declared twice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := ParseVersion(tt.input, 0, tt.version)
+			if len(errs) == 0 || !strings.Contains(errs[0], tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, errs)
+			}
+		})
+	}
+}
+
+func TestParseObservability(t *testing.T) {
+	result, errs := ParseVersion("observability {\n  metrics: true\n}", 0, lang.Version{Major: 1, Minor: 1})
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	if result.Observability == nil {
+		t.Fatal("expected an observability block")
+	}
+	if opt := result.Observability.FindOption("metrics"); opt == nil || opt.Value != "true" || opt.Line != 2 {
+		t.Errorf("expected metrics: true on line 2, got %+v", opt)
+	}
+}
+
+func TestParseObservabilityErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		version lang.Version
+		wantErr string
+	}{
+		{"older language version", "observability {\n  metrics: true\n}", lang.Default, "observability blocks require gmx 1.1"},
+		{"unknown option", "observability {\n  tracing: true\n}", lang.Version{Major: 1, Minor: 1}, "line 2: observability: unknown option tracing"},
+		{"not a boolean", "observability {\n  metrics: \"yes\"\n}", lang.Version{Major: 1, Minor: 1}, "observability: metrics expects true or false, got yes"},
+		{"from a variable", "observability {\n  metrics: @env(\"METRICS\") @default(true)\n}", lang.Version{Major: 1, Minor: 1}, "line 2: observability: metrics is decided at build time, write true or false"},
+		{"declared twice", "observability {\n  metrics: true\n}\nobservability {\n  metrics: false\n}", lang.Version{Major: 1, Minor: 1}, "line 4: observability is declared twice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := ParseVersion(tt.input, 0, tt.version)
+			if len(errs) == 0 || !strings.Contains(errs[0], tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, errs)
+			}
+		})
+	}
+}
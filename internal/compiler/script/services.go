@@ -0,0 +1,64 @@
+package script
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/utils"
+)
+
+// Script code calls the declared methods of a service through the package
+// variable main sets to its instance:
+//
+//	try Mailer.send(user.email, "Welcome", body) → mailerService.Send(user.Email, "Welcome", body)
+
+// ServiceVar returns the package variable holding the instance of a service
+// which script code calls
+func ServiceVar(name string) string {
+	return utils.LowerFirst(name) + "Service"
+}
+
+// serviceCall returns the service a call reaches, as Mailer.send(...), or
+// nil when call does not call a service method
+func (t *Transpiler) serviceCall(call *ast.CallExpr) (*ast.ServiceDecl, string) {
+	member, ok := call.Function.(*ast.MemberExpr)
+	if !ok {
+		return nil, ""
+	}
+	ident, ok := member.Object.(*ast.Ident)
+	if !ok {
+		return nil, ""
+	}
+	// A variable named as the service is not the service
+	if _, shadowed := t.varTypes[ident.Name]; shadowed {
+		return nil, ""
+	}
+	return t.services[ident.Name], member.Property
+}
+
+// transpileServiceCall transpiles the call of a service method; the method
+// must be declared by the service, with as many arguments as parameters
+func (t *Transpiler) transpileServiceCall(svc *ast.ServiceDecl, method string, call *ast.CallExpr) string {
+	var decl *ast.ServiceMethod
+	for _, m := range svc.Methods {
+		if m.Name == method {
+			decl = m
+			break
+		}
+	}
+	if decl == nil {
+		t.errors = append(t.errors, fmt.Sprintf("line %d: service %s has no method %s", call.Line, svc.Name, method))
+		return "nil"
+	}
+	if len(call.Args) != len(decl.Params) {
+		t.errors = append(t.errors, fmt.Sprintf("line %d: %s.%s takes %d arguments, got %d", call.Line, svc.Name, method, len(decl.Params), len(call.Args)))
+		return "nil"
+	}
+
+	args := make([]string, 0, len(call.Args))
+	for _, arg := range call.Args {
+		args = append(args, t.transpileExpr(arg))
+	}
+	return fmt.Sprintf("%s.%s(%s)", ServiceVar(svc.Name), utils.ToPascalCase(method), strings.Join(args, ", "))
+}
@@ -0,0 +1,67 @@
+package script
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+)
+
+const servicesScript = `service Mailer {
+  provider: "smtp"
+  func send(to: string, subject: string, body: string) error
+}
+`
+
+// transpileWithServices transpiles a script calling the services it declares
+func transpileWithServices(t *testing.T, src string) *TranspileResult {
+	t.Helper()
+	result, errs := Parse(src, 0)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	return TranspileModels(&ast.ScriptBlock{Funcs: result.Funcs}, result.Models, nil, result.Services)
+}
+
+func TestTranspileServiceCall(t *testing.T) {
+	out := transpileWithServices(t, servicesScript+`func notify(to: string) error {
+  try Mailer.send(to, "Hello", "Welcome")
+  return nil
+}`)
+	if len(out.Errors) > 0 {
+		t.Fatalf("transpile errors: %v", out.Errors)
+	}
+	if want := `if err := mailerService.Send(to, "Hello", "Welcome"); err != nil {`; !strings.Contains(out.GoCode, want) {
+		t.Errorf("expected %q in:\n%s", want, out.GoCode)
+	}
+}
+
+func TestTranspileServiceCallShadowed(t *testing.T) {
+	out := transpileWithServices(t, servicesScript+`func notify(Mailer: string) error {
+  let n = Mailer.len()
+  return nil
+}`)
+	if strings.Contains(out.GoCode, "mailerService") {
+		t.Errorf("a parameter named as the service is not the service:\n%s", out.GoCode)
+	}
+}
+
+func TestTranspileServiceCallErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{"unknown method", `try Mailer.sned(to, "Hello", "Welcome")`, "line 6: service Mailer has no method sned"},
+		{"missing argument", `try Mailer.send(to, "Hello")`, "line 6: Mailer.send takes 3 arguments, got 2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := transpileWithServices(t, servicesScript+"func notify(to: string) error {\n  "+tt.body+"\n  return nil\n}")
+			if len(out.Errors) == 0 || !strings.Contains(out.Errors[0], tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, out.Errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package script
+
+import (
+	"fmt"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/token"
+)
+
+// A setting is a runtime-tunable value, stored in the database and read
+// through a typed accessor named after it:
+//
+//	setting supportEmail: string @default("help@example.com")
+//	setting maxUploads: int @default(10)
+//
+// The declared default applies until an admin stores another value.
+
+// settingTypes are the types a setting may have
+var settingTypes = map[string]bool{"string": true, "int": true, "float": true, "bool": true}
+
+// isSettingStart reports whether the current token opens a setting: setting
+// is a contextual keyword, followed by the name of the setting
+func (p *Parser) isSettingStart() bool {
+	return p.curTokenIs(token.IDENT) && p.curToken.Literal == "setting" && p.peekTokenIs(token.IDENT)
+}
+
+// parseSettingDecl parses: setting name: type @default(value); it stops on
+// the token following the declaration
+func (p *Parser) parseSettingDecl() *ast.SettingDecl {
+	setting := &ast.SettingDecl{
+		Line: p.curToken.Pos.Line + p.lineOffset,
+	}
+	p.requireFeature("settings")
+
+	p.nextToken() // move to the setting name
+	setting.Name = p.curToken.Literal
+	if !p.expectPeek(token.COLON) {
+		return nil
+	}
+	if !p.expectPeekType() {
+		p.error(fmt.Sprintf("expected type after ':', got %s", p.peekToken.Type))
+		return nil
+	}
+	setting.Type = p.curToken.Literal
+	if !settingTypes[setting.Type] {
+		p.error(fmt.Sprintf("setting %s: type %s is not supported, use string, int, float or bool", setting.Name, setting.Type))
+		return nil
+	}
+	p.nextToken()
+
+	for p.curTokenIs(token.AT) {
+		ann := p.parseAnnotation()
+		if ann == nil {
+			return nil
+		}
+		if ann.Name != "default" {
+			p.error(fmt.Sprintf("setting %s: unknown annotation @%s, only @default applies to settings", setting.Name, ann.Name))
+			return nil
+		}
+		setting.Annotations = append(setting.Annotations, ann)
+	}
+	return setting
+}
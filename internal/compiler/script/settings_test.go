@@ -0,0 +1,65 @@
+package script
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/lang"
+)
+
+func TestParseSettings(t *testing.T) {
+	input := `setting supportEmail: string @default("help@example.com")
+setting maxUploads: int @default(10)
+setting maintenance: bool
+
+func ping() error {
+  return nil
+}`
+	result, errs := ParseVersion(input, 0, v11)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	if len(result.Settings) != 3 || len(result.Funcs) != 1 {
+		t.Fatalf("expected 3 settings and 1 func, got %d and %d", len(result.Settings), len(result.Funcs))
+	}
+
+	tests := []struct {
+		name, typ, def string
+		line           int
+	}{
+		{"supportEmail", "string", "help@example.com", 1},
+		{"maxUploads", "int", "10", 2},
+		{"maintenance", "bool", "", 3},
+	}
+	for i, tt := range tests {
+		setting := result.Settings[i]
+		if setting.Name != tt.name || setting.Type != tt.typ || setting.Default() != tt.def || setting.Line != tt.line {
+			t.Errorf("setting %d: expected %s %s @default(%s) at line %d, got %s %s @default(%s) at line %d",
+				i, tt.name, tt.typ, tt.def, tt.line, setting.Name, setting.Type, setting.Default(), setting.Line)
+		}
+	}
+}
+
+func TestParseSettingErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		version lang.Version
+		wantErr string
+	}{
+		{"unsupported type", `setting launchedAt: datetime`, v11, "setting launchedAt: type datetime is not supported"},
+		{"unknown annotation", `setting supportEmail: string @email`, v11, "only @default applies to settings"},
+		{"missing type", `setting supportEmail @default("a")`, v11, "expected next token to be :"},
+		{"reserved", `setting select: string`, v11, `setting "select" is a reserved Go keyword`},
+		{"gated", `setting supportEmail: string`, lang.Default, "settings require gmx 1.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := ParseVersion(tt.input, 0, tt.version)
+			if !strings.Contains(strings.Join(errs, "\n"), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, errs)
+			}
+		})
+	}
+}
@@ -27,14 +27,38 @@ type TranspileResult struct {
 }
 
 type Transpiler struct {
-	buf         strings.Builder
-	sourceMap   *SourceMap
-	goLine      int               // current line in generated Go
-	indent      int               // indentation level
-	models      []string          // known model names for ORM method detection
-	errDeclared bool              // tracks if err variable has been declared in current scope
-	varTypes    map[string]string // tracks variable types for instance method detection
-	currentFunc string            // current function name for context
+	buf          strings.Builder
+	sourceMap    *SourceMap
+	goLine       int                          // current line in generated Go
+	indent       int                          // indentation level
+	models       []string                     // known model names for ORM method detection
+	repos        map[string]bool              // models whose helpers delegate to a @repository
+	policies     map[string]*ast.PolicyDecl   // models whose helpers check a policy
+	pkFields     map[string]string            // Go name of the @pk field of each model
+	pkColumns    map[string]string            // column of the @pk field of each model
+	live         map[string]string            // zero @pk value of each @live model, which tells creations from updates
+	fields       map[string]map[string]bool   // declared fields of each model, checked by queries
+	enums        map[string]map[string]string // Go type of the enum fields of each model, which strings convert to
+	relations    map[string]map[string]string // related model of each relation field of each model, which queries include
+	preloads     map[string][]string          // relation fields each model loads with its records (@preload)
+	varTypes     map[string]string            // tracks variable types for instance method detection
+	currentFunc  string                       // current function name for context
+	configExpr   string                       // Go expression `config` refers to in service methods
+	voidFunc     bool                         // current function has no return value
+	returnType   string                       // Go return type of the current function
+	sagaDepth    int                          // saga closures enclosing the current statement
+	caches       map[string]string            // Go client of each redis service, which remember blocks read
+	services     map[string]*ast.ServiceDecl  // services whose methods script code calls
+	rememberType string                       // Go type of the records of the enclosing remember block, "" outside
+	negotiate    bool                         // current function answers JSON to clients asking for it (@negotiate)
+	jsonAPI      bool                         // current function always answers JSON (@json)
+	async        bool                         // current function runs as a background job (@async), where job is its progress
+	jobs         bool                         // some functions run as background jobs: the context carries their job
+	queued       map[string]*ast.FuncDecl     // @job functions, which enqueue statements queue; nil for a function transpiled alone
+	publishes    bool                         // publish(...) is the builtin recording events in the outbox of ctx
+	funcs        map[string]bool              // functions of the script, called with the context of their caller
+	inPolicy     bool                         // transpiling a policy rule, where role(...) is a builtin
+	errors       []string                     // constructs that cannot be transpiled
 }
 
 func NewTranspiler(modelNames []string) *Transpiler {
@@ -49,12 +73,101 @@ func NewTranspiler(modelNames []string) *Transpiler {
 
 // Transpile converts all functions in a ScriptBlock to Go code
 func Transpile(script *ast.ScriptBlock, modelNames []string) *TranspileResult {
-	t := NewTranspiler(modelNames)
+	return NewTranspiler(modelNames).transpileScript(script)
+}
+
+// TranspileModels is Transpile for the models of an app: the ORM helpers of
+// @repository models forward to their repository, and script code reaches
+// the models with a policy through helpers checking it; remember blocks
+// cache records in the named redis services, and calls reach the methods of
+// the given services
+func TranspileModels(script *ast.ScriptBlock, models []*ast.ModelDecl, caches []string, services []*ast.ServiceDecl) *TranspileResult {
+	names := make([]string, 0, len(models))
+	for _, model := range models {
+		names = append(names, model.Name)
+	}
+	t := NewTranspiler(names)
+	t.repos = make(map[string]bool)
+	t.policies = make(map[string]*ast.PolicyDecl)
+	t.pkFields = make(map[string]string)
+	t.pkColumns = make(map[string]string)
+	t.fields = make(map[string]map[string]bool)
+	t.live = make(map[string]string)
+	t.enums = make(map[string]map[string]string)
+	t.relations = make(map[string]map[string]string)
+	t.preloads = make(map[string][]string)
+	t.caches = make(map[string]string)
+	for _, name := range caches {
+		t.caches[name] = utils.LowerFirst(name) + "Redis"
+	}
+	t.services = make(map[string]*ast.ServiceDecl)
+	for _, svc := range services {
+		t.services[svc.Name] = svc
+	}
+	known := make(map[string]bool)
+	for _, name := range names {
+		known[name] = true
+	}
+	for _, model := range models {
+		t.fields[model.Name] = make(map[string]bool)
+		t.enums[model.Name] = make(map[string]string)
+		t.relations[model.Name] = make(map[string]string)
+		t.preloads[model.Name] = model.Preloads()
+		if model.FindAnnotation("repository") != nil {
+			t.repos[model.Name] = true
+		}
+		if model.Policy != nil {
+			t.policies[model.Name] = model.Policy
+		}
+		for _, field := range model.Fields {
+			t.fields[model.Name][field.Name] = true
+			if field.Type == "enum" {
+				// Named as the generator names the type: Task.status → TaskStatus
+				t.enums[model.Name][field.Name] = model.Name + utils.ToPascalCase(field.Name)
+			}
+			if related := strings.TrimSuffix(field.Type, "[]"); known[related] {
+				t.relations[model.Name][field.Name] = related
+			}
+			for _, ann := range field.Annotations {
+				if ann.Name == "pk" {
+					t.pkFields[model.Name] = utils.ToPascalCase(field.Name)
+					t.pkColumns[model.Name] = utils.ToSnakeCase(field.Name)
+					if model.FindAnnotation("live") != nil {
+						t.live[model.Name] = `""`
+						if field.Type == "int" {
+							t.live[model.Name] = "0"
+						}
+					}
+				}
+			}
+		}
+	}
+	return t.transpileScript(script)
+}
+
+// transpileScript emits the helpers and the functions of a script block
+func (t *Transpiler) transpileScript(script *ast.ScriptBlock) *TranspileResult {
 	result := &TranspileResult{
 		SourceMap: t.sourceMap,
 		Errors:    []string{},
 	}
 
+	t.queued = make(map[string]*ast.FuncDecl)
+	t.publishes = true
+	t.funcs = make(map[string]bool)
+	for _, fn := range script.Funcs {
+		t.funcs[fn.Name] = true
+		if fn.Name == "publish" {
+			t.publishes = false
+		}
+		if fn.FindAnnotation("async") != nil {
+			t.jobs = true
+		}
+		if fn.FindAnnotation("job") != nil {
+			t.queued[fn.Name] = fn
+		}
+	}
+
 	// Generate ORM helpers first
 	t.genORMHelpers()
 
@@ -68,17 +181,36 @@ func Transpile(script *ast.ScriptBlock, modelNames []string) *TranspileResult {
 	for _, fn := range script.Funcs {
 		t.TranspileFunc(fn)
 		t.emit("\n")
+		if t.queued[fn.Name] != nil {
+			t.genEnqueueHelper(fn)
+			t.emit("\n")
+		}
 	}
 
 	result.GoCode = t.buf.String()
+	result.Errors = append(result.Errors, t.errors...)
 	return result
 }
 
+// TranspileFunction converts one function without the ORM helpers and context
+// types, so its Go code and source map can be inspected in isolation
+func TranspileFunction(fn *ast.FuncDecl, modelNames []string) *TranspileResult {
+	t := NewTranspiler(modelNames)
+	t.TranspileFunc(fn)
+	return &TranspileResult{
+		GoCode:    t.buf.String(),
+		SourceMap: t.sourceMap,
+		Errors:    append([]string{}, t.errors...),
+	}
+}
+
 // TranspileFunc converts a single FuncDecl to Go code
 func (t *Transpiler) TranspileFunc(fn *ast.FuncDecl) string {
 	t.currentFunc = fn.Name
-	t.errDeclared = false
 	t.varTypes = make(map[string]string) // reset for new function
+	t.negotiate = fn.FindAnnotation("negotiate") != nil
+	t.jsonAPI = fn.FindAnnotation("json") != nil
+	t.async = fn.FindAnnotation("async") != nil
 
 	// Generate function signature
 	// GMX: func toggleTask(id: uuid) error
@@ -101,9 +233,23 @@ func (t *Transpiler) TranspileFunc(fn *ast.FuncDecl) string {
 		returnType = "error"
 	}
 	goReturnType := t.transpileType(returnType)
-	t.emit(") %s {\n", goReturnType)
+	t.returnType = goReturnType
+	if len(fn.After) > 0 {
+		// The after hooks run from a defer, which needs the named result
+		t.emit(") (err error) {\n")
+	} else {
+		t.emit(") %s {\n", goReturnType)
+	}
 	t.indent++
 
+	// before hooks run first and may abort the handler by returning an error
+	for _, stmt := range fn.Before {
+		t.transpileStmt(stmt)
+	}
+	if len(fn.After) > 0 {
+		t.transpileAfterHooks(fn.After)
+	}
+
 	// Transpile function body
 	for _, stmt := range fn.Body {
 		t.transpileStmt(stmt)
@@ -112,6 +258,10 @@ func (t *Transpiler) TranspileFunc(fn *ast.FuncDecl) string {
 	// Ensure function returns (in case no explicit return)
 	if !t.endsWithReturn(fn.Body) {
 		t.emitIndent()
+		if t.jsonAPI {
+			t.emit("ctx.Writer.WriteHeader(http.StatusNoContent)\n")
+			t.emitIndent()
+		}
 		if goReturnType == "error" {
 			t.emit("return nil\n")
 		} else {
@@ -127,6 +277,66 @@ func (t *Transpiler) TranspileFunc(fn *ast.FuncDecl) string {
 	return t.buf.String()
 }
 
+// transpileAfterHooks defers the after hook statements: they run once the
+// body returned without error, and an error they return becomes the result
+func (t *Transpiler) transpileAfterHooks(stmts []ast.Statement) {
+	t.emitIndent()
+	t.emit("defer func() {\n")
+	t.indent++
+	t.emitIndent()
+	t.emit("if err != nil {\n")
+	t.emitIndent()
+	t.emit("\treturn\n")
+	t.emitIndent()
+	t.emit("}\n")
+	t.emitIndent()
+	t.emit("err = func() error {\n")
+	t.indent++
+	for _, stmt := range stmts {
+		t.transpileStmt(stmt)
+	}
+	if !t.endsWithReturn(stmts) {
+		t.emitIndent()
+		t.emit("return nil\n")
+	}
+	t.indent--
+	t.emitIndent()
+	t.emit("}()\n")
+	t.indent--
+	t.emitIndent()
+	t.emit("}()\n")
+}
+
+// TranspileServiceMethod converts the body of a service method to Go statements.
+// Inside the body, `config` refers to the service configuration (configExpr);
+// goReturnType is the already-mapped Go return type, empty for void methods.
+// Service methods run outside requests: ctx and model helpers are unavailable
+func TranspileServiceMethod(method *ast.ServiceMethod, goReturnType, configExpr string) string {
+	t := NewTranspiler(nil)
+	t.currentFunc = method.Name
+	t.configExpr = configExpr
+	t.voidFunc = goReturnType == ""
+	t.returnType = goReturnType
+	t.indent = 1
+
+	for _, stmt := range method.Body {
+		t.transpileStmt(stmt)
+	}
+
+	if !t.voidFunc && !t.endsWithReturn(method.Body) {
+		t.emitIndent()
+		if goReturnType == "error" {
+			t.emit("return nil\n")
+		} else {
+			t.emit("var zero %s\n", goReturnType)
+			t.emitIndent()
+			t.emit("return zero\n")
+		}
+	}
+
+	return t.buf.String()
+}
+
 func (t *Transpiler) transpileStmt(stmt ast.Statement) {
 	switch s := stmt.(type) {
 	case *ast.LetStmt:
@@ -139,6 +349,12 @@ func (t *Transpiler) transpileStmt(stmt ast.Statement) {
 		t.transpileReturnStmt(s)
 	case *ast.IfStmt:
 		t.transpileIfStmt(s)
+	case *ast.ForStmt:
+		t.transpileForStmt(s)
+	case *ast.SagaStmt:
+		t.transpileSagaStmt(s)
+	case *ast.EnqueueStmt:
+		t.transpileEnqueueStmt(s)
 	case *ast.ExprStmt:
 		t.transpileExprStmt(s)
 	case *ast.AssignStmt:
@@ -150,23 +366,23 @@ func (t *Transpiler) transpileStmt(stmt ast.Statement) {
 }
 
 func (t *Transpiler) transpileLetStmt(stmt *ast.LetStmt) {
+	if remember := rememberValue(stmt.Value); remember != nil {
+		t.transpileRememberLet(stmt, remember)
+		return
+	}
 	t.emitIndent()
 	t.emitLineComment(stmt.Line)
 
 	// Check if value is a try expression
 	if tryExpr, ok := stmt.Value.(*ast.TryExpr); ok {
 		// let x = try expr -> x, err := expr; if err != nil { return err }
-		if t.errDeclared {
-			t.emit("%s, err = %s\n", stmt.Name, t.transpileExpr(tryExpr.Expr))
-		} else {
-			t.emit("%s, err := %s\n", stmt.Name, t.transpileExpr(tryExpr.Expr))
-			t.errDeclared = true
-		}
+		// x is always new, so := also reuses an err declared by an earlier let
+		t.emit("%s, err := %s\n", stmt.Name, t.transpileExpr(tryExpr.Expr))
 		t.emitIndent()
 		t.emit("if err != nil {\n")
 		t.indent++
 		t.emitIndent()
-		t.emit("return err\n")
+		t.emit("%s\n", t.errReturn())
 		t.indent--
 		t.emitIndent()
 		t.emit("}\n")
@@ -181,6 +397,10 @@ func (t *Transpiler) transpileLetStmt(stmt *ast.LetStmt) {
 }
 
 func (t *Transpiler) transpileConstStmt(stmt *ast.LetStmt) {
+	if remember := rememberValue(stmt.Value); remember != nil {
+		t.transpileRememberLet(stmt, remember)
+		return
+	}
 	t.emitIndent()
 	t.emitLineComment(stmt.Line)
 	t.emit("%s := %s\n", stmt.Name, t.transpileExpr(stmt.Value))
@@ -191,13 +411,41 @@ func (t *Transpiler) transpileReturnStmt(stmt *ast.ReturnStmt) {
 	t.emitIndent()
 	t.emitLineComment(stmt.Line)
 
-	if stmt.Value == nil {
+	if t.rememberType != "" {
+		t.transpileRememberReturn(stmt)
+		return
+	}
+
+	// Saga steps run in a closure: only an error may leave them
+	if t.sagaDepth > 0 && (stmt.Value == nil || isRender(stmt.Value)) {
+		t.errors = append(t.errors, fmt.Sprintf("line %d: a saga step can only return an error; render after the saga", stmt.Line))
+		return
+	}
+
+	// @json functions answer 204 No Content when they render nothing
+	if t.jsonAPI && t.sagaDepth == 0 && (stmt.Value == nil || isNilValue(stmt.Value)) {
+		t.emit("ctx.Writer.WriteHeader(http.StatusNoContent)\n")
+		t.emitIndent()
 		t.emit("return nil\n")
 		return
 	}
 
+	if stmt.Value == nil {
+		if t.voidFunc {
+			t.emit("return\n")
+		} else {
+			t.emit("return nil\n")
+		}
+		return
+	}
+
 	// Check for render() expression
 	if renderExpr, ok := stmt.Value.(*ast.RenderExpr); ok {
+		if t.jsonAPI {
+			t.transpileJSONRender(renderExpr.Args)
+			return
+		}
+		t.transpileNegotiation(renderExpr.Args)
 		t.transpileRenderExpr(renderExpr)
 		t.emit("return nil\n")
 		return
@@ -206,6 +454,11 @@ func (t *Transpiler) transpileReturnStmt(stmt *ast.ReturnStmt) {
 	// Check for render() call (legacy - for backward compatibility)
 	if call, ok := stmt.Value.(*ast.CallExpr); ok {
 		if ident, ok := call.Function.(*ast.Ident); ok && ident.Name == "render" {
+			if t.jsonAPI {
+				t.transpileJSONRender(call.Args)
+				return
+			}
+			t.transpileNegotiation(call.Args)
 			t.transpileRenderCall(call)
 			t.emit("return nil\n")
 			return
@@ -215,6 +468,42 @@ func (t *Transpiler) transpileReturnStmt(stmt *ast.ReturnStmt) {
 	t.emit("return %s\n", t.transpileExpr(stmt.Value))
 }
 
+// transpileNegotiation answers the rendered data as JSON in @negotiate
+// functions when the client prefers it; OOB fragments have no JSON form,
+// so only the first rendered value is encoded
+func (t *Transpiler) transpileNegotiation(args []ast.Expression) {
+	if !t.negotiate || len(args) == 0 {
+		return
+	}
+	t.emitIndent()
+	t.emit("if negotiateJSON(ctx.Writer, ctx.Request) {\n")
+	t.indent++
+	t.emitIndent()
+	t.emit("return writeJSON(ctx.Writer, %s)\n", t.transpileExpr(args[0]))
+	t.indent--
+	t.emitIndent()
+	t.emit("}\n")
+}
+
+// transpileJSONRender answers the rendered data as JSON in @json functions,
+// which render no fragment: only the first value is encoded, and render()
+// without one answers 204 No Content
+func (t *Transpiler) transpileJSONRender(args []ast.Expression) {
+	if len(args) == 0 {
+		t.emit("ctx.Writer.WriteHeader(http.StatusNoContent)\n")
+		t.emitIndent()
+		t.emit("return nil\n")
+		return
+	}
+	t.emit("return writeJSON(ctx.Writer, %s)\n", t.transpileExpr(args[0]))
+}
+
+// isNilValue reports whether a returned value is nil
+func isNilValue(expr ast.Expression) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && ident.Name == "nil"
+}
+
 func (t *Transpiler) transpileIfStmt(stmt *ast.IfStmt) {
 	t.emitIndent()
 	t.emitLineComment(stmt.Line)
@@ -239,6 +528,47 @@ func (t *Transpiler) transpileIfStmt(stmt *ast.IfStmt) {
 	t.emit("}\n")
 }
 
+// transpileForStmt converts a for loop to a Go range loop. Over a collection
+// of models, the loop variable points into the collection, so that the ORM
+// helpers receive the record and changes stay visible after the loop:
+// for task in tasks → for taskIndex := range tasks { task := &tasks[taskIndex]
+func (t *Transpiler) transpileForStmt(stmt *ast.ForStmt) {
+	t.emitIndent()
+	t.emitLineComment(stmt.Line)
+
+	// try returns the error next to the collection: the loop needs the collection alone
+	if _, ok := stmt.Iterable.(*ast.TryExpr); ok {
+		t.errors = append(t.errors, fmt.Sprintf("line %d: for cannot iterate over a try expression; assign the collection with let first", stmt.Line))
+		return
+	}
+
+	iterable := t.transpileExpr(stmt.Iterable)
+	index := stmt.Index
+	if ident, ok := stmt.Iterable.(*ast.Ident); ok && t.isCollectionVar(ident) && t.isModelType(strings.TrimPrefix(t.varTypes[ident.Name], "[]")) {
+		if index == "" {
+			index = stmt.Value + "Index"
+		}
+		t.emit("for %s := range %s {\n", index, iterable)
+		t.indent++
+		t.emitIndent()
+		t.emit("%s := &%s[%s]\n", stmt.Value, iterable, index)
+		t.varTypes[stmt.Value] = strings.TrimPrefix(t.varTypes[ident.Name], "[]")
+	} else {
+		if index == "" {
+			index = "_"
+		}
+		t.emit("for %s, %s := range %s {\n", index, stmt.Value, iterable)
+		t.indent++
+	}
+
+	for _, s := range stmt.Body {
+		t.transpileStmt(s)
+	}
+	t.indent--
+	t.emitIndent()
+	t.emit("}\n")
+}
+
 func (t *Transpiler) transpileExprStmt(stmt *ast.ExprStmt) {
 	t.emitIndent()
 	t.emitLineComment(stmt.Line)
@@ -249,11 +579,10 @@ func (t *Transpiler) transpileExprStmt(stmt *ast.ExprStmt) {
 		t.emit("if err := %s; err != nil {\n", t.transpileExpr(tryExpr.Expr))
 		t.indent++
 		t.emitIndent()
-		t.emit("return err\n")
+		t.emit("%s\n", t.errReturn())
 		t.indent--
 		t.emitIndent()
 		t.emit("}\n")
-		t.errDeclared = true
 	} else {
 		t.emit("%s\n", t.transpileExpr(stmt.Expr))
 	}
@@ -262,17 +591,55 @@ func (t *Transpiler) transpileExprStmt(stmt *ast.ExprStmt) {
 func (t *Transpiler) transpileAssignStmt(stmt *ast.AssignStmt) {
 	t.emitIndent()
 	t.emitLineComment(stmt.Line)
-	t.emit("%s = %s\n", t.transpileExpr(stmt.Target), t.transpileExpr(stmt.Value))
+	// Writing a json key goes through JSON.Set, which allocates the map of a new record
+	if index, ok := stmt.Target.(*ast.IndexExpr); ok {
+		t.emit("%s.Set(%s, %s)\n", t.transpileExpr(index.Object), t.transpileExpr(index.Index), t.transpileExpr(stmt.Value))
+		return
+	}
+	t.emit("%s = %s\n", t.transpileExpr(stmt.Target), t.toEnum(t.enumType(stmt.Target), stmt.Value))
+}
+
+// enumType returns the Go type of the enum field an expression reads, such
+// as task.status, or ""
+func (t *Transpiler) enumType(expr ast.Expression) string {
+	member, ok := expr.(*ast.MemberExpr)
+	if !ok {
+		return ""
+	}
+	ident, ok := member.Object.(*ast.Ident)
+	if !ok {
+		return ""
+	}
+	return t.enums[t.varTypes[ident.Name]][member.Property]
+}
+
+// toEnum transpiles a value stored in or compared with an enum field,
+// converting strings to its type; literals convert implicitly
+func (t *Transpiler) toEnum(enum string, value ast.Expression) string {
+	code := t.transpileExpr(value)
+	if lit, ok := value.(*ast.StringLit); enum == "" || (ok && len(lit.Parts) == 0) {
+		return code
+	}
+	return enum + "(" + code + ")"
 }
 
 func (t *Transpiler) transpileExpr(expr ast.Expression) string {
 	switch e := expr.(type) {
 	case *ast.Ident:
+		if e.Name == "config" && t.configExpr != "" {
+			return t.configExpr
+		}
+		// job.progress(40) reports the progress of an @async function
+		if e.Name == "job" && t.async {
+			return "ctx.Job"
+		}
 		return e.Name
 	case *ast.IntLit:
 		return e.Value
 	case *ast.FloatLit:
 		return e.Value
+	case *ast.DurationLit:
+		return transpileDuration(e.Value)
 	case *ast.StringLit:
 		// Check for string interpolation
 		if len(e.Parts) > 0 {
@@ -282,6 +649,17 @@ func (t *Transpiler) transpileExpr(expr ast.Expression) string {
 	case *ast.BoolLit:
 		return fmt.Sprintf("%t", e.Value)
 	case *ast.BinaryExpr:
+		if e.Op == "contains" {
+			return fmt.Sprintf("%s.Contains(%s)", t.transpileExpr(e.Left), t.transpileExpr(e.Right))
+		}
+		if e.Op == "==" || e.Op == "!=" {
+			if enum := t.enumType(e.Left); enum != "" {
+				return fmt.Sprintf("%s %s %s", t.transpileExpr(e.Left), e.Op, t.toEnum(enum, e.Right))
+			}
+			if enum := t.enumType(e.Right); enum != "" {
+				return fmt.Sprintf("%s %s %s", t.toEnum(enum, e.Left), e.Op, t.transpileExpr(e.Right))
+			}
+		}
 		return fmt.Sprintf("%s %s %s", t.transpileExpr(e.Left), e.Op, t.transpileExpr(e.Right))
 	case *ast.UnaryExpr:
 		return fmt.Sprintf("%s%s", e.Op, t.transpileExpr(e.Operand))
@@ -289,15 +667,23 @@ func (t *Transpiler) transpileExpr(expr ast.Expression) string {
 		return t.transpileCallExpr(e)
 	case *ast.MemberExpr:
 		return t.transpileMemberExpr(e)
+	case *ast.IndexExpr:
+		return fmt.Sprintf("%s[%s]", t.transpileExpr(e.Object), t.transpileExpr(e.Index))
 	case *ast.StructLit:
 		return t.transpileStructLiteral(e)
 	case *ast.TryExpr:
 		// Bare try expression (not in let/const)
 		return t.transpileExpr(e.Expr)
+	case *ast.RememberExpr:
+		t.errors = append(t.errors, fmt.Sprintf("line %d: %s.remember blocks are assigned with let", e.Line, e.Cache))
+		return "nil"
 	case *ast.ErrorExpr:
 		return t.transpileErrorExpr(e)
 	case *ast.CtxExpr:
 		return fmt.Sprintf("ctx.%s", utils.Capitalize(e.Field))
+	case *ast.NamedArg:
+		t.errors = append(t.errors, fmt.Sprintf("line %d: named argument %s: only Model.where() and log calls take named arguments", e.Line, e.Name))
+		return t.transpileExpr(e.Value)
 	case *ast.RenderExpr:
 		// render() as expression (shouldn't happen, but handle it)
 		return "nil /* render() should be used in return statement */"
@@ -311,10 +697,42 @@ func (t *Transpiler) transpileCallExpr(expr *ast.CallExpr) string {
 	if ident, ok := expr.Function.(*ast.Ident); ok && ident.Name == "error" {
 		if len(expr.Args) == 1 {
 			if strLit, ok := expr.Args[0].(*ast.StringLit); ok {
-				return fmt.Sprintf("fmt.Errorf(%q)", strLit.Value)
+				return fmt.Sprintf("&ValidationError{Message: %q}", strLit.Value)
 			}
-			return fmt.Sprintf("fmt.Errorf(\"%%v\", %s)", t.transpileExpr(expr.Args[0]))
+			return fmt.Sprintf("&ValidationError{Message: fmt.Sprint(%s)}", t.transpileExpr(expr.Args[0]))
+		}
+	}
+
+	// role(admin) in policy rules: the session user is one of the admins
+	if role, ok := roleCall(expr); ok && t.inPolicy {
+		if role != "admin" {
+			t.errors = append(t.errors, fmt.Sprintf("line %d: unsupported role %q (expected admin)", expr.Line, role))
 		}
+		return "sessionAdmins[ctx.User]"
+	}
+
+	// publish("task.created", task): recorded in the outbox of the context
+	if ident, ok := expr.Function.(*ast.Ident); ok && ident.Name == "publish" && t.publishes {
+		args := []string{"ctx"}
+		for _, arg := range expr.Args {
+			args = append(args, t.transpileExpr(arg))
+		}
+		return fmt.Sprintf("publish(%s)", strings.Join(args, ", "))
+	}
+
+	// log.info("created task", task.id): the logger of the context
+	if method, ok := t.logCall(expr); ok {
+		return t.transpileLogCall(expr, method)
+	}
+
+	// Service methods: Mailer.send(to, subject, body)
+	if svc, method := t.serviceCall(expr); svc != nil {
+		return t.transpileServiceCall(svc, method, expr)
+	}
+
+	// Model queries: Task.where(done: false).order(createdAt, desc).first()
+	if model, calls, ok := t.modelQuery(expr); ok && (len(calls) > 1 || queryMethod(expr) != "all" || len(expr.Args) > 0) {
+		return t.transpileQuery(model, calls)
 	}
 
 	// Check for Model.find(), Model.all() static methods
@@ -325,6 +743,17 @@ func (t *Transpiler) transpileCallExpr(expr *ast.CallExpr) string {
 
 			if t.isModelType(modelName) {
 				// Static model method
+				// Models with a policy are reached through the helpers checking it
+				if t.policies[modelName] != nil {
+					switch methodName {
+					case "find":
+						if len(expr.Args) == 1 {
+							return fmt.Sprintf("%sFindAuthorized(ctx, %s)", modelName, t.transpileExpr(expr.Args[0]))
+						}
+					case "all":
+						return fmt.Sprintf("%sAllAuthorized(ctx)", modelName)
+					}
+				}
 				switch methodName {
 				case "find":
 					if len(expr.Args) == 1 {
@@ -336,6 +765,14 @@ func (t *Transpiler) transpileCallExpr(expr *ast.CallExpr) string {
 			} else {
 				// Instance method - check if variable is a model instance
 				if varType, ok := t.varTypes[modelName]; ok && t.isModelType(varType) {
+					if t.policies[varType] != nil {
+						switch methodName {
+						case "save":
+							return fmt.Sprintf("%sSaveAuthorized(ctx, %s)", varType, modelName)
+						case "delete":
+							return fmt.Sprintf("%sDeleteAuthorized(ctx, %s)", varType, modelName)
+						}
+					}
 					switch methodName {
 					case "save":
 						return fmt.Sprintf("%sSave(ctx.DB, %s)", varType, modelName)
@@ -347,14 +784,26 @@ func (t *Transpiler) transpileCallExpr(expr *ast.CallExpr) string {
 		}
 	}
 
-	// Regular function call
+	// Regular function call; script functions, notify or publish overrides
+	// included, take the context first
 	var args []string
+	if ident, ok := expr.Function.(*ast.Ident); ok && t.funcs[ident.Name] {
+		args = append(args, "ctx")
+	}
 	for _, arg := range expr.Args {
 		args = append(args, t.transpileExpr(arg))
 	}
 	return fmt.Sprintf("%s(%s)", t.transpileExpr(expr.Function), strings.Join(args, ", "))
 }
 
+// splitConditions flattens a && b && c into its conditions
+func splitConditions(expr ast.Expression) []ast.Expression {
+	if bin, ok := expr.(*ast.BinaryExpr); ok && bin.Op == "&&" {
+		return append(splitConditions(bin.Left), splitConditions(bin.Right)...)
+	}
+	return []ast.Expression{expr}
+}
+
 func (t *Transpiler) transpileMemberExpr(expr *ast.MemberExpr) string {
 	// Check for ctx.tenant, ctx.user
 	if ident, ok := expr.Object.(*ast.Ident); ok && ident.Name == "ctx" {
@@ -376,7 +825,7 @@ func (t *Transpiler) transpileStructLiteral(expr *ast.StructLit) string {
 	var fields []string
 	for key, value := range expr.Fields {
 		fieldName := utils.ToPascalCase(key)
-		fieldValue := t.transpileExpr(value)
+		fieldValue := t.toEnum(t.enums[expr.TypeName][key], value)
 		fields = append(fields, fmt.Sprintf("%s: %s", fieldName, fieldValue))
 	}
 	// If it's a model type, create as pointer for consistency with ORM helpers
@@ -386,6 +835,15 @@ func (t *Transpiler) transpileStructLiteral(expr *ast.StructLit) string {
 	return fmt.Sprintf("%s{%s}", expr.TypeName, strings.Join(fields, ", "))
 }
 
+// durationUnitNames maps duration literal units to their time constant
+var durationUnitNames = map[string]string{"ms": "Millisecond", "s": "Second", "m": "Minute", "h": "Hour"}
+
+// transpileDuration converts a duration literal: 24h → 24 * time.Hour
+func transpileDuration(lit string) string {
+	n := strings.TrimRight(lit, "hms")
+	return fmt.Sprintf("%s * time.%s", n, durationUnitNames[lit[len(n):]])
+}
+
 func (t *Transpiler) transpileStringInterpolationParts(parts []ast.StringPart) string {
 	// Convert StringParts to fmt.Sprintf
 	var formatParts []string
@@ -464,9 +922,15 @@ func (t *Transpiler) transpileType(typ string) string {
 		return "string"
 	case "int":
 		return "int"
+	case "money":
+		return "Money"
+	case "string[]":
+		return "StringList"
+	case "bytes":
+		return "Blob"
 	case "bool":
 		return "bool"
-	case "string":
+	case "string", "password":
 		return "string"
 	default:
 		// Might be a model type
@@ -490,16 +954,16 @@ func (t *Transpiler) trackVarType(varName string, expr ast.Expression) {
 	// Track variable type for instance method detection
 	switch e := expr.(type) {
 	case *ast.CallExpr:
-		// Check if it's Model.find() or Model.all()
-		if member, ok := e.Function.(*ast.MemberExpr); ok {
-			if ident, ok := member.Object.(*ast.Ident); ok {
-				if t.isModelType(ident.Name) {
-					if member.Property == "all" {
-						t.varTypes[varName] = "[]" + ident.Name
-					} else {
-						t.varTypes[varName] = ident.Name
-					}
-				}
+		// Model queries return records, ending with first() one record; Model.find() one record
+		if model, calls, ok := t.modelQuery(e); ok {
+			if queryMethod(calls[len(calls)-1]) == "first" {
+				t.varTypes[varName] = model
+			} else {
+				t.varTypes[varName] = "[]" + model
+			}
+		} else if member, ok := e.Function.(*ast.MemberExpr); ok {
+			if ident, ok := member.Object.(*ast.Ident); ok && t.isModelType(ident.Name) {
+				t.varTypes[varName] = ident.Name
 			}
 		}
 	case *ast.StructLit:
@@ -564,11 +1028,24 @@ func (t *Transpiler) emitLineComment(gmxLine int) {
 func (t *Transpiler) genORMHelpers() {
 	t.emit("// ORM helper functions\n\n")
 
+	if len(t.policies) > 0 {
+		t.emit("// errForbidden is returned by the ORM helpers when a policy denies an action\n")
+		t.emit("var errForbidden = errors.New(\"forbidden by policy\")\n\n")
+	}
+	t.genQueryScopes()
+
 	for _, model := range t.models {
+		t.genFirstHelper(model)
+		if t.repos[model] {
+			t.genRepositoryHelpers(model)
+			continue
+		}
+
 		// Find helper
+		db := t.preloadDB(model)
 		t.emit("func %sFind(db *gorm.DB, id string) (*%s, error) {\n", model, model)
 		t.emit("\tvar obj %s\n", model)
-		t.emit("\tif err := db.First(&obj, \"id = ?\", id).Error; err != nil {\n")
+		t.emit("\tif err := %s.First(&obj, \"id = ?\", id).Error; err != nil {\n", db)
 		t.emit("\t\treturn nil, err\n")
 		t.emit("\t}\n")
 		t.emit("\treturn &obj, nil\n")
@@ -577,24 +1054,65 @@ func (t *Transpiler) genORMHelpers() {
 		// All helper
 		t.emit("func %sAll(db *gorm.DB) ([]%s, error) {\n", model, model)
 		t.emit("\tvar objs []%s\n", model)
-		t.emit("\tif err := db.Find(&objs).Error; err != nil {\n")
+		t.emit("\tif err := %s.Find(&objs).Error; err != nil {\n", db)
 		t.emit("\t\treturn nil, err\n")
 		t.emit("\t}\n")
 		t.emit("\treturn objs, nil\n")
 		t.emit("}\n\n")
 
-		// Save helper
-		t.emit("func %sSave(db *gorm.DB, obj *%s) error {\n", model, model)
-		t.emit("\treturn db.Save(obj).Error\n")
+		// Where helper
+		t.emit("func %sWhere(db *gorm.DB, scopes ...func(*gorm.DB) *gorm.DB) ([]%s, error) {\n", model, model)
+		t.emit("\tvar objs []%s\n", model)
+		t.emit("\tif err := %s.Scopes(scopes...).Find(&objs).Error; err != nil {\n", db)
+		t.emit("\t\treturn nil, err\n")
+		t.emit("\t}\n")
+		t.emit("\treturn objs, nil\n")
 		t.emit("}\n\n")
 
-		// Delete helper
-		t.emit("func %sDelete(db *gorm.DB, obj *%s) error {\n", model, model)
-		t.emit("\treturn db.Delete(obj).Error\n")
-		t.emit("}\n\n")
+		// Save and Delete helpers
+		t.genSaveHelper(model, "db.Save(obj).Error")
+		t.genDeleteHelper(model, "db.Delete(obj).Error")
+	}
+
+	for _, model := range t.models {
+		if policy := t.policies[model]; policy != nil {
+			t.genPolicyHelpers(model, policy)
+		}
 	}
 }
 
+// genRepositoryHelpers emits the ORM helpers of a model stored by a
+// @repository, forwarding each call to the repository value
+func (t *Transpiler) genRepositoryHelpers(model string) {
+	repo := utils.LowerFirst(model) + "Repository"
+	db := t.preloadDB(model)
+
+	t.emit("func %sFind(db *gorm.DB, id string) (*%s, error) {\n", model, model)
+	t.emit("\treturn %s.Find(%s, id)\n", repo, db)
+	t.emit("}\n\n")
+
+	t.emit("func %sAll(db *gorm.DB) ([]%s, error) {\n", model, model)
+	t.emit("\treturn %s.All(%s)\n", repo, db)
+	t.emit("}\n\n")
+
+	t.emit("func %sWhere(db *gorm.DB, scopes ...func(*gorm.DB) *gorm.DB) ([]%s, error) {\n", model, model)
+	t.emit("\treturn %s.Where(%s, scopes...)\n", repo, db)
+	t.emit("}\n\n")
+
+	t.genSaveHelper(model, repo+".Save(db, obj)")
+	t.genDeleteHelper(model, repo+".Delete(db, obj)")
+}
+
+// preloadDB returns the connection the ORM helpers of a model load its
+// records with: db, preloading the relations of its @preload
+func (t *Transpiler) preloadDB(model string) string {
+	db := "db"
+	for _, relation := range t.preloads[model] {
+		db += fmt.Sprintf(".Preload(%q)", utils.ToPascalCase(relation))
+	}
+	return db
+}
+
 func (t *Transpiler) genGMXContext() {
 	t.emit("// GMXContext holds request context and dependencies\n")
 	t.emit("type GMXContext struct {\n")
@@ -603,6 +1121,15 @@ func (t *Transpiler) genGMXContext() {
 	t.emit("\tUser    string\n")
 	t.emit("\tWriter  http.ResponseWriter\n")
 	t.emit("\tRequest *http.Request\n")
+	t.emit("\tLog     *slog.Logger // carries the request ID; log.info(...) in scripts\n")
+	if t.jobs {
+		t.emit("\tJob     *Job // background job of an @async function\n")
+	}
+	t.emit("}\n\n")
+	t.emit("// Cancelled reports whether the client went away or the @timeout deadline\n")
+	t.emit("// passed: ctx.cancelled() lets long-running work stop early\n")
+	t.emit("func (ctx *GMXContext) Cancelled() bool {\n")
+	t.emit("\treturn ctx.Request.Context().Err() != nil\n")
 	t.emit("}\n\n")
 }
 
@@ -615,9 +1142,9 @@ func (t *Transpiler) genRenderFragment() {
 }
 
 func (t *Transpiler) transpileErrorExpr(expr *ast.ErrorExpr) string {
-	// error("message") -> fmt.Errorf("message")
+	// error("message") -> &ValidationError{Message: "message"}, shown to the user
 	msgStr := t.transpileExpr(expr.Message)
-	return fmt.Sprintf("fmt.Errorf(%s)", msgStr)
+	return fmt.Sprintf("&ValidationError{Message: %s}", msgStr)
 }
 
 func (t *Transpiler) transpileRenderExpr(expr *ast.RenderExpr) {
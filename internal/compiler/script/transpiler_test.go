@@ -1,6 +1,7 @@
 package script
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 
@@ -188,6 +189,49 @@ func TestTranspileModelAll(t *testing.T) {
 	}
 }
 
+func TestTranspileModelWhere(t *testing.T) {
+	parsed, errs := Parse(`func urgent(tag: string) error {
+		let tasks = try Task.where(tags contains tag && tags contains "urgent")
+		if tasks[0].tags contains "home" {
+			return error("home")
+		}
+		return render(tasks)
+	}`, 0)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+
+	result := Transpile(&ast.ScriptBlock{Funcs: parsed.Funcs}, []string{"Task"})
+	if len(result.Errors) > 0 {
+		t.Fatalf("transpile errors: %v", result.Errors)
+	}
+	expected := []string{
+		"func TaskWhere(db *gorm.DB, scopes ...func(*gorm.DB) *gorm.DB) ([]Task, error)",
+		`tasks, err := TaskWhere(ctx.DB, listContains("tags", tag), listContains("tags", "urgent"))`,
+		`if tasks[0].Tags.Contains("home") {`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(result.GoCode, exp) {
+			t.Errorf("Expected %q, got: %s", exp, result.GoCode)
+		}
+	}
+}
+
+func TestTranspileModelWhereUnsupportedCondition(t *testing.T) {
+	parsed, errs := Parse(`func open() error {
+		let tasks = try Task.where(done == false)
+		return render(tasks)
+	}`, 0)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+
+	result := Transpile(&ast.ScriptBlock{Funcs: parsed.Funcs}, []string{"Task"})
+	if len(result.Errors) != 1 || !strings.Contains(result.Errors[0], "field contains value") {
+		t.Errorf("expected an unsupported condition error, got %v", result.Errors)
+	}
+}
+
 func TestTranspileModelSave(t *testing.T) {
 	script := &ast.ScriptBlock{
 		Funcs: []*ast.FuncDecl{
@@ -332,8 +376,8 @@ func TestTranspileError(t *testing.T) {
 	}
 
 	result := Transpile(script, []string{})
-	if !strings.Contains(result.GoCode, `fmt.Errorf("not found")`) {
-		t.Errorf("Expected 'fmt.Errorf(\"not found\")', got: %s", result.GoCode)
+	if !strings.Contains(result.GoCode, `&ValidationError{Message: "not found"}`) {
+		t.Errorf("Expected '&ValidationError{Message: \"not found\"}', got: %s", result.GoCode)
 	}
 }
 
@@ -512,6 +556,31 @@ func TestTranspileAssignment(t *testing.T) {
 	}
 }
 
+func TestTranspileIndexExpr(t *testing.T) {
+	meta := &ast.MemberExpr{Object: &ast.Ident{Name: "task"}, Property: "meta"}
+	script := &ast.ScriptBlock{
+		Funcs: []*ast.FuncDecl{
+			{
+				Name:   "test",
+				Params: []*ast.Param{{Name: "task", Type: "Task"}},
+				Body: []ast.Statement{
+					&ast.AssignStmt{
+						Target: &ast.IndexExpr{Object: meta, Index: &ast.StringLit{Value: "color"}},
+						Value:  &ast.IndexExpr{Object: meta, Index: &ast.StringLit{Value: "fallback"}},
+						Line:   1,
+					},
+				},
+				Line: 1,
+			},
+		},
+	}
+
+	result := Transpile(script, []string{"Task"})
+	if !strings.Contains(result.GoCode, `task.Meta.Set("color", task.Meta["fallback"])`) {
+		t.Errorf("Expected key write through JSON.Set, got: %s", result.GoCode)
+	}
+}
+
 func TestTranspileStructLiteral(t *testing.T) {
 	script := &ast.ScriptBlock{
 		Funcs: []*ast.FuncDecl{
@@ -889,8 +958,8 @@ func TestTranspileErrorExpr(t *testing.T) {
 	}
 
 	result := Transpile(script, []string{})
-	if !strings.Contains(result.GoCode, `fmt.Errorf("not found")`) {
-		t.Errorf("Expected 'fmt.Errorf(\"not found\")', got: %s", result.GoCode)
+	if !strings.Contains(result.GoCode, `&ValidationError{Message: "not found"}`) {
+		t.Errorf("Expected '&ValidationError{Message: \"not found\"}', got: %s", result.GoCode)
 	}
 }
 
@@ -921,8 +990,8 @@ func TestTranspileErrorExprInLet(t *testing.T) {
 	}
 
 	result := Transpile(script, []string{})
-	if !strings.Contains(result.GoCode, `fmt.Errorf("validation failed")`) {
-		t.Errorf("Expected 'fmt.Errorf(\"validation failed\")', got: %s", result.GoCode)
+	if !strings.Contains(result.GoCode, `&ValidationError{Message: "validation failed"}`) {
+		t.Errorf("Expected '&ValidationError{Message: \"validation failed\"}', got: %s", result.GoCode)
 	}
 }
 
@@ -1489,9 +1558,9 @@ func TestTranspileCallExprErrorWithExpr(t *testing.T) {
 	}
 
 	result := Transpile(script, []string{})
-	// Should use %v format for non-string expressions
-	if !strings.Contains(result.GoCode, `fmt.Errorf("%v", count)`) {
-		t.Errorf("Expected 'fmt.Errorf(\"%%v\", count)', got: %s", result.GoCode)
+	// Non-string expressions are formatted into the message
+	if !strings.Contains(result.GoCode, `&ValidationError{Message: fmt.Sprint(count)}`) {
+		t.Errorf("Expected '&ValidationError{Message: fmt.Sprint(count)}', got: %s", result.GoCode)
 	}
 }
 
@@ -1670,3 +1739,354 @@ func TestTranspileIfWithoutElse(t *testing.T) {
 		t.Errorf("Expected no else branch, got: %s", code)
 	}
 }
+
+func TestTranspileServiceMethod(t *testing.T) {
+	method := &ast.ServiceMethod{
+		Name:       "notify",
+		Params:     []*ast.Param{{Name: "msg", Type: "string"}},
+		ReturnType: "error",
+		Body: []ast.Statement{
+			&ast.ExprStmt{
+				Expr: &ast.CallExpr{
+					Function: &ast.MemberExpr{Object: &ast.Ident{Name: "Log"}, Property: "Println"},
+					Args:     []ast.Expression{&ast.MemberExpr{Object: &ast.Ident{Name: "config"}, Property: "url"}, &ast.Ident{Name: "msg"}},
+				},
+				Line: 1,
+			},
+		},
+	}
+
+	code := TranspileServiceMethod(method, "error", "s.config")
+	for _, exp := range []string{"Log.Println(s.config.Url, msg)", "return nil"} {
+		if !strings.Contains(code, exp) {
+			t.Errorf("expected %q in:\n%s", exp, code)
+		}
+	}
+}
+
+func TestTranspileServiceMethodVoid(t *testing.T) {
+	method := &ast.ServiceMethod{
+		Name: "ping",
+		Body: []ast.Statement{&ast.ReturnStmt{Line: 1}},
+	}
+
+	code := TranspileServiceMethod(method, "", "s.config")
+	if strings.Contains(code, "return nil") {
+		t.Errorf("void method should use a bare return, got:\n%s", code)
+	}
+	if !strings.Contains(code, "return\n") {
+		t.Errorf("expected a bare return, got:\n%s", code)
+	}
+}
+
+func TestTranspileFunction(t *testing.T) {
+	parsed, errs := Parse(`func toggleTask(id: uuid) error {
+		let task = try Task.find(id)
+		task.done = !task.done
+		try task.save()
+		return render(task)
+	}`, 10)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+
+	result := TranspileFunction(parsed.Funcs[0], []string{"Task"})
+	if len(result.Errors) > 0 {
+		t.Fatalf("transpile errors: %v", result.Errors)
+	}
+	if strings.Contains(result.GoCode, "TaskFind(db *gorm.DB") || strings.Contains(result.GoCode, "type GMXContext") {
+		t.Errorf("expected the function alone, got:\n%s", result.GoCode)
+	}
+	if !strings.HasPrefix(result.GoCode, " // gmx:11\nfunc toggleTask(ctx *GMXContext, id string) error {") {
+		t.Errorf("unexpected function header:\n%s", result.GoCode)
+	}
+
+	// One source map entry for the declaration and one per statement
+	var lines []int
+	for _, entry := range result.SourceMap.Entries {
+		lines = append(lines, entry.GmxLine)
+	}
+	if want := []int{11, 12, 13, 14, 15}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("source map lines = %v, want %v", lines, want)
+	}
+}
+
+func TestTranspileHooks(t *testing.T) {
+	parsed, errs := Parse(`before createTask {
+  if ctx.User == "" {
+    return error("sign in first")
+  }
+}
+after createTask {
+  let all = try Task.all()
+}
+func createTask(title: string) error {
+  const task = Task{title: title}
+  try task.save()
+  return render(task)
+}`, 0)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+
+	code := TranspileFunction(parsed.Funcs[0], []string{"Task"}).GoCode
+	for _, want := range []string{
+		"func createTask(ctx *GMXContext, title string) (err error) {",
+		"return &ValidationError{Message: \"sign in first\"}",
+		"defer func() { if err != nil { return } err = func() error {",
+		"all, err := TaskAll(ctx.DB)",
+	} {
+		if !strings.Contains(strings.Join(strings.Fields(code), " "), want) {
+			t.Errorf("expected %q in:\n%s", want, code)
+		}
+	}
+
+	// The before hook runs first, the deferred after hook is set up before the body
+	before := strings.Index(code, "sign in first")
+	deferred := strings.Index(code, "defer func()")
+	body := strings.Index(code, "task := &Task{")
+	if !(before < deferred && deferred < body) {
+		t.Errorf("unexpected statement order:\n%s", code)
+	}
+}
+
+func TestTranspileSuccessiveTryLets(t *testing.T) {
+	parsed, errs := Parse(`func pair(a: uuid, b: uuid) error {
+  let first = try Task.find(a)
+  let second = try Task.find(b)
+  return render(first, second)
+}`, 0)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+
+	code := TranspileFunction(parsed.Funcs[0], []string{"Task"}).GoCode
+	if !strings.Contains(code, "second, err := TaskFind(ctx.DB, b)") {
+		t.Errorf("expected the second try let to declare its variable, got:\n%s", code)
+	}
+}
+
+func TestTranspileNegotiate(t *testing.T) {
+	parsed, errs := Parse(`@negotiate
+func listTasks() error {
+  let tasks = try Task.all()
+  return render(tasks)
+}
+func getTask(id: uuid) error {
+  let task = try Task.find(id)
+  return render(task)
+}`, 0)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+
+	negotiated := TranspileFunction(parsed.Funcs[0], []string{"Task"}).GoCode
+	want := "if negotiateJSON(ctx.Writer, ctx.Request) { return writeJSON(ctx.Writer, tasks) } for _, item := range tasks {"
+	if !strings.Contains(strings.Join(strings.Fields(negotiated), " "), want) {
+		t.Errorf("expected the JSON branch ahead of the fragment rendering, got:\n%s", negotiated)
+	}
+
+	plain := TranspileFunction(parsed.Funcs[1], []string{"Task"}).GoCode
+	if strings.Contains(plain, "negotiateJSON") {
+		t.Errorf("functions without @negotiate must only render fragments, got:\n%s", plain)
+	}
+}
+
+func TestTranspileJSON(t *testing.T) {
+	parsed, errs := Parse(`@json
+func getTask(id: uuid) error {
+  let task = try Task.find(id)
+  return render(task)
+}
+@json
+func deleteTask(id: uuid) error {
+  let task = try Task.find(id)
+  try task.delete()
+  return nil
+}
+@json
+func touchTask(id: uuid) error {
+  let task = try Task.find(id)
+  try task.save()
+}`, 0)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"render", "return writeJSON(ctx.Writer, task)"},
+		{"nil return", "ctx.Writer.WriteHeader(http.StatusNoContent) return nil"},
+		{"implicit return", "ctx.Writer.WriteHeader(http.StatusNoContent) return nil"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := strings.Join(strings.Fields(TranspileFunction(parsed.Funcs[i], []string{"Task"}).GoCode), " ")
+			if !strings.Contains(code, tt.want) {
+				t.Errorf("expected %q, got:\n%s", tt.want, code)
+			}
+			if strings.Contains(code, "renderFragment") || strings.Contains(code, "negotiateJSON") {
+				t.Errorf("@json functions must never render fragments, got:\n%s", code)
+			}
+		})
+	}
+}
+
+func TestTranspileAsyncJob(t *testing.T) {
+	parsed, errs := Parse(`@async
+func importTasks() error {
+  job.progress(40)
+  return nil
+}`, 0)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+
+	result := Transpile(&ast.ScriptBlock{Funcs: parsed.Funcs}, nil)
+	if !strings.Contains(result.GoCode, "ctx.Job.Progress(40)") {
+		t.Errorf("expected job to be the job of the context, got:\n%s", result.GoCode)
+	}
+	if !strings.Contains(result.GoCode, "Job     *Job") {
+		t.Errorf("expected the context to carry the job, got:\n%s", result.GoCode)
+	}
+}
+
+func TestTranspileForStatement(t *testing.T) {
+	tests := []struct {
+		name string
+		loop string
+		want []string
+	}{
+		{
+			"models by pointer",
+			"for task in tasks {\n    task.done = true\n    try task.save()\n  }",
+			[]string{"for taskIndex := range tasks {", "task := &tasks[taskIndex]", "task.Done = true", "if err := TaskSave(ctx.DB, task); err != nil {"},
+		},
+		{
+			"models with index",
+			"for i, task in tasks {\n    task.title = \"#{i}\"\n  }",
+			[]string{"for i := range tasks {", "task := &tasks[i]"},
+		},
+		{
+			"values",
+			"for tag in tasks[0].tags {\n    let t = tag\n  }",
+			[]string{"for _, tag := range tasks[0].Tags {", "t := tag"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := "func f() error {\n  let tasks = try Task.all()\n  " + tt.loop + "\n  return nil\n}"
+			parsed, errs := ParseVersion(input, 0, v11)
+			if len(errs) > 0 {
+				t.Fatalf("parse errors: %v", errs)
+			}
+			result := Transpile(&ast.ScriptBlock{Funcs: parsed.Funcs}, []string{"Task"})
+			if len(result.Errors) > 0 {
+				t.Fatalf("transpile errors: %v", result.Errors)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(result.GoCode, want) {
+					t.Errorf("expected %q in:\n%s", want, result.GoCode)
+				}
+			}
+		})
+	}
+}
+
+func TestTranspileForStatementOverTry(t *testing.T) {
+	input := "func f() error {\n  for task in try Task.all() {\n  }\n  return nil\n}"
+	parsed, errs := ParseVersion(input, 0, v11)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	result := Transpile(&ast.ScriptBlock{Funcs: parsed.Funcs}, []string{"Task"})
+	if len(result.Errors) != 1 || !strings.Contains(result.Errors[0], "line 2: for cannot iterate over a try expression") {
+		t.Errorf("expected a try iterable to be rejected, got %v", result.Errors)
+	}
+}
+
+func TestTranspileEnumFields(t *testing.T) {
+	input := `model Task {
+  id: uuid @pk
+  status: enum(pending, done)
+}
+
+func setStatus(id: uuid, status: string) error {
+  let task = try Task.find(id)
+  task.status = status
+  if status != task.status {
+    task.status = "done"
+  }
+  let draft = Task{status: status}
+  return nil
+}`
+	result, errs := ParseVersion(input, 0, v11)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	out := TranspileModels(&ast.ScriptBlock{Funcs: result.Funcs}, result.Models, nil, nil)
+	if len(out.Errors) > 0 {
+		t.Fatalf("transpile errors: %v", out.Errors)
+	}
+
+	// Strings convert to the enum type; literals convert implicitly
+	for _, want := range []string{
+		"task.Status = TaskStatus(status)",
+		"if TaskStatus(status) != task.Status {",
+		`task.Status = "done"`,
+		"&Task{Status: TaskStatus(status)}",
+	} {
+		if !strings.Contains(out.GoCode, want) {
+			t.Errorf("expected %q in:\n%s", want, out.GoCode)
+		}
+	}
+}
+
+func TestTranspilePublish(t *testing.T) {
+	input := "func f() error {\n  let task = try Task.find(\"1\")\n  try publish(\"task.created\", task)\n  return nil\n}"
+	parsed, errs := Parse(input, 0)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+
+	// The builtin records the event in the transaction of the context
+	result := Transpile(&ast.ScriptBlock{Funcs: parsed.Funcs}, []string{"Task"})
+	if want := `if err := publish(ctx, "task.created", task); err != nil {`; !strings.Contains(result.GoCode, want) {
+		t.Errorf("expected %q in:\n%s", want, result.GoCode)
+	}
+
+	// A script function named publish replaces the builtin, called as the
+	// other script functions
+	custom, errs := Parse("func publish(topic: string, record: Task) error {\n  return nil\n}", 0)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	result = Transpile(&ast.ScriptBlock{Funcs: append(parsed.Funcs, custom.Funcs...)}, []string{"Task"})
+	for _, want := range []string{
+		"func publish(ctx *GMXContext, topic string, record *Task) error {",
+		`if err := publish(ctx, "task.created", task); err != nil {`,
+	} {
+		if !strings.Contains(result.GoCode, want) {
+			t.Errorf("expected %q in:\n%s", want, result.GoCode)
+		}
+	}
+}
+
+func TestTranspileScriptFuncCall(t *testing.T) {
+	input := "func label(title: string) string {\n  return title\n}\nfunc f() error {\n  let name = label(\"x\")\n  try check(name)\n  return nil\n}\nfunc check(name: string) error {\n  return nil\n}"
+	parsed, errs := Parse(input, 0)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+
+	// Script functions take the context of their caller
+	result := Transpile(&ast.ScriptBlock{Funcs: parsed.Funcs}, nil)
+	for _, want := range []string{`name := label(ctx, "x")`, "if err := check(ctx, name); err != nil {"} {
+		if !strings.Contains(result.GoCode, want) {
+			t.Errorf("expected %q in:\n%s", want, result.GoCode)
+		}
+	}
+}
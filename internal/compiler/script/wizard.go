@@ -0,0 +1,122 @@
+package script
+
+import (
+	"fmt"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/token"
+)
+
+// A wizard is a multi-step form: each step is validated before the next one
+// is shown, and the last one calls the finish function with the values of
+// every step, bound to its parameters by name:
+//
+//	wizard Onboarding {
+//	  step profile {
+//	    name: string @min(2)
+//	    email: string @email
+//	  }
+//	  step preferences {
+//	    newsletter: bool
+//	  }
+//	  finish completeOnboarding
+//	}
+
+// wizardFieldTypes are the types a wizard field may have
+var wizardFieldTypes = map[string]bool{"string": true, "int": true, "bool": true}
+
+// wizardAnnotations are the validations a wizard field may declare
+var wizardAnnotations = map[string]bool{"min": true, "max": true, "email": true}
+
+// isWizardStart reports whether the current token opens a wizard: wizard is
+// a contextual keyword, followed by the name of the wizard
+func (p *Parser) isWizardStart() bool {
+	return p.curTokenIs(token.IDENT) && p.curToken.Literal == "wizard" && p.peekTokenIs(token.IDENT)
+}
+
+// parseWizardDecl parses wizard Name { step name { fields } ... finish func };
+// it stops on the closing brace
+func (p *Parser) parseWizardDecl() *ast.WizardDecl {
+	wizard := &ast.WizardDecl{
+		Line: p.curToken.Pos.Line + p.lineOffset,
+	}
+	p.requireFeature("wizards")
+
+	p.nextToken() // move to the wizard name
+	wizard.Name = p.curToken.Literal
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	p.nextToken()
+
+	fields := make(map[string]bool)
+	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
+		switch {
+		case p.curTokenIs(token.IDENT) && p.curToken.Literal == "step" && p.peekTokenIs(token.IDENT):
+			// A step declares its fields as a model does
+			decl := p.parseModelDecl()
+			if decl == nil {
+				return nil
+			}
+			step := &ast.WizardStep{Name: decl.Name, Fields: decl.Fields, Line: decl.Line}
+			for _, other := range wizard.Steps {
+				if other.Name == step.Name {
+					p.error(fmt.Sprintf("wizard %s declares step %s twice", wizard.Name, step.Name))
+					return nil
+				}
+			}
+			for _, field := range step.Fields {
+				if err := checkWizardField(wizard.Name, field, fields); err != nil {
+					p.errors = append(p.errors, fmt.Sprintf("line %d: %v", field.Line, err))
+					return nil
+				}
+			}
+			wizard.Steps = append(wizard.Steps, step)
+			// parseModelDecl already moves past the closing brace
+
+		case p.curTokenIs(token.IDENT) && p.curToken.Literal == "finish" && p.peekTokenIs(token.IDENT):
+			p.nextToken()
+			wizard.Finish = p.curToken.Literal
+			p.nextToken()
+
+		default:
+			p.error(fmt.Sprintf("expected step or finish in wizard %s, got %s", wizard.Name, p.curToken.Literal))
+			return nil
+		}
+	}
+
+	if !p.curTokenIs(token.RBRACE) {
+		p.error(fmt.Sprintf("unterminated wizard %s", wizard.Name))
+		return nil
+	}
+	if len(wizard.Steps) == 0 {
+		p.error(fmt.Sprintf("wizard %s requires at least one step", wizard.Name))
+		return nil
+	}
+	if wizard.Finish == "" {
+		p.error(fmt.Sprintf("wizard %s requires a finish function: finish <func>", wizard.Name))
+		return nil
+	}
+	return wizard
+}
+
+// checkWizardField checks the type and annotations of a step field, whose
+// name must be unique across the steps of the wizard
+func checkWizardField(wizard string, field *ast.FieldDecl, seen map[string]bool) error {
+	if seen[field.Name] {
+		return fmt.Errorf("wizard %s declares field %s twice", wizard, field.Name)
+	}
+	seen[field.Name] = true
+	if !wizardFieldTypes[field.Type] {
+		return fmt.Errorf("wizard %s: field %s has type %s, use string, int or bool", wizard, field.Name, field.Type)
+	}
+	for _, ann := range field.Annotations {
+		if !wizardAnnotations[ann.Name] {
+			return fmt.Errorf("wizard %s: field %s: unknown annotation @%s, only @min, @max and @email apply to wizard fields", wizard, field.Name, ann.Name)
+		}
+		if ann.Name == "email" && field.Type != "string" {
+			return fmt.Errorf("wizard %s: field %s: @email applies to string fields", wizard, field.Name)
+		}
+	}
+	return nil
+}
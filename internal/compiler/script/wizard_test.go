@@ -0,0 +1,126 @@
+package script
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btouchard/gmx/internal/compiler/lang"
+)
+
+const wizardScript = `wizard Onboarding {
+  step profile {
+    name: string @min(2)
+    email: string @email
+  }
+  step preferences {
+    age: int @min(13)
+    newsletter: bool
+  }
+  finish completeOnboarding
+}
+func completeOnboarding(name: string, email: string) error {
+  return nil
+}`
+
+func TestParseWizard(t *testing.T) {
+	result, errs := ParseVersion(wizardScript, 0, lang.Version{Major: 1, Minor: 1})
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	if len(result.Wizards) != 1 || len(result.Funcs) != 1 {
+		t.Fatalf("expected 1 wizard and 1 function, got %d and %d", len(result.Wizards), len(result.Funcs))
+	}
+
+	wizard := result.Wizards[0]
+	if wizard.Name != "Onboarding" || wizard.Finish != "completeOnboarding" || wizard.Line != 1 {
+		t.Errorf("unexpected wizard %s finishing with %s at line %d", wizard.Name, wizard.Finish, wizard.Line)
+	}
+	if len(wizard.Steps) != 2 {
+		t.Fatalf("expected 2 steps, got %d", len(wizard.Steps))
+	}
+	if wizard.Steps[0].Name != "profile" || len(wizard.Steps[0].Fields) != 2 {
+		t.Errorf("step 1: expected profile with 2 fields, got %s with %d", wizard.Steps[0].Name, len(wizard.Steps[0].Fields))
+	}
+	if wizard.Steps[1].Line != 6 {
+		t.Errorf("step 2: expected line 6, got %d", wizard.Steps[1].Line)
+	}
+	if field := wizard.FindField("age"); field == nil || field.Type != "int" {
+		t.Errorf("expected the int field age, got %v", field)
+	}
+	if wizard.FindField("title") != nil {
+		t.Errorf("expected no field title")
+	}
+}
+
+func TestParseWizardErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		version lang.Version
+		wantErr string
+	}{
+		{
+			"older language version",
+			wizardScript,
+			lang.Default,
+			"wizards require gmx 1.1",
+		},
+		{
+			"no step",
+			"wizard Signup {\n  finish signup\n}",
+			lang.Version{Major: 1, Minor: 1},
+			"wizard Signup requires at least one step",
+		},
+		{
+			"no finish",
+			"wizard Signup {\n  step account {\n    name: string\n  }\n}",
+			lang.Version{Major: 1, Minor: 1},
+			"wizard Signup requires a finish function: finish <func>",
+		},
+		{
+			"duplicate step",
+			"wizard Signup {\n  step account {\n    name: string\n  }\n  step account {\n    email: string\n  }\n  finish signup\n}",
+			lang.Version{Major: 1, Minor: 1},
+			"wizard Signup declares step account twice",
+		},
+		{
+			"field in two steps",
+			"wizard Signup {\n  step account {\n    name: string\n  }\n  step profile {\n    name: string\n  }\n  finish signup\n}",
+			lang.Version{Major: 1, Minor: 1},
+			"line 6: wizard Signup declares field name twice",
+		},
+		{
+			"unsupported type",
+			"wizard Signup {\n  step account {\n    born: date\n  }\n  finish signup\n}",
+			lang.Version{Major: 1, Minor: 1},
+			"wizard Signup: field born has type date, use string, int or bool",
+		},
+		{
+			"unsupported annotation",
+			"wizard Signup {\n  step account {\n    name: string @unique\n  }\n  finish signup\n}",
+			lang.Version{Major: 1, Minor: 1},
+			"unknown annotation @unique, only @min, @max and @email apply to wizard fields",
+		},
+		{
+			"email on int",
+			"wizard Signup {\n  step account {\n    age: int @email\n  }\n  finish signup\n}",
+			lang.Version{Major: 1, Minor: 1},
+			"wizard Signup: field age: @email applies to string fields",
+		},
+		{
+			"unexpected member",
+			"wizard Signup {\n  title: string\n}",
+			lang.Version{Major: 1, Minor: 1},
+			"expected step or finish in wizard Signup, got title",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := ParseVersion(tt.input, 0, tt.version)
+			if len(errs) == 0 || !strings.Contains(errs[0], tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, errs)
+			}
+		})
+	}
+}
@@ -12,6 +12,9 @@ type Token struct {
 	Type    TokenType
 	Literal string
 	Pos     Position
+	// ContentPos locates the first character of the trimmed content of
+	// RAW_GO, RAW_TEMPLATE and RAW_STYLE section tokens
+	ContentPos Position
 }
 
 const (
@@ -61,7 +64,8 @@ const (
 	LBRACKET TokenType = "["
 	RBRACKET TokenType = "]"
 
-	AT TokenType = "@"
+	AT   TokenType = "@"
+	HASH TokenType = "#"
 
 	// Keywords
 	FUNC    TokenType = "FUNC"
@@ -71,6 +75,7 @@ const (
 	FALSE   TokenType = "FALSE"
 	IF      TokenType = "IF"
 	ELSE    TokenType = "ELSE"
+	FOR     TokenType = "FOR"
 	RETURN  TokenType = "RETURN"
 	MODEL   TokenType = "MODEL"
 	SERVICE TokenType = "SERVICE"
@@ -96,6 +101,7 @@ var keywords = map[string]TokenType{
 	"false":   FALSE,
 	"if":      IF,
 	"else":    ELSE,
+	"for":     FOR,
 	"return":  RETURN,
 	"model":   MODEL,
 	"service": SERVICE,
@@ -15,6 +15,7 @@ func TestLookupIdent(t *testing.T) {
 		{"false", FALSE},
 		{"if", IF},
 		{"else", ELSE},
+		{"for", FOR},
 		{"return", RETURN},
 		{"model", MODEL},
 		{"service", SERVICE},
@@ -32,6 +33,7 @@ func TestLookupIdent(t *testing.T) {
 		{"foo_bar", IDENT},
 		{"", IDENT},
 		{"unknown", IDENT},
+		{"in", IDENT}, // contextual, in for headers only
 	}
 
 	for _, tt := range tests {
@@ -1,6 +1,10 @@
 package utils
 
-import "strings"
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
 
 // ToPascalCase converts snake_case and camelCase to PascalCase
 // Gère les cas spéciaux: "id" → "ID", "user_id" → "UserID", "tenant_id" → "TenantID"
@@ -35,6 +39,33 @@ func ToPascalCase(s string) string {
 	return Capitalize(s)
 }
 
+// ToSnakeCase retourne le nom de colonne GORM d'un champ: "tags" → "tags", "userId" → "user_id".
+// Suit la NamingStrategy par défaut de GORM appliquée au nom Go du champ (ToPascalCase) :
+// seules les majuscules ASCII sont séparées, les octets UTF-8 sont recopiés tels quels.
+func ToSnakeCase(s string) string {
+	name := ToPascalCase(s)
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if isUpper(c) && i > 0 {
+			prevLower := !isUpper(name[i-1])
+			nextLower := i+1 < len(name) && !isUpper(name[i+1])
+			if prevLower || nextLower {
+				b.WriteByte('_')
+			}
+		}
+		if isUpper(c) {
+			c += 'a' - 'A'
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+func isUpper(c byte) bool {
+	return c >= 'A' && c <= 'Z'
+}
+
 // Capitalize met en majuscule la première lettre. "id" → "ID" (cas spécial).
 func Capitalize(s string) string {
 	if s == "" {
@@ -44,7 +75,17 @@ func Capitalize(s string) string {
 	if strings.ToLower(s) == "id" {
 		return "ID"
 	}
-	return strings.ToUpper(s[:1]) + s[1:]
+	r, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(r)) + s[size:]
+}
+
+// LowerFirst met en minuscule la première lettre: "Mailer" → "mailer", "État" → "état".
+func LowerFirst(s string) string {
+	if s == "" {
+		return ""
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToLower(r)) + s[size:]
 }
 
 // ReceiverName retourne la première lettre en minuscule d'un nom de modèle.
@@ -52,5 +93,6 @@ func ReceiverName(modelName string) string {
 	if modelName == "" {
 		return ""
 	}
-	return strings.ToLower(modelName[:1])
+	r, _ := utf8.DecodeRuneInString(modelName)
+	return string(unicode.ToLower(r))
 }
@@ -49,6 +49,30 @@ func TestToPascalCase(t *testing.T) {
 	}
 }
 
+func TestToSnakeCase(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"tags", "tags"},
+		{"id", "id"},
+		{"userId", "user_id"},
+		{"createdAt", "created_at"},
+		{"created_at", "created_at"},
+		{"HTMLParser", "html_parser"},
+		{"dateÉchéance", "dateÉchéance"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if result := ToSnakeCase(tt.input); result != tt.expected {
+				t.Errorf("ToSnakeCase(%q) = %q, want %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
 func TestCapitalize(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -62,6 +86,8 @@ func TestCapitalize(t *testing.T) {
 		{"empty string", "", ""},
 		{"single char", "a", "A"},
 		{"already capitalized", "Hello", "Hello"},
+		{"accented", "état", "État"},
+		{"uncased script", "名前", "名前"},
 		{"email", "email", "Email"},
 		{"title", "title", "Title"},
 	}
@@ -89,6 +115,7 @@ func TestReceiverName(t *testing.T) {
 		{"empty string", "", ""},
 		{"single char", "A", "a"},
 		{"lowercase already", "task", "t"},
+		{"accented", "Échéance", "é"},
 	}
 
 	for _, tt := range tests {
@@ -100,3 +127,23 @@ func TestReceiverName(t *testing.T) {
 		})
 	}
 }
+
+func TestLowerFirst(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"Mailer", "mailer"},
+		{"État", "état"},
+		{"mailer", "mailer"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if result := LowerFirst(tt.input); result != tt.expected {
+				t.Errorf("LowerFirst(%q) = %q, want %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
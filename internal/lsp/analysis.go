@@ -0,0 +1,321 @@
+package lsp
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/btouchard/gmx/internal/compiler/ast"
+	"github.com/btouchard/gmx/internal/compiler/lexer"
+	"github.com/btouchard/gmx/internal/compiler/parser"
+	"github.com/btouchard/gmx/internal/compiler/resolver"
+)
+
+// errorPosition matches the position of a parse error: "4:9: msg" for the
+// file, "script parsing: 2:3: msg" relative to the script block, and
+// "script parsing: line 4: msg" for the file
+var errorPosition = regexp.MustCompile(`^(script parsing: )?(?:line (\d+)|(\d+):(\d+)): (.*)$`)
+
+// importError matches the resolver errors of an import path
+var importError = regexp.MustCompile(`import (\S+)`)
+
+// Annotations offered by the completion, by where they go
+var (
+	declAnnotations  = []string{"repository", "feedItem", "typeahead", "live", "auth", "role", "honeypot", "once", "captcha", "signed", "timeout", "negotiate", "json", "autosave", "renamedFrom", "async", "timestamps", "preload", "job", "schedule", "require2fa", "login", "account", "personalData"}
+	fieldAnnotations = []string{"pk", "unique", "default", "min", "max", "email", "scoped", "relation", "money", "maxSize", "sizes", "pii", "sensitive", "env", "renamedFrom", "index", "personalData"}
+)
+
+// Completion contexts in a line of script, up to the cursor
+var (
+	declAnnotationPrefix = regexp.MustCompile(`^\s*@\w*$`)
+	annotationPrefix     = regexp.MustCompile(`@\w*$`)
+	memberPrefix         = regexp.MustCompile(`(\w+)\.\w*$`)
+	literalPrefix        = regexp.MustCompile(`(\w+)\{[^{}]*$`)
+	identChars           = regexp.MustCompile(`\w`)
+)
+
+// parse parses a .gmx document, returning its parse errors
+func parse(text string) (*ast.GMXFile, []string) {
+	p := parser.New(lexer.New(text))
+	file := p.ParseGMXFile()
+	return file, p.Errors()
+}
+
+// diagnostics reports the parse errors of a document at their line and, once
+// it parses, the import errors of the resolver; path is empty for documents
+// which are not on disk
+func diagnostics(text, path string) []Diagnostic {
+	lines := strings.Split(text, "\n")
+	file, errs := parse(text)
+	diags := []Diagnostic{}
+	for _, e := range errs {
+		diags = append(diags, parseDiagnostic(e, file, lines))
+	}
+	if len(errs) > 0 || path == "" {
+		return diags
+	}
+
+	_, resolveErrs := resolver.New(filepath.Dir(path)).Resolve(file, path)
+	for _, e := range resolveErrs {
+		line := 0
+		if m := importError.FindStringSubmatch(e); m != nil {
+			for i, l := range lines {
+				if strings.Contains(l, strconv.Quote(strings.TrimSuffix(m[1], ":"))) {
+					line = i
+					break
+				}
+			}
+		}
+		diags = append(diags, lineDiagnostic(lines, line, 0, e))
+	}
+	return diags
+}
+
+// parseDiagnostic places a parse error from its position, on the first line
+// when it has none
+func parseDiagnostic(e string, file *ast.GMXFile, lines []string) Diagnostic {
+	m := errorPosition.FindStringSubmatch(e)
+	if m == nil {
+		return lineDiagnostic(lines, 0, 0, e)
+	}
+	line, col := 0, 0
+	if m[2] != "" {
+		line, _ = strconv.Atoi(m[2])
+	} else {
+		line, _ = strconv.Atoi(m[3])
+		col, _ = strconv.Atoi(m[4])
+		if m[1] != "" && file.Script != nil {
+			line += file.Script.StartLine
+		}
+	}
+	if col > 0 {
+		col--
+	}
+	return lineDiagnostic(lines, line-1, col, m[5])
+}
+
+// lineDiagnostic reports an error from a byte column to the end of a line
+func lineDiagnostic(lines []string, line, col int, msg string) Diagnostic {
+	if line < 0 || line >= len(lines) {
+		line = 0
+	}
+	text := lines[line]
+	if col > len(text) {
+		col = len(text)
+	}
+	return Diagnostic{
+		Range: Range{
+			Start: Position{Line: line, Character: utf16Len(text[:col])},
+			End:   Position{Line: line, Character: utf16Len(text)},
+		},
+		Severity: severityError,
+		Source:   "gmx",
+		Message:  msg,
+	}
+}
+
+// inScript checks if a line is inside the <script> block of a document
+func inScript(lines []string, line int) bool {
+	open := false
+	for i, l := range lines {
+		trimmed := strings.TrimSpace(l)
+		switch {
+		case !open && strings.HasPrefix(trimmed, "<script"):
+			open = true
+			if i == line {
+				return false
+			}
+		case open && strings.HasPrefix(trimmed, "</script>"):
+			return false
+		}
+		if i == line {
+			return open
+		}
+	}
+	return false
+}
+
+// completions offers annotations after @ and the fields of the models after
+// a dot or inside a model literal, in the script block of a document
+func completions(text string, pos Position) []CompletionItem {
+	lines := strings.Split(text, "\n")
+	if pos.Line >= len(lines) || !inScript(lines, pos.Line) {
+		return []CompletionItem{}
+	}
+	line := lines[pos.Line]
+	prefix := line[:byteOffset(line, pos.Character)]
+	file, _ := parse(text)
+
+	items := []CompletionItem{}
+	switch {
+	case declAnnotationPrefix.MatchString(prefix):
+		for _, name := range append(declAnnotations, fieldAnnotations...) {
+			items = append(items, CompletionItem{Label: name, Kind: kindProperty})
+		}
+	case annotationPrefix.MatchString(prefix):
+		for _, name := range fieldAnnotations {
+			items = append(items, CompletionItem{Label: name, Kind: kindProperty})
+		}
+	case literalPrefix.MatchString(prefix):
+		name := literalPrefix.FindStringSubmatch(prefix)[1]
+		for _, model := range file.Models {
+			if model.Name == name {
+				items = append(items, fieldItems(model)...)
+			}
+		}
+	case memberPrefix.MatchString(prefix):
+		// Model.find and friends are methods; records expose every model field
+		name := memberPrefix.FindStringSubmatch(prefix)[1]
+		for _, model := range file.Models {
+			if model.Name == name {
+				return items
+			}
+		}
+		for _, model := range file.Models {
+			items = append(items, fieldItems(model)...)
+		}
+	}
+	return items
+}
+
+// fieldItems returns the completion items of the fields of a model
+func fieldItems(model *ast.ModelDecl) []CompletionItem {
+	items := make([]CompletionItem, 0, len(model.Fields))
+	for _, field := range model.Fields {
+		items = append(items, CompletionItem{
+			Label:  field.Name,
+			Kind:   kindField,
+			Detail: fmt.Sprintf("%s.%s: %s", model.Name, field.Name, field.TypeString()),
+		})
+	}
+	// @timestamps adds the fields the generated code keeps up to date
+	if model.FindAnnotation("timestamps") != nil {
+		for _, name := range []string{"createdAt", "updatedAt"} {
+			items = append(items, CompletionItem{
+				Label:  name,
+				Kind:   kindField,
+				Detail: fmt.Sprintf("%s.%s: datetime (@timestamps)", model.Name, name),
+			})
+		}
+	}
+	return items
+}
+
+// definition locates the declaration of the model, function or variable
+// under the cursor, following the imports of documents on disk; read
+// returns the content of an imported file
+func definition(text, uri string, pos Position, read func(path string) (string, bool)) *Location {
+	lines := strings.Split(text, "\n")
+	if pos.Line >= len(lines) {
+		return nil
+	}
+	name := wordAt(lines[pos.Line], byteOffset(lines[pos.Line], pos.Character))
+	if name == "" {
+		return nil
+	}
+	file, _ := parse(text)
+
+	if line, ok := declLine(file, name); ok {
+		return lineLocation(uri, line)
+	}
+	path := uriToPath(uri)
+	if path == "" {
+		return nil
+	}
+	for _, imp := range file.Imports {
+		if imp.IsNative || !strings.HasSuffix(imp.Path, ".gmx") {
+			continue
+		}
+		target := filepath.Join(filepath.Dir(path), imp.Path)
+		if imp.Default == name {
+			return lineLocation(pathToURI(target), 1)
+		}
+		for _, member := range imp.Members {
+			local := member
+			if alias, ok := imp.Aliases[member]; ok {
+				local = alias
+			}
+			if local != name {
+				continue
+			}
+			if src, ok := read(target); ok {
+				imported, _ := parse(src)
+				if line, ok := declLine(imported, member); ok {
+					return lineLocation(pathToURI(target), line)
+				}
+			}
+			return lineLocation(pathToURI(target), 1)
+		}
+	}
+	return nil
+}
+
+// declLine returns the 1-based line of the model, function or variable
+// declared with a name
+func declLine(file *ast.GMXFile, name string) (int, bool) {
+	for _, model := range file.Models {
+		if model.Name == name {
+			return model.Line, true
+		}
+	}
+	if file.Script != nil {
+		for _, fn := range file.Script.Funcs {
+			if fn.Name == name {
+				return fn.Line, true
+			}
+		}
+	}
+	for _, v := range file.Vars {
+		if v.Name == name {
+			return v.Line, true
+		}
+	}
+	return 0, false
+}
+
+// lineLocation returns the start of a 1-based line of a document
+func lineLocation(uri string, line int) *Location {
+	if line > 0 {
+		line--
+	}
+	return &Location{URI: uri, Range: Range{Start: Position{Line: line}, End: Position{Line: line}}}
+}
+
+// wordAt returns the identifier around a byte offset of a line
+func wordAt(line string, offset int) string {
+	start, end := offset, offset
+	for start > 0 && identChars.MatchString(line[start-1:start]) {
+		start--
+	}
+	for end < len(line) && identChars.MatchString(line[end:end+1]) {
+		end++
+	}
+	return line[start:end]
+}
+
+// byteOffset converts a UTF-16 character offset of a line to a byte offset
+func byteOffset(line string, character int) int {
+	units := 0
+	for i, r := range line {
+		if units >= character {
+			return i
+		}
+		units += len(utf16.Encode([]rune{r}))
+	}
+	return len(line)
+}
+
+// utf16Len returns the length of a string in UTF-16 code units
+func utf16Len(s string) int {
+	n := 0
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		n += len(utf16.Encode([]rune{r}))
+		s = s[size:]
+	}
+	return n
+}
@@ -0,0 +1,142 @@
+package lsp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const taskDoc = `gmx 1.1
+<script>
+import { Mailer as SiteMailer } from "./lib.gmx"
+model Task {
+  id: uuid @pk @default(uuid_v4)
+  title: string @min(3)
+}
+func createTask(title: string) error {
+  const task = Task{title: title}
+  try task.save()
+  return render(task)
+}
+</script>
+<template><p>{{.Tasks}}</p></template>
+`
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		wantLine int
+		wantMsg  string
+	}{
+		{"script relative position", strings.Replace(taskDoc, "title: string @min(3)", "title: string @", 1), 5, "expected IDENT"},
+		{"script absolute line", strings.Replace(taskDoc, "try task.save()", "try task.", 1), 9, "expected next token to be IDENT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diags := diagnostics(tt.text, "")
+			if len(diags) == 0 {
+				t.Fatal("expected diagnostics, got none")
+			}
+			if diags[0].Range.Start.Line != tt.wantLine || !strings.Contains(diags[0].Message, tt.wantMsg) {
+				t.Errorf("expected %q at line %d, got %q at line %d", tt.wantMsg, tt.wantLine, diags[0].Message, diags[0].Range.Start.Line)
+			}
+		})
+	}
+
+	if diags := diagnostics(strings.Replace(taskDoc, "import { Mailer as SiteMailer } from \"./lib.gmx\"\n", "", 1), ""); len(diags) != 0 {
+		t.Errorf("expected no diagnostics for a valid document, got %v", diags)
+	}
+}
+
+func TestCompletions(t *testing.T) {
+	lines := strings.Split(taskDoc, "\n")
+	tests := []struct {
+		name  string
+		line  int
+		text  string
+		want  []string
+		empty bool
+	}{
+		{"field annotation", 5, "  title: string @m", []string{"min", "max", "money"}, false},
+		{"declaration annotation", 7, "@", []string{"auth", "repository", "live"}, false},
+		{"record field", 9, "  try task.", []string{"id", "title"}, false},
+		{"model method", 9, "  try Task.", nil, true},
+		{"model literal", 8, "  const task = Task{ti", []string{"title"}, false},
+		{"outside script", 13, "<template><p>{{.", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := append([]string{}, lines...)
+			doc[tt.line] = tt.text
+			items := completions(strings.Join(doc, "\n"), Position{Line: tt.line, Character: len(tt.text)})
+			if tt.empty && len(items) != 0 {
+				t.Errorf("expected no completion, got %v", items)
+			}
+			labels := make(map[string]bool)
+			for _, item := range items {
+				labels[item.Label] = true
+			}
+			for _, w := range tt.want {
+				if !labels[w] {
+					t.Errorf("completion missing %q in %v", w, items)
+				}
+			}
+		})
+	}
+
+	// Records of @timestamps models expose the fields the annotation adds
+	doc := strings.Split(strings.Replace(taskDoc, "model Task {", "@timestamps model Task {", 1), "\n")
+	doc[9] = "  try task.upd"
+	items := completions(strings.Join(doc, "\n"), Position{Line: 9, Character: len(doc[9])})
+	found := false
+	for _, item := range items {
+		found = found || item.Label == "updatedAt"
+	}
+	if !found {
+		t.Errorf("completion missing updatedAt in %v", items)
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	dir := t.TempDir()
+	lib := "gmx 1.1\n<script>\nmodel Mailer {\n  host: string\n}\n</script>\n"
+	if err := os.WriteFile(filepath.Join(dir, "lib.gmx"), []byte(lib), 0644); err != nil {
+		t.Fatal(err)
+	}
+	uri := pathToURI(filepath.Join(dir, "app.gmx"))
+	read := func(path string) (string, bool) {
+		data, err := os.ReadFile(path)
+		return string(data), err == nil
+	}
+
+	tests := []struct {
+		name     string
+		pos      Position
+		wantURI  string
+		wantLine int
+	}{
+		{"local model", Position{Line: 8, Character: 17}, uri, 3},
+		{"local func", Position{Line: 7, Character: 7}, uri, 7},
+		{"imported member", Position{Line: 2, Character: 25}, pathToURI(filepath.Join(dir, "lib.gmx")), 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc := definition(taskDoc, uri, tt.pos, read)
+			if loc == nil {
+				t.Fatal("expected a location, got none")
+			}
+			if loc.URI != tt.wantURI || loc.Range.Start.Line != tt.wantLine {
+				t.Errorf("expected %s:%d, got %s:%d", tt.wantURI, tt.wantLine, loc.URI, loc.Range.Start.Line)
+			}
+		})
+	}
+
+	if loc := definition(taskDoc, uri, Position{Line: 9, Character: 12}, read); loc != nil {
+		t.Errorf("expected no location for a method, got %v", loc)
+	}
+}
@@ -0,0 +1,88 @@
+package lsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// frame encodes a message with its Content-Length header
+func frame(t *testing.T, msg interface{}) string {
+	t.Helper()
+	body, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+// responses decodes the messages written by the server
+func responses(t *testing.T, out *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	s := NewServer(out, nil)
+	var msgs []map[string]interface{}
+	for {
+		body, err := s.read()
+		if err != nil {
+			return msgs
+		}
+		var msg map[string]interface{}
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Fatalf("invalid response %s: %v", body, err)
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+func TestServerSession(t *testing.T) {
+	uri := "untitled:app.gmx"
+	broken := strings.Replace(taskDoc, "title: string @min(3)", "title: string @", 1)
+	input := frame(t, map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": map[string]interface{}{}}) +
+		frame(t, map[string]interface{}{"jsonrpc": "2.0", "method": "initialized", "params": map[string]interface{}{}}) +
+		frame(t, map[string]interface{}{"jsonrpc": "2.0", "method": "textDocument/didOpen", "params": map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri, "languageId": "gmx", "version": 1, "text": broken},
+		}}) +
+		frame(t, map[string]interface{}{"jsonrpc": "2.0", "id": 2, "method": "textDocument/completion", "params": map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri}, "position": map[string]interface{}{"line": 9, "character": 11},
+		}}) +
+		frame(t, map[string]interface{}{"jsonrpc": "2.0", "id": 3, "method": "textDocument/hover", "params": map[string]interface{}{}}) +
+		frame(t, map[string]interface{}{"jsonrpc": "2.0", "id": 4, "method": "shutdown"}) +
+		frame(t, map[string]interface{}{"jsonrpc": "2.0", "method": "exit"})
+
+	var out bytes.Buffer
+	if err := NewServer(strings.NewReader(input), &out).Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	msgs := responses(t, &out)
+	if len(msgs) != 5 {
+		t.Fatalf("expected 5 messages, got %d: %v", len(msgs), msgs)
+	}
+
+	caps, _ := msgs[0]["result"].(map[string]interface{})["capabilities"].(map[string]interface{})
+	if caps["definitionProvider"] != true {
+		t.Errorf("initialize should advertise definitions, got %v", msgs[0])
+	}
+
+	if msgs[1]["method"] != "textDocument/publishDiagnostics" {
+		t.Fatalf("expected diagnostics after didOpen, got %v", msgs[1])
+	}
+	diags := msgs[1]["params"].(map[string]interface{})["diagnostics"].([]interface{})
+	if len(diags) == 0 {
+		t.Errorf("expected diagnostics for the incomplete annotation, got none")
+	}
+
+	items, _ := msgs[2]["result"].([]interface{})
+	if len(items) != 2 {
+		t.Errorf("expected the 2 fields of Task, got %v", msgs[2])
+	}
+
+	if msgs[3]["error"].(map[string]interface{})["code"] != float64(codeMethodNotFound) {
+		t.Errorf("expected method not found for hover, got %v", msgs[3])
+	}
+
+	if result, ok := msgs[4]["result"]; !ok || result != nil {
+		t.Errorf("expected a null result for shutdown, got %v", msgs[4])
+	}
+}